@@ -0,0 +1,70 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"net/netip"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/appctype"
+	"tailscale.com/types/views"
+)
+
+// sourceACL restricts the clients which may use a service. A nil
+// sourceACL allows all clients.
+type sourceACL struct {
+	prefixes []netip.Prefix
+	tags     []string
+}
+
+// compileSourceACL returns the sourceACL of a service's AllowedSources, or
+// nil if they're empty.
+func compileSourceACL(sources []string) (*sourceACL, error) {
+	if len(sources) == 0 {
+		return nil, nil
+	}
+	prefixes, tags, err := appctype.ParseSources(sources)
+	if err != nil {
+		return nil, err
+	}
+	return &sourceACL{prefixes: prefixes, tags: tags}, nil
+}
+
+// allows reports whether the client at src may use the service. The tags
+// of src are looked up with whoIs, which may be nil, only if needed.
+func (a *sourceACL) allows(src netip.AddrPort, whoIs func(netip.AddrPort) (tailcfg.NodeView, tailcfg.UserProfile, bool)) bool {
+	if a == nil {
+		return true
+	}
+	ip := src.Addr().Unmap()
+	for _, p := range a.prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	if len(a.tags) == 0 || whoIs == nil {
+		return false
+	}
+	n, _, ok := whoIs(src)
+	if !ok {
+		return false
+	}
+	for _, t := range a.tags {
+		if views.SliceContains(n.Tags(), t) {
+			return true
+		}
+	}
+	return false
+}
+
+// handlerSources returns the sourceACL of h.
+func handlerSources(h handler) *sourceACL {
+	switch h := h.(type) {
+	case *tcpRoundRobinHandler:
+		return h.Sources
+	case *tcpSNIHandler:
+		return h.Sources
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/tailcfg"
+)
+
+func TestSourceACL(t *testing.T) {
+	whoIs := func(ipp netip.AddrPort) (tailcfg.NodeView, tailcfg.UserProfile, bool) {
+		if ipp.Addr() != netip.MustParseAddr("100.64.0.3") {
+			return tailcfg.NodeView{}, tailcfg.UserProfile{}, false
+		}
+		n := &tailcfg.Node{Tags: []string{"tag:ci"}}
+		return n.View(), tailcfg.UserProfile{}, true
+	}
+
+	acl, err := compileSourceACL([]string{"100.64.1.0/24", "fd7a:115c:a1e0::5", "tag:ci"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		src   string
+		whoIs func(netip.AddrPort) (tailcfg.NodeView, tailcfg.UserProfile, bool)
+		want  bool
+	}{
+		{"100.64.1.7:1234", nil, true},
+		{"[::ffff:100.64.1.7]:1234", nil, true},
+		{"[fd7a:115c:a1e0::5]:1234", nil, true},
+		{"[fd7a:115c:a1e0::6]:1234", nil, false},
+		{"100.64.0.3:1234", whoIs, true},
+		{"100.64.0.3:1234", nil, false},
+		{"100.64.0.4:1234", whoIs, false},
+	}
+	for _, tt := range tests {
+		if got := acl.allows(netip.MustParseAddrPort(tt.src), tt.whoIs); got != tt.want {
+			t.Errorf("allows(%s) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+
+	// Services without AllowedSources allow everyone.
+	acl, err = compileSourceACL(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acl.allows(netip.MustParseAddrPort("192.0.2.1:1234"), nil) {
+		t.Error("empty AllowedSources denied a client")
+	}
+
+	if _, err := compileSourceACL([]string{"tag:"}); err == nil {
+		t.Error("compileSourceACL accepted an invalid tag")
+	}
+}
@@ -5,10 +5,14 @@
 package appc
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"expvar"
 	"log"
 	"net"
 	"net/netip"
+	"net/url"
 	"sync"
 	"time"
 
@@ -18,8 +22,10 @@
 	"tailscale.com/types/appctype"
 	"tailscale.com/types/ipproto"
 	"tailscale.com/types/nettype"
+	"tailscale.com/types/tkatype"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/mak"
+	"tailscale.com/util/set"
 )
 
 var tsMBox = dnsmessage.MustNewName("support.tailscale.com.")
@@ -33,8 +39,71 @@ type target struct {
 
 // Server implements an App Connector.
 type Server struct {
+	// lookupNetIP and hostDial, if non-nil, are used instead of
+	// net.DefaultResolver.LookupNetIP and a net.Dialer. For tests.
+	lookupNetIP func(ctx context.Context, network, host string) ([]netip.Addr, error)
+	hostDial    dialFunc
+
 	mu         sync.RWMutex // mu guards following fields
 	connectors map[appctype.ConfigID]connector
+
+	// config is the configuration most recently passed to Configure, and
+	// configGen is incremented by every call to Configure.
+	config    *appctype.AppConnectorConfig
+	configGen int64
+
+	// effectiveConfig is config with any unattested domains removed.
+	effectiveConfig *appctype.AppConnectorConfig
+
+	// attestationKeys, if non-empty, are the keys that must sign the
+	// domain attestation of a configuration. See SetDomainAttestationKeys.
+	attestationKeys []tkatype.KeyID
+
+	// listenAddrs are the addresses of all services in the current
+	// configuration, which are never valid destinations.
+	listenAddrs set.Set[netip.Addr]
+
+	// isTailnetRoute and tailnetDial are set by SetTailnetRouting, or nil
+	// if all destinations are dialed on the host network.
+	isTailnetRoute func(netip.Addr) bool
+	tailnetDial    dialFunc
+
+	udpFlows udpFlowTable
+
+	// probeDial is set by SetProbeDialer, or nil if probes are disabled.
+	// probeResults are the results of the most recent probes of the
+	// current configuration, keyed by service name.
+	probeDial    dialFunc
+	probeResults map[string]*appctype.ProbeResult
+
+	flowLog flowLogger
+
+	// backendPools are the backend pools of the DNAT services of the
+	// current configuration, keyed by service name, and stopHealthChecks
+	// stops their health checks.
+	backendPools     map[string]*backendPool
+	stopHealthChecks context.CancelFunc
+
+	// advertiseRoute and unadvertiseRoute are set by SetRouteAdvertiser,
+	// or nil if routes aren't learned. learnedRoutes are the addresses
+	// routes were advertised for, with when each expires, and routeExpiry
+	// fires when the next of them does.
+	advertiseRoute   func(...netip.Prefix) error
+	unadvertiseRoute func(...netip.Prefix) error
+	learnedRoutes    map[netip.Addr]time.Time
+	routeExpiry      *time.Timer
+
+	// whoIs is set by SetWhoIs, or nil if PROXY protocol headers don't
+	// carry the identity of clients.
+	whoIs func(netip.AddrPort) (tailcfg.NodeView, tailcfg.UserProfile, bool)
+
+	// getCert is set by SetCertGetter, or nil if SNI proxy services can't
+	// terminate TLS.
+	getCert func(ctx context.Context, domain string) (*tls.Certificate, error)
+
+	// drains are the services removed from the configuration whose
+	// connections are being drained.
+	drains []*drain
 }
 
 type appcMetrics struct {
@@ -43,6 +112,19 @@ type appcMetrics struct {
 	tcpConns       expvar.Int
 	sniConns       expvar.Int
 	unhandledConns expvar.Int
+	udpSessions    expvar.Int
+	quicSessions   expvar.Int
+	probeFailures  expvar.Int
+
+	healthTransitions expvar.Int
+
+	learnedRoutes        expvar.Int
+	learnedRoutesDropped expvar.Int
+
+	tlsTerminatedRequests expvar.Int
+	deniedConns           expvar.Int
+	firewallRefused       expvar.Int
+	drainClosedConns      expvar.Int
 }
 
 var getMetrics = sync.OnceValue[*appcMetrics](func() *appcMetrics {
@@ -53,6 +135,26 @@ type appcMetrics struct {
 	clientmetric.NewCounterFunc("sniproxy_tls_sessions", m.sniConns.Value)
 	stats.Set("tcp_sessions", &m.tcpConns)
 	clientmetric.NewCounterFunc("sniproxy_tcp_sessions", m.tcpConns.Value)
+	stats.Set("udp_sessions", &m.udpSessions)
+	clientmetric.NewCounterFunc("sniproxy_udp_sessions", m.udpSessions.Value)
+	stats.Set("quic_sessions", &m.quicSessions)
+	clientmetric.NewCounterFunc("sniproxy_quic_sessions", m.quicSessions.Value)
+	stats.Set("backend_health_transitions", &m.healthTransitions)
+	clientmetric.NewCounterFunc("sniproxy_backend_health_transitions", m.healthTransitions.Value)
+	stats.Set("probe_failures", &m.probeFailures)
+	clientmetric.NewCounterFunc("sniproxy_probe_failures", m.probeFailures.Value)
+	stats.Set("learned_routes", &m.learnedRoutes)
+	clientmetric.NewGaugeFunc("sniproxy_learned_routes", m.learnedRoutes.Value)
+	stats.Set("learned_routes_dropped", &m.learnedRoutesDropped)
+	clientmetric.NewCounterFunc("sniproxy_learned_routes_dropped", m.learnedRoutesDropped.Value)
+	stats.Set("tls_terminated_requests", &m.tlsTerminatedRequests)
+	clientmetric.NewCounterFunc("sniproxy_tls_terminated_requests", m.tlsTerminatedRequests.Value)
+	stats.Set("denied_conns", &m.deniedConns)
+	clientmetric.NewCounterFunc("sniproxy_denied_conns", m.deniedConns.Value)
+	stats.Set("firewall_refused_flows", &m.firewallRefused)
+	clientmetric.NewCounterFunc("sniproxy_firewall_refused_flows", m.firewallRefused.Value)
+	stats.Set("drain_closed_conns", &m.drainClosedConns)
+	clientmetric.NewCounterFunc("sniproxy_drain_closed_conns", m.drainClosedConns.Value)
 	stats.Set("dns_responses", &m.dnsResponses)
 	clientmetric.NewCounterFunc("sniproxy_dns_responses", m.dnsResponses.Value)
 	stats.Set("dns_failed", &m.dnsFailures)
@@ -66,7 +168,134 @@ type appcMetrics struct {
 func (s *Server) Configure(cfg *appctype.AppConnectorConfig) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.connectors = makeConnectorsFromConfig(cfg)
+	s.configureLocked(cfg)
+}
+
+// ErrConfigChanged is returned by ConfigureIfUnchanged when the app
+// connector has been reconfigured since the expected generation.
+var ErrConfigChanged = errors.New("app connector configuration changed concurrently")
+
+// Config returns the configuration most recently passed to Configure, or
+// nil if the app connector has not been configured, and its generation,
+// which increases with every call to Configure. The returned configuration
+// must not be modified.
+func (s *Server) Config() (cfg *appctype.AppConnectorConfig, gen int64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config, s.configGen
+}
+
+// ConfigureIfUnchanged is like Configure, but only applies cfg if the
+// generation of the current configuration, as returned by Config, is gen.
+// Otherwise it returns ErrConfigChanged and leaves the app connector alone.
+// It returns the generation of the new configuration.
+func (s *Server) ConfigureIfUnchanged(cfg *appctype.AppConnectorConfig, gen int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.configGen != gen {
+		return s.configGen, ErrConfigChanged
+	}
+	s.configureLocked(cfg)
+	return s.configGen, nil
+}
+
+func (s *Server) configureLocked(cfg *appctype.AppConnectorConfig) {
+	s.config = cfg
+	s.configGen++
+	if err := cfg.CheckExperiments(); err != nil {
+		log.Printf("appc: ignoring %v", err)
+	}
+	if err := appctype.Validate(cfg); err != nil {
+		for _, ve := range err.(appctype.ValidationErrors) {
+			log.Printf("appc: invalid config: %v", ve)
+		}
+	}
+	if len(s.attestationKeys) > 0 {
+		cfg = attestedConfig(cfg, s.attestationKeys)
+	}
+	s.effectiveConfig = cfg
+	s.probeResults = nil
+	old := s.connectors
+	s.connectors = makeConnectorsFromConfig(cfg, s.dial, &s.flowLog, s.proxyHeader, &tlsTerminator{
+		getCert: s.certificate,
+		whoIs:   s.clientIdentity,
+	})
+	s.drainRemovedLocked(old, cfg.DrainTimeout)
+	s.startHealthChecksLocked()
+	s.listenAddrs = listenAddrsFromConfig(cfg)
+}
+
+// Status returns the status of the services the app connector is running,
+// which excludes any refused for lack of a domain attestation, along with
+// the results of any probes of them.
+func (s *Server) Status() []appctype.ServiceStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.effectiveConfig == nil {
+		return nil
+	}
+	st := s.addProbeResultsLocked(s.effectiveConfig.Status())
+	stats := servicesOf(s.connectors)
+	for i := range st {
+		if p := s.backendPools[st[i].Service]; p != nil {
+			st[i].Unhealthy = p.unhealthy()
+		}
+		if rs := stats[st[i].Service]; rs != nil {
+			rs.addStatus(&st[i])
+		}
+	}
+	return st
+}
+
+// startHealthChecksLocked stops the health checks of the previous
+// configuration and starts those of the current one. s.mu must be held.
+func (s *Server) startHealthChecksLocked() {
+	if s.stopHealthChecks != nil {
+		s.stopHealthChecks()
+		s.stopHealthChecks = nil
+	}
+	s.backendPools = nil
+	for _, c := range s.connectors {
+		for _, h := range c.Handlers {
+			if h, ok := h.(*tcpRoundRobinHandler); ok && h.Backends != nil {
+				mak.Set(&s.backendPools, h.Backends.service, h.Backends)
+			}
+		}
+	}
+	var ctx context.Context
+	for _, p := range s.backendPools {
+		if p.check == nil {
+			continue
+		}
+		if ctx == nil {
+			ctx, s.stopHealthChecks = context.WithCancel(context.Background())
+		}
+		go p.runHealthChecks(ctx, s.dial)
+	}
+}
+
+// Close stops the app connector's health checks, closes the connections of services being drained
+// and withdraws the routes it learned.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.stopHealthChecks != nil {
+		s.stopHealthChecks()
+		s.stopHealthChecks = nil
+	}
+	s.stopDrainsLocked()
+	s.stopRouteExpiryLocked()
+	var learned []netip.Prefix
+	for ip := range s.learnedRoutes {
+		learned = append(learned, netip.PrefixFrom(ip, ip.BitLen()))
+	}
+	s.learnedRoutes = nil
+	unadvertise := s.unadvertiseRoute
+	s.mu.Unlock()
+
+	if len(learned) > 0 && unadvertise != nil {
+		return unadvertise(learned...)
+	}
+	return nil
 }
 
 // HandleTCPFlow implements tsnet.FallbackTCPHandler.
@@ -74,9 +303,12 @@ func (s *Server) HandleTCPFlow(src, dst netip.AddrPort) (handler func(net.Conn),
 	m := getMetrics()
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	if !s.allowsSourceLocked(src, dst) {
+		return nil, false
+	}
 
 	for _, c := range s.connectors {
-		if handler, intercept := c.handleTCPFlow(src, dst, m); intercept {
+		if handler, intercept := c.handleTCPFlow(src, dst, m, s.whoIs); intercept {
 			return handler, intercept
 		}
 	}
@@ -122,6 +354,9 @@ func (s *Server) HandleDNS(c nettype.ConnPacketConn) {
 
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	if src, err := netip.ParseAddrPort(c.RemoteAddr().String()); err != nil || !s.allowsSourceLocked(src, netip.AddrPortFrom(localAddr, 53)) {
+		return
+	}
 	for _, connector := range s.connectors {
 		resp, err := connector.handleDNS(&msg, localAddr)
 		if err != nil {
@@ -150,8 +385,10 @@ type connector struct {
 	Handlers map[target]handler
 }
 
-// handleTCPFlow implements tsnet.FallbackTCPHandler.
-func (c *connector) handleTCPFlow(src, dst netip.AddrPort, m *appcMetrics) (handler func(net.Conn), intercept bool) {
+// handleTCPFlow implements tsnet.FallbackTCPHandler. Flows from clients
+// a service's AllowedSources don't allow, whose tags are looked up with
+// whoIs, aren't intercepted.
+func (c *connector) handleTCPFlow(src, dst netip.AddrPort, m *appcMetrics, whoIs func(netip.AddrPort) (tailcfg.NodeView, tailcfg.UserProfile, bool)) (handler func(net.Conn), intercept bool) {
 	for t, h := range c.Handlers {
 		if t.Matching.Proto != 0 && t.Matching.Proto != int(ipproto.TCP) {
 			continue
@@ -162,6 +399,10 @@ func (c *connector) handleTCPFlow(src, dst netip.AddrPort, m *appcMetrics) (hand
 		if !t.Matching.Ports.Contains(dst.Port()) {
 			continue
 		}
+		if !handlerSources(h).allows(src, whoIs) {
+			m.deniedConns.Add(1)
+			return nil, false
+		}
 
 		switch h.(type) {
 		case *tcpSNIHandler:
@@ -266,15 +507,27 @@ type handler interface {
 	ReachableOn() []netip.Addr
 }
 
-func installDNATHandler(d *appctype.DNATConfig, out *connector) {
+// dialFunc dials a connection to a service's destination.
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+func installDNATHandler(service string, d *appctype.DNATConfig, dial dialFunc, flows *flowLogger, proxyHeader proxyHeaderFunc, out *connector) {
+	sources, err := compileSourceACL(d.AllowedSources)
+	if err != nil {
+		// Ignoring a bad source would let clients it doesn't allow
+		// through, so refuse the whole service instead.
+		log.Printf("appc: refusing %s: %v", service, err)
+		return
+	}
 	// These handlers don't actually do DNAT, they just
 	// proxy the data over the connection.
-	var dialer net.Dialer
-	dialer.Timeout = 5 * time.Second
 	h := tcpRoundRobinHandler{
 		To:           d.To,
-		DialContext:  dialer.DialContext,
+		DialContext:  dial,
 		ReachableIPs: d.Addrs,
+		Stats:        newRuleStats(service, flows),
+		Backends:     newBackendPool(service, d),
+		Sources:      sources,
+		ProxyHeader:  proxyHeaderFor(service, d.ProxyProtocol, proxyHeader),
 	}
 
 	for _, addr := range d.Addrs {
@@ -289,13 +542,38 @@ func installDNATHandler(d *appctype.DNATConfig, out *connector) {
 	}
 }
 
-func installSNIHandler(c *appctype.SNIProxyConfig, out *connector) {
-	var dialer net.Dialer
-	dialer.Timeout = 5 * time.Second
+func installSNIHandler(service string, c *appctype.SNIProxyConfig, dial dialFunc, flows *flowLogger, proxyHeader proxyHeaderFunc, term *tlsTerminator, out *connector) {
+	patterns, err := compileDomainPatterns(c.DomainPatterns)
+	if err != nil {
+		// Ignoring a bad deny pattern would proxy domains it should
+		// refuse, so refuse the whole service instead.
+		log.Printf("appc: refusing SNI proxy: %v", err)
+		return
+	}
+	sources, err := compileSourceACL(c.AllowedSources)
+	if err != nil {
+		log.Printf("appc: refusing %s: %v", service, err)
+		return
+	}
+	var backend *url.URL
+	if c.TerminateTLS != nil {
+		if backend, err = appctype.ParseTLSBackend(c.TerminateTLS.Backend); err != nil {
+			log.Printf("appc: refusing %s: %v", service, err)
+			return
+		}
+	}
 	h := tcpSNIHandler{
 		Allowlist:    c.AllowedDomains,
-		DialContext:  dialer.DialContext,
+		Patterns:     patterns,
+		DialContext:  dial,
 		ReachableIPs: c.Addrs,
+		Stats:        newRuleStats(service, flows),
+		Sources:      sources,
+		ProxyHeader:  proxyHeaderFor(service, c.ProxyProtocol, proxyHeader),
+		TerminateTLS: c.TerminateTLS,
+		Backend:      backend,
+		Terminator:   term,
+		Service:      service,
 	}
 
 	for _, addr := range c.Addrs {
@@ -310,17 +588,21 @@ func installSNIHandler(c *appctype.SNIProxyConfig, out *connector) {
 	}
 }
 
-func makeConnectorsFromConfig(cfg *appctype.AppConnectorConfig) map[appctype.ConfigID]connector {
+// makeConnectorsFromConfig returns the connectors of the services in cfg,
+// which dial destinations with dial, log their flows to flows, which may be
+// nil, send the PROXY protocol headers of proxyHeader if configured to, and
+// terminate TLS with term if configured to.
+func makeConnectorsFromConfig(cfg *appctype.AppConnectorConfig, dial dialFunc, flows *flowLogger, proxyHeader proxyHeaderFunc, term *tlsTerminator) map[appctype.ConfigID]connector {
 	var connectors map[appctype.ConfigID]connector
 
 	for cID, d := range cfg.DNAT {
 		c := connectors[cID]
-		installDNATHandler(&d, &c)
+		installDNATHandler("dnat/"+string(cID), &d, dial, flows, proxyHeader, &c)
 		mak.Set(&connectors, cID, c)
 	}
 	for cID, d := range cfg.SNIProxy {
 		c := connectors[cID]
-		installSNIHandler(&d, &c)
+		installSNIHandler("sni/"+string(cID), &d, dial, flows, proxyHeader, term, &c)
 		mak.Set(&connectors, cID, c)
 	}
 
@@ -4,6 +4,7 @@
 package appc
 
 import (
+	"errors"
 	"net/netip"
 	"testing"
 
@@ -80,11 +81,11 @@ func TestMakeConnectorsFromConfig(t *testing.T) {
 
 	for _, tc := range tcs {
 		t.Run(tc.name, func(t *testing.T) {
-			connectors := makeConnectorsFromConfig(tc.input)
+			connectors := makeConnectorsFromConfig(tc.input, nil, nil, nil, nil)
 
 			if diff := cmp.Diff(connectors, tc.want,
-				cmpopts.IgnoreFields(tcpRoundRobinHandler{}, "DialContext"),
-				cmpopts.IgnoreFields(tcpSNIHandler{}, "DialContext"),
+				cmpopts.IgnoreFields(tcpRoundRobinHandler{}, "DialContext", "Stats", "Backends"),
+				cmpopts.IgnoreFields(tcpSNIHandler{}, "DialContext", "Stats", "Service"),
 				cmp.Comparer(func(x, y netip.Addr) bool {
 					return x == y
 				})); diff != "" {
@@ -93,3 +94,60 @@ func TestMakeConnectorsFromConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestFirewallMode(t *testing.T) {
+	dst := netip.MustParseAddrPort("100.64.0.1:443")
+	tcp443 := tailcfg.ProtoPortRange{Proto: 6, Ports: tailcfg.PortRange{First: 443, Last: 443}}
+	cfg := &appctype.AppConnectorConfig{
+		SNIProxy: map[appctype.ConfigID]appctype.SNIProxyConfig{
+			"a": {Addrs: []netip.Addr{dst.Addr()}, IP: []tailcfg.ProtoPortRange{tcp443}},
+		},
+	}
+	s := &Server{}
+	s.SetWhoIs(func(ipp netip.AddrPort) (tailcfg.NodeView, tailcfg.UserProfile, bool) {
+		return tailcfg.NodeView{}, tailcfg.UserProfile{}, ipp.Addr() == netip.MustParseAddr("100.64.0.2")
+	})
+
+	tests := []struct {
+		mode appctype.FirewallMode
+		src  string
+		want bool
+	}{
+		{appctype.FirewallModeOff, "192.168.1.2:1234", true},
+		{appctype.FirewallModeEnforce, "100.64.0.2:1234", true},
+		{appctype.FirewallModeEnforce, "100.64.0.3:1234", false},
+		{appctype.FirewallModeEnforce, "192.168.1.2:1234", false},
+		{appctype.FirewallModeDryRun, "192.168.1.2:1234", true},
+	}
+	for _, tt := range tests {
+		cfg.Firewall = tt.mode
+		s.Configure(cfg)
+		_, got := s.HandleTCPFlow(netip.MustParseAddrPort(tt.src), dst)
+		if got != tt.want {
+			t.Errorf("mode %q, src %v: intercept = %v, want %v", tt.mode, tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestConfigureIfUnchanged(t *testing.T) {
+	s := &Server{}
+	if cfg, gen := s.Config(); cfg != nil || gen != 0 {
+		t.Fatalf("initial Config = %v, %d; want nil, 0", cfg, gen)
+	}
+
+	a := &appctype.AppConnectorConfig{AdvertiseRoutes: true}
+	gen, err := s.ConfigureIfUnchanged(a, 0)
+	if err != nil || gen != 1 {
+		t.Fatalf("ConfigureIfUnchanged = %d, %v; want 1, nil", gen, err)
+	}
+
+	// A concurrent Configure invalidates the generation.
+	b := &appctype.AppConnectorConfig{}
+	s.Configure(b)
+	if gen, err := s.ConfigureIfUnchanged(a, 1); !errors.Is(err, ErrConfigChanged) || gen != 2 {
+		t.Fatalf("stale ConfigureIfUnchanged = %d, %v; want 2, ErrConfigChanged", gen, err)
+	}
+	if cfg, gen := s.Config(); cfg != b || gen != 2 {
+		t.Fatalf("Config = %v, %d; want %v, 2", cfg, gen, b)
+	}
+}
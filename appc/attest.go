@@ -0,0 +1,130 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"tailscale.com/types/appctype"
+	"tailscale.com/types/tkatype"
+	"tailscale.com/util/mak"
+)
+
+// SetDomainAttestationKeys configures the app connector to only proxy
+// domains covered by an appctype.DomainAttestation signed by one of keys,
+// which are tailnet key authority 25519 key IDs. Domains that are not
+// covered by a valid attestation are refused. If the keys changed, the
+// current configuration is reapplied with them.
+//
+// Passing no keys disables attestation checking.
+func (s *Server) SetDomainAttestationKeys(keys []tkatype.KeyID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if slices.EqualFunc(s.attestationKeys, keys, func(a, b tkatype.KeyID) bool { return bytes.Equal(a, b) }) {
+		return
+	}
+	s.attestationKeys = slices.Clone(keys)
+	if s.config != nil {
+		s.configureLocked(s.config)
+	}
+}
+
+// verifyDomainAttestation reports whether att is validly signed by one of
+// keys, returning the set of lowercased domains it covers.
+func verifyDomainAttestation(att *appctype.DomainAttestation, keys []tkatype.KeyID) (map[string]bool, error) {
+	if att == nil {
+		return nil, errors.New("no domain attestation")
+	}
+	if !slices.ContainsFunc(keys, func(k tkatype.KeyID) bool { return bytes.Equal(k, att.Signature.KeyID) }) {
+		return nil, fmt.Errorf("domain attestation signed by untrusted key %x", []byte(att.Signature.KeyID))
+	}
+	if len(att.Signature.KeyID) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("domain attestation key has length %d, want %d", len(att.Signature.KeyID), ed25519.PublicKeySize)
+	}
+	h := appctype.DomainsHash(att.Domains)
+	if !ed25519.Verify(ed25519.PublicKey(att.Signature.KeyID), h[:], att.Signature.Signature) {
+		return nil, errors.New("invalid domain attestation signature")
+	}
+	ret := make(map[string]bool, len(att.Domains))
+	for _, d := range att.Domains {
+		ret[strings.ToLower(d)] = true
+	}
+	return ret, nil
+}
+
+// attestedConfig returns a copy of cfg with all domains that are not covered
+// by its attestation, as verified with keys, removed. Allow domain patterns
+// are covered if the attestation lists their String form. Services left with
+// no destinations are removed entirely, as an SNI proxy with no allowed
+// domains permits every domain.
+func attestedConfig(cfg *appctype.AppConnectorConfig, keys []tkatype.KeyID) *appctype.AppConnectorConfig {
+	attested, err := verifyDomainAttestation(cfg.DomainAttestation, keys)
+	if err != nil {
+		log.Printf("appc: refusing all domains: %v", err)
+	}
+	allowed := func(cID appctype.ConfigID, d string) bool {
+		if attested[strings.ToLower(d)] {
+			return true
+		}
+		log.Printf("appc: %s: refusing unattested domain %q", cID, d)
+		return false
+	}
+
+	ret := *cfg
+	ret.DNAT = nil
+	ret.SNIProxy = nil
+	for cID, d := range cfg.DNAT {
+		var to []string
+		var weights []int // kept parallel to to
+		for i, dst := range d.To {
+			if _, err := netip.ParseAddr(dst); err == nil || allowed(cID, dst) {
+				to = append(to, dst)
+				if len(d.Weights) == len(d.To) {
+					weights = append(weights, d.Weights[i])
+				}
+			}
+		}
+		if len(to) == 0 {
+			continue
+		}
+		d.To = to
+		d.Weights = weights
+		mak.Set(&ret.DNAT, cID, d)
+	}
+	for cID, c := range cfg.SNIProxy {
+		var domains []string
+		for _, d := range c.AllowedDomains {
+			if allowed(cID, d) {
+				domains = append(domains, d)
+			}
+		}
+		var patterns []appctype.DomainPattern
+		var allowPatterns int
+		for _, p := range c.DomainPatterns {
+			// Deny patterns only narrow what's proxied, so need no
+			// attestation.
+			if p.Deny || allowed(cID, p.String()) {
+				patterns = append(patterns, p)
+				if !p.Deny {
+					allowPatterns++
+				}
+			}
+		}
+		if len(domains) == 0 && allowPatterns == 0 {
+			log.Printf("appc: %s: refusing SNI proxy with no attested domains", cID)
+			continue
+		}
+		c.AllowedDomains = domains
+		c.DomainPatterns = patterns
+		mak.Set(&ret.SNIProxy, cID, c)
+	}
+	return &ret
+}
@@ -0,0 +1,154 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"crypto/ed25519"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/appctype"
+	"tailscale.com/types/tkatype"
+)
+
+func TestConfigureDomainAttestation(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attest := func(priv ed25519.PrivateKey, domains ...string) *appctype.DomainAttestation {
+		h := appctype.DomainsHash(domains)
+		return &appctype.DomainAttestation{
+			Domains: domains,
+			Signature: tkatype.Signature{
+				KeyID:     tkatype.KeyID(priv.Public().(ed25519.PublicKey)),
+				Signature: ed25519.Sign(priv, h[:]),
+			},
+		}
+	}
+
+	addr := netip.MustParseAddr("100.64.0.1")
+	all := []tailcfg.ProtoPortRange{{Ports: tailcfg.PortRangeAny}}
+	cfg := func(att *appctype.DomainAttestation) *appctype.AppConnectorConfig {
+		return &appctype.AppConnectorConfig{
+			DNAT: map[appctype.ConfigID]appctype.DNATConfig{
+				"dnat-ok":  {Addrs: []netip.Addr{addr}, To: []string{"Example.org"}, IP: all},
+				"dnat-bad": {Addrs: []netip.Addr{addr}, To: []string{"evil.example"}, IP: all},
+				"dnat-ip":  {Addrs: []netip.Addr{addr}, To: []string{"192.0.2.1"}, IP: all},
+			},
+			SNIProxy: map[appctype.ConfigID]appctype.SNIProxyConfig{
+				"sni":     {Addrs: []netip.Addr{addr}, AllowedDomains: []string{"example.com", "evil.example"}, IP: all},
+				"sni-any": {Addrs: []netip.Addr{addr}, IP: all},
+				"sni-glob": {Addrs: []netip.Addr{addr}, IP: all, DomainPatterns: []appctype.DomainPattern{
+					{Glob: "*.example.net"},
+					{Glob: "admin.**", Deny: true},
+				}},
+			},
+			DomainAttestation: att,
+		}
+	}
+	connectorIDs := func(s *Server) []appctype.ConfigID {
+		var ret []appctype.ConfigID
+		for cID := range s.connectors {
+			ret = append(ret, cID)
+		}
+		slices.Sort(ret)
+		return ret
+	}
+	allowlist := func(s *Server, cID appctype.ConfigID) []string {
+		for _, h := range s.connectors[cID].Handlers {
+			return h.(*tcpSNIHandler).Allowlist
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name    string
+		keys    []tkatype.KeyID
+		att     *appctype.DomainAttestation
+		wantIDs []appctype.ConfigID
+		wantSNI []string
+	}{
+		{
+			name:    "disabled",
+			att:     nil,
+			wantIDs: []appctype.ConfigID{"dnat-bad", "dnat-ip", "dnat-ok", "sni", "sni-any", "sni-glob"},
+			wantSNI: []string{"example.com", "evil.example"},
+		},
+		{
+			name:    "attested",
+			keys:    []tkatype.KeyID{tkatype.KeyID(pub)},
+			att:     attest(priv, "example.com", "example.org", "glob:*.example.net"),
+			wantIDs: []appctype.ConfigID{"dnat-ip", "dnat-ok", "sni", "sni-glob"},
+			wantSNI: []string{"example.com"},
+		},
+		{
+			name:    "unsigned",
+			keys:    []tkatype.KeyID{tkatype.KeyID(pub)},
+			att:     nil,
+			wantIDs: []appctype.ConfigID{"dnat-ip"},
+		},
+		{
+			name:    "untrusted-key",
+			keys:    []tkatype.KeyID{tkatype.KeyID(pub)},
+			att:     attest(otherPriv, "example.com", "example.org"),
+			wantIDs: []appctype.ConfigID{"dnat-ip"},
+		},
+		{
+			name:    "second-trusted-key",
+			keys:    []tkatype.KeyID{tkatype.KeyID(pub), tkatype.KeyID(otherPub)},
+			att:     attest(otherPriv, "example.com"),
+			wantIDs: []appctype.ConfigID{"dnat-ip", "sni"},
+			wantSNI: []string{"example.com"},
+		},
+		{
+			name: "tampered",
+			keys: []tkatype.KeyID{tkatype.KeyID(pub)},
+			att: func() *appctype.DomainAttestation {
+				att := attest(priv, "example.com")
+				att.Domains = append(att.Domains, "evil.example")
+				return att
+			}(),
+			wantIDs: []appctype.ConfigID{"dnat-ip"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := new(Server)
+			s.SetDomainAttestationKeys(tt.keys)
+			s.Configure(cfg(tt.att))
+			if got := connectorIDs(s); !slices.Equal(got, tt.wantIDs) {
+				t.Errorf("connectors = %q; want %q", got, tt.wantIDs)
+			}
+			if got := allowlist(s, "sni"); !slices.Equal(got, tt.wantSNI) {
+				t.Errorf("sni allowlist = %q; want %q", got, tt.wantSNI)
+			}
+			var status []appctype.ConfigID
+			for _, st := range s.Status() {
+				_, cID, _ := strings.Cut(st.Service, "/")
+				status = append(status, appctype.ConfigID(cID))
+			}
+			slices.Sort(status)
+			if !slices.Equal(status, tt.wantIDs) {
+				t.Errorf("status services = %q; want %q", status, tt.wantIDs)
+			}
+
+			// Setting the keys after configuring reapplies the
+			// configuration with them.
+			s = new(Server)
+			s.Configure(cfg(tt.att))
+			s.SetDomainAttestationKeys(tt.keys)
+			if got := connectorIDs(s); !slices.Equal(got, tt.wantIDs) {
+				t.Errorf("after late SetDomainAttestationKeys, connectors = %q; want %q", got, tt.wantIDs)
+			}
+		})
+	}
+}
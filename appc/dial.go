@@ -0,0 +1,111 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"tailscale.com/types/appctype"
+	"tailscale.com/util/set"
+)
+
+// dialTimeout is how long to wait for a connection to a destination.
+const dialTimeout = 5 * time.Second
+
+// SetTailnetRouting configures the app connector to reach destinations
+// whose addresses are routed over the tailnet, such as another node's subnet
+// routes or another app connector, by dialing them with dial instead of on
+// the host network. isTailnetRoute reports whether an address is routed over
+// the tailnet.
+//
+// Passing nil functions dials all destinations on the host network.
+func (s *Server) SetTailnetRouting(isTailnetRoute func(netip.Addr) bool, dial func(ctx context.Context, network, address string) (net.Conn, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if isTailnetRoute == nil || dial == nil {
+		isTailnetRoute, dial = nil, nil
+	}
+	s.isTailnetRoute, s.tailnetDial = isTailnetRoute, dial
+}
+
+// errRoutingLoop is returned when a destination resolves to one of the app
+// connector's own addresses.
+var errRoutingLoop = errors.New("routing loop")
+
+// listenAddrsFromConfig returns the addresses of all services in cfg.
+func listenAddrsFromConfig(cfg *appctype.AppConnectorConfig) set.Set[netip.Addr] {
+	ret := make(set.Set[netip.Addr])
+	for _, d := range cfg.DNAT {
+		ret.AddSlice(d.Addrs)
+	}
+	for _, d := range cfg.SNIProxy {
+		ret.AddSlice(d.Addrs)
+	}
+	return ret
+}
+
+// dial dials address, a host:port whose host is a DNS name or IP address,
+// trying each of its addresses in turn. Addresses routed over the tailnet are
+// dialed over the tailnet; addresses of the app connector itself are refused
+// as routing loops.
+func (s *Server) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+
+	var ips []netip.Addr
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ips = []netip.Addr{ip}
+	} else {
+		lookup := s.lookupNetIP
+		if lookup == nil {
+			lookup = net.DefaultResolver.LookupNetIP
+		}
+		ips, err = lookup(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+		s.learnRoutes(ips)
+	}
+
+	s.mu.RLock()
+	listenAddrs, isTailnetRoute, tailnetDial := s.listenAddrs, s.isTailnetRoute, s.tailnetDial
+	s.mu.RUnlock()
+
+	hostDial := s.hostDial
+	if hostDial == nil {
+		var d net.Dialer
+		hostDial = d.DialContext
+	}
+
+	var errs []error
+	for _, ip := range ips {
+		ip = ip.Unmap()
+		if listenAddrs.Contains(ip) {
+			errs = append(errs, fmt.Errorf("%s: %w: %v is an app connector address", address, errRoutingLoop, ip))
+			continue
+		}
+		dial := hostDial
+		if isTailnetRoute != nil && isTailnetRoute(ip) {
+			dial = tailnetDial
+		}
+		c, err := dial(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return c, nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("%s: no addresses", address)
+	}
+	return nil, errors.Join(errs...)
+}
@@ -0,0 +1,103 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"tailscale.com/net/memnet"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/appctype"
+)
+
+func TestDialTailnetRouting(t *testing.T) {
+	connAddr := netip.MustParseAddr("100.64.0.1")
+	siteRoute := netip.MustParsePrefix("10.1.0.0/16")
+	dnsRecords := map[string][]netip.Addr{
+		"lan.example":   {netip.MustParseAddr("192.168.1.10")},
+		"site.example":  {netip.MustParseAddr("10.1.2.3")},
+		"loop.example":  {connAddr},
+		"mixed.example": {connAddr, netip.MustParseAddr("10.1.2.4")},
+	}
+
+	var hostDials, tailnetDials []string
+	fakeDial := func(dials *[]string) dialFunc {
+		return func(ctx context.Context, network, address string) (net.Conn, error) {
+			*dials = append(*dials, address)
+			c, _ := memnet.NewConn(address, 1024)
+			return c, nil
+		}
+	}
+	s := &Server{
+		lookupNetIP: func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+			if ips, ok := dnsRecords[host]; ok {
+				return ips, nil
+			}
+			return nil, errors.New("no such host")
+		},
+		hostDial: fakeDial(&hostDials),
+	}
+	s.SetTailnetRouting(siteRoute.Contains, fakeDial(&tailnetDials))
+	s.Configure(&appctype.AppConnectorConfig{
+		DNAT: map[appctype.ConfigID]appctype.DNATConfig{
+			"a": {
+				Addrs: []netip.Addr{connAddr},
+				To:    []string{"site.example"},
+				IP:    []tailcfg.ProtoPortRange{{Ports: tailcfg.PortRangeAny}},
+			},
+		},
+	})
+
+	tests := []struct {
+		addr        string
+		wantHost    []string
+		wantTailnet []string
+		wantLoop    bool
+	}{
+		{addr: "lan.example:80", wantHost: []string{"192.168.1.10:80"}},
+		{addr: "site.example:80", wantTailnet: []string{"10.1.2.3:80"}},
+		{addr: "10.1.9.9:22", wantTailnet: []string{"10.1.9.9:22"}},
+		{addr: "loop.example:80", wantLoop: true},
+		{addr: "100.64.0.1:80", wantLoop: true},
+		{addr: "mixed.example:80", wantTailnet: []string{"10.1.2.4:80"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			hostDials, tailnetDials = nil, nil
+			c, err := s.dial(context.Background(), "tcp", tt.addr)
+			if tt.wantLoop {
+				if !errors.Is(err, errRoutingLoop) {
+					t.Fatalf("err = %v; want routing loop", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else {
+				c.Close()
+			}
+			if !slices.Equal(hostDials, tt.wantHost) {
+				t.Errorf("host dials = %q; want %q", hostDials, tt.wantHost)
+			}
+			if !slices.Equal(tailnetDials, tt.wantTailnet) {
+				t.Errorf("tailnet dials = %q; want %q", tailnetDials, tt.wantTailnet)
+			}
+		})
+	}
+
+	// Without tailnet routing, everything is dialed on the host network.
+	s.SetTailnetRouting(nil, nil)
+	hostDials, tailnetDials = nil, nil
+	c, err := s.dial(context.Background(), "tcp", "site.example:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if len(hostDials) != 1 || len(tailnetDials) != 0 {
+		t.Errorf("host dials = %q, tailnet dials = %q; want one host dial", hostDials, tailnetDials)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"tailscale.com/types/appctype"
+)
+
+// domainPatterns is the compiled form of the DomainPatterns of an
+// appctype.SNIProxyConfig.
+type domainPatterns struct {
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+// compileDomainPatterns compiles ps, or returns nil if ps is empty.
+func compileDomainPatterns(ps []appctype.DomainPattern) (*domainPatterns, error) {
+	if len(ps) == 0 {
+		return nil, nil
+	}
+	ret := new(domainPatterns)
+	for _, p := range ps {
+		var expr string
+		switch {
+		case p.Glob != "" && p.Regexp != "":
+			return nil, fmt.Errorf("domain pattern %v: both glob and regexp set", p)
+		case p.Glob != "":
+			expr = globToRegexp(p.Glob)
+		case p.Regexp != "":
+			expr = p.Regexp
+		default:
+			return nil, errors.New("empty domain pattern")
+		}
+		re, err := regexp.Compile(`(?i)^(?:` + expr + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("domain pattern %v: %w", p, err)
+		}
+		if p.Deny {
+			ret.deny = append(ret.deny, re)
+		} else {
+			ret.allow = append(ret.allow, re)
+		}
+	}
+	return ret, nil
+}
+
+// globToRegexp returns the regular expression equivalent to glob, a
+// appctype.DomainPattern glob.
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				sb.WriteString(`.*`)
+				i++
+			} else {
+				sb.WriteString(`[^.]*`)
+			}
+		case '?':
+			sb.WriteString(`[^.]`)
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+func matchesAny(res []*regexp.Regexp, name string) bool {
+	for _, re := range res {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// domainAllowed reports whether name may be proxied by an SNI proxy whose
+// allowed domains are allowlist and whose compiled domain patterns are
+// patterns, which may be nil.
+func domainAllowed(name string, allowlist []string, patterns *domainPatterns) bool {
+	name = strings.TrimSuffix(name, ".")
+	if patterns != nil && matchesAny(patterns.deny, name) {
+		return false
+	}
+	if len(allowlist) == 0 && (patterns == nil || len(patterns.allow) == 0) {
+		return true
+	}
+	for _, d := range allowlist {
+		if strings.EqualFold(d, name) {
+			return true
+		}
+		if strings.HasPrefix(d, ".") && len(name) > len(d) && strings.EqualFold(d, name[len(name)-len(d):]) {
+			return true
+		}
+	}
+	return patterns != nil && matchesAny(patterns.allow, name)
+}
@@ -0,0 +1,109 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/appctype"
+)
+
+func TestDomainAllowed(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowlist []string
+		patterns  []appctype.DomainPattern
+		allowed   []string
+		refused   []string
+	}{
+		{
+			name:    "allow-all",
+			allowed: []string{"example.com", "a.b.example.org"},
+		},
+		{
+			name:      "exact-and-suffix",
+			allowlist: []string{"Example.com", ".internal.example.org"},
+			allowed:   []string{"example.com", "EXAMPLE.com.", "git.internal.example.org", "a.b.internal.example.org"},
+			refused:   []string{"www.example.com", "internal.example.org", "xinternal.example.org"},
+		},
+		{
+			name: "glob-with-deny",
+			patterns: []appctype.DomainPattern{
+				{Glob: "*.internal.example.com"},
+				{Glob: "admin.**", Deny: true},
+			},
+			allowed: []string{"git.internal.example.com", "GIT.internal.example.com"},
+			refused: []string{"admin.internal.example.com", "a.b.internal.example.com", "internal.example.com", "example.org"},
+		},
+		{
+			name: "double-star-and-question",
+			patterns: []appctype.DomainPattern{
+				{Glob: "**.example.com"},
+				{Glob: "db?.example.org"},
+			},
+			allowed: []string{"a.b.example.com", "db1.example.org"},
+			refused: []string{"example.com", "db12.example.org", "db..example.org"},
+		},
+		{
+			name:      "regexp",
+			allowlist: []string{"example.com"},
+			patterns: []appctype.DomainPattern{
+				{Regexp: `build-[0-9]+\.ci\.example\.com`},
+			},
+			allowed: []string{"example.com", "build-42.ci.example.com"},
+			refused: []string{"build-x.ci.example.com", "xbuild-42.ci.example.com.evil"},
+		},
+		{
+			name: "deny-only",
+			patterns: []appctype.DomainPattern{
+				{Regexp: `.*\.corp`, Deny: true},
+			},
+			allowed: []string{"example.com"},
+			refused: []string{"hr.corp"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patterns, err := compileDomainPatterns(tt.patterns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, d := range tt.allowed {
+				if !domainAllowed(d, tt.allowlist, patterns) {
+					t.Errorf("%q refused, want allowed", d)
+				}
+			}
+			for _, d := range tt.refused {
+				if domainAllowed(d, tt.allowlist, patterns) {
+					t.Errorf("%q allowed, want refused", d)
+				}
+			}
+		})
+	}
+}
+
+func TestCompileDomainPatternsErrors(t *testing.T) {
+	for _, ps := range [][]appctype.DomainPattern{
+		{{}},
+		{{Glob: "*.example.com", Regexp: ".*"}},
+		{{Regexp: "(", Deny: true}},
+	} {
+		if _, err := compileDomainPatterns(ps); err == nil {
+			t.Errorf("compileDomainPatterns(%v) succeeded, want error", ps)
+		}
+	}
+
+	// A service with a bad pattern is refused entirely.
+	var c connector
+	installSNIHandler("sni/bad", &appctype.SNIProxyConfig{
+		Addrs:          []netip.Addr{netip.MustParseAddr("100.64.0.1")},
+		IP:             []tailcfg.ProtoPortRange{{Ports: tailcfg.PortRangeAny}},
+		DomainPatterns: []appctype.DomainPattern{{Regexp: "(", Deny: true}},
+	}, nil, nil, nil, nil, &c)
+	if len(c.Handlers) != 0 {
+		t.Errorf("installed %d handlers for a service with a bad pattern", len(c.Handlers))
+	}
+}
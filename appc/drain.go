@@ -0,0 +1,129 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/types/appctype"
+)
+
+const defaultDrainTimeout = 30 * time.Second
+
+// drain is a service removed from the app connector's configuration whose
+// connections are being drained.
+type drain struct {
+	rs       *ruleStats
+	started  time.Time
+	deadline time.Time
+	timer    *time.Timer // closes the remaining connections at deadline
+}
+
+// handlerStats returns the stats of h, or nil if it has none.
+func handlerStats(h handler) *ruleStats {
+	switch h := h.(type) {
+	case *tcpRoundRobinHandler:
+		return h.Stats
+	case *tcpSNIHandler:
+		return h.Stats
+	}
+	return nil
+}
+
+// servicesOf returns the stats of the services of connectors, keyed by
+// service name.
+func servicesOf(connectors map[appctype.ConfigID]connector) map[string]*ruleStats {
+	ret := make(map[string]*ruleStats)
+	for _, c := range connectors {
+		for _, h := range c.Handlers {
+			if rs := handlerStats(h); rs != nil {
+				ret[rs.service] = rs
+			}
+		}
+	}
+	return ret
+}
+
+// drainRemovedLocked starts draining the connections of the services of
+// old, the connectors of the previous configuration, which aren't in the
+// current one. Their connections are closed after timeout, or immediately
+// if timeout is negative. s.mu must be held.
+func (s *Server) drainRemovedLocked(old map[appctype.ConfigID]connector, timeout time.Duration) {
+	if timeout == 0 {
+		timeout = defaultDrainTimeout
+	}
+	current := servicesOf(s.connectors)
+	for service, rs := range servicesOf(old) {
+		if _, ok := current[service]; ok {
+			continue
+		}
+		n := rs.activeConns()
+		if n == 0 {
+			continue
+		}
+		if timeout < 0 {
+			log.Printf("appc: closing %d connections of removed service %s", n, service)
+			go rs.closeConns()
+			continue
+		}
+		log.Printf("appc: draining %d connections of removed service %s for up to %v", n, service, timeout)
+		now := time.Now()
+		d := &drain{rs: rs, started: now, deadline: now.Add(timeout)}
+		d.timer = time.AfterFunc(timeout, func() { s.finishDrain(d) })
+		s.drains = append(s.drains, d)
+	}
+}
+
+// finishDrain closes the connections of d still open at its deadline.
+func (s *Server) finishDrain(d *drain) {
+	s.mu.Lock()
+	s.drains = slices.DeleteFunc(s.drains, func(d2 *drain) bool { return d2 == d })
+	s.mu.Unlock()
+
+	if n := d.rs.closeConns(); n > 0 {
+		getMetrics().drainClosedConns.Add(int64(n))
+		log.Printf("appc: closed %d connections of removed service %s after draining", n, d.rs.service)
+	}
+}
+
+// stopDrainsLocked closes the connections of all services being drained.
+// s.mu must be held.
+func (s *Server) stopDrainsLocked() {
+	for _, d := range s.drains {
+		d.timer.Stop()
+		go d.rs.closeConns()
+	}
+	s.drains = nil
+}
+
+// Drains returns the progress of draining the connections of services
+// removed from the configuration which still have open connections, sorted
+// by service.
+func (s *Server) Drains() []appctype.DrainStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var ret []appctype.DrainStatus
+	for _, d := range s.drains {
+		n := d.rs.activeConns()
+		if n == 0 {
+			continue
+		}
+		ret = append(ret, appctype.DrainStatus{
+			Service:     d.rs.service,
+			Started:     d.started,
+			Deadline:    d.deadline,
+			ActiveConns: n,
+		})
+	}
+	slices.SortFunc(ret, func(a, b appctype.DrainStatus) int {
+		if c := strings.Compare(a.Service, b.Service); c != 0 {
+			return c
+		}
+		return a.Started.Compare(b.Started)
+	})
+	return ret
+}
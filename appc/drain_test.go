@@ -0,0 +1,73 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"io"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/net/memnet"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/appctype"
+)
+
+func TestDrainRemovedServices(t *testing.T) {
+	dnat := func(addr string) appctype.DNATConfig {
+		return appctype.DNATConfig{
+			Addrs: []netip.Addr{netip.MustParseAddr(addr)},
+			To:    []string{"example.com"},
+			IP:    []tailcfg.ProtoPortRange{{Ports: tailcfg.PortRangeAny}},
+		}
+	}
+	s := new(Server)
+	defer s.Close()
+	s.Configure(&appctype.AppConnectorConfig{
+		DNAT: map[appctype.ConfigID]appctype.DNATConfig{
+			"a": dnat("100.64.0.1"),
+			"b": dnat("100.64.0.2"),
+		},
+		DrainTimeout: time.Hour,
+	})
+
+	// Open a connection to each service.
+	open := func(service string) io.Reader {
+		t.Helper()
+		s.mu.RLock()
+		rs := servicesOf(s.connectors)[service]
+		s.mu.RUnlock()
+		client, server := memnet.NewConn(service, 1024)
+		rs.trackConn(server, "tcp")
+		return client
+	}
+	clientA := open("dnat/a")
+	open("dnat/b")
+
+	// Removing a, but not b, drains a.
+	s.Configure(&appctype.AppConnectorConfig{
+		DNAT:         map[appctype.ConfigID]appctype.DNATConfig{"b": dnat("100.64.0.2")},
+		DrainTimeout: time.Hour,
+	})
+	drains := s.Drains()
+	if len(drains) != 1 || drains[0].Service != "dnat/a" || drains[0].ActiveConns != 1 {
+		t.Fatalf("Drains = %+v, want dnat/a with 1 connection", drains)
+	}
+	if got, want := drains[0].Deadline.Sub(drains[0].Started), time.Hour; got != want {
+		t.Errorf("drain deadline %v after start, want %v", got, want)
+	}
+
+	// At the deadline, the remaining connections are closed.
+	s.mu.RLock()
+	d := s.drains[0]
+	s.mu.RUnlock()
+	d.timer.Stop()
+	s.finishDrain(d)
+	if _, err := clientA.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read from drained connection: %v, want EOF", err)
+	}
+	if drains := s.Drains(); len(drains) != 0 {
+		t.Errorf("Drains after deadline = %+v", drains)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"log"
+	"net/netip"
+
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/types/appctype"
+)
+
+// allowsSourceLocked reports whether the firewall mode of the current
+// configuration accepts a flow from src to dst. Flows reach the app connector
+// through netstack, so this is where they're filtered: in
+// appctype.FirewallModeEnforce, flows from sources that aren't Tailscale
+// addresses of known peers are refused, and in appctype.FirewallModeDryRun
+// they're logged and accepted.
+//
+// s.mu must be held.
+func (s *Server) allowsSourceLocked(src, dst netip.AddrPort) bool {
+	if s.effectiveConfig == nil || s.effectiveConfig.Firewall == appctype.FirewallModeOff {
+		return true
+	}
+	if s.isTailnetSourceLocked(src) {
+		return true
+	}
+	if s.effectiveConfig.Firewall == appctype.FirewallModeDryRun {
+		log.Printf("appc: firewall dry-run: would refuse flow %v -> %v from outside the tailnet", src, dst)
+		return true
+	}
+	getMetrics().firewallRefused.Add(1)
+	return false
+}
+
+// isTailnetSourceLocked reports whether src is a Tailscale address and, if
+// the identity of clients can be looked up, belongs to a known peer.
+//
+// s.mu must be held.
+func (s *Server) isTailnetSourceLocked(src netip.AddrPort) bool {
+	if !tsaddr.IsTailscaleIP(src.Addr()) {
+		return false
+	}
+	if s.whoIs == nil {
+		return true
+	}
+	_, _, ok := s.whoIs(src)
+	return ok
+}
@@ -0,0 +1,254 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/types/appctype"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/set"
+)
+
+// ruleMetrics are the clientmetrics of one kind of app connector service,
+// such as "dnat", shared by all services of that kind. Services themselves
+// are named in the configuration, so they aren't part of metric names, which
+// must be bounded; see ruleStats for their own counts.
+type ruleMetrics struct {
+	activeConns   *clientmetric.Metric
+	bytesIn       *clientmetric.Metric
+	bytesOut      *clientmetric.Metric
+	connectErrors *clientmetric.Metric
+	dials         *clientmetric.Metric
+}
+
+var (
+	ruleMetricsMu sync.Mutex
+	ruleMetricsBy map[string]*ruleMetrics // keyed by service kind
+)
+
+// getRuleMetrics returns the metrics of the kind of service, such as "dnat"
+// for "dnat/foo", creating them if needed.
+func getRuleMetrics(service string) *ruleMetrics {
+	kind, _, _ := strings.Cut(service, "/")
+	ruleMetricsMu.Lock()
+	defer ruleMetricsMu.Unlock()
+	if m, ok := ruleMetricsBy[kind]; ok {
+		return m
+	}
+	prefix := "sniproxy_" + kind + "_"
+	m := &ruleMetrics{
+		activeConns:   clientmetric.NewGauge(prefix + "active_conns"),
+		bytesIn:       clientmetric.NewCounter(prefix + "bytes_in"),
+		bytesOut:      clientmetric.NewCounter(prefix + "bytes_out"),
+		connectErrors: clientmetric.NewCounter(prefix + "connect_errors"),
+		dials:         clientmetric.NewCounter(prefix + "dials"),
+	}
+	mak.Set(&ruleMetricsBy, kind, m)
+	return m
+}
+
+// flowLogger writes a JSON line for each finished connection through the
+// app connector, if it has a writer.
+type flowLogger struct {
+	mu sync.Mutex
+	w  io.Writer // or nil to not log flows
+}
+
+// SetFlowLog sets w as the destination of the app connector's flow log, a
+// JSON-encoded appctype.FlowLogEntry per line for each connection or UDP flow
+// that ends. tsnet sets it to its AppConnectorFlowLog. Passing nil disables
+// the flow log.
+func (s *Server) SetFlowLog(w io.Writer) {
+	s.flowLog.mu.Lock()
+	defer s.flowLog.mu.Unlock()
+	s.flowLog.w = w
+}
+
+func (l *flowLogger) log(e *appctype.FlowLogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.w == nil {
+		return
+	}
+	// Encode writes the entry in one Write call, ending in a newline.
+	json.NewEncoder(l.w).Encode(e)
+}
+
+// ruleStats accounts the connections of one service of an app connector,
+// both in the service's own counts, reported by Status, and in the metrics of
+// its kind.
+type ruleStats struct {
+	service string
+	m       *ruleMetrics
+	flows   *flowLogger // or nil
+
+	bytesIn       atomic.Int64
+	bytesOut      atomic.Int64
+	connectErrors atomic.Int64
+	dials         atomic.Int64
+
+	mu    sync.Mutex
+	conns set.Set[*trackedConn] // open connections
+}
+
+func newRuleStats(service string, flows *flowLogger) *ruleStats {
+	return &ruleStats{
+		service: service,
+		m:       getRuleMetrics(service),
+		flows:   flows,
+	}
+}
+
+// trackConn returns c wrapped to account its traffic to the service, and to
+// log its flow when closed. rs may be nil, in which case nothing is
+// accounted.
+func (rs *ruleStats) trackConn(c net.Conn, proto string) *trackedConn {
+	tc := &trackedConn{Conn: c, rs: rs, start: time.Now(), proto: proto}
+	if rs != nil {
+		rs.m.activeConns.Add(1)
+		rs.mu.Lock()
+		mak.Set(&rs.conns, tc, struct{}{})
+		rs.mu.Unlock()
+	}
+	return tc
+}
+
+// activeConns returns the number of open connections of the service.
+func (rs *ruleStats) activeConns() int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.conns.Len()
+}
+
+// addStatus adds the counts of the service to st.
+func (rs *ruleStats) addStatus(st *appctype.ServiceStatus) {
+	st.ActiveConns = rs.activeConns()
+	st.BytesIn = rs.bytesIn.Load()
+	st.BytesOut = rs.bytesOut.Load()
+	st.ConnectErrors = rs.connectErrors.Load()
+	st.Dials = rs.dials.Load()
+}
+
+// closeConns closes the open connections of the service, returning how
+// many there were.
+func (rs *ruleStats) closeConns() int {
+	rs.mu.Lock()
+	conns := rs.conns.Slice()
+	rs.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+	return len(conns)
+}
+
+// trackedConn is a connection from a client of an app connector service.
+type trackedConn struct {
+	net.Conn
+	rs    *ruleStats // or nil
+	start time.Time
+	proto string
+
+	bytesIn  atomic.Int64 // read from the client
+	bytesOut atomic.Int64 // written to the client
+
+	mu      sync.Mutex
+	backend string // address dialed for the connection, if any
+	err     error  // error dialing the backend, if any
+
+	closeOnce sync.Once
+}
+
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.bytesIn.Add(int64(n))
+	if c.rs != nil {
+		c.rs.bytesIn.Add(int64(n))
+		c.rs.m.bytesIn.Add(int64(n))
+	}
+	return n, err
+}
+
+func (c *trackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.bytesOut.Add(int64(n))
+	if c.rs != nil {
+		c.rs.bytesOut.Add(int64(n))
+		c.rs.m.bytesOut.Add(int64(n))
+	}
+	return n, err
+}
+
+// Close closes the connection and, the first time, logs its flow.
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		if c.rs == nil {
+			return
+		}
+		c.rs.m.activeConns.Add(-1)
+		c.rs.mu.Lock()
+		c.rs.conns.Delete(c)
+		c.rs.mu.Unlock()
+		if c.rs.flows != nil {
+			c.rs.flows.log(c.flowLogEntry())
+		}
+	})
+	return err
+}
+
+func (c *trackedConn) flowLogEntry() *appctype.FlowLogEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := &appctype.FlowLogEntry{
+		Start:    c.start,
+		Duration: time.Since(c.start),
+		Service:  c.rs.service,
+		Proto:    c.proto,
+		Src:      c.RemoteAddr().String(),
+		Dst:      c.LocalAddr().String(),
+		Backend:  c.backend,
+		BytesIn:  c.bytesIn.Load(),
+		BytesOut: c.bytesOut.Load(),
+	}
+	if c.err != nil {
+		e.Error = c.err.Error()
+	}
+	return e
+}
+
+// dialer returns dial wrapped to account the backends dialed for c.
+func (c *trackedConn) dialer(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		c.dialed(address, err)
+		return conn, err
+	}
+}
+
+// dialed records the dial of a backend at address for c, which failed if
+// err is non-nil.
+func (c *trackedConn) dialed(address string, err error) {
+	c.mu.Lock()
+	c.backend, c.err = address, err
+	c.mu.Unlock()
+	if c.rs == nil {
+		return
+	}
+	if err != nil {
+		c.rs.connectErrors.Add(1)
+		c.rs.m.connectErrors.Add(1)
+	} else {
+		c.rs.dials.Add(1)
+		c.rs.m.dials.Add(1)
+	}
+}
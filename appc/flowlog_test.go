@@ -0,0 +1,153 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/appctype"
+	"tailscale.com/types/ipproto"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) entries(t *testing.T) []appctype.FlowLogEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var ret []appctype.FlowLogEntry
+	dec := json.NewDecoder(bytes.NewReader(b.b.Bytes()))
+	for {
+		var e appctype.FlowLogEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			return ret
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		ret = append(ret, e)
+	}
+}
+
+func TestTrackedConn(t *testing.T) {
+	var fl flowLogger
+	var buf syncBuffer
+	fl.w = &buf
+	rs := newRuleStats("dnat/test-tracked", &fl)
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := rs.trackConn(c1, "tcp")
+	if v := rs.activeConns(); v != 1 {
+		t.Errorf("active conns = %d, want 1", v)
+	}
+
+	go io.Copy(c2, c2) // echo
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(c, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	dial := c.dialer(func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, errors.New("no route to host")
+	})
+	if _, err := dial(context.Background(), "tcp", "10.0.0.1:443"); err == nil {
+		t.Fatal("dial succeeded")
+	}
+	c.Close()
+	c.Close() // logs once
+
+	var st appctype.ServiceStatus
+	rs.addStatus(&st)
+	if st.ActiveConns != 0 || st.BytesIn != 5 || st.BytesOut != 5 || st.ConnectErrors != 1 || st.Dials != 0 {
+		t.Errorf("service counts = %+v", st)
+	}
+	if rs.m != getRuleMetrics("dnat/other") {
+		t.Error("services of the same kind don't share metrics")
+	}
+
+	es := buf.entries(t)
+	if len(es) != 1 {
+		t.Fatalf("got %d flow log entries, want 1", len(es))
+	}
+	e := es[0]
+	if e.Service != "dnat/test-tracked" || e.Proto != "tcp" || e.Backend != "10.0.0.1:443" ||
+		e.BytesIn != 5 || e.BytesOut != 5 || !strings.Contains(e.Error, "no route to host") {
+		t.Errorf("flow log entry = %+v", e)
+	}
+}
+
+func TestFlowLogUDP(t *testing.T) {
+	port := udpEchoServer(t)
+	addr := netip.MustParseAddr("100.64.0.1")
+	s := &Server{
+		lookupNetIP: func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+			if host == "down.example.com" {
+				return nil, errors.New("no such host")
+			}
+			return []netip.Addr{netip.MustParseAddr("127.0.0.1")}, nil
+		},
+	}
+	var buf syncBuffer
+	s.SetFlowLog(&buf)
+	s.Configure(&appctype.AppConnectorConfig{
+		DNAT: map[appctype.ConfigID]appctype.DNATConfig{
+			"down": {
+				Addrs: []netip.Addr{addr},
+				To:    []string{"down.example.com"},
+				IP:    []tailcfg.ProtoPortRange{{Proto: int(ipproto.UDP), Ports: tailcfg.PortRange{First: port, Last: port}}},
+			},
+		},
+	})
+
+	h, ok := s.HandleUDPFlow(netip.MustParseAddrPort("100.100.1.1:4000"), netip.AddrPortFrom(addr, port))
+	if !ok {
+		t.Fatal("flow not intercepted")
+	}
+	_, flow := udpFlowConns(t)
+	done := make(chan struct{})
+	go func() {
+		h(flow)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("flow to unreachable backend not dropped")
+	}
+
+	es := buf.entries(t)
+	if len(es) != 1 {
+		t.Fatalf("got %d flow log entries, want 1", len(es))
+	}
+	if e := es[0]; e.Service != "dnat/down" || e.Proto != "udp" || !strings.HasPrefix(e.Backend, "down.example.com:") || e.Error == "" {
+		t.Errorf("flow log entry = %+v", e)
+	}
+	for _, st := range s.Status() {
+		if st.Service == "dnat/down" && st.ConnectErrors != 1 {
+			t.Errorf("connect errors = %d, want 1", st.ConnectErrors)
+		}
+	}
+}
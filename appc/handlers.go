@@ -10,10 +10,11 @@
 	"math/rand"
 	"net"
 	"net/netip"
-	"slices"
+	"net/url"
 
 	"inet.af/tcpproxy"
 	"tailscale.com/net/netutil"
+	"tailscale.com/types/appctype"
 )
 
 type tcpRoundRobinHandler struct {
@@ -21,11 +22,34 @@ type tcpRoundRobinHandler struct {
 	// An entry may be either an IP address or a DNS name.
 	To []string
 
-	// DialContext is used to make the outgoing TCP connection.
+	// DialContext is used to make the outgoing TCP connection or UDP
+	// socket.
 	DialContext func(ctx context.Context, network, address string) (net.Conn, error)
 
 	// ReachableIPs enumerates the IP addresses this handler is reachable on.
 	ReachableIPs []netip.Addr
+
+	// Stats, if non-nil, accounts the handler's connections.
+	Stats *ruleStats
+
+	// Backends, if non-nil, selects the destination of each connection
+	// from To. Otherwise one is chosen at random.
+	Backends *backendPool
+
+	// Sources, if non-nil, restricts which clients may use the handler.
+	Sources *sourceACL
+
+	// ProxyHeader, if non-nil, returns the PROXY protocol header to send to
+	// the destination of each connection.
+	ProxyHeader proxyHeaderFunc
+}
+
+// pickDest returns the destination for a new connection.
+func (h *tcpRoundRobinHandler) pickDest() string {
+	if h.Backends != nil {
+		return h.Backends.pick()
+	}
+	return h.To[rand.Intn(len(h.To))]
 }
 
 // ReachableOn returns the IP addresses this handler is reachable on.
@@ -33,7 +57,8 @@ func (h *tcpRoundRobinHandler) ReachableOn() []netip.Addr {
 	return h.ReachableIPs
 }
 
-func (h *tcpRoundRobinHandler) Handle(c net.Conn) {
+func (h *tcpRoundRobinHandler) Handle(conn net.Conn) {
+	c := h.Stats.trackConn(conn, "tcp")
 	addrPortStr := c.LocalAddr().String()
 	_, port, err := net.SplitHostPort(addrPortStr)
 	if err != nil {
@@ -47,10 +72,10 @@ func (h *tcpRoundRobinHandler) Handle(c net.Conn) {
 		return netutil.NewOneConnListener(c, nil), nil
 	}
 
-	dest := h.To[rand.Intn(len(h.To))]
+	dest := h.pickDest()
 	dial := &tcpproxy.DialProxy{
 		Addr:        fmt.Sprintf("%s:%s", dest, port),
-		DialContext: h.DialContext,
+		DialContext: dialerFor(c, h.DialContext, h.ProxyHeader),
 	}
 
 	p.AddRoute(addrPortStr, dial)
@@ -58,15 +83,41 @@ func (h *tcpRoundRobinHandler) Handle(c net.Conn) {
 }
 
 type tcpSNIHandler struct {
-	// Allowlist enumerates the FQDNs which may be proxied via SNI. An
-	// empty slice means all domains are permitted.
+	// Allowlist enumerates the FQDNs which may be proxied via SNI, or
+	// with a leading ".", the suffixes of subdomains which may be. An
+	// empty slice with no allow Patterns means all domains are permitted.
 	Allowlist []string
 
-	// DialContext is used to make the outgoing TCP connection.
+	// Patterns, if non-nil, are additional patterns of domains which may
+	// or may not be proxied.
+	Patterns *domainPatterns
+
+	// DialContext is used to make the outgoing TCP connection or UDP
+	// socket.
 	DialContext func(ctx context.Context, network, address string) (net.Conn, error)
 
 	// ReachableIPs enumerates the IP addresses this handler is reachable on.
 	ReachableIPs []netip.Addr
+
+	// Stats, if non-nil, accounts the handler's connections.
+	Stats *ruleStats
+
+	// Sources, if non-nil, restricts which clients may use the handler.
+	Sources *sourceACL
+
+	// ProxyHeader, if non-nil, returns the PROXY protocol header to send to
+	// the destination of each connection.
+	ProxyHeader proxyHeaderFunc
+
+	// TerminateTLS, if non-nil, configures the handler to terminate TLS
+	// connections with the certificates of Terminator, rather than pass
+	// them through, and forward their requests to Backend, the parsed
+	// TerminateTLS.Backend. Service names the handler's service in access
+	// logs.
+	TerminateTLS *appctype.TLSTermination
+	Backend      *url.URL
+	Terminator   *tlsTerminator
+	Service      string
 }
 
 // ReachableOn returns the IP addresses this handler is reachable on.
@@ -74,7 +125,8 @@ func (h *tcpSNIHandler) ReachableOn() []netip.Addr {
 	return h.ReachableIPs
 }
 
-func (h *tcpSNIHandler) Handle(c net.Conn) {
+func (h *tcpSNIHandler) Handle(conn net.Conn) {
+	c := h.Stats.trackConn(conn, "tcp")
 	addrPortStr := c.LocalAddr().String()
 	_, port, err := net.SplitHostPort(addrPortStr)
 	if err != nil {
@@ -82,22 +134,23 @@ func (h *tcpSNIHandler) Handle(c net.Conn) {
 		c.Close()
 		return
 	}
+	if h.TerminateTLS != nil {
+		h.terminate(c)
+		return
+	}
 
 	var p tcpproxy.Proxy
 	p.ListenFunc = func(net, laddr string) (net.Listener, error) {
 		return netutil.NewOneConnListener(c, nil), nil
 	}
 	p.AddSNIRouteFunc(addrPortStr, func(ctx context.Context, sniName string) (t tcpproxy.Target, ok bool) {
-		if len(h.Allowlist) > 0 {
-			// TODO(tom): handle subdomains
-			if slices.Index(h.Allowlist, sniName) < 0 {
-				return nil, false
-			}
+		if !domainAllowed(sniName, h.Allowlist, h.Patterns) {
+			return nil, false
 		}
 
 		return &tcpproxy.DialProxy{
 			Addr:        net.JoinHostPort(sniName, port),
-			DialContext: h.DialContext,
+			DialContext: dialerFor(c, h.DialContext, h.ProxyHeader),
 		}, true
 	})
 	p.Start()
@@ -0,0 +1,243 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/appctype"
+	"tailscale.com/types/ipproto"
+)
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 2 * time.Second
+
+	// healthCheckThreshold is how many consecutive checks of a destination
+	// must disagree with its current health to change it.
+	healthCheckThreshold = 2
+)
+
+// backendPool selects the destination of each connection to a DNAT service
+// from its To list, according to their weights and health.
+type backendPool struct {
+	service string
+	to      []string
+	weights []int // parallel to to
+
+	// check and checkPort configure the health checks of the pool, or
+	// check is nil if there are none.
+	check     *appctype.HealthCheck
+	checkPort uint16
+
+	mu     sync.Mutex
+	health []backendHealth // parallel to to
+}
+
+// backendHealth is the health check state of a destination.
+type backendHealth struct {
+	unhealthy bool
+	streak    int   // consecutive checks disagreeing with unhealthy
+	lastErr   error // error of the last failed check
+}
+
+// newBackendPool returns the backend pool of the DNAT service d.
+func newBackendPool(service string, d *appctype.DNATConfig) *backendPool {
+	p := &backendPool{
+		service: service,
+		to:      d.To,
+		health:  make([]backendHealth, len(d.To)),
+	}
+	if len(d.Weights) == len(d.To) {
+		p.weights = d.Weights
+	} else if len(d.Weights) > 0 {
+		log.Printf("appc: %s: ignoring %d weights for %d destinations", service, len(d.Weights), len(d.To))
+	}
+	if hc := d.HealthCheck; hc != nil {
+		port := hc.Port
+		if port == 0 {
+			port = firstTCPPort(d.IP)
+		}
+		if port == 0 {
+			log.Printf("appc: %s: no port to health check", service)
+		} else {
+			p.check, p.checkPort = hc, port
+		}
+	}
+	return p
+}
+
+// firstTCPPort returns the first port of ip that forwards TCP, or zero if
+// there's none.
+func firstTCPPort(ip []tailcfg.ProtoPortRange) uint16 {
+	for _, pr := range ip {
+		if (pr.Proto == 0 || pr.Proto == int(ipproto.TCP)) && pr.Ports.First != 0 {
+			return pr.Ports.First
+		}
+	}
+	return 0
+}
+
+func (p *backendPool) weight(i int) int {
+	if p.weights == nil {
+		return 1
+	}
+	return max(p.weights[i], 0)
+}
+
+// pick returns the destination for a new connection: a random healthy
+// destination of non-zero weight, chosen in proportion to the weights, or
+// failing that, a healthy standby. If every destination is unhealthy, it's
+// chosen from all of them, as there's nothing better to do.
+func (p *backendPool) pick() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, tier := range []func(int) bool{
+		func(i int) bool { return !p.health[i].unhealthy && p.weight(i) > 0 },
+		func(i int) bool { return !p.health[i].unhealthy },
+		func(i int) bool { return true },
+	} {
+		var total int
+		for i := range p.to {
+			if tier(i) {
+				total += max(p.weight(i), 1)
+			}
+		}
+		if total == 0 {
+			continue
+		}
+		n := rand.Intn(total)
+		for i := range p.to {
+			if !tier(i) {
+				continue
+			}
+			if n -= max(p.weight(i), 1); n < 0 {
+				return p.to[i]
+			}
+		}
+	}
+	return p.to[rand.Intn(len(p.to))]
+}
+
+// unhealthy returns the destinations that are out of rotation.
+func (p *backendPool) unhealthy() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var ret []string
+	for i, h := range p.health {
+		if h.unhealthy {
+			ret = append(ret, p.to[i])
+		}
+	}
+	return ret
+}
+
+// record records the result of a health check of the i'th destination,
+// which failed if err is non-nil, and logs any change in its health.
+func (p *backendPool) record(i int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := &p.health[i]
+	if err != nil {
+		h.lastErr = err
+	}
+	if (err != nil) == h.unhealthy {
+		h.streak = 0
+		return
+	}
+	if h.streak++; h.streak < healthCheckThreshold {
+		return
+	}
+	h.unhealthy, h.streak = !h.unhealthy, 0
+	getMetrics().healthTransitions.Add(1)
+	if h.unhealthy {
+		log.Printf("appc: %s: destination %s is unhealthy, taking it out of rotation: %v", p.service, p.to[i], err)
+	} else {
+		log.Printf("appc: %s: destination %s is healthy again", p.service, p.to[i])
+	}
+}
+
+// runHealthChecks checks each destination of the pool every interval
+// until ctx is done, dialing with dial.
+func (p *backendPool) runHealthChecks(ctx context.Context, dial dialFunc) {
+	interval := p.check.Interval
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		var wg sync.WaitGroup
+		for i := range p.to {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				p.record(i, p.checkOne(ctx, dial, p.to[i]))
+			}(i)
+		}
+		wg.Wait()
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// checkOne health checks the destination dest.
+func (p *backendPool) checkOne(ctx context.Context, dial dialFunc, dest string) error {
+	timeout := p.check.Timeout
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	addr := net.JoinHostPort(dest, strconv.Itoa(int(p.checkPort)))
+
+	switch p.check.Kind {
+	case "", appctype.HealthCheckTCP:
+		c, err := dial(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return c.Close()
+	case appctype.HealthCheckHTTP:
+		path := p.check.Path
+		if path == "" {
+			path = "/"
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", "http://"+addr+path, nil)
+		if err != nil {
+			return err
+		}
+		hc := &http.Client{
+			Transport: &http.Transport{
+				DialContext:       dial,
+				DisableKeepAlives: true,
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		res, err := hc.Do(req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode >= 500 {
+			return fmt.Errorf("HTTP status %s", res.Status)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown health check kind %q", p.check.Kind)
+}
@@ -0,0 +1,174 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/appctype"
+)
+
+func TestBackendPoolPick(t *testing.T) {
+	p := newBackendPool("dnat/test", &appctype.DNATConfig{
+		To:      []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		Weights: []int{3, 1, 0},
+	})
+	picks := func() map[string]int {
+		ret := map[string]int{}
+		for i := 0; i < 1000; i++ {
+			ret[p.pick()]++
+		}
+		return ret
+	}
+	failed := errors.New("connection refused")
+	markUnhealthy := func(i int) {
+		for n := 0; n < healthCheckThreshold; n++ {
+			p.record(i, failed)
+		}
+	}
+
+	got := picks()
+	if got["10.0.0.3"] != 0 {
+		t.Errorf("standby picked %d times while others are healthy", got["10.0.0.3"])
+	}
+	if got["10.0.0.1"] < 2*got["10.0.0.2"] {
+		t.Errorf("picks %v not in proportion to weights 3:1", got)
+	}
+
+	markUnhealthy(0)
+	if got := picks(); got["10.0.0.1"] != 0 || got["10.0.0.2"] != 1000 {
+		t.Errorf("picks with 10.0.0.1 unhealthy = %v", got)
+	}
+	markUnhealthy(1)
+	if got := picks(); got["10.0.0.3"] != 1000 {
+		t.Errorf("picks with only the standby healthy = %v", got)
+	}
+	markUnhealthy(2)
+	if got := picks(); len(got) != 3 {
+		t.Errorf("picks with none healthy = %v, want all destinations", got)
+	}
+	if got, want := p.unhealthy(), []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}; !slices.Equal(got, want) {
+		t.Errorf("unhealthy = %q, want %q", got, want)
+	}
+
+	// A single success doesn't bring a destination back, but a streak does.
+	p.record(0, nil)
+	p.record(0, failed)
+	p.record(0, nil)
+	if got := p.unhealthy(); len(got) != 3 {
+		t.Errorf("unhealthy = %q after an interrupted streak", got)
+	}
+	p.record(0, nil)
+	if got, want := p.unhealthy(), []string{"10.0.0.2", "10.0.0.3"}; !slices.Equal(got, want) {
+		t.Errorf("unhealthy = %q, want %q", got, want)
+	}
+}
+
+func TestBackendPoolBadWeights(t *testing.T) {
+	p := newBackendPool("dnat/test", &appctype.DNATConfig{
+		To:      []string{"10.0.0.1", "10.0.0.2"},
+		Weights: []int{1},
+	})
+	if p.weights != nil {
+		t.Errorf("weights = %v, want ignored", p.weights)
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	up, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer up.Close()
+	go func() {
+		for {
+			c, err := up.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	port := uint16(up.Addr().(*net.TCPAddr).Port)
+
+	// An HTTP server which is up, but failing.
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			t.Errorf("health check of %q, want /healthz", r.URL.Path)
+		}
+		http.Error(w, "oops", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	httpHost, httpPort, _ := net.SplitHostPort(failing.Listener.Addr().String())
+	httpPortNum, _ := strconv.Atoi(httpPort)
+
+	s := &Server{}
+	defer s.Close()
+	tcp := []tailcfg.ProtoPortRange{{Ports: tailcfg.PortRange{First: port, Last: port}}}
+	s.Configure(&appctype.AppConnectorConfig{
+		DNAT: map[appctype.ConfigID]appctype.DNATConfig{
+			"tcp": {
+				Addrs:       []netip.Addr{netip.MustParseAddr("100.64.0.1")},
+				To:          []string{"127.0.0.1", "127.0.0.2"}, // nothing listens on the latter
+				IP:          tcp,
+				HealthCheck: &appctype.HealthCheck{Interval: 10 * time.Millisecond},
+			},
+			"http": {
+				Addrs: []netip.Addr{netip.MustParseAddr("100.64.0.2")},
+				To:    []string{httpHost},
+				IP:    tcp,
+				HealthCheck: &appctype.HealthCheck{
+					Kind:     appctype.HealthCheckHTTP,
+					Port:     uint16(httpPortNum),
+					Path:     "/healthz",
+					Interval: 10 * time.Millisecond,
+				},
+			},
+			"unchecked": {
+				Addrs: []netip.Addr{netip.MustParseAddr("100.64.0.3")},
+				To:    []string{"127.0.0.2"},
+				IP:    tcp,
+			},
+		},
+	})
+
+	want := map[string][]string{
+		"dnat/tcp":       {"127.0.0.2"},
+		"dnat/http":      {httpHost},
+		"dnat/unchecked": nil,
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		got := map[string][]string{}
+		for _, st := range s.Status() {
+			got[st.Service] = st.Unhealthy
+		}
+		ok := true
+		for svc, w := range want {
+			ok = ok && slices.Equal(got[svc], w)
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unhealthy destinations = %q, want %q", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Reconfiguring stops the old health checks and forgets their results.
+	s.Configure(&appctype.AppConnectorConfig{})
+	if s.stopHealthChecks != nil || s.backendPools != nil {
+		t.Error("health checks still running after reconfiguration")
+	}
+}
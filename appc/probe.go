@@ -0,0 +1,226 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/appctype"
+	"tailscale.com/types/ipproto"
+)
+
+const (
+	// probeTimeout is how long a probe of a service may take.
+	probeTimeout = 10 * time.Second
+
+	// probeSettleTime is how long a DNAT probe waits after connecting for
+	// the upstream destination to speak. A silent connection can't be told
+	// apart from an app connector stuck dialing upstream, so upstreams which
+	// don't speak first fail the probe.
+	probeSettleTime = 2 * time.Second
+)
+
+// SetProbeDialer sets the function used to connect to the app connector's
+// own service addresses from the tailnet side when probing them. tsnet sets
+// one when the app connector is registered with it. Passing nil disables
+// probes.
+func (s *Server) SetProbeDialer(dial func(ctx context.Context, network, address string) (net.Conn, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.probeDial = dial
+}
+
+// RunProbes probes each of the app connector's services every interval
+// until ctx is done. See Probe.
+func (s *Server) RunProbes(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		s.Probe(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// serviceProbe is a probe to make of a service.
+type serviceProbe struct {
+	service string
+	addr    netip.AddrPort
+	domain  string // for SNI proxies, the domain to request
+}
+
+// Probe probes each of the app connector's TCP services end to end,
+// connecting to one of its addresses with the dialer set by SetProbeDialer
+// and checking the connection is forwarded to an upstream destination. The
+// results are included in Status until the app connector is reconfigured.
+//
+// Probe does nothing if no dialer is set.
+func (s *Server) Probe(ctx context.Context) {
+	s.mu.RLock()
+	dial, cfg, gen := s.probeDial, s.effectiveConfig, s.configGen
+	s.mu.RUnlock()
+	if dial == nil || cfg == nil {
+		return
+	}
+
+	var probes []serviceProbe
+	for cID, d := range cfg.DNAT {
+		if ap, ok := probeAddr(d.Addrs, d.IP); ok {
+			probes = append(probes, serviceProbe{service: "dnat/" + string(cID), addr: ap})
+		}
+	}
+	for cID, d := range cfg.SNIProxy {
+		ap, ok := probeAddr(d.Addrs, d.IP)
+		if !ok {
+			continue
+		}
+		// Only literal domains can be probed; a suffix or pattern
+		// doesn't name a server.
+		var domain string
+		for _, dom := range d.AllowedDomains {
+			if !strings.HasPrefix(dom, ".") {
+				domain = dom
+				break
+			}
+		}
+		if domain == "" {
+			continue
+		}
+		probes = append(probes, serviceProbe{service: "sni/" + string(cID), addr: ap, domain: domain})
+	}
+
+	m := getMetrics()
+	results := make(map[string]*appctype.ProbeResult, len(probes))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, p := range probes {
+		wg.Add(1)
+		go func(p serviceProbe) {
+			defer wg.Done()
+			res := runProbe(ctx, dial, p)
+			if !res.Healthy() {
+				m.probeFailures.Add(1)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			results[p.service] = res
+		}(p)
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.configGen != gen {
+		return // reconfigured while probing; the results are stale
+	}
+	s.probeResults = results
+}
+
+// probeAddr returns the address and port to probe of a service listening on
+// addrs and ip, which is the first address and TCP port, if any.
+func probeAddr(addrs []netip.Addr, ip []tailcfg.ProtoPortRange) (netip.AddrPort, bool) {
+	if len(addrs) == 0 {
+		return netip.AddrPort{}, false
+	}
+	for _, pr := range ip {
+		if pr.Proto != 0 && pr.Proto != int(ipproto.TCP) {
+			continue
+		}
+		if pr.Ports.First == 0 {
+			continue // no port to pick from a wildcard
+		}
+		return netip.AddrPortFrom(addrs[0], pr.Ports.First), true
+	}
+	return netip.AddrPort{}, false
+}
+
+// runProbe makes the probe p, dialing with dial.
+func runProbe(ctx context.Context, dial dialFunc, p serviceProbe) *appctype.ProbeResult {
+	res := &appctype.ProbeResult{
+		Time:   time.Now(),
+		Target: p.addr.String(),
+	}
+	if p.domain != "" {
+		res.Target += " " + p.domain
+	}
+	err := probeService(ctx, dial, p)
+	res.Latency = time.Since(res.Time)
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
+
+var (
+	errProbeDropped = errors.New("connection dropped by app connector; upstream unreachable?")
+	errProbeSilent  = errors.New("no response from upstream")
+)
+
+func probeService(ctx context.Context, dial dialFunc, p serviceProbe) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	c, err := dial(ctx, "tcp", p.addr.String())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+
+	if p.domain != "" {
+		// The handshake can only complete if the app connector
+		// forwarded the ClientHello to the upstream server. Its
+		// certificate is the upstream's business, not ours.
+		tc := tls.Client(c, &tls.Config{
+			ServerName:         p.domain,
+			InsecureSkipVerify: true,
+		})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			if errors.Is(err, io.EOF) {
+				return errProbeDropped
+			}
+			return err
+		}
+		return nil
+	}
+
+	// The app connector accepts the connection before dialing upstream,
+	// and drops it if that fails. Only hearing from the upstream counts
+	// as success.
+	c.SetReadDeadline(time.Now().Add(probeSettleTime))
+	var b [1]byte
+	if _, err := c.Read(b[:]); err != nil {
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			return errProbeSilent
+		}
+		if errors.Is(err, io.EOF) {
+			return errProbeDropped
+		}
+		return err
+	}
+	return nil
+}
+
+// addProbeResultsLocked attaches the results of the most recent probes to
+// status. s.mu must be held.
+func (s *Server) addProbeResultsLocked(status []appctype.ServiceStatus) []appctype.ServiceStatus {
+	for i := range status {
+		status[i].Probe = s.probeResults[status[i].Service]
+	}
+	return status
+}
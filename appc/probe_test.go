@@ -0,0 +1,158 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"sync"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/appctype"
+	"tailscale.com/types/ipproto"
+)
+
+func TestProbe(t *testing.T) {
+	// An app connector forwarding to an upstream which greets clients.
+	healthy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer healthy.Close()
+	go func() {
+		for {
+			c, err := healthy.Accept()
+			if err != nil {
+				return
+			}
+			c.Write([]byte("hello\n"))
+			c.Close()
+		}
+	}()
+
+	// An app connector which can't reach its upstream, and so drops
+	// connections.
+	broken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broken.Close()
+	go func() {
+		for {
+			c, err := broken.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	// An app connector forwarding to an upstream which never speaks.
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			c, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	// An SNI proxy forwarding to a TLS server.
+	tlsSrv := httptest.NewTLSServer(nil)
+	defer tlsSrv.Close()
+
+	// Stand in for the tailnet: connections to service addresses go to
+	// the listeners above.
+	routes := map[netip.Addr]string{
+		netip.MustParseAddr("100.64.0.1"): healthy.Addr().String(),
+		netip.MustParseAddr("100.64.0.2"): broken.Addr().String(),
+		netip.MustParseAddr("100.64.0.3"): tlsSrv.Listener.Addr().String(),
+		netip.MustParseAddr("100.64.0.5"): silent.Addr().String(),
+	}
+	var dialed []string
+	var mu sync.Mutex
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		mu.Lock()
+		dialed = append(dialed, address)
+		mu.Unlock()
+		dst, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return nil, err
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, network, routes[dst.Addr()])
+	}
+
+	ports := func(ps ...tailcfg.ProtoPortRange) []tailcfg.ProtoPortRange { return ps }
+	tcp443 := tailcfg.ProtoPortRange{Proto: int(ipproto.TCP), Ports: tailcfg.PortRange{First: 443, Last: 443}}
+	udp443 := tailcfg.ProtoPortRange{Proto: int(ipproto.UDP), Ports: tailcfg.PortRange{First: 443, Last: 443}}
+	s := &Server{}
+	s.Configure(&appctype.AppConnectorConfig{
+		DNAT: map[appctype.ConfigID]appctype.DNATConfig{
+			"healthy": {Addrs: []netip.Addr{netip.MustParseAddr("100.64.0.1")}, To: []string{"10.0.0.1"}, IP: ports(udp443, tcp443)},
+			"broken":  {Addrs: []netip.Addr{netip.MustParseAddr("100.64.0.2")}, To: []string{"10.0.0.2"}, IP: ports(tcp443)},
+			"silent":  {Addrs: []netip.Addr{netip.MustParseAddr("100.64.0.5")}, To: []string{"10.0.0.5"}, IP: ports(tcp443)},
+			"udp":     {Addrs: []netip.Addr{netip.MustParseAddr("100.64.0.9")}, To: []string{"10.0.0.9"}, IP: ports(udp443)},
+		},
+		SNIProxy: map[appctype.ConfigID]appctype.SNIProxyConfig{
+			"web":      {Addrs: []netip.Addr{netip.MustParseAddr("100.64.0.3")}, AllowedDomains: []string{".example.com", "www.example.com"}, IP: ports(tcp443)},
+			"suffixes": {Addrs: []netip.Addr{netip.MustParseAddr("100.64.0.4")}, AllowedDomains: []string{".example.com"}, IP: ports(tcp443)},
+		},
+	})
+
+	// Without a dialer, nothing is probed.
+	s.Probe(context.Background())
+	for _, st := range s.Status() {
+		if st.Probe != nil {
+			t.Fatalf("%s probed without a dialer", st.Service)
+		}
+	}
+
+	s.SetProbeDialer(dial)
+	s.Probe(context.Background())
+	got := map[string]*appctype.ProbeResult{}
+	for _, st := range s.Status() {
+		got[st.Service] = st.Probe
+	}
+	if r := got["dnat/healthy"]; !r.Healthy() || r.Target != "100.64.0.1:443" {
+		t.Errorf("dnat/healthy: %+v", r)
+	}
+	if r := got["dnat/broken"]; r == nil || r.Healthy() {
+		t.Errorf("dnat/broken: %v, want failure", r)
+	}
+	if r := got["dnat/silent"]; r == nil || r.Healthy() {
+		t.Errorf("dnat/silent: %v, want failure", r)
+	}
+	if r := got["sni/web"]; !r.Healthy() || r.Target != "100.64.0.3:443 www.example.com" {
+		t.Errorf("sni/web: %+v", r)
+	}
+	for _, svc := range []string{"dnat/udp", "sni/suffixes"} {
+		if r := got[svc]; r != nil {
+			t.Errorf("%s: %v, want unprobed", svc, r)
+		}
+	}
+	slices.Sort(dialed)
+	if want := []string{"100.64.0.1:443", "100.64.0.2:443", "100.64.0.3:443", "100.64.0.5:443"}; !slices.Equal(dialed, want) {
+		t.Errorf("dialed %q, want %q", dialed, want)
+	}
+
+	// Reconfiguring discards the results.
+	cfg, _ := s.Config()
+	s.Configure(cfg)
+	for _, st := range s.Status() {
+		if st.Probe != nil {
+			t.Errorf("%s: stale probe result after reconfiguration", st.Service)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/appctype"
+)
+
+// proxyProtoSig is the signature which starts a PROXY protocol version 2
+// header.
+const proxyProtoSig = "\r\n\r\n\x00\r\nQUIT\n"
+
+// proxyHeaderFunc returns the PROXY protocol header to send to a destination
+// for a TCP connection from src to dst.
+type proxyHeaderFunc func(src, dst netip.AddrPort) []byte
+
+// SetWhoIs sets the function with which the app connector looks up the
+// identity of clients to send to destinations in PROXY protocol headers,
+// typically the WhoIs method of an ipnlocal.LocalBackend. Passing nil omits
+// the identity of clients from the headers.
+func (s *Server) SetWhoIs(whoIs func(netip.AddrPort) (tailcfg.NodeView, tailcfg.UserProfile, bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.whoIs = whoIs
+}
+
+// proxyHeader returns the PROXY protocol version 2 header for a TCP
+// connection from src to dst, carrying the identity of src if it's known.
+func (s *Server) proxyHeader(src, dst netip.AddrPort) []byte {
+	var tlvs []proxyTLV
+	if n, u, ok := s.clientIdentity(src); ok {
+		tlvs = append(tlvs,
+			proxyTLV{appctype.ProxyTLVNodeName, n.Name()},
+			proxyTLV{appctype.ProxyTLVNodeStableID, string(n.StableID())},
+			proxyTLV{appctype.ProxyTLVUserLogin, u.LoginName},
+		)
+	}
+	return appendProxyHeader(nil, src, dst, tlvs)
+}
+
+// proxyHeaderFor returns the header function of a service whose
+// configuration asks for PROXY protocol version, or nil if it asks for none
+// or an unsupported version.
+func proxyHeaderFor(service string, version int, proxyHeader proxyHeaderFunc) proxyHeaderFunc {
+	switch version {
+	case 0:
+		return nil
+	case 2:
+		return proxyHeader
+	}
+	log.Printf("appc: %s: unsupported PROXY protocol version %d; not sending headers", service, version)
+	return nil
+}
+
+// proxyTLV is a TLV of a PROXY protocol version 2 header.
+type proxyTLV struct {
+	typ   appctype.ProxyTLV
+	value string
+}
+
+// appendProxyHeader appends to b the PROXY protocol version 2 header of a
+// TCP connection from src to dst with the given TLVs. If src and dst are of
+// different address families, both are sent as IPv6 addresses.
+func appendProxyHeader(b []byte, src, dst netip.AddrPort, tlvs []proxyTLV) []byte {
+	srcIP, dstIP := src.Addr().Unmap(), dst.Addr().Unmap()
+	fam := byte(0x11) // TCP over IPv4
+	addrLen := 12
+	if !srcIP.Is4() || !dstIP.Is4() {
+		fam = 0x21 // TCP over IPv6
+		addrLen = 36
+	}
+	n := addrLen
+	for _, t := range tlvs {
+		n += 3 + len(t.value)
+	}
+
+	b = append(b, proxyProtoSig...)
+	b = append(b, 0x21, fam) // version 2, PROXY command
+	b = binary.BigEndian.AppendUint16(b, uint16(n))
+	if fam == 0x11 {
+		s4, d4 := srcIP.As4(), dstIP.As4()
+		b = append(b, s4[:]...)
+		b = append(b, d4[:]...)
+	} else {
+		s16, d16 := srcIP.As16(), dstIP.As16()
+		b = append(b, s16[:]...)
+		b = append(b, d16[:]...)
+	}
+	b = binary.BigEndian.AppendUint16(b, src.Port())
+	b = binary.BigEndian.AppendUint16(b, dst.Port())
+	for _, t := range tlvs {
+		b = append(b, byte(t.typ))
+		b = binary.BigEndian.AppendUint16(b, uint16(len(t.value)))
+		b = append(b, t.value...)
+	}
+	return b
+}
+
+// withProxyHeader returns a dialFunc which dials with dial, then sends hdr
+// before returning the connection.
+func withProxyHeader(dial dialFunc, hdr []byte) dialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		c, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := c.Write(hdr); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("sending PROXY header to %s: %w", address, err)
+		}
+		return c, nil
+	}
+}
+
+// addrPortOf returns the address and port of a, which should be a TCP or
+// UDP address.
+func addrPortOf(a net.Addr) netip.AddrPort {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		return a.AddrPort()
+	case *net.UDPAddr:
+		return a.AddrPort()
+	}
+	ap, _ := netip.ParseAddrPort(a.String())
+	return ap
+}
+
+// dialerFor returns the dialFunc with which a handler dials the destination
+// of its connection c: dial, sending the header of proxyHeader first if it's
+// non-nil, with the dial recorded in the stats of c.
+func dialerFor(c *trackedConn, dial dialFunc, proxyHeader proxyHeaderFunc) dialFunc {
+	if proxyHeader != nil {
+		dial = withProxyHeader(dial, proxyHeader(addrPortOf(c.RemoteAddr()), addrPortOf(c.LocalAddr())))
+	}
+	return c.dialer(dial)
+}
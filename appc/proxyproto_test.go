@@ -0,0 +1,125 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/appctype"
+)
+
+func TestAppendProxyHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		src, dst netip.AddrPort
+		tlvs     []proxyTLV
+		want     string // hex
+	}{
+		{
+			name: "ipv4",
+			src:  netip.MustParseAddrPort("100.100.1.1:40000"),
+			dst:  netip.MustParseAddrPort("100.64.0.1:443"),
+			want: "0d0a0d0a000d0a515549540a" + "2111" + "000c" +
+				"64640101" + "64400001" + "9c40" + "01bb",
+		},
+		{
+			name: "ipv6",
+			src:  netip.MustParseAddrPort("[fd7a:115c:a1e0::2]:40000"),
+			dst:  netip.MustParseAddrPort("[fd7a:115c:a1e0::1]:443"),
+			want: "0d0a0d0a000d0a515549540a" + "2121" + "0024" +
+				"fd7a115ca1e000000000000000000002" + "fd7a115ca1e000000000000000000001" + "9c40" + "01bb",
+		},
+		{
+			name: "mixed_with_tlvs",
+			src:  netip.MustParseAddrPort("100.100.1.1:40000"),
+			dst:  netip.MustParseAddrPort("[fd7a:115c:a1e0::1]:443"),
+			tlvs: []proxyTLV{{appctype.ProxyTLVUserLogin, "bob"}},
+			want: "0d0a0d0a000d0a515549540a" + "2121" + "002a" +
+				"00000000000000000000ffff64640101" + "fd7a115ca1e000000000000000000001" + "9c40" + "01bb" +
+				"e20003626f62",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := hex.EncodeToString(appendProxyHeader(nil, tt.src, tt.dst, tt.tlvs))
+			if got != tt.want {
+				t.Errorf("header = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// addrConn is a net.Conn with fixed addresses.
+type addrConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c addrConn) LocalAddr() net.Addr  { return c.local }
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestProxyHeaderDial(t *testing.T) {
+	src := netip.MustParseAddrPort("100.100.1.1:40000")
+	dst := netip.MustParseAddrPort("100.64.0.1:443")
+
+	s := new(Server)
+	s.SetWhoIs(func(ipp netip.AddrPort) (tailcfg.NodeView, tailcfg.UserProfile, bool) {
+		if ipp != src {
+			t.Errorf("WhoIs(%v), want %v", ipp, src)
+		}
+		n := &tailcfg.Node{Name: "laptop.example.ts.net.", StableID: "nStable"}
+		return n.View(), tailcfg.UserProfile{LoginName: "alice@example.com"}, true
+	})
+
+	client, _ := net.Pipe()
+	defer client.Close()
+	c := (*ruleStats)(nil).trackConn(addrConn{
+		Conn:   client,
+		local:  net.TCPAddrFromAddrPort(dst),
+		remote: net.TCPAddrFromAddrPort(src),
+	}, "tcp")
+
+	backend, backendPeer := net.Pipe()
+	defer backendPeer.Close()
+	dial := dialerFor(c, func(ctx context.Context, network, address string) (net.Conn, error) {
+		return backend, nil
+	}, proxyHeaderFor("dnat/test", 2, s.proxyHeader))
+
+	want := appendProxyHeader(nil, src, dst, []proxyTLV{
+		{appctype.ProxyTLVNodeName, "laptop.example.ts.net."},
+		{appctype.ProxyTLVNodeStableID, "nStable"},
+		{appctype.ProxyTLVUserLogin, "alice@example.com"},
+	})
+	got := make(chan []byte, 1)
+	go func() {
+		b := make([]byte, len(want))
+		io.ReadFull(backendPeer, b)
+		got <- b
+	}()
+	conn, err := dial(context.Background(), "tcp", "10.0.0.1:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if b := <-got; !bytes.Equal(b, want) {
+		t.Errorf("backend got header %x, want %x", b, want)
+	}
+
+	// Without a WhoIs function, the header carries only addresses.
+	s.SetWhoIs(nil)
+	if got, want := s.proxyHeader(src, dst), appendProxyHeader(nil, src, dst, nil); !bytes.Equal(got, want) {
+		t.Errorf("header without WhoIs = %x, want %x", got, want)
+	}
+
+	if proxyHeaderFor("dnat/test", 1, s.proxyHeader) != nil {
+		t.Error("header func for unsupported PROXY protocol version 1")
+	}
+}
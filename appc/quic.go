@@ -0,0 +1,319 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/hkdf"
+)
+
+// This file implements just enough of QUIC (RFC 9000 and RFC 9001) to read
+// the TLS ClientHello in a client's Initial packets, whose protection keys
+// are derived from public values in the packets themselves.
+
+// quicVersion1 is the version number of QUIC version 1, the only version
+// whose Initial packets we understand.
+const quicVersion1 = 0x00000001
+
+// quicV1InitialSalt is the salt used to derive QUIC version 1 Initial keys,
+// from RFC 9001, section 5.2.
+var quicV1InitialSalt = []byte{
+	0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17,
+	0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a,
+}
+
+var errNotQUICInitial = errors.New("not a QUIC v1 Initial packet")
+
+// quicInitialKeys are the client's Initial packet protection keys for a
+// connection.
+type quicInitialKeys struct {
+	aead cipher.AEAD
+	iv   []byte
+	hp   cipher.Block
+}
+
+// hkdfExpandLabel implements HKDF-Expand-Label from RFC 8446, section 7.1,
+// with an empty context.
+func hkdfExpandLabel(secret []byte, label string, length int) []byte {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(length))
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes([]byte("tls13 " + label))
+	})
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {})
+	out := make([]byte, length)
+	if _, err := hkdf.Expand(sha256.New, secret, b.BytesOrPanic()).Read(out); err != nil {
+		panic(err) // can't happen for these lengths
+	}
+	return out
+}
+
+// newQUICInitialKeys derives the client Initial keys for a connection whose
+// client chose the destination connection ID dcid.
+func newQUICInitialKeys(dcid []byte) (*quicInitialKeys, error) {
+	initial := hkdf.Extract(sha256.New, dcid, quicV1InitialSalt)
+	client := hkdfExpandLabel(initial, "client in", sha256.Size)
+	block, err := aes.NewCipher(hkdfExpandLabel(client, "quic key", 16))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := aes.NewCipher(hkdfExpandLabel(client, "quic hp", 16))
+	if err != nil {
+		return nil, err
+	}
+	return &quicInitialKeys{
+		aead: aead,
+		iv:   hkdfExpandLabel(client, "quic iv", aead.NonceSize()),
+		hp:   hp,
+	}, nil
+}
+
+// readQUICVarint reads a QUIC variable-length integer from s.
+func readQUICVarint(s *cryptobyte.String) (uint64, bool) {
+	if len(*s) == 0 {
+		return 0, false
+	}
+	n := 1 << ((*s)[0] >> 6)
+	var b []byte
+	if !s.ReadBytes(&b, n) {
+		return 0, false
+	}
+	v := uint64(b[0] & 0x3f)
+	for _, c := range b[1:] {
+		v = v<<8 | uint64(c)
+	}
+	return v, true
+}
+
+// openQUICInitial removes the protection from pkt, the first QUIC packet
+// in a UDP datagram, if it's a client's QUIC v1 Initial packet, and returns
+// its destination connection ID and decrypted payload.
+func openQUICInitial(pkt []byte) (dcid, payload []byte, err error) {
+	s := cryptobyte.String(pkt)
+	var first uint8
+	var version uint32
+	var scid, token []byte
+	if !s.ReadUint8(&first) || first&0xc0 != 0xc0 || first&0x30 != 0 ||
+		!s.ReadUint32(&version) || version != quicVersion1 ||
+		!s.ReadUint8LengthPrefixed((*cryptobyte.String)(&dcid)) ||
+		!s.ReadUint8LengthPrefixed((*cryptobyte.String)(&scid)) {
+		return nil, nil, errNotQUICInitial
+	}
+	tokenLen, ok := readQUICVarint(&s)
+	if !ok || tokenLen > uint64(len(s)) || !s.ReadBytes(&token, int(tokenLen)) {
+		return nil, nil, errNotQUICInitial
+	}
+	length, ok := readQUICVarint(&s)
+	if !ok || uint64(len(s)) < length {
+		return nil, nil, errNotQUICInitial
+	}
+	pnOffset := len(pkt) - len(s)
+	end := pnOffset + int(length)
+	// The header protection sample starts 4 bytes after the start of the
+	// packet number, as if it were 4 bytes long.
+	if end < pnOffset+4+16 {
+		return nil, nil, errNotQUICInitial
+	}
+
+	keys, err := newQUICInitialKeys(dcid)
+	if err != nil {
+		return nil, nil, err
+	}
+	mask := make([]byte, 16)
+	keys.hp.Encrypt(mask, pkt[pnOffset+4:pnOffset+4+16])
+
+	// Unprotect a copy of the header, which is the AEAD's additional data.
+	first ^= mask[0] & 0x0f
+	pnLen := int(first&0x03) + 1
+	header := make([]byte, pnOffset+pnLen)
+	copy(header, pkt)
+	header[0] = first
+	var pn uint64
+	for i := 0; i < pnLen; i++ {
+		header[pnOffset+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(header[pnOffset+i])
+	}
+
+	// Clients number their first Initial packets from zero, so the
+	// truncated packet number is the full packet number.
+	nonce := make([]byte, len(keys.iv))
+	copy(nonce, keys.iv)
+	var pnBytes [8]byte
+	binary.BigEndian.PutUint64(pnBytes[:], pn)
+	for i := range pnBytes {
+		nonce[len(nonce)-8+i] ^= pnBytes[i]
+	}
+	payload, err = keys.aead.Open(nil, nonce, pkt[pnOffset+pnLen:end], header)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decrypting QUIC Initial packet: %w", err)
+	}
+	return dcid, payload, nil
+}
+
+// quicCryptoStream reassembles the CRYPTO frames of a client's Initial
+// packets into the start of its TLS handshake.
+type quicCryptoStream struct {
+	dcid   []byte // destination connection ID of the first packet
+	data   []byte // contiguous stream data from offset 0
+	chunks map[uint64][]byte
+}
+
+// maxQUICCryptoStream is the most ClientHello data we're willing to buffer.
+const maxQUICCryptoStream = 16 << 10
+
+// addPacket adds the CRYPTO frames of the Initial packet at the start of
+// datagram pkt to the stream.
+func (cs *quicCryptoStream) addPacket(pkt []byte) error {
+	dcid, payload, err := openQUICInitial(pkt)
+	if err != nil {
+		return err
+	}
+	if cs.dcid == nil {
+		cs.dcid = dcid
+	} else if string(dcid) != string(cs.dcid) {
+		return errors.New("QUIC Initial packets with different connection IDs")
+	}
+
+	s := cryptobyte.String(payload)
+	for len(s) > 0 {
+		typ, ok := readQUICVarint(&s)
+		if !ok {
+			return errors.New("truncated QUIC frame")
+		}
+		switch typ {
+		case 0x00, 0x01: // PADDING, PING
+		case 0x02, 0x03: // ACK, ACK with ECN counts
+			var largest, delay, count, first uint64
+			for _, v := range []*uint64{&largest, &delay, &count, &first} {
+				if *v, ok = readQUICVarint(&s); !ok {
+					return errors.New("truncated QUIC ACK frame")
+				}
+			}
+			n := 2 * count
+			if typ == 0x03 {
+				n += 3
+			}
+			for i := uint64(0); i < n; i++ {
+				if _, ok := readQUICVarint(&s); !ok {
+					return errors.New("truncated QUIC ACK frame")
+				}
+			}
+		case 0x06: // CRYPTO
+			off, ok1 := readQUICVarint(&s)
+			n, ok2 := readQUICVarint(&s)
+			var data []byte
+			if !ok1 || !ok2 || n > uint64(len(s)) || !s.ReadBytes(&data, int(n)) {
+				return errors.New("truncated QUIC CRYPTO frame")
+			}
+			if off+n > maxQUICCryptoStream {
+				return errors.New("QUIC ClientHello too large")
+			}
+			if cs.chunks == nil {
+				cs.chunks = make(map[uint64][]byte)
+			}
+			cs.chunks[off] = data
+		case 0x1c: // CONNECTION_CLOSE
+			return errors.New("QUIC connection closed by client")
+		default:
+			return fmt.Errorf("unexpected QUIC frame type %#x in Initial packet", typ)
+		}
+	}
+
+	// Append any chunks that are now contiguous, dropping duplicates.
+	for {
+		progressed := false
+		for off, data := range cs.chunks {
+			have := uint64(len(cs.data))
+			switch end := off + uint64(len(data)); {
+			case end <= have:
+				delete(cs.chunks, off)
+			case off <= have:
+				cs.data = append(cs.data, data[have-off:]...)
+				delete(cs.chunks, off)
+				progressed = true
+			}
+		}
+		if !progressed {
+			return nil
+		}
+	}
+}
+
+// errIncompleteClientHello is returned by serverName when more Initial
+// packets are needed to read the ClientHello.
+var errIncompleteClientHello = errors.New("incomplete ClientHello")
+
+// serverName returns the server name in the ClientHello of the stream, or
+// errIncompleteClientHello if the stream doesn't yet hold all of it.
+func (cs *quicCryptoStream) serverName() (string, error) {
+	s := cryptobyte.String(cs.data)
+	var typ uint8
+	var length uint32
+	if !s.ReadUint8(&typ) || !s.ReadUint24(&length) {
+		return "", errIncompleteClientHello
+	}
+	if typ != 1 { // client_hello
+		return "", fmt.Errorf("unexpected TLS handshake message type %d", typ)
+	}
+	var hello cryptobyte.String
+	if !s.ReadBytes((*[]byte)(&hello), int(length)) {
+		return "", errIncompleteClientHello
+	}
+	return clientHelloServerName(hello)
+}
+
+// clientHelloServerName returns the server name in the server_name
+// extension of the body of a TLS ClientHello message, or the empty string if
+// it has none.
+func clientHelloServerName(s cryptobyte.String) (string, error) {
+	var sessionID, ciphers, compression, exts cryptobyte.String
+	if !s.Skip(2+32) || // legacy_version, random
+		!s.ReadUint8LengthPrefixed(&sessionID) ||
+		!s.ReadUint16LengthPrefixed(&ciphers) ||
+		!s.ReadUint8LengthPrefixed(&compression) {
+		return "", errors.New("malformed ClientHello")
+	}
+	if s.Empty() {
+		return "", nil // no extensions
+	}
+	if !s.ReadUint16LengthPrefixed(&exts) {
+		return "", errors.New("malformed ClientHello extensions")
+	}
+	for !exts.Empty() {
+		var typ uint16
+		var ext cryptobyte.String
+		if !exts.ReadUint16(&typ) || !exts.ReadUint16LengthPrefixed(&ext) {
+			return "", errors.New("malformed ClientHello extension")
+		}
+		if typ != 0 { // server_name
+			continue
+		}
+		var names cryptobyte.String
+		if !ext.ReadUint16LengthPrefixed(&names) {
+			return "", errors.New("malformed server_name extension")
+		}
+		for !names.Empty() {
+			var nameType uint8
+			var name cryptobyte.String
+			if !names.ReadUint8(&nameType) || !names.ReadUint16LengthPrefixed(&name) {
+				return "", errors.New("malformed server_name extension")
+			}
+			if nameType == 0 { // host_name
+				return string(name), nil
+			}
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,156 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+func TestQUICInitialKeyDerivation(t *testing.T) {
+	// Test vectors from RFC 9001, Appendix A.1.
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+	initial := hkdf.Extract(sha256.New, dcid, quicV1InitialSalt)
+	client := hkdfExpandLabel(initial, "client in", 32)
+	for _, tt := range []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"client in", client, "c00cf151ca5be075ed0ebfb5c80323c42d6b7db67881289af4008f1f6c357aea"},
+		{"quic key", hkdfExpandLabel(client, "quic key", 16), "1f369613dd76d5467730efcbe3b1a22d"},
+		{"quic iv", hkdfExpandLabel(client, "quic iv", 12), "fa044b2f42a3fd3b46fb255c"},
+		{"quic hp", hkdfExpandLabel(client, "quic hp", 16), "9f50449e04a0e810283a1e9933adedd2"},
+	} {
+		if got := hex.EncodeToString(tt.got); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// testClientHello returns a TLS ClientHello handshake message for
+// serverName, as generated by crypto/tls.
+func testClientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go tls.Client(c1, &tls.Config{ServerName: serverName}).Handshake()
+	var hdr [5]byte
+	if _, err := io.ReadFull(c2, hdr[:]); err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(hdr[3:]))
+	if _, err := io.ReadFull(c2, msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func appendQUICVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return binary.BigEndian.AppendUint16(b, uint16(v)|0x4000)
+	default:
+		return binary.BigEndian.AppendUint32(b, uint32(v)|0x80000000)
+	}
+}
+
+// sealQUICInitial returns a protected client Initial packet with packet
+// number pn, carrying data at offset off of the CRYPTO stream.
+func sealQUICInitial(t *testing.T, dcid []byte, pn byte, off int, data []byte) []byte {
+	t.Helper()
+	keys, err := newQUICInitialKeys(dcid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte{0x06}
+	payload = appendQUICVarint(payload, uint64(off))
+	payload = appendQUICVarint(payload, uint64(len(data)))
+	payload = append(payload, data...)
+	payload = append(payload, make([]byte, 32)...) // PADDING
+
+	hdr := []byte{0xc0} // long header, Initial, 1-byte packet number
+	hdr = binary.BigEndian.AppendUint32(hdr, quicVersion1)
+	hdr = append(hdr, byte(len(dcid)))
+	hdr = append(hdr, dcid...)
+	hdr = append(hdr, 0) // no source connection ID
+	hdr = append(hdr, 0) // no token
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(1+len(payload)+keys.aead.Overhead())|0x4000)
+	pnOffset := len(hdr)
+	hdr = append(hdr, pn)
+
+	nonce := append([]byte(nil), keys.iv...)
+	nonce[len(nonce)-1] ^= pn
+	pkt := keys.aead.Seal(hdr, nonce, payload, hdr)
+
+	mask := make([]byte, 16)
+	keys.hp.Encrypt(mask, pkt[pnOffset+4:pnOffset+4+16])
+	pkt[0] ^= mask[0] & 0x0f
+	pkt[pnOffset] ^= mask[1]
+	return pkt
+}
+
+func TestQUICServerName(t *testing.T) {
+	hello := testClientHello(t, "quic.example.com")
+	dcid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	half := len(hello) / 2
+
+	tests := []struct {
+		name string
+		pkts [][]byte
+	}{
+		{"one-packet", [][]byte{sealQUICInitial(t, dcid, 0, 0, hello)}},
+		{"two-packets", [][]byte{
+			sealQUICInitial(t, dcid, 0, 0, hello[:half]),
+			sealQUICInitial(t, dcid, 1, half, hello[half:]),
+		}},
+		{"reordered", [][]byte{
+			sealQUICInitial(t, dcid, 1, half, hello[half:]),
+			sealQUICInitial(t, dcid, 0, 0, hello[:half]),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cs quicCryptoStream
+			for i, pkt := range tt.pkts {
+				if err := cs.addPacket(pkt); err != nil {
+					t.Fatalf("packet %d: %v", i, err)
+				}
+				name, err := cs.serverName()
+				if i < len(tt.pkts)-1 {
+					if err != errIncompleteClientHello {
+						t.Fatalf("after packet %d: serverName = %q, %v; want incomplete", i, name, err)
+					}
+					continue
+				}
+				if err != nil || name != "quic.example.com" {
+					t.Fatalf("serverName = %q, %v; want quic.example.com", name, err)
+				}
+			}
+		})
+	}
+
+	// Tampered packets fail to decrypt.
+	pkt := sealQUICInitial(t, dcid, 0, 0, hello)
+	pkt[len(pkt)-1] ^= 1
+	var cs quicCryptoStream
+	if err := cs.addPacket(pkt); err == nil {
+		t.Error("tampered packet accepted")
+	}
+
+	// As do packets that aren't QUIC.
+	if err := cs.addPacket([]byte("\x00\x01 a DNS query, perhaps")); err != errNotQUICInitial {
+		t.Errorf("non-QUIC packet: err = %v, want errNotQUICInitial", err)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"log"
+	"net/netip"
+	"slices"
+	"time"
+
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/types/appctype"
+	"tailscale.com/util/mak"
+)
+
+const (
+	defaultLearnedRouteTTL  = 24 * time.Hour
+	defaultMaxLearnedRoutes = 1000
+)
+
+// SetRouteAdvertiser configures the app connector to advertise routes it
+// learns from DNS answers when its configuration has AdvertiseRoutes set or
+// the ExperimentLearnRoutes experiment enabled, using advertise and
+// unadvertise, which are typically the methods of the same name of an
+// ipnlocal.LocalBackend.
+//
+// Passing nil functions stops learning routes. Routes already learned are
+// forgotten without being withdrawn.
+func (s *Server) SetRouteAdvertiser(advertise, unadvertise func(...netip.Prefix) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if advertise == nil || unadvertise == nil {
+		advertise, unadvertise = nil, nil
+		s.learnedRoutes = nil
+		s.stopRouteExpiryLocked()
+	}
+	s.advertiseRoute, s.unadvertiseRoute = advertise, unadvertise
+}
+
+// learnedRouteLimits returns the TTL and cap of learned routes in cfg.
+func learnedRouteLimits(cfg *appctype.AppConnectorConfig) (ttl time.Duration, max int) {
+	ttl, max = cfg.LearnedRouteTTL, cfg.MaxLearnedRoutes
+	if ttl <= 0 {
+		ttl = defaultLearnedRouteTTL
+	}
+	if max <= 0 {
+		max = defaultMaxLearnedRoutes
+	}
+	return ttl, max
+}
+
+// routable reports whether ip is an address a learned route may be
+// advertised for.
+func routable(ip netip.Addr) bool {
+	return ip.IsValid() && !ip.IsUnspecified() && !ip.IsLoopback() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsMulticast() && !tsaddr.IsTailscaleIP(ip)
+}
+
+// learnRoutes records that a service's destination domain resolved to ips,
+// advertising routes for those not already advertised and extending the
+// lifetime of those that are.
+func (s *Server) learnRoutes(ips []netip.Addr) {
+	s.mu.Lock()
+	cfg, advertise := s.effectiveConfig, s.advertiseRoute
+	if advertise == nil || cfg == nil || !(cfg.AdvertiseRoutes || cfg.Experiment(appctype.ExperimentLearnRoutes)) {
+		s.mu.Unlock()
+		return
+	}
+	ttl, max := learnedRouteLimits(cfg)
+	expiry := time.Now().Add(ttl)
+	var added []netip.Prefix
+	for _, ip := range ips {
+		ip = ip.Unmap()
+		if !routable(ip) || s.listenAddrs.Contains(ip) {
+			continue
+		}
+		if _, ok := s.learnedRoutes[ip]; !ok {
+			if len(s.learnedRoutes) >= max {
+				getMetrics().learnedRoutesDropped.Add(1)
+				continue
+			}
+			added = append(added, netip.PrefixFrom(ip, ip.BitLen()))
+		}
+		mak.Set(&s.learnedRoutes, ip, expiry)
+	}
+	if s.routeExpiry == nil && len(s.learnedRoutes) > 0 {
+		s.routeExpiry = time.AfterFunc(ttl, func() { s.expireLearnedRoutes(time.Now()) })
+	}
+	getMetrics().learnedRoutes.Set(int64(len(s.learnedRoutes)))
+	s.mu.Unlock()
+
+	if len(added) == 0 {
+		return
+	}
+	if err := advertise(added...); err != nil {
+		log.Printf("appc: advertising %d learned routes: %v", len(added), err)
+		// Forget them, so they're tried again when next seen.
+		s.mu.Lock()
+		for _, p := range added {
+			delete(s.learnedRoutes, p.Addr())
+		}
+		getMetrics().learnedRoutes.Set(int64(len(s.learnedRoutes)))
+		s.mu.Unlock()
+		return
+	}
+	log.Printf("appc: advertised learned routes %v", added)
+}
+
+// expireLearnedRoutes withdraws the learned routes whose addresses haven't
+// been seen since a TTL before now, and arranges to be called again when the
+// next of the rest expires.
+func (s *Server) expireLearnedRoutes(now time.Time) {
+	s.mu.Lock()
+	var expired []netip.Prefix
+	var next time.Time
+	for ip, expiry := range s.learnedRoutes {
+		if !now.Before(expiry) {
+			delete(s.learnedRoutes, ip)
+			expired = append(expired, netip.PrefixFrom(ip, ip.BitLen()))
+		} else if next.IsZero() || expiry.Before(next) {
+			next = expiry
+		}
+	}
+	s.stopRouteExpiryLocked()
+	if !next.IsZero() {
+		s.routeExpiry = time.AfterFunc(next.Sub(now), func() { s.expireLearnedRoutes(time.Now()) })
+	}
+	getMetrics().learnedRoutes.Set(int64(len(s.learnedRoutes)))
+	unadvertise := s.unadvertiseRoute
+	s.mu.Unlock()
+
+	if len(expired) == 0 || unadvertise == nil {
+		return
+	}
+	slices.SortFunc(expired, func(a, b netip.Prefix) int { return a.Addr().Compare(b.Addr()) })
+	if err := unadvertise(expired...); err != nil {
+		log.Printf("appc: withdrawing expired learned routes %v: %v", expired, err)
+		return
+	}
+	log.Printf("appc: withdrew expired learned routes %v", expired)
+}
+
+// stopRouteExpiryLocked stops the timer which expires learned routes, if
+// any. s.mu must be held.
+func (s *Server) stopRouteExpiryLocked() {
+	if s.routeExpiry != nil {
+		s.routeExpiry.Stop()
+		s.routeExpiry = nil
+	}
+}
+
+// LearnedRoutes returns the routes the app connector has advertised for the
+// addresses it has seen its services' destination domains resolve to.
+func (s *Server) LearnedRoutes() []netip.Prefix {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ret := make([]netip.Prefix, 0, len(s.learnedRoutes))
+	for ip := range s.learnedRoutes {
+		ret = append(ret, netip.PrefixFrom(ip, ip.BitLen()))
+	}
+	slices.SortFunc(ret, func(a, b netip.Prefix) int { return a.Addr().Compare(b.Addr()) })
+	return ret
+}
@@ -0,0 +1,202 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/net/memnet"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/appctype"
+)
+
+// fakeRouteAdvertiser records the routes it's asked to advertise.
+type fakeRouteAdvertiser struct {
+	mu     sync.Mutex
+	routes []netip.Prefix
+	err    error // returned by advertise, if non-nil
+}
+
+func (ra *fakeRouteAdvertiser) advertise(ipps ...netip.Prefix) error {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	if ra.err != nil {
+		return ra.err
+	}
+	ra.routes = append(ra.routes, ipps...)
+	return nil
+}
+
+func (ra *fakeRouteAdvertiser) unadvertise(ipps ...netip.Prefix) error {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	ra.routes = slices.DeleteFunc(ra.routes, func(p netip.Prefix) bool {
+		return slices.Contains(ipps, p)
+	})
+	return nil
+}
+
+func (ra *fakeRouteAdvertiser) get() []netip.Prefix {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	return slices.Clone(ra.routes)
+}
+
+func TestLearnRoutes(t *testing.T) {
+	connAddr := netip.MustParseAddr("100.64.0.1")
+	dnsRecords := map[string][]netip.Addr{
+		"a.example": {netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")},
+		"b.example": {netip.MustParseAddr("192.0.2.2"), netip.MustParseAddr("100.100.1.1"), connAddr},
+		"c.example": {netip.MustParseAddr("192.0.2.3")},
+	}
+	s := &Server{
+		lookupNetIP: func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+			if ips, ok := dnsRecords[host]; ok {
+				return ips, nil
+			}
+			return nil, errors.New("no such host")
+		},
+		hostDial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			c, _ := memnet.NewConn(address, 1024)
+			return c, nil
+		},
+	}
+	ra := new(fakeRouteAdvertiser)
+	s.SetRouteAdvertiser(ra.advertise, ra.unadvertise)
+	const ttl = time.Hour
+	cfg := &appctype.AppConnectorConfig{
+		DNAT: map[appctype.ConfigID]appctype.DNATConfig{
+			"a": {
+				Addrs: []netip.Addr{connAddr},
+				To:    []string{"a.example"},
+				IP:    []tailcfg.ProtoPortRange{{Ports: tailcfg.PortRangeAny}},
+			},
+		},
+		LearnedRouteTTL:  ttl,
+		MaxLearnedRoutes: 3,
+	}
+	s.Configure(cfg)
+
+	dial := func(host string) {
+		t.Helper()
+		c, err := s.dial(context.Background(), "tcp", net.JoinHostPort(host, "443"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+	}
+
+	// Nothing is learned unless the configuration asks for it.
+	dial("a.example")
+	if got := ra.get(); len(got) != 0 {
+		t.Fatalf("advertised %v without AdvertiseRoutes", got)
+	}
+
+	cfg.AdvertiseRoutes = true
+	s.Configure(cfg)
+	dial("a.example")
+	dial("a.example")
+	dial("b.example") // Tailscale and app connector addresses aren't routes
+	want := []netip.Prefix{
+		netip.MustParsePrefix("192.0.2.1/32"),
+		netip.MustParsePrefix("2001:db8::1/128"),
+		netip.MustParsePrefix("192.0.2.2/32"),
+	}
+	if got := ra.get(); !slices.Equal(got, want) {
+		t.Errorf("advertised %v, want %v", got, want)
+	}
+
+	// At the cap, new addresses aren't advertised.
+	dial("c.example")
+	if got := ra.get(); !slices.Equal(got, want) {
+		t.Errorf("advertised %v beyond the cap, want %v", got, want)
+	}
+
+	// Routes seen again later live longer.
+	s.mu.Lock()
+	s.learnedRoutes[netip.MustParseAddr("192.0.2.1")] = time.Now().Add(3 * ttl)
+	s.mu.Unlock()
+	s.expireLearnedRoutes(time.Now().Add(2 * ttl))
+	want = want[:1]
+	if got := ra.get(); !slices.Equal(got, want) {
+		t.Errorf("after expiry advertised %v, want %v", got, want)
+	}
+	if got := s.LearnedRoutes(); !slices.Equal(got, want) {
+		t.Errorf("LearnedRoutes = %v, want %v", got, want)
+	}
+
+	// Closing withdraws the rest.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := ra.get(); len(got) != 0 {
+		t.Errorf("after Close advertised %v", got)
+	}
+}
+
+func TestLearnRoutesAdvertiseError(t *testing.T) {
+	s := &Server{
+		lookupNetIP: func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+			return []netip.Addr{netip.MustParseAddr("192.0.2.1")}, nil
+		},
+		hostDial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			c, _ := memnet.NewConn(address, 1024)
+			return c, nil
+		},
+	}
+	defer s.Close()
+	ra := &fakeRouteAdvertiser{err: errors.New("prefs locked")}
+	s.SetRouteAdvertiser(ra.advertise, ra.unadvertise)
+	s.Configure(&appctype.AppConnectorConfig{AdvertiseRoutes: true})
+
+	if _, err := s.dial(context.Background(), "tcp", "a.example:443"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.LearnedRoutes(); len(got) != 0 {
+		t.Errorf("LearnedRoutes = %v after failing to advertise them", got)
+	}
+
+	// They're tried again when next seen.
+	ra.mu.Lock()
+	ra.err = nil
+	ra.mu.Unlock()
+	if _, err := s.dial(context.Background(), "tcp", "a.example:443"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ra.get(), []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32")}; !slices.Equal(got, want) {
+		t.Errorf("advertised %v, want %v", got, want)
+	}
+}
+
+func TestLearnRoutesExperiment(t *testing.T) {
+	s := &Server{
+		lookupNetIP: func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+			return []netip.Addr{netip.MustParseAddr("192.0.2.1")}, nil
+		},
+		hostDial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			c, _ := memnet.NewConn(address, 1024)
+			return c, nil
+		},
+	}
+	defer s.Close()
+	ra := new(fakeRouteAdvertiser)
+	s.SetRouteAdvertiser(ra.advertise, ra.unadvertise)
+	s.Configure(&appctype.AppConnectorConfig{
+		Experiments: map[appctype.Experiment]bool{appctype.ExperimentLearnRoutes: true},
+	})
+
+	if _, err := s.dial(context.Background(), "tcp", "a.example:443"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ra.get(), []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32")}; !slices.Equal(got, want) {
+		t.Errorf("advertised %v, want %v", got, want)
+	}
+}
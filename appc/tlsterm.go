@@ -0,0 +1,194 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"time"
+
+	"tailscale.com/net/netutil"
+	"tailscale.com/tailcfg"
+)
+
+// certTimeout is how long getting a certificate for a TLS handshake may
+// take, which may involve an ACME exchange.
+const certTimeout = time.Minute
+
+// SetCertGetter sets the function with which SNI proxy services that
+// terminate TLS get the certificates of domains, typically one using the
+// GetCertPEM method of an ipnlocal.LocalBackend. Passing nil makes such
+// services fail their TLS handshakes.
+func (s *Server) SetCertGetter(getCert func(ctx context.Context, domain string) (*tls.Certificate, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.getCert = getCert
+}
+
+// certificate returns the certificate with which to terminate TLS
+// connections for domain.
+func (s *Server) certificate(ctx context.Context, domain string) (*tls.Certificate, error) {
+	s.mu.RLock()
+	getCert := s.getCert
+	s.mu.RUnlock()
+	if getCert == nil {
+		return nil, errors.New("no certificate source")
+	}
+	return getCert(ctx, domain)
+}
+
+// clientIdentity returns the identity of the client at src, if it's known.
+func (s *Server) clientIdentity(src netip.AddrPort) (tailcfg.NodeView, tailcfg.UserProfile, bool) {
+	s.mu.RLock()
+	whoIs := s.whoIs
+	s.mu.RUnlock()
+	if whoIs == nil {
+		return tailcfg.NodeView{}, tailcfg.UserProfile{}, false
+	}
+	return whoIs(src)
+}
+
+// tlsTerminator is how SNI proxy handlers that terminate TLS get
+// certificates and the identity of clients.
+type tlsTerminator struct {
+	getCert func(ctx context.Context, domain string) (*tls.Certificate, error)
+	whoIs   func(netip.AddrPort) (tailcfg.NodeView, tailcfg.UserProfile, bool) // or nil
+
+	// rootCAs, if non-nil, are used instead of the system roots to verify
+	// destinations. For tests.
+	rootCAs *x509.CertPool
+}
+
+// terminate serves the HTTP requests of the TLS connection c, forwarding
+// each to h.Backend.
+func (h *tcpSNIHandler) terminate(c *trackedConn) {
+	src := addrPortOf(c.RemoteAddr())
+	tr := &http.Transport{
+		DialContext:     dialerFor(c, h.DialContext, h.ProxyHeader),
+		IdleConnTimeout: time.Minute,
+	}
+	if h.Terminator != nil && h.Terminator.rootCAs != nil {
+		tr.TLSClientConfig = &tls.Config{RootCAs: h.Terminator.rootCAs}
+	}
+	hs := &http.Server{
+		Handler:   h.proxy(src, tr),
+		TLSConfig: &tls.Config{GetCertificate: h.getCertificate},
+		ConnState: func(_ net.Conn, st http.ConnState) {
+			if st == http.StateClosed || st == http.StateHijacked {
+				tr.CloseIdleConnections()
+			}
+		},
+	}
+	hs.ServeTLS(netutil.NewOneConnListener(c, nil), "", "")
+}
+
+// getCertificate returns the certificate for the TLS handshake of hi, if
+// its SNI is allowed.
+func (h *tcpSNIHandler) getCertificate(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if hi.ServerName == "" {
+		return nil, errors.New("no SNI server name")
+	}
+	if !domainAllowed(hi.ServerName, h.Allowlist, h.Patterns) {
+		return nil, fmt.Errorf("domain %q not allowed", hi.ServerName)
+	}
+	if h.Terminator == nil || h.Terminator.getCert == nil {
+		return nil, errors.New("no certificate source")
+	}
+	ctx, cancel := context.WithTimeout(hi.Context(), certTimeout)
+	defer cancel()
+	return h.Terminator.getCert(ctx, hi.ServerName)
+}
+
+// proxy returns the handler of the HTTP requests from src, which forwards
+// them to h.Backend with tr.
+func (h *tcpSNIHandler) proxy(src netip.AddrPort, tr http.RoundTripper) http.Handler {
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(h.Backend)
+			r.Out.Host = r.In.Host
+			r.SetXForwarded()
+			h.setHeaders(r.Out.Header, src)
+		},
+		Transport: tr,
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		getMetrics().tlsTerminatedRequests.Add(1)
+		if !h.TerminateTLS.AccessLog {
+			rp.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		rp.ServeHTTP(lw, r)
+		log.Printf("appc: %s: %v %s %s%s %s: %d, %d bytes in %v",
+			h.Service, src, r.Method, r.Host, r.URL.RequestURI(), r.Proto,
+			lw.status(), lw.n, time.Since(start).Round(time.Millisecond))
+	})
+}
+
+// setHeaders sets the configured headers of requests from src in hdr.
+func (h *tcpSNIHandler) setHeaders(hdr http.Header, src netip.AddrPort) {
+	if h.TerminateTLS.IdentityHeaders {
+		// Clear any values the client sent, so that they can be trusted.
+		hdr.Del("Tailscale-User-Login")
+		hdr.Del("Tailscale-User-Name")
+		hdr.Del("Tailscale-Node-Name")
+		if h.Terminator != nil && h.Terminator.whoIs != nil {
+			if n, u, ok := h.Terminator.whoIs(src); ok && !n.IsTagged() {
+				hdr.Set("Tailscale-User-Login", u.LoginName)
+				hdr.Set("Tailscale-User-Name", u.DisplayName)
+				hdr.Set("Tailscale-Node-Name", n.Name())
+			}
+		}
+	}
+	for k, v := range h.TerminateTLS.SetHeaders {
+		hdr.Set(k, v)
+	}
+}
+
+// loggingResponseWriter is an http.ResponseWriter which records the status
+// and length of the response.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	code int
+	n    int64
+}
+
+func (w *loggingResponseWriter) WriteHeader(code int) {
+	if w.code == 0 && code >= 200 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *loggingResponseWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, as
+// httputil.ReverseProxy uses it to flush streamed responses.
+func (w *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// status returns the status of the response.
+func (w *loggingResponseWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
@@ -0,0 +1,111 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+
+	"tailscale.com/net/memnet"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/appctype"
+)
+
+func TestTLSTermination(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, k := range []string{"X-Team", "Tailscale-User-Login", "Tailscale-Node-Name", "X-Forwarded-For"} {
+			io.WriteString(w, k+"="+r.Header.Get(k)+"\n")
+		}
+		io.WriteString(w, "Host="+r.Host+" Path="+r.URL.Path+"\n")
+	}))
+	backend.StartTLS()
+	defer backend.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(backend.Certificate())
+
+	src := netip.MustParseAddrPort("100.64.0.2:40000")
+	h := &tcpSNIHandler{
+		Allowlist: []string{"example.com"},
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if addr != "example.com:8443" {
+				t.Errorf("dialed %s, want the backend, example.com:8443", addr)
+			}
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", backend.Listener.Addr().String())
+		},
+		TerminateTLS: &appctype.TLSTermination{
+			Backend:         "https://example.com:8443",
+			SetHeaders:      map[string]string{"X-Team": "infra"},
+			IdentityHeaders: true,
+			AccessLog:       true,
+		},
+		Terminator: &tlsTerminator{
+			// httptest's certificate is also valid for example.com.
+			getCert: func(ctx context.Context, domain string) (*tls.Certificate, error) {
+				return &backend.TLS.Certificates[0], nil
+			},
+			whoIs: func(ipp netip.AddrPort) (tailcfg.NodeView, tailcfg.UserProfile, bool) {
+				if ipp != src {
+					return tailcfg.NodeView{}, tailcfg.UserProfile{}, false
+				}
+				n := &tailcfg.Node{Name: "laptop.example.ts.net."}
+				return n.View(), tailcfg.UserProfile{LoginName: "alice@example.com"}, true
+			},
+			rootCAs: roots,
+		},
+		Backend: &url.URL{Scheme: "https", Host: "example.com:8443"},
+		Service: "sni/test",
+	}
+
+	dial := func(serverName string) *tls.Conn {
+		cSock, sSock := memnet.NewTCPConn(src, netip.MustParseAddrPort("100.64.0.1:443"), 1024)
+		go h.Handle(sSock)
+		return tls.Client(cSock, &tls.Config{ServerName: serverName, RootCAs: roots})
+	}
+
+	c := dial("example.com")
+	defer c.Close()
+	req, _ := http.NewRequest("GET", "https://example.com/path", nil)
+	req.Header.Set("Tailscale-User-Login", "mallory@example.com")
+	if err := req.Write(c); err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.ReadResponse(bufio.NewReader(c), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"X-Team=infra",
+		"Tailscale-User-Login=alice@example.com",
+		"Tailscale-Node-Name=laptop.example.ts.net.",
+		"X-Forwarded-For=100.64.0.2",
+		"Host=example.com Path=/path",
+		"",
+	}, "\n")
+	if string(body) != want {
+		t.Errorf("backend got:\n%s\nwant:\n%s", body, want)
+	}
+
+	// Domains that aren't allowed fail the handshake.
+	c = dial("other.example")
+	defer c.Close()
+	if err := c.Handshake(); err == nil {
+		t.Error("handshake for disallowed domain succeeded")
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/nettype"
+)
+
+const (
+	// udpIdleTimeout is how long a UDP flow can go without a packet in
+	// either direction before its state is dropped.
+	udpIdleTimeout = 2 * time.Minute
+
+	// dnsIdleTimeout is udpIdleTimeout for flows to port 53, which are
+	// typically a single query and response.
+	dnsIdleTimeout = 15 * time.Second
+
+	// maxUDPFlows is the most UDP flows the app connector tracks at once.
+	// New flows beyond it are dropped.
+	maxUDPFlows = 4096
+
+	// maxUDPPacketSize is the largest UDP payload.
+	maxUDPPacketSize = 65535
+
+	// quicInitialPackets is the most QUIC Initial packets an SNI proxy
+	// reads while looking for a complete ClientHello.
+	quicInitialPackets = 4
+)
+
+// idleTimeoutForPort returns the idle timeout of UDP flows to port.
+func idleTimeoutForPort(port uint16) time.Duration {
+	if port == 53 {
+		return dnsIdleTimeout
+	}
+	return udpIdleTimeout
+}
+
+// udpFlow is the NAT state of a UDP flow through the app connector: the
+// flow from a client to a service address, and the upstream socket its
+// packets are forwarded over.
+type udpFlow struct {
+	src, dst   netip.AddrPort
+	idle       time.Duration
+	lastActive atomic.Int64 // unix nanos of the last packet in either direction
+}
+
+func (f *udpFlow) touch() {
+	f.lastActive.Store(time.Now().UnixNano())
+}
+
+func (f *udpFlow) idleFor() time.Duration {
+	return time.Since(time.Unix(0, f.lastActive.Load()))
+}
+
+// udpFlowTable tracks the app connector's UDP flows.
+type udpFlowTable struct {
+	mu    sync.Mutex
+	flows map[[2]netip.AddrPort]*udpFlow // keyed by src, dst
+}
+
+var errTooManyUDPFlows = errors.New("too many UDP flows")
+
+// add starts tracking a flow from src to dst. Its caller must call remove
+// when the flow ends.
+func (t *udpFlowTable) add(src, dst netip.AddrPort) (*udpFlow, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k := [2]netip.AddrPort{src, dst}
+	if _, ok := t.flows[k]; ok {
+		return nil, fmt.Errorf("duplicate UDP flow %v -> %v", src, dst)
+	}
+	if len(t.flows) >= maxUDPFlows {
+		return nil, errTooManyUDPFlows
+	}
+	f := &udpFlow{src: src, dst: dst, idle: idleTimeoutForPort(dst.Port())}
+	f.touch()
+	if t.flows == nil {
+		t.flows = make(map[[2]netip.AddrPort]*udpFlow)
+	}
+	t.flows[k] = f
+	return f, nil
+}
+
+func (t *udpFlowTable) remove(f *udpFlow) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.flows, [2]netip.AddrPort{f.src, f.dst})
+}
+
+// len returns the number of tracked flows.
+func (t *udpFlowTable) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.flows)
+}
+
+// copyPackets copies packets from src to dst until either fails or the
+// flow has been idle in both directions for its idle timeout.
+func (f *udpFlow) copyPackets(dst, src net.Conn) error {
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		src.SetReadDeadline(time.Now().Add(f.idle))
+		n, err := src.Read(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() && f.idleFor() < f.idle {
+				continue // the other direction is active
+			}
+			return err
+		}
+		f.touch()
+		if _, err := dst.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+}
+
+// relay forwards packets between the client and upstream sockets of f,
+// after first sending the already-read packets first to upstream. It
+// closes both sockets when the flow ends.
+func (f *udpFlow) relay(client, upstream net.Conn, first [][]byte) {
+	defer client.Close()
+	defer upstream.Close()
+	for _, p := range first {
+		if _, err := upstream.Write(p); err != nil {
+			return
+		}
+	}
+	errc := make(chan error, 2)
+	go func() { errc <- f.copyPackets(upstream, client) }()
+	go func() { errc <- f.copyPackets(client, upstream) }()
+	<-errc
+	// Closing both sockets, as deferred above, ends the other copy.
+}
+
+// udpHandler is a handler which also handles UDP flows.
+type udpHandler interface {
+	handler
+
+	// HandleUDP handles the UDP flow f, whose packets are read from and
+	// written to c.
+	HandleUDP(c nettype.ConnPacketConn, f *udpFlow)
+}
+
+// handleUDPFlow is like handleTCPFlow, for UDP flows.
+func (c *connector) handleUDPFlow(src, dst netip.AddrPort) (h udpHandler, ok bool) {
+	for t, h := range c.Handlers {
+		if t.Matching.Proto != 0 && t.Matching.Proto != int(ipproto.UDP) {
+			continue
+		}
+		if !t.Dest.Contains(dst.Addr()) || !t.Matching.Ports.Contains(dst.Port()) {
+			continue
+		}
+		if uh, ok := h.(udpHandler); ok {
+			return uh, true
+		}
+	}
+	return nil, false
+}
+
+// HandleUDPFlow is like HandleTCPFlow, for UDP flows. It implements
+// tsnet.FallbackUDPHandler.
+//
+// Flows to DNAT services are forwarded to the service's destinations. Flows
+// to SNI proxy services are taken to be QUIC, and are forwarded to the server
+// named in the ClientHello of their Initial packets.
+func (s *Server) HandleUDPFlow(src, dst netip.AddrPort) (handler func(nettype.ConnPacketConn), intercept bool) {
+	m := getMetrics()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.allowsSourceLocked(src, dst) {
+		return nil, false
+	}
+
+	for _, c := range s.connectors {
+		h, ok := c.handleUDPFlow(src, dst)
+		if !ok {
+			continue
+		}
+		if !handlerSources(h).allows(src, s.whoIs) {
+			m.deniedConns.Add(1)
+			return nil, false
+		}
+		switch h.(type) {
+		case *tcpSNIHandler:
+			m.quicSessions.Add(1)
+		default:
+			m.udpSessions.Add(1)
+		}
+		return func(c nettype.ConnPacketConn) {
+			f, err := s.udpFlows.add(src, dst)
+			if err != nil {
+				log.Printf("appc: dropping UDP flow %v -> %v: %v", src, dst, err)
+				c.Close()
+				return
+			}
+			defer s.udpFlows.remove(f)
+			h.HandleUDP(c, f)
+		}, true
+	}
+
+	m.unhandledConns.Add(1)
+	return nil, false
+}
+
+// dialUDP dials a UDP socket to host:port with dial.
+func dialUDP(dial dialFunc, host string, port uint16) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	return dial(ctx, "udp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+}
+
+// HandleUDP implements udpHandler, forwarding the flow to one of the
+// handler's destinations.
+func (h *tcpRoundRobinHandler) HandleUDP(conn nettype.ConnPacketConn, f *udpFlow) {
+	c := h.Stats.trackConn(conn, "udp")
+	dest := h.pickDest()
+	upstream, err := dialUDP(c.dialer(h.DialContext), dest, f.dst.Port())
+	if err != nil {
+		log.Printf("appc: UDP flow %v -> %v: %v", f.src, f.dst, err)
+		c.Close()
+		return
+	}
+	f.relay(c, upstream, nil)
+}
+
+// HandleUDP implements udpHandler, forwarding the QUIC connection of the
+// flow to the server named in its ClientHello, if allowed.
+func (h *tcpSNIHandler) HandleUDP(conn nettype.ConnPacketConn, f *udpFlow) {
+	c := h.Stats.trackConn(conn, "udp")
+	name, pkts, err := readQUICServerName(c)
+	if err != nil {
+		log.Printf("appc: QUIC flow %v -> %v: %v", f.src, f.dst, err)
+		c.Close()
+		return
+	}
+	if !domainAllowed(name, h.Allowlist, h.Patterns) {
+		c.Close()
+		return
+	}
+	upstream, err := dialUDP(c.dialer(h.DialContext), name, f.dst.Port())
+	if err != nil {
+		log.Printf("appc: QUIC flow %v -> %v: %v", f.src, f.dst, err)
+		c.Close()
+		return
+	}
+	f.touch()
+	f.relay(c, upstream, pkts)
+}
+
+// readQUICServerName reads QUIC Initial packets from c until they hold a
+// complete ClientHello, returning its server name and the packets read.
+func readQUICServerName(c net.Conn) (name string, pkts [][]byte, err error) {
+	c.SetReadDeadline(time.Now().Add(dialTimeout))
+	defer c.SetReadDeadline(time.Time{})
+
+	var cs quicCryptoStream
+	buf := make([]byte, maxUDPPacketSize)
+	for len(pkts) < quicInitialPackets {
+		n, err := c.Read(buf)
+		if err != nil {
+			return "", nil, err
+		}
+		pkt := append([]byte(nil), buf[:n]...)
+		pkts = append(pkts, pkt)
+		if err := cs.addPacket(pkt); err != nil {
+			return "", nil, err
+		}
+		name, err := cs.serverName()
+		if errors.Is(err, errIncompleteClientHello) {
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		if name == "" {
+			return "", nil, errors.New("no server name in ClientHello")
+		}
+		return name, pkts, nil
+	}
+	return "", nil, errIncompleteClientHello
+}
@@ -0,0 +1,206 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/appctype"
+	"tailscale.com/types/ipproto"
+)
+
+// udpEchoServer starts a UDP server on localhost that echoes packets, and
+// returns its port.
+func udpEchoServer(t *testing.T) uint16 {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, maxUDPPacketSize)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], addr)
+		}
+	}()
+	return uint16(pc.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// udpFlowConns returns a pair of connected UDP sockets standing in for a
+// client and the flow's socket handed to the app connector.
+func udpFlowConns(t *testing.T) (client, flow *net.UDPConn) {
+	t.Helper()
+	a, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := net.DialUDP("udp", nil, a.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+	client, err = net.DialUDP("udp", a.LocalAddr().(*net.UDPAddr), b.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close(); b.Close() })
+	return client, b
+}
+
+func roundTrip(t *testing.T, c net.Conn, msg []byte) {
+	t.Helper()
+	if _, err := c.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, maxUDPPacketSize)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], msg) {
+		t.Fatalf("got %q, want %q", buf[:n], msg)
+	}
+}
+
+func TestHandleUDPFlow(t *testing.T) {
+	port := udpEchoServer(t)
+	addr := netip.MustParseAddr("100.64.0.1")
+	ip := func(proto ipproto.Proto) []tailcfg.ProtoPortRange {
+		return []tailcfg.ProtoPortRange{{Proto: int(proto), Ports: tailcfg.PortRange{First: port, Last: port}}}
+	}
+	s := &Server{
+		lookupNetIP: func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+			return []netip.Addr{netip.MustParseAddr("127.0.0.1")}, nil
+		},
+	}
+	s.Configure(&appctype.AppConnectorConfig{
+		DNAT: map[appctype.ConfigID]appctype.DNATConfig{
+			"dns": {Addrs: []netip.Addr{addr}, To: []string{"127.0.0.1"}, IP: ip(ipproto.UDP)},
+		},
+		SNIProxy: map[appctype.ConfigID]appctype.SNIProxyConfig{
+			"h3": {Addrs: []netip.Addr{netip.MustParseAddr("100.64.0.2")}, AllowedDomains: []string{"quic.example.com"}, IP: ip(ipproto.UDP)},
+		},
+	})
+	src := netip.MustParseAddrPort("100.100.1.1:4000")
+
+	if _, intercept := s.HandleUDPFlow(src, netip.AddrPortFrom(addr, port+1)); intercept {
+		t.Fatal("intercepted flow to unconfigured port")
+	}
+
+	t.Run("dnat", func(t *testing.T) {
+		h, intercept := s.HandleUDPFlow(src, netip.AddrPortFrom(addr, port))
+		if !intercept || h == nil {
+			t.Fatal("DNAT flow not intercepted")
+		}
+		client, flow := udpFlowConns(t)
+		go h(flow)
+		roundTrip(t, client, []byte("hello"))
+		roundTrip(t, client, []byte("again"))
+		if n := s.udpFlows.len(); n != 1 {
+			t.Errorf("tracked flows = %d, want 1", n)
+		}
+	})
+
+	t.Run("quic", func(t *testing.T) {
+		dst := netip.AddrPortFrom(netip.MustParseAddr("100.64.0.2"), port)
+		dcid := []byte{8, 7, 6, 5, 4, 3, 2, 1}
+
+		h, intercept := s.HandleUDPFlow(src, dst)
+		if !intercept || h == nil {
+			t.Fatal("QUIC flow not intercepted")
+		}
+		client, flow := udpFlowConns(t)
+		go h(flow)
+		roundTrip(t, client, sealQUICInitial(t, dcid, 0, 0, testClientHello(t, "quic.example.com")))
+
+		// Connections to domains that aren't allowed are dropped.
+		h, _ = s.HandleUDPFlow(netip.MustParseAddrPort("100.100.1.1:4001"), dst)
+		client, flow = udpFlowConns(t)
+		done := make(chan struct{})
+		go func() {
+			h(flow)
+			close(done)
+		}()
+		client.Write(sealQUICInitial(t, dcid, 0, 0, testClientHello(t, "evil.example")))
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("disallowed QUIC flow not dropped")
+		}
+	})
+}
+
+func TestUDPFlowIdleTimeout(t *testing.T) {
+	port := udpEchoServer(t)
+	upstream, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, flow := udpFlowConns(t)
+	f := &udpFlow{idle: 100 * time.Millisecond}
+	f.touch()
+	done := make(chan struct{})
+	go func() {
+		f.relay(flow, upstream, nil)
+		close(done)
+	}()
+
+	// Activity keeps the flow alive past its idle timeout.
+	for i := 0; i < 3; i++ {
+		roundTrip(t, client, []byte("ping"))
+		time.Sleep(50 * time.Millisecond)
+	}
+	select {
+	case <-done:
+		t.Fatal("active flow timed out")
+	default:
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle flow not closed")
+	}
+}
+
+func TestUDPFlowTable(t *testing.T) {
+	var ft udpFlowTable
+	src := netip.MustParseAddrPort("100.100.1.1:4000")
+	dst := netip.MustParseAddrPort("100.64.0.1:53")
+	f, err := ft.add(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.idle != dnsIdleTimeout {
+		t.Errorf("DNS flow idle timeout = %v, want %v", f.idle, dnsIdleTimeout)
+	}
+	if _, err := ft.add(src, dst); err == nil {
+		t.Error("duplicate flow added")
+	}
+	for i := 1; i < maxUDPFlows; i++ {
+		if _, err := ft.add(netip.AddrPortFrom(netip.MustParseAddr("100.100.1.3"), uint16(i)), dst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ft.add(netip.MustParseAddrPort("100.100.1.2:1"), dst); err != errTooManyUDPFlows {
+		t.Errorf("add beyond limit: err = %v, want errTooManyUDPFlows", err)
+	}
+	ft.remove(f)
+	if n := ft.len(); n != maxUDPFlows-1 {
+		t.Errorf("len = %d, want %d", n, maxUDPFlows-1)
+	}
+}
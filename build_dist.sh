@@ -43,6 +43,10 @@ while [ "$#" -gt 1 ]; do
 		shift
 		tags="${tags:+$tags,}ts_include_cli"
 		;;
+	--webclient)
+		shift
+		tags="${tags:+$tags,}ts_include_webclient"
+		;;
 	*)
 		break
 		;;
@@ -4,7 +4,11 @@
 // Package apitype contains types for the Tailscale LocalAPI and control plane API.
 package apitype
 
-import "tailscale.com/tailcfg"
+import (
+	"time"
+
+	"tailscale.com/tailcfg"
+)
 
 // LocalAPIHost is the Host header value used by the LocalAPI.
 const LocalAPIHost = "local-tailscaled.sock"
@@ -31,8 +35,36 @@ type FileTarget struct {
 }
 
 type WaitingFile struct {
-	Name string
+	Name string // base name of the file
 	Size int64
+
+	// Path is the slash-separated path of the file within the inbox,
+	// for files that were sent as part of a directory. It is empty for
+	// other files, which are identified by Name alone.
+	Path string `json:",omitempty"`
+}
+
+// FileSHA256Header is the HTTP request header in which a sender of a
+// Taildrop file may put the hex-encoded SHA-256 checksum of the whole file,
+// for the receiver to verify before accepting the file.
+const FileSHA256Header = "Taildrop-Sha256"
+
+// FileManifestName is the base name of the manifest that is sent after all
+// the files of a directory sent with Taildrop, at the root of that directory.
+// It is checked by the receiver against the files it received,
+// rather than stored.
+const FileManifestName = ".taildrop-manifest"
+
+// FileManifest is the JSON contents of a FileManifestName file.
+type FileManifest struct {
+	Files []FileManifestEntry
+}
+
+// FileManifestEntry is a file in a FileManifest.
+type FileManifestEntry struct {
+	Name   string // slash-separated, relative to the directory of the manifest
+	Size   int64
+	SHA256 string // hex-encoded
 }
 
 // SetPushDeviceTokenRequest is the body POSTed to the LocalAPI endpoint /set-device-token.
@@ -49,3 +81,82 @@ type ReloadConfigResponse struct {
 	Reloaded bool   // whether the config was reloaded
 	Err      string // any error message
 }
+
+// AutoExitNodeDecision is the response to a LocalAPI auto-exit-node
+// request: the latest choice of exit node made because of
+// ipn.Prefs.AutoExitNode, and why.
+type AutoExitNodeDecision struct {
+	Time     time.Time            // when the choice was made
+	Selected tailcfg.StableNodeID // the exit node chosen; empty if there's none to choose
+	Reason   string               // why Selected was chosen, for humans
+
+	// Candidates are the exit nodes that were considered, best first,
+	// including those that couldn't be chosen.
+	Candidates []AutoExitNodeCandidate
+}
+
+// AutoExitNodeCandidate is an exit node considered for an
+// AutoExitNodeDecision.
+type AutoExitNodeCandidate struct {
+	ID   tailcfg.StableNodeID
+	Name string // MagicDNS base name
+
+	// DERPRegion is the exit node's home DERP region, and Latency this
+	// node's latency to it per the latest netcheck report, or zero if
+	// unknown. It stands in for the latency to the exit node itself.
+	DERPRegion int           `json:",omitempty"`
+	Latency    time.Duration `json:",omitempty"`
+
+	// Priority is the exit node's advertised Hostinfo.Location priority,
+	// which breaks ties between exit nodes of equal latency.
+	Priority int `json:",omitempty"`
+
+	// Excluded, if non-empty, is why the exit node couldn't be chosen,
+	// such as "offline".
+	Excluded string `json:",omitempty"`
+}
+
+// LocalAPITokenHeader is the HTTP header in which LocalAPI clients send a
+// LocalAPI access token. When present, the request is permitted only what
+// the token's scope allows, regardless of who is connecting.
+const LocalAPITokenHeader = "Tailscale-LocalAPI-Token"
+
+// LocalAPIScope is what a LocalAPI access token permits.
+type LocalAPIScope string
+
+const (
+	// LocalAPIScopeStatus permits reading the node's status, prefs and
+	// health, but not changing anything.
+	LocalAPIScopeStatus LocalAPIScope = "status"
+
+	// LocalAPIScopeServe additionally permits changing the serve and
+	// Funnel config and fetching TLS certs.
+	LocalAPIScopeServe LocalAPIScope = "serve"
+
+	// LocalAPIScopeAdmin permits everything.
+	LocalAPIScopeAdmin LocalAPIScope = "admin"
+)
+
+// LocalAPIToken describes a LocalAPI access token. The token itself is
+// only ever returned when it's created, in a CreateLocalAPITokenResponse.
+type LocalAPIToken struct {
+	ID      string // unique; used to revoke the token
+	Name    string `json:",omitempty"` // optional, for humans
+	Scope   LocalAPIScope
+	Created time.Time
+}
+
+// CreateLocalAPITokenRequest is the body POSTed to the LocalAPI endpoint
+// /auth-tokens to create a LocalAPI access token.
+type CreateLocalAPITokenRequest struct {
+	Name  string `json:",omitempty"`
+	Scope LocalAPIScope
+}
+
+// CreateLocalAPITokenResponse is the response to a
+// CreateLocalAPITokenRequest.
+type CreateLocalAPITokenResponse struct {
+	// Token is the secret token to send in the LocalAPITokenHeader.
+	Token string
+	Info  LocalAPIToken
+}
@@ -10,6 +10,7 @@ type DNSConfig struct {
 	Domains            []string                 `json:"domains"`
 	Nameservers        []string                 `json:"nameservers"`
 	Proxied            bool                     `json:"proxied"`
+	Blocklists         []string                 `json:"blocklists,omitempty"`
 	TempCorpIssue13969 string                   `json:"TempCorpIssue13969,omitempty"`
 }
 
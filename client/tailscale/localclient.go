@@ -9,6 +9,7 @@
 	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -28,6 +29,7 @@
 	"go4.org/mem"
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/envknob"
+	"tailscale.com/health"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/netutil"
@@ -35,9 +37,13 @@
 	"tailscale.com/safesocket"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tka"
+	"tailscale.com/types/appctype"
+	"tailscale.com/types/dnstype"
 	"tailscale.com/types/key"
+	"tailscale.com/types/netmap/nmdiff"
 	"tailscale.com/types/tkatype"
 	"tailscale.com/util/cmpx"
+	"tailscale.com/wgengine/filter"
 )
 
 // defaultLocalClient is the default LocalClient when using the legacy
@@ -68,6 +74,11 @@ type LocalClient struct {
 	// connecting to the GUI client variants.
 	UseSocketOnly bool
 
+	// AuthToken optionally specifies a LocalAPI access token, as created
+	// by CreateLocalAPIToken, to send with each request. tailscaled then
+	// permits only what the token's scope allows.
+	AuthToken string
+
 	// tsClient does HTTP requests to the local Tailscale daemon.
 	// It's lazily initialized on first use.
 	tsClient     *http.Client
@@ -127,6 +138,9 @@ func (lc *LocalClient) DoLocalRequest(req *http.Request) (*http.Response, error)
 	if _, token, err := safesocket.LocalTCPPortAndToken(); err == nil {
 		req.SetBasicAuth("", token)
 	}
+	if lc.AuthToken != "" {
+		req.Header.Set(apitype.LocalAPITokenHeader, lc.AuthToken)
+	}
 	return lc.tsClient.Do(req)
 }
 
@@ -291,6 +305,26 @@ func (lc *LocalClient) Goroutines(ctx context.Context) ([]byte, error) {
 	return lc.get200(ctx, "/localapi/v0/goroutines")
 }
 
+// Health runs the Tailscale daemon's health checks and returns their
+// results, failing checks first.
+func (lc *LocalClient) Health(ctx context.Context) ([]health.CheckResult, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/health")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]health.CheckResult](body)
+}
+
+// AutoExitNodeDecision returns the Tailscale daemon's latest choice of
+// exit node made because of ipn.Prefs.AutoExitNode, and why it made it.
+func (lc *LocalClient) AutoExitNodeDecision(ctx context.Context) (*apitype.AutoExitNodeDecision, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/auto-exit-node")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.AutoExitNodeDecision](body)
+}
+
 // DaemonMetrics returns the Tailscale daemon's metrics in
 // the Prometheus text exposition format.
 func (lc *LocalClient) DaemonMetrics(ctx context.Context) ([]byte, error) {
@@ -542,6 +576,28 @@ func (lc *LocalClient) SetComponentDebugLogging(ctx context.Context, component s
 	return nil
 }
 
+// SetMapLogLevel sets the verbosity of tailscaled's map session logging
+// ("default", "summary", "response" or "peer") for the duration d, after
+// which it reverts to "default".
+func (lc *LocalClient) SetMapLogLevel(ctx context.Context, level string, d time.Duration) error {
+	body, err := lc.send(ctx, "POST",
+		fmt.Sprintf("/localapi/v0/debug-map-log-level?level=%s&secs=%d",
+			url.QueryEscape(level), int64(d.Seconds())), 200, nil)
+	if err != nil {
+		return fmt.Errorf("error %w: %s", err, body)
+	}
+	var res struct {
+		Error string
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return err
+	}
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+	return nil
+}
+
 // Status returns the Tailscale daemon's status.
 func Status(ctx context.Context) (*ipnstate.Status, error) {
 	return defaultLocalClient.Status(ctx)
@@ -562,6 +618,13 @@ func (lc *LocalClient) StatusWithoutPeers(ctx context.Context) (*ipnstate.Status
 	return lc.status(ctx, "?peers=false")
 }
 
+// StatusWithPeerFilter returns the Tailscale daemon's status, with only
+// the peers that filter, an ipnstate.PeerSelector such as "tag:prod
+// os:linux", selects.
+func (lc *LocalClient) StatusWithPeerFilter(ctx context.Context, filter string) (*ipnstate.Status, error) {
+	return lc.status(ctx, "?filter="+url.QueryEscape(filter))
+}
+
 func (lc *LocalClient) status(ctx context.Context, queryString string) (*ipnstate.Status, error) {
 	body, err := lc.get200(ctx, "/localapi/v0/status"+queryString)
 	if err != nil {
@@ -570,6 +633,126 @@ func (lc *LocalClient) status(ctx context.Context, queryString string) (*ipnstat
 	return decodeJSON[*ipnstate.Status](body)
 }
 
+// DebugNetcheckHistory returns tailscaled's recent netcheck reports as
+// JSON: an array of netcheck.HistoryEntry, oldest first.
+func (lc *LocalClient) DebugNetcheckHistory(ctx context.Context) ([]byte, error) {
+	return lc.get200(ctx, "/localapi/v0/debug-netcheck-history")
+}
+
+// DebugDERPRace returns tailscaled's home and warm standby DERP regions
+// and its most recent race to replace a failed home region, as JSON.
+func (lc *LocalClient) DebugDERPRace(ctx context.Context) ([]byte, error) {
+	return lc.get200(ctx, "/localapi/v0/debug-derp-race")
+}
+
+// DebugPortmapLeases returns the port mappings tailscaled currently holds
+// on the machine's home routers as JSON: an array of portmapper.Lease.
+func (lc *LocalClient) DebugPortmapLeases(ctx context.Context) ([]byte, error) {
+	return lc.get200(ctx, "/localapi/v0/debug-portmap-leases")
+}
+
+// DebugMapTraces returns the latencies of recent MapResponses through the
+// stages of processing them as JSON: a controlclient.MapTraces.
+func (lc *LocalClient) DebugMapTraces(ctx context.Context) ([]byte, error) {
+	return lc.get200(ctx, "/localapi/v0/debug-map-traces")
+}
+
+// DebugNetMapDiff returns how tailscaled's current network map differs
+// from the one before it.
+func (lc *LocalClient) DebugNetMapDiff(ctx context.Context) (*nmdiff.Diff, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-netmap-diff")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*nmdiff.Diff](body)
+}
+
+// DebugPacketFilterRules returns the packet filter rules of tailscaled's
+// current network map, as sent by the control plane.
+func (lc *LocalClient) DebugPacketFilterRules(ctx context.Context) ([]tailcfg.FilterRule, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-packet-filter-rules")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]tailcfg.FilterRule](body)
+}
+
+// DebugPacketFilterMatches returns the packet filter of tailscaled's
+// current network map, as compiled from its rules.
+func (lc *LocalClient) DebugPacketFilterMatches(ctx context.Context) ([]filter.Match, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-packet-filter-matches")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]filter.Match](body)
+}
+
+// DebugPeerEndpointChanges returns the recent changes to the endpoints
+// of the peer with the Tailscale IP ip as JSON: an array of
+// magicsock.EndpointChange, oldest first.
+func (lc *LocalClient) DebugPeerEndpointChanges(ctx context.Context, ip netip.Addr) ([]byte, error) {
+	return lc.get200(ctx, "/localapi/v0/debug-peer-endpoint-changes?ip="+url.QueryEscape(ip.String()))
+}
+
+// DebugLog writes lines to tailscaled's log, each prefixed with prefix
+// and a colon, or with "debug-log" if prefix is empty, and uploads them.
+func (lc *LocalClient) DebugLog(ctx context.Context, prefix string, lines ...string) error {
+	type logRequest struct {
+		Lines  []string
+		Prefix string
+	}
+	_, err := lc.send(ctx, "POST", "/localapi/v0/debug-log", http.StatusNoContent, jsonBody(logRequest{Lines: lines, Prefix: prefix}))
+	return err
+}
+
+// DebugWebClientAuth starts, if id is empty, or waits for the completion
+// of, an authentication session of the peer src with the control plane
+// to manage this node through its web client.
+func (lc *LocalClient) DebugWebClientAuth(ctx context.Context, id string, src tailcfg.NodeID) (*tailcfg.WebClientAuthResponse, error) {
+	type authRequest struct {
+		ID  string
+		Src tailcfg.NodeID
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-web-client", 200, jsonBody(authRequest{ID: id, Src: src}))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*tailcfg.WebClientAuthResponse](body)
+}
+
+// PeerTraffic returns the WireGuard traffic to and from each peer that
+// has had any, busiest first.
+func (lc *LocalClient) PeerTraffic(ctx context.Context) ([]ipnstate.PeerTraffic, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/peer-traffic")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]ipnstate.PeerTraffic](body)
+}
+
+// StreamPeerTraffic returns the WireGuard traffic to and from each peer
+// that has had any, busiest first, as JSON lines of []ipnstate.PeerTraffic
+// sent every interval until ctx is done. Unlike PeerTraffic, it also counts
+// each peer's new flows, which requires write access to the LocalAPI.
+//
+// The caller must close the returned ReadCloser.
+func (lc *LocalClient) StreamPeerTraffic(ctx context.Context, interval time.Duration) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/peer-traffic?watch="+url.QueryEscape(interval.String()), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, bestError(errors.New(res.Status), body)
+	}
+	return res.Body, nil
+}
+
 // IDToken is a request to get an OIDC ID token for an audience.
 // The token can be presented to any resource provider which offers OIDC
 // Federation.
@@ -640,6 +823,26 @@ func (lc *LocalClient) FileTargets(ctx context.Context) ([]apitype.FileTarget, e
 // A size of -1 means unknown.
 // The name parameter is the original filename, not escaped.
 func (lc *LocalClient) PushFile(ctx context.Context, target tailcfg.StableNodeID, size int64, name string, r io.Reader) error {
+	return lc.PushFileWithOptions(ctx, target, size, name, r, PushFileOptions{})
+}
+
+// PushFileOptions are optional parameters for PushFileWithOptions.
+type PushFileOptions struct {
+	// SHA256, if non-nil, is the SHA-256 checksum of the whole file.
+	// The target verifies it before accepting the file.
+	SHA256 []byte
+}
+
+// PushFileWithOptions is like PushFile, but with options.
+//
+// The name may be the slash-separated path of a file within a directory
+// that's being sent, in which case the directory should be completed by
+// sending an apitype.FileManifest named apitype.FileManifestName at its root.
+//
+// If sending a file fails after the target received part of it, sending it
+// again resumes from the data the target already has.
+// Errors returned by the target are of type *PushFileError.
+func (lc *LocalClient) PushFileWithOptions(ctx context.Context, target tailcfg.StableNodeID, size int64, name string, r io.Reader, opts PushFileOptions) error {
 	req, err := http.NewRequestWithContext(ctx, "PUT", "http://"+apitype.LocalAPIHost+"/localapi/v0/file-put/"+string(target)+"/"+url.PathEscape(name), r)
 	if err != nil {
 		return err
@@ -647,6 +850,9 @@ func (lc *LocalClient) PushFile(ctx context.Context, target tailcfg.StableNodeID
 	if size != -1 {
 		req.ContentLength = size
 	}
+	if opts.SHA256 != nil {
+		req.Header.Set(apitype.FileSHA256Header, hex.EncodeToString(opts.SHA256))
+	}
 	res, err := lc.doLocalRequestNiceError(req)
 	if err != nil {
 		return err
@@ -656,7 +862,25 @@ func (lc *LocalClient) PushFile(ctx context.Context, target tailcfg.StableNodeID
 		return nil
 	}
 	all, _ := io.ReadAll(res.Body)
-	return bestError(fmt.Errorf("%s: %s", res.Status, all), all)
+	return &PushFileError{
+		StatusCode: res.StatusCode,
+		err:        bestError(fmt.Errorf("%s: %s", res.Status, all), all),
+	}
+}
+
+// PushFileError is an error returned by the target of PushFile.
+type PushFileError struct {
+	StatusCode int // HTTP status code
+	err        error
+}
+
+func (e *PushFileError) Error() string { return e.err.Error() }
+func (e *PushFileError) Unwrap() error { return e.err }
+
+// Temporary reports whether sending the file again might succeed, such as
+// when the connection to the target broke or the file arrived corrupted.
+func (e *PushFileError) Temporary() bool {
+	return e.StatusCode >= 500 && e.StatusCode != http.StatusNotImplemented
 }
 
 // CheckIPForwarding asks the local Tailscale daemon whether it looks like the
@@ -729,6 +953,22 @@ func (lc *LocalClient) Logout(ctx context.Context) error {
 	return err
 }
 
+// ResetAuth resets tailscaled's authentication state, including its
+// persisted keys, removing all profiles and resetting preferences. It's
+// left with a new profile, ready for StartLoginInteractive to register it
+// as a new node.
+func (lc *LocalClient) ResetAuth(ctx context.Context) error {
+	_, err := lc.send(ctx, "POST", "/localapi/v0/reset-auth", http.StatusNoContent, nil)
+	return err
+}
+
+// SetPushDeviceToken sets the token that the control plane uses to send
+// push notifications to this device, such as on mobile platforms.
+func (lc *LocalClient) SetPushDeviceToken(ctx context.Context, token string) error {
+	_, err := lc.send(ctx, "POST", "/localapi/v0/set-push-device-token", 200, jsonBody(apitype.SetPushDeviceTokenRequest{PushDeviceToken: token}))
+	return err
+}
+
 // SetDNS adds a DNS TXT record for the given domain name, containing
 // the provided TXT value. The intended use case is answering
 // LetsEncrypt/ACME dns-01 challenges.
@@ -751,6 +991,51 @@ func (lc *LocalClient) SetDNS(ctx context.Context, name, value string) error {
 	return err
 }
 
+// FlushDNSCache empties tailscaled's DNS resolver's cache of responses
+// from upstream resolvers.
+func (lc *LocalClient) FlushDNSCache(ctx context.Context) error {
+	_, err := lc.send(ctx, "POST", "/localapi/v0/dns-cache-flush", 204, nil)
+	return err
+}
+
+// DNSOSConfiguratorStatus reports how tailscaled configures the OS's
+// DNS settings, such as with which mechanism.
+func (lc *LocalClient) DNSOSConfiguratorStatus(ctx context.Context) (*dnstype.OSConfiguratorStatus, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/dns-osconfig")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*dnstype.OSConfiguratorStatus](body)
+}
+
+// SSHRecordings returns the Tailscale SSH session recordings that
+// tailscaled keeps on local disk, oldest first. If verify, each finished
+// recording is checked against its hash, with failures reported in its
+// VerifyError.
+func (lc *LocalClient) SSHRecordings(ctx context.Context, verify bool) ([]ipnstate.SSHRecording, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/ssh-recordings?verify="+strconv.FormatBool(verify))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]ipnstate.SSHRecording](body)
+}
+
+// QueryDNS resolves name through tailscaled's DNS resolver, with the
+// search domains, split DNS routes, MagicDNS records and fallback
+// resolvers it'd use for a query from the OS, and returns a trace of
+// which rule matched and which upstream answered. The queryType is a
+// DNS record type such as "A" or "AAAA".
+func (lc *LocalClient) QueryDNS(ctx context.Context, name, queryType string) (*dnstype.QueryTrace, error) {
+	v := url.Values{}
+	v.Set("name", name)
+	v.Set("type", queryType)
+	body, err := lc.get200(ctx, "/localapi/v0/dns-query?"+v.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*dnstype.QueryTrace](body)
+}
+
 // DialTCP connects to the host's port via Tailscale.
 //
 // The host may be a base DNS name (resolved from the netmap inside
@@ -1062,6 +1347,45 @@ func (lc *LocalClient) NetworkLockLog(ctx context.Context, maxEntries int) ([]ip
 	return decodeJSON[[]ipnstate.NetworkLockUpdate](body)
 }
 
+// NetworkLockSignBatch signs each of keys with the node's tailnet lock
+// key. Unless offline, the signatures are also submitted to the control
+// plane; offline signatures can be submitted from another node with
+// NetworkLockSubmitSignatures. Failures to sign individual keys are
+// reported in their results' Error.
+func (lc *LocalClient) NetworkLockSignBatch(ctx context.Context, keys []ipnstate.NetworkLockSigningRequestKey, offline bool) ([]ipnstate.NetworkLockSignature, error) {
+	type signBatchRequest struct {
+		Keys    []ipnstate.NetworkLockSigningRequestKey
+		Offline bool
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/tka/sign-batch", 200, jsonBody(signBatchRequest{Keys: keys, Offline: offline}))
+	if err != nil {
+		return nil, fmt.Errorf("error: %w", err)
+	}
+	return decodeJSON[[]ipnstate.NetworkLockSignature](body)
+}
+
+// NetworkLockSubmitSignatures submits signatures made by another node,
+// such as offline with NetworkLockSignBatch, to the control plane.
+// Failures to submit individual signatures are reported in their
+// results' Error.
+func (lc *LocalClient) NetworkLockSubmitSignatures(ctx context.Context, sigs []ipnstate.NetworkLockSignature) ([]ipnstate.NetworkLockSignature, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/tka/submit-signatures", 200, jsonBody(sigs))
+	if err != nil {
+		return nil, fmt.Errorf("error: %w", err)
+	}
+	return decodeJSON[[]ipnstate.NetworkLockSignature](body)
+}
+
+// NetworkLockSignAudit returns the audit trail of the node key signatures
+// made or submitted by the node, oldest first.
+func (lc *LocalClient) NetworkLockSignAudit(ctx context.Context) ([]ipnstate.NetworkLockSignAuditEntry, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/tka/sign-audit")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]ipnstate.NetworkLockSignAuditEntry](body)
+}
+
 // NetworkLockForceLocalDisable forcibly shuts down network lock on this node.
 func (lc *LocalClient) NetworkLockForceLocalDisable(ctx context.Context) error {
 	// This endpoint expects an empty JSON stanza as the payload.
@@ -1141,6 +1465,69 @@ func (lc *LocalClient) SetServeConfig(ctx context.Context, config *ipn.ServeConf
 	return nil
 }
 
+// GetAppConnectorConfig returns the active app connector configuration,
+// which is nil if the app connector hasn't been configured, and its etag for
+// use with SetAppConnectorConfig.
+func (lc *LocalClient) GetAppConnectorConfig(ctx context.Context) (cfg *appctype.AppConnectorConfig, etag string, err error) {
+	body, h, err := lc.sendWithHeaders(ctx, "GET", "/localapi/v0/app-connector-config", 200, nil, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("getting app connector config: %w", err)
+	}
+	cfg, err = decodeJSON[*appctype.AppConnectorConfig](body)
+	if err != nil {
+		return nil, "", err
+	}
+	return cfg, h.Get("Etag"), nil
+}
+
+// SetAppConnectorConfig atomically replaces the active app connector
+// configuration, returning the etag of the new configuration. If etag is
+// non-empty, the configuration is only replaced if it hasn't changed since
+// GetAppConnectorConfig returned etag; otherwise a PreconditionsFailedError
+// is returned. Configurations with conflicting services are refused.
+func (lc *LocalClient) SetAppConnectorConfig(ctx context.Context, cfg *appctype.AppConnectorConfig, etag string) (newETag string, err error) {
+	h := make(http.Header)
+	if etag != "" {
+		h.Set("If-Match", etag)
+	}
+	_, rh, err := lc.sendWithHeaders(ctx, "POST", "/localapi/v0/app-connector-config", 200, jsonBody(cfg), h)
+	if err != nil {
+		return "", fmt.Errorf("setting app connector config: %w", err)
+	}
+	return rh.Get("Etag"), nil
+}
+
+// CheckAppConnectorConfig returns the services of cfg that listen on
+// overlapping addresses, protocols and ports, without applying it.
+func (lc *LocalClient) CheckAppConnectorConfig(ctx context.Context, cfg *appctype.AppConnectorConfig) ([]appctype.Conflict, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/app-connector-config?dry-run=true", 200, jsonBody(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("checking app connector config: %w", err)
+	}
+	return decodeJSON[[]appctype.Conflict](body)
+}
+
+// AppConnectorStatus returns the status of the services of the node's app
+// connector, including which part of the tailnet policy granted each.
+func (lc *LocalClient) AppConnectorStatus(ctx context.Context) ([]appctype.ServiceStatus, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/app-connector-status")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]appctype.ServiceStatus](body)
+}
+
+// AppConnectorDrains returns the progress of draining the connections of
+// services removed from the app connector configuration, which are closed
+// at their deadlines.
+func (lc *LocalClient) AppConnectorDrains(ctx context.Context) ([]appctype.DrainStatus, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/app-connector-drains")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]appctype.DrainStatus](body)
+}
+
 // NetworkLockDisable shuts down network-lock across the tailnet.
 func (lc *LocalClient) NetworkLockDisable(ctx context.Context, secret []byte) error {
 	if _, err := lc.send(ctx, "POST", "/localapi/v0/tka/disable", 200, bytes.NewReader(secret)); err != nil {
@@ -1168,6 +1555,72 @@ func (lc *LocalClient) GetServeConfig(ctx context.Context) (*ipn.ServeConfig, er
 	return sc, nil
 }
 
+// WatchServeAccessLog subscribes to the serve access log: the records of
+// the HTTP requests served per the serve config, from the tailnet or via
+// Funnel. Records are dropped if the watcher falls far behind.
+//
+// The context is used for the life of the watch, not just the call to
+// WatchServeAccessLog. The returned ServeAccessLogWatcher's Close method
+// must be called when done to release resources.
+func (lc *LocalClient) WatchServeAccessLog(ctx context.Context) (*ServeAccessLogWatcher, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/serve-access-log", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, bestError(fmt.Errorf("HTTP %s: %s", res.Status, body), body)
+	}
+	return &ServeAccessLogWatcher{
+		ctx:     ctx,
+		httpRes: res,
+		dec:     json.NewDecoder(res.Body),
+	}, nil
+}
+
+// ServeAccessLogWatcher is an active subscription to the serve access log.
+// It's returned by LocalClient.WatchServeAccessLog.
+//
+// It must be closed when done.
+type ServeAccessLogWatcher struct {
+	ctx     context.Context // from original WatchServeAccessLog call
+	httpRes *http.Response
+	dec     *json.Decoder
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Close stops the watcher and releases its resources.
+func (w *ServeAccessLogWatcher) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.httpRes.Body.Close()
+}
+
+// Next returns the record of the next served request.
+// If the context from LocalClient.WatchServeAccessLog is done, that error
+// is returned.
+func (w *ServeAccessLogWatcher) Next() (ipn.ServeAccess, error) {
+	var a ipn.ServeAccess
+	if err := w.dec.Decode(&a); err != nil {
+		if cerr := w.ctx.Err(); cerr != nil {
+			err = cerr
+		}
+		return ipn.ServeAccess{}, err
+	}
+	return a, nil
+}
+
 func getServeConfigFromJSON(body []byte) (sc *ipn.ServeConfig, err error) {
 	if err := json.Unmarshal(body, &sc); err != nil {
 		return nil, err
@@ -1277,6 +1730,31 @@ func (lc *LocalClient) SwitchProfile(ctx context.Context, profile ipn.ProfileID)
 	return err
 }
 
+// ExportProfile returns the preferences and non-secret state of the
+// given profile, for importing with ImportProfile, such as on another
+// machine. It has none of the profile's keys.
+func (lc *LocalClient) ExportProfile(ctx context.Context, profile ipn.ProfileID) (*ipn.ProfileExport, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/profiles/"+url.PathEscape(string(profile))+"/export")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipn.ProfileExport](body)
+}
+
+// ImportProfile adds a logged-out profile with the configuration in ex,
+// as returned by ExportProfile, and returns it. If switchTo is true, it
+// also switches to the new profile. The user must then log in with
+// LoginInteractive or an auth key.
+func (lc *LocalClient) ImportProfile(ctx context.Context, ex *ipn.ProfileExport, switchTo bool) (ipn.LoginProfile, error) {
+	v := url.Values{}
+	v.Set("switch", strconv.FormatBool(switchTo))
+	body, err := lc.send(ctx, "POST", "/localapi/v0/profiles/import?"+v.Encode(), http.StatusCreated, jsonBody(ex))
+	if err != nil {
+		return ipn.LoginProfile{}, err
+	}
+	return decodeJSON[ipn.LoginProfile](body)
+}
+
 // DeleteProfile removes the profile with the given ID.
 // If the profile is the current profile, an empty profile
 // will be selected as if SwitchToEmptyProfile was called.
@@ -1285,6 +1763,168 @@ func (lc *LocalClient) DeleteProfile(ctx context.Context, profile ipn.ProfileID)
 	return err
 }
 
+// CreateLocalAPIToken creates a LocalAPI access token with the given
+// scope, for use in LocalClient.AuthToken. The token is only returned
+// this once.
+func (lc *LocalClient) CreateLocalAPIToken(ctx context.Context, name string, scope apitype.LocalAPIScope) (*apitype.CreateLocalAPITokenResponse, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/auth-tokens", 200, jsonBody(apitype.CreateLocalAPITokenRequest{
+		Name:  name,
+		Scope: scope,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.CreateLocalAPITokenResponse](body)
+}
+
+// LocalAPITokens returns the LocalAPI access tokens, without their
+// secrets.
+func (lc *LocalClient) LocalAPITokens(ctx context.Context) ([]apitype.LocalAPIToken, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/auth-tokens")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.LocalAPIToken](body)
+}
+
+// RevokeLocalAPIToken revokes the LocalAPI access token with the given
+// ID.
+func (lc *LocalClient) RevokeLocalAPIToken(ctx context.Context, id string) error {
+	_, err := lc.send(ctx, "DELETE", "/localapi/v0/auth-tokens?id="+url.QueryEscape(id), http.StatusNoContent, nil)
+	return err
+}
+
+// ConnApprovals returns the incoming connections awaiting approval and
+// the decisions made on earlier ones. See ipn.Prefs.ConnApproval.
+func (lc *LocalClient) ConnApprovals(ctx context.Context) (*ipn.ConnApprovals, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/conn-approvals")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipn.ConnApprovals](body)
+}
+
+// DecideConnApproval accepts or rejects a pending incoming connection.
+// The decision also applies to later connections like it.
+func (lc *LocalClient) DecideConnApproval(ctx context.Context, d ipn.ConnApprovalDecision) error {
+	_, err := lc.send(ctx, "POST", "/localapi/v0/conn-approvals", http.StatusNoContent, jsonBody(d))
+	return err
+}
+
+// ForgetConnApprovals deletes the decisions made on incoming connections
+// from the given peer, so that they need approval again.
+func (lc *LocalClient) ForgetConnApprovals(ctx context.Context, peer tailcfg.StableNodeID) error {
+	_, err := lc.send(ctx, "DELETE", "/localapi/v0/conn-approvals?peer="+url.QueryEscape(string(peer)), http.StatusNoContent, nil)
+	return err
+}
+
+// WatchFileOffers subscribes to incoming Taildrop files. While the
+// subscription is open, tailscaled holds each incoming file until it's
+// accepted or rejected with DecideFileOffer, or until it expires.
+//
+// The context is used for the life of the watch, not just the call to
+// WatchFileOffers. The returned FileOfferWatcher's Close method must be
+// called when done to release resources.
+func (lc *LocalClient) WatchFileOffers(ctx context.Context) (*FileOfferWatcher, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/file-offers", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, bestError(fmt.Errorf("HTTP %s: %s", res.Status, body), body)
+	}
+	return &FileOfferWatcher{
+		ctx:     ctx,
+		httpRes: res,
+		dec:     json.NewDecoder(res.Body),
+	}, nil
+}
+
+// FileOfferWatcher is an active subscription to incoming Taildrop files.
+// It's returned by LocalClient.WatchFileOffers.
+//
+// It must be closed when done.
+type FileOfferWatcher struct {
+	ctx     context.Context // from original WatchFileOffers call
+	httpRes *http.Response
+	dec     *json.Decoder
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Close stops the watcher and releases its resources. Files still
+// awaiting a decision are accepted if there are no other watchers.
+func (w *FileOfferWatcher) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.httpRes.Body.Close()
+}
+
+// Next returns the next incoming file awaiting a decision.
+// If the context from LocalClient.WatchFileOffers is done, that error is
+// returned.
+func (w *FileOfferWatcher) Next() (ipn.FileOffer, error) {
+	var o ipn.FileOffer
+	if err := w.dec.Decode(&o); err != nil {
+		if cerr := w.ctx.Err(); cerr != nil {
+			err = cerr
+		}
+		return ipn.FileOffer{}, err
+	}
+	return o, nil
+}
+
+// DecideFileOffer accepts or rejects an incoming Taildrop file announced
+// by a FileOfferWatcher. An accepted file is put in the Taildrop inbox,
+// or at d.Path if set, which requires the caller to run as root or as
+// the same user as tailscaled.
+func (lc *LocalClient) DecideFileOffer(ctx context.Context, d ipn.FileOfferDecision) error {
+	_, err := lc.send(ctx, "POST", "/localapi/v0/file-offers", http.StatusNoContent, jsonBody(d))
+	return err
+}
+
+// ProcessFileOffers decides on incoming Taildrop files with fn until ctx
+// is done, returning the error that stopped it. fn is called with each
+// file awaiting a decision, one at a time; the ID of the decision it
+// returns is set to that of the file.
+func (lc *LocalClient) ProcessFileOffers(ctx context.Context, fn func(ipn.FileOffer) ipn.FileOfferDecision) error {
+	w, err := lc.WatchFileOffers(ctx)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	for {
+		o, err := w.Next()
+		if err != nil {
+			return err
+		}
+		d := fn(o)
+		d.ID = o.ID
+		// A failed decision is otherwise ignored: the file may have
+		// expired or been decided on by another processor.
+		if err := lc.DecideFileOffer(ctx, d); err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// DoctorBundle runs tailscaled's diagnostics and connectivity probes and
+// returns a support bundle of the results, a gzipped tar archive.
+func (lc *LocalClient) DoctorBundle(ctx context.Context) ([]byte, error) {
+	return lc.get200(ctx, "/localapi/v0/doctor-bundle")
+}
+
 // QueryFeature makes a request for instructions on how to enable
 // a feature, such as Funnel, for the node's tailnet. If relevant,
 // this includes a control server URL the user can visit to enable
@@ -1329,7 +1969,67 @@ func (lc *LocalClient) DebugSetExpireIn(ctx context.Context, d time.Duration) er
 // The provided context does not determine the lifetime of the
 // returned io.ReadCloser.
 func (lc *LocalClient) StreamDebugCapture(ctx context.Context) (io.ReadCloser, error) {
-	req, err := http.NewRequestWithContext(ctx, "POST", "http://"+apitype.LocalAPIHost+"/localapi/v0/debug-capture", nil)
+	return lc.StreamDebugCaptureWithOpts(ctx, nil)
+}
+
+// DebugCaptureOpts contains options for StreamDebugCaptureWithOpts.
+type DebugCaptureOpts struct {
+	// Filter, if non-empty, is a tcpdump-style filter expression (such
+	// as "tcp and port 22") selecting the packets to capture. It's
+	// evaluated by tailscaled, so packets that don't match are never
+	// sent to the client.
+	Filter string
+
+	// Format is the capture format: "pcap" (the default) or "pcapng".
+	Format string
+
+	// Snaplen, if positive, is the most bytes of each packet to capture.
+	Snaplen int
+}
+
+// StreamDebugCaptureWithOpts is like StreamDebugCapture, with options.
+//
+// opts can be nil; if so, default values will be used.
+func (lc *LocalClient) StreamDebugCaptureWithOpts(ctx context.Context, opts *DebugCaptureOpts) (io.ReadCloser, error) {
+	vals := make(url.Values)
+	if opts != nil {
+		if opts.Filter != "" {
+			vals.Set("filter", opts.Filter)
+		}
+		if opts.Format != "" {
+			vals.Set("format", opts.Format)
+		}
+		if opts.Snaplen > 0 {
+			vals.Set("snaplen", strconv.Itoa(opts.Snaplen))
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://"+apitype.LocalAPIHost+"/localapi/v0/debug-capture?"+vals.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if len(body) > 0 {
+			return nil, fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(body))
+		}
+		return nil, errors.New(res.Status)
+	}
+	return res.Body, nil
+}
+
+// StreamDebugFlows returns the new flows seen by the packet filter and its
+// verdicts on them, as JSON lines of filter.FlowEvent. The packet filter
+// starts recording them the first time it's called. If follow is true, the
+// stream continues with new flows as they're seen until ctx is done.
+//
+// The caller must close the returned ReadCloser.
+func (lc *LocalClient) StreamDebugFlows(ctx context.Context, follow bool) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/debug-flows?follow="+fmt.Sprint(follow), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1355,8 +2055,20 @@ func (lc *LocalClient) StreamDebugCapture(ctx context.Context) (io.ReadCloser, e
 //
 // A default set of ipn.Notify messages are returned but the set can be modified by mask.
 func (lc *LocalClient) WatchIPNBus(ctx context.Context, mask ipn.NotifyWatchOpt) (*IPNBusWatcher, error) {
+	return lc.WatchIPNBusEvents(ctx, mask, 0)
+}
+
+// WatchIPNBusEvents is like WatchIPNBus, but subscribes to only the
+// types of events in events. Tailscaled drops the other fields of each
+// Notify, and Notify messages with none of those events, before
+// sending them. A zero events subscribes to all events but Health.
+func (lc *LocalClient) WatchIPNBusEvents(ctx context.Context, mask ipn.NotifyWatchOpt, events ipn.NotifyEvent) (*IPNBusWatcher, error) {
+	path := "/localapi/v0/watch-ipn-bus?mask=" + fmt.Sprint(mask)
+	if events != 0 {
+		path += "&events=" + url.QueryEscape(events.String())
+	}
 	req, err := http.NewRequestWithContext(ctx, "GET",
-		"http://"+apitype.LocalAPIHost+"/localapi/v0/watch-ipn-bus?mask="+fmt.Sprint(mask),
+		"http://"+apitype.LocalAPIHost+path,
 		nil)
 	if err != nil {
 		return nil, err
@@ -1413,3 +2125,66 @@ func (w *IPNBusWatcher) Next() (ipn.Notify, error) {
 	}
 	return n, nil
 }
+
+// statusEvents are the IPN bus events after which a StatusWatcher gets
+// the status again.
+const statusEvents = ipn.EventState | ipn.EventPrefs | ipn.EventNetMap | ipn.EventPeerOnline | ipn.EventHealth
+
+// WatchStatus subscribes to changes of tailscaled's status, such as of its
+// state, preferences, peers or health. If peers is false, the statuses
+// don't include the peers, like StatusWithoutPeers.
+//
+// The context is used for the life of the watch, not just the call to
+// WatchStatus. The returned StatusWatcher's Close method must be called
+// when done to release resources.
+func (lc *LocalClient) WatchStatus(ctx context.Context, peers bool) (*StatusWatcher, error) {
+	bus, err := lc.WatchIPNBusEvents(ctx, ipn.NotifyInitialState|ipn.NotifyNoPrivateKeys, statusEvents)
+	if err != nil {
+		return nil, err
+	}
+	w := &StatusWatcher{lc: lc, ctx: ctx, bus: bus}
+	if !peers {
+		w.query = "?peers=false"
+	}
+	return w, nil
+}
+
+// StatusWatcher is an active subscription to changes of tailscaled's
+// status. It's returned by LocalClient.WatchStatus.
+//
+// It must be closed when done.
+type StatusWatcher struct {
+	lc    *LocalClient
+	ctx   context.Context // from original WatchStatus call
+	bus   *IPNBusWatcher
+	query string
+}
+
+// Close stops the watcher and releases its resources.
+func (w *StatusWatcher) Close() error {
+	return w.bus.Close()
+}
+
+// Next returns the status after its next change. The first call returns
+// the current status.
+// If the context from LocalClient.WatchStatus is done, that error is
+// returned.
+func (w *StatusWatcher) Next() (*ipnstate.Status, error) {
+	for {
+		n, err := w.bus.Next()
+		if err != nil {
+			return nil, err
+		}
+		if n.Events()&statusEvents != 0 {
+			break
+		}
+	}
+	st, err := w.lc.status(w.ctx, w.query)
+	if err != nil {
+		if cerr := w.ctx.Err(); cerr != nil {
+			err = cerr
+		}
+		return nil, err
+	}
+	return st, nil
+}
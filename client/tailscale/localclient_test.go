@@ -5,7 +5,23 @@
 
 package tailscale
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
 
 func TestGetServeConfigFromJSON(t *testing.T) {
 	sc, err := getServeConfigFromJSON([]byte("null"))
@@ -25,3 +41,126 @@ func TestGetServeConfigFromJSON(t *testing.T) {
 		t.Errorf("want non-nil TCP for object")
 	}
 }
+
+// newFakeLocalClient returns a LocalClient whose requests are served by h.
+func newFakeLocalClient(t *testing.T, h http.Handler) *LocalClient {
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	return &LocalClient{
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", ts.Listener.Addr().String())
+		},
+	}
+}
+
+func TestLocalClientDebugEndpoints(t *testing.T) {
+	var gotLog struct {
+		Lines  []string
+		Prefix string
+	}
+	var gotToken apitype.SetPushDeviceTokenRequest
+	var resetAuth bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/localapi/v0/debug-packet-filter-rules", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]tailcfg.FilterRule{{SrcIPs: []string{"*"}}})
+	})
+	mux.HandleFunc("/localapi/v0/debug-peer-endpoint-changes", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `[{"What":"`+r.FormValue("ip")+`"}]`)
+	})
+	mux.HandleFunc("/localapi/v0/debug-log", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gotLog)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/localapi/v0/reset-auth", func(w http.ResponseWriter, r *http.Request) {
+		resetAuth = r.Method == "POST"
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/localapi/v0/set-push-device-token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gotToken)
+	})
+	mux.HandleFunc("/localapi/v0/debug-netmap-diff", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no netmap", http.StatusNotFound)
+	})
+	lc := newFakeLocalClient(t, mux)
+	ctx := context.Background()
+
+	rules, err := lc.DebugPacketFilterRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || !slices.Equal(rules[0].SrcIPs, []string{"*"}) {
+		t.Errorf("DebugPacketFilterRules = %+v", rules)
+	}
+	changes, err := lc.DebugPeerEndpointChanges(ctx, netip.MustParseAddr("100.64.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(changes), `[{"What":"100.64.0.1"}]`; got != want {
+		t.Errorf("DebugPeerEndpointChanges = %s; want %s", got, want)
+	}
+	if err := lc.DebugLog(ctx, "test", "one", "two"); err != nil {
+		t.Fatal(err)
+	}
+	if gotLog.Prefix != "test" || !slices.Equal(gotLog.Lines, []string{"one", "two"}) {
+		t.Errorf("DebugLog sent %+v", gotLog)
+	}
+	if err := lc.ResetAuth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !resetAuth {
+		t.Error("ResetAuth didn't POST")
+	}
+	if err := lc.SetPushDeviceToken(ctx, "token"); err != nil {
+		t.Fatal(err)
+	}
+	if gotToken.PushDeviceToken != "token" {
+		t.Errorf("SetPushDeviceToken sent %+v", gotToken)
+	}
+	if _, err := lc.DebugNetMapDiff(ctx); err == nil || !strings.Contains(err.Error(), "no netmap") {
+		t.Errorf("DebugNetMapDiff error = %v; want no netmap", err)
+	}
+}
+
+func TestWatchStatus(t *testing.T) {
+	var statusCalls int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/localapi/v0/watch-ipn-bus", func(w http.ResponseWriter, r *http.Request) {
+		running, starting := ipn.Running, ipn.Starting
+		enc := json.NewEncoder(w)
+		enc.Encode(ipn.Notify{State: &starting})
+		enc.Encode(ipn.Notify{Version: "ignored"})
+		enc.Encode(ipn.Notify{State: &running})
+	})
+	mux.HandleFunc("/localapi/v0/status", func(w http.ResponseWriter, r *http.Request) {
+		statusCalls++
+		if r.FormValue("peers") != "false" {
+			t.Errorf("status query = %q; want peers=false", r.URL.RawQuery)
+		}
+		json.NewEncoder(w).Encode(&ipnstate.Status{BackendState: []string{"Starting", "Running"}[statusCalls-1]})
+	})
+	lc := newFakeLocalClient(t, mux)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := lc.WatchStatus(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for _, want := range []string{"Starting", "Running"} {
+		st, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.BackendState != want {
+			t.Errorf("BackendState = %q; want %q", st.BackendState, want)
+		}
+	}
+	if _, err := w.Next(); err == nil {
+		t.Error("Next succeeded after the end of the stream")
+	}
+	if statusCalls != 2 {
+		t.Errorf("got %d status calls; want 2", statusCalls)
+	}
+}
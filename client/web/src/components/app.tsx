@@ -1,7 +1,12 @@
+import cx from "classnames"
 import React from "react"
 import { Footer, Header, IP, State } from "src/components/legacy"
 import useAuth, { AuthResponse } from "src/hooks/auth"
-import useNodeData, { NodeData } from "src/hooks/node-data"
+import useNodeData, {
+  NodeData,
+  NodeUpdate,
+  PeerData,
+} from "src/hooks/node-data"
 import { ReactComponent as ConnectedDeviceIcon } from "src/icons/connected-device.svg"
 import { ReactComponent as TailscaleIcon } from "src/icons/tailscale-icon.svg"
 import { ReactComponent as TailscaleLogo } from "src/icons/tailscale-logo.svg"
@@ -20,7 +25,7 @@ export default function App() {
 
   return !needsLogin &&
     (data.DebugMode === "login" || data.DebugMode === "full") ? (
-    <WebClient {...data} />
+    <WebClient data={data} updateNode={updateNode} />
   ) : (
     // Legacy client UI
     <div className="py-14">
@@ -34,7 +39,13 @@ export default function App() {
   )
 }
 
-function WebClient(props: NodeData) {
+function WebClient({
+  data,
+  updateNode,
+}: {
+  data: NodeData
+  updateNode: (update: NodeUpdate) => void
+}) {
   const { data: auth, loading: loadingAuth, waitOnAuth } = useAuth()
 
   if (loadingAuth) {
@@ -43,12 +54,12 @@ function WebClient(props: NodeData) {
 
   return (
     <div className="flex flex-col items-center min-w-sm max-w-lg mx-auto py-10">
-      {props.DebugMode === "full" && auth?.ok ? (
-        <ManagementView {...props} />
+      {data.DebugMode === "full" && auth?.ok ? (
+        <ManagementView data={data} updateNode={updateNode} />
       ) : (
-        <ReadonlyView data={props} auth={auth} waitOnAuth={waitOnAuth} />
+        <ReadonlyView data={data} auth={auth} waitOnAuth={waitOnAuth} />
       )}
-      <Footer className="mt-20" licensesURL={props.LicensesURL} />
+      <Footer className="mt-20" licensesURL={data.LicensesURL} />
     </div>
   )
 }
@@ -107,15 +118,21 @@ function ReadonlyView({
   )
 }
 
-function ManagementView(props: NodeData) {
+function ManagementView({
+  data,
+  updateNode,
+}: {
+  data: NodeData
+  updateNode: (update: NodeUpdate) => void
+}) {
   return (
     <div className="px-5">
       <div className="flex justify-between mb-12">
         <TailscaleIcon />
         <div className="flex">
-          <p className="mr-2">{props.Profile.LoginName}</p>
+          <p className="mr-2">{data.Profile.LoginName}</p>
           {/* TODO(sonia): support tagged node profile view more eloquently */}
-          <ProfilePic url={props.Profile.ProfilePicURL} />
+          <ProfilePic url={data.Profile.ProfilePicURL} />
         </div>
       </div>
       <p className="tracking-wide uppercase text-gray-600 pb-3">This device</p>
@@ -123,20 +140,97 @@ function ManagementView(props: NodeData) {
         <div className="flex justify-between items-center text-lg">
           <div className="flex items-center">
             <ConnectedDeviceIcon />
-            <p className="font-medium ml-3">{props.DeviceName}</p>
+            <p className="font-medium ml-3">{data.DeviceName}</p>
           </div>
-          <p className="tracking-widest">{props.IP}</p>
+          <p className="tracking-widest">{data.IP}</p>
         </div>
       </div>
       <p className="text-gray-500 pt-2">
         Tailscale is up and running. You can connect to this device from devices
         in your tailnet by using its name or IP address.
       </p>
-      <button className="button button-blue mt-6">Advertise exit node</button>
+      <button
+        className={cx("button mt-6", {
+          "button-red": data.AdvertiseExitNode,
+          "button-blue": !data.AdvertiseExitNode,
+        })}
+        onClick={() =>
+          updateNode({ AdvertiseExitNode: !data.AdvertiseExitNode })
+        }
+      >
+        {data.AdvertiseExitNode
+          ? "Stop advertising exit node"
+          : "Advertise exit node"}
+      </button>
+      <ExitNodeSelector data={data} updateNode={updateNode} />
+      <PeerList peers={data.Peers || []} />
     </div>
   )
 }
 
+function ExitNodeSelector({
+  data,
+  updateNode,
+}: {
+  data: NodeData
+  updateNode: (update: NodeUpdate) => void
+}) {
+  const exitNodes = data.ExitNodes || []
+  if (exitNodes.length === 0 && !data.ExitNodeID) {
+    return null
+  }
+  return (
+    <>
+      <p className="tracking-wide uppercase text-gray-600 pt-10 pb-3">
+        Exit node
+      </p>
+      <select
+        className="w-full border rounded-md px-3 py-2 bg-white"
+        value={data.ExitNodeID}
+        onChange={(e) => updateNode({ ExitNodeID: e.target.value })}
+      >
+        <option value="">None</option>
+        {exitNodes.map((p) => (
+          <option key={p.ID} value={p.ID} disabled={!p.Online}>
+            {p.Name}
+            {p.Online ? "" : " (offline)"}
+          </option>
+        ))}
+      </select>
+    </>
+  )
+}
+
+function PeerList({ peers }: { peers: PeerData[] }) {
+  if (peers.length === 0) {
+    return null
+  }
+  return (
+    <>
+      <p className="tracking-wide uppercase text-gray-600 pt-10 pb-3">
+        Devices
+      </p>
+      <ul className="-mx-5 border rounded-md bg-white divide-y">
+        {peers.map((p) => (
+          <li key={p.ID} className="flex justify-between px-5 py-3">
+            <div className="flex items-center">
+              <span
+                className={cx("w-2 h-2 rounded-full mr-3", {
+                  "bg-green-500": p.Online,
+                  "bg-gray-300": !p.Online,
+                })}
+              />
+              <p className="font-medium">{p.Name}</p>
+              <p className="text-gray-500 ml-2">{p.OS}</p>
+            </div>
+            <p className="tracking-widest">{p.IP}</p>
+          </li>
+        ))}
+      </ul>
+    </>
+  )
+}
+
 function ProfilePic({ url }: { url: string }) {
   return (
     <div className="relative flex-shrink-0 w-8 h-8 rounded-full overflow-hidden">
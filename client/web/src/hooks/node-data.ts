@@ -17,6 +17,18 @@ export type NodeData = {
   IPNVersion: string
 
   DebugMode: "" | "login" | "full" // empty when not running in any debug mode
+
+  ExitNodeID: string // exit node in use; empty if none
+  ExitNodes: PeerData[] | null // peers offering to be exit nodes
+  Peers: PeerData[] | null
+}
+
+export type PeerData = {
+  ID: string
+  Name: string // short MagicDNS name
+  IP: string
+  OS: string
+  Online: boolean
 }
 
 export type UserProfile = {
@@ -30,6 +42,7 @@ export type NodeUpdate = {
   AdvertiseExitNode?: boolean
   Reauthenticate?: boolean
   ForceLogout?: boolean
+  ExitNodeID?: string // empty string to not use an exit node
 }
 
 // useNodeData returns basic data about the current node.
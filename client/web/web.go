@@ -36,10 +36,33 @@
 	"tailscale.com/version/distro"
 )
 
+// ServerMode specifies the mode of a running web client.
+type ServerMode string
+
+const (
+	// LegacyServerMode serves the web client as "tailscale web" does.
+	// It's secured by limiting the interface it listens on, or on
+	// Synology and QNAP, by the platform's own auth.
+	LegacyServerMode ServerMode = ""
+
+	// ManageServerMode serves the web client to Tailscale peers. All
+	// requests must come over Tailscale, and changes require a browser
+	// session the user has authenticated with the control server.
+	ManageServerMode ServerMode = "manage"
+
+	// LocalManageServerMode is ManageServerMode for requests over
+	// loopback, which are made as this node. The caller must check
+	// they come from a local user allowed to manage the node. If the
+	// node is tagged, there's no tailnet user to authenticate as, so
+	// that check is all the authorization they get.
+	LocalManageServerMode ServerMode = "local-manage"
+)
+
 // Server is the backend server for a Tailscale web client.
 type Server struct {
 	lc      *tailscale.LocalClient
 	timeNow func() time.Time
+	mode    ServerMode
 
 	devMode     bool
 	tsDebugMode string
@@ -121,6 +144,9 @@ func (s *browserSession) expires() time.Time {
 
 // ServerOpts contains options for constructing a new Server.
 type ServerOpts struct {
+	// Mode specifies the mode of the web client server.
+	Mode ServerMode
+
 	DevMode bool
 
 	// CGIMode indicates if the server is running as a CGI script.
@@ -148,11 +174,17 @@ func NewServer(opts ServerOpts) (s *Server, cleanup func()) {
 		lc:         opts.LocalClient,
 		pathPrefix: opts.PathPrefix,
 		timeNow:    opts.TimeNow,
+		mode:       opts.Mode,
 	}
 	if s.timeNow == nil {
 		s.timeNow = time.Now
 	}
 	s.tsDebugMode = s.debugMode()
+	if s.mode != LegacyServerMode {
+		// The manage modes use the Tailscale auth of the "full" debug
+		// mode, which is also what tells the frontend to run it.
+		s.tsDebugMode = "full"
+	}
 	s.assetsHandler, cleanup = assetsHandler(opts.DevMode)
 
 	// Create handler for "/api" requests with CSRF protection.
@@ -222,7 +254,7 @@ func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
 // errors to the ResponseWriter itself.
 func (s *Server) authorizeRequest(w http.ResponseWriter, r *http.Request) (ok bool) {
 	if s.tsDebugMode == "full" { // client using tailscale auth
-		_, err := s.lc.WhoIs(r.Context(), r.RemoteAddr)
+		_, err := s.whoIs(r)
 		switch {
 		case err != nil:
 			// All requests must be made over tailscale.
@@ -234,6 +266,9 @@ func (s *Server) authorizeRequest(w http.ResponseWriter, r *http.Request) (ok bo
 		case r.URL.Path == "/api/auth":
 			// Endpoint for browser to request auth allowed without browser session.
 			return true
+		case strings.HasPrefix(r.URL.Path, "/api/") && s.isLocalTaggedRequest(r):
+			// No browser session possible; see LocalManageServerMode.
+			return true
 		case strings.HasPrefix(r.URL.Path, "/api/"):
 			// All other /api/ endpoints require a valid browser session.
 			//
@@ -318,7 +353,7 @@ func (s *Server) serveLoginAPI(w http.ResponseWriter, r *http.Request) {
 // The WhoIsResponse is always populated, with a non-nil Node and UserProfile,
 // unless getTailscaleBrowserSession reports errNotUsingTailscale.
 func (s *Server) getTailscaleBrowserSession(r *http.Request) (*browserSession, *apitype.WhoIsResponse, error) {
-	whoIs, err := s.lc.WhoIs(r.Context(), r.RemoteAddr)
+	whoIs, err := s.whoIs(r)
 	switch {
 	case err != nil:
 		return nil, nil, errNotUsingTailscale
@@ -362,6 +397,40 @@ func (s *Server) getTailscaleBrowserSession(r *http.Request) (*browserSession, *
 	return session, whoIs, nil
 }
 
+// whoIs returns the Tailscale identity of the request's source: the
+// peer it came from or, in LocalManageServerMode for requests over
+// loopback, this node.
+func (s *Server) whoIs(r *http.Request) (*apitype.WhoIsResponse, error) {
+	if s.mode == LocalManageServerMode {
+		if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil && ap.Addr().IsLoopback() {
+			st, err := s.lc.StatusWithoutPeers(r.Context())
+			if err != nil {
+				return nil, err
+			}
+			if st.Self == nil || len(st.Self.TailscaleIPs) == 0 {
+				return nil, errors.New("this node has no Tailscale IP")
+			}
+			return s.lc.WhoIs(r.Context(), st.Self.TailscaleIPs[0].String())
+		}
+	}
+	return s.lc.WhoIs(r.Context(), r.RemoteAddr)
+}
+
+// isLocalTaggedRequest reports whether r was made over loopback in
+// LocalManageServerMode to a tagged node. Such requests act as the node
+// itself, which can't log in to the control server as a user.
+func (s *Server) isLocalTaggedRequest(r *http.Request) bool {
+	if s.mode != LocalManageServerMode {
+		return false
+	}
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil || !ap.Addr().IsLoopback() {
+		return false
+	}
+	st, err := s.lc.StatusWithoutPeers(r.Context())
+	return err == nil && st.Self != nil && st.Self.IsTagged()
+}
+
 type authResponse struct {
 	OK      bool   `json:"ok"`                // true when user has valid auth session
 	AuthURL string `json:"authUrl,omitempty"` // filled when user has control auth action to take
@@ -376,6 +445,9 @@ func (s *Server) serveTailscaleAuth(w http.ResponseWriter, r *http.Request) {
 
 	session, whois, err := s.getTailscaleBrowserSession(r)
 	switch {
+	case errors.Is(err, errTaggedSource) && s.isLocalTaggedRequest(r):
+		// Authorized by the local user; see LocalManageServerMode.
+		resp = authResponse{OK: true}
 	case err != nil && !errors.Is(err, errNoSession):
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
@@ -543,6 +615,18 @@ type nodeData struct {
 	UnraidToken       string
 	IPNVersion        string
 	DebugMode         string // empty when not running in any debug mode
+
+	ExitNodeID tailcfg.StableNodeID // exit node in use; empty if none
+	ExitNodes  []peerData           // peers offering to be exit nodes
+	Peers      []peerData
+}
+
+type peerData struct {
+	ID     tailcfg.StableNodeID
+	Name   string // short MagicDNS name
+	IP     string
+	OS     string
+	Online bool
 }
 
 func (s *Server) serveGetNodeData(w http.ResponseWriter, r *http.Request) {
@@ -585,6 +669,28 @@ func (s *Server) serveGetNodeData(w http.ResponseWriter, r *http.Request) {
 	if len(st.TailscaleIPs) != 0 {
 		data.IP = st.TailscaleIPs[0].String()
 	}
+	data.ExitNodeID = prefs.ExitNodeID
+	for _, p := range st.Peer {
+		pd := peerData{
+			ID:     p.ID,
+			Name:   strings.Split(p.DNSName, ".")[0],
+			OS:     p.OS,
+			Online: p.Online,
+		}
+		if pd.Name == "" {
+			pd.Name = p.HostName
+		}
+		if len(p.TailscaleIPs) != 0 {
+			pd.IP = p.TailscaleIPs[0].String()
+		}
+		data.Peers = append(data.Peers, pd)
+		if p.ExitNodeOption {
+			data.ExitNodes = append(data.ExitNodes, pd)
+		}
+	}
+	byName := func(a, b peerData) int { return strings.Compare(a.Name, b.Name) }
+	slices.SortFunc(data.Peers, byName)
+	slices.SortFunc(data.ExitNodes, byName)
 	if err := json.NewEncoder(w).Encode(*data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -597,6 +703,10 @@ type nodeUpdate struct {
 	AdvertiseExitNode bool
 	Reauthenticate    bool
 	ForceLogout       bool
+
+	// ExitNodeID, if non-nil, is the exit node to use, or the empty
+	// string to not use one.
+	ExitNodeID *tailcfg.StableNodeID
 }
 
 func (s *Server) servePostNodeUpdate(w http.ResponseWriter, r *http.Request) {
@@ -644,6 +754,10 @@ func (s *Server) servePostNodeUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 	mp.Prefs.WantRunning = true
 	mp.Prefs.AdvertiseRoutes = routes
+	if postData.ExitNodeID != nil {
+		mp.ExitNodeIDSet = true
+		mp.Prefs.ExitNodeID = *postData.ExitNodeID
+	}
 	log.Printf("Doing edit: %v", mp.Pretty())
 
 	if _, err := s.lc.EditPrefs(r.Context(), mp); err != nil {
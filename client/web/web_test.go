@@ -10,6 +10,7 @@
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"net/url"
 	"strings"
 	"testing"
@@ -18,9 +19,11 @@
 	"github.com/google/go-cmp/cmp"
 	"tailscale.com/client/tailscale"
 	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/memnet"
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
 	"tailscale.com/types/views"
 	"tailscale.com/util/httpm"
 )
@@ -139,6 +142,58 @@ func TestServeAPI(t *testing.T) {
 	}
 }
 
+func TestServeGetNodeDataPeers(t *testing.T) {
+	lal := memnet.Listen("local-tailscaled.sock:80")
+	defer lal.Close()
+	localapi := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/localapi/v0/status":
+			json.NewEncoder(w).Encode(ipnstate.Status{
+				Self: &ipnstate.PeerStatus{DNSName: "self.example.ts.net."},
+				Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+					key.NewNode().Public(): {
+						ID:             "n2",
+						DNSName:        "nas.example.ts.net.",
+						TailscaleIPs:   []netip.Addr{netip.MustParseAddr("100.64.0.2")},
+						Online:         true,
+						ExitNodeOption: true,
+					},
+					key.NewNode().Public(): {
+						ID:       "n1",
+						HostName: "laptop",
+						OS:       "linux",
+					},
+				},
+			})
+		case "/localapi/v0/prefs":
+			json.NewEncoder(w).Encode(ipn.Prefs{ExitNodeID: "n2"})
+		default:
+			http.Error(w, "unexpected "+r.URL.Path, http.StatusNotFound)
+		}
+	})}
+	defer localapi.Close()
+	go localapi.Serve(lal)
+	s := &Server{lc: &tailscale.LocalClient{Dial: lal.Dial}}
+
+	w := httptest.NewRecorder()
+	s.serveGetNodeData(w, httptest.NewRequest("GET", "/api/data", nil))
+	var got nodeData
+	if err := json.NewDecoder(w.Result().Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	nas := peerData{ID: "n2", Name: "nas", IP: "100.64.0.2", Online: true}
+	laptop := peerData{ID: "n1", Name: "laptop", OS: "linux"}
+	if got.ExitNodeID != "n2" {
+		t.Errorf("ExitNodeID = %q; want n2", got.ExitNodeID)
+	}
+	if diff := cmp.Diff([]peerData{laptop, nas}, got.Peers); diff != "" {
+		t.Errorf("Peers wrong (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]peerData{nas}, got.ExitNodes); diff != "" {
+		t.Errorf("ExitNodes wrong (-want +got):\n%s", diff)
+	}
+}
+
 func TestGetTailscaleBrowserSession(t *testing.T) {
 	userA := &tailcfg.UserProfile{ID: tailcfg.UserID(1)}
 	userB := &tailcfg.UserProfile{ID: tailcfg.UserID(2)}
@@ -626,6 +681,150 @@ func() *ipnstate.PeerStatus { return self },
 	testAuthPathError   = "/a/will-error"
 )
 
+// TestAuthorizeRequestLocalManage tests s.authorizeRequest in
+// LocalManageServerMode, where requests over loopback are made as the
+// node itself.
+func TestAuthorizeRequestLocalManage(t *testing.T) {
+	user := &tailcfg.UserProfile{ID: tailcfg.UserID(1)}
+	selfIP := "100.100.100.100"
+	self := &ipnstate.PeerStatus{
+		ID:           "self",
+		UserID:       user.ID,
+		TailscaleIPs: []netip.Addr{netip.MustParseAddr(selfIP)},
+	}
+	selfNode := &apitype.WhoIsResponse{Node: &tailcfg.Node{ID: 1, StableID: "self"}, UserProfile: user}
+
+	lal := memnet.Listen("local-tailscaled.sock:80")
+	defer lal.Close()
+	localapi := mockLocalAPI(t,
+		map[string]*apitype.WhoIsResponse{selfIP: selfNode},
+		func() *ipnstate.PeerStatus { return self },
+	)
+	defer localapi.Close()
+	go localapi.Serve(lal)
+
+	s := &Server{
+		lc:          &tailscale.LocalClient{Dial: lal.Dial},
+		mode:        LocalManageServerMode,
+		tsDebugMode: "full",
+	}
+	validCookie := "ts-cookie"
+	s.browserSessions.Store(validCookie, &browserSession{
+		ID:            validCookie,
+		SrcNode:       selfNode.Node.ID,
+		SrcUser:       user.ID,
+		Created:       time.Now(),
+		Authenticated: true,
+	})
+
+	tests := []struct {
+		reqPath   string
+		reqMethod string
+
+		wantOkWithoutSession bool
+		wantOkWithSession    bool
+	}{{
+		reqPath:              "/api/data",
+		reqMethod:            httpm.GET,
+		wantOkWithoutSession: true,
+		wantOkWithSession:    true,
+	}, {
+		reqPath:              "/api/data",
+		reqMethod:            httpm.POST,
+		wantOkWithoutSession: false,
+		wantOkWithSession:    true,
+	}, {
+		reqPath:              "/api/local/v0/logout",
+		reqMethod:            httpm.POST,
+		wantOkWithoutSession: false,
+		wantOkWithSession:    true,
+	}, {
+		reqPath:              "/api/auth",
+		reqMethod:            httpm.GET,
+		wantOkWithoutSession: true,
+		wantOkWithSession:    true,
+	}}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s-%s", tt.reqMethod, tt.reqPath), func(t *testing.T) {
+			doAuthorize := func(remoteAddr string, cookie string) bool {
+				r := httptest.NewRequest(tt.reqMethod, tt.reqPath, nil)
+				r.RemoteAddr = remoteAddr
+				if cookie != "" {
+					r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: cookie})
+				}
+				w := httptest.NewRecorder()
+				return s.authorizeRequest(w, r)
+			}
+			if doAuthorize("123.45.67.89:1234", validCookie) {
+				t.Error("request from non-loopback, non-Tailscale IP allowed")
+			}
+			if gotOk := doAuthorize("127.0.0.1:1234", ""); gotOk != tt.wantOkWithoutSession {
+				t.Errorf("wantOkWithoutSession; want=%v, got=%v", tt.wantOkWithoutSession, gotOk)
+			}
+			if gotOk := doAuthorize("127.0.0.1:1234", validCookie); gotOk != tt.wantOkWithSession {
+				t.Errorf("wantOkWithSession; want=%v, got=%v", tt.wantOkWithSession, gotOk)
+			}
+		})
+	}
+}
+
+// TestLocalManageTagged tests that on a tagged node, which has no user
+// to authenticate as, LocalManageServerMode authorizes requests over
+// loopback without a browser session, leaving it to the caller's check
+// of the local user making them.
+func TestLocalManageTagged(t *testing.T) {
+	selfIP := "100.100.100.100"
+	tags := views.SliceOf([]string{"tag:server"})
+	self := &ipnstate.PeerStatus{
+		ID:           "self",
+		Tags:         &tags,
+		TailscaleIPs: []netip.Addr{netip.MustParseAddr(selfIP)},
+	}
+	selfNode := &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{ID: 1, StableID: "self", Tags: tags.AsSlice()},
+		UserProfile: &tailcfg.UserProfile{ID: tailcfg.UserID(1)},
+	}
+
+	lal := memnet.Listen("local-tailscaled.sock:80")
+	defer lal.Close()
+	localapi := mockLocalAPI(t,
+		map[string]*apitype.WhoIsResponse{selfIP: selfNode},
+		func() *ipnstate.PeerStatus { return self },
+	)
+	defer localapi.Close()
+	go localapi.Serve(lal)
+
+	for _, mode := range []ServerMode{ManageServerMode, LocalManageServerMode} {
+		s := &Server{
+			lc:          &tailscale.LocalClient{Dial: lal.Dial},
+			mode:        mode,
+			tsDebugMode: "full",
+		}
+		want := mode == LocalManageServerMode
+
+		r := httptest.NewRequest(httpm.POST, "/api/data", nil)
+		r.RemoteAddr = "127.0.0.1:1234"
+		if got := s.authorizeRequest(httptest.NewRecorder(), r); got != want {
+			t.Errorf("%q mode: authorizeRequest = %v; want %v", mode, got, want)
+		}
+
+		if mode != LocalManageServerMode {
+			continue
+		}
+		r = httptest.NewRequest(httpm.GET, "/api/auth", nil)
+		r.RemoteAddr = "127.0.0.1:1234"
+		w := httptest.NewRecorder()
+		s.serveTailscaleAuth(w, r)
+		var resp authResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("auth response %q: %v", w.Body.String(), err)
+		}
+		if !resp.OK {
+			t.Errorf("auth response = %+v; want OK", resp)
+		}
+	}
+}
+
 // mockLocalAPI constructs a test localapi handler that can be used
 // to simulate localapi responses without a functioning tailnet.
 //
@@ -4,14 +4,22 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"golang.org/x/crypto/acme/autocert"
 )
 
@@ -29,6 +37,13 @@ type certProvider interface {
 	HTTPHandler(fallback http.Handler) http.Handler
 }
 
+// certRunner is implemented by certProviders that reload or renew their
+// certs in the background.
+type certRunner interface {
+	// run does so until ctx is done.
+	run(ctx context.Context)
+}
+
 func certProviderByCertMode(mode, dir, hostname string) (certProvider, error) {
 	if dir == "" {
 		return nil, errors.New("missing required --certdir flag")
@@ -47,34 +62,106 @@ func certProviderByCertMode(mode, dir, hostname string) (certProvider, error) {
 		return certManager, nil
 	case "manual":
 		return NewManualCertManager(dir, hostname)
+	case "dns":
+		return newDNSCertManager(dir, hostname)
 	default:
 		return nil, fmt.Errorf("unsupport cert mode: %q", mode)
 	}
 }
 
 type manualCertManager struct {
-	cert     *tls.Certificate
+	cert     atomic.Pointer[tls.Certificate]
 	hostname string
+	crtPath  string
+	keyPath  string
 }
 
 // NewManualCertManager returns a cert provider which read certificate by given hostname on create.
 func NewManualCertManager(certdir, hostname string) (certProvider, error) {
+	return newManualCertManager(certdir, hostname)
+}
+
+func newManualCertManager(certdir, hostname string) (*manualCertManager, error) {
 	keyname := unsafeHostnameCharacters.ReplaceAllString(hostname, "")
-	crtPath := filepath.Join(certdir, keyname+".crt")
-	keyPath := filepath.Join(certdir, keyname+".key")
-	cert, err := tls.LoadX509KeyPair(crtPath, keyPath)
+	m := &manualCertManager{
+		hostname: hostname,
+		crtPath:  filepath.Join(certdir, keyname+".crt"),
+		keyPath:  filepath.Join(certdir, keyname+".key"),
+	}
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// reload loads the certificate from disk, replacing the one being
+// served. If it fails, the old one is still served.
+func (m *manualCertManager) reload() error {
+	cert, err := tls.LoadX509KeyPair(m.crtPath, m.keyPath)
 	if err != nil {
-		return nil, fmt.Errorf("can not load x509 key pair for hostname %q: %w", keyname, err)
+		return fmt.Errorf("can not load x509 key pair for hostname %q: %w", m.hostname, err)
 	}
 	// ensure hostname matches with the certificate
 	x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
 	if err != nil {
-		return nil, fmt.Errorf("can not load cert: %w", err)
+		return fmt.Errorf("can not load cert: %w", err)
+	}
+	if err := x509Cert.VerifyHostname(m.hostname); err != nil {
+		return fmt.Errorf("cert invalid for hostname %q: %w", m.hostname, err)
 	}
-	if err := x509Cert.VerifyHostname(hostname); err != nil {
-		return nil, fmt.Errorf("cert invalid for hostname %q: %w", hostname, err)
+	cert.Leaf = x509Cert
+	m.cert.Store(&cert)
+	return nil
+}
+
+// run reloads the certificate when its files change or derper gets a
+// SIGHUP, until ctx is done.
+func (m *manualCertManager) run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var events <-chan fsnotify.Event
+	if w, err := fsnotify.NewWatcher(); err != nil {
+		log.Printf("cert: not watching for changes: %v", err)
+	} else if err := w.Add(filepath.Dir(m.crtPath)); err != nil {
+		// Watch the directory, not the files, as they may be
+		// replaced by renames.
+		log.Printf("cert: not watching for changes: %v", err)
+		w.Close()
+	} else {
+		defer w.Close()
+		events = w.Events
+	}
+
+	// The cert and key files are usually written one after the other;
+	// wait for both.
+	const settle = time.Second
+	reloadTimer := time.NewTimer(0)
+	<-reloadTimer.C
+	for {
+		select {
+		case <-ctx.Done():
+			reloadTimer.Stop()
+			return
+		case <-hup:
+			m.logReload("SIGHUP")
+		case ev := <-events:
+			if ev.Name == m.crtPath || ev.Name == m.keyPath {
+				reloadTimer.Reset(settle)
+			}
+		case <-reloadTimer.C:
+			m.logReload("change on disk")
+		}
+	}
+}
+
+func (m *manualCertManager) logReload(why string) {
+	if err := m.reload(); err != nil {
+		log.Printf("cert: reload on %s failed, still serving old cert: %v", why, err)
+		return
 	}
-	return &manualCertManager{cert: &cert, hostname: hostname}, nil
+	log.Printf("cert: reloaded on %s; expires %v", why, m.cert.Load().Leaf.NotAfter)
 }
 
 func (m *manualCertManager) TLSConfig() *tls.Config {
@@ -95,7 +182,7 @@ func (m *manualCertManager) getCertificate(hi *tls.ClientHelloInfo) (*tls.Certif
 	// Return a shallow copy of the cert so the caller can append to its
 	// Certificate field.
 	certCopy := new(tls.Certificate)
-	*certCopy = *m.cert
+	*certCopy = *m.cert.Load()
 	certCopy.Certificate = certCopy.Certificate[:len(certCopy.Certificate):len(certCopy.Certificate)]
 	return certCopy, nil
 }
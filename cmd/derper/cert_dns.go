@@ -0,0 +1,296 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"tailscale.com/atomicfile"
+)
+
+const (
+	// dnsCertRenewBefore is how long before its expiry a --certmode=dns
+	// cert is renewed.
+	dnsCertRenewBefore = 30 * 24 * time.Hour
+
+	// dnsCertCheckInterval is how often it's checked, and how soon a
+	// failed renewal is retried.
+	dnsCertCheckInterval = 12 * time.Hour
+
+	// dnsCertIssueTimeout bounds issuing a cert.
+	dnsCertIssueTimeout = 10 * time.Minute
+)
+
+// dnsProvider publishes the TXT records of ACME DNS-01 challenges, for
+// --certmode=dns.
+type dnsProvider interface {
+	// Present publishes a TXT record at fqdn with value. It must not
+	// return until the record is visible to the ACME server.
+	Present(ctx context.Context, fqdn, value string) error
+
+	// CleanUp removes the record added by Present.
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// dnsProviderFunc returns the dnsProvider for --certmode=dns. Builds of
+// derper with a DNS provider built in can replace it.
+var dnsProviderFunc = func() (dnsProvider, error) {
+	if *acmeDNSHook == "" {
+		return nil, errors.New("--certmode=dns requires --acme-dns-hook")
+	}
+	return execDNSProvider(*acmeDNSHook), nil
+}
+
+// execDNSProvider is a dnsProvider that runs the program at its path as
+// "path present|cleanup <fqdn> <value>".
+type execDNSProvider string
+
+func (p execDNSProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "present", fqdn, value)
+}
+
+func (p execDNSProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "cleanup", fqdn, value)
+}
+
+func (p execDNSProvider) run(ctx context.Context, args ...string) error {
+	out, err := exec.CommandContext(ctx, string(p), args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %s", p, args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// dnsCertManager is a certProvider that gets its cert from an ACME CA
+// using DNS-01 challenges, so the server needn't be reachable from the
+// internet to get one. It stores the cert where --certmode=manual would
+// read it from.
+type dnsCertManager struct {
+	*manualCertManager
+	client *acme.Client
+	dns    dnsProvider
+}
+
+func newDNSCertManager(dir, hostname string) (*dnsCertManager, error) {
+	if strings.HasPrefix(hostname, "*.") {
+		return nil, fmt.Errorf("--certmode=dns can't serve wildcard hostname %q", hostname)
+	}
+	dns, err := dnsProviderFunc()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	accountKey, err := loadACMEAccountKey(filepath.Join(dir, "acme_account.key"))
+	if err != nil {
+		return nil, err
+	}
+	m := &dnsCertManager{
+		client: &acme.Client{Key: accountKey, DirectoryURL: *acmeDirectory},
+		dns:    dns,
+	}
+
+	// Get a cert before serving, if there's no usable one already.
+	m.manualCertManager, err = newManualCertManager(dir, hostname)
+	if err == nil && !needsRenewal(m.cert.Load().Leaf, time.Now()) {
+		return m, nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("cert: replacing unusable cert: %v", err)
+	}
+	if m.manualCertManager == nil {
+		keyname := unsafeHostnameCharacters.ReplaceAllString(hostname, "")
+		m.manualCertManager = &manualCertManager{
+			hostname: hostname,
+			crtPath:  filepath.Join(dir, keyname+".crt"),
+			keyPath:  filepath.Join(dir, keyname+".key"),
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dnsCertIssueTimeout)
+	defer cancel()
+	if err := m.issue(ctx); err != nil {
+		if m.cert.Load() == nil {
+			return nil, err
+		}
+		log.Printf("cert: renewal failed, serving old cert: %v", err)
+	}
+	return m, nil
+}
+
+// needsRenewal reports whether cert expires within dnsCertRenewBefore
+// of now.
+func needsRenewal(cert *x509.Certificate, now time.Time) bool {
+	return now.Add(dnsCertRenewBefore).After(cert.NotAfter)
+}
+
+// run reloads the cert like --certmode=manual and renews it before it
+// expires, until ctx is done.
+func (m *dnsCertManager) run(ctx context.Context) {
+	go m.manualCertManager.run(ctx)
+	t := time.NewTicker(dnsCertCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if !needsRenewal(m.cert.Load().Leaf, time.Now()) {
+			continue
+		}
+		ictx, cancel := context.WithTimeout(ctx, dnsCertIssueTimeout)
+		err := m.issue(ictx)
+		cancel()
+		if err != nil {
+			log.Printf("cert: renewal failed, retrying in %v: %v", dnsCertCheckInterval, err)
+		}
+	}
+}
+
+// issue gets a new cert for m.hostname from the ACME CA, writes it to
+// disk and serves it.
+func (m *dnsCertManager) issue(ctx context.Context) error {
+	log.Printf("cert: getting a cert for %q from %s", m.hostname, m.client.DirectoryURL)
+	if _, err := m.client.Register(ctx, &acme.Account{}, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return fmt.Errorf("ACME register: %w", err)
+	}
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(m.hostname))
+	if err != nil {
+		return fmt.Errorf("ACME order: %w", err)
+	}
+	for _, u := range order.AuthzURLs {
+		if err := m.authorize(ctx, u); err != nil {
+			return err
+		}
+	}
+	order, err = m.client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return fmt.Errorf("ACME order: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames: []string{m.hostname},
+	}, key)
+	if err != nil {
+		return err
+	}
+	der, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("ACME finalize: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	var crtPEM []byte
+	for _, b := range der {
+		crtPEM = append(crtPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})...)
+	}
+	if err := atomicfile.WriteFile(m.keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(m.crtPath, crtPEM, 0644); err != nil {
+		return err
+	}
+	if err := m.reload(); err != nil {
+		return err
+	}
+	log.Printf("cert: got a cert for %q; expires %v", m.hostname, m.cert.Load().Leaf.NotAfter)
+	return nil
+}
+
+// authorize completes the DNS-01 challenge of the ACME authorization at
+// authzURL, if it's not already valid.
+func (m *dnsCertManager) authorize(ctx context.Context, authzURL string) error {
+	z, err := m.client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return fmt.Errorf("ACME authorization: %w", err)
+	}
+	if z.Status == acme.StatusValid {
+		return nil
+	}
+	var chal *acme.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("ACME CA offered no dns-01 challenge for %q", z.Identifier.Value)
+	}
+	value, err := m.client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	fqdn := "_acme-challenge." + z.Identifier.Value
+	if err := m.dns.Present(ctx, fqdn, value); err != nil {
+		return fmt.Errorf("publishing DNS-01 challenge: %w", err)
+	}
+	defer func() {
+		if err := m.dns.CleanUp(context.Background(), fqdn, value); err != nil {
+			log.Printf("cert: cleaning up DNS-01 challenge: %v", err)
+		}
+	}()
+	if _, err := m.client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("ACME accept: %w", err)
+	}
+	if _, err := m.client.WaitAuthorization(ctx, z.URI); err != nil {
+		return fmt.Errorf("ACME authorization of %q: %w", z.Identifier.Value, err)
+	}
+	return nil
+}
+
+// loadACMEAccountKey loads the ACME account key at path, creating it if
+// it doesn't exist.
+func loadACMEAccountKey(path string) (crypto.Signer, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := atomicfile.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,149 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed cert for hostname, with serial
+// number serial, where --certmode=manual reads it from in dir.
+func writeTestCert(t *testing.T, dir, hostname string, serial int64) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: hostname},
+		DNSNames:     []string{hostname},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, hostname+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, hostname+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManualCertReload(t *testing.T) {
+	const hostname = "derp.example.com"
+	dir := t.TempDir()
+	writeTestCert(t, dir, hostname, 1)
+	m, err := newManualCertManager(dir, hostname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := func() int64 {
+		t.Helper()
+		cert, err := m.getCertificate(&tls.ClientHelloInfo{ServerName: hostname})
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return x.SerialNumber.Int64()
+	}
+	if got := serial(); got != 1 {
+		t.Fatalf("serving serial %d; want 1", got)
+	}
+
+	writeTestCert(t, dir, hostname, 2)
+	if err := m.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := serial(); got != 2 {
+		t.Errorf("after reload, serving serial %d; want 2", got)
+	}
+
+	writeTestCert(t, dir, "other.example.com", 3)
+	os.Rename(filepath.Join(dir, "other.example.com.crt"), filepath.Join(dir, hostname+".crt"))
+	os.Rename(filepath.Join(dir, "other.example.com.key"), filepath.Join(dir, hostname+".key"))
+	if err := m.reload(); err == nil {
+		t.Errorf("reloaded a cert for the wrong hostname")
+	}
+	if got := serial(); got != 2 {
+		t.Errorf("after failed reload, serving serial %d; want 2", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.run(ctx)
+	time.Sleep(100 * time.Millisecond) // let it start watching
+	writeTestCert(t, dir, hostname, 4)
+	for deadline := time.Now().Add(10 * time.Second); serial() != 4; {
+		if time.Now().After(deadline) {
+			t.Fatalf("cert not reloaded on change")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestExecDNSProvider(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+	hook := filepath.Join(dir, "hook")
+	if err := os.WriteFile(hook, []byte("#!/bin/sh\necho \"$@\" >> "+log+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	p := execDNSProvider(hook)
+	ctx := context.Background()
+	if err := p.Present(ctx, "_acme-challenge.derp.example.com", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CleanUp(ctx, "_acme-challenge.derp.example.com", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "present _acme-challenge.derp.example.com abc\ncleanup _acme-challenge.derp.example.com abc\n"
+	if string(got) != want {
+		t.Errorf("hook ran as:\n%s\nwant:\n%s", got, want)
+	}
+
+	if err := execDNSProvider(filepath.Join(dir, "missing")).Present(ctx, "x", "y"); err == nil {
+		t.Errorf("missing hook succeeded")
+	}
+}
+
+func TestNeedsRenewal(t *testing.T) {
+	now := time.Now()
+	if needsRenewal(&x509.Certificate{NotAfter: now.Add(60 * 24 * time.Hour)}, now) {
+		t.Errorf("cert with 60 days left needs renewal")
+	}
+	if !needsRenewal(&x509.Certificate{NotAfter: now.Add(10 * 24 * time.Hour)}, now) {
+		t.Errorf("cert with 10 days left doesn't need renewal")
+	}
+}
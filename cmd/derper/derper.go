@@ -25,6 +25,7 @@
 	"time"
 
 	"go4.org/mem"
+	"golang.org/x/crypto/acme"
 	"golang.org/x/time/rate"
 	"tailscale.com/atomicfile"
 	"tailscale.com/derp"
@@ -32,21 +33,24 @@
 	"tailscale.com/metrics"
 	"tailscale.com/net/stun"
 	"tailscale.com/tsweb"
+	"tailscale.com/tsweb/varz"
 	"tailscale.com/types/key"
 	"tailscale.com/util/cmpx"
 )
 
 var (
-	dev        = flag.Bool("dev", false, "run in localhost development mode (overrides -a)")
-	addr       = flag.String("a", ":443", "server HTTP/HTTPS listen address, in form \":port\", \"ip:port\", or for IPv6 \"[ip]:port\". If the IP is omitted, it defaults to all interfaces. Serves HTTPS if the port is 443 and/or -certmode is manual, otherwise HTTP.")
-	httpPort   = flag.Int("http-port", 80, "The port on which to serve HTTP. Set to -1 to disable. The listener is bound to the same IP (if any) as specified in the -a flag.")
-	stunPort   = flag.Int("stun-port", 3478, "The UDP port on which to serve STUN. The listener is bound to the same IP (if any) as specified in the -a flag.")
-	configPath = flag.String("c", "", "config file path")
-	certMode   = flag.String("certmode", "letsencrypt", "mode for getting a cert. possible options: manual, letsencrypt")
-	certDir    = flag.String("certdir", tsweb.DefaultCertDir("derper-certs"), "directory to store LetsEncrypt certs, if addr's port is :443")
-	hostname   = flag.String("hostname", "derp.tailscale.com", "LetsEncrypt host name, if addr's port is :443")
-	runSTUN    = flag.Bool("stun", true, "whether to run a STUN server. It will bind to the same IP (if any) as the --addr flag value.")
-	runDERP    = flag.Bool("derp", true, "whether to run a DERP server. The only reason to set this false is if you're decommissioning a server but want to keep its bootstrap DNS functionality still running.")
+	dev           = flag.Bool("dev", false, "run in localhost development mode (overrides -a)")
+	addr          = flag.String("a", ":443", "server HTTP/HTTPS listen address, in form \":port\", \"ip:port\", or for IPv6 \"[ip]:port\". If the IP is omitted, it defaults to all interfaces. Serves HTTPS if the port is 443 and/or -certmode is manual or dns, otherwise HTTP.")
+	httpPort      = flag.Int("http-port", 80, "The port on which to serve HTTP. Set to -1 to disable. The listener is bound to the same IP (if any) as specified in the -a flag.")
+	stunPort      = flag.Int("stun-port", 3478, "The UDP port on which to serve STUN. The listener is bound to the same IP (if any) as specified in the -a flag.")
+	configPath    = flag.String("c", "", "config file path")
+	certMode      = flag.String("certmode", "letsencrypt", "mode for getting a cert. possible options: manual, letsencrypt, dns. With manual, the cert is reloaded when its files in -certdir change or on SIGHUP. With dns, it's obtained and renewed from -acme-directory using DNS-01 challenges, for servers not reachable from the internet.")
+	certDir       = flag.String("certdir", tsweb.DefaultCertDir("derper-certs"), "directory to store LetsEncrypt certs, if addr's port is :443")
+	hostname      = flag.String("hostname", "derp.tailscale.com", "LetsEncrypt host name, if addr's port is :443")
+	acmeDirectory = flag.String("acme-directory", acme.LetsEncryptURL, "for -certmode=dns, the ACME directory URL of the CA to get the cert from")
+	acmeDNSHook   = flag.String("acme-dns-hook", "", "for -certmode=dns, path to a program run as \"hook present|cleanup <fqdn> <value>\" to add and remove the TXT records of DNS-01 challenges; present must not exit until the record is visible to the CA")
+	runSTUN       = flag.Bool("stun", true, "whether to run a STUN server. It will bind to the same IP (if any) as the --addr flag value.")
+	runDERP       = flag.Bool("derp", true, "whether to run a DERP server. The only reason to set this false is if you're decommissioning a server but want to keep its bootstrap DNS functionality still running.")
 
 	meshPSKFile    = flag.String("mesh-psk-file", defaultMeshPSKFile(), "if non-empty, path to file containing the mesh pre-shared key file. It should contain some hex string; whitespace is trimmed.")
 	meshWith       = flag.String("mesh-with", "", "optional comma-separated list of hostnames to mesh with; the server's own hostname can be in the list")
@@ -56,6 +60,16 @@
 
 	acceptConnLimit = flag.Float64("accept-connection-limit", math.Inf(+1), "rate limit for accepting new connection")
 	acceptConnBurst = flag.Int("accept-connection-burst", math.MaxInt, "burst limit for accepting new connection")
+
+	clientPacketsPerSec = flag.Int("client-packets-per-sec", 0, "if non-zero, rate limit on the packets per second each client can send")
+	clientBytesPerSec   = flag.Int("client-bytes-per-sec", 0, "if non-zero, rate limit on the bytes per second each client can send")
+	regionPacketsPerSec = flag.Int("region-packets-per-sec", 0, "if non-zero, rate limit on the packets per second all clients of this server can send together")
+	regionBytesPerSec   = flag.Int("region-bytes-per-sec", 0, "if non-zero, rate limit on the bytes per second all clients of this server can send together")
+
+	perClientMetrics = flag.Bool("per-client-metrics", false, "whether /metrics includes per-client-key byte and packet counters, one series per connected client; /debug/clients has them regardless")
+
+	federationConfigPath = flag.String("federation-config", "", "if non-empty, path to a JSON file configuring federation with DERP servers run by other organizations: this organization's Operator name, the Region to offer at /derp/federation, the Allow list of other organizations' signers, and the Peers URLs of their /derp/federation endpoints")
+	federationKeyPath    = flag.String("federation-key-file", "", "path to this organization's federation signing key, created if it doesn't exist; required if --federation-config offers a Region")
 )
 
 var (
@@ -148,10 +162,16 @@ func main() {
 
 	cfg := loadConfig()
 
-	serveTLS := tsweb.IsProd443(*addr) || *certMode == "manual"
+	serveTLS := tsweb.IsProd443(*addr) || *certMode == "manual" || *certMode == "dns"
 
 	s := derp.NewServer(cfg.PrivateKey, log.Printf)
 	s.SetVerifyClient(*verifyClients)
+	s.SetRateLimits(derp.RateLimits{
+		ClientPacketsPerSec: *clientPacketsPerSec,
+		ClientBytesPerSec:   *clientBytesPerSec,
+		RegionPacketsPerSec: *regionPacketsPerSec,
+		RegionBytesPerSec:   *regionBytesPerSec,
+	})
 
 	if *meshPSKFile != "" {
 		b, err := os.ReadFile(*meshPSKFile)
@@ -168,6 +188,10 @@ func main() {
 	if err := startMesh(s); err != nil {
 		log.Fatalf("startMesh: %v", err)
 	}
+	fed, err := startFederation(s)
+	if err != nil {
+		log.Fatalf("startFederation: %v", err)
+	}
 	expvar.Publish("derp", s.ExpVar())
 
 	mux := http.NewServeMux()
@@ -181,6 +205,9 @@ func main() {
 		}))
 	}
 	mux.HandleFunc("/derp/probe", probeHandler)
+	if fed != nil {
+		mux.Handle("/derp/federation", fed)
+	}
 	go refreshBootstrapDNSLoop()
 	mux.HandleFunc("/bootstrap-dns", tsweb.BrowserHeaderHandlerFunc(handleBootstrapDNS))
 	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -208,6 +235,7 @@ func main() {
 		io.WriteString(w, "User-agent: *\nDisallow: /\n")
 	}))
 	mux.Handle("/generate_204", http.HandlerFunc(serveNoContent))
+	mux.Handle("/metrics", tsweb.Protected(metricsHandler(s)))
 	debug := tsweb.Debugger(mux)
 	debug.KV("TLS hostname", *hostname)
 	debug.KV("Mesh key", s.HasMeshKey())
@@ -220,6 +248,8 @@ func main() {
 		}
 	}))
 	debug.Handle("traffic", "Traffic check", http.HandlerFunc(s.ServeDebugTraffic))
+	debug.Handle("ratelimits", "Client rate limits (GET, or POST JSON to replace)", rateLimitsHandler(s))
+	debug.Handle("clients", "Per-client accounting (JSON)", clientsHandler(s))
 
 	if *runSTUN {
 		go serveSTUN(listenHost, *stunPort)
@@ -249,6 +279,9 @@ func main() {
 		if err != nil {
 			log.Fatalf("derper: can not start cert provider: %v", err)
 		}
+		if r, ok := certManager.(certRunner); ok {
+			go r.run(context.Background())
+		}
 		httpsrv.TLSConfig = certManager.TLSConfig()
 		getCert := httpsrv.TLSConfig.GetCertificate
 		httpsrv.TLSConfig.GetCertificate = func(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
@@ -351,6 +384,93 @@ func probeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// rateLimitsHandler serves s's client rate limits as JSON, and replaces
+// them with the JSON derp.RateLimits POSTed to it. A zero field removes
+// that limit.
+func rateLimitsHandler(s *derp.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+		case "POST":
+			var rl derp.RateLimits
+			if err := json.NewDecoder(r.Body).Decode(&rl); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if rl.ClientPacketsPerSec < 0 || rl.ClientBytesPerSec < 0 || rl.RegionPacketsPerSec < 0 || rl.RegionBytesPerSec < 0 {
+				http.Error(w, "rate limits must not be negative", http.StatusBadRequest)
+				return
+			}
+			s.SetRateLimits(rl)
+			log.Printf("derper: rate limits set to %+v", rl)
+		default:
+			http.Error(w, "want GET or POST", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.RateLimits())
+	})
+}
+
+// clientsHandler serves the accounting of s's client connections as a
+// JSON array of derp.ClientStats, busiest first.
+func clientsHandler(s *derp.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(w)
+		e.SetIndent("", "\t")
+		e.Encode(s.ClientStats())
+	})
+}
+
+// metricsHandler serves the process's metrics, including s's, in the
+// Prometheus text format, like /debug/varz. With --per-client-metrics,
+// it also serves byte and packet counters for each connected client key.
+func metricsHandler(s *derp.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		varz.Handler(w, r)
+		if *perClientMetrics {
+			writeClientMetrics(w, s.ClientStats())
+		}
+	})
+}
+
+// writeClientMetrics writes per-client-key counters for stats in the
+// Prometheus text format. Connections sharing a key are summed.
+func writeClientMetrics(w io.Writer, stats []derp.ClientStats) {
+	type counters struct{ packetsRecv, bytesRecv, packetsSent, bytesSent, dropped int64 }
+	byKey := map[key.NodePublic]*counters{}
+	var keys []key.NodePublic
+	for _, st := range stats {
+		c, ok := byKey[st.Key]
+		if !ok {
+			c = new(counters)
+			byKey[st.Key] = c
+			keys = append(keys, st.Key)
+		}
+		c.packetsRecv += st.PacketsRecv
+		c.bytesRecv += st.BytesRecv
+		c.packetsSent += st.PacketsSent
+		c.bytesSent += st.BytesSent
+		c.dropped += st.PacketsDropped
+	}
+	for _, m := range []struct {
+		name string
+		get  func(*counters) int64
+	}{
+		{"derp_client_packets_received", func(c *counters) int64 { return c.packetsRecv }},
+		{"derp_client_bytes_received", func(c *counters) int64 { return c.bytesRecv }},
+		{"derp_client_packets_sent", func(c *counters) int64 { return c.packetsSent }},
+		{"derp_client_bytes_sent", func(c *counters) int64 { return c.bytesSent }},
+		{"derp_client_packets_dropped", func(c *counters) int64 { return c.dropped }},
+	} {
+		fmt.Fprintf(w, "# TYPE %s counter\n", m.name)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s{client=%q} %d\n", m.name, k.String(), m.get(byKey[k]))
+		}
+	}
+}
+
 func serveSTUN(host string, port int) {
 	pc, err := net.ListenPacket("udp", net.JoinHostPort(host, fmt.Sprint(port)))
 	if err != nil {
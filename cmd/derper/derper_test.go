@@ -11,8 +11,10 @@
 	"strings"
 	"testing"
 
+	"tailscale.com/derp"
 	"tailscale.com/net/stun"
 	"tailscale.com/tstest/deptest"
+	"tailscale.com/types/key"
 )
 
 func TestProdAutocertHostPolicy(t *testing.T) {
@@ -140,3 +142,56 @@ func TestDeps(t *testing.T) {
 		},
 	}.Check(t)
 }
+
+func TestRateLimitsHandler(t *testing.T) {
+	s := derp.NewServer(key.NewNode(), t.Logf)
+	defer s.Close()
+	h := rateLimitsHandler(s)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/debug/ratelimits", strings.NewReader(`{"ClientBytesPerSec": 1000, "RegionPacketsPerSec": 50}`)))
+	if rec.Code != 200 {
+		t.Fatalf("POST: got %d: %s", rec.Code, rec.Body)
+	}
+	want := derp.RateLimits{ClientBytesPerSec: 1000, RegionPacketsPerSec: 50}
+	if got := s.RateLimits(); got != want {
+		t.Errorf("limits = %+v; want %+v", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/ratelimits", nil))
+	if got, want := strings.TrimSpace(rec.Body.String()), `{"ClientBytesPerSec":1000,"RegionPacketsPerSec":50}`; got != want {
+		t.Errorf("GET = %s; want %s", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/debug/ratelimits", strings.NewReader(`{"ClientPacketsPerSec": -1}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("negative limit: got %d; want 400", rec.Code)
+	}
+	if got := s.RateLimits(); got != want {
+		t.Errorf("limits changed by a bad request: %+v", got)
+	}
+}
+
+func TestWriteClientMetrics(t *testing.T) {
+	k1, k2 := key.NewNode().Public(), key.NewNode().Public()
+	var buf strings.Builder
+	writeClientMetrics(&buf, []derp.ClientStats{
+		{Key: k1, BytesRecv: 100, PacketsRecv: 2},
+		{Key: k2, BytesSent: 7, PacketsDropped: 1},
+		{Key: k1, BytesRecv: 50, PacketsRecv: 1}, // dup connection
+	})
+	got := buf.String()
+	for _, want := range []string{
+		"# TYPE derp_client_bytes_received counter\n",
+		"derp_client_bytes_received{client=\"" + k1.String() + "\"} 150\n",
+		"derp_client_packets_received{client=\"" + k1.String() + "\"} 3\n",
+		"derp_client_bytes_sent{client=\"" + k2.String() + "\"} 7\n",
+		"derp_client_packets_dropped{client=\"" + k2.String() + "\"} 1\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/derp"
+	"tailscale.com/derp/derphttp"
+	"tailscale.com/derp/federation"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/set"
+)
+
+// federationConfig is the file named by --federation-config, which
+// configures sharing relay capacity with DERP servers run by other
+// organizations.
+type federationConfig struct {
+	// Operator is the name of the organization running this server, as
+	// in federated peers' allowlists.
+	Operator string
+
+	// Region, if non-nil, is this server's region, as clients should
+	// use it. It's signed and offered to federated peers and clients at
+	// /derp/federation.
+	Region *tailcfg.DERPRegion `json:",omitempty"`
+
+	// Allow are the organizations whose DERP servers to federate with.
+	Allow []*tailcfg.DERPFederationSigner `json:",omitempty"`
+
+	// Peers are the URLs of federated peers' /derp/federation
+	// endpoints. Peers whose descriptors are signed by an organization
+	// in Allow may mesh with this server, and this server meshes with
+	// them.
+	Peers []string `json:",omitempty"`
+}
+
+// federationKeyFile is the file named by --federation-key-file.
+type federationKeyFile struct {
+	SigningKey ed25519.PrivateKey
+}
+
+const (
+	// federationDescriptorLifetime is how long the descriptors served
+	// at /derp/federation are valid.
+	federationDescriptorLifetime = 24 * time.Hour
+
+	// federationRefreshInterval is how often federated peers'
+	// descriptors are fetched.
+	federationRefreshInterval = 10 * time.Minute
+)
+
+// federator federates a DERP server with the peers in its
+// federationConfig.
+type federator struct {
+	s          *derp.Server
+	cfg        federationConfig
+	signingKey ed25519.PrivateKey // or nil if cfg.Region is nil
+	logf       logger.Logf
+
+	mu    sync.Mutex
+	peers map[string]*federatedPeer // keyed by cfg.Peers URL
+}
+
+// federatedPeer is the state of a peer in federationConfig.Peers that
+// served valid descriptors.
+type federatedPeer struct {
+	keys    []key.NodePublic
+	expires time.Time // when the earliest of its descriptors expires
+	stop    func()    // stops meshing with it
+}
+
+// startFederation starts federating s as configured by
+// --federation-config, if set. It returns a nil federator if not.
+func startFederation(s *derp.Server) (*federator, error) {
+	if *federationConfigPath == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(*federationConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	f := &federator{
+		s:     s,
+		logf:  logger.WithPrefix(log.Printf, "federation: "),
+		peers: map[string]*federatedPeer{},
+	}
+	if err := json.Unmarshal(b, &f.cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", *federationConfigPath, err)
+	}
+	if f.cfg.Region != nil {
+		if f.cfg.Operator == "" {
+			return nil, errors.New("federation config with a Region requires an Operator")
+		}
+		if *federationKeyPath == "" {
+			return nil, errors.New("federation config with a Region requires --federation-key-file")
+		}
+		f.signingKey, err = loadFederationKey(*federationKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		pub := f.signingKey.Public().(ed25519.PublicKey)
+		f.logf("offering region %d as %q with signing key %s", f.cfg.Region.RegionID, f.cfg.Operator, base64.StdEncoding.EncodeToString(pub))
+	}
+	for _, u := range f.cfg.Peers {
+		if _, err := meshURLOfPeer(u); err != nil {
+			return nil, err
+		}
+	}
+	if len(f.cfg.Peers) > 0 {
+		go f.refreshLoop()
+	}
+	return f, nil
+}
+
+// loadFederationKey loads the signing key from the
+// --federation-key-file at path, creating it if it doesn't exist.
+func loadFederationKey(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		_, priv, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			return nil, err
+		}
+		b, err := json.MarshalIndent(federationKeyFile{SigningKey: priv}, "", "\t")
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return nil, err
+		}
+		if err := atomicfile.WriteFile(path, b, 0600); err != nil {
+			return nil, err
+		}
+		return priv, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var kf federationKeyFile
+	if err := json.Unmarshal(b, &kf); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(kf.SigningKey) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("%s: bad SigningKey", path)
+	}
+	return kf.SigningKey, nil
+}
+
+// meshURLOfPeer returns the DERP URL of the server whose /derp/federation
+// endpoint is at peerURL.
+func meshURLOfPeer(peerURL string) (string, error) {
+	u, err := url.Parse(peerURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return "", fmt.Errorf("federation peer %q is not an HTTP(S) URL", peerURL)
+	}
+	u.Path = "/derp"
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// ServeHTTP serves this server's signed descriptor, if it offers a
+// region, as a JSON array of federation.Signed.
+func (f *federator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusMethodNotAllowed)
+		return
+	}
+	signed := []*federation.Signed{}
+	if f.cfg.Region != nil {
+		s, err := federation.Sign(&federation.Descriptor{
+			Operator:   f.cfg.Operator,
+			Region:     f.cfg.Region,
+			ServerKeys: []key.NodePublic{f.s.PublicKey()},
+			Expires:    time.Now().Add(federationDescriptorLifetime),
+		}, f.signingKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		signed = append(signed, s)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(signed)
+}
+
+func (f *federator) refreshLoop() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		f.refresh(ctx, time.Now())
+		cancel()
+		time.Sleep(federationRefreshInterval)
+	}
+}
+
+// refresh fetches the descriptors of f's peers, lets the servers they
+// describe mesh with f's server, and meshes with them. Peers that can't
+// be fetched keep their previous state until their descriptors expire.
+func (f *federator) refresh(ctx context.Context, now time.Time) {
+	for _, u := range f.cfg.Peers {
+		signed, err := federation.Fetch(ctx, http.DefaultClient, u)
+		if err != nil {
+			f.logf("%v", err)
+			f.mu.Lock()
+			if p, ok := f.peers[u]; ok && !now.Before(p.expires) {
+				f.logf("descriptors from %s expired", u)
+				f.removePeerLocked(u)
+			}
+			f.mu.Unlock()
+			continue
+		}
+		descs, _, err := federation.VerifyAll(signed, f.cfg.Allow, now)
+		if err != nil {
+			f.logf("from %s: %v", u, err)
+		}
+		f.mu.Lock()
+		if len(descs) == 0 {
+			f.removePeerLocked(u)
+		} else {
+			f.setPeerLocked(u, descs)
+		}
+		f.mu.Unlock()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []key.NodePublic
+	for _, p := range f.peers {
+		keys = append(keys, p.keys...)
+	}
+	f.s.SetFederatedPeers(keys)
+}
+
+// setPeerLocked records the valid descriptors fetched from the peer at
+// peerURL, starting to mesh with it if needed. f.mu must be held.
+func (f *federator) setPeerLocked(peerURL string, descs []*federation.Descriptor) {
+	p, ok := f.peers[peerURL]
+	if !ok {
+		meshURL, _ := meshURLOfPeer(peerURL) // checked by startFederation
+		p = &federatedPeer{stop: f.startMesh(meshURL)}
+		f.peers[peerURL] = p
+		f.logf("federating with %s", peerURL)
+	}
+	p.keys = p.keys[:0]
+	p.expires = descs[0].Expires
+	for _, d := range descs {
+		p.keys = append(p.keys, d.ServerKeys...)
+		if d.Expires.Before(p.expires) {
+			p.expires = d.Expires
+		}
+	}
+}
+
+// removePeerLocked stops federating with the peer at peerURL, if it was.
+// f.mu must be held.
+func (f *federator) removePeerLocked(peerURL string) {
+	p, ok := f.peers[peerURL]
+	if !ok {
+		return
+	}
+	f.logf("no longer federating with %s", peerURL)
+	p.stop()
+	delete(f.peers, peerURL)
+}
+
+// startMesh starts forwarding packets for the clients of the federated
+// peer DERP server at meshURL through it, like startMeshWithHost does
+// for servers sharing the mesh key. It returns a func to stop.
+func (f *federator) startMesh(meshURL string) (stop func()) {
+	logf := logger.WithPrefix(log.Printf, fmt.Sprintf("federated mesh(%q): ", meshURL))
+	c, err := derphttp.NewClient(f.s.PrivateKey(), meshURL, logf)
+	if err != nil {
+		// Unreachable: meshURLOfPeer made a valid URL.
+		logf("%v", err)
+		return func() {}
+	}
+	var (
+		mu      sync.Mutex
+		present = set.Set[key.NodePublic]{}
+		stopped bool
+	)
+	add := func(k key.NodePublic, _ netip.AddrPort) {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopped {
+			return
+		}
+		present.Add(k)
+		f.s.AddPacketForwarder(k, c)
+	}
+	remove := func(k key.NodePublic) {
+		mu.Lock()
+		defer mu.Unlock()
+		present.Delete(k)
+		f.s.RemovePacketForwarder(k, c)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go c.RunWatchConnectionLoop(ctx, f.s.PublicKey(), logf, add, remove)
+	return func() {
+		cancel()
+		c.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+		for k := range present {
+			f.s.RemovePacketForwarder(k, c)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/derp/derphttp"
+	"tailscale.com/derp/federation"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+)
+
+func TestFederation(t *testing.T) {
+	signingKey, err := loadFederationKey(filepath.Join(t.TempDir(), "fed.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	region := &tailcfg.DERPRegion{
+		RegionID:   950,
+		RegionCode: "ex",
+		Nodes:      []*tailcfg.DERPNode{{Name: "950a", RegionID: 950, HostName: "derp.example.com"}},
+	}
+
+	s1 := derp.NewServer(key.NewNode(), t.Logf)
+	defer s1.Close()
+	f1 := &federator{
+		s:          s1,
+		cfg:        federationConfig{Operator: "example", Region: region},
+		signingKey: signingKey,
+		logf:       t.Logf,
+		peers:      map[string]*federatedPeer{},
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/derp", derphttp.Handler(s1))
+	mux.Handle("/derp/federation", f1)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	peerURL := ts.URL + "/derp/federation"
+
+	signed, err := federation.Fetch(context.Background(), ts.Client(), peerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signers := []*tailcfg.DERPFederationSigner{{
+		Operator: "example",
+		Key:      signingKey.Public().(ed25519.PublicKey),
+	}}
+	descs, _, err := federation.VerifyAll(signed, signers, time.Now())
+	if err != nil || len(descs) != 1 {
+		t.Fatalf("served descriptors = %v, %v; want 1 valid", descs, err)
+	}
+	if d := descs[0]; d.Region.RegionID != 950 || len(d.ServerKeys) != 1 || d.ServerKeys[0] != s1.PublicKey() {
+		t.Errorf("served descriptor = %+v", d)
+	}
+
+	newPeer := func(allow []*tailcfg.DERPFederationSigner) *federator {
+		s := derp.NewServer(key.NewNode(), logger.Discard)
+		t.Cleanup(func() { s.Close() })
+		f := &federator{
+			s:     s,
+			cfg:   federationConfig{Operator: "other", Allow: allow, Peers: []string{peerURL}},
+			logf:  t.Logf,
+			peers: map[string]*federatedPeer{},
+		}
+		t.Cleanup(func() {
+			for u := range f.peers {
+				f.removePeerLocked(u)
+			}
+		})
+		return f
+	}
+
+	f2 := newPeer(signers)
+	f2.refresh(context.Background(), time.Now())
+	p := f2.peers[peerURL]
+	if p == nil || len(p.keys) != 1 || p.keys[0] != s1.PublicKey() {
+		t.Fatalf("peer state = %+v; want s1's key", p)
+	}
+
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	f3 := newPeer([]*tailcfg.DERPFederationSigner{{Operator: "example", Key: otherPub}})
+	f3.refresh(context.Background(), time.Now())
+	if len(f3.peers) != 0 {
+		t.Errorf("federated with a peer signed by a key not in Allow")
+	}
+
+	// A peer that can't be fetched is kept until its descriptors expire.
+	ts.Close()
+	f2.refresh(context.Background(), time.Now())
+	if f2.peers[peerURL] == nil {
+		t.Errorf("peer dropped on fetch error before expiry")
+	}
+	f2.refresh(context.Background(), time.Now().Add(2*federationDescriptorLifetime))
+	if f2.peers[peerURL] != nil {
+		t.Errorf("peer kept after its descriptors expired")
+	}
+}
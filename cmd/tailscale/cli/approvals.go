@@ -0,0 +1,190 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+var approvalsCmd = &ffcli.Command{
+	Name:       "approvals",
+	ShortUsage: "approvals <list|accept|reject|forget|watch> [command flags]",
+	ShortHelp:  "Approve incoming connections",
+	LongHelp: strings.TrimSpace(`
+With "tailscale set --connection-approval", new incoming connections from
+a peer are held until accepted or rejected here, unless an earlier
+decision covers them. Connections not decided on within a minute are
+rejected, and are asked about again when the peer next tries.
+
+Decisions apply to later connections from the same peer to the same
+port, or with --all-ports, to any port.
+`),
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "list",
+			ShortUsage: "approvals list",
+			ShortHelp:  "List the pending connections and the decisions made",
+			Exec:       runApprovalsList,
+		},
+		{
+			Name:       "accept",
+			ShortUsage: "approvals accept [--all-ports] <id>",
+			ShortHelp:  "Accept a pending connection",
+			Exec:       func(ctx context.Context, args []string) error { return runApprovalsDecide(ctx, args, true) },
+			FlagSet:    approvalsDecideFlagSet("accept"),
+		},
+		{
+			Name:       "reject",
+			ShortUsage: "approvals reject [--all-ports] <id>",
+			ShortHelp:  "Reject a pending connection",
+			Exec:       func(ctx context.Context, args []string) error { return runApprovalsDecide(ctx, args, false) },
+			FlagSet:    approvalsDecideFlagSet("reject"),
+		},
+		{
+			Name:       "forget",
+			ShortUsage: "approvals forget <peer-id>",
+			ShortHelp:  "Forget the decisions about a peer",
+			Exec:       runApprovalsForget,
+		},
+		{
+			Name:       "watch",
+			ShortUsage: "approvals watch",
+			ShortHelp:  "Ask about connections as they arrive",
+			Exec:       runApprovalsWatch,
+		},
+	},
+	Exec: func(context.Context, []string) error {
+		return errors.New("approvals subcommand required; run 'tailscale approvals -h' for details")
+	},
+}
+
+var approvalsArgs struct {
+	allPorts bool
+}
+
+func approvalsDecideFlagSet(name string) *flag.FlagSet {
+	fs := newFlagSet(name)
+	fs.BoolVar(&approvalsArgs.allPorts, "all-ports", false, "apply the decision to connections from the peer to any port")
+	return fs
+}
+
+func runApprovalsList(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	ca, err := localClient.ConnApprovals(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	w := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	if len(ca.Pending) == 0 {
+		fmt.Fprintln(w, "No pending connections.")
+	} else {
+		fmt.Fprintln(w, "ID\tPEER\tCONNECTION\tEXPIRES")
+		for _, r := range ca.Pending {
+			fmt.Fprintf(w, "%s\t%s\t%v %v -> %v\t%s\n", r.ID, r.PeerName, r.Proto, r.Src, r.Dst, r.Expires.Local().Format(time.TimeOnly))
+		}
+	}
+	fmt.Fprintln(w)
+	if len(ca.Rules) == 0 {
+		fmt.Fprintln(w, "No decisions made.")
+	} else {
+		fmt.Fprintln(w, "PEER\tPROTO\tPORT\tDECISION")
+		for _, r := range ca.Rules {
+			proto, port := "any", "any"
+			if r.Proto != 0 {
+				proto = r.Proto.String()
+			}
+			if r.Port != 0 {
+				port = fmt.Sprint(r.Port)
+			}
+			decision := "reject"
+			if r.Allow {
+				decision = "accept"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Peer, proto, port, decision)
+		}
+	}
+	return w.Flush()
+}
+
+func runApprovalsDecide(ctx context.Context, args []string, allow bool) error {
+	if len(args) != 1 {
+		return errors.New("exactly one pending connection ID required; see 'tailscale approvals list'")
+	}
+	err := localClient.DecideConnApproval(ctx, ipn.ConnApprovalDecision{
+		ID:       args[0],
+		Allow:    allow,
+		AllPorts: approvalsArgs.allPorts,
+	})
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	return nil
+}
+
+func runApprovalsForget(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale approvals forget <peer-id>")
+	}
+	if err := localClient.ForgetConnApprovals(ctx, tailcfg.StableNodeID(args[0])); err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	return nil
+}
+
+func runApprovalsWatch(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	watcher, err := localClient.WatchIPNBusEvents(ctx, 0, ipn.EventConnApproval)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	defer watcher.Close()
+	stdin := bufio.NewScanner(os.Stdin)
+	outln("Waiting for incoming connections that need approval.")
+	for {
+		n, err := watcher.Next()
+		if err != nil {
+			return err
+		}
+		r := n.ConnApproval
+		if r == nil {
+			continue
+		}
+		printf("\n%v connection from %s (%v) to %v\n", r.Proto, r.PeerName, r.Src, r.Dst)
+		printf("Accept? [y]es, [a]ll ports, [n]o, [r]eject all ports, or skip: ")
+		if !stdin.Scan() {
+			return stdin.Err()
+		}
+		d := ipn.ConnApprovalDecision{ID: r.ID}
+		switch strings.ToLower(strings.TrimSpace(stdin.Text())) {
+		case "y", "yes":
+			d.Allow = true
+		case "a":
+			d.Allow, d.AllPorts = true, true
+		case "n", "no":
+		case "r":
+			d.AllPorts = true
+		default:
+			continue
+		}
+		if err := localClient.DecideConnApproval(ctx, d); err != nil {
+			printf("%v\n", err)
+		}
+	}
+}
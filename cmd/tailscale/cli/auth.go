@@ -0,0 +1,127 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+var authCmd = &ffcli.Command{
+	Name:       "auth",
+	ShortUsage: "auth <subcommand> [command flags]",
+	ShortHelp:  "Manage access to the Tailscale daemon",
+	Subcommands: []*ffcli.Command{
+		authTokenCmd,
+	},
+	Exec: func(context.Context, []string) error {
+		return errors.New("auth subcommand required; run 'tailscale auth -h' for details")
+	},
+}
+
+var authTokenCmd = &ffcli.Command{
+	Name:       "token",
+	ShortUsage: "auth token <create|list|revoke> [command flags]",
+	ShortHelp:  "Manage LocalAPI access tokens",
+	LongHelp: strings.TrimSpace(`
+LocalAPI access tokens let programs, such as monitoring agents, use the
+Tailscale daemon with only the access they need, whoever they run as.
+
+The scope of a token is one of:
+
+  status  read the node's status, prefs and health, but change nothing
+  serve   also change the serve and Funnel config and fetch TLS certs
+  admin   everything
+
+Programs send the token in the Tailscale-LocalAPI-Token header of their
+requests. The tailscale CLI sends the token in $TS_LOCALAPI_TOKEN, if set.
+`),
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "create",
+			ShortUsage: "auth token create --scope=<status|serve|admin> [--name=<name>]",
+			ShortHelp:  "Create a token and print it",
+			Exec:       runAuthTokenCreate,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("create")
+				fs.StringVar(&authTokenArgs.scope, "scope", string(apitype.LocalAPIScopeStatus), "what the token permits: status, serve or admin")
+				fs.StringVar(&authTokenArgs.name, "name", "", "optional name of the token, such as what uses it")
+				return fs
+			})(),
+		},
+		{
+			Name:       "list",
+			ShortUsage: "auth token list",
+			ShortHelp:  "List the tokens",
+			Exec:       runAuthTokenList,
+		},
+		{
+			Name:       "revoke",
+			ShortUsage: "auth token revoke <id>",
+			ShortHelp:  "Revoke a token",
+			Exec:       runAuthTokenRevoke,
+		},
+	},
+	Exec: func(context.Context, []string) error {
+		return errors.New("auth token subcommand required; run 'tailscale auth token -h' for details")
+	},
+}
+
+var authTokenArgs struct {
+	scope string
+	name  string
+}
+
+func runAuthTokenCreate(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	res, err := localClient.CreateLocalAPIToken(ctx, authTokenArgs.name, apitype.LocalAPIScope(authTokenArgs.scope))
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	printf("%s\n", res.Token)
+	outln("")
+	outln("# This token won't be shown again. Revoke it with:")
+	printf("#   tailscale auth token revoke %s\n", res.Info.ID)
+	return nil
+}
+
+func runAuthTokenList(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	toks, err := localClient.LocalAPITokens(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if len(toks) == 0 {
+		outln("No LocalAPI access tokens.")
+		return nil
+	}
+	w := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSCOPE\tCREATED\tNAME")
+	for _, t := range toks {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.ID, t.Scope, t.Created.Local().Format(time.DateTime), t.Name)
+	}
+	return w.Flush()
+}
+
+func runAuthTokenRevoke(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale auth token revoke <id>")
+	}
+	if err := localClient.RevokeLocalAPIToken(ctx, args[0]); err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	return nil
+}
@@ -9,6 +9,7 @@
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"flag"
 	"fmt"
@@ -16,6 +17,7 @@
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"software.sslmate.com/src/go-pkcs12"
@@ -34,6 +36,7 @@
 		fs.StringVar(&certArgs.certFile, "cert-file", "", "output cert file or \"-\" for stdout; defaults to DOMAIN.crt if --cert-file and --key-file are both unset")
 		fs.StringVar(&certArgs.keyFile, "key-file", "", "output key file or \"-\" for stdout; defaults to DOMAIN.key if --cert-file and --key-file are both unset")
 		fs.BoolVar(&certArgs.serve, "serve-demo", false, "if true, serve on port :443 using the cert as a demo, instead of writing out the files to disk")
+		fs.BoolVar(&certArgs.json, "json", false, "output what was written as JSON")
 		return fs
 	})(),
 }
@@ -42,6 +45,17 @@
 	certFile string
 	keyFile  string
 	serve    bool
+	json     bool
+}
+
+// certJSON is the output of "tailscale cert --json".
+type certJSON struct {
+	Domain      string
+	CertFile    string    `json:",omitempty"`
+	KeyFile     string    `json:",omitempty"`
+	CertChanged bool      // whether CertFile was written, rather than already being current
+	KeyChanged  bool      // likewise for KeyFile
+	NotAfter    time.Time // when the cert expires
 }
 
 func runCert(ctx context.Context, args []string) error {
@@ -95,9 +109,17 @@ func runCert(ctx context.Context, args []string) error {
 		printf(format, a...)
 	}
 	if certArgs.certFile == "-" || certArgs.keyFile == "-" {
+		if certArgs.json {
+			return errors.New("can't use --json when writing the cert or key to stdout")
+		}
 		printf = log.Printf
 		log.SetFlags(0)
 	}
+	if certArgs.json {
+		printf = func(format string, a ...any) {
+			fmt.Fprintf(Stderr, format, a...)
+		}
+	}
 	if certArgs.certFile == "" && certArgs.keyFile == "" {
 		certArgs.certFile = domain + ".crt"
 		certArgs.keyFile = domain + ".key"
@@ -118,8 +140,19 @@ func runCert(ctx context.Context, args []string) error {
 		}
 		printf("Warning: the macOS CLI runs in a sandbox; this binary's filesystem writes go to $HOME/Library/Containers/%s/Data\n", dir)
 	}
+	out := certJSON{
+		Domain:   domain,
+		CertFile: certArgs.certFile,
+		KeyFile:  certArgs.keyFile,
+	}
+	if block, _ := pem.Decode(certPEM); block != nil {
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			out.NotAfter = cert.NotAfter
+		}
+	}
 	if certArgs.certFile != "" {
 		certChanged, err := writeIfChanged(certArgs.certFile, certPEM, 0644)
+		out.CertChanged = certChanged
 		if err != nil {
 			return err
 		}
@@ -145,6 +178,7 @@ func runCert(ctx context.Context, args []string) error {
 		if err != nil {
 			return err
 		}
+		out.KeyChanged = keyChanged
 		if certArgs.keyFile != "-" {
 			macWarn()
 			if keyChanged {
@@ -154,6 +188,9 @@ func runCert(ctx context.Context, args []string) error {
 			}
 		}
 	}
+	if certArgs.json {
+		printJSON(out)
+	}
 	return nil
 }
 
@@ -95,6 +95,7 @@ func Run(args []string) (err error) {
 
 	rootfs := newFlagSet("tailscale")
 	rootfs.StringVar(&rootArgs.socket, "socket", paths.DefaultTailscaledSocket(), "path to tailscaled socket")
+	rootfs.StringVar(&rootArgs.instance, "instance", envknob.String("TS_INSTANCE"), "name of the tailscaled instance to use, as given to 'tailscaled --instance'; defaults to $TS_INSTANCE")
 
 	rootCmd := &ffcli.Command{
 		Name:       "tailscale",
@@ -115,8 +116,13 @@ func Run(args []string) (err error) {
 			switchCmd,
 			configureCmd,
 			netcheckCmd,
+			dnsCmd,
 			ipCmd,
 			statusCmd,
+			topCmd,
+			healthCmd,
+			authCmd,
+			approvalsCmd,
 			pingCmd,
 			ncCmd,
 			sshCmd,
@@ -126,6 +132,7 @@ func Run(args []string) (err error) {
 			webCmd,
 			fileCmd,
 			bugReportCmd,
+			doctorCmd,
 			certCmd,
 			netlockCmd,
 			licensesCmd,
@@ -151,6 +158,8 @@ func Run(args []string) (err error) {
 		rootCmd.Subcommands = append(rootCmd.Subcommands, configureHostCmd)
 	}
 
+	rootCmd.Subcommands = append(rootCmd.Subcommands, newCompletionCmd(rootCmd))
+
 	for _, c := range rootCmd.Subcommands {
 		if c.UsageFunc == nil {
 			c.UsageFunc = usageFunc
@@ -161,23 +170,42 @@ func Run(args []string) (err error) {
 		if errors.Is(err, flag.ErrHelp) {
 			return nil
 		}
+		err = usageError{err}
+		if jsonRequested(rootCmd) {
+			printJSONError(err)
+		}
 		return err
 	}
 
 	localClient.Socket = rootArgs.socket
+	localClient.AuthToken = envknob.String("TS_LOCALAPI_TOKEN")
+	socketSet := false
 	rootfs.Visit(func(f *flag.Flag) {
 		if f.Name == "socket" {
-			localClient.UseSocketOnly = true
+			socketSet = true
 		}
 	})
+	if rootArgs.instance != "" && !socketSet {
+		if err := paths.CheckInstanceName(rootArgs.instance); err != nil {
+			return usageError{err}
+		}
+		localClient.Socket = paths.InstanceTailscaledSocket(rootArgs.instance)
+		socketSet = true
+	}
+	if socketSet {
+		localClient.UseSocketOnly = true
+	}
 
 	err = rootCmd.Run(context.Background())
 	if tailscale.IsAccessDeniedError(err) && os.Getuid() != 0 && runtime.GOOS != "windows" {
-		return fmt.Errorf("%v\n\nUse 'sudo tailscale %s' or 'tailscale up --operator=$USER' to not require root.", err, strings.Join(args, " "))
+		err = fmt.Errorf("%w\n\nUse 'sudo tailscale %s' or 'tailscale up --operator=$USER' to not require root.", err, strings.Join(args, " "))
 	}
 	if errors.Is(err, flag.ErrHelp) {
 		return nil
 	}
+	if err != nil && jsonRequested(rootCmd) {
+		printJSONError(err)
+	}
 	return err
 }
 
@@ -194,7 +222,8 @@ func fatalf(format string, a ...any) {
 var Fatalf func(format string, a ...any)
 
 var rootArgs struct {
-	socket string
+	socket   string
+	instance string
 }
 
 // usageFuncNoDefaultValues is like usageFunc but doesn't print default values.
@@ -5,22 +5,28 @@
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"net/netip"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/google/go-cmp/cmp"
+	"github.com/kballard/go-shellquote"
 	"tailscale.com/health/healthmsg"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tka"
 	"tailscale.com/tstest"
+	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
@@ -671,6 +677,23 @@ func TestPrefsFromUpArgs(t *testing.T) {
 			},
 			wantErr: `cannot use 100.105.106.107 as an exit node as it is a local IP address to this machine; did you mean --advertise-exit-node?`,
 		},
+		{
+			name: "exit_node_auto",
+			args: upArgsFromOSArgs("linux", "--exit-node=auto", "--exit-node-allow-lan-access"),
+			want: &ipn.Prefs{
+				ControlURL:             ipn.DefaultControlURL,
+				WantRunning:            true,
+				AllowSingleHosts:       true,
+				CorpDNS:                true,
+				AutoExitNode:           true,
+				ExitNodeAllowLANAccess: true,
+				NetfilterMode:          preftype.NetfilterOn,
+				AutoUpdate: ipn.AutoUpdatePrefs{
+					Check: true,
+					Apply: false,
+				},
+			},
+		},
 		{
 			name: "warn_linux_netfilter_nodivert",
 			goos: "linux",
@@ -890,6 +913,8 @@ func TestUpdatePrefs(t *testing.T) {
 				AdvertiseRoutesSet:        true,
 				AdvertiseTagsSet:          true,
 				AllowSingleHostsSet:       true,
+				AutoExitNodeSet:           true,
+				ConnApprovalSet:           true,
 				ControlURLSet:             true,
 				CorpDNSSet:                true,
 				ExitNodeAllowLANAccessSet: true,
@@ -1290,3 +1315,140 @@ func TestParseNLArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestParseNLSigningKeys(t *testing.T) {
+	nk1 := "nodekey:" + strings.Repeat("01", 32)
+	nk2 := "nodekey:" + strings.Repeat("02", 32)
+	rk := "nlpub:" + strings.Repeat("03", 32)
+
+	tcs := []struct {
+		name     string
+		input    string
+		wantKeys []string // node keys
+		wantRot  []bool   // whether each has a rotation key
+		wantErr  bool
+	}{
+		{
+			name:     "lines",
+			input:    "# keys to sign\n" + nk1 + "\n\n  " + nk2 + " " + rk + "\n",
+			wantKeys: []string{nk1, nk2},
+			wantRot:  []bool{false, true},
+		},
+		{
+			name:     "signing request",
+			input:    `{"Keys": [{"NodeKey": "` + nk2 + `"}]}`,
+			wantKeys: []string{nk2},
+			wantRot:  []bool{false},
+		},
+		{
+			name:    "bad node key",
+			input:   "nodekey:xyz\n",
+			wantErr: true,
+		},
+		{
+			name:    "too many fields",
+			input:   nk1 + " " + rk + " " + rk + "\n",
+			wantErr: true,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			keys, err := parseNLSigningKeys([]byte(tc.input))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tc.wantErr)
+			}
+			if len(keys) != len(tc.wantKeys) {
+				t.Fatalf("got %d keys, want %d", len(keys), len(tc.wantKeys))
+			}
+			for i, k := range keys {
+				if got := k.NodeKey.String(); got != tc.wantKeys[i] {
+					t.Errorf("key %d = %v, want %v", i, got, tc.wantKeys[i])
+				}
+				if got := len(k.RotationPublic) > 0; got != tc.wantRot[i] {
+					t.Errorf("key %d has rotation key: %v, want %v", i, got, tc.wantRot[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    float64
+		want string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1024, "1.0K"},
+		{1536, "1.5K"},
+		{5 << 20, "5.0M"},
+		{3 << 30, "3.0G"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%v) = %q; want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+// nlSignerHelperKey is the key of the signer helper process run by
+// TestNLSigner.
+var nlSignerHelperKey = ed25519.NewKeyFromSeed(bytes.Repeat([]byte{7}, ed25519.SeedSize))
+
+// TestNLSignerHelperProcess isn't a real test; it's the signer helper
+// program run by TestNLSigner.
+func TestNLSignerHelperProcess(t *testing.T) {
+	if os.Getenv("TS_TEST_NL_SIGNER_HELPER") == "" {
+		t.Skip("not a helper process")
+	}
+	if flag.NArg() != 2 || flag.Arg(0) != "slot 9a" {
+		t.Fatalf("args = %q; want [\"slot 9a\" <op>]", flag.Args())
+	}
+	switch op := flag.Arg(1); op {
+	case "public-key":
+		fmt.Println(key.NLPublicFromEd25519Unsafe(nlSignerHelperKey.Public().(ed25519.PublicKey)).CLIString())
+	case "sign":
+		msg, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Printf("%x\n", ed25519.Sign(nlSignerHelperKey, msg))
+	default:
+		t.Fatalf("unknown op %q", op)
+	}
+	os.Exit(0)
+}
+
+func TestNLSigner(t *testing.T) {
+	t.Setenv("TS_TEST_NL_SIGNER_HELPER", "1")
+	ctx := context.Background()
+	signer, err := parseNLSigner(ctx, "exec:"+shellquote.Join(os.Args[0])+" '-test.run=^TestNLSignerHelperProcess$' -- \"slot 9a\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := nlSignerHelperKey.Public().(ed25519.PublicKey)
+	if got, want := signer.public, key.NLPublicFromEd25519Unsafe(pub); got != want {
+		t.Errorf("public key = %v; want %v", got.CLIString(), want.CLIString())
+	}
+
+	nodeKey := key.NewNode().Public()
+	res := nlSignWithSigner(signer, []ipnstate.NetworkLockSigningRequestKey{{NodeKey: nodeKey}})
+	if len(res) != 1 || res[0].Error != "" {
+		t.Fatalf("nlSignWithSigner = %+v", res)
+	}
+	var sig tka.NodeKeySignature
+	if err := sig.Unserialize(res[0].Signature); err != nil {
+		t.Fatal(err)
+	}
+	sigHash := sig.SigHash()
+	if !ed25519.Verify(pub, sigHash[:], sig.Signature) {
+		t.Error("node key signature doesn't verify")
+	}
+
+	if _, err := parseNLSigner(ctx, "pkcs11:slot=1"); err == nil {
+		t.Error("parsing an unknown kind of signer succeeded")
+	}
+	if _, err := parseNLSigner(ctx, "exec:helper 'unterminated"); err == nil {
+		t.Error("parsing a signer with an unterminated quote succeeded")
+	}
+}
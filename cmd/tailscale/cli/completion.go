@@ -0,0 +1,302 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/util/dnsname"
+)
+
+// completeFunc returns the candidates for completing a command's argument
+// or flag value. They needn't have the prefix being completed; those that
+// don't are left out.
+type completeFunc func(context.Context) ([]string, error)
+
+// argCompleters are the completeFuncs of the positional arguments of
+// commands, by the path of the command, such as "file cp".
+var argCompleters = map[string]completeFunc{
+	"file cp": completeFileTargets,
+	"nc":      completePeerNames,
+	"ping":    completePeerNames,
+	"ssh":     completePeerNames,
+}
+
+// flagCompleters are the completeFuncs of flag values, by the path of the
+// command and the flag, such as "set --exit-node".
+var flagCompleters = map[string]completeFunc{
+	"set --exit-node": completeExitNodes,
+	"up --exit-node":  completeExitNodes,
+}
+
+// completionTimeout bounds how long completion waits for tailscaled, so
+// that the shell doesn't hang if it's unresponsive.
+const completionTimeout = 2 * time.Second
+
+// completionScripts are the shell completion scripts printed by
+// "tailscale completion". Each runs "tailscale completion __complete"
+// with the word being completed and the words before it, and falls back
+// to completing file names if it prints nothing.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for tailscale
+_tailscale() {
+	local IFS=$'\n'
+	COMPREPLY=($(tailscale completion __complete --cur="${COMP_WORDS[COMP_CWORD]}" -- "${COMP_WORDS[@]:1:COMP_CWORD-1}" 2>/dev/null))
+}
+complete -o default -F _tailscale tailscale
+`,
+	"zsh": `#compdef tailscale
+_tailscale() {
+	local -a completions
+	completions=(${(f)"$(tailscale completion __complete --cur="${words[CURRENT]}" -- "${(@)words[2,CURRENT-1]}" 2>/dev/null)"})
+	if (( ${#completions} )); then
+		compadd -Q -- "${completions[@]}"
+	else
+		_files
+	fi
+}
+compdef _tailscale tailscale
+`,
+	"fish": `# fish completion for tailscale
+function __tailscale_complete
+	set -l cur (commandline -ct)
+	set -l out (tailscale completion __complete --cur="$cur" -- (commandline -opc)[2..-1] 2>/dev/null)
+	if test (count $out) -gt 0
+		printf '%s\n' $out
+	else
+		__fish_complete_path $cur
+	end
+end
+complete -c tailscale -f -a '(__tailscale_complete)'
+`,
+	"powershell": `# PowerShell completion for tailscale
+Register-ArgumentCompleter -Native -CommandName tailscale -ScriptBlock {
+	param($wordToComplete, $commandAst, $cursorPosition)
+	$words = @($commandAst.CommandElements | Select-Object -Skip 1 | ForEach-Object { $_.ToString() })
+	if ($wordToComplete -ne '' -and $words.Count -gt 0) {
+		$words = @($words | Select-Object -SkipLast 1)
+	}
+	& tailscale completion __complete "--cur=$wordToComplete" -- @words 2>$null | ForEach-Object {
+		[System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+	}
+}
+`,
+}
+
+// newCompletionCmd returns the "completion" command, which completes
+// the commands of root.
+func newCompletionCmd(root *ffcli.Command) *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "completion",
+		ShortUsage: "completion <bash|zsh|fish|powershell>",
+		ShortHelp:  "Print a shell completion script",
+		LongHelp: strings.TrimSpace(`
+"tailscale completion" prints a script that completes the tailscale
+command's subcommands and flags in the given shell, as well as peer names
+for commands such as "tailscale ping" and exit node names for
+"tailscale set --exit-node", which it gets from tailscaled as you type.
+
+To use it in bash, add this to ~/.bashrc:
+
+  source <(tailscale completion bash)
+
+In zsh, add this to ~/.zshrc, after compinit:
+
+  source <(tailscale completion zsh)
+
+In fish:
+
+  tailscale completion fish > ~/.config/fish/completions/tailscale.fish
+
+In PowerShell, add this to your profile:
+
+  tailscale completion powershell | Out-String | Invoke-Expression
+`),
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) > 0 && args[0] == "__complete" {
+				return runComplete(ctx, root, args[1:])
+			}
+			if len(args) != 1 {
+				return errors.New("usage: tailscale completion <bash|zsh|fish|powershell>")
+			}
+			script, ok := completionScripts[args[0]]
+			if !ok {
+				return fmt.Errorf("unsupported shell %q; want bash, zsh, fish or powershell", args[0])
+			}
+			printf("%s", script)
+			return nil
+		},
+	}
+}
+
+// runComplete runs "tailscale completion __complete --cur=<word> -- <words>",
+// printing the completions of word after words, one per line.
+func runComplete(ctx context.Context, root *ffcli.Command, args []string) error {
+	fs := flag.NewFlagSet("__complete", flag.ContinueOnError)
+	cur := fs.String("cur", "", "the word being completed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, completionTimeout)
+	defer cancel()
+	for _, c := range complete(ctx, root, fs.Args(), *cur) {
+		outln(c)
+	}
+	return nil
+}
+
+// complete returns the completions of cur, the word being completed,
+// after the words before it, as arguments to root.
+func complete(ctx context.Context, root *ffcli.Command, words []string, cur string) []string {
+	cmd := root
+	path := []string{}
+	var valueOf *flag.Flag // the flag that cur is the value of, if any
+	sawArg := false
+	for _, w := range words {
+		if valueOf != nil {
+			valueOf = nil
+			continue
+		}
+		if w == "--" {
+			sawArg = true
+			continue
+		}
+		if name, ok := strings.CutPrefix(w, "-"); ok && !sawArg {
+			name = strings.TrimPrefix(name, "-")
+			if strings.Contains(name, "=") {
+				continue
+			}
+			if f := lookupFlag(cmd, name); f != nil && !isBoolFlag(f) {
+				valueOf = f
+			}
+			continue
+		}
+		if !sawArg {
+			if i := slices.IndexFunc(cmd.Subcommands, func(c *ffcli.Command) bool { return c.Name == w }); i >= 0 {
+				cmd = cmd.Subcommands[i]
+				path = append(path, cmd.Name)
+				continue
+			}
+		}
+		sawArg = true
+	}
+	cmdPath := strings.Join(path, " ")
+
+	var prefix, rest string // cur is prefix+rest; rest is completed
+	var cands []string
+	switch {
+	case valueOf != nil:
+		rest = cur
+		cands = runCompleter(ctx, flagCompleters[cmdPath+" --"+valueOf.Name])
+	case strings.HasPrefix(cur, "-") && strings.Contains(cur, "="):
+		name, value, _ := strings.Cut(cur, "=")
+		prefix, rest = name+"=", value
+		cands = runCompleter(ctx, flagCompleters[cmdPath+" --"+strings.TrimLeft(name, "-")])
+	case strings.HasPrefix(cur, "-"):
+		rest = cur
+		if cmd.FlagSet != nil {
+			cmd.FlagSet.VisitAll(func(f *flag.Flag) {
+				if !strings.HasPrefix(f.Usage, "HIDDEN: ") {
+					cands = append(cands, "--"+f.Name)
+				}
+			})
+		}
+	default:
+		rest = cur
+		if user, host, ok := strings.Cut(cur, "@"); ok && cmdPath == "ssh" {
+			prefix, rest = user+"@", host
+		}
+		if !sawArg {
+			for _, c := range cmd.Subcommands {
+				cands = append(cands, c.Name)
+			}
+		}
+		cands = append(cands, runCompleter(ctx, argCompleters[cmdPath])...)
+	}
+
+	var ret []string
+	for _, c := range cands {
+		if strings.HasPrefix(c, rest) {
+			ret = append(ret, prefix+c)
+		}
+	}
+	return ret
+}
+
+// lookupFlag returns the flag of cmd with the given name, or nil if there's
+// none.
+func lookupFlag(cmd *ffcli.Command, name string) *flag.Flag {
+	if cmd.FlagSet == nil {
+		return nil
+	}
+	return cmd.FlagSet.Lookup(name)
+}
+
+// runCompleter returns the candidates of fn, if it's non-nil. If fn
+// fails, such as because tailscaled isn't running, its error is ignored
+// and whatever candidates it still returned are used.
+func runCompleter(ctx context.Context, fn completeFunc) []string {
+	if fn == nil {
+		return nil
+	}
+	cands, _ := fn(ctx)
+	return cands
+}
+
+// completePeerNames completes the MagicDNS names of peers.
+func completePeerNames(ctx context.Context) ([]string, error) {
+	st, err := localClient.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, ps := range st.Peer {
+		if name := dnsname.FirstLabel(ps.DNSName); name != "" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// completeExitNodes completes the MagicDNS names of exit nodes, and
+// "auto".
+func completeExitNodes(ctx context.Context) ([]string, error) {
+	names := []string{"auto"}
+	st, err := localClient.Status(ctx)
+	if err != nil {
+		return names, err
+	}
+	for _, ps := range st.Peer {
+		if name := dnsname.FirstLabel(ps.DNSName); ps.ExitNodeOption && name != "" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names[1:])
+	return names, nil
+}
+
+// completeFileTargets completes the targets of "tailscale file cp", as
+// "<name>:".
+func completeFileTargets(ctx context.Context) ([]string, error) {
+	fts, err := localClient.FileTargets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	for _, ft := range fts {
+		if name := dnsname.FirstLabel(ft.Node.Name); name != "" {
+			targets = append(targets, name+":")
+		}
+	}
+	slices.Sort(targets)
+	return targets, nil
+}
@@ -0,0 +1,75 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/tstest"
+)
+
+func TestComplete(t *testing.T) {
+	peers := func(context.Context) ([]string, error) { return []string{"alpha", "beta"}, nil }
+	exitNodes := func(context.Context) ([]string, error) { return []string{"auto", "exit1"}, nil }
+	tstest.Replace(t, &argCompleters, map[string]completeFunc{
+		"ping":    peers,
+		"ssh":     peers,
+		"file cp": peers,
+	})
+	tstest.Replace(t, &flagCompleters, map[string]completeFunc{
+		"set --exit-node": exitNodes,
+	})
+
+	setFS := flag.NewFlagSet("set", flag.ContinueOnError)
+	setFS.String("exit-node", "", "")
+	setFS.Bool("exit-node-allow-lan-access", false, "")
+	setFS.Bool("shields-up", false, "")
+	setFS.Bool("secret", false, "HIDDEN: not shown")
+	pingFS := flag.NewFlagSet("ping", flag.ContinueOnError)
+	pingFS.Int("c", 10, "")
+	root := &ffcli.Command{
+		Name: "tailscale",
+		Subcommands: []*ffcli.Command{
+			{Name: "set", FlagSet: setFS},
+			{Name: "ping", FlagSet: pingFS},
+			{Name: "ssh"},
+			{Name: "status"},
+			{Name: "file", Subcommands: []*ffcli.Command{{Name: "cp"}, {Name: "get"}}},
+		},
+	}
+
+	tests := []struct {
+		line string // words; the last is completed
+		want []string
+	}{
+		{"", []string{"set", "ping", "ssh", "status", "file"}},
+		{"s", []string{"set", "ssh", "status"}},
+		{"set --", []string{"--exit-node", "--exit-node-allow-lan-access", "--shields-up"}},
+		{"set --exit-node ", []string{"auto", "exit1"}},
+		{"set --exit-node e", []string{"exit1"}},
+		{"set --exit-node=", []string{"--exit-node=auto", "--exit-node=exit1"}},
+		{"set --shields-up --exit-node=a", []string{"--exit-node=auto"}},
+		{"set --shields-up ", nil},
+		{"ping ", []string{"alpha", "beta"}},
+		{"ping -c 3 b", []string{"beta"}},
+		{"ping alpha ", []string{"alpha", "beta"}},
+		{"ssh root@a", []string{"root@alpha"}},
+		{"file ", []string{"cp", "get"}},
+		{"file cp ", []string{"alpha", "beta"}},
+		{"file get ", nil},
+		{"status ", nil},
+	}
+	for _, tt := range tests {
+		words := strings.Split(tt.line, " ")
+		got := complete(context.Background(), root, words[:len(words)-1], words[len(words)-1])
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("complete(%q) = %q; want %q", tt.line, got, tt.want)
+		}
+	}
+}
@@ -22,8 +22,10 @@
 	"os"
 	"os/exec"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
@@ -33,15 +35,18 @@
 	"tailscale.com/control/controlhttp"
 	"tailscale.com/hostinfo"
 	"tailscale.com/ipn"
+	"tailscale.com/net/netcheck"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tshttpproxy"
 	"tailscale.com/paths"
 	"tailscale.com/safesocket"
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/appctype"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/must"
 	"tailscale.com/wgengine/capture"
+	"tailscale.com/wgengine/filter"
 )
 
 var debugCmd = &ffcli.Command{
@@ -73,6 +78,22 @@
 				return fs
 			})(),
 		},
+		{
+			Name:       "map-log-level",
+			Exec:       runDebugMapLogLevel,
+			ShortHelp:  "temporarily change the verbosity of map session logs",
+			ShortUsage: "tailscale debug map-log-level [default|summary|response|peer]",
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("map-log-level")
+				fs.DurationVar(&debugMapLogLevelArgs.forDur, "for", 10*time.Minute, "how long to use the level for before reverting to default")
+				return fs
+			})(),
+		},
+		{
+			Name:      "map-traces",
+			Exec:      runDebugMapTraces,
+			ShortHelp: "print the latencies of map responses from receipt to engine reconfiguration",
+		},
 		{
 			Name:      "daemon-goroutines",
 			Exec:      runDaemonGoroutines,
@@ -160,6 +181,23 @@
 			Exec:      debugControlKnobs,
 			ShortHelp: "see current control knobs",
 		},
+		{
+			Name:      "app-connector-status",
+			Exec:      debugAppConnectorStatus,
+			ShortHelp: "print app connector services, the policy grants that own them, and their health",
+		},
+		{
+			Name:       "appconnector-check",
+			Exec:       debugAppConnectorCheck,
+			ShortHelp:  "check an app connector config for problems",
+			ShortUsage: "tailscale debug appconnector-check [<config.json>|-]",
+			LongHelp: strings.TrimSpace(`
+Checks the app connector config in the given JSON file, or on standard input
+if the file is "-", for services with overlapping listen addresses, invalid
+port ranges, no destinations, or malformed domains. With no file, the
+node's active app connector config is checked.
+`),
+		},
 		{
 			Name:      "prefs",
 			Exec:      runPrefs,
@@ -225,15 +263,58 @@
 			ShortHelp: "test a DERP configuration",
 		},
 		{
-			Name:      "capture",
-			Exec:      runCapture,
-			ShortHelp: "streams pcaps for debugging",
+			Name:       "capture",
+			Exec:       runCapture,
+			ShortUsage: "capture [flags] [filter expression]",
+			ShortHelp:  "streams pcaps for debugging",
+			LongHelp: `"tailscale debug capture" streams the packets traversing tailscaled.
+
+The optional filter expression selects the packets to capture, using a subset
+of the tcpdump syntax: [src|dst] host <ip>, [src|dst] net <cidr>,
+[src|dst] port <n>, [src|dst] portrange <n>-<m>, ip, ip6, tcp, udp, icmp,
+icmp6, sctp and disco, combined with and, or, not and parentheses. For example:
+
+  tailscale debug capture -o ssh.pcap tcp and port 22`,
 			FlagSet: (func() *flag.FlagSet {
 				fs := newFlagSet("capture")
 				fs.StringVar(&captureArgs.outFile, "o", "", "path to stream the pcap (or - for stdout), leave empty to start wireshark")
+				fs.StringVar(&captureArgs.format, "format", "pcap", `capture format: "pcap" or "pcapng"`)
+				fs.IntVar(&captureArgs.snaplen, "snaplen", 0, "if non-zero, the most bytes of each packet to capture")
 				return fs
 			})(),
 		},
+		{
+			Name:      "flows",
+			Exec:      runDebugFlows,
+			ShortHelp: "print the new flows seen by the packet filter and its verdicts",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug flows' command prints the recent new flows seen by the
+packet filter, with the rule that matched each one, if any, and whether it
+was accepted or dropped. The packet filter only starts recording them the
+first time it's run.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("flows")
+				fs.BoolVar(&debugFlowsArgs.follow, "follow", false, "keep printing new flows as they're seen")
+				fs.BoolVar(&debugFlowsArgs.json, "json", false, "print the flows as JSON lines")
+				return fs
+			})(),
+		},
+		{
+			Name:      "netcheck-history",
+			Exec:      runDebugNetcheckHistory,
+			ShortHelp: "print tailscaled's recent netcheck reports and how they changed",
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("netcheck-history")
+				fs.BoolVar(&debugNetcheckHistoryArgs.json, "json", false, "print the full reports as JSON")
+				return fs
+			})(),
+		},
+		{
+			Name:      "derp-race",
+			Exec:      runDebugDERPRace,
+			ShortHelp: "print the home and standby DERP regions and the last home failover race, as JSON",
+		},
 		{
 			Name:      "portmap",
 			Exec:      debugPortmap,
@@ -549,6 +630,85 @@ func runDaemonLogs(ctx context.Context, args []string) error {
 	}
 }
 
+var debugFlowsArgs struct {
+	follow bool
+	json   bool
+}
+
+func runDebugFlows(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	rc, err := localClient.StreamDebugFlows(ctx, debugFlowsArgs.follow)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	d := json.NewDecoder(rc)
+	for {
+		var ev filter.FlowEvent
+		if err := d.Decode(&ev); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if debugFlowsArgs.json {
+			j, _ := json.Marshal(ev)
+			outln(string(j))
+			continue
+		}
+		rule := ev.Rule
+		if rule == "" {
+			rule = "-"
+		}
+		printf("%s %-3s %-6v %v -> %v %s (%s) rule %s\n", ev.Time.Local().Format("15:04:05.000"), ev.Dir, ev.Proto, ev.Src, ev.Dst, ev.Verdict, ev.Reason, rule)
+	}
+}
+
+func runDebugDERPRace(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	j, err := localClient.DebugDERPRace(ctx)
+	if err != nil {
+		return err
+	}
+	Stdout.Write(j)
+	return nil
+}
+
+var debugNetcheckHistoryArgs struct {
+	json bool
+}
+
+func runDebugNetcheckHistory(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	j, err := localClient.DebugNetcheckHistory(ctx)
+	if err != nil {
+		return err
+	}
+	if debugNetcheckHistoryArgs.json {
+		Stdout.Write(j)
+		return nil
+	}
+	var history []netcheck.HistoryEntry
+	if err := json.Unmarshal(j, &history); err != nil {
+		return err
+	}
+	for _, e := range history {
+		r := e.Report
+		printf("%s derp=%d udp=%v ipv6=%v mapping-varies-by-dest-ip=%v portmap=%q\n",
+			e.Time.Local().Format("2006-01-02 15:04:05"), r.PreferredDERP, r.UDP, r.IPv6, r.MappingVariesByDestIP, portMapping(r))
+		for _, c := range e.Changes {
+			printf("\t%s\n", c)
+		}
+	}
+	return nil
+}
+
 var metricsArgs struct {
 	watch bool
 }
@@ -767,6 +927,38 @@ func runDebugComponentLogs(ctx context.Context, args []string) error {
 	return nil
 }
 
+var debugMapLogLevelArgs struct {
+	forDur time.Duration
+}
+
+func runDebugMapLogLevel(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: debug map-log-level [default|summary|response|peer]")
+	}
+	level, dur := args[0], debugMapLogLevelArgs.forDur
+	if err := localClient.SetMapLogLevel(ctx, level, dur); err != nil {
+		return err
+	}
+	if level == "default" || dur <= 0 {
+		fmt.Printf("Reset map session log level to default\n")
+	} else {
+		fmt.Printf("Set map session log level to %q for %v\n", level, dur)
+	}
+	return nil
+}
+
+func runDebugMapTraces(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	traces, err := localClient.DebugMapTraces(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = Stdout.Write(traces)
+	return err
+}
+
 var devStoreSetArgs struct {
 	danger bool
 }
@@ -814,10 +1006,24 @@ func runSetExpire(ctx context.Context, args []string) error {
 
 var captureArgs struct {
 	outFile string
+	format  string
+	snaplen int
 }
 
 func runCapture(ctx context.Context, args []string) error {
-	stream, err := localClient.StreamDebugCapture(ctx)
+	// Like tcpdump, any arguments are the filter expression.
+	filter := strings.Join(args, " ")
+	if _, err := capture.ParseFilter(filter); err != nil {
+		return err
+	}
+	if _, err := capture.ParseFormat(captureArgs.format); err != nil {
+		return err
+	}
+	stream, err := localClient.StreamDebugCaptureWithOpts(ctx, &tailscale.DebugCaptureOpts{
+		Filter:  filter,
+		Format:  captureArgs.format,
+		Snaplen: captureArgs.snaplen,
+	})
 	if err != nil {
 		return err
 	}
@@ -924,18 +1130,11 @@ func runPeerEndpointChanges(ctx context.Context, args []string) error {
 		log.Printf("lookup %q => %q", hostOrIP, ip)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", "http://local-tailscaled.sock/localapi/v0/debug-peer-endpoint-changes?ip="+ip, nil)
+	ipp, err := netip.ParseAddr(ip)
 	if err != nil {
 		return err
 	}
-
-	resp, err := localClient.DoLocalRequest(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	body, err := localClient.DebugPeerEndpointChanges(ctx, ipp)
 	if err != nil {
 		return err
 	}
@@ -952,6 +1151,101 @@ func runPeerEndpointChanges(ctx context.Context, args []string) error {
 	return nil
 }
 
+func debugAppConnectorStatus(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	st, err := localClient.AppConnectorStatus(ctx)
+	if err != nil {
+		return err
+	}
+	if len(st) == 0 {
+		outln("no app connector services")
+		return printAppConnectorDrains(ctx)
+	}
+	tw := tabwriter.NewWriter(Stdout, 0, 2, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tADDRS\tPORTS\tDESTINATIONS\tPROVENANCE\tPROBE\tCONNS")
+	for _, s := range st {
+		addrs := make([]string, len(s.Addrs))
+		for i, a := range s.Addrs {
+			addrs[i] = a.String()
+		}
+		ports := make([]string, len(s.IP))
+		for i, p := range s.IP {
+			ports[i] = p.String()
+		}
+		dests := make([]string, len(s.Destinations))
+		for i, d := range s.Destinations {
+			dests[i] = d
+			if slices.Contains(s.Unhealthy, d) {
+				dests[i] += " (unhealthy)"
+			}
+		}
+		conns := fmt.Sprintf("%d open, %d dials, %d errors", s.ActiveConns, s.Dials, s.ConnectErrors)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%v\t%v\t%s\n", s.Service, strings.Join(addrs, ","), strings.Join(ports, ","), strings.Join(dests, ","), s.Provenance, s.Probe, conns)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return printAppConnectorDrains(ctx)
+}
+
+// printAppConnectorDrains prints the services removed from the app connector
+// configuration whose connections are still draining.
+func printAppConnectorDrains(ctx context.Context) error {
+	drains, err := localClient.AppConnectorDrains(ctx)
+	if err != nil {
+		return err
+	}
+	for _, d := range drains {
+		printf("draining removed service %s: %d connections open, closing in %v\n",
+			d.Service, d.ActiveConns, time.Until(d.Deadline).Round(time.Second))
+	}
+	return nil
+}
+
+func debugAppConnectorCheck(ctx context.Context, args []string) error {
+	var cfg *appctype.AppConnectorConfig
+	switch len(args) {
+	case 0:
+		var err error
+		cfg, _, err = localClient.GetAppConnectorConfig(ctx)
+		if err != nil {
+			return err
+		}
+		if cfg == nil {
+			outln("app connector not configured")
+			return nil
+		}
+	case 1:
+		var b []byte
+		var err error
+		if args[0] == "-" {
+			b, err = io.ReadAll(os.Stdin)
+		} else {
+			b, err = os.ReadFile(args[0])
+		}
+		if err != nil {
+			return err
+		}
+		cfg = new(appctype.AppConnectorConfig)
+		if err := json.Unmarshal(b, cfg); err != nil {
+			return fmt.Errorf("decoding config: %w", err)
+		}
+	default:
+		return errors.New("usage: tailscale debug appconnector-check [<config.json>|-]")
+	}
+	var verrs appctype.ValidationErrors
+	if err := appctype.Validate(cfg); !errors.As(err, &verrs) {
+		outln("no problems found")
+		return nil
+	}
+	for _, ve := range verrs {
+		outln(ve.Error())
+	}
+	return fmt.Errorf("found %d problems", len(verrs))
+}
+
 func debugControlKnobs(ctx context.Context, args []string) error {
 	if len(args) > 0 {
 		return errors.New("unexpected arguments")
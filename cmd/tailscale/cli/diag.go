@@ -21,6 +21,12 @@
 // returns either the same error or a better one to help the user
 // understand why tailscaled isn't running for their platform.
 func fixTailscaledConnectError(origErr error) error {
+	return tailscaledConnectError{diagnoseConnectError(origErr), origErr}
+}
+
+// diagnoseConnectError returns the error for fixTailscaledConnectError to
+// return.
+func diagnoseConnectError(origErr error) error {
 	procs, err := ps.Processes()
 	if err != nil {
 		return fmt.Errorf("failed to connect to local Tailscaled process and failed to enumerate processes while looking for it")
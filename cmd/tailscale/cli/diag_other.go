@@ -11,5 +11,5 @@
 // so just don't diagnose connect failures.
 
 func fixTailscaledConnectError(origErr error) error {
-	return fmt.Errorf("failed to connect to local tailscaled process (is it running?); got: %w", origErr)
+	return tailscaledConnectError{fmt.Errorf("failed to connect to local tailscaled process (is it running?); got: %w", origErr), origErr}
 }
@@ -0,0 +1,169 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/types/dnstype"
+)
+
+var dnsCmd = &ffcli.Command{
+	Name:       "dns",
+	ShortUsage: "dns <subcommand> [command flags]",
+	ShortHelp:  "Diagnose the DNS resolver",
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "query",
+			ShortUsage: "dns query [--json] <name> [a|aaaa|cname|mx|ns|ptr|soa|srv|txt]",
+			ShortHelp:  "Resolve a name like the system would, showing how",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale dns query' command resolves a name through Tailscale's DNS
+resolver, with the same search domains, split DNS routes, MagicDNS records and
+fallback resolvers that queries from the system get. For each name tried, it
+shows which rule handled the query and which upstream resolver answered.
+`),
+			Exec: runDNSQuery,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("query")
+				fs.BoolVar(&dnsQueryArgs.json, "json", false, "output the trace as JSON")
+				return fs
+			})(),
+		},
+		{
+			Name:       "flush",
+			ShortUsage: "dns flush",
+			ShortHelp:  "Empty the cache of responses from upstream resolvers",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale dns flush' command empties Tailscale's DNS resolver's cache of
+responses from upstream resolvers, enabled with 'tailscale set --dns-cache'.
+It doesn't flush the operating system's own DNS cache.
+`),
+			Exec: runDNSFlush,
+		},
+		{
+			Name:       "status",
+			ShortUsage: "dns status [--json]",
+			ShortHelp:  "Show how Tailscale configures the system's DNS settings",
+			Exec:       runDNSStatus,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("status")
+				fs.BoolVar(&dnsStatusArgs.json, "json", false, "output the status as JSON")
+				return fs
+			})(),
+		},
+	},
+	Exec: func(context.Context, []string) error {
+		return errors.New("dns subcommand required; run 'tailscale dns -h' for details")
+	},
+}
+
+var dnsQueryArgs struct {
+	json bool
+}
+
+var dnsStatusArgs struct {
+	json bool
+}
+
+func runDNSQuery(ctx context.Context, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: tailscale dns query <name> [type]")
+	}
+	queryType := "A"
+	if len(args) == 2 {
+		queryType = strings.ToUpper(args[1])
+	}
+	trace, err := localClient.QueryDNS(ctx, args[0], queryType)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if dnsQueryArgs.json {
+		j, err := json.MarshalIndent(trace, "", "  ")
+		if err != nil {
+			return err
+		}
+		outln(string(j))
+		return nil
+	}
+	printDNSQueryTrace(Stdout, trace)
+	return nil
+}
+
+func runDNSFlush(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	if err := localClient.FlushDNSCache(ctx); err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	return nil
+}
+
+func runDNSStatus(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	st, err := localClient.DNSOSConfiguratorStatus(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if dnsStatusArgs.json {
+		printJSON(st)
+		return nil
+	}
+	printf("OS configurator: %s\n", st.Mode)
+	printf("Split DNS supported: %v\n", st.SupportsSplitDNS)
+	return nil
+}
+
+// printDNSQueryTrace writes a human-readable form of trace to w.
+func printDNSQueryTrace(w io.Writer, trace *dnstype.QueryTrace) {
+	fmt.Fprintf(w, "Query for %s (%s):\n", trace.Name, trace.Type)
+	for _, at := range trace.Attempts {
+		fmt.Fprintf(w, "\n%s", at.FQDN)
+		if at.SearchDomain != "" {
+			fmt.Fprintf(w, " (with search domain %s)", at.SearchDomain)
+		}
+		fmt.Fprintln(w)
+		switch at.Source {
+		case "magicdns":
+			fmt.Fprintf(w, "  handled by: MagicDNS\n")
+		case "route":
+			fmt.Fprintf(w, "  handled by: split DNS route for %s\n", at.Route)
+		case "default":
+			fmt.Fprintf(w, "  handled by: default resolvers\n")
+		case "cloud":
+			fmt.Fprintf(w, "  handled by: cloud provider's resolver (fallback)\n")
+		default:
+			fmt.Fprintf(w, "  handled by: nothing (no resolvers configured)\n")
+		}
+		if len(at.Resolvers) > 0 {
+			addrs := make([]string, len(at.Resolvers))
+			for i, r := range at.Resolvers {
+				addrs[i] = r.Addr
+			}
+			fmt.Fprintf(w, "  upstreams: %s\n", strings.Join(addrs, ", "))
+		}
+		if at.Upstream != "" {
+			fmt.Fprintf(w, "  answered by: %s\n", at.Upstream)
+		}
+		result := at.RCode
+		if at.Err != "" {
+			result = strings.TrimSpace(result + " error: " + at.Err)
+		}
+		fmt.Fprintf(w, "  result: %s (%v)\n", result, at.Duration.Round(time.Microsecond))
+		for _, a := range at.Answers {
+			fmt.Fprintf(w, "    %s\n", a)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/types/dnstype"
+)
+
+func TestPrintDNSQueryTrace(t *testing.T) {
+	var buf strings.Builder
+	printDNSQueryTrace(&buf, &dnstype.QueryTrace{
+		Name: "db",
+		Type: "A",
+		Attempts: []*dnstype.QueryAttempt{
+			{
+				FQDN:         "db.tailnet.ts.net.",
+				SearchDomain: "tailnet.ts.net.",
+				Source:       "magicdns",
+				RCode:        "NXDOMAIN",
+				Duration:     50 * time.Microsecond,
+			},
+			{
+				FQDN:         "db.corp.example.",
+				SearchDomain: "corp.example.",
+				Source:       "route",
+				Route:        "corp.example.",
+				Resolvers:    []*dnstype.Resolver{{Addr: "10.0.0.1"}, {Addr: "10.0.0.2"}},
+				Upstream:     "10.0.0.2",
+				RCode:        "NOERROR",
+				Answers:      []string{"db.corp.example. 300 IN A 10.1.2.3"},
+				Duration:     12 * time.Millisecond,
+			},
+		},
+	})
+	want := `Query for db (A):
+
+db.tailnet.ts.net. (with search domain tailnet.ts.net.)
+  handled by: MagicDNS
+  result: NXDOMAIN (50µs)
+
+db.corp.example. (with search domain corp.example.)
+  handled by: split DNS route for corp.example.
+  upstreams: 10.0.0.1, 10.0.0.2
+  answered by: 10.0.0.2
+  result: NOERROR (12ms)
+    db.corp.example. 300 IN A 10.1.2.3
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/doctor"
+)
+
+var doctorCmd = &ffcli.Command{
+	Name:       "doctor",
+	ShortUsage: "doctor [--output=<file>]",
+	ShortHelp:  "Diagnose problems and write a support bundle",
+	LongHelp: strings.TrimSpace(`
+"tailscale doctor" has tailscaled probe its connectivity to the control
+plane, the DERP regions, the local network's port mapping services and
+DNS, and shows the results. It also writes a support bundle: an archive of
+the results, tailscaled's other checks, a summary of the network map and
+the tailscaled logs written while the probes ran. IP addresses, keys,
+email addresses and the names of nodes and the tailnet are redacted from
+the checks and logs, and the network map summary leaves out names,
+addresses and keys. Attach the bundle to your support ticket.
+`),
+	Exec: runDoctor,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("doctor")
+		fs.StringVar(&doctorArgs.output, "output", "", `file to write the bundle to, or "-" for stdout; defaults to tailscale-doctor-<time>.tar.gz`)
+		fs.BoolVar(&doctorArgs.json, "json", false, "output the results in JSON format")
+		return fs
+	})(),
+}
+
+var doctorArgs struct {
+	output string
+	json   bool
+}
+
+// doctorJSON is the output of "tailscale doctor --json".
+type doctorJSON struct {
+	File   string // where the bundle was written
+	Probes []doctor.ProbeResult
+}
+
+func runDoctor(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	if doctorArgs.json && doctorArgs.output == "-" {
+		return usageError{errors.New("can't use --json with --output=-")}
+	}
+	out := Stdout
+	if doctorArgs.output == "-" {
+		out = Stderr
+	}
+	if !doctorArgs.json {
+		fmt.Fprintln(out, "Running diagnostics; this can take a few seconds...")
+	}
+	bundle, err := localClient.DoctorBundle(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+
+	var probes []doctor.ProbeResult
+	pj, err := doctor.ReadBundleFile(bytes.NewReader(bundle), doctor.BundleProbesFile)
+	if err != nil {
+		return fmt.Errorf("reading bundle: %w", err)
+	}
+	if err := json.Unmarshal(pj, &probes); err != nil {
+		return fmt.Errorf("reading bundle: %w", err)
+	}
+
+	file := doctorArgs.output
+	switch file {
+	case "-":
+		if _, err := Stdout.Write(bundle); err != nil {
+			return err
+		}
+	case "":
+		file = fmt.Sprintf("tailscale-doctor-%s.tar.gz", time.Now().Format("20060102-150405"))
+		fallthrough
+	default:
+		if err := os.WriteFile(file, bundle, 0600); err != nil {
+			return err
+		}
+	}
+
+	if doctorArgs.json {
+		printJSON(doctorJSON{File: file, Probes: probes})
+		return nil
+	}
+	printDoctorProbes(out, probes)
+	if file != "-" {
+		fmt.Fprintf(out, "\nWrote support bundle to %s\n", file)
+	}
+	return nil
+}
+
+// printDoctorProbes writes the table of probe results of "tailscale doctor"
+// to w.
+func printDoctorProbes(w io.Writer, probes []doctor.ProbeResult) {
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROBE\tRESULT\tLATENCY\tDETAIL")
+	for _, p := range probes {
+		result, latency, detail := "ok", "-", p.Detail
+		if !p.OK {
+			result, detail = "FAIL", p.Error
+		}
+		if p.Latency > 0 {
+			latency = p.Latency.Round(100 * time.Microsecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.Name, result, latency, detail)
+	}
+	tw.Flush()
+}
@@ -6,10 +6,14 @@
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"mime"
 	"net/http"
@@ -25,6 +29,7 @@
 	"github.com/mattn/go-isatty"
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"golang.org/x/time/rate"
+	"tailscale.com/client/tailscale"
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/envknob"
 	"tailscale.com/net/tsaddr"
@@ -65,22 +70,56 @@ func (c *countingReader) Read(buf []byte) (int, error) {
 
 var fileCpCmd = &ffcli.Command{
 	Name:       "cp",
-	ShortUsage: "file cp <files...> <target>:",
+	ShortUsage: "file cp [--recursive] <files...> <target>:",
 	ShortHelp:  "Copy file(s) to a host",
-	Exec:       runCp,
+	LongHelp: strings.TrimSpace(`
+'tailscale file cp' sends files to a host's Tailscale file inbox.
+
+Each file is checked by the receiving host before it's accepted. If sending a
+file fails part way, for instance because the connection to the host broke,
+it's sent again, resuming from where the host got to.
+
+With --recursive, directories are sent along with all the files in them.
+Empty directories are not sent.
+`),
+	Exec: runCp,
 	FlagSet: (func() *flag.FlagSet {
 		fs := newFlagSet("cp")
 		fs.StringVar(&cpArgs.name, "name", "", "alternate filename to use, especially useful when <file> is \"-\" (stdin)")
+		fs.BoolVar(&cpArgs.recursive, "recursive", false, "send directories, including all the files in them")
+		fs.IntVar(&cpArgs.retries, "retries", 3, "number of times to retry sending a file after a temporary failure")
 		fs.BoolVar(&cpArgs.verbose, "verbose", false, "verbose output")
 		fs.BoolVar(&cpArgs.targets, "targets", false, "list possible file cp targets")
+		fs.BoolVar(&cpArgs.json, "json", false, "output a line of JSON for each file sent, or with --targets, the targets as JSON")
 		return fs
 	})(),
 }
 
 var cpArgs struct {
-	name    string
-	verbose bool
-	targets bool
+	name      string
+	recursive bool
+	retries   int
+	verbose   bool
+	targets   bool
+	json      bool
+}
+
+// fileJSON is the output of "tailscale file cp --json" and "tailscale file
+// get --json" for each file sent or received.
+type fileJSON struct {
+	Name   string
+	Target string `json:",omitempty"` // the target it was sent to
+	Path   string `json:",omitempty"` // where it was received to
+	Size   int64
+}
+
+// fileTargetJSON is the output of "tailscale file cp --targets --json"
+// for each target.
+type fileTargetJSON struct {
+	Name     string
+	IP       netip.Addr
+	Online   *bool      `json:",omitempty"` // nil if unknown
+	LastSeen *time.Time `json:",omitempty"`
 }
 
 func runCp(ctx context.Context, args []string) error {
@@ -129,69 +168,195 @@ func runCp(ctx context.Context, args []string) error {
 		}
 	}
 
+	t := &cpTarget{name: target, ip: ip, stableID: stableID}
 	for _, fileArg := range files {
-		var fileContents *countingReader
-		var name = cpArgs.name
-		var contentLength int64 = -1
 		if fileArg == "-" {
-			fileContents = &countingReader{Reader: os.Stdin}
-			if name == "" {
-				name, fileContents, err = pickStdinFilename()
-				if err != nil {
-					return err
-				}
-			}
-		} else {
-			f, err := os.Open(fileArg)
-			if err != nil {
-				if version.IsSandboxedMacOS() {
-					return errors.New("the GUI version of Tailscale on macOS runs in a macOS sandbox that can't read files")
-				}
-				return err
-			}
-			defer f.Close()
-			fi, err := f.Stat()
-			if err != nil {
+			if err := t.sendStdin(ctx); err != nil {
 				return err
 			}
-			if fi.IsDir() {
-				return errors.New("directories not supported")
-			}
-			contentLength = fi.Size()
-			fileContents = &countingReader{Reader: io.LimitReader(f, contentLength)}
-			if name == "" {
-				name = filepath.Base(fileArg)
+			continue
+		}
+		fi, err := os.Stat(fileArg)
+		if err != nil {
+			if version.IsSandboxedMacOS() {
+				return errors.New("the GUI version of Tailscale on macOS runs in a macOS sandbox that can't read files")
 			}
-
-			if envknob.Bool("TS_DEBUG_SLOW_PUSH") {
-				fileContents = &countingReader{Reader: &slowReader{r: fileContents}}
+			return err
+		}
+		name := cpArgs.name
+		if name == "" {
+			name = filepath.Base(fileArg)
+		}
+		if fi.IsDir() {
+			if !cpArgs.recursive {
+				return fmt.Errorf("%s is a directory; use --recursive to send directories", fileArg)
 			}
+			err = t.sendDir(ctx, fileArg, name)
+		} else {
+			_, err = t.sendFile(ctx, fileArg, name)
+		}
+		if err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		if cpArgs.verbose {
-			log.Printf("sending %q to %v/%v/%v ...", name, target, ip, stableID)
+// cpTarget is the host that "tailscale file cp" sends files to.
+type cpTarget struct {
+	name     string // as given on the command line
+	ip       string
+	stableID tailcfg.StableNodeID
+}
+
+// sendStdin sends the contents of stdin.
+// Unlike files, it's sent without a checksum, and not retried.
+func (t *cpTarget) sendStdin(ctx context.Context) error {
+	fileContents := &countingReader{Reader: os.Stdin}
+	name := cpArgs.name
+	if name == "" {
+		var err error
+		name, fileContents, err = pickStdinFilename()
+		if err != nil {
+			return err
 		}
+	}
+	return t.push(ctx, name, fileContents, -1, nil)
+}
 
-		var group syncs.WaitGroup
-		ctxProgress, cancelProgress := context.WithCancel(ctx)
-		defer cancelProgress()
-		if isatty.IsTerminal(os.Stderr.Fd()) {
-			group.Go(func() { progressPrinter(ctxProgress, name, fileContents.n.Load, contentLength) })
+// sendFile sends the file at path as name, retrying after temporary
+// failures, and returns its entry in the manifest of a directory.
+func (t *cpTarget) sendFile(ctx context.Context, path, name string) (apitype.FileManifestEntry, error) {
+	size, sum, err := hashFile(path)
+	if err != nil {
+		return apitype.FileManifestEntry{}, err
+	}
+	err = t.retry(ctx, name, func() error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fileContents := &countingReader{Reader: io.LimitReader(f, size)}
+		if envknob.Bool("TS_DEBUG_SLOW_PUSH") {
+			fileContents = &countingReader{Reader: &slowReader{r: fileContents}}
 		}
+		return t.push(ctx, name, fileContents, size, sum)
+	})
+	return apitype.FileManifestEntry{Name: name, Size: size, SHA256: hex.EncodeToString(sum)}, err
+}
 
-		err := localClient.PushFile(ctx, stableID, contentLength, name, fileContents)
-		cancelProgress()
-		group.Wait() // wait for progress printer to stop before reporting the error
+// sendDir sends the files in the directory at dir, and its subdirectories,
+// as the directory name, followed by the directory's manifest.
+func (t *cpTarget) sendDir(ctx context.Context, dir, name string) error {
+	var manifest apitype.FileManifest
+	err := filepath.WalkDir(dir, func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if de.IsDir() {
+			return nil
+		}
+		if !de.Type().IsRegular() {
+			fmt.Fprintf(Stderr, "# skipping %s: not a regular file\n", p)
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if path.Base(rel) == apitype.FileManifestName {
+			return fmt.Errorf("can't send %s: reserved filename", p)
+		}
+		f, err := t.sendFile(ctx, p, path.Join(name, rel))
 		if err != nil {
 			return err
 		}
-		if cpArgs.verbose {
-			log.Printf("sent %q", name)
+		f.Name = rel
+		manifest.Files = append(manifest.Files, f)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(manifest.Files) == 0 {
+		fmt.Fprintf(Stderr, "# warning: %s has no files to send\n", dir)
+		return nil
+	}
+	mj, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return t.retry(ctx, name, func() error {
+		return localClient.PushFile(ctx, t.stableID, int64(len(mj)), path.Join(name, apitype.FileManifestName), bytes.NewReader(mj))
+	})
+}
+
+// retry calls send, which sends the file name, until it succeeds, fails
+// other than temporarily, or fails cpArgs.retries more times.
+func (t *cpTarget) retry(ctx context.Context, name string, send func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := send()
+		var pe *tailscale.PushFileError
+		if err == nil || attempt > cpArgs.retries || !errors.As(err, &pe) || !pe.Temporary() {
+			return err
+		}
+		delay := time.Duration(attempt) * time.Second
+		fmt.Fprintf(Stderr, "# sending %q failed: %v; retrying in %v\n", name, err, delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
 		}
 	}
+}
+
+// push sends the contents of fileContents as name, showing the progress
+// if stderr is a terminal. The size may be -1 if it's unknown, and sum is
+// the file's SHA-256 checksum, or nil if it's unknown.
+func (t *cpTarget) push(ctx context.Context, name string, fileContents *countingReader, size int64, sum []byte) error {
+	if cpArgs.verbose {
+		log.Printf("sending %q to %v/%v/%v ...", name, t.name, t.ip, t.stableID)
+	}
+
+	var group syncs.WaitGroup
+	ctxProgress, cancelProgress := context.WithCancel(ctx)
+	defer cancelProgress()
+	if isatty.IsTerminal(os.Stderr.Fd()) {
+		group.Go(func() { progressPrinter(ctxProgress, name, fileContents.n.Load, size) })
+	}
+
+	err := localClient.PushFileWithOptions(ctx, t.stableID, size, name, fileContents, tailscale.PushFileOptions{SHA256: sum})
+	cancelProgress()
+	group.Wait() // wait for progress printer to stop before reporting the error
+	if err != nil {
+		return err
+	}
+	if cpArgs.verbose {
+		log.Printf("sent %q", name)
+	}
+	if cpArgs.json {
+		printJSONLine(fileJSON{Name: name, Target: t.name, Size: fileContents.n.Load()})
+	}
 	return nil
 }
 
+// hashFile returns the size and SHA-256 checksum of the file at path.
+func hashFile(path string) (size int64, sum []byte, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err = io.Copy(h, f)
+	if err != nil {
+		return 0, nil, err
+	}
+	return size, h.Sum(nil), nil
+}
+
 func progressPrinter(ctx context.Context, name string, contentCount func() int64, contentLength int64) {
 	var rateValueFast, rateValueSlow tsrate.Value
 	rateValueFast.HalfLife = 1 * time.Second  // fast response for rate measurement
@@ -365,6 +530,20 @@ func runCpTargets(ctx context.Context, args []string) error {
 	if err != nil {
 		return err
 	}
+	if cpArgs.json {
+		targets := make([]fileTargetJSON, 0, len(fts))
+		for _, ft := range fts {
+			n := ft.Node
+			targets = append(targets, fileTargetJSON{
+				Name:     n.ComputedName,
+				IP:       n.Addresses[0].Addr(),
+				Online:   n.Online,
+				LastSeen: n.LastSeen,
+			})
+		}
+		printJSON(targets)
+		return nil
+	}
 	for _, ft := range fts {
 		n := ft.Node
 		var detail string
@@ -420,6 +599,7 @@ func (v *onConflict) Set(s string) error {
 		fs.BoolVar(&getArgs.wait, "wait", false, "wait for a file to arrive if inbox is empty")
 		fs.BoolVar(&getArgs.loop, "loop", false, "run get in a loop, receiving files as they come in")
 		fs.BoolVar(&getArgs.verbose, "verbose", false, "verbose output")
+		fs.BoolVar(&getArgs.json, "json", false, "output a line of JSON for each file received or error")
 		fs.Var(&getArgs.conflict, "conflict", `behavior when a conflicting (same-named) file already exists in the target directory.
 	skip:       skip conflicting files: leave them in the taildrop inbox and print an error. get any non-conflicting files
 	overwrite:  overwrite existing file
@@ -432,6 +612,7 @@ func (v *onConflict) Set(s string) error {
 	wait     bool
 	loop     bool
 	verbose  bool
+	json     bool
 	conflict onConflict
 }{conflict: skipOnExist}
 
@@ -484,13 +665,34 @@ func openFileOrSubstitute(dir, base string, action onConflict) (*os.File, error)
 	}
 }
 
+// inboxName returns the name by which wf is known to the inbox:
+// its path for files sent as part of a directory, else its name.
+func inboxName(wf apitype.WaitingFile) string {
+	if wf.Path != "" {
+		return wf.Path
+	}
+	return wf.Name
+}
+
 func receiveFile(ctx context.Context, wf apitype.WaitingFile, dir string) (targetFile string, size int64, err error) {
-	rc, size, err := localClient.GetWaitingFile(ctx, wf.Name)
+	inName := inboxName(wf)
+	rc, size, err := localClient.GetWaitingFile(ctx, inName)
 	if err != nil {
-		return "", 0, fmt.Errorf("opening inbox file %q: %w", wf.Name, err)
+		return "", 0, fmt.Errorf("opening inbox file %q: %w", inName, err)
 	}
 	defer rc.Close()
-	f, err := openFileOrSubstitute(dir, wf.Name, getArgs.conflict)
+	// Files sent as part of a directory are written to the same
+	// subdirectory of dir.
+	name := filepath.FromSlash(inName)
+	if !filepath.IsLocal(name) {
+		return "", 0, fmt.Errorf("invalid inbox file name %q", inName)
+	}
+	if sub := filepath.Dir(name); sub != "." {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return "", 0, err
+		}
+	}
+	f, err := openFileOrSubstitute(dir, name, getArgs.conflict)
 	if err != nil {
 		return "", 0, err
 	}
@@ -541,10 +743,13 @@ func runFileGetOneBatch(ctx context.Context, dir string) []error {
 			continue
 		}
 		if getArgs.verbose {
-			printf("wrote %v as %v (%d bytes)\n", wf.Name, writtenFile, size)
+			printf("wrote %v as %v (%d bytes)\n", inboxName(wf), writtenFile, size)
 		}
-		if err = localClient.DeleteWaitingFile(ctx, wf.Name); err != nil {
-			errs = append(errs, fmt.Errorf("deleting %q from inbox: %v", wf.Name, err))
+		if getArgs.json {
+			printJSONLine(fileJSON{Name: inboxName(wf), Path: writtenFile, Size: size})
+		}
+		if err = localClient.DeleteWaitingFile(ctx, inboxName(wf)); err != nil {
+			errs = append(errs, fmt.Errorf("deleting %q from inbox: %v", inboxName(wf), err))
 			continue
 		}
 		deleted++
@@ -576,7 +781,7 @@ func runFileGet(ctx context.Context, args []string) error {
 		for {
 			errs := runFileGetOneBatch(ctx, dir)
 			for _, err := range errs {
-				outln(err)
+				printFileGetError(err)
 			}
 			if len(errs) > 0 {
 				// It's possible whatever caused the error(s) (e.g. conflicting target file,
@@ -598,11 +803,21 @@ func runFileGet(ctx context.Context, args []string) error {
 		return nil
 	}
 	for _, err := range errs[:len(errs)-1] {
-		outln(err)
+		printFileGetError(err)
 	}
 	return errs[len(errs)-1]
 }
 
+// printFileGetError outputs an error receiving a file that doesn't stop
+// "tailscale file get".
+func printFileGetError(err error) {
+	if getArgs.json {
+		printJSONError(err)
+	} else {
+		outln(err)
+	}
+}
+
 func wipeInbox(ctx context.Context) error {
 	if getArgs.wait {
 		return errors.New("can't use --wait with /dev/null target")
@@ -614,10 +829,10 @@ func wipeInbox(ctx context.Context) error {
 	deleted := 0
 	for _, wf := range wfs {
 		if getArgs.verbose {
-			log.Printf("deleting %v ...", wf.Name)
+			log.Printf("deleting %v ...", inboxName(wf))
 		}
-		if err := localClient.DeleteWaitingFile(ctx, wf.Name); err != nil {
-			return fmt.Errorf("deleting %q: %v", wf.Name, err)
+		if err := localClient.DeleteWaitingFile(ctx, inboxName(wf)); err != nil {
+			return fmt.Errorf("deleting %q: %v", inboxName(wf), err)
 		}
 		deleted++
 	}
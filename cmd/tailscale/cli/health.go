@@ -0,0 +1,77 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/health"
+)
+
+var healthCmd = &ffcli.Command{
+	Name:       "health",
+	ShortUsage: "health [--json]",
+	ShortHelp:  "Run the Tailscale daemon's health checks",
+	LongHelp: `Run the Tailscale daemon's health checks and show the failing ones,
+along with how serious each problem is and how to fix it.
+
+With --json, the results of all checks are printed, with remediation codes
+suitable for programs to act on.`,
+	Exec: runHealth,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("health")
+		fs.BoolVar(&healthArgs.json, "json", false, "output the results of all checks as JSON")
+		return fs
+	})(),
+}
+
+var healthArgs struct {
+	json bool
+}
+
+func runHealth(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	res, err := localClient.Health(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if healthArgs.json {
+		enc := json.NewEncoder(Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	}
+	printHealth(Stdout, res)
+	return nil
+}
+
+// printHealth writes the failing checks of res to w, for humans.
+func printHealth(w io.Writer, res []health.CheckResult) {
+	var passed int
+	for _, r := range res {
+		if r.Healthy {
+			passed++
+			continue
+		}
+		fmt.Fprintf(w, "%-7s  %s: %s\n", r.Severity, r.Name, r.Error)
+		if rem := r.Remediation; rem != nil {
+			fmt.Fprintf(w, "         fix: %s\n", rem.Text)
+			if rem.URL != "" {
+				fmt.Fprintf(w, "         see: %s\n", rem.URL)
+			}
+		}
+	}
+	if passed == len(res) {
+		fmt.Fprintf(w, "All %d health checks passed.\n", passed)
+	} else {
+		fmt.Fprintf(w, "%d of %d health checks passed.\n", passed, len(res))
+	}
+}
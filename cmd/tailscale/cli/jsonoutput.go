@@ -0,0 +1,136 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale"
+)
+
+// The exit codes of the tailscale command, returned by ExitCode. They're
+// part of the CLI's interface for automation, so they mustn't change.
+const (
+	exitOK                 = 0
+	exitError              = 1 // any error not covered below
+	exitUsage              = 2 // invalid flags or arguments, as for flag.ExitOnError
+	exitDaemonUnreachable  = 3 // tailscaled isn't running or can't be reached
+	exitAccessDenied       = 4 // tailscaled denied the request
+	exitPreconditionFailed = 5 // the request conflicted with tailscaled's state
+)
+
+// jsonErrorOutput is what a command run with --json outputs when it
+// fails, instead of its usual output.
+type jsonErrorOutput struct {
+	Error jsonError
+}
+
+// jsonError describes why a command failed.
+type jsonError struct {
+	// Code is the kind of error: "error", "usage", "daemon-unreachable",
+	// "access-denied" or "precondition-failed". Unlike Message, it's
+	// stable.
+	Code string
+
+	// Message is the error, for humans.
+	Message string
+
+	// ExitCode is the exit code of the command.
+	ExitCode int
+}
+
+// usageError is an error in the flags or arguments of a command.
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string { return e.err.Error() }
+func (e usageError) Unwrap() error { return e.err }
+
+// tailscaledConnectError is an error returned by fixTailscaledConnectError,
+// keeping the original error for ExitCode.
+type tailscaledConnectError struct {
+	err  error // the better error
+	orig error
+}
+
+func (e tailscaledConnectError) Error() string   { return e.err.Error() }
+func (e tailscaledConnectError) Unwrap() []error { return []error{e.err, e.orig} }
+
+// ExitCode returns the exit code for the tailscale command to exit with
+// after Run returns err.
+func ExitCode(err error) int {
+	_, code := classifyError(err)
+	return code
+}
+
+// classifyError returns the jsonError.Code and exit code of err.
+func classifyError(err error) (string, int) {
+	var oe *net.OpError
+	switch {
+	case err == nil:
+		return "", exitOK
+	case errors.As(err, new(usageError)):
+		return "usage", exitUsage
+	case tailscale.IsAccessDeniedError(err):
+		return "access-denied", exitAccessDenied
+	case tailscale.IsPreconditionsFailedError(err):
+		return "precondition-failed", exitPreconditionFailed
+	case errors.As(err, &oe) && oe.Op == "dial":
+		return "daemon-unreachable", exitDaemonUnreachable
+	}
+	return "error", exitError
+}
+
+// printJSONError outputs err as a jsonErrorOutput, on a single line so
+// that it can also end a stream of JSON lines.
+func printJSONError(err error) {
+	code, exit := classifyError(err)
+	printJSONLine(jsonErrorOutput{Error: jsonError{
+		Code:     code,
+		Message:  err.Error(),
+		ExitCode: exit,
+	}})
+}
+
+// printJSON outputs v as indented JSON.
+func printJSON(v any) {
+	j, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		panic(err) // only our own types are output
+	}
+	printf("%s\n", j)
+}
+
+// printJSONLine outputs v as a single line of JSON, for commands that
+// output a stream of objects.
+func printJSONLine(v any) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	printf("%s\n", j)
+}
+
+// jsonRequested reports whether the command being run from root was
+// given --json, per the flags parsed so far.
+func jsonRequested(root *ffcli.Command) bool {
+	var found bool
+	var walk func(*ffcli.Command)
+	walk = func(c *ffcli.Command) {
+		if c.FlagSet != nil {
+			if f := c.FlagSet.Lookup("json"); f != nil && isBoolFlag(f) && f.Value.String() == "true" {
+				found = true
+			}
+		}
+		for _, sub := range c.Subcommands {
+			walk(sub)
+		}
+	}
+	walk(root)
+	return found
+}
@@ -0,0 +1,65 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"tailscale.com/client/tailscale"
+	"tailscale.com/tstest"
+)
+
+func TestExitCode(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "unix", Err: errors.New("no such file or directory")}
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, exitOK},
+		{"other", errors.New("boom"), exitError},
+		{"usage", usageError{errors.New("bad flag")}, exitUsage},
+		{"access_denied", fmt.Errorf("editing prefs: %w", &tailscale.AccessDeniedError{}), exitAccessDenied},
+		{"precondition", &tailscale.PreconditionsFailedError{}, exitPreconditionFailed},
+		{"unreachable", fixTailscaledConnectError(dialErr), exitDaemonUnreachable},
+		{"not_unreachable", fixTailscaledConnectError(errors.New("404 Not Found")), exitError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode(%v) = %d; want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONErrorOutput(t *testing.T) {
+	var stdout bytes.Buffer
+	tstest.Replace[io.Writer](t, &Stdout, &stdout)
+	tstest.Replace[io.Writer](t, &Stderr, io.Discard)
+	tstest.Replace(t, &dnsStatusArgs.json, false)
+
+	socket := filepath.Join(t.TempDir(), "tailscaled.sock")
+	err := Run([]string{"--socket=" + socket, "dns", "status", "--json"})
+	if err == nil {
+		t.Fatal("Run succeeded without tailscaled")
+	}
+	var got jsonErrorOutput
+	if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+		t.Fatalf("output %q isn't a JSON error: %v", stdout.Bytes(), err)
+	}
+	if got.Error.Code != "daemon-unreachable" || got.Error.ExitCode != exitDaemonUnreachable || got.Error.Message != err.Error() {
+		t.Errorf("got %+v; want daemon-unreachable error %q", got.Error, err)
+	}
+	if ExitCode(err) != exitDaemonUnreachable {
+		t.Errorf("ExitCode = %d; want %d", ExitCode(err), exitDaemonUnreachable)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tka"
+	"tailscale.com/types/key"
+)
+
+const nlSignerHelp = `The signer is given as "exec:<program> [<args>...]": a helper program
+that keeps the key in hardware, such as a PIV smart card. The program and
+its arguments are split as by a shell, so may be quoted.
+
+Tailnet lock keys are ed25519 keys, so the hardware must be able to make
+ed25519 signatures, as YubiKey 5.7 and later can in their PIV slots. TPMs
+and the macOS Secure Enclave only support P-256 keys, so they can't hold
+tailnet lock keys.
+
+The helper is run with its arguments followed by:
+  - "public-key", to print the key's public key as tlpub:<hex>
+  - "sign", to sign the message read from stdin, printing the signature
+    in hex`
+
+var nlSignerKeyCmd = &ffcli.Command{
+	Name:       "signer-key",
+	ShortUsage: "signer-key --signer=<signer>",
+	ShortHelp:  "Print the tailnet lock key of a hardware signer",
+	LongHelp: `Prints the tailnet lock key (tlpub:...) of a hardware signer, to trust it
+with "tailscale lock add" or "tailscale lock init". Once trusted, node
+keys can be signed with it by "tailscale lock sign --signer".
+
+` + nlSignerHelp,
+	Exec: runNetworkLockSignerKey,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("lock signer-key")
+		fs.StringVar(&nlSignerKeyArgs.signer, "signer", "", "hardware signer holding the key")
+		return fs
+	})(),
+}
+
+var nlSignerKeyArgs struct {
+	signer string
+}
+
+func runNetworkLockSignerKey(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	if nlSignerKeyArgs.signer == "" {
+		return errors.New("usage: lock signer-key --signer=<signer>")
+	}
+	s, err := parseNLSigner(ctx, nlSignerKeyArgs.signer)
+	if err != nil {
+		return err
+	}
+	outln(s.public.CLIString())
+	return nil
+}
+
+// nlSigner is a tailnet lock key kept outside of tailscaled, such as in
+// hardware.
+type nlSigner struct {
+	tka.KeySigner
+	public key.NLPublic
+}
+
+// parseNLSigner returns the signer described by spec. See nlSignerHelp.
+func parseNLSigner(ctx context.Context, spec string) (*nlSigner, error) {
+	cmdline, ok := strings.CutPrefix(spec, "exec:")
+	if !ok {
+		return nil, fmt.Errorf("unknown signer %q; want exec:<program>", spec)
+	}
+	args, err := shellquote.Split(cmdline)
+	if err != nil {
+		return nil, fmt.Errorf("signer: %w", err)
+	}
+	if len(args) == 0 {
+		return nil, errors.New("signer: missing program")
+	}
+	es := &execSigner{ctx: ctx, args: args}
+	out, err := es.run("public-key", nil)
+	if err != nil {
+		return nil, err
+	}
+	var pub key.NLPublic
+	if err := pub.UnmarshalText(bytes.TrimSpace(out)); err != nil {
+		return nil, fmt.Errorf("signer: parsing public key %q: %w", bytes.TrimSpace(out), err)
+	}
+	es.pub = pub
+	ks, err := tka.CryptoSigner(es)
+	if err != nil {
+		return nil, err
+	}
+	return &nlSigner{KeySigner: ks, public: pub}, nil
+}
+
+// execSigner is a crypto.Signer whose private key is held by a helper
+// program. See nlSignerHelp.
+type execSigner struct {
+	ctx  context.Context
+	args []string
+	pub  key.NLPublic
+}
+
+func (s *execSigner) Public() crypto.PublicKey {
+	return s.pub.Verifier()
+}
+
+func (s *execSigner) Sign(_ io.Reader, msg []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != 0 {
+		return nil, errors.New("signer: only ed25519 signatures of whole messages are supported")
+	}
+	out, err := s.run("sign", msg)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := hex.DecodeString(string(bytes.TrimSpace(out)))
+	if err != nil {
+		return nil, fmt.Errorf("signer: parsing signature: %w", err)
+	}
+	return sig, nil
+}
+
+// run runs the helper program with op, passing it stdin, and returns its
+// output.
+func (s *execSigner) run(op string, stdin []byte) ([]byte, error) {
+	cmd := exec.CommandContext(s.ctx, s.args[0], append(s.args[1:], op)...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stderr = os.Stderr // for prompts, such as for a PIN or a touch
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("signer: %s %s: %w", s.args[0], op, err)
+	}
+	return out, nil
+}
+
+// nlSignWithSigner signs keys with signer, returning the signatures.
+// Keys that fail don't stop the others from being signed; their results
+// have an Error.
+func nlSignWithSigner(signer *nlSigner, keys []ipnstate.NetworkLockSigningRequestKey) []ipnstate.NetworkLockSignature {
+	res := make([]ipnstate.NetworkLockSignature, len(keys))
+	for i, k := range keys {
+		res[i].NodeKey = k.NodeKey
+		sig, err := tka.SignNodeKey(signer, k.NodeKey, k.RotationPublic)
+		if err != nil {
+			res[i].Error = err.Error()
+			continue
+		}
+		res[i].Signature = sig.Serialize()
+	}
+	return res
+}
+
+// nlCosignWithSigner adds a signature of aum made by the signer described
+// by spec, returning the serialized AUM.
+func nlCosignWithSigner(ctx context.Context, spec string, aum tka.AUM) ([]byte, error) {
+	signer, err := parseNLSigner(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+	for _, sig := range aum.Signatures {
+		if bytes.Equal(sig.KeyID, signer.KeyID()) {
+			return nil, errors.New("this signer has already signed this recovery AUM")
+		}
+	}
+	sigs, err := signer.SignAUM(aum.SigHash())
+	if err != nil {
+		return nil, err
+	}
+	aum.Signatures = append(aum.Signatures, sigs...)
+	return aum.Serialize(), nil
+}
@@ -15,6 +15,7 @@
 	"os"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/mattn/go-colorable"
@@ -37,6 +38,10 @@
 		nlAddCmd,
 		nlRemoveCmd,
 		nlSignCmd,
+		nlSignRequestCmd,
+		nlSubmitSignaturesCmd,
+		nlSignAuditCmd,
+		nlSignerKeyCmd,
 		nlDisableCmd,
 		nlDisablementKDFCmd,
 		nlLogCmd,
@@ -437,37 +442,120 @@ func runNetworkLockModify(ctx context.Context, addArgs, removeArgs []string) err
 
 var nlSignCmd = &ffcli.Command{
 	Name:       "sign",
-	ShortUsage: "sign <node-key> [<rotation-key>] or sign <auth-key>",
+	ShortUsage: "sign [--offline] [--signer=<signer>] <node-key> [<rotation-key>] or sign <auth-key>\n  sign [--offline] [--signer=<signer>] [--output=<file>] --from-file=<file>",
 	ShortHelp:  "Signs a node or pre-approved auth key",
 	LongHelp: `Either:
   - signs a node key and transmits the signature to the coordination server, or
-  - signs a pre-approved auth key, printing it in a form that can be used to bring up nodes under tailnet lock`,
+  - signs a pre-approved auth key, printing it in a form that can be used to bring up nodes under tailnet lock
+
+With --from-file, each of the node keys in the file is signed. The file is
+either a signing request written by "tailscale lock sign-request", or lists
+a node key per line, optionally followed by a rotation key. Blank lines and
+lines starting with # are ignored.
+
+With --offline, the signatures aren't transmitted, but written as JSON to
+--output (or stdout) for an online node to transmit with "tailscale lock
+submit-signatures". This lets signing nodes stay disconnected.
+
+With --signer, the node keys are signed by a tailnet lock key kept in
+hardware instead of this node's key. See "tailscale lock signer-key".
+
+Signatures made or submitted are recorded in an audit trail, shown by
+"tailscale lock audit".`,
 	Exec: runNetworkLockSign,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("lock sign")
+		fs.StringVar(&nlSignArgs.fromFile, "from-file", "", "file of node keys to sign, or a signing request")
+		fs.BoolVar(&nlSignArgs.offline, "offline", false, "don't transmit the signatures; write them out instead")
+		fs.StringVar(&nlSignArgs.output, "output", "", "with --offline, file to write the signatures to; defaults to stdout")
+		fs.StringVar(&nlSignArgs.signer, "signer", "", "hardware signer to sign node keys with, as exec:<program>; see \"tailscale lock signer-key\"")
+		return fs
+	})(),
+}
+
+var nlSignArgs struct {
+	fromFile string
+	offline  bool
+	output   string
+	signer   string
 }
 
 func runNetworkLockSign(ctx context.Context, args []string) error {
 	if len(args) > 0 && strings.HasPrefix(args[0], "tskey-auth-") {
+		if nlSignArgs.signer != "" {
+			return errors.New("--signer can't be used to sign auth keys")
+		}
 		return runTskeyWrapCmd(ctx, args)
 	}
 
-	var (
-		nodeKey     key.NodePublic
-		rotationKey key.NLPublic
-	)
+	keys, err := nlSigningKeys(args, nlSignArgs.fromFile)
+	if err != nil {
+		return err
+	}
+	if nlSignArgs.signer != "" {
+		return nlSignWithSignerArg(ctx, keys)
+	}
+	if len(keys) == 1 && !nlSignArgs.offline {
+		return nlSignOne(ctx, keys[0])
+	}
 
-	if len(args) == 0 || len(args) > 2 {
-		return errors.New("usage: lock sign <node-key> [<rotation-key>]")
+	res, err := localClient.NetworkLockSignBatch(ctx, keys, nlSignArgs.offline)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if nlSignArgs.offline {
+		if err := nlWriteJSON(nlSignArgs.output, res); err != nil {
+			return err
+		}
+		if nlSignArgs.output == "" {
+			return nlSignFailures(res)
+		}
 	}
-	if err := nodeKey.UnmarshalText([]byte(args[0])); err != nil {
-		return fmt.Errorf("decoding node-key: %w", err)
+	return nlPrintSignResults(res, "signed")
+}
+
+// nlSignWithSignerArg signs keys with the signer of the --signer flag,
+// then transmits the signatures or, with --offline, writes them out.
+func nlSignWithSignerArg(ctx context.Context, keys []ipnstate.NetworkLockSigningRequestKey) error {
+	signer, err := parseNLSigner(ctx, nlSignArgs.signer)
+	if err != nil {
+		return err
 	}
-	if len(args) > 1 {
-		if err := rotationKey.UnmarshalText([]byte(args[1])); err != nil {
-			return fmt.Errorf("decoding rotation-key: %w", err)
+	res := nlSignWithSigner(signer, keys)
+	if nlSignArgs.offline {
+		if err := nlWriteJSON(nlSignArgs.output, res); err != nil {
+			return err
+		}
+		if nlSignArgs.output == "" {
+			return nlSignFailures(res)
+		}
+		return nlPrintSignResults(res, "signed")
+	}
+
+	var toSubmit []ipnstate.NetworkLockSignature
+	for _, r := range res {
+		if r.Error != "" {
+			printf("%v: %s\n", r.NodeKey, r.Error)
+			continue
 		}
+		toSubmit = append(toSubmit, r)
+	}
+	if len(toSubmit) == 0 {
+		return nlSignFailures(res)
+	}
+	submitted, err := localClient.NetworkLockSubmitSignatures(ctx, toSubmit)
+	if err != nil {
+		return fixTailscaledConnectError(err)
 	}
+	if err := nlPrintSignResults(submitted, "signed"); err != nil {
+		return err
+	}
+	return nlSignFailures(res)
+}
 
-	err := localClient.NetworkLockSign(ctx, nodeKey, []byte(rotationKey.Verifier()))
+// nlSignOne signs and transmits a single node key.
+func nlSignOne(ctx context.Context, k ipnstate.NetworkLockSigningRequestKey) error {
+	err := localClient.NetworkLockSign(ctx, k.NodeKey, k.RotationPublic)
 	// Provide a better help message for when someone clicks through the signing flow
 	// on the wrong device.
 	if err != nil && strings.Contains(err.Error(), "this node is not trusted by network lock") {
@@ -479,6 +567,236 @@ func runNetworkLockSign(ctx context.Context, args []string) error {
 	return err
 }
 
+// nlSigningKeys returns the node keys to sign given by args, a node key
+// and an optional rotation key, or by the file fromFile.
+func nlSigningKeys(args []string, fromFile string) ([]ipnstate.NetworkLockSigningRequestKey, error) {
+	if fromFile != "" {
+		if len(args) > 0 {
+			return nil, errors.New("can't use --from-file with node keys as arguments")
+		}
+		b, err := os.ReadFile(fromFile)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := parseNLSigningKeys(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fromFile, err)
+		}
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("%s: no node keys", fromFile)
+		}
+		return keys, nil
+	}
+	if len(args) == 0 || len(args) > 2 {
+		return nil, errors.New("usage: lock sign <node-key> [<rotation-key>]")
+	}
+	k, err := parseNLSigningKey(args[0], args[1:])
+	if err != nil {
+		return nil, err
+	}
+	return []ipnstate.NetworkLockSigningRequestKey{k}, nil
+}
+
+// parseNLSigningKeys parses the node keys to sign from b, either a JSON
+// ipnstate.NetworkLockSigningRequest or lines of a node key followed by an
+// optional rotation key.
+func parseNLSigningKeys(b []byte) ([]ipnstate.NetworkLockSigningRequestKey, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		var req ipnstate.NetworkLockSigningRequest
+		if err := json.Unmarshal(b, &req); err != nil {
+			return nil, fmt.Errorf("decoding signing request: %w", err)
+		}
+		return req.Keys, nil
+	}
+	var keys []ipnstate.NetworkLockSigningRequestKey
+	for i, line := range strings.Split(string(b), "\n") {
+		f := strings.Fields(line)
+		if len(f) == 0 || strings.HasPrefix(f[0], "#") {
+			continue
+		}
+		if len(f) > 2 {
+			return nil, fmt.Errorf("line %d: want <node-key> [<rotation-key>]", i+1)
+		}
+		k, err := parseNLSigningKey(f[0], f[1:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func parseNLSigningKey(nodeKey string, rotationKey []string) (ipnstate.NetworkLockSigningRequestKey, error) {
+	var k ipnstate.NetworkLockSigningRequestKey
+	if err := k.NodeKey.UnmarshalText([]byte(nodeKey)); err != nil {
+		return k, fmt.Errorf("decoding node-key: %w", err)
+	}
+	if len(rotationKey) > 0 {
+		var rk key.NLPublic
+		if err := rk.UnmarshalText([]byte(rotationKey[0])); err != nil {
+			return k, fmt.Errorf("decoding rotation-key: %w", err)
+		}
+		k.RotationPublic = []byte(rk.Verifier())
+	}
+	return k, nil
+}
+
+// nlWriteJSON writes v as indented JSON to the file path, or to stdout if
+// path is empty.
+func nlWriteJSON(path string, v any) error {
+	j, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	j = append(j, '\n')
+	if path == "" {
+		_, err := Stdout.Write(j)
+		return err
+	}
+	return os.WriteFile(path, j, 0600)
+}
+
+// nlPrintSignResults prints the outcome of each of res, and returns an
+// error if any failed.
+func nlPrintSignResults(res []ipnstate.NetworkLockSignature, verb string) error {
+	for _, r := range res {
+		if r.Error != "" {
+			printf("%v: %s\n", r.NodeKey, r.Error)
+		} else {
+			printf("%s %v\n", verb, r.NodeKey)
+		}
+	}
+	return nlSignFailures(res)
+}
+
+// nlSignFailures returns an error if any of res failed.
+func nlSignFailures(res []ipnstate.NetworkLockSignature) error {
+	var failed int
+	for _, r := range res {
+		if r.Error != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d node keys failed", failed, len(res))
+	}
+	return nil
+}
+
+var nlSignRequestCmd = &ffcli.Command{
+	Name:       "sign-request",
+	ShortUsage: "sign-request [--output=<file>] <node-key> [<rotation-key>]\n  sign-request [--output=<file>] --from-file=<file>",
+	ShortHelp:  "Exports a request to sign node keys on an offline signing node",
+	LongHelp: `Writes a signing request for the node keys, given as for "tailscale lock sign",
+to take to a signing node that's offline. There, "tailscale lock sign --offline
+--from-file=<request>" signs them, and the signatures it writes are brought
+back and transmitted with "tailscale lock submit-signatures".`,
+	Exec: runNetworkLockSignRequest,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("lock sign-request")
+		fs.StringVar(&nlSignRequestArgs.fromFile, "from-file", "", "file of node keys to sign")
+		fs.StringVar(&nlSignRequestArgs.output, "output", "", "file to write the request to; defaults to stdout")
+		return fs
+	})(),
+}
+
+var nlSignRequestArgs struct {
+	fromFile string
+	output   string
+}
+
+func runNetworkLockSignRequest(ctx context.Context, args []string) error {
+	keys, err := nlSigningKeys(args, nlSignRequestArgs.fromFile)
+	if err != nil {
+		return err
+	}
+	return nlWriteJSON(nlSignRequestArgs.output, ipnstate.NetworkLockSigningRequest{Keys: keys})
+}
+
+var nlSubmitSignaturesCmd = &ffcli.Command{
+	Name:       "submit-signatures",
+	ShortUsage: "submit-signatures <file>",
+	ShortHelp:  "Transmits node key signatures made offline",
+	LongHelp: `Transmits the node key signatures in the file, as written by
+"tailscale lock sign --offline", to the coordination server. Each is first
+checked against this node's view of tailnet lock.`,
+	Exec: runNetworkLockSubmitSignatures,
+}
+
+func runNetworkLockSubmitSignatures(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: lock submit-signatures <file>")
+	}
+	b, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	var sigs []ipnstate.NetworkLockSignature
+	if err := json.Unmarshal(b, &sigs); err != nil {
+		return fmt.Errorf("decoding signatures: %w", err)
+	}
+	// Signing failures are carried over; there's nothing to submit.
+	var toSubmit []ipnstate.NetworkLockSignature
+	for _, s := range sigs {
+		if len(s.Signature) > 0 {
+			toSubmit = append(toSubmit, s)
+		}
+	}
+	if len(toSubmit) == 0 {
+		return fmt.Errorf("%s: no signatures", args[0])
+	}
+	res, err := localClient.NetworkLockSubmitSignatures(ctx, toSubmit)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	return nlPrintSignResults(res, "submitted signature of")
+}
+
+var nlSignAuditCmd = &ffcli.Command{
+	Name:       "audit",
+	ShortUsage: "audit [--json]",
+	ShortHelp:  "List the node key signatures made or submitted by this node",
+	LongHelp:   "List the node key signatures made or submitted by this node, oldest first",
+	Exec:       runNetworkLockSignAudit,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("lock audit")
+		fs.BoolVar(&nlSignAuditArgs.json, "json", false, "output in JSON format")
+		return fs
+	})(),
+}
+
+var nlSignAuditArgs struct {
+	json bool
+}
+
+func runNetworkLockSignAudit(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	entries, err := localClient.NetworkLockSignAudit(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if nlSignAuditArgs.json {
+		printJSON(entries)
+		return nil
+	}
+	if len(entries) == 0 {
+		outln("No signatures made or submitted.")
+		return nil
+	}
+	w := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTION\tNODE KEY\tSIGNING KEY ID\tRESULT")
+	for _, e := range entries {
+		result := "ok"
+		if e.Error != "" {
+			result = e.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%v\t%x\t%s\n", e.Time.Local().Format(time.DateTime), e.Action, e.NodeKey.ShortString(), e.KeyID, result)
+	}
+	return w.Flush()
+}
+
 var nlDisableCmd = &ffcli.Command{
 	Name:       "disable",
 	ShortUsage: "disable <disablement-secret>",
@@ -718,6 +1036,7 @@ func wrapAuthKey(ctx context.Context, keyStr string, status *ipnstate.Status) er
 	cosign   bool
 	finish   bool
 	forkFrom string
+	signer   string
 }
 
 var nlRevokeKeysCmd = &ffcli.Command{
@@ -742,6 +1061,7 @@ func wrapAuthKey(ctx context.Context, keyStr string, status *ipnstate.Status) er
 		fs.BoolVar(&nlRevokeKeysArgs.cosign, "cosign", false, "continue generating the recovery using the tailnet lock key on this device and the provided recovery blob")
 		fs.BoolVar(&nlRevokeKeysArgs.finish, "finish", false, "finish the recovery process by transmitting the revocation")
 		fs.StringVar(&nlRevokeKeysArgs.forkFrom, "fork-from", "", "parent AUM hash to rewrite from (advanced users only)")
+		fs.StringVar(&nlRevokeKeysArgs.signer, "signer", "", "with --cosign, hardware signer to co-sign with instead of this device's key; see \"tailscale lock signer-key\"")
 		return fs
 	})(),
 }
@@ -800,7 +1120,12 @@ func runNetworkLockRevokeKeys(ctx context.Context, args []string) error {
 	}
 
 	if nlRevokeKeysArgs.cosign {
-		aumBytes, err := localClient.NetworkLockCosignRecoveryAUM(ctx, recoveryAUM)
+		var aumBytes []byte
+		if nlRevokeKeysArgs.signer != "" {
+			aumBytes, err = nlCosignWithSigner(ctx, nlRevokeKeysArgs.signer, recoveryAUM)
+		} else {
+			aumBytes, err = localClient.NetworkLockCosignRecoveryAUM(ctx, recoveryAUM)
+		}
 		if err != nil {
 			return fmt.Errorf("co-signing recovery AUM failed: %w", err)
 		}
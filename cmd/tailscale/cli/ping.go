@@ -12,6 +12,7 @@
 	"net"
 	"net/netip"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -23,7 +24,7 @@
 
 var pingCmd = &ffcli.Command{
 	Name:       "ping",
-	ShortUsage: "ping <hostname-or-IP>",
+	ShortUsage: "ping <hostname-or-IP | selector...>",
 	ShortHelp:  "Ping a host at the Tailscale layer, see how it routed",
 	LongHelp: strings.TrimSpace(`
 
@@ -43,6 +44,10 @@
 (e.g. 100.x.y.z) or a subnet IP advertised by a Tailscale
 relay node.
 
+Instead of a host, selector terms such as "tag:prod" or "os:linux" can
+be given to ping each of the peers they select in turn; see
+'tailscale status -h' for their syntax.
+
 `),
 	Exec: runPing,
 	FlagSet: (func() *flag.FlagSet {
@@ -55,6 +60,7 @@
 		fs.IntVar(&pingArgs.num, "c", 10, "max number of pings to send. 0 for infinity.")
 		fs.DurationVar(&pingArgs.timeout, "timeout", 5*time.Second, "timeout before giving up on a ping")
 		fs.IntVar(&pingArgs.size, "size", 0, "size of the ping message (disco pings only). 0 for minimum size.")
+		fs.BoolVar(&pingArgs.stats, "stats", false, "after pinging, print the recently measured latency and loss of each path to the peer")
 		return fs
 	})(),
 }
@@ -67,6 +73,7 @@
 	tsmp        bool
 	icmp        bool
 	peerAPI     bool
+	stats       bool
 	timeout     time.Duration
 }
 
@@ -94,8 +101,11 @@ func runPing(ctx context.Context, args []string) error {
 		os.Exit(1)
 	}
 
+	if len(args) > 0 && ipnstate.LooksLikePeerSelector(args[0]) {
+		return pingSelected(ctx, strings.Join(args, " "))
+	}
 	if len(args) != 1 || args[0] == "" {
-		return errors.New("usage: ping <hostname-or-IP>")
+		return errors.New("usage: ping <hostname-or-IP | selector...>")
 	}
 	var ip string
 
@@ -113,6 +123,65 @@ func runPing(ctx context.Context, args []string) error {
 		log.Printf("lookup %q => %q", hostOrIP, ip)
 	}
 
+	err = pingLoop(ctx, ip)
+	if pingArgs.stats {
+		if err := printPathStats(ctx, ip); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// pingSelected pings each of the peers selected by filter, an
+// ipnstate.PeerSelector, in turn.
+func pingSelected(ctx context.Context, filter string) error {
+	if _, err := ipnstate.ParsePeerSelector(filter); err != nil {
+		return usageError{err}
+	}
+	st, err := localClient.StatusWithPeerFilter(ctx, filter)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	var peers []*ipnstate.PeerStatus
+	for _, ps := range st.Peer {
+		if len(ps.TailscaleIPs) > 0 {
+			peers = append(peers, ps)
+		}
+	}
+	if len(peers) == 0 {
+		return fmt.Errorf("no peers match %q", filter)
+	}
+	slices.SortFunc(peers, func(a, b *ipnstate.PeerStatus) int {
+		return strings.Compare(dnsOrQuoteHostname(st, a), dnsOrQuoteHostname(st, b))
+	})
+	var failed int
+	for i, ps := range peers {
+		if i > 0 {
+			outln()
+		}
+		ip := ps.TailscaleIPs[0].String()
+		printf("# %s (%s)\n", dnsOrQuoteHostname(st, ps), ip)
+		if err := pingLoop(ctx, ip); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			printf("%s: %v\n", dnsOrQuoteHostname(st, ps), err)
+			failed++
+		}
+		if pingArgs.stats {
+			if err := printPathStats(ctx, ip); err != nil {
+				return err
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d peers failed", failed, len(peers))
+	}
+	return nil
+}
+
+// pingLoop pings ip until the flags say to stop.
+func pingLoop(ctx context.Context, ip string) error {
 	n := 0
 	anyPong := false
 	for {
@@ -180,6 +249,33 @@ func runPing(ctx context.Context, args []string) error {
 	}
 }
 
+// printPathStats prints the path stats of the peer with the Tailscale IP ip.
+func printPathStats(ctx context.Context, ip string) error {
+	st, err := localClient.Status(ctx)
+	if err != nil {
+		return err
+	}
+	addr := netip.MustParseAddr(ip)
+	for _, ps := range st.Peer {
+		if !slices.Contains(ps.TailscaleIPs, addr) {
+			continue
+		}
+		if len(ps.PathStats) == 0 {
+			printf("no path stats for %s yet\n", ip)
+			return nil
+		}
+		printf("\npath stats for %s:\n", ip)
+		for _, p := range ps.PathStats {
+			rtt := time.Duration(p.RTTSeconds * float64(time.Second)).Round(100 * time.Microsecond)
+			minRTT := time.Duration(p.MinRTTSeconds * float64(time.Second)).Round(100 * time.Microsecond)
+			printf("  %-8s rtt %v (min %v), %.0f%% loss of %d pings\n", p.Path, rtt, minRTT, p.Loss*100, p.Pings)
+		}
+		return nil
+	}
+	printf("no path stats for %s; it's not a peer's Tailscale IP\n", ip)
+	return nil
+}
+
 func tailscaleIPFromArg(ctx context.Context, hostOrIP string) (ip string, self bool, err error) {
 	// If the argument is an IP address, use it directly without any resolution.
 	if net.ParseIP(hostOrIP) != nil {
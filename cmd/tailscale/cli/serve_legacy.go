@@ -143,6 +143,7 @@ type localServeClient interface {
 	SetServeConfig(context.Context, *ipn.ServeConfig) error
 	QueryFeature(ctx context.Context, feature string) (*tailcfg.QueryFeatureResponse, error)
 	WatchIPNBus(ctx context.Context, mask ipn.NotifyWatchOpt) (*tailscale.IPNBusWatcher, error)
+	WatchServeAccessLog(ctx context.Context) (*tailscale.ServeAccessLogWatcher, error)
 	IncrementCounter(ctx context.Context, name string, delta int) error
 }
 
@@ -164,6 +165,14 @@ type serveEnv struct {
 	tcp              string    // TCP port
 	tlsTerminatedTCP string    // a TLS terminated TCP port
 	subcmd           serveMode // subcommand
+	method           string    // HTTP method to serve; empty means all
+	rewritePath      string    // path replacing the mount point when proxying
+	reqHeaders       headerFlag
+	respHeaders      headerFlag
+	indexFiles       string // comma-separated index file names
+	spa              bool   // single-page app mode
+	noListing        bool   // don't list directories
+	cacheControl     string // Cache-Control of served files
 
 	lc localServeClient // localClient interface, specific to serve
 
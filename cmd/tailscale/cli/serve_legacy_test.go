@@ -897,6 +897,10 @@ func (lc *fakeLocalServeClient) WatchIPNBus(ctx context.Context, mask ipn.Notify
 	return nil, nil // unused in tests
 }
 
+func (lc *fakeLocalServeClient) WatchServeAccessLog(ctx context.Context) (*tailscale.ServeAccessLogWatcher, error) {
+	return nil, errors.New("unused in tests")
+}
+
 func (lc *fakeLocalServeClient) IncrementCounter(ctx context.Context, name string, delta int) error {
 	return nil // unused in tests
 }
@@ -5,12 +5,14 @@
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
@@ -20,12 +22,14 @@
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/client/tailscale"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
+	"tailscale.com/util/cmpx"
 	"tailscale.com/util/mak"
 	"tailscale.com/version"
 )
@@ -41,6 +45,8 @@ type commandInfo struct {
 var serveHelpCommon = strings.TrimSpace(`
 <target> can be a port number (e.g., 3000), a partial URL (e.g., localhost:3000), or a
 full URL including a path (e.g., http://localhost:3000/foo, https+insecure://localhost:3000/foo).
+Use an h2c:// URL (e.g., h2c://localhost:50051) for a backend that speaks HTTP/2 without TLS,
+such as a plaintext gRPC server.
 
 EXAMPLES
   - Mount a local web server at 127.0.0.1:3000 in the foreground:
@@ -109,6 +115,7 @@ func newServeV2Command(e *serveEnv, subcmd serveMode) *ffcli.Command {
 		ShortUsage: strings.Join([]string{
 			fmt.Sprintf("%s <target>", info.Name),
 			fmt.Sprintf("%s status [--json]", info.Name),
+			fmt.Sprintf("%s access-log [--json]", info.Name),
 			fmt.Sprintf("%s reset", info.Name),
 		}, "\n  "),
 		LongHelp: info.LongHelp + fmt.Sprintf(strings.TrimSpace(serveHelpCommon), info.Name, info.Name),
@@ -121,7 +128,15 @@ func newServeV2Command(e *serveEnv, subcmd serveMode) *ffcli.Command {
 			fs.StringVar(&e.http, "http", "", "HTTP listener")
 			fs.StringVar(&e.tcp, "tcp", "", "TCP listener")
 			fs.StringVar(&e.tlsTerminatedTCP, "tls-terminated-tcp", "", "TLS terminated TCP listener")
-
+			fs.BoolVar(&e.json, "json", false, "output the resulting serve config as JSON")
+			fs.StringVar(&e.method, "method", "", "only serve requests with this HTTP method (e.g. POST) at the path")
+			fs.StringVar(&e.rewritePath, "rewrite-path", "", "path to replace the served path with when proxying (e.g. /v1)")
+			fs.Var(&e.reqHeaders, "set-header", `header to set on proxied requests, as "Name: value"; an empty value removes it; may be repeated. Values may include {user.login}, {user.name}, {node.name} and {node.ip}`)
+			fs.Var(&e.respHeaders, "set-response-header", `header to set on responses, as "Name: value"; an empty value removes it; may be repeated`)
+			fs.StringVar(&e.indexFiles, "index", "", "comma-separated names of the index files of served directories, in order of preference (default index.html)")
+			fs.BoolVar(&e.spa, "spa", false, "serve the root index file for missing paths without a file extension, for single-page apps")
+			fs.BoolVar(&e.noListing, "no-listing", false, "don't list the contents of served directories without an index file")
+			fs.StringVar(&e.cacheControl, "cache-control", "", "Cache-Control header value of served files (e.g. max-age=3600); index files get no-cache")
 		}),
 		UsageFunc: usageFunc,
 		Subcommands: []*ffcli.Command{
@@ -134,6 +149,22 @@ func newServeV2Command(e *serveEnv, subcmd serveMode) *ffcli.Command {
 				}),
 				UsageFunc: usageFunc,
 			},
+			{
+				Name:      "access-log",
+				Exec:      e.runServeAccessLog,
+				ShortHelp: "print the requests served, as they're served",
+				LongHelp: strings.TrimSpace(`
+Print a line for each HTTP request served, from your tailnet or from the
+internet via Funnel, until interrupted.
+
+To also append the access log to a file, as JSON lines, start tailscaled with
+the TS_SERVE_ACCESS_LOG environment variable set to the file's path.
+`),
+				FlagSet: e.newFlags("serve-access-log", func(fs *flag.FlagSet) {
+					fs.BoolVar(&e.json, "json", false, "output JSON lines")
+				}),
+				UsageFunc: usageFunc,
+			},
 			{
 				Name:      "reset",
 				ShortHelp: "reset current serve/funnel config",
@@ -191,6 +222,12 @@ func (e *serveEnv) runServeCombined(subcmd serveMode) execFunc {
 		if err != nil {
 			return fmt.Errorf("failed to clean the mount point: %w", err)
 		}
+		if e.method != "" {
+			if !validHTTPMethod(e.method) {
+				return fmt.Errorf("invalid HTTP method %q", e.method)
+			}
+			mount = ipn.MountPointForMethod(strings.ToUpper(e.method), mount)
+		}
 
 		if e.setPath != "" {
 			// TODO(marwan-at-work): either
@@ -286,6 +323,9 @@ func (e *serveEnv) runServeCombined(subcmd serveMode) execFunc {
 		if msg != "" {
 			fmt.Fprintln(os.Stderr, msg)
 		}
+		if e.json {
+			printJSON(parentSC)
+		}
 
 		if watcher != nil {
 			for {
@@ -303,6 +343,46 @@ func (e *serveEnv) runServeCombined(subcmd serveMode) execFunc {
 	}
 }
 
+// runServeAccessLog is the entry point for the "serve access-log"
+// subcommand, which prints the requests served until interrupted.
+func (e *serveEnv) runServeAccessLog(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
+	defer cancel()
+	w, err := e.lc.WatchServeAccessLog(ctx)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	for {
+		a, err := w.Next()
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return err
+		}
+		if e.json {
+			j, err := json.Marshal(a)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(e.stdout(), "%s\n", j)
+			continue
+		}
+		fmt.Fprintln(e.stdout(), formatServeAccess(a))
+	}
+}
+
+// formatServeAccess returns a as a line of the "serve access-log" output.
+func formatServeAccess(a ipn.ServeAccess) string {
+	who := cmpx.Or(a.User, a.Node, "funnel")
+	return fmt.Sprintf("%s %s %s %s %s%s %d %dB %.1fms",
+		a.Time.Format(time.RFC3339), a.Src.Addr(), who, a.Method, a.Host, a.Path, a.Status, a.BytesOut, a.LatencyMS)
+}
+
 func (e *serveEnv) validateConfig(sc *ipn.ServeConfig, port uint16, wantServe serveType) error {
 	sc, isFg := findConfig(sc, port)
 	if sc == nil {
@@ -502,13 +582,40 @@ func (e *serveEnv) applyWebServe(sc *ipn.ServeConfig, dnsName string, srvPort ui
 			mount += "/"
 		}
 		h.Path = target
+		if e.indexFiles != "" {
+			for _, name := range strings.Split(e.indexFiles, ",") {
+				name = strings.TrimSpace(name)
+				if name == "" || strings.ContainsAny(name, `/\`) {
+					return fmt.Errorf("invalid index file name %q", name)
+				}
+				h.IndexFiles = append(h.IndexFiles, name)
+			}
+		}
+		h.SPA = e.spa
+		h.NoListing = e.noListing
+		h.CacheControl = e.cacheControl
 	default:
-		t, err := expandProxyTargetDev(target, []string{"http", "https", "https+insecure"}, "http")
+		t, err := expandProxyTargetDev(target, []string{"http", "https", "https+insecure", "h2c"}, "http")
 		if err != nil {
 			return err
 		}
 		h.Proxy = t
+		if e.rewritePath != "" {
+			rp, err := cleanURLPath(e.rewritePath)
+			if err != nil {
+				return fmt.Errorf("invalid rewrite path: %w", err)
+			}
+			h.RewritePath = rp
+		}
+		h.RequestHeaders = e.reqHeaders
+	}
+	if h.Proxy == "" && (e.rewritePath != "" || len(e.reqHeaders) > 0) {
+		return errors.New("--rewrite-path and --set-header are only supported when proxying")
 	}
+	if h.Path == "" && (e.indexFiles != "" || e.spa || e.noListing || e.cacheControl != "") {
+		return errors.New("--index, --spa, --no-listing and --cache-control are only supported when serving files")
+	}
+	h.ResponseHeaders = e.respHeaders
 
 	// TODO: validation needs to check nested foreground configs
 	if sc.IsTCPForwardingOnPort(srvPort) {
@@ -733,6 +840,7 @@ func (e *serveEnv) removeTCPServe(sc *ipn.ServeConfig, src uint16) error {
 //   - https://localhost:3000
 //   - https-insecure://localhost:3000
 //   - https-insecure://localhost:3000/foo
+//   - h2c://localhost:50051
 func expandProxyTargetDev(target string, supportedSchemes []string, defaultScheme string) (string, error) {
 	var host = "127.0.0.1"
 
@@ -774,6 +882,41 @@ func expandProxyTargetDev(target string, supportedSchemes []string, defaultSchem
 	return u.String(), nil
 }
 
+// validHTTPMethod reports whether s is usable as an HTTP method.
+func validHTTPMethod(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// headerFlag is a flag.Value for repeatable "Name: value" header flags.
+type headerFlag map[string]string
+
+func (f *headerFlag) String() string {
+	var lines []string
+	for k, v := range *f {
+		lines = append(lines, k+": "+v)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, ", ")
+}
+
+func (f *headerFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, ":")
+	k = strings.TrimSpace(k)
+	if !ok || k == "" || strings.ContainsAny(k, " \t") {
+		return fmt.Errorf("header %q is not of the form \"Name: value\"", s)
+	}
+	mak.Set(f, http.CanonicalHeaderKey(k), strings.TrimSpace(v))
+	return nil
+}
+
 // cleanURLPath ensures the path is clean and has a leading "/".
 func cleanURLPath(urlPath string) (string, error) {
 	if urlPath == "" {
@@ -514,6 +514,32 @@ type step struct {
 		wantErr: exactErr(errHelp, "errHelp"),
 	})
 	add(step{reset: true})
+	add(step{ // static site options
+		command: cmd("serve --bg --index=index.htm,index.html --spa --no-listing --cache-control=max-age=60 " + filepath.Join(td, "subdir")),
+		want: &ipn.ServeConfig{
+			TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+			Web: map[ipn.HostPort]*ipn.WebServerConfig{
+				"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/": {
+						Path:         filepath.Join(td, "subdir"),
+						IndexFiles:   []string{"index.htm", "index.html"},
+						SPA:          true,
+						NoListing:    true,
+						CacheControl: "max-age=60",
+					},
+				}},
+			},
+		},
+	})
+	add(step{ // static site options when proxying
+		command: cmd("serve --bg --spa localhost:3000"),
+		wantErr: anyErr(),
+	})
+	add(step{
+		command: cmd("serve --bg --index=../index.html " + filepath.Join(td, "subdir")),
+		wantErr: anyErr(),
+	})
+	add(step{reset: true})
 	add(step{
 		command: cmd("serve --https=443 --bg " + filepath.Join(td, "subdir")),
 		want: &ipn.ServeConfig{
@@ -725,6 +751,66 @@ type step struct {
 		wantErr: anyErr(),
 	})
 
+	// proxy rules
+	add(step{reset: true})
+	add(step{
+		command: cmd("serve --bg --set-path=/api --rewrite-path=/v1 --set-header=X-User:{user.login} --set-header=x-removed: --set-response-header=Server: localhost:3000"),
+		want: &ipn.ServeConfig{
+			TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+			Web: map[ipn.HostPort]*ipn.WebServerConfig{
+				"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/api": {
+						Proxy:           "http://127.0.0.1:3000",
+						RewritePath:     "/v1",
+						RequestHeaders:  map[string]string{"X-User": "{user.login}", "X-Removed": ""},
+						ResponseHeaders: map[string]string{"Server": ""},
+					},
+				}},
+			},
+		},
+	})
+	add(step{
+		command: cmd("serve --bg --set-path=/api --method=post localhost:3001"),
+		want: &ipn.ServeConfig{
+			TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+			Web: map[ipn.HostPort]*ipn.WebServerConfig{
+				"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/api": {
+						Proxy:           "http://127.0.0.1:3000",
+						RewritePath:     "/v1",
+						RequestHeaders:  map[string]string{"X-User": "{user.login}", "X-Removed": ""},
+						ResponseHeaders: map[string]string{"Server": ""},
+					},
+					"POST /api": {Proxy: "http://127.0.0.1:3001"},
+				}},
+			},
+		},
+	})
+	add(step{
+		command: cmd("serve --bg --set-path=/api --method=post off"),
+		want: &ipn.ServeConfig{
+			TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+			Web: map[ipn.HostPort]*ipn.WebServerConfig{
+				"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/api": {
+						Proxy:           "http://127.0.0.1:3000",
+						RewritePath:     "/v1",
+						RequestHeaders:  map[string]string{"X-User": "{user.login}", "X-Removed": ""},
+						ResponseHeaders: map[string]string{"Server": ""},
+					},
+				}},
+			},
+		},
+	})
+	add(step{ // headers for requests can't be set when not proxying
+		command: cmd("serve --bg --set-path=/text --set-header=X-Foo:bar text:hi"),
+		wantErr: anyErr(),
+	})
+	add(step{
+		command: cmd("serve --bg --set-path=/bad --method=GE/T localhost:3000"),
+		wantErr: anyErr(),
+	})
+
 	lc := &fakeLocalServeClient{}
 	// And now run the steps above.
 	for i, st := range steps {
@@ -978,6 +1064,7 @@ func TestExpandProxyTargetDev(t *testing.T) {
 		{name: "include-path", input: "http://127.0.0.1:8080/foo", expected: "http://127.0.0.1:8080/foo"},
 		{name: "https-scheme", input: "https://localhost:8080", expected: "https://127.0.0.1:8080"},
 		{name: "https+insecure-scheme", input: "https+insecure://localhost:8080", expected: "https+insecure://127.0.0.1:8080"},
+		{name: "h2c-scheme", input: "h2c://localhost:50051", expected: "h2c://127.0.0.1:50051"},
 		{name: "change-default-scheme", input: "localhost:8080", defaultScheme: "https", expected: "https://127.0.0.1:8080"},
 		{name: "change-supported-schemes", input: "localhost:8080", defaultScheme: "tcp", supportedSchemes: []string{"tcp"}, expected: "tcp://127.0.0.1:8080"},
 
@@ -990,7 +1077,7 @@ func TestExpandProxyTargetDev(t *testing.T) {
 
 	for _, tt := range tests {
 		defaultScheme := "http"
-		supportedSchemes := []string{"http", "https", "https+insecure"}
+		supportedSchemes := []string{"http", "https", "https+insecure", "h2c"}
 
 		if tt.supportedSchemes != nil {
 			supportedSchemes = tt.supportedSchemes
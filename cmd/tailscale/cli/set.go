@@ -9,14 +9,20 @@
 	"flag"
 	"fmt"
 	"net/netip"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/clientupdate"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/netutil"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/safesocket"
+	"tailscale.com/tailcfg"
 	"tailscale.com/types/views"
+	"tailscale.com/util/dnsname"
 )
 
 var setCmd = &ffcli.Command{
@@ -39,6 +45,7 @@ type setArgsT struct {
 	exitNodeIP             string
 	exitNodeAllowLANAccess bool
 	shieldsUp              bool
+	connApproval           bool
 	runSSH                 bool
 	hostname               string
 	advertiseRoutes        string
@@ -50,6 +57,24 @@ type setArgsT struct {
 	updateCheck            bool
 	updateApply            bool
 	postureChecking        bool
+	advertiseMetadata      string
+	advertiseDNSRecords    string
+	udpPortRange           string
+	udpPortRotate          time.Duration
+	udpPortRotateOnFailure bool
+	endpointPins           string
+	endpointTypes          string
+	peerEndpointTypes      string
+	outerDSCP              string
+	ipv6FlowLabels         bool
+	routeMetrics           string
+	dnsCache               string
+	dnsBlocklists          string
+	dnsBlocklistNullIP     bool
+	maintenanceWindow      string
+	webClient              string
+	halfOpenLimits         string
+	json                   bool
 }
 
 func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
@@ -58,9 +83,10 @@ func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
 	setf.StringVar(&setArgs.profileName, "nickname", "", "nickname for the current account")
 	setf.BoolVar(&setArgs.acceptRoutes, "accept-routes", false, "accept routes advertised by other Tailscale nodes")
 	setf.BoolVar(&setArgs.acceptDNS, "accept-dns", false, "accept DNS configuration from the admin panel")
-	setf.StringVar(&setArgs.exitNodeIP, "exit-node", "", "Tailscale exit node (IP or base name) for internet traffic, or empty string to not use an exit node")
+	setf.StringVar(&setArgs.exitNodeIP, "exit-node", "", "Tailscale exit node (IP or base name) for internet traffic, \"auto\" to choose the one with the lowest latency, or empty string to not use an exit node")
 	setf.BoolVar(&setArgs.exitNodeAllowLANAccess, "exit-node-allow-lan-access", false, "Allow direct access to the local network when routing traffic via an exit node")
 	setf.BoolVar(&setArgs.shieldsUp, "shields-up", false, "don't allow incoming connections")
+	setf.BoolVar(&setArgs.connApproval, "connection-approval", false, "ask before allowing new incoming connections from peers, per decisions made with \"tailscale approvals\"")
 	setf.BoolVar(&setArgs.runSSH, "ssh", false, "run an SSH server, permitting access per tailnet admin's declared policy")
 	setf.StringVar(&setArgs.hostname, "hostname", "", "hostname to use instead of the one provided by the OS")
 	setf.StringVar(&setArgs.advertiseRoutes, "advertise-routes", "", "routes to advertise to other nodes (comma-separated, e.g. \"10.0.0.0/8,192.168.0.0/24\") or empty string to not advertise routes")
@@ -68,6 +94,23 @@ func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
 	setf.BoolVar(&setArgs.updateCheck, "update-check", true, "HIDDEN: notify about available Tailscale updates")
 	setf.BoolVar(&setArgs.updateApply, "auto-update", false, "HIDDEN: automatically update to the latest available version")
 	setf.BoolVar(&setArgs.postureChecking, "posture-checking", false, "HIDDEN: allow management plane to gather device posture information")
+	setf.StringVar(&setArgs.advertiseMetadata, "advertise-metadata", "", "key=value metadata to publish about this node (comma-separated, e.g. \"rack=r12,team=infra\") or empty string to publish none")
+	setf.StringVar(&setArgs.advertiseDNSRecords, "advertise-dns-records", "", "DNS records to publish under this node's MagicDNS name, if the tailnet allows it (semicolon-separated \"name type value\", e.g. \"_ssh._tcp SRV 0 5 22 @;@ TXT owner=infra\"; names are relative to the node's name, \"@\" being the name itself) or empty string to publish none")
+	setf.StringVar(&setArgs.udpPortRange, "udp-port-range", "", "local UDP port range to use for WireGuard traffic (e.g. \"41641-41700\"), or empty string to use any port")
+	setf.DurationVar(&setArgs.udpPortRotate, "udp-port-rotate", 0, "how often to move to a new local UDP port (e.g. \"1h\"), or 0 to not move on a schedule")
+	setf.BoolVar(&setArgs.udpPortRotateOnFailure, "udp-port-rotate-on-failure", false, "move to a new local UDP port when UDP paths to peers repeatedly fail")
+	setf.StringVar(&setArgs.outerDSCP, "outer-dscp", "", "DSCP to mark the UDP packets carrying WireGuard traffic with (a name such as \"ef\" or \"af41\", or a number), \"copy\" to use the marking of the traffic they carry, or empty string to leave them unmarked (Linux only)")
+	setf.BoolVar(&setArgs.ipv6FlowLabels, "ipv6-flow-labels", false, "set stable per-peer flow labels on the IPv6 UDP packets carrying WireGuard traffic (Linux only)")
+	setf.StringVar(&setArgs.routeMetrics, "route-metrics", "", "class=metric metrics for the routes Tailscale installs, to make them win or lose against other VPN software (comma-separated, e.g. \"tailnet=10,subnet=500,exit-node=1000\"; lower wins), or empty string to use the defaults (Linux and Windows only)")
+	setf.StringVar(&setArgs.dnsCache, "dns-cache", "", "cache responses from upstream DNS resolvers, as a comma-separated list of the options max-entries=N (required), min-ttl=D and max-ttl=D clamping how long they're cached (e.g. \"max-entries=1000,max-ttl=1h\"), or empty string to not cache them")
+	setf.StringVar(&setArgs.dnsBlocklists, "dns-blocklists", "", "blocklists of names for the MagicDNS resolver to answer NXDOMAIN for, as absolute file paths or HTTPS URLs of hosts files or RPZ zone files (comma-separated, e.g. \"https://example.com/hosts,/etc/tailscale/blocklist\"), or empty string to use only the tailnet's")
+	setf.BoolVar(&setArgs.dnsBlocklistNullIP, "dns-blocklist-null-ip", false, "answer A and AAAA queries for names on --dns-blocklists with 0.0.0.0 and :: instead of NXDOMAIN")
+	setf.StringVar(&setArgs.maintenanceWindow, "maintenance-window", "", "cron-like schedule of when tailscaled may apply auto-updates and prompt to re-authenticate, as the five cron fields of when windows open followed by how long they last (e.g. \"0 2 * * mon-fri 2h\", optionally prefixed by \"CRON_TZ=UTC \"), or empty string to allow them at any time")
+	setf.StringVar(&setArgs.webClient, "webclient", "", "serve a web UI for managing this node on port 5252 of its Tailscale IPs to peers the tailnet policy grants the \"https://tailscale.com/cap/webui\" capability (\"tailnet\"), on localhost to users of this machine (\"localhost\", Linux only), or not at all (empty string); changes require logging in to the tailnet")
+	setf.StringVar(&setArgs.halfOpenLimits, "half-open-limits", "", "limit incoming TCP connections from peers that haven't completed their handshake, as a comma-separated list of the options per-peer=N and total=N (at least one required), timeout=D and evict=oldest to replace the oldest instead of dropping new ones (e.g. \"per-peer=1024,total=16384\"), or empty string for no limits")
+	setf.StringVar(&setArgs.endpointPins, "endpoint-pins", "", "peer=path pins fixing the path to peers (IP or base name), bypassing path discovery (comma-separated, e.g. \"db1=derp-only,db2=192.168.1.5:41641\"; a path is \"derp-only\", \"direct-only\" or an ip:port), or empty string to pin none")
+	setf.StringVar(&setArgs.endpointTypes, "endpoint-types", "", "which types of peers' endpoints to use (comma-separated \"local\", \"stun\", \"portmap\", \"stun4localport\", \"explicitconf\" or \"controlinferred\"; types prefixed with \"-\" are never used, the others are preferred in order, e.g. \"local,-portmap\"), or empty string to use all endpoints")
+	setf.StringVar(&setArgs.peerEndpointTypes, "peer-endpoint-types", "", "peer=types overrides of --endpoint-types for peers (IP or base name), in the same form (semicolon-separated, e.g. \"db1=-stun;db2=local,stun\"), or empty string to override none")
 
 	if safesocket.GOOSUsesPeerCreds(goos) {
 		setf.StringVar(&setArgs.opUser, "operator", "", "Unix username to allow to operate on tailscaled without sudo")
@@ -77,6 +120,8 @@ func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
 		setf.BoolVar(&setArgs.forceDaemon, "unattended", false, "run in \"Unattended Mode\" where Tailscale keeps running even after the current GUI user logs out (Windows-only)")
 	}
 
+	setf.BoolVar(&setArgs.json, "json", false, "output the resulting preferences as JSON")
+
 	registerAcceptRiskFlag(setf, &setArgs.acceptedRisks)
 	return setf
 }
@@ -103,6 +148,7 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 			CorpDNS:                setArgs.acceptDNS,
 			ExitNodeAllowLANAccess: setArgs.exitNodeAllowLANAccess,
 			ShieldsUp:              setArgs.shieldsUp,
+			ConnApproval:           setArgs.connApproval,
 			RunSSH:                 setArgs.runSSH,
 			Hostname:               setArgs.hostname,
 			OperatorUser:           setArgs.opUser,
@@ -111,11 +157,42 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 				Check: setArgs.updateCheck,
 				Apply: setArgs.updateApply,
 			},
-			PostureChecking: setArgs.postureChecking,
+			PostureChecking:    setArgs.postureChecking,
+			EndpointTypes:      setArgs.endpointTypes,
+			OuterDSCP:          setArgs.outerDSCP,
+			IPv6FlowLabels:     setArgs.ipv6FlowLabels,
+			DNSBlocklists:      parseDNSBlocklistsFlag(setArgs.dnsBlocklists),
+			DNSBlocklistNullIP: setArgs.dnsBlocklistNullIP,
+			MaintenanceWindow:  setArgs.maintenanceWindow,
+			WebClient:          setArgs.webClient,
 		},
 	}
 
-	if setArgs.exitNodeIP != "" {
+	if maskedPrefs.AdvertiseMetadata, err = parseMetadataFlag(setArgs.advertiseMetadata); err != nil {
+		return err
+	}
+	if maskedPrefs.AdvertiseDNSRecords, err = parseDNSRecordsFlag(setArgs.advertiseDNSRecords); err != nil {
+		return err
+	}
+	if maskedPrefs.EndpointPins, err = parseEndpointPinsFlag(setArgs.endpointPins, st); err != nil {
+		return err
+	}
+	if maskedPrefs.PeerEndpointTypes, err = parsePeerEndpointTypesFlag(setArgs.peerEndpointTypes, st); err != nil {
+		return err
+	}
+	if maskedPrefs.RouteMetrics, err = parseRouteMetricsFlag(setArgs.routeMetrics); err != nil {
+		return err
+	}
+	if maskedPrefs.DNSCache, err = parseDNSCacheFlag(setArgs.dnsCache); err != nil {
+		return err
+	}
+	if maskedPrefs.HalfOpenLimits, err = parseHalfOpenLimitsFlag(setArgs.halfOpenLimits); err != nil {
+		return err
+	}
+
+	if setArgs.exitNodeIP == "auto" {
+		maskedPrefs.AutoExitNode = true
+	} else if setArgs.exitNodeIP != "" {
 		if err := maskedPrefs.Prefs.SetExitNodeIP(setArgs.exitNodeIP, st); err != nil {
 			var e ipn.ExitNodeLocalIPError
 			if errors.As(err, &e) {
@@ -126,6 +203,7 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 	}
 
 	var advertiseExitNodeSet, advertiseRoutesSet bool
+	udpPortFlagsSet := map[string]bool{}
 	setFlagSet.Visit(func(f *flag.Flag) {
 		updateMaskedPrefsFromUpOrSetFlag(maskedPrefs, f.Name)
 		switch f.Name {
@@ -133,6 +211,8 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 			advertiseExitNodeSet = true
 		case "advertise-routes":
 			advertiseRoutesSet = true
+		case "udp-port-range", "udp-port-rotate", "udp-port-rotate-on-failure":
+			udpPortFlagsSet[f.Name] = true
 		}
 	})
 	if maskedPrefs.IsEmpty() {
@@ -150,6 +230,13 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 		}
 	}
 
+	if maskedPrefs.UDPPortSet {
+		maskedPrefs.UDPPort, err = calcUDPPortForSet(curPrefs.UDPPort, udpPortFlagsSet, setArgs)
+		if err != nil {
+			return err
+		}
+	}
+
 	if maskedPrefs.RunSSHSet {
 		wantSSH, haveSSH := maskedPrefs.RunSSH, curPrefs.RunSSH
 		if err := presentSSHToggleRisk(wantSSH, haveSSH, setArgs.acceptedRisks); err != nil {
@@ -168,8 +255,14 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 		return err
 	}
 
-	_, err = localClient.EditPrefs(ctx, maskedPrefs)
-	return err
+	newPrefs, err := localClient.EditPrefs(ctx, maskedPrefs)
+	if err != nil {
+		return err
+	}
+	if setArgs.json {
+		printJSON(newPrefs)
+	}
+	return nil
 }
 
 // calcAdvertiseRoutesForSet returns the new value for Prefs.AdvertiseRoutes based on the
@@ -201,3 +294,298 @@ func calcAdvertiseRoutesForSet(advertiseExitNodeSet, advertiseRoutesSet bool, cu
 	}
 	return nil, nil
 }
+
+// parseMetadataFlag parses the value of the --advertise-metadata flag, a
+// comma-separated list of key=value pairs, into a Hostinfo.Metadata map.
+// An empty string returns a nil map.
+func parseMetadataFlag(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	md := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --advertise-metadata entry %q; want key=value", kv)
+		}
+		if _, dup := md[k]; dup {
+			return nil, fmt.Errorf("duplicate --advertise-metadata key %q", k)
+		}
+		md[k] = v
+	}
+	if err := tailcfg.CheckHostinfoMetadata(md); err != nil {
+		return nil, fmt.Errorf("invalid --advertise-metadata: %w", err)
+	}
+	return md, nil
+}
+
+// parseDNSRecordsFlag parses the value of the --advertise-dns-records flag,
+// a semicolon-separated list of "name type value" records, into
+// Hostinfo.DNSRecords. The value is the rest of each record, so it can
+// contain spaces, as SRV values do. An empty string returns nil.
+func parseDNSRecordsFlag(s string) ([]tailcfg.DNSRecord, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var recs []tailcfg.DNSRecord
+	for _, r := range strings.Split(s, ";") {
+		f := strings.SplitN(strings.TrimSpace(r), " ", 3)
+		if len(f) != 3 {
+			return nil, fmt.Errorf("invalid --advertise-dns-records entry %q; want \"name type value\"", r)
+		}
+		recs = append(recs, tailcfg.DNSRecord{
+			Name:  f[0],
+			Type:  strings.ToUpper(f[1]),
+			Value: f[2],
+		})
+	}
+	if err := tailcfg.CheckHostinfoDNSRecords(recs); err != nil {
+		return nil, fmt.Errorf("invalid --advertise-dns-records: %w", err)
+	}
+	return recs, nil
+}
+
+// parseRouteMetricsFlag parses the value of the --route-metrics flag, a
+// comma-separated list of class=metric pairs where the classes are
+// "tailnet", "subnet" and "exit-node". Classes not listed use the
+// platform's default metric.
+func parseRouteMetricsFlag(s string) (ipn.RouteMetricPrefs, error) {
+	var rm ipn.RouteMetricPrefs
+	if s == "" {
+		return rm, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return ipn.RouteMetricPrefs{}, fmt.Errorf("invalid --route-metrics entry %q; want class=metric", kv)
+		}
+		m, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return ipn.RouteMetricPrefs{}, fmt.Errorf("invalid --route-metrics metric %q", v)
+		}
+		switch k {
+		case "tailnet":
+			rm.Tailnet = uint32(m)
+		case "subnet":
+			rm.Subnet = uint32(m)
+		case "exit-node":
+			rm.ExitNode = uint32(m)
+		default:
+			return ipn.RouteMetricPrefs{}, fmt.Errorf("invalid --route-metrics class %q; want \"tailnet\", \"subnet\" or \"exit-node\"", k)
+		}
+	}
+	return rm, nil
+}
+
+// parseDNSBlocklistsFlag parses the value of the --dns-blocklists flag,
+// a comma-separated list of blocklist sources. tailscaled validates them.
+func parseDNSBlocklistsFlag(s string) []string {
+	var srcs []string
+	for _, src := range strings.Split(s, ",") {
+		if src = strings.TrimSpace(src); src != "" {
+			srcs = append(srcs, src)
+		}
+	}
+	return srcs
+}
+
+// parseDNSCacheFlag parses the value of the --dns-cache flag, a
+// comma-separated list of the options max-entries=N, min-ttl=D and
+// max-ttl=D. An empty string disables the cache.
+func parseDNSCacheFlag(s string) (ipn.DNSCachePrefs, error) {
+	var dc ipn.DNSCachePrefs
+	if s == "" {
+		return dc, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return ipn.DNSCachePrefs{}, fmt.Errorf("invalid --dns-cache option %q; want name=value", kv)
+		}
+		var err error
+		switch k {
+		case "max-entries":
+			dc.MaxEntries, err = strconv.Atoi(v)
+		case "min-ttl":
+			dc.MinTTL, err = time.ParseDuration(v)
+		case "max-ttl":
+			dc.MaxTTL, err = time.ParseDuration(v)
+		default:
+			return ipn.DNSCachePrefs{}, fmt.Errorf("invalid --dns-cache option %q; want \"max-entries\", \"min-ttl\" or \"max-ttl\"", k)
+		}
+		if err != nil {
+			return ipn.DNSCachePrefs{}, fmt.Errorf("invalid --dns-cache %s value %q", k, v)
+		}
+	}
+	if dc.MaxEntries <= 0 {
+		return ipn.DNSCachePrefs{}, errors.New("--dns-cache needs a positive max-entries")
+	}
+	if err := dc.Check(); err != nil {
+		return ipn.DNSCachePrefs{}, fmt.Errorf("invalid --dns-cache: %w", err)
+	}
+	return dc, nil
+}
+
+// parseHalfOpenLimitsFlag parses the value of the --half-open-limits flag,
+// a comma-separated list of the options per-peer=N, total=N, timeout=D and
+// evict=oldest. An empty string means no limits.
+func parseHalfOpenLimitsFlag(s string) (ipn.HalfOpenLimitsPrefs, error) {
+	var hl ipn.HalfOpenLimitsPrefs
+	if s == "" {
+		return hl, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return ipn.HalfOpenLimitsPrefs{}, fmt.Errorf("invalid --half-open-limits option %q; want name=value", kv)
+		}
+		var err error
+		switch k {
+		case "per-peer":
+			hl.MaxPerPeer, err = strconv.Atoi(v)
+		case "total":
+			hl.MaxTotal, err = strconv.Atoi(v)
+		case "timeout":
+			hl.Timeout, err = time.ParseDuration(v)
+		case "evict":
+			if v != "oldest" {
+				err = errors.New("unknown eviction policy")
+			}
+			hl.EvictOldest = true
+		default:
+			return ipn.HalfOpenLimitsPrefs{}, fmt.Errorf("invalid --half-open-limits option %q; want \"per-peer\", \"total\", \"timeout\" or \"evict\"", k)
+		}
+		if err != nil {
+			return ipn.HalfOpenLimitsPrefs{}, fmt.Errorf("invalid --half-open-limits %s value %q", k, v)
+		}
+	}
+	if hl.MaxPerPeer <= 0 && hl.MaxTotal <= 0 {
+		return ipn.HalfOpenLimitsPrefs{}, errors.New("--half-open-limits needs a positive per-peer or total")
+	}
+	if err := hl.Check(); err != nil {
+		return ipn.HalfOpenLimitsPrefs{}, fmt.Errorf("invalid --half-open-limits: %w", err)
+	}
+	return hl, nil
+}
+
+// parseEndpointPinsFlag parses the value of the --endpoint-pins flag, a
+// comma-separated list of peer=path pairs, into a Prefs.EndpointPins map.
+// Peers are given by Tailscale IP or MagicDNS base name and looked up in
+// st. The paths are validated by tailscaled. An empty string returns a nil
+// map.
+func parseEndpointPinsFlag(s string, st *ipnstate.Status) (map[tailcfg.StableNodeID]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	pins := make(map[tailcfg.StableNodeID]string)
+	for _, kv := range strings.Split(s, ",") {
+		peer, path, ok := strings.Cut(kv, "=")
+		if !ok || path == "" {
+			return nil, fmt.Errorf("invalid --endpoint-pins entry %q; want peer=path", kv)
+		}
+		ps, err := peerForFlag(st, "endpoint-pins", peer)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := pins[ps.ID]; dup {
+			return nil, fmt.Errorf("duplicate --endpoint-pins peer %q", peer)
+		}
+		pins[ps.ID] = path
+	}
+	return pins, nil
+}
+
+// parsePeerEndpointTypesFlag parses the value of the --peer-endpoint-types
+// flag, a semicolon-separated list of peer=types pairs, into a
+// Prefs.PeerEndpointTypes map. Peers are given by Tailscale IP or MagicDNS
+// base name and looked up in st. The types are validated by tailscaled. An
+// empty string returns a nil map.
+func parsePeerEndpointTypesFlag(s string, st *ipnstate.Status) (map[tailcfg.StableNodeID]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	m := make(map[tailcfg.StableNodeID]string)
+	for _, kv := range strings.Split(s, ";") {
+		peer, types, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --peer-endpoint-types entry %q; want peer=types", kv)
+		}
+		ps, err := peerForFlag(st, "peer-endpoint-types", peer)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := m[ps.ID]; dup {
+			return nil, fmt.Errorf("duplicate --peer-endpoint-types peer %q", peer)
+		}
+		m[ps.ID] = types
+	}
+	return m, nil
+}
+
+// peerForFlag returns the peer in st whose Tailscale IP or MagicDNS base
+// name is s, the peer given in an entry of the named flag.
+func peerForFlag(st *ipnstate.Status, flagName, s string) (*ipnstate.PeerStatus, error) {
+	if ps, ok := peerMatchingIP(st, s); ok {
+		if ps == st.Self {
+			return nil, fmt.Errorf("cannot use %s in --%s; it is this machine", s, flagName)
+		}
+		return ps, nil
+	}
+	var match *ipnstate.PeerStatus
+	for _, ps := range st.Peer {
+		if !strings.EqualFold(s, dnsname.TrimSuffix(ps.DNSName, st.MagicDNSSuffix)) {
+			continue
+		}
+		if match != nil {
+			return nil, fmt.Errorf("ambiguous peer name %q in --%s", s, flagName)
+		}
+		match = ps
+	}
+	if match == nil {
+		return nil, fmt.Errorf("no peer %q found for --%s; must be IP or unique node name", s, flagName)
+	}
+	return match, nil
+}
+
+// calcUDPPortForSet returns the new value for Prefs.UDPPort based on the
+// current value cur and the --udp-port-* flags passed to "tailscale set".
+// flagIsSet reports which of those flags were set; the others leave their
+// part of cur unchanged.
+func calcUDPPortForSet(cur ipn.UDPPortPrefs, flagIsSet map[string]bool, setArgs setArgsT) (ipn.UDPPortPrefs, error) {
+	up := cur
+	if flagIsSet["udp-port-range"] {
+		var err error
+		up.RangeFirst, up.RangeLast, err = parseUDPPortRange(setArgs.udpPortRange)
+		if err != nil {
+			return ipn.UDPPortPrefs{}, err
+		}
+	}
+	if flagIsSet["udp-port-rotate"] {
+		up.RotateEvery = setArgs.udpPortRotate
+	}
+	if flagIsSet["udp-port-rotate-on-failure"] {
+		up.RotateOnPathFailure = setArgs.udpPortRotateOnFailure
+	}
+	if err := up.Check(); err != nil {
+		return ipn.UDPPortPrefs{}, err
+	}
+	return up, nil
+}
+
+// parseUDPPortRange parses the value of the --udp-port-range flag, either
+// "first-last" or a single port. An empty string returns zero ports.
+func parseUDPPortRange(s string) (first, last uint16, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	firstStr, lastStr, ok := strings.Cut(s, "-")
+	if !ok {
+		lastStr = firstStr
+	}
+	f, err1 := strconv.ParseUint(firstStr, 10, 16)
+	l, err2 := strconv.ParseUint(lastStr, 10, 16)
+	if err1 != nil || err2 != nil || f == 0 || l == 0 || f > l {
+		return 0, 0, fmt.Errorf("invalid --udp-port-range %q; want a port or \"first-last\"", s)
+	}
+	return uint16(f), uint16(l), nil
+}
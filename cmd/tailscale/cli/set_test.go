@@ -7,9 +7,13 @@
 	"net/netip"
 	"reflect"
 	"testing"
+	"time"
 
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
 	"tailscale.com/types/ptr"
 )
 
@@ -129,3 +133,292 @@ func TestCalcAdvertiseRoutesForSet(t *testing.T) {
 		})
 	}
 }
+
+func TestParseMetadataFlag(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "rack=r12", want: map[string]string{"rack": "r12"}},
+		{in: "rack=r12,team=infra,empty=", want: map[string]string{"rack": "r12", "team": "infra", "empty": ""}},
+		{in: "rack", wantErr: true},
+		{in: "rack=a,rack=b", wantErr: true},
+		{in: "bad key=x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseMetadataFlag(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseMetadataFlag(%q) err = %v; want error: %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseMetadataFlag(%q) = %v; want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseDNSRecordsFlag(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []tailcfg.DNSRecord
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "_ssh._tcp srv 0 5 22 @", want: []tailcfg.DNSRecord{
+			{Name: "_ssh._tcp", Type: "SRV", Value: "0 5 22 @"},
+		}},
+		{in: "@ TXT owner=infra, team=net; www CNAME web.example.com.", want: []tailcfg.DNSRecord{
+			{Name: "@", Type: "TXT", Value: "owner=infra, team=net"},
+			{Name: "www", Type: "CNAME", Value: "web.example.com."},
+		}},
+		{in: "@ TXT", wantErr: true},
+		{in: "@ A 1.2.3.4", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseDNSRecordsFlag(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDNSRecordsFlag(%q) err = %v; want error: %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseDNSRecordsFlag(%q) = %v; want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseDNSCacheFlag(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ipn.DNSCachePrefs
+		wantErr bool
+	}{
+		{in: "", want: ipn.DNSCachePrefs{}},
+		{in: "max-entries=1000", want: ipn.DNSCachePrefs{MaxEntries: 1000}},
+		{in: "max-entries=500,min-ttl=30s,max-ttl=1h", want: ipn.DNSCachePrefs{MaxEntries: 500, MinTTL: 30 * time.Second, MaxTTL: time.Hour}},
+		{in: "max-ttl=1h", wantErr: true},
+		{in: "max-entries=10,min-ttl=1h,max-ttl=1m", wantErr: true},
+		{in: "max-entries=ten", wantErr: true},
+		{in: "max-entries=10,ttl=1m", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseDNSCacheFlag(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDNSCacheFlag(%q) err = %v; want error: %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseDNSCacheFlag(%q) = %+v; want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseHalfOpenLimitsFlag(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ipn.HalfOpenLimitsPrefs
+		wantErr bool
+	}{
+		{in: "", want: ipn.HalfOpenLimitsPrefs{}},
+		{in: "per-peer=1024", want: ipn.HalfOpenLimitsPrefs{MaxPerPeer: 1024}},
+		{in: "per-peer=64,total=1024,timeout=10s,evict=oldest", want: ipn.HalfOpenLimitsPrefs{MaxPerPeer: 64, MaxTotal: 1024, Timeout: 10 * time.Second, EvictOldest: true}},
+		{in: "timeout=10s", wantErr: true},
+		{in: "total=-1", wantErr: true},
+		{in: "total=10,evict=newest", wantErr: true},
+		{in: "max=10", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseHalfOpenLimitsFlag(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseHalfOpenLimitsFlag(%q) err = %v; want error: %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseHalfOpenLimitsFlag(%q) = %+v; want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseRouteMetricsFlag(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ipn.RouteMetricPrefs
+		wantErr bool
+	}{
+		{in: "", want: ipn.RouteMetricPrefs{}},
+		{in: "subnet=500", want: ipn.RouteMetricPrefs{Subnet: 500}},
+		{in: "tailnet=10,subnet=500,exit-node=1000", want: ipn.RouteMetricPrefs{Tailnet: 10, Subnet: 500, ExitNode: 1000}},
+		{in: "subnet", wantErr: true},
+		{in: "subnet=-1", wantErr: true},
+		{in: "exit=1000", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseRouteMetricsFlag(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRouteMetricsFlag(%q) err = %v; want error: %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseRouteMetricsFlag(%q) = %+v; want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseEndpointPinsFlag(t *testing.T) {
+	st := &ipnstate.Status{
+		MagicDNSSuffix: "foo.ts.net",
+		Self: &ipnstate.PeerStatus{
+			ID:           "self",
+			DNSName:      "me.foo.ts.net.",
+			TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.1")},
+		},
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {
+				ID:           "n1",
+				DNSName:      "db1.foo.ts.net.",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.2")},
+			},
+			key.NewNode().Public(): {
+				ID:           "n2",
+				DNSName:      "db2.foo.ts.net.",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.3")},
+			},
+		},
+	}
+	tests := []struct {
+		in      string
+		want    map[tailcfg.StableNodeID]string
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "db1=derp-only", want: map[tailcfg.StableNodeID]string{"n1": "derp-only"}},
+		{in: "DB1=derp-only,100.64.0.3=192.168.1.5:41641", want: map[tailcfg.StableNodeID]string{"n1": "derp-only", "n2": "192.168.1.5:41641"}},
+		{in: "db1", wantErr: true},
+		{in: "db1=", wantErr: true},
+		{in: "db3=derp-only", wantErr: true},
+		{in: "100.64.0.1=derp-only", wantErr: true},
+		{in: "db1=derp-only,100.64.0.2=direct-only", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseEndpointPinsFlag(tt.in, st)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseEndpointPinsFlag(%q) err = %v; want error: %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseEndpointPinsFlag(%q) = %v; want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParsePeerEndpointTypesFlag(t *testing.T) {
+	st := &ipnstate.Status{
+		MagicDNSSuffix: "foo.ts.net",
+		Self: &ipnstate.PeerStatus{
+			ID:           "self",
+			DNSName:      "me.foo.ts.net.",
+			TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.1")},
+		},
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {
+				ID:           "n1",
+				DNSName:      "db1.foo.ts.net.",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.2")},
+			},
+			key.NewNode().Public(): {
+				ID:           "n2",
+				DNSName:      "db2.foo.ts.net.",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.3")},
+			},
+		},
+	}
+	tests := []struct {
+		in      string
+		want    map[tailcfg.StableNodeID]string
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "db1=-stun", want: map[tailcfg.StableNodeID]string{"n1": "-stun"}},
+		{in: "db1=local,-stun;100.64.0.3=", want: map[tailcfg.StableNodeID]string{"n1": "local,-stun", "n2": ""}},
+		{in: "db1", wantErr: true},
+		{in: "db3=local", wantErr: true},
+		{in: "100.64.0.1=local", wantErr: true},
+		{in: "db1=local;100.64.0.2=stun", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parsePeerEndpointTypesFlag(tt.in, st)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePeerEndpointTypesFlag(%q) err = %v; want error: %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePeerEndpointTypesFlag(%q) = %v; want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCalcUDPPortForSet(t *testing.T) {
+	cur := ipn.UDPPortPrefs{RangeFirst: 41000, RangeLast: 41099, RotateEvery: time.Hour}
+	tests := []struct {
+		name    string
+		flags   map[string]bool
+		args    setArgsT
+		want    ipn.UDPPortPrefs
+		wantErr bool
+	}{
+		{
+			name:  "rotate-on-failure-keeps-rest",
+			flags: map[string]bool{"udp-port-rotate-on-failure": true},
+			args:  setArgsT{udpPortRotateOnFailure: true},
+			want:  ipn.UDPPortPrefs{RangeFirst: 41000, RangeLast: 41099, RotateEvery: time.Hour, RotateOnPathFailure: true},
+		},
+		{
+			name:  "new-range",
+			flags: map[string]bool{"udp-port-range": true},
+			args:  setArgsT{udpPortRange: "50000-50010"},
+			want:  ipn.UDPPortPrefs{RangeFirst: 50000, RangeLast: 50010, RotateEvery: time.Hour},
+		},
+		{
+			name:  "single-port",
+			flags: map[string]bool{"udp-port-range": true},
+			args:  setArgsT{udpPortRange: "41641"},
+			want:  ipn.UDPPortPrefs{RangeFirst: 41641, RangeLast: 41641, RotateEvery: time.Hour},
+		},
+		{
+			name:  "clear",
+			flags: map[string]bool{"udp-port-range": true, "udp-port-rotate": true},
+			args:  setArgsT{},
+			want:  ipn.UDPPortPrefs{},
+		},
+		{
+			name:    "backwards-range",
+			flags:   map[string]bool{"udp-port-range": true},
+			args:    setArgsT{udpPortRange: "50010-50000"},
+			wantErr: true,
+		},
+		{
+			name:    "bad-port",
+			flags:   map[string]bool{"udp-port-range": true},
+			args:    setArgsT{udpPortRange: "70000"},
+			wantErr: true,
+		},
+		{
+			name:    "rotate-too-often",
+			flags:   map[string]bool{"udp-port-rotate": true},
+			args:    setArgsT{udpPortRotate: time.Second},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := calcUDPPortForSet(cur, tt.flags, tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v; want error: %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,15 +5,16 @@
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
-	"errors"
 	"flag"
 	"fmt"
 	"net"
 	"net/http"
 	"net/netip"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -30,10 +31,25 @@
 
 var statusCmd = &ffcli.Command{
 	Name:       "status",
-	ShortUsage: "status [--active] [--web] [--json]",
+	ShortUsage: "status [--active] [--bytes] [--web] [--json] [--filter=<selector>] [<selector>...]",
 	ShortHelp:  "Show state of tailscaled and its connections",
 	LongHelp: strings.TrimSpace(`
 
+SELECTORS
+
+The peers shown can be limited with selector terms, given with --filter or
+as arguments, of the form "key:value":
+
+  tag:<tag>          peers with the tag, such as tag:prod
+  user:<login>       peers owned by the user, such as user:alice or
+                     user:alice@example.com
+  os:<os>            peers running the OS, such as os:linux
+  online:<bool>      peers that are (or aren't) connected to control
+
+A peer is shown if, for each key, it matches any of the terms with that
+key. For example, "tailscale status --filter 'tag:prod tag:staging' os:linux"
+shows Linux peers tagged either tag:prod or tag:staging.
+
 JSON FORMAT
 
 Warning: this format has changed between releases and might change more
@@ -53,10 +69,12 @@
 		fs.BoolVar(&statusArgs.json, "json", false, "output in JSON format (WARNING: format subject to change)")
 		fs.BoolVar(&statusArgs.web, "web", false, "run webserver with HTML showing status")
 		fs.BoolVar(&statusArgs.active, "active", false, "filter output to only peers with active sessions (not applicable to web mode)")
+		fs.BoolVar(&statusArgs.bytes, "bytes", false, "show each peer's traffic in packets and recent rates, busiest peers first (not applicable to web mode)")
 		fs.BoolVar(&statusArgs.self, "self", true, "show status of local machine")
 		fs.BoolVar(&statusArgs.peers, "peers", true, "show status of peers")
 		fs.StringVar(&statusArgs.listen, "listen", "127.0.0.1:8384", "listen address for web mode; use port 0 for automatic")
 		fs.BoolVar(&statusArgs.browser, "browser", true, "Open a browser in web mode")
+		fs.StringVar(&statusArgs.filter, "filter", "", "show only the peers matching the selector, such as \"tag:prod os:linux\"")
 		return fs
 	})(),
 }
@@ -67,17 +85,30 @@
 	listen  string // in web mode, webserver address to listen on, empty means auto
 	browser bool   // in web mode, whether to open browser
 	active  bool   // in CLI mode, filter output to only peers with active sessions
+	bytes   bool   // in CLI mode, show per-peer traffic detail, busiest first
 	self    bool   // in CLI mode, show status of local machine
 	peers   bool   // in CLI mode, show status of peer machines
+	filter  string // ipnstate.PeerSelector of the peers to show
 }
 
 func runStatus(ctx context.Context, args []string) error {
-	if len(args) > 0 {
-		return errors.New("unexpected non-flag arguments to 'tailscale status'")
+	filter := statusArgs.filter
+	for _, arg := range args {
+		if !ipnstate.LooksLikePeerSelector(arg) {
+			return fmt.Errorf("unexpected non-flag argument %q to 'tailscale status'; want a selector such as tag:prod", arg)
+		}
+		filter += " " + arg
+	}
+	if _, err := ipnstate.ParsePeerSelector(filter); err != nil {
+		return usageError{err}
 	}
 	getStatus := localClient.Status
 	if !statusArgs.peers {
 		getStatus = localClient.StatusWithoutPeers
+	} else if strings.TrimSpace(filter) != "" {
+		getStatus = func(ctx context.Context) (*ipnstate.Status, error) {
+			return localClient.StatusWithPeerFilter(ctx, filter)
+		}
 	}
 	st, err := getStatus(ctx)
 	if err != nil {
@@ -117,7 +148,7 @@ func runStatus(ctx context.Context, args []string) error {
 				http.NotFound(w, r)
 				return
 			}
-			st, err := localClient.Status(ctx)
+			st, err := getStatus(ctx)
 			if err != nil {
 				http.Error(w, err.Error(), 500)
 				return
@@ -159,6 +190,10 @@ func runStatus(ctx context.Context, args []string) error {
 			ownerLogin(st, ps),
 			ps.OS,
 		)
+		if ps.Quarantined {
+			f("key expired; traffic blocked\n")
+			return
+		}
 		relay := ps.Relay
 		anyTraffic := ps.TxBytes != 0 || ps.RxBytes != 0
 		var offline string
@@ -193,9 +228,16 @@ func runStatus(ctx context.Context, args []string) error {
 				f("; offline")
 			}
 		}
-		if anyTraffic {
+		if anyTraffic && statusArgs.bytes {
+			f(", tx %s in %d pkts (%s/s) rx %s in %d pkts (%s/s)",
+				formatIEC(float64(ps.TxBytes), "B"), ps.TxPackets, formatIEC(ps.TxBytesPerSec, "B"),
+				formatIEC(float64(ps.RxBytes), "B"), ps.RxPackets, formatIEC(ps.RxBytesPerSec, "B"))
+		} else if anyTraffic {
 			f(", tx %d rx %d", ps.TxBytes, ps.RxBytes)
 		}
+		if ps.EndpointPin != "" {
+			f("; pinned %s", ps.EndpointPin)
+		}
 		f("\n")
 	}
 
@@ -220,6 +262,11 @@ func runStatus(ctx context.Context, args []string) error {
 			peers = append(peers, ps)
 		}
 		ipnstate.SortPeers(peers)
+		if statusArgs.bytes {
+			slices.SortStableFunc(peers, func(a, b *ipnstate.PeerStatus) int {
+				return cmp.Compare(b.TxBytes+b.RxBytes, a.TxBytes+a.RxBytes)
+			})
+		}
 		for _, ps := range peers {
 			if statusArgs.active && !ps.Active {
 				continue
@@ -0,0 +1,128 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mattn/go-isatty"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/util/dnsname"
+)
+
+var topCmd = &ffcli.Command{
+	Name:       "top",
+	ShortUsage: "top [--interval=<duration>]",
+	ShortHelp:  "Show live traffic to and from peers",
+	LongHelp: strings.TrimSpace(`
+"tailscale top" shows the peers this node is exchanging traffic with,
+busiest first, refreshed until interrupted. For each peer it shows:
+
+  PATH     how traffic goes: "direct" or "derp"
+  LATENCY  the round-trip time over that path
+  FLOWS    new connections to or from the peer in the last minute,
+           counted from when "tailscale top" was first run
+  TX/s     the rate of traffic sent to the peer
+  RX/s     the rate of traffic received from the peer
+  TX, RX   the totals
+`),
+	Exec: runTop,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("top")
+		fs.DurationVar(&topArgs.interval, "interval", time.Second, "how often to refresh")
+		fs.BoolVar(&topArgs.json, "json", false, "output each refresh as a line of JSON")
+		return fs
+	})(),
+}
+
+var topArgs struct {
+	interval time.Duration
+	json     bool
+}
+
+func runTop(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	rc, err := localClient.StreamPeerTraffic(ctx, topArgs.interval)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	defer rc.Close()
+	clearScreen := isatty.IsTerminal(os.Stdout.Fd())
+	d := json.NewDecoder(rc)
+	for {
+		var peers []ipnstate.PeerTraffic
+		if err := d.Decode(&peers); err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if topArgs.json {
+			j, _ := json.Marshal(peers)
+			outln(string(j))
+			continue
+		}
+		if clearScreen {
+			// Move to the top left and clear the screen.
+			printf("\x1b[H\x1b[2J")
+		}
+		printTop(Stdout, peers)
+	}
+}
+
+// printTop writes the table of "tailscale top" for peers to w.
+func printTop(w io.Writer, peers []ipnstate.PeerTraffic) {
+	var txRate, rxRate float64
+	for _, p := range peers {
+		txRate += p.TxBytesPerSec
+		rxRate += p.RxBytesPerSec
+	}
+	fmt.Fprintf(w, "%s  %d peers  tx %s/s  rx %s/s\n\n", time.Now().Format(time.TimeOnly), len(peers), formatBytes(txRate), formatBytes(rxRate))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "PEER\tIP\tPATH\tLATENCY\tFLOWS\tTX/s\tRX/s\tTX\tRX\t")
+	for _, p := range peers {
+		name := dnsname.FirstLabel(p.DNSName)
+		if name == "" {
+			name = p.NodeKey.ShortString()
+		}
+		path, latency := "-", "-"
+		if p.Path != "" {
+			path = p.Path
+		}
+		if p.LatencySeconds > 0 {
+			latency = time.Duration(p.LatencySeconds * float64(time.Second)).Round(100 * time.Microsecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t\n",
+			name, p.IP, path, latency, p.Flows,
+			formatBytes(p.TxBytesPerSec), formatBytes(p.RxBytesPerSec),
+			formatBytes(float64(p.TxBytes)), formatBytes(float64(p.RxBytes)))
+	}
+	tw.Flush()
+}
+
+// formatBytes formats n bytes for humans, such as "1.5M".
+func formatBytes(n float64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%.0fB", n)
+	}
+	i := -1
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%c", n, units[i])
+}
@@ -106,9 +106,10 @@ func newUpFlagSet(goos string, upArgs *upArgsT, cmd string) *flag.FlagSet {
 	upf.BoolVar(&upArgs.acceptRoutes, "accept-routes", acceptRouteDefault(goos), "accept routes advertised by other Tailscale nodes")
 	upf.BoolVar(&upArgs.acceptDNS, "accept-dns", true, "accept DNS configuration from the admin panel")
 	upf.BoolVar(&upArgs.singleRoutes, "host-routes", true, "HIDDEN: install host routes to other Tailscale nodes")
-	upf.StringVar(&upArgs.exitNodeIP, "exit-node", "", "Tailscale exit node (IP or base name) for internet traffic, or empty string to not use an exit node")
+	upf.StringVar(&upArgs.exitNodeIP, "exit-node", "", "Tailscale exit node (IP or base name) for internet traffic, \"auto\" to choose the one with the lowest latency, or empty string to not use an exit node")
 	upf.BoolVar(&upArgs.exitNodeAllowLANAccess, "exit-node-allow-lan-access", false, "Allow direct access to the local network when routing traffic via an exit node")
 	upf.BoolVar(&upArgs.shieldsUp, "shields-up", false, "don't allow incoming connections")
+	upf.BoolVar(&upArgs.connApproval, "connection-approval", false, "ask before allowing new incoming connections from peers, per decisions made with \"tailscale approvals\"")
 	upf.BoolVar(&upArgs.runSSH, "ssh", false, "run an SSH server, permitting access per tailnet admin's declared policy")
 	upf.StringVar(&upArgs.advertiseTags, "advertise-tags", "", "comma-separated ACL tags to request; each must start with \"tag:\" (e.g. \"tag:eng,tag:montreal,tag:ssh\")")
 	upf.StringVar(&upArgs.hostname, "hostname", "", "hostname to use instead of the one provided by the OS")
@@ -133,7 +134,7 @@ func newUpFlagSet(goos string, upArgs *upArgsT, cmd string) *flag.FlagSet {
 
 	if cmd == "up" {
 		// Some flags are only for "up", not "login".
-		upf.BoolVar(&upArgs.json, "json", false, "output in JSON format (WARNING: format subject to change)")
+		upf.BoolVar(&upArgs.json, "json", false, "output in JSON format")
 		upf.BoolVar(&upArgs.reset, "reset", false, "reset unspecified settings to their default values")
 		upf.BoolVar(&upArgs.forceReauth, "force-reauth", false, "force reauthentication")
 		registerAcceptRiskFlag(upf, &upArgs.acceptedRisks)
@@ -159,6 +160,7 @@ type upArgsT struct {
 	exitNodeIP             string
 	exitNodeAllowLANAccess bool
 	shieldsUp              bool
+	connApproval           bool
 	runSSH                 bool
 	forceReauth            bool
 	forceDaemon            bool
@@ -262,7 +264,9 @@ func prefsFromUpArgs(upArgs upArgsT, warnf logger.Logf, st *ipnstate.Status, goo
 		// supports "off" mode.
 		prefs.NetfilterMode = preftype.NetfilterOff
 	}
-	if upArgs.exitNodeIP != "" {
+	if upArgs.exitNodeIP == "auto" {
+		prefs.AutoExitNode = true
+	} else if upArgs.exitNodeIP != "" {
 		if err := prefs.SetExitNodeIP(upArgs.exitNodeIP, st); err != nil {
 			var e ipn.ExitNodeLocalIPError
 			if errors.As(err, &e) {
@@ -276,6 +280,7 @@ func prefsFromUpArgs(upArgs upArgsT, warnf logger.Logf, st *ipnstate.Status, goo
 	prefs.CorpDNS = upArgs.acceptDNS
 	prefs.AllowSingleHosts = upArgs.singleRoutes
 	prefs.ShieldsUp = upArgs.shieldsUp
+	prefs.ConnApproval = upArgs.connApproval
 	prefs.RunSSH = upArgs.runSSH
 	prefs.AdvertiseRoutes = routes
 	prefs.AdvertiseTags = tags
@@ -711,7 +716,7 @@ func init() {
 	addPrefFlagMapping("advertise-routes", "AdvertiseRoutes")
 
 	// And this flag has two ipn.Prefs:
-	addPrefFlagMapping("exit-node", "ExitNodeIP", "ExitNodeID")
+	addPrefFlagMapping("exit-node", "ExitNodeIP", "ExitNodeID", "AutoExitNode")
 
 	// The rest are 1:1:
 	addPrefFlagMapping("accept-dns", "CorpDNS")
@@ -722,6 +727,7 @@ func init() {
 	addPrefFlagMapping("login-server", "ControlURL")
 	addPrefFlagMapping("netfilter-mode", "NetfilterMode")
 	addPrefFlagMapping("shields-up", "ShieldsUp")
+	addPrefFlagMapping("connection-approval", "ConnApproval")
 	addPrefFlagMapping("snat-subnet-routes", "NoSNAT")
 	addPrefFlagMapping("exit-node-allow-lan-access", "ExitNodeAllowLANAccess")
 	addPrefFlagMapping("unattended", "ForceDaemon")
@@ -731,6 +737,23 @@ func init() {
 	addPrefFlagMapping("update-check", "AutoUpdate")
 	addPrefFlagMapping("auto-update", "AutoUpdate")
 	addPrefFlagMapping("posture-checking", "PostureChecking")
+	addPrefFlagMapping("advertise-metadata", "AdvertiseMetadata")
+	addPrefFlagMapping("advertise-dns-records", "AdvertiseDNSRecords")
+	addPrefFlagMapping("udp-port-range", "UDPPort")
+	addPrefFlagMapping("udp-port-rotate", "UDPPort")
+	addPrefFlagMapping("udp-port-rotate-on-failure", "UDPPort")
+	addPrefFlagMapping("endpoint-pins", "EndpointPins")
+	addPrefFlagMapping("endpoint-types", "EndpointTypes")
+	addPrefFlagMapping("peer-endpoint-types", "PeerEndpointTypes")
+	addPrefFlagMapping("outer-dscp", "OuterDSCP")
+	addPrefFlagMapping("ipv6-flow-labels", "IPv6FlowLabels")
+	addPrefFlagMapping("route-metrics", "RouteMetrics")
+	addPrefFlagMapping("dns-cache", "DNSCache")
+	addPrefFlagMapping("dns-blocklists", "DNSBlocklists")
+	addPrefFlagMapping("dns-blocklist-null-ip", "DNSBlocklistNullIP")
+	addPrefFlagMapping("maintenance-window", "MaintenanceWindow")
+	addPrefFlagMapping("webclient", "WebClient")
+	addPrefFlagMapping("half-open-limits", "HalfOpenLimits")
 }
 
 func addPrefFlagMapping(flagName string, prefNames ...string) {
@@ -908,6 +931,9 @@ func prefsToFlags(env upCheckEnv, prefs *ipn.Prefs) (flagVal map[string]any) {
 	ret := make(map[string]any)
 
 	exitNodeIPStr := func() string {
+		if prefs.AutoExitNode {
+			return "auto"
+		}
 		if prefs.ExitNodeIP.IsValid() {
 			return prefs.ExitNodeIP.String()
 		}
@@ -944,6 +970,8 @@ func prefsToFlags(env upCheckEnv, prefs *ipn.Prefs) (flagVal map[string]any) {
 			set(prefs.CorpDNS)
 		case "shields-up":
 			set(prefs.ShieldsUp)
+		case "connection-approval":
+			set(prefs.ConnApproval)
 		case "exit-node":
 			set(exitNodeIPStr())
 		case "exit-node-allow-lan-access":
@@ -21,6 +21,6 @@ func main() {
 	}
 	if err := cli.Run(args); err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(cli.ExitCode(err))
 	}
 }
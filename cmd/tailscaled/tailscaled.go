@@ -29,6 +29,7 @@
 	"syscall"
 	"time"
 
+	"tailscale.com/client/tailscale"
 	"tailscale.com/cmd/tailscaled/childproc"
 	"tailscale.com/control/controlclient"
 	"tailscale.com/envknob"
@@ -134,6 +135,7 @@ func defaultPort() uint16 {
 	statepath      string
 	statedir       string
 	socketpath     string
+	instance       string
 	birdSocketPath string
 	verbose        int
 	socksAddr      string // listen address for SOCKS5 server
@@ -175,6 +177,7 @@ func main() {
 	flag.BoolVar(&printVersion, "version", false, "print version information and exit")
 	flag.BoolVar(&args.disableLogs, "no-logs-no-support", false, "disable log uploads; this also disables any technical support")
 	flag.StringVar(&args.confFile, "config", "", "path to config file")
+	flag.StringVar(&args.instance, "instance", "", "name of this tailscaled instance, to run several on one machine; it changes the defaults of --socket and --statedir to be the instance's own, and is targeted with 'tailscale --instance=<name>'. Named instances use --tun=userspace-networking, as only the default instance can use a TUN device")
 
 	if len(os.Args) > 0 && filepath.Base(os.Args[0]) == "tailscale" && beCLI != nil {
 		beCLI()
@@ -204,6 +207,13 @@ func main() {
 		}
 	}
 
+	if args.instance != "" {
+		if err := applyInstanceDefaults(); err != nil {
+			log.SetFlags(0)
+			log.Fatal(err)
+		}
+	}
+
 	if fd, ok := envknob.LookupInt("TS_PARENT_DEATH_FD"); ok && fd > 2 {
 		go dieOnPipeReadErrorOfFD(fd)
 	}
@@ -252,6 +262,55 @@ func main() {
 	}
 }
 
+// applyInstanceDefaults changes the defaults of the flags that must differ
+// between the tailscaled instances on a machine to be those of the
+// instance named by --instance. Flags given explicitly are left alone.
+func applyInstanceDefaults() error {
+	name := args.instance
+	if err := paths.CheckInstanceName(name); err != nil {
+		return err
+	}
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	if !set["socket"] {
+		args.socketpath = paths.InstanceTailscaledSocket(name)
+	}
+	if !set["statedir"] && !set["state"] {
+		args.statedir = paths.InstanceStateDir(name)
+		if args.statedir == "" {
+			return fmt.Errorf("--statedir is required with --instance on %s", runtime.GOOS)
+		}
+	}
+	if !set["tun"] {
+		args.tunname = "userspace-networking"
+	} else if err := checkInstanceTun(args.tunname); err != nil {
+		return err
+	}
+
+	// Keep the instance's log ID and log buffers in its own state
+	// directory, so that instances log as separate nodes.
+	if args.statedir != "" && os.Getenv("TS_LOGS_DIR") == "" {
+		if err := paths.MkStateDir(args.statedir); err != nil {
+			return fmt.Errorf("creating state directory: %w", err)
+		}
+		os.Setenv("TS_LOGS_DIR", args.statedir)
+	}
+	return nil
+}
+
+// checkInstanceTun reports an error if tun, the --tun of a named
+// instance, is a TUN device. Named instances can only use userspace
+// networking: with a TUN device, tailscaled manages the machine's
+// routing table, ip rules, netfilter chains and DNS configuration, which
+// the instances would share and fight over.
+func checkInstanceTun(tun string) error {
+	if tun != "userspace-networking" {
+		return fmt.Errorf("--tun=%s can't be used with --instance; named instances must use --tun=userspace-networking, and only the default instance can use a TUN device", tun)
+	}
+	return nil
+}
+
 func trySynologyMigration(p string) error {
 	if runtime.GOOS != "linux" || distro.Get() != distro.Synology {
 		return nil
@@ -523,6 +582,9 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 		dialer.NetstackDialTCP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
 			return ns.DialContextTCP(ctx, dst)
 		}
+		dialer.NetstackDialUDP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
+			return ns.DialContextUDP(ctx, dst)
+		}
 	}
 	if socksListener != nil || httpProxyListener != nil {
 		var addrs []string
@@ -570,6 +632,10 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 		dnsfallback.SetCachePath(filepath.Join(root, "derpmap.cached.json"), logf)
 	}
 	configureTaildrop(logf, lb)
+	lb.ConfigureWebClient(&tailscale.LocalClient{
+		Socket:        args.socketpath,
+		UseSocketOnly: args.socketpath != "",
+	})
 	if err := ns.Start(lb); err != nil {
 		log.Fatalf("failed to start netstack: %v", err)
 	}
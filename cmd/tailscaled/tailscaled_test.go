@@ -15,6 +15,24 @@ func TestNothing(t *testing.T) {
 	// without any errors about no matching tests.
 }
 
+func TestCheckInstanceTun(t *testing.T) {
+	tests := []struct {
+		tun     string
+		wantErr bool
+	}{
+		{"userspace-networking", false},
+		{"tailscale0", true},
+		{"ts-ci", true},
+		{"tailscale0,userspace-networking", true},
+		{"utun", true},
+	}
+	for _, tt := range tests {
+		if err := checkInstanceTun(tt.tun); (err != nil) != tt.wantErr {
+			t.Errorf("checkInstanceTun(%q) = %v; want error: %v", tt.tun, err, tt.wantErr)
+		}
+	}
+}
+
 func TestDeps(t *testing.T) {
 	deptest.DepChecker{
 		GOOS:   "darwin",
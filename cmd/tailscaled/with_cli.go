@@ -17,7 +17,7 @@ func init() {
 		args := os.Args[1:]
 		if err := cli.Run(args); err != nil {
 			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+			os.Exit(cli.ExitCode(err))
 		}
 	}
 }
@@ -435,6 +435,10 @@ type mapRoutineState struct {
 var _ NetmapDeltaUpdater = mapRoutineState{}
 
 func (mrs mapRoutineState) UpdateFullNetmap(nm *netmap.NetworkMap) {
+	mrs.updateFullNetmapTraced(nm, nil)
+}
+
+func (mrs mapRoutineState) updateFullNetmapTraced(nm *netmap.NetworkMap, tr *MapTrace) {
 	c := mrs.c
 
 	c.mu.Lock()
@@ -449,7 +453,7 @@ func (mrs mapRoutineState) UpdateFullNetmap(nm *netmap.NetworkMap) {
 	c.mu.Unlock()
 
 	if stillAuthed {
-		c.sendStatus("mapRoutine-got-netmap", nil, "", nm)
+		c.sendStatusTraced("mapRoutine-got-netmap", nil, "", nm, tr)
 	}
 	// Reset the backoff timer if we got a netmap.
 	mrs.bo.BackOff(ctx, nil)
@@ -588,6 +592,12 @@ func (c *Auto) SetTKAHead(headHash string) {
 
 // sendStatus can not be called with the c.mu held.
 func (c *Auto) sendStatus(who string, err error, url string, nm *netmap.NetworkMap) {
+	c.sendStatusTraced(who, err, url, nm, nil)
+}
+
+// sendStatusTraced is like sendStatus, but also sends tr, the trace of the
+// MapResponse that produced nm, if any.
+func (c *Auto) sendStatusTraced(who string, err error, url string, nm *netmap.NetworkMap, tr *MapTrace) {
 	c.mu.Lock()
 	if c.closed {
 		c.mu.Unlock()
@@ -615,10 +625,14 @@ func (c *Auto) sendStatus(who string, err error, url string, nm *netmap.NetworkM
 		Err:     err,
 		state:   state,
 	}
+	if nm != nil {
+		new.Trace = tr
+	}
 
 	// Launch a new goroutine to avoid blocking the caller while the observer
 	// does its thing, which may result in a call back into the client.
 	c.observerQueue.Add(func() {
+		new.Trace.Mark(MapTraceDelivered)
 		c.observer.SetControlClientStatus(c, new)
 	})
 }
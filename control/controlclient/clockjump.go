@@ -0,0 +1,66 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controlclient
+
+import (
+	"time"
+
+	"tailscale.com/util/clientmetric"
+)
+
+const (
+	// clockJumpThreshold is how far the wall clock must move relative to
+	// the monotonic clock between two observations to count as a jump.
+	clockJumpThreshold = 10 * time.Second
+
+	// clockCheckInterval is how often a map session checks for clock
+	// jumps.
+	clockCheckInterval = 10 * time.Second
+)
+
+var (
+	metricClockJumpsForward  = clientmetric.NewCounter("controlclient_clock_jumps_forward")
+	metricClockJumpsBackward = clientmetric.NewCounter("controlclient_clock_jumps_backward")
+)
+
+// clockJumpDetector detects jumps of the wall clock, such as from a
+// suspend and resume of the machine or an NTP step, by comparing it against
+// the monotonic clock.
+//
+// It is not safe for concurrent use.
+type clockJumpDetector struct {
+	last time.Time // last observation, with its monotonic clock reading
+}
+
+// observe records now, which should come from time.Now and so carry a
+// monotonic clock reading, and returns how far the wall clock jumped
+// relative to the monotonic clock since the previous observation, or zero if
+// it didn't jump.
+//
+// Times without a monotonic clock reading, such as those of a fake clock,
+// never jump.
+func (d *clockJumpDetector) observe(now time.Time) time.Duration {
+	last := d.last
+	d.last = now
+	if last.IsZero() {
+		return 0
+	}
+	return checkClockJump(now.Round(0).Sub(last.Round(0)), now.Sub(last))
+}
+
+// checkClockJump returns the jump of the wall clock given the elapsed wall
+// and monotonic time over the same interval, or zero if the difference is
+// below clockJumpThreshold. Jumps are counted in clientmetrics.
+func checkClockJump(wall, mono time.Duration) time.Duration {
+	jump := wall - mono
+	if jump.Abs() < clockJumpThreshold {
+		return 0
+	}
+	if jump > 0 {
+		metricClockJumpsForward.Add(1)
+	} else {
+		metricClockJumpsBackward.Add(1)
+	}
+	return jump
+}
@@ -0,0 +1,47 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controlclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckClockJump(t *testing.T) {
+	tests := []struct {
+		name       string
+		wall, mono time.Duration
+		want       time.Duration
+	}{
+		{"steady", 10 * time.Second, 10 * time.Second, 0},
+		{"drift", 10*time.Second + 500*time.Millisecond, 10 * time.Second, 0},
+		{"suspend", 2 * time.Hour, 10 * time.Second, 2*time.Hour - 10*time.Second},
+		{"ntp-step-back", -time.Minute, 10 * time.Second, -time.Minute - 10*time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkClockJump(tt.wall, tt.mono); got != tt.want {
+				t.Errorf("checkClockJump(%v, %v) = %v, want %v", tt.wall, tt.mono, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClockJumpDetector(t *testing.T) {
+	var d clockJumpDetector
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if jump := d.observe(now.Add(time.Duration(i) * time.Minute)); jump != 0 {
+			t.Errorf("observation %d: jump = %v, want 0", i, jump)
+		}
+	}
+
+	// Times from fake clocks have no monotonic reading, and so never jump.
+	d = clockJumpDetector{}
+	start := time.Unix(1700000000, 0)
+	d.observe(start)
+	if jump := d.observe(start.Add(time.Hour)); jump != 0 {
+		t.Errorf("jump = %v, want 0", jump)
+	}
+}
@@ -54,6 +54,7 @@
 	"tailscale.com/types/tkatype"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/multierr"
+	"tailscale.com/util/set"
 	"tailscale.com/util/singleflight"
 	"tailscale.com/util/syspolicy"
 	"tailscale.com/util/systemd"
@@ -75,10 +76,12 @@ type Direct struct {
 	debugFlags            []string
 	skipIPForwardingCheck bool
 	pinger                Pinger
-	popBrowser            func(url string)             // or nil
-	c2nHandler            http.Handler                 // or nil
-	onClientVersion       func(*tailcfg.ClientVersion) // or nil
-	onControlTime         func(time.Time)              // or nil
+	popBrowser            func(url string)               // or nil
+	c2nHandler            http.Handler                   // or nil
+	onClientVersion       func(*tailcfg.ClientVersion)   // or nil
+	onControlTime         func(time.Time)                // or nil
+	onClockJump           func(time.Duration)            // or nil
+	importantPeers        func() set.Set[tailcfg.NodeID] // or nil
 
 	dialPlan ControlDialPlanner // can be nil
 
@@ -119,16 +122,18 @@ type Options struct {
 	Hostinfo             *tailcfg.Hostinfo // non-nil passes ownership, nil means to use default using os.Hostname, etc
 	DiscoPublicKey       key.DiscoPublic
 	Logf                 logger.Logf
-	HTTPTestClient       *http.Client                 // optional HTTP client to use (for tests only)
-	NoiseTestClient      *http.Client                 // optional HTTP client to use for noise RPCs (tests only)
-	DebugFlags           []string                     // debug settings to send to control
-	NetMon               *netmon.Monitor              // optional network monitor
-	PopBrowserURL        func(url string)             // optional func to open browser
-	OnClientVersion      func(*tailcfg.ClientVersion) // optional func to inform GUI of client version status
-	OnControlTime        func(time.Time)              // optional func to notify callers of new time from control
-	Dialer               *tsdial.Dialer               // non-nil
-	C2NHandler           http.Handler                 // or nil
-	ControlKnobs         *controlknobs.Knobs          // or nil to ignore
+	HTTPTestClient       *http.Client                   // optional HTTP client to use (for tests only)
+	NoiseTestClient      *http.Client                   // optional HTTP client to use for noise RPCs (tests only)
+	DebugFlags           []string                       // debug settings to send to control
+	NetMon               *netmon.Monitor                // optional network monitor
+	PopBrowserURL        func(url string)               // optional func to open browser
+	OnClientVersion      func(*tailcfg.ClientVersion)   // optional func to inform GUI of client version status
+	OnControlTime        func(time.Time)                // optional func to notify callers of new time from control
+	OnClockJump          func(time.Duration)            // optional func to notify callers of a jump of the wall clock during a map session
+	ImportantPeers       func() set.Set[tailcfg.NodeID] // optional func returning peers whose delta updates to deliver first
+	Dialer               *tsdial.Dialer                 // non-nil
+	C2NHandler           http.Handler                   // or nil
+	ControlKnobs         *controlknobs.Knobs            // or nil to ignore
 
 	// Observer is called when there's a change in status to report
 	// from the control client.
@@ -280,6 +285,8 @@ func NewDirect(opts Options) (*Direct, error) {
 		popBrowser:            opts.PopBrowserURL,
 		onClientVersion:       opts.OnClientVersion,
 		onControlTime:         opts.OnControlTime,
+		onClockJump:           opts.OnClockJump,
+		importantPeers:        opts.ImportantPeers,
 		c2nHandler:            opts.C2NHandler,
 		dialer:                opts.Dialer,
 		dnsCache:              dnsCache,
@@ -969,9 +976,15 @@ func (c *Direct) sendMapRequest(ctx context.Context, isStreaming bool, nu Netmap
 		return nil
 	}
 
-	var mapResIdx int // 0 for first message, then 1+ for deltas
+	// Messages are read here, then decoded, applied to the session, and
+	// delivered to nu by a pipeline of separate goroutines.
+	pipe := newMapPipeline(ctx, cancel, c.logf)
 
-	sess := newMapSession(persist.PrivateNodeKey(), nu, c.controlKnobs)
+	// mapResIdx is the index of the message being applied: 0 for the first
+	// message, then 1+ for deltas. It's only used by the apply stage.
+	var mapResIdx int
+
+	sess := newMapSession(persist.PrivateNodeKey(), pipe.updater(nu), c.controlKnobs)
 	defer sess.Close()
 	sess.cancel = cancel
 	sess.logf = c.logf
@@ -984,7 +997,7 @@ func (c *Direct) sendMapRequest(ctx context.Context, isStreaming bool, nu Netmap
 		// This is handy for debugging, and our logs processing
 		// pipeline depends on it. (TODO: Remove this dependency.)
 		now := c.clock.Now()
-		if now.Sub(c.lastPrintMap) < 5*time.Minute {
+		if now.Sub(c.lastPrintMap) < 5*time.Minute && !mapLogAtLeast(MapLogSummary) {
 			return
 		}
 		c.lastPrintMap = now
@@ -1006,42 +1019,25 @@ func (c *Direct) sendMapRequest(ctx context.Context, isStreaming bool, nu Netmap
 		}
 		c.expiry = nm.Expiry
 	}
+	if c.onClockJump != nil {
+		sess.onClockJump = c.onClockJump
+	}
+	sess.importantPeers = c.importantPeers
 	sess.StartWatchdog()
 
 	// gotNonKeepAliveMessage is whether we've yet received a MapResponse message without
-	// KeepAlive set.
+	// KeepAlive set. It's only used by the decode stage.
 	var gotNonKeepAliveMessage bool
 
-	// If allowStream, then the server will use an HTTP long poll to
-	// return incremental results. There is always one response right
-	// away, followed by a delay, and eventually others.
-	// If !allowStream, it'll still send the first result in exactly
-	// the same format before just closing the connection.
-	// We can use this same read loop either way.
-	var msg []byte
-	for ; mapResIdx == 0 || isStreaming; mapResIdx++ {
-		vlogf("netmap: starting size read after %v (poll %v)", time.Since(t0).Round(time.Millisecond), mapResIdx)
-		var siz [4]byte
-		if _, err := io.ReadFull(res.Body, siz[:]); err != nil {
-			vlogf("netmap: size read error after %v: %v", time.Since(t0).Round(time.Millisecond), err)
-			return err
-		}
-		size := binary.LittleEndian.Uint32(siz[:])
-		vlogf("netmap: read size %v after %v", size, time.Since(t0).Round(time.Millisecond))
-		msg = append(msg[:0], make([]byte, size)...)
-		if _, err := io.ReadFull(res.Body, msg); err != nil {
-			vlogf("netmap: body read error: %v", err)
-			return err
-		}
-		vlogf("netmap: read body after %v", time.Since(t0).Round(time.Millisecond))
-
-		var resp tailcfg.MapResponse
-		if err := c.decodeMsg(msg, &resp, machinePrivKey); err != nil {
-			vlogf("netmap: decode error: %v")
-			return err
+	decode := func(msg []byte) (*tailcfg.MapResponse, error) {
+		resp := new(tailcfg.MapResponse)
+		if err := c.decodeMsg(msg, resp, machinePrivKey); err != nil {
+			vlogf("netmap: decode error: %v", err)
+			return nil, err
 		}
 
 		metricMapResponseMessages.Add(1)
+		recentMapStats.recordMessage(c.clock.Now(), len(msg), resp.KeepAlive)
 
 		if isStreaming {
 			health.GotStreamedMapResponse()
@@ -1088,11 +1084,11 @@ func (c *Direct) sendMapRequest(ctx context.Context, isStreaming bool, nu Netmap
 			vlogf("netmap: sent timer reset")
 		case <-ctx.Done():
 			c.logf("[v1] netmap: not resetting timer; context done: %v", ctx.Err())
-			return ctx.Err()
+			return nil, ctx.Err()
 		}
 		if resp.KeepAlive {
 			metricMapResponseKeepAlives.Add(1)
-			continue
+			return nil, nil
 		}
 
 		metricMapResponseMap.Add(1)
@@ -1102,13 +1098,50 @@ func (c *Direct) sendMapRequest(ctx context.Context, isStreaming bool, nu Netmap
 		} else if resp.Node == nil {
 			// The very first non-keep-alive message should have Node populated.
 			c.logf("initial MapResponse lacked Node")
-			return errors.New("initial MapResponse lacked node")
+			return nil, errors.New("initial MapResponse lacked node")
 		}
 		gotNonKeepAliveMessage = true
+		return resp, nil
+	}
+	apply := func(idx int, resp *tailcfg.MapResponse) error {
+		mapResIdx = idx
+		return sess.HandleNonKeepAliveMapResponse(ctx, resp)
+	}
+	pipe.start(decode, apply)
 
-		if err := sess.HandleNonKeepAliveMapResponse(ctx, &resp); err != nil {
-			return err
+	// If allowStream, then the server will use an HTTP long poll to
+	// return incremental results. There is always one response right
+	// away, followed by a delay, and eventually others.
+	// If !allowStream, it'll still send the first result in exactly
+	// the same format before just closing the connection.
+	// We can use this same read loop either way.
+	readErr := func() error {
+		for i := 0; i == 0 || isStreaming; i++ {
+			vlogf("netmap: starting size read after %v (poll %v)", time.Since(t0).Round(time.Millisecond), i)
+			var siz [4]byte
+			if _, err := io.ReadFull(res.Body, siz[:]); err != nil {
+				vlogf("netmap: size read error after %v: %v", time.Since(t0).Round(time.Millisecond), err)
+				return err
+			}
+			size := binary.LittleEndian.Uint32(siz[:])
+			vlogf("netmap: read size %v after %v", size, time.Since(t0).Round(time.Millisecond))
+			msg := make([]byte, size)
+			if _, err := io.ReadFull(res.Body, msg); err != nil {
+				vlogf("netmap: body read error: %v", err)
+				return err
+			}
+			vlogf("netmap: read body after %v", time.Since(t0).Round(time.Millisecond))
+			if !pipe.push(msg) {
+				return nil // the pipeline's error is returned below
+			}
 		}
+		return nil
+	}()
+	if err := pipe.finish(); err != nil {
+		return err
+	}
+	if readErr != nil {
+		return readErr
 	}
 	if ctx.Err() != nil {
 		return ctx.Err()
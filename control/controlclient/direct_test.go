@@ -4,11 +4,13 @@
 package controlclient
 
 import (
+	"context"
 	"crypto/ed25519"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
+	"slices"
 	"testing"
 	"time"
 
@@ -16,7 +18,9 @@
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstest/controlserver"
 	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
 )
 
 func TestNewDirect(t *testing.T) {
@@ -86,6 +90,124 @@ func TestNewDirect(t *testing.T) {
 	}
 }
 
+type netmapChan chan *netmap.NetworkMap
+
+func (ch netmapChan) UpdateFullNetmap(nm *netmap.NetworkMap) { ch <- nm }
+
+// TestMapRequestEndpoints tests that the endpoints of nodes on IPv6-only and
+// dual-stack networks are reported to control in MapRequests and reach
+// their peers' netmaps.
+func TestMapRequestEndpoints(t *testing.T) {
+	ep := func(s string, typ tailcfg.EndpointType) tailcfg.Endpoint {
+		return tailcfg.Endpoint{Addr: netip.MustParseAddrPort(s), Type: typ}
+	}
+	tests := []struct {
+		name string
+		eps  []tailcfg.Endpoint
+		want []netip.AddrPort // as stored by control and seen by peers
+	}{
+		{
+			name: "v6_only",
+			eps: []tailcfg.Endpoint{
+				ep("[2001:db8::1]:41641", tailcfg.EndpointLocal),
+				ep("[2001:db8:1::5]:41641", tailcfg.EndpointSTUN),
+			},
+			want: []netip.AddrPort{
+				netip.MustParseAddrPort("[2001:db8::1]:41641"),
+				netip.MustParseAddrPort("[2001:db8:1::5]:41641"),
+			},
+		},
+		{
+			// Link-local addresses are reported, but dropped by
+			// control, so a v6-only node with only those has no
+			// endpoints and is reached via DERP.
+			name: "v6_only_link_local",
+			eps: []tailcfg.Endpoint{
+				ep("[fe80::1]:41641", tailcfg.EndpointLocal),
+			},
+			want: nil,
+		},
+		{
+			name: "dual_stack",
+			eps: []tailcfg.Endpoint{
+				ep("192.168.1.5:41641", tailcfg.EndpointLocal),
+				ep("[2001:db8::1]:41641", tailcfg.EndpointLocal),
+				ep("203.0.113.1:41641", tailcfg.EndpointSTUN),
+			},
+			want: []netip.AddrPort{
+				netip.MustParseAddrPort("192.168.1.5:41641"),
+				netip.MustParseAddrPort("[2001:db8::1]:41641"),
+				netip.MustParseAddrPort("203.0.113.1:41641"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			s := controlserver.New(t)
+
+			newClient := func() *Direct {
+				t.Helper()
+				mk := key.NewMachine()
+				hi := hostinfo.New()
+				hi.BackendLogID = "test"
+				c, err := NewDirect(Options{
+					ServerURL: s.URL(),
+					GetMachinePrivateKey: func() (key.MachinePrivate, error) {
+						return mk, nil
+					},
+					Hostinfo:       hi,
+					Dialer:         new(tsdial.Dialer),
+					HTTPTestClient: s.HTTPClient(),
+					Logf:           t.Logf,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { c.Close() })
+				if _, err := c.TryLogin(ctx, nil, LoginDefault); err != nil {
+					t.Fatalf("TryLogin: %v", err)
+				}
+				return c
+			}
+
+			c := newClient()
+			if !c.SetEndpoints(tt.eps) {
+				t.Fatal("SetEndpoints reported no change")
+			}
+			if err := c.SendUpdate(ctx); err != nil {
+				t.Fatalf("SendUpdate: %v", err)
+			}
+			nk := c.GetPersist().PublicNodeKey()
+			n := s.Node(nk)
+			if n == nil {
+				t.Fatal("node not found on control")
+			}
+			if !slices.Equal(n.Endpoints, tt.want) {
+				t.Errorf("control has endpoints %v; want %v", n.Endpoints, tt.want)
+			}
+
+			peer := newClient()
+			netmaps := make(netmapChan, 10)
+			go peer.PollNetMap(ctx, netmaps)
+			var nm *netmap.NetworkMap
+			select {
+			case nm = <-netmaps:
+			case <-ctx.Done():
+				t.Fatal("timeout waiting for netmap")
+			}
+			pv, ok := nm.PeerByTailscaleIP(n.Addresses[0].Addr())
+			if !ok {
+				t.Fatalf("peer netmap lacks %v", n.Addresses[0])
+			}
+			if got := pv.Endpoints().AsSlice(); !slices.Equal(got, tt.want) {
+				t.Errorf("peer sees endpoints %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func fakeEndpoints(ports ...uint16) (ret []tailcfg.Endpoint) {
 	for _, port := range ports {
 		ret = append(ret, tailcfg.Endpoint{
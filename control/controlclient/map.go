@@ -23,10 +23,12 @@
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/netmap"
+	"tailscale.com/types/netmap/nmdiff"
 	"tailscale.com/types/ptr"
 	"tailscale.com/types/views"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/cmpx"
+	"tailscale.com/util/set"
 	"tailscale.com/wgengine/filter"
 )
 
@@ -72,10 +74,20 @@ type mapSession struct {
 	// changed.
 	onSelfNodeChanged func(*netmap.NetworkMap)
 
+	// onClockJump is called from the watchdog goroutine when the wall clock
+	// jumps by the given amount relative to the monotonic clock, so that
+	// wall clock deadlines can be rebased.
+	onClockJump func(jump time.Duration)
+
+	// importantPeers, if non-nil, returns the peers whose mutations should
+	// be delivered first when a MapResponse carries a large batch of them.
+	importantPeers func() set.Set[tailcfg.NodeID]
+
 	// Fields storing state over the course of multiple MapResponses.
 	lastNode               tailcfg.NodeView
 	peers                  map[tailcfg.NodeID]*tailcfg.NodeView // pointer to view (oddly). same pointers as sortedPeers.
 	sortedPeers            []*tailcfg.NodeView                  // same pointers as peers, but sorted by Node.ID
+	peerIndex              netmap.PeerIndexBuilder              // indexes peers; kept in sync with peers
 	lastDNSConfig          *tailcfg.DNSConfig
 	lastDERPMap            *tailcfg.DERPMap
 	lastUserProfile        map[tailcfg.UserID]tailcfg.UserProfile
@@ -89,7 +101,8 @@ type mapSession struct {
 	lastPopBrowserURL      string
 	stickyDebug            tailcfg.Debug // accumulated opt.Bool values
 	lastTKAInfo            *tailcfg.TKAInfo
-	lastNetmapSummary      string // from NetworkMap.VeryConcise
+	lastNetmapSummary      string             // from NetworkMap.VeryConcise
+	lastDebugNetmap        *netmap.NetworkMap // last full netmap, if DevKnob.DumpNetMaps
 }
 
 // newMapSession returns a mostly unconfigured new mapSession.
@@ -114,6 +127,7 @@ func newMapSession(privateNodeKey key.NodePrivate, nu NetmapUpdater, controlKnob
 		onDebug:                func(context.Context, *tailcfg.Debug, chan<- struct{}) error { return nil },
 		onConciseNetMapSummary: func(string) {},
 		onSelfNodeChanged:      func(*netmap.NetworkMap) {},
+		onClockJump:            func(time.Duration) {},
 	}
 	ms.sessionAliveCtx, ms.sessionAliveCtxClose = context.WithCancel(context.Background())
 	return ms
@@ -126,16 +140,52 @@ func (ms *mapSession) clock() tstime.Clock {
 // StartWatchdog starts the session's watchdog timer.
 // If there's no activity in too long, it tears down the connection.
 // Call Close to release these resources.
+//
+// The watchdog also checks for jumps of the wall clock, restarting its
+// timer and calling onClockJump when it sees one.
 func (ms *mapSession) StartWatchdog() {
 	timer, timedOutChan := ms.clock().NewTimer(watchdogTimeout)
+	ticker, tickerChan := ms.clock().NewTicker(clockCheckInterval)
 	go func() {
 		defer timer.Stop()
+		defer ticker.Stop()
+		var jumps clockJumpDetector
+		jumps.observe(ms.clock().Now())
+
+		// rebase restarts the stopped or fired timer after a jump.
+		// Whether timers ran across a suspend depends on the platform,
+		// so rather than tearing down the long poll based on a deadline
+		// from before the jump, give it a full timeout from now to show
+		// signs of life.
+		rebase := func(jump time.Duration) {
+			ms.logf("netmap: wall clock jumped by %v; rebasing session timers", jump.Round(time.Second))
+			timer.Reset(watchdogTimeout)
+			ms.onClockJump(jump)
+		}
 		for {
 			select {
+			case now := <-tickerChan:
+				jump := jumps.observe(now)
+				if jump == 0 {
+					continue
+				}
+				if !timer.Stop() {
+					select {
+					case <-timedOutChan:
+					default:
+					}
+				}
+				rebase(jump)
 			case <-ms.sessionAliveCtx.Done():
 				ms.vlogf("netmap: ending timeout goroutine")
 				return
 			case <-timedOutChan:
+				// On resume, the timer and ticker can fire together;
+				// don't time out before checking for a jump.
+				if jump := jumps.observe(ms.clock().Now()); jump != 0 {
+					rebase(jump)
+					continue
+				}
 				ms.logf("map response long-poll timed out!")
 				ms.cancel()
 				return
@@ -168,6 +218,10 @@ func (ms *mapSession) Close() {
 // TODO(bradfitz): make this handle all fields later. For now (2023-08-20) this
 // is [re]factoring progress enough.
 func (ms *mapSession) HandleNonKeepAliveMapResponse(ctx context.Context, resp *tailcfg.MapResponse) error {
+	if mapLogAtLeast(MapLogResponse) {
+		ms.logf("netmap: response: %s", describeMapResponse(resp))
+	}
+
 	if debug := resp.Debug; debug != nil {
 		if err := ms.onDebug(ctx, debug, ms.watchdogReset); err != nil {
 			return err
@@ -177,9 +231,11 @@ func (ms *mapSession) HandleNonKeepAliveMapResponse(ctx context.Context, resp *t
 	if DevKnob.StripEndpoints() {
 		for _, p := range resp.Peers {
 			p.Endpoints = nil
+			p.EndpointTypes = nil
 		}
 		for _, p := range resp.PeersChanged {
 			p.Endpoints = nil
+			p.EndpointTypes = nil
 		}
 	}
 
@@ -211,6 +267,12 @@ func (ms *mapSession) HandleNonKeepAliveMapResponse(ctx context.Context, resp *t
 	nm := ms.netmap()
 	ms.lastNetmapSummary = nm.VeryConcise()
 	ms.onConciseNetMapSummary(ms.lastNetmapSummary)
+	if DevKnob.DumpNetMaps() {
+		if ms.lastDebugNetmap != nil {
+			ms.vlogf("netmap: diff from previous:\n%v", nmdiff.Compare(ms.lastDebugNetmap, nm))
+		}
+		ms.lastDebugNetmap = nm
+	}
 
 	// If the self node changed, we might need to update persist.
 	if resp.Node != nil {
@@ -230,10 +292,72 @@ func (ms *mapSession) tryHandleIncrementally(res *tailcfg.MapResponse) bool {
 		return false
 	}
 	mutations, ok := netmap.MutationsFromMapResponse(res, time.Now())
-	if ok && len(mutations) > 0 {
-		return nud.UpdateNetmapDelta(mutations)
+	if !ok {
+		return false
+	}
+	for _, batch := range ms.deltaBatches(mutations) {
+		if !nud.UpdateNetmapDelta(batch) {
+			return false
+		}
+	}
+	return true
+}
+
+// maxDeltaBatch is the most mutations delivered to a NetmapDeltaUpdater
+// in one call, so a storm of churn can't hold it up for long. Batches that
+// are larger get split, with the mutations of important peers delivered
+// first.
+const maxDeltaBatch = 500
+
+var (
+	metricDeltaBatchesSplit         = clientmetric.NewCounter("controlclient_delta_batches_split")
+	metricDeltaMutationsPrioritized = clientmetric.NewCounter("controlclient_delta_mutations_prioritized")
+)
+
+// deltaBatches returns muts split into the batches to deliver, in order.
+//
+// Small batches are returned as is. Otherwise, the mutations of the peers
+// that ms.importantPeers reports (such as the exit node in use and the peers
+// being actively talked to) make up the first batch, so that a churn storm
+// doesn't delay them behind thousands of irrelevant ones, and the remaining
+// mutations follow in batches of at most maxDeltaBatch. The relative order of
+// mutations within each of the two groups is preserved.
+//
+// If a later batch fails to apply, the caller falls back to a full netmap,
+// which also covers the mutations already delivered.
+func (ms *mapSession) deltaBatches(muts []netmap.NodeMutation) [][]netmap.NodeMutation {
+	if len(muts) == 0 {
+		return nil
 	}
-	return ok
+	if len(muts) <= maxDeltaBatch {
+		return [][]netmap.NodeMutation{muts}
+	}
+	metricDeltaBatchesSplit.Add(1)
+	var ret [][]netmap.NodeMutation
+	rest := muts
+	if ms.importantPeers != nil {
+		if important := ms.importantPeers(); len(important) > 0 {
+			var first []netmap.NodeMutation
+			rest = make([]netmap.NodeMutation, 0, len(muts))
+			for _, m := range muts {
+				if important.Contains(m.NodeIDBeingMutated()) {
+					first = append(first, m)
+				} else {
+					rest = append(rest, m)
+				}
+			}
+			if len(first) > 0 {
+				metricDeltaMutationsPrioritized.Add(int64(len(first)))
+				ret = append(ret, first)
+			}
+		}
+	}
+	for len(rest) > 0 {
+		n := min(len(rest), maxDeltaBatch)
+		ret = append(ret, rest[:n])
+		rest = rest[n:]
+	}
+	return ret
 }
 
 // updateStats are some stats from updateStateFromResponse, primarily for
@@ -248,7 +372,8 @@ type updateStats struct {
 
 // updateStateFromResponse updates ms from res. It takes ownership of res.
 func (ms *mapSession) updateStateFromResponse(resp *tailcfg.MapResponse) {
-	ms.updatePeersStateFromResponse(resp)
+	stats := ms.updatePeersStateFromResponse(resp)
+	recentMapStats.recordUpdate(ms.clock().Now(), stats)
 
 	if resp.Node != nil {
 		ms.lastNode = resp.Node.View()
@@ -332,6 +457,13 @@ func (ms *mapSession) updateStateFromResponse(resp *tailcfg.MapResponse) {
 	patchifiedPeerEqual = clientmetric.NewCounter("controlclient_patchified_peer_equal")
 )
 
+// logPeerChange logs a change to a peer if the map log level is MapLogPeer.
+func (ms *mapSession) logPeerChange(format string, args ...any) {
+	if mapLogAtLeast(MapLogPeer) {
+		ms.logf("netmap: "+format, args...)
+	}
+}
+
 // updatePeersStateFromResponseres updates ms.peers and ms.sortedPeers from res. It takes ownership of res.
 func (ms *mapSession) updatePeersStateFromResponse(resp *tailcfg.MapResponse) (stats updateStats) {
 	defer func() {
@@ -351,16 +483,22 @@ func (ms *mapSession) updatePeersStateFromResponse(resp *tailcfg.MapResponse) (s
 		for _, n := range resp.Peers {
 			keep[n.ID] = true
 			if vp, ok := ms.peers[n.ID]; ok {
+				ms.logPeerChange("peer %v replaced", n.ID)
 				stats.changed++
+				ms.peerIndex.Upsert(*vp, n.View())
 				*vp = n.View()
 			} else {
+				ms.logPeerChange("peer %v added", n.ID)
 				stats.added++
+				ms.peerIndex.Upsert(tailcfg.NodeView{}, n.View())
 				ms.peers[n.ID] = ptr.To(n.View())
 			}
 		}
-		for id := range ms.peers {
+		for id, vp := range ms.peers {
 			if !keep[id] {
+				ms.logPeerChange("peer %v removed", id)
 				stats.removed++
+				ms.peerIndex.Remove(*vp)
 				delete(ms.peers, id)
 			}
 		}
@@ -369,7 +507,9 @@ func (ms *mapSession) updatePeersStateFromResponse(resp *tailcfg.MapResponse) (s
 	}
 
 	for _, id := range resp.PeersRemoved {
-		if _, ok := ms.peers[id]; ok {
+		if vp, ok := ms.peers[id]; ok {
+			ms.logPeerChange("peer %v removed", id)
+			ms.peerIndex.Remove(*vp)
 			delete(ms.peers, id)
 			stats.removed++
 		}
@@ -377,16 +517,21 @@ func (ms *mapSession) updatePeersStateFromResponse(resp *tailcfg.MapResponse) (s
 
 	for _, n := range resp.PeersChanged {
 		if vp, ok := ms.peers[n.ID]; ok {
+			ms.logPeerChange("peer %v replaced", n.ID)
 			stats.changed++
+			ms.peerIndex.Upsert(*vp, n.View())
 			*vp = n.View()
 		} else {
+			ms.logPeerChange("peer %v added", n.ID)
 			stats.added++
+			ms.peerIndex.Upsert(tailcfg.NodeView{}, n.View())
 			ms.peers[n.ID] = ptr.To(n.View())
 		}
 	}
 
 	for nodeID, seen := range resp.PeerSeenChange {
 		if vp, ok := ms.peers[nodeID]; ok {
+			ms.logPeerChange("peer %v seen=%v", nodeID, seen)
 			mut := vp.AsStruct()
 			if seen {
 				mut.LastSeen = ptr.To(clock.Now())
@@ -400,6 +545,7 @@ func (ms *mapSession) updatePeersStateFromResponse(resp *tailcfg.MapResponse) (s
 
 	for nodeID, online := range resp.OnlineChange {
 		if vp, ok := ms.peers[nodeID]; ok {
+			ms.logPeerChange("peer %v online=%v", nodeID, online)
 			mut := vp.AsStruct()
 			mut.Online = ptr.To(online)
 			*vp = mut.View()
@@ -412,6 +558,7 @@ func (ms *mapSession) updatePeersStateFromResponse(resp *tailcfg.MapResponse) (s
 		if !ok {
 			continue
 		}
+		ms.logPeerChange("peer %v patched: %v", pc.NodeID, logger.AsJSON(pc))
 		stats.changed++
 		mut := vp.AsStruct()
 		if pc.DERPRegion != 0 {
@@ -424,6 +571,7 @@ func (ms *mapSession) updatePeersStateFromResponse(resp *tailcfg.MapResponse) (s
 		}
 		if pc.Endpoints != nil {
 			mut.Endpoints = pc.Endpoints
+			mut.EndpointTypes = pc.EndpointTypes
 			patchEndpoints.Add(1)
 		}
 		if pc.Key != nil {
@@ -458,6 +606,10 @@ func (ms *mapSession) updatePeersStateFromResponse(resp *tailcfg.MapResponse) (s
 			mut.CapMap = v
 			patchCapMap.Add(1)
 		}
+		if pc.Capabilities != nil || pc.CapMap != nil {
+			// The only indexed fields a patch can change.
+			ms.peerIndex.Upsert(*vp, mut.View())
+		}
 		*vp = mut.View()
 	}
 
@@ -601,6 +753,10 @@ func peerChangeDiff(was tailcfg.NodeView, n *tailcfg.Node) (_ *tailcfg.PeerChang
 			}
 		case "KeySignature":
 			if !was.KeySignature().Equal(n.KeySignature) {
+				if len(n.KeySignature) == 0 {
+					// A nil PeerChange.KeySignature means no change.
+					return nil, false
+				}
 				pc().KeySignature = slices.Clone(n.KeySignature)
 			}
 		case "Machine":
@@ -620,9 +776,17 @@ func peerChangeDiff(was tailcfg.NodeView, n *tailcfg.Node) (_ *tailcfg.PeerChang
 				return nil, false
 			}
 		case "Endpoints":
-			if !views.SliceEqual(was.Endpoints(), views.SliceOf(n.Endpoints)) {
+			if !views.SliceEqual(was.Endpoints(), views.SliceOf(n.Endpoints)) ||
+				!views.SliceEqual(was.EndpointTypes(), views.SliceOf(n.EndpointTypes)) {
+				if len(n.Endpoints) == 0 {
+					// A nil PeerChange.Endpoints means no change.
+					return nil, false
+				}
 				pc().Endpoints = slices.Clone(n.Endpoints)
+				pc().EndpointTypes = slices.Clone(n.EndpointTypes)
 			}
+		case "EndpointTypes":
+			// Handled above in the "Endpoints" case.
 		case "DERP":
 			if was.DERP() != n.DERP {
 				ip, portStr, err := net.SplitHostPort(n.DERP)
@@ -651,6 +815,10 @@ func peerChangeDiff(was tailcfg.NodeView, n *tailcfg.Node) (_ *tailcfg.PeerChang
 			}
 		case "Cap":
 			if was.Cap() != n.Cap {
+				if n.Cap == 0 {
+					// A zero PeerChange.Cap means no change.
+					return nil, false
+				}
 				pc().Cap = n.Cap
 			}
 		case "CapMap":
@@ -667,12 +835,12 @@ func peerChangeDiff(was tailcfg.NodeView, n *tailcfg.Node) (_ *tailcfg.PeerChang
 			}
 		case "Online":
 			wasOnline := was.Online()
-			if n.Online != nil && wasOnline != nil && *n.Online != *wasOnline {
+			if n.Online != nil && (wasOnline == nil || *n.Online != *wasOnline) {
 				pc().Online = ptr.To(*n.Online)
 			}
 		case "LastSeen":
 			wasSeen := was.LastSeen()
-			if n.LastSeen != nil && wasSeen != nil && !wasSeen.Equal(*n.LastSeen) {
+			if n.LastSeen != nil && (wasSeen == nil || !wasSeen.Equal(*n.LastSeen)) {
 				pc().LastSeen = ptr.To(*n.LastSeen)
 			}
 		case "MachineAuthorized":
@@ -723,6 +891,14 @@ func peerChangeDiff(was tailcfg.NodeView, n *tailcfg.Node) (_ *tailcfg.PeerChang
 					return nil, false
 				}
 			}
+		case "MaxTxBitrateForThisPeer":
+			if was.MaxTxBitrateForThisPeer() != n.MaxTxBitrateForThisPeer {
+				return nil, false
+			}
+		case "DSCPForThisPeer":
+			if was.DSCPForThisPeer() != n.DSCPForThisPeer {
+				return nil, false
+			}
 
 		}
 	}
@@ -746,6 +922,7 @@ func (ms *mapSession) netmap() *netmap.NetworkMap {
 		PrivateKey:        ms.privateNodeKey,
 		MachineKey:        ms.machinePubKey,
 		Peers:             peerViews,
+		PeerIndex:         ms.peerIndex.Index(),
 		UserProfiles:      make(map[tailcfg.UserID]tailcfg.UserProfile),
 		Domain:            ms.lastDomain,
 		DomainAuditLogID:  ms.lastDomainAuditLogID,
@@ -0,0 +1,286 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controlclient
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/netmap"
+	"tailscale.com/util/clientmetric"
+)
+
+// mapPipelineQueueLen is the capacity of each queue between the stages of a
+// mapPipeline. When a queue is full, the stage feeding it blocks, and
+// eventually so does the reading of the long-poll response.
+const mapPipelineQueueLen = 8
+
+// slowMapStage is how long a stage can spend on one message before it's
+// logged as slow.
+const slowMapStage = time.Second
+
+// mapStage is one stage of a mapPipeline. Its metrics are shared by the
+// pipelines of all map sessions.
+type mapStage struct {
+	name   string
+	count  *clientmetric.Metric // messages processed
+	micros *clientmetric.Metric // total time processing messages
+	slow   *clientmetric.Metric // messages that took longer than slowMapStage
+	panics *clientmetric.Metric
+}
+
+func newMapStage(name string) *mapStage {
+	return &mapStage{
+		name:   name,
+		count:  clientmetric.NewCounter("controlclient_map_" + name + "_count"),
+		micros: clientmetric.NewCounter("controlclient_map_" + name + "_micros"),
+		slow:   clientmetric.NewCounter("controlclient_map_" + name + "_slow"),
+		panics: clientmetric.NewCounter("controlclient_map_" + name + "_panics"),
+	}
+}
+
+var (
+	mapStageDecode  = newMapStage("decode")
+	mapStageApply   = newMapStage("apply")
+	mapStageDeliver = newMapStage("deliver")
+)
+
+// run runs f, recording how long it took and converting a panic in f into
+// an error.
+func (st *mapStage) run(logf logger.Logf, f func() error) (err error) {
+	start := time.Now()
+	defer func() {
+		if p := recover(); p != nil {
+			st.panics.Add(1)
+			logf("netmap: panic in %s stage: %v\n%s", st.name, p, debug.Stack())
+			err = fmt.Errorf("panic in map response %s stage: %v", st.name, p)
+		}
+		d := time.Since(start)
+		st.count.Add(1)
+		st.micros.Add(d.Microseconds())
+		if d > slowMapStage {
+			st.slow.Add(1)
+			logf("netmap: %s stage took %v", st.name, d.Round(time.Millisecond))
+		}
+	}()
+	return f()
+}
+
+// mapPipelineMsg is a message of a map long-poll response, along with its
+// index in the response and its trace.
+type mapPipelineMsg[T any] struct {
+	idx   int
+	v     T
+	trace *MapTrace
+}
+
+// mapPipeline processes the messages of a map long-poll response in three
+// stages, each with its own goroutine: decoding messages, applying
+// non-keep-alive MapResponses to the mapSession, and delivering the
+// resulting updates to the NetmapUpdater. The stages are connected by
+// bounded queues, so a stall in one stage only backs up the stages before
+// it, and a panic in any stage fails the pipeline rather than the process.
+type mapPipeline struct {
+	ctx    context.Context    // done once the pipeline fails or the poll ends
+	cancel context.CancelFunc // cancels the long poll
+	logf   logger.Logf
+
+	decodeq  chan mapPipelineMsg[[]byte]
+	applyq   chan mapPipelineMsg[*tailcfg.MapResponse]
+	deliverq chan func()
+
+	nextIdx int // index of the next message passed to push
+	wg      sync.WaitGroup
+
+	// applying is the trace of the MapResponse being applied. It's only
+	// accessed by the apply stage.
+	applying *MapTrace
+
+	errOnce sync.Once
+	err     error // first error from any stage
+}
+
+// newMapPipeline returns a new mapPipeline for the long poll whose context
+// is ctx and which is canceled by cancel. Its stages are started by start.
+func newMapPipeline(ctx context.Context, cancel context.CancelFunc, logf logger.Logf) *mapPipeline {
+	return &mapPipeline{
+		ctx:      ctx,
+		cancel:   cancel,
+		logf:     logf,
+		decodeq:  make(chan mapPipelineMsg[[]byte], mapPipelineQueueLen),
+		applyq:   make(chan mapPipelineMsg[*tailcfg.MapResponse], mapPipelineQueueLen),
+		deliverq: make(chan func(), mapPipelineQueueLen),
+	}
+}
+
+// start starts the pipeline's stages. decode is called with each message
+// passed to push and returns the MapResponse to pass to apply, or nil if
+// there's nothing to apply. apply passes its updates to the delivery stage
+// via the NetmapUpdater returned by updater.
+func (p *mapPipeline) start(decode func(msg []byte) (*tailcfg.MapResponse, error), apply func(idx int, resp *tailcfg.MapResponse) error) {
+	p.wg.Add(3)
+	go func() {
+		defer p.wg.Done()
+		defer close(p.applyq)
+		for m := range p.decodeq {
+			var resp *tailcfg.MapResponse
+			err := mapStageDecode.run(p.logf, func() (err error) {
+				resp, err = decode(m.v)
+				return err
+			})
+			if err != nil {
+				p.fail(err)
+				return
+			}
+			if resp == nil {
+				continue
+			}
+			m.trace.Mark(MapTraceDecoded)
+			select {
+			case p.applyq <- mapPipelineMsg[*tailcfg.MapResponse]{m.idx, resp, m.trace}:
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		defer p.wg.Done()
+		defer close(p.deliverq)
+		for m := range p.applyq {
+			p.applying = m.trace
+			if err := mapStageApply.run(p.logf, func() error { return apply(m.idx, m.v) }); err != nil {
+				p.fail(err)
+				return
+			}
+			p.applying = nil
+		}
+	}()
+	go func() {
+		defer p.wg.Done()
+		for f := range p.deliverq {
+			if err := mapStageDeliver.run(p.logf, func() error { f(); return nil }); err != nil {
+				p.fail(err)
+				return
+			}
+		}
+	}()
+}
+
+// fail records err as the pipeline's error, if it's the first, and cancels
+// the long poll.
+func (p *mapPipeline) fail(err error) {
+	p.errOnce.Do(func() { p.err = err })
+	p.cancel()
+}
+
+// push queues msg, the next message of the long-poll response, for
+// decoding. It reports whether the pipeline is still running.
+func (p *mapPipeline) push(msg []byte) bool {
+	m := mapPipelineMsg[[]byte]{p.nextIdx, msg, newMapTrace(time.Now())}
+	p.nextIdx++
+	select {
+	case p.decodeq <- m:
+		return true
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+// finish waits for the stages to process all messages passed to push and
+// returns the first error from any stage. It must be called exactly once,
+// after the last call to push.
+func (p *mapPipeline) finish() error {
+	close(p.decodeq)
+	p.wg.Wait()
+	return p.err
+}
+
+// deliver queues f to be run by the delivery stage. It reports whether the
+// pipeline is still running.
+func (p *mapPipeline) deliver(f func()) bool {
+	select {
+	case p.deliverq <- f:
+		return true
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+// updater returns a NetmapUpdater that calls nu from the delivery stage.
+// It implements NetmapDeltaUpdater if nu does.
+func (p *mapPipeline) updater(nu NetmapUpdater) NetmapUpdater {
+	q := pipelineNetmapUpdater{p, nu}
+	if nud, ok := nu.(NetmapDeltaUpdater); ok {
+		return pipelineNetmapDeltaUpdater{q, nud}
+	}
+	return q
+}
+
+// pipelineNetmapUpdater is a NetmapUpdater that queues updates for the
+// delivery stage of a mapPipeline.
+type pipelineNetmapUpdater struct {
+	p  *mapPipeline
+	nu NetmapUpdater
+}
+
+// tracedNetmapUpdater is implemented by NetmapUpdaters which pass on the
+// trace of the MapResponse that produced a netmap to their observer, which
+// marks when the netmap was delivered to it and when it took effect.
+type tracedNetmapUpdater interface {
+	updateFullNetmapTraced(*netmap.NetworkMap, *MapTrace)
+}
+
+func (q pipelineNetmapUpdater) UpdateFullNetmap(nm *netmap.NetworkMap) {
+	tr := q.p.applying
+	tr.Mark(MapTraceApplied)
+	q.p.deliver(func() {
+		if tnu, ok := q.nu.(tracedNetmapUpdater); ok {
+			tnu.updateFullNetmapTraced(nm, tr)
+			return
+		}
+		q.nu.UpdateFullNetmap(nm)
+		tr.Mark(MapTraceDelivered)
+	})
+}
+
+// pipelineNetmapDeltaUpdater is a pipelineNetmapUpdater for a
+// NetmapDeltaUpdater.
+type pipelineNetmapDeltaUpdater struct {
+	pipelineNetmapUpdater
+	nud NetmapDeltaUpdater
+}
+
+// UpdateNetmapDelta waits for the delivery stage to deliver muts, as the
+// caller falls back to a full netmap if they're not handled.
+//
+// Deltas take effect as they're delivered, so their trace is complete once
+// they're handled.
+func (q pipelineNetmapDeltaUpdater) UpdateNetmapDelta(muts []netmap.NodeMutation) bool {
+	tr := q.p.applying
+	tr.Mark(MapTraceApplied)
+	done := make(chan bool, 1)
+	if !q.p.deliver(func() {
+		ok := q.nud.UpdateNetmapDelta(muts)
+		if ok {
+			tr.Mark(MapTraceDelivered)
+			tr.Mark(MapTraceEngine)
+		}
+		done <- ok
+	}) {
+		// The pipeline has failed, so there's no point in building a
+		// full netmap that would never be delivered.
+		return true
+	}
+	select {
+	case ok := <-done:
+		return ok
+	case <-q.p.ctx.Done():
+		return true
+	}
+}
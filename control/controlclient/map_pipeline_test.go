@@ -0,0 +1,87 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controlclient
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+// recordingNetmapUpdater is a NetmapDeltaUpdater that records the updates
+// it's given, panicking on a netmap with the domain "panic".
+type recordingNetmapUpdater struct {
+	got []string
+}
+
+func (nu *recordingNetmapUpdater) UpdateFullNetmap(nm *netmap.NetworkMap) {
+	if nm.Domain == "panic" {
+		panic("boom")
+	}
+	nu.got = append(nu.got, "full:"+nm.Domain)
+}
+
+func (nu *recordingNetmapUpdater) UpdateNetmapDelta(muts []netmap.NodeMutation) bool {
+	nu.got = append(nu.got, "delta:"+strconv.Itoa(len(muts)))
+	return len(muts) > 0
+}
+
+func runTestMapPipeline(t *testing.T, msgs ...string) (*recordingNetmapUpdater, error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := newMapPipeline(ctx, cancel, t.Logf)
+	nu := new(recordingNetmapUpdater)
+	up := p.updater(nu).(NetmapDeltaUpdater)
+	p.start(
+		func(msg []byte) (*tailcfg.MapResponse, error) {
+			if string(msg) == "keepalive" {
+				return nil, nil
+			}
+			return &tailcfg.MapResponse{Domain: string(msg)}, nil
+		},
+		func(idx int, resp *tailcfg.MapResponse) error {
+			if n, ok := strings.CutPrefix(resp.Domain, "delta:"); ok {
+				muts := make([]netmap.NodeMutation, len(n))
+				if !up.UpdateNetmapDelta(muts) {
+					up.(NetmapUpdater).UpdateFullNetmap(&netmap.NetworkMap{Domain: "fallback" + strconv.Itoa(idx)})
+				}
+				return nil
+			}
+			up.(NetmapUpdater).UpdateFullNetmap(&netmap.NetworkMap{Domain: resp.Domain})
+			return nil
+		},
+	)
+	for _, m := range msgs {
+		if !p.push([]byte(m)) {
+			break
+		}
+	}
+	return nu, p.finish()
+}
+
+func TestMapPipeline(t *testing.T) {
+	nu, err := runTestMapPipeline(t, "a", "keepalive", "delta:xx", "delta:", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"full:a", "delta:2", "delta:0", "full:fallback3", "full:b"}
+	if strings.Join(nu.got, " ") != strings.Join(want, " ") {
+		t.Errorf("got %q, want %q", nu.got, want)
+	}
+
+	// A panic while delivering fails the pipeline instead of the process.
+	panics := mapStageDeliver.panics.Value()
+	_, err = runTestMapPipeline(t, "a", "panic", "b")
+	if err == nil || !strings.Contains(err.Error(), "panic in map response deliver stage") {
+		t.Errorf("err = %v, want deliver stage panic", err)
+	}
+	if got := mapStageDeliver.panics.Value() - panics; got != 1 {
+		t.Errorf("deliver panics = %d, want 1", got)
+	}
+}
@@ -7,11 +7,13 @@
 	"context"
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"net/netip"
 	"reflect"
 	"strings"
 	"sync/atomic"
 	"testing"
+	"testing/quick"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
@@ -19,14 +21,17 @@
 	"tailscale.com/control/controlknobs"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstest"
+	"tailscale.com/tstest/perf"
 	"tailscale.com/tstime"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/netmap"
+	"tailscale.com/types/netmap/nmdiff"
 	"tailscale.com/types/ptr"
 	"tailscale.com/util/mak"
 	"tailscale.com/util/must"
+	"tailscale.com/util/set"
 )
 
 func eps(s ...string) []netip.AddrPort {
@@ -532,9 +537,8 @@ func TestNetmapForResponse(t *testing.T) {
 		if !nm1.SelfNode.Valid() {
 			t.Fatal("nil Node in 1st netmap")
 		}
-		if !reflect.DeepEqual(nm1.SelfNode, wantNode) {
-			j, _ := json.Marshal(nm1.SelfNode)
-			t.Errorf("Node mismatch in 1st netmap; got: %s", j)
+		if diff := nmdiff.CompareNodes(wantNode, nm1.SelfNode); len(diff) > 0 {
+			t.Errorf("Node mismatch in 1st netmap: %v", diff)
 		}
 
 		ms.updateStateFromResponse(&tailcfg.MapResponse{})
@@ -542,9 +546,8 @@ func TestNetmapForResponse(t *testing.T) {
 		if !nm2.SelfNode.Valid() {
 			t.Fatal("nil Node in 1st netmap")
 		}
-		if !reflect.DeepEqual(nm2.SelfNode, wantNode) {
-			j, _ := json.Marshal(nm2.SelfNode)
-			t.Errorf("Node mismatch in 2nd netmap; got: %s", j)
+		if diff := nmdiff.CompareNodes(wantNode, nm2.SelfNode); len(diff) > 0 {
+			t.Errorf("Node mismatch in 2nd netmap: %v", diff)
 		}
 	})
 }
@@ -679,6 +682,12 @@ func TestPeerChangeDiff(t *testing.T) {
 			b:    &tailcfg.Node{ID: 1, Endpoints: eps("10.0.0.2:2")},
 			want: &tailcfg.PeerChange{NodeID: 1, Endpoints: eps("10.0.0.2:2")},
 		},
+		{
+			name: "patch-endpoint-types",
+			a:    &tailcfg.Node{ID: 1, Endpoints: eps("10.0.0.1:1"), EndpointTypes: []tailcfg.EndpointType{tailcfg.EndpointLocal}},
+			b:    &tailcfg.Node{ID: 1, Endpoints: eps("10.0.0.1:1"), EndpointTypes: []tailcfg.EndpointType{tailcfg.EndpointExplicitConf}},
+			want: &tailcfg.PeerChange{NodeID: 1, Endpoints: eps("10.0.0.1:1"), EndpointTypes: []tailcfg.EndpointType{tailcfg.EndpointExplicitConf}},
+		},
 		{
 			name: "patch-cap",
 			a:    &tailcfg.Node{ID: 1, Cap: 1},
@@ -790,6 +799,18 @@ func TestPeerChangeDiffAllocs(t *testing.T) {
 	}
 }
 
+// TestPeerChangeDiffPerf checks peerChangeDiff against the budgets in
+// testdata/perf.json.
+func TestPeerChangeDiffPerf(t *testing.T) {
+	c := perf.Checker{Golden: "testdata/perf.json"}
+	a := &tailcfg.Node{ID: 1, Key: key.NewNode().Public(), Endpoints: eps("10.0.0.1:1111")}
+	same := a.Clone()
+	c.Check(t, "peerChangeDiff/same", func() { peerChangeDiff(a.View(), same) })
+	moved := a.Clone()
+	moved.Endpoints = eps("10.0.0.2:2222")
+	c.Check(t, "peerChangeDiff/endpoints", func() { peerChangeDiff(a.View(), moved) })
+}
+
 type countingNetmapUpdater struct {
 	full atomic.Int64
 }
@@ -960,3 +981,282 @@ func BenchmarkMapSessionDelta(b *testing.B) {
 		})
 	}
 }
+
+// nodePair is a pair of random nodes for property tests of peerChangeDiff.
+// B is a copy of A with a random subset of its fields changed.
+type nodePair struct {
+	A, B *tailcfg.Node
+}
+
+// Generate implements quick.Generator.
+func (nodePair) Generate(r *rand.Rand, size int) reflect.Value {
+	a := new(tailcfg.Node)
+	av := reflect.ValueOf(a).Elem()
+	for i := 0; i < av.NumField(); i++ {
+		if sf := av.Type().Field(i); sf.IsExported() {
+			av.Field(i).Set(randNodeFieldValue(r, sf))
+		}
+	}
+	b := a.Clone()
+	bv := reflect.ValueOf(b).Elem()
+	for i := 0; i < bv.NumField(); i++ {
+		if sf := bv.Type().Field(i); sf.IsExported() && r.Intn(4) == 0 {
+			bv.Field(i).Set(randNodeFieldValue(r, sf))
+		}
+	}
+	return reflect.ValueOf(nodePair{a, b})
+}
+
+// randNodeFieldValue returns a random value for the tailcfg.Node field sf.
+func randNodeFieldValue(r *rand.Rand, sf reflect.StructField) reflect.Value {
+	switch sf.Name {
+	case "ID":
+		// Mostly keep the same small set of IDs so that most pairs are
+		// about the same node.
+		return reflect.ValueOf(tailcfg.NodeID(1 + r.Intn(2)))
+	case "DERP":
+		if r.Intn(4) == 0 {
+			return reflect.ValueOf("")
+		}
+		return reflect.ValueOf(fmt.Sprintf("%s:%d", tailcfg.DerpMagicIP, 1+r.Intn(3)))
+	}
+	return randValue(r, sf.Type)
+}
+
+// randValue returns a random value of type t, using only a few distinct
+// values per type so that random pairs are often equal. Empty slices and
+// maps are always nil.
+//
+// It panics on types it doesn't know how to generate, so that new
+// tailcfg.Node field types must be added here.
+func randValue(r *rand.Rand, t reflect.Type) reflect.Value {
+	var v any
+	switch t {
+	case reflect.TypeOf(key.NodePublic{}):
+		v = testNodeKeys[r.Intn(len(testNodeKeys))]
+	case reflect.TypeOf(key.MachinePublic{}):
+		v = testMachineKeys[r.Intn(len(testMachineKeys))]
+	case reflect.TypeOf(key.DiscoPublic{}):
+		v = testDiscoKeys[r.Intn(len(testDiscoKeys))]
+	case reflect.TypeOf(time.Time{}):
+		v = time.Unix(int64(r.Intn(3)), 0)
+	case reflect.TypeOf(netip.Addr{}):
+		v = netip.AddrFrom4([4]byte{100, 64, 0, byte(r.Intn(3))})
+	case reflect.TypeOf(netip.Prefix{}):
+		v = netip.PrefixFrom(netip.AddrFrom4([4]byte{100, 64, 0, byte(r.Intn(3))}), 32)
+	case reflect.TypeOf(netip.AddrPort{}):
+		v = netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, 0, byte(r.Intn(3))}), uint16(1+r.Intn(2)))
+	case reflect.TypeOf(tailcfg.HostinfoView{}):
+		if r.Intn(3) == 0 {
+			v = tailcfg.HostinfoView{}
+		} else {
+			v = (&tailcfg.Hostinfo{Hostname: fmt.Sprint("host", r.Intn(2))}).View()
+		}
+	case reflect.TypeOf(tailcfg.RawMessage("")):
+		v = tailcfg.RawMessage(fmt.Sprintf(`"%d"`, r.Intn(2)))
+	}
+	if v != nil {
+		return reflect.ValueOf(v)
+	}
+
+	rv := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		rv.SetBool(r.Intn(2) == 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		rv.SetInt(int64(r.Intn(3)))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		rv.SetUint(uint64(r.Intn(3)))
+	case reflect.String:
+		rv.SetString(fmt.Sprint("s", r.Intn(3)))
+	case reflect.Pointer:
+		if r.Intn(3) != 0 {
+			p := reflect.New(t.Elem())
+			p.Elem().Set(randValue(r, t.Elem()))
+			rv.Set(p)
+		}
+	case reflect.Slice:
+		if n := r.Intn(3); n > 0 {
+			rv.Set(reflect.MakeSlice(t, n, n))
+			for i := 0; i < n; i++ {
+				if et := t.Elem(); et.Kind() == reflect.Pointer {
+					// Nil elements aren't meaningful in Node slices.
+					p := reflect.New(et.Elem())
+					p.Elem().Set(randValue(r, et.Elem()))
+					rv.Index(i).Set(p)
+				} else {
+					rv.Index(i).Set(randValue(r, et))
+				}
+			}
+		}
+	case reflect.Map:
+		if n := r.Intn(3); n > 0 {
+			rv.Set(reflect.MakeMap(t))
+			for i := 0; i < n; i++ {
+				rv.SetMapIndex(randValue(r, t.Key()), randValue(r, t.Elem()))
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() {
+				rv.Field(i).Set(randValue(r, t.Field(i).Type))
+			}
+		}
+	default:
+		panic(fmt.Sprintf("randValue: unsupported type %v", t))
+	}
+	return rv
+}
+
+var (
+	testNodeKeys    = []key.NodePublic{key.NewNode().Public(), key.NewNode().Public()}
+	testMachineKeys = []key.MachinePublic{key.NewMachine().Public(), key.NewMachine().Public()}
+	testDiscoKeys   = []key.DiscoPublic{key.NewDisco().Public(), key.NewDisco().Public()}
+)
+
+// TestPeerChangeDiffRoundTrip checks that whenever peerChangeDiff says two
+// nodes differ by a patch, applying that patch to the first node yields the
+// second, for random pairs of nodes covering all tailcfg.Node fields.
+func TestPeerChangeDiffRoundTrip(t *testing.T) {
+	f := func(p nodePair) bool {
+		a, b := p.A, p.B
+		pc, ok := peerChangeDiff(a.View(), b)
+		if !ok {
+			if a.ID == b.ID && a.Equal(b) {
+				t.Errorf("equal nodes reported unpatchable: %s", logger.AsJSON(a))
+				return false
+			}
+			return true
+		}
+
+		// Fields where the zero value in a peer update means no change, or
+		// that peerChangeDiff deliberately doesn't patch, keep a's value.
+		want := b.Clone()
+		if want.Online == nil {
+			want.Online = a.Online
+		}
+		if want.LastSeen == nil {
+			want.LastSeen = a.LastSeen
+		}
+		if want.CapMap == nil {
+			want.CapMap = a.CapMap
+		}
+		want.ComputedName = a.ComputedName
+		want.ComputedNameWithHost = a.ComputedNameWithHost
+		want.DataPlaneAuditLogID = a.DataPlaneAuditLogID
+
+		ms := newTestMapSession(t, nil)
+		mak.Set(&ms.peers, a.ID, ptr.To(a.View()))
+		ms.rebuildSorted()
+		if pc != nil {
+			ms.updatePeersStateFromResponse(&tailcfg.MapResponse{
+				PeersChangedPatch: []*tailcfg.PeerChange{pc},
+			})
+		}
+		got := ms.peers[a.ID].AsStruct()
+		if !got.Equal(want) {
+			t.Errorf("patch %s did not round trip\n from: %s\n  got: %s\n want: %s",
+				logger.AsJSON(pc), logger.AsJSON(a), logger.AsJSON(got), logger.AsJSON(want))
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 5000}); err != nil {
+		t.Error(err)
+	}
+}
+
+// recordingDeltaUpdater is a NetmapDeltaUpdater that records the batches
+// of mutations it's given.
+type recordingDeltaUpdater struct {
+	batches [][]netmap.NodeMutation
+}
+
+func (u *recordingDeltaUpdater) UpdateFullNetmap(*netmap.NetworkMap) {}
+
+func (u *recordingDeltaUpdater) UpdateNetmapDelta(muts []netmap.NodeMutation) bool {
+	u.batches = append(u.batches, muts)
+	return true
+}
+
+func TestDeltaBatchesPrioritized(t *testing.T) {
+	const numPeers = 2*maxDeltaBatch + 100
+	res := &tailcfg.MapResponse{}
+	for i := 1; i <= numPeers; i++ {
+		res.PeersChangedPatch = append(res.PeersChangedPatch, &tailcfg.PeerChange{
+			NodeID: tailcfg.NodeID(i),
+			Online: ptr.To(true),
+		})
+	}
+	nodeIDs := func(muts []netmap.NodeMutation) []tailcfg.NodeID {
+		var ret []tailcfg.NodeID
+		for _, m := range muts {
+			ret = append(ret, m.NodeIDBeingMutated())
+		}
+		return ret
+	}
+
+	tests := []struct {
+		name      string
+		important set.Set[tailcfg.NodeID]
+		wantFirst []tailcfg.NodeID // first batch, if any important peers
+		wantSizes []int
+	}{
+		{
+			name:      "no_hint",
+			wantSizes: []int{maxDeltaBatch, maxDeltaBatch, 100},
+		},
+		{
+			name:      "important",
+			important: set.SetOf([]tailcfg.NodeID{numPeers, 7, numPeers + 1}),
+			wantFirst: []tailcfg.NodeID{7, numPeers},
+			wantSizes: []int{2, maxDeltaBatch, maxDeltaBatch, 98},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := new(recordingDeltaUpdater)
+			ms := newTestMapSession(t, u)
+			if tt.important != nil {
+				ms.importantPeers = func() set.Set[tailcfg.NodeID] { return tt.important }
+			}
+			if !ms.tryHandleIncrementally(res) {
+				t.Fatal("tryHandleIncrementally = false")
+			}
+			var sizes []int
+			seen := set.Set[tailcfg.NodeID]{}
+			for _, b := range u.batches {
+				sizes = append(sizes, len(b))
+				for _, id := range nodeIDs(b) {
+					if seen.Contains(id) {
+						t.Errorf("mutation of node %v delivered twice", id)
+					}
+					seen.Add(id)
+				}
+			}
+			if !reflect.DeepEqual(sizes, tt.wantSizes) {
+				t.Errorf("batch sizes = %v, want %v", sizes, tt.wantSizes)
+			}
+			if len(seen) != numPeers {
+				t.Errorf("delivered mutations of %d nodes, want %d", len(seen), numPeers)
+			}
+			if tt.wantFirst != nil {
+				if got := nodeIDs(u.batches[0]); !reflect.DeepEqual(got, tt.wantFirst) {
+					t.Errorf("first batch = %v, want %v", got, tt.wantFirst)
+				}
+			}
+		})
+	}
+
+	// Small batches aren't split, even with important peers in them.
+	u := new(recordingDeltaUpdater)
+	ms := newTestMapSession(t, u)
+	ms.importantPeers = func() set.Set[tailcfg.NodeID] { return set.SetOf([]tailcfg.NodeID{2}) }
+	small := &tailcfg.MapResponse{PeersChangedPatch: res.PeersChangedPatch[:3]}
+	if !ms.tryHandleIncrementally(small) {
+		t.Fatal("tryHandleIncrementally = false")
+	}
+	if len(u.batches) != 1 || len(u.batches[0]) != 3 {
+		t.Errorf("small batch delivered as %d batches", len(u.batches))
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controlclient
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"tailscale.com/tailcfg"
+)
+
+// MapLogLevel is the verbosity of map session logging. Each level logs
+// everything the levels below it do.
+type MapLogLevel int32
+
+const (
+	// MapLogDefault logs a summary of the netmap at most every five
+	// minutes.
+	MapLogDefault MapLogLevel = iota
+	// MapLogSummary logs a summary of the netmap after every MapResponse.
+	MapLogSummary
+	// MapLogResponse additionally logs a one-line description of every
+	// non-keep-alive MapResponse.
+	MapLogResponse
+	// MapLogPeer additionally logs every change to every peer.
+	MapLogPeer
+)
+
+var mapLogLevelNames = []string{
+	MapLogDefault:  "default",
+	MapLogSummary:  "summary",
+	MapLogResponse: "response",
+	MapLogPeer:     "peer",
+}
+
+func (l MapLogLevel) String() string {
+	if l >= 0 && int(l) < len(mapLogLevelNames) {
+		return mapLogLevelNames[l]
+	}
+	return fmt.Sprintf("MapLogLevel(%d)", int32(l))
+}
+
+// ParseMapLogLevel parses the name of a MapLogLevel, as returned by its String
+// method.
+func ParseMapLogLevel(s string) (MapLogLevel, error) {
+	for l, name := range mapLogLevelNames {
+		if s == name {
+			return MapLogLevel(l), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown map log level %q; want one of %s", s, strings.Join(mapLogLevelNames, ", "))
+}
+
+// mapLogLevel is the current MapLogLevel of all map sessions.
+var mapLogLevel atomic.Int32
+
+// SetMapLogLevel sets the verbosity of map session logging, taking effect
+// immediately for current and future map sessions.
+func SetMapLogLevel(l MapLogLevel) {
+	mapLogLevel.Store(int32(l))
+}
+
+// GetMapLogLevel returns the current verbosity of map session logging.
+func GetMapLogLevel() MapLogLevel {
+	return MapLogLevel(mapLogLevel.Load())
+}
+
+// mapLogAtLeast reports whether the map log level is l or higher.
+func mapLogAtLeast(l MapLogLevel) bool {
+	return GetMapLogLevel() >= l
+}
+
+// describeMapResponse returns a one-line description of which parts of a
+// MapResponse are present, for MapLogResponse logging.
+func describeMapResponse(resp *tailcfg.MapResponse) string {
+	var sb strings.Builder
+	add := func(format string, args ...any) {
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		fmt.Fprintf(&sb, format, args...)
+	}
+	if resp.Node != nil {
+		add("node")
+	}
+	if resp.Peers != nil {
+		add("peers=%d", len(resp.Peers))
+	}
+	if n := len(resp.PeersChanged); n > 0 {
+		add("changed=%d", n)
+	}
+	if n := len(resp.PeersChangedPatch); n > 0 {
+		add("patched=%d", n)
+	}
+	if n := len(resp.PeersRemoved); n > 0 {
+		add("removed=%d", n)
+	}
+	if n := len(resp.PeerSeenChange); n > 0 {
+		add("seen=%d", n)
+	}
+	if n := len(resp.OnlineChange); n > 0 {
+		add("online=%d", n)
+	}
+	if resp.DNSConfig != nil {
+		add("dns")
+	}
+	if resp.DERPMap != nil {
+		add("derpmap")
+	}
+	if resp.PacketFilter != nil {
+		add("filter")
+	}
+	if resp.SSHPolicy != nil {
+		add("ssh")
+	}
+	if resp.UserProfiles != nil {
+		add("users=%d", len(resp.UserProfiles))
+	}
+	if resp.Debug != nil {
+		add("debug")
+	}
+	if resp.ControlTime != nil {
+		add("time")
+	}
+	if sb.Len() == 0 {
+		return "(empty)"
+	}
+	return sb.String()
+}
@@ -0,0 +1,72 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controlclient
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"tailscale.com/tailcfg"
+)
+
+func TestParseMapLogLevel(t *testing.T) {
+	for _, l := range []MapLogLevel{MapLogDefault, MapLogSummary, MapLogResponse, MapLogPeer} {
+		got, err := ParseMapLogLevel(l.String())
+		if err != nil || got != l {
+			t.Errorf("ParseMapLogLevel(%q) = %v, %v; want %v", l, got, err, l)
+		}
+	}
+	if _, err := ParseMapLogLevel("loud"); err == nil {
+		t.Error("ParseMapLogLevel(loud) succeeded; want error")
+	}
+}
+
+func TestDescribeMapResponse(t *testing.T) {
+	tests := []struct {
+		resp *tailcfg.MapResponse
+		want string
+	}{
+		{&tailcfg.MapResponse{}, "(empty)"},
+		{
+			&tailcfg.MapResponse{
+				Node:              &tailcfg.Node{},
+				PeersChangedPatch: []*tailcfg.PeerChange{{NodeID: 1}, {NodeID: 2}},
+				PeersRemoved:      []tailcfg.NodeID{3},
+				DERPMap:           &tailcfg.DERPMap{},
+			},
+			"node patched=2 removed=1 derpmap",
+		},
+	}
+	for _, tt := range tests {
+		if got := describeMapResponse(tt.resp); got != tt.want {
+			t.Errorf("describeMapResponse = %q; want %q", got, tt.want)
+		}
+	}
+}
+
+func TestMapLogLevelPeer(t *testing.T) {
+	t.Cleanup(func() { SetMapLogLevel(MapLogDefault) })
+	var logs []string
+	ms := newTestMapSession(t, nil)
+	ms.logf = func(format string, args ...any) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+	resp := func() *tailcfg.MapResponse {
+		return &tailcfg.MapResponse{
+			PeersChanged: []*tailcfg.Node{{ID: 1}},
+		}
+	}
+
+	ms.updatePeersStateFromResponse(resp())
+	if len(logs) != 0 {
+		t.Fatalf("unexpected logs at default level: %q", logs)
+	}
+
+	SetMapLogLevel(MapLogPeer)
+	ms.updatePeersStateFromResponse(resp())
+	if got := strings.Join(logs, "\n"); !strings.Contains(got, "peer nodeid:1 replaced") {
+		t.Errorf("logs = %q; want peer nodeid:1 replaced", got)
+	}
+}
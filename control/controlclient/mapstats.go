@@ -0,0 +1,164 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controlclient
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/util/clientmetric"
+)
+
+// MapStats are counts of MapResponse activity over some period of time.
+type MapStats struct {
+	Messages     int64 // MapResponse messages, including keep-alives
+	Bytes        int64 // size of MapResponse messages on the wire
+	Maps         int64 // non-keep-alive MapResponses
+	FullMaps     int64 // MapResponses with a full list of peers
+	PeersAdded   int64
+	PeersRemoved int64
+	PeersChanged int64
+}
+
+func (s *MapStats) add(o *MapStats) {
+	s.Messages += o.Messages
+	s.Bytes += o.Bytes
+	s.Maps += o.Maps
+	s.FullMaps += o.FullMaps
+	s.PeersAdded += o.PeersAdded
+	s.PeersRemoved += o.PeersRemoved
+	s.PeersChanged += o.PeersChanged
+}
+
+// MapStatsWindow is the MapStats of the most recent Window of time.
+type MapStatsWindow struct {
+	Window time.Duration
+	MapStats
+}
+
+func (w MapStatsWindow) String() string {
+	return fmt.Sprintf("%v: msgs=%d bytes=%d maps=%d full=%d peers=+%d/-%d/~%d",
+		w.Window, w.Messages, w.Bytes, w.Maps, w.FullMaps, w.PeersAdded, w.PeersRemoved, w.PeersChanged)
+}
+
+// MapStatsWindows are the windows of time returned by RecentMapStats.
+var MapStatsWindows = []time.Duration{time.Minute, 5 * time.Minute, time.Hour}
+
+const (
+	// mapStatsBucket is the granularity of recentMapStats.
+	mapStatsBucket = 10 * time.Second
+	// mapStatsBuckets is the number of buckets needed to cover the
+	// longest of MapStatsWindows.
+	mapStatsBuckets = int(time.Hour / mapStatsBucket)
+)
+
+// mapStatsRing is a ring of MapStats, each covering mapStatsBucket of time.
+type mapStatsRing struct {
+	mu      sync.Mutex
+	buckets [mapStatsBuckets]MapStats
+	epochs  [mapStatsBuckets]int64 // which bucket (time/mapStatsBucket) each slot holds
+}
+
+// recentMapStats are the MapStats of all map sessions in the process.
+var recentMapStats mapStatsRing
+
+func bucketOf(t time.Time) int64 {
+	return t.UnixNano() / int64(mapStatsBucket)
+}
+
+// update calls f with the bucket for now.
+func (r *mapStatsRing) update(now time.Time, f func(*MapStats)) {
+	e := bucketOf(now)
+	i := int(e % int64(mapStatsBuckets))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.epochs[i] != e {
+		r.epochs[i] = e
+		r.buckets[i] = MapStats{}
+	}
+	f(&r.buckets[i])
+}
+
+// sum returns the sum of the buckets covering the d before now.
+func (r *mapStatsRing) sum(now time.Time, d time.Duration) MapStats {
+	var ret MapStats
+	e := bucketOf(now)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k := int64(0); k < int64(d/mapStatsBucket) && k < int64(mapStatsBuckets); k++ {
+		i := int((e - k) % int64(mapStatsBuckets))
+		if r.epochs[i] == e-k {
+			ret.add(&r.buckets[i])
+		}
+	}
+	return ret
+}
+
+// recordMessage records the receipt of a MapResponse message of size
+// bytes.
+func (r *mapStatsRing) recordMessage(now time.Time, size int, keepAlive bool) {
+	r.update(now, func(s *MapStats) {
+		s.Messages++
+		s.Bytes += int64(size)
+		if !keepAlive {
+			s.Maps++
+		}
+	})
+}
+
+// recordUpdate records the peer changes made by a MapResponse.
+func (r *mapStatsRing) recordUpdate(now time.Time, st updateStats) {
+	r.update(now, func(s *MapStats) {
+		if st.allNew {
+			s.FullMaps++
+		}
+		s.PeersAdded += int64(st.added)
+		s.PeersRemoved += int64(st.removed)
+		s.PeersChanged += int64(st.changed)
+	})
+}
+
+// RecentMapStats returns the MapStats of all map sessions over each of
+// MapStatsWindows, ending now.
+func RecentMapStats() []MapStatsWindow {
+	now := time.Now()
+	ret := make([]MapStatsWindow, len(MapStatsWindows))
+	for i, d := range MapStatsWindows {
+		ret[i] = MapStatsWindow{Window: d, MapStats: recentMapStats.sum(now, d)}
+	}
+	return ret
+}
+
+// FormatRecentMapStats returns RecentMapStats as a single line, for logging.
+func FormatRecentMapStats() string {
+	var sb strings.Builder
+	for i, w := range RecentMapStats() {
+		if i > 0 {
+			sb.WriteString("; ")
+		}
+		sb.WriteString(w.String())
+	}
+	return sb.String()
+}
+
+func init() {
+	for i, suffix := range []string{"1m", "5m", "1h"} {
+		d := MapStatsWindows[i]
+		stat := func(name string, field func(*MapStats) int64) {
+			clientmetric.NewGaugeFunc("controlclient_map_"+name+"_"+suffix, func() int64 {
+				s := recentMapStats.sum(time.Now(), d)
+				return field(&s)
+			})
+		}
+		stat("messages", func(s *MapStats) int64 { return s.Messages })
+		stat("bytes", func(s *MapStats) int64 { return s.Bytes })
+		stat("maps", func(s *MapStats) int64 { return s.Maps })
+		stat("full_maps", func(s *MapStats) int64 { return s.FullMaps })
+		stat("peers_added", func(s *MapStats) int64 { return s.PeersAdded })
+		stat("peers_removed", func(s *MapStats) int64 { return s.PeersRemoved })
+		stat("peers_changed", func(s *MapStats) int64 { return s.PeersChanged })
+	}
+}
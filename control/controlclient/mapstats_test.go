@@ -0,0 +1,43 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controlclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMapStatsRing(t *testing.T) {
+	var r mapStatsRing
+	start := time.Unix(1700000000, 0)
+
+	r.recordMessage(start, 1000, false)
+	r.recordUpdate(start, updateStats{allNew: true, added: 10})
+	r.recordMessage(start.Add(2*time.Minute), 50, true)
+	r.recordMessage(start.Add(4*time.Minute), 200, false)
+	r.recordUpdate(start.Add(4*time.Minute), updateStats{changed: 3, removed: 1})
+
+	now := start.Add(4*time.Minute + 5*time.Second)
+	tests := []struct {
+		d    time.Duration
+		want MapStats
+	}{
+		{time.Minute, MapStats{Messages: 1, Bytes: 200, Maps: 1, PeersChanged: 3, PeersRemoved: 1}},
+		{5 * time.Minute, MapStats{Messages: 3, Bytes: 1250, Maps: 2, FullMaps: 1, PeersAdded: 10, PeersChanged: 3, PeersRemoved: 1}},
+		{time.Hour, MapStats{Messages: 3, Bytes: 1250, Maps: 2, FullMaps: 1, PeersAdded: 10, PeersChanged: 3, PeersRemoved: 1}},
+	}
+	for _, tt := range tests {
+		if got := r.sum(now, tt.d); got != tt.want {
+			t.Errorf("sum(%v) = %+v; want %+v", tt.d, got, tt.want)
+		}
+	}
+
+	// An hour later, everything has aged out, even though the ring's slots
+	// are reused.
+	later := start.Add(2 * time.Hour)
+	r.recordMessage(later, 7, true)
+	if got, want := r.sum(later, time.Hour), (MapStats{Messages: 1, Bytes: 7}); got != want {
+		t.Errorf("after an hour, sum = %+v; want %+v", got, want)
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controlclient
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/util/cmpx"
+)
+
+// MapTraceStage is a stage a MapResponse passes through on its way from the
+// long poll to taking effect.
+type MapTraceStage int
+
+const (
+	MapTraceReceived  MapTraceStage = iota // read from the long poll
+	MapTraceDecoded                        // decoded
+	MapTraceApplied                        // applied to the map session, producing an update
+	MapTraceDelivered                      // update delivered to the client's observer
+	MapTraceEngine                         // engine reconfigured with the update
+	numMapTraceStages
+)
+
+var mapTraceStageNames = [numMapTraceStages]string{
+	MapTraceReceived:  "received",
+	MapTraceDecoded:   "decoded",
+	MapTraceApplied:   "applied",
+	MapTraceDelivered: "delivered",
+	MapTraceEngine:    "engine",
+}
+
+func (s MapTraceStage) String() string {
+	if s < 0 || s >= numMapTraceStages {
+		return "unknown"
+	}
+	return mapTraceStageNames[s]
+}
+
+// MapTrace traces a non-keep-alive MapResponse through the stages of
+// MapTraceStage. It's carried along with the update the MapResponse produces,
+// such as in Status.Trace, so the client's observer can mark the last stage.
+//
+// A nil *MapTrace is valid and ignores all marks.
+type MapTrace struct {
+	id uint64
+
+	mu    sync.Mutex
+	times [numMapTraceStages]time.Time
+}
+
+var lastMapTraceID atomic.Uint64
+
+func newMapTrace(now time.Time) *MapTrace {
+	t := &MapTrace{id: lastMapTraceID.Add(1)}
+	t.times[MapTraceReceived] = now
+	return t
+}
+
+// Mark records that the traced MapResponse reached stage st now. Only the
+// first mark of each stage counts, so a MapResponse whose update is delivered
+// in several parts is timed by the first. Marking MapTraceEngine completes
+// the trace.
+func (t *MapTrace) Mark(st MapTraceStage) {
+	t.mark(st, time.Now())
+}
+
+func (t *MapTrace) mark(st MapTraceStage, now time.Time) {
+	if t == nil || st <= MapTraceReceived || st >= numMapTraceStages {
+		return
+	}
+	t.mu.Lock()
+	if !t.times[st].IsZero() {
+		t.mu.Unlock()
+		return
+	}
+	t.times[st] = now
+	// The latency of a stage is from the most recent earlier stage,
+	// as updates can skip stages, such as deltas not needing the
+	// engine reconfigured.
+	var prev time.Time
+	for s := st - 1; s >= MapTraceReceived && prev.IsZero(); s-- {
+		prev = t.times[s]
+	}
+	var done *MapTraceSummary
+	if st == MapTraceEngine {
+		done = t.summaryLocked()
+	}
+	t.mu.Unlock()
+
+	recentMapTraces.observe(st, now.Sub(prev), done)
+}
+
+// summaryLocked returns the summary of t. t.mu must be held.
+func (t *MapTrace) summaryLocked() *MapTraceSummary {
+	s := &MapTraceSummary{ID: t.id, Received: t.times[MapTraceReceived]}
+	prev := s.Received
+	for st := MapTraceDecoded; st < numMapTraceStages; st++ {
+		if t.times[st].IsZero() {
+			continue
+		}
+		s.Stages = append(s.Stages, MapTraceStageLatency{
+			Stage:   st.String(),
+			Latency: t.times[st].Sub(prev),
+		})
+		s.Total = t.times[st].Sub(s.Received)
+		prev = t.times[st]
+	}
+	return s
+}
+
+// MapTraceStageLatency is how long a traced MapResponse took to reach a
+// stage from the stage before it.
+type MapTraceStageLatency struct {
+	Stage   string
+	Latency time.Duration
+}
+
+// MapTraceSummary is a completed MapTrace.
+type MapTraceSummary struct {
+	ID       uint64
+	Received time.Time
+	Total    time.Duration // from receipt to the engine reconfigured
+	Stages   []MapTraceStageLatency
+}
+
+// MapTraceBucket is a bucket of a MapTraceHistogram.
+type MapTraceBucket struct {
+	LE    time.Duration // upper bound; zero for the last, unbounded, bucket
+	Count int64
+}
+
+// MapTraceHistogram is a histogram of how long MapResponses took to reach a
+// stage from the stage before it.
+type MapTraceHistogram struct {
+	Stage   string
+	Count   int64
+	Sum     time.Duration
+	Buckets []MapTraceBucket
+}
+
+// MapTraces are the latencies of MapResponses through the stages of
+// MapTraceStage, as returned by RecentMapTraces.
+type MapTraces struct {
+	// Stages are the histograms of the latency of each stage, since
+	// the process started.
+	Stages []MapTraceHistogram
+
+	// Slowest are the slowest of the most recently completed traces,
+	// slowest first.
+	Slowest []MapTraceSummary
+}
+
+// mapTraceBuckets are the upper bounds of the buckets of
+// MapTraceHistogram.
+var mapTraceBuckets = []time.Duration{
+	time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	30 * time.Second,
+}
+
+const (
+	// numRecentMapTraces is how many completed traces are kept.
+	numRecentMapTraces = 100
+	// numSlowestMapTraces is how many of those RecentMapTraces returns.
+	numSlowestMapTraces = 10
+)
+
+// mapTraceStats are the stats of MapTraces.
+type mapTraceStats struct {
+	mu      sync.Mutex
+	counts  [numMapTraceStages][]int64 // parallel to mapTraceBuckets, plus one
+	sums    [numMapTraceStages]time.Duration
+	recent  [numRecentMapTraces]*MapTraceSummary // ring
+	nRecent int                                  // total added to recent
+}
+
+// recentMapTraces are the stats of the MapTraces of all map sessions in the
+// process.
+var recentMapTraces mapTraceStats
+
+// observe records that a MapResponse took d to reach stage st and, if done
+// is non-nil, that its trace completed.
+func (s *mapTraceStats) observe(st MapTraceStage, d time.Duration, done *MapTraceSummary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts[st] == nil {
+		s.counts[st] = make([]int64, len(mapTraceBuckets)+1)
+	}
+	i, _ := slices.BinarySearch(mapTraceBuckets, d)
+	s.counts[st][i]++
+	s.sums[st] += d
+	if done != nil {
+		s.recent[s.nRecent%numRecentMapTraces] = done
+		s.nRecent++
+	}
+}
+
+// get returns the MapTraces of s.
+func (s *mapTraceStats) get() MapTraces {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ret MapTraces
+	for st := MapTraceDecoded; st < numMapTraceStages; st++ {
+		h := MapTraceHistogram{Stage: st.String(), Sum: s.sums[st]}
+		for i, n := range s.counts[st] {
+			b := MapTraceBucket{Count: n}
+			if i < len(mapTraceBuckets) {
+				b.LE = mapTraceBuckets[i]
+			}
+			h.Buckets = append(h.Buckets, b)
+			h.Count += n
+		}
+		ret.Stages = append(ret.Stages, h)
+	}
+	for _, t := range s.recent {
+		if t != nil {
+			ret.Slowest = append(ret.Slowest, *t)
+		}
+	}
+	slices.SortStableFunc(ret.Slowest, func(a, b MapTraceSummary) int {
+		return cmpx.Compare(b.Total, a.Total)
+	})
+	if len(ret.Slowest) > numSlowestMapTraces {
+		ret.Slowest = ret.Slowest[:numSlowestMapTraces]
+	}
+	return ret
+}
+
+// RecentMapTraces returns the latencies of the MapResponses of all map
+// sessions through the stages of MapTraceStage.
+func RecentMapTraces() MapTraces {
+	return recentMapTraces.get()
+}
@@ -0,0 +1,64 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controlclient
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMapTrace(t *testing.T) {
+	stageCount := func(st MapTraceStage, le time.Duration) int64 {
+		for _, h := range RecentMapTraces().Stages {
+			if h.Stage != st.String() {
+				continue
+			}
+			for _, b := range h.Buckets {
+				if b.LE == le {
+					return b.Count
+				}
+			}
+		}
+		t.Fatalf("no bucket %v of stage %v", le, st)
+		return 0
+	}
+	decoded := stageCount(MapTraceDecoded, time.Millisecond)
+	delivered := stageCount(MapTraceDelivered, 0)
+
+	start := time.Unix(1700000000, 0)
+	tr := newMapTrace(start)
+	tr.mark(MapTraceDecoded, start.Add(500*time.Microsecond))
+	tr.mark(MapTraceApplied, start.Add(20*time.Millisecond))
+	tr.mark(MapTraceApplied, start.Add(time.Second)) // only the first counts
+	tr.mark(MapTraceDelivered, start.Add(time.Hour))
+	tr.mark(MapTraceEngine, start.Add(time.Hour+time.Second))
+	(*MapTrace)(nil).Mark(MapTraceEngine) // no-op
+
+	if got := stageCount(MapTraceDecoded, time.Millisecond); got != decoded+1 {
+		t.Errorf("decoded count in 1ms bucket = %v; want %v", got, decoded+1)
+	}
+	if got := stageCount(MapTraceDelivered, 0); got != delivered+1 {
+		t.Errorf("delivered count in unbounded bucket = %v; want %v", got, delivered+1)
+	}
+
+	slowest := RecentMapTraces().Slowest
+	if len(slowest) == 0 {
+		t.Fatal("no slowest traces")
+	}
+	want := MapTraceSummary{
+		ID:       tr.id,
+		Received: start,
+		Total:    time.Hour + time.Second,
+		Stages: []MapTraceStageLatency{
+			{"decoded", 500 * time.Microsecond},
+			{"applied", 19500 * time.Microsecond},
+			{"delivered", time.Hour - 20*time.Millisecond},
+			{"engine", time.Second},
+		},
+	}
+	if got := slowest[0]; !reflect.DeepEqual(got, want) {
+		t.Errorf("slowest trace = %+v; want %+v", got, want)
+	}
+}
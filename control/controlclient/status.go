@@ -84,6 +84,11 @@ type Status struct {
 	// TODO(bradfitz,maisem): clarify this.
 	Persist persist.PersistView
 
+	// Trace, if non-nil, traces the MapResponse that produced NetMap. The
+	// observer should mark MapTraceEngine once it has reconfigured the
+	// engine with NetMap.
+	Trace *MapTrace `json:"-"`
+
 	// state is the internal state. It should not be exposed outside this
 	// package, but we have some automated tests elsewhere that need to
 	// use it via the StateForTest accessor.
@@ -112,6 +117,7 @@ func (s *Status) Equal(s2 *Status) bool {
 		s.Err == s2.Err &&
 		s.URL == s2.URL &&
 		s.state == s2.state &&
+		s.Trace == s2.Trace &&
 		reflect.DeepEqual(s.Persist, s2.Persist) &&
 		reflect.DeepEqual(s.NetMap, s2.NetMap)
 }
@@ -117,6 +117,14 @@
 	// and how long to try total. See ServerRestartingMessage docs for
 	// more details on how the client should interpret them.
 	frameRestarting = frameType(0x15)
+
+	// frameRateLimited is sent from server to client, like an HTTP
+	// 429, when the server dropped packets from the client for being
+	// over its rate limits. Payload is two big endian uint32s: how many
+	// milliseconds the client should wait before sending more, and the
+	// bytes per second the client may send (0 if unspecified). See
+	// RateLimitedMessage.
+	frameRateLimited = frameType(0x16)
 )
 
 // PeerGoneReasonType is a one byte reason code explaining why a
@@ -445,6 +445,25 @@ type ServerRestartingMessage struct {
 
 func (ServerRestartingMessage) msg() {}
 
+// RateLimitedMessage is a one-way message from server to client, saying
+// that the server dropped packets from the client for being over its rate
+// limits.
+//
+// If BytesPerSecond is non-zero, the Client has already limited its sends
+// to it.
+type RateLimitedMessage struct {
+	// RetryAfter is an advisory duration that the client should wait
+	// before sending more packets.
+	RetryAfter time.Duration
+
+	// BytesPerSecond is how many bytes per second the server will
+	// accept from the client, including all framing bytes. Zero means
+	// unspecified.
+	BytesPerSecond int
+}
+
+func (RateLimitedMessage) msg() {}
+
 // Recv reads a message from the DERP server.
 //
 // The returned message may alias memory owned by the Client; it
@@ -601,6 +620,22 @@ func (c *Client) recvTimeout(timeout time.Duration) (m ReceivedMessage, err erro
 			m.ReconnectIn = time.Duration(binary.BigEndian.Uint32(b[0:4])) * time.Millisecond
 			m.TryFor = time.Duration(binary.BigEndian.Uint32(b[4:8])) * time.Millisecond
 			return m, nil
+
+		case frameRateLimited:
+			var m RateLimitedMessage
+			if n < 8 {
+				c.logf("[unexpected] dropping short rate limited frame")
+				continue
+			}
+			m.RetryAfter = time.Duration(binary.BigEndian.Uint32(b[0:4])) * time.Millisecond
+			m.BytesPerSecond = int(binary.BigEndian.Uint32(b[4:8]))
+			if m.BytesPerSecond > 0 {
+				c.setSendRateLimiter(ServerInfoMessage{
+					TokenBucketBytesPerSecond: m.BytesPerSecond,
+					TokenBucketBytesBurst:     byteBurst(m.BytesPerSecond),
+				})
+			}
+			return m, nil
 		}
 	}
 }
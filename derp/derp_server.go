@@ -127,6 +127,7 @@ type Server struct {
 	packetsForwardedIn           expvar.Int
 	peerGoneDisconnectedFrames   expvar.Int // number of peer disconnected frames sent
 	peerGoneNotHereFrames        expvar.Int // number of peer not here frames sent
+	rateLimitedFrames            expvar.Int // number of rate limited frames sent
 	gotPing                      expvar.Int // number of ping frames from client
 	sentPong                     expvar.Int // number of pong frames enqueued to client
 	accepts                      expvar.Int
@@ -144,6 +145,15 @@ type Server struct {
 	avgQueueDuration             *uint64          // In milliseconds; accessed atomically
 	tcpRtt                       metrics.LabelMap // histogram
 
+	// rateLimiters are the limits on what clients send, or nil if
+	// unlimited. See SetRateLimits.
+	rateLimiters atomic.Pointer[rateLimiters]
+
+	// federatedPeers are the keys of DERP servers run by other
+	// organizations that may mesh with this one without the mesh key,
+	// or nil if none. See SetFederatedPeers.
+	federatedPeers atomic.Pointer[set.Set[key.NodePublic]]
+
 	// verifyClients only accepts client connections to the DERP server if the clientKey is a
 	// known peer in the network, as specified by a running tailscaled's client's LocalAPI.
 	verifyClients bool
@@ -335,6 +345,7 @@ func NewServer(privateKey key.NodePrivate, logf logger.Logf) *Server {
 		s.packetsDroppedReason.Get("queue_head"),
 		s.packetsDroppedReason.Get("queue_tail"),
 		s.packetsDroppedReason.Get("write_error"),
+		s.packetsDroppedReason.Get("rate_limited"),
 	}
 	s.packetsDroppedTypeDisco = s.packetsDroppedType.Get("disco")
 	s.packetsDroppedTypeOther = s.packetsDroppedType.Get("other")
@@ -356,6 +367,67 @@ func (s *Server) SetVerifyClient(v bool) {
 	s.verifyClients = v
 }
 
+// SetFederatedPeers sets the public keys of the DERP servers run by other
+// organizations that may federate with this server. Unlike servers with
+// the mesh key, they can only watch which clients are connected, without
+// their IP:ports, and forward packets from their own clients to this
+// server's. They can't send packets as clients, so they're exempt from
+// client verification.
+//
+// It may be called at any time. Connections already established keep
+// the permissions they had.
+func (s *Server) SetFederatedPeers(keys []key.NodePublic) {
+	peers := set.SetOf(keys)
+	s.federatedPeers.Store(&peers)
+}
+
+// isFederatedClient reports whether src is a client of the federated
+// peer with key peer, as learned by watching its connections.
+func (s *Server) isFederatedClient(src, peer key.NodePublic) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[src]; ok {
+		return false // local clients can't be spoofed
+	}
+	switch fwd := s.clientsMesh[src].(type) {
+	case nil:
+		return false
+	case *multiForwarder:
+		for f := range fwd.all {
+			if forwarderServerKey(f) == peer {
+				return true
+			}
+		}
+		return false
+	default:
+		return forwarderServerKey(fwd) == peer
+	}
+}
+
+// forwarderServerKey returns the key of the DERP server that fwd
+// forwards packets to, or the zero key if it doesn't say.
+func forwarderServerKey(fwd PacketForwarder) key.NodePublic {
+	if f, ok := fwd.(interface{ ServerPublicKey() key.NodePublic }); ok {
+		return f.ServerPublicKey()
+	}
+	return key.NodePublic{}
+}
+
+// watchedIPPort returns the IP:port of a client to tell c, a watcher,
+// which is ipPort for mesh peers but hidden from federated ones.
+func (c *sclient) watchedIPPort(ipPort netip.AddrPort) netip.AddrPort {
+	if c.canFederate {
+		return netip.AddrPort{}
+	}
+	return ipPort
+}
+
+// isFederatedPeer reports whether k is the key of a federated peer.
+func (s *Server) isFederatedPeer(k key.NodePublic) bool {
+	peers := s.federatedPeers.Load()
+	return peers != nil && peers.Contains(k)
+}
+
 // HasMeshKey reports whether the server is configured with a mesh key.
 func (s *Server) HasMeshKey() bool { return s.meshKey != "" }
 
@@ -545,10 +617,13 @@ func (s *Server) registerClient(c *sclient) {
 // s.mu must be held.
 func (s *Server) broadcastPeerStateChangeLocked(peer key.NodePublic, ipPort netip.AddrPort, present bool) {
 	for w := range s.watchers {
+		if len(w.peerStateChange) == 0 {
+			w.peerStateChangeSince = s.clock.Now()
+		}
 		w.peerStateChange = append(w.peerStateChange, peerConnState{
 			peer:    peer,
 			present: present,
-			ipPort:  ipPort,
+			ipPort:  w.watchedIPPort(ipPort),
 		})
 		go w.requestMeshUpdate()
 	}
@@ -594,7 +669,7 @@ func (s *Server) unregisterClient(c *sclient) {
 		}
 	}
 
-	if c.canMesh {
+	if c.canMesh || c.canFederate {
 		delete(s.watchers, c)
 	}
 
@@ -647,7 +722,7 @@ func (c *sclient) requestPeerGoneWriteLimited(peer key.NodePublic, contents []by
 }
 
 func (s *Server) addWatcher(c *sclient) {
-	if !c.canMesh {
+	if !c.canMesh && !c.canFederate {
 		panic("invariant: addWatcher called without permissions")
 	}
 
@@ -660,6 +735,9 @@ func (s *Server) addWatcher(c *sclient) {
 	defer s.mu.Unlock()
 
 	// Queue messages for each already-connected client.
+	if len(c.peerStateChange) == 0 {
+		c.peerStateChangeSince = s.clock.Now()
+	}
 	for peer, clientSet := range s.clients {
 		ac := clientSet.ActiveClient()
 		if ac == nil {
@@ -668,7 +746,7 @@ func (s *Server) addWatcher(c *sclient) {
 		c.peerStateChange = append(c.peerStateChange, peerConnState{
 			peer:    peer,
 			present: true,
-			ipPort:  ac.remoteIPPort,
+			ipPort:  c.watchedIPPort(ac.remoteIPPort),
 		})
 	}
 
@@ -721,9 +799,12 @@ func (s *Server) accept(ctx context.Context, nc Conn, brw *bufio.ReadWriter, rem
 		peerGone:       make(chan peerGoneMsg),
 		canMesh:        clientInfo.MeshKey != "" && clientInfo.MeshKey == s.meshKey,
 		peerGoneLim:    rate.NewLimiter(rate.Every(time.Second), 3),
+		rateLimitedCh:  make(chan time.Duration, 1),
+		rateLimitedLim: rate.NewLimiter(rate.Every(time.Second), 1),
 	}
 
-	if c.canMesh {
+	c.canFederate = !c.canMesh && s.isFederatedPeer(clientKey)
+	if c.canMesh || c.canFederate {
 		c.meshUpdate = make(chan struct{})
 	}
 	if clientInfo != nil {
@@ -836,7 +917,7 @@ func (c *sclient) handleFrameWatchConns(ft frameType, fl uint32) error {
 	if fl != 0 {
 		return fmt.Errorf("handleFrameWatchConns wrong size")
 	}
-	if !c.canMesh {
+	if !c.canMesh && !c.canFederate {
 		return fmt.Errorf("insufficient permissions")
 	}
 	c.s.addWatcher(c)
@@ -905,7 +986,7 @@ func (c *sclient) handleFrameClosePeer(ft frameType, fl uint32) error {
 // handleFrameForwardPacket reads a "forward packet" frame from the client
 // (which must be a trusted client, a peer in our mesh).
 func (c *sclient) handleFrameForwardPacket(ft frameType, fl uint32) error {
-	if !c.canMesh {
+	if !c.canMesh && !c.canFederate {
 		return fmt.Errorf("insufficient permissions")
 	}
 	s := c.s
@@ -914,7 +995,12 @@ func (c *sclient) handleFrameForwardPacket(ft frameType, fl uint32) error {
 	if err != nil {
 		return fmt.Errorf("client %x: recvForwardPacket: %v", c.key, err)
 	}
+	if c.canFederate && !s.isFederatedClient(srcKey, c.key) {
+		return fmt.Errorf("federated peer %x forwarded a packet from %x, which isn't its client", c.key, srcKey)
+	}
 	s.packetsForwardedIn.Add(1)
+	c.packetsRecv.Add(1)
+	c.bytesRecv.Add(int64(len(contents)))
 
 	var dstLen int
 	var dst *sclient
@@ -963,12 +1049,23 @@ func (s *Server) notePeerSendLocked(src key.NodePublic, dst *sclient) {
 
 // handleFrameSendPacket reads a "send packet" frame from the client.
 func (c *sclient) handleFrameSendPacket(ft frameType, fl uint32) error {
+	if c.canFederate {
+		return fmt.Errorf("federated peer %x can't send packets as a client", c.key)
+	}
 	s := c.s
 
 	dstKey, contents, err := s.recvPacket(c.br, fl)
 	if err != nil {
 		return fmt.Errorf("client %x: recvPacket: %v", c.key, err)
 	}
+	c.packetsRecv.Add(1)
+	c.bytesRecv.Add(int64(len(contents)))
+	if retryAfter, ok := c.allowSend(frameHeaderLen + int(fl)); !ok {
+		c.packetsRateLimited.Add(1)
+		s.recordDrop(contents, c.key, dstKey, dropReasonRateLimited)
+		c.requestRateLimitedWriteLimited(retryAfter)
+		return nil
+	}
 
 	var fwd PacketForwarder
 	var dstLen int
@@ -1036,6 +1133,7 @@ func (c *sclient) debugLogf(format string, v ...any) {
 	dropReasonQueueTail                          // destination queue is full, dropped packet at queue tail
 	dropReasonWriteError                         // OS write() failed
 	dropReasonDupClient                          // the public key is connected 2+ times (active/active, fighting)
+	dropReasonRateLimited                        // the sender is over its or the region's rate limit
 )
 
 func (s *Server) recordDrop(packetBytes []byte, srcKey, dstKey key.NodePublic, reason dropReason) {
@@ -1072,6 +1170,7 @@ func (c *sclient) sendPkt(dst *sclient, p pkt) error {
 	for attempt := 0; attempt < 3; attempt++ {
 		select {
 		case <-dst.done:
+			dst.packetsDropped.Add(1)
 			s.recordDrop(p.bs, c.key, dstKey, dropReasonGoneDisconnected)
 			dst.debugLogf("sendPkt attempt %d dropped, dst gone", attempt)
 			return nil
@@ -1086,6 +1185,7 @@ func (c *sclient) sendPkt(dst *sclient, p pkt) error {
 
 		select {
 		case pkt := <-sendQueue:
+			dst.packetsDropped.Add(1)
 			s.recordDrop(pkt.bs, c.key, dstKey, dropReasonQueueHead)
 			c.recordQueueTime(pkt.enqueuedAt)
 		default:
@@ -1094,6 +1194,7 @@ func (c *sclient) sendPkt(dst *sclient, p pkt) error {
 	// Failed to make room for packet. This can happen in a heavily
 	// contended queue with racing writers. Give up and tail-drop in
 	// this case to keep reader unblocked.
+	dst.packetsDropped.Add(1)
 	s.recordDrop(p.bs, c.key, dstKey, dropReasonQueueTail)
 	dst.debugLogf("sendPkt attempt %d dropped, queue full")
 
@@ -1114,7 +1215,7 @@ func (c *sclient) requestPeerGoneWrite(peer key.NodePublic, reason PeerGoneReaso
 }
 
 func (c *sclient) requestMeshUpdate() {
-	if !c.canMesh {
+	if !c.canMesh && !c.canFederate {
 		panic("unexpected requestMeshUpdate")
 	}
 	select {
@@ -1124,7 +1225,7 @@ func (c *sclient) requestMeshUpdate() {
 }
 
 func (s *Server) verifyClient(clientKey key.NodePublic, info *clientInfo) error {
-	if !s.verifyClients {
+	if !s.verifyClients || s.isFederatedPeer(clientKey) {
 		return nil
 	}
 	status, err := tailscale.Status(context.TODO())
@@ -1209,7 +1310,12 @@ type serverInfo struct {
 }
 
 func (s *Server) sendServerInfo(bw *lazyBufioWriter, clientKey key.NodePublic) error {
-	msg, err := json.Marshal(serverInfo{Version: ProtocolVersion})
+	si := serverInfo{Version: ProtocolVersion}
+	if rl := s.rateLimiters.Load(); rl != nil && rl.limits.ClientBytesPerSec > 0 {
+		si.TokenBucketBytesPerSecond = rl.limits.ClientBytesPerSec
+		si.TokenBucketBytesBurst = byteBurst(rl.limits.ClientBytesPerSec)
+	}
+	msg, err := json.Marshal(si)
 	if err != nil {
 		return err
 	}
@@ -1322,23 +1428,34 @@ type sclient struct {
 	key            key.NodePublic
 	info           clientInfo
 	logf           logger.Logf
-	done           <-chan struct{}  // closed when connection closes
-	remoteAddr     string           // usually ip:port from net.Conn.RemoteAddr().String()
-	remoteIPPort   netip.AddrPort   // zero if remoteAddr is not ip:port.
-	sendQueue      chan pkt         // packets queued to this client; never closed
-	discoSendQueue chan pkt         // important packets queued to this client; never closed
-	sendPongCh     chan [8]byte     // pong replies to send to the client; never closed
-	peerGone       chan peerGoneMsg // write request that a peer is not at this server (not used by mesh peers)
-	meshUpdate     chan struct{}    // write request to write peerStateChange
-	canMesh        bool             // clientInfo had correct mesh token for inter-region routing
-	isDup          atomic.Bool      // whether more than 1 sclient for key is connected
-	isDisabled     atomic.Bool      // whether sends to this peer are disabled due to active/active dups
-	debug          bool             // turn on for verbose logging
+	done           <-chan struct{}    // closed when connection closes
+	remoteAddr     string             // usually ip:port from net.Conn.RemoteAddr().String()
+	remoteIPPort   netip.AddrPort     // zero if remoteAddr is not ip:port.
+	sendQueue      chan pkt           // packets queued to this client; never closed
+	discoSendQueue chan pkt           // important packets queued to this client; never closed
+	sendPongCh     chan [8]byte       // pong replies to send to the client; never closed
+	peerGone       chan peerGoneMsg   // write request that a peer is not at this server (not used by mesh peers)
+	rateLimitedCh  chan time.Duration // write request that the client is rate limited, with how long to back off
+	meshUpdate     chan struct{}      // write request to write peerStateChange
+	canMesh        bool               // clientInfo had correct mesh token for inter-region routing
+	canFederate    bool               // a federated peer without the mesh token; see SetFederatedPeers
+	isDup          atomic.Bool        // whether more than 1 sclient for key is connected
+	isDisabled     atomic.Bool        // whether sends to this peer are disabled due to active/active dups
+	debug          bool               // turn on for verbose logging
+
+	// Accounting, for ClientStats.
+	packetsRecv, bytesRecv atomic.Int64 // packets from the client
+	packetsSent, bytesSent atomic.Int64 // packets to the client
+	packetsDropped         atomic.Int64 // packets to the client that were dropped
+	packetsRateLimited     atomic.Int64 // packets from the client dropped by rate limits
 
 	// Owned by run, not thread-safe.
-	br          *bufio.Reader
-	connectedAt time.Time
-	preferred   bool
+	br           *bufio.Reader
+	connectedAt  time.Time
+	preferred    bool
+	rateLimitsOf *rateLimiters // the server limits pktLim and byteLim were made for
+	pktLim       *rate.Limiter // or nil if unlimited
+	byteLim      *rate.Limiter // or nil if unlimited
 
 	// Owned by sender, not thread-safe.
 	bw *lazyBufioWriter
@@ -1351,10 +1468,19 @@ type sclient struct {
 	// to this node.
 	peerStateChange []peerConnState
 
+	// peerStateChangeSince is when the oldest of peerStateChange
+	// was queued, or the zero time if it's empty. It's how far
+	// behind the mesh peer is.
+	peerStateChangeSince time.Time
+
 	// peerGoneLimiter limits how often the server will inform a
 	// client that it's trying to establish a direct connection
 	// through us with a peer we have no record of.
 	peerGoneLim *rate.Limiter
+
+	// rateLimitedLim limits how often the server will tell a
+	// client that it's being rate limited.
+	rateLimitedLim *rate.Limiter
 }
 
 // peerConnState represents whether a peer is connected to the server
@@ -1439,8 +1565,10 @@ func (c *sclient) sendLoop(ctx context.Context) error {
 		for {
 			select {
 			case pkt := <-c.sendQueue:
+				c.packetsDropped.Add(1)
 				c.s.recordDrop(pkt.bs, pkt.src, c.key, dropReasonGoneDisconnected)
 			case pkt := <-c.discoSendQueue:
+				c.packetsDropped.Add(1)
 				c.s.recordDrop(pkt.bs, pkt.src, c.key, dropReasonGoneDisconnected)
 			default:
 				return
@@ -1465,6 +1593,9 @@ func (c *sclient) sendLoop(ctx context.Context) error {
 		case msg := <-c.peerGone:
 			werr = c.sendPeerGone(msg.peer, msg.reason)
 			continue
+		case d := <-c.rateLimitedCh:
+			werr = c.sendRateLimited(d)
+			continue
 		case <-c.meshUpdate:
 			werr = c.sendMeshUpdates()
 			continue
@@ -1496,6 +1627,8 @@ func (c *sclient) sendLoop(ctx context.Context) error {
 			return nil
 		case msg := <-c.peerGone:
 			werr = c.sendPeerGone(msg.peer, msg.reason)
+		case d := <-c.rateLimitedCh:
+			werr = c.sendRateLimited(d)
 		case <-c.meshUpdate:
 			werr = c.sendMeshUpdates()
 			continue
@@ -1558,11 +1691,19 @@ func (c *sclient) sendPeerGone(peer key.NodePublic, reason PeerGoneReasonType) e
 // sendPeerPresent sends a peerPresent frame, without flushing.
 func (c *sclient) sendPeerPresent(peer key.NodePublic, ipPort netip.AddrPort) error {
 	c.setWriteDeadline()
-	const frameLen = keyLen + 16 + 2
-	if err := writeFrameHeader(c.bw.bw(), framePeerPresent, frameLen); err != nil {
+	frameLen := keyLen + 16 + 2
+	if !ipPort.IsValid() {
+		frameLen = keyLen // hidden from the watcher; see watchedIPPort
+	}
+	if err := writeFrameHeader(c.bw.bw(), framePeerPresent, uint32(frameLen)); err != nil {
 		return err
 	}
 	payload := make([]byte, frameLen)
+	if !ipPort.IsValid() {
+		_ = peer.AppendTo(payload[:0])
+		_, err := c.bw.Write(payload)
+		return err
+	}
 	_ = peer.AppendTo(payload[:0])
 	a16 := ipPort.Addr().As16()
 	copy(payload[keyLen:], a16[:])
@@ -1604,6 +1745,7 @@ func (c *sclient) sendMeshUpdates() error {
 
 	// Did we manage to write them all into the bufio buffer without flushing?
 	if len(c.peerStateChange) == 0 {
+		c.peerStateChangeSince = time.Time{}
 		if cap(c.peerStateChange) > 16 {
 			c.peerStateChange = nil
 		}
@@ -1623,8 +1765,11 @@ func (c *sclient) sendPacket(srcKey key.NodePublic, contents []byte) (err error)
 	defer func() {
 		// Stats update.
 		if err != nil {
+			c.packetsDropped.Add(1)
 			c.s.recordDrop(contents, srcKey, c.key, dropReasonWriteError)
 		} else {
+			c.packetsSent.Add(1)
+			c.bytesSent.Add(int64(len(contents)))
 			c.s.packetsSent.Add(1)
 			c.s.bytesSent.Add(int64(len(contents)))
 		}
@@ -1831,6 +1976,7 @@ func (s *Server) ExpVar() expvar.Var {
 	m.Set("sent_pong", &s.sentPong)
 	m.Set("peer_gone_disconnected_frames", &s.peerGoneDisconnectedFrames)
 	m.Set("peer_gone_not_here_frames", &s.peerGoneNotHereFrames)
+	m.Set("rate_limited_frames", &s.rateLimitedFrames)
 	m.Set("packets_forwarded_out", &s.packetsForwardedOut)
 	m.Set("packets_forwarded_in", &s.packetsForwardedIn)
 	m.Set("multiforwarder_created", &s.multiForwarderCreated)
@@ -1840,6 +1986,7 @@ func (s *Server) ExpVar() expvar.Var {
 		return math.Float64frombits(atomic.LoadUint64(s.avgQueueDuration))
 	}))
 	m.Set("counter_tcp_rtt", &s.tcpRtt)
+	m.Set("gauge_mesh_peer_max_lag_seconds", s.expVarFunc(func() any { return s.maxMeshLagLocked().Seconds() }))
 	var expvarVersion expvar.String
 	expvarVersion.Set(version.Long())
 	m.Set("version", &expvarVersion)
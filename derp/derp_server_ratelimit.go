@@ -0,0 +1,142 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derp
+
+import (
+	"time"
+
+	"tailscale.com/tstime/rate"
+)
+
+// RateLimits are limits on the packets clients send through a Server, so
+// that a server on a small machine can protect itself from a single
+// chatty client. Packets over a limit are dropped and the client is sent
+// a frame saying so, like an HTTP 429.
+//
+// Zero values mean no limit. Mesh peers are never limited.
+type RateLimits struct {
+	// ClientPacketsPerSec and ClientBytesPerSec limit each client
+	// connection. Bytes include DERP framing.
+	ClientPacketsPerSec int `json:",omitempty"`
+	ClientBytesPerSec   int `json:",omitempty"`
+
+	// RegionPacketsPerSec and RegionBytesPerSec limit all of the
+	// server's clients together: the whole region, for a region
+	// served by a single server.
+	RegionPacketsPerSec int `json:",omitempty"`
+	RegionBytesPerSec   int `json:",omitempty"`
+}
+
+// rateLimiters are the server-wide state for a RateLimits.
+type rateLimiters struct {
+	limits        RateLimits
+	regionPackets *rate.Limiter // or nil if unlimited
+	regionBytes   *rate.Limiter // or nil if unlimited
+}
+
+// SetRateLimits sets the limits on the packets clients send. It may be
+// called at any time: connected clients are held to the new limits from
+// their next packet on, and told the new client byte rate when they're
+// next rate limited.
+func (s *Server) SetRateLimits(l RateLimits) {
+	s.rateLimiters.Store(&rateLimiters{
+		limits:        l,
+		regionPackets: newPacketLimiter(l.RegionPacketsPerSec),
+		regionBytes:   newByteLimiter(l.RegionBytesPerSec),
+	})
+}
+
+// RateLimits returns the limits set by SetRateLimits.
+func (s *Server) RateLimits() RateLimits {
+	if rl := s.rateLimiters.Load(); rl != nil {
+		return rl.limits
+	}
+	return RateLimits{}
+}
+
+// newPacketLimiter returns a limiter of perSec packets per second with a
+// second's worth of burst, or nil if perSec is not positive.
+func newPacketLimiter(perSec int) *rate.Limiter {
+	if perSec <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(perSec), perSec)
+}
+
+// newByteLimiter returns a limiter of perSec bytes per second, or nil if
+// perSec is not positive.
+func newByteLimiter(perSec int) *rate.Limiter {
+	if perSec <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(perSec), byteBurst(perSec))
+}
+
+// byteBurst returns the burst for a limit of perSec bytes per second: a
+// second's worth, but at least one maximum size packet frame.
+func byteBurst(perSec int) int {
+	return max(perSec, frameHeaderLen+keyLen+MaxPacketSize)
+}
+
+// allowSend reports whether c may send a packet frame of n bytes under
+// the server's rate limits. If not, it also returns how long c should
+// wait before sending more.
+func (c *sclient) allowSend(n int) (retryAfter time.Duration, ok bool) {
+	rl := c.s.rateLimiters.Load()
+	if rl == nil || c.canMesh {
+		return 0, true
+	}
+	if c.rateLimitsOf != rl {
+		c.rateLimitsOf = rl
+		c.pktLim = newPacketLimiter(rl.limits.ClientPacketsPerSec)
+		c.byteLim = newByteLimiter(rl.limits.ClientBytesPerSec)
+	}
+	switch {
+	case c.pktLim != nil && !c.pktLim.Allow():
+		return timeToAccrue(1, rl.limits.ClientPacketsPerSec), false
+	case c.byteLim != nil && !c.byteLim.AllowN(n):
+		return timeToAccrue(n, rl.limits.ClientBytesPerSec), false
+	case rl.regionPackets != nil && !rl.regionPackets.Allow():
+		return timeToAccrue(1, rl.limits.RegionPacketsPerSec), false
+	case rl.regionBytes != nil && !rl.regionBytes.AllowN(n):
+		return timeToAccrue(n, rl.limits.RegionBytesPerSec), false
+	}
+	return 0, true
+}
+
+// timeToAccrue returns how long a limiter of perSec tokens per second
+// takes to accrue n tokens.
+func timeToAccrue(n, perSec int) time.Duration {
+	return time.Duration(n) * time.Second / time.Duration(perSec)
+}
+
+// requestRateLimitedWriteLimited sends a request to write a "rate
+// limited" frame, unless one was sent recently.
+func (c *sclient) requestRateLimitedWriteLimited(retryAfter time.Duration) {
+	if !c.rateLimitedLim.Allow() {
+		return
+	}
+	select {
+	case c.rateLimitedCh <- retryAfter:
+	default:
+	}
+}
+
+// sendRateLimited sends a rateLimited frame, without flushing.
+func (c *sclient) sendRateLimited(retryAfter time.Duration) error {
+	c.s.rateLimitedFrames.Add(1)
+	var bytesPerSec int
+	if rl := c.s.rateLimiters.Load(); rl != nil {
+		bytesPerSec = rl.limits.ClientBytesPerSec
+	}
+	c.setWriteDeadline()
+	var b [8]byte
+	bin.PutUint32(b[0:], uint32(max(retryAfter.Milliseconds(), 1)))
+	bin.PutUint32(b[4:], uint32(bytesPerSec))
+	if err := writeFrameHeader(c.bw.bw(), frameRateLimited, uint32(len(b))); err != nil {
+		return err
+	}
+	_, err := c.bw.Write(b[:])
+	return err
+}
@@ -0,0 +1,95 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derp
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"tailscale.com/types/key"
+)
+
+// ClientStats is the accounting of a client connection to a Server, for
+// capacity planning and finding abusive clients.
+type ClientStats struct {
+	Key         key.NodePublic
+	RemoteAddr  string
+	ConnectedAt time.Time
+	Mesh        bool `json:",omitempty"` // whether it's a mesh peer
+	Dup         bool `json:",omitempty"` // whether the key has other connections
+
+	// PacketsRecv and BytesRecv count the packets the client sent
+	// through the server, including ones forwarded by a mesh peer.
+	PacketsRecv int64
+	BytesRecv   int64
+
+	// PacketsSent and BytesSent count the packets the server sent to
+	// the client.
+	PacketsSent int64
+	BytesSent   int64
+
+	// PacketsDropped counts packets to the client that were dropped,
+	// such as because its queue was full.
+	PacketsDropped int64 `json:",omitempty"`
+
+	// PacketsRateLimited counts packets from the client that were
+	// dropped for exceeding the server's RateLimits.
+	PacketsRateLimited int64 `json:",omitempty"`
+
+	// MeshLag, for a mesh peer watching the server's clients, is how
+	// long the oldest peer presence change not yet written to it has
+	// been queued.
+	MeshLag time.Duration `json:",omitempty"`
+}
+
+// ClientStats returns the accounting of each of the server's client
+// connections, busiest first.
+func (s *Server) ClientStats() []ClientStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	var ret []ClientStats
+	for _, cs := range s.clients {
+		cs.ForeachClient(func(c *sclient) {
+			st := ClientStats{
+				Key:                c.key,
+				RemoteAddr:         c.remoteAddr,
+				ConnectedAt:        c.connectedAt,
+				Mesh:               c.canMesh,
+				Dup:                c.isDup.Load(),
+				PacketsRecv:        c.packetsRecv.Load(),
+				BytesRecv:          c.bytesRecv.Load(),
+				PacketsSent:        c.packetsSent.Load(),
+				BytesSent:          c.bytesSent.Load(),
+				PacketsDropped:     c.packetsDropped.Load(),
+				PacketsRateLimited: c.packetsRateLimited.Load(),
+			}
+			if !c.peerStateChangeSince.IsZero() {
+				st.MeshLag = now.Sub(c.peerStateChangeSince)
+			}
+			ret = append(ret, st)
+		})
+	}
+	slices.SortFunc(ret, func(a, b ClientStats) int {
+		if c := cmp.Compare(b.BytesRecv+b.BytesSent, a.BytesRecv+a.BytesSent); c != 0 {
+			return c
+		}
+		return a.ConnectedAt.Compare(b.ConnectedAt)
+	})
+	return ret
+}
+
+// maxMeshLagLocked returns the largest MeshLag of the mesh peers
+// watching the server's clients. s.mu must be held.
+func (s *Server) maxMeshLagLocked() time.Duration {
+	now := s.clock.Now()
+	var lag time.Duration
+	for w := range s.watchers {
+		if !w.peerStateChangeSince.IsZero() {
+			lag = max(lag, now.Sub(w.peerStateChangeSince))
+		}
+	}
+	return lag
+}
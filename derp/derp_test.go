@@ -706,6 +706,105 @@ func TestWatch(t *testing.T) {
 	w3.wantGone(t, c1.pub)
 }
 
+func TestFederatedPeerWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ts := newTestServer(t, ctx)
+	defer ts.close(t)
+
+	var peerKey key.NodePublic
+	newWatcher := func(name string) *testClient {
+		return newTestClient(t, ts, name, func(nc net.Conn, priv key.NodePrivate, logf logger.Logf) (*Client, error) {
+			if name == "federated" {
+				peerKey = priv.Public()
+				ts.s.SetFederatedPeers([]key.NodePublic{peerKey})
+			}
+			brw := bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))
+			c, err := NewClient(priv, nc, brw, logf) // no mesh key
+			if err != nil {
+				return nil, err
+			}
+			waitConnect(t, c)
+			if err := c.WatchConnectionChanges(); err != nil {
+				return nil, err
+			}
+			return c, nil
+		})
+	}
+
+	fed := newWatcher("federated")
+	fed.wantPresent(t, peerKey)
+	c1 := newRegularClient(t, ts, "c1")
+	m, err := fed.c.recvTimeout(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pm, ok := m.(PeerPresentMessage); !ok || pm.Key != c1.pub {
+		t.Fatalf("got %#v; want peer present for c1", m)
+	} else if pm.IPPort.IsValid() {
+		t.Errorf("federated watcher got c1's IP:port %v; want it hidden", pm.IPPort)
+	}
+
+	// c1 isn't the federated peer's client, so it can't forward its
+	// packets.
+	if err := fed.c.ForwardPacket(pubAll(9), c1.pub, []byte("spoofed")); err != nil {
+		t.Fatal(err)
+	}
+	m, err = fed.c.recvTimeout(5 * time.Second)
+	if err == nil {
+		t.Errorf("federated peer got %T after forwarding for a non-client; want connection closed", m)
+	} else if errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("federated peer's connection stayed open after forwarding for a non-client")
+	}
+
+	other := newWatcher("other")
+	m, err = other.c.recvTimeout(5 * time.Second)
+	if err == nil {
+		t.Errorf("non-federated watcher got %T; want connection closed", m)
+	} else if errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("non-federated watcher's connection stayed open")
+	}
+}
+
+type testServerFwd struct {
+	testFwd
+	server key.NodePublic
+}
+
+func (f testServerFwd) ServerPublicKey() key.NodePublic { return f.server }
+
+func TestIsFederatedClient(t *testing.T) {
+	s := &Server{
+		clients:     make(map[key.NodePublic]clientSet),
+		clientsMesh: map[key.NodePublic]PacketForwarder{},
+	}
+	peer, other := pubAll(10), pubAll(11)
+	u1, u2, u3 := pubAll(1), pubAll(2), pubAll(3)
+
+	s.AddPacketForwarder(u1, testServerFwd{testFwd(1), peer})
+	s.AddPacketForwarder(u2, testServerFwd{testFwd(2), other})
+	s.AddPacketForwarder(u2, testServerFwd{testFwd(3), peer}) // now a multiForwarder
+	s.AddPacketForwarder(u3, testFwd(4))                      // doesn't say its server
+
+	for _, tt := range []struct {
+		src  key.NodePublic
+		want bool
+	}{
+		{u1, true},
+		{u2, true},
+		{u3, false},
+		{pubAll(4), false}, // unknown
+	} {
+		if got := s.isFederatedClient(tt.src, peer); got != tt.want {
+			t.Errorf("isFederatedClient(%v) = %v; want %v", tt.src.ShortString(), got, tt.want)
+		}
+	}
+	if s.isFederatedClient(u1, other) {
+		t.Errorf("isFederatedClient(u1, other) = true; want false")
+	}
+}
+
 type testFwd int
 
 func (testFwd) ForwardPacket(key.NodePublic, key.NodePublic, []byte) error {
@@ -988,6 +1087,15 @@ func TestClientRecv(t *testing.T) {
 				TryFor:      2 * time.Millisecond,
 			},
 		},
+		{
+			name: "rate_limited",
+			input: []byte{
+				byte(frameRateLimited), 0, 0, 0, 8,
+				0, 0, 0, 250,
+				0, 0, 0, 0,
+			},
+			want: RateLimitedMessage{RetryAfter: 250 * time.Millisecond},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1487,6 +1595,156 @@ func TestClientSendRateLimiting(t *testing.T) {
 	}
 }
 
+func TestServerRateLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ts := newTestServer(t, ctx)
+	defer ts.close(t)
+	ts.s.SetRateLimits(RateLimits{ClientPacketsPerSec: 2})
+
+	alice := newRegularClient(t, ts, "alice")
+	bob := newRegularClient(t, ts, "bob")
+	for i := 0; i < 5; i++ {
+		if err := alice.c.Send(bob.pub, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for {
+		m, err := alice.c.recvTimeout(5 * time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m, ok := m.(RateLimitedMessage); ok {
+			if m.RetryAfter != 500*time.Millisecond {
+				t.Errorf("RetryAfter = %v; want 500ms", m.RetryAfter)
+			}
+			break
+		}
+	}
+	var got []byte
+	for len(got) < 2 {
+		m, err := bob.c.recvTimeout(5 * time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p, ok := m.(ReceivedPacket); ok {
+			got = append(got, p.Data...)
+		}
+	}
+	if !bytes.Equal(got, []byte{0, 1}) {
+		t.Errorf("bob got %v; want the first two packets", got)
+	}
+	if n := ts.s.packetsDroppedReasonCounters[dropReasonRateLimited].Value(); n != 3 {
+		t.Errorf("rate limited drops = %d; want 3", n)
+	}
+
+	ts.s.SetRateLimits(RateLimits{})
+	if err := alice.c.Send(bob.pub, []byte{9}); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		m, err := bob.c.recvTimeout(5 * time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p, ok := m.(ReceivedPacket); ok {
+			if !bytes.Equal(p.Data, []byte{9}) {
+				t.Errorf("bob got %v after removing limits; want [9]", p.Data)
+			}
+			return
+		}
+	}
+}
+
+func TestServerClientStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ts := newTestServer(t, ctx)
+	defer ts.close(t)
+	ts.s.SetRateLimits(RateLimits{ClientPacketsPerSec: 2})
+
+	alice := newRegularClient(t, ts, "alice")
+	bob := newRegularClient(t, ts, "bob")
+	w := newTestWatcher(t, ts, "watcher")
+	for i := 0; i < 3; i++ {
+		if err := alice.c.Send(bob.pub, make([]byte, 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for n := 0; n < 2; {
+		m, err := bob.c.recvTimeout(5 * time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := m.(ReceivedPacket); ok {
+			n++
+		}
+	}
+
+	byKey := map[key.NodePublic]ClientStats{}
+	var first key.NodePublic
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		stats := ts.s.ClientStats()
+		for _, st := range stats {
+			byKey[st.Key] = st
+		}
+		if len(stats) == 3 && byKey[alice.pub].PacketsRateLimited == 1 {
+			first = stats[0].Key
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("ClientStats = %+v; want 3 clients with alice rate limited", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := byKey[alice.pub]; st.PacketsRecv != 3 || st.BytesRecv != 30 || st.PacketsSent != 0 || st.Mesh {
+		t.Errorf("alice stats = %+v; want 3 packets, 30 bytes received", st)
+	}
+	if st := byKey[bob.pub]; st.PacketsSent != 2 || st.BytesSent != 20 || st.PacketsRecv != 0 {
+		t.Errorf("bob stats = %+v; want 2 packets, 20 bytes sent", st)
+	}
+	if st := byKey[w.pub]; !st.Mesh {
+		t.Errorf("watcher stats = %+v; want Mesh", st)
+	}
+	if first != alice.pub {
+		t.Errorf("busiest client = %v; want alice", ts.keyName(first))
+	}
+}
+
+func TestServerInfoAdvertisesClientByteLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ts := newTestServer(t, ctx)
+	defer ts.close(t)
+	ts.s.SetRateLimits(RateLimits{ClientBytesPerSec: 100 << 10})
+
+	nc, err := net.Dial("tcp", ts.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	brw := bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))
+	c, err := NewClient(key.NewNode(), nc, brw, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := c.recvTimeout(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ServerInfoMessage{
+		TokenBucketBytesPerSecond: 100 << 10,
+		TokenBucketBytesBurst:     100 << 10,
+	}
+	if m != want {
+		t.Errorf("got %#v; want %#v", m, want)
+	}
+}
+
 func TestServerRepliesToPing(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -41,6 +41,7 @@
 	"tailscale.com/tstime"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/cmpx"
 )
 
@@ -88,6 +89,7 @@ type Client struct {
 	connGen      int // incremented once per new connection; valid values are >0
 	serverPubKey key.NodePublic
 	tlsState     *tls.ConnectionState
+	wsFallback   bool                             // whether to connect over a WebSocket, since the DERP upgrade failed
 	pingOut      map[derp.PingMessage]chan<- bool // chan to send to on pong
 	clock        tstime.Clock
 }
@@ -268,17 +270,14 @@ func (c *Client) preferIPv6() bool {
 	return false
 }
 
-// dialWebsocketFunc is non-nil (set by websocket.go's init) when compiled in.
-var dialWebsocketFunc func(ctx context.Context, urlStr string) (net.Conn, error)
+// metricWebsocketFallback counts connections that fell back to a
+// WebSocket because the DERP upgrade failed.
+var metricWebsocketFallback = clientmetric.NewCounter("derphttp_websocket_fallback")
 
+// useWebsockets reports whether to always connect over a WebSocket,
+// rather than only when the DERP upgrade fails.
 func useWebsockets() bool {
-	if runtime.GOOS == "js" {
-		return true
-	}
-	if dialWebsocketFunc != nil {
-		return envknob.Bool("TS_DEBUG_DERP_WS_CLIENT")
-	}
-	return false
+	return runtime.GOOS == "js" || envknob.Bool("TS_DEBUG_DERP_WS_CLIENT")
 }
 
 func (c *Client) connect(ctx context.Context, caller string) (client *derp.Client, connGen int, err error) {
@@ -318,55 +317,90 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 		}
 	}
 
-	var tcpConn net.Conn
-
 	defer func() {
 		if err != nil {
 			if ctx.Err() != nil {
 				err = fmt.Errorf("%v: %v", ctx.Err(), err)
 			}
 			err = fmt.Errorf("%s connect to %v: %v", caller, c.targetString(reg), err)
-			if tcpConn != nil {
-				go tcpConn.Close()
-			}
 		}
 	}()
 
-	var node *tailcfg.DERPNode // nil when using c.url to dial
-	switch {
-	case useWebsockets():
-		var urlStr string
-		if c.url != nil {
-			urlStr = c.url.String()
-		} else {
-			urlStr = c.urlString(reg.Nodes[0])
+	if useWebsockets() || c.wsFallback {
+		client, connGen, err = c.connectWebsocketLocked(ctx, caller, reg)
+		if err == nil || !c.wsFallback {
+			return client, connGen, err
 		}
-		c.logf("%s: connecting websocket to %v", caller, urlStr)
-		conn, err := dialWebsocketFunc(ctx, urlStr)
-		if err != nil {
-			c.logf("%s: websocket to %v error: %v", caller, urlStr, err)
-			return nil, 0, err
+		// The WebSocket fallback stopped working; maybe whatever was
+		// in the way of the DERP upgrade is gone now.
+		c.wsFallback = false
+	}
+	client, connGen, err = c.connectNativeLocked(ctx, caller, reg)
+	var ue upgradeError
+	if errors.As(err, &ue) && ctx.Err() == nil {
+		c.logf("%s: DERP upgrade failed (%v); falling back to WebSocket", caller, ue.err)
+		wsClient, wsConnGen, wsErr := c.connectWebsocketLocked(ctx, caller, reg)
+		if wsErr == nil {
+			c.wsFallback = true
+			metricWebsocketFallback.Add(1)
+			return wsClient, wsConnGen, nil
 		}
-		brw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
-		derpClient, err := derp.NewClient(c.privateKey, conn, brw, c.logf,
-			derp.MeshKey(c.MeshKey),
-			derp.CanAckPings(c.canAckPings),
-			derp.IsProber(c.IsProber),
-		)
-		if err != nil {
+		c.logf("%s: WebSocket fallback failed: %v", caller, wsErr)
+	}
+	return client, connGen, err
+}
+
+// upgradeError is an error in the DERP upgrade of a connection that was
+// established, as opposed to an error dialing it. It's often a
+// middlebox that doesn't allow the upgrade, in which case a WebSocket
+// might get through.
+type upgradeError struct {
+	err error
+}
+
+func (e upgradeError) Error() string { return e.err.Error() }
+func (e upgradeError) Unwrap() error { return e.err }
+
+// connectWebsocketLocked connects to the DERP server over a WebSocket.
+// c.mu must be held.
+func (c *Client) connectWebsocketLocked(ctx context.Context, caller string, reg *tailcfg.DERPRegion) (client *derp.Client, connGen int, err error) {
+	c.logf("%s: connecting websocket to %v", caller, c.targetString(reg))
+	conn, err := c.dialWebsocket(ctx, reg)
+	if err != nil {
+		c.logf("%s: websocket to %v error: %v", caller, c.targetString(reg), err)
+		return nil, 0, err
+	}
+	brw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	derpClient, err := derp.NewClient(c.privateKey, conn, brw, c.logf,
+		derp.MeshKey(c.MeshKey),
+		derp.CanAckPings(c.canAckPings),
+		derp.IsProber(c.IsProber),
+	)
+	if err != nil {
+		go conn.Close()
+		return nil, 0, err
+	}
+	if c.preferred {
+		if err := derpClient.NotePreferred(true); err != nil {
+			go conn.Close()
 			return nil, 0, err
 		}
-		if c.preferred {
-			if err := derpClient.NotePreferred(true); err != nil {
-				go conn.Close()
-				return nil, 0, err
-			}
-		}
-		c.serverPubKey = derpClient.ServerPublicKey()
-		c.client = derpClient
-		c.netConn = conn
-		c.connGen++
-		return c.client, c.connGen, nil
+	}
+	c.serverPubKey = derpClient.ServerPublicKey()
+	c.client = derpClient
+	c.netConn = conn
+	c.tlsState = nil
+	c.connGen++
+	return c.client, c.connGen, nil
+}
+
+// connectNativeLocked connects to the DERP server by upgrading an HTTP
+// request to the DERP protocol. Errors after the connection is
+// established are upgradeErrors. c.mu must be held.
+func (c *Client) connectNativeLocked(ctx context.Context, caller string, reg *tailcfg.DERPRegion) (client *derp.Client, connGen int, err error) {
+	var tcpConn net.Conn
+	var node *tailcfg.DERPNode // nil when using c.url to dial
+	switch {
 	case c.url != nil:
 		c.logf("%s: connecting to %v", caller, c.url)
 		tcpConn, err = c.dialURL(ctx)
@@ -377,6 +411,12 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 	if err != nil {
 		return nil, 0, err
 	}
+	defer func() {
+		if err != nil {
+			err = upgradeError{err}
+			go tcpConn.Close()
+		}
+	}()
 
 	// Now that we have a TCP connection, force close it if the
 	// TLS handshake + DERP setup takes too long.
@@ -567,6 +607,12 @@ func (c *Client) dialRegion(ctx context.Context, reg *tailcfg.DERPRegion) (net.C
 }
 
 func (c *Client) tlsClient(nc net.Conn, node *tailcfg.DERPNode) *tls.Conn {
+	return tls.Client(nc, c.tlsConfig(node))
+}
+
+// tlsConfig returns the TLS config for connecting to node, or to c.url
+// if node is nil.
+func (c *Client) tlsConfig(node *tailcfg.DERPNode) *tls.Config {
 	tlsConf := tlsdial.Config(c.tlsServerName(node), c.TLSConfig)
 	if node != nil {
 		if node.InsecureForTests {
@@ -577,7 +623,7 @@ func (c *Client) tlsClient(nc net.Conn, node *tailcfg.DERPNode) *tls.Conn {
 			tlsdial.SetConfigExpectedCert(tlsConf, node.CertName)
 		}
 	}
-	return tls.Client(nc, tlsConf)
+	return tlsConf
 }
 
 // DialRegionTLS returns a TLS connection to a DERP node in the given region.
@@ -692,13 +738,18 @@ type res struct {
 			}
 		}()
 	}
-	if shouldDialProto(n.IPv4, netip.Addr.Is4) {
+	// On IPv6-only networks, dialing IPv4 can only fail, so don't.
+	v6Only := c.netMon != nil && c.netMon.InterfaceState().IsV6Only()
+	if shouldDialProto(n.IPv4, netip.Addr.Is4) && !v6Only {
 		startDial(n.IPv4, "tcp4")
 	}
 	if shouldDialProto(n.IPv6, netip.Addr.Is6) {
 		startDial(n.IPv6, "tcp6")
 	}
 	if nwait == 0 {
+		if v6Only {
+			return nil, errors.New("IPv6 is explicitly disabled for node and the network is IPv6-only")
+		}
 		return nil, errors.New("both IPv4 and IPv6 are explicitly disabled for node")
 	}
 
@@ -0,0 +1,107 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derphttp
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RaceResult is the outcome of one attempt in a RaceConnect.
+type RaceResult struct {
+	// Started is whether the attempt was started before the race
+	// ended.
+	Started bool `json:",omitempty"`
+
+	// Latency is how long connecting took, if it finished.
+	Latency time.Duration `json:",omitempty"`
+
+	// Err is why connecting failed, if it did.
+	Err string `json:",omitempty"`
+
+	// Won is whether this attempt connected first.
+	Won bool `json:",omitempty"`
+}
+
+// RaceConnect connects n DERP clients in order of preference, like happy
+// eyeballs: the first attempt starts right away, and each next one
+// starts after stagger, or as soon as all started attempts have failed.
+// It returns the index of the first client to connect.
+//
+// client returns the i'th client to connect. It's called when that
+// attempt starts and may return nil if the client isn't available.
+// Attempts still in flight when RaceConnect returns keep going, so that
+// losers may end up connected as warm standbys; attempts that weren't
+// started never are.
+//
+// The returned results are indexed like the clients, and are a snapshot
+// as of when RaceConnect returned. If no client connects, RaceConnect
+// returns the first error.
+func RaceConnect(ctx context.Context, n int, stagger time.Duration, client func(i int) *Client) (winner int, results []RaceResult, err error) {
+	if n == 0 {
+		return -1, nil, errors.New("no DERP clients to race")
+	}
+	type attempt struct {
+		i       int
+		latency time.Duration
+		err     error
+	}
+	doneCh := make(chan attempt, n) // buffered so losers never block
+	results = make([]RaceResult, n)
+	next, inFlight := 0, 0
+	start := func() {
+		i := next
+		next++
+		inFlight++
+		results[i].Started = true
+		dc := client(i)
+		if dc == nil {
+			doneCh <- attempt{i: i, err: errors.New("client unavailable")}
+			return
+		}
+		go func() {
+			t0 := time.Now()
+			err := dc.Connect(ctx)
+			doneCh <- attempt{i: i, latency: time.Since(t0), err: err}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(stagger)
+	defer timer.Stop()
+	var firstErr error
+	for {
+		select {
+		case <-ctx.Done():
+			return -1, results, ctx.Err()
+		case <-timer.C:
+			if next < n {
+				start()
+				timer.Reset(stagger)
+			}
+		case a := <-doneCh:
+			inFlight--
+			results[a.i].Latency = a.latency
+			if a.err == nil {
+				results[a.i].Won = true
+				return a.i, results, nil
+			}
+			results[a.i].Err = a.err.Error()
+			if firstErr == nil {
+				firstErr = a.err
+			}
+			if inFlight == 0 {
+				if next == n {
+					return -1, results, firstErr
+				}
+				start()
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(stagger)
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derphttp
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/types/key"
+)
+
+func TestRaceConnect(t *testing.T) {
+	s := derp.NewServer(key.NewNode(), t.Logf)
+	defer s.Close()
+	httpsrv := &http.Server{
+		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
+		Handler:      Handler(s),
+	}
+	ln, err := net.Listen("tcp4", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go httpsrv.Serve(ln)
+	defer httpsrv.Close()
+
+	// A server that's down: nothing listening.
+	dead, err := net.Listen("tcp4", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead.Close()
+
+	// A server that's slow: it accepts connections but never replies.
+	hung, err := net.Listen("tcp4", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hung.Close()
+
+	urls := []string{
+		"", // client unavailable
+		"http://" + dead.Addr().String(),
+		"http://" + hung.Addr().String(),
+		"http://" + ln.Addr().String(),
+	}
+	var clients []*Client
+	defer func() {
+		for _, c := range clients {
+			c.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	winner, results, err := RaceConnect(ctx, len(urls), 50*time.Millisecond, func(i int) *Client {
+		if urls[i] == "" {
+			return nil
+		}
+		c, err := NewClient(key.NewNode(), urls[i], t.Logf)
+		if err != nil {
+			t.Errorf("NewClient: %v", err)
+			return nil
+		}
+		clients = append(clients, c)
+		return c
+	})
+	if err != nil {
+		t.Fatalf("RaceConnect: %v", err)
+	}
+	if winner != 3 {
+		t.Fatalf("winner = %d; want 3; results: %+v", winner, results)
+	}
+	for i, r := range results {
+		if !r.Started {
+			t.Errorf("attempt %d not started", i)
+		}
+	}
+	if results[0].Err == "" || results[1].Err == "" {
+		t.Errorf("unavailable and down clients didn't fail: %+v", results)
+	}
+	if r := results[2]; r.Err != "" || r.Won {
+		t.Errorf("slow client = %+v; want still connecting", r)
+	}
+	if r := results[3]; !r.Won || r.Latency == 0 {
+		t.Errorf("winner = %+v; want won with latency", r)
+	}
+
+	if _, _, err := RaceConnect(ctx, 2, time.Hour, func(i int) *Client { return nil }); err == nil {
+		t.Errorf("RaceConnect with no available clients succeeded")
+	}
+}
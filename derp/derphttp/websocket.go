@@ -1,32 +1,92 @@
 // Copyright (c) Tailscale Inc & AUTHORS
 // SPDX-License-Identifier: BSD-3-Clause
 
-//go:build linux || js
+//go:build !js
 
 package derphttp
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"net"
+	"net/http"
+	"sync/atomic"
 
 	"nhooyr.io/websocket"
 	"tailscale.com/net/wsconn"
+	"tailscale.com/tailcfg"
 )
 
-func init() {
-	dialWebsocketFunc = dialWebsocket
-}
+// dialWebsocket returns a WebSocket connection to the DERP server at
+// c.url or in reg, speaking the "derp" subprotocol.
+//
+// It dials the way the DERP upgrade does, including through an HTTP
+// proxy with its credentials, but offers only HTTP/1.1 via ALPN, so
+// proxies that inspect TLS and only allow web traffic let it through.
+func (c *Client) dialWebsocket(ctx context.Context, reg *tailcfg.DERPRegion) (net.Conn, error) {
+	var nc net.Conn
+	var node *tailcfg.DERPNode // nil when using c.url to dial
+	var err error
+	if c.url != nil {
+		nc, err = c.dialURL(ctx)
+	} else {
+		nc, node, err = c.dialRegion(ctx, reg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.useHTTPS() {
+		tlsConf := c.tlsConfig(node)
+		tlsConf.NextProtos = []string{"http/1.1"}
+		tlsConn := tls.Client(nc, tlsConf)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		nc = tlsConn
+	}
 
-func dialWebsocket(ctx context.Context, urlStr string) (net.Conn, error) {
-	c, res, err := websocket.Dial(ctx, urlStr, &websocket.DialOptions{
+	// Make the WebSocket's one HTTP request over nc.
+	var dialed atomic.Bool
+	dial := func(context.Context, string, string) (net.Conn, error) {
+		if dialed.Swap(true) {
+			return nil, errors.New("websocket already dialed")
+		}
+		return nc, nil
+	}
+	hc := &http.Client{Transport: &http.Transport{
+		DialContext:    dial,
+		DialTLSContext: dial,
+	}}
+	urlStr := c.urlString(node)
+	conn, res, err := websocket.Dial(ctx, urlStr, &websocket.DialOptions{
+		HTTPClient:   hc,
 		Subprotocols: []string{"derp"},
+		// WireGuard messages aren't compressible.
+		CompressionMode: websocket.CompressionDisabled,
 	})
 	if err != nil {
-		log.Printf("websocket Dial: %v, %+v", err, res)
+		nc.Close()
+		if res != nil {
+			return nil, fmt.Errorf("websocket to %v: %v: %v", urlStr, res.Status, err)
+		}
 		return nil, err
 	}
-	log.Printf("websocket: connected to %v", urlStr)
-	netConn := wsconn.NetConn(context.Background(), c, websocket.MessageBinary, urlStr)
-	return netConn, nil
+	return wsNetConn{wsconn.NetConn(context.Background(), conn, websocket.MessageBinary, urlStr), nc}, nil
+}
+
+// wsNetConn is a WebSocket net.Conn whose Close closes the underlying
+// connection right away. Closing the WebSocket itself can block for
+// seconds, such as while closeForReconnect holds Client.mu.
+type wsNetConn struct {
+	net.Conn
+	nc net.Conn // underlying connection
+}
+
+func (c wsNetConn) Close() error {
+	err := c.nc.Close()
+	go c.Conn.Close() // frees the WebSocket's resources, eventually
+	return err
 }
@@ -0,0 +1,35 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derphttp
+
+import (
+	"context"
+	"log"
+	"net"
+
+	"nhooyr.io/websocket"
+	"tailscale.com/net/wsconn"
+	"tailscale.com/tailcfg"
+)
+
+// dialWebsocket returns a WebSocket connection to the DERP server at
+// c.url or in reg, speaking the "derp" subprotocol.
+func (c *Client) dialWebsocket(ctx context.Context, reg *tailcfg.DERPRegion) (net.Conn, error) {
+	var urlStr string
+	if c.url != nil {
+		urlStr = c.url.String()
+	} else {
+		urlStr = c.urlString(reg.Nodes[0])
+	}
+	conn, res, err := websocket.Dial(ctx, urlStr, &websocket.DialOptions{
+		Subprotocols: []string{"derp"},
+	})
+	if err != nil {
+		log.Printf("websocket Dial: %v, %+v", err, res)
+		return nil, err
+	}
+	log.Printf("websocket: connected to %v", urlStr)
+	netConn := wsconn.NetConn(context.Background(), conn, websocket.MessageBinary, urlStr)
+	return netConn, nil
+}
@@ -0,0 +1,94 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derphttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+	"tailscale.com/derp"
+	"tailscale.com/net/wsconn"
+	"tailscale.com/types/key"
+)
+
+func TestWebsocketFallback(t *testing.T) {
+	s := derp.NewServer(key.NewNode(), t.Logf)
+	t.Cleanup(func() { s.Close() }) // after the clients close, so it needn't wait on them
+
+	// Serve DERP like a server behind a middlebox that only lets
+	// WebSockets through.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			http.Error(w, "blocked by proxy", http.StatusForbidden)
+			return
+		}
+		c, err := websocket.Accept(w, r, &websocket.AcceptOptions{Subprotocols: []string{"derp"}})
+		if err != nil {
+			t.Logf("websocket.Accept: %v", err)
+			return
+		}
+		wc := wsconn.NetConn(r.Context(), c, websocket.MessageBinary, r.RemoteAddr)
+		brw := bufio.NewReadWriter(bufio.NewReader(wc), bufio.NewWriter(wc))
+		s.Accept(r.Context(), wc, brw, r.RemoteAddr)
+	}))
+	t.Cleanup(ts.Close)
+
+	newClient := func() *Client {
+		c, err := NewClient(key.NewNode(), ts.URL+"/derp", t.Logf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := c.Connect(ctx); err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+		c.mu.Lock()
+		fellBack := c.wsFallback
+		c.mu.Unlock()
+		if !fellBack {
+			t.Fatalf("connected without falling back to a WebSocket")
+		}
+		waitConnect(t, c)
+		return c
+	}
+	c1, c2 := newClient(), newClient()
+
+	if err := c1.Send(c2.SelfPublicKey(), []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		m, err := c2.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p, ok := m.(derp.ReceivedPacket); ok {
+			if !bytes.Equal(p.Data, []byte("hello")) || p.Source != c1.SelfPublicKey() {
+				t.Errorf("got %q from %v", p.Data, p.Source.ShortString())
+			}
+			break
+		}
+	}
+
+	// Reconnecting goes straight to the WebSocket.
+	before := metricWebsocketFallback.Value()
+	c1.mu.Lock()
+	dc := c1.client
+	c1.mu.Unlock()
+	c1.closeForReconnect(dc)
+	if _, _, err := c1.connect(context.Background(), "test"); err != nil {
+		t.Fatalf("reconnect: %v", err)
+	}
+	if got := metricWebsocketFallback.Value(); got != before {
+		t.Errorf("reconnect fell back again; fallbacks %d -> %d", before, got)
+	}
+}
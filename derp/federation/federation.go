@@ -0,0 +1,184 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package federation lets DERP relays run by different organizations
+// share relay capacity.
+//
+// An organization describes each region it offers in a Descriptor,
+// signed with its ed25519 key. Federated DERP servers fetch each other's
+// signed descriptors, check them against an allowlist of organizations
+// (tailcfg.DERPFederationSigner), and mesh with the servers they
+// describe. Clients learn the regions from the URLs and signers in
+// tailcfg.DERPMap.Federation.
+//
+// Meshing with a server lets it forward packets on behalf of any client,
+// so only allowlist organizations you'd mesh with yourself.
+package federation
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+// Descriptor describes a DERP region offered for federation by the
+// organization running it.
+type Descriptor struct {
+	// Operator is the name of the organization running the region.
+	Operator string
+
+	// Region is the region, as clients should use it.
+	Region *tailcfg.DERPRegion
+
+	// ServerKeys are the public keys of the region's DERP servers.
+	// Federated DERP servers accept mesh connections from them.
+	ServerKeys []key.NodePublic `json:",omitempty"`
+
+	// Expires is when the descriptor stops being valid.
+	Expires time.Time
+}
+
+// Signed is a Descriptor signed by its operator.
+type Signed struct {
+	// Descriptor is the JSON-encoded Descriptor.
+	Descriptor []byte
+
+	// Signature is the operator's ed25519 signature of Descriptor.
+	Signature []byte
+}
+
+// Sign signs d with the operator's private key priv.
+func Sign(d *Descriptor, priv ed25519.PrivateKey) (*Signed, error) {
+	j, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return &Signed{
+		Descriptor: j,
+		Signature:  ed25519.Sign(priv, j),
+	}, nil
+}
+
+// Verify returns the descriptor s signed, if it's signed by one of
+// signers, names that signer as its Operator, describes a usable region,
+// and hasn't expired as of now.
+func (s *Signed) Verify(signers []*tailcfg.DERPFederationSigner, now time.Time) (*Descriptor, error) {
+	d := new(Descriptor)
+	if err := json.Unmarshal(s.Descriptor, d); err != nil {
+		return nil, fmt.Errorf("decoding descriptor: %w", err)
+	}
+	if !signedBy(s, d.Operator, signers) {
+		return nil, fmt.Errorf("descriptor from %q not signed by an allowed signer", d.Operator)
+	}
+	if !now.Before(d.Expires) {
+		return nil, fmt.Errorf("descriptor from %q expired at %v", d.Operator, d.Expires)
+	}
+	r := d.Region
+	if r == nil || r.RegionID <= 0 || len(r.Nodes) == 0 {
+		return nil, fmt.Errorf("descriptor from %q has no usable region", d.Operator)
+	}
+	for _, n := range r.Nodes {
+		if n == nil || n.RegionID != r.RegionID || n.HostName == "" {
+			return nil, fmt.Errorf("descriptor from %q has bad node in region %d", d.Operator, r.RegionID)
+		}
+	}
+	return d, nil
+}
+
+// signedBy reports whether s is signed by one of the signers for
+// operator.
+func signedBy(s *Signed, operator string, signers []*tailcfg.DERPFederationSigner) bool {
+	for _, si := range signers {
+		if si.Operator != operator || len(si.Key) != ed25519.PublicKeySize {
+			continue
+		}
+		if ed25519.Verify(ed25519.PublicKey(si.Key), s.Descriptor, s.Signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxFetchSize is the most Fetch reads from a URL.
+const maxFetchSize = 1 << 20
+
+// Fetch gets the JSON array of signed descriptors at url, as served by
+// derper at /derp/federation. The descriptors are not verified.
+func Fetch(ctx context.Context, c *http.Client, url string) ([]*Signed, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, res.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(res.Body, maxFetchSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxFetchSize {
+		return nil, fmt.Errorf("fetching %s: response too large", url)
+	}
+	var signed []*Signed
+	if err := json.Unmarshal(b, &signed); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", url, err)
+	}
+	return signed, nil
+}
+
+// VerifyAll returns the descriptors in signed that verify against
+// signers as of now, and the signed descriptors they came from. The
+// error, if non-nil, says why the others didn't.
+func VerifyAll(signed []*Signed, signers []*tailcfg.DERPFederationSigner, now time.Time) (descs []*Descriptor, valid []*Signed, err error) {
+	var errs []error
+	for _, s := range signed {
+		if s == nil {
+			continue
+		}
+		d, err := s.Verify(signers, now)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		descs = append(descs, d)
+		valid = append(valid, s)
+	}
+	return descs, valid, errors.Join(errs...)
+}
+
+// AddRegions returns dm with the regions of descs added, except those
+// whose RegionIDs are already in use; the first descriptor of a RegionID
+// wins. dm is not modified: if any regions are added, the result is a
+// copy.
+func AddRegions(dm *tailcfg.DERPMap, descs []*Descriptor) *tailcfg.DERPMap {
+	if dm == nil {
+		return nil
+	}
+	ret := dm
+	for _, d := range descs {
+		if _, ok := ret.Regions[d.Region.RegionID]; ok {
+			continue
+		}
+		if ret == dm {
+			ret = dm.Clone()
+			if ret.Regions == nil {
+				ret.Regions = map[int]*tailcfg.DERPRegion{}
+			}
+		}
+		ret.Regions[d.Region.RegionID] = d.Region.Clone()
+	}
+	return ret
+}
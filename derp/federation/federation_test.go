@@ -0,0 +1,139 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package federation
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func testRegion(id int) *tailcfg.DERPRegion {
+	return &tailcfg.DERPRegion{
+		RegionID:   id,
+		RegionCode: "test",
+		Nodes: []*tailcfg.DERPNode{{
+			Name:     "1a",
+			RegionID: id,
+			HostName: "derp.example.com",
+		}},
+	}
+}
+
+func mustSign(t *testing.T, d *Descriptor, priv ed25519.PrivateKey) *Signed {
+	t.Helper()
+	s, err := Sign(d, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestVerify(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signers := []*tailcfg.DERPFederationSigner{{Operator: "example", Key: pub}}
+	now := time.Now()
+	good := &Descriptor{
+		Operator:   "example",
+		Region:     testRegion(950),
+		ServerKeys: []key.NodePublic{key.NewNode().Public()},
+		Expires:    now.Add(time.Hour),
+	}
+
+	tests := []struct {
+		name    string
+		signed  *Signed
+		wantErr string
+	}{
+		{"good", mustSign(t, good, priv), ""},
+		{"wrong-key", mustSign(t, good, otherPriv), "not signed by an allowed signer"},
+		{"wrong-operator", mustSign(t, &Descriptor{Operator: "other", Region: good.Region, Expires: good.Expires}, priv), "not signed by an allowed signer"},
+		{"expired", mustSign(t, &Descriptor{Operator: "example", Region: good.Region, Expires: now}, priv), "expired"},
+		{"no-region", mustSign(t, &Descriptor{Operator: "example", Expires: good.Expires}, priv), "no usable region"},
+		{"bad-node", mustSign(t, &Descriptor{Operator: "example", Region: &tailcfg.DERPRegion{
+			RegionID: 950,
+			Nodes:    []*tailcfg.DERPNode{{RegionID: 951, HostName: "derp.example.com"}},
+		}, Expires: good.Expires}, priv), "bad node"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := tt.signed.Verify(signers, now)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Verify: %v", err)
+				}
+				if d.Region.RegionID != 950 || len(d.ServerKeys) != 1 || d.ServerKeys[0] != good.ServerKeys[0] {
+					t.Errorf("Verify = %+v; want %+v", d, good)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Verify error = %v; want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	tampered := mustSign(t, good, priv)
+	tampered.Descriptor = []byte(strings.Replace(string(tampered.Descriptor), "950", "951", -1))
+	if _, err := tampered.Verify(signers, now); err == nil {
+		t.Errorf("tampered descriptor verified")
+	}
+}
+
+func TestFetchAndAddRegions(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signers := []*tailcfg.DERPFederationSigner{{Operator: "example", Key: pub}}
+	exp := time.Now().Add(time.Hour)
+	signed := []*Signed{
+		mustSign(t, &Descriptor{Operator: "example", Region: testRegion(1), Expires: exp}, priv),
+		mustSign(t, &Descriptor{Operator: "example", Region: testRegion(950), Expires: exp}, priv),
+		mustSign(t, &Descriptor{Operator: "example", Region: testRegion(950), Expires: exp.Add(-2 * time.Hour)}, priv),
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(signed)
+	}))
+	defer ts.Close()
+
+	got, err := Fetch(context.Background(), ts.Client(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	descs, valid, err := VerifyAll(got, signers, time.Now())
+	if len(descs) != 2 || len(valid) != 2 {
+		t.Fatalf("verified %d descriptors; want 2", len(descs))
+	}
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("VerifyAll error = %v; want expiry", err)
+	}
+
+	dm := &tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{1: {RegionID: 1, RegionCode: "home"}}}
+	merged := AddRegions(dm, descs)
+	if len(dm.Regions) != 1 {
+		t.Errorf("AddRegions modified its input")
+	}
+	if len(merged.Regions) != 2 || merged.Regions[1].RegionCode != "home" || merged.Regions[950] == nil {
+		t.Errorf("AddRegions = %v; want region 1 kept and 950 added", merged.Regions)
+	}
+	if got := AddRegions(merged, descs); got != merged {
+		t.Errorf("AddRegions copied the map with nothing to add")
+	}
+}
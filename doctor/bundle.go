@@ -0,0 +1,188 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package doctor
+
+import (
+	"archive/tar"
+	"cmp"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The files of a support bundle written by LocalBackend.DoctorBundle.
+const (
+	BundleInfoFile   = "info.json"      // versions, OS and health
+	BundleProbesFile = "probes.json"    // []ProbeResult
+	BundleChecksFile = "checks.txt"     // the logs of the Checks
+	BundleNetmapFile = "netmap.json"    // a redacted summary of the netmap
+	BundleLogsFile   = "tailscaled.log" // recent redacted tailscaled logs
+)
+
+// ProbeResult is the result of a connectivity probe run for a support
+// bundle, such as of reaching the control plane or a DERP region.
+type ProbeResult struct {
+	// Name is what was probed: "control", "dns", "portmapper", or
+	// "derp/<region code>".
+	Name string
+
+	// OK is whether the probe succeeded.
+	OK bool
+
+	// Latency is how long the probe took to succeed, if it did and
+	// that's meaningful.
+	Latency time.Duration `json:",omitempty"`
+
+	// Detail is what the probe found, for humans.
+	Detail string `json:",omitempty"`
+
+	// Error is why the probe failed, if it did.
+	Error string `json:",omitempty"`
+}
+
+// Bundle writes a support bundle, a gzipped tar archive of diagnostic
+// files.
+type Bundle struct {
+	zw  *gzip.Writer
+	tw  *tar.Writer
+	now time.Time
+}
+
+// NewBundle returns a Bundle writing to w, with files modified at now.
+// The caller must call Close when done adding files.
+func NewBundle(w io.Writer, now time.Time) *Bundle {
+	zw := gzip.NewWriter(w)
+	return &Bundle{zw: zw, tw: tar.NewWriter(zw), now: now}
+}
+
+// AddFile adds a file with the given name and contents to the bundle.
+func (b *Bundle) AddFile(name string, data []byte) error {
+	err := b.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(len(data)),
+		Mode:     0644,
+		ModTime:  b.now,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = b.tw.Write(data)
+	return err
+}
+
+// AddJSON adds a file with the given name to the bundle, containing v as
+// indented JSON.
+func (b *Bundle) AddJSON(name string, v any) error {
+	j, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return b.AddFile(name, append(j, '\n'))
+}
+
+// Close finishes writing the bundle. It doesn't close the underlying
+// writer.
+func (b *Bundle) Close() error {
+	return errors.Join(b.tw.Close(), b.zw.Close())
+}
+
+// ReadBundleFile returns the contents of the named file in the support
+// bundle read from r.
+func ReadBundleFile(r io.Reader, name string) ([]byte, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(zr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no %s in bundle", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Name == name {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+var (
+	// ipLikeRx matches what might be an IPv4 or IPv6 address, which
+	// Redactor.Redact checks before redacting.
+	ipLikeRx = regexp.MustCompile(`[0-9]{1,3}(?:\.[0-9]{1,3}){3}|[0-9a-fA-F]{0,4}(?::[0-9a-fA-F]{0,4}){2,7}`)
+
+	// keyRx matches the keys that tailscaled logs in full.
+	keyRx = regexp.MustCompile(`\b(nodekey|discokey|mkey|nlpub):[0-9a-f]{16,64}\b`)
+
+	// emailRx matches email addresses, such as login names.
+	emailRx = regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*`)
+)
+
+// minRedactedNameLen is the length below which names aren't redacted, so
+// that short names such as "a" or "pc" don't garble the rest of the logs.
+const minRedactedNameLen = 3
+
+// A Redactor redacts the IP addresses, keys, email addresses and
+// identities, such as node and DNS names, from logs, so that a support
+// bundle doesn't reveal who a node is or who it talked to. Short key
+// prefixes, such as "[abcde]", are kept so that lines about the same
+// peer can still be related.
+type Redactor struct {
+	names *strings.Replacer // or nil if no names
+}
+
+// NewRedactor returns a Redactor that, in addition to addresses and keys,
+// redacts the given names, such as the DNS names of the node and its
+// peers, and their login names.
+func NewRedactor(names ...string) *Redactor {
+	names = slices.DeleteFunc(slices.Clone(names), func(n string) bool {
+		return len(n) < minRedactedNameLen
+	})
+	if len(names) == 0 {
+		return &Redactor{}
+	}
+	// Longer names first, so that "host.example.ts.net" is redacted as a
+	// whole rather than around "host".
+	slices.SortFunc(names, func(a, b string) int {
+		if c := cmp.Compare(len(b), len(a)); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+	names = slices.Compact(names)
+	oldnew := make([]string, 0, 2*len(names))
+	for _, n := range names {
+		oldnew = append(oldnew, n, "[redacted]")
+	}
+	return &Redactor{names: strings.NewReplacer(oldnew...)}
+}
+
+// Redact returns s, some logs, redacted.
+func (r *Redactor) Redact(s string) string {
+	s = keyRx.ReplaceAllString(s, "$1:redacted")
+	s = emailRx.ReplaceAllString(s, "redacted@redacted")
+	if r.names != nil {
+		s = r.names.Replace(s)
+	}
+	return ipLikeRx.ReplaceAllStringFunc(s, func(m string) string {
+		ip, err := netip.ParseAddr(m)
+		if err != nil {
+			return m
+		}
+		if ip.Is4() {
+			return "x.x.x.x"
+		}
+		return "x:x::x"
+	})
+}
@@ -0,0 +1,65 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package doctor
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestBundle(t *testing.T) {
+	var buf bytes.Buffer
+	b := NewBundle(&buf, time.Unix(1700000000, 0))
+	if err := b.AddFile(BundleLogsFile, []byte("some logs\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddJSON(BundleProbesFile, []ProbeResult{{Name: "control", OK: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadBundleFile(bytes.NewReader(buf.Bytes()), BundleLogsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "some logs\n" {
+		t.Errorf("logs = %q", got)
+	}
+	got, err = ReadBundleFile(bytes.NewReader(buf.Bytes()), BundleProbesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[\n\t{\n\t\t\"Name\": \"control\",\n\t\t\"OK\": true\n\t}\n]\n"; string(got) != want {
+		t.Errorf("probes = %q; want %q", got, want)
+	}
+	if _, err := ReadBundleFile(bytes.NewReader(buf.Bytes()), BundleNetmapFile); err == nil {
+		t.Error("reading missing file succeeded")
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	r := NewRedactor("host.example.ts.net", "host", "Ada Lovelace", "pc")
+	tests := []struct {
+		in, want string
+	}{
+		{"no addresses here", "no addresses here"},
+		{"magicsock: endpoints changed: 203.0.113.5:41641 (stun)", "magicsock: endpoints changed: x.x.x.x:41641 (stun)"},
+		{"route 100.64.0.1/32 via [2001:db8::1]:41641", "route x.x.x.x/32 via [x:x::x]:41641"},
+		{"peer nodekey:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef up", "peer nodekey:redacted up"},
+		{"[abcde] d:1234567890abcdef now using 10.0.0.2:41641", "[abcde] d:1234567890abcdef now using x.x.x.x:41641"},
+		{"2023/11/14 22:13:20 took 12:34:56", "2023/11/14 22:13:20 took 12:34:56"},
+		{"version 1.2.3", "version 1.2.3"},
+		{"active login: ada@example.com", "active login: redacted@redacted"},
+		{"dialing host.example.ts.net (host) for Ada Lovelace", "dialing [redacted] ([redacted]) for [redacted]"},
+		{"pc names are too short to redact", "pc names are too short to redact"},
+	}
+	for _, tt := range tests {
+		if got := r.Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
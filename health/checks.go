@@ -0,0 +1,296 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package health
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"tailscale.com/util/multierr"
+	"tailscale.com/util/set"
+)
+
+// Severity is how serious a failing health check is.
+type Severity int
+
+const (
+	// SeverityInfo is for problems that degrade the node only slightly,
+	// such as port mapping failing.
+	SeverityInfo Severity = iota
+
+	// SeverityWarning is for problems that break a feature or will soon
+	// break one, such as a certificate about to expire.
+	SeverityWarning
+
+	// SeverityError is for problems that break connectivity, such as
+	// being unable to configure routes.
+	SeverityError
+)
+
+var severityNames = []string{
+	SeverityInfo:    "info",
+	SeverityWarning: "warning",
+	SeverityError:   "error",
+}
+
+func (s Severity) String() string {
+	if s >= 0 && int(s) < len(severityNames) {
+		return severityNames[s]
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *Severity) UnmarshalText(b []byte) error {
+	for i, name := range severityNames {
+		if name == string(b) {
+			*s = Severity(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown health severity %q", b)
+}
+
+// Remediation is a hint for fixing a failing health check.
+type Remediation struct {
+	// Code identifies the fix, such as "free-disk-space", for programs
+	// acting on it. Codes don't change between releases.
+	Code string
+
+	// Text describes the fix for humans.
+	Text string
+
+	// URL, if non-empty, is a page with more about the problem.
+	URL string `json:",omitempty"`
+}
+
+// Check is a health check, registered with RegisterCheck.
+type Check struct {
+	// Name uniquely identifies the check, such as "dns".
+	Name string
+
+	// Subsystem is the subsystem the check is about, if any.
+	Subsystem Subsystem
+
+	// Severity is how serious it is when the check fails.
+	Severity Severity
+
+	// Remediation is how to fix the problem when the check fails.
+	Remediation Remediation
+
+	// Run reports the problem, if any. It's called without the health
+	// package's locks held and must not block for long.
+	Run func() error
+}
+
+// CheckResult is the result of running a Check.
+type CheckResult struct {
+	Name      string
+	Subsystem Subsystem `json:",omitempty"`
+	Severity  Severity
+	Healthy   bool
+
+	// Error and Remediation are only set if the check failed.
+	Error       string       `json:",omitempty"`
+	Remediation *Remediation `json:",omitempty"`
+}
+
+// checks are the registered health checks, guarded by mu.
+var checks = set.HandleSet[*Check]{}
+
+// RegisterCheck registers c to be run by RunChecks. The returned func
+// unregisters it.
+func RegisterCheck(c Check) (unregister func()) {
+	if c.Name == "" || c.Run == nil {
+		panic("health: RegisterCheck without Name or Run")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	handle := checks.Add(&c)
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(checks, handle)
+	}
+}
+
+// RunChecks runs the registered health checks and returns their results,
+// failing checks first, most severe first, and otherwise by name.
+func RunChecks() []CheckResult {
+	mu.Lock()
+	cs := make([]*Check, 0, len(checks))
+	for _, c := range checks {
+		cs = append(cs, c)
+	}
+	mu.Unlock()
+
+	res := make([]CheckResult, 0, len(cs))
+	for _, c := range cs {
+		r := CheckResult{
+			Name:      c.Name,
+			Subsystem: c.Subsystem,
+			Severity:  c.Severity,
+			Healthy:   true,
+		}
+		if err := c.Run(); err != nil {
+			r.Healthy = false
+			r.Error = err.Error()
+			rem := c.Remediation
+			r.Remediation = &rem
+		}
+		res = append(res, r)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Healthy != b.Healthy {
+			return !a.Healthy
+		}
+		if a.Severity != b.Severity {
+			return a.Severity > b.Severity
+		}
+		return a.Name < b.Name
+	})
+	return res
+}
+
+// WithCheck returns a WarnableOpt for NewWarnable that also registers the
+// returned Warnable as a health check named name, failing with its error.
+func WithCheck(name string, sev Severity, rem Remediation) WarnableOpt {
+	return warnOptFunc(func(w *Warnable) {
+		w.check = &Check{
+			Name:        name,
+			Severity:    sev,
+			Remediation: rem,
+			Run:         w.get,
+		}
+	})
+}
+
+func init() {
+	sysCheck := func(sys ...Subsystem) func() error {
+		return func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			var errs []error
+			for _, s := range sys {
+				if err := sysErr[s]; err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return multierr.New(errs...)
+		}
+	}
+	for _, c := range []Check{
+		{
+			Name:     "network",
+			Severity: SeverityError,
+			Remediation: Remediation{
+				Code: "connect-network",
+				Text: "Connect this device to a network.",
+			},
+			Run: func() error {
+				mu.Lock()
+				defer mu.Unlock()
+				if !anyInterfaceUp {
+					return errors.New("network down")
+				}
+				return nil
+			},
+		},
+		{
+			Name:      "router",
+			Subsystem: SysRouter,
+			Severity:  SeverityError,
+			Remediation: Remediation{
+				Code: "check-router-config",
+				Text: "Check tailscaled's logs for why it couldn't configure routes or the TUN device.",
+			},
+			Run: sysCheck(SysRouter),
+		},
+		{
+			Name:      "dns",
+			Subsystem: SysDNS,
+			Severity:  SeverityWarning,
+			Remediation: Remediation{
+				Code: "check-dns-config",
+				Text: "Check the OS DNS configuration, or stop Tailscale managing it with 'tailscale set --accept-dns=false'.",
+				URL:  "https://tailscale.com/kb/1188/linux-dns",
+			},
+			Run: sysCheck(SysDNS, SysDNSOS, SysDNSManager),
+		},
+		{
+			Name:      "tailnet-lock",
+			Subsystem: SysTKA,
+			Severity:  SeverityError,
+			Remediation: Remediation{
+				Code: "check-tailnet-lock",
+				Text: "Run 'tailscale lock status' for details.",
+				URL:  "https://tailscale.com/kb/1226/tailnet-lock",
+			},
+			Run: sysCheck(SysTKA),
+		},
+		{
+			Name:     "login",
+			Severity: SeverityError,
+			Remediation: Remediation{
+				Code: "reauthenticate",
+				Text: "Run 'tailscale up' to log in again.",
+			},
+			Run: func() error {
+				mu.Lock()
+				defer mu.Unlock()
+				if lastLoginErr != nil {
+					return fmt.Errorf("last login error: %w", lastLoginErr)
+				}
+				return nil
+			},
+		},
+		{
+			Name:     "derp",
+			Severity: SeverityWarning,
+			Remediation: Remediation{
+				Code: "check-derp-reachability",
+				Text: "Check that firewalls allow outbound HTTPS and STUN to DERP servers.",
+				URL:  "https://tailscale.com/kb/1082/firewall-ports",
+			},
+			Run: func() error {
+				mu.Lock()
+				defer mu.Unlock()
+				var errs []error
+				if rid := derpHomeRegion; ipnWantRunning && rid != 0 && !derpRegionConnected[rid] {
+					errs = append(errs, fmt.Errorf("not connected to home DERP region %v", rid))
+				}
+				for rid, problem := range derpRegionHealthProblem {
+					errs = append(errs, fmt.Errorf("derp%d: %v", rid, problem))
+				}
+				return multierr.New(errs...)
+			},
+		},
+		{
+			Name:     "tls",
+			Severity: SeverityWarning,
+			Remediation: Remediation{
+				Code: "check-tls-interception",
+				Text: "Check for TLS-intercepting proxies or security software, and that the system clock is correct.",
+			},
+			Run: func() error {
+				mu.Lock()
+				defer mu.Unlock()
+				var errs []error
+				for serverName, err := range tlsConnectionErrors {
+					errs = append(errs, fmt.Errorf("TLS connection error for %q: %w", serverName, err))
+				}
+				return multierr.New(errs...)
+			},
+		},
+	} {
+		RegisterCheck(c)
+	}
+}
@@ -85,6 +85,9 @@ func NewWarnable(opts ...WarnableOpt) *Warnable {
 	mu.Lock()
 	defer mu.Unlock()
 	warnables.Add(w)
+	if w.check != nil {
+		checks.Add(w.check)
+	}
 	return w
 }
 
@@ -110,6 +113,7 @@ func (f warnOptFunc) mod(w *Warnable) { f(w) }
 // The caller of NewWarnable is responsible for calling Set to update the state.
 type Warnable struct {
 	debugFlag string // optional MapRequest.DebugFlag to send when unhealthy
+	check     *Check // optional health check reporting the state; see WithCheck
 
 	isSet atomic.Bool
 	mu    sync.Mutex
@@ -480,6 +484,10 @@ func overallErrorLocked() error {
 		errs = append(errs, fmt.Errorf("%v: %w", sys, err))
 	}
 	for w := range warnables {
+		if w.check != nil && w.check.Severity < SeverityWarning {
+			// Too minor to affect overall health.
+			continue
+		}
 		if err := w.get(); err != nil {
 			errs = append(errs, err)
 		}
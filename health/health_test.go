@@ -7,6 +7,7 @@
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
 	"tailscale.com/util/set"
@@ -37,5 +38,89 @@ func TestAppendWarnableDebugFlags(t *testing.T) {
 func resetWarnables() {
 	mu.Lock()
 	defer mu.Unlock()
+	for w := range warnables {
+		for h, c := range checks {
+			if c == w.check {
+				delete(checks, h)
+			}
+		}
+	}
 	warnables = set.Set[*Warnable]{}
 }
+
+func TestRunChecks(t *testing.T) {
+	var fail1, fail2 error
+	unreg1 := RegisterCheck(Check{Name: "test-a", Severity: SeverityWarning, Run: func() error { return fail1 }})
+	defer unreg1()
+	unreg2 := RegisterCheck(Check{
+		Name:        "test-b",
+		Severity:    SeverityError,
+		Remediation: Remediation{Code: "fix-b", Text: "Fix b."},
+		Run:         func() error { return fail2 },
+	})
+	defer unreg2()
+	w := NewWarnable(WithCheck("test-c", SeverityInfo, Remediation{Code: "fix-c"}))
+	defer resetWarnables()
+
+	results := func() map[string]CheckResult {
+		m := map[string]CheckResult{}
+		for _, r := range RunChecks() {
+			if strings.HasPrefix(r.Name, "test-") {
+				m[r.Name] = r
+			}
+		}
+		return m
+	}
+	got := results()
+	for _, name := range []string{"test-a", "test-b", "test-c"} {
+		if r, ok := got[name]; !ok || !r.Healthy || r.Remediation != nil {
+			t.Errorf("%s = %+v, %v; want healthy", name, r, ok)
+		}
+	}
+
+	fail1 = errors.New("a broke")
+	fail2 = errors.New("b broke")
+	w.Set(errors.New("c broke"))
+	res := RunChecks()
+	var names []string
+	for _, r := range res {
+		if strings.HasPrefix(r.Name, "test-") {
+			names = append(names, r.Name)
+		}
+	}
+	if want := []string{"test-b", "test-a", "test-c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("order = %q; want %q", names, want)
+	}
+	b := results()["test-b"]
+	want := CheckResult{
+		Name:        "test-b",
+		Severity:    SeverityError,
+		Error:       "b broke",
+		Remediation: &Remediation{Code: "fix-b", Text: "Fix b."},
+	}
+	if !reflect.DeepEqual(b, want) {
+		t.Errorf("test-b = %+v; want %+v", b, want)
+	}
+
+	unreg1()
+	if _, ok := results()["test-a"]; ok {
+		t.Error("test-a still registered")
+	}
+}
+
+func TestSeverityText(t *testing.T) {
+	for _, s := range []Severity{SeverityInfo, SeverityWarning, SeverityError} {
+		b, err := s.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Severity
+		if err := got.UnmarshalText(b); err != nil || got != s {
+			t.Errorf("round trip of %v = %v, %v", s, got, err)
+		}
+	}
+	var s Severity
+	if err := s.UnmarshalText([]byte("dire")); err == nil {
+		t.Error("unmarshaling unknown severity succeeded")
+	}
+}
@@ -5,6 +5,7 @@
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -121,6 +122,26 @@ type Notify struct {
 	// is available.
 	ClientVersion *tailcfg.ClientVersion `json:",omitempty"`
 
+	// NetcheckChanges, if non-empty, describes how key facts about the
+	// local network, as found by netcheck, just changed, such as the NAT
+	// type or preferred DERP region.
+	NetcheckChanges []string `json:",omitempty"`
+
+	// Failover, if non-nil, describes traffic just having moved to a new
+	// network interface after a link change.
+	Failover *ipnstate.FailoverEvent `json:",omitempty"`
+
+	// PeerOnline, if non-empty, lists peers whose online status, as
+	// reported by the control plane, just changed.
+	PeerOnline []PeerOnlineChange `json:",omitempty"`
+
+	// Health, if non-nil, is the new set of health warnings.
+	Health *HealthState `json:",omitempty"`
+
+	// ConnApproval, if non-nil, is a new incoming connection awaiting
+	// approval. See Prefs.ConnApproval.
+	ConnApproval *ConnApprovalRequest `json:",omitempty"`
+
 	// type is mirrored in xcode/Shared/IPN.swift
 }
 
@@ -160,10 +181,221 @@ func (n Notify) String() string {
 	if n.LocalTCPPort != nil {
 		fmt.Fprintf(&sb, "tcpport=%v ", n.LocalTCPPort)
 	}
+	if len(n.NetcheckChanges) != 0 {
+		fmt.Fprintf(&sb, "netcheck=%q ", n.NetcheckChanges)
+	}
+	if n.Failover != nil {
+		fmt.Fprintf(&sb, "failover=%q->%q ", n.Failover.OldInterface, n.Failover.NewInterface)
+	}
+	if len(n.PeerOnline) != 0 {
+		fmt.Fprintf(&sb, "peeronline=%d ", len(n.PeerOnline))
+	}
+	if n.Health != nil {
+		fmt.Fprintf(&sb, "health=%d ", len(n.Health.Warnings))
+	}
+	if n.ConnApproval != nil {
+		fmt.Fprintf(&sb, "connapproval=%v ", n.ConnApproval.ID)
+	}
 	s := sb.String()
 	return s[0:len(s)-1] + "}"
 }
 
+// PeerOnlineChange is a change in a peer's online status.
+type PeerOnlineChange struct {
+	NodeID tailcfg.StableNodeID
+	Online bool
+}
+
+// HealthState is the set of health warnings of the backend.
+type HealthState struct {
+	// Warnings are the current health warnings, as in
+	// ipnstate.Status.Health. It's empty if the backend is healthy.
+	Warnings []string
+}
+
+// NotifyEvent is a bitmask of the types of events a Notify can carry.
+// IPN bus watchers can subscribe to just some of them; see
+// Notify.Filter.
+type NotifyEvent uint64
+
+const (
+	EventState         NotifyEvent = 1 << iota // State
+	EventPrefs                                 // Prefs
+	EventNetMap                                // NetMap
+	EventEngine                                // Engine
+	EventBrowseToURL                           // BrowseToURL
+	EventLoginFinished                         // LoginFinished
+	EventErrMessage                            // ErrMessage
+	EventBackendLogID                          // BackendLogID
+	EventFiles                                 // FilesWaiting and IncomingFiles
+	EventLocalTCPPort                          // LocalTCPPort
+	EventClientVersion                         // ClientVersion
+	EventNetcheck                              // NetcheckChanges
+	EventFailover                              // Failover
+	EventPeerOnline                            // PeerOnline
+	EventHealth                                // Health
+	EventConnApproval                          // ConnApproval
+
+	// AllNotifyEvents is the set of all event types.
+	AllNotifyEvents = 1<<iota - 1
+)
+
+var notifyEventNames = []string{
+	"state",
+	"prefs",
+	"netmap",
+	"engine",
+	"browse-to-url",
+	"login-finished",
+	"error",
+	"backend-log-id",
+	"files",
+	"local-tcp-port",
+	"client-version",
+	"netcheck",
+	"failover",
+	"peer-online",
+	"health",
+	"conn-approval",
+}
+
+// String returns the comma-separated names of the events in e, as
+// parsed by ParseNotifyEvents.
+func (e NotifyEvent) String() string {
+	var names []string
+	for i, name := range notifyEventNames {
+		if e&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if rest := e &^ AllNotifyEvents; rest != 0 {
+		names = append(names, fmt.Sprintf("%#x", uint64(rest)))
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseNotifyEvents parses a comma-separated list of event names, such
+// as "state,netmap,peer-online", into a NotifyEvent.
+func ParseNotifyEvents(s string) (NotifyEvent, error) {
+	var e NotifyEvent
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		i := slices.Index(notifyEventNames, name)
+		if i < 0 {
+			return 0, fmt.Errorf("unknown IPN bus event %q", name)
+		}
+		e |= 1 << i
+	}
+	return e, nil
+}
+
+// Events returns the types of events n carries.
+func (n *Notify) Events() NotifyEvent {
+	var e NotifyEvent
+	set := func(ev NotifyEvent, present bool) {
+		if present {
+			e |= ev
+		}
+	}
+	set(EventState, n.State != nil)
+	set(EventPrefs, n.Prefs != nil)
+	set(EventNetMap, n.NetMap != nil)
+	set(EventEngine, n.Engine != nil)
+	set(EventBrowseToURL, n.BrowseToURL != nil)
+	set(EventLoginFinished, n.LoginFinished != nil)
+	set(EventErrMessage, n.ErrMessage != nil)
+	set(EventBackendLogID, n.BackendLogID != nil)
+	set(EventFiles, n.FilesWaiting != nil || n.IncomingFiles != nil)
+	set(EventLocalTCPPort, n.LocalTCPPort != nil)
+	set(EventClientVersion, n.ClientVersion != nil)
+	set(EventNetcheck, len(n.NetcheckChanges) != 0)
+	set(EventFailover, n.Failover != nil)
+	set(EventPeerOnline, len(n.PeerOnline) != 0)
+	set(EventHealth, n.Health != nil)
+	set(EventConnApproval, n.ConnApproval != nil)
+	return e
+}
+
+// Filter returns n restricted to the types of events in events, for
+// watchers that only subscribe to some of them. A zero events means
+// all events, for watchers that predate subscriptions.
+//
+// It returns n itself if nothing needs removing, a shallow copy of n
+// if some fields do, and nil if n carries no events the watcher wants
+// and so should not be sent to it. A Notify with a SessionID or
+// without any events, such as a wake-up, is always kept.
+func (n *Notify) Filter(events NotifyEvent) *Notify {
+	if events == 0 {
+		return n
+	}
+	have := n.Events()
+	drop := have &^ events
+	if drop == 0 {
+		return n
+	}
+	if have&events == 0 && n.SessionID == "" {
+		return nil
+	}
+	n2 := &Notify{
+		Version:   n.Version,
+		SessionID: n.SessionID,
+	}
+	keep := func(ev NotifyEvent) bool { return drop&ev == 0 }
+	if keep(EventState) {
+		n2.State = n.State
+	}
+	if keep(EventPrefs) {
+		n2.Prefs = n.Prefs
+	}
+	if keep(EventNetMap) {
+		n2.NetMap = n.NetMap
+	}
+	if keep(EventEngine) {
+		n2.Engine = n.Engine
+	}
+	if keep(EventBrowseToURL) {
+		n2.BrowseToURL = n.BrowseToURL
+	}
+	if keep(EventLoginFinished) {
+		n2.LoginFinished = n.LoginFinished
+	}
+	if keep(EventErrMessage) {
+		n2.ErrMessage = n.ErrMessage
+	}
+	if keep(EventBackendLogID) {
+		n2.BackendLogID = n.BackendLogID
+	}
+	if keep(EventFiles) {
+		n2.FilesWaiting = n.FilesWaiting
+		n2.IncomingFiles = n.IncomingFiles
+	}
+	if keep(EventLocalTCPPort) {
+		n2.LocalTCPPort = n.LocalTCPPort
+	}
+	if keep(EventClientVersion) {
+		n2.ClientVersion = n.ClientVersion
+	}
+	if keep(EventNetcheck) {
+		n2.NetcheckChanges = n.NetcheckChanges
+	}
+	if keep(EventFailover) {
+		n2.Failover = n.Failover
+	}
+	if keep(EventPeerOnline) {
+		n2.PeerOnline = n.PeerOnline
+	}
+	if keep(EventHealth) {
+		n2.Health = n.Health
+	}
+	if keep(EventConnApproval) {
+		n2.ConnApproval = n.ConnApproval
+	}
+	return n2
+}
+
 // PartialFile represents an in-progress file transfer.
 type PartialFile struct {
 	Name         string    // e.g. "foo.jpg"
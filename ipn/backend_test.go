@@ -0,0 +1,68 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"math/bits"
+	"testing"
+
+	"tailscale.com/types/empty"
+	"tailscale.com/types/ptr"
+)
+
+func TestParseNotifyEvents(t *testing.T) {
+	e, err := ParseNotifyEvents("state, netmap,,peer-online,health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := EventState | EventNetMap | EventPeerOnline | EventHealth; e != want {
+		t.Errorf("got %v; want %v", e, want)
+	}
+	if got, want := e.String(), "state,netmap,peer-online,health"; got != want {
+		t.Errorf("String = %q; want %q", got, want)
+	}
+	if e, err := ParseNotifyEvents(""); err != nil || e != 0 {
+		t.Errorf("empty: got %v, %v; want 0, nil", e, err)
+	}
+	if _, err := ParseNotifyEvents("state,bogus"); err == nil {
+		t.Error("unknown event: got nil error")
+	}
+	if len(notifyEventNames) != bits.Len64(uint64(AllNotifyEvents)) {
+		t.Errorf("notifyEventNames has %d names; want one per event", len(notifyEventNames))
+	}
+}
+
+func TestNotifyFilter(t *testing.T) {
+	n := &Notify{
+		Version:      "1.2.3",
+		State:        ptr.To(Running),
+		FilesWaiting: &empty.Message{},
+		PeerOnline:   []PeerOnlineChange{{NodeID: "n1", Online: true}},
+	}
+	if got := n.Events(); got != EventState|EventFiles|EventPeerOnline {
+		t.Fatalf("Events = %v", got)
+	}
+	if got := n.Filter(0); got != n {
+		t.Errorf("Filter(0) = %v; want n", got)
+	}
+	if got := n.Filter(AllNotifyEvents); got != n {
+		t.Errorf("Filter(all) = %v; want n", got)
+	}
+	got := n.Filter(EventPeerOnline | EventHealth)
+	if got == nil || got.Events() != EventPeerOnline || got.Version != n.Version {
+		t.Errorf("Filter(peer-online,health) = %v", got)
+	}
+	if got := n.Filter(EventNetMap); got != nil {
+		t.Errorf("Filter(netmap) = %v; want nil", got)
+	}
+
+	withSession := &Notify{SessionID: "abc", State: ptr.To(Running)}
+	if got := withSession.Filter(EventNetMap); got == nil || got.SessionID != "abc" || got.State != nil {
+		t.Errorf("Filter with SessionID = %v", got)
+	}
+	wakeUp := new(Notify)
+	if got := wakeUp.Filter(EventNetMap); got != wakeUp {
+		t.Errorf("Filter of empty Notify = %v; want it unchanged", got)
+	}
+}
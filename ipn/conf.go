@@ -7,6 +7,7 @@
 	"net/netip"
 
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/appctype"
 	"tailscale.com/types/opt"
 	"tailscale.com/types/preftype"
 )
@@ -20,12 +21,18 @@ type ConfigVAlpha struct {
 	AuthKey   *string  `json:",omitempty"` // as needed if NeedsLogin. either key or path to a file (if prefixed with "file:")
 	Enabled   opt.Bool `json:",omitempty"` // wantRunning; empty string defaults to true
 
-	OperatorUser *string `json:",omitempty"` // local user name who is allowed to operate tailscaled without being root or using sudo
-	Hostname     *string `json:",omitempty"`
+	OperatorUser  *string  `json:",omitempty"` // local user name who is allowed to operate tailscaled without being root or using sudo
+	Hostname      *string  `json:",omitempty"`
+	AdvertiseTags []string `json:",omitempty"`
 
 	AcceptDNS    opt.Bool `json:"acceptDNS,omitempty"` // --accept-dns
 	AcceptRoutes opt.Bool `json:"acceptRoutes,omitempty"`
 
+	AdvertiseDNSRecords []tailcfg.DNSRecord `json:",omitempty"`
+	DNSCache            *DNSCachePrefs      `json:",omitempty"`
+	DNSBlocklists       []string            `json:",omitempty"` // nil leaves them alone; empty clears them
+	DNSBlocklistNullIP  opt.Bool            `json:",omitempty"`
+
 	ExitNode                   *string  `json:"exitNode,omitempty"` // IP, StableID, or MagicDNS base name
 	AllowLANWhileUsingExitNode opt.Bool `json:"allowLANWhileUsingExitNode,omitempty"`
 
@@ -40,10 +47,17 @@ type ConfigVAlpha struct {
 	AutoUpdate      *AutoUpdatePrefs `json:",omitempty"`
 	ServeConfigTemp *ServeConfig     `json:",omitempty"` // TODO(bradfitz,maisem): make separate stable type for this
 
+	AppConnector      *appctype.AppConnectorConfig `json:",omitempty"` // if this node runs an app connector
+	MaintenanceWindow *string                      `json:",omitempty"` // see Prefs.MaintenanceWindow
+	WebClient         *string                      `json:",omitempty"` // "", "tailnet" or "localhost"
+
 	// TODO(bradfitz,maisem): future something like:
 	// Profile map[string]*Config // keyed by alice@gmail.com, corp.com (TailnetSID)
 }
 
+// ToPrefs returns the prefs c sets. Fields c leaves unset aren't set in
+// the returned MaskedPrefs. The serve and app connector configurations
+// aren't prefs and are applied separately.
 func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 	var mp MaskedPrefs
 	if c == nil {
@@ -67,6 +81,10 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 		mp.Hostname = *c.Hostname
 		mp.HostnameSet = true
 	}
+	if c.AdvertiseTags != nil {
+		mp.AdvertiseTags = c.AdvertiseTags
+		mp.AdvertiseTagsSet = true
+	}
 	if c.AcceptDNS != "" {
 		mp.CorpDNS = c.AcceptDNS.EqualBool(true)
 		mp.CorpDNSSet = true
@@ -75,6 +93,22 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 		mp.RouteAll = c.AcceptRoutes.EqualBool(true)
 		mp.RouteAllSet = true
 	}
+	if c.AdvertiseDNSRecords != nil {
+		mp.AdvertiseDNSRecords = c.AdvertiseDNSRecords
+		mp.AdvertiseDNSRecordsSet = true
+	}
+	if c.DNSCache != nil {
+		mp.DNSCache = *c.DNSCache
+		mp.DNSCacheSet = true
+	}
+	if c.DNSBlocklists != nil {
+		mp.DNSBlocklists = c.DNSBlocklists
+		mp.DNSBlocklistsSet = true
+	}
+	if c.DNSBlocklistNullIP != "" {
+		mp.DNSBlocklistNullIP = c.DNSBlocklistNullIP.EqualBool(true)
+		mp.DNSBlocklistNullIPSet = true
+	}
 	if c.ExitNode != nil {
 		ip, err := netip.ParseAddr(*c.ExitNode)
 		if err == nil {
@@ -95,7 +129,7 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 	}
 	if c.DisableSNAT != "" {
 		mp.NoSNAT = c.DisableSNAT.EqualBool(true)
-		mp.NoSNAT = true
+		mp.NoSNATSet = true
 	}
 	if c.NetfilterMode != nil {
 		m, err := preftype.ParseNetfilterMode(*c.NetfilterMode)
@@ -121,5 +155,13 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 		mp.AutoUpdate = *c.AutoUpdate
 		mp.AutoUpdateSet = true
 	}
+	if c.MaintenanceWindow != nil {
+		mp.MaintenanceWindow = *c.MaintenanceWindow
+		mp.MaintenanceWindowSet = true
+	}
+	if c.WebClient != nil {
+		mp.WebClient = *c.WebClient
+		mp.WebClientSet = true
+	}
 	return mp, nil
 }
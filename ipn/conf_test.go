@@ -0,0 +1,47 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"reflect"
+	"testing"
+
+	"tailscale.com/types/ptr"
+)
+
+func TestConfigToPrefs(t *testing.T) {
+	c := &ConfigVAlpha{
+		Enabled:            "false",
+		DisableSNAT:        "true",
+		AdvertiseTags:      []string{"tag:server"},
+		DNSBlocklists:      []string{},
+		DNSBlocklistNullIP: "true",
+		MaintenanceWindow:  ptr.To("0 2 * * * 1h"),
+		WebClient:          ptr.To(WebClientTailnet),
+	}
+	got, err := c.ToPrefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := MaskedPrefs{
+		Prefs: Prefs{
+			NoSNAT:             true,
+			AdvertiseTags:      []string{"tag:server"},
+			DNSBlocklists:      []string{},
+			DNSBlocklistNullIP: true,
+			MaintenanceWindow:  "0 2 * * * 1h",
+			WebClient:          WebClientTailnet,
+		},
+		WantRunningSet:        true,
+		NoSNATSet:             true,
+		AdvertiseTagsSet:      true,
+		DNSBlocklistsSet:      true,
+		DNSBlocklistNullIPSet: true,
+		MaintenanceWindowSet:  true,
+		WebClientSet:          true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ToPrefs wrong\n got: %v\nwant: %v", got.Pretty(), want.Pretty())
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"net/netip"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ipproto"
+)
+
+// ConnApprovalRequest is a new incoming connection from a peer that's
+// awaiting approval because Prefs.ConnApproval is set and no
+// ConnApprovalRule covers it. Its packets are dropped until it's
+// approved, after which the peer's retries get through.
+type ConnApprovalRequest struct {
+	// ID identifies the request, to accept or reject it.
+	ID string
+
+	Peer     tailcfg.StableNodeID
+	PeerName string // the peer's MagicDNS name, for humans
+	Proto    ipproto.Proto
+	Src      netip.AddrPort
+	Dst      netip.AddrPort
+
+	// Expires is when the request is dropped if it hasn't been accepted
+	// or rejected, leaving the connection rejected.
+	Expires time.Time
+}
+
+// ConnApprovalRule is a decision on incoming connections from a peer,
+// stored when a ConnApprovalRequest is accepted or rejected. While
+// Prefs.ConnApproval is set, it adds to the packet filter.
+type ConnApprovalRule struct {
+	Peer  tailcfg.StableNodeID
+	Proto ipproto.Proto `json:",omitempty"` // or zero for any protocol
+	Port  uint16        `json:",omitempty"` // destination port, or zero for any
+	Allow bool
+}
+
+// Matches reports whether r covers connections from peer using proto to
+// port.
+func (r ConnApprovalRule) Matches(peer tailcfg.StableNodeID, proto ipproto.Proto, port uint16) bool {
+	return r.Peer == peer &&
+		(r.Proto == 0 || r.Proto == proto) &&
+		(r.Port == 0 || r.Port == port)
+}
+
+// ConnApprovals is the response to a LocalAPI conn-approvals request.
+type ConnApprovals struct {
+	Pending []ConnApprovalRequest // oldest first
+	Rules   []ConnApprovalRule
+}
+
+// ConnApprovalDecision is the body POSTed to the LocalAPI conn-approvals
+// endpoint to accept or reject a ConnApprovalRequest.
+type ConnApprovalDecision struct {
+	ID    string
+	Allow bool
+
+	// AllPorts, if true, makes the decision cover connections from the
+	// peer to any port, not just the requested one.
+	AllPorts bool `json:",omitempty"`
+}
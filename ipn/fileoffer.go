@@ -0,0 +1,47 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+// FileOffer is an incoming Taildrop file that's held, while a processor
+// is subscribed to the LocalAPI file-offers endpoint, until the
+// processor accepts, rejects or redirects it with a FileOfferDecision.
+type FileOffer struct {
+	// ID identifies the offer, to decide on it.
+	ID string
+
+	// Name is the name of the file, as chosen by the sender.
+	// It's slash-separated for files sent as part of a directory.
+	Name string
+
+	Size   int64  // or -1 if unknown
+	Offset int64  `json:",omitempty"` // where a resumed transfer continues from
+	SHA256 string `json:",omitempty"` // hex-encoded checksum, if the sender provided it
+
+	Peer     tailcfg.StableNodeID
+	PeerName string // the peer's MagicDNS name, for humans
+
+	// Expires is when the offer is rejected if it hasn't been decided on.
+	Expires time.Time
+}
+
+// FileOfferDecision is the body POSTed to the LocalAPI file-offers
+// endpoint to decide on a FileOffer.
+type FileOfferDecision struct {
+	ID     string
+	Accept bool
+
+	// Path, if non-empty, is the absolute path to move an accepted file
+	// to once it's received, rather than leaving it in the Taildrop inbox.
+	// Its directory must exist, and the file must not.
+	// As tailscaled creates the file with its own privileges, only
+	// clients running as root or as the same user as tailscaled may
+	// set it; others should fetch the accepted file from the inbox.
+	Path string `json:",omitempty"`
+}
@@ -24,6 +24,11 @@ func (src *Prefs) Clone() *Prefs {
 	*dst = *src
 	dst.AdvertiseTags = append(src.AdvertiseTags[:0:0], src.AdvertiseTags...)
 	dst.AdvertiseRoutes = append(src.AdvertiseRoutes[:0:0], src.AdvertiseRoutes...)
+	dst.AdvertiseMetadata = maps.Clone(src.AdvertiseMetadata)
+	dst.EndpointPins = maps.Clone(src.EndpointPins)
+	dst.PeerEndpointTypes = maps.Clone(src.PeerEndpointTypes)
+	dst.AdvertiseDNSRecords = append(src.AdvertiseDNSRecords[:0:0], src.AdvertiseDNSRecords...)
+	dst.DNSBlocklists = append(src.DNSBlocklists[:0:0], src.DNSBlocklists...)
 	dst.Persist = src.Persist.Clone()
 	return dst
 }
@@ -35,12 +40,14 @@ func (src *Prefs) Clone() *Prefs {
 	AllowSingleHosts       bool
 	ExitNodeID             tailcfg.StableNodeID
 	ExitNodeIP             netip.Addr
+	AutoExitNode           bool
 	ExitNodeAllowLANAccess bool
 	CorpDNS                bool
 	RunSSH                 bool
 	WantRunning            bool
 	LoggedOut              bool
 	ShieldsUp              bool
+	ConnApproval           bool
 	AdvertiseTags          []string
 	Hostname               string
 	NotepadURLs            bool
@@ -53,6 +60,21 @@ func (src *Prefs) Clone() *Prefs {
 	ProfileName            string
 	AutoUpdate             AutoUpdatePrefs
 	PostureChecking        bool
+	AdvertiseMetadata      map[string]string
+	UDPPort                UDPPortPrefs
+	EndpointPins           map[tailcfg.StableNodeID]string
+	EndpointTypes          string
+	PeerEndpointTypes      map[tailcfg.StableNodeID]string
+	OuterDSCP              string
+	IPv6FlowLabels         bool
+	RouteMetrics           RouteMetricPrefs
+	AdvertiseDNSRecords    []tailcfg.DNSRecord
+	DNSCache               DNSCachePrefs
+	DNSBlocklists          []string
+	DNSBlocklistNullIP     bool
+	MaintenanceWindow      string
+	WebClient              string
+	HalfOpenLimits         HalfOpenLimitsPrefs
 	Persist                *persist.Persist
 }{})
 
@@ -122,14 +144,24 @@ func (src *HTTPHandler) Clone() *HTTPHandler {
 	}
 	dst := new(HTTPHandler)
 	*dst = *src
+	dst.RequestHeaders = maps.Clone(src.RequestHeaders)
+	dst.ResponseHeaders = maps.Clone(src.ResponseHeaders)
+	dst.IndexFiles = append(src.IndexFiles[:0:0], src.IndexFiles...)
 	return dst
 }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HTTPHandlerCloneNeedsRegeneration = HTTPHandler(struct {
-	Path  string
-	Proxy string
-	Text  string
+	Path            string
+	Proxy           string
+	Text            string
+	RewritePath     string
+	RequestHeaders  map[string]string
+	ResponseHeaders map[string]string
+	IndexFiles      []string
+	SPA             bool
+	NoListing       bool
+	CacheControl    string
 }{})
 
 // Clone makes a deep copy of WebServerConfig.
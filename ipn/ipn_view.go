@@ -68,12 +68,14 @@ func (v PrefsView) RouteAll() bool                     { return v.ж.RouteAll }
 func (v PrefsView) AllowSingleHosts() bool             { return v.ж.AllowSingleHosts }
 func (v PrefsView) ExitNodeID() tailcfg.StableNodeID   { return v.ж.ExitNodeID }
 func (v PrefsView) ExitNodeIP() netip.Addr             { return v.ж.ExitNodeIP }
+func (v PrefsView) AutoExitNode() bool                 { return v.ж.AutoExitNode }
 func (v PrefsView) ExitNodeAllowLANAccess() bool       { return v.ж.ExitNodeAllowLANAccess }
 func (v PrefsView) CorpDNS() bool                      { return v.ж.CorpDNS }
 func (v PrefsView) RunSSH() bool                       { return v.ж.RunSSH }
 func (v PrefsView) WantRunning() bool                  { return v.ж.WantRunning }
 func (v PrefsView) LoggedOut() bool                    { return v.ж.LoggedOut }
 func (v PrefsView) ShieldsUp() bool                    { return v.ж.ShieldsUp }
+func (v PrefsView) ConnApproval() bool                 { return v.ж.ConnApproval }
 func (v PrefsView) AdvertiseTags() views.Slice[string] { return views.SliceOf(v.ж.AdvertiseTags) }
 func (v PrefsView) Hostname() string                   { return v.ж.Hostname }
 func (v PrefsView) NotepadURLs() bool                  { return v.ж.NotepadURLs }
@@ -88,7 +90,31 @@ func (v PrefsView) OperatorUser() string                  { return v.ж.Operator
 func (v PrefsView) ProfileName() string                   { return v.ж.ProfileName }
 func (v PrefsView) AutoUpdate() AutoUpdatePrefs           { return v.ж.AutoUpdate }
 func (v PrefsView) PostureChecking() bool                 { return v.ж.PostureChecking }
-func (v PrefsView) Persist() persist.PersistView          { return v.ж.Persist.View() }
+
+func (v PrefsView) AdvertiseMetadata() views.Map[string, string] {
+	return views.MapOf(v.ж.AdvertiseMetadata)
+}
+func (v PrefsView) UDPPort() UDPPortPrefs { return v.ж.UDPPort }
+func (v PrefsView) EndpointPins() views.Map[tailcfg.StableNodeID, string] {
+	return views.MapOf(v.ж.EndpointPins)
+}
+func (v PrefsView) EndpointTypes() string { return v.ж.EndpointTypes }
+func (v PrefsView) PeerEndpointTypes() views.Map[tailcfg.StableNodeID, string] {
+	return views.MapOf(v.ж.PeerEndpointTypes)
+}
+func (v PrefsView) OuterDSCP() string              { return v.ж.OuterDSCP }
+func (v PrefsView) IPv6FlowLabels() bool           { return v.ж.IPv6FlowLabels }
+func (v PrefsView) RouteMetrics() RouteMetricPrefs { return v.ж.RouteMetrics }
+func (v PrefsView) AdvertiseDNSRecords() views.Slice[tailcfg.DNSRecord] {
+	return views.SliceOf(v.ж.AdvertiseDNSRecords)
+}
+func (v PrefsView) DNSCache() DNSCachePrefs             { return v.ж.DNSCache }
+func (v PrefsView) DNSBlocklists() views.Slice[string]  { return views.SliceOf(v.ж.DNSBlocklists) }
+func (v PrefsView) DNSBlocklistNullIP() bool            { return v.ж.DNSBlocklistNullIP }
+func (v PrefsView) MaintenanceWindow() string           { return v.ж.MaintenanceWindow }
+func (v PrefsView) WebClient() string                   { return v.ж.WebClient }
+func (v PrefsView) HalfOpenLimits() HalfOpenLimitsPrefs { return v.ж.HalfOpenLimits }
+func (v PrefsView) Persist() persist.PersistView        { return v.ж.Persist.View() }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _PrefsViewNeedsRegeneration = Prefs(struct {
@@ -97,12 +123,14 @@ func (v PrefsView) Persist() persist.PersistView          { return v.ж.Persist.
 	AllowSingleHosts       bool
 	ExitNodeID             tailcfg.StableNodeID
 	ExitNodeIP             netip.Addr
+	AutoExitNode           bool
 	ExitNodeAllowLANAccess bool
 	CorpDNS                bool
 	RunSSH                 bool
 	WantRunning            bool
 	LoggedOut              bool
 	ShieldsUp              bool
+	ConnApproval           bool
 	AdvertiseTags          []string
 	Hostname               string
 	NotepadURLs            bool
@@ -115,6 +143,21 @@ func (v PrefsView) Persist() persist.PersistView          { return v.ж.Persist.
 	ProfileName            string
 	AutoUpdate             AutoUpdatePrefs
 	PostureChecking        bool
+	AdvertiseMetadata      map[string]string
+	UDPPort                UDPPortPrefs
+	EndpointPins           map[tailcfg.StableNodeID]string
+	EndpointTypes          string
+	PeerEndpointTypes      map[tailcfg.StableNodeID]string
+	OuterDSCP              string
+	IPv6FlowLabels         bool
+	RouteMetrics           RouteMetricPrefs
+	AdvertiseDNSRecords    []tailcfg.DNSRecord
+	DNSCache               DNSCachePrefs
+	DNSBlocklists          []string
+	DNSBlocklistNullIP     bool
+	MaintenanceWindow      string
+	WebClient              string
+	HalfOpenLimits         HalfOpenLimitsPrefs
 	Persist                *persist.Persist
 }{})
 
@@ -298,15 +341,34 @@ func (v *HTTPHandlerView) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (v HTTPHandlerView) Path() string  { return v.ж.Path }
-func (v HTTPHandlerView) Proxy() string { return v.ж.Proxy }
-func (v HTTPHandlerView) Text() string  { return v.ж.Text }
+func (v HTTPHandlerView) Path() string        { return v.ж.Path }
+func (v HTTPHandlerView) Proxy() string       { return v.ж.Proxy }
+func (v HTTPHandlerView) Text() string        { return v.ж.Text }
+func (v HTTPHandlerView) RewritePath() string { return v.ж.RewritePath }
+
+func (v HTTPHandlerView) RequestHeaders() views.Map[string, string] {
+	return views.MapOf(v.ж.RequestHeaders)
+}
+func (v HTTPHandlerView) ResponseHeaders() views.Map[string, string] {
+	return views.MapOf(v.ж.ResponseHeaders)
+}
+func (v HTTPHandlerView) IndexFiles() views.Slice[string] { return views.SliceOf(v.ж.IndexFiles) }
+func (v HTTPHandlerView) SPA() bool                       { return v.ж.SPA }
+func (v HTTPHandlerView) NoListing() bool                 { return v.ж.NoListing }
+func (v HTTPHandlerView) CacheControl() string            { return v.ж.CacheControl }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HTTPHandlerViewNeedsRegeneration = HTTPHandler(struct {
-	Path  string
-	Proxy string
-	Text  string
+	Path            string
+	Proxy           string
+	Text            string
+	RewritePath     string
+	RequestHeaders  map[string]string
+	ResponseHeaders map[string]string
+	IndexFiles      []string
+	SPA             bool
+	NoListing       bool
+	CacheControl    string
 }{})
 
 // View returns a readonly view of WebServerConfig.
@@ -137,6 +137,15 @@ func (ci *ConnIdentity) IsReadonlyConn(operatorUID string, logf logger.Logf) boo
 		logf("connection from peer with unknown userid; read-only")
 		return ro
 	}
+	return IsReadonlyUID(uid, operatorUID, logf)
+}
+
+// IsReadonlyUID reports whether a connection from the local user uid
+// should be considered read-only, as IsReadonlyConn does for the user
+// owning a connection.
+func IsReadonlyUID(uid, operatorUID string, logf logger.Logf) bool {
+	const ro = true
+	const rw = false
 	if uid == "0" {
 		logf("connection from userid %v; root has access", uid)
 		return rw
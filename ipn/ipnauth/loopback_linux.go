@@ -0,0 +1,36 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnauth
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+
+	"tailscale.com/net/netstat"
+)
+
+// LoopbackUID returns the local user ID owning the other end of c, a TCP
+// connection accepted on a loopback address. It reports false if that
+// can't be determined, such as if c isn't a loopback TCP connection.
+func LoopbackUID(c net.Conn) (uid string, ok bool) {
+	la, err := netip.ParseAddrPort(c.LocalAddr().String())
+	if err != nil || !la.Addr().IsLoopback() {
+		return "", false
+	}
+	ra, err := netip.ParseAddrPort(c.RemoteAddr().String())
+	if err != nil {
+		return "", false
+	}
+	tab, err := netstat.Get()
+	if err != nil {
+		return "", false
+	}
+	for _, e := range tab.Entries {
+		if e.Local == ra && e.Remote == la {
+			return strconv.Itoa(e.OSMetadata.UID), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,15 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package ipnauth
+
+import "net"
+
+// LoopbackUID returns the local user ID owning the other end of c, a TCP
+// connection accepted on a loopback address. It's only implemented on
+// Linux; elsewhere it always reports false.
+func LoopbackUID(c net.Conn) (uid string, ok bool) {
+	return "", false
+}
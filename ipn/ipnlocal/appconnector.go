@@ -0,0 +1,403 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/appctype"
+	"tailscale.com/types/tkatype"
+)
+
+// AppConnector is an app connector whose configuration can be managed
+// through the LocalAPI. It is implemented by *appc.Server.
+type AppConnector interface {
+	// Config returns the active configuration, or nil if none, and its
+	// generation, which increases each time the app connector is
+	// reconfigured.
+	Config() (cfg *appctype.AppConnectorConfig, gen int64)
+
+	// Configure atomically replaces the active configuration.
+	Configure(*appctype.AppConnectorConfig)
+
+	// ConfigureIfUnchanged is like Configure, but returns an error and
+	// leaves the configuration alone if its generation is no longer gen.
+	// It returns the generation of the active configuration.
+	ConfigureIfUnchanged(cfg *appctype.AppConnectorConfig, gen int64) (int64, error)
+
+	// Status returns the status of the services the app connector is
+	// running.
+	Status() []appctype.ServiceStatus
+
+	// Drains returns the progress of draining the connections of services
+	// removed from the configuration.
+	Drains() []appctype.DrainStatus
+
+	// SetRouteAdvertiser sets the functions with which the app connector
+	// advertises and withdraws the routes it learns from DNS answers, or
+	// nil functions if it may not.
+	SetRouteAdvertiser(advertise, unadvertise func(...netip.Prefix) error)
+
+	// SetWhoIs sets the function with which the app connector looks up the
+	// identity of clients, or nil if it may not.
+	SetWhoIs(func(netip.AddrPort) (tailcfg.NodeView, tailcfg.UserProfile, bool))
+
+	// SetCertGetter sets the function with which the app connector gets
+	// the certificates of domains it terminates TLS for, or nil if it may
+	// not.
+	SetCertGetter(func(ctx context.Context, domain string) (*tls.Certificate, error))
+
+	// SetTailnetRouting sets the functions with which the app connector
+	// reports whether a destination is routed over the tailnet and dials
+	// such destinations, or nil functions to dial all destinations on the
+	// host network.
+	SetTailnetRouting(isTailnetRoute func(netip.Addr) bool, dial func(ctx context.Context, network, address string) (net.Conn, error))
+
+	// SetDomainAttestationKeys sets the keys that must sign the domain
+	// attestation of the configuration, or none to not require one.
+	SetDomainAttestationKeys(keys []tkatype.KeyID)
+}
+
+// ErrNoAppConnector is returned when managing the app connector
+// configuration of a node which doesn't run an app connector.
+var ErrNoAppConnector = errors.New("no app connector")
+
+// ErrAppConnectorConflict is returned by SetAppConnectorConfig when the
+// new configuration has services that conflict with each other.
+var ErrAppConnectorConflict = errors.New("conflicting app connector services")
+
+// SetAppConnector sets the app connector whose configuration is managed
+// through the LocalAPI, or nil to remove it.
+//
+// The app connector advertises the routes it learns from DNS answers as
+// subnet routes of this node, looks up the identity of its clients with
+// WhoIs, terminates TLS with certificates from GetCertPEM, and dials
+// destinations routed over the tailnet, such as other nodes' subnet routes,
+// through the tunnel. If the node
+// has the tailcfg.NodeAttrAppConnectorDomainAttestation attribute, it also
+// requires domain attestations signed by the tailnet key authority.
+//
+// Routes learned by a previous app connector, including one of a previous
+// run, are withdrawn.
+func (b *LocalBackend) SetAppConnector(ac AppConnector) {
+	b.mu.Lock()
+	old := b.appConnector
+	b.appConnector = ac
+	b.mu.Unlock()
+
+	if old != nil && old != ac {
+		old.SetRouteAdvertiser(nil, nil)
+		old.SetWhoIs(nil)
+		old.SetCertGetter(nil)
+		old.SetTailnetRouting(nil, nil)
+	}
+	if old != ac {
+		// The new app connector hasn't learned any routes yet, and
+		// those of the old one, or of a previous run, won't expire.
+		b.dropLearnedRoutes()
+	}
+	if ac != nil {
+		ac.SetRouteAdvertiser(b.AdvertiseRoute, b.UnadvertiseRoute)
+		ac.SetWhoIs(b.WhoIs)
+		ac.SetCertGetter(b.appConnectorCert)
+		ac.SetTailnetRouting(b.isTailnetRoute, b.dialer.UserDial)
+		b.updateAppConnectorAttestation()
+	}
+}
+
+// updateAppConnectorAttestation sets the keys the app connector requires
+// domain attestations to be signed by: the tailnet key authority's keys if
+// the node has the tailcfg.NodeAttrAppConnectorDomainAttestation attribute,
+// and none otherwise. It's called when the netmap changes.
+func (b *LocalBackend) updateAppConnectorAttestation() {
+	b.mu.Lock()
+	ac := b.appConnector
+	var keys []tkatype.KeyID
+	if b.tka != nil && hasCapability(b.netMap, tailcfg.NodeAttrAppConnectorDomainAttestation) {
+		for _, k := range b.tka.authority.Keys() {
+			if id, err := k.ID(); err == nil {
+				keys = append(keys, id)
+			}
+		}
+	}
+	b.mu.Unlock()
+	if ac != nil {
+		ac.SetDomainAttestationKeys(keys)
+	}
+}
+
+// isTailnetRoute reports whether ip is routed over the tailnet to a peer,
+// for the app connector to dial it through the tunnel.
+func (b *LocalBackend) isTailnetRoute(ip netip.Addr) bool {
+	pip, ok := b.e.PeerForIP(ip)
+	return ok && !pip.IsSelf
+}
+
+// appConnectorCert returns the certificate of domain, for the app connector
+// to terminate TLS with.
+func (b *LocalBackend) appConnectorCert(ctx context.Context, domain string) (*tls.Certificate, error) {
+	pair, err := b.GetCertPEM(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(pair.CertPEM, pair.KeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// AdvertiseRoute adds ipps, learned by the app connector, to the routes this
+// node advertises, except for those already covered by an advertised route.
+// The routes it adds are recorded as learned, for UnadvertiseRoute.
+func (b *LocalBackend) AdvertiseRoute(ipps ...netip.Prefix) error {
+	b.mu.Lock()
+	prefs := b.pm.CurrentPrefs().AsStruct()
+	learned := b.learnedRoutesLocked()
+	var changed bool
+	for _, ipp := range ipps {
+		ipp = ipp.Masked()
+		if slices.ContainsFunc(prefs.AdvertiseRoutes, func(r netip.Prefix) bool {
+			return r.Bits() > 0 && r.Bits() <= ipp.Bits() && r.Contains(ipp.Addr())
+		}) {
+			continue
+		}
+		prefs.AdvertiseRoutes = append(prefs.AdvertiseRoutes, ipp)
+		learned = append(learned, ipp)
+		changed = true
+	}
+	if !changed {
+		b.mu.Unlock()
+		return nil
+	}
+	if err := b.setLearnedRoutesLocked(learned); err != nil {
+		b.mu.Unlock()
+		return err
+	}
+	b.setPrefsLockedOnEntry("AdvertiseRoute", prefs) // does a b.mu.Unlock
+	return nil
+}
+
+// UnadvertiseRoute removes ipps from the routes this node advertises, if
+// they were added by AdvertiseRoute. The user's own routes are left alone.
+func (b *LocalBackend) UnadvertiseRoute(ipps ...netip.Prefix) error {
+	b.mu.Lock()
+	learned := b.learnedRoutesLocked()
+	var withdraw []netip.Prefix
+	learned = slices.DeleteFunc(learned, func(r netip.Prefix) bool {
+		if slices.Contains(ipps, r) {
+			withdraw = append(withdraw, r)
+			return true
+		}
+		return false
+	})
+	if len(withdraw) == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	return b.withdrawLearnedRoutesLockedOnEntry("UnadvertiseRoute", withdraw, learned)
+}
+
+// dropLearnedRoutes withdraws all the routes the app connector learned, such
+// as when it's replaced, or those learned before a restart, which were to
+// expire at times kept only in memory.
+func (b *LocalBackend) dropLearnedRoutes() {
+	b.mu.Lock()
+	learned := b.learnedRoutesLocked()
+	if len(learned) == 0 {
+		b.mu.Unlock()
+		return
+	}
+	b.logf("appc: withdrawing %d learned routes", len(learned))
+	if err := b.withdrawLearnedRoutesLockedOnEntry("dropLearnedRoutes", learned, nil); err != nil {
+		b.logf("appc: withdrawing learned routes: %v", err)
+	}
+}
+
+// withdrawLearnedRoutesLockedOnEntry removes routes from the advertised
+// routes and records the rest of the learned routes as learned.
+//
+// b.mu must be held on entry. It's released on exit.
+func (b *LocalBackend) withdrawLearnedRoutesLockedOnEntry(caller string, routes, learned []netip.Prefix) error {
+	if err := b.setLearnedRoutesLocked(learned); err != nil {
+		b.mu.Unlock()
+		return err
+	}
+	prefs := b.pm.CurrentPrefs().AsStruct()
+	n := len(prefs.AdvertiseRoutes)
+	prefs.AdvertiseRoutes = slices.DeleteFunc(prefs.AdvertiseRoutes, func(r netip.Prefix) bool {
+		return slices.Contains(routes, r)
+	})
+	if len(prefs.AdvertiseRoutes) == n {
+		b.mu.Unlock()
+		return nil
+	}
+	b.setPrefsLockedOnEntry(caller, prefs) // does a b.mu.Unlock
+	return nil
+}
+
+// learnedRoutesLocked returns the routes of the current profile learned by
+// the app connector. b.mu must be held.
+func (b *LocalBackend) learnedRoutesLocked() []netip.Prefix {
+	var routes []netip.Prefix
+	bs, err := b.store.ReadState(ipn.AppConnectorLearnedRoutesKey(b.pm.CurrentProfile().ID))
+	if err == nil {
+		err = json.Unmarshal(bs, &routes)
+	}
+	if err != nil && !errors.Is(err, ipn.ErrStateNotExist) {
+		b.logf("appc: reading learned routes: %v", err)
+	}
+	return routes
+}
+
+// setLearnedRoutesLocked records routes as the routes of the current profile
+// learned by the app connector. b.mu must be held.
+func (b *LocalBackend) setLearnedRoutesLocked(routes []netip.Prefix) error {
+	if routes == nil {
+		routes = []netip.Prefix{}
+	}
+	bs, err := json.Marshal(routes)
+	if err != nil {
+		return err
+	}
+	return ipn.WriteState(b.store, ipn.AppConnectorLearnedRoutesKey(b.pm.CurrentProfile().ID), bs)
+}
+
+func (b *LocalBackend) appConnectorOrErr() (AppConnector, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.appConnector == nil {
+		return nil, ErrNoAppConnector
+	}
+	return b.appConnector, nil
+}
+
+// AppConnectorConfig returns the active app connector configuration, or
+// nil if the app connector hasn't been configured, and its etag for use
+// with SetAppConnectorConfig.
+func (b *LocalBackend) AppConnectorConfig() (cfg *appctype.AppConnectorConfig, etag string, err error) {
+	ac, err := b.appConnectorOrErr()
+	if err != nil {
+		return nil, "", err
+	}
+	cfg, gen := ac.Config()
+	return cfg, strconv.FormatInt(gen, 10), nil
+}
+
+// AppConnectorStatus returns the status of the services of the app
+// connector, including which part of the tailnet policy granted each.
+func (b *LocalBackend) AppConnectorStatus() ([]appctype.ServiceStatus, error) {
+	ac, err := b.appConnectorOrErr()
+	if err != nil {
+		return nil, err
+	}
+	return ac.Status(), nil
+}
+
+// AppConnectorDrains returns the progress of draining the connections of
+// services removed from the app connector configuration.
+func (b *LocalBackend) AppConnectorDrains() ([]appctype.DrainStatus, error) {
+	ac, err := b.appConnectorOrErr()
+	if err != nil {
+		return nil, err
+	}
+	return ac.Drains(), nil
+}
+
+// checkTLSTermination checks that the SNI proxy services of cfg that
+// terminate TLS only do so for names of this node, which are the only ones
+// it can get certificates for.
+func (b *LocalBackend) checkTLSTermination(cfg *appctype.AppConnectorConfig) error {
+	b.mu.Lock()
+	var certDomains []string
+	if b.netMap != nil {
+		certDomains = b.netMap.DNS.CertDomains
+	}
+	b.mu.Unlock()
+	var errs appctype.ValidationErrors
+	for id, s := range cfg.SNIProxy {
+		if s.TerminateTLS == nil {
+			continue
+		}
+		for i, d := range s.AllowedDomains {
+			if !slices.Contains(certDomains, d) {
+				errs = append(errs, &appctype.ValidationError{
+					Kind:  "sni",
+					ID:    id,
+					Field: fmt.Sprintf("AllowedDomains[%d]", i),
+					Err:   fmt.Errorf("%w: %q", appctype.ErrNotNodeName, d),
+				})
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	slices.SortStableFunc(errs, func(a, b *appctype.ValidationError) int {
+		return strings.Compare(string(a.ID), string(b.ID))
+	})
+	return errs
+}
+
+// SetAppConnectorConfig atomically replaces the active app connector
+// configuration with cfg, returning the etag of the new configuration.
+//
+// If etag is non-empty, cfg is only applied if the active configuration
+// still has that etag; otherwise ErrETagMismatch is returned. If cfg has
+// services listening on overlapping addresses and ports, they are returned
+// along with ErrAppConnectorConflict. If dryRun is true, cfg is only
+// checked for conflicts and never applied. If cfg enables experiments the
+// app connector doesn't support, an error wrapping
+// appctype.ErrUnknownExperiment is returned. If cfg has no conflicts but
+// fails appctype.Validate, its appctype.ValidationErrors are returned.
+func (b *LocalBackend) SetAppConnectorConfig(cfg *appctype.AppConnectorConfig, etag string, dryRun bool) (newETag string, conflicts []appctype.Conflict, err error) {
+	ac, err := b.appConnectorOrErr()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := cfg.CheckExperiments(); err != nil {
+		return "", nil, err
+	}
+	conflicts = cfg.Conflicts()
+	if len(conflicts) == 0 {
+		// Validate reports conflicts too, but they're returned as such.
+		if err := appctype.Validate(cfg); err != nil {
+			return "", nil, err
+		}
+		if err := b.checkTLSTermination(cfg); err != nil {
+			return "", nil, err
+		}
+	}
+	if dryRun {
+		return "", conflicts, nil
+	}
+	if len(conflicts) > 0 {
+		return "", conflicts, ErrAppConnectorConflict
+	}
+	if etag == "" {
+		ac.Configure(cfg)
+		_, gen := ac.Config()
+		return strconv.FormatInt(gen, 10), nil, nil
+	}
+	gen, err := strconv.ParseInt(etag, 10, 64)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %q", ErrETagMismatch, etag)
+	}
+	gen, err = ac.ConfigureIfUnchanged(cfg, gen)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrETagMismatch, err)
+	}
+	return strconv.FormatInt(gen, 10), nil, nil
+}
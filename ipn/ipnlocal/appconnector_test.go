@@ -0,0 +1,156 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"tailscale.com/appc"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/appctype"
+	"tailscale.com/types/netmap"
+)
+
+var _ AppConnector = (*appc.Server)(nil)
+
+func TestSetAppConnectorConfig(t *testing.T) {
+	b := newTestLocalBackend(t)
+	if _, _, err := b.AppConnectorConfig(); !errors.Is(err, ErrNoAppConnector) {
+		t.Fatalf("AppConnectorConfig without app connector: err = %v, want ErrNoAppConnector", err)
+	}
+
+	ac := new(appc.Server)
+	b.SetAppConnector(ac)
+
+	addr := netip.MustParseAddr("100.64.0.1")
+	tcp443 := tailcfg.ProtoPortRange{Proto: 6, Ports: tailcfg.PortRange{First: 443, Last: 443}}
+	cfg := &appctype.AppConnectorConfig{
+		DNAT: map[appctype.ConfigID]appctype.DNATConfig{
+			"a": {Addrs: []netip.Addr{addr}, To: []string{"example.com"}, IP: []tailcfg.ProtoPortRange{tcp443}},
+		},
+		SNIProxy: map[appctype.ConfigID]appctype.SNIProxyConfig{
+			"b": {Addrs: []netip.Addr{addr}, IP: []tailcfg.ProtoPortRange{tcp443}, AllowedDomains: []string{"example.com"}},
+		},
+	}
+
+	// A dry run reports conflicts without applying anything.
+	_, conflicts, err := b.SetAppConnectorConfig(cfg, "", true)
+	if err != nil || len(conflicts) != 1 {
+		t.Fatalf("dry run = %v, %v; want 1 conflict", conflicts, err)
+	}
+	if got, _ := ac.Config(); got != nil {
+		t.Fatalf("dry run applied config")
+	}
+
+	// Configs enabling unknown experiments are refused.
+	unknown := &appctype.AppConnectorConfig{Experiments: map[appctype.Experiment]bool{"teleport": true}}
+	if _, _, err := b.SetAppConnectorConfig(unknown, "", true); !errors.Is(err, appctype.ErrUnknownExperiment) {
+		t.Fatalf("unknown experiment: err = %v, want ErrUnknownExperiment", err)
+	}
+
+	// Invalid configs are refused.
+	invalid := &appctype.AppConnectorConfig{DNAT: map[appctype.ConfigID]appctype.DNATConfig{"nowhere": {}}}
+	if _, _, err := b.SetAppConnectorConfig(invalid, "", false); !errors.Is(err, appctype.ErrEmptyTo) {
+		t.Fatalf("invalid config: err = %v, want ErrEmptyTo", err)
+	}
+
+	// TLS can only be terminated for the node's own names.
+	b.netMap = &netmap.NetworkMap{DNS: tailcfg.DNSConfig{CertDomains: []string{"node.example.ts.net"}}}
+	term := func(domain string) *appctype.AppConnectorConfig {
+		return &appctype.AppConnectorConfig{SNIProxy: map[appctype.ConfigID]appctype.SNIProxyConfig{
+			"term": {AllowedDomains: []string{domain}, TerminateTLS: &appctype.TLSTermination{Backend: "http://10.0.0.1:8080"}},
+		}}
+	}
+	if _, _, err := b.SetAppConnectorConfig(term("example.com"), "", true); !errors.Is(err, appctype.ErrNotNodeName) {
+		t.Fatalf("terminating TLS for another domain: err = %v, want ErrNotNodeName", err)
+	}
+	if _, _, err := b.SetAppConnectorConfig(term("node.example.ts.net"), "", true); err != nil {
+		t.Fatalf("terminating TLS for own name: %v", err)
+	}
+
+	// Conflicting configs are refused.
+	if _, _, err := b.SetAppConnectorConfig(cfg, "", false); !errors.Is(err, ErrAppConnectorConflict) {
+		t.Fatalf("conflicting config: err = %v, want ErrAppConnectorConflict", err)
+	}
+
+	delete(cfg.SNIProxy, "b")
+	_, etag, err := b.AppConnectorConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newETag, _, err := b.SetAppConnectorConfig(cfg, etag, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, gotETag, _ := b.AppConnectorConfig(); got != cfg || gotETag != newETag {
+		t.Fatalf("AppConnectorConfig = %v, %q; want %v, %q", got, gotETag, cfg, newETag)
+	}
+
+	// A stale etag is refused.
+	if _, _, err := b.SetAppConnectorConfig(&appctype.AppConnectorConfig{}, etag, false); !errors.Is(err, ErrETagMismatch) {
+		t.Fatalf("stale etag: err = %v, want ErrETagMismatch", err)
+	}
+	if got, _ := ac.Config(); got != cfg {
+		t.Fatalf("stale etag replaced config")
+	}
+
+	st, err := b.AppConnectorStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st) != 1 || st[0].Service != "dnat/a" {
+		t.Fatalf("AppConnectorStatus = %+v; want dnat/a", st)
+	}
+}
+
+func TestAdvertiseRoute(t *testing.T) {
+	b := newTestLocalBackend(t)
+	b.hostinfo = &tailcfg.Hostinfo{}
+	subnet := netip.MustParsePrefix("192.0.2.0/24")
+	if _, err := b.EditPrefs(&ipn.MaskedPrefs{
+		Prefs:              ipn.Prefs{AdvertiseRoutes: []netip.Prefix{subnet}},
+		AdvertiseRoutesSet: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	learned := []netip.Prefix{
+		netip.MustParsePrefix("192.0.2.1/32"), // covered by subnet
+		netip.MustParsePrefix("198.51.100.1/32"),
+		netip.MustParsePrefix("2001:db8::1/128"),
+	}
+	if err := b.AdvertiseRoute(learned...); err != nil {
+		t.Fatal(err)
+	}
+	want := []netip.Prefix{subnet, learned[1], learned[2]}
+	if got := b.Prefs().AdvertiseRoutes().AsSlice(); !slices.Equal(got, want) {
+		t.Errorf("AdvertiseRoutes = %v, want %v", got, want)
+	}
+
+	// Only learned routes are withdrawn, not the user's, even if the
+	// app connector learned an address they cover.
+	if err := b.UnadvertiseRoute(learned[:2]...); err != nil {
+		t.Fatal(err)
+	}
+	want = []netip.Prefix{subnet, learned[2]}
+	if got := b.Prefs().AdvertiseRoutes().AsSlice(); !slices.Equal(got, want) {
+		t.Errorf("AdvertiseRoutes = %v, want %v", got, want)
+	}
+
+	// Setting an app connector drops the routes learned before, whose
+	// expiry was kept by the previous one.
+	b.SetAppConnector(&appc.Server{})
+	if got, want := b.Prefs().AdvertiseRoutes().AsSlice(), []netip.Prefix{subnet}; !slices.Equal(got, want) {
+		t.Errorf("AdvertiseRoutes = %v, want %v", got, want)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if got := b.learnedRoutesLocked(); len(got) != 0 {
+		t.Errorf("learned routes = %v, want none", got)
+	}
+}
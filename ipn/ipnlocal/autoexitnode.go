@@ -0,0 +1,266 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/set"
+)
+
+// The automatically chosen exit node is only replaced by one with lower
+// latency if the improvement is at least autoExitNodeMinGain and
+// autoExitNodeMinGainFrac of the current latency, so that it doesn't
+// flap between exit nodes with similar latencies.
+const (
+	autoExitNodeMinGain     = 10 * time.Millisecond
+	autoExitNodeMinGainFrac = 0.2
+)
+
+// updateAutoExitNode picks the exit node if ipn.Prefs.AutoExitNode is
+// set, switching to a different one if pickAutoExitNode says to. It's
+// called when the netmap, peers' online state or the network change.
+func (b *LocalBackend) updateAutoExitNode() {
+	b.mu.Lock()
+	prefs := b.pm.CurrentPrefs()
+	enabled := prefs.Valid() && prefs.AutoExitNode() && b.netMap != nil
+	b.mu.Unlock()
+	if !enabled {
+		return
+	}
+
+	var regionLatency map[int]time.Duration
+	if h := b.magicConn().NetcheckHistory(); len(h) > 0 && h[len(h)-1].Report != nil {
+		regionLatency = h[len(h)-1].Report.RegionLatency
+	}
+
+	b.mu.Lock()
+	prefs = b.pm.CurrentPrefs()
+	if !prefs.Valid() || !prefs.AutoExitNode() || b.netMap == nil {
+		b.mu.Unlock()
+		return
+	}
+	// Only exit nodes can be candidates, so look them up in the netmap's
+	// peer index rather than scanning every peer. The netmap's copies may
+	// predate mutations, so use the current ones from b.peers.
+	var peers []tailcfg.NodeView
+	for _, p := range b.netMap.PeersWithRoute(tsaddr.AllIPv4()) {
+		if p, ok := b.peers[p.ID()]; ok {
+			peers = append(peers, p)
+		}
+	}
+	d := pickAutoExitNode(b.logf, b.netMap.SelfNode, peers, regionLatency, prefs.ExitNodeID())
+	d.Time = b.clock.Now()
+	b.autoExitNode = d
+	if d.Selected.IsZero() || d.Selected == prefs.ExitNodeID() {
+		b.mu.Unlock()
+		return
+	}
+	b.logf("auto exit node: %s", d.Reason)
+	p := prefs.AsStruct()
+	p.ExitNodeID = d.Selected
+	p.ExitNodeIP = netip.Addr{}
+	b.setPrefsLockedOnEntry("updateAutoExitNode", p) // does a b.mu.Unlock
+}
+
+// AutoExitNodeDecision returns the latest choice of exit node made
+// because of ipn.Prefs.AutoExitNode, or nil if it's not set or no
+// choice has been made yet.
+func (b *LocalBackend) AutoExitNodeDecision() *apitype.AutoExitNodeDecision {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if prefs := b.pm.CurrentPrefs(); !prefs.Valid() || !prefs.AutoExitNode() {
+		return nil
+	}
+	return b.autoExitNode
+}
+
+// pickAutoExitNode picks the exit node to use from peers: the online exit
+// node permitted by self's NodeAttrAutoExitNodeCandidates with the
+// lowest latency to its home DERP region, per regionLatency, and of those
+// the one with the highest Location.Priority. The current exit node is
+// kept unless it can no longer be picked or another is sufficiently
+// better. If no exit node can be picked, the current one is kept, so that
+// traffic doesn't unexpectedly leave through the local network.
+func pickAutoExitNode(logf logger.Logf, self tailcfg.NodeView, peers []tailcfg.NodeView, regionLatency map[int]time.Duration, current tailcfg.StableNodeID) *apitype.AutoExitNodeDecision {
+	permitted := autoExitNodeCandidatesPolicy(logf, self)
+	d := new(apitype.AutoExitNodeDecision)
+	for _, p := range peers {
+		if !tsaddr.ContainsExitRoutes(p.AllowedIPs()) {
+			continue
+		}
+		c := apitype.AutoExitNodeCandidate{
+			ID:         p.StableID(),
+			Name:       p.ComputedName(),
+			DERPRegion: derpRegionOf(p.DERP()),
+		}
+		c.Latency = regionLatency[c.DERPRegion]
+		if loc := p.Hostinfo().Location(); loc != nil {
+			c.Priority = loc.Priority
+		}
+		switch {
+		case p.Expired():
+			c.Excluded = "key expired"
+		case p.Online() != nil && !*p.Online():
+			c.Excluded = "offline"
+		case permitted != nil && !permitted(p):
+			c.Excluded = "not permitted by policy"
+		}
+		d.Candidates = append(d.Candidates, c)
+	}
+	slices.SortFunc(d.Candidates, compareAutoExitNodeCandidates)
+
+	var best, cur *apitype.AutoExitNodeCandidate
+	for i := range d.Candidates {
+		c := &d.Candidates[i]
+		if c.Excluded != "" {
+			continue
+		}
+		if best == nil {
+			best = c
+		}
+		if c.ID == current {
+			cur = c
+		}
+	}
+	switch {
+	case best == nil && current.IsZero():
+		d.Reason = "no exit node can be chosen"
+	case best == nil:
+		d.Selected = current
+		d.Reason = fmt.Sprintf("keeping %s: no other exit node can be chosen", current)
+	case cur != nil && !worthSwitching(cur, best):
+		d.Selected = cur.ID
+		if cur == best {
+			d.Reason = fmt.Sprintf("keeping %s: %s", cur.Name, describeAutoExitNode(cur))
+		} else {
+			d.Reason = fmt.Sprintf("keeping %s: %s, not much worse than %s", cur.Name, describeAutoExitNode(cur), best.Name)
+		}
+	default:
+		d.Selected = best.ID
+		d.Reason = fmt.Sprintf("chose %s: %s", best.Name, describeAutoExitNode(best))
+		if !current.IsZero() && cur == nil {
+			d.Reason += fmt.Sprintf("; %s can no longer be chosen", current)
+		}
+	}
+	return d
+}
+
+// compareAutoExitNodeCandidates orders candidates best first: eligible
+// ones first, then by latency, with unknown latencies last, then by
+// priority, and otherwise by name and ID.
+func compareAutoExitNodeCandidates(a, b apitype.AutoExitNodeCandidate) int {
+	if (a.Excluded == "") != (b.Excluded == "") {
+		if a.Excluded == "" {
+			return -1
+		}
+		return 1
+	}
+	if (a.Latency == 0) != (b.Latency == 0) {
+		if a.Latency != 0 {
+			return -1
+		}
+		return 1
+	}
+	if c := cmp.Compare(a.Latency, b.Latency); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(b.Priority, a.Priority); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.Name, b.Name); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// worthSwitching reports whether best is enough of an improvement over
+// cur to switch exit nodes.
+func worthSwitching(cur, best *apitype.AutoExitNodeCandidate) bool {
+	if cur == best {
+		return false
+	}
+	if cur.Latency == 0 {
+		// Unknown latency; best is only better if its latency is known.
+		return best.Latency != 0
+	}
+	if best.Latency == 0 {
+		return false
+	}
+	gain := cur.Latency - best.Latency
+	return gain >= autoExitNodeMinGain && float64(gain) >= autoExitNodeMinGainFrac*float64(cur.Latency)
+}
+
+func describeAutoExitNode(c *apitype.AutoExitNodeCandidate) string {
+	var sb strings.Builder
+	if c.Latency != 0 {
+		fmt.Fprintf(&sb, "%v latency via DERP region %d", c.Latency.Round(time.Millisecond), c.DERPRegion)
+	} else {
+		sb.WriteString("latency unknown")
+	}
+	if c.Priority != 0 {
+		fmt.Fprintf(&sb, ", priority %d", c.Priority)
+	}
+	return sb.String()
+}
+
+// derpRegionOf returns the DERP region of a tailcfg.Node.DERP value, or
+// zero if there's none.
+func derpRegionOf(derp string) int {
+	s, ok := strings.CutPrefix(derp, tailcfg.DerpMagicIP+":")
+	if !ok {
+		return 0
+	}
+	rid, _ := strconv.Atoi(s)
+	return rid
+}
+
+// autoExitNodeCandidatesPolicy returns a func reporting whether an exit
+// node may be chosen automatically per self's
+// NodeAttrAutoExitNodeCandidates, or nil if any may.
+func autoExitNodeCandidatesPolicy(logf logger.Logf, self tailcfg.NodeView) func(tailcfg.NodeView) bool {
+	if !self.Valid() {
+		return nil
+	}
+	vals, ok := self.CapMap().GetOk(tailcfg.NodeAttrAutoExitNodeCandidates)
+	if !ok {
+		return nil
+	}
+	ids := set.Set[tailcfg.StableNodeID]{}
+	tags := set.Set[string]{}
+	for i := 0; i < vals.Len(); i++ {
+		var v string
+		if err := json.Unmarshal([]byte(vals.At(i)), &v); err != nil {
+			logf("invalid %q node attribute: %v", tailcfg.NodeAttrAutoExitNodeCandidates, err)
+			continue
+		}
+		if strings.HasPrefix(v, "tag:") {
+			tags.Add(v)
+		} else {
+			ids.Add(tailcfg.StableNodeID(v))
+		}
+	}
+	return func(p tailcfg.NodeView) bool {
+		if ids.Contains(p.StableID()) {
+			return true
+		}
+		for i := 0; i < p.Tags().Len(); i++ {
+			if tags.Contains(p.Tags().At(i)) {
+				return true
+			}
+		}
+		return false
+	}
+}
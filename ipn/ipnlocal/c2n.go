@@ -121,6 +121,21 @@ func (b *LocalBackend) handleC2N(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		writeJSON(w, res)
+	case "/app-connector/status":
+		if r.Method != httpm.GET {
+			http.Error(w, "bad method", http.StatusMethodNotAllowed)
+			return
+		}
+		st, err := b.AppConnectorStatus()
+		if errors.Is(err, ErrNoAppConnector) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, st)
 	case "/sockstats":
 		if r.Method != "POST" {
 			http.Error(w, "bad method", http.StatusMethodNotAllowed)
@@ -168,6 +183,10 @@ func (b *LocalBackend) handleC2NUpdatePost(w http.ResponseWriter, r *http.Reques
 		res.Err = "not supported"
 		return
 	}
+	if !b.allowDisruptive("auto-update") {
+		res.Err = "outside maintenance window"
+		return
+	}
 
 	// Check if update was already started, and mark as started.
 	if !b.trySetC2NUpdateStarted() {
@@ -41,6 +41,7 @@
 	"tailscale.com/ipn/store"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/multierr"
 	"tailscale.com/version"
 	"tailscale.com/version/distro"
 )
@@ -80,6 +81,61 @@ func (b *LocalBackend) certDir() (string, error) {
 
 var acmeDebug = envknob.RegisterBool("TS_DEBUG_ACME")
 
+// certExpiryWarning is how long before a cached cert expires that the
+// "cert-expiry" health check fails. Certs are normally renewed well
+// before then, so it failing means they're not being renewed.
+const certExpiryWarning = 14 * 24 * time.Hour
+
+// checkCertExpiry is the "cert-expiry" health check. It reports the
+// cached certs of the node's cert domains that have expired or soon will.
+func (b *LocalBackend) checkCertExpiry() error {
+	b.mu.Lock()
+	nm := b.netMap
+	b.mu.Unlock()
+	if nm == nil || len(nm.DNS.CertDomains) == 0 {
+		return nil
+	}
+	cs, err := b.getCertStore()
+	if err != nil {
+		// No certs can have been cached.
+		return nil
+	}
+	now := b.clock.Now()
+	var errs []error
+	for _, domain := range nm.DNS.CertDomains {
+		pair, err := getCertPEMCached(cs, domain, now)
+		if errors.Is(err, errCertExpired) {
+			errs = append(errs, fmt.Errorf("certificate for %s has expired", domain))
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		notAfter, err := certNotAfter(pair.CertPEM)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("certificate for %s: %w", domain, err))
+			continue
+		}
+		if left := notAfter.Sub(now); left < certExpiryWarning {
+			errs = append(errs, fmt.Errorf("certificate for %s expires in %v", domain, left.Round(time.Hour)))
+		}
+	}
+	return multierr.New(errs...)
+}
+
+// certNotAfter returns the expiry time of the first cert in certPEM.
+func certNotAfter(certPEM []byte) (time.Time, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return time.Time{}, fmt.Errorf("parsing certificate PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing certificate: %w", err)
+	}
+	return cert.NotAfter, nil
+}
+
 // GetCertPEM gets the TLSCertKeyPair for domain, either from cache or via the
 // ACME process. ACME process is used for new domain certs, existing expired
 // certs or existing certs that should get renewed due to upcoming expiry.
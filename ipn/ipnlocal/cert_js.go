@@ -12,6 +12,8 @@ type TLSCertKeyPair struct {
 	CertPEM, KeyPEM []byte
 }
 
+func (b *LocalBackend) checkCertExpiry() error { return nil }
+
 func (b *LocalBackend) GetCertPEM(ctx context.Context, domain string) (*TLSCertKeyPair, error) {
 	return nil, errors.New("not implemented for js/wasm")
 }
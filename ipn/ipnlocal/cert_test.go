@@ -199,3 +199,25 @@ func TestShouldStartDomainRenewal(t *testing.T) {
 		})
 	}
 }
+
+func TestCertNotAfter(t *testing.T) {
+	certPEM, err := certTestFS.ReadFile("testdata/example.com.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := certNotAfter(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(cert.NotAfter) {
+		t.Errorf("certNotAfter = %v; want %v", got, cert.NotAfter)
+	}
+	if _, err := certNotAfter([]byte("not a cert")); err == nil {
+		t.Error("certNotAfter of garbage succeeded")
+	}
+}
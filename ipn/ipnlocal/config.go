@@ -0,0 +1,209 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+)
+
+var (
+	warnConfigLoad = health.NewWarnable(health.WithCheck("config-file", health.SeverityError, health.Remediation{
+		Code: "fix-config-file",
+		Text: "Fix the config file; until then, the last one loaded stays in effect.",
+	}))
+	warnConfigDrift = health.NewWarnable(health.WithCheck("config-drift", health.SeverityWarning, health.Remediation{
+		Code: "reload-config",
+		Text: "Undo the local changes, or update the config file to match them.",
+	}))
+)
+
+// configPollInterval is how often tailscaled checks its config file for
+// changes and the node's config for drift from it.
+const configPollInterval = 10 * time.Second
+
+// watchConfigFile checks the config file every configPollInterval until
+// b shuts down. See checkConfigFile.
+func (b *LocalBackend) watchConfigFile() {
+	t, tc := b.clock.NewTicker(configPollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-tc:
+		}
+		b.checkConfigFile()
+	}
+}
+
+// checkConfigFile reloads the config file if it changed on disk.
+// Otherwise, if the config is locked, it converges the node's config
+// toward the file's again, in case something other than the user, such
+// as a profile switch, changed it. If it isn't locked, drift from the
+// file is only reported.
+func (b *LocalBackend) checkConfigFile() {
+	b.mu.Lock()
+	conf := b.conf
+	b.mu.Unlock()
+	if conf == nil {
+		return
+	}
+	raw, err := os.ReadFile(conf.Path)
+	if err != nil {
+		warnConfigLoad.Set(fmt.Errorf("error reading config file: %w", err))
+	} else if !bytes.Equal(raw, conf.Raw) {
+		b.logf("config: %s changed; reloading", conf.Path)
+		if _, err := b.ReloadConfig(); err != nil {
+			b.logf("config: %v", err)
+		}
+		return
+	}
+	if conf.Parsed.Locked.EqualBool(false) {
+		b.updateConfigDrift()
+		return
+	}
+	b.mu.Lock()
+	b.applyConfigLockedOnEntry()
+}
+
+// applyConfigLockedOnEntry applies the prefs, serve config and app
+// connector config of b.conf, and then reports any drift from it that
+// remains, such as a serve config that can't be applied until there's a
+// netmap.
+//
+// b.mu must be held on entry. It's released before returning.
+func (b *LocalBackend) applyConfigLockedOnEntry() {
+	c := &b.conf.Parsed
+	if c.ServeConfigTemp != nil && b.netMap != nil && !b.serveConfigMatchesLocked(c.ServeConfigTemp) {
+		b.logf("config: applying serve config")
+		if err := b.setServeConfigLocked(c.ServeConfigTemp, ""); err != nil {
+			b.logf("config: applying serve config: %v", err)
+		}
+	}
+	p, err := b.configPrefsLocked()
+	if err != nil {
+		b.mu.Unlock()
+		b.logf("config: %v", err)
+	} else if p.View().Equals(b.pm.CurrentPrefs()) {
+		b.mu.Unlock()
+	} else {
+		b.setPrefsLockedOnEntry("config", p) // does a b.mu.Unlock
+	}
+
+	if c.AppConnector != nil && !b.appConnectorConfigMatches(c) {
+		b.logf("config: applying app connector config")
+		if _, _, err := b.SetAppConnectorConfig(c.AppConnector, "", false); err != nil {
+			b.logf("config: applying app connector config: %v", err)
+		}
+	}
+	b.updateConfigDrift()
+}
+
+// configPrefsLocked returns the current prefs with those of b.conf
+// applied, resolving the exit node as setPrefsLockedOnEntry would.
+//
+// b.mu must be held.
+func (b *LocalBackend) configPrefsLocked() (*ipn.Prefs, error) {
+	mp, err := b.conf.Parsed.ToPrefs()
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", b.conf.Path, err)
+	}
+	p := b.pm.CurrentPrefs().AsStruct()
+	p.ApplyEdits(&mp)
+	setExitNodeID(p, b.netMap)
+	return p, nil
+}
+
+// updateConfigDrift sets or clears the health warning about the node's
+// config having drifted from the config file.
+func (b *LocalBackend) updateConfigDrift() {
+	drift := b.configDrift()
+	if len(drift) == 0 {
+		warnConfigDrift.Set(nil)
+		return
+	}
+	b.mu.Lock()
+	path := b.conf.Path
+	b.mu.Unlock()
+	warnConfigDrift.Set(fmt.Errorf("config differs from config file %s: %s", path, strings.Join(drift, ", ")))
+}
+
+// configDrift returns the names of the prefs and other settings that
+// differ from those in the config file, or nil if none do or there's no
+// config file.
+func (b *LocalBackend) configDrift() []string {
+	b.mu.Lock()
+	if b.conf == nil {
+		b.mu.Unlock()
+		return nil
+	}
+	c := &b.conf.Parsed
+	var drift []string
+	if want, err := b.configPrefsLocked(); err == nil {
+		drift = prefsDiff(b.pm.CurrentPrefs().AsStruct(), want)
+	}
+	// The serve config can't be applied without a netmap, so don't
+	// report it until there is one.
+	if c.ServeConfigTemp != nil && b.netMap != nil && !b.serveConfigMatchesLocked(c.ServeConfigTemp) {
+		drift = append(drift, "ServeConfig")
+	}
+	b.mu.Unlock()
+
+	if c.AppConnector != nil && !b.appConnectorConfigMatches(c) {
+		drift = append(drift, "AppConnector")
+	}
+	return drift
+}
+
+// prefsDiff returns the names of the fields of want that differ from
+// those of got, per ipn.Prefs.Equals.
+func prefsDiff(got, want *ipn.Prefs) []string {
+	if got.Equals(want) {
+		return nil
+	}
+	var diff []string
+	wv := reflect.ValueOf(want).Elem()
+	for i := 0; i < wv.NumField(); i++ {
+		p := got.Clone()
+		reflect.ValueOf(p).Elem().Field(i).Set(wv.Field(i))
+		if !p.Equals(got) {
+			diff = append(diff, wv.Type().Field(i).Name)
+		}
+	}
+	return diff
+}
+
+// serveConfigMatchesLocked reports whether the current serve config is
+// sc.
+//
+// b.mu must be held.
+func (b *LocalBackend) serveConfigMatchesLocked(sc *ipn.ServeConfig) bool {
+	return jsonEqual(b.serveConfig, sc)
+}
+
+// appConnectorConfigMatches reports whether the app connector is
+// running with the app connector config of c.
+func (b *LocalBackend) appConnectorConfigMatches(c *ipn.ConfigVAlpha) bool {
+	cfg, _, err := b.AppConnectorConfig()
+	return err == nil && jsonEqual(cfg, c.AppConnector)
+}
+
+// jsonEqual reports whether a and b have the same JSON encoding.
+func jsonEqual(a, b any) bool {
+	aj, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bj, err := json.Marshal(b)
+	return err == nil && bytes.Equal(aj, bj)
+}
@@ -0,0 +1,330 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/syncs"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/ptr"
+	"tailscale.com/util/rands"
+)
+
+// connApprovalTimeout is how long a ConnApprovalRequest waits to be
+// accepted or rejected.
+const connApprovalTimeout = time.Minute
+
+// maxPendingConnApprovals is the most ConnApprovalRequests that can be
+// pending at once, so that peers can't make tailscaled use unbounded
+// memory by connecting to many ports. Connections beyond it are
+// rejected without asking.
+const maxPendingConnApprovals = 64
+
+// ErrNoConnApproval is returned by DecideConnApproval when there's no
+// pending ConnApprovalRequest with the given ID, such as because it
+// expired.
+var ErrNoConnApproval = errors.New("no such pending connection approval request")
+
+// connApprovalPeer is a peer that ConnApprovalRules can be about.
+type connApprovalPeer struct {
+	id   tailcfg.StableNodeID
+	name string
+}
+
+// maxConnApprovalVerdicts is the most verdicts connApprovals caches.
+// Once it's reached, the cache starts over.
+const maxConnApprovalVerdicts = 4096
+
+// connApprovalFlow is what approve caches its verdicts by.
+type connApprovalFlow struct {
+	src   netip.Addr
+	proto ipproto.Proto
+	port  uint16
+}
+
+// connApprovalKey is what a pending ConnApprovalRequest is about.
+type connApprovalKey struct {
+	peer  tailcfg.StableNodeID
+	proto ipproto.Proto
+	port  uint16
+}
+
+// connApprovals is the state of Prefs.ConnApproval. Its approve method
+// is the packet filter's filter.ApproveFunc, so it has its own lock
+// rather than using LocalBackend.mu.
+type connApprovals struct {
+	logf  logger.Logf
+	clock tstime.Clock
+	store ipn.StateStore
+	send  func(ipn.Notify)
+
+	mu      sync.Mutex
+	peers   map[netip.Addr]connApprovalPeer // by peer Tailscale IP
+	rules   []ipn.ConnApprovalRule
+	pending map[connApprovalKey]*ipn.ConnApprovalRequest
+
+	// verdicts caches approve's verdicts on the flows that peers and
+	// rules decide, so that packets the filter doesn't cache flows
+	// for, such as inbound UDP, don't take mu and scan the rules. It's
+	// only added to with mu held, and cleared whenever peers or rules
+	// change.
+	verdicts syncs.Map[connApprovalFlow, bool]
+}
+
+func newConnApprovals(logf logger.Logf, clock tstime.Clock, store ipn.StateStore, send func(ipn.Notify)) *connApprovals {
+	ca := &connApprovals{
+		logf:  logf,
+		clock: clock,
+		store: store,
+		send:  send,
+	}
+	bs, err := store.ReadState(ipn.ConnApprovalsStateKey)
+	if err == nil {
+		err = json.Unmarshal(bs, &ca.rules)
+	}
+	if err != nil && !errors.Is(err, ipn.ErrStateNotExist) {
+		logf("connapproval: reading rules: %v", err)
+	}
+	return ca
+}
+
+// setPeers sets the peers whose incoming connections may be approved.
+// Connections from other addresses, such as ones behind subnet routers,
+// are always rejected.
+func (ca *connApprovals) setPeers(peers map[tailcfg.NodeID]tailcfg.NodeView) {
+	m := make(map[netip.Addr]connApprovalPeer)
+	for _, p := range peers {
+		for i := range p.Addresses().LenIter() {
+			if pfx := p.Addresses().At(i); pfx.IsSingleIP() {
+				m[pfx.Addr()] = connApprovalPeer{id: p.StableID(), name: p.Name()}
+			}
+		}
+	}
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	ca.peers = m
+	ca.verdicts.Clear()
+}
+
+// reset forgets the pending requests and the peers, for when
+// Prefs.ConnApproval is turned off.
+func (ca *connApprovals) reset() {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	ca.peers = nil
+	ca.pending = nil
+	ca.verdicts.Clear()
+}
+
+// approve is the filter.ApproveFunc of Prefs.ConnApproval. It reports
+// whether the stored rules allow the flow, and if none cover it, asks
+// for approval with a ConnApprovalRequest.
+func (ca *connApprovals) approve(proto ipproto.Proto, src, dst netip.AddrPort) bool {
+	flow := connApprovalFlow{src.Addr(), proto, dst.Port()}
+	if allow, ok := ca.verdicts.Load(flow); ok {
+		return allow
+	}
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	p, ok := ca.peers[src.Addr()]
+	if !ok {
+		ca.cacheVerdictLocked(flow, false)
+		return false
+	}
+	if r, ok := ca.ruleLocked(p.id, proto, dst.Port()); ok {
+		ca.cacheVerdictLocked(flow, r.Allow)
+		return r.Allow
+	}
+	key := connApprovalKey{p.id, proto, dst.Port()}
+	if _, ok := ca.pending[key]; ok || len(ca.pending) >= maxPendingConnApprovals {
+		return false
+	}
+	req := &ipn.ConnApprovalRequest{
+		ID:       rands.HexString(16),
+		Peer:     p.id,
+		PeerName: p.name,
+		Proto:    proto,
+		Src:      src,
+		Dst:      dst,
+		Expires:  ca.clock.Now().Add(connApprovalTimeout),
+	}
+	if ca.pending == nil {
+		ca.pending = make(map[connApprovalKey]*ipn.ConnApprovalRequest)
+	}
+	ca.pending[key] = req
+	ca.clock.AfterFunc(connApprovalTimeout, func() { ca.expire(key, req.ID) })
+	ca.logf("connapproval: %v connection from %s (%v) to %v awaiting approval", proto, p.name, src, dst)
+	n := ipn.Notify{ConnApproval: ptr.To(*req)}
+	go ca.send(n)
+	return false
+}
+
+// cacheVerdictLocked caches allow as the verdict on flow.
+//
+// ca.mu must be held.
+func (ca *connApprovals) cacheVerdictLocked(flow connApprovalFlow, allow bool) {
+	if ca.verdicts.Len() >= maxConnApprovalVerdicts {
+		ca.verdicts.Clear()
+	}
+	ca.verdicts.Store(flow, allow)
+}
+
+// ruleLocked returns the most specific rule covering connections from
+// peer using proto to port, if any.
+//
+// ca.mu must be held.
+func (ca *connApprovals) ruleLocked(peer tailcfg.StableNodeID, proto ipproto.Proto, port uint16) (_ ipn.ConnApprovalRule, ok bool) {
+	specificity := func(r ipn.ConnApprovalRule) int {
+		n := 0
+		if r.Port != 0 {
+			n += 2
+		}
+		if r.Proto != 0 {
+			n++
+		}
+		return n
+	}
+	best := -1
+	for i, r := range ca.rules {
+		if r.Matches(peer, proto, port) && (best < 0 || specificity(r) > specificity(ca.rules[best])) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return ipn.ConnApprovalRule{}, false
+	}
+	return ca.rules[best], true
+}
+
+// expire drops the pending request for key if it's still the one with
+// the given ID.
+func (ca *connApprovals) expire(key connApprovalKey, id string) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if req, ok := ca.pending[key]; ok && req.ID == id {
+		delete(ca.pending, key)
+		ca.logf("connapproval: request %s expired", id)
+	}
+}
+
+// decide accepts or rejects the pending request per d, storing the
+// decision as a rule.
+func (ca *connApprovals) decide(d ipn.ConnApprovalDecision) error {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	var req *ipn.ConnApprovalRequest
+	for _, r := range ca.pending {
+		if r.ID == d.ID {
+			req = r
+			break
+		}
+	}
+	if req == nil {
+		return ErrNoConnApproval
+	}
+	rule := ipn.ConnApprovalRule{
+		Peer:  req.Peer,
+		Proto: req.Proto,
+		Port:  req.Dst.Port(),
+		Allow: d.Allow,
+	}
+	if d.AllPorts {
+		rule.Proto, rule.Port = 0, 0
+	}
+	rules := slices.DeleteFunc(slices.Clone(ca.rules), func(r ipn.ConnApprovalRule) bool {
+		// Replace the rules the new one supersedes.
+		return r.Peer == rule.Peer && (d.AllPorts || r.Proto == rule.Proto && r.Port == rule.Port)
+	})
+	rules = append(rules, rule)
+	if err := ca.storeRulesLocked(rules); err != nil {
+		return err
+	}
+	for k, r := range ca.pending {
+		if rule.Matches(r.Peer, r.Proto, r.Dst.Port()) {
+			delete(ca.pending, k)
+		}
+	}
+	verdict := "rejected"
+	if d.Allow {
+		verdict = "accepted"
+	}
+	ca.logf("connapproval: %s request %s; rule %+v", verdict, req.ID, rule)
+	return nil
+}
+
+// forget deletes the rules about peer.
+func (ca *connApprovals) forget(peer tailcfg.StableNodeID) error {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	rules := slices.DeleteFunc(slices.Clone(ca.rules), func(r ipn.ConnApprovalRule) bool {
+		return r.Peer == peer
+	})
+	if len(rules) == len(ca.rules) {
+		return fmt.Errorf("no connection approval rules for %q", peer)
+	}
+	return ca.storeRulesLocked(rules)
+}
+
+// storeRulesLocked replaces the rules with rules and stores them.
+//
+// ca.mu must be held.
+func (ca *connApprovals) storeRulesLocked(rules []ipn.ConnApprovalRule) error {
+	bs, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	if err := ipn.WriteState(ca.store, ipn.ConnApprovalsStateKey, bs); err != nil {
+		return err
+	}
+	ca.rules = rules
+	ca.verdicts.Clear()
+	return nil
+}
+
+// list returns the pending requests and the rules.
+func (ca *connApprovals) list() ipn.ConnApprovals {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	ret := ipn.ConnApprovals{
+		Pending: make([]ipn.ConnApprovalRequest, 0, len(ca.pending)),
+		Rules:   slices.Clone(ca.rules),
+	}
+	for _, r := range ca.pending {
+		ret.Pending = append(ret.Pending, *r)
+	}
+	slices.SortFunc(ret.Pending, func(a, b ipn.ConnApprovalRequest) int {
+		return a.Expires.Compare(b.Expires)
+	})
+	return ret
+}
+
+// ConnApprovals returns the incoming connections awaiting approval and
+// the decisions made on earlier ones. See ipn.Prefs.ConnApproval.
+func (b *LocalBackend) ConnApprovals() ipn.ConnApprovals {
+	return b.connApprovals.list()
+}
+
+// DecideConnApproval accepts or rejects a pending incoming connection,
+// storing the decision for later connections like it. It returns
+// ErrNoConnApproval if there's no such pending connection.
+func (b *LocalBackend) DecideConnApproval(d ipn.ConnApprovalDecision) error {
+	return b.connApprovals.decide(d)
+}
+
+// ForgetConnApprovals deletes the stored decisions on incoming
+// connections from peer, so that they need approval again.
+func (b *LocalBackend) ForgetConnApprovals(peer tailcfg.StableNodeID) error {
+	return b.connApprovals.forget(peer)
+}
@@ -0,0 +1,132 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+
+	"tailscale.com/types/key"
+	"tailscale.com/util/mak"
+	"tailscale.com/wgengine/wgcfg"
+)
+
+// peerDialRoute is a route to a single IP through a peer, added with
+// AddPeerDialRoute.
+type peerDialRoute struct {
+	peer key.NodePublic
+	refs int // number of AddPeerDialRoute calls not yet released
+}
+
+// AddPeerDialRoute routes traffic to dst through peer, such as an exit
+// node or subnet router other than the ones that prefs select, until the
+// returned release func is called. It's for connections dialed through a
+// specific peer, as by tsnet.
+//
+// WireGuard picks the peer of each packet by its destination IP, and only
+// accepts packets from a peer whose AllowedIPs contain their source, so
+// the route is added to peer's AllowedIPs: while it's in place, all
+// traffic to dst goes through peer. Only the WireGuard config changes;
+// routes and DNS are left alone. If dst already routes through peer, as
+// when peer is the selected exit node, nothing changes at all.
+//
+// Routes are reference-counted per destination, and a destination can only
+// be routed through one peer at a time.
+func (b *LocalBackend) AddPeerDialRoute(peer key.NodePublic, dst netip.Addr) (release func(), err error) {
+	pfx := netip.PrefixFrom(dst, dst.BitLen())
+
+	b.dialRouteMu.Lock()
+	defer b.dialRouteMu.Unlock()
+	b.mu.Lock()
+	r, ok := b.peerDialRoutes[pfx]
+	if ok && r.peer != peer {
+		b.mu.Unlock()
+		return nil, fmt.Errorf("%v is already routed through peer %v", dst, r.peer.ShortString())
+	}
+	if !ok {
+		r = &peerDialRoute{peer: peer}
+		mak.Set(&b.peerDialRoutes, pfx, r)
+	}
+	r.refs++
+	b.mu.Unlock()
+	if !ok && !b.routesThroughPeer(peer, dst) {
+		b.authReconfig()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.dialRouteMu.Lock()
+			defer b.dialRouteMu.Unlock()
+			b.mu.Lock()
+			r.refs--
+			removed := r.refs == 0
+			if removed {
+				delete(b.peerDialRoutes, pfx)
+			}
+			b.mu.Unlock()
+			if removed {
+				// Only reconfigure if the route was added to the WireGuard
+				// config, rather than already there.
+				if p, ok := b.e.PeerForIP(dst); ok && p.Route == pfx {
+					b.authReconfig()
+				}
+			}
+		})
+	}, nil
+}
+
+// routesThroughPeer reports whether the engine routes ip through peer.
+func (b *LocalBackend) routesThroughPeer(peer key.NodePublic, ip netip.Addr) bool {
+	p, ok := b.e.PeerForIP(ip)
+	return ok && !p.IsSelf && p.Node.Key() == peer
+}
+
+// peerDialRoutesLocked returns the routes of AddPeerDialRoute by peer.
+//
+// b.mu must be held.
+func (b *LocalBackend) peerDialRoutesLocked() map[key.NodePublic][]netip.Prefix {
+	var ret map[key.NodePublic][]netip.Prefix
+	for pfx, r := range b.peerDialRoutes {
+		mak.Set(&ret, r.peer, append(ret[r.peer], pfx))
+	}
+	return ret
+}
+
+// addPeerDialRoutes adds routes, the routes of AddPeerDialRoute by peer,
+// to the AllowedIPs of the peers of cfg, other than those that cfg
+// already routes through the same peer.
+func addPeerDialRoutes(cfg *wgcfg.Config, routes map[key.NodePublic][]netip.Prefix) {
+	var add map[key.NodePublic][]netip.Prefix
+	for peer, rs := range routes {
+		for _, r := range rs {
+			if routedPeer(cfg, r.Addr()) != peer {
+				mak.Set(&add, peer, append(add[peer], r))
+			}
+		}
+	}
+	for i := range cfg.Peers {
+		p := &cfg.Peers[i]
+		if rs := add[p.PublicKey]; len(rs) > 0 {
+			p.AllowedIPs = append(slices.Clip(p.AllowedIPs), rs...)
+		}
+	}
+}
+
+// routedPeer returns the peer that cfg routes ip through, the one with the
+// most specific AllowedIP containing it, or the zero key if none.
+func routedPeer(cfg *wgcfg.Config, ip netip.Addr) key.NodePublic {
+	var best netip.Prefix
+	var ret key.NodePublic
+	for _, p := range cfg.Peers {
+		for _, pfx := range p.AllowedIPs {
+			if pfx.Contains(ip) && (!best.IsValid() || pfx.Bits() > best.Bits()) {
+				best, ret = pfx, p.PublicKey
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,93 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	"tailscale.com/types/key"
+	"tailscale.com/wgengine/wgcfg"
+)
+
+func TestAddPeerDialRoute(t *testing.T) {
+	b := newTestLocalBackend(t)
+	exit1, exit2 := key.NewNode().Public(), key.NewNode().Public()
+	dst := netip.MustParseAddr("203.0.113.1")
+	want := func(routes map[key.NodePublic][]netip.Prefix) {
+		t.Helper()
+		b.mu.Lock()
+		got := b.peerDialRoutesLocked()
+		b.mu.Unlock()
+		if len(got) != len(routes) {
+			t.Fatalf("routes = %v; want %v", got, routes)
+		}
+		for k, v := range routes {
+			if !slices.Equal(got[k], v) {
+				t.Fatalf("routes = %v; want %v", got, routes)
+			}
+		}
+	}
+
+	release1, err := b.AddPeerDialRoute(exit1, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release2, err := b.AddPeerDialRoute(exit1, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.AddPeerDialRoute(exit2, dst); err == nil {
+		t.Error("routing the same destination through another peer succeeded")
+	}
+	want(map[key.NodePublic][]netip.Prefix{exit1: {netip.MustParsePrefix("203.0.113.1/32")}})
+
+	release1()
+	release1() // no-op
+	want(map[key.NodePublic][]netip.Prefix{exit1: {netip.MustParsePrefix("203.0.113.1/32")}})
+	release2()
+	want(nil)
+
+	if _, err := b.AddPeerDialRoute(exit2, dst); err != nil {
+		t.Errorf("routing through another peer after release: %v", err)
+	}
+}
+
+func TestAddPeerDialRoutes(t *testing.T) {
+	exit, other := key.NewNode().Public(), key.NewNode().Public()
+	own := []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32")}
+	cfg := &wgcfg.Config{Peers: []wgcfg.Peer{
+		{PublicKey: exit, AllowedIPs: own},
+		{PublicKey: other, AllowedIPs: []netip.Prefix{netip.MustParsePrefix("100.64.0.3/32")}},
+	}}
+	route := netip.MustParsePrefix("203.0.113.1/32")
+	addPeerDialRoutes(cfg, map[key.NodePublic][]netip.Prefix{exit: {route}})
+
+	if got, want := cfg.Peers[0].AllowedIPs, []netip.Prefix{own[0], route}; !slices.Equal(got, want) {
+		t.Errorf("exit AllowedIPs = %v; want %v", got, want)
+	}
+	if got := cfg.Peers[1].AllowedIPs; len(got) != 1 {
+		t.Errorf("other AllowedIPs = %v; want unchanged", got)
+	}
+	if len(own) != 1 || own[0] != netip.MustParsePrefix("100.64.0.2/32") {
+		t.Errorf("original AllowedIPs modified: %v", own)
+	}
+
+	// Routes that cfg already has through the same peer aren't added.
+	cfg = &wgcfg.Config{Peers: []wgcfg.Peer{
+		{PublicKey: exit, AllowedIPs: []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0")}},
+		{PublicKey: other, AllowedIPs: []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")}},
+	}}
+	addPeerDialRoutes(cfg, map[key.NodePublic][]netip.Prefix{
+		exit:  {route},
+		other: {netip.MustParsePrefix("203.0.113.2/32")},
+	})
+	if got, want := cfg.Peers[0].AllowedIPs, []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), route}; !slices.Equal(got, want) {
+		t.Errorf("exit AllowedIPs = %v; want %v", got, want)
+	}
+	if got := cfg.Peers[1].AllowedIPs; len(got) != 1 {
+		t.Errorf("other AllowedIPs = %v; want unchanged", got)
+	}
+}
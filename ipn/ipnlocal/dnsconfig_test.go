@@ -5,12 +5,15 @@
 
 import (
 	"encoding/json"
+	"net"
 	"net/netip"
 	"reflect"
 	"testing"
+	"time"
 
 	"tailscale.com/ipn"
 	"tailscale.com/net/dns"
+	"tailscale.com/net/dns/resolver"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstest"
 	"tailscale.com/types/dnstype"
@@ -167,6 +170,62 @@ func TestDNSConfigForNetmap(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "published_records",
+			nm: &netmap.NetworkMap{
+				Name: "myname.net",
+				SelfNode: (&tailcfg.Node{
+					Addresses: ipps("100.101.101.101"),
+				}).View(),
+				DNS: tailcfg.DNSConfig{
+					ExtraRecords: []tailcfg.DNSRecord{
+						{Name: "docs.com", Type: "CNAME", Value: "peera.net"},
+					},
+				},
+			},
+			peers: nodeViews([]*tailcfg.Node{
+				{
+					ID:        1,
+					Name:      "peera.net",
+					Addresses: ipps("100.102.0.1"),
+					CapMap:    tailcfg.NodeCapMap{tailcfg.NodeAttrPublishDNSRecords: nil},
+					Hostinfo: (&tailcfg.Hostinfo{
+						DNSRecords: []tailcfg.DNSRecord{
+							{Name: "_ssh._tcp", Type: "SRV", Value: "0 5 22 @"},
+							{Name: "@", Type: "TXT", Value: "owner=infra"},
+							{Name: "WWW", Type: "CNAME", Value: "web.example.com."},
+						},
+					}).View(),
+				},
+				{
+					ID:        2,
+					Name:      "b.net",
+					Addresses: ipps("100.102.0.2"),
+					Hostinfo: (&tailcfg.Hostinfo{
+						DNSRecords: []tailcfg.DNSRecord{
+							{Name: "@", Type: "TXT", Value: "not allowed to publish"},
+						},
+					}).View(),
+				},
+			}),
+			prefs: &ipn.Prefs{},
+			want: &dns.Config{
+				Routes: map[dnsname.FQDN][]*dnstype.Resolver{},
+				Hosts: map[dnsname.FQDN][]netip.Addr{
+					"myname.net.": ips("100.101.101.101"),
+					"peera.net.":  ips("100.102.0.1"),
+					"b.net.":      ips("100.102.0.2"),
+				},
+				Records: map[dnsname.FQDN]*resolver.Records{
+					"docs.com.": {CNAME: "peera.net."},
+					"_ssh._tcp.peera.net.": {SRV: []*net.SRV{
+						{Target: "peera.net.", Port: 22, Weight: 5},
+					}},
+					"peera.net.":     {TXT: []string{"owner=infra"}},
+					"www.peera.net.": {CNAME: "web.example.com."},
+				},
+			},
+		},
 		{
 			name: "corp_dns_misc",
 			nm: &netmap.NetworkMap{
@@ -289,6 +348,78 @@ func TestDNSConfigForNetmap(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "encrypted_resolvers",
+			nm: &netmap.NetworkMap{
+				DNS: tailcfg.DNSConfig{
+					Resolvers: []*dnstype.Resolver{
+						{Addr: "https://dns.example.com/dns-query"},
+						{Addr: "https://"},
+					},
+					Routes: map[string][]*dnstype.Resolver{
+						"corp.com.":  {{Addr: "tls://10.0.0.53", TLSServerName: "dns.corp.com"}},
+						"other.com.": {{Addr: "10.0.0.53", TLSServerName: "dns.other.com"}},
+					},
+				},
+			},
+			prefs: &ipn.Prefs{
+				CorpDNS: true,
+			},
+			want: &dns.Config{
+				Hosts: map[dnsname.FQDN][]netip.Addr{},
+				DefaultResolvers: []*dnstype.Resolver{
+					{Addr: "https://dns.example.com/dns-query"},
+				},
+				Routes: map[dnsname.FQDN][]*dnstype.Resolver{
+					"corp.com.":  {{Addr: "tls://10.0.0.53", TLSServerName: "dns.corp.com"}},
+					"other.com.": {},
+				},
+			},
+			wantLog: "ignoring DNS resolver: invalid DoH URL \"https://\"\n" +
+				"ignoring DNS resolver: resolver \"10.0.0.53\": TLS options are only for DoH and DoT resolvers\n",
+		},
+		{
+			name: "dns_cache",
+			nm:   &netmap.NetworkMap{},
+			prefs: &ipn.Prefs{
+				DNSCache: ipn.DNSCachePrefs{MaxEntries: 1000, MaxTTL: time.Hour},
+			},
+			want: &dns.Config{
+				Hosts:  map[dnsname.FQDN][]netip.Addr{},
+				Routes: map[dnsname.FQDN][]*dnstype.Resolver{},
+				Cache:  resolver.CacheConfig{MaxEntries: 1000, MaxTTL: time.Hour},
+			},
+		},
+		{
+			name: "dns_blocklists",
+			nm: &netmap.NetworkMap{
+				DNS: tailcfg.DNSConfig{
+					Blocklists: []string{
+						"https://example.com/hosts",
+						"/etc/passwd", // only URLs from control
+						"https://example.com/rpz",
+					},
+				},
+			},
+			prefs: &ipn.Prefs{
+				CorpDNS:            true,
+				DNSBlocklists:      []string{"/etc/tailscale/blocklist", "https://example.com/hosts"},
+				DNSBlocklistNullIP: true,
+			},
+			want: &dns.Config{
+				Hosts:  map[dnsname.FQDN][]netip.Addr{},
+				Routes: map[dnsname.FQDN][]*dnstype.Resolver{},
+				Blocklist: resolver.BlocklistConfig{
+					Sources: []string{
+						"https://example.com/hosts",
+						"https://example.com/rpz",
+						"/etc/tailscale/blocklist",
+					},
+					NullIP: true,
+				},
+			},
+			wantLog: "ignoring DNS blocklist \"/etc/passwd\" from control: not an HTTPS URL\n",
+		},
 		{
 			name: "exit_nodes_need_fallbacks",
 			nm: &netmap.NetworkMap{
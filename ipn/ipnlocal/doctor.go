@@ -0,0 +1,405 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/doctor"
+	"tailscale.com/ipn"
+	"tailscale.com/logtail"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/net/portmapper"
+	"tailscale.com/net/tlsdial"
+	"tailscale.com/net/tshttpproxy"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/netmap"
+	"tailscale.com/version"
+)
+
+const (
+	// doctorProbeTimeout bounds how long each connectivity probe of
+	// DoctorBundle runs.
+	doctorProbeTimeout = 10 * time.Second
+
+	// maxDoctorLogLines is the most lines of tailscaled logs that
+	// DoctorBundle captures.
+	maxDoctorLogLines = 2000
+)
+
+// doctorInfo is the BundleInfoFile of a support bundle.
+type doctorInfo struct {
+	Version       string
+	OS            string
+	OSVersion     string `json:",omitempty"`
+	Distro        string `json:",omitempty"`
+	DistroVersion string `json:",omitempty"`
+	BackendState  string
+	Health        []string `json:",omitempty"`
+}
+
+// doctorNetmap is the BundleNetmapFile of a support bundle, a summary of
+// the netmap without names, addresses or keys.
+type doctorNetmap struct {
+	SelfNodeID        tailcfg.StableNodeID
+	KeyExpiry         time.Time `json:",omitempty"`
+	Peers             []doctorPeer
+	DERPRegions       []int
+	PacketFilterRules int
+	DNSResolvers      int
+	DNSRoutes         int
+	MagicDNS          bool
+}
+
+type doctorPeer struct {
+	NodeID     tailcfg.StableNodeID
+	OS         string `json:",omitempty"`
+	Online     *bool  `json:",omitempty"`
+	Expired    bool   `json:",omitempty"`
+	HomeDERP   string `json:",omitempty"`
+	Endpoints  int
+	AllowedIPs int
+}
+
+func summarizeNetmap(nm *netmap.NetworkMap) *doctorNetmap {
+	if nm == nil {
+		return nil
+	}
+	s := &doctorNetmap{
+		SelfNodeID:        nm.SelfNode.StableID(),
+		PacketFilterRules: nm.PacketFilterRules.Len(),
+		DNSResolvers:      len(nm.DNS.Resolvers),
+		DNSRoutes:         len(nm.DNS.Routes),
+		MagicDNS:          nm.DNS.Proxied,
+	}
+	if nm.SelfNode.Valid() {
+		s.KeyExpiry = nm.SelfNode.KeyExpiry()
+	}
+	for _, p := range nm.Peers {
+		s.Peers = append(s.Peers, doctorPeer{
+			NodeID:     p.StableID(),
+			OS:         p.Hostinfo().OS(),
+			Online:     p.Online(),
+			Expired:    p.Expired(),
+			HomeDERP:   p.DERP(),
+			Endpoints:  p.Endpoints().Len(),
+			AllowedIPs: p.AllowedIPs().Len(),
+		})
+	}
+	if nm.DERPMap != nil {
+		for id := range nm.DERPMap.Regions {
+			s.DERPRegions = append(s.DERPRegions, id)
+		}
+		slices.Sort(s.DERPRegions)
+	}
+	return s
+}
+
+// doctorRedactor returns a Redactor for the logs of a support bundle,
+// which redacts the names in nm and the login name of profile.
+func doctorRedactor(nm *netmap.NetworkMap, profile ipn.LoginProfile) *doctor.Redactor {
+	names := []string{profile.Name, profile.UserProfile.LoginName, profile.UserProfile.DisplayName}
+	addNode := func(n tailcfg.NodeView) {
+		if !n.Valid() {
+			return
+		}
+		names = append(names, n.ComputedName(), n.Hostinfo().Hostname())
+		if fqdn := strings.TrimSuffix(n.Name(), "."); fqdn != "" {
+			host, _, _ := strings.Cut(fqdn, ".")
+			names = append(names, fqdn, host)
+		}
+	}
+	if nm != nil {
+		names = append(names, strings.TrimSuffix(nm.Name, "."), nm.Domain, nm.MagicDNSSuffix())
+		addNode(nm.SelfNode)
+		for _, p := range nm.Peers {
+			addNode(p)
+		}
+		for _, up := range nm.UserProfiles {
+			names = append(names, up.LoginName, up.DisplayName)
+		}
+		for _, d := range nm.DNS.Domains {
+			names = append(names, strings.TrimSuffix(d, "."))
+		}
+		for d := range nm.DNS.Routes {
+			names = append(names, strings.TrimSuffix(d, "."))
+		}
+	}
+	return doctor.NewRedactor(names...)
+}
+
+// captureLogs captures what tailscaled logs, through the same tap as
+// "tailscale debug daemon-logs", until the returned stop func is called,
+// which returns the lines logged, up to maxDoctorLogLines of them.
+func captureLogs() (stop func() []string) {
+	msgc := make(chan string, 256)
+	unregister := logtail.RegisterLogTap(msgc)
+	done := make(chan []string, 1)
+	go func() {
+		var lines []string
+		dropped := 0
+		for msg := range msgc {
+			var entry struct {
+				Logtail struct {
+					ClientTime string `json:"client_time"`
+				} `json:"logtail"`
+				Text string `json:"text"`
+			}
+			if json.Unmarshal([]byte(msg), &entry) != nil || entry.Text == "" {
+				continue
+			}
+			if len(lines) >= maxDoctorLogLines {
+				dropped++
+				continue
+			}
+			lines = append(lines, strings.TrimSpace(entry.Logtail.ClientTime+" "+strings.TrimSuffix(entry.Text, "\n")))
+		}
+		if dropped > 0 {
+			lines = append(lines, fmt.Sprintf("[%d more lines not captured]", dropped))
+		}
+		done <- lines
+	}()
+	return func() []string {
+		// Once unregistered, the tap no longer sends on msgc.
+		unregister()
+		close(msgc)
+		return <-done
+	}
+}
+
+// DoctorBundle runs the doctor's checks and connectivity probes, and
+// writes a support bundle of their results, along with a redacted summary
+// of the netmap and the logs written while they ran, redacted, to w.
+//
+// Logs are only captured while DoctorBundle runs, so that the rest of the
+// time logging doesn't pay for it.
+func (b *LocalBackend) DoctorBundle(ctx context.Context, w io.Writer) error {
+	stopLogs := captureLogs()
+
+	b.mu.Lock()
+	nm := b.netMap
+	prefs := b.pm.CurrentPrefs()
+	profile := b.pm.CurrentProfile()
+	info := doctorInfo{
+		Version:      version.Long(),
+		OS:           version.OS(),
+		BackendState: b.state.String(),
+		Health:       b.healthWarningsLocked(),
+	}
+	if hi := b.hostinfo; hi != nil {
+		info.OSVersion = hi.OSVersion
+		info.Distro = hi.Distro
+		info.DistroVersion = hi.DistroVersion
+	}
+	b.mu.Unlock()
+
+	var checks bytes.Buffer
+	var checksMu sync.Mutex
+	b.Doctor(ctx, func(format string, args ...any) {
+		checksMu.Lock()
+		defer checksMu.Unlock()
+		fmt.Fprintf(&checks, strings.TrimSuffix(format, "\n")+"\n", args...)
+	})
+
+	var dm *tailcfg.DERPMap
+	if nm != nil {
+		dm = nm.DERPMap
+	}
+	probes := b.doctorProbes(ctx, prefs.ControlURLOrDefault(), dm)
+	var logs string
+	if lines := stopLogs(); len(lines) > 0 {
+		logs = strings.Join(lines, "\n") + "\n"
+	}
+
+	redactor := doctorRedactor(nm, profile)
+	bundle := doctor.NewBundle(w, b.clock.Now())
+	err := errors.Join(
+		bundle.AddJSON(doctor.BundleInfoFile, info),
+		bundle.AddJSON(doctor.BundleProbesFile, probes),
+		bundle.AddFile(doctor.BundleChecksFile, []byte(redactor.Redact(checks.String()))),
+		bundle.AddJSON(doctor.BundleNetmapFile, summarizeNetmap(nm)),
+		bundle.AddFile(doctor.BundleLogsFile, []byte(redactor.Redact(logs))),
+	)
+	return errors.Join(err, bundle.Close())
+}
+
+// doctorProbes runs the connectivity probes of DoctorBundle concurrently,
+// returning their results in a stable order.
+func (b *LocalBackend) doctorProbes(ctx context.Context, controlURL string, dm *tailcfg.DERPMap) []doctor.ProbeResult {
+	ctx, cancel := context.WithTimeout(ctx, doctorProbeTimeout)
+	defer cancel()
+
+	var (
+		mu  sync.Mutex
+		res []doctor.ProbeResult
+		wg  sync.WaitGroup
+	)
+	run := func(f func(context.Context) []doctor.ProbeResult) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rs := f(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			res = append(res, rs...)
+		}()
+	}
+	run(func(ctx context.Context) []doctor.ProbeResult {
+		return []doctor.ProbeResult{b.probeControl(ctx, controlURL)}
+	})
+	run(func(ctx context.Context) []doctor.ProbeResult {
+		return []doctor.ProbeResult{probeDNS(ctx, controlURL)}
+	})
+	run(func(ctx context.Context) []doctor.ProbeResult {
+		return []doctor.ProbeResult{b.probePortmapper(ctx)}
+	})
+	run(func(ctx context.Context) []doctor.ProbeResult {
+		return b.probeDERP(ctx, dm)
+	})
+	wg.Wait()
+
+	slices.SortFunc(res, func(a, b doctor.ProbeResult) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return res
+}
+
+// probeControl probes whether the control plane at controlURL can be
+// reached over HTTPS, as the control client does.
+func (b *LocalBackend) probeControl(ctx context.Context, controlURL string) doctor.ProbeResult {
+	res := doctor.ProbeResult{Name: "control"}
+	u, err := url.Parse(controlURL)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = tshttpproxy.ProxyFromEnvironment
+	tshttpproxy.SetTransportGetProxyConnectHeader(tr)
+	tr.TLSClientConfig = tlsdial.Config(u.Hostname(), tr.TLSClientConfig)
+	tr.DialContext = b.dialer.SystemDial
+	defer tr.CloseIdleConnections()
+
+	keyURL := fmt.Sprintf("%s/key?v=%d", strings.TrimSuffix(controlURL, "/"), tailcfg.CurrentCapabilityVersion)
+	req, err := http.NewRequestWithContext(ctx, "GET", keyURL, nil)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	start := b.clock.Now()
+	hres, err := tr.RoundTrip(req)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	hres.Body.Close()
+	if hres.StatusCode != http.StatusOK {
+		res.Error = fmt.Sprintf("%s: %s", u.Host, hres.Status)
+		return res
+	}
+	res.OK = true
+	res.Latency = b.clock.Since(start)
+	res.Detail = fmt.Sprintf("reached %s", u.Host)
+	return res
+}
+
+// probeDNS probes whether the system resolver can resolve the control
+// plane's hostname.
+func probeDNS(ctx context.Context, controlURL string) doctor.ProbeResult {
+	res := doctor.ProbeResult{Name: "dns"}
+	u, err := url.Parse(controlURL)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, u.Hostname())
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.OK = true
+	res.Latency = time.Since(start)
+	res.Detail = fmt.Sprintf("resolved %s to %d addresses", u.Hostname(), len(addrs))
+	return res
+}
+
+// probePortmapper probes which port mapping services the local network
+// has.
+func (b *LocalBackend) probePortmapper(ctx context.Context) doctor.ProbeResult {
+	res := doctor.ProbeResult{Name: "portmapper"}
+	c := portmapper.NewClient(logger.Discard, b.sys.NetMon.Get(), nil, b.ControlKnobs(), nil)
+	defer c.Close()
+	pr, err := c.Probe(ctx)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	var have []string
+	if pr.UPnP {
+		have = append(have, "UPnP")
+	}
+	if pr.PMP {
+		have = append(have, "NAT-PMP")
+	}
+	if pr.PCP {
+		have = append(have, "PCP")
+	}
+	// Not having a port mapping service is common and not an error;
+	// connections then rely more on NAT traversal and DERP.
+	res.OK = true
+	if len(have) == 0 {
+		res.Detail = "no port mapping services found"
+	} else {
+		res.Detail = "found " + strings.Join(have, ", ")
+	}
+	return res
+}
+
+// probeDERP runs a netcheck against the DERP regions of dm, returning a
+// result for each region.
+func (b *LocalBackend) probeDERP(ctx context.Context, dm *tailcfg.DERPMap) []doctor.ProbeResult {
+	if dm == nil || len(dm.Regions) == 0 {
+		return []doctor.ProbeResult{{Name: "derp", Error: "no DERP map (not connected?)"}}
+	}
+	c := &netcheck.Client{
+		Logf:   logger.Discard,
+		NetMon: b.sys.NetMon.Get(),
+	}
+	if err := c.Standalone(ctx, ":0"); err != nil {
+		return []doctor.ProbeResult{{Name: "derp", Error: err.Error()}}
+	}
+	report, err := c.GetReport(ctx, dm)
+	if err != nil {
+		return []doctor.ProbeResult{{Name: "derp", Error: err.Error()}}
+	}
+	var res []doctor.ProbeResult
+	for id, reg := range dm.Regions {
+		r := doctor.ProbeResult{Name: "derp/" + reg.RegionCode}
+		if lat, ok := report.RegionLatency[id]; ok {
+			r.OK = true
+			r.Latency = lat
+			if id == report.PreferredDERP {
+				r.Detail = "preferred"
+			}
+		} else {
+			r.Error = "no STUN response"
+		}
+		res = append(res, r)
+	}
+	return res
+}
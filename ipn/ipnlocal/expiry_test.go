@@ -5,7 +5,6 @@
 
 import (
 	"fmt"
-	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -14,6 +13,7 @@
 	"tailscale.com/tstest"
 	"tailscale.com/types/key"
 	"tailscale.com/types/netmap"
+	"tailscale.com/types/netmap/nmdiff"
 )
 
 func TestFlagExpiredPeers(t *testing.T) {
@@ -116,8 +116,8 @@ func TestFlagExpiredPeers(t *testing.T) {
 				em.onControlTime(*tt.controlTime)
 			}
 			em.flagExpiredPeers(tt.netmap, now)
-			if !reflect.DeepEqual(tt.netmap.Peers, tt.want) {
-				t.Errorf("wrong results\n got: %s\nwant: %s", formatNodes(tt.netmap.Peers), formatNodes(tt.want))
+			if d := nmdiff.Compare(&netmap.NetworkMap{Peers: tt.want}, &netmap.NetworkMap{Peers: tt.netmap.Peers}); !d.IsEmpty() {
+				t.Errorf("wrong results\n got: %s\nwant: %s\ndiff (want to got):\n%v", formatNodes(tt.netmap.Peers), formatNodes(tt.want), d)
 			}
 		})
 	}
@@ -275,6 +275,27 @@ func TestNextPeerExpiry(t *testing.T) {
 	})
 }
 
+func TestOnClockJumpRechecksExpiry(t *testing.T) {
+	b := newTestLocalBackend(t)
+	b.em.onControlTime(b.clock.Now().Add(time.Minute))
+	delta := b.em.clockDelta.Load()
+
+	var rechecks []string
+	b.mu.Lock()
+	b.recheckExpiry = func(why string) { rechecks = append(rechecks, why) }
+	b.mu.Unlock()
+
+	// Expiry is rechecked against the new time, leaving the delta to
+	// control alone.
+	b.onClockJump(2 * time.Hour)
+	if len(rechecks) != 1 {
+		t.Fatalf("got %d expiry rechecks, want 1", len(rechecks))
+	}
+	if got := b.em.clockDelta.Load(); got != delta {
+		t.Errorf("clock delta after jump = %v, want %v", got, delta)
+	}
+}
+
 func formatNodes(nodes []tailcfg.NodeView) string {
 	var sb strings.Builder
 	for i, n := range nodes {
@@ -0,0 +1,221 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tstime"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/rands"
+	"tailscale.com/util/set"
+)
+
+// fileOfferTimeout is how long a FileOffer waits to be decided on.
+const fileOfferTimeout = time.Minute
+
+// maxPendingFileOffers is the most FileOffers that can be pending at
+// once. Files sent beyond it are refused without asking.
+const maxPendingFileOffers = 64
+
+var (
+	// ErrNoFileOffer is returned by DecideFileOffer when there's no
+	// pending FileOffer with the given ID, such as because it expired.
+	ErrNoFileOffer = errors.New("no such pending file offer")
+
+	errFileOfferRejected = errors.New("file rejected by receiver")
+	errFileOfferExpired  = errors.New("file not accepted by receiver in time")
+	errTooManyFileOffers = errors.New("too many files awaiting acceptance")
+)
+
+// fileOffer is a pending FileOffer.
+type fileOffer struct {
+	offer   ipn.FileOffer
+	decided chan ipn.FileOfferDecision // buffered; receives at most one decision
+}
+
+// fileOffers holds incoming Taildrop files for acceptance by the
+// processors subscribed with the LocalAPI file-offers endpoint.
+type fileOffers struct {
+	logf  logger.Logf
+	clock tstime.Clock
+
+	mu      sync.Mutex
+	subs    set.HandleSet[chan ipn.FileOffer]
+	pending map[string]*fileOffer // by ID
+}
+
+func newFileOffers(logf logger.Logf, clock tstime.Clock) *fileOffers {
+	return &fileOffers{logf: logf, clock: clock}
+}
+
+// subscribe registers a processor of file offers, which are sent on the
+// returned channel, starting with those pending. Until unsubscribe is
+// called, incoming files wait for a decision. When the last processor
+// unsubscribes, the pending files are accepted, as if there had been
+// no processors.
+func (fo *fileOffers) subscribe() (offers <-chan ipn.FileOffer, unsubscribe func()) {
+	ch := make(chan ipn.FileOffer, maxPendingFileOffers)
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	for _, p := range fo.pending {
+		ch <- p.offer
+	}
+	h := fo.subs.Add(ch)
+	return ch, func() {
+		fo.mu.Lock()
+		defer fo.mu.Unlock()
+		delete(fo.subs, h)
+		if len(fo.subs) > 0 {
+			return
+		}
+		for id, p := range fo.pending {
+			delete(fo.pending, id)
+			p.decided <- ipn.FileOfferDecision{ID: id, Accept: true}
+		}
+	}
+}
+
+// isPending reports whether the offer with the given ID awaits a decision.
+func (fo *fileOffers) isPending(id string) bool {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	_, ok := fo.pending[id]
+	return ok
+}
+
+// offer asks the subscribed processors whether to accept o, filling in
+// its ID and expiry, and waits for a decision. It accepts o without
+// asking if there are no processors.
+func (fo *fileOffers) offer(ctx context.Context, o ipn.FileOffer) (ipn.FileOfferDecision, error) {
+	if fo == nil {
+		return ipn.FileOfferDecision{Accept: true}, nil
+	}
+	fo.mu.Lock()
+	if len(fo.subs) == 0 {
+		fo.mu.Unlock()
+		return ipn.FileOfferDecision{Accept: true}, nil
+	}
+	if len(fo.pending) >= maxPendingFileOffers {
+		fo.mu.Unlock()
+		return ipn.FileOfferDecision{}, errTooManyFileOffers
+	}
+	o.ID = rands.HexString(16)
+	o.Expires = fo.clock.Now().Add(fileOfferTimeout)
+	p := &fileOffer{offer: o, decided: make(chan ipn.FileOfferDecision, 1)}
+	if fo.pending == nil {
+		fo.pending = make(map[string]*fileOffer)
+	}
+	fo.pending[o.ID] = p
+	for _, ch := range fo.subs {
+		select {
+		case ch <- o:
+		default:
+			// The processor is far behind; let the offer expire.
+		}
+	}
+	fo.mu.Unlock()
+	fo.logf("fileoffers: file from %s awaiting acceptance as %s", o.PeerName, o.ID)
+
+	defer func() {
+		fo.mu.Lock()
+		defer fo.mu.Unlock()
+		delete(fo.pending, o.ID)
+	}()
+	timer, timerC := fo.clock.NewTimer(fileOfferTimeout)
+	defer timer.Stop()
+	select {
+	case d := <-p.decided:
+		if !d.Accept {
+			return d, errFileOfferRejected
+		}
+		return d, nil
+	case <-timerC:
+		fo.logf("fileoffers: %s expired", o.ID)
+		return ipn.FileOfferDecision{}, errFileOfferExpired
+	case <-ctx.Done():
+		return ipn.FileOfferDecision{}, ctx.Err()
+	}
+}
+
+// decide delivers d to the pending offer it's about.
+func (fo *fileOffers) decide(d ipn.FileOfferDecision) error {
+	if d.Path != "" {
+		if !d.Accept {
+			return errors.New("path given for rejected file")
+		}
+		if err := checkFileOfferPath(d.Path); err != nil {
+			return err
+		}
+	}
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	p, ok := fo.pending[d.ID]
+	if !ok {
+		return ErrNoFileOffer
+	}
+	delete(fo.pending, d.ID)
+	p.decided <- d
+	verdict := "rejected"
+	if d.Accept {
+		verdict = "accepted"
+	}
+	fo.logf("fileoffers: %s %s", verdict, d.ID)
+	return nil
+}
+
+// checkFileOfferPath reports whether path is usable as the path of a
+// received file: an absolute path that doesn't exist, in an existing
+// directory.
+func checkFileOfferPath(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("path %q is not absolute", path)
+	}
+	if fi, err := os.Stat(filepath.Dir(path)); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("%q is not a directory", filepath.Dir(path))
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return fmt.Errorf("%q already exists", path)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// WatchFileOffers subscribes to incoming Taildrop files, calling fn with
+// each one that awaits a decision, until ctx is done or fn returns false.
+// While subscribed, incoming files are held until they're decided on with
+// DecideFileOffer.
+func (b *LocalBackend) WatchFileOffers(ctx context.Context, fn func(ipn.FileOffer) (keepGoing bool)) {
+	offers, unsubscribe := b.fileOffers.subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case o := <-offers:
+			if !b.fileOffers.isPending(o.ID) {
+				continue // decided on or expired while queued
+			}
+			if !fn(o) {
+				return
+			}
+		}
+	}
+}
+
+// DecideFileOffer accepts or rejects a pending incoming Taildrop file.
+// It returns ErrNoFileOffer if there's no such pending file.
+func (b *LocalBackend) DecideFileOffer(d ipn.FileOfferDecision) error {
+	return b.fileOffers.decide(d)
+}
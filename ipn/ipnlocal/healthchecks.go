@@ -0,0 +1,79 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"tailscale.com/health"
+)
+
+// taildropMinFreeSpace is the free disk space below which the
+// "taildrop-disk" health check fails.
+const taildropMinFreeSpace = 1 << 30
+
+// registerHealthChecks registers LocalBackend's health checks. The
+// returned func unregisters them.
+func (b *LocalBackend) registerHealthChecks() (unregister func()) {
+	unregs := []func(){
+		health.RegisterCheck(health.Check{
+			Name:     "cert-expiry",
+			Severity: health.SeverityWarning,
+			Remediation: health.Remediation{
+				Code: "renew-cert",
+				Text: "Run 'tailscale cert' for the domain, and check that this device can reach Let's Encrypt.",
+				URL:  "https://tailscale.com/kb/1153/enabling-https",
+			},
+			Run: b.checkCertExpiry,
+		}),
+		health.RegisterCheck(health.Check{
+			Name:     "taildrop-disk",
+			Severity: health.SeverityWarning,
+			Remediation: health.Remediation{
+				Code: "free-disk-space",
+				Text: "Free up disk space, such as by moving received files out of Taildrop with 'tailscale file get'.",
+			},
+			Run: b.checkTaildropDisk,
+		}),
+	}
+	return func() {
+		for _, unreg := range unregs {
+			unreg()
+		}
+	}
+}
+
+// checkTaildropDisk is the "taildrop-disk" health check. It reports
+// when the disk Taildrop receives files on is nearly full.
+func (b *LocalBackend) checkTaildropDisk() error {
+	b.mu.Lock()
+	dir := b.directFileRoot
+	b.mu.Unlock()
+	if dir == "" {
+		if varRoot := b.TailscaleVarRoot(); varRoot != "" {
+			dir = filepath.Join(varRoot, "files")
+		}
+	}
+	if dir == "" {
+		return nil
+	}
+	if _, err := os.Stat(dir); err != nil {
+		// Nothing received yet.
+		return nil
+	}
+	free, err := freeDiskSpace(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("checking free space for Taildrop: %w", err)
+	}
+	if free < taildropMinFreeSpace {
+		return fmt.Errorf("only %d MiB free for Taildrop in %s", free>>20, dir)
+	}
+	return nil
+}
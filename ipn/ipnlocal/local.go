@@ -4,6 +4,7 @@
 package ipnlocal
 
 import (
+	"cmp"
 	"context"
 	"encoding/base64"
 	"encoding/json"
@@ -31,6 +32,7 @@
 	"go4.org/mem"
 	"go4.org/netipx"
 	xmaps "golang.org/x/exp/maps"
+	"golang.org/x/net/dns/dnsmessage"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/control/controlclient"
@@ -50,12 +52,15 @@
 	"tailscale.com/log/sockstatlog"
 	"tailscale.com/logpolicy"
 	"tailscale.com/net/dns"
+	"tailscale.com/net/dns/resolver"
 	"tailscale.com/net/dnscache"
 	"tailscale.com/net/dnsfallback"
 	"tailscale.com/net/interfaces"
+	"tailscale.com/net/netcheck"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netns"
 	"tailscale.com/net/netutil"
+	"tailscale.com/net/portmapper"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/paths"
@@ -72,6 +77,7 @@
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
 	"tailscale.com/types/netmap"
+	"tailscale.com/types/netmap/nmdiff"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
 	"tailscale.com/types/ptr"
@@ -79,6 +85,7 @@
 	"tailscale.com/util/cmpx"
 	"tailscale.com/util/deephash"
 	"tailscale.com/util/dnsname"
+	"tailscale.com/util/maintwindow"
 	"tailscale.com/util/mak"
 	"tailscale.com/util/multierr"
 	"tailscale.com/util/osshare"
@@ -134,6 +141,7 @@ func RegisterNewSSHServer(fn newSSHServerFunc) {
 type watchSession struct {
 	ch        chan *ipn.Notify
 	sessionID string
+	events    ipn.NotifyEvent // the events to send; see WatchEvents
 }
 
 // LocalBackend is the glue between the major pieces of the Tailscale
@@ -148,28 +156,29 @@ type watchSession struct {
 // state machine generates events back out to zero or more components.
 type LocalBackend struct {
 	// Elements that are thread-safe or constant after construction.
-	ctx                   context.Context    // canceled by Close
-	ctxCancel             context.CancelFunc // cancels ctx
-	logf                  logger.Logf        // general logging
-	keyLogf               logger.Logf        // for printing list of peers on change
-	statsLogf             logger.Logf        // for printing peers stats on change
-	sys                   *tsd.System
-	e                     wgengine.Engine // non-nil; TODO(bradfitz): remove; use sys
-	store                 ipn.StateStore  // non-nil; TODO(bradfitz): remove; use sys
-	dialer                *tsdial.Dialer  // non-nil; TODO(bradfitz): remove; use sys
-	backendLogID          logid.PublicID
-	unregisterNetMon      func()
-	unregisterHealthWatch func()
-	portpoll              *portlist.Poller // may be nil
-	portpollOnce          sync.Once        // guards starting readPoller
-	gotPortPollRes        chan struct{}    // closed upon first readPoller result
-	varRoot               string           // or empty if SetVarRoot never called
-	logFlushFunc          func()           // or nil if SetLogFlusher wasn't called
-	em                    *expiryManager   // non-nil
-	sshAtomicBool         atomic.Bool
-	shutdownCalled        bool // if Shutdown has been called
-	debugSink             *capture.Sink
-	sockstatLogger        *sockstatlog.Logger
+	ctx                    context.Context    // canceled by Close
+	ctxCancel              context.CancelFunc // cancels ctx
+	logf                   logger.Logf        // general logging
+	keyLogf                logger.Logf        // for printing list of peers on change
+	statsLogf              logger.Logf        // for printing peers stats on change
+	sys                    *tsd.System
+	e                      wgengine.Engine // non-nil; TODO(bradfitz): remove; use sys
+	store                  ipn.StateStore  // non-nil; TODO(bradfitz): remove; use sys
+	dialer                 *tsdial.Dialer  // non-nil; TODO(bradfitz): remove; use sys
+	backendLogID           logid.PublicID
+	unregisterNetMon       func()
+	unregisterHealthWatch  func()
+	unregisterHealthChecks func()
+	portpoll               *portlist.Poller // may be nil
+	portpollOnce           sync.Once        // guards starting readPoller
+	gotPortPollRes         chan struct{}    // closed upon first readPoller result
+	varRoot                string           // or empty if SetVarRoot never called
+	logFlushFunc           func()           // or nil if SetLogFlusher wasn't called
+	em                     *expiryManager   // non-nil
+	sshAtomicBool          atomic.Bool
+	shutdownCalled         bool // if Shutdown has been called
+	debugSink              *capture.Sink
+	sockstatLogger         *sockstatlog.Logger
 
 	// getTCPHandlerForFunnelFlow returns a handler for an incoming TCP flow for
 	// the provided srcAddr and dstPort if one exists.
@@ -204,6 +213,7 @@ type LocalBackend struct {
 	httpTestClient *http.Client // for controlclient. nil by default, used by tests.
 	ccGen          clientGen    // function for producing controlclient; lazily populated
 	sshServer      SSHServer    // or nil, initialized lazily.
+	appConnector   AppConnector // or nil; see SetAppConnector
 	notify         func(ipn.Notify)
 	cc             controlclient.Client
 	ccAuto         *controlclient.Auto // if cc is of type *controlclient.Auto
@@ -212,6 +222,10 @@ type LocalBackend struct {
 	state          ipn.State
 	capFileSharing bool // whether netMap contains the file sharing capability
 	capTailnetLock bool // whether netMap contains the tailnet lock capability
+	// flowLog is the log of new flows set on each packet filter, or nil
+	// when no one is watching it; see WatchFlowLog.
+	flowLog         *filter.FlowLog
+	flowLogWatchers int // callers of WatchFlowLog that aren't done
 	// hostinfo is mutated in-place while mu is held.
 	hostinfo *tailcfg.Hostinfo
 	// netMap is the most recently set full netmap from the controlclient.
@@ -221,12 +235,15 @@ type LocalBackend struct {
 	// In general, avoid using the netMap.Peers slice. We'd like it to go away
 	// as of 2023-09-17.
 	netMap *netmap.NetworkMap
+	// prevNetMap is the full netmap set before netMap, for debugging.
+	prevNetMap *netmap.NetworkMap
 	// peers is the set of current peers and their current values after applying
 	// delta node mutations as they come in (with mu held). The map values can
 	// be given out to callers, but the map itself must not escape the LocalBackend.
 	peers            map[tailcfg.NodeID]tailcfg.NodeView
 	nodeByAddr       map[netip.Addr]tailcfg.NodeID
 	nmExpiryTimer    tstime.TimerController // for updating netMap on node expiry; can be nil
+	recheckExpiry    func(why string)       // rechecks node expiry of the current netmap; see onClockJump; or nil
 	activeLogin      string                 // last logged LoginName from netMap
 	engineStatus     ipn.EngineStatus
 	endpoints        []tailcfg.Endpoint
@@ -258,9 +275,37 @@ type LocalBackend struct {
 	directFileRoot          string
 	directFileDoFinalRename bool // false on macOS, true on several NAS platforms
 	componentLogUntil       map[string]componentLogState
+	mapLogUntil             componentLogState // when a raised controlclient.MapLogLevel reverts
 	// c2nUpdateStatus is the status of c2n-triggered client update.
 	c2nUpdateStatus updateStatus
 
+	// maint is the state of actions deferred until the next maintenance
+	// window.
+	maint maintenanceState
+
+	// webClient is the built-in web UI, served per Prefs.WebClient.
+	webClient webClient
+
+	// localAPITokens caches the stored LocalAPI access tokens, once
+	// localAPITokensLoaded, so checking a token doesn't read the store.
+	// It's never modified in place.
+	localAPITokens       []storedLocalAPIToken
+	localAPITokensLoaded bool
+
+	// autoExitNode is the latest exit node choice made because of
+	// Prefs.AutoExitNode, or nil if none has been made.
+	autoExitNode *apitype.AutoExitNodeDecision
+
+	// connApprovals is the state of Prefs.ConnApproval.
+	connApprovals *connApprovals
+
+	// fileOffers holds incoming Taildrop files for acceptance by
+	// LocalAPI clients.
+	fileOffers *fileOffers
+
+	// serveAccessLog records the requests served per the ServeConfig.
+	serveAccessLog *serveAccessLog
+
 	// ServeConfig fields. (also guarded by mu)
 	lastServeConfJSON   mem.RO              // last JSON that was parsed into serveConfig
 	serveConfig         ipn.ServeConfigView // or !Valid if none
@@ -289,6 +334,18 @@ type LocalBackend struct {
 
 	// Last ClientVersion received in MapResponse, guarded by mu.
 	lastClientVersion *tailcfg.ClientVersion
+
+	// signAuditMu serializes access to the audit trail of tailnet lock
+	// signatures; see recordSignAudit.
+	signAuditMu sync.Mutex
+
+	// dialRouteMu serializes changes to peerDialRoutes, including the
+	// reconfigs they cause, so that routes are in place once
+	// AddPeerDialRoute returns. It must not be taken while holding mu.
+	dialRouteMu sync.Mutex
+	// peerDialRoutes are the routes added with AddPeerDialRoute, by
+	// destination, guarded by mu.
+	peerDialRoutes map[netip.Prefix]*peerDialRoute
 }
 
 type updateStatus struct {
@@ -376,6 +433,10 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 		b.sockstatLogger.SetLoggingEnabled(true)
 	}
 
+	b.connApprovals = newConnApprovals(logf, clock, store, b.send)
+	b.fileOffers = newFileOffers(logf, clock)
+	b.serveAccessLog = newServeAccessLog(logf)
+
 	// Default filter blocks everything and logs nothing, until Start() is called.
 	b.setFilter(filter.NewAllowNone(logf, &netipx.IPSet{}))
 
@@ -391,6 +452,11 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 	b.unregisterNetMon = netMon.RegisterChangeCallback(b.linkChange)
 
 	b.unregisterHealthWatch = health.RegisterWatcher(b.onHealthChange)
+	b.unregisterHealthChecks = b.registerHealthChecks()
+
+	if b.conf != nil {
+		go b.watchConfigFile()
+	}
 
 	if tunWrap, ok := b.sys.Tun.GetOK(); ok {
 		tunWrap.PeerAPIPort = b.GetPeerAPIPort
@@ -502,6 +568,41 @@ func (b *LocalBackend) GetComponentDebugLogging(component string) time.Time {
 	return ls.until
 }
 
+// SetMapSessionLogLevel sets the verbosity of map session logging to level
+// until the until time, after which it reverts to controlclient.MapLogDefault.
+// If until is in the past, the level is reset to the default immediately.
+//
+// Unlike SetComponentDebugLogging, the level isn't persisted across restarts.
+func (b *LocalBackend) SetMapSessionLogLevel(level controlclient.MapLogLevel, until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	if level == controlclient.MapLogDefault || !now.Before(until) {
+		level, until = controlclient.MapLogDefault, time.Time{}
+	}
+	controlclient.SetMapLogLevel(level)
+	if b.mapLogUntil.timer != nil {
+		b.mapLogUntil.timer.Stop()
+	}
+	b.mapLogUntil = componentLogState{until: until}
+	if until.IsZero() {
+		b.logf("map session log level reset to %v", level)
+		return
+	}
+	onFor := until.Sub(now)
+	b.logf("map session log level set to %v for %v (until %v)", level, onFor.Round(time.Second), until.UTC().Format(time.RFC3339))
+	b.mapLogUntil.timer = b.clock.AfterFunc(onFor, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.mapLogUntil.until.Equal(until) {
+			controlclient.SetMapLogLevel(controlclient.MapLogDefault)
+			b.mapLogUntil = componentLogState{}
+			b.logf("map session log level reset to %v (by timer)", controlclient.MapLogDefault)
+		}
+	})
+}
+
 // Dialer returns the backend's dialer.
 // It is always non-nil.
 func (b *LocalBackend) Dialer() *tsdial.Dialer {
@@ -530,22 +631,25 @@ func (b *LocalBackend) SetDirectFileDoFinalRename(v bool) {
 	b.directFileDoFinalRename = v
 }
 
-// ReloadCOnfig reloads the backend's config from disk.
+// ReloadConfig reloads the backend's config from disk and applies it.
 //
 // It returns (false, nil) if not running in declarative mode, (true, nil) on
 // success, or (false, error) on failure.
 func (b *LocalBackend) ReloadConfig() (ok bool, err error) {
 	b.mu.Lock()
-	defer b.mu.Unlock()
 	if b.conf == nil {
+		b.mu.Unlock()
 		return false, nil
 	}
 	conf, err := conffile.Load(b.conf.Path)
 	if err != nil {
+		b.mu.Unlock()
+		warnConfigLoad.Set(err)
 		return false, err
 	}
+	warnConfigLoad.Set(nil)
 	b.conf = conf
-	// TODO(bradfitz): apply things
+	b.applyConfigLockedOnEntry()
 	return true, nil
 }
 
@@ -595,6 +699,10 @@ func (b *LocalBackend) linkChange(delta *netmon.ChangeDelta) {
 			go b.initPeerAPIListener()
 		}
 	}
+
+	if delta.Major {
+		go b.updateAutoExitNode()
+	}
 }
 
 func (b *LocalBackend) onHealthChange(sys health.Subsystem, err error) {
@@ -603,6 +711,33 @@ func (b *LocalBackend) onHealthChange(sys health.Subsystem, err error) {
 	} else {
 		b.logf("health(%q): error: %v", sys, err)
 	}
+
+	b.mu.Lock()
+	hs := &ipn.HealthState{Warnings: b.healthWarningsLocked()}
+	b.mu.Unlock()
+	b.send(ipn.Notify{Health: hs})
+}
+
+// healthWarningsLocked returns the current health warnings, as reported
+// in ipnstate.Status.Health.
+//
+// b.mu must be held.
+func (b *LocalBackend) healthWarningsLocked() []string {
+	var warnings []string
+	if err := health.OverallError(); err != nil {
+		switch e := err.(type) {
+		case multierr.Error:
+			for _, err := range e.Errors() {
+				warnings = append(warnings, err.Error())
+			}
+		default:
+			warnings = append(warnings, err.Error())
+		}
+	}
+	if m := b.sshOnButUnusableHealthCheckMessageLocked(); m != "" {
+		warnings = append(warnings, m)
+	}
+	return warnings
 }
 
 // Shutdown halts the backend and all its sub-components. The backend
@@ -635,6 +770,7 @@ func (b *LocalBackend) Shutdown() {
 		b.sshServer = nil
 	}
 	b.closePeerAPIListenersLocked()
+	b.updateWebClientLocked("")
 	if b.debugSink != nil {
 		b.e.InstallCaptureHook(nil)
 		b.debugSink.Close()
@@ -651,6 +787,7 @@ func (b *LocalBackend) Shutdown() {
 
 	b.unregisterNetMon()
 	b.unregisterHealthWatch()
+	b.unregisterHealthChecks()
 	if cc != nil {
 		cc.Shutdown()
 	}
@@ -699,6 +836,93 @@ func (b *LocalBackend) StatusWithoutPeers() *ipnstate.Status {
 	return sb.Status()
 }
 
+// peerTrafficFlowWindow is how far back PeerTraffic counts new flows.
+const peerTrafficFlowWindow = time.Minute
+
+// PeerTraffic returns the WireGuard traffic to and from each peer that has
+// had any, busiest first.
+func (b *LocalBackend) PeerTraffic() []ipnstate.PeerTraffic {
+	st := b.Status()
+	b.mu.Lock()
+	fl := b.flowLog
+	b.mu.Unlock()
+	var flows map[netip.Addr]int
+	if fl != nil {
+		flows = recentFlowsByAddr(fl, time.Now().Add(-peerTrafficFlowWindow))
+	}
+	var ret []ipnstate.PeerTraffic
+	for _, nk := range st.Peers() {
+		ps := st.Peer[nk]
+		if ps.TxBytes == 0 && ps.RxBytes == 0 {
+			continue
+		}
+		pt := ipnstate.PeerTraffic{
+			NodeKey:       nk,
+			DNSName:       ps.DNSName,
+			TxBytes:       ps.TxBytes,
+			RxBytes:       ps.RxBytes,
+			TxPackets:     ps.TxPackets,
+			RxPackets:     ps.RxPackets,
+			TxBytesPerSec: ps.TxBytesPerSec,
+			RxBytesPerSec: ps.RxBytesPerSec,
+		}
+		if len(ps.TailscaleIPs) > 0 {
+			pt.IP = ps.TailscaleIPs[0]
+		}
+		pt.Path, pt.LatencySeconds = peerPath(ps)
+		for _, ip := range ps.TailscaleIPs {
+			pt.Flows += flows[ip]
+		}
+		ret = append(ret, pt)
+	}
+	slices.SortStableFunc(ret, func(a, b ipnstate.PeerTraffic) int {
+		return cmp.Compare(b.TxBytes+b.RxBytes, a.TxBytes+a.RxBytes)
+	})
+	return ret
+}
+
+// peerPath returns the kind of path that traffic to the peer ps currently
+// takes, "direct" or "derp", and its latency in seconds, if known. The
+// path is empty if ps hasn't been active recently.
+func peerPath(ps *ipnstate.PeerStatus) (path string, latencySeconds float64) {
+	if !ps.Active {
+		return "", 0
+	}
+	kind := "derp"
+	path = "derp"
+	if ps.CurAddr != "" {
+		path = "direct"
+		kind = "direct4"
+		if ap, err := netip.ParseAddrPort(ps.CurAddr); err == nil && ap.Addr().Is6() {
+			kind = "direct6"
+		}
+	}
+	for _, s := range ps.PathStats {
+		if s.Path == kind {
+			return path, s.RTTSeconds
+		}
+	}
+	return path, 0
+}
+
+// recentFlowsByAddr returns how many new flows fl has accepted since the
+// given time, by the remote address of each.
+func recentFlowsByAddr(fl *filter.FlowLog, since time.Time) map[netip.Addr]int {
+	events, _ := fl.Since(0)
+	m := make(map[netip.Addr]int)
+	for _, ev := range events {
+		if ev.Verdict != "accept" || ev.Time.Before(since) {
+			continue
+		}
+		if ev.Dir == "in" {
+			m[ev.Src.Addr()]++
+		} else {
+			m[ev.Dst.Addr()]++
+		}
+	}
+	return m
+}
+
 // UpdateStatus implements ipnstate.StatusUpdater.
 func (b *LocalBackend) UpdateStatus(sb *ipnstate.StatusBuilder) {
 	b.e.UpdateStatus(sb) // does wireguard + magicsock status
@@ -714,19 +938,7 @@ func (b *LocalBackend) UpdateStatus(sb *ipnstate.StatusBuilder) {
 		if prefs := b.pm.CurrentPrefs(); prefs.Valid() && prefs.AutoUpdate().Check {
 			s.ClientVersion = b.lastClientVersion
 		}
-		if err := health.OverallError(); err != nil {
-			switch e := err.(type) {
-			case multierr.Error:
-				for _, err := range e.Errors() {
-					s.Health = append(s.Health, err.Error())
-				}
-			default:
-				s.Health = append(s.Health, err.Error())
-			}
-		}
-		if m := b.sshOnButUnusableHealthCheckMessageLocked(); m != "" {
-			s.Health = append(s.Health, m)
-		}
+		s.Health = append(s.Health, b.healthWarningsLocked()...)
 		if version.IsUnstableBuild() {
 			s.Health = append(s.Health, "This is an unstable (development) version of Tailscale; frequent updates and bugs are likely")
 		}
@@ -877,9 +1089,14 @@ func peerStatusFromNode(ps *ipnstate.PeerStatus, n tailcfg.NodeView) {
 		v := n.PrimaryRoutes()
 		ps.PrimaryRoutes = &v
 	}
+	if hi := n.Hostinfo(); hi.Valid() && hi.Metadata().Len() > 0 {
+		md := hi.Metadata()
+		ps.Metadata = md.AsMap()
+	}
 
 	if n.Expired() {
 		ps.Expired = true
+		ps.Quarantined = true
 	}
 	if t := n.KeyExpiry(); !t.IsZero() {
 		t = t.Round(time.Second)
@@ -1001,25 +1218,27 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 			b.nmExpiryTimer = nil
 		}
 
+		// recheck calls ourselves with the current status again; the
+		// logic in setClientStatus will take care of updating the
+		// expired field of peers in the netmap. It's skipped if the
+		// world has moved on past the saved call (e.g. if we race
+		// stopping the timer).
+		recheck := func(why string) {
+			if b.numClientStatusCalls.Load() != currCall {
+				return
+			}
+			b.logf("setClientStatus: rechecking netmap expiry: %s", why)
+			b.SetControlClientStatus(c, st)
+		}
+		b.recheckExpiry = recheck
+
 		// Figure out when the next node in the netmap is expiring so we can
 		// start a timer to reconfigure at that point.
 		nextExpiry := b.em.nextPeerExpiry(st.NetMap, now)
 		if !nextExpiry.IsZero() {
 			tmrDuration := nextExpiry.Sub(now) + 10*time.Second
 			b.nmExpiryTimer = b.clock.AfterFunc(tmrDuration, func() {
-				// Skip if the world has moved on past the
-				// saved call (e.g. if we race stopping this
-				// timer).
-				if b.numClientStatusCalls.Load() != currCall {
-					return
-				}
-
-				b.logf("setClientStatus: netmap expiry timer triggered after %v", tmrDuration)
-
-				// Call ourselves with the current status again; the logic in
-				// setClientStatus will take care of updating the expired field
-				// of peers in the netmap.
-				b.SetControlClientStatus(c, st)
+				recheck(fmt.Sprintf("expiry timer triggered after %v", tmrDuration))
 			})
 		}
 	}
@@ -1165,7 +1384,9 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 		// Update our cached DERP map
 		dnsfallback.UpdateCache(st.NetMap.DERPMap, b.logf)
 
-		b.send(ipn.Notify{NetMap: st.NetMap})
+		b.send(ipn.Notify{NetMap: st.NetMap, PeerOnline: peerOnlineChanges(netMap, st.NetMap)})
+		b.updateAutoExitNode()
+		b.updateAppConnectorAttestation()
 	}
 	if st.URL != "" {
 		b.logf("Received auth URL: %.20v...", st.URL)
@@ -1177,6 +1398,7 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 	// This is currently (2020-07-28) necessary; conditionally disabling it is fragile!
 	// This is where netmap information gets propagated to router and magicsock.
 	b.authReconfig()
+	st.Trace.Mark(controlclient.MapTraceEngine)
 }
 
 var _ controlclient.NetmapDeltaUpdater = (*LocalBackend)(nil)
@@ -1191,6 +1413,9 @@ func (b *LocalBackend) UpdateNetmapDelta(muts []netmap.NodeMutation) (handled bo
 	defer func() {
 		if notify != nil {
 			b.send(*notify)
+			if len(notify.PeerOnline) > 0 {
+				go b.updateAutoExitNode()
+			}
 		}
 	}()
 
@@ -1210,6 +1435,16 @@ func (b *LocalBackend) UpdateNetmapDelta(muts []netmap.NodeMutation) (handled bo
 			return cmpx.Compare(a.ID(), b.ID())
 		})
 		notify = &ipn.Notify{NetMap: nm}
+		for _, m := range muts {
+			if m, ok := m.(netmap.NodeMutationOnline); ok {
+				if p, ok := b.peers[m.NodeIDBeingMutated()]; ok {
+					notify.PeerOnline = append(notify.PeerOnline, ipn.PeerOnlineChange{
+						NodeID: p.StableID(),
+						Online: m.Online,
+					})
+				}
+			}
+		}
 	} else if testenv.InTest() {
 		// In tests, send an empty Notify as a wake-up so end-to-end
 		// integration tests in another repo can check on the status of
@@ -1219,6 +1454,58 @@ func (b *LocalBackend) UpdateNetmapDelta(muts []netmap.NodeMutation) (handled bo
 	return true
 }
 
+// activePeerWindow is how recently the engine must have completed a
+// handshake with a peer for importantPeers to consider it actively in use.
+// WireGuard rekeys every two minutes while traffic flows.
+const activePeerWindow = 3 * time.Minute
+
+// importantPeers returns the peers whose delta updates the control client
+// should deliver first: the exit node in use, if any, and the peers the
+// engine has recently completed a handshake with.
+func (b *LocalBackend) importantPeers() set.Set[tailcfg.NodeID] {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ret := set.Set[tailcfg.NodeID]{}
+	if exitNodeID := b.pm.CurrentPrefs().ExitNodeID(); !exitNodeID.IsZero() && b.netMap != nil {
+		if p, ok := b.netMap.PeerWithStableID(exitNodeID); ok {
+			ret.Add(p.ID())
+		}
+	}
+	if len(b.engineStatus.LivePeers) == 0 {
+		return ret
+	}
+	now := b.clock.Now()
+	for id, p := range b.peers {
+		if st, ok := b.engineStatus.LivePeers[p.Key()]; ok && now.Sub(st.LastHandshake) < activePeerWindow {
+			ret.Add(id)
+		}
+	}
+	return ret
+}
+
+// peerOnlineChanges returns the peers whose online status differs
+// between the netmaps old and nm. Peers new in nm that are online count
+// as changed; peers that left don't.
+func peerOnlineChanges(old, nm *netmap.NetworkMap) []ipn.PeerOnlineChange {
+	if nm == nil {
+		return nil
+	}
+	wasOnline := map[tailcfg.StableNodeID]bool{}
+	if old != nil {
+		for _, p := range old.Peers {
+			wasOnline[p.StableID()] = p.Online() != nil && *p.Online()
+		}
+	}
+	var ret []ipn.PeerOnlineChange
+	for _, p := range nm.Peers {
+		online := p.Online() != nil && *p.Online()
+		if online != wasOnline[p.StableID()] {
+			ret = append(ret, ipn.PeerOnlineChange{NodeID: p.StableID(), Online: online})
+		}
+	}
+	return ret
+}
+
 // mutationsAreWorthyOfTellingIPNBus reports whether any mutation type in muts is
 // worthy of spamming the IPN bus (the Windows & Mac GUIs, basically) to tell them
 // about the update.
@@ -1632,9 +1919,11 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 		DebugFlags:           debugFlags,
 		NetMon:               b.sys.NetMon.Get(),
 		Pinger:               b,
-		PopBrowserURL:        b.tellClientToBrowseToURL,
+		PopBrowserURL:        b.popBrowserURLFromControl,
 		OnClientVersion:      b.onClientVersion,
 		OnControlTime:        b.em.onControlTime,
+		OnClockJump:          b.onClockJump,
+		ImportantPeers:       b.importantPeers,
 		Dialer:               b.Dialer(),
 		Observer:             b,
 		C2NHandler:           http.HandlerFunc(b.handleC2N),
@@ -1682,6 +1971,8 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 	cc.SetTKAHead(tkaHead)
 
 	b.magicConn().SetNetInfoCallback(b.setNetInfo)
+	b.magicConn().SetNetcheckChangeCallback(b.onNetcheckChanges)
+	b.magicConn().SetFailoverCallback(b.onFailover)
 
 	blid := b.backendLogID.String()
 	b.logf("Backend: logs: be:%v fe:%v", blid, opts.FrontendLogID)
@@ -1716,8 +2007,14 @@ func (b *LocalBackend) updateFilterLocked(netMap *netmap.NetworkMap, prefs ipn.P
 		packetFilter []filter.Match
 		localNetsB   netipx.IPSetBuilder
 		logNetsB     netipx.IPSetBuilder
+		quarantineB  netipx.IPSetBuilder
 		shieldsUp    = !prefs.Valid() || prefs.ShieldsUp() // Be conservative when not ready
+		connApproval = prefs.Valid() && prefs.ConnApproval()
+		halfOpen     ipn.HalfOpenLimitsPrefs
 	)
+	if prefs.Valid() {
+		halfOpen = prefs.HalfOpenLimits()
+	}
 	// Log traffic for Tailscale IPs.
 	logNetsB.AddPrefix(tsaddr.CGNATRange())
 	logNetsB.AddPrefix(tsaddr.TailscaleULARange())
@@ -1736,6 +2033,28 @@ func (b *LocalBackend) updateFilterLocked(netMap *netmap.NetworkMap, prefs ipn.P
 		} else {
 			warnInvalidUnsignedNodes.Set(nil)
 		}
+
+		// Quarantine peers whose node key has expired: they stay in the
+		// netmap so their state can be shown, but no traffic flows to or
+		// from any of their AllowedIPs, including subnet routes. Exit
+		// routes, and routes a live peer also has, aren't quarantined, as
+		// that would block traffic that doesn't go through the peer.
+		var liveB netipx.IPSetBuilder
+		for _, p := range b.peers {
+			for i := range p.AllowedIPs().LenIter() {
+				pfx := p.AllowedIPs().At(i)
+				switch {
+				case pfx.Bits() == 0:
+				case p.Expired():
+					quarantineB.AddPrefix(pfx)
+				default:
+					liveB.AddPrefix(pfx)
+				}
+			}
+		}
+		if live, err := liveB.IPSet(); err == nil {
+			quarantineB.RemoveSet(live)
+		}
 	}
 	if prefs.Valid() {
 		ar := prefs.AdvertiseRoutes()
@@ -1770,20 +2089,29 @@ func (b *LocalBackend) updateFilterLocked(netMap *netmap.NetworkMap, prefs ipn.P
 	}
 	localNets, _ := localNetsB.IPSet()
 	logNets, _ := logNetsB.IPSet()
+	quarantined, _ := quarantineB.IPSet()
 	var sshPol tailcfg.SSHPolicy
 	if haveNetmap && netMap.SSHPolicy != nil {
 		sshPol = *netMap.SSHPolicy
 	}
+	if connApproval && haveNetmap {
+		b.connApprovals.setPeers(b.peers)
+	} else if b.connApprovals != nil {
+		b.connApprovals.reset()
+	}
 
 	changed := deephash.Update(&b.filterHash, &struct {
-		HaveNetmap  bool
-		Addrs       views.Slice[netip.Prefix]
-		FilterMatch []filter.Match
-		LocalNets   []netipx.IPRange
-		LogNets     []netipx.IPRange
-		ShieldsUp   bool
-		SSHPolicy   tailcfg.SSHPolicy
-	}{haveNetmap, addrs, packetFilter, localNets.Ranges(), logNets.Ranges(), shieldsUp, sshPol})
+		HaveNetmap   bool
+		Addrs        views.Slice[netip.Prefix]
+		FilterMatch  []filter.Match
+		LocalNets    []netipx.IPRange
+		LogNets      []netipx.IPRange
+		Quarantined  []netipx.IPRange
+		ShieldsUp    bool
+		ConnApproval bool
+		HalfOpen     ipn.HalfOpenLimitsPrefs
+		SSHPolicy    tailcfg.SSHPolicy
+	}{haveNetmap, addrs, packetFilter, localNets.Ranges(), logNets.Ranges(), quarantined.Ranges(), shieldsUp, connApproval, halfOpen, sshPol})
 	if !changed {
 		return
 	}
@@ -1795,19 +2123,44 @@ func (b *LocalBackend) updateFilterLocked(netMap *netmap.NetworkMap, prefs ipn.P
 	}
 
 	oldFilter := b.e.GetFilter()
+	var f *filter.Filter
 	if shieldsUp {
 		b.logf("[v1] netmap packet filter: (shields up)")
-		b.setFilter(filter.NewShieldsUpFilter(localNets, logNets, oldFilter, b.logf))
+		f = filter.NewShieldsUpFilter(localNets, logNets, oldFilter, b.logf)
 	} else {
 		b.logf("[v1] netmap packet filter: %v filters", len(packetFilter))
-		b.setFilter(filter.New(packetFilter, localNets, logNets, oldFilter, b.logf))
+		f = filter.New(packetFilter, localNets, logNets, oldFilter, b.logf)
+	}
+	if rs := quarantined.Ranges(); len(rs) > 0 {
+		b.logf("[v1] netmap packet filter: quarantining %v", rs)
+		f.SetQuarantined(quarantined)
 	}
+	if connApproval {
+		b.logf("[v1] netmap packet filter: new incoming connections need approval")
+		f.SetApprover(b.connApprovals.approve)
+	}
+	f.SetConnLimits(halfOpenConnLimits(halfOpen))
+	b.setFilter(f)
 
 	if b.sshServer != nil {
 		go b.sshServer.OnPolicyChange()
 	}
 }
 
+// halfOpenConnLimits returns the packet filter's limits on half-open
+// incoming TCP connections for the prefs hl.
+func halfOpenConnLimits(hl ipn.HalfOpenLimitsPrefs) filter.ConnLimits {
+	l := filter.ConnLimits{
+		MaxPerPeer: hl.MaxPerPeer,
+		MaxTotal:   hl.MaxTotal,
+		Timeout:    hl.Timeout,
+	}
+	if hl.EvictOldest {
+		l.Evict = filter.EvictOldest
+	}
+	return l
+}
+
 // packetFilterPermitsUnlockedNodes reports any peer in peers with the
 // UnsignedPeerAPIOnly bool set true has any of its allowed IPs in the packet
 // filter.
@@ -1848,10 +2201,57 @@ func packetFilterPermitsUnlockedNodes(peers map[tailcfg.NodeID]tailcfg.NodeView,
 }
 
 func (b *LocalBackend) setFilter(f *filter.Filter) {
+	f.SetFlowLog(b.flowLog)
 	b.filterAtomic.Store(f)
 	b.e.SetFilter(f)
 }
 
+// flowLogSize is how many new flows LocalBackend.FlowLog keeps.
+const flowLogSize = 1024
+
+// disableFlowLog makes WatchFlowLog fail, so that the packet filter never
+// records flows.
+var disableFlowLog = envknob.RegisterBool("TS_DISABLE_FLOW_LOG")
+
+// ErrFlowLogDisabled is returned by WatchFlowLog when the flow log is
+// disabled with the TS_DISABLE_FLOW_LOG environment variable.
+var ErrFlowLogDisabled = errors.New("flow log disabled by TS_DISABLE_FLOW_LOG")
+
+// WatchFlowLog returns the log of the new flows seen by the packet filter and
+// its verdicts on them, and a func to call when done with it. The filter
+// records flows while anyone is watching, starting with an empty log.
+func (b *LocalBackend) WatchFlowLog() (fl *filter.FlowLog, done func(), err error) {
+	if disableFlowLog() {
+		return nil, nil, ErrFlowLogDisabled
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.flowLog == nil {
+		b.flowLog = filter.NewFlowLog(flowLogSize)
+		if f := b.filterAtomic.Load(); f != nil {
+			f.SetFlowLog(b.flowLog)
+		}
+	}
+	b.flowLogWatchers++
+	var once sync.Once
+	return b.flowLog, func() { once.Do(b.unwatchFlowLog) }, nil
+}
+
+// unwatchFlowLog stops the packet filter recording flows if no one else is
+// watching them.
+func (b *LocalBackend) unwatchFlowLog() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flowLogWatchers--
+	if b.flowLogWatchers > 0 {
+		return
+	}
+	b.flowLog = nil
+	if f := b.filterAtomic.Load(); f != nil {
+		f.SetFlowLog(nil)
+	}
+}
+
 var removeFromDefaultRoute = []netip.Prefix{
 	// RFC1918 LAN ranges
 	netip.MustParsePrefix("192.168.0.0/16"),
@@ -2079,6 +2479,14 @@ func applyConfigToHostinfo(hi *tailcfg.Hostinfo, c *conffile.Config) {
 // notifications. There is currently (2022-11-22) no mechanism provided to
 // detect when a message has been dropped.
 func (b *LocalBackend) WatchNotifications(ctx context.Context, mask ipn.NotifyWatchOpt, onWatchAdded func(), fn func(roNotify *ipn.Notify) (keepGoing bool)) {
+	b.WatchEvents(ctx, mask, 0, onWatchAdded, fn)
+}
+
+// WatchEvents is like WatchNotifications, but only delivers the types
+// of events in events, filtering out the rest of each Notify, and
+// notifications with none of them, before calling fn. A zero events
+// delivers defaultWatchEvents.
+func (b *LocalBackend) WatchEvents(ctx context.Context, mask ipn.NotifyWatchOpt, events ipn.NotifyEvent, onWatchAdded func(), fn func(roNotify *ipn.Notify) (keepGoing bool)) {
 	ch := make(chan *ipn.Notify, 128)
 
 	sessionID := rands.HexString(16)
@@ -2100,6 +2508,9 @@ func (b *LocalBackend) WatchNotifications(ctx context.Context, mask ipn.NotifyWa
 			return origFn(&n2)
 		}
 	}
+	if events == 0 {
+		events = defaultWatchEvents
+	}
 
 	var ini *ipn.Notify
 
@@ -2122,9 +2533,10 @@ func (b *LocalBackend) WatchNotifications(ctx context.Context, mask ipn.NotifyWa
 		if mask&ipn.NotifyInitialNetMap != 0 {
 			ini.NetMap = b.netMap
 		}
+		ini = ini.Filter(events)
 	}
 
-	handle := b.notifyWatchers.Add(&watchSession{ch, sessionID})
+	handle := b.notifyWatchers.Add(&watchSession{ch, sessionID, events})
 	b.mu.Unlock()
 
 	defer func() {
@@ -2247,8 +2659,12 @@ func (b *LocalBackend) send(n ipn.Notify) {
 	}
 
 	for _, sess := range b.notifyWatchers {
+		sn := n.Filter(sess.events)
+		if sn == nil {
+			continue // nothing the watcher subscribed to
+		}
 		select {
-		case sess.ch <- &n:
+		case sess.ch <- sn:
 		default:
 			// Drop the notification if the channel is full.
 		}
@@ -2257,10 +2673,17 @@ func (b *LocalBackend) send(n ipn.Notify) {
 	b.mu.Unlock()
 
 	if notifyFunc != nil {
-		notifyFunc(n)
+		if n := n.Filter(defaultWatchEvents); n != nil {
+			notifyFunc(*n)
+		}
 	}
 }
 
+// defaultWatchEvents are the events sent to watchers that don't subscribe
+// to particular ones: all of them except Health, which watchers that
+// predate subscriptions don't expect.
+const defaultWatchEvents = ipn.AllNotifyEvents &^ ipn.EventHealth
+
 func (b *LocalBackend) sendFileNotify() {
 	var n ipn.Notify
 
@@ -2500,6 +2923,11 @@ func (b *LocalBackend) setTCPPortsIntercepted(ports []uint16) {
 // and shouldInterceptTCPPortAtomic from the prefs p, which may be !Valid().
 func (b *LocalBackend) setAtomicValuesFromPrefsLocked(p ipn.PrefsView) {
 	b.sshAtomicBool.Store(p.Valid() && p.RunSSH() && envknob.CanSSHD())
+	if p.Valid() {
+		b.updateWebClientLocked(p.WebClient())
+	} else {
+		b.updateWebClientLocked("")
+	}
 
 	if !p.Valid() {
 		b.containsViaIPFuncAtomic.Store(tsaddr.FalseContainsIPFunc())
@@ -2784,6 +3212,60 @@ func (b *LocalBackend) checkPrefsLocked(p *ipn.Prefs) error {
 	if err := b.checkFunnelEnabledLocked(p); err != nil {
 		errs = append(errs, err)
 	}
+	if err := tailcfg.CheckHostinfoMetadata(p.AdvertiseMetadata); err != nil {
+		errs = append(errs, err)
+	}
+	if err := tailcfg.CheckHostinfoDNSRecords(p.AdvertiseDNSRecords); err != nil {
+		errs = append(errs, err)
+	}
+	if err := p.DNSCache.Check(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, src := range p.DNSBlocklists {
+		if err := resolver.CheckBlocklistSource(src); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := p.UDPPort.Check(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := p.HalfOpenLimits.Check(); err != nil {
+		errs = append(errs, err)
+	}
+	for id, pin := range p.EndpointPins {
+		if _, err := magicsock.ParseEndpointPin(pin); err != nil {
+			errs = append(errs, fmt.Errorf("endpoint pin for %v: %w", id, err))
+		}
+	}
+	if _, err := magicsock.ParseEndpointTypePolicy(p.EndpointTypes); err != nil {
+		errs = append(errs, err)
+	}
+	for id, s := range p.PeerEndpointTypes {
+		if _, err := magicsock.ParseEndpointTypePolicy(s); err != nil {
+			errs = append(errs, fmt.Errorf("endpoint types for %v: %w", id, err))
+		}
+	}
+	if _, _, err := ipn.ParseOuterDSCP(p.OuterDSCP); err != nil {
+		errs = append(errs, err)
+	}
+	if p.MaintenanceWindow != "" {
+		if _, err := maintwindow.Parse(p.MaintenanceWindow); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	switch p.WebClient {
+	case "":
+	case ipn.WebClientTailnet, ipn.WebClientLocalhost:
+		if !webClientBuiltIn {
+			errs = append(errs, errors.New("the web client isn't included in this build of tailscaled; build it with the ts_include_webclient tag"))
+		} else if p.WebClient == ipn.WebClientLocalhost && runtime.GOOS != "linux" {
+			// Requests on localhost are authorized by the local user
+			// making them, which can only be looked up on Linux.
+			errs = append(errs, fmt.Errorf("web client mode %q is only supported on Linux", p.WebClient))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown web client mode %q; want %q or %q", p.WebClient, ipn.WebClientTailnet, ipn.WebClientLocalhost))
+	}
 	return multierr.New(errs...)
 }
 
@@ -2862,7 +3344,7 @@ func (b *LocalBackend) isDefaultServerLocked() bool {
 }
 
 func (b *LocalBackend) checkExitNodePrefsLocked(p *ipn.Prefs) error {
-	if (p.ExitNodeIP.IsValid() || p.ExitNodeID != "") && p.AdvertisesExitNode() {
+	if (p.ExitNodeIP.IsValid() || p.ExitNodeID != "" || p.AutoExitNode) && p.AdvertisesExitNode() {
 		return errors.New("Cannot advertise an exit node and use an exit node at the same time.")
 	}
 	return nil
@@ -2885,6 +3367,11 @@ func (b *LocalBackend) EditPrefs(mp *ipn.MaskedPrefs) (ipn.PrefsView, error) {
 	p0 := b.pm.CurrentPrefs()
 	p1 := b.pm.CurrentPrefs().AsStruct()
 	p1.ApplyEdits(mp)
+	if (mp.ExitNodeIDSet || mp.ExitNodeIPSet) && !mp.AutoExitNodeSet {
+		// Choosing an exit node, or none, turns off choosing one
+		// automatically.
+		p1.AutoExitNode = false
+	}
 	if err := b.checkPrefsLocked(p1); err != nil {
 		b.mu.Unlock()
 		b.logf("EditPrefs check error: %v", err)
@@ -3009,6 +3496,9 @@ func (b *LocalBackend) setPrefsLockedOnEntry(caller string, newp *ipn.Prefs) ipn
 	b.lastProfileID = b.pm.CurrentProfile().ID
 	b.mu.Unlock()
 
+	if oldp.MaintenanceWindow() != newp.MaintenanceWindow {
+		b.onMaintenanceWindowOpen()
+	}
 	if oldp.ShieldsUp() != newp.ShieldsUp || hostInfoChanged {
 		b.doSetHostinfoFilterServices(newHi)
 	}
@@ -3029,6 +3519,10 @@ func (b *LocalBackend) setPrefsLockedOnEntry(caller string, newp *ipn.Prefs) ipn
 	}
 
 	b.send(ipn.Notify{Prefs: &prefs})
+
+	if !oldp.AutoExitNode() && newp.AutoExitNode {
+		b.updateAutoExitNode()
+	}
 	return prefs
 }
 
@@ -3096,6 +3590,9 @@ func (b *LocalBackend) TCPHandlerForDst(src, dst netip.AddrPort) (handler func(c
 		opts = append(opts, ptr.To(tcpip.KeepaliveIdleOption(72*time.Hour)))
 		return b.handleSSHConn, opts
 	}
+	if dst.Port() == webClientPort && b.shouldServeWebClient(src.Addr()) {
+		return b.handleWebClientConn, opts
+	}
 	if port, ok := b.GetPeerAPIPort(dst.Addr()); ok && dst.Port() == port {
 		return func(c net.Conn) error {
 			b.handlePeerAPIConn(src, dst, c)
@@ -3175,6 +3672,15 @@ func (b *LocalBackend) NetMap() *netmap.NetworkMap {
 	return b.netMap
 }
 
+// NetMapDiff returns the difference between the previous and the current
+// full netmap. Like NetMap, it doesn't reflect delta updates from control
+// applied since the current netmap was set.
+func (b *LocalBackend) NetMapDiff() *nmdiff.Diff {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return nmdiff.Compare(b.prevNetMap, b.netMap)
+}
+
 func (b *LocalBackend) isEngineBlocked() bool {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -3204,6 +3710,10 @@ func (b *LocalBackend) authReconfig() {
 	disableSubnetsIfPAC := hasCapability(nm, tailcfg.NodeAttrDisableSubnetsIfPAC)
 	dohURL, dohURLOK := exitNodeCanProxyDNS(nm, b.peers, prefs.ExitNodeID())
 	dcfg := dnsConfigForNetmap(nm, b.peers, prefs, b.logf, version.OS())
+	pins := endpointPinsForPeers(prefs, b.peers)
+	epPolicy := endpointPolicyForPeers(prefs, b.peers)
+	routeMetrics := routeMetricsForNetmap(b.logf, nm, prefs)
+	dialRoutes := b.peerDialRoutesLocked()
 	b.mu.Unlock()
 
 	if blocked {
@@ -3219,6 +3729,17 @@ func (b *LocalBackend) authReconfig() {
 		return
 	}
 
+	up := prefs.UDPPort()
+	b.magicConn().SetUDPPortPolicy(magicsock.UDPPortPolicy{
+		RangeFirst:          up.RangeFirst,
+		RangeLast:           up.RangeLast,
+		RotateEvery:         up.RotateEvery,
+		RotateOnPathFailure: up.RotateOnPathFailure,
+	})
+	b.magicConn().SetEndpointPins(pins)
+	b.magicConn().SetEndpointPolicy(epPolicy)
+	b.setOuterQoS(prefs)
+
 	var flags netmap.WGConfigFlags
 	if prefs.RouteAll() {
 		flags |= netmap.AllowSubnetRoutes
@@ -3247,9 +3768,12 @@ func (b *LocalBackend) authReconfig() {
 		b.logf("wgcfg: %v", err)
 		return
 	}
-
 	oneCGNATRoute := shouldUseOneCGNATRoute(b.logf, b.sys.ControlKnobs(), version.OS())
-	rcfg := b.routerConfig(cfg, prefs, oneCGNATRoute)
+	rcfg := b.routerConfig(cfg, prefs, oneCGNATRoute, routeMetrics)
+
+	// Dial routes are only for connections dialed from the node itself,
+	// so they go in the WireGuard config but not the OS routes.
+	addPeerDialRoutes(cfg, dialRoutes)
 
 	err = b.e.Reconfig(cfg, rcfg, dcfg)
 	if err == wgengine.ErrNoChanges {
@@ -3311,6 +3835,7 @@ func dnsConfigForNetmap(nm *netmap.NetworkMap, peers map[tailcfg.NodeID]tailcfg.
 	selfV6Only := views.SliceContainsFunc(nm.GetAddresses(), tsaddr.PrefixIs6) &&
 		!views.SliceContainsFunc(nm.GetAddresses(), tsaddr.PrefixIs4)
 	dcfg.OnlyIPv6 = selfV6Only
+	dcfg.Cache = resolver.CacheConfig(prefs.DNSCache())
 
 	// Populate MagicDNS records. We do this unconditionally so that
 	// quad-100 can always respond to MagicDNS queries, even if the OS
@@ -3364,6 +3889,11 @@ func dnsConfigForNetmap(nm *netmap.NetworkMap, peers map[tailcfg.NodeID]tailcfg.
 		switch rec.Type {
 		case "", "A", "AAAA":
 			// Treat these all the same for now: infer from the value
+		case "CNAME", "SRV", "TXT":
+			if err := addDNSRecord(dcfg, rec, ""); err != nil {
+				logf("[unexpected] invalid DNS record %+v: %v", rec, err)
+			}
+			continue
 		default:
 			// TODO: more
 			continue
@@ -3380,10 +3910,41 @@ func dnsConfigForNetmap(nm *netmap.NetworkMap, peers map[tailcfg.NodeID]tailcfg.
 		dcfg.Hosts[fqdn] = append(dcfg.Hosts[fqdn], ip)
 	}
 
+	// Serve the records nodes publish under their own names, if control
+	// lets them.
+	publish := func(n tailcfg.NodeView) {
+		if !n.Valid() || !n.HasCap(tailcfg.NodeAttrPublishDNSRecords) {
+			return
+		}
+		hi := n.Hostinfo()
+		if !hi.Valid() || hi.DNSRecords().Len() == 0 {
+			return
+		}
+		recs := hi.DNSRecords().AsSlice()
+		if err := tailcfg.CheckHostinfoDNSRecords(recs); err != nil {
+			logf("ignoring DNS records of %q: %v", n.Name(), err)
+			return
+		}
+		origin, err := dnsname.ToFQDN(strings.ToLower(n.Name()))
+		if err != nil || n.Name() == "" {
+			return
+		}
+		for _, rec := range recs {
+			if err := addDNSRecord(dcfg, rec, origin); err != nil {
+				logf("ignoring DNS record %+v of %q: %v", rec, n.Name(), err)
+			}
+		}
+	}
+	publish(nm.SelfNode)
+	for _, peer := range peers {
+		publish(peer)
+	}
+
 	if !prefs.CorpDNS() {
 		return dcfg
 	}
 
+	dcfg.Blocklist = dnsBlocklistConfig(nm, prefs, logf)
 	for _, dom := range nm.DNS.Domains {
 		fqdn, err := dnsname.ToFQDN(dom)
 		if err != nil {
@@ -3398,7 +3959,7 @@ func dnsConfigForNetmap(nm *netmap.NetworkMap, peers map[tailcfg.NodeID]tailcfg.
 	}
 
 	addDefault := func(resolvers []*dnstype.Resolver) {
-		dcfg.DefaultResolvers = append(dcfg.DefaultResolvers, resolvers...)
+		dcfg.DefaultResolvers = append(dcfg.DefaultResolvers, validResolvers(logf, resolvers)...)
 	}
 
 	// If we're using an exit node and that exit node is new enough (1.19.x+)
@@ -3435,8 +3996,11 @@ func dnsConfigForNetmap(nm *netmap.NetworkMap, peers map[tailcfg.NodeID]tailcfg.
 		// slice appropriately.
 		// Per #9498 the exact requirements of nil vs empty slice remain
 		// unclear, this is a haunted graveyard to be resolved.
+		//
+		// Invalid resolvers are dropped; if that leaves none, the suffix
+		// is answered locally rather than leaked to the default resolvers.
 		dcfg.Routes[fqdn] = make([]*dnstype.Resolver, 0, len(resolvers))
-		dcfg.Routes[fqdn] = append(dcfg.Routes[fqdn], resolvers...)
+		dcfg.Routes[fqdn] = append(dcfg.Routes[fqdn], validResolvers(logf, resolvers)...)
 	}
 
 	// Set FallbackResolvers as the default resolvers in the
@@ -3468,6 +4032,32 @@ func dnsConfigForNetmap(nm *netmap.NetworkMap, peers map[tailcfg.NodeID]tailcfg.
 	return dcfg
 }
 
+// dnsBlocklistConfig returns the blocklists of names for tailscaled's
+// resolver to block: those of the tailnet's DNS settings, which must be
+// HTTPS URLs, and those of prefs.
+func dnsBlocklistConfig(nm *netmap.NetworkMap, prefs ipn.PrefsView, logf logger.Logf) resolver.BlocklistConfig {
+	var bc resolver.BlocklistConfig
+	add := func(src string) {
+		if !slices.Contains(bc.Sources, src) {
+			bc.Sources = append(bc.Sources, src)
+		}
+	}
+	for _, src := range nm.DNS.Blocklists {
+		if !strings.HasPrefix(src, "https://") || resolver.CheckBlocklistSource(src) != nil {
+			logf("ignoring DNS blocklist %q from control: not an HTTPS URL", src)
+			continue
+		}
+		add(src)
+	}
+	for _, src := range prefs.DNSBlocklists().AsSlice() {
+		add(src)
+	}
+	if len(bc.Sources) > 0 {
+		bc.NullIP = prefs.DNSBlocklistNullIP()
+	}
+	return bc
+}
+
 // SetTCPHandlerForFunnelFlow sets the TCP handler for Funnel flows.
 // It should only be called before the LocalBackend is used.
 func (b *LocalBackend) SetTCPHandlerForFunnelFlow(h func(src netip.AddrPort, dstPort uint16) (handler func(net.Conn))) {
@@ -3738,8 +4328,66 @@ func peerRoutes(logf logger.Logf, peers []wgcfg.Peer, cgnatThreshold int) (route
 	return routes
 }
 
-// routerConfig produces a router.Config from a wireguard config and IPN prefs.
-func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs ipn.PrefsView, oneCGNATRoute bool) *router.Config {
+// routeMetricsForNetmap returns the metrics to install routes with: the
+// user's preferences, overridden by control's NodeAttrRouteMetrics
+// policy, if any.
+func routeMetricsForNetmap(logf logger.Logf, nm *netmap.NetworkMap, prefs ipn.PrefsView) ipn.RouteMetricPrefs {
+	rm := prefs.RouteMetrics()
+	if nm == nil || !nm.SelfNode.Valid() {
+		return rm
+	}
+	vals, ok := nm.SelfNode.CapMap().GetOk(tailcfg.NodeAttrRouteMetrics)
+	if !ok {
+		return rm
+	}
+	for i := 0; i < vals.Len(); i++ {
+		var v ipn.RouteMetricPrefs
+		if err := json.Unmarshal([]byte(vals.At(i)), &v); err != nil {
+			logf("invalid %q node attribute: %v", tailcfg.NodeAttrRouteMetrics, err)
+			continue
+		}
+		if v.Tailnet != 0 {
+			rm.Tailnet = v.Tailnet
+		}
+		if v.Subnet != 0 {
+			rm.Subnet = v.Subnet
+		}
+		if v.ExitNode != 0 {
+			rm.ExitNode = v.ExitNode
+		}
+	}
+	return rm
+}
+
+// routeMetricsFor returns the router.Config.RouteMetrics for routes,
+// classifying each as a route via an exit node (a default route or one of
+// exitRoutes), a route to tailnet addresses, or a subnet route.
+func routeMetricsFor(routes, exitRoutes []netip.Prefix, rm ipn.RouteMetricPrefs) map[netip.Prefix]uint32 {
+	if rm == (ipn.RouteMetricPrefs{}) {
+		return nil
+	}
+	var ret map[netip.Prefix]uint32
+	for _, r := range routes {
+		var m uint32
+		switch {
+		case r.Bits() == 0 || slices.Contains(exitRoutes, r):
+			m = rm.ExitNode
+		case r == tsaddr.CGNATRange() || r == tsaddr.TailscaleULARange() ||
+			r.IsSingleIP() && tsaddr.IsTailscaleIP(r.Addr()):
+			m = rm.Tailnet
+		default:
+			m = rm.Subnet
+		}
+		if m != 0 {
+			mak.Set(&ret, r, m)
+		}
+	}
+	return ret
+}
+
+// routerConfig produces a router.Config from a wireguard config and IPN
+// prefs. routeMetrics are the metrics to install the routes with.
+func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs ipn.PrefsView, oneCGNATRoute bool, routeMetrics ipn.RouteMetricPrefs) *router.Config {
 	singleRouteThreshold := 10_000
 	if oneCGNATRoute {
 		singleRouteThreshold = 1
@@ -3764,6 +4412,7 @@ func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs ipn.PrefsView, oneC
 	// likely to break some functionality, but if the user expressed a
 	// preference for routing remotely, we want to avoid leaking
 	// traffic at the expense of functionality.
+	var exitRoutes []netip.Prefix
 	if prefs.ExitNodeID() != "" || prefs.ExitNodeIP().IsValid() {
 		var default4, default6 bool
 		for _, route := range rs.Routes {
@@ -3795,6 +4444,7 @@ func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs ipn.PrefsView, oneC
 				// Explicitly add routes to the local network so that we do not
 				// leak any traffic.
 				rs.Routes = append(rs.Routes, externalIPs...)
+				exitRoutes = externalIPs
 			}
 			b.logf("allowing exit node access to local IPs: %v", rs.LocalRoutes)
 		}
@@ -3803,6 +4453,7 @@ func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs ipn.PrefsView, oneC
 	if slices.ContainsFunc(rs.LocalAddrs, tsaddr.PrefixIs4) {
 		rs.Routes = append(rs.Routes, netip.PrefixFrom(tsaddr.TailscaleServiceIP(), 32))
 	}
+	rs.RouteMetrics = routeMetricsFor(rs.Routes, exitRoutes, routeMetrics)
 
 	return rs
 }
@@ -3829,6 +4480,9 @@ func (b *LocalBackend) applyPrefsToHostinfoLocked(hi *tailcfg.Hostinfo, prefs ip
 	hi.RequestTags = prefs.AdvertiseTags().AsSlice()
 	hi.ShieldsUp = prefs.ShieldsUp()
 	hi.AllowsUpdate = envknob.AllowsRemoteUpdate() || prefs.AutoUpdate().Apply
+	md := prefs.AdvertiseMetadata()
+	hi.Metadata = md.AsMap()
+	hi.DNSRecords = prefs.AdvertiseDNSRecords().AsSlice()
 
 	var sshHostKeys []string
 	if prefs.RunSSH() && envknob.CanSSHD() {
@@ -4076,6 +4730,7 @@ func (b *LocalBackend) resetControlClientLocked() controlclient.Client {
 	if b.nmExpiryTimer != nil {
 		b.nmExpiryTimer.Stop()
 		b.nmExpiryTimer = nil
+		b.recheckExpiry = nil
 
 		// Also bump the epoch to ensure that if the timer started, it
 		// will abort.
@@ -4190,12 +4845,45 @@ func (b *LocalBackend) setNetInfo(ni *tailcfg.NetInfo) {
 	cc := b.cc
 	b.mu.Unlock()
 
+	// There's a new netcheck report, so the exit nodes' latencies may
+	// have changed.
+	go b.updateAutoExitNode()
+
 	if cc == nil {
 		return
 	}
 	cc.SetNetInfo(ni)
 }
 
+// onNetcheckChanges is called by magicsock when key facts about the
+// network, such as the NAT type, change between netcheck reports.
+func (b *LocalBackend) onNetcheckChanges(changes []string) {
+	b.send(ipn.Notify{NetcheckChanges: changes})
+}
+
+// onFailover is called by magicsock when traffic moves to a new network
+// interface after a link change.
+func (b *LocalBackend) onFailover(ev ipnstate.FailoverEvent) {
+	b.send(ipn.Notify{Failover: &ev})
+}
+
+// NetcheckHistory returns the recent netcheck reports, oldest first.
+func (b *LocalBackend) NetcheckHistory() []netcheck.HistoryEntry {
+	return b.magicConn().NetcheckHistory()
+}
+
+// DERPRaceStatus returns the home and standby DERP regions and the most
+// recent race to replace a failed home region.
+func (b *LocalBackend) DERPRaceStatus() magicsock.DERPRaceStatus {
+	return b.magicConn().DERPRaceStatus()
+}
+
+// PortMapLeases returns the port mappings currently held on the
+// machine's home routers.
+func (b *LocalBackend) PortMapLeases() []portmapper.Lease {
+	return b.magicConn().PortMapLeases()
+}
+
 func hasCapability(nm *netmap.NetworkMap, cap tailcfg.NodeCapability) bool {
 	if nm != nil {
 		return nm.SelfNode.HasCap(cap)
@@ -4215,6 +4903,9 @@ func (b *LocalBackend) setNetMapLocked(nm *netmap.NetworkMap) {
 	if nm != nil {
 		login = cmpx.Or(nm.UserProfiles[nm.User()].LoginName, "<missing-profile>")
 	}
+	if nm != b.netMap {
+		b.prevNetMap = b.netMap
+	}
 	b.netMap = nm
 	b.updatePeersFromNetmapLocked(nm)
 	if login != b.activeLogin {
@@ -4362,6 +5053,9 @@ func (b *LocalBackend) setTCPPortsInterceptedFromNetmapAndPrefsLocked(prefs ipn.
 	if prefs.Valid() && prefs.RunSSH() && envknob.CanSSHD() {
 		handlePorts = append(handlePorts, 22)
 	}
+	if prefs.Valid() && prefs.WebClient() == ipn.WebClientTailnet {
+		handlePorts = append(handlePorts, webClientPort)
+	}
 
 	b.reloadServeConfigLocked(prefs)
 	if b.serveConfig.Valid() {
@@ -4811,6 +5505,189 @@ func (b *LocalBackend) SetExpirySooner(ctx context.Context, expiry time.Time) er
 	return cc.SetExpirySooner(ctx, expiry)
 }
 
+// setOuterQoS sets the marking of the UDP packets carrying WireGuard
+// traffic per prefs.
+func (b *LocalBackend) setOuterQoS(prefs ipn.PrefsView) {
+	copyDSCP, dscp, _ := ipn.ParseOuterDSCP(prefs.OuterDSCP()) // validated by checkPrefsLocked
+	mc := b.magicConn()
+	mc.SetOuterQoS(magicsock.OuterQoS{
+		CopyDSCP:   copyDSCP,
+		DSCP:       dscp,
+		FlowLabels: prefs.IPv6FlowLabels(),
+	})
+	if tunWrap, ok := b.sys.Tun.GetOK(); ok {
+		if copyDSCP {
+			tunWrap.SetDSCPNotifyFunc(mc.NoteInnerDSCP)
+		} else {
+			tunWrap.SetDSCPNotifyFunc(nil)
+		}
+	}
+}
+
+// endpointPinsForPeers returns the magicsock endpoint pins for the peers
+// pinned in prefs. Pins for nodes not in peers, and invalid pins (which
+// checkPrefsLocked rejects), are ignored.
+func endpointPinsForPeers(prefs ipn.PrefsView, peers map[tailcfg.NodeID]tailcfg.NodeView) map[key.NodePublic]magicsock.EndpointPin {
+	if !prefs.Valid() || prefs.EndpointPins().Len() == 0 {
+		return nil
+	}
+	var pins map[key.NodePublic]magicsock.EndpointPin
+	for _, p := range peers {
+		s, ok := prefs.EndpointPins().GetOk(p.StableID())
+		if !ok {
+			continue
+		}
+		if pin, err := magicsock.ParseEndpointPin(s); err == nil {
+			mak.Set(&pins, p.Key(), pin)
+		}
+	}
+	return pins
+}
+
+// endpointPolicyForPeers returns the magicsock endpoint type policy set
+// by prefs' EndpointTypes and PeerEndpointTypes, or nil if there's none.
+// Overrides for nodes not in peers, and invalid policies (which
+// checkPrefsLocked rejects), are ignored.
+func endpointPolicyForPeers(prefs ipn.PrefsView, peers map[tailcfg.NodeID]tailcfg.NodeView) *magicsock.EndpointPolicy {
+	if !prefs.Valid() || (prefs.EndpointTypes() == "" && prefs.PeerEndpointTypes().Len() == 0) {
+		return nil
+	}
+	pol := new(magicsock.EndpointPolicy)
+	if def, err := magicsock.ParseEndpointTypePolicy(prefs.EndpointTypes()); err == nil {
+		pol.Default = def
+	}
+	for _, p := range peers {
+		s, ok := prefs.PeerEndpointTypes().GetOk(p.StableID())
+		if !ok {
+			continue
+		}
+		if pp, err := magicsock.ParseEndpointTypePolicy(s); err == nil {
+			mak.Set(&pol.Peers, p.Key(), pp)
+		}
+	}
+	return pol
+}
+
+// validResolvers returns the resolvers in rs that pass
+// dnstype.Resolver.Check, logging the others.
+func validResolvers(logf logger.Logf, rs []*dnstype.Resolver) []*dnstype.Resolver {
+	var valid []*dnstype.Resolver
+	for _, r := range rs {
+		if err := r.Check(); err != nil {
+			logf("ignoring DNS resolver: %v", err)
+			continue
+		}
+		valid = append(valid, r)
+	}
+	return valid
+}
+
+// addDNSRecord adds the CNAME, SRV or TXT record rec to dcfg.Records.
+// If origin is non-empty, names in rec not ending in a dot are relative
+// to it, like in a zone file.
+func addDNSRecord(dcfg *dns.Config, rec tailcfg.DNSRecord, origin dnsname.FQDN) error {
+	name, err := qualifyDNSName(rec.Name, origin)
+	if err != nil {
+		return err
+	}
+	recs := dcfg.Records[name]
+	if recs == nil {
+		recs = new(resolver.Records)
+		mak.Set(&dcfg.Records, name, recs)
+	}
+	switch rec.Type {
+	case "CNAME":
+		target, err := qualifyDNSName(rec.Value, origin)
+		if err != nil {
+			return err
+		}
+		recs.CNAME = target
+	case "SRV":
+		priority, weight, port, target, err := tailcfg.ParseSRVValue(rec.Value)
+		if err != nil {
+			return err
+		}
+		fqdn, err := qualifyDNSName(target, origin)
+		if err != nil {
+			return err
+		}
+		recs.SRV = append(recs.SRV, &net.SRV{
+			Target:   fqdn.WithTrailingDot(),
+			Port:     port,
+			Priority: priority,
+			Weight:   weight,
+		})
+	case "TXT":
+		recs.TXT = append(recs.TXT, rec.Value)
+	default:
+		return fmt.Errorf("unsupported type %q", rec.Type)
+	}
+	return nil
+}
+
+// qualifyDNSName returns name as a lowercase FQDN. If origin is
+// non-empty and name doesn't end in a dot, name is relative to origin,
+// with "@" meaning origin itself.
+func qualifyDNSName(name string, origin dnsname.FQDN) (dnsname.FQDN, error) {
+	switch {
+	case origin == "" || strings.HasSuffix(name, "."):
+	case name == "@":
+		return origin, nil
+	default:
+		name += "." + origin.WithTrailingDot()
+	}
+	return dnsname.ToFQDN(strings.ToLower(name))
+}
+
+// dnsQueryTypes are the DNS query types TraceDNSQuery accepts, by name.
+var dnsQueryTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"PTR":   dnsmessage.TypePTR,
+	"SOA":   dnsmessage.TypeSOA,
+	"SRV":   dnsmessage.TypeSRV,
+	"TXT":   dnsmessage.TypeTXT,
+}
+
+// TraceDNSQuery resolves name through tailscaled's DNS resolver, as a
+// query from the OS would be, and reports which rule matched and which
+// upstream answered. It's for debugging DNS configurations.
+func (b *LocalBackend) TraceDNSQuery(ctx context.Context, name, queryType string) (*dnstype.QueryTrace, error) {
+	typ, ok := dnsQueryTypes[strings.ToUpper(queryType)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported DNS query type %q", queryType)
+	}
+	dm, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return nil, errors.New("no DNS manager")
+	}
+	return dm.TraceQuery(ctx, name, typ)
+}
+
+// FlushDNSCache empties tailscaled's DNS resolver's cache of responses
+// from upstream resolvers.
+func (b *LocalBackend) FlushDNSCache() error {
+	dm, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return errors.New("no DNS manager")
+	}
+	dm.FlushCache()
+	return nil
+}
+
+// DNSOSConfiguratorStatus reports how tailscaled configures the OS's
+// DNS settings.
+func (b *LocalBackend) DNSOSConfiguratorStatus() (*dnstype.OSConfiguratorStatus, error) {
+	dm, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return nil, errors.New("no DNS manager")
+	}
+	return dm.OSConfiguratorStatus(), nil
+}
+
 // exitNodeCanProxyDNS reports the DoH base URL ("http://foo/dns-query") without query parameters
 // to exitNodeID's DoH service, if available.
 //
@@ -4886,6 +5763,25 @@ func (b *LocalBackend) ControlKnobs() *controlknobs.Knobs {
 	return b.sys.ControlKnobs()
 }
 
+// onClockJump is called by the control client when the wall clock jumps by
+// jump relative to the monotonic clock, such as after a suspend and resume.
+//
+// Node expiry is computed from the wall clock, so it's rechecked right away
+// against the new time rather than waiting for a timer armed before the
+// jump. Peer paths were last validated on the monotonic clock, which may not
+// have advanced while suspended, so they're re-validated too.
+func (b *LocalBackend) onClockJump(jump time.Duration) {
+	b.mu.Lock()
+	recheck := b.recheckExpiry
+	b.mu.Unlock()
+	if recheck != nil {
+		recheck(fmt.Sprintf("clock jumped by %v", jump.Round(time.Second)))
+	}
+	if jump > 0 {
+		b.magicConn().NoteClockJump()
+	}
+}
+
 func (b *LocalBackend) magicConn() *magicsock.Conn {
 	return b.sys.MagicSock.Get()
 }
@@ -5251,6 +6147,59 @@ func (b *LocalBackend) NewProfile() error {
 	return b.resetForProfileChangeLockedOnEntry()
 }
 
+// ExportProfile returns the portable configuration of the profile with
+// the given id, without its keys, for importing on another machine.
+func (b *LocalBackend) ExportProfile(id ipn.ProfileID) (*ipn.ProfileExport, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pm.ExportProfile(id)
+}
+
+// ImportProfile adds a profile with the configuration in ex. If
+// switchTo is set, it also switches to it, restarting the backend, with
+// no other profile change possible in between.
+func (b *LocalBackend) ImportProfile(ex *ipn.ProfileExport, switchTo bool) (ipn.LoginProfile, error) {
+	b.mu.Lock()
+	if err := b.checkImportLocked(ex); err != nil {
+		b.mu.Unlock()
+		b.logf("ImportProfile check error: %v", err)
+		return ipn.LoginProfile{}, err
+	}
+	lp, err := b.pm.ImportProfile(ex)
+	if err != nil || !switchTo {
+		b.mu.Unlock()
+		return lp, err
+	}
+	if err := b.pm.SwitchProfile(lp.ID); err != nil {
+		b.mu.Unlock()
+		return lp, err
+	}
+	return lp, b.resetForProfileChangeLockedOnEntry()
+}
+
+// checkImportLocked validates ex, a profile about to be imported, the same
+// way EditPrefs validates edits to the current profile's prefs.
+func (b *LocalBackend) checkImportLocked(ex *ipn.ProfileExport) error {
+	if err := ex.Check(); err != nil {
+		return err
+	}
+	p := ex.Prefs
+	if err := b.checkPrefsLocked(p); err != nil {
+		return err
+	}
+	if p.RunSSH && !envknob.CanSSHD() {
+		return errors.New("Tailscale SSH server administratively disabled.")
+	}
+	// checkPrefsLocked allows the current profile's name, but the import
+	// is a new profile, so its names can't be in use by any.
+	for _, name := range []string{ex.Name, p.ProfileName} {
+		if name != "" && b.pm.ProfileIDForName(name) != "" {
+			return fmt.Errorf("profile name %q already in use", name)
+		}
+	}
+	return nil
+}
+
 // ListProfiles returns a list of all LoginProfiles.
 func (b *LocalBackend) ListProfiles() []ipn.LoginProfile {
 	b.mu.Lock()
@@ -5280,8 +6229,9 @@ func (b *LocalBackend) ResetAuth() error {
 }
 
 // StreamDebugCapture writes a pcap stream of packets traversing
-// tailscaled to the provided response writer.
-func (b *LocalBackend) StreamDebugCapture(ctx context.Context, w io.Writer) error {
+// tailscaled to the provided response writer, with the given output
+// options.
+func (b *LocalBackend) StreamDebugCapture(ctx context.Context, w io.Writer, opts capture.OutputOptions) error {
 	var s *capture.Sink
 
 	b.mu.Lock()
@@ -5294,7 +6244,7 @@ func (b *LocalBackend) StreamDebugCapture(ctx context.Context, w io.Writer) erro
 	}
 	b.mu.Unlock()
 
-	unregister := s.RegisterOutput(w)
+	unregister := s.RegisterOutputWithOptions(w, opts)
 
 	select {
 	case <-ctx.Done():
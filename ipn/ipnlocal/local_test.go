@@ -5,24 +5,34 @@
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/netip"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"go4.org/netipx"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/control/controlclient"
+	"tailscale.com/envknob"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/conffile"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/net/interfaces"
+	"tailscale.com/net/packet"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tsd"
 	"tailscale.com/tstest"
 	"tailscale.com/types/dnstype"
+	"tailscale.com/types/ipproto"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
@@ -32,6 +42,7 @@
 	"tailscale.com/util/set"
 	"tailscale.com/wgengine"
 	"tailscale.com/wgengine/filter"
+	"tailscale.com/wgengine/magicsock"
 	"tailscale.com/wgengine/wgcfg"
 )
 
@@ -260,6 +271,124 @@ func TestPeerRoutes(t *testing.T) {
 
 }
 
+func TestRouteMetricsFor(t *testing.T) {
+	pp := netip.MustParsePrefix
+	routes := []netip.Prefix{
+		pp("0.0.0.0/0"),
+		pp("::/0"),
+		pp("10.0.0.0/8"),
+		pp("100.64.0.0/10"),
+		pp("100.101.102.103/32"),
+		pp("100.100.100.100/32"),
+		pp("192.168.1.0/24"),
+		pp("fd7a:115c:a1e0::/48"),
+	}
+	exitRoutes := []netip.Prefix{pp("192.168.1.0/24")}
+
+	if got := routeMetricsFor(routes, exitRoutes, ipn.RouteMetricPrefs{}); got != nil {
+		t.Errorf("no metrics: got %v; want nil", got)
+	}
+
+	got := routeMetricsFor(routes, exitRoutes, ipn.RouteMetricPrefs{Tailnet: 10, ExitNode: 1000})
+	want := map[netip.Prefix]uint32{
+		pp("0.0.0.0/0"):           1000,
+		pp("::/0"):                1000,
+		pp("100.64.0.0/10"):       10,
+		pp("100.101.102.103/32"):  10,
+		pp("100.100.100.100/32"):  10,
+		pp("192.168.1.0/24"):      1000,
+		pp("fd7a:115c:a1e0::/48"): 10,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	got = routeMetricsFor(routes, nil, ipn.RouteMetricPrefs{Subnet: 500})
+	want = map[netip.Prefix]uint32{
+		pp("10.0.0.0/8"):     500,
+		pp("192.168.1.0/24"): 500,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestRouteMetricsForNetmap(t *testing.T) {
+	prefs := &ipn.Prefs{RouteMetrics: ipn.RouteMetricPrefs{Tailnet: 10, Subnet: 500}}
+	nm := &netmap.NetworkMap{
+		SelfNode: (&tailcfg.Node{
+			CapMap: tailcfg.NodeCapMap{
+				tailcfg.NodeAttrRouteMetrics: {`{"Subnet":50,"ExitNode":2000}`},
+			},
+		}).View(),
+	}
+	want := ipn.RouteMetricPrefs{Tailnet: 10, Subnet: 50, ExitNode: 2000}
+	if got := routeMetricsForNetmap(t.Logf, nm, prefs.View()); got != want {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+	if got := routeMetricsForNetmap(t.Logf, nil, prefs.View()); got != prefs.RouteMetrics {
+		t.Errorf("nil netmap: got %+v; want %+v", got, prefs.RouteMetrics)
+	}
+	if got := routeMetricsForNetmap(t.Logf, &netmap.NetworkMap{}, prefs.View()); got != prefs.RouteMetrics {
+		t.Errorf("netmap without SelfNode: got %+v; want %+v", got, prefs.RouteMetrics)
+	}
+}
+
+// TestEndpointPolicyFromPrefs tests that Prefs.EndpointTypes and
+// Prefs.PeerEndpointTypes reach magicsock when the engine is reconfigured.
+func TestEndpointPolicyFromPrefs(t *testing.T) {
+	b := newTestLocalBackend(t)
+	peer := &tailcfg.Node{
+		ID:        2,
+		StableID:  "n2",
+		Key:       key.NewNode().Public(),
+		Hostinfo:  (&tailcfg.Hostinfo{}).View(),
+		Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32")},
+	}
+	b.mu.Lock()
+	b.setNetMapLocked(&netmap.NetworkMap{
+		SelfNode: (&tailcfg.Node{
+			Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+		}).View(),
+		Peers: nodeViews([]*tailcfg.Node{peer}),
+	})
+	b.mu.Unlock()
+
+	setPrefs := func(p *ipn.Prefs) {
+		t.Helper()
+		p.WantRunning = true
+		if err := b.pm.SetPrefs(p.View(), ""); err != nil {
+			t.Fatal(err)
+		}
+		b.authReconfig()
+	}
+
+	setPrefs(&ipn.Prefs{
+		EndpointTypes: "local,-portmap",
+		PeerEndpointTypes: map[tailcfg.StableNodeID]string{
+			"n2":      "-stun",
+			"unknown": "-local",
+		},
+	})
+	want := &magicsock.EndpointPolicy{
+		Default: magicsock.EndpointTypePolicy{
+			Ignore: []tailcfg.EndpointType{tailcfg.EndpointPortmapped},
+			Prefer: []tailcfg.EndpointType{tailcfg.EndpointLocal},
+		},
+		Peers: map[key.NodePublic]magicsock.EndpointTypePolicy{
+			peer.Key: {Ignore: []tailcfg.EndpointType{tailcfg.EndpointSTUN}},
+		},
+	}
+	if got := b.magicConn().EndpointPolicy(); !reflect.DeepEqual(got, want) {
+		t.Errorf("endpoint policy = %+v; want %+v", got, want)
+	}
+
+	setPrefs(&ipn.Prefs{})
+	if got := b.magicConn().EndpointPolicy(); got != nil {
+		t.Errorf("endpoint policy after clearing prefs = %+v; want nil", got)
+	}
+}
+
 func TestPeerAPIBase(t *testing.T) {
 	tests := []struct {
 		name string
@@ -776,6 +905,38 @@ func TestWatchNotificationsCallbacks(t *testing.T) {
 	}
 }
 
+func TestImportantPeers(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := newTestLocalBackend(t)
+	b.clock = tstest.NewClock(tstest.ClockOpts{Start: now})
+
+	var keys []key.NodePublic
+	b.netMap = &netmap.NetworkMap{}
+	for i := 1; i <= 4; i++ {
+		k := key.NewNode().Public()
+		keys = append(keys, k)
+		b.netMap.Peers = append(b.netMap.Peers, (&tailcfg.Node{
+			ID:       tailcfg.NodeID(i),
+			StableID: tailcfg.StableNodeID(fmt.Sprintf("stable-%d", i)),
+			Key:      k,
+		}).View())
+	}
+	b.updatePeersFromNetmapLocked(b.netMap)
+	if err := b.pm.SetPrefs((&ipn.Prefs{ExitNodeID: "stable-4"}).View(), ""); err != nil {
+		t.Fatal(err)
+	}
+	b.engineStatus.LivePeers = map[key.NodePublic]ipnstate.PeerStatusLite{
+		keys[0]: {NodeKey: keys[0], LastHandshake: now.Add(-time.Minute)},
+		keys[1]: {NodeKey: keys[1], LastHandshake: now.Add(-time.Hour)}, // idle
+	}
+
+	got := b.importantPeers()
+	want := set.SetOf([]tailcfg.NodeID{1, 4})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("importantPeers = %v, want %v", got.Slice(), want.Slice())
+	}
+}
+
 // tests LocalBackend.updateNetmapDeltaLocked
 func TestUpdateNetmapDelta(t *testing.T) {
 	var b LocalBackend
@@ -1157,3 +1318,792 @@ func routesEqual(t *testing.T, a, b map[dnsname.FQDN][]*dnstype.Resolver) bool {
 	}
 	return true
 }
+
+func TestSetMapSessionLogLevel(t *testing.T) {
+	t.Cleanup(func() { controlclient.SetMapLogLevel(controlclient.MapLogDefault) })
+	clock := tstest.NewClock(tstest.ClockOpts{Start: time.Unix(1700000000, 0)})
+	b := newTestLocalBackend(t)
+	b.clock = clock
+
+	b.SetMapSessionLogLevel(controlclient.MapLogPeer, clock.Now().Add(time.Minute))
+	if got := controlclient.GetMapLogLevel(); got != controlclient.MapLogPeer {
+		t.Fatalf("level = %v; want %v", got, controlclient.MapLogPeer)
+	}
+	clock.Advance(30 * time.Second)
+	if got := controlclient.GetMapLogLevel(); got != controlclient.MapLogPeer {
+		t.Fatalf("level after 30s = %v; want %v", got, controlclient.MapLogPeer)
+	}
+	clock.Advance(time.Minute)
+	if got := controlclient.GetMapLogLevel(); got != controlclient.MapLogDefault {
+		t.Fatalf("level after expiry = %v; want %v", got, controlclient.MapLogDefault)
+	}
+
+	// A reset stops the pending revert of an earlier level.
+	b.SetMapSessionLogLevel(controlclient.MapLogSummary, clock.Now().Add(time.Minute))
+	b.SetMapSessionLogLevel(controlclient.MapLogDefault, time.Time{})
+	if got := controlclient.GetMapLogLevel(); got != controlclient.MapLogDefault {
+		t.Fatalf("level after reset = %v; want %v", got, controlclient.MapLogDefault)
+	}
+}
+
+func TestQuarantineExpiredPeers(t *testing.T) {
+	b := newTestLocalBackend(t)
+	expired := &tailcfg.Node{
+		ID:        2,
+		StableID:  "expired",
+		Key:       key.NewNode().Public(),
+		Hostinfo:  (&tailcfg.Hostinfo{}).View(),
+		Expired:   true,
+		Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32")},
+		AllowedIPs: []netip.Prefix{
+			netip.MustParsePrefix("100.64.0.2/32"),
+			netip.MustParsePrefix("10.1.0.0/24"),
+			netip.MustParsePrefix("10.2.0.0/24"),
+			netip.MustParsePrefix("0.0.0.0/0"),
+			netip.MustParsePrefix("::/0"),
+		},
+	}
+	live := &tailcfg.Node{
+		ID:        3,
+		StableID:  "live",
+		Key:       key.NewNode().Public(),
+		Hostinfo:  (&tailcfg.Hostinfo{}).View(),
+		Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.3/32")},
+		AllowedIPs: []netip.Prefix{
+			netip.MustParsePrefix("100.64.0.3/32"),
+			netip.MustParsePrefix("10.2.0.0/24"),
+		},
+	}
+	nm := &netmap.NetworkMap{
+		SelfNode: (&tailcfg.Node{
+			Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+		}).View(),
+		Peers: nodeViews([]*tailcfg.Node{expired, live}),
+		PacketFilter: []filter.Match{{
+			IPProto: []ipproto.Proto{ipproto.UDP},
+			Srcs:    []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0")},
+			Dsts:    []filter.NetPortRange{{Net: netip.MustParsePrefix("100.64.0.1/32"), Ports: filter.PortRange{First: 0, Last: 65535}}},
+		}},
+	}
+
+	b.mu.Lock()
+	b.setNetMapLocked(nm)
+	b.updateFilterLocked(nm, (&ipn.Prefs{}).View())
+	b.mu.Unlock()
+
+	f := b.e.GetFilter()
+	from := func(src string) *packet.Parsed {
+		p := new(packet.Parsed)
+		p.Decode(packet.Generate(&packet.UDP4Header{
+			IP4Header: packet.IP4Header{Src: netip.MustParseAddr(src), Dst: netip.MustParseAddr("100.64.0.1")},
+			SrcPort:   1,
+			DstPort:   2,
+		}, []byte("payload")))
+		return p
+	}
+	if got := f.RunIn(from("100.64.0.2"), 0); got != filter.Drop {
+		t.Errorf("packet from expired peer = %v; want Drop", got)
+	}
+	if got := f.RunIn(from("100.64.0.3"), 0); got != filter.Accept {
+		t.Errorf("packet from live peer = %v; want Accept", got)
+	}
+	if got := f.RunIn(from("10.1.0.5"), 0); got != filter.Drop {
+		t.Errorf("packet from expired peer's subnet route = %v; want Drop", got)
+	}
+	if got := f.RunIn(from("10.2.0.5"), 0); got != filter.Accept {
+		t.Errorf("packet from route shared with live peer = %v; want Accept", got)
+	}
+	if got := f.RunIn(from("8.8.8.8"), 0); got != filter.Accept {
+		t.Errorf("packet from the internet = %v; want Accept", got)
+	}
+
+	sb := new(ipnstate.StatusBuilder)
+	b.mu.Lock()
+	b.populatePeerStatusLocked(sb)
+	b.mu.Unlock()
+	st := sb.Status()
+	for _, ps := range st.Peer {
+		if want := ps.ID == "expired"; ps.Quarantined != want {
+			t.Errorf("peer %v Quarantined = %v; want %v", ps.ID, ps.Quarantined, want)
+		}
+	}
+}
+
+func TestPeerOnlineChanges(t *testing.T) {
+	peer := func(id tailcfg.StableNodeID, online *bool) tailcfg.NodeView {
+		return (&tailcfg.Node{StableID: id, Online: online}).View()
+	}
+	old := &netmap.NetworkMap{Peers: []tailcfg.NodeView{
+		peer("a", ptr.To(true)),
+		peer("b", ptr.To(false)),
+		peer("c", ptr.To(true)),
+		peer("gone", ptr.To(true)),
+	}}
+	nm := &netmap.NetworkMap{Peers: []tailcfg.NodeView{
+		peer("a", ptr.To(true)),
+		peer("b", ptr.To(true)),
+		peer("c", nil),
+		peer("new-online", ptr.To(true)),
+		peer("new-offline", ptr.To(false)),
+	}}
+	got := peerOnlineChanges(old, nm)
+	want := []ipn.PeerOnlineChange{
+		{NodeID: "b", Online: true},
+		{NodeID: "c", Online: false},
+		{NodeID: "new-online", Online: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	if got := peerOnlineChanges(old, nil); got != nil {
+		t.Errorf("nil netmap: got %v; want nil", got)
+	}
+}
+
+func TestWatchEventsFilter(t *testing.T) {
+	b := new(LocalBackend)
+	b.activeWatchSessions = make(set.Set[string])
+	sent := []ipn.Notify{
+		{State: ptr.To(ipn.Running)},
+		{Health: &ipn.HealthState{Warnings: []string{"uh oh"}}},
+		{NetMap: new(netmap.NetworkMap), PeerOnline: []ipn.PeerOnlineChange{{NodeID: "a", Online: true}}},
+	}
+	watch := func(events ipn.NotifyEvent) (got []*ipn.Notify) {
+		b.WatchEvents(context.Background(), 0, events, func() {
+			for _, n := range sent {
+				b.send(n)
+			}
+		}, func(roNotify *ipn.Notify) bool {
+			got = append(got, roNotify)
+			return len(got) < 2
+		})
+		return got
+	}
+
+	got := watch(ipn.EventPeerOnline | ipn.EventHealth)
+	if len(got) != 2 || got[0].Health == nil || got[1].NetMap != nil || len(got[1].PeerOnline) != 1 {
+		t.Errorf("got %v; want the Health and PeerOnline changes", got)
+	}
+
+	// Watchers that don't subscribe get everything but Health.
+	got = watch(0)
+	if len(got) != 2 || got[0].State == nil || got[1].NetMap == nil {
+		t.Errorf("got %v; want the State and NetMap changes", got)
+	}
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	b := newTestLocalBackend(t)
+	noon := time.Date(2023, 11, 17, 12, 0, 0, 0, time.UTC)
+	b.clock = tstest.NewClock(tstest.ClockOpts{Start: noon})
+
+	if !b.allowDisruptive("auto-update") {
+		t.Fatal("disruptive action not allowed without a maintenance window")
+	}
+
+	p := ipn.NewPrefs()
+	p.MaintenanceWindow = "CRON_TZ=UTC 0 22 * * * 2h"
+	if err := b.pm.SetPrefs(p.View(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if b.allowDisruptive("auto-update") {
+		t.Fatal("disruptive action allowed outside the maintenance window")
+	}
+	const url = "https://login.example.com/a/1"
+	b.popBrowserURLFromControl(url)
+
+	b.mu.Lock()
+	if got, want := b.maint.describeDeferred(), "auto-update and re-authentication prompt"; got != want {
+		t.Errorf("deferred = %q; want %q", got, want)
+	}
+	if want := time.Date(2023, 11, 17, 22, 0, 0, 0, time.UTC); !b.maint.opensAt.Equal(want) {
+		t.Errorf("opensAt = %v; want %v", b.maint.opensAt, want)
+	}
+	if b.maint.popURL != url {
+		t.Errorf("popURL = %q; want %q", b.maint.popURL, url)
+	}
+	b.mu.Unlock()
+
+	b.clock = tstest.NewClock(tstest.ClockOpts{Start: noon.Add(10*time.Hour + time.Minute)})
+	b.onMaintenanceWindowOpen()
+	b.mu.Lock()
+	if len(b.maint.deferred) != 0 || b.maint.popURL != "" || b.maint.timer != nil {
+		t.Errorf("deferral not ended when window opened: %+v", b.maint)
+	}
+	b.mu.Unlock()
+	if !b.allowDisruptive("auto-update") {
+		t.Error("disruptive action not allowed in the maintenance window")
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	b := newTestLocalBackend(t)
+	path := filepath.Join(t.TempDir(), "tailscaled.conf")
+	write := func(s string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(s), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"version": "alpha0", "Locked": false, "Enabled": false, "Hostname": "foo", "AdvertiseRoutes": ["10.0.0.0/24"]}`)
+	conf, err := conffile.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.mu.Lock()
+	b.conf = conf
+	b.hostinfo = &tailcfg.Hostinfo{}
+	b.mu.Unlock()
+
+	checkPrefs := func(wantHostname string, wantDrift ...string) {
+		t.Helper()
+		if got := b.Prefs().Hostname(); got != wantHostname {
+			t.Errorf("Hostname = %q; want %q", got, wantHostname)
+		}
+		if got := b.configDrift(); !reflect.DeepEqual(got, wantDrift) {
+			t.Errorf("configDrift = %q; want %q", got, wantDrift)
+		}
+	}
+
+	if ok, err := b.ReloadConfig(); !ok || err != nil {
+		t.Fatalf("ReloadConfig = %v, %v", ok, err)
+	}
+	checkPrefs("foo")
+	if got, want := b.Prefs().AdvertiseRoutes().AsSlice(), []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("AdvertiseRoutes = %v; want %v", got, want)
+	}
+
+	// Unlocked, local changes are permitted, but reported as drift.
+	if _, err := b.EditPrefs(&ipn.MaskedPrefs{Prefs: ipn.Prefs{Hostname: "bar"}, HostnameSet: true}); err != nil {
+		t.Fatal(err)
+	}
+	checkPrefs("bar", "Hostname")
+	b.checkConfigFile()
+	checkPrefs("bar", "Hostname")
+
+	// Changing the file reloads and applies it.
+	write(`{"version": "alpha0", "Enabled": false, "Hostname": "baz"}`)
+	b.checkConfigFile()
+	checkPrefs("baz")
+
+	// Locked, the node converges back toward the file.
+	p := b.Prefs().AsStruct()
+	p.Hostname = "qux"
+	b.SetPrefs(p)
+	checkPrefs("qux", "Hostname")
+	b.checkConfigFile()
+	checkPrefs("baz")
+
+	// A bad file leaves the last good config in place.
+	write(`{"version": "alpha0", "Hostname": 1}`)
+	if ok, err := b.ReloadConfig(); ok || err == nil {
+		t.Errorf("ReloadConfig of bad file = %v, %v; want error", ok, err)
+	}
+	checkPrefs("baz")
+}
+
+func TestPickAutoExitNode(t *testing.T) {
+	exitNode := func(id, derp string, online bool, priority int, tags ...string) tailcfg.NodeView {
+		n := &tailcfg.Node{
+			StableID:     tailcfg.StableNodeID(id),
+			ComputedName: id,
+			DERP:         derp,
+			AllowedIPs:   tsaddr.ExitRoutes(),
+			Online:       ptr.To(online),
+			Tags:         tags,
+			Hostinfo:     (&tailcfg.Hostinfo{Location: &tailcfg.Location{Priority: priority}}).View(),
+		}
+		return n.View()
+	}
+	peers := []tailcfg.NodeView{
+		exitNode("fra", "127.3.3.40:4", true, 0, "tag:eu"),
+		exitNode("nyc", "127.3.3.40:1", true, 0),
+		exitNode("nyc2", "127.3.3.40:1", true, 10),
+		exitNode("sfo", "127.3.3.40:2", true, 0),
+		exitNode("sea", "127.3.3.40:3", false, 0),
+		(&tailcfg.Node{StableID: "laptop", ComputedName: "laptop", DERP: "127.3.3.40:1"}).View(),
+	}
+	latency := map[int]time.Duration{
+		1: 20 * time.Millisecond,
+		2: 25 * time.Millisecond,
+		3: 5 * time.Millisecond,
+		4: 80 * time.Millisecond,
+	}
+	selfWithPolicy := func(vals ...string) tailcfg.NodeView {
+		n := &tailcfg.Node{CapMap: tailcfg.NodeCapMap{}}
+		for _, v := range vals {
+			n.CapMap[tailcfg.NodeAttrAutoExitNodeCandidates] = append(n.CapMap[tailcfg.NodeAttrAutoExitNodeCandidates], tailcfg.RawMessage(`"`+v+`"`))
+		}
+		return n.View()
+	}
+
+	tests := []struct {
+		name      string
+		self      tailcfg.NodeView
+		peers     []tailcfg.NodeView
+		latency   map[int]time.Duration
+		current   tailcfg.StableNodeID
+		want      tailcfg.StableNodeID
+		wantFirst tailcfg.StableNodeID // first candidate, if non-empty
+	}{
+		{
+			name:      "lowest_latency_then_priority",
+			peers:     peers,
+			latency:   latency,
+			want:      "nyc2",
+			wantFirst: "nyc2",
+		},
+		{
+			name:    "keep_similar_current",
+			peers:   peers,
+			latency: latency,
+			current: "sfo",
+			want:    "sfo",
+		},
+		{
+			name:    "switch_from_much_worse_current",
+			peers:   peers,
+			latency: latency,
+			current: "fra",
+			want:    "nyc2",
+		},
+		{
+			name:    "switch_from_offline_current",
+			peers:   peers,
+			latency: latency,
+			current: "sea",
+			want:    "nyc2",
+		},
+		{
+			name:    "policy_by_id_and_tag",
+			self:    selfWithPolicy("sfo", "tag:eu"),
+			peers:   peers,
+			latency: latency,
+			want:    "sfo",
+		},
+		{
+			name:    "policy_excludes_all_keeps_current",
+			self:    selfWithPolicy("tag:none"),
+			peers:   peers,
+			latency: latency,
+			current: "nyc",
+			want:    "nyc",
+		},
+		{
+			name:    "unknown_latency_last",
+			peers:   peers,
+			latency: map[int]time.Duration{4: 80 * time.Millisecond},
+			want:    "fra",
+		},
+		{
+			name:  "no_exit_nodes",
+			peers: peers[5:],
+			want:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := pickAutoExitNode(t.Logf, tt.self, tt.peers, tt.latency, tt.current)
+			if d.Selected != tt.want {
+				t.Errorf("Selected = %q, want %q; reason: %s", d.Selected, tt.want, d.Reason)
+			}
+			if d.Reason == "" {
+				t.Error("empty Reason")
+			}
+			if tt.wantFirst != "" && (len(d.Candidates) == 0 || d.Candidates[0].ID != tt.wantFirst) {
+				t.Errorf("Candidates = %+v, want %q first", d.Candidates, tt.wantFirst)
+			}
+			for _, c := range d.Candidates {
+				if c.ID == "laptop" {
+					t.Errorf("non-exit node %q is a candidate", c.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestLocalAPITokens(t *testing.T) {
+	b := newTestLocalBackend(t)
+
+	if _, _, err := b.CreateLocalAPIToken("bad", "root"); err == nil {
+		t.Error("CreateLocalAPIToken with invalid scope succeeded")
+	}
+	tok, info, err := b.CreateLocalAPIToken("monitoring", apitype.LocalAPIScopeStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "monitoring" || info.Scope != apitype.LocalAPIScopeStatus || info.ID == "" {
+		t.Errorf("token info = %+v", info)
+	}
+	if scope, ok := b.LocalAPITokenScope(tok); !ok || scope != apitype.LocalAPIScopeStatus {
+		t.Errorf("LocalAPITokenScope = %q, %v; want %q, true", scope, ok, apitype.LocalAPIScopeStatus)
+	}
+	for _, bad := range []string{"", "tslapi-", tok + "0", localAPITokenPrefix + info.ID + "-0000", strings.TrimPrefix(tok, localAPITokenPrefix+info.ID)} {
+		if _, ok := b.LocalAPITokenScope(bad); ok {
+			t.Errorf("LocalAPITokenScope(%q) ok", bad)
+		}
+	}
+	bs, err := b.store.ReadState(ipn.LocalAPITokensStateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret := tok[strings.LastIndex(tok, "-")+1:]; strings.Contains(string(bs), secret) {
+		t.Errorf("token secret stored in plain text: %s", bs)
+	}
+
+	tok2, info2, err := b.CreateLocalAPIToken("", apitype.LocalAPIScopeAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toks, err := b.LocalAPITokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []apitype.LocalAPIToken{info, info2}; !reflect.DeepEqual(toks, want) {
+		t.Errorf("LocalAPITokens = %+v; want %+v", toks, want)
+	}
+
+	if err := b.RevokeLocalAPIToken(info.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.RevokeLocalAPIToken(info.ID); !errors.Is(err, ErrNoLocalAPIToken) {
+		t.Errorf("second RevokeLocalAPIToken = %v; want ErrNoLocalAPIToken", err)
+	}
+	if _, ok := b.LocalAPITokenScope(tok); ok {
+		t.Error("revoked token still valid")
+	}
+	if scope, ok := b.LocalAPITokenScope(tok2); !ok || scope != apitype.LocalAPIScopeAdmin {
+		t.Errorf("LocalAPITokenScope = %q, %v; want %q, true", scope, ok, apitype.LocalAPIScopeAdmin)
+	}
+}
+
+// readCountingStore is an ipn.StateStore that counts its reads.
+type readCountingStore struct {
+	ipn.StateStore
+	reads int
+}
+
+func (s *readCountingStore) ReadState(id ipn.StateKey) ([]byte, error) {
+	s.reads++
+	return s.StateStore.ReadState(id)
+}
+
+func TestLocalAPITokensCached(t *testing.T) {
+	b := newTestLocalBackend(t)
+	store := &readCountingStore{StateStore: b.store}
+	b.store = store
+
+	tok, info, err := b.CreateLocalAPIToken("monitoring", apitype.LocalAPIScopeStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.reads = 0
+	for i := 0; i < 3; i++ {
+		if _, ok := b.LocalAPITokenScope(tok); !ok {
+			t.Fatal("token not valid")
+		}
+	}
+	if store.reads != 0 {
+		t.Errorf("checking tokens read the store %d times; want none", store.reads)
+	}
+	if err := b.RevokeLocalAPIToken(info.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.LocalAPITokenScope(tok); ok {
+		t.Error("revoked token still valid")
+	}
+}
+
+func TestConnApprovals(t *testing.T) {
+	clock := tstest.NewClock(tstest.ClockOpts{})
+	store := new(mem.Store)
+	notifies := make(chan ipn.Notify, 10)
+	ca := newConnApprovals(t.Logf, clock, store, func(n ipn.Notify) { notifies <- n })
+
+	peer := (&tailcfg.Node{
+		ID:        1,
+		StableID:  "peer1",
+		Name:      "peer1.example.ts.net.",
+		Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+	}).View()
+	ca.setPeers(map[tailcfg.NodeID]tailcfg.NodeView{peer.ID(): peer})
+
+	src := netip.MustParseAddrPort("100.64.0.1:40000")
+	ssh := netip.MustParseAddrPort("100.64.0.2:22")
+	web := netip.MustParseAddrPort("100.64.0.2:80")
+	other := netip.MustParseAddrPort("100.64.0.9:40000")
+
+	if ca.approve(ipproto.TCP, other, ssh) {
+		t.Fatal("connection from unknown address approved")
+	}
+	if ca.approve(ipproto.TCP, src, ssh) {
+		t.Fatal("undecided connection approved")
+	}
+	n := <-notifies
+	if n.ConnApproval == nil || n.ConnApproval.Peer != "peer1" || n.ConnApproval.Dst != ssh {
+		t.Fatalf("got notify %v; want ConnApproval for peer1 to %v", n, ssh)
+	}
+	id := n.ConnApproval.ID
+
+	// Retries while pending don't ask again.
+	ca.approve(ipproto.TCP, src, ssh)
+	if got := ca.list(); len(got.Pending) != 1 {
+		t.Fatalf("got %d pending; want 1", len(got.Pending))
+	}
+
+	if err := ca.decide(ipn.ConnApprovalDecision{ID: "bogus", Allow: true}); !errors.Is(err, ErrNoConnApproval) {
+		t.Fatalf("decide(bogus) = %v; want ErrNoConnApproval", err)
+	}
+	if err := ca.decide(ipn.ConnApprovalDecision{ID: id, Allow: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !ca.approve(ipproto.TCP, src, ssh) {
+		t.Error("accepted connection not approved")
+	}
+	if allow, ok := ca.verdicts.Load(connApprovalFlow{src.Addr(), ipproto.TCP, ssh.Port()}); !ok || !allow {
+		t.Errorf("cached verdict = %v, %v; want true, true", allow, ok)
+	}
+	if ca.approve(ipproto.TCP, src, web) {
+		t.Error("connection to other port approved")
+	}
+	n = <-notifies
+
+	// The decision persists.
+	ca2 := newConnApprovals(t.Logf, clock, store, func(ipn.Notify) {})
+	ca2.setPeers(map[tailcfg.NodeID]tailcfg.NodeView{peer.ID(): peer})
+	if !ca2.approve(ipproto.TCP, src, ssh) {
+		t.Error("accepted connection not approved after reload")
+	}
+
+	// Rejecting all ports replaces the earlier decisions about the peer.
+	if err := ca.decide(ipn.ConnApprovalDecision{ID: n.ConnApproval.ID, AllPorts: true}); err != nil {
+		t.Fatal(err)
+	}
+	if ca.approve(ipproto.TCP, src, web) || ca.approve(ipproto.UDP, src, ssh) {
+		t.Error("rejected connection approved")
+	}
+	if got := ca.list().Rules; len(got) != 1 {
+		t.Errorf("got rules %+v; want just the rejection", got)
+	}
+	select {
+	case n := <-notifies:
+		t.Errorf("unexpected notify %v after rejection", n)
+	default:
+	}
+
+	if err := ca.forget("peer1"); err != nil {
+		t.Fatal(err)
+	}
+	if ca.approve(ipproto.TCP, src, ssh) {
+		t.Error("connection approved after forget")
+	}
+	<-notifies
+	clock.Advance(connApprovalTimeout)
+	if got := ca.list(); len(got.Pending) != 0 || len(got.Rules) != 0 {
+		t.Errorf("after expiry got %+v; want nothing", got)
+	}
+}
+
+func TestFileOffers(t *testing.T) {
+	fo := newFileOffers(t.Logf, tstest.NewClock(tstest.ClockOpts{}))
+	ctx := context.Background()
+
+	// Without processors, files are accepted without asking.
+	if d, err := fo.offer(ctx, ipn.FileOffer{Name: "foo"}); err != nil || !d.Accept {
+		t.Fatalf("offer without processors = %+v, %v; want accepted", d, err)
+	}
+
+	offers, unsubscribe := fo.subscribe()
+	type result struct {
+		d   ipn.FileOfferDecision
+		err error
+	}
+	offer := func(name string) (ipn.FileOffer, <-chan result) {
+		t.Helper()
+		c := make(chan result, 1)
+		go func() {
+			d, err := fo.offer(ctx, ipn.FileOffer{Name: name, Peer: "peer1"})
+			c <- result{d, err}
+		}()
+		o := <-offers
+		if o.Name != name || o.ID == "" || !fo.isPending(o.ID) {
+			t.Fatalf("got offer %+v; want pending %q", o, name)
+		}
+		return o, c
+	}
+
+	o, c := offer("rejected")
+	if err := fo.decide(ipn.FileOfferDecision{ID: "bogus", Accept: true}); !errors.Is(err, ErrNoFileOffer) {
+		t.Fatalf("decide(bogus) = %v; want ErrNoFileOffer", err)
+	}
+	if err := fo.decide(ipn.FileOfferDecision{ID: o.ID, Path: "/nowhere"}); err == nil {
+		t.Fatal("rejection with a path succeeded")
+	}
+	if err := fo.decide(ipn.FileOfferDecision{ID: o.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-c; !errors.Is(r.err, errFileOfferRejected) {
+		t.Errorf("rejected offer = %+v; want errFileOfferRejected", r)
+	}
+	if err := fo.decide(ipn.FileOfferDecision{ID: o.ID, Accept: true}); !errors.Is(err, ErrNoFileOffer) {
+		t.Errorf("second decision = %v; want ErrNoFileOffer", err)
+	}
+
+	dir := t.TempDir()
+	o, c = offer("redirected")
+	if err := fo.decide(ipn.FileOfferDecision{ID: o.ID, Accept: true, Path: "relative"}); err == nil {
+		t.Fatal("decision with relative path succeeded")
+	}
+	if err := fo.decide(ipn.FileOfferDecision{ID: o.ID, Accept: true, Path: dir}); err == nil {
+		t.Fatal("decision with existing path succeeded")
+	}
+	path := filepath.Join(dir, "file")
+	if err := fo.decide(ipn.FileOfferDecision{ID: o.ID, Accept: true, Path: path}); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-c; r.err != nil || r.d.Path != path {
+		t.Errorf("redirected offer = %+v; want path %q", r, path)
+	}
+
+	// Pending files are accepted when the last processor goes away.
+	_, c = offer("abandoned")
+	unsubscribe()
+	if r := <-c; r.err != nil || !r.d.Accept {
+		t.Errorf("abandoned offer = %+v; want accepted", r)
+	}
+}
+
+func TestDoctorRedactor(t *testing.T) {
+	nm := &netmap.NetworkMap{
+		Name:   "self.example.ts.net.",
+		Domain: "example.com",
+		Peers: []tailcfg.NodeView{
+			(&tailcfg.Node{
+				ID:       1,
+				Name:     "peer-laptop.example.ts.net.",
+				Hostinfo: (&tailcfg.Hostinfo{Hostname: "Alices-MacBook"}).View(),
+			}).View(),
+		},
+		UserProfiles: map[tailcfg.UserID]tailcfg.UserProfile{
+			1: {LoginName: "alice@example.com", DisplayName: "Alice Liddell"},
+		},
+	}
+	r := doctorRedactor(nm, ipn.LoginProfile{Name: "bob@example.com"})
+	tests := []struct {
+		in, want string
+	}{
+		{"active login: bob@example.com", "active login: redacted@redacted"},
+		{"peer peer-laptop.example.ts.net. (Alices-MacBook) owned by Alice Liddell", "peer [redacted]. ([redacted]) owned by [redacted]"},
+		{"ping peer-laptop: 100.64.0.2:41641", "ping [redacted]: x.x.x.x:41641"},
+		{"self.example.ts.net is up", "[redacted] is up"},
+		{"nothing to see", "nothing to see"},
+	}
+	for _, tt := range tests {
+		if got := r.Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPeerPath(t *testing.T) {
+	stats := []ipnstate.PathStats{
+		{Path: "direct4", Pings: 3, RTTSeconds: 0.010},
+		{Path: "direct6", Pings: 3, RTTSeconds: 0.008},
+		{Path: "derp", Pings: 3, RTTSeconds: 0.050},
+	}
+	tests := []struct {
+		name        string
+		ps          ipnstate.PeerStatus
+		wantPath    string
+		wantLatency float64
+	}{
+		{
+			name: "idle",
+			ps:   ipnstate.PeerStatus{CurAddr: "1.2.3.4:41641", PathStats: stats},
+		},
+		{
+			name:        "direct4",
+			ps:          ipnstate.PeerStatus{Active: true, CurAddr: "1.2.3.4:41641", PathStats: stats},
+			wantPath:    "direct",
+			wantLatency: 0.010,
+		},
+		{
+			name:        "direct6",
+			ps:          ipnstate.PeerStatus{Active: true, CurAddr: "[2001:db8::1]:41641", PathStats: stats},
+			wantPath:    "direct",
+			wantLatency: 0.008,
+		},
+		{
+			name:        "derp",
+			ps:          ipnstate.PeerStatus{Active: true, Relay: "nyc", PathStats: stats},
+			wantPath:    "derp",
+			wantLatency: 0.050,
+		},
+		{
+			name:     "derp_unprobed",
+			ps:       ipnstate.PeerStatus{Active: true, Relay: "nyc"},
+			wantPath: "derp",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, latency := peerPath(&tt.ps)
+			if path != tt.wantPath || latency != tt.wantLatency {
+				t.Errorf("peerPath = %q, %v; want %q, %v", path, latency, tt.wantPath, tt.wantLatency)
+			}
+		})
+	}
+}
+
+func TestWatchFlowLog(t *testing.T) {
+	b := newTestLocalBackend(t)
+	fl1, done1, err := b.WatchFlowLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fl2, done2, err := b.WatchFlowLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fl1 != fl2 {
+		t.Fatal("concurrent watchers got different flow logs")
+	}
+	done1()
+	done1() // no-op
+	if b.flowLog == nil {
+		t.Fatal("flow log stopped while still watched")
+	}
+	done2()
+	if b.flowLog != nil {
+		t.Fatal("flow log still running after the last watcher is done")
+	}
+}
+
+func TestImportProfileChecksPrefs(t *testing.T) {
+	b := newTestLocalBackend(t)
+	export := func(name string, edit func(*ipn.Prefs)) *ipn.ProfileExport {
+		p := ipn.NewPrefs()
+		edit(p)
+		return &ipn.ProfileExport{Version: ipn.ProfileExportVersion, Name: name, Prefs: p}
+	}
+
+	if _, err := b.ImportProfile(export("ok", func(*ipn.Prefs) {}), false); err != nil {
+		t.Fatalf("ImportProfile: %v", err)
+	}
+
+	envknob.Setenv("TS_DISABLE_SSH_SERVER", "1")
+	defer envknob.Setenv("TS_DISABLE_SSH_SERVER", "")
+	for name, ex := range map[string]*ipn.ProfileExport{
+		"bad-hostname": export("bad-hostname", func(p *ipn.Prefs) { p.Hostname = "badhostname.tailscale." }),
+		"ssh":          export("ssh", func(p *ipn.Prefs) { p.RunSSH = true }),
+		"name-in-use":  export("ok", func(*ipn.Prefs) {}),
+		"pref-name":    export("other", func(p *ipn.Prefs) { p.ProfileName = "ok" }),
+	} {
+		if _, err := b.ImportProfile(ex, true); err == nil {
+			t.Errorf("%s: ImportProfile succeeded; want error", name)
+		}
+	}
+	if got := b.ListProfiles(); len(got) != 1 {
+		t.Errorf("ListProfiles() = %+v; want just the first import", got)
+	}
+}
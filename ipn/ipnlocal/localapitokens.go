@@ -0,0 +1,176 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/util/rands"
+)
+
+// localAPITokenPrefix starts every LocalAPI access token, to make them
+// recognizable. The rest is "<ID>-<secret>".
+const localAPITokenPrefix = "tslapi-"
+
+// ErrNoLocalAPIToken is returned by RevokeLocalAPIToken when there's no
+// token with the given ID.
+var ErrNoLocalAPIToken = errors.New("no such LocalAPI token")
+
+// storedLocalAPIToken is a LocalAPI access token as stored under
+// ipn.LocalAPITokensStateKey.
+type storedLocalAPIToken struct {
+	apitype.LocalAPIToken
+
+	// SecretSHA256 is the hex SHA-256 of the token's secret.
+	SecretSHA256 string
+}
+
+// validLocalAPIScope reports whether s is a known LocalAPI token scope.
+func validLocalAPIScope(s apitype.LocalAPIScope) bool {
+	switch s {
+	case apitype.LocalAPIScopeStatus, apitype.LocalAPIScopeServe, apitype.LocalAPIScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// CreateLocalAPIToken creates and stores a LocalAPI access token with
+// the given scope. It returns the token, which can't be retrieved again.
+func (b *LocalBackend) CreateLocalAPIToken(name string, scope apitype.LocalAPIScope) (token string, info apitype.LocalAPIToken, err error) {
+	if !validLocalAPIScope(scope) {
+		return "", info, fmt.Errorf("invalid LocalAPI token scope %q", scope)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	toks, err := b.localAPITokensLocked()
+	if err != nil {
+		return "", info, err
+	}
+	secret := rands.HexString(32)
+	sum := sha256.Sum256([]byte(secret))
+	st := storedLocalAPIToken{
+		LocalAPIToken: apitype.LocalAPIToken{
+			ID:      rands.HexString(12),
+			Name:    name,
+			Scope:   scope,
+			Created: b.clock.Now().UTC(),
+		},
+		SecretSHA256: hex.EncodeToString(sum[:]),
+	}
+	if err := b.storeLocalAPITokensLocked(append(slices.Clip(toks), st)); err != nil {
+		return "", info, err
+	}
+	b.logf("created LocalAPI token %s with scope %q", st.ID, scope)
+	return localAPITokenPrefix + st.ID + "-" + secret, st.LocalAPIToken, nil
+}
+
+// LocalAPITokens returns the LocalAPI access tokens, oldest first.
+func (b *LocalBackend) LocalAPITokens() ([]apitype.LocalAPIToken, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	toks, err := b.localAPITokensLocked()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]apitype.LocalAPIToken, 0, len(toks))
+	for _, t := range toks {
+		ret = append(ret, t.LocalAPIToken)
+	}
+	return ret, nil
+}
+
+// RevokeLocalAPIToken deletes the LocalAPI access token with the given
+// ID. It returns ErrNoLocalAPIToken if there's none.
+func (b *LocalBackend) RevokeLocalAPIToken(id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	toks, err := b.localAPITokensLocked()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(toks, func(t storedLocalAPIToken) bool { return t.ID == id })
+	if i < 0 {
+		return ErrNoLocalAPIToken
+	}
+	if err := b.storeLocalAPITokensLocked(slices.Delete(slices.Clone(toks), i, i+1)); err != nil {
+		return err
+	}
+	b.logf("revoked LocalAPI token %s", id)
+	return nil
+}
+
+// LocalAPITokenScope returns the scope of the LocalAPI access token tok,
+// or false if it isn't a valid token.
+func (b *LocalBackend) LocalAPITokenScope(tok string) (_ apitype.LocalAPIScope, ok bool) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(tok, localAPITokenPrefix), "-")
+	if !ok {
+		return "", false
+	}
+	b.mu.Lock()
+	toks, err := b.localAPITokensLocked()
+	b.mu.Unlock()
+	if err != nil {
+		b.logf("reading LocalAPI tokens: %v", err)
+		return "", false
+	}
+	for _, t := range toks {
+		if t.ID != id {
+			continue
+		}
+		sum := sha256.Sum256([]byte(secret))
+		if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(t.SecretSHA256)) != 1 {
+			return "", false
+		}
+		return t.Scope, true
+	}
+	return "", false
+}
+
+// localAPITokensLocked returns the stored LocalAPI access tokens, reading
+// them from the store the first time. The caller must not modify them.
+//
+// b.mu must be held.
+func (b *LocalBackend) localAPITokensLocked() ([]storedLocalAPIToken, error) {
+	if b.localAPITokensLoaded {
+		return b.localAPITokens, nil
+	}
+	var toks []storedLocalAPIToken
+	bs, err := b.store.ReadState(ipn.LocalAPITokensStateKey)
+	switch {
+	case errors.Is(err, ipn.ErrStateNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(bs, &toks); err != nil {
+			return nil, fmt.Errorf("invalid LocalAPI tokens in %s: %w", ipn.LocalAPITokensStateKey, err)
+		}
+	}
+	b.localAPITokens, b.localAPITokensLoaded = toks, true
+	return toks, nil
+}
+
+// storeLocalAPITokensLocked replaces the stored LocalAPI access tokens
+// with toks.
+//
+// b.mu must be held.
+func (b *LocalBackend) storeLocalAPITokensLocked(toks []storedLocalAPIToken) error {
+	bs, err := json.Marshal(toks)
+	if err != nil {
+		return err
+	}
+	if err := ipn.WriteState(b.store, ipn.LocalAPITokensStateKey, bs); err != nil {
+		return err
+	}
+	b.localAPITokens, b.localAPITokensLoaded = toks, true
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/tstime"
+	"tailscale.com/util/maintwindow"
+	"tailscale.com/util/set"
+)
+
+var warnMaintenanceDeferred = health.NewWarnable(health.WithCheck("maintenance-deferred", health.SeverityWarning, health.Remediation{
+	Code: "wait-for-maintenance-window",
+	Text: "Wait for the maintenance window, or change it with 'tailscale set --maintenance-window'.",
+}))
+
+// maintenanceState is the state of the actions LocalBackend has
+// deferred until the next maintenance window. See
+// ipn.Prefs.MaintenanceWindow.
+type maintenanceState struct {
+	deferred set.Set[string]        // descriptions of the deferred actions
+	popURL   string                 // deferred auth URL from control to pop, if any
+	opensAt  time.Time              // when timer fires; zero if no timer
+	timer    tstime.TimerController // fires when the next window opens; nil if none
+}
+
+// maintenanceWindowLocked returns the maintenance window of the current
+// prefs, or nil if there's none.
+//
+// b.mu must be held.
+func (b *LocalBackend) maintenanceWindowLocked() *maintwindow.Window {
+	prefs := b.pm.CurrentPrefs()
+	if !prefs.Valid() || prefs.MaintenanceWindow() == "" {
+		return nil
+	}
+	w, err := maintwindow.Parse(prefs.MaintenanceWindow())
+	if err != nil {
+		// checkPrefsLocked rejects such prefs, so this is from an
+		// older or hand-edited state file. Don't block anything.
+		b.logf("maintenance: ignoring %v", err)
+		return nil
+	}
+	return w
+}
+
+// inMaintenanceWindowLocked reports whether disruptive actions are
+// allowed now: when there's no maintenance window or one is open.
+//
+// b.mu must be held.
+func (b *LocalBackend) inMaintenanceWindowLocked() bool {
+	w := b.maintenanceWindowLocked()
+	return w == nil || w.Contains(b.clock.Now())
+}
+
+// deferUntilMaintenanceWindowLocked records that action was deferred
+// until the next maintenance window.
+//
+// b.mu must be held.
+func (b *LocalBackend) deferUntilMaintenanceWindowLocked(action string) {
+	if b.maint.deferred == nil {
+		b.maint.deferred = set.Set[string]{}
+	}
+	if !b.maint.deferred.Contains(action) {
+		b.logf("maintenance: deferring %s", action)
+		b.maint.deferred.Add(action)
+	}
+	b.updateMaintenanceLocked()
+}
+
+// updateMaintenanceLocked surfaces the deferred actions as a health
+// warning and arranges for their deferral to end when the next
+// maintenance window opens.
+//
+// b.mu must be held.
+func (b *LocalBackend) updateMaintenanceLocked() {
+	w := b.maintenanceWindowLocked()
+	if w == nil || len(b.maint.deferred) == 0 {
+		return
+	}
+	now := b.clock.Now()
+	next, err := w.Next(now)
+	if err != nil {
+		warnMaintenanceDeferred.Set(fmt.Errorf("%s deferred, but maintenance window %q never opens", b.maint.describeDeferred(), w))
+		return
+	}
+	warnMaintenanceDeferred.Set(fmt.Errorf("%s deferred until the maintenance window opens at %v", b.maint.describeDeferred(), next.Format(time.RFC3339)))
+	if b.maint.timer != nil {
+		if b.maint.opensAt.Equal(next) {
+			return
+		}
+		b.maint.timer.Stop()
+	}
+	b.maint.opensAt = next
+	b.maint.timer = b.clock.AfterFunc(next.Sub(now), b.onMaintenanceWindowOpen)
+}
+
+func (m *maintenanceState) describeDeferred() string {
+	actions := m.deferred.Slice()
+	slices.Sort(actions)
+	return strings.Join(actions, " and ")
+}
+
+// onMaintenanceWindowOpen ends the deferral of actions once a
+// maintenance window opens. It's also called when the window prefs
+// change, in which case it might instead reschedule the deferral.
+func (b *LocalBackend) onMaintenanceWindowOpen() {
+	b.mu.Lock()
+	if len(b.maint.deferred) == 0 {
+		b.mu.Unlock()
+		return
+	}
+	if !b.inMaintenanceWindowLocked() {
+		b.updateMaintenanceLocked()
+		b.mu.Unlock()
+		return
+	}
+	b.logf("maintenance: window open; ending deferral of %s", b.maint.describeDeferred())
+	popURL := b.maint.popURL
+	if b.maint.timer != nil {
+		b.maint.timer.Stop()
+	}
+	b.maint = maintenanceState{}
+	b.mu.Unlock()
+
+	warnMaintenanceDeferred.Set(nil)
+	if popURL != "" {
+		b.tellClientToBrowseToURL(popURL)
+	}
+}
+
+// popBrowserURLFromControl is called when the control server asks to
+// pop a browser to url, such as to re-authenticate. Outside of
+// maintenance windows, that's deferred until the next one opens.
+func (b *LocalBackend) popBrowserURLFromControl(url string) {
+	b.mu.Lock()
+	if !b.inMaintenanceWindowLocked() {
+		b.maint.popURL = url
+		b.deferUntilMaintenanceWindowLocked("re-authentication prompt")
+		b.mu.Unlock()
+		return
+	}
+	b.mu.Unlock()
+	b.tellClientToBrowseToURL(url)
+}
+
+// allowDisruptive reports whether the disruptive action may happen
+// now. If not, the refusal is recorded as a deferral until the next
+// maintenance window.
+func (b *LocalBackend) allowDisruptive(action string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.inMaintenanceWindowLocked() {
+		return true
+	}
+	b.deferUntilMaintenanceWindowLocked(action)
+	return false
+}
@@ -10,6 +10,7 @@
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -592,51 +593,210 @@ func (b *LocalBackend) NetworkLockForceLocalDisable() error {
 // NetworkLockSign signs the given node-key and submits it to the control plane.
 // rotationPublic, if specified, must be an ed25519 public key.
 func (b *LocalBackend) NetworkLockSign(nodeKey key.NodePublic, rotationPublic []byte) error {
-	ourNodeKey, sig, err := func(nodeKey key.NodePublic, rotationPublic []byte) (key.NodePublic, tka.NodeKeySignature, error) {
-		b.mu.Lock()
-		defer b.mu.Unlock()
+	b.mu.Lock()
+	ourNodeKey, sig, err := b.networkLockSignLocked(nodeKey, rotationPublic)
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
 
-		var nlPriv key.NLPrivate
-		if p := b.pm.CurrentPrefs(); p.Valid() && p.Persist().Valid() {
-			nlPriv = p.Persist().NetworkLockKey()
-		}
-		if nlPriv.IsZero() {
-			return key.NodePublic{}, tka.NodeKeySignature{}, errMissingNetmap
-		}
+	b.logf("Generated network-lock signature for %v, submitting to control plane", nodeKey)
+	_, err = b.tkaSubmitSignature(ourNodeKey, sig.Serialize())
+	b.recordSignAudit("sign", nodeKey, sig, err)
+	return err
+}
 
-		if b.tka == nil {
-			return key.NodePublic{}, tka.NodeKeySignature{}, errNetworkLockNotActive
+// networkLockSignLocked signs nodeKey, and rotationPublic if non-nil, with
+// this node's tailnet lock key, returning this node's node key and the
+// signature.
+//
+// b.mu must be held.
+func (b *LocalBackend) networkLockSignLocked(nodeKey key.NodePublic, rotationPublic []byte) (key.NodePublic, tka.NodeKeySignature, error) {
+	var nlPriv key.NLPrivate
+	if p := b.pm.CurrentPrefs(); p.Valid() && p.Persist().Valid() {
+		nlPriv = p.Persist().NetworkLockKey()
+	}
+	if nlPriv.IsZero() {
+		return key.NodePublic{}, tka.NodeKeySignature{}, errMissingNetmap
+	}
+
+	if b.tka == nil {
+		return key.NodePublic{}, tka.NodeKeySignature{}, errNetworkLockNotActive
+	}
+	if !b.tka.authority.KeyTrusted(nlPriv.KeyID()) {
+		return key.NodePublic{}, tka.NodeKeySignature{}, errors.New("this node is not trusted by network lock")
+	}
+
+	sig, err := tka.SignNodeKey(nlPriv, nodeKey, rotationPublic)
+	if err != nil {
+		return key.NodePublic{}, tka.NodeKeySignature{}, err
+	}
+	return b.pm.CurrentPrefs().Persist().PublicNodeKey(), sig, nil
+}
+
+// NetworkLockSignBatch signs each of keys with this node's tailnet lock
+// key, as NetworkLockSign does. If offline, the signatures are returned
+// without being submitted to the coordination server, for submitting
+// with NetworkLockSubmitSignatures from another node.
+//
+// Keys that fail don't stop the others from being signed; their
+// results have an Error.
+func (b *LocalBackend) NetworkLockSignBatch(keys []ipnstate.NetworkLockSigningRequestKey, offline bool) []ipnstate.NetworkLockSignature {
+	action := "sign"
+	if offline {
+		action = "sign-offline"
+	}
+	res := make([]ipnstate.NetworkLockSignature, len(keys))
+	for i, k := range keys {
+		r := &res[i]
+		r.NodeKey = k.NodeKey
+
+		b.mu.Lock()
+		ourNodeKey, sig, err := b.networkLockSignLocked(k.NodeKey, k.RotationPublic)
+		b.mu.Unlock()
+		if err != nil {
+			r.Error = err.Error()
+			b.recordSignAudit(action, k.NodeKey, sig, err)
+			continue
 		}
-		if !b.tka.authority.KeyTrusted(nlPriv.KeyID()) {
-			return key.NodePublic{}, tka.NodeKeySignature{}, errors.New("this node is not trusted by network lock")
+		r.Signature = sig.Serialize()
+		if !offline {
+			if _, err = b.tkaSubmitSignature(ourNodeKey, r.Signature); err != nil {
+				r.Error = err.Error()
+			} else {
+				r.Submitted = true
+			}
 		}
+		b.recordSignAudit(action, k.NodeKey, sig, err)
+	}
+	b.logf("network-lock: signed %d node keys (offline=%v)", len(keys), offline)
+	return res
+}
 
-		p, err := nodeKey.MarshalBinary()
-		if err != nil {
-			return key.NodePublic{}, tka.NodeKeySignature{}, err
+// NetworkLockSubmitSignatures submits sigs, signatures made by another
+// node such as with NetworkLockSignBatch offline, to the coordination
+// server. Each is first checked against the local tailnet key authority.
+//
+// It returns sigs with their Submitted or Error fields set.
+func (b *LocalBackend) NetworkLockSubmitSignatures(sigs []ipnstate.NetworkLockSignature) ([]ipnstate.NetworkLockSignature, error) {
+	b.mu.Lock()
+	var ourNodeKey key.NodePublic
+	if p := b.pm.CurrentPrefs(); p.Valid() && p.Persist().Valid() && !p.Persist().PrivateNodeKey().IsZero() {
+		ourNodeKey = p.Persist().PublicNodeKey()
+	}
+	var authority *tka.Authority
+	if b.tka != nil {
+		authority = b.tka.authority
+	}
+	b.mu.Unlock()
+	if ourNodeKey.IsZero() {
+		return nil, errors.New("no node-key: is tailscale logged in?")
+	}
+	if authority == nil {
+		return nil, errNetworkLockNotActive
+	}
+
+	res := make([]ipnstate.NetworkLockSignature, len(sigs))
+	for i, s := range sigs {
+		res[i] = s
+		r := &res[i]
+		r.Error, r.Submitted = "", false
+
+		var sig tka.NodeKeySignature
+		err := sig.Unserialize(s.Signature)
+		if err == nil {
+			err = authority.NodeKeyAuthorized(s.NodeKey, s.Signature)
 		}
-		sig := tka.NodeKeySignature{
-			SigKind:        tka.SigDirect,
-			KeyID:          nlPriv.KeyID(),
-			Pubkey:         p,
-			WrappingPubkey: rotationPublic,
+		if err == nil {
+			_, err = b.tkaSubmitSignature(ourNodeKey, s.Signature)
 		}
-		sig.Signature, err = nlPriv.SignNKS(sig.SigHash())
 		if err != nil {
-			return key.NodePublic{}, tka.NodeKeySignature{}, fmt.Errorf("signature failed: %w", err)
+			r.Error = err.Error()
+		} else {
+			r.Submitted = true
 		}
+		b.recordSignAudit("submit", s.NodeKey, sig, err)
+	}
+	return res, nil
+}
 
-		return b.pm.CurrentPrefs().Persist().PublicNodeKey(), sig, nil
-	}(nodeKey, rotationPublic)
+// signAuditPath returns the path of the audit trail of node key
+// signatures, a file of JSON lines of ipnstate.NetworkLockSignAuditEntry,
+// or the empty string if there's nowhere to keep it.
+func (b *LocalBackend) signAuditPath() string {
+	root := b.TailscaleVarRoot()
+	if root == "" {
+		return ""
+	}
+	return filepath.Join(root, "tka-sign-audit.jsonl")
+}
+
+// recordSignAudit appends an entry for a node key signature action to the
+// audit trail. sig may be the zero value if signing failed.
+func (b *LocalBackend) recordSignAudit(action string, nodeKey key.NodePublic, sig tka.NodeKeySignature, actionErr error) {
+	path := b.signAuditPath()
+	if path == "" {
+		return
+	}
+	e := ipnstate.NetworkLockSignAuditEntry{
+		Time:    b.clock.Now().UTC(),
+		Action:  action,
+		NodeKey: nodeKey,
+		KeyID:   sig.KeyID,
+	}
+	if sig.Signature != nil {
+		h := sig.SigHash()
+		e.SigHash = hex.EncodeToString(h[:])
+	}
+	if actionErr != nil {
+		e.Error = actionErr.Error()
+	}
+	j, err := json.Marshal(e)
 	if err != nil {
-		return err
+		b.logf("network-lock: encoding audit entry: %v", err)
+		return
+	}
+	b.signAuditMu.Lock()
+	defer b.signAuditMu.Unlock()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		b.logf("network-lock: opening audit trail: %v", err)
+		return
 	}
+	defer f.Close()
+	if _, err := f.Write(append(j, '\n')); err != nil {
+		b.logf("network-lock: writing audit trail: %v", err)
+	}
+}
 
-	b.logf("Generated network-lock signature for %v, submitting to control plane", nodeKey)
-	if _, err := b.tkaSubmitSignature(ourNodeKey, sig.Serialize()); err != nil {
-		return err
+// NetworkLockSignAudit returns the audit trail of the node key signatures
+// made or submitted by this node, oldest first.
+func (b *LocalBackend) NetworkLockSignAudit() ([]ipnstate.NetworkLockSignAuditEntry, error) {
+	path := b.signAuditPath()
+	if path == "" {
+		return nil, errors.New("no state directory; signatures aren't audited")
+	}
+	b.signAuditMu.Lock()
+	defer b.signAuditMu.Unlock()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []ipnstate.NetworkLockSignAuditEntry
+	dec := json.NewDecoder(f)
+	for {
+		var e ipnstate.NetworkLockSignAuditEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return entries, fmt.Errorf("reading audit trail: %w", err)
+		}
+		entries = append(entries, e)
 	}
-	return nil
 }
 
 // NetworkLockModify adds and/or removes keys in the tailnet's key authority.
@@ -980,21 +1140,10 @@ func (b *LocalBackend) NetworkLockVerifySigningDeeplink(url string) tka.Deeplink
 }
 
 func signNodeKey(nodeInfo tailcfg.TKASignInfo, signer key.NLPrivate) (*tka.NodeKeySignature, error) {
-	p, err := nodeInfo.NodePublic.MarshalBinary()
+	sig, err := tka.SignNodeKey(signer, nodeInfo.NodePublic, nodeInfo.RotationPubkey)
 	if err != nil {
 		return nil, err
 	}
-
-	sig := tka.NodeKeySignature{
-		SigKind:        tka.SigDirect,
-		KeyID:          signer.KeyID(),
-		Pubkey:         p,
-		WrappingPubkey: nodeInfo.RotationPubkey,
-	}
-	sig.Signature, err = signer.SignNKS(sig.SigHash())
-	if err != nil {
-		return nil, fmt.Errorf("signature failed: %w", err)
-	}
 	return &sig, nil
 }
 
@@ -13,15 +13,18 @@
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"tailscale.com/control/controlclient"
 	"tailscale.com/hostinfo"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tka"
+	"tailscale.com/tstime"
 	"tailscale.com/types/key"
 	"tailscale.com/types/netmap"
 	"tailscale.com/types/persist"
@@ -764,11 +767,47 @@ func TestTKASign(t *testing.T) {
 		},
 		pm:    pm,
 		store: pm.Store(),
+		clock: tstime.StdClock{},
 	}
 
 	if err := b.NetworkLockSign(toSign.Public(), nil); err != nil {
 		t.Errorf("NetworkLockSign() failed: %v", err)
 	}
+
+	// Sign offline, then submit the signatures as if on another node.
+	sigs := b.NetworkLockSignBatch([]ipnstate.NetworkLockSigningRequestKey{{NodeKey: toSign.Public()}, {NodeKey: toSign.Public()}}, true)
+	for _, s := range sigs {
+		if s.Error != "" || s.Submitted || len(s.Signature) == 0 {
+			t.Errorf("offline signature = %+v; want unsubmitted signature", s)
+		}
+	}
+	sigs = append(sigs, ipnstate.NetworkLockSignature{NodeKey: nodePriv.Public(), Signature: sigs[0].Signature})
+	res, err := b.NetworkLockSubmitSignatures(sigs)
+	if err != nil {
+		t.Fatalf("NetworkLockSubmitSignatures() failed: %v", err)
+	}
+	for i, s := range res {
+		if wantOK := i < 2; s.Submitted != wantOK || (s.Error == "") != wantOK {
+			t.Errorf("submitted signature %d = %+v; want submitted: %v", i, s, wantOK)
+		}
+	}
+
+	audit, err := b.NetworkLockSignAudit()
+	if err != nil {
+		t.Fatalf("NetworkLockSignAudit() failed: %v", err)
+	}
+	var actions []string
+	for _, e := range audit {
+		actions = append(actions, e.Action)
+		if e.Action != "submit" || e.NodeKey == toSign.Public() {
+			if e.Error != "" || e.NodeKey != toSign.Public() || e.SigHash == "" {
+				t.Errorf("audit entry = %+v; want successful signature of %v", e, toSign.Public())
+			}
+		}
+	}
+	if want := []string{"sign", "sign-offline", "sign-offline", "submit", "submit", "submit"}; !slices.Equal(actions, want) {
+		t.Errorf("audit actions = %q; want %q", actions, want)
+	}
 }
 
 func TestTKAForceDisable(t *testing.T) {
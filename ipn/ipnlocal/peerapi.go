@@ -17,6 +17,7 @@
 	"net/netip"
 	"net/url"
 	"os"
+	"path"
 	"runtime"
 	"slices"
 	"sort"
@@ -28,6 +29,7 @@
 	"github.com/kortschak/wol"
 	"golang.org/x/net/dns/dnsmessage"
 	"golang.org/x/net/http/httpguts"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/envknob"
 	"tailscale.com/health"
 	"tailscale.com/hostinfo"
@@ -703,18 +705,42 @@ func (h *peerAPIHandler) handlePeerPut(w http.ResponseWriter, r *http.Request) {
 			}
 			offset = ranges[0].Start
 		}
-		n, err := h.ps.taildrop.PutFile(taildrop.ClientID(fmt.Sprint(id)), baseName, r.Body, offset, r.ContentLength)
-		switch err {
-		case nil:
+		var opts taildrop.PutFileOptions
+		if sumHdr := r.Header.Get(apitype.FileSHA256Header); sumHdr != "" {
+			opts.SHA256 = new(taildrop.Checksum)
+			if err := opts.SHA256.UnmarshalText([]byte(sumHdr)); err != nil {
+				http.Error(w, "invalid "+apitype.FileSHA256Header+" header", http.StatusBadRequest)
+				return
+			}
+		}
+		d, err := h.offerFile(r, baseName, offset, r.Header.Get(apitype.FileSHA256Header))
+		switch {
+		case err == errTooManyFileOffers:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		n, name, err := h.ps.taildrop.PutFileWithOptions(taildrop.ClientID(fmt.Sprint(id)), baseName, r.Body, offset, r.ContentLength, opts)
+		if err == nil && d.Path != "" {
+			if err := h.ps.taildrop.MoveFile(name, d.Path); err != nil {
+				h.logf("moving received file to its accepted path: %v; leaving it in the inbox", err)
+			}
+		}
+		switch {
+		case err == nil:
 			d := h.ps.b.clock.Since(t0).Round(time.Second / 10)
 			h.logf("got put of %s in %v from %v/%v", approxSize(n), d, h.remoteAddr.Addr(), h.peerNode.ComputedName)
 			io.WriteString(w, "{}\n")
-		case taildrop.ErrNoTaildrop:
+		case err == taildrop.ErrNoTaildrop:
 			http.Error(w, err.Error(), http.StatusForbidden)
-		case taildrop.ErrInvalidFileName:
+		case err == taildrop.ErrInvalidFileName:
 			http.Error(w, err.Error(), http.StatusBadRequest)
-		case taildrop.ErrFileExists:
+		case err == taildrop.ErrFileExists:
 			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, taildrop.ErrManifestMismatch):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 		default:
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
@@ -723,6 +749,28 @@ func (h *peerAPIHandler) handlePeerPut(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// offerFile asks the LocalAPI clients processing incoming files, if any,
+// whether to accept the file baseName that r puts, resuming at offset.
+// It returns an error if the file was not accepted.
+func (h *peerAPIHandler) offerFile(r *http.Request, baseName string, offset int64, sha256 string) (ipn.FileOfferDecision, error) {
+	if path.Base(baseName) == apitype.FileManifestName {
+		// Not a file, but the check of a directory's files.
+		return ipn.FileOfferDecision{Accept: true}, nil
+	}
+	size := int64(-1)
+	if r.ContentLength >= 0 {
+		size = offset + r.ContentLength
+	}
+	return h.ps.b.fileOffers.offer(r.Context(), ipn.FileOffer{
+		Name:     baseName,
+		Size:     size,
+		Offset:   offset,
+		SHA256:   sha256,
+		Peer:     h.peerNode.StableID(),
+		PeerName: h.peerNode.ComputedName(),
+	})
+}
+
 func approxSize(n int64) string {
 	if n <= 1<<10 {
 		return "<=1KB"
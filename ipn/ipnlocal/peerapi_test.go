@@ -101,6 +101,19 @@ func fileHasContents(name string, want string) check {
 	}
 }
 
+func fileNotExists(name string) check {
+	return func(t *testing.T, e *peerAPITestEnv) {
+		root := e.ph.ps.taildrop.Dir()
+		if root == "" {
+			t.Errorf("no rootdir; can't check whether %q exists", name)
+			return
+		}
+		if _, err := os.Stat(filepath.Join(root, name)); !os.IsNotExist(err) {
+			t.Errorf("fileNotExists(%q): %v", name, err)
+		}
+	}
+}
+
 func hexAll(v string) string {
 	var sb strings.Builder
 	for i := 0; i < len(v); i++ {
@@ -284,15 +297,58 @@ func TestHandlePeerAPI(t *testing.T) {
 			),
 		},
 		{
-			name:       "bad_filename_slash",
+			name:       "filename_in_directory",
 			isSelf:     true,
 			capSharing: true,
-			reqs:       []*http.Request{httptest.NewRequest("PUT", "/v0/put/foo/bar", nil)},
+			reqs:       []*http.Request{httptest.NewRequest("PUT", "/v0/put/foo/bar", strings.NewReader("baz"))},
+			checks: checks(
+				httpStatus(200),
+				fileHasContents("foo/bar", "baz"),
+			),
+		},
+		{
+			name:       "bad_filename_dotdot_in_directory",
+			isSelf:     true,
+			capSharing: true,
+			reqs:       []*http.Request{httptest.NewRequest("PUT", "/v0/put/foo/"+hexAll("..")+"/bar", nil)},
 			checks: checks(
 				httpStatus(400),
 				bodyContains("invalid filename"),
 			),
 		},
+		{
+			name:       "put_checked",
+			isSelf:     true,
+			capSharing: true,
+			reqs: []*http.Request{
+				func() *http.Request {
+					r := httptest.NewRequest("PUT", "/v0/put/foo", strings.NewReader("baz"))
+					r.Header.Set(apitype.FileSHA256Header, "baa5a0964d3320fbc0c6a922140453c8513ea24ab8fd0577034804a967248096")
+					return r
+				}(),
+			},
+			checks: checks(
+				httpStatus(200),
+				fileHasContents("foo", "baz"),
+			),
+		},
+		{
+			name:       "put_checked_corrupt",
+			isSelf:     true,
+			capSharing: true,
+			reqs: []*http.Request{
+				func() *http.Request {
+					r := httptest.NewRequest("PUT", "/v0/put/foo", strings.NewReader("bar"))
+					r.Header.Set(apitype.FileSHA256Header, "baa5a0964d3320fbc0c6a922140453c8513ea24ab8fd0577034804a967248096")
+					return r
+				}(),
+			},
+			checks: checks(
+				httpStatus(500),
+				bodyContains("checksum mismatch"),
+				fileNotExists("foo"),
+			),
+		},
 		{
 			name:       "bad_filename_encoded_dot",
 			isSelf:     true,
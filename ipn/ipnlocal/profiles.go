@@ -374,6 +374,61 @@ func (pm *profileManager) loadSavedPrefs(key ipn.StateKey) (ipn.PrefsView, error
 	return savedPrefs.View(), nil
 }
 
+// ExportProfile returns the portable configuration of the profile with
+// the given id, without its keys. If the profile is not known, it
+// returns errProfileNotFound.
+func (pm *profileManager) ExportProfile(id ipn.ProfileID) (*ipn.ProfileExport, error) {
+	kp, ok := pm.knownProfiles[id]
+	if !ok || kp.LocalUserID != pm.currentUserID {
+		return nil, errProfileNotFound
+	}
+	prefs := pm.prefs
+	if kp.ID != pm.currentProfile.ID {
+		var err error
+		if prefs, err = pm.loadSavedPrefs(kp.Key); err != nil {
+			return nil, err
+		}
+	}
+	p := prefs.AsStruct()
+	p.Persist = nil
+	return &ipn.ProfileExport{
+		Version:             ipn.ProfileExportVersion,
+		Name:                kp.Name,
+		TailnetMagicDNSName: kp.TailnetMagicDNSName,
+		LoginName:           kp.UserProfile.LoginName,
+		Prefs:               p,
+	}, nil
+}
+
+// ImportProfile adds a profile with the configuration in ex, logged
+// out, and returns it. It doesn't switch to it.
+func (pm *profileManager) ImportProfile(ex *ipn.ProfileExport) (ipn.LoginProfile, error) {
+	if err := ex.Check(); err != nil {
+		return ipn.LoginProfile{}, err
+	}
+	metricImportProfile.Add(1)
+
+	p := ex.Prefs.Clone()
+	p.LoggedOut = true
+	kp := &ipn.LoginProfile{
+		Name:                ex.Name,
+		TailnetMagicDNSName: ex.TailnetMagicDNSName,
+		LocalUserID:         pm.currentUserID,
+		ControlURL:          p.ControlURL,
+	}
+	kp.ID, kp.Key = newUnusedID(pm.knownProfiles)
+	if err := pm.writePrefsToStore(kp.Key, p.View()); err != nil {
+		return ipn.LoginProfile{}, err
+	}
+	pm.knownProfiles[kp.ID] = kp
+	if err := pm.writeKnownProfiles(); err != nil {
+		delete(pm.knownProfiles, kp.ID)
+		pm.WriteState(kp.Key, nil)
+		return ipn.LoginProfile{}, err
+	}
+	return *kp, nil
+}
+
 // CurrentProfile returns the current LoginProfile.
 // The value may be zero if the profile is not persisted.
 func (pm *profileManager) CurrentProfile() ipn.LoginProfile {
@@ -616,6 +671,7 @@ func (pm *profileManager) migrateFromLegacyPrefs() error {
 	metricSwitchProfile    = clientmetric.NewCounter("profiles_switch")
 	metricDeleteProfile    = clientmetric.NewCounter("profiles_delete")
 	metricDeleteAllProfile = clientmetric.NewCounter("profiles_delete_all")
+	metricImportProfile    = clientmetric.NewCounter("profiles_import")
 
 	metricMigration        = clientmetric.NewCounter("profiles_migration")
 	metricMigrationError   = clientmetric.NewCounter("profiles_migration_error")
@@ -576,3 +576,91 @@ func TestProfileManagementWindows(t *testing.T) {
 		t.Fatalf("CurrentUserID = %q; want %q", pm.CurrentUserID(), uid)
 	}
 }
+
+func TestProfileExportImport(t *testing.T) {
+	pm, err := newProfileManagerWithGOOS(new(mem.Store), logger.Discard, "linux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := pm.CurrentPrefs().AsStruct()
+	p.ControlURL = "https://control.example.com"
+	p.ProfileName = "work"
+	p.RouteAll = true
+	p.AdvertiseTags = []string{"tag:server"}
+	p.Persist = &persist.Persist{
+		NodeID:         "node1",
+		PrivateNodeKey: key.NewNode(),
+		UserProfile: tailcfg.UserProfile{
+			ID:        1,
+			LoginName: "user@example.com",
+		},
+	}
+	must.Do(pm.SetPrefs(p.View(), "example.ts.net"))
+	exported := pm.CurrentProfile()
+
+	if _, err := pm.ExportProfile("nope"); err != errProfileNotFound {
+		t.Fatalf("ExportProfile of unknown profile: err = %v; want errProfileNotFound", err)
+	}
+	// Export from another profile, to load the prefs from the store.
+	pm.NewProfile()
+	ex, err := pm.ExportProfile(exported.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.Prefs.Persist != nil {
+		t.Fatal("export has Persist")
+	}
+	if ex.Name != "work" || ex.LoginName != "user@example.com" || ex.TailnetMagicDNSName != "example.ts.net" {
+		t.Errorf("export metadata = %q, %q, %q", ex.Name, ex.LoginName, ex.TailnetMagicDNSName)
+	}
+
+	// Import it on another machine.
+	pm2, err := newProfileManagerWithGOOS(new(mem.Store), logger.Discard, "linux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lp, err := pm2.ImportProfile(ex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lp.ID == "" || lp.Name != "work" || lp.ControlURL != "https://control.example.com" {
+		t.Errorf("imported profile = %+v", lp)
+	}
+	if got := pm2.Profiles(); len(got) != 1 || got[0].ID != lp.ID {
+		t.Errorf("Profiles() = %+v; want the imported profile", got)
+	}
+	must.Do(pm2.SwitchProfile(lp.ID))
+	want := p.Clone()
+	want.Persist = nil
+	want.LoggedOut = true
+	if got := pm2.CurrentPrefs(); !got.Equals(want.View()) {
+		t.Errorf("imported prefs = %v; want %v", got.Pretty(), want.Pretty())
+	}
+
+	// Logging in updates the imported profile rather than adding one.
+	p2 := pm2.CurrentPrefs().AsStruct()
+	p2.LoggedOut = false
+	p2.Persist = &persist.Persist{
+		NodeID:         "node2",
+		PrivateNodeKey: key.NewNode(),
+		UserProfile: tailcfg.UserProfile{
+			ID:        2,
+			LoginName: "user@example.org",
+		},
+	}
+	must.Do(pm2.SetPrefs(p2.View(), ""))
+	if got := pm2.Profiles(); len(got) != 1 || got[0].ID != lp.ID || got[0].NodeID != "node2" {
+		t.Errorf("Profiles() after login = %+v; want the imported profile", got)
+	}
+
+	for _, bad := range []*ipn.ProfileExport{
+		{Version: 0, Prefs: ipn.NewPrefs()},
+		{Version: ipn.ProfileExportVersion + 1, Prefs: ipn.NewPrefs()},
+		{Version: ipn.ProfileExportVersion},
+		{Version: ipn.ProfileExportVersion, Prefs: p},
+	} {
+		if _, err := pm2.ImportProfile(bad); err == nil {
+			t.Errorf("ImportProfile(%+v) succeeded; want error", bad)
+		}
+	}
+}
@@ -26,6 +26,7 @@
 	"sync/atomic"
 	"time"
 
+	"golang.org/x/net/http/httpguts"
 	"golang.org/x/net/http2"
 	"tailscale.com/ipn"
 	"tailscale.com/logtail/backoff"
@@ -34,6 +35,8 @@
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/lazy"
 	"tailscale.com/types/logger"
+	"tailscale.com/types/views"
+	"tailscale.com/util/cmpx"
 	"tailscale.com/util/mak"
 	"tailscale.com/version"
 )
@@ -521,16 +524,24 @@ func (b *LocalBackend) getServeHandler(r *http.Request) (_ ipn.HTTPHandlerView,
 		return z, "", false
 	}
 
-	if h, ok := wsc.Handlers().GetOk(r.URL.Path); ok {
+	// getOk returns the handler for mount, preferring the one for the
+	// request's method.
+	getOk := func(mount string) (ipn.HTTPHandlerView, bool) {
+		if h, ok := wsc.Handlers().GetOk(ipn.MountPointForMethod(r.Method, mount)); ok {
+			return h, true
+		}
+		return wsc.Handlers().GetOk(mount)
+	}
+	if h, ok := getOk(r.URL.Path); ok {
 		return h, r.URL.Path, true
 	}
 	pth := path.Clean(r.URL.Path)
 	for {
 		withSlash := pth + "/"
-		if h, ok := wsc.Handlers().GetOk(withSlash); ok {
+		if h, ok := getOk(withSlash); ok {
 			return h, withSlash, true
 		}
-		if h, ok := wsc.Handlers().GetOk(pth); ok {
+		if h, ok := getOk(pth); ok {
 			return h, pth, true
 		}
 		if pth == "/" {
@@ -552,6 +563,7 @@ func (b *LocalBackend) proxyHandlerForBackend(backend string) (http.Handler, err
 		logf:     b.logf,
 		url:      u,
 		insecure: insecure,
+		h2c:      strings.HasPrefix(backend, "h2c://"),
 		backend:  backend,
 		lb:       b,
 	}
@@ -562,18 +574,24 @@ func (b *LocalBackend) proxyHandlerForBackend(backend string) (http.Handler, err
 // (preconfigured via ipn.ServeConfig). If the host is configured with
 // http+insecure prefix, connection between proxy and backend will be over
 // insecure TLS. If the backend host has a http prefix and the incoming request
-// has application/grpc content type header, the connection will be over h2c.
-// Otherwise standard Go http transport will be used.
+// has application/grpc content type header, or the backend host has a h2c
+// prefix, the connection will be over h2c. Connection upgrades, such as to
+// WebSockets, use HTTP/1.1 with timeouts. Otherwise standard Go http
+// transport will be used.
 type reverseProxy struct {
 	logf logger.Logf
 	url  *url.URL
 	// insecure tracks whether the connection to an https backend should be
 	// insecure (i.e because we cannot verify its CA).
-	insecure      bool
-	backend       string
-	lb            *LocalBackend
-	httpTransport lazy.SyncValue[*http.Transport]  // transport for non-h2c backends
-	h2cTransport  lazy.SyncValue[*http2.Transport] // transport for h2c backends
+	insecure bool
+	// h2c tracks whether all non-upgrade requests to the backend should be
+	// sent over h2c (HTTP/2 without TLS).
+	h2c              bool
+	backend          string
+	lb               *LocalBackend
+	httpTransport    lazy.SyncValue[*http.Transport]  // transport for non-h2c backends
+	h2cTransport     lazy.SyncValue[*http2.Transport] // transport for h2c backends
+	upgradeTransport lazy.SyncValue[*http.Transport]  // transport for connection upgrades
 	// closed tracks whether proxy is closed/currently closing.
 	closed atomic.Bool
 }
@@ -591,6 +609,11 @@ func (rp *reverseProxy) close() {
 	}); httpTransport != nil {
 		httpTransport.CloseIdleConnections()
 	}
+	if upgradeTransport := rp.upgradeTransport.Get(func() *http.Transport {
+		return nil
+	}); upgradeTransport != nil {
+		upgradeTransport.CloseIdleConnections()
+	}
 }
 
 func (rp *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -610,11 +633,14 @@ func (rp *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// https://datatracker.ietf.org/doc/html/rfc9113#name-starting-http-2.
 	// However, we assume that http:// proxy prefix in combination with the
 	// protoccol being HTTP/2 is sufficient to detect h2c for our needs. Only use this for
-	// gRPC to fix a known problem of plaintext gRPC backends
-	if rp.shouldProxyViaH2C(r) {
-		rp.logf("received a proxy request for plaintext gRPC")
+	// gRPC to fix a known problem of plaintext gRPC backends, unless the
+	// backend is configured with the h2c:// prefix.
+	switch {
+	case isUpgradeRequest(r):
+		p.Transport = rp.getUpgradeTransport()
+	case rp.shouldProxyViaH2C(r):
 		p.Transport = rp.getH2CTransport()
-	} else {
+	default:
 		p.Transport = rp.getTransport()
 	}
 	p.ServeHTTP(w, r)
@@ -648,13 +674,77 @@ func (rp *reverseProxy) getH2CTransport() *http2.Transport {
 			DialTLSContext: func(ctx context.Context, network string, addr string, _ *tls.Config) (net.Conn, error) {
 				return rp.lb.dialer.SystemDial(ctx, "tcp", rp.url.Host)
 			},
+			// Detect dead backend connections, which long-lived gRPC
+			// streams would otherwise hang on.
+			ReadIdleTimeout: 30 * time.Second,
+			PingTimeout:     15 * time.Second,
+		}
+	})
+}
+
+// upgradeHandshakeTimeout is how long the backend may take to respond to a
+// request to upgrade the connection, such as to a WebSocket.
+const upgradeHandshakeTimeout = 30 * time.Second
+
+// upgradedConnIdleTimeout is how long an upgraded connection to the backend,
+// such as a WebSocket, may go without traffic in either direction before
+// it's closed.
+const upgradedConnIdleTimeout = time.Hour
+
+// getUpgradeTransport returns the Transport used for requests to upgrade
+// the connection to the backend, such as to a WebSocket. Those need
+// HTTP/1.1, even for h2c backends. The Transport gets created lazily, at
+// most once.
+func (rp *reverseProxy) getUpgradeTransport() *http.Transport {
+	return rp.upgradeTransport.Get(func() *http.Transport {
+		return &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				c, err := rp.lb.dialer.SystemDial(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				return &idleTimeoutConn{Conn: c, timeout: upgradedConnIdleTimeout}, nil
+			},
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: rp.insecure,
+			},
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: upgradeHandshakeTimeout,
+			// Upgraded connections aren't reused.
+			DisableKeepAlives: true,
 		}
 	})
 }
 
+// idleTimeoutConn is a net.Conn that times out after going without reads
+// or writes for timeout.
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(p []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(p)
+}
+
+func (c *idleTimeoutConn) Write(p []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(p)
+}
+
+// isUpgradeRequest reports whether r asks to upgrade the connection to
+// another protocol, such as a WebSocket.
+func isUpgradeRequest(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" && httpguts.HeaderValuesContainsToken(r.Header["Connection"], "Upgrade")
+}
+
 // This is not a generally reliable way how to determine whether a request is
 // for a h2c server, but sufficient for our particular use case.
 func (rp *reverseProxy) shouldProxyViaH2C(r *http.Request) bool {
+	if rp.h2c {
+		return true
+	}
 	contentType := r.Header.Get(contentTypeHeader)
 	return r.ProtoMajor == 2 && strings.HasPrefix(rp.backend, "http://") && isGRPCContentType(contentType)
 }
@@ -708,39 +798,134 @@ func (b *LocalBackend) addTailscaleIdentityHeaders(r *httputil.ProxyRequest) {
 // serveWebHandler is an http.HandlerFunc that maps incoming requests to the
 // correct *http.
 func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	aw := &accessLogResponseWriter{ResponseWriter: w}
+	w = aw
+	var body *countingReadCloser
+	if r.Body != nil {
+		body = &countingReadCloser{ReadCloser: r.Body}
+		r.Body = body
+	}
+	a := b.newServeAccess(r, start)
+	defer func() {
+		a.Status = cmpx.Or(aw.status, http.StatusOK)
+		a.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+		if body != nil {
+			a.BytesIn = body.n
+		}
+		a.BytesOut = aw.n
+		b.serveAccessLog.record(a)
+	}()
+
 	h, mountPoint, ok := b.getServeHandler(r)
+	a.Route = mountPoint
 	if !ok {
 		http.NotFound(w, r)
 		return
 	}
+	if hs := h.ResponseHeaders(); hs.Len() > 0 {
+		w = &setHeadersResponseWriter{ResponseWriter: w, headers: hs}
+	}
 	if s := h.Text(); s != "" {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		io.WriteString(w, s)
 		return
 	}
 	if v := h.Path(); v != "" {
-		b.serveFileOrDirectory(w, r, v, mountPoint)
+		b.serveFileOrDirectory(w, r, v, mountPoint, fileServeOptsOf(h))
 		return
 	}
 	if v := h.Proxy(); v != "" {
-		p, ok := b.serveProxyHandlers.Load(v)
+		ph, ok := b.serveProxyHandlers.Load(v)
 		if !ok {
 			http.Error(w, "unknown proxy destination", http.StatusInternalServerError)
 			return
 		}
-		h := p.(http.Handler)
-		// Trim the mount point from the URL path before proxying. (#6571)
-		if r.URL.Path != "/" {
-			h = http.StripPrefix(strings.TrimSuffix(mountPoint, "/"), h)
+		if hs := h.RequestHeaders(); hs.Len() > 0 {
+			b.setServeRequestHeaders(r, hs)
+		}
+		p := ph.(http.Handler)
+		// Trim the mount point from the URL path before proxying, (#6571)
+		// replacing it with RewritePath if set.
+		if rp := h.RewritePath(); rp != "" {
+			p = http.StripPrefix(strings.TrimSuffix(mountPoint, "/"), prefixPath(strings.TrimSuffix(rp, "/"), p))
+		} else if r.URL.Path != "/" {
+			p = http.StripPrefix(strings.TrimSuffix(mountPoint, "/"), p)
 		}
-		h.ServeHTTP(w, r)
+		p.ServeHTTP(w, r)
 		return
 	}
 
 	http.Error(w, "empty handler", 500)
 }
 
-func (b *LocalBackend) serveFileOrDirectory(w http.ResponseWriter, r *http.Request, fileOrDir, mountPoint string) {
+// setServeRequestHeaders sets the headers hs, from an
+// ipn.HTTPHandler's RequestHeaders, on r.
+func (b *LocalBackend) setServeRequestHeaders(r *http.Request, hs views.Map[string, string]) {
+	var login, name, nodeName, nodeIP string
+	if c, ok := getServeHTTPContext(r); ok {
+		if node, user, ok := b.WhoIs(c.SrcAddr); ok {
+			nodeName = node.ComputedName()
+			nodeIP = c.SrcAddr.Addr().String()
+			if !node.IsTagged() {
+				login, name = user.LoginName, user.DisplayName
+			}
+		}
+	}
+	rep := strings.NewReplacer(
+		"{user.login}", login,
+		"{user.name}", name,
+		"{node.name}", nodeName,
+		"{node.ip}", nodeIP,
+	)
+	hs.Range(func(k, v string) bool {
+		if v == "" {
+			r.Header.Del(k)
+		} else {
+			r.Header.Set(k, rep.Replace(v))
+		}
+		return true
+	})
+}
+
+// prefixPath returns a handler that serves requests with h after
+// prefixing their URL path with prefix.
+func prefixPath(prefix string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = prefix + r.URL.Path
+		if r.URL.RawPath != "" {
+			r2.URL.RawPath = prefix + r.URL.RawPath
+		}
+		if r2.URL.Path == "" {
+			r2.URL.Path = "/"
+		}
+		h.ServeHTTP(w, r2)
+	})
+}
+
+// fileServeOpts are the options of serving a file or directory, from an
+// ipn.HTTPHandler.
+type fileServeOpts struct {
+	indexFiles   []string // or empty for index.html
+	spa          bool
+	noListing    bool
+	cacheControl string
+}
+
+func fileServeOptsOf(h ipn.HTTPHandlerView) fileServeOpts {
+	return fileServeOpts{
+		indexFiles:   h.IndexFiles().AsSlice(),
+		spa:          h.SPA(),
+		noListing:    h.NoListing(),
+		cacheControl: h.CacheControl(),
+	}
+}
+
+func (b *LocalBackend) serveFileOrDirectory(w http.ResponseWriter, r *http.Request, fileOrDir, mountPoint string, opts fileServeOpts) {
 	fi, err := os.Stat(fileOrDir)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -761,7 +946,7 @@ func (b *LocalBackend) serveFileOrDirectory(w http.ResponseWriter, r *http.Reque
 			return
 		}
 		defer f.Close()
-		http.ServeContent(w, r, path.Base(mountPoint), fi.ModTime(), f)
+		serveFile(w, r, path.Base(mountPoint), fi, f, opts.cacheControl)
 		return
 	}
 	if !fi.IsDir() {
@@ -773,7 +958,51 @@ func (b *LocalBackend) serveFileOrDirectory(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	var fs http.Handler = http.FileServer(http.Dir(fileOrDir))
+	// The path within the directory. http.Dir cleans it, so that it can't
+	// refer to files outside of the directory.
+	name := strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(mountPoint, "/"))
+	if !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+	dir := http.Dir(fileOrDir)
+	f, err := dir.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			if opts.spa && (r.Method == "GET" || r.Method == "HEAD") && path.Ext(name) == "" &&
+				serveIndexFile(w, r, dir, "/", opts) {
+				return
+			}
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "error opening file", 500)
+		return
+	}
+	defer f.Close()
+	fi, err = f.Stat()
+	if err != nil {
+		http.Error(w, "error opening file", 500)
+		return
+	}
+	if !fi.IsDir() {
+		serveFile(w, r, fi.Name(), fi, f, opts.cacheControl)
+		return
+	}
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		u := *r.URL
+		u.Path += "/"
+		http.Redirect(w, r, u.RequestURI(), http.StatusMovedPermanently)
+		return
+	}
+	if serveIndexFile(w, r, dir, name, opts) {
+		return
+	}
+	if opts.noListing {
+		http.NotFound(w, r)
+		return
+	}
+
+	var fs http.Handler = http.FileServer(dir)
 	if mountPoint != "/" {
 		fs = http.StripPrefix(strings.TrimSuffix(mountPoint, "/"), fs)
 	}
@@ -783,6 +1012,45 @@ func (b *LocalBackend) serveFileOrDirectory(w http.ResponseWriter, r *http.Reque
 	}, r)
 }
 
+// serveIndexFile serves the index file of the directory named dirName in
+// dir, reporting whether there was one.
+func serveIndexFile(w http.ResponseWriter, r *http.Request, dir http.Dir, dirName string, opts fileServeOpts) bool {
+	indexFiles := opts.indexFiles
+	if len(indexFiles) == 0 {
+		indexFiles = []string{"index.html"}
+	}
+	for _, index := range indexFiles {
+		f, err := dir.Open(path.Join(dirName, index))
+		if err != nil {
+			continue
+		}
+		fi, err := f.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+			f.Close()
+			continue
+		}
+		defer f.Close()
+		cacheControl := ""
+		if opts.cacheControl != "" {
+			cacheControl = "no-cache"
+		}
+		serveFile(w, r, index, fi, f, cacheControl)
+		return true
+	}
+	return false
+}
+
+// serveFile serves the contents of the file f, with the given name and
+// FileInfo, and the given Cache-Control header value if non-empty.
+func serveFile(w http.ResponseWriter, r *http.Request, name string, fi os.FileInfo, f io.ReadSeeker, cacheControl string) {
+	if cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+		// A weak ETag lets caches revalidate the file cheaply.
+		w.Header().Set("Etag", fmt.Sprintf(`W/"%x-%x"`, fi.ModTime().UnixNano(), fi.Size()))
+	}
+	http.ServeContent(w, r, name, fi.ModTime(), f)
+}
+
 // fixLocationHeaderResponseWriter is an http.ResponseWriter wrapper that, upon
 // flushing HTTP headers, prefixes any Location header with the mount point.
 type fixLocationHeaderResponseWriter struct {
@@ -808,12 +1076,50 @@ func (w *fixLocationHeaderResponseWriter) Write(p []byte) (int, error) {
 	return w.ResponseWriter.Write(p)
 }
 
+// setHeadersResponseWriter is an http.ResponseWriter wrapper that, upon
+// flushing HTTP headers, sets the configured headers.
+type setHeadersResponseWriter struct {
+	http.ResponseWriter
+	headers views.Map[string, string] // empty values delete the header
+	setOnce sync.Once                 // guards call to set
+}
+
+func (w *setHeadersResponseWriter) set() {
+	h := w.ResponseWriter.Header()
+	w.headers.Range(func(k, v string) bool {
+		if v == "" {
+			h.Del(k)
+		} else {
+			h.Set(k, v)
+		}
+		return true
+	})
+}
+
+func (w *setHeadersResponseWriter) WriteHeader(code int) {
+	w.setOnce.Do(w.set)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *setHeadersResponseWriter) Write(p []byte) (int, error) {
+	w.setOnce.Do(w.set)
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the underlying ResponseWriter, for use by
+// http.ResponseController, such as to flush responses or hijack
+// connections.
+func (w *setHeadersResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 // expandProxyArg returns a URL from s, where s can be of form:
 //
 // * port number ("8080")
 // * host:port ("localhost:8080")
 // * full URL ("http://localhost:8080", in which case it's returned unchanged)
 // * insecure TLS ("https+insecure://127.0.0.1:4430")
+// * h2c ("h2c://127.0.0.1:50051", returned as an http:// URL)
 func expandProxyArg(s string) (targetURL string, insecureSkipVerify bool) {
 	if s == "" {
 		return "", false
@@ -824,6 +1130,9 @@ func expandProxyArg(s string) (targetURL string, insecureSkipVerify bool) {
 	if rest, ok := strings.CutPrefix(s, "https+insecure://"); ok {
 		return "https://" + rest, true
 	}
+	if rest, ok := strings.CutPrefix(s, "h2c://"); ok {
+		return "http://" + rest, false
+	}
 	if allNumeric(s) {
 		return "http://127.0.0.1:" + s, false
 	}
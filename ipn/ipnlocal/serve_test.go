@@ -4,6 +4,7 @@
 package ipnlocal
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/sha256"
@@ -12,6 +13,8 @@
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
@@ -23,6 +26,9 @@
 	"testing"
 	"time"
 
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"tailscale.com/envknob"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/tailcfg"
@@ -51,6 +57,7 @@ type res struct {
 		{"http://foo.com", res{"http://foo.com", false}},
 		{"https://foo.com", res{"https://foo.com", false}},
 		{"https+insecure://10.2.3.4", res{"https://10.2.3.4", true}},
+		{"h2c://10.2.3.4:50051", res{"http://10.2.3.4:50051", false}},
 	}
 	for _, tt := range tests {
 		target, insecure := expandProxyArg(tt.in)
@@ -69,6 +76,7 @@ func TestGetServeHandler(t *testing.T) {
 				Handlers: map[string]*ipn.HTTPHandler{
 					"/":         {},
 					"/bar":      {},
+					"POST /bar": {Text: "post"},
 					"/foo/":     {},
 					"/foo/bar":  {},
 					"/foo/bar/": {},
@@ -78,11 +86,13 @@ func TestGetServeHandler(t *testing.T) {
 	}
 
 	tests := []struct {
-		name string
-		port uint16 // or 443 is zero
-		path string // http.Request.URL.Path
-		conf *ipn.ServeConfig
-		want string // mountPoint
+		name     string
+		port     uint16 // or 443 is zero
+		method   string // or GET if empty
+		path     string // http.Request.URL.Path
+		conf     *ipn.ServeConfig
+		want     string // mountPoint
+		wantText string
 	}{
 		{
 			name: "nothing",
@@ -108,6 +118,14 @@ func TestGetServeHandler(t *testing.T) {
 			path: "/bar",
 			want: "/bar",
 		},
+		{
+			name:     "bar-post",
+			conf:     conf1,
+			method:   "POST",
+			path:     "/bar/baz",
+			want:     "/bar",
+			wantText: "post",
+		},
 		{
 			name: "foo-bar",
 			conf: conf1,
@@ -152,6 +170,7 @@ func TestGetServeHandler(t *testing.T) {
 				logf:        t.Logf,
 			}
 			req := &http.Request{
+				Method: cmpx.Or(tt.method, "GET"),
 				URL: &url.URL{
 					Path: tt.path,
 				},
@@ -172,6 +191,9 @@ func TestGetServeHandler(t *testing.T) {
 			if got != tt.want {
 				t.Errorf("got handler at mount %q, want %q", got, tt.want)
 			}
+			if ok && h.Text() != tt.wantText {
+				t.Errorf("got handler with text %q, want %q", h.Text(), tt.wantText)
+			}
 		})
 	}
 }
@@ -447,6 +469,222 @@ type headerCheck struct {
 	}
 }
 
+func TestServeHTTPProxyRules(t *testing.T) {
+	b := newTestBackend(t)
+
+	testServ := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Path", r.URL.Path)
+			w.Header().Set("X-User", r.Header.Get("X-User"))
+			w.Header().Set("X-Removed", r.Header.Get("X-Removed"))
+			w.Header().Set("Server", "backend")
+		},
+	))
+	defer testServ.Close()
+
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/api/": {
+					Proxy:       testServ.URL,
+					RewritePath: "/v1/",
+					RequestHeaders: map[string]string{
+						"X-User":    "{user.login} on {node.name}",
+						"X-Removed": "",
+					},
+					ResponseHeaders: map[string]string{
+						"Server":     "",
+						"X-Frontend": "serve",
+					},
+				},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/api/foo"},
+		Header: http.Header{"X-Removed": {"secret"}},
+		TLS:    &tls.ConnectionState{ServerName: "example.ts.net"},
+	}
+	req = req.WithContext(context.WithValue(req.Context(), serveHTTPContextKey{}, &serveHTTPContext{
+		DestPort: 443,
+		SrcAddr:  netip.MustParseAddrPort("100.150.151.152:1234"),
+	}))
+	w := httptest.NewRecorder()
+	b.serveWebHandler(w, req)
+
+	h := w.Result().Header
+	for header, want := range map[string]string{
+		"X-Path":     "/v1/foo",
+		"X-User":     "someone@example.com on some-peer",
+		"X-Removed":  "",
+		"Server":     "",
+		"X-Frontend": "serve",
+	} {
+		if got := h.Get(header); got != want {
+			t.Errorf("invalid %q header; want=%q, got=%q", header, want, got)
+		}
+	}
+}
+
+func TestServeHTTPProxyH2CAndUpgrade(t *testing.T) {
+	b := newTestBackend(t)
+
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Upgrade") != "echo" {
+				io.WriteString(w, r.Proto)
+				return
+			}
+			c, brw, err := http.NewResponseController(w).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer c.Close()
+			io.WriteString(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+			brw.Flush()
+			io.Copy(c, brw)
+		},
+	), &http2.Server{}))
+	defer backend.Close()
+
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/": {Proxy: "h2c://" + backend.Listener.Addr().String()},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+	records, unsubscribe := b.serveAccessLog.subscribe()
+	defer unsubscribe()
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.TLS = &tls.ConnectionState{ServerName: "example.ts.net"}
+		r = r.WithContext(context.WithValue(r.Context(), serveHTTPContextKey{}, &serveHTTPContext{
+			DestPort: 443,
+			SrcAddr:  netip.MustParseAddrPort("100.150.151.152:1234"),
+		}))
+		b.serveWebHandler(w, r)
+	}))
+	defer front.Close()
+
+	res, err := http.Get(front.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(got) != "HTTP/2.0" {
+		t.Errorf("backend got request over %q; want HTTP/2.0", got)
+	}
+
+	// Upgrades use HTTP/1.1, even to h2c backends.
+	c, err := net.Dial("tcp", front.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	io.WriteString(c, "GET / HTTP/1.1\r\nHost: example.ts.net\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	br := bufio.NewReader(c)
+	res, err = http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("upgrade response status = %v; want 101", res.Status)
+	}
+	io.WriteString(c, "ping")
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(br, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("read %q, %v from upgraded connection; want ping", buf, err)
+	}
+
+	// The upgraded connection is logged when it's closed.
+	c.Close()
+	for a := range records {
+		if a.Status == http.StatusSwitchingProtocols {
+			break
+		}
+	}
+}
+
+func TestServeAccessLog(t *testing.T) {
+	b := newTestBackend(t)
+	logFile := filepath.Join(t.TempDir(), "access.log")
+	envknob.Setenv("TS_SERVE_ACCESS_LOG", logFile)
+	defer envknob.Setenv("TS_SERVE_ACCESS_LOG", "")
+
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/hello": {Text: "hello"},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+	records, unsubscribe := b.serveAccessLog.subscribe()
+	defer unsubscribe()
+
+	serve := func(path, srcIP string) {
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: path, RawQuery: "secret=1"},
+			TLS:    &tls.ConnectionState{ServerName: "example.ts.net"},
+		}
+		req = req.WithContext(context.WithValue(req.Context(), serveHTTPContextKey{}, &serveHTTPContext{
+			DestPort: 443,
+			SrcAddr:  netip.MustParseAddrPort(srcIP + ":1234"),
+		}))
+		b.serveWebHandler(httptest.NewRecorder(), req)
+	}
+	requests := serveRouteMetricsFor(443, "/hello").requests.Value()
+
+	serve("/hello", "100.150.151.152")
+	got := <-records
+	got.Time, got.LatencyMS = time.Time{}, 0
+	want := ipn.ServeAccess{
+		Host:     "example.ts.net:443",
+		Route:    "/hello",
+		Src:      netip.MustParseAddrPort("100.150.151.152:1234"),
+		Node:     "some-peer",
+		User:     "someone@example.com",
+		Method:   "GET",
+		Path:     "/hello",
+		Status:   200,
+		BytesOut: 5,
+	}
+	if got != want {
+		t.Errorf("got record %+v; want %+v", got, want)
+	}
+	if n := serveRouteMetricsFor(443, "/hello").requests.Value(); n != requests+1 {
+		t.Errorf("route requests metric = %d; want %d", n, requests+1)
+	}
+
+	serve("/other", "100.160.161.162")
+	got = <-records
+	if !got.Funnel || got.Route != "" || got.Status != http.StatusNotFound || got.User != "" {
+		t.Errorf("got record %+v; want 404 from funnel", got)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(must.Get(os.ReadFile(logFile)))), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("access log has %d lines; want 2: %q", len(lines), lines)
+	}
+	var a ipn.ServeAccess
+	if err := json.Unmarshal([]byte(lines[0]), &a); err != nil || a.Route != "/hello" {
+		t.Errorf("access log line %q: %+v, %v; want /hello", lines[0], a, err)
+	}
+}
+
 func Test_reverseProxyConfiguration(t *testing.T) {
 	b := newTestBackend(t)
 	type test struct {
@@ -691,7 +929,7 @@ func TestServeFileOrDirectory(t *testing.T) {
 	for _, tt := range tests {
 		rec := httptest.NewRecorder()
 		req := httptest.NewRequest("GET", tt.req, nil)
-		b.serveFileOrDirectory(rec, req, td, tt.mount)
+		b.serveFileOrDirectory(rec, req, td, tt.mount, fileServeOpts{})
 		if tt.want == nil {
 			t.Errorf("no want for path %q", tt.req)
 			return
@@ -702,6 +940,55 @@ func TestServeFileOrDirectory(t *testing.T) {
 	}
 }
 
+func TestServeDirectoryOptions(t *testing.T) {
+	td := t.TempDir()
+	for name, contents := range map[string]string{
+		"index.html":     "the app",
+		"app.js":         "the script",
+		"docs/readme":    "read me",
+		"custom/home.md": "home",
+	} {
+		must.Do(os.MkdirAll(filepath.Join(td, filepath.Dir(name)), 0700))
+		must.Do(os.WriteFile(filepath.Join(td, name), []byte(contents), 0600))
+	}
+
+	b := &LocalBackend{}
+	tests := []struct {
+		name      string
+		req       string
+		opts      fileServeOpts
+		wantCode  int
+		wantBody  string
+		wantCache string // Cache-Control
+	}{
+		{"index", "/app/", fileServeOpts{}, 200, "the app", ""},
+		{"listing", "/app/docs/", fileServeOpts{}, 200, "readme", ""},
+		{"no-listing", "/app/docs/", fileServeOpts{noListing: true}, 404, "", ""},
+		{"custom-index", "/app/custom/", fileServeOpts{indexFiles: []string{"home.txt", "home.md"}}, 200, "home", ""},
+		{"missing", "/app/some/route", fileServeOpts{}, 404, "", ""},
+		{"spa-route", "/app/some/route", fileServeOpts{spa: true, cacheControl: "max-age=3600"}, 200, "the app", "no-cache"},
+		{"spa-missing-file", "/app/missing.js", fileServeOpts{spa: true}, 404, "", ""},
+		{"spa-file", "/app/app.js", fileServeOpts{spa: true, cacheControl: "max-age=3600"}, 200, "the script", "max-age=3600"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", tt.req, nil)
+			b.serveFileOrDirectory(rec, req, td, "/app/", tt.opts)
+			res := rec.Result()
+			if res.StatusCode != tt.wantCode {
+				t.Fatalf("status = %d; want %d", res.StatusCode, tt.wantCode)
+			}
+			if tt.wantCode == 200 && !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %q; want it to contain %q", rec.Body, tt.wantBody)
+			}
+			if got := res.Header.Get("Cache-Control"); got != tt.wantCache {
+				t.Errorf("Cache-Control = %q; want %q", got, tt.wantCache)
+			}
+		})
+	}
+}
+
 func Test_isGRPCContentType(t *testing.T) {
 	tests := []struct {
 		contentType string
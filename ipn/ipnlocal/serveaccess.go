@@ -0,0 +1,286 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/envknob"
+	"tailscale.com/ipn"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/set"
+)
+
+// serveAccessLogFile, if set, is the path of a file to append the serve
+// access log to, as JSON lines of ipn.ServeAccess.
+var serveAccessLogFile = envknob.RegisterString("TS_SERVE_ACCESS_LOG")
+
+// maxServeAccessBacklog is how many records of served requests a LocalAPI
+// stream of the serve access log may fall behind by before records are
+// dropped from it.
+const maxServeAccessBacklog = 256
+
+// serveAccessLog records the HTTP requests served per the ServeConfig, to
+// the TS_SERVE_ACCESS_LOG file and to the LocalAPI serve-access-log
+// streams, and counts them in clientmetrics.
+type serveAccessLog struct {
+	logf logger.Logf
+
+	mu      sync.Mutex
+	subs    set.HandleSet[chan ipn.ServeAccess]
+	f       *os.File // or nil if not yet opened
+	fileErr bool     // whether opening or writing f failed, to log it once
+}
+
+func newServeAccessLog(logf logger.Logf) *serveAccessLog {
+	return &serveAccessLog{logf: logf}
+}
+
+// subscribe registers a stream of the access log, which is sent the
+// records of served requests on the returned channel until unsubscribe
+// is called.
+func (l *serveAccessLog) subscribe() (records <-chan ipn.ServeAccess, unsubscribe func()) {
+	ch := make(chan ipn.ServeAccess, maxServeAccessBacklog)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h := l.subs.Add(ch)
+	return ch, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.subs, h)
+	}
+}
+
+// record counts a served request in clientmetrics and writes a to the
+// access log, if l is non-nil.
+func (l *serveAccessLog) record(a ipn.ServeAccess) {
+	metricServeRequests.Add(1)
+	if a.Funnel {
+		metricServeFunnelRequests.Add(1)
+	}
+	if a.Route != "" {
+		if port, err := a.Host.Port(); err == nil {
+			if m := serveRouteMetricsFor(port, a.Route); m != nil {
+				m.requests.Add(1)
+				if a.Status >= 500 {
+					m.errors.Add(1)
+				}
+				m.bytesOut.Add(a.BytesOut)
+			}
+		}
+	}
+
+	if l == nil {
+		return
+	}
+	path := serveAccessLogFile()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, ch := range l.subs {
+		select {
+		case ch <- a:
+		default:
+			// The stream is far behind; drop the record.
+		}
+	}
+	if path == "" {
+		return
+	}
+	js, err := json.Marshal(a)
+	if err != nil {
+		l.logf("serve: access log: %v", err)
+		return
+	}
+	if l.f == nil {
+		l.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			l.f = nil
+			l.noteFileErrLocked(err)
+			return
+		}
+	}
+	if _, err := l.f.Write(append(js, '\n')); err != nil {
+		// Reopen it next time, in case it was removed or rotated.
+		l.f.Close()
+		l.f = nil
+		l.noteFileErrLocked(err)
+		return
+	}
+	l.fileErr = false
+}
+
+// noteFileErrLocked logs err, an error writing to the access log file,
+// unless the previous write failed too.
+func (l *serveAccessLog) noteFileErrLocked(err error) {
+	if !l.fileErr {
+		l.logf("serve: writing access log: %v", err)
+	}
+	l.fileErr = true
+}
+
+var (
+	metricServeRequests       = clientmetric.NewCounter("serve_requests")
+	metricServeFunnelRequests = clientmetric.NewCounter("serve_funnel_requests")
+)
+
+// maxServeRouteMetrics is the most serve routes that get their own
+// clientmetrics, as metrics can't be unpublished.
+const maxServeRouteMetrics = 100
+
+// serveRouteMetrics are the clientmetrics of a serve route.
+type serveRouteMetrics struct {
+	requests *clientmetric.Metric // requests served
+	errors   *clientmetric.Metric // requests served with a 5xx status
+	bytesOut *clientmetric.Metric // bytes of response bodies
+}
+
+var (
+	serveRouteMetricsMu  sync.Mutex
+	serveRouteMetricsMap map[string]*serveRouteMetrics // by metric name prefix
+)
+
+// serveRouteMetricsFor returns the clientmetrics of the route at mount on
+// the given port, creating them if needed. It returns nil if there are too
+// many routes. Routes on the same port with the same mount point share
+// their metrics, as the metric names don't include the host name.
+func serveRouteMetricsFor(port uint16, mount string) *serveRouteMetrics {
+	prefix := fmt.Sprintf("serve_route_%d_%s", port, strings.Map(func(r rune) rune {
+		if 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' {
+			return r
+		}
+		return '_'
+	}, mount))
+	serveRouteMetricsMu.Lock()
+	defer serveRouteMetricsMu.Unlock()
+	if m, ok := serveRouteMetricsMap[prefix]; ok {
+		return m
+	}
+	if len(serveRouteMetricsMap) >= maxServeRouteMetrics {
+		return nil
+	}
+	m := &serveRouteMetrics{
+		requests: clientmetric.NewCounter(prefix + "_requests"),
+		errors:   clientmetric.NewCounter(prefix + "_errors"),
+		bytesOut: clientmetric.NewCounter(prefix + "_bytes_out"),
+	}
+	if serveRouteMetricsMap == nil {
+		serveRouteMetricsMap = make(map[string]*serveRouteMetrics)
+	}
+	serveRouteMetricsMap[prefix] = m
+	return m
+}
+
+// accessLogResponseWriter is an http.ResponseWriter wrapper that records
+// the response status and size for the serve access log.
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	status int   // or 0 if not yet written
+	n      int64 // bytes of body written
+}
+
+func (w *accessLogResponseWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 || code == http.StatusSwitchingProtocols {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessLogResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Hijack takes over the connection, which is recorded as having been
+// upgraded.
+func (w *accessLogResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	c, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return c, brw, err
+}
+
+// Unwrap returns the underlying ResponseWriter, for use by
+// http.ResponseController.
+func (w *accessLogResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// countingReadCloser is an io.ReadCloser that counts the bytes read.
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// newServeAccess returns the record of serving r, received at start, for
+// the serve access log. Its Route, Status and sizes are left to be filled
+// in.
+func (b *LocalBackend) newServeAccess(r *http.Request, start time.Time) ipn.ServeAccess {
+	a := ipn.ServeAccess{
+		Time:   start,
+		Method: r.Method,
+		Path:   r.URL.Path,
+	}
+	host := r.Host
+	if r.TLS != nil {
+		host = r.TLS.ServerName
+	} else if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	c, ok := getServeHTTPContext(r)
+	if !ok {
+		return a
+	}
+	a.Host = ipn.HostPort(net.JoinHostPort(host, fmt.Sprint(c.DestPort)))
+	a.Src = c.SrcAddr
+	node, user, ok := b.WhoIs(c.SrcAddr)
+	if !ok {
+		a.Funnel = true // traffic from outside of the tailnet
+		return a
+	}
+	a.Node = node.ComputedName()
+	if !node.IsTagged() {
+		a.User = user.LoginName
+	}
+	return a
+}
+
+// WatchServeAccessLog streams the serve access log, calling fn with the
+// record of each HTTP request served per the ServeConfig, until ctx is
+// done or fn returns false. Records are dropped if fn falls far behind.
+func (b *LocalBackend) WatchServeAccessLog(ctx context.Context, fn func(ipn.ServeAccess) (keepGoing bool)) {
+	records, unsubscribe := b.serveAccessLog.subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case a := <-records:
+			if !fn(a) {
+				return
+			}
+		}
+	}
+}
@@ -26,6 +26,8 @@
 
 	"github.com/tailscale/golang-x-crypto/ssh"
 	"go4.org/mem"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/ssh/sshrec"
 	"tailscale.com/tailcfg"
 	"tailscale.com/util/lineread"
 	"tailscale.com/util/mak"
@@ -217,3 +219,14 @@ func (b *LocalBackend) getSSHHostKeyPublicStrings() (ret []string) {
 	}
 	return ret
 }
+
+// SSHRecordings returns the Tailscale SSH session recordings kept on
+// local disk, oldest first. If verify, the recordings are checked
+// against the hashes made when they were finished.
+func (b *LocalBackend) SSHRecordings(verify bool) ([]ipnstate.SSHRecording, error) {
+	st, err := sshrec.FromEnv(b.TailscaleVarRoot(), b.logf)
+	if err != nil {
+		return nil, err
+	}
+	return st.List(verify)
+}
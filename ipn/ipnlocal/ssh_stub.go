@@ -8,6 +8,7 @@
 import (
 	"errors"
 
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
 )
 
@@ -18,3 +19,7 @@ func (b *LocalBackend) getSSHHostKeyPublicStrings() []string {
 func (b *LocalBackend) getSSHUsernames(*tailcfg.C2NSSHUsernamesRequest) (*tailcfg.C2NSSHUsernamesResponse, error) {
 	return nil, errors.New("not implemented")
 }
+
+func (b *LocalBackend) SSHRecordings(verify bool) ([]ipnstate.SSHRecording, error) {
+	return nil, errors.New("not implemented")
+}
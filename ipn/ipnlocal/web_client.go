@@ -0,0 +1,213 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ios && !android && !js && ts_include_webclient
+
+package ipnlocal
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+
+	"tailscale.com/client/tailscale"
+	"tailscale.com/client/web"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/net/netutil"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/httpm"
+)
+
+// webClientPort is the port the web client is served on, on the node's
+// Tailscale IPs or on localhost, per ipn.Prefs.WebClient.
+const webClientPort = 5252
+
+// webClientBuiltIn is whether this build includes the web client, which
+// brings in html/template and gorilla/csrf, so is only built with the
+// ts_include_webclient tag.
+const webClientBuiltIn = true
+
+// webClient is LocalBackend's built-in web UI, served per
+// ipn.Prefs.WebClient.
+type webClient struct {
+	mu      sync.Mutex
+	lc      *tailscale.LocalClient // for the server to use; nil until ConfigureWebClient
+	server  *web.Server            // nil until first request
+	mode    web.ServerMode         // of server
+	cleanup func()                 // from web.NewServer; nil if none
+
+	// localLn and localSrv serve the web client on localhost, when
+	// Prefs.WebClient is ipn.WebClientLocalhost. They're guarded by
+	// LocalBackend.mu, not mu.
+	localLn  net.Listener
+	localSrv *http.Server
+}
+
+// ConfigureWebClient sets the LocalClient the built-in web client uses
+// to reach this LocalBackend. Until it's called, the web client isn't
+// served.
+func (b *LocalBackend) ConfigureWebClient(lc *tailscale.LocalClient) {
+	b.webClient.mu.Lock()
+	defer b.webClient.mu.Unlock()
+	b.webClient.lc = lc
+}
+
+// webClientGetOrInit returns the web client server in the given mode,
+// creating it if needed. A server in another mode is replaced, so
+// browser sessions don't carry over between modes.
+func (b *LocalBackend) webClientGetOrInit(mode web.ServerMode) (*web.Server, error) {
+	b.webClient.mu.Lock()
+	defer b.webClient.mu.Unlock()
+	if b.webClient.server != nil && b.webClient.mode == mode {
+		return b.webClient.server, nil
+	}
+	if b.webClient.lc == nil {
+		return nil, errors.New("web client not configured")
+	}
+	b.webClientShutdownLocked()
+	b.logf("webclient: starting in %q mode", mode)
+	b.webClient.server, b.webClient.cleanup = web.NewServer(web.ServerOpts{
+		Mode:        mode,
+		LocalClient: b.webClient.lc,
+		TimeNow:     b.clock.Now,
+	})
+	b.webClient.mode = mode
+	return b.webClient.server, nil
+}
+
+// webClientShutdown releases the web client server, if any.
+func (b *LocalBackend) webClientShutdown() {
+	b.webClient.mu.Lock()
+	defer b.webClient.mu.Unlock()
+	b.webClientShutdownLocked()
+}
+
+// webClientShutdownLocked releases the web client server, if any.
+//
+// b.webClient.mu must be held.
+func (b *LocalBackend) webClientShutdownLocked() {
+	if b.webClient.server == nil {
+		return
+	}
+	b.logf("webclient: shutting down")
+	if b.webClient.cleanup != nil {
+		b.webClient.cleanup()
+	}
+	b.webClient.server = nil
+	b.webClient.cleanup = nil
+}
+
+// shouldServeWebClient reports whether the web client should be served
+// on the node's Tailscale IPs to the peer at src.
+func (b *LocalBackend) shouldServeWebClient(src netip.Addr) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prefs := b.pm.CurrentPrefs()
+	if !prefs.Valid() || prefs.WebClient() != ipn.WebClientTailnet {
+		return false
+	}
+	return b.peerCapsLocked(src).HasCapability(tailcfg.PeerCapabilityWebUI)
+}
+
+// handleWebClientConn serves the web client over c, a connection to
+// one of the node's Tailscale IPs.
+func (b *LocalBackend) handleWebClientConn(c net.Conn) error {
+	s, err := b.webClientGetOrInit(web.ManageServerMode)
+	if err != nil {
+		c.Close()
+		return err
+	}
+	hs := &http.Server{Handler: s}
+	return hs.Serve(netutil.NewOneConnListener(c, nil))
+}
+
+// updateWebClientLocked starts or stops serving the web client on
+// localhost, and releases the web client when it's not served at all,
+// per mode, a value of ipn.Prefs.WebClient.
+//
+// b.mu must be held.
+func (b *LocalBackend) updateWebClientLocked(mode string) {
+	wc := &b.webClient
+	if mode == ipn.WebClientLocalhost && wc.localLn == nil {
+		ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(webClientPort)))
+		if err != nil {
+			b.logf("webclient: %v", err)
+		} else {
+			b.logf("webclient: serving on http://%v/", ln.Addr())
+			wc.localLn = ln
+			wc.localSrv = &http.Server{
+				Handler:     http.HandlerFunc(b.serveWebClientLocal),
+				ConnContext: webClientConnContext,
+			}
+			go wc.localSrv.Serve(ln)
+		}
+	}
+	if mode != ipn.WebClientLocalhost && wc.localLn != nil {
+		wc.localSrv.Close()
+		wc.localLn = nil
+		wc.localSrv = nil
+	}
+	if mode == "" {
+		// Not while holding b.mu: creating the server calls LocalAPI.
+		go b.webClientShutdown()
+	}
+}
+
+// webClientUIDKey is the context key for the local user ID owning a
+// connection to the web client on localhost, if known.
+type webClientUIDKey struct{}
+
+// webClientConnContext returns ctx with the local user ID owning c, a
+// connection to the web client on localhost, if it can be determined.
+func webClientConnContext(ctx context.Context, c net.Conn) context.Context {
+	if uid, ok := ipnauth.LoopbackUID(c); ok {
+		return context.WithValue(ctx, webClientUIDKey{}, uid)
+	}
+	return ctx
+}
+
+// serveWebClientLocal serves the web client on localhost.
+//
+// Requests are authorized as the local user making them: users who
+// can't change the node's prefs over LocalAPI can only view it, and
+// connections whose user can't be determined are refused. On top of
+// that, the web client requires an authenticated browser session for
+// changes.
+func (b *LocalBackend) serveWebClientLocal(w http.ResponseWriter, r *http.Request) {
+	if host, _, err := net.SplitHostPort(r.Host); err != nil || !isLoopbackHost(host) {
+		// Guard against DNS rebinding.
+		http.Error(w, "invalid Host; use localhost", http.StatusForbidden)
+		return
+	}
+	uid, ok := r.Context().Value(webClientUIDKey{}).(string)
+	if !ok {
+		http.Error(w, "can't determine the local user making the request", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET && r.Method != httpm.HEAD && ipnauth.IsReadonlyUID(uid, b.OperatorUserID(), logger.Discard) {
+		http.Error(w, "only root, the operator or an admin can manage this node", http.StatusForbidden)
+		return
+	}
+	s, err := b.webClientGetOrInit(web.LocalManageServerMode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	s.ServeHTTP(w, r)
+}
+
+// isLoopbackHost reports whether host, from an HTTP Host header, names
+// the loopback interface.
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
+}
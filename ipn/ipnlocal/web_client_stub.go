@@ -0,0 +1,38 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build ios || android || js || !ts_include_webclient
+
+package ipnlocal
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+
+	"tailscale.com/client/tailscale"
+)
+
+const webClientPort = 5252
+
+// webClientBuiltIn is whether this build includes the web client.
+const webClientBuiltIn = false
+
+// webClient is empty in builds without the web client.
+type webClient struct{}
+
+// ConfigureWebClient does nothing in builds without the web client.
+func (b *LocalBackend) ConfigureWebClient(lc *tailscale.LocalClient) {}
+
+func (b *LocalBackend) shouldServeWebClient(src netip.Addr) bool { return false }
+
+func (b *LocalBackend) handleWebClientConn(c net.Conn) error {
+	c.Close()
+	return errors.New("web client not supported in this build")
+}
+
+func (b *LocalBackend) updateWebClientLocked(mode string) {
+	if mode != "" {
+		b.logf("webclient: not supported in this build")
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ios && !android && !js && ts_include_webclient
+
+package ipnlocal
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestServeWebClientLocal(t *testing.T) {
+	b := newTestLocalBackend(t)
+	// Not configured, so requests that pass authorization fail with
+	// StatusServiceUnavailable.
+	const passed = http.StatusServiceUnavailable
+
+	tests := []struct {
+		name   string
+		method string
+		host   string
+		uid    string // empty for unknown
+		want   int
+	}{
+		{"root_post", "POST", "localhost:5252", "0", passed},
+		{"root_post_ip", "POST", "127.0.0.1:5252", "0", passed},
+		{"user_get", "GET", "localhost:5252", "12345", passed},
+		{"user_post", "POST", "localhost:5252", "12345", http.StatusForbidden},
+		{"unknown_user_get", "GET", "localhost:5252", "", http.StatusForbidden},
+		{"rebound_host", "GET", "evil.example.com:5252", "0", http.StatusForbidden},
+		{"no_port", "GET", "localhost", "0", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/data", nil)
+			r.Host = tt.host
+			if tt.uid != "" {
+				r = r.WithContext(context.WithValue(r.Context(), webClientUIDKey{}, tt.uid))
+			}
+			w := httptest.NewRecorder()
+			b.serveWebClientLocal(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %v; want %v; body: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestWebClientConnContext(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("local users of loopback connections are only known on Linux")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uid, _ := r.Context().Value(webClientUIDKey{}).(string)
+			io.WriteString(w, uid)
+		}),
+		ConnContext: webClientConnContext,
+	}
+	go hs.Serve(ln)
+	defer hs.Close()
+
+	res, err := http.Get("http://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	got, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strconv.Itoa(os.Getuid()); string(got) != want {
+		t.Errorf("uid = %q; want %q", got, want)
+	}
+}
@@ -11,6 +11,7 @@
 	"io"
 	"net"
 	"net/http"
+	"os"
 	"os/user"
 	"strconv"
 	"strings"
@@ -202,6 +203,7 @@ func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
 		lah := localapi.NewHandler(lb, s.logf, s.netMon, s.backendLogID)
 		lah.PermitRead, lah.PermitWrite = s.localAPIPermissions(ci)
 		lah.PermitCert = s.connCanFetchCerts(ci)
+		lah.PermitFilePath = s.connCanPlaceFiles(ci)
 		lah.ServeHTTP(w, r)
 		return
 	}
@@ -341,6 +343,20 @@ func (s *Server) connCanFetchCerts(ci *ipnauth.ConnIdentity) bool {
 	return false
 }
 
+// connCanPlaceFiles reports whether ci may have received Taildrop files
+// moved to a path of its choosing. tailscaled creates those files with
+// its own privileges, so this is only true on Unix for connections from
+// root or from the user tailscaled runs as.
+func (s *Server) connCanPlaceFiles(ci *ipnauth.ConnIdentity) bool {
+	if ci.IsUnixSock() && ci.Creds() != nil {
+		connUID, ok := ci.Creds().UserID()
+		if ok && (connUID == "0" || connUID == strconv.Itoa(os.Getuid())) {
+			return true
+		}
+	}
+	return false
+}
+
 // addActiveHTTPRequest adds c to the server's list of active HTTP requests.
 //
 // If the returned error may be of type inUseOtherUserError.
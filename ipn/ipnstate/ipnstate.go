@@ -147,6 +147,62 @@ type NetworkLockUpdate struct {
 	Raw []byte
 }
 
+// NetworkLockSigningRequest is a request to sign node keys with a tailnet
+// lock key. It's exported from a node that can't sign, to be signed on a
+// signing node that's offline.
+type NetworkLockSigningRequest struct {
+	Keys []NetworkLockSigningRequestKey
+}
+
+// NetworkLockSigningRequestKey is a node key to sign.
+type NetworkLockSigningRequestKey struct {
+	NodeKey key.NodePublic
+
+	// RotationPublic is the public key that the node may use to rotate
+	// its node key without another signature, if any.
+	RotationPublic []byte `json:",omitempty"`
+}
+
+// NetworkLockSignature is the result of signing a node key with a tailnet
+// lock key.
+type NetworkLockSignature struct {
+	NodeKey key.NodePublic
+
+	// Signature is the serialized tka.NodeKeySignature, if signing
+	// succeeded.
+	Signature []byte `json:",omitempty"`
+
+	// Submitted is whether the signature was submitted to the
+	// coordination server. Signatures made offline aren't.
+	Submitted bool `json:",omitempty"`
+
+	// Error is why the key couldn't be signed or the signature couldn't
+	// be submitted, if so.
+	Error string `json:",omitempty"`
+}
+
+// NetworkLockSignAuditEntry is an entry in the audit trail of the node
+// key signatures made or submitted by a node.
+type NetworkLockSignAuditEntry struct {
+	Time time.Time
+
+	// Action is "sign" for a signature made and submitted, "sign-offline"
+	// for one made but not submitted, or "submit" for one made elsewhere
+	// and submitted.
+	Action string
+
+	NodeKey key.NodePublic
+
+	// KeyID is the ID of the tailnet lock key that made the signature.
+	KeyID []byte `json:",omitempty"`
+
+	// SigHash is the hex-encoded hash of the signature.
+	SigHash string `json:",omitempty"`
+
+	// Error is why the action failed, if it did.
+	Error string `json:",omitempty"`
+}
+
 // TailnetStatus is information about a Tailscale network ("tailnet").
 type TailnetStatus struct {
 	// Name is the name of the network that's currently in use.
@@ -230,6 +286,23 @@ type PeerStatus struct {
 	CurAddr string // one of Addrs, or unique if roaming
 	Relay   string // DERP region
 
+	// PathStats is the recently measured quality of each kind of path to
+	// the peer that has been probed.
+	PathStats []PathStats `json:",omitempty"`
+
+	// EndpointPin, if non-empty, is the fixed path the peer's traffic is
+	// pinned to by Prefs.EndpointPins: "derp-only", "direct-only" or an
+	// "ip:port".
+	EndpointPin string `json:",omitempty"`
+
+	// RxPackets and TxPackets are the WireGuard packets received from
+	// and sent to the peer. RxBytesPerSec and TxBytesPerSec are the
+	// rates RxBytes and TxBytes grew at recently.
+	RxPackets     int64   `json:",omitempty"`
+	TxPackets     int64   `json:",omitempty"`
+	RxBytesPerSec float64 `json:",omitempty"`
+	TxBytesPerSec float64 `json:",omitempty"`
+
 	RxBytes        int64
 	TxBytes        int64
 	Created        time.Time // time registered with tailcontrol
@@ -287,11 +360,21 @@ type PeerStatus struct {
 	// expiration time has passed.
 	Expired bool `json:",omitempty"`
 
+	// Quarantined means that the packet filter drops all traffic to and
+	// from this peer, including its subnet routes, because its node key
+	// has expired. The peer remains in the status so that the reason can
+	// be shown.
+	Quarantined bool `json:",omitempty"`
+
 	// KeyExpiry, if present, is the time at which the node key expired or
 	// will expire.
 	KeyExpiry *time.Time `json:",omitempty"`
 
 	Location *tailcfg.Location `json:",omitempty"`
+
+	// Metadata is the node's key/value metadata from Hostinfo.Metadata,
+	// such as its rack, region or owning team.
+	Metadata map[string]string `json:",omitempty"`
 }
 
 // HasCap reports whether ps has the given capability.
@@ -422,18 +505,39 @@ func (sb *StatusBuilder) AddPeer(peer key.NodePublic, st *PeerStatus) {
 	if v := st.SSH_HostKeys; v != nil {
 		e.SSH_HostKeys = v
 	}
+	if v := st.Metadata; v != nil {
+		e.Metadata = v
+	}
 	if v := st.Addrs; v != nil {
 		e.Addrs = v
 	}
 	if v := st.CurAddr; v != "" {
 		e.CurAddr = v
 	}
+	if v := st.PathStats; v != nil {
+		e.PathStats = v
+	}
+	if v := st.EndpointPin; v != "" {
+		e.EndpointPin = v
+	}
 	if v := st.RxBytes; v != 0 {
 		e.RxBytes = v
 	}
 	if v := st.TxBytes; v != 0 {
 		e.TxBytes = v
 	}
+	if v := st.RxPackets; v != 0 {
+		e.RxPackets = v
+	}
+	if v := st.TxPackets; v != 0 {
+		e.TxPackets = v
+	}
+	if v := st.RxBytesPerSec; v != 0 {
+		e.RxBytesPerSec = v
+	}
+	if v := st.TxBytesPerSec; v != 0 {
+		e.TxBytesPerSec = v
+	}
 	if v := st.LastHandshake; !v.IsZero() {
 		e.LastHandshake = v
 	}
@@ -476,6 +580,9 @@ func (sb *StatusBuilder) AddPeer(peer key.NodePublic, st *PeerStatus) {
 	if st.Expired {
 		e.Expired = true
 	}
+	if st.Quarantined {
+		e.Quarantined = true
+	}
 	if t := st.KeyExpiry; t != nil {
 		e.KeyExpiry = ptr.To(*t)
 	}
@@ -621,6 +728,92 @@ func osEmoji(os string) string {
 	return "👽"
 }
 
+// PathStats is the quality of one kind of path to a peer, measured by
+// the disco pings recently sent along it.
+type PathStats struct {
+	// Path is the kind of path: "direct4" or "direct6" for direct UDP
+	// over IPv4 or IPv6, or "derp" for relayed.
+	Path string
+
+	// Pings is how many pings the stats are from.
+	Pings int
+
+	// RTTSeconds is the mean round-trip time of the pings that got
+	// replies, and MinRTTSeconds the shortest. They're zero if none did.
+	RTTSeconds    float64 `json:",omitempty"`
+	MinRTTSeconds float64 `json:",omitempty"`
+
+	// Loss is the fraction, from 0 to 1, of the pings that got no reply.
+	Loss float64
+}
+
+// FailoverEvent describes the node moving its traffic to a new network
+// interface after a link change, such as from Wi-Fi to cellular or when
+// a cable is unplugged.
+type FailoverEvent struct {
+	// When is when the link change was handled.
+	When time.Time
+
+	// OldInterface and NewInterface are the names of the interfaces
+	// with the default route before and after the change. Either is
+	// empty if there was none.
+	OldInterface string `json:",omitempty"`
+	NewInterface string `json:",omitempty"`
+
+	// PeersProbed is how many peers with active sessions had their paths
+	// immediately re-probed over the new interface.
+	PeersProbed int
+
+	// Duration is how long rebinding the sockets and sending the probes
+	// took.
+	Duration time.Duration
+}
+
+// PeerTraffic is the WireGuard traffic to and from a peer, as returned
+// by the LocalAPI's peer-traffic endpoint.
+type PeerTraffic struct {
+	NodeKey key.NodePublic
+	DNSName string
+	IP      netip.Addr `json:",omitempty"` // the peer's first Tailscale IP
+
+	TxBytes, RxBytes     int64
+	TxPackets, RxPackets int64
+
+	// TxBytesPerSec and RxBytesPerSec are the recent rates of traffic.
+	TxBytesPerSec, RxBytesPerSec float64
+
+	// Path is how traffic to the peer currently goes: "direct" or
+	// "derp", or empty if there's been none recently.
+	Path string `json:",omitempty"`
+
+	// LatencySeconds is the mean round-trip time of the recent disco
+	// pings over Path, or zero if unknown.
+	LatencySeconds float64 `json:",omitempty"`
+
+	// Flows is how many new flows to or from the peer the packet filter
+	// accepted in the last minute. It's only counted once the filter's
+	// flow log has been started, such as by watching peer traffic.
+	Flows int `json:",omitempty"`
+}
+
+// SSHRecording is a Tailscale SSH session recording kept on local disk,
+// as returned by the LocalAPI's ssh-recordings endpoint.
+type SSHRecording struct {
+	Name    string    // file name, in the asciinema cast format
+	Start   time.Time // when the session started
+	ModTime time.Time // when the recording was last written
+	Size    int64
+
+	// SHA256 is the hex SHA-256 hash of the recording, made when it was
+	// finished. It's empty while the session is still in progress.
+	SHA256 string `json:",omitempty"`
+
+	// VerifyError, if non-empty, is why the recording doesn't match
+	// SHA256, such as because it was modified. It's only set when
+	// recordings are verified.
+	VerifyError string `json:",omitempty"`
+}
+
 // PingResult contains response information for the "tailscale ping" subcommand,
 // saying how Tailscale can reach a Tailscale IP or subnet-routed IP.
 // See tailcfg.PingResponse for a related response that is sent back to control
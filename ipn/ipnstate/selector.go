@@ -0,0 +1,136 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnstate
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/views"
+)
+
+// selectorKeys are the keys of the terms of a PeerSelector.
+var selectorKeys = []string{"tag", "user", "os", "online"}
+
+// PeerSelector selects peers by their labels, such as their tags or OS.
+//
+// It's written as terms of the form "key:value", separated by spaces or
+// commas, such as "tag:prod os:linux". A peer matches if, for each key,
+// it matches any of the terms with that key. The keys are:
+//
+//   - tag: the peer has the tag, with or without its "tag:" prefix
+//   - user: the peer's owner has the login name, or the login name
+//     before its "@"
+//   - os: the peer's OS, such as "linux", ignoring case
+//   - online: whether the peer is connected to control, "true" or "false"
+//
+// The zero value matches all peers.
+type PeerSelector struct {
+	terms map[string][]string // by key
+	str   string
+}
+
+// ParsePeerSelector parses s as a PeerSelector.
+func ParsePeerSelector(s string) (*PeerSelector, error) {
+	sel := &PeerSelector{terms: map[string][]string{}}
+	var norm []string
+	for _, term := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' }) {
+		key, value, ok := strings.Cut(term, ":")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid selector term %q; want key:value", term)
+		}
+		key = strings.ToLower(key)
+		if !slices.Contains(selectorKeys, key) {
+			return nil, fmt.Errorf("unknown selector key %q; want one of %s", key, strings.Join(selectorKeys, ", "))
+		}
+		switch key {
+		case "tag":
+			value = strings.TrimPrefix(value, "tag:")
+		case "os":
+			value = strings.ToLower(value)
+		case "online":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid selector term %q; want online:true or online:false", term)
+			}
+			value = strconv.FormatBool(b)
+		}
+		sel.terms[key] = append(sel.terms[key], value)
+		norm = append(norm, key+":"+value)
+	}
+	sel.str = strings.Join(norm, " ")
+	return sel, nil
+}
+
+// LooksLikePeerSelector reports whether s is probably meant as a
+// PeerSelector rather than a hostname or IP address: whether it starts
+// with one of the selector keys and a colon.
+func LooksLikePeerSelector(s string) bool {
+	key, _, ok := strings.Cut(s, ":")
+	return ok && slices.Contains(selectorKeys, strings.ToLower(key))
+}
+
+// String returns the selector in its canonical form.
+func (sel *PeerSelector) String() string {
+	if sel == nil {
+		return ""
+	}
+	return sel.str
+}
+
+// IsEmpty reports whether sel matches all peers.
+func (sel *PeerSelector) IsEmpty() bool {
+	return sel == nil || len(sel.terms) == 0
+}
+
+// Match reports whether sel matches ps, whose owner is looked up in
+// users.
+func (sel *PeerSelector) Match(ps *PeerStatus, users map[tailcfg.UserID]tailcfg.UserProfile) bool {
+	if sel.IsEmpty() {
+		return true
+	}
+	for key, values := range sel.terms {
+		if !slices.ContainsFunc(values, func(v string) bool { return matchTerm(key, v, ps, users) }) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchTerm(key, value string, ps *PeerStatus, users map[tailcfg.UserID]tailcfg.UserProfile) bool {
+	switch key {
+	case "tag":
+		return ps.Tags != nil && views.SliceContains(*ps.Tags, "tag:"+value)
+	case "user":
+		if ps.Tags != nil && ps.Tags.Len() > 0 {
+			return false // tagged nodes aren't owned by a user
+		}
+		up, ok := users[ps.UserID]
+		if !ok {
+			return false
+		}
+		name, _, _ := strings.Cut(up.LoginName, "@")
+		return strings.EqualFold(up.LoginName, value) || strings.EqualFold(name, value)
+	case "os":
+		return strings.ToLower(ps.OS) == value
+	case "online":
+		return strconv.FormatBool(ps.Online) == value
+	}
+	return false
+}
+
+// FilterPeers removes the peers that sel doesn't match from st.
+func (st *Status) FilterPeers(sel *PeerSelector) {
+	if sel.IsEmpty() {
+		return
+	}
+	for k, ps := range st.Peer {
+		if !sel.Match(ps, st.User) {
+			delete(st.Peer, k)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnstate
+
+import (
+	"slices"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/views"
+)
+
+func TestPeerSelector(t *testing.T) {
+	tags := func(tt ...string) *views.Slice[string] {
+		v := views.SliceOf(tt)
+		return &v
+	}
+	st := &Status{
+		User: map[tailcfg.UserID]tailcfg.UserProfile{
+			1: {LoginName: "alice@example.com"},
+			2: {LoginName: "bob@example.com"},
+		},
+		Peer: map[key.NodePublic]*PeerStatus{},
+	}
+	peers := map[string]*PeerStatus{
+		"alice-laptop": {OS: "macOS", UserID: 1, Online: true},
+		"alice-server": {OS: "linux", UserID: 1},
+		"bob-laptop":   {OS: "windows", UserID: 2, Online: true},
+		"prod-db":      {OS: "linux", UserID: 1, Tags: tags("tag:prod", "tag:db"), Online: true},
+		"staging-db":   {OS: "linux", UserID: 1, Tags: tags("tag:staging", "tag:db")},
+	}
+	for name, ps := range peers {
+		ps.HostName = name
+		st.Peer[key.NewNode().Public()] = ps
+	}
+
+	tests := []struct {
+		sel     string
+		want    []string
+		wantErr bool
+	}{
+		{sel: "", want: []string{"alice-laptop", "alice-server", "bob-laptop", "prod-db", "staging-db"}},
+		{sel: "tag:prod", want: []string{"prod-db"}},
+		{sel: "tag:tag:db", want: []string{"prod-db", "staging-db"}},
+		{sel: "tag:prod tag:staging", want: []string{"prod-db", "staging-db"}},
+		{sel: "user:alice os:linux", want: []string{"alice-server"}},
+		{sel: "user:BOB@example.com", want: []string{"bob-laptop"}},
+		{sel: "os:Linux,online:true", want: []string{"prod-db"}},
+		{sel: "online:false", want: []string{"alice-server", "staging-db"}},
+		{sel: "tag:nope", want: nil},
+		{sel: "color:blue", wantErr: true},
+		{sel: "tag:", wantErr: true},
+		{sel: "prod", wantErr: true},
+		{sel: "online:maybe", wantErr: true},
+	}
+	for _, tt := range tests {
+		sel, err := ParsePeerSelector(tt.sel)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePeerSelector(%q) error = %v; want error: %v", tt.sel, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var got []string
+		for _, ps := range st.Peer {
+			if sel.Match(ps, st.User) {
+				got = append(got, ps.HostName)
+			}
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q matched %q; want %q", tt.sel, got, tt.want)
+		}
+	}
+}
+
+func TestLooksLikePeerSelector(t *testing.T) {
+	for s, want := range map[string]bool{
+		"tag:prod":       true,
+		"OS:linux":       true,
+		"online:true":    true,
+		"myhost":         false,
+		"100.64.0.1":     false,
+		"fd7a:115c::1":   false,
+		"myhost.ts.net":  false,
+		"example.com:80": false,
+	} {
+		if got := LooksLikePeerSelector(s); got != want {
+			t.Errorf("LooksLikePeerSelector(%q) = %v; want %v", s, got, want)
+		}
+	}
+}
@@ -26,6 +26,7 @@
 	"time"
 
 	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/control/controlclient"
 	"tailscale.com/envknob"
 	"tailscale.com/health"
 	"tailscale.com/hostinfo"
@@ -40,6 +41,7 @@
 	"tailscale.com/taildrop"
 	"tailscale.com/tka"
 	"tailscale.com/tstime"
+	"tailscale.com/types/appctype"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
@@ -51,7 +53,10 @@
 	"tailscale.com/util/mak"
 	"tailscale.com/util/osdiag"
 	"tailscale.com/util/rands"
+	"tailscale.com/util/set"
 	"tailscale.com/version"
+	"tailscale.com/wgengine/capture"
+	"tailscale.com/wgengine/filter"
 	"tailscale.com/wgengine/magicsock"
 )
 
@@ -69,44 +74,68 @@
 
 	// The other /localapi/v0/NAME handlers are exact matches and contain only NAME
 	// without a trailing slash:
+	"app-connector-config":        (*Handler).serveAppConnectorConfig,
+	"app-connector-drains":        (*Handler).serveAppConnectorDrains,
+	"app-connector-status":        (*Handler).serveAppConnectorStatus,
+	"auth-tokens":                 (*Handler).serveAuthTokens,
+	"auto-exit-node":              (*Handler).serveAutoExitNode,
 	"bugreport":                   (*Handler).serveBugReport,
 	"check-ip-forwarding":         (*Handler).serveCheckIPForwarding,
 	"check-prefs":                 (*Handler).serveCheckPrefs,
 	"component-debug-logging":     (*Handler).serveComponentDebugLogging,
+	"conn-approvals":              (*Handler).serveConnApprovals,
 	"debug":                       (*Handler).serveDebug,
 	"debug-derp-region":           (*Handler).serveDebugDERPRegion,
+	"debug-flows":                 (*Handler).serveDebugFlows,
 	"debug-packet-filter-matches": (*Handler).serveDebugPacketFilterMatches,
 	"debug-packet-filter-rules":   (*Handler).serveDebugPacketFilterRules,
 	"debug-portmap":               (*Handler).serveDebugPortmap,
+	"debug-portmap-leases":        (*Handler).serveDebugPortmapLeases,
 	"debug-peer-endpoint-changes": (*Handler).serveDebugPeerEndpointChanges,
 	"debug-capture":               (*Handler).serveDebugCapture,
+	"debug-derp-race":             (*Handler).serveDebugDERPRace,
 	"debug-log":                   (*Handler).serveDebugLog,
+	"debug-map-log-level":         (*Handler).serveDebugMapLogLevel,
+	"debug-map-traces":            (*Handler).serveDebugMapTraces,
+	"debug-netcheck-history":      (*Handler).serveDebugNetcheckHistory,
+	"debug-netmap-diff":           (*Handler).serveDebugNetMapDiff,
 	"debug-web-client":            (*Handler).serveDebugWebClient,
 	"derpmap":                     (*Handler).serveDERPMap,
 	"dev-set-state-store":         (*Handler).serveDevSetStateStore,
 	"set-push-device-token":       (*Handler).serveSetPushDeviceToken,
 	"dial":                        (*Handler).serveDial,
+	"dns-cache-flush":             (*Handler).serveDNSCacheFlush,
+	"dns-osconfig":                (*Handler).serveDNSOSConfig,
+	"dns-query":                   (*Handler).serveDNSQuery,
+	"doctor-bundle":               (*Handler).serveDoctorBundle,
+	"file-offers":                 (*Handler).serveFileOffers,
 	"file-targets":                (*Handler).serveFileTargets,
 	"goroutines":                  (*Handler).serveGoroutines,
+	"health":                      (*Handler).serveHealth,
 	"id-token":                    (*Handler).serveIDToken,
 	"login-interactive":           (*Handler).serveLoginInteractive,
 	"logout":                      (*Handler).serveLogout,
 	"logtap":                      (*Handler).serveLogTap,
 	"metrics":                     (*Handler).serveMetrics,
+	"peer-traffic":                (*Handler).servePeerTraffic,
 	"ping":                        (*Handler).servePing,
 	"prefs":                       (*Handler).servePrefs,
 	"pprof":                       (*Handler).servePprof,
 	"reload-config":               (*Handler).reloadConfig,
 	"reset-auth":                  (*Handler).serveResetAuth,
+	"serve-access-log":            (*Handler).serveServeAccessLog,
 	"serve-config":                (*Handler).serveServeConfig,
 	"set-dns":                     (*Handler).serveSetDNS,
 	"set-expiry-sooner":           (*Handler).serveSetExpirySooner,
+	"ssh-recordings":              (*Handler).serveSSHRecordings,
 	"start":                       (*Handler).serveStart,
 	"status":                      (*Handler).serveStatus,
 	"tka/init":                    (*Handler).serveTKAInit,
 	"tka/log":                     (*Handler).serveTKALog,
 	"tka/modify":                  (*Handler).serveTKAModify,
 	"tka/sign":                    (*Handler).serveTKASign,
+	"tka/sign-audit":              (*Handler).serveTKASignAudit,
+	"tka/sign-batch":              (*Handler).serveTKASignBatch,
 	"tka/status":                  (*Handler).serveTKAStatus,
 	"tka/disable":                 (*Handler).serveTKADisable,
 	"tka/force-local-disable":     (*Handler).serveTKALocalDisable,
@@ -116,12 +145,54 @@
 	"tka/generate-recovery-aum":   (*Handler).serveTKAGenerateRecoveryAUM,
 	"tka/cosign-recovery-aum":     (*Handler).serveTKACosignRecoveryAUM,
 	"tka/submit-recovery-aum":     (*Handler).serveTKASubmitRecoveryAUM,
+	"tka/submit-signatures":       (*Handler).serveTKASubmitSignatures,
 	"upload-client-metrics":       (*Handler).serveUploadClientMetrics,
 	"watch-ipn-bus":               (*Handler).serveWatchIPNBus,
 	"whois":                       (*Handler).serveWhoIs,
 	"query-feature":               (*Handler).serveQueryFeature,
 }
 
+// tokenScopeHandlers are the handlers, keyed like handler, that requests
+// made with a LocalAPI access token of a scope other than
+// apitype.LocalAPIScopeAdmin may use. A LocalAPIScopeServe token may also
+// use those of LocalAPIScopeStatus.
+var tokenScopeHandlers = map[apitype.LocalAPIScope]set.Set[string]{
+	apitype.LocalAPIScopeStatus: set.SetOf([]string{
+		"app-connector-status",
+		"auto-exit-node",
+		"conn-approvals",
+		"derpmap",
+		"file-targets",
+		"health",
+		"metrics",
+		"peer-traffic",
+		"prefs",
+		"status",
+		"tka/status",
+		"watch-ipn-bus",
+		"whois",
+	}),
+	apitype.LocalAPIScopeServe: set.SetOf([]string{
+		"cert/",
+		"query-feature",
+		"serve-access-log",
+		"serve-config",
+	}),
+}
+
+// tokenScopePermits reports whether a request made with a LocalAPI access
+// token of the given scope may use the handler named name.
+func tokenScopePermits(scope apitype.LocalAPIScope, name string) bool {
+	switch scope {
+	case apitype.LocalAPIScopeAdmin:
+		return true
+	case apitype.LocalAPIScopeServe:
+		return tokenScopeHandlers[apitype.LocalAPIScopeServe].Contains(name) ||
+			tokenScopeHandlers[apitype.LocalAPIScopeStatus].Contains(name)
+	}
+	return tokenScopeHandlers[scope].Contains(name)
+}
+
 var (
 	// The clientmetrics package is stateful, but we want to expose a simple
 	// imperative API to local clients, so we need to keep track of
@@ -157,6 +228,19 @@ type Handler struct {
 	// cert fetching access.
 	PermitCert bool
 
+	// PermitServe is whether the client is additionally granted
+	// access to change the serve config.
+	PermitServe bool
+
+	// PermitFilePath is whether the client may have accepted Taildrop
+	// files moved to a path of its choosing, which tailscaled creates
+	// with its own privileges.
+	PermitFilePath bool
+
+	// tokenScope is the scope of the LocalAPI access token the request
+	// was made with, or empty if none.
+	tokenScope apitype.LocalAPIScope
+
 	b            *ipnlocal.LocalBackend
 	logf         logger.Logf
 	netMon       *netmon.Monitor // optional; nil means interfaces will be looked up on-demand
@@ -192,11 +276,35 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	if fn, ok := handlerForPath(r.URL.Path); ok {
-		fn(h, w, r)
-	} else {
+	if tok := r.Header.Get(apitype.LocalAPITokenHeader); tok != "" {
+		scope, ok := h.b.LocalAPITokenScope(tok)
+		if !ok {
+			metricInvalidRequests.Add(1)
+			http.Error(w, "invalid LocalAPI token", http.StatusUnauthorized)
+			return
+		}
+		h.setTokenScope(scope)
+	}
+	name, fn, ok := handlerForPath(r.URL.Path)
+	if !ok {
 		http.NotFound(w, r)
+		return
 	}
+	if h.tokenScope != "" && name != "" && !tokenScopePermits(h.tokenScope, name) {
+		http.Error(w, fmt.Sprintf("%s access denied for LocalAPI token scope %q", name, h.tokenScope), http.StatusForbidden)
+		return
+	}
+	fn(h, w, r)
+}
+
+// setTokenScope replaces h's permissions with those of a LocalAPI access
+// token of the given scope.
+func (h *Handler) setTokenScope(scope apitype.LocalAPIScope) {
+	h.tokenScope = scope
+	h.PermitRead = true
+	h.PermitWrite = scope == apitype.LocalAPIScopeAdmin
+	h.PermitCert = scope != apitype.LocalAPIScopeStatus
+	h.PermitServe = scope != apitype.LocalAPIScopeStatus
 }
 
 // validLocalHostForTesting allows loopback handlers without RequiredPassword for testing.
@@ -226,11 +334,12 @@ func (h *Handler) validHost(hostname string) bool {
 	return addr.IsLoopback()
 }
 
-// handlerForPath returns the LocalAPI handler for the provided Request.URI.Path.
+// handlerForPath returns the LocalAPI handler for the provided Request.URI.Path,
+// and its key in the handler map, or empty for the root handler.
 // (the path doesn't include any query parameters)
-func handlerForPath(urlPath string) (h localAPIHandler, ok bool) {
+func handlerForPath(urlPath string) (name string, h localAPIHandler, ok bool) {
 	if urlPath == "/" {
-		return (*Handler).serveLocalAPIRoot, true
+		return "", (*Handler).serveLocalAPIRoot, true
 	}
 	suff, ok := strings.CutPrefix(urlPath, "/localapi/v0/")
 	if !ok {
@@ -238,22 +347,22 @@ func handlerForPath(urlPath string) (h localAPIHandler, ok bool) {
 		// to people that they're not necessarily stable APIs. In practice we'll
 		// probably need to keep them pretty stable anyway, but for now treat
 		// them as an internal implementation detail.
-		return nil, false
+		return "", nil, false
 	}
 	if fn, ok := handler[suff]; ok {
 		// Here we match exact handler suffixes like "status" or ones with a
 		// slash already in their name, like "tka/status".
-		return fn, true
+		return suff, fn, true
 	}
 	// Otherwise, it might be a prefix match like "files/*" which we look up
 	// by the prefix including first trailing slash.
 	if i := strings.IndexByte(suff, '/'); i != -1 {
 		suff = suff[:i+1]
 		if fn, ok := handler[suff]; ok {
-			return fn, true
+			return suff, fn, true
 		}
 	}
-	return nil, false
+	return "", nil, false
 }
 
 func (*Handler) serveLocalAPIRoot(w http.ResponseWriter, r *http.Request) {
@@ -345,6 +454,8 @@ func (h *Handler) serveBugReport(w http.ResponseWriter, r *http.Request) {
 		h.logf("user bugreport netmap: no active netmap")
 	}
 
+	h.logf("user bugreport map stats: %s", controlclient.FormatRecentMapStats())
+
 	// Print all envknobs; we otherwise only print these on startup, and
 	// printing them here ensures we don't have to go spelunking through
 	// logs for them.
@@ -477,6 +588,155 @@ func (h *Handler) serveGoroutines(w http.ResponseWriter, r *http.Request) {
 	w.Write(buf)
 }
 
+// serveHealth runs the health checks and returns their results as a
+// JSON array of health.CheckResult.
+func (h *Handler) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "health access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(health.RunChecks())
+}
+
+// serveAutoExitNode returns the latest choice of exit node made because
+// of Prefs.AutoExitNode, as an apitype.AutoExitNodeDecision.
+func (h *Handler) serveAutoExitNode(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "auto-exit-node access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	d := h.b.AutoExitNodeDecision()
+	if d == nil {
+		http.Error(w, "automatic exit node selection is off", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// serveAuthTokens manages LocalAPI access tokens. GET lists them, POST
+// creates one per the JSON apitype.CreateLocalAPITokenRequest in the body,
+// and DELETE revokes the one with the ID in the "id" query parameter.
+func (h *Handler) serveAuthTokens(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "auth-tokens access denied", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		toks, err := h.b.LocalAPITokens()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toks)
+	case "POST":
+		var req apitype.CreateLocalAPITokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		tok, info, err := h.b.CreateLocalAPIToken(req.Name, req.Scope)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(apitype.CreateLocalAPITokenResponse{Token: tok, Info: info})
+	case "DELETE":
+		if err := h.b.RevokeLocalAPIToken(r.FormValue("id")); err != nil {
+			if errors.Is(err, ipnlocal.ErrNoLocalAPIToken) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "use GET, POST or DELETE", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveConnApprovals manages the approval of incoming connections per
+// ipn.Prefs.ConnApproval. GET returns the pending connections and the
+// stored decisions, POST accepts or rejects a pending connection per the
+// JSON ipn.ConnApprovalDecision in the body, and DELETE forgets the
+// decisions about the peer with the stable node ID in the "peer" query
+// parameter.
+func (h *Handler) serveConnApprovals(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !h.PermitRead {
+			http.Error(w, "conn-approvals access denied", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.b.ConnApprovals())
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "conn-approvals access denied", http.StatusForbidden)
+			return
+		}
+		var d ipn.ConnApprovalDecision
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if err := h.b.DecideConnApproval(d); err != nil {
+			if errors.Is(err, ipnlocal.ErrNoConnApproval) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		if !h.PermitWrite {
+			http.Error(w, "conn-approvals access denied", http.StatusForbidden)
+			return
+		}
+		if err := h.b.ForgetConnApprovals(tailcfg.StableNodeID(r.FormValue("peer"))); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "use GET, POST or DELETE", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveDoctorBundle writes a support bundle.
+func (h *Handler) serveDoctorBundle(w http.ResponseWriter, r *http.Request) {
+	// Require write access (~root): bundles contain logs.
+	if !h.PermitWrite {
+		http.Error(w, "doctor-bundle access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
+	}
+	var buf bytes.Buffer
+	if err := h.b.DoctorBundle(r.Context(), &buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Write(buf.Bytes())
+}
+
 // serveLogTap taps into the tailscaled/logtail server output and streams
 // it to the client.
 func (h *Handler) serveLogTap(w http.ResponseWriter, r *http.Request) {
@@ -652,6 +912,82 @@ func (h *Handler) serveDebugPacketFilterMatches(w http.ResponseWriter, r *http.R
 	enc.Encode(nm.PacketFilter)
 }
 
+// serveDebugFlows writes the new flows seen by the packet filter and its
+// verdicts on them as JSON lines, starting to record them if it wasn't
+// already. With "?follow=true", it then streams new ones as they're seen.
+func (h *Handler) serveDebugFlows(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	follow := r.FormValue("follow") == "true"
+	fl, done, err := h.b.WatchFlowLog()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer done()
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	var ticker *time.Ticker
+	if follow {
+		ticker = time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+	}
+	var seq uint64
+	for {
+		var events []filter.FlowEvent
+		events, seq = fl.Since(seq)
+		for _, ev := range events {
+			if err := enc.Encode(ev); err != nil {
+				return
+			}
+		}
+		if !follow {
+			return
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// serveDebugNetMapDiff returns the difference between the previous and the
+// current netmap, as JSON or, with "?format=text", in human-readable form.
+func (h *Handler) serveDebugNetMapDiff(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.b.NetMap() == nil {
+		http.Error(w, "no netmap", http.StatusNotFound)
+		return
+	}
+	d := h.b.NetMapDiff()
+	if r.FormValue("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, d.String())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(d)
+}
+
 func (h *Handler) serveDebugPortmap(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "debug access denied", http.StatusForbidden)
@@ -821,6 +1157,48 @@ func (h *Handler) serveComponentDebugLogging(w http.ResponseWriter, r *http.Requ
 	json.NewEncoder(w).Encode(res)
 }
 
+// serveDebugMapLogLevel sets the verbosity of map session logging to the
+// "level" parameter for "secs" seconds, after which it reverts to the default.
+func (h *Handler) serveDebugMapLogLevel(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	var res struct {
+		Error string
+	}
+	level, err := controlclient.ParseMapLogLevel(r.FormValue("level"))
+	if err != nil {
+		res.Error = err.Error()
+	} else {
+		secs, _ := strconv.Atoi(r.FormValue("secs"))
+		h.b.SetMapSessionLogLevel(level, h.clock.Now().Add(time.Duration(secs)*time.Second))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// serveDebugMapTraces returns the latencies of MapResponses from their
+// receipt to the engine being reconfigured, as JSON.
+func (h *Handler) serveDebugMapTraces(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(controlclient.RecentMapTraces())
+}
+
 // servePprofFunc is the implementation of Handler.servePprof, after auth,
 // for platforms where we want to link it in.
 var servePprofFunc func(http.ResponseWriter, *http.Request)
@@ -853,7 +1231,6 @@ func (h *Handler) reloadConfig(w http.ResponseWriter, r *http.Request) {
 	res.Reloaded = ok
 	if err != nil {
 		res.Err = err.Error()
-		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(&res)
@@ -861,124 +1238,487 @@ func (h *Handler) reloadConfig(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) serveResetAuth(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
-		http.Error(w, "reset-auth modify access denied", http.StatusForbidden)
+		http.Error(w, "reset-auth modify access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := h.b.ResetAuth(); err != nil {
+		http.Error(w, "reset-auth failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveServeAccessLog streams the serve access log: each ipn.ServeAccess
+// record of an HTTP request served per the ServeConfig, as a line of JSON.
+func (h *Handler) serveServeAccessLog(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "serve access log denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "not a flusher", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	f.Flush()
+	h.b.WatchServeAccessLog(r.Context(), func(a ipn.ServeAccess) (keepGoing bool) {
+		js, err := json.Marshal(a)
+		if err != nil {
+			h.logf("json.Marshal: %v", err)
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", js); err != nil {
+			return false
+		}
+		f.Flush()
+		return true
+	})
+}
+
+func (h *Handler) serveServeConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !h.PermitRead {
+			http.Error(w, "serve config denied", http.StatusForbidden)
+			return
+		}
+		config := h.b.ServeConfig()
+		bts, err := json.Marshal(config)
+		if err != nil {
+			http.Error(w, "error encoding config: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sum := sha256.Sum256(bts)
+		etag := hex.EncodeToString(sum[:])
+		w.Header().Set("Etag", etag)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(bts)
+	case "POST":
+		if !h.PermitWrite && !h.PermitServe {
+			http.Error(w, "serve config denied", http.StatusForbidden)
+			return
+		}
+		configIn := new(ipn.ServeConfig)
+		if err := json.NewDecoder(r.Body).Decode(configIn); err != nil {
+			writeErrorJSON(w, fmt.Errorf("decoding config: %w", err))
+			return
+		}
+		etag := r.Header.Get("If-Match")
+		if err := h.b.SetServeConfig(configIn, etag); err != nil {
+			if errors.Is(err, ipnlocal.ErrETagMismatch) {
+				http.Error(w, err.Error(), http.StatusPreconditionFailed)
+				return
+			}
+			writeErrorJSON(w, fmt.Errorf("updating config: %w", err))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveAppConnectorConfig gets or replaces the app connector configuration.
+//
+// POST requests replace it with the JSON-encoded appctype.AppConnectorConfig
+// in the body, if the If-Match header, when present, matches the Etag of the
+// active configuration. With ?dry-run=true, the configuration is only checked.
+// Either way, the response is the JSON list of conflicting services in the
+// new configuration.
+func (h *Handler) serveAppConnectorConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !h.PermitRead {
+			http.Error(w, "app connector config denied", http.StatusForbidden)
+			return
+		}
+		cfg, etag, err := h.b.AppConnectorConfig()
+		if err != nil {
+			if errors.Is(err, ipnlocal.ErrNoAppConnector) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			writeErrorJSON(w, err)
+			return
+		}
+		w.Header().Set("Etag", etag)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cfg)
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "app connector config denied", http.StatusForbidden)
+			return
+		}
+		cfg := new(appctype.AppConnectorConfig)
+		if err := json.NewDecoder(r.Body).Decode(cfg); err != nil {
+			http.Error(w, "decoding config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dryRun := defBool(r.FormValue("dry-run"), false)
+		etag, conflicts, err := h.b.SetAppConnectorConfig(cfg, r.Header.Get("If-Match"), dryRun)
+		var verrs appctype.ValidationErrors
+		switch {
+		case errors.Is(err, ipnlocal.ErrNoAppConnector):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, ipnlocal.ErrETagMismatch):
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		case errors.Is(err, appctype.ErrUnknownExperiment), errors.As(err, &verrs):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, ipnlocal.ErrAppConnectorConflict):
+			msg := err.Error()
+			for _, c := range conflicts {
+				msg += "\n" + c.String()
+			}
+			http.Error(w, msg, http.StatusConflict)
+			return
+		case err != nil:
+			writeErrorJSON(w, err)
+			return
+		}
+		if etag != "" {
+			w.Header().Set("Etag", etag)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if conflicts == nil {
+			conflicts = []appctype.Conflict{}
+		}
+		json.NewEncoder(w).Encode(conflicts)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) serveAppConnectorStatus(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "app connector status denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	st, err := h.b.AppConnectorStatus()
+	if err != nil {
+		if errors.Is(err, ipnlocal.ErrNoAppConnector) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeErrorJSON(w, err)
+		return
+	}
+	if st == nil {
+		st = []appctype.ServiceStatus{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+func (h *Handler) serveAppConnectorDrains(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "app connector status denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	drains, err := h.b.AppConnectorDrains()
+	if err != nil {
+		if errors.Is(err, ipnlocal.ErrNoAppConnector) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeErrorJSON(w, err)
+		return
+	}
+	if drains == nil {
+		drains = []appctype.DrainStatus{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(drains)
+}
+
+func (h *Handler) serveCheckIPForwarding(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "IP forwarding check access denied", http.StatusForbidden)
+		return
+	}
+	var warning string
+	if err := h.b.CheckIPForwarding(); err != nil {
+		warning = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Warning string
+	}{
+		Warning: warning,
+	})
+}
+
+func (h *Handler) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "status access denied", http.StatusForbidden)
+		return
+	}
+	// filter, if set, is a PeerSelector of the peers to include, so that
+	// clients of large tailnets needn't fetch them all.
+	sel, err := ipnstate.ParsePeerSelector(r.FormValue("filter"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	var st *ipnstate.Status
+	if defBool(r.FormValue("peers"), true) {
+		st = h.b.Status()
+		st.FilterPeers(sel)
+	} else {
+		st = h.b.StatusWithoutPeers()
+	}
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(st)
+}
+
+func (h *Handler) serveDebugPeerEndpointChanges(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "status access denied", http.StatusForbidden)
+		return
+	}
+
+	ipStr := r.FormValue("ip")
+	if ipStr == "" {
+		http.Error(w, "missing 'ip' parameter", http.StatusBadRequest)
+		return
+	}
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		http.Error(w, "invalid IP", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	chs, err := h.b.GetPeerEndpointChanges(r.Context(), ip)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(chs)
+}
+
+func (h *Handler) serveDebugNetcheckHistory(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "status access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.b.NetcheckHistory())
+}
+
+func (h *Handler) serveDebugDERPRace(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "status access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.b.DERPRaceStatus())
+}
+
+// serveDNSQuery resolves the "name" parameter through tailscaled's DNS
+// resolver and returns a dnstype.QueryTrace of how it was resolved. The
+// optional "type" parameter is the query type, A by default.
+func (h *Handler) serveDNSQuery(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "dns-query access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
+	}
+	name := r.FormValue("name")
+	if name == "" {
+		http.Error(w, "missing 'name' parameter", http.StatusBadRequest)
+		return
+	}
+	queryType := r.FormValue("type")
+	if queryType == "" {
+		queryType = "A"
+	}
+	trace, err := h.b.TraceDNSQuery(r.Context(), name, queryType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(trace)
+}
+
+// serveDNSCacheFlush empties tailscaled's DNS resolver's cache of
+// responses from upstream resolvers.
+func (h *Handler) serveDNSCacheFlush(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "dns-cache-flush access denied", http.StatusForbidden)
 		return
 	}
 	if r.Method != httpm.POST {
 		http.Error(w, "use POST", http.StatusMethodNotAllowed)
 		return
 	}
-
-	if err := h.b.ResetAuth(); err != nil {
-		http.Error(w, "reset-auth failed: "+err.Error(), http.StatusInternalServerError)
+	if err := h.b.FlushDNSCache(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (h *Handler) serveServeConfig(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case "GET":
-		if !h.PermitRead {
-			http.Error(w, "serve config denied", http.StatusForbidden)
-			return
-		}
-		config := h.b.ServeConfig()
-		bts, err := json.Marshal(config)
-		if err != nil {
-			http.Error(w, "error encoding config: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		sum := sha256.Sum256(bts)
-		etag := hex.EncodeToString(sum[:])
-		w.Header().Set("Etag", etag)
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(bts)
-	case "POST":
-		if !h.PermitWrite {
-			http.Error(w, "serve config denied", http.StatusForbidden)
-			return
-		}
-		configIn := new(ipn.ServeConfig)
-		if err := json.NewDecoder(r.Body).Decode(configIn); err != nil {
-			writeErrorJSON(w, fmt.Errorf("decoding config: %w", err))
-			return
-		}
-		etag := r.Header.Get("If-Match")
-		if err := h.b.SetServeConfig(configIn, etag); err != nil {
-			if errors.Is(err, ipnlocal.ErrETagMismatch) {
-				http.Error(w, err.Error(), http.StatusPreconditionFailed)
-				return
-			}
-			writeErrorJSON(w, fmt.Errorf("updating config: %w", err))
-			return
-		}
-		w.WriteHeader(http.StatusOK)
-	default:
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+// serveDNSOSConfig reports how tailscaled configures the OS's DNS
+// settings.
+func (h *Handler) serveDNSOSConfig(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "dns-osconfig access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	st, err := h.b.DNSOSConfiguratorStatus()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(st)
 }
 
-func (h *Handler) serveCheckIPForwarding(w http.ResponseWriter, r *http.Request) {
+// serveSSHRecordings lists the Tailscale SSH session recordings kept on
+// local disk. With ?verify=true, they're checked against their hashes.
+func (h *Handler) serveSSHRecordings(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitRead {
-		http.Error(w, "IP forwarding check access denied", http.StatusForbidden)
+		http.Error(w, "ssh-recordings access denied", http.StatusForbidden)
 		return
 	}
-	var warning string
-	if err := h.b.CheckIPForwarding(); err != nil {
-		warning = err.Error()
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	verify, _ := strconv.ParseBool(r.FormValue("verify"))
+	recs, err := h.b.SSHRecordings(verify)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(struct {
-		Warning string
-	}{
-		Warning: warning,
-	})
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(recs)
 }
 
-func (h *Handler) serveStatus(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) serveDebugPortmapLeases(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitRead {
 		http.Error(w, "status access denied", http.StatusForbidden)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	var st *ipnstate.Status
-	if defBool(r.FormValue("peers"), true) {
-		st = h.b.Status()
-	} else {
-		st = h.b.StatusWithoutPeers()
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
 	e := json.NewEncoder(w)
 	e.SetIndent("", "\t")
-	e.Encode(st)
+	e.Encode(h.b.PortMapLeases())
 }
 
-func (h *Handler) serveDebugPeerEndpointChanges(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) servePeerTraffic(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitRead {
 		http.Error(w, "status access denied", http.StatusForbidden)
 		return
 	}
-
-	ipStr := r.FormValue("ip")
-	if ipStr == "" {
-		http.Error(w, "missing 'ip' parameter", http.StatusBadRequest)
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusBadRequest)
 		return
 	}
-	ip, err := netip.ParseAddr(ipStr)
-	if err != nil {
-		http.Error(w, "invalid IP", http.StatusBadRequest)
+	if v := r.FormValue("watch"); v != "" {
+		if !h.PermitWrite {
+			// Watching starts the flow log.
+			http.Error(w, "peer traffic watch access denied", http.StatusForbidden)
+			return
+		}
+		h.watchPeerTraffic(w, r, v)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	chs, err := h.b.GetPeerEndpointChanges(r.Context(), ip)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
 	e := json.NewEncoder(w)
 	e.SetIndent("", "\t")
-	e.Encode(chs)
+	e.Encode(h.b.PeerTraffic())
+}
+
+// minPeerTrafficWatchInterval is the shortest interval at which the
+// peer-traffic endpoint streams stats.
+const minPeerTrafficWatchInterval = 250 * time.Millisecond
+
+// watchPeerTraffic streams the peer traffic as JSON lines of
+// []ipnstate.PeerTraffic, one every interval, until the request is
+// done. It starts the packet filter's flow log, so that new flows are
+// counted, until the last watcher leaves; so it requires write access.
+func (h *Handler) watchPeerTraffic(w http.ResponseWriter, r *http.Request, interval string) {
+	d, err := time.ParseDuration(interval)
+	if err != nil || d < minPeerTrafficWatchInterval {
+		http.Error(w, fmt.Sprintf("invalid watch interval %q; want a duration of at least %v", interval, minPeerTrafficWatchInterval), http.StatusBadRequest)
+		return
+	}
+	if _, done, err := h.b.WatchFlowLog(); err == nil {
+		defer done()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		if err := enc.Encode(h.b.PeerTraffic()); err != nil {
+			return
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
 // InUseOtherUserIPNStream reports whether r is a request for the watch-ipn-bus
@@ -1028,8 +1768,13 @@ func (h *Handler) serveWatchIPNBus(w http.ResponseWriter, r *http.Request) {
 		}
 		mask = ipn.NotifyWatchOpt(v)
 	}
+	events, err := ipn.ParseNotifyEvents(r.FormValue("events"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	ctx := r.Context()
-	h.b.WatchNotifications(ctx, mask, f.Flush, func(roNotify *ipn.Notify) (keepGoing bool) {
+	h.b.WatchEvents(ctx, mask, events, f.Flush, func(roNotify *ipn.Notify) (keepGoing bool) {
 		js, err := json.Marshal(roNotify)
 		if err != nil {
 			h.logf("json.Marshal: %v", err)
@@ -1252,6 +1997,62 @@ func (h *Handler) serveFileTargets(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(fts)
 }
 
+// serveFileOffers lets a client process incoming Taildrop files.
+// GET subscribes to them, streaming each ipn.FileOffer as a line of JSON;
+// while subscribed, incoming files are held until decided on. POST accepts
+// or rejects a pending file per the JSON ipn.FileOfferDecision in the body.
+// Only clients with PermitFilePath may give a path for the accepted file;
+// others retrieve it from the inbox themselves.
+func (h *Handler) serveFileOffers(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "file-offers access denied", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		f, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "not a flusher", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		f.Flush()
+		h.b.WatchFileOffers(r.Context(), func(o ipn.FileOffer) (keepGoing bool) {
+			js, err := json.Marshal(o)
+			if err != nil {
+				h.logf("json.Marshal: %v", err)
+				return false
+			}
+			if _, err := fmt.Fprintf(w, "%s\n", js); err != nil {
+				return false
+			}
+			f.Flush()
+			return true
+		})
+	case "POST":
+		var d ipn.FileOfferDecision
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if d.Path != "" && !h.PermitFilePath {
+			http.Error(w, "file-offers path access denied", http.StatusForbidden)
+			return
+		}
+		if err := h.b.DecideFileOffer(d); err != nil {
+			if errors.Is(err, ipnlocal.ErrNoFileOffer) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "use GET or POST", http.StatusMethodNotAllowed)
+	}
+}
+
 // serveFilePut sends a file to another node.
 //
 // It's sometimes possible for clients to do this themselves, without
@@ -1358,6 +2159,9 @@ func (h *Handler) serveFilePut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	outReq.ContentLength = r.ContentLength
+	if sum := r.Header.Get(apitype.FileSHA256Header); sum != "" {
+		outReq.Header.Set(apitype.FileSHA256Header, sum)
+	}
 	if offset > 0 {
 		h.logf("resuming put at offset %d after %v", offset, resumeDuration)
 		rangeHdr, _ := httphdr.FormatRange([]httphdr.Range{{offset, 0}})
@@ -1652,6 +2456,80 @@ type signRequest struct {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (h *Handler) serveTKASignBatch(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "lock status access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type signBatchRequest struct {
+		Keys    []ipnstate.NetworkLockSigningRequestKey
+		Offline bool // don't submit the signatures
+	}
+	var req signBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	res := h.b.NetworkLockSignBatch(req.Keys, req.Offline)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *Handler) serveTKASubmitSignatures(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "lock status access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var sigs []ipnstate.NetworkLockSignature
+	if err := json.NewDecoder(r.Body).Decode(&sigs); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.b.NetworkLockSubmitSignatures(sigs)
+	if err != nil {
+		http.Error(w, "submitting signatures failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *Handler) serveTKASignAudit(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "lock status access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	entries, err := h.b.NetworkLockSignAudit()
+	if err != nil {
+		http.Error(w, "reading audit trail failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	j, err := json.MarshalIndent(entries, "", "\t")
+	if err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
+
 func (h *Handler) serveTKAInit(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "lock init access denied", http.StatusForbidden)
@@ -1985,7 +2863,12 @@ func (h *Handler) serveTKASubmitRecoveryAUM(w http.ResponseWriter, r *http.Reque
 //   - PUT /profiles/: add new profile (no response). A separate
 //     StartLoginInteractive() is needed to populate and persist the new profile.
 //   - GET /profiles/current: current profile (JSON-ecoded ipn.LoginProfile)
+//   - POST /profiles/import: add profile from a JSON-encoded
+//     ipn.ProfileExport, and switch to it if the "switch" parameter is true
+//     (JSON-encoded ipn.LoginProfile of the new profile)
 //   - GET /profiles/<id>: output profile (JSON-ecoded ipn.LoginProfile)
+//   - GET /profiles/<id>/export: output profile's preferences and
+//     non-secret state (JSON-encoded ipn.ProfileExport)
 //   - POST /profiles/<id>: switch to profile (no response)
 //   - DELETE /profiles/<id>: delete profile (no response)
 func (h *Handler) serveProfiles(w http.ResponseWriter, r *http.Request) {
@@ -2030,6 +2913,44 @@ func (h *Handler) serveProfiles(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if suffix == "import" {
+		if r.Method != httpm.POST {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		var ex ipn.ProfileExport
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&ex); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if err := ex.Check(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		lp, err := h.b.ImportProfile(&ex, defBool(r.FormValue("switch"), false))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(lp)
+		return
+	}
+	if id, ok := strings.CutSuffix(suffix, "/export"); ok {
+		if r.Method != httpm.GET {
+			http.Error(w, "use GET", http.StatusMethodNotAllowed)
+			return
+		}
+		ex, err := h.b.ExportProfile(ipn.ProfileID(id))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ex)
+		return
+	}
 
 	profileID := ipn.ProfileID(suffix)
 	switch r.Method {
@@ -2201,10 +3122,28 @@ func (h *Handler) serveDebugCapture(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "POST required", http.StatusMethodNotAllowed)
 		return
 	}
+	var opts capture.OutputOptions
+	var err error
+	if opts.Filter, err = capture.ParseFilter(r.FormValue("filter")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if v := r.FormValue("format"); v != "" {
+		if opts.Format, err = capture.ParseFormat(v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.FormValue("snaplen"); v != "" {
+		if opts.Snaplen, err = strconv.Atoi(v); err != nil || opts.Snaplen < 0 {
+			http.Error(w, "invalid snaplen", http.StatusBadRequest)
+			return
+		}
+	}
 
 	w.WriteHeader(200)
 	w.(http.Flusher).Flush()
-	h.b.StreamDebugCapture(r.Context(), w)
+	h.b.StreamDebugCapture(r.Context(), w, opts)
 }
 
 func (h *Handler) serveDebugLog(w http.ResponseWriter, r *http.Request) {
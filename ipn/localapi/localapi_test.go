@@ -17,8 +17,13 @@
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/hostinfo"
 	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/ipn/store/mem"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tsd"
 	"tailscale.com/tstest"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/logid"
+	"tailscale.com/wgengine"
 )
 
 func TestValidHost(t *testing.T) {
@@ -146,3 +151,87 @@ func TestWhoIsJustIP(t *testing.T) {
 		})
 	}
 }
+
+func TestLocalAPITokenScopes(t *testing.T) {
+	sys := new(tsd.System)
+	sys.Set(new(mem.Store))
+	eng, err := wgengine.NewFakeUserspaceEngine(logger.Discard, sys.Set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(eng.Close)
+	sys.Set(eng)
+	b, err := ipnlocal.NewLocalBackend(logger.Discard, logid.PublicID{}, sys, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Shutdown)
+
+	tokens := map[apitype.LocalAPIScope]string{}
+	for _, scope := range []apitype.LocalAPIScope{apitype.LocalAPIScopeStatus, apitype.LocalAPIScopeServe, apitype.LocalAPIScopeAdmin} {
+		tok, _, err := b.CreateLocalAPIToken("", scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[scope] = tok
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{"status_status", tokens[apitype.LocalAPIScopeStatus], "GET", "status", http.StatusOK},
+		{"status_serve_config", tokens[apitype.LocalAPIScopeStatus], "GET", "serve-config", http.StatusForbidden},
+		{"status_auth_tokens", tokens[apitype.LocalAPIScopeStatus], "GET", "auth-tokens", http.StatusForbidden},
+		{"status_logout", tokens[apitype.LocalAPIScopeStatus], "POST", "logout", http.StatusForbidden},
+		{"status_prefs_edit", tokens[apitype.LocalAPIScopeStatus], "PATCH", "prefs", http.StatusForbidden},
+		{"serve_status", tokens[apitype.LocalAPIScopeServe], "GET", "status", http.StatusOK},
+		{"serve_serve_config", tokens[apitype.LocalAPIScopeServe], "GET", "serve-config", http.StatusOK},
+		{"serve_auth_tokens", tokens[apitype.LocalAPIScopeServe], "GET", "auth-tokens", http.StatusForbidden},
+		{"admin_auth_tokens", tokens[apitype.LocalAPIScopeAdmin], "GET", "auth-tokens", http.StatusOK},
+		{"invalid_token", "tslapi-bogus-bogus", "GET", "status", http.StatusUnauthorized},
+		{"no_token", "", "GET", "auth-tokens", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Without a token, the connection only permits reading; with
+			// one, the token's scope replaces that.
+			h := NewHandler(b, logger.Discard, nil, logid.PublicID{})
+			h.PermitRead = true
+			req := httptest.NewRequest(tt.method, "/localapi/v0/"+tt.path, strings.NewReader("{}"))
+			req.Host = apitype.LocalAPIHost
+			if tt.token != "" {
+				req.Header.Set(apitype.LocalAPITokenHeader, tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v; body: %s", rec.Code, tt.wantStatus, rec.Body.Bytes())
+			}
+		})
+	}
+}
+
+func TestPeerTrafficWatchRequiresWrite(t *testing.T) {
+	h := &Handler{PermitRead: true}
+	req := httptest.NewRequest("GET", "/localapi/v0/peer-traffic?watch=1s", nil)
+	rec := httptest.NewRecorder()
+	h.servePeerTraffic(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("watch with read-only access: status = %d; want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestFileOfferPathRequiresPermission(t *testing.T) {
+	h := &Handler{PermitRead: true, PermitWrite: true}
+	body := `{"ID":"x","Accept":true,"Path":"/etc/cron.d/x"}`
+	req := httptest.NewRequest("POST", "/localapi/v0/file-offers", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.serveFileOffers(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("path without PermitFilePath: status = %d; want %d", rec.Code, http.StatusForbidden)
+	}
+}
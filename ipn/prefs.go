@@ -9,12 +9,16 @@
 	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"net/netip"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"tailscale.com/atomicfile"
 	"tailscale.com/ipn/ipnstate"
@@ -99,6 +103,12 @@ type Prefs struct {
 	ExitNodeID tailcfg.StableNodeID
 	ExitNodeIP netip.Addr
 
+	// AutoExitNode is whether LocalBackend picks the exit node itself,
+	// keeping ExitNodeID set to the lowest-latency online exit node that
+	// control policy permits. It re-evaluates the choice as the network
+	// changes.
+	AutoExitNode bool `json:",omitempty"`
+
 	// ExitNodeAllowLANAccess indicates whether locally accessible subnets should be
 	// routed directly or via the exit node.
 	ExitNodeAllowLANAccess bool
@@ -130,6 +140,13 @@ type Prefs struct {
 	// connections. This overrides tailcfg.Hostinfo's ShieldsUp.
 	ShieldsUp bool
 
+	// ConnApproval specifies whether new incoming connections that the
+	// packet filter permits must also be approved locally. Connections
+	// not covered by a stored ConnApprovalRule are dropped and announced
+	// as a ConnApprovalRequest on the IPN bus, to be accepted or rejected
+	// before it expires. It has no effect when ShieldsUp is set.
+	ConnApproval bool `json:",omitempty"`
+
 	// AdvertiseTags specifies groups that this node wants to join, for
 	// purposes of ACL enforcement. These can be referenced from the ACL
 	// security policy. Note that advertising a tag doesn't guarantee that
@@ -204,6 +221,90 @@ type Prefs struct {
 	// posture checks.
 	PostureChecking bool
 
+	// AdvertiseMetadata is key/value metadata about this node, such as
+	// its rack, region or owning team, to publish to the tailnet in
+	// Hostinfo.Metadata. It must pass tailcfg.CheckHostinfoMetadata.
+	AdvertiseMetadata map[string]string `json:",omitempty"`
+
+	// UDPPort sets which local UDP port WireGuard traffic uses and when to
+	// move to a new one. See UDPPortPrefs docs for more details.
+	UDPPort UDPPortPrefs
+
+	// EndpointPins pins the traffic to specific peers to a fixed path,
+	// bypassing automatic path discovery, for networks where traffic must
+	// take a known path. The values are "derp-only", "direct-only" or an
+	// "ip:port" to always send to.
+	EndpointPins map[tailcfg.StableNodeID]string `json:",omitempty"`
+
+	// EndpointTypes is the local policy for which of peers' advertised
+	// endpoints to use, by their type, as a comma-separated list of
+	// tailcfg.EndpointType names ("local", "stun", "portmap", etc).
+	// Endpoints of types prefixed with "-" are never used; the others
+	// are preferred over unlisted types, most preferred first. Empty
+	// means all endpoints are used with no preference.
+	EndpointTypes string `json:",omitempty"`
+
+	// PeerEndpointTypes overrides EndpointTypes for specific peers, in
+	// the same form.
+	PeerEndpointTypes map[tailcfg.StableNodeID]string `json:",omitempty"`
+
+	// OuterDSCP is the Differentiated Services code point to mark the UDP
+	// packets carrying WireGuard traffic with, for networks with QoS
+	// policies. It's a DSCP name ("ef", "af41", "cs1", etc.) or number,
+	// OuterDSCPCopy to use the marking of the traffic they carry, or empty
+	// to leave them unmarked. See ParseOuterDSCP.
+	OuterDSCP string `json:",omitempty"`
+
+	// IPv6FlowLabels specifies whether to set stable per-peer flow labels
+	// on the IPv6 UDP packets carrying WireGuard traffic, so ECMP routing
+	// keeps each peer's traffic on one path.
+	IPv6FlowLabels bool `json:",omitempty"`
+
+	// RouteMetrics sets the metrics (priorities) of the routes the node
+	// agent installs, so they can deliberately win or lose against
+	// routes from VPN clients or SD-WAN agents on the same machine, as
+	// far as the platform allows. See RouteMetricPrefs docs for more
+	// details.
+	RouteMetrics RouteMetricPrefs `json:",omitempty"`
+
+	// AdvertiseDNSRecords are DNS records to publish under this node's
+	// MagicDNS name, in Hostinfo.DNSRecords. They must pass
+	// tailcfg.CheckHostinfoDNSRecords.
+	AdvertiseDNSRecords []tailcfg.DNSRecord `json:",omitempty"`
+
+	// DNSCache is the policy of the cache of responses from upstream
+	// DNS resolvers in tailscaled's resolver. The zero value disables
+	// it. See DNSCachePrefs docs for more details.
+	DNSCache DNSCachePrefs `json:",omitempty"`
+
+	// DNSBlocklists are blocklists of names, such as of ad or malware
+	// domains, that tailscaled's resolver answers NXDOMAIN for. Each is
+	// an absolute file path or an HTTPS URL of a hosts file or RPZ zone
+	// file. They add to any blocklists the tailnet's DNS settings have.
+	DNSBlocklists []string `json:",omitempty"`
+
+	// DNSBlocklistNullIP is whether to answer A and AAAA queries for
+	// blocked names with 0.0.0.0 and :: instead of NXDOMAIN.
+	DNSBlocklistNullIP bool `json:",omitempty"`
+
+	// MaintenanceWindow, if non-empty, restricts disruptive actions
+	// that tailscaled starts on its own, such as applying auto-updates
+	// and prompting to re-authenticate, to recurring windows. Outside
+	// of them, such actions are deferred until the next window opens.
+	// See the maintwindow package for the format.
+	MaintenanceWindow string `json:",omitempty"`
+
+	// WebClient is where tailscaled serves its built-in web UI for
+	// managing the node: WebClientTailnet, WebClientLocalhost, or empty
+	// to not serve it.
+	WebClient string `json:",omitempty"`
+
+	// HalfOpenLimits limits the incoming TCP connections from peers that
+	// the packet filter lets stay half-open, to protect the node and the
+	// subnets it routes to from SYN floods. See HalfOpenLimitsPrefs docs
+	// for more details. The zero value means no limits.
+	HalfOpenLimits HalfOpenLimitsPrefs
+
 	// The Persist field is named 'Config' in the file for backward
 	// compatibility with earlier versions.
 	// TODO(apenwarr): We should move this out of here, it's not a pref.
@@ -224,6 +325,83 @@ type AutoUpdatePrefs struct {
 	Apply bool
 }
 
+// Values of Prefs.WebClient.
+const (
+	// WebClientTailnet serves the web UI on the node's Tailscale IPs to
+	// peers granted tailcfg.PeerCapabilityWebUI. Changes require the
+	// user to authenticate with the control server.
+	WebClientTailnet = "tailnet"
+
+	// WebClientLocalhost serves the web UI on the loopback interface.
+	// Only local users allowed to change prefs can make changes, and
+	// they must authenticate with the control server too. It's only
+	// supported on Linux.
+	WebClientLocalhost = "localhost"
+)
+
+// MinUDPPortRotateInterval is the smallest allowed non-zero
+// UDPPortPrefs.RotateEvery.
+const MinUDPPortRotateInterval = time.Minute
+
+// UDPPortPrefs are the settings for the local UDP port the node agent sends
+// and receives WireGuard traffic on, for networks whose firewalls only allow
+// some ports, or time out or block long-lived UDP flows on a single port.
+type UDPPortPrefs struct {
+	// RangeFirst and RangeLast, if non-zero, restrict the port to the
+	// inclusive range [RangeFirst, RangeLast]. Either both or neither
+	// must be set.
+	RangeFirst uint16 `json:",omitempty"`
+	RangeLast  uint16 `json:",omitempty"`
+
+	// RotateEvery, if non-zero, is how often to move to a new port. It
+	// must be at least MinUDPPortRotateInterval.
+	RotateEvery time.Duration `json:",omitempty"`
+
+	// RotateOnPathFailure specifies whether to move to a new port when
+	// UDP paths to peers repeatedly fail.
+	RotateOnPathFailure bool `json:",omitempty"`
+}
+
+// RouteMetricPrefs are the metrics of the routes the node agent installs,
+// by class of route. Lower metrics are preferred. Zero means the
+// platform's default metric. They're only used on Linux and Windows.
+//
+// On Linux, all routes stay in Tailscale's routing table, so that traffic
+// tailscaled marks to bypass it never loops back into the tunnel. That
+// table is looked up before the main one, so there the metrics only order
+// routes to the same prefix within it, such as those of other software
+// that installs routes in Tailscale's table.
+//
+// Control can override them per class with the "route-metrics" node
+// attribute; see tailcfg.NodeAttrRouteMetrics.
+type RouteMetricPrefs struct {
+	// Tailnet is the metric of the routes to tailnet addresses.
+	Tailnet uint32 `json:",omitempty"`
+
+	// Subnet is the metric of the routes to subnets advertised by
+	// peers.
+	Subnet uint32 `json:",omitempty"`
+
+	// ExitNode is the metric of the default routes (and, without local
+	// network access, routes to the local networks) via an exit node.
+	ExitNode uint32 `json:",omitempty"`
+}
+
+// DNSCachePrefs is the policy of tailscaled's cache of responses from
+// upstream DNS resolvers. Successful responses are cached for the lowest
+// TTL of their answers, and NXDOMAIN and empty responses for the TTL
+// their SOA record gives, per RFC 2308.
+type DNSCachePrefs struct {
+	// MaxEntries is the maximum number of responses to cache. Zero
+	// disables the cache.
+	MaxEntries int `json:",omitempty"`
+
+	// MinTTL and MaxTTL, if non-zero, clamp how long responses are
+	// cached, whatever their TTLs.
+	MinTTL time.Duration `json:",omitempty"`
+	MaxTTL time.Duration `json:",omitempty"`
+}
+
 // MaskedPrefs is a Prefs with an associated bitmask of which fields are set.
 type MaskedPrefs struct {
 	Prefs
@@ -233,12 +411,14 @@ type MaskedPrefs struct {
 	AllowSingleHostsSet       bool `json:",omitempty"`
 	ExitNodeIDSet             bool `json:",omitempty"`
 	ExitNodeIPSet             bool `json:",omitempty"`
+	AutoExitNodeSet           bool `json:",omitempty"`
 	ExitNodeAllowLANAccessSet bool `json:",omitempty"`
 	CorpDNSSet                bool `json:",omitempty"`
 	RunSSHSet                 bool `json:",omitempty"`
 	WantRunningSet            bool `json:",omitempty"`
 	LoggedOutSet              bool `json:",omitempty"`
 	ShieldsUpSet              bool `json:",omitempty"`
+	ConnApprovalSet           bool `json:",omitempty"`
 	AdvertiseTagsSet          bool `json:",omitempty"`
 	HostnameSet               bool `json:",omitempty"`
 	NotepadURLsSet            bool `json:",omitempty"`
@@ -251,6 +431,21 @@ type MaskedPrefs struct {
 	ProfileNameSet            bool `json:",omitempty"`
 	AutoUpdateSet             bool `json:",omitempty"`
 	PostureCheckingSet        bool `json:",omitempty"`
+	AdvertiseMetadataSet      bool `json:",omitempty"`
+	UDPPortSet                bool `json:",omitempty"`
+	EndpointPinsSet           bool `json:",omitempty"`
+	EndpointTypesSet          bool `json:",omitempty"`
+	PeerEndpointTypesSet      bool `json:",omitempty"`
+	OuterDSCPSet              bool `json:",omitempty"`
+	IPv6FlowLabelsSet         bool `json:",omitempty"`
+	RouteMetricsSet           bool `json:",omitempty"`
+	AdvertiseDNSRecordsSet    bool `json:",omitempty"`
+	DNSCacheSet               bool `json:",omitempty"`
+	DNSBlocklistsSet          bool `json:",omitempty"`
+	DNSBlocklistNullIPSet     bool `json:",omitempty"`
+	MaintenanceWindowSet      bool `json:",omitempty"`
+	WebClientSet              bool `json:",omitempty"`
+	HalfOpenLimitsSet         bool `json:",omitempty"`
 }
 
 // ApplyEdits mutates p, assigning fields from m.Prefs for each MaskedPrefs
@@ -362,7 +557,12 @@ func (p *Prefs) pretty(goos string) string {
 	if p.ShieldsUp {
 		sb.WriteString("shields=true ")
 	}
-	if p.ExitNodeIP.IsValid() {
+	if p.ConnApproval {
+		sb.WriteString("connapproval=true ")
+	}
+	if p.AutoExitNode {
+		fmt.Fprintf(&sb, "exit=auto(%v) lan=%t ", p.ExitNodeID, p.ExitNodeAllowLANAccess)
+	} else if p.ExitNodeIP.IsValid() {
 		fmt.Fprintf(&sb, "exit=%v lan=%t ", p.ExitNodeIP, p.ExitNodeAllowLANAccess)
 	} else if !p.ExitNodeID.IsZero() {
 		fmt.Fprintf(&sb, "exit=%v lan=%t ", p.ExitNodeID, p.ExitNodeAllowLANAccess)
@@ -376,6 +576,42 @@ func (p *Prefs) pretty(goos string) string {
 	if len(p.AdvertiseTags) > 0 {
 		fmt.Fprintf(&sb, "tags=%s ", strings.Join(p.AdvertiseTags, ","))
 	}
+	if len(p.AdvertiseMetadata) > 0 {
+		fmt.Fprintf(&sb, "metadata=%v ", p.AdvertiseMetadata)
+	}
+	if len(p.EndpointPins) > 0 {
+		fmt.Fprintf(&sb, "pins=%v ", p.EndpointPins)
+	}
+	if p.EndpointTypes != "" {
+		fmt.Fprintf(&sb, "eptypes=%s ", p.EndpointTypes)
+	}
+	if len(p.PeerEndpointTypes) > 0 {
+		fmt.Fprintf(&sb, "peereptypes=%v ", p.PeerEndpointTypes)
+	}
+	if p.OuterDSCP != "" {
+		fmt.Fprintf(&sb, "outerdscp=%s ", p.OuterDSCP)
+	}
+	if p.IPv6FlowLabels {
+		sb.WriteString("flowlabels=true ")
+	}
+	sb.WriteString(p.RouteMetrics.Pretty())
+	if len(p.AdvertiseDNSRecords) > 0 {
+		fmt.Fprintf(&sb, "dnsrecords=%d ", len(p.AdvertiseDNSRecords))
+	}
+	sb.WriteString(p.DNSCache.Pretty())
+	if len(p.DNSBlocklists) > 0 {
+		fmt.Fprintf(&sb, "blocklists=%d ", len(p.DNSBlocklists))
+		if p.DNSBlocklistNullIP {
+			sb.WriteString("blocklist-nullip=true ")
+		}
+	}
+	if p.MaintenanceWindow != "" {
+		fmt.Fprintf(&sb, "maint=%q ", p.MaintenanceWindow)
+	}
+	if p.WebClient != "" {
+		fmt.Fprintf(&sb, "webclient=%s ", p.WebClient)
+	}
+	sb.WriteString(p.HalfOpenLimits.Pretty())
 	if goos == "linux" {
 		fmt.Fprintf(&sb, "nf=%v ", p.NetfilterMode)
 	}
@@ -389,6 +625,7 @@ func (p *Prefs) pretty(goos string) string {
 		fmt.Fprintf(&sb, "op=%q ", p.OperatorUser)
 	}
 	sb.WriteString(p.AutoUpdate.Pretty())
+	sb.WriteString(p.UDPPort.Pretty())
 	if p.Persist != nil {
 		sb.WriteString(p.Persist.Pretty())
 	} else {
@@ -428,6 +665,7 @@ func (p *Prefs) Equals(p2 *Prefs) bool {
 		p.AllowSingleHosts == p2.AllowSingleHosts &&
 		p.ExitNodeID == p2.ExitNodeID &&
 		p.ExitNodeIP == p2.ExitNodeIP &&
+		p.AutoExitNode == p2.AutoExitNode &&
 		p.ExitNodeAllowLANAccess == p2.ExitNodeAllowLANAccess &&
 		p.CorpDNS == p2.CorpDNS &&
 		p.RunSSH == p2.RunSSH &&
@@ -435,6 +673,7 @@ func (p *Prefs) Equals(p2 *Prefs) bool {
 		p.LoggedOut == p2.LoggedOut &&
 		p.NotepadURLs == p2.NotepadURLs &&
 		p.ShieldsUp == p2.ShieldsUp &&
+		p.ConnApproval == p2.ConnApproval &&
 		p.NoSNAT == p2.NoSNAT &&
 		p.NetfilterMode == p2.NetfilterMode &&
 		p.OperatorUser == p2.OperatorUser &&
@@ -445,7 +684,22 @@ func (p *Prefs) Equals(p2 *Prefs) bool {
 		p.Persist.Equals(p2.Persist) &&
 		p.ProfileName == p2.ProfileName &&
 		p.AutoUpdate == p2.AutoUpdate &&
-		p.PostureChecking == p2.PostureChecking
+		p.PostureChecking == p2.PostureChecking &&
+		maps.Equal(p.AdvertiseMetadata, p2.AdvertiseMetadata) &&
+		p.UDPPort == p2.UDPPort &&
+		maps.Equal(p.EndpointPins, p2.EndpointPins) &&
+		p.EndpointTypes == p2.EndpointTypes &&
+		maps.Equal(p.PeerEndpointTypes, p2.PeerEndpointTypes) &&
+		p.OuterDSCP == p2.OuterDSCP &&
+		p.IPv6FlowLabels == p2.IPv6FlowLabels &&
+		p.RouteMetrics == p2.RouteMetrics &&
+		slices.Equal(p.AdvertiseDNSRecords, p2.AdvertiseDNSRecords) &&
+		p.DNSCache == p2.DNSCache &&
+		slices.Equal(p.DNSBlocklists, p2.DNSBlocklists) &&
+		p.DNSBlocklistNullIP == p2.DNSBlocklistNullIP &&
+		p.MaintenanceWindow == p2.MaintenanceWindow &&
+		p.WebClient == p2.WebClient &&
+		p.HalfOpenLimits == p2.HalfOpenLimits
 }
 
 func (au AutoUpdatePrefs) Pretty() string {
@@ -458,6 +712,167 @@ func (au AutoUpdatePrefs) Pretty() string {
 	return "update=off "
 }
 
+func (up UDPPortPrefs) Pretty() string {
+	var parts []string
+	if up.RangeFirst != 0 || up.RangeLast != 0 {
+		parts = append(parts, fmt.Sprintf("%d-%d", up.RangeFirst, up.RangeLast))
+	}
+	if up.RotateEvery != 0 {
+		parts = append(parts, "rotate="+up.RotateEvery.String())
+	}
+	if up.RotateOnPathFailure {
+		parts = append(parts, "rotate-on-failure")
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "udpport=" + strings.Join(parts, ",") + " "
+}
+
+func (rm RouteMetricPrefs) Pretty() string {
+	var parts []string
+	if rm.Tailnet != 0 {
+		parts = append(parts, fmt.Sprintf("tailnet:%d", rm.Tailnet))
+	}
+	if rm.Subnet != 0 {
+		parts = append(parts, fmt.Sprintf("subnet:%d", rm.Subnet))
+	}
+	if rm.ExitNode != 0 {
+		parts = append(parts, fmt.Sprintf("exit-node:%d", rm.ExitNode))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "routemetrics=" + strings.Join(parts, ",") + " "
+}
+
+func (dc DNSCachePrefs) Pretty() string {
+	if dc.MaxEntries == 0 {
+		return ""
+	}
+	s := fmt.Sprintf("dnscache=%d", dc.MaxEntries)
+	if dc.MinTTL != 0 {
+		s += fmt.Sprintf(",min-ttl=%v", dc.MinTTL)
+	}
+	if dc.MaxTTL != 0 {
+		s += fmt.Sprintf(",max-ttl=%v", dc.MaxTTL)
+	}
+	return s + " "
+}
+
+// Check returns an error if dc is not a valid DNSCachePrefs.
+func (dc DNSCachePrefs) Check() error {
+	if dc.MaxEntries < 0 || dc.MinTTL < 0 || dc.MaxTTL < 0 {
+		return errors.New("DNS cache size and TTLs can't be negative")
+	}
+	if dc.MaxTTL != 0 && dc.MinTTL > dc.MaxTTL {
+		return fmt.Errorf("DNS cache min TTL %v is greater than max TTL %v", dc.MinTTL, dc.MaxTTL)
+	}
+	return nil
+}
+
+// Check returns an error if up is not a valid UDPPortPrefs.
+func (up UDPPortPrefs) Check() error {
+	if (up.RangeFirst == 0) != (up.RangeLast == 0) {
+		return errors.New("UDP port range must have both a first and a last port")
+	}
+	if up.RangeFirst > up.RangeLast {
+		return fmt.Errorf("invalid UDP port range %d-%d", up.RangeFirst, up.RangeLast)
+	}
+	if up.RotateEvery < 0 || (up.RotateEvery > 0 && up.RotateEvery < MinUDPPortRotateInterval) {
+		return fmt.Errorf("UDP port rotation interval %v must be at least %v", up.RotateEvery, MinUDPPortRotateInterval)
+	}
+	return nil
+}
+
+// HalfOpenLimitsPrefs are the limits on the incoming TCP connections from
+// peers that have sent a SYN but not yet completed the handshake.
+type HalfOpenLimitsPrefs struct {
+	// MaxPerPeer, if non-zero, is the most half-open connections
+	// allowed from any one peer IP.
+	MaxPerPeer int `json:",omitempty"`
+
+	// MaxTotal, if non-zero, is the most half-open connections allowed
+	// from all peers together.
+	MaxTotal int `json:",omitempty"`
+
+	// Timeout, if non-zero, is how long a connection counts as half-open
+	// before it's forgotten. The default is 30 seconds.
+	Timeout time.Duration `json:",omitempty"`
+
+	// EvictOldest specifies whether a new connection over a limit
+	// replaces the oldest half-open one, instead of being dropped. This
+	// bounds memory use but doesn't protect the destination.
+	EvictOldest bool `json:",omitempty"`
+}
+
+func (hl HalfOpenLimitsPrefs) Pretty() string {
+	var parts []string
+	if hl.MaxPerPeer != 0 {
+		parts = append(parts, fmt.Sprintf("perpeer=%d", hl.MaxPerPeer))
+	}
+	if hl.MaxTotal != 0 {
+		parts = append(parts, fmt.Sprintf("total=%d", hl.MaxTotal))
+	}
+	if hl.Timeout != 0 {
+		parts = append(parts, "timeout="+hl.Timeout.String())
+	}
+	if hl.EvictOldest {
+		parts = append(parts, "evict-oldest")
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "halfopen=" + strings.Join(parts, ",") + " "
+}
+
+// Check returns an error if hl is not a valid HalfOpenLimitsPrefs.
+func (hl HalfOpenLimitsPrefs) Check() error {
+	if hl.MaxPerPeer < 0 || hl.MaxTotal < 0 {
+		return fmt.Errorf("half-open connection limits %d, %d must not be negative", hl.MaxPerPeer, hl.MaxTotal)
+	}
+	if hl.Timeout < 0 {
+		return fmt.Errorf("half-open connection timeout %v must not be negative", hl.Timeout)
+	}
+	return nil
+}
+
+// OuterDSCPCopy is the Prefs.OuterDSCP value that marks the UDP packets
+// carrying WireGuard traffic to each peer with the DSCP of the traffic.
+const OuterDSCPCopy = "copy"
+
+// dscpNames are the standard names of Differentiated Services code points
+// that aren't AF classes, which are computed.
+var dscpNames = map[string]uint8{
+	"cs0": 0, "cs1": 8, "cs2": 16, "cs3": 24, "cs4": 32, "cs5": 40, "cs6": 48, "cs7": 56,
+	"ef": 46, "va": 44, "le": 1,
+}
+
+// ParseOuterDSCP parses a Prefs.OuterDSCP value. It reports whether s is
+// OuterDSCPCopy, or else the code point s names, which is zero if s is
+// empty.
+func ParseOuterDSCP(s string) (copyInner bool, dscp uint8, err error) {
+	s = strings.ToLower(s)
+	switch s {
+	case "":
+		return false, 0, nil
+	case OuterDSCPCopy:
+		return true, 0, nil
+	}
+	if v, ok := dscpNames[s]; ok {
+		return false, v, nil
+	}
+	// Assured Forwarding: "afXY" for class X in 1-4 and drop precedence
+	// Y in 1-3.
+	if len(s) == 4 && strings.HasPrefix(s, "af") && s[2] >= '1' && s[2] <= '4' && s[3] >= '1' && s[3] <= '3' {
+		return false, (s[2]-'0')<<3 | (s[3]-'0')<<1, nil
+	}
+	if v, err := strconv.ParseUint(s, 10, 8); err == nil && v < 64 {
+		return false, uint8(v), nil
+	}
+	return false, 0, fmt.Errorf("invalid outer DSCP %q; want %q, a DSCP name such as \"ef\" or \"af41\", or a number from 0 to 63", s, OuterDSCPCopy)
+}
+
 func compareIPNets(a, b []netip.Prefix) bool {
 	if len(a) != len(b) {
 		return false
@@ -793,3 +1208,43 @@ type LoginProfile struct {
 	// into.
 	ControlURL string
 }
+
+// ProfileExportVersion is the current version of the ProfileExport
+// format.
+const ProfileExportVersion = 1
+
+// ProfileExport is a profile's portable configuration, for recreating
+// it on another machine: its preferences and non-secret metadata. It
+// has none of the profile's keys, so an imported profile logs in as a
+// new node.
+type ProfileExport struct {
+	// Version is the ProfileExportVersion the export was made with.
+	Version int
+
+	// Name is the user-visible name of the profile.
+	Name string
+
+	// TailnetMagicDNSName is the MagicDNS suffix of the profile's
+	// tailnet, if known. It's informational.
+	TailnetMagicDNSName string `json:",omitempty"`
+
+	// LoginName is the login name of the profile's user, if known.
+	// It's informational.
+	LoginName string `json:",omitempty"`
+
+	// Prefs are the profile's preferences, without Persist.
+	Prefs *Prefs
+}
+
+// Check reports whether e can be imported.
+func (e *ProfileExport) Check() error {
+	switch {
+	case e.Version < 1 || e.Version > ProfileExportVersion:
+		return fmt.Errorf("unsupported profile export version %d", e.Version)
+	case e.Prefs == nil:
+		return errors.New("profile export has no prefs")
+	case e.Prefs.Persist != nil:
+		return errors.New("profile export has node state")
+	}
+	return nil
+}
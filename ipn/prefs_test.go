@@ -40,12 +40,14 @@ func TestPrefsEqual(t *testing.T) {
 		"AllowSingleHosts",
 		"ExitNodeID",
 		"ExitNodeIP",
+		"AutoExitNode",
 		"ExitNodeAllowLANAccess",
 		"CorpDNS",
 		"RunSSH",
 		"WantRunning",
 		"LoggedOut",
 		"ShieldsUp",
+		"ConnApproval",
 		"AdvertiseTags",
 		"Hostname",
 		"NotepadURLs",
@@ -58,6 +60,21 @@ func TestPrefsEqual(t *testing.T) {
 		"ProfileName",
 		"AutoUpdate",
 		"PostureChecking",
+		"AdvertiseMetadata",
+		"UDPPort",
+		"EndpointPins",
+		"EndpointTypes",
+		"PeerEndpointTypes",
+		"OuterDSCP",
+		"IPv6FlowLabels",
+		"RouteMetrics",
+		"AdvertiseDNSRecords",
+		"DNSCache",
+		"DNSBlocklists",
+		"DNSBlocklistNullIP",
+		"MaintenanceWindow",
+		"WebClient",
+		"HalfOpenLimits",
 		"Persist",
 	}
 	if have := fieldsOf(reflect.TypeOf(Prefs{})); !reflect.DeepEqual(have, prefsHandles) {
@@ -226,6 +243,11 @@ func TestPrefsEqual(t *testing.T) {
 			&Prefs{ShieldsUp: true},
 			true,
 		},
+		{
+			&Prefs{ConnApproval: true},
+			&Prefs{ConnApproval: false},
+			false,
+		},
 
 		{
 			&Prefs{AdvertiseRoutes: nil},
@@ -315,6 +337,126 @@ func TestPrefsEqual(t *testing.T) {
 			&Prefs{PostureChecking: false},
 			false,
 		},
+		{
+			&Prefs{AdvertiseMetadata: map[string]string{"rack": "r1"}},
+			&Prefs{AdvertiseMetadata: map[string]string{"rack": "r1"}},
+			true,
+		},
+		{
+			&Prefs{AdvertiseMetadata: map[string]string{"rack": "r1"}},
+			&Prefs{AdvertiseMetadata: map[string]string{"rack": "r2"}},
+			false,
+		},
+		{
+			&Prefs{UDPPort: UDPPortPrefs{RangeFirst: 41000, RangeLast: 41099}},
+			&Prefs{UDPPort: UDPPortPrefs{RangeFirst: 41000, RangeLast: 41099}},
+			true,
+		},
+		{
+			&Prefs{UDPPort: UDPPortPrefs{RangeFirst: 41000, RangeLast: 41099}},
+			&Prefs{UDPPort: UDPPortPrefs{RangeFirst: 41000, RangeLast: 41099, RotateOnPathFailure: true}},
+			false,
+		},
+		{
+			&Prefs{EndpointPins: map[tailcfg.StableNodeID]string{"n1": "derp-only"}},
+			&Prefs{EndpointPins: map[tailcfg.StableNodeID]string{"n1": "derp-only"}},
+			true,
+		},
+		{
+			&Prefs{EndpointPins: map[tailcfg.StableNodeID]string{"n1": "derp-only"}},
+			&Prefs{EndpointPins: map[tailcfg.StableNodeID]string{"n1": "direct-only"}},
+			false,
+		},
+		{
+			&Prefs{EndpointTypes: "local,-portmap"},
+			&Prefs{EndpointTypes: "local"},
+			false,
+		},
+		{
+			&Prefs{PeerEndpointTypes: map[tailcfg.StableNodeID]string{"n1": "stun"}},
+			&Prefs{PeerEndpointTypes: map[tailcfg.StableNodeID]string{"n1": "stun"}},
+			true,
+		},
+		{
+			&Prefs{PeerEndpointTypes: map[tailcfg.StableNodeID]string{"n1": "stun"}},
+			&Prefs{PeerEndpointTypes: map[tailcfg.StableNodeID]string{"n1": "-stun"}},
+			false,
+		},
+		{
+			&Prefs{OuterDSCP: "ef"},
+			&Prefs{OuterDSCP: "copy"},
+			false,
+		},
+		{
+			&Prefs{IPv6FlowLabels: true},
+			&Prefs{IPv6FlowLabels: false},
+			false,
+		},
+		{
+			&Prefs{RouteMetrics: RouteMetricPrefs{Subnet: 100}},
+			&Prefs{RouteMetrics: RouteMetricPrefs{Subnet: 200}},
+			false,
+		},
+		{
+			&Prefs{RouteMetrics: RouteMetricPrefs{Tailnet: 10, ExitNode: 1000}},
+			&Prefs{RouteMetrics: RouteMetricPrefs{Tailnet: 10, ExitNode: 1000}},
+			true,
+		},
+		{
+			&Prefs{AdvertiseDNSRecords: []tailcfg.DNSRecord{{Name: "@", Type: "TXT", Value: "a"}}},
+			&Prefs{AdvertiseDNSRecords: []tailcfg.DNSRecord{{Name: "@", Type: "TXT", Value: "a"}}},
+			true,
+		},
+		{
+			&Prefs{AdvertiseDNSRecords: []tailcfg.DNSRecord{{Name: "@", Type: "TXT", Value: "a"}}},
+			&Prefs{AdvertiseDNSRecords: []tailcfg.DNSRecord{{Name: "@", Type: "TXT", Value: "b"}}},
+			false,
+		},
+		{
+			&Prefs{DNSCache: DNSCachePrefs{MaxEntries: 1000}},
+			&Prefs{DNSCache: DNSCachePrefs{MaxEntries: 1000, MaxTTL: time.Hour}},
+			false,
+		},
+		{
+			&Prefs{DNSCache: DNSCachePrefs{MaxEntries: 1000, MaxTTL: time.Hour}},
+			&Prefs{DNSCache: DNSCachePrefs{MaxEntries: 1000, MaxTTL: time.Hour}},
+			true,
+		},
+		{
+			&Prefs{DNSBlocklists: []string{"/etc/blocklist"}},
+			&Prefs{DNSBlocklists: []string{"/etc/blocklist"}, DNSBlocklistNullIP: true},
+			false,
+		},
+		{
+			&Prefs{MaintenanceWindow: "0 2 * * * 2h"},
+			&Prefs{MaintenanceWindow: "0 3 * * * 2h"},
+			false,
+		},
+		{
+			&Prefs{WebClient: WebClientTailnet},
+			&Prefs{WebClient: WebClientLocalhost},
+			false,
+		},
+		{
+			&Prefs{HalfOpenLimits: HalfOpenLimitsPrefs{MaxPerPeer: 64}},
+			&Prefs{HalfOpenLimits: HalfOpenLimitsPrefs{MaxPerPeer: 64}},
+			true,
+		},
+		{
+			&Prefs{HalfOpenLimits: HalfOpenLimitsPrefs{MaxPerPeer: 64}},
+			&Prefs{HalfOpenLimits: HalfOpenLimitsPrefs{MaxPerPeer: 64, EvictOldest: true}},
+			false,
+		},
+		{
+			&Prefs{ExitNodeID: "n1", AutoExitNode: true},
+			&Prefs{ExitNodeID: "n1"},
+			false,
+		},
+		{
+			&Prefs{DNSBlocklists: []string{"/etc/blocklist"}},
+			&Prefs{DNSBlocklists: []string{"https://example.com/hosts"}},
+			false,
+		},
 	}
 	for i, tt := range tests {
 		got := tt.a.Equals(tt.b)
@@ -515,6 +657,77 @@ func TestPrefsPretty(t *testing.T) {
 			"linux",
 			`Prefs{ra=false mesh=false dns=false want=false routes=[] nf=off update=on Persist=nil}`,
 		},
+		{
+			Prefs{
+				UDPPort: UDPPortPrefs{
+					RangeFirst:          41000,
+					RangeLast:           41099,
+					RotateEvery:         30 * time.Minute,
+					RotateOnPathFailure: true,
+				},
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false routes=[] nf=off update=off udpport=41000-41099,rotate=30m0s,rotate-on-failure Persist=nil}`,
+		},
+		{
+			Prefs{
+				OuterDSCP:      "af41",
+				IPv6FlowLabels: true,
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false routes=[] outerdscp=af41 flowlabels=true nf=off update=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				RouteMetrics: RouteMetricPrefs{Tailnet: 10, ExitNode: 1000},
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false routes=[] routemetrics=tailnet:10,exit-node:1000 nf=off update=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				DNSCache: DNSCachePrefs{MaxEntries: 1000, MinTTL: 30 * time.Second},
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false routes=[] dnscache=1000,min-ttl=30s nf=off update=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				DNSBlocklists:      []string{"/etc/blocklist", "https://example.com/hosts"},
+				DNSBlocklistNullIP: true,
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false routes=[] blocklists=2 blocklist-nullip=true nf=off update=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				MaintenanceWindow: "0 2 * * mon-fri 2h",
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false routes=[] maint="0 2 * * mon-fri 2h" nf=off update=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				WebClient: WebClientTailnet,
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false routes=[] webclient=tailnet nf=off update=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				ExitNodeID:   "n1",
+				AutoExitNode: true,
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false exit=auto(n1) lan=false routes=[] nf=off update=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				ConnApproval: true,
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false connapproval=true routes=[] nf=off update=off Persist=nil}`,
+		},
 	}
 	for i, tt := range tests {
 		got := tt.p.pretty(tt.os)
@@ -955,3 +1168,80 @@ func TestNotifyPrefsJSONRoundtrip(t *testing.T) {
 		t.Fatal("Prefs should not be valid after deserialization")
 	}
 }
+
+func TestParseOuterDSCP(t *testing.T) {
+	tests := []struct {
+		in       string
+		wantCopy bool
+		want     uint8
+		wantErr  bool
+	}{
+		{in: ""},
+		{in: "copy", wantCopy: true},
+		{in: "ef", want: 46},
+		{in: "EF", want: 46},
+		{in: "cs1", want: 8},
+		{in: "af11", want: 10},
+		{in: "af41", want: 34},
+		{in: "af43", want: 38},
+		{in: "0", want: 0},
+		{in: "63", want: 63},
+		{in: "64", wantErr: true},
+		{in: "af44", wantErr: true},
+		{in: "af51", wantErr: true},
+		{in: "-1", wantErr: true},
+		{in: "gold", wantErr: true},
+	}
+	for _, tt := range tests {
+		gotCopy, got, err := ParseOuterDSCP(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseOuterDSCP(%q) err = %v; want error: %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if gotCopy != tt.wantCopy || got != tt.want {
+			t.Errorf("ParseOuterDSCP(%q) = %v, %d; want %v, %d", tt.in, gotCopy, got, tt.wantCopy, tt.want)
+		}
+	}
+}
+
+func TestUDPPortPrefsCheck(t *testing.T) {
+	tests := []struct {
+		up      UDPPortPrefs
+		wantErr bool
+	}{
+		{UDPPortPrefs{}, false},
+		{UDPPortPrefs{RangeFirst: 41000, RangeLast: 41099}, false},
+		{UDPPortPrefs{RangeFirst: 41641, RangeLast: 41641}, false},
+		{UDPPortPrefs{RotateEvery: time.Hour, RotateOnPathFailure: true}, false},
+		{UDPPortPrefs{RangeFirst: 41000}, true},
+		{UDPPortPrefs{RangeLast: 41099}, true},
+		{UDPPortPrefs{RangeFirst: 41099, RangeLast: 41000}, true},
+		{UDPPortPrefs{RotateEvery: time.Second}, true},
+		{UDPPortPrefs{RotateEvery: -time.Hour}, true},
+	}
+	for _, tt := range tests {
+		err := tt.up.Check()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%+v.Check() = %v; want error: %v", tt.up, err, tt.wantErr)
+		}
+	}
+}
+
+func TestHalfOpenLimitsPrefsCheck(t *testing.T) {
+	tests := []struct {
+		hl      HalfOpenLimitsPrefs
+		wantErr bool
+	}{
+		{HalfOpenLimitsPrefs{}, false},
+		{HalfOpenLimitsPrefs{MaxPerPeer: 1024, MaxTotal: 16384, Timeout: time.Minute, EvictOldest: true}, false},
+		{HalfOpenLimitsPrefs{MaxPerPeer: -1}, true},
+		{HalfOpenLimitsPrefs{MaxTotal: -1}, true},
+		{HalfOpenLimitsPrefs{MaxTotal: 10, Timeout: -time.Second}, true},
+	}
+	for _, tt := range tests {
+		err := tt.hl.Check()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%+v.Check() = %v; want error: %v", tt.hl, err, tt.wantErr)
+		}
+	}
+}
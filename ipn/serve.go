@@ -93,7 +93,22 @@ type FunnelConn struct {
 
 // WebServerConfig describes a web server's configuration.
 type WebServerConfig struct {
-	Handlers map[string]*HTTPHandler // mountPoint => handler
+	// Handlers maps from mount point ("/", "/foo", etc) to its handler.
+	// A mount point may be prefixed by an HTTP method and a space, as in
+	// "POST /foo", to only handle requests with that method. Such a
+	// handler takes precedence over one for the same mount point without
+	// a method. See MountPointForMethod.
+	Handlers map[string]*HTTPHandler
+}
+
+// MountPointForMethod returns the WebServerConfig.Handlers key for the
+// handler of requests with the given method at mount. An empty method
+// returns mount unchanged.
+func MountPointForMethod(method, mount string) string {
+	if method == "" {
+		return mount
+	}
+	return method + " " + mount
 }
 
 // TCPPortHandler describes what to do when handling a TCP
@@ -134,8 +149,45 @@ type HTTPHandler struct {
 
 	Text string `json:",omitempty"` // plaintext to serve (primarily for testing)
 
-	// TODO(bradfitz): bool to not enumerate directories? TTL on mapping for
-	// temporary ones? Error codes? Redirects?
+	// RewritePath, if non-empty, replaces the mount point at the start of
+	// the path of requests sent to Proxy. By default, the mount point is
+	// removed.
+	RewritePath string `json:",omitempty"`
+
+	// RequestHeaders are header values to set on requests sent to Proxy,
+	// replacing those from the client. An empty value removes the header.
+	// Values may refer to the client with {user.login}, {user.name},
+	// {node.name} and {node.ip}; the user references are empty for tagged
+	// nodes, and all of them are empty for Funnel traffic. The
+	// Tailscale-User-* identity headers can't be replaced.
+	RequestHeaders map[string]string `json:",omitempty"`
+
+	// ResponseHeaders are header values to set on responses, replacing
+	// those from Proxy. An empty value removes the header.
+	ResponseHeaders map[string]string `json:",omitempty"`
+
+	// IndexFiles are the names of the files to serve for requests for a
+	// directory of Path, in order of preference. If empty, it's
+	// index.html.
+	IndexFiles []string `json:",omitempty"`
+
+	// SPA, if true, means that GET requests for paths without a file
+	// extension that don't exist in the directory of Path are served its
+	// root index file, so that a single-page app can route them.
+	SPA bool `json:",omitempty"`
+
+	// NoListing, if true, means that directories of Path without an
+	// index file aren't listed.
+	NoListing bool `json:",omitempty"`
+
+	// CacheControl, if non-empty, is the Cache-Control header value of
+	// the files served from Path. Index files are instead served with
+	// "no-cache", so that changes to them and to the names of the files
+	// they refer to are picked up.
+	CacheControl string `json:",omitempty"`
+
+	// TODO(bradfitz): TTL on mapping for temporary ones? Error codes?
+	// Redirects?
 }
 
 // WebHandlerExists reports whether if the ServeConfig Web handler exists for
@@ -0,0 +1,55 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"net/netip"
+	"time"
+)
+
+// ServeAccess is a record of an HTTP request served per a ServeConfig,
+// from the tailnet or from the internet via Funnel. It's a line of the
+// serve access log, as streamed by the LocalAPI serve-access-log endpoint.
+type ServeAccess struct {
+	// Time is when the request was received.
+	Time time.Time
+
+	// Host is the SNI name and port the request was for.
+	Host HostPort
+
+	// Route is the mount point of the handler that served the request,
+	// as in WebServerConfig.Handlers. It's empty if no handler matched.
+	Route string `json:",omitempty"`
+
+	// Funnel is whether the request came from the internet via Funnel.
+	Funnel bool `json:",omitempty"`
+
+	// Src is the address of the client.
+	Src netip.AddrPort
+
+	// Node is the name of the client's node, if it's in the tailnet.
+	Node string `json:",omitempty"`
+
+	// User is the login name of the client's user, if it's in the
+	// tailnet and not tagged.
+	User string `json:",omitempty"`
+
+	// Method and Path are the request's HTTP method and URL path.
+	// The query isn't recorded.
+	Method string
+	Path   string
+
+	// Status is the HTTP status code of the response.
+	Status int
+
+	// LatencyMS is how long it took to serve the request, in
+	// milliseconds. For upgraded connections, such as WebSockets, it's
+	// how long the connection lasted.
+	LatencyMS float64
+
+	// BytesIn and BytesOut are the sizes of the request and response
+	// bodies.
+	BytesIn  int64
+	BytesOut int64
+}
@@ -53,6 +53,16 @@
 	// CurrentProfileStateKey is the key under which we store the current
 	// profile.
 	CurrentProfileStateKey = StateKey("_current-profile")
+
+	// LocalAPITokensStateKey is the key under which we store the LocalAPI
+	// access tokens, of all profiles. The value is JSON; the tokens'
+	// secrets are only stored hashed.
+	LocalAPITokensStateKey = StateKey("_localapi-tokens")
+
+	// ConnApprovalsStateKey is the key under which we store the
+	// decisions on incoming connections made while Prefs.ConnApproval
+	// is set. The value is a JSON-encoded []ConnApprovalRule.
+	ConnApprovalsStateKey = StateKey("_conn-approvals")
 )
 
 // CurrentProfileID returns the StateKey that stores the
@@ -66,6 +76,14 @@ func CurrentProfileKey(userID string) StateKey {
 	return StateKey("_current/" + userID)
 }
 
+// AppConnectorLearnedRoutesKey returns the StateKey that stores the routes
+// the app connector learned from DNS answers and added to the advertised
+// routes of the profile with the given ID, to tell them apart from the
+// user's own. The value is a JSON-encoded []netip.Prefix.
+func AppConnectorLearnedRoutesKey(id ProfileID) StateKey {
+	return StateKey("_appc-learned-routes/" + string(id))
+}
+
 // StateStore persists state, and produces it back on request.
 type StateStore interface {
 	// ReadState returns the bytes associated with ID. Returns (nil,
@@ -41,9 +41,21 @@ type Config struct {
 	// it to resolve, you also need to add appropriate routes to
 	// Routes.
 	Hosts map[dnsname.FQDN][]netip.Addr
+	// Records maps DNS FQDNs to their records other than A and
+	// AAAA, such as the SRV and TXT records nodes publish. Like
+	// Hosts, they need appropriate Routes to resolve.
+	Records map[dnsname.FQDN]*resolver.Records
 	// OnlyIPv6, if true, uses the IPv6 service IP (for MagicDNS)
 	// instead of the IPv4 version (100.100.100.100).
 	OnlyIPv6 bool
+	// Cache is the policy of 100.100.100.100's cache of responses
+	// from upstream resolvers. The zero value disables it.
+	Cache resolver.CacheConfig
+	// Blocklist configures the blocklists of names 100.100.100.100
+	// answers NXDOMAIN for. It only applies to queries the OS sends
+	// to 100.100.100.100: all of them with DefaultResolvers, otherwise
+	// those within Routes.
+	Blocklist resolver.BlocklistConfig
 }
 
 func (c *Config) serviceIP() netip.Addr {
@@ -64,6 +76,15 @@ func (c *Config) WriteToBufioWriter(w *bufio.Writer) {
 
 	fmt.Fprintf(w, " SearchDomains:%v", c.SearchDomains)
 	fmt.Fprintf(w, " Hosts:%v", len(c.Hosts))
+	if len(c.Records) > 0 {
+		fmt.Fprintf(w, " Records:%v", len(c.Records))
+	}
+	if c.Cache.Enabled() {
+		fmt.Fprintf(w, " Cache:%v", c.Cache)
+	}
+	if c.Blocklist.Enabled() {
+		fmt.Fprintf(w, " Blocklist:%v", c.Blocklist)
+	}
 	w.WriteString("}")
 }
 
@@ -92,8 +113,8 @@ func (c Config) hasDefaultIPResolversOnly() bool {
 	return true
 }
 
-// hasHostsWithoutSplitDNSRoutes reports whether c contains any Host entries
-// that aren't covered by a SplitDNS route suffix.
+// hasHostsWithoutSplitDNSRoutes reports whether c contains any Host or
+// Records entries that aren't covered by a SplitDNS route suffix.
 func (c Config) hasHostsWithoutSplitDNSRoutes() bool {
 	// TODO(bradfitz): this could be more efficient, but we imagine
 	// the number of SplitDNS routes and/or hosts will be small.
@@ -102,6 +123,11 @@ func (c Config) hasHostsWithoutSplitDNSRoutes() bool {
 			return true
 		}
 	}
+	for name := range c.Records {
+		if !c.hasSplitDNSRouteForHost(name) {
+			return true
+		}
+	}
 	return false
 }
 
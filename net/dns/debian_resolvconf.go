@@ -133,6 +133,8 @@ func (m *resolvconfManager) SetDNS(config OSConfig) error {
 	return nil
 }
 
+func (m *resolvconfManager) Mode() string { return "debian-resolvconf" }
+
 func (m *resolvconfManager) SupportsSplitDNS() bool {
 	return false
 }
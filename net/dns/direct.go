@@ -288,7 +288,11 @@ func (m *directManager) setWant(want []byte) {
 	m.wantResolvConf = want
 }
 
-var warnTrample = health.NewWarnable()
+var warnTrample = health.NewWarnable(health.WithCheck("dns-resolv-conf", health.SeverityWarning, health.Remediation{
+	Code: "stop-resolv-conf-fight",
+	Text: "Configure the other program managing /etc/resolv.conf to leave it alone, or use systemd-resolved.",
+	URL:  "https://tailscale.com/s/dns-fight",
+}))
 
 // checkForFileTrample checks whether /etc/resolv.conf has been trampled
 // by another program on the system. (e.g. a DHCP client)
@@ -400,6 +404,8 @@ func (m *directManager) SetDNS(config OSConfig) (err error) {
 	return nil
 }
 
+func (m *directManager) Mode() string { return "direct" }
+
 func (m *directManager) SupportsSplitDNS() bool {
 	return false
 }
@@ -8,6 +8,7 @@
 	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/netip"
@@ -22,6 +23,7 @@
 	"tailscale.com/net/dns/resolver"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/tsdial"
+	"tailscale.com/syncs"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/clientmetric"
@@ -63,6 +65,9 @@ type Manager struct {
 
 	resolver *resolver.Resolver
 	os       OSConfigurator
+
+	// searchDomains are the search domains last set on the OS.
+	searchDomains syncs.AtomicValue[[]dnsname.FQDN]
 }
 
 // NewManagers created a new manager from the given config.
@@ -105,6 +110,7 @@ func (m *Manager) Set(cfg Config) error {
 	if err := m.resolver.SetConfig(rcfg); err != nil {
 		return err
 	}
+	m.searchDomains.Store(ocfg.SearchDomains)
 	if err := m.os.SetDNS(ocfg); err != nil {
 		health.SetDNSOSHealth(err)
 		return err
@@ -163,6 +169,9 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 	// authoritative suffixes, even if we don't propagate MagicDNS to
 	// the OS.
 	rcfg.Hosts = cfg.Hosts
+	rcfg.Records = cfg.Records
+	rcfg.Cache = cfg.Cache
+	rcfg.Blocklist = cfg.Blocklist
 	routes := map[dnsname.FQDN][]*dnstype.Resolver{} // assigned conditionally to rcfg.Routes below.
 	for suffix, resolvers := range cfg.Routes {
 		if len(resolvers) == 0 {
@@ -185,13 +194,14 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 		// case where cfg is entirely zero, in which case these
 		// configs clear all Tailscale DNS settings.
 		return rcfg, ocfg, nil
-	case cfg.hasDefaultIPResolversOnly() && !cfg.hasHostsWithoutSplitDNSRoutes():
+	case cfg.hasDefaultIPResolversOnly() && !cfg.hasHostsWithoutSplitDNSRoutes() && !cfg.Blocklist.Enabled():
 		// Trivial CorpDNS configuration, just override the OS resolver.
 		//
 		// If there are hosts (ExtraRecords) that are not covered by an existing
 		// SplitDNS route, then we don't go into this path so that we fall into
 		// the next case and send the extra record hosts queries through
-		// 100.100.100.100 instead where we can answer them.
+		// 100.100.100.100 instead where we can answer them. Likewise
+		// with blocklists, which only 100.100.100.100 can apply.
 		//
 		// TODO: for OSes that support it, pass IP:port and DoH
 		// addresses directly to OS.
@@ -228,7 +238,7 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 	// This bool is used in a couple of places below to implement this
 	// workaround.
 	isWindows := runtime.GOOS == "windows"
-	if cfg.singleResolverSet() != nil && m.os.SupportsSplitDNS() && !isWindows {
+	if cfg.singleResolverSet() != nil && m.os.SupportsSplitDNS() && !isWindows && !cfg.Blocklist.Enabled() {
 		// Split DNS configuration requested, where all split domains
 		// go to the same resolvers. We can let the OS do it.
 		ocfg.Nameservers = toIPsOnly(cfg.singleResolverSet())
@@ -461,6 +471,24 @@ func (m *Manager) FlushCaches() error {
 	return flushCaches()
 }
 
+// FlushCache empties 100.100.100.100's cache of responses from upstream
+// resolvers. Unlike FlushCaches, it leaves the OS's caches alone.
+func (m *Manager) FlushCache() {
+	m.resolver.FlushCache()
+}
+
+// OSConfiguratorStatus reports how m configures the OS's DNS settings.
+func (m *Manager) OSConfiguratorStatus() *dnstype.OSConfiguratorStatus {
+	st := &dnstype.OSConfiguratorStatus{
+		Mode:             fmt.Sprintf("%T", m.os),
+		SupportsSplitDNS: m.os.SupportsSplitDNS(),
+	}
+	if mc, ok := m.os.(interface{ Mode() string }); ok {
+		st.Mode = mc.Mode()
+	}
+	return st
+}
+
 // Cleanup restores the system DNS configuration to its original state
 // in case the Tailscale daemon terminated without closing the router.
 // No other state needs to be instantiated before this runs.
@@ -8,12 +8,44 @@
 	"os"
 
 	"go4.org/mem"
+	"tailscale.com/envknob"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/mak"
 )
 
 func NewOSConfigurator(logf logger.Logf, ifName string) (OSConfigurator, error) {
-	return &darwinConfigurator{logf: logf, ifName: ifName}, nil
+	files := &darwinConfigurator{logf: logf, ifName: ifName}
+	mode := darwinDNSMode(ifName)
+	logf("dns: using %q mode", mode)
+	if mode == "resolver-files" {
+		return files, nil
+	}
+	// Remove any /etc/resolver files an earlier tailscaled left behind,
+	// which would otherwise override the scoped resolvers.
+	if err := files.removeResolverFiles(func(string) bool { return true }); err != nil {
+		logf("dns: removing old resolver files: %v", err)
+	}
+	return newScutilManager(logf, ifName), nil
+}
+
+// darwinDNSMode returns the mode to configure DNS with: "resolver-files",
+// the default, to write /etc/resolver files, or "scutil", to publish it
+// in the SystemConfiguration dynamic store scoped to the interface
+// ifName. The scutil mode is experimental and only used if
+// TS_DNS_DARWIN_MODE=scutil.
+func darwinDNSMode(ifName string) string {
+	if envknob.String("TS_DNS_DARWIN_MODE") != "scutil" {
+		return "resolver-files"
+	}
+	if ifName == "" {
+		// No interface to scope resolvers to, as with
+		// --tun=userspace-networking.
+		return "resolver-files"
+	}
+	if _, err := os.Stat(scutilPath); err != nil {
+		return "resolver-files"
+	}
+	return "scutil"
 }
 
 // darwinConfigurator is the tailscaled-on-macOS DNS OS configurator that
@@ -29,6 +61,8 @@ func (c *darwinConfigurator) Close() error {
 	return nil
 }
 
+func (c *darwinConfigurator) Mode() string { return "resolver-files" }
+
 func (c *darwinConfigurator) SupportsSplitDNS() bool {
 	return true
 }
@@ -399,6 +399,40 @@ func TestManager(t *testing.T) {
 				MatchDomains:  fqdns("corp.com"),
 			},
 		},
+		{
+			name: "corp-blocklist",
+			in: Config{
+				DefaultResolvers: mustRes("1.1.1.1", "9.9.9.9"),
+				SearchDomains:    fqdns("tailscale.com", "universe.tf"),
+				Blocklist:        resolver.BlocklistConfig{Sources: []string{"/nonexistent/blocklist"}},
+			},
+			os: OSConfig{
+				Nameservers:   mustIPs("100.100.100.100"),
+				SearchDomains: fqdns("tailscale.com", "universe.tf"),
+			},
+			rs: resolver.Config{
+				Routes:    upstreams(".", "1.1.1.1", "9.9.9.9"),
+				Blocklist: resolver.BlocklistConfig{Sources: []string{"/nonexistent/blocklist"}},
+			},
+		},
+		{
+			name: "routes-split-blocklist",
+			in: Config{
+				Routes:        upstreams("corp.com", "2.2.2.2"),
+				SearchDomains: fqdns("tailscale.com", "universe.tf"),
+				Blocklist:     resolver.BlocklistConfig{Sources: []string{"/nonexistent/blocklist"}},
+			},
+			split: true,
+			os: OSConfig{
+				Nameservers:   mustIPs("100.100.100.100"),
+				SearchDomains: fqdns("tailscale.com", "universe.tf"),
+				MatchDomains:  fqdns("corp.com"),
+			},
+			rs: resolver.Config{
+				Routes:    upstreams("corp.com.", "2.2.2.2"),
+				Blocklist: resolver.BlocklistConfig{Sources: []string{"/nonexistent/blocklist"}},
+			},
+		},
 		{
 			name: "routes-multi",
 			in: Config{
@@ -382,6 +382,8 @@ func (m *windowsManager) SetDNS(cfg OSConfig) error {
 	return nil
 }
 
+func (m *windowsManager) Mode() string { return "windows" }
+
 func (m *windowsManager) SupportsSplitDNS() bool {
 	return m.nrptDB != nil
 }
@@ -258,6 +258,8 @@ func (m *nmManager) trySet(ctx context.Context, config OSConfig) error {
 	return nil
 }
 
+func (m *nmManager) Mode() string { return "network-manager" }
+
 func (m *nmManager) SupportsSplitDNS() bool {
 	var mode string
 	v, err := m.dnsManager.GetProperty("org.freedesktop.NetworkManager.DnsManager.Mode")
@@ -6,6 +6,7 @@
 type noopManager struct{}
 
 func (m noopManager) SetDNS(OSConfig) error  { return nil }
+func (m noopManager) Mode() string           { return "noop" }
 func (m noopManager) SupportsSplitDNS() bool { return false }
 func (m noopManager) Close() error           { return nil }
 func (m noopManager) GetBaseConfig() (OSConfig, error) {
@@ -46,6 +46,8 @@ func (m openresolvManager) SetDNS(config OSConfig) error {
 	return nil
 }
 
+func (m openresolvManager) Mode() string { return "openresolv" }
+
 func (m openresolvManager) SupportsSplitDNS() bool {
 	return false
 }
@@ -68,6 +68,8 @@ func (m *resolvdManager) SetDNS(config OSConfig) error {
 	return cmd.Run()
 }
 
+func (m *resolvdManager) Mode() string { return "resolvd" }
+
 func (m *resolvdManager) SupportsSplitDNS() bool {
 	return false
 }
@@ -388,6 +388,8 @@ func (m *resolvedManager) setConfigOverDBus(ctx context.Context, rManager dbus.B
 	return nil
 }
 
+func (m *resolvedManager) Mode() string { return "systemd-resolved" }
+
 func (m *resolvedManager) SupportsSplitDNS() bool {
 	return true
 }
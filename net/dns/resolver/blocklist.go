@@ -0,0 +1,381 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/net/tsdial"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/mak"
+)
+
+// BlocklistConfig configures the resolver's blocking of queries for
+// names on blocklists, such as of ad or malware domains.
+// The zero value blocks nothing.
+type BlocklistConfig struct {
+	// Sources are the blocklists, as absolute paths of local files or
+	// HTTPS URLs. See parseBlocklist for the formats understood.
+	Sources []string
+
+	// NullIP is whether to answer A and AAAA queries for blocked names
+	// with 0.0.0.0 and :: instead of NXDOMAIN. Queries of other types
+	// for blocked names get NXDOMAIN either way.
+	NullIP bool
+}
+
+// Enabled reports whether c blocks any names.
+func (c BlocklistConfig) Enabled() bool { return len(c.Sources) > 0 }
+
+// Equal reports whether c and o are the same configuration.
+func (c BlocklistConfig) Equal(o BlocklistConfig) bool {
+	return c.NullIP == o.NullIP && slices.Equal(c.Sources, o.Sources)
+}
+
+func (c BlocklistConfig) String() string {
+	if !c.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("{Sources:%q NullIP:%v}", c.Sources, c.NullIP)
+}
+
+// CheckBlocklistSource reports whether src is a valid blocklist source:
+// an absolute file path or an HTTPS URL.
+func CheckBlocklistSource(src string) error {
+	switch {
+	case strings.HasPrefix(src, "https://"):
+		if len(src) == len("https://") {
+			return fmt.Errorf("blocklist URL %q has no host", src)
+		}
+		return nil
+	case strings.Contains(src, "://"):
+		return fmt.Errorf("blocklist %q: only https:// URLs are supported", src)
+	case !filepath.IsAbs(src):
+		return fmt.Errorf("blocklist %q: file path must be absolute", src)
+	}
+	return nil
+}
+
+const (
+	// blocklistRefreshInterval is how often blocklists are reloaded.
+	blocklistRefreshInterval = 12 * time.Hour
+
+	// maxBlocklistSize is the largest blocklist loaded, in bytes.
+	maxBlocklistSize = 64 << 20
+)
+
+// blockSet is a parsed blocklist.
+type blockSet struct {
+	names map[dnsname.FQDN]bool // blocked names
+	zones map[dnsname.FQDN]bool // names whose subdomains, but not themselves, are blocked
+}
+
+func (s *blockSet) len() int { return len(s.names) + len(s.zones) }
+
+// blocks reports whether s blocks name.
+func (s *blockSet) blocks(name dnsname.FQDN) bool {
+	if s.names[name] {
+		return true
+	}
+	if len(s.zones) == 0 {
+		return false
+	}
+	for n := string(name); ; {
+		_, parent, ok := strings.Cut(n, ".")
+		if !ok || parent == "" {
+			return false
+		}
+		if s.zones[dnsname.FQDN(parent)] {
+			return true
+		}
+		n = parent
+	}
+}
+
+// parseBlocklist parses a blocklist in one of these formats, which may
+// be mixed:
+//
+//   - hosts file lines, such as "0.0.0.0 ads.example.com", blocking
+//     the names after the IP address, with '#' starting comments.
+//   - lines of just a name, blocking that name.
+//   - RPZ zone file records, such as "ads.example.com CNAME ." or
+//     "*.ads.example.com CNAME .", with ';' starting comments. Other
+//     records and RPZ actions are ignored.
+func parseBlocklist(r io.Reader) (*blockSet, error) {
+	s := &blockSet{
+		names: map[dnsname.FQDN]bool{},
+		zones: map[dnsname.FQDN]bool{},
+	}
+	var origin string // RPZ zone file $ORIGIN, with trailing dot
+	inParens := false // within a multi-line RPZ record, such as the SOA
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 64<<10)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if inParens {
+			inParens = !strings.Contains(line, ")")
+			continue
+		}
+		if strings.Contains(line, "(") && !strings.Contains(line, ")") {
+			inParens = true
+			continue
+		}
+		if strings.EqualFold(fields[0], "$ORIGIN") && len(fields) == 2 {
+			origin = strings.ToLower(strings.TrimSuffix(fields[1], ".")) + "."
+			continue
+		}
+		if strings.HasPrefix(fields[0], "$") {
+			continue
+		}
+
+		if _, err := netip.ParseAddr(fields[0]); err == nil {
+			for _, name := range fields[1:] {
+				s.add(name, false)
+			}
+			continue
+		}
+		if len(fields) == 1 {
+			if strings.Contains(strings.TrimSuffix(fields[0], "."), ".") {
+				s.add(fields[0], false)
+			}
+			continue
+		}
+		// An RPZ record: owner [TTL] [class] CNAME target.
+		i := slices.IndexFunc(fields, func(f string) bool { return strings.EqualFold(f, "CNAME") })
+		if i < 1 || i != len(fields)-2 {
+			continue
+		}
+		if target := fields[i+1]; target != "." && target != "*." {
+			continue // not NXDOMAIN or NODATA; such as rpz-passthru.
+		}
+		name := strings.ToLower(fields[0])
+		if origin != "" {
+			if strings.HasSuffix(name, "."+origin) {
+				name = strings.TrimSuffix(name, origin)
+			} else if !strings.HasSuffix(name, ".") {
+				name += "." // relative to origin, which is the RPZ zone
+			}
+		}
+		wildcard := strings.HasPrefix(name, "*.")
+		s.add(strings.TrimPrefix(name, "*."), wildcard)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// add adds name to s, or its subdomains if zone is set, ignoring
+// names that aren't valid or are local.
+func (s *blockSet) add(name string, zone bool) {
+	fqdn, err := dnsname.ToFQDN(strings.ToLower(name))
+	if err != nil || fqdn == "." {
+		return
+	}
+	switch fqdn {
+	case "localhost.", "localhost.localdomain.", "local.", "broadcasthost.", "ip6-localhost.", "ip6-loopback.":
+		return
+	}
+	if zone {
+		s.zones[fqdn] = true
+	} else {
+		s.names[fqdn] = true
+	}
+}
+
+// blocklist is the resolver's set of blocklists, which it loads and
+// refreshes in the background.
+type blocklist struct {
+	logf logger.Logf
+
+	// fetch returns the contents of a blocklist source. It's a field
+	// for tests.
+	fetch func(ctx context.Context, src string) ([]byte, error)
+
+	mu   sync.Mutex
+	cfg  BlocklistConfig
+	sets map[string]*blockSet // by source; missing until loaded
+	stop context.CancelFunc   // stops loading cfg's sources; nil if none
+}
+
+func newBlocklist(logf logger.Logf, dialer *tsdial.Dialer) *blocklist {
+	hc := &http.Client{
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         dialer.SystemDial,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		Timeout: time.Minute,
+	}
+	return &blocklist{
+		logf: logf,
+		fetch: func(ctx context.Context, src string) ([]byte, error) {
+			return fetchBlocklist(ctx, hc, src)
+		},
+	}
+}
+
+// setConfig sets the blocklists to use, loading any that changed in
+// the background.
+func (b *blocklist) setConfig(cfg BlocklistConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if cfg.Equal(b.cfg) {
+		return
+	}
+	sourcesChanged := !slices.Equal(cfg.Sources, b.cfg.Sources)
+	b.cfg = BlocklistConfig{
+		Sources: slices.Clone(cfg.Sources),
+		NullIP:  cfg.NullIP,
+	}
+	if !sourcesChanged {
+		return
+	}
+	if b.stop != nil {
+		b.stop()
+		b.stop = nil
+	}
+	for src := range b.sets {
+		if !slices.Contains(cfg.Sources, src) {
+			delete(b.sets, src)
+		}
+	}
+	b.updateMetricsLocked()
+	if !cfg.Enabled() {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b.stop = cancel
+	go b.refreshLoop(ctx, b.cfg.Sources)
+}
+
+// close stops loading blocklists.
+func (b *blocklist) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stop != nil {
+		b.stop()
+		b.stop = nil
+	}
+}
+
+// refreshLoop loads sources until ctx is done.
+func (b *blocklist) refreshLoop(ctx context.Context, sources []string) {
+	t := time.NewTicker(blocklistRefreshInterval)
+	defer t.Stop()
+	for {
+		for _, src := range sources {
+			b.load(ctx, src)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// load loads the blocklist src. If that fails, the previously loaded
+// version of it, if any, stays in use.
+func (b *blocklist) load(ctx context.Context, src string) {
+	data, err := b.fetch(ctx, src)
+	var set *blockSet
+	if err == nil {
+		set, err = parseBlocklist(bytes.NewReader(data))
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			metricDNSBlocklistLoadError.Add(1)
+			b.logf("blocklist %s: %v", src, err)
+		}
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ctx.Err() != nil {
+		return // superseded by a newer config
+	}
+	mak.Set(&b.sets, src, set)
+	b.updateMetricsLocked()
+	b.logf("blocklist %s: loaded %d names", src, set.len())
+}
+
+func (b *blocklist) updateMetricsLocked() {
+	n := 0
+	for _, s := range b.sets {
+		n += s.len()
+	}
+	metricDNSBlocklistNames.Set(int64(n))
+}
+
+// blocked reports whether a blocklist blocks name, and if so, whether
+// to answer with null IPs rather than NXDOMAIN.
+func (b *blocklist) blocked(name dnsname.FQDN) (blocked, nullIP bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range b.sets {
+		if s.blocks(name) {
+			return true, b.cfg.NullIP
+		}
+	}
+	return false, false
+}
+
+// fetchBlocklist returns the contents of the blocklist src, fetching it
+// with hc if it's a URL.
+func fetchBlocklist(ctx context.Context, hc *http.Client, src string) ([]byte, error) {
+	var r io.Reader
+	if strings.HasPrefix(src, "https://") {
+		req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := hc.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected HTTP status %v", res.Status)
+		}
+		r = res.Body
+	} else {
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxBlocklistSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxBlocklistSize {
+		return nil, errors.New("blocklist too large")
+	}
+	return data, nil
+}
@@ -0,0 +1,148 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/util/dnsname"
+)
+
+func TestParseBlocklist(t *testing.T) {
+	const list = `
+# A hosts file.
+127.0.0.1 localhost
+0.0.0.0 ads.example.com tracker.example.com # trailing comment
+::      v6ads.example.com
+
+# A list of names.
+Malware.example.net
+notaname
+
+; An RPZ zone.
+$TTL 300
+$ORIGIN rpz.example.org.
+@ SOA ns.rpz.example.org. hostmaster.rpz.example.org. (
+	1 ; serial
+	3600 ; refresh
+	600 1209600 300 )
+@ IN NS ns.rpz.example.org.
+bad.example.com CNAME .
+*.bad.example.com 300 IN CNAME .
+nodata.example.com CNAME *.
+good.example.com CNAME rpz-passthru.
+fq.example.com.rpz.example.org. CNAME .
+`
+	s, err := parseBlocklist(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[dnsname.FQDN]bool{
+		"ads.example.com.":       true,
+		"tracker.example.com.":   true,
+		"v6ads.example.com.":     true,
+		"malware.example.net.":   true,
+		"bad.example.com.":       true,
+		"x.bad.example.com.":     true,
+		"y.x.bad.example.com.":   true,
+		"nodata.example.com.":    true,
+		"fq.example.com.":        true,
+		"localhost.":             false,
+		"sub.ads.example.com.":   false,
+		"example.com.":           false,
+		"good.example.com.":      false,
+		"notaname.":              false,
+		"1.":                     false,
+		"ns.rpz.example.org.":    false,
+		"hostmaster.example.org": false,
+	} {
+		if got := s.blocks(name); got != want {
+			t.Errorf("blocks(%q) = %v; want %v", name, got, want)
+		}
+	}
+}
+
+func TestCheckBlocklistSource(t *testing.T) {
+	for src, ok := range map[string]bool{
+		"https://example.com/hosts": true,
+		"/etc/tailscale/blocklist":  true,
+		"https://":                  false,
+		"http://example.com/hosts":  false,
+		"relative/path":             false,
+	} {
+		if err := CheckBlocklistSource(src); (err == nil) != ok {
+			t.Errorf("CheckBlocklistSource(%q) = %v; want ok=%v", src, err, ok)
+		}
+	}
+}
+
+func TestResolverBlocklist(t *testing.T) {
+	r := newResolver(t)
+	defer r.Close()
+	r.blocklist.fetch = func(ctx context.Context, src string) ([]byte, error) {
+		if src == "/good" {
+			return []byte("0.0.0.0 ads.example.com\n"), nil
+		}
+		return nil, errors.New("not found")
+	}
+	cfg := Config{
+		Blocklist: BlocklistConfig{Sources: []string{"/missing", "/good"}},
+	}
+	r.SetConfig(cfg)
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if blocked, _ := r.blocklist.blocked("ads.example.com."); blocked {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout loading blocklists")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	newQuery := func(name string, typ dns.Type) []byte {
+		t.Helper()
+		b := dns.NewBuilder(nil, dns.Header{ID: 1, RecursionDesired: true})
+		b.StartQuestions()
+		b.Question(dns.Question{Name: dns.MustNewName(name), Type: typ, Class: dns.ClassINET})
+		q, err := b.Finish()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return q
+	}
+	query := func(name string, typ dns.Type) (dns.RCode, []dns.Resource) {
+		t.Helper()
+		res, err := r.respond(newQuery(name, typ))
+		if err != nil {
+			t.Fatalf("respond(%s): %v", name, err)
+		}
+		var msg dns.Message
+		if err := msg.Unpack(res); err != nil {
+			t.Fatal(err)
+		}
+		return msg.RCode, msg.Answers
+	}
+
+	if rcode, _ := query("ads.example.com.", dns.TypeA); rcode != dns.RCodeNameError {
+		t.Errorf("blocked A rcode = %v; want NXDOMAIN", rcode)
+	}
+	if _, err := r.respond(newQuery("example.com.", dns.TypeA)); err != errNotOurName {
+		t.Errorf("unblocked name: err = %v; want errNotOurName", err)
+	}
+
+	cfg.Blocklist.NullIP = true
+	r.SetConfig(cfg)
+	rcode, ans := query("ads.example.com.", dns.TypeAAAA)
+	if rcode != dns.RCodeSuccess || len(ans) != 1 || ans[0].Body.(*dns.AAAAResource).AAAA != [16]byte{} {
+		t.Errorf("blocked AAAA with NullIP = %v %v; want ::", rcode, ans)
+	}
+	if rcode, _ := query("ads.example.com.", dns.TypeTXT); rcode != dns.RCodeNameError {
+		t.Errorf("blocked TXT with NullIP rcode = %v; want NXDOMAIN", rcode)
+	}
+}
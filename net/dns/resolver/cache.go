@@ -0,0 +1,317 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/util/lru"
+)
+
+// CacheConfig is the policy of the resolver's cache of forwarded
+// responses. The zero value disables the cache.
+type CacheConfig struct {
+	// MaxEntries is the maximum number of responses to cache. Zero
+	// disables the cache.
+	MaxEntries int
+
+	// MinTTL and MaxTTL, if non-zero, clamp how long responses are
+	// cached, whatever their TTLs.
+	MinTTL time.Duration
+	MaxTTL time.Duration
+}
+
+// Enabled reports whether c enables the cache.
+func (c CacheConfig) Enabled() bool { return c.MaxEntries > 0 }
+
+// clampTTL returns ttl clamped to c's MinTTL and MaxTTL.
+func (c CacheConfig) clampTTL(ttl time.Duration) time.Duration {
+	if c.MinTTL != 0 && ttl < c.MinTTL {
+		ttl = c.MinTTL
+	}
+	if c.MaxTTL != 0 && ttl > c.MaxTTL {
+		ttl = c.MaxTTL
+	}
+	return ttl
+}
+
+func (c CacheConfig) String() string {
+	if !c.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("{MaxEntries:%d MinTTL:%v MaxTTL:%v}", c.MaxEntries, c.MinTTL, c.MaxTTL)
+}
+
+// cacheKey is the key of a cached response: the query's question, and
+// what affects the size of the response the client can take.
+type cacheKey struct {
+	name   string // as in the query, not case-folded, to echo it back
+	typ    dns.Type
+	class  dns.Class
+	family string // "udp" or "tcp"
+	edns   bool   // whether the query has an additional section, such as an OPT record
+}
+
+// cacheEntry is a cached response.
+type cacheEntry struct {
+	res     []byte
+	ttlOffs []int // offsets in res of the TTLs of its records, excluding OPT
+	ttls    []uint32
+	added   time.Time
+	expires time.Time
+}
+
+// responseCache is the resolver's cache of forwarded responses, both
+// positive and negative (RFC 2308). It's safe for concurrent use.
+type responseCache struct {
+	mu  sync.Mutex
+	cfg CacheConfig
+	lru *lru.Cache[cacheKey, *cacheEntry] // nil if disabled
+}
+
+// setConfig sets the cache's policy. If the policy changed or flush is
+// true, as it is when the upstreams changed, the cache is emptied.
+func (c *responseCache) setConfig(cfg CacheConfig, flush bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cfg == c.cfg && !flush {
+		return
+	}
+	c.cfg = cfg
+	c.lru = nil
+	if cfg.Enabled() {
+		c.lru = &lru.Cache[cacheKey, *cacheEntry]{MaxEntries: cfg.MaxEntries}
+	}
+}
+
+// flush removes all entries from the cache.
+func (c *responseCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru != nil {
+		c.lru = &lru.Cache[cacheKey, *cacheEntry]{MaxEntries: c.cfg.MaxEntries}
+	}
+}
+
+// enabled reports whether the cache is enabled.
+func (c *responseCache) enabled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru != nil
+}
+
+// queryCacheKey returns the cache key for query, received over family.
+func queryCacheKey(query []byte, family string) (k cacheKey, ok bool) {
+	var p dns.Parser
+	h, err := p.Start(query)
+	if err != nil || h.Response {
+		return k, false
+	}
+	q, err := p.Question()
+	if err != nil {
+		return k, false
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return k, false
+	}
+	return cacheKey{
+		name:   q.Name.String(),
+		typ:    q.Type,
+		class:  q.Class,
+		family: family,
+		edns:   binary.BigEndian.Uint16(query[10:12]) > 0,
+	}, true
+}
+
+// get returns the cached response to query at now, if any, with the
+// query's ID and TTLs counted down.
+func (c *responseCache) get(query []byte, family string, now time.Time) ([]byte, bool) {
+	k, ok := queryCacheKey(query, family)
+	if !ok {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return nil, false
+	}
+	e, ok := c.lru.GetOk(k)
+	if !ok {
+		metricDNSCacheMiss.Add(1)
+		return nil, false
+	}
+	if !now.Before(e.expires) {
+		c.lru.Delete(k)
+		metricDNSCacheMiss.Add(1)
+		return nil, false
+	}
+	metricDNSCacheHit.Add(1)
+
+	res := append([]byte(nil), e.res...)
+	copy(res[:2], query[:2])
+	elapsed := uint32(now.Sub(e.added) / time.Second)
+	remaining := uint32((e.expires.Sub(now) + time.Second - 1) / time.Second)
+	for i, off := range e.ttlOffs {
+		// Count the TTLs down, but never past when the entry expires,
+		// which MinTTL may have put later than the records would.
+		ttl := e.ttls[i] - min(elapsed, e.ttls[i])
+		if ttl == 0 || ttl > remaining {
+			ttl = remaining
+		}
+		binary.BigEndian.PutUint32(res[off:], ttl)
+	}
+	return res, true
+}
+
+// put caches res, the response to query, at now if it's cacheable:
+// a complete successful response, or an NXDOMAIN or empty response
+// with an SOA record saying how long to cache it for.
+func (c *responseCache) put(query []byte, family string, res []byte, now time.Time) {
+	if !c.enabled() || truncatedFlagSet(res) || getTxID(query) != getTxID(res) {
+		return
+	}
+	k, ok := queryCacheKey(query, family)
+	if !ok {
+		return
+	}
+	ttl, ok := responseTTL(res)
+	if !ok {
+		return
+	}
+	offs, ttls, ok := recordTTLs(res)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return
+	}
+	ttl = c.cfg.clampTTL(ttl)
+	if ttl <= 0 {
+		return
+	}
+	c.lru.Set(k, &cacheEntry{
+		res:     append([]byte(nil), res...),
+		ttlOffs: offs,
+		ttls:    ttls,
+		added:   now,
+		expires: now.Add(ttl),
+	})
+}
+
+// responseTTL returns how long the response res may be cached for,
+// before any clamping: the lowest TTL of its answers, or for a negative
+// response, the TTL its SOA record gives per RFC 2308 section 5.
+func responseTTL(res []byte) (ttl time.Duration, ok bool) {
+	var p dns.Parser
+	h, err := p.Start(res)
+	if err != nil || !h.Response {
+		return 0, false
+	}
+	if h.RCode != dns.RCodeSuccess && h.RCode != dns.RCodeNameError {
+		return 0, false
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return 0, false
+	}
+	var minTTL uint32
+	answers := 0
+	for {
+		ah, err := p.AnswerHeader()
+		if err == dns.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return 0, false
+		}
+		if answers == 0 || ah.TTL < minTTL {
+			minTTL = ah.TTL
+		}
+		answers++
+		if err := p.SkipAnswer(); err != nil {
+			return 0, false
+		}
+	}
+	if h.RCode == dns.RCodeSuccess && answers > 0 {
+		return time.Duration(minTTL) * time.Second, true
+	}
+
+	// A negative response.
+	for {
+		ah, err := p.AuthorityHeader()
+		if err == dns.ErrSectionDone {
+			return 0, false // no SOA, so don't cache it
+		}
+		if err != nil {
+			return 0, false
+		}
+		if ah.Type != dns.TypeSOA {
+			if err := p.SkipAuthority(); err != nil {
+				return 0, false
+			}
+			continue
+		}
+		soa, err := p.SOAResource()
+		if err != nil {
+			return 0, false
+		}
+		return time.Duration(min(ah.TTL, soa.MinTTL)) * time.Second, true
+	}
+}
+
+// recordTTLs returns the offsets in the DNS message pkt of the TTLs of
+// its records, other than the OPT pseudo-record whose TTL field holds
+// flags, and the TTLs.
+func recordTTLs(pkt []byte) (offs []int, ttls []uint32, ok bool) {
+	if len(pkt) < headerBytes {
+		return nil, nil, false
+	}
+	off := headerBytes
+	for i := 0; i < int(binary.BigEndian.Uint16(pkt[4:6])); i++ { // questions
+		if off, ok = skipName(pkt, off); !ok || off+4 > len(pkt) {
+			return nil, nil, false
+		}
+		off += 4 // type and class
+	}
+	n := int(binary.BigEndian.Uint16(pkt[6:8])) +
+		int(binary.BigEndian.Uint16(pkt[8:10])) +
+		int(binary.BigEndian.Uint16(pkt[10:12]))
+	for i := 0; i < n; i++ {
+		if off, ok = skipName(pkt, off); !ok || off+10 > len(pkt) {
+			return nil, nil, false
+		}
+		if dns.Type(binary.BigEndian.Uint16(pkt[off:])) != dns.TypeOPT {
+			offs = append(offs, off+4)
+			ttls = append(ttls, binary.BigEndian.Uint32(pkt[off+4:]))
+		}
+		off += 10 + int(binary.BigEndian.Uint16(pkt[off+8:]))
+		if off > len(pkt) {
+			return nil, nil, false
+		}
+	}
+	return offs, ttls, true
+}
+
+// skipName returns the offset in pkt after the DNS name at off.
+func skipName(pkt []byte, off int) (int, bool) {
+	for off < len(pkt) {
+		l := int(pkt[off])
+		switch {
+		case l == 0:
+			return off + 1, true
+		case l&0xC0 == 0xC0: // compression pointer, ending the name
+			return off + 2, off+2 <= len(pkt)
+		case l&0xC0 != 0:
+			return 0, false
+		}
+		off += 1 + l
+	}
+	return 0, false
+}
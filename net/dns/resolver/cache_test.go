@@ -0,0 +1,163 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+)
+
+func cacheTestQuery(tb testing.TB, id uint16, name string) []byte {
+	tb.Helper()
+	b := dns.NewBuilder(nil, dns.Header{ID: id})
+	b.StartQuestions()
+	b.Question(dns.Question{Name: dns.MustNewName(name), Type: dns.TypeA, Class: dns.ClassINET})
+	q, err := b.Finish()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return q
+}
+
+// cacheTestResponse returns a response to a query for name with ID id.
+// If aTTL is non-zero, it has an A record with that TTL; otherwise it's
+// an NXDOMAIN, with an SOA record with TTL soaTTL unless it's zero.
+func cacheTestResponse(tb testing.TB, id uint16, name string, aTTL, soaTTL uint32) []byte {
+	tb.Helper()
+	h := dns.Header{ID: id, Response: true}
+	if aTTL == 0 {
+		h.RCode = dns.RCodeNameError
+	}
+	n := dns.MustNewName(name)
+	b := dns.NewBuilder(nil, h)
+	b.EnableCompression()
+	b.StartQuestions()
+	b.Question(dns.Question{Name: n, Type: dns.TypeA, Class: dns.ClassINET})
+	b.StartAnswers()
+	if aTTL != 0 {
+		b.AResource(dns.ResourceHeader{Name: n, Class: dns.ClassINET, TTL: aTTL}, dns.AResource{A: [4]byte{10, 0, 0, 1}})
+	}
+	b.StartAuthorities()
+	if soaTTL != 0 {
+		b.SOAResource(dns.ResourceHeader{Name: n, Class: dns.ClassINET, TTL: soaTTL}, dns.SOAResource{
+			NS:     dns.MustNewName("ns.example.com."),
+			MBox:   dns.MustNewName("hostmaster.example.com."),
+			MinTTL: 60,
+		})
+	}
+	res, err := b.Finish()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return res
+}
+
+func firstRecordTTL(tb testing.TB, res []byte) uint32 {
+	tb.Helper()
+	offs, _, ok := recordTTLs(res)
+	if !ok || len(offs) == 0 {
+		tb.Fatalf("no records in %x", res)
+	}
+	return binary.BigEndian.Uint32(res[offs[0]:])
+}
+
+func TestResponseCache(t *testing.T) {
+	var c responseCache
+	now := time.Now()
+	const name = "host.example.com."
+
+	c.put(cacheTestQuery(t, 1, name), "udp", cacheTestResponse(t, 1, name, 300, 0), now)
+	if _, ok := c.get(cacheTestQuery(t, 2, name), "udp", now); ok {
+		t.Fatal("disabled cache returned a response")
+	}
+
+	c.setConfig(CacheConfig{MaxEntries: 2, MaxTTL: 200 * time.Second}, false)
+	c.put(cacheTestQuery(t, 1, name), "udp", cacheTestResponse(t, 1, name, 300, 0), now)
+	res, ok := c.get(cacheTestQuery(t, 2, name), "udp", now.Add(100*time.Second))
+	if !ok {
+		t.Fatal("cache miss")
+	}
+	if id := getTxID(res); id != 2 {
+		t.Errorf("response ID = %d; want 2", id)
+	}
+	if ttl := firstRecordTTL(t, res); ttl != 100 {
+		t.Errorf("TTL = %d; want 100 (MaxTTL minus elapsed)", ttl)
+	}
+	if _, ok := c.get(cacheTestQuery(t, 2, name), "tcp", now); ok {
+		t.Error("cache hit for other family")
+	}
+	if _, ok := c.get(cacheTestQuery(t, 3, name), "udp", now.Add(200*time.Second)); ok {
+		t.Error("cache hit after MaxTTL")
+	}
+
+	// Negative responses are cached for the SOA's TTL or minimum TTL,
+	// whichever is lower, and not at all without an SOA.
+	const nx, nxNoSOA = "nx.example.com.", "nx2.example.com."
+	c.put(cacheTestQuery(t, 1, nx), "udp", cacheTestResponse(t, 1, nx, 0, 3600), now)
+	c.put(cacheTestQuery(t, 1, nxNoSOA), "udp", cacheTestResponse(t, 1, nxNoSOA, 0, 0), now)
+	if _, ok := c.get(cacheTestQuery(t, 2, nx), "udp", now.Add(59*time.Second)); !ok {
+		t.Error("negative response not cached")
+	}
+	if _, ok := c.get(cacheTestQuery(t, 2, nx), "udp", now.Add(60*time.Second)); ok {
+		t.Error("negative response cached past SOA minimum TTL")
+	}
+	if _, ok := c.get(cacheTestQuery(t, 2, nxNoSOA), "udp", now); ok {
+		t.Error("negative response without SOA cached")
+	}
+
+	// MinTTL extends short TTLs, and the TTLs served count down to
+	// the extended expiry.
+	c.setConfig(CacheConfig{MaxEntries: 2, MinTTL: 30 * time.Second}, false)
+	c.put(cacheTestQuery(t, 1, name), "udp", cacheTestResponse(t, 1, name, 5, 0), now)
+	res, ok = c.get(cacheTestQuery(t, 2, name), "udp", now.Add(10*time.Second))
+	if !ok {
+		t.Fatal("cache miss within MinTTL")
+	}
+	if ttl := firstRecordTTL(t, res); ttl != 20 {
+		t.Errorf("TTL = %d; want 20", ttl)
+	}
+
+	// Setting the same config keeps entries, unless the routes changed.
+	c.setConfig(CacheConfig{MaxEntries: 2, MinTTL: 30 * time.Second}, false)
+	if _, ok := c.get(cacheTestQuery(t, 2, name), "udp", now); !ok {
+		t.Error("same config flushed the cache")
+	}
+	c.setConfig(CacheConfig{MaxEntries: 2, MinTTL: 30 * time.Second}, true)
+	if _, ok := c.get(cacheTestQuery(t, 2, name), "udp", now); ok {
+		t.Error("changed routes didn't flush the cache")
+	}
+
+	c.put(cacheTestQuery(t, 1, name), "udp", cacheTestResponse(t, 1, name, 300, 0), now)
+	c.flush()
+	if _, ok := c.get(cacheTestQuery(t, 2, name), "udp", now); ok {
+		t.Error("cache hit after flush")
+	}
+
+	// The least recently used entry is evicted past MaxEntries.
+	for _, n := range []string{"a.example.com.", "b.example.com.", "c.example.com."} {
+		c.put(cacheTestQuery(t, 1, n), "udp", cacheTestResponse(t, 1, n, 300, 0), now)
+	}
+	if _, ok := c.get(cacheTestQuery(t, 2, "a.example.com."), "udp", now); ok {
+		t.Error("oldest entry not evicted")
+	}
+	if _, ok := c.get(cacheTestQuery(t, 2, "c.example.com."), "udp", now); !ok {
+		t.Error("newest entry evicted")
+	}
+}
+
+func TestResponseCacheSkipsTruncated(t *testing.T) {
+	var c responseCache
+	c.setConfig(CacheConfig{MaxEntries: 10}, false)
+	now := time.Now()
+	const name = "big.example.com."
+	res := cacheTestResponse(t, 1, name, 300, 0)
+	res[2] |= dnsFlagTruncated >> 8
+	c.put(cacheTestQuery(t, 1, name), "udp", res, now)
+	if _, ok := c.get(cacheTestQuery(t, 2, name), "udp", now); ok {
+		t.Error("truncated response cached")
+	}
+}
@@ -6,6 +6,9 @@
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -15,6 +18,7 @@
 	"net/http"
 	"net/netip"
 	"net/url"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -26,6 +30,7 @@
 	"tailscale.com/envknob"
 	"tailscale.com/net/dns/publicdns"
 	"tailscale.com/net/dnscache"
+	"tailscale.com/net/dnsfallback"
 	"tailscale.com/net/neterror"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netns"
@@ -36,6 +41,7 @@
 	"tailscale.com/types/nettype"
 	"tailscale.com/util/cloudenv"
 	"tailscale.com/util/dnsname"
+	"tailscale.com/util/mak"
 	"tailscale.com/util/race"
 	"tailscale.com/version"
 )
@@ -198,7 +204,8 @@ type forwarder struct {
 
 	mu sync.Mutex // guards following
 
-	dohClient map[string]*http.Client // urlBase -> client
+	dohClient map[string]*http.Client // urlBase or tlsResolverKey -> client
+	dotConns  map[string][]*dotConn   // tlsResolverKey -> idle conns, most recently used last
 
 	// routes are per-suffix resolvers to use, with
 	// the most specific routes first.
@@ -233,6 +240,14 @@ func newForwarder(logf logger.Logf, netMon *netmon.Monitor, linkSel ForwardLinkS
 
 func (f *forwarder) Close() error {
 	f.ctxCancel()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conns := range f.dotConns {
+		for _, dc := range conns {
+			dc.Close()
+		}
+	}
+	f.dotConns = nil
 	return nil
 }
 
@@ -420,6 +435,217 @@ func (f *forwarder) getKnownDoHClientForProvider(urlBase string) (c *http.Client
 	return c, true
 }
 
+// tlsResolverKey returns the key of the DoH client or DoT connections
+// for r, which differ by TLS options as well as by address.
+func tlsResolverKey(r *dnstype.Resolver) string {
+	return r.Addr + "|" + r.TLSServerName + "|" + strings.Join(r.PinnedSPKI, ",")
+}
+
+// tlsConfigFor returns the TLS config to talk to the DoH or DoT resolver r
+// at host, applying its TLSServerName and PinnedSPKI options.
+func tlsConfigFor(r *dnstype.Resolver, host string) *tls.Config {
+	conf := &tls.Config{ServerName: host}
+	if r.TLSServerName != "" {
+		conf.ServerName = r.TLSServerName
+	}
+	if len(r.PinnedSPKI) == 0 {
+		return conf
+	}
+	// The pins replace the usual chain verification, so servers with
+	// self-signed certificates work.
+	conf.InsecureSkipVerify = true
+	conf.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("no peer certificates")
+		}
+		sum := sha256.Sum256(cs.PeerCertificates[0].RawSubjectPublicKeyInfo)
+		pin := base64.StdEncoding.EncodeToString(sum[:])
+		if !slices.Contains(r.PinnedSPKI, pin) {
+			return fmt.Errorf("certificate SPKI %s doesn't match the resolver's pins", pin)
+		}
+		return nil
+	}
+	return conf
+}
+
+// tlsResolverDialer returns a func to dial the DoH or DoT resolver r at
+// host. If host isn't an IP address, it's resolved using r's
+// BootstrapResolution if any, and otherwise the system resolver, falling
+// back to tailscaled's bootstrap DNS. The system resolver could be
+// ourselves, so BootstrapResolution should be set for such resolvers
+// named by hostname.
+func (f *forwarder) tlsResolverDialer(r *dnstype.Resolver, host string) dnscache.DialContextFunc {
+	nsDialer := netns.NewDialer(f.logf, f.netMon)
+	if _, err := netip.ParseAddr(host); err == nil {
+		return nsDialer.DialContext
+	}
+	dnsCache := &dnscache.Resolver{
+		Logf:             f.logf,
+		NetMon:           f.netMon,
+		LookupIPFallback: dnsfallback.MakeLookupFunc(f.logf, f.netMon),
+	}
+	if len(r.BootstrapResolution) > 0 {
+		dnsCache.SingleHost = host
+		dnsCache.SingleHostStaticResult = r.BootstrapResolution
+	}
+	return dnscache.Dialer(nsDialer.DialContext, dnsCache)
+}
+
+// getDoHClient returns the HTTP client for the DoH resolver r, which
+// reuses connections to it.
+func (f *forwarder) getDoHClient(r *dnstype.Resolver) (*http.Client, error) {
+	key := tlsResolverKey(r)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.dohClient[key]; ok {
+		return c, nil
+	}
+	dohURL, err := url.Parse(r.Addr)
+	if err != nil {
+		return nil, err
+	}
+	dial := f.tlsResolverDialer(r, dohURL.Hostname())
+	c := &http.Client{
+		Transport: &http.Transport{
+			ForceAttemptHTTP2: true,
+			IdleConnTimeout:   dohTransportTimeout,
+			TLSClientConfig:   tlsConfigFor(r, dohURL.Hostname()),
+			DialContext: func(ctx context.Context, netw, addr string) (net.Conn, error) {
+				if !strings.HasPrefix(netw, "tcp") {
+					return nil, fmt.Errorf("unexpected network %q", netw)
+				}
+				return dial(ctx, netw, addr)
+			},
+		},
+	}
+	mak.Set(&f.dohClient, key, c)
+	return c, nil
+}
+
+// dotConn is a connection to a DoT resolver, kept for reuse between
+// queries.
+type dotConn struct {
+	*tls.Conn
+	lastUsed time.Time
+}
+
+// getDoTConn returns an idle connection to the DoT resolver r, or dials
+// a new one. reused is whether the connection was used before, in which
+// case the server might have closed it.
+func (f *forwarder) getDoTConn(ctx context.Context, r *dnstype.Resolver) (dc *dotConn, reused bool, err error) {
+	key := tlsResolverKey(r)
+	f.mu.Lock()
+	for conns := f.dotConns[key]; len(conns) > 0; conns = f.dotConns[key] {
+		dc = conns[len(conns)-1]
+		f.dotConns[key] = conns[:len(conns)-1]
+		if time.Since(dc.lastUsed) < dohTransportTimeout {
+			f.mu.Unlock()
+			return dc, true, nil
+		}
+		dc.Close()
+	}
+	f.mu.Unlock()
+
+	host, port, err := r.DoTHostPort()
+	if err != nil {
+		return nil, false, err
+	}
+	c, err := f.tlsResolverDialer(r, host)(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, false, err
+	}
+	tc := tls.Client(c, tlsConfigFor(r, host))
+	if err := tc.HandshakeContext(ctx); err != nil {
+		c.Close()
+		return nil, false, err
+	}
+	return &dotConn{Conn: tc}, false, nil
+}
+
+// putDoTConn returns dc to the idle connections to r, unless there are
+// already maxIdleDoTConns of them.
+func (f *forwarder) putDoTConn(r *dnstype.Resolver, dc *dotConn) {
+	const maxIdleDoTConns = 4
+	key := tlsResolverKey(r)
+	dc.lastUsed = time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.ctx.Err() != nil || len(f.dotConns[key]) >= maxIdleDoTConns {
+		dc.Close()
+		return
+	}
+	mak.Set(&f.dotConns, key, append(f.dotConns[key], dc))
+}
+
+// sendDoT sends the query in fq to the DoT resolver r, reusing an idle
+// connection to it if there is one.
+func (f *forwarder) sendDoT(ctx context.Context, fq *forwardQuery, r *dnstype.Resolver) ([]byte, error) {
+	metricDNSFwdDoT.Add(1)
+	ctx = sockstats.WithSockStats(ctx, sockstats.LabelDNSForwarderTCP, f.logf)
+	ctx, cancel := context.WithTimeout(ctx, tcpQueryTimeout)
+	defer cancel()
+	for {
+		dc, reused, err := f.getDoTConn(ctx, r)
+		if err != nil {
+			metricDNSFwdDoTErrorDial.Add(1)
+			return nil, err
+		}
+		res, err := f.sendDoTConn(ctx, fq, dc)
+		if err == nil {
+			f.putDoTConn(r, dc)
+			metricDNSFwdDoTSuccess.Add(1)
+			return res, nil
+		}
+		dc.Close()
+		if reused && ctx.Err() == nil && !errors.Is(err, errTxIDMismatch) {
+			// The server probably closed the idle connection;
+			// retry on a new one.
+			continue
+		}
+		metricDNSFwdDoTError.Add(1)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+}
+
+// sendDoTConn sends the query in fq over dc and reads the response, in
+// the DNS-over-TCP framing.
+func (f *forwarder) sendDoTConn(ctx context.Context, fq *forwardQuery, dc *dotConn) ([]byte, error) {
+	fq.closeOnCtxDone.Add(dc)
+	defer fq.closeOnCtxDone.Remove(dc)
+	if d, ok := ctx.Deadline(); ok {
+		dc.SetDeadline(d)
+		defer dc.SetDeadline(time.Time{})
+	}
+
+	query := make([]byte, len(fq.packet)+2)
+	binary.BigEndian.PutUint16(query, uint16(len(fq.packet)))
+	copy(query[2:], fq.packet)
+	if _, err := dc.Write(query); err != nil {
+		return nil, err
+	}
+	var length uint16
+	if err := binary.Read(dc, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	out := make([]byte, length)
+	if _, err := io.ReadFull(dc, out); err != nil {
+		return nil, err
+	}
+	if getTxID(out) != fq.txid {
+		return nil, errTxIDMismatch
+	}
+	if getRCode(out) == dns.RCodeServerFailure {
+		return nil, errServerFailure
+	}
+	if truncatedFlagSet(out) {
+		metricDNSFwdTruncated.Add(1)
+	}
+	return out, nil
+}
+
 const dohType = "application/dns-message"
 
 func (f *forwarder) sendDoH(ctx context.Context, urlBase string, c *http.Client, packet []byte) ([]byte, error) {
@@ -475,23 +701,26 @@ func (f *forwarder) send(ctx context.Context, fq *forwardQuery, rr resolverAndDe
 	if strings.HasPrefix(rr.name.Addr, "http://") {
 		return f.sendDoH(ctx, rr.name.Addr, f.dialer.PeerAPIHTTPClient(), fq.packet)
 	}
-	if strings.HasPrefix(rr.name.Addr, "https://") {
-		// Only known DoH providers are supported currently. Specifically, we
-		// only support DoH providers where we can TCP connect to them on port
-		// 443 at the same IP address they serve normal UDP DNS from (1.1.1.1,
-		// 8.8.8.8, 9.9.9.9, etc.) That's why OpenDNS and custom DoH providers
-		// aren't currently supported. There's no backup DNS resolution path for
-		// them.
+	if rr.name.IsDoH() {
+		// Prefer the known DoH providers' clients, which dial the IPs
+		// they serve normal UDP DNS from (1.1.1.1, 8.8.8.8, 9.9.9.9,
+		// etc.) without any bootstrap DNS resolution. Other DoH servers,
+		// or known ones with TLS options, get their own client.
 		urlBase := rr.name.Addr
-		if hc, ok := f.getKnownDoHClientForProvider(urlBase); ok {
-			return f.sendDoH(ctx, urlBase, hc, fq.packet)
+		if rr.name.TLSServerName == "" && len(rr.name.PinnedSPKI) == 0 {
+			if hc, ok := f.getKnownDoHClientForProvider(urlBase); ok {
+				return f.sendDoH(ctx, urlBase, hc, fq.packet)
+			}
 		}
-		metricDNSFwdErrorType.Add(1)
-		return nil, fmt.Errorf("arbitrary https:// resolvers not supported yet")
+		hc, err := f.getDoHClient(rr.name)
+		if err != nil {
+			metricDNSFwdErrorType.Add(1)
+			return nil, err
+		}
+		return f.sendDoH(ctx, urlBase, hc, fq.packet)
 	}
-	if strings.HasPrefix(rr.name.Addr, "tls://") {
-		metricDNSFwdErrorType.Add(1)
-		return nil, fmt.Errorf("tls:// resolvers not supported yet")
+	if rr.name.IsDoT() {
+		return f.sendDoT(ctx, fq, rr.name)
 	}
 
 	ctx, cancel := context.WithCancel(ctx)
@@ -771,18 +1000,34 @@ func (f *forwarder) sendTCP(ctx context.Context, fq *forwardQuery, rr resolverAn
 
 // resolvers returns the resolvers to use for domain.
 func (f *forwarder) resolvers(domain dnsname.FQDN) []resolverAndDelay {
+	_, resolvers := f.routeFor(domain)
+	return resolvers
+}
+
+// routeFor returns the resolvers to use for domain and the suffix of
+// the route they're from. The suffix is empty if there's no matching
+// route, in which case the resolvers are the cloud host fallback, if any.
+func (f *forwarder) routeFor(domain dnsname.FQDN) (suffix dnsname.FQDN, resolvers []resolverAndDelay) {
 	f.mu.Lock()
 	routes := f.routes
 	cloudHostFallback := f.cloudHostFallback
 	f.mu.Unlock()
 	for _, route := range routes {
 		if route.Suffix == "." || route.Suffix.Contains(domain) {
-			return route.Resolvers
+			return route.Suffix, route.Resolvers
 		}
 	}
-	return cloudHostFallback // or nil if no fallback
+	return "", cloudHostFallback // or nil if no fallback
+}
+
+// forwardTrace records which upstream answered a forwarded query, if
+// it's in the query's context under forwardTraceKey{}.
+type forwardTrace struct {
+	upstream *dnstype.Resolver
 }
 
+type forwardTraceKey struct{}
+
 // forwardQuery is information and state about a forwarded DNS query that's
 // being sent to 1 or more upstreams.
 //
@@ -884,7 +1129,11 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 	}
 	defer fq.closeOnCtxDone.Close()
 
-	resc := make(chan []byte, 1) // it's fine buffered or not
+	type result struct {
+		bs []byte
+		rr *resolverAndDelay
+	}
+	resc := make(chan result, 1) // it's fine buffered or not
 	errc := make(chan error, 1)  // it's fine buffered or not too
 	for i := range resolvers {
 		go func(rr *resolverAndDelay) {
@@ -906,7 +1155,7 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 				return
 			}
 			select {
-			case resc <- resb:
+			case resc <- result{resb, rr}:
 			case <-ctx.Done():
 			}
 		}(&resolvers[i])
@@ -917,11 +1166,14 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 	for {
 		select {
 		case v := <-resc:
+			if t, ok := ctx.Value(forwardTraceKey{}).(*forwardTrace); ok {
+				t.upstream = v.rr.name
+			}
 			select {
 			case <-ctx.Done():
 				metricDNSFwdErrorContext.Add(1)
 				return ctx.Err()
-			case responseChan <- packet{v, query.family, query.addr}:
+			case responseChan <- packet{v.bs, query.family, query.addr}:
 				metricDNSFwdSuccess.Add(1)
 				return nil
 			}
@@ -6,12 +6,18 @@
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/binary"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/netip"
 	"os"
 	"reflect"
@@ -647,3 +653,144 @@ func TestForwarderTCPFallbackError(t *testing.T) {
 		t.Errorf("wanted errServerFailure, got: %v", err)
 	}
 }
+
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestForwarderDoHPinned(t *testing.T) {
+	const domain = "doh-response.tailscale.com."
+	request, response := makeLargeResponse(t, domain)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := io.ReadAll(r.Body)
+		if !bytes.Equal(got, request) {
+			t.Errorf("invalid request\ngot: %+v\nwant: %+v", got, request)
+		}
+		w.Header().Set("Content-Type", dohType)
+		w.Write(response)
+	}))
+	defer srv.Close()
+	pin := spkiPin(srv.Certificate())
+
+	for _, tt := range []struct {
+		name    string
+		pins    []string
+		wantErr bool
+	}{
+		{"pinned", []string{testBadPin, pin}, false},
+		{"unpinned", nil, true}, // the test certificate isn't trusted
+		{"wrong-pin", []string{testBadPin}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &dnstype.Resolver{Addr: srv.URL + "/dns-query", PinnedSPKI: tt.pins}
+			resp, err := runTestQueryTo(t, r, request)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v; want error: %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(resp, response) {
+				t.Errorf("invalid response\ngot: %+v\nwant: %+v", resp, response)
+			}
+		})
+	}
+}
+
+func TestForwarderDoT(t *testing.T) {
+	const domain = "dot-response.tailscale.com."
+	request, response := makeLargeResponse(t, domain)
+
+	// Borrow httptest's certificate, for 127.0.0.1 and example.com.
+	certSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer certSrv.Close()
+	pin := spkiPin(certSrv.Certificate())
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certSrv.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				defer c.Close()
+				for {
+					var n uint16
+					if err := binary.Read(c, binary.BigEndian, &n); err != nil {
+						return
+					}
+					got := make([]byte, n)
+					if _, err := io.ReadFull(c, got); err != nil {
+						return
+					}
+					if !bytes.Equal(got, request) {
+						t.Errorf("invalid request\ngot: %+v\nwant: %+v", got, request)
+					}
+					binary.Write(c, binary.BigEndian, uint16(len(response)))
+					c.Write(response)
+				}
+			}()
+		}
+	}()
+
+	r := &dnstype.Resolver{
+		Addr:          "tls://" + ln.Addr().String(),
+		TLSServerName: "example.com",
+		PinnedSPKI:    []string{pin},
+	}
+	fwd := newTestForwarder(t)
+	defer fwd.Close()
+	for i := 0; i < 3; i++ {
+		resp, err := sendTestQuery(fwd, r, request)
+		if err != nil {
+			t.Fatalf("query %d: %v", i, err)
+		}
+		if !bytes.Equal(resp, response) {
+			t.Errorf("query %d: invalid response\ngot: %+v\nwant: %+v", i, resp, response)
+		}
+	}
+	if n := accepted.Load(); n != 1 {
+		t.Errorf("server accepted %d connections; want 1, reused", n)
+	}
+
+	r = &dnstype.Resolver{Addr: r.Addr, PinnedSPKI: []string{testBadPin}}
+	if _, err := sendTestQuery(fwd, r, request); err == nil {
+		t.Errorf("query with wrong pin succeeded")
+	}
+}
+
+// testBadPin is a valid SPKI pin that matches no test certificate.
+const testBadPin = "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+
+func newTestForwarder(tb testing.TB) *forwarder {
+	netMon, err := netmon.New(tb.Logf)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var dialer tsdial.Dialer
+	dialer.SetNetMon(netMon)
+	return newForwarder(tb.Logf, netMon, nil, &dialer, nil)
+}
+
+func sendTestQuery(fwd *forwarder, r *dnstype.Resolver, request []byte) ([]byte, error) {
+	fq := &forwardQuery{
+		txid:           getTxID(request),
+		packet:         request,
+		closeOnCtxDone: new(closePool),
+		family:         "tcp",
+	}
+	defer fq.closeOnCtxDone.Close()
+	return fwd.send(context.Background(), fq, resolverAndDelay{name: r})
+}
+
+func runTestQueryTo(tb testing.TB, r *dnstype.Resolver, request []byte) ([]byte, error) {
+	fwd := newTestForwarder(tb)
+	defer fwd.Close()
+	return sendTestQuery(fwd, r, request)
+}
@@ -0,0 +1,182 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/dnsname"
+)
+
+// TraceQuery resolves name exactly as a query to the resolver would be,
+// and reports which rule handled it and which upstream, if any,
+// answered.
+func (r *Resolver) TraceQuery(ctx context.Context, name dnsname.FQDN, typ dns.Type) *dnstype.QueryAttempt {
+	start := time.Now()
+	at := &dnstype.QueryAttempt{FQDN: string(name)}
+	defer func() { at.Duration = time.Since(start) }()
+
+	q, err := traceQueryPacket(name, typ)
+	if err != nil {
+		at.Source = "none"
+		at.Err = err.Error()
+		return at
+	}
+	select {
+	case <-r.closed:
+		at.Source = "none"
+		at.Err = "resolver closed"
+		return at
+	default:
+	}
+
+	out, err := r.respond(q)
+	if err != errNotOurName {
+		at.Source = "magicdns"
+		if err != nil {
+			at.Err = err.Error()
+		} else {
+			traceResponse(at, out)
+		}
+		return at
+	}
+
+	suffix, resolvers := r.forwarder.routeFor(name)
+	switch {
+	case suffix == ".":
+		at.Source = "default"
+	case suffix != "":
+		at.Source = "route"
+		at.Route = string(suffix)
+	case len(resolvers) > 0:
+		at.Source = "cloud"
+	default:
+		at.Source = "none"
+	}
+	for _, rr := range resolvers {
+		at.Resolvers = append(at.Resolvers, rr.name)
+	}
+
+	tr := new(forwardTrace)
+	ctx = context.WithValue(ctx, forwardTraceKey{}, tr)
+	ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
+	defer cancel()
+	responses := make(chan packet, 1)
+	err = r.forwarder.forwardWithDestChan(ctx, packet{q, "tcp", netip.AddrPort{}}, responses, resolvers...)
+	if tr.upstream != nil {
+		at.Upstream = tr.upstream.Addr
+	}
+	if err != nil {
+		at.Err = err.Error()
+	}
+	select {
+	case resp := <-responses:
+		traceResponse(at, resp.bs)
+	default:
+	}
+	return at
+}
+
+// traceQueryPacket returns a recursive query for name of type typ.
+func traceQueryPacket(name dnsname.FQDN, typ dns.Type) ([]byte, error) {
+	qname, err := dns.NewName(name.WithTrailingDot())
+	if err != nil {
+		return nil, err
+	}
+	b := dns.NewBuilder(nil, dns.Header{
+		ID:               uint16(rand.Intn(1 << 16)),
+		RecursionDesired: true,
+	})
+	b.StartQuestions()
+	if err := b.Question(dns.Question{Name: qname, Type: typ, Class: dns.ClassINET}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// traceResponse sets the RCode and Answers of at from the DNS response
+// res.
+func traceResponse(at *dnstype.QueryAttempt, res []byte) {
+	var p dns.Parser
+	h, err := p.Start(res)
+	if err != nil {
+		at.Err = fmt.Sprintf("parsing response: %v", err)
+		return
+	}
+	at.RCode = rcodeString(h.RCode)
+	if err := p.SkipAllQuestions(); err != nil {
+		at.Err = fmt.Sprintf("parsing response: %v", err)
+		return
+	}
+	answers, err := p.AllAnswers()
+	if err != nil {
+		at.Err = fmt.Sprintf("parsing response: %v", err)
+		return
+	}
+	for _, a := range answers {
+		at.Answers = append(at.Answers, formatResource(a))
+	}
+}
+
+// rcodeString returns the conventional name of rcode, as shown by dig.
+func rcodeString(rcode dns.RCode) string {
+	switch rcode {
+	case dns.RCodeSuccess:
+		return "NOERROR"
+	case dns.RCodeFormatError:
+		return "FORMERR"
+	case dns.RCodeServerFailure:
+		return "SERVFAIL"
+	case dns.RCodeNameError:
+		return "NXDOMAIN"
+	case dns.RCodeNotImplemented:
+		return "NOTIMP"
+	case dns.RCodeRefused:
+		return "REFUSED"
+	}
+	return "RCODE" + strconv.Itoa(int(rcode))
+}
+
+// typeString returns the name of typ, such as "AAAA".
+func typeString(typ dns.Type) string {
+	return strings.TrimPrefix(typ.String(), "Type")
+}
+
+// formatResource formats a like a line of a zone file.
+func formatResource(a dns.Resource) string {
+	var data string
+	switch b := a.Body.(type) {
+	case *dns.AResource:
+		data = netip.AddrFrom4(b.A).String()
+	case *dns.AAAAResource:
+		data = netip.AddrFrom16(b.AAAA).String()
+	case *dns.CNAMEResource:
+		data = b.CNAME.String()
+	case *dns.PTRResource:
+		data = b.PTR.String()
+	case *dns.NSResource:
+		data = b.NS.String()
+	case *dns.MXResource:
+		data = fmt.Sprintf("%d %s", b.Pref, b.MX)
+	case *dns.SRVResource:
+		data = fmt.Sprintf("%d %d %d %s", b.Priority, b.Weight, b.Port, b.Target)
+	case *dns.SOAResource:
+		data = fmt.Sprintf("%s %s %d %d %d %d %d", b.NS, b.MBox, b.Serial, b.Refresh, b.Retry, b.Expire, b.MinTTL)
+	case *dns.TXTResource:
+		quoted := make([]string, len(b.TXT))
+		for i, s := range b.TXT {
+			quoted[i] = strconv.Quote(s)
+		}
+		data = strings.Join(quoted, " ")
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s %d IN %s %s", a.Header.Name, a.Header.TTL, typeString(a.Header.Type), data))
+}
@@ -0,0 +1,93 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/dnsname"
+)
+
+func TestTraceQuery(t *testing.T) {
+	test4 := netip.MustParseAddr("2.3.4.5")
+	test6 := netip.MustParseAddr("ff::1")
+
+	server1 := serveDNS(t, "127.0.0.1:0",
+		"test.site.", resolveToIP(testipv4, testipv6, "dns.test.site."))
+	defer server1.Shutdown()
+	server2 := serveDNS(t, "127.0.0.1:0",
+		"test.other.", resolveToIP(test4, test6, "dns.other."))
+	defer server2.Shutdown()
+	addr1 := server1.PacketConn.LocalAddr().String()
+	addr2 := server2.PacketConn.LocalAddr().String()
+
+	r := newResolver(t)
+	defer r.Close()
+	cfg := dnsCfg
+	cfg.Routes = map[dnsname.FQDN][]*dnstype.Resolver{
+		".":      {{Addr: addr1}},
+		"other.": {{Addr: addr2}},
+	}
+	r.SetConfig(cfg)
+
+	tests := []struct {
+		name dnsname.FQDN
+		want dnstype.QueryAttempt
+	}{
+		{
+			name: "test1.ipn.dev.",
+			want: dnstype.QueryAttempt{
+				Source:  "magicdns",
+				RCode:   "NOERROR",
+				Answers: []string{"test1.ipn.dev. 600 IN A 1.2.3.4"},
+			},
+		},
+		{
+			name: "missing.ipn.dev.",
+			want: dnstype.QueryAttempt{
+				Source: "magicdns",
+				RCode:  "NXDOMAIN",
+			},
+		},
+		{
+			name: "test.other.",
+			want: dnstype.QueryAttempt{
+				Source:    "route",
+				Route:     "other.",
+				Resolvers: []*dnstype.Resolver{{Addr: addr2}},
+				Upstream:  addr2,
+				RCode:     "NOERROR",
+				Answers:   []string{"test.other. 0 IN A 2.3.4.5"},
+			},
+		},
+		{
+			name: "test.site.",
+			want: dnstype.QueryAttempt{
+				Source:    "default",
+				Resolvers: []*dnstype.Resolver{{Addr: addr1}},
+				Upstream:  addr1,
+				RCode:     "NOERROR",
+				Answers:   []string{"test.site. 0 IN A 1.2.3.4"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.name), func(t *testing.T) {
+			got := r.TraceQuery(context.Background(), tt.name, dns.TypeA)
+			if got.Duration <= 0 {
+				t.Errorf("Duration = %v; want > 0", got.Duration)
+			}
+			got.Duration = 0
+			tt.want.FQDN = string(tt.name)
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", *got, tt.want)
+			}
+		})
+	}
+}
@@ -12,10 +12,12 @@
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"net/netip"
 	"os"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -73,9 +75,18 @@ type Config struct {
 	Routes map[dnsname.FQDN][]*dnstype.Resolver
 	// LocalHosts is a map of FQDNs to corresponding IPs.
 	Hosts map[dnsname.FQDN][]netip.Addr
+	// Records are the other records of FQDNs, such as those nodes
+	// publish for service discovery. They're answered like Hosts.
+	Records map[dnsname.FQDN]*Records
 	// LocalDomains is a list of DNS name suffixes that should not be
 	// routed to upstream resolvers.
 	LocalDomains []dnsname.FQDN
+	// Cache is the policy of the cache of responses from upstream
+	// resolvers. The zero value disables it.
+	Cache CacheConfig
+	// Blocklist configures the blocking of queries for names on
+	// blocklists. It doesn't apply to names answered locally.
+	Blocklist BlocklistConfig
 }
 
 // WriteToBufioWriter write a debug version of c for logs to w, omitting
@@ -83,7 +94,11 @@ type Config struct {
 func (c *Config) WriteToBufioWriter(w *bufio.Writer) {
 	w.WriteString("{Routes:")
 	WriteRoutes(w, c.Routes)
-	fmt.Fprintf(w, " Hosts:%v LocalDomains:[", len(c.Hosts))
+	fmt.Fprintf(w, " Hosts:%v", len(c.Hosts))
+	if len(c.Records) > 0 {
+		fmt.Fprintf(w, " Records:%v", len(c.Records))
+	}
+	w.WriteString(" LocalDomains:[")
 	space := false
 	arpa := 0
 	for _, d := range c.LocalDomains {
@@ -101,12 +116,29 @@ func (c *Config) WriteToBufioWriter(w *bufio.Writer) {
 	if arpa > 0 {
 		fmt.Fprintf(w, "+%darpa", arpa)
 	}
+	if c.Cache.Enabled() {
+		fmt.Fprintf(w, " Cache:%v", c.Cache)
+	}
+	if c.Blocklist.Enabled() {
+		fmt.Fprintf(w, " Blocklist:%v", c.Blocklist)
+	}
 	if c := cloudenv.Get(); c != "" {
 		fmt.Fprintf(w, ", cloud=%q", string(c))
 	}
 	w.WriteString("}")
 }
 
+// Records are the DNS records of a name other than its A and AAAA
+// records, which are in Config.Hosts.
+type Records struct {
+	// CNAME, if non-empty, makes the name an alias of CNAME. An alias
+	// has no other records.
+	CNAME dnsname.FQDN
+
+	TXT []string   // each its own record with one string
+	SRV []*net.SRV // Target is an FQDN with a trailing dot
+}
+
 // WriteIPPorts writes vv to w.
 func WriteIPPorts(w *bufio.Writer, vv []netip.AddrPort) {
 	w.WriteByte('[')
@@ -186,6 +218,10 @@ type Resolver struct {
 	saveConfigForTests func(cfg Config) // used in tests to capture resolver config
 	// forwarder forwards requests to upstream nameservers.
 	forwarder *forwarder
+	// cache caches the forwarder's responses, if enabled.
+	cache responseCache
+	// blocklist blocks queries for names on blocklists, if any.
+	blocklist *blocklist
 
 	// closed signals all goroutines to stop.
 	closed chan struct{}
@@ -197,6 +233,8 @@ type Resolver struct {
 	localDomains []dnsname.FQDN
 	hostToIP     map[dnsname.FQDN][]netip.Addr
 	ipToHost     map[netip.Addr]dnsname.FQDN
+	records      map[dnsname.FQDN]*Records
+	routes       map[dnsname.FQDN][]*dnstype.Resolver
 }
 
 type ForwardLinkSelector interface {
@@ -221,6 +259,7 @@ func New(logf logger.Logf, netMon *netmon.Monitor, linkSel ForwardLinkSelector,
 		dialer:   dialer,
 	}
 	r.forwarder = newForwarder(r.logf, netMon, linkSel, dialer, knobs)
+	r.blocklist = newBlocklist(r.logf, dialer)
 	return r
 }
 
@@ -240,12 +279,19 @@ func (r *Resolver) SetConfig(cfg Config) error {
 	}
 
 	r.forwarder.setRoutes(cfg.Routes)
+	r.blocklist.setConfig(cfg.Blocklist)
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	routesChanged := !maps.EqualFunc(r.routes, cfg.Routes, func(a, b []*dnstype.Resolver) bool {
+		return slices.EqualFunc(a, b, (*dnstype.Resolver).Equal)
+	})
+	r.cache.setConfig(cfg.Cache, routesChanged)
+	r.routes = cfg.Routes
 	r.localDomains = cfg.LocalDomains
 	r.hostToIP = cfg.Hosts
 	r.ipToHost = reverse
+	r.records = cfg.Records
 	return nil
 }
 
@@ -261,6 +307,7 @@ func (r *Resolver) Close() {
 	close(r.closed)
 
 	r.forwarder.Close()
+	r.blocklist.close()
 }
 
 // dnsQueryTimeout is not intended to be user-visible (the users
@@ -279,6 +326,9 @@ func (r *Resolver) Query(ctx context.Context, bs []byte, family string, from net
 
 	out, err := r.respond(bs)
 	if err == errNotOurName {
+		if res, ok := r.cache.get(bs, family, time.Now()); ok {
+			return res, nil
+		}
 		responses := make(chan packet, 1)
 		ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
 		defer close(responses)
@@ -295,12 +345,19 @@ func (r *Resolver) Query(ctx context.Context, bs []byte, family string, from net
 				return nil, err
 			}
 		}
-		return (<-responses).bs, nil
+		res := (<-responses).bs
+		r.cache.put(bs, family, res, time.Now())
+		return res, nil
 	}
 
 	return out, err
 }
 
+// FlushCache empties the resolver's cache of upstream responses.
+func (r *Resolver) FlushCache() {
+	r.cache.flush()
+}
+
 // parseExitNodeQuery parses a DNS request packet.
 // It returns nil if it's malformed or lacking a question.
 func parseExitNodeQuery(q []byte) *response {
@@ -1042,6 +1099,23 @@ func marshalResponse(resp *response) ([]byte, error) {
 		return nil, err
 	}
 
+	// The answer for an alias is its CNAME, then the target's addresses.
+	if resp.CNAME != "" && resp.Question.Type != dns.TypeCNAME {
+		if err := marshalCNAME(resp.Question.Name, resp.CNAME, &builder); err != nil {
+			return nil, err
+		}
+		target, err := dns.NewName(resp.CNAME)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range resp.IPs {
+			if err := marshalIP(target, ip, &builder); err != nil {
+				return nil, err
+			}
+		}
+		return builder.Finish()
+	}
+
 	switch resp.Question.Type {
 	case dns.TypeA, dns.TypeAAAA, dns.TypeALL:
 		if err := marshalIP(resp.Question.Name, resp.IP, &builder); err != nil {
@@ -1240,8 +1314,15 @@ func (r *Resolver) respond(query []byte) ([]byte, error) {
 		return r.respondReverse(query, name, parser.response())
 	}
 
+	if resp := r.respondRecords(name, parser); resp != nil {
+		return marshalResponse(resp)
+	}
+
 	ip, rcode := r.resolveLocal(name, parser.Question.Type)
 	if rcode == dns.RCodeRefused {
+		if resp := r.respondBlocked(name, parser); resp != nil {
+			return marshalResponse(resp)
+		}
 		return nil, errNotOurName // sentinel error return value: it requests forwarding
 	}
 
@@ -1251,6 +1332,64 @@ func (r *Resolver) respond(query []byte) ([]byte, error) {
 	return marshalResponse(resp)
 }
 
+// respondRecords returns the response to the query in parser for name
+// from the resolver's Records, or nil if it has none. It leaves A and
+// AAAA queries for a name that isn't an alias to resolveLocal.
+func (r *Resolver) respondRecords(name dnsname.FQDN, parser *dnsParser) *response {
+	r.mu.Lock()
+	rec, ok := r.records[name]
+	hosts := r.hostToIP
+	r.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	typ := parser.Question.Type
+	resp := parser.response()
+	switch {
+	case rec.CNAME != "":
+		// Answer an alias's CNAME whatever the type, along with the
+		// target's addresses if they're ours, like other servers do.
+		resp.CNAME = rec.CNAME.WithTrailingDot()
+		for _, ip := range hosts[rec.CNAME] {
+			if typ == dns.TypeA && ip.Is4() || typ == dns.TypeAAAA && ip.Is6() {
+				resp.IPs = append(resp.IPs, ip)
+			}
+		}
+	case typ == dns.TypeTXT:
+		resp.TXT = rec.TXT
+	case typ == dns.TypeSRV:
+		resp.SRVs = rec.SRV
+	default:
+		if _, ok := hosts[name]; ok {
+			return nil
+		}
+		// The name exists, but has no records of typ.
+	}
+	metricDNSMagicDNSSuccessRecords.Add(1)
+	resp.Header.RCode = dns.RCodeSuccess
+	return resp
+}
+
+// respondBlocked returns the response to the query in parser for name
+// if a blocklist blocks name, or nil otherwise.
+func (r *Resolver) respondBlocked(name dnsname.FQDN, parser *dnsParser) *response {
+	blocked, nullIP := r.blocklist.blocked(name)
+	if !blocked {
+		return nil
+	}
+	metricDNSBlocked.Add(1)
+	resp := parser.response()
+	switch {
+	case nullIP && parser.Question.Type == dns.TypeA:
+		resp.IP = netip.IPv4Unspecified()
+	case nullIP && parser.Question.Type == dns.TypeAAAA:
+		resp.IP = netip.IPv6Unspecified()
+	default:
+		resp.Header.RCode = dns.RCodeNameError
+	}
+	return resp
+}
+
 // unARPA maps from "4.4.8.8.in-addr.arpa." to "8.8.4.4", etc.
 func unARPA(a string) (ipStr string, ok bool) {
 	const suf4 = ".in-addr.arpa."
@@ -1291,6 +1430,7 @@ func unARPA(a string) (ipStr string, ok bool) {
 
 	metricDNSMagicDNSSuccessName    = clientmetric.NewCounter("dns_query_magic_success_name")
 	metricDNSMagicDNSSuccessReverse = clientmetric.NewCounter("dns_query_magic_success_reverse")
+	metricDNSMagicDNSSuccessRecords = clientmetric.NewCounter("dns_query_magic_success_records")
 
 	metricDNSExitProxyQuery           = clientmetric.NewCounter("dns_exit_node_query")
 	metricDNSExitProxyErrorName       = clientmetric.NewCounter("dns_exit_node_error_name")
@@ -1331,6 +1471,18 @@ func unARPA(a string) (ipStr string, ok bool) {
 	metricDNSFwdDoHErrorTransport = clientmetric.NewCounter("dns_query_fwd_doh_error_transport")
 	metricDNSFwdDoHErrorBody      = clientmetric.NewCounter("dns_query_fwd_doh_error_body")
 
+	metricDNSCacheHit  = clientmetric.NewCounter("dns_query_cache_hit")
+	metricDNSCacheMiss = clientmetric.NewCounter("dns_query_cache_miss")
+
+	metricDNSBlocked            = clientmetric.NewCounter("dns_query_blocked")
+	metricDNSBlocklistLoadError = clientmetric.NewCounter("dns_blocklist_load_error")
+	metricDNSBlocklistNames     = clientmetric.NewGauge("dns_blocklist_names")
+
+	metricDNSFwdDoT          = clientmetric.NewCounter("dns_query_fwd_dot")
+	metricDNSFwdDoTErrorDial = clientmetric.NewCounter("dns_query_fwd_dot_error_dial")
+	metricDNSFwdDoTError     = clientmetric.NewCounter("dns_query_fwd_dot_error")
+	metricDNSFwdDoTSuccess   = clientmetric.NewCounter("dns_query_fwd_dot_success")
+
 	metricDNSResolveLocal             = clientmetric.NewCounter("dns_resolve_local")
 	metricDNSResolveLocalErrorOnion   = clientmetric.NewCounter("dns_resolve_local_error_onion")
 	metricDNSResolveLocalErrorMissing = clientmetric.NewCounter("dns_resolve_local_error_missing")
@@ -377,6 +377,54 @@ func TestResolveLocal(t *testing.T) {
 	}
 }
 
+func TestResolveRecords(t *testing.T) {
+	r := newResolver(t)
+	defer r.Close()
+
+	cfg := dnsCfg
+	cfg.Records = map[dnsname.FQDN]*Records{
+		"test1.ipn.dev.": {TXT: []string{"owner=infra"}},
+		"_ssh._tcp.test1.ipn.dev.": {SRV: []*net.SRV{
+			{Target: "test1.ipn.dev.", Port: 22, Priority: 0, Weight: 5},
+		}},
+		"www.test1.ipn.dev.": {CNAME: "test1.ipn.dev."},
+	}
+	r.SetConfig(cfg)
+
+	tests := []struct {
+		qname dnsname.FQDN
+		qtype dns.Type
+		rcode string
+		want  []string
+	}{
+		{"test1.ipn.dev.", dns.TypeTXT, "NOERROR", []string{`test1.ipn.dev. 600 IN TXT "owner=infra"`}},
+		{"test1.ipn.dev.", dns.TypeA, "NOERROR", []string{"test1.ipn.dev. 600 IN A 1.2.3.4"}},
+		{"_ssh._tcp.test1.ipn.dev.", dns.TypeSRV, "NOERROR", []string{"_ssh._tcp.test1.ipn.dev. 600 IN SRV 0 5 22 test1.ipn.dev."}},
+		{"_ssh._tcp.test1.ipn.dev.", dns.TypeA, "NOERROR", nil},
+		{"www.test1.ipn.dev.", dns.TypeA, "NOERROR", []string{
+			"www.test1.ipn.dev. 600 IN CNAME test1.ipn.dev.",
+			"test1.ipn.dev. 600 IN A 1.2.3.4",
+		}},
+		{"www.test1.ipn.dev.", dns.TypeCNAME, "NOERROR", []string{"www.test1.ipn.dev. 600 IN CNAME test1.ipn.dev."}},
+		{"_ftp._tcp.test1.ipn.dev.", dns.TypeSRV, "NXDOMAIN", nil},
+	}
+	for _, tt := range tests {
+		res, err := syncRespond(r, dnspacket(tt.qname, tt.qtype, noEdns))
+		if err != nil {
+			t.Errorf("%s %v: %v", tt.qname, tt.qtype, err)
+			continue
+		}
+		var got dnstype.QueryAttempt
+		traceResponse(&got, res)
+		if got.Err != "" {
+			t.Errorf("%s %v: %v", tt.qname, tt.qtype, got.Err)
+		}
+		if got.RCode != tt.rcode || !reflect.DeepEqual(got.Answers, tt.want) {
+			t.Errorf("%s %v = %s %q; want %s %q", tt.qname, tt.qtype, got.RCode, got.Answers, tt.rcode, tt.want)
+		}
+	}
+}
+
 func TestResolveLocalReverse(t *testing.T) {
 	r := newResolver(t)
 	defer r.Close()
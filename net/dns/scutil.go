@@ -0,0 +1,146 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package dns
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"tailscale.com/types/logger"
+)
+
+// scutilPath is the path to macOS's scutil(8), which configures the
+// SystemConfiguration dynamic store.
+const scutilPath = "/usr/sbin/scutil"
+
+// scutilManager is a macOS OSConfigurator that publishes the DNS
+// configuration in the SystemConfiguration dynamic store, as the DNS
+// settings of a network service bound to the Tailscale interface, like
+// other VPN software does.
+//
+// Unlike the /etc/resolver files darwinConfigurator writes, configd
+// turns these into resolvers scoped to the Tailscale interface, which
+// it merges with, rather than lets get clobbered by, the resolvers
+// other VPNs publish.
+type scutilManager struct {
+	logf   logger.Logf
+	ifName string
+
+	// run runs an scutil script. It's a field for tests.
+	run func(script string) error
+}
+
+func newScutilManager(logf logger.Logf, ifName string) *scutilManager {
+	return &scutilManager{
+		logf:   logf,
+		ifName: ifName,
+		run:    runScutil,
+	}
+}
+
+// serviceKey returns the dynamic store key of the entity (such as
+// "DNS" or "IPv4") of m's network service.
+func (m *scutilManager) serviceKey(entity string) string {
+	return "State:/Network/Service/tailscale-" + m.ifName + "/" + entity
+}
+
+func (m *scutilManager) Mode() string { return "scutil" }
+
+func (m *scutilManager) SupportsSplitDNS() bool { return true }
+
+func (m *scutilManager) GetBaseConfig() (OSConfig, error) {
+	return OSConfig{}, ErrGetBaseConfigNotSupported
+}
+
+func (m *scutilManager) SetDNS(cfg OSConfig) error {
+	if cfg.IsZero() {
+		return m.run(m.removeScript())
+	}
+	return m.run(m.setScript(cfg))
+}
+
+func (m *scutilManager) Close() error {
+	return m.run(m.removeScript())
+}
+
+// setScript returns the scutil script that publishes cfg.
+//
+// With MatchDomains, the nameservers only serve those suffixes.
+// Without, the service overrides the primary service's DNS settings,
+// making the nameservers the default ones.
+func (m *scutilManager) setScript(cfg OSConfig) string {
+	var b strings.Builder
+	b.WriteString("open\n")
+	b.WriteString("d.init\n")
+	if len(cfg.Nameservers) > 0 {
+		b.WriteString("d.add ServerAddresses *")
+		for _, ip := range cfg.Nameservers {
+			b.WriteString(" " + ip.String())
+		}
+		b.WriteString("\n")
+	}
+	if len(cfg.MatchDomains) > 0 {
+		b.WriteString("d.add SupplementalMatchDomains *")
+		for _, d := range cfg.MatchDomains {
+			b.WriteString(" " + string(d.WithoutTrailingDot()))
+		}
+		b.WriteString("\n")
+	}
+	if len(cfg.SearchDomains) > 0 {
+		b.WriteString("d.add SearchDomains *")
+		for _, d := range cfg.SearchDomains {
+			b.WriteString(" " + string(d.WithoutTrailingDot()))
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "set %s\n", m.serviceKey("DNS"))
+
+	// The IPv4 entity binds the service, and so its resolvers, to
+	// the Tailscale interface.
+	b.WriteString("d.init\n")
+	fmt.Fprintf(&b, "d.add InterfaceName %s\n", m.ifName)
+	if len(cfg.MatchDomains) == 0 && len(cfg.Nameservers) > 0 {
+		b.WriteString("d.add OverridePrimary # 1\n")
+	}
+	fmt.Fprintf(&b, "set %s\n", m.serviceKey("IPv4"))
+	b.WriteString("quit\n")
+	return b.String()
+}
+
+// removeScript returns the scutil script that removes m's service.
+func (m *scutilManager) removeScript() string {
+	return fmt.Sprintf("open\nremove %s\nremove %s\nquit\n", m.serviceKey("DNS"), m.serviceKey("IPv4"))
+}
+
+// runScutil runs script with scutil. scutil exits zero even if a
+// command fails, so any output is an error, other than about removing
+// keys that don't exist.
+func runScutil(script string) error {
+	cmd := exec.Command(scutilPath)
+	cmd.Stdin = strings.NewReader(script)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("running scutil: %w; output: %s", err, bytes.TrimSpace(out))
+	}
+	return scutilOutputError(out)
+}
+
+// scutilOutputError returns the error in the output out of an scutil
+// script, if any.
+func scutilOutputError(out []byte) error {
+	var errs []string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line == "No such key" {
+			continue
+		}
+		errs = append(errs, line)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("scutil: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package dns
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/util/dnsname"
+)
+
+func TestScutilManager(t *testing.T) {
+	var scripts []string
+	m := newScutilManager(t.Logf, "utun3")
+	m.run = func(script string) error {
+		scripts = append(scripts, script)
+		return nil
+	}
+	fqdns := func(names ...string) (ret []dnsname.FQDN) {
+		for _, n := range names {
+			ret = append(ret, dnsname.FQDN(n))
+		}
+		return ret
+	}
+
+	tests := []struct {
+		name string
+		cfg  OSConfig
+		want string
+	}{
+		{
+			name: "split",
+			cfg: OSConfig{
+				Nameservers:   []netip.Addr{netip.MustParseAddr("100.100.100.100")},
+				MatchDomains:  fqdns("ts.net.", "corp.example.com."),
+				SearchDomains: fqdns("foo.ts.net."),
+			},
+			want: `open
+d.init
+d.add ServerAddresses * 100.100.100.100
+d.add SupplementalMatchDomains * ts.net corp.example.com
+d.add SearchDomains * foo.ts.net
+set State:/Network/Service/tailscale-utun3/DNS
+d.init
+d.add InterfaceName utun3
+set State:/Network/Service/tailscale-utun3/IPv4
+quit
+`,
+		},
+		{
+			name: "override",
+			cfg: OSConfig{
+				Nameservers: []netip.Addr{netip.MustParseAddr("100.100.100.100")},
+			},
+			want: `open
+d.init
+d.add ServerAddresses * 100.100.100.100
+set State:/Network/Service/tailscale-utun3/DNS
+d.init
+d.add InterfaceName utun3
+d.add OverridePrimary # 1
+set State:/Network/Service/tailscale-utun3/IPv4
+quit
+`,
+		},
+		{
+			name: "zero",
+			want: `open
+remove State:/Network/Service/tailscale-utun3/DNS
+remove State:/Network/Service/tailscale-utun3/IPv4
+quit
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scripts = nil
+			if err := m.SetDNS(tt.cfg); err != nil {
+				t.Fatal(err)
+			}
+			if len(scripts) != 1 || scripts[0] != tt.want {
+				t.Errorf("scripts = %q; want %q", scripts, tt.want)
+			}
+		})
+	}
+}
+
+func TestScutilOutputError(t *testing.T) {
+	if err := scutilOutputError([]byte("  No such key\n\n")); err != nil {
+		t.Errorf("missing key: got error %v", err)
+	}
+	if err := scutilOutputError([]byte("set: failed\n")); err == nil {
+		t.Error("failure output: got no error")
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package dns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/dnsname"
+)
+
+// TraceQuery resolves name through the same pipeline as queries to
+// tailscaled's resolver, and reports how. Unless name has a trailing dot,
+// it's first expanded with the search domains set on the OS, like the OS
+// resolver would with ndots:1.
+func (m *Manager) TraceQuery(ctx context.Context, name string, typ dns.Type) (*dnstype.QueryTrace, error) {
+	if name == "" || name == "." {
+		return nil, fmt.Errorf("invalid DNS name %q", name)
+	}
+	type candidate struct {
+		fqdn         dnsname.FQDN
+		searchDomain dnsname.FQDN
+	}
+	var candidates []candidate
+	addAbsolute := func() error {
+		fqdn, err := dnsname.ToFQDN(name)
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, candidate{fqdn: fqdn})
+		return nil
+	}
+	if strings.HasSuffix(name, ".") || strings.Contains(name, ".") {
+		if err := addAbsolute(); err != nil {
+			return nil, err
+		}
+	}
+	if !strings.HasSuffix(name, ".") {
+		for _, sd := range m.searchDomains.Load() {
+			fqdn, err := dnsname.ToFQDN(name + "." + sd.WithTrailingDot())
+			if err != nil {
+				continue
+			}
+			candidates = append(candidates, candidate{fqdn, sd})
+		}
+		if !strings.Contains(name, ".") {
+			if err := addAbsolute(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	trace := &dnstype.QueryTrace{
+		Name: name,
+		Type: strings.TrimPrefix(typ.String(), "Type"),
+	}
+	for _, c := range candidates {
+		at := m.resolver.TraceQuery(ctx, c.fqdn, typ)
+		at.SearchDomain = string(c.searchDomain)
+		trace.Attempts = append(trace.Attempts, at)
+		// Like the OS resolver, move on to the next name only if this
+		// one doesn't exist or has no records of typ.
+		noData := at.RCode == "NOERROR" && len(at.Answers) == 0
+		if at.RCode != "NXDOMAIN" && !noData {
+			break
+		}
+	}
+	return trace, nil
+}
@@ -0,0 +1,69 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package dns
+
+import (
+	"context"
+	"testing"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/net/tsdial"
+)
+
+func TestManagerTraceQuery(t *testing.T) {
+	f := fakeOSConfigurator{SplitDNS: true}
+	m := NewManager(t.Logf, &f, nil, new(tsdial.Dialer), nil, nil)
+	defer m.Down()
+	if err := m.Set(Config{
+		Hosts:         hosts("foo.tailnet.ts.net.", "100.101.102.103"),
+		Routes:        upstreams("tailnet.ts.net.", "", "corp.example.", ""),
+		SearchDomains: fqdns("corp.example.", "tailnet.ts.net."),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	type attempt struct{ fqdn, searchDomain, rcode string }
+	tests := []struct {
+		name string
+		want []attempt
+	}{
+		{"foo", []attempt{
+			{"foo.corp.example.", "corp.example.", "NXDOMAIN"},
+			{"foo.tailnet.ts.net.", "tailnet.ts.net.", "NOERROR"},
+		}},
+		{"foo.tailnet.ts.net.", []attempt{
+			{"foo.tailnet.ts.net.", "", "NOERROR"},
+		}},
+		{"foo.tailnet.ts.net", []attempt{
+			{"foo.tailnet.ts.net.", "", "NOERROR"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := m.TraceQuery(context.Background(), tt.name, dns.TypeA)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []attempt
+			for _, at := range tr.Attempts {
+				if at.Source != "magicdns" {
+					t.Errorf("%s: Source = %q; want magicdns", at.FQDN, at.Source)
+				}
+				got = append(got, attempt{at.FQDN, at.SearchDomain, at.RCode})
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("attempts = %v; want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("attempt %d = %v; want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+
+	if _, err := m.TraceQuery(context.Background(), "", dns.TypeA); err == nil {
+		t.Errorf("traced an empty name")
+	}
+}
@@ -122,39 +122,11 @@ func lookup(ctx context.Context, host string, logf logger.Logf, netMon *netmon.M
 		return []netip.Addr{ip}, nil
 	}
 
-	type nameIP struct {
-		dnsName string
-		ip      netip.Addr
-	}
-
-	dm := getDERPMap()
-
-	var cands4, cands6 []nameIP
-	for _, dr := range dm.Regions {
-		for _, n := range dr.Nodes {
-			if ip, err := netip.ParseAddr(n.IPv4); err == nil {
-				cands4 = append(cands4, nameIP{n.HostName, ip})
-			}
-			if ip, err := netip.ParseAddr(n.IPv6); err == nil {
-				cands6 = append(cands6, nameIP{n.HostName, ip})
-			}
-		}
-	}
-	slicesx.Shuffle(cands4)
-	slicesx.Shuffle(cands6)
-
-	const maxCands = 6
-	var cands []nameIP // up to maxCands alternating v4/v6 as long as we have both
-	for (len(cands4) > 0 || len(cands6) > 0) && len(cands) < maxCands {
-		if len(cands4) > 0 {
-			cands = append(cands, cands4[0])
-			cands4 = cands4[1:]
-		}
-		if len(cands6) > 0 {
-			cands = append(cands, cands6[0])
-			cands6 = cands6[1:]
-		}
+	var v6Only bool
+	if netMon != nil {
+		v6Only = netMon.InterfaceState().IsV6Only()
 	}
+	cands := bootstrapCandidates(getDERPMap(), v6Only)
 	if len(cands) == 0 {
 		return nil, fmt.Errorf("no DNS fallback options for %q", host)
 	}
@@ -182,6 +154,49 @@ type nameIP struct {
 	return nil, fmt.Errorf("no DNS fallback candidates remain for %q", host)
 }
 
+// nameIP is a DERP node's name and one of its IPs, to use as a
+// bootstrap DNS server.
+type nameIP struct {
+	dnsName string
+	ip      netip.Addr
+}
+
+// maxBootstrapCandidates is the most DERP nodes that lookup asks.
+const maxBootstrapCandidates = 6
+
+// bootstrapCandidates returns, in random order, the DERP nodes of dm to
+// ask for DNS bootstrap, alternating between IPv4 and IPv6 as long as
+// there are both. If v6Only, the machine has no IPv4 to dial with, so
+// only IPv6 candidates are returned.
+func bootstrapCandidates(dm *tailcfg.DERPMap, v6Only bool) []nameIP {
+	var cands4, cands6 []nameIP
+	for _, dr := range dm.Regions {
+		for _, n := range dr.Nodes {
+			if ip, err := netip.ParseAddr(n.IPv4); err == nil && !v6Only {
+				cands4 = append(cands4, nameIP{n.HostName, ip})
+			}
+			if ip, err := netip.ParseAddr(n.IPv6); err == nil {
+				cands6 = append(cands6, nameIP{n.HostName, ip})
+			}
+		}
+	}
+	slicesx.Shuffle(cands4)
+	slicesx.Shuffle(cands6)
+
+	var cands []nameIP // up to maxBootstrapCandidates alternating v4/v6 as long as we have both
+	for (len(cands4) > 0 || len(cands6) > 0) && len(cands) < maxBootstrapCandidates {
+		if len(cands4) > 0 {
+			cands = append(cands, cands4[0])
+			cands4 = cands4[1:]
+		}
+		if len(cands6) > 0 {
+			cands = append(cands, cands6[0])
+			cands6 = cands6[1:]
+		}
+	}
+	return cands
+}
+
 // serverName and serverIP of are, say, "derpN.tailscale.com".
 // queryName is the name being sought (e.g. "controlplane.tailscale.com"), passed as hint.
 func bootstrapDNSMap(ctx context.Context, serverName string, serverIP netip.Addr, queryName string, logf logger.Logf, netMon *netmon.Monitor) (dnsMap, error) {
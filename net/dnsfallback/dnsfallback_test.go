@@ -170,3 +170,52 @@ func TestCacheUnchanged(t *testing.T) {
 		t.Fatalf("didn't find non-empty regular file; mode=%v size=%d", st.Mode(), st.Size())
 	}
 }
+
+func TestBootstrapCandidates(t *testing.T) {
+	dm := &tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			1: {
+				RegionID: 1,
+				Nodes: []*tailcfg.DERPNode{
+					{Name: "1a", HostName: "derp1a", IPv4: "1.0.0.1", IPv6: "2001:db8::1"},
+					{Name: "1b", HostName: "derp1b", IPv4: "1.0.0.2"},
+				},
+			},
+			2: {
+				RegionID: 2,
+				Nodes: []*tailcfg.DERPNode{
+					{Name: "2a", HostName: "derp2a", IPv6: "2001:db8::2"},
+				},
+			},
+		},
+	}
+	tests := []struct {
+		name     string
+		v6Only   bool
+		want4    int
+		want6    int
+		firstIs4 bool
+	}{
+		{name: "dual-stack", want4: 2, want6: 2, firstIs4: true},
+		{name: "v6-only", v6Only: true, want4: 0, want6: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cands := bootstrapCandidates(dm, tt.v6Only)
+			var got4, got6 int
+			for _, c := range cands {
+				if c.ip.Is4() {
+					got4++
+				} else {
+					got6++
+				}
+			}
+			if got4 != tt.want4 || got6 != tt.want6 {
+				t.Errorf("got %d IPv4 and %d IPv6 candidates; want %d and %d", got4, got6, tt.want4, tt.want6)
+			}
+			if len(cands) > 0 && cands[0].ip.Is4() != tt.firstIs4 {
+				t.Errorf("first candidate = %v; want IPv4 = %v", cands[0].ip, tt.firstIs4)
+			}
+		})
+	}
+}
@@ -401,6 +401,13 @@ func (s *State) AnyInterfaceUp() bool {
 	return s != nil && (s.HaveV4 || s.HaveV6)
 }
 
+// IsV6Only reports whether the machine seems to only have IPv6 Internet
+// access, as on IPv6-only networks without a CLAT. Dialing IPv4 addresses
+// on such networks fails.
+func (s *State) IsV6Only() bool {
+	return s != nil && s.HaveV6 && !s.HaveV4
+}
+
 func netAddrsEqual(a, b []net.Addr) bool {
 	if len(a) != len(b) {
 		return false
@@ -566,6 +573,45 @@ func LikelyHomeRouterIP() (gateway, myIP netip.Addr, ok bool) {
 	return gateway, myIP, myIP.IsValid()
 }
 
+var likelyHomeRouterIPs func() []netip.Addr
+
+// HomeRouter is a likely residential router and the IP address of the
+// current machine on its LAN.
+type HomeRouter struct {
+	Gateway netip.Addr
+	MyIP    netip.Addr
+}
+
+// LikelyHomeRouterIPs is like LikelyHomeRouterIP, but returns all the
+// likely residential routers of a machine on more than one LAN, such as a
+// laptop on both Ethernet and Wi-Fi. The one LikelyHomeRouterIP returns,
+// if any, is first. Other routers are only found on Linux.
+func LikelyHomeRouterIPs() []HomeRouter {
+	var ret []HomeRouter
+	if gw, myIP, ok := LikelyHomeRouterIP(); ok {
+		ret = append(ret, HomeRouter{gw, myIP})
+	}
+	if likelyHomeRouterIPs == nil {
+		return ret
+	}
+	for _, gw := range likelyHomeRouterIPs() {
+		if len(ret) > 0 && ret[0].Gateway == gw {
+			continue
+		}
+		// Use the machine's IP on the same subnet as the router.
+		var myIP netip.Addr
+		ForeachInterfaceAddress(func(i Interface, pfx netip.Prefix) {
+			if i.IsUp() && !myIP.IsValid() && pfx.Addr().Is4() && pfx.Contains(gw) {
+				myIP = pfx.Addr()
+			}
+		})
+		if myIP.IsValid() {
+			ret = append(ret, HomeRouter{gw, myIP})
+		}
+	}
+	return ret
+}
+
 // isUsableV4 reports whether ip is a usable IPv4 address which could
 // conceivably be used to get Internet connectivity. Globally routable and
 // private IPv4 addresses are always Usable, and link local 169.254.x.x
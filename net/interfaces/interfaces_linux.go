@@ -15,6 +15,7 @@
 	"os"
 	"os/exec"
 	"runtime"
+	"slices"
 	"strings"
 	"sync/atomic"
 
@@ -28,6 +29,7 @@
 
 func init() {
 	likelyHomeRouterIP = likelyHomeRouterIPLinux
+	likelyHomeRouterIPs = likelyHomeRouterIPsLinux
 }
 
 var procNetRouteErr atomic.Bool
@@ -54,9 +56,60 @@ func likelyHomeRouterIPLinux() (ret netip.Addr, ok bool) {
 		}
 		return ret, false
 	}
-	lineNum := 0
+	lineNum, err := procNetRouteGateways(func(ip netip.Addr) bool {
+		ret = ip
+		return false
+	})
+	if err != nil {
+		procNetRouteErr.Store(true)
+		if runtime.GOOS == "android" {
+			return likelyHomeRouterIPAndroid()
+		}
+		log.Printf("interfaces: failed to read /proc/net/route: %v", err)
+	}
+	if ret.IsValid() {
+		return ret, true
+	}
+	if lineNum >= maxProcNetRouteRead {
+		// If we went over our line limit without finding an answer, assume
+		// we're a big fancy Linux router (or at least not a home system)
+		// and set the error bit so we stop trying this in the future (and wasting CPU).
+		// See https://github.com/tailscale/tailscale/issues/7621.
+		//
+		// Remember that "likelyHomeRouterIP" exists purely to find the port
+		// mapping service (UPnP, PMP, PCP) often present on a home router. If we hit
+		// the route (line) limit without finding an answer, we're unlikely to ever
+		// find one in the future.
+		procNetRouteErr.Store(true)
+	}
+	return netip.Addr{}, false
+}
+
+// likelyHomeRouterIPsLinux returns the private IPv4 gateways of all the
+// up routes in /proc/net/route, in order, for machines with more than one
+// home router.
+func likelyHomeRouterIPsLinux() (ret []netip.Addr) {
+	if procNetRouteErr.Load() {
+		if ip, ok := likelyHomeRouterIPLinux(); ok {
+			return []netip.Addr{ip}
+		}
+		return nil
+	}
+	procNetRouteGateways(func(ip netip.Addr) bool {
+		if !slices.Contains(ret, ip) {
+			ret = append(ret, ip)
+		}
+		return true
+	})
+	return ret
+}
+
+// procNetRouteGateways calls fn with the private IPv4 gateway of each up
+// route in /proc/net/route, in order, until fn returns false. It returns
+// how many lines it read, stopping after maxProcNetRouteRead.
+func procNetRouteGateways(fn func(netip.Addr) bool) (lineNum int, err error) {
 	var f []mem.RO
-	err := lineread.File(procNetRoutePath, func(line []byte) error {
+	err = lineread.File(procNetRoutePath, func(line []byte) error {
 		lineNum++
 		if lineNum == 1 {
 			// Skip header line.
@@ -82,8 +135,7 @@ func likelyHomeRouterIPLinux() (ret netip.Addr, ok bool) {
 			return nil // ignore error, skip line and keep going
 		}
 		ip := netaddr.IPv4(byte(ipu32), byte(ipu32>>8), byte(ipu32>>16), byte(ipu32>>24))
-		if ip.IsPrivate() {
-			ret = ip
+		if ip.IsPrivate() && !fn(ip) {
 			return errStopReading
 		}
 		return nil
@@ -91,29 +143,7 @@ func likelyHomeRouterIPLinux() (ret netip.Addr, ok bool) {
 	if errors.Is(err, errStopReading) {
 		err = nil
 	}
-	if err != nil {
-		procNetRouteErr.Store(true)
-		if runtime.GOOS == "android" {
-			return likelyHomeRouterIPAndroid()
-		}
-		log.Printf("interfaces: failed to read /proc/net/route: %v", err)
-	}
-	if ret.IsValid() {
-		return ret, true
-	}
-	if lineNum >= maxProcNetRouteRead {
-		// If we went over our line limit without finding an answer, assume
-		// we're a big fancy Linux router (or at least not a home system)
-		// and set the error bit so we stop trying this in the future (and wasting CPU).
-		// See https://github.com/tailscale/tailscale/issues/7621.
-		//
-		// Remember that "likelyHomeRouterIP" exists purely to find the port
-		// mapping service (UPnP, PMP, PCP) often present on a home router. If we hit
-		// the route (line) limit without finding an answer, we're unlikely to ever
-		// find one in the future.
-		procNetRouteErr.Store(true)
-	}
-	return netip.Addr{}, false
+	return lineNum, err
 }
 
 // Android apps don't have permission to read /proc/net/route, at
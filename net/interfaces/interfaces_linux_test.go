@@ -7,8 +7,10 @@
 	"errors"
 	"fmt"
 	"io/fs"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 
 	"tailscale.com/tstest"
@@ -35,6 +37,28 @@ func TestGoogleCloudRunDefaultRouteInterface(t *testing.T) {
 	}
 }
 
+func TestLikelyHomeRouterIPsLinux(t *testing.T) {
+	dir := t.TempDir()
+	tstest.Replace(t, &procNetRoutePath, filepath.Join(dir, "MultiHomed"))
+	buf := []byte("Iface\tDestination\tGateway\tFlags\tRefCnt\tUse\tMetric\tMask\tMTU\tWindow\tIRTT\n" +
+		"eth0\t00000000\t0101A8C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n" + // 192.168.1.1
+		"wlan0\t00000000\t0100000A\t0003\t0\t0\t600\t00000000\t0\t0\t0\n" + // 10.0.0.1
+		"eth0\t0001A8C0\t00000000\t0001\t0\t0\t100\t00FFFFFF\t0\t0\t0\n" + // no gateway
+		"eth2\t00000000\t08080808\t0003\t0\t0\t700\t00000000\t0\t0\t0\n" + // public
+		"eth3\t00000A0A\t0101A8C0\t0003\t0\t0\t100\t00FFFFFF\t0\t0\t0\n") // duplicate
+	if err := os.WriteFile(procNetRoutePath, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got := likelyHomeRouterIPsLinux()
+	want := []netip.Addr{netip.MustParseAddr("192.168.1.1"), netip.MustParseAddr("10.0.0.1")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	if ip, ok := likelyHomeRouterIPLinux(); !ok || ip != want[0] {
+		t.Errorf("likelyHomeRouterIPLinux = %v, %v; want %v", ip, ok, want[0])
+	}
+}
+
 // we read chunks of /proc/net/route at a time, test that files longer than the chunk
 // size can be handled.
 func TestExtremelyLongProcNetRoute(t *testing.T) {
@@ -0,0 +1,106 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netcheck
+
+import (
+	"fmt"
+	"time"
+
+	"tailscale.com/types/opt"
+)
+
+// historySize is how many recent Reports a Client keeps for History.
+const historySize = 64
+
+// HistoryEntry is a Report in a Client's History.
+type HistoryEntry struct {
+	Time   time.Time
+	Report *Report
+
+	// Changes is how the key facts about the network changed since the
+	// previous Report, as described by ReportChanges.
+	Changes []string `json:",omitempty"`
+}
+
+// History returns the Client's most recent Reports, oldest first, for
+// debugging how the network has changed over time.
+func (c *Client) History() []HistoryEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]HistoryEntry(nil), c.history...)
+}
+
+// addHistory adds r, made at now, to c's History.
+func (c *Client) addHistory(now time.Time, r *Report) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var prev *Report
+	if n := len(c.history); n > 0 {
+		prev = c.history[n-1].Report
+	}
+	if len(c.history) >= historySize {
+		c.history = append(c.history[:0], c.history[len(c.history)-historySize+1:]...)
+	}
+	c.history = append(c.history, HistoryEntry{
+		Time:    now,
+		Report:  r.Clone(),
+		Changes: ReportChanges(prev, r),
+	})
+}
+
+// ReportChanges describes how the key facts about the network differ
+// between the earlier Report a and the later b: the NAT type, the
+// preferred DERP region, the availability of port mapping protocols,
+// and whether UDP and IPv6 work. It returns nil if a is nil or none
+// changed.
+func ReportChanges(a, b *Report) []string {
+	if a == nil || b == nil {
+		return nil
+	}
+	var changes []string
+	add := func(format string, args ...any) {
+		changes = append(changes, fmt.Sprintf(format, args...))
+	}
+	if a.UDP != b.UDP {
+		add("UDP works: %v => %v", a.UDP, b.UDP)
+	}
+	if a.IPv6 != b.IPv6 {
+		add("IPv6 works: %v => %v", a.IPv6, b.IPv6)
+	}
+	if natType(a) != natType(b) {
+		add("NAT type: %s => %s", natType(a), natType(b))
+	}
+	if a.PreferredDERP != b.PreferredDERP {
+		add("preferred DERP: %d => %d", a.PreferredDERP, b.PreferredDERP)
+	}
+	// Port mapping availability is only compared when it was checked
+	// both times, as it's only probed in some reports.
+	for _, pm := range []struct {
+		name string
+		a, b opt.Bool
+	}{
+		{"UPnP", a.UPnP, b.UPnP},
+		{"NAT-PMP", a.PMP, b.PMP},
+		{"PCP", a.PCP, b.PCP},
+	} {
+		if pm.a != "" && pm.b != "" && pm.a != pm.b {
+			add("%s available: %v => %v", pm.name, pm.a, pm.b)
+		}
+	}
+	return changes
+}
+
+// natType returns a short description of r's IPv4 NAT's mapping
+// behavior.
+func natType(r *Report) string {
+	v, ok := r.MappingVariesByDestIP.Get()
+	switch {
+	case !ok:
+		return "unknown"
+	case v:
+		return "hard (mapping varies by destination)"
+	default:
+		return "easy"
+	}
+}
@@ -209,6 +209,7 @@ type Client struct {
 	lastFull time.Time             // time of last full (non-incremental) report
 	curState *reportState          // non-nil if we're in a call to GetReport
 	resolver *dnscache.Resolver    // only set if UseDNSCache is true
+	history  []HistoryEntry        // up to historySize recent reports, oldest first
 }
 
 func (c *Client) enoughRegions() int {
@@ -1023,6 +1024,7 @@ func (c *Client) finishAndStoreReport(rs *reportState, dm *tailcfg.DERPMap) *Rep
 	rs.mu.Unlock()
 
 	c.addReportHistoryAndSetPreferredDERP(report, dm.View())
+	c.addHistory(c.timeNow(), report)
 	c.logConciseReport(report, dm)
 
 	return report
@@ -188,6 +188,59 @@ func TestBasic(t *testing.T) {
 	if r.PreferredDERP != 1 {
 		t.Errorf("PreferredDERP = %v; want 1", r.PreferredDERP)
 	}
+	if h := c.History(); len(h) != 1 || h[0].Report.PreferredDERP != 1 {
+		t.Errorf("History = %+v; want the one report", h)
+	}
+}
+
+func TestReportChanges(t *testing.T) {
+	base := &Report{UDP: true, PreferredDERP: 1, MappingVariesByDestIP: "false", UPnP: "false"}
+	tests := []struct {
+		name string
+		b    Report
+		want []string
+	}{
+		{"same", *base, nil},
+		{"derp", Report{UDP: true, PreferredDERP: 2, MappingVariesByDestIP: "false", UPnP: "false"},
+			[]string{"preferred DERP: 1 => 2"}},
+		{"nat_and_upnp", Report{UDP: true, PreferredDERP: 1, MappingVariesByDestIP: "true", UPnP: "true"},
+			[]string{"NAT type: easy => hard (mapping varies by destination)", "UPnP available: false => true"}},
+		{"upnp_unchecked", Report{UDP: true, PreferredDERP: 1, MappingVariesByDestIP: "false"}, nil},
+		{"udp_blocked", Report{PreferredDERP: 1, UPnP: "false"},
+			[]string{"UDP works: true => false", "NAT type: easy => unknown"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ReportChanges(base, &tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+	if got := ReportChanges(nil, base); got != nil {
+		t.Errorf("ReportChanges(nil, r) = %q; want nil", got)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	c := new(Client)
+	t0 := time.Unix(1000, 0)
+	for i := 0; i < historySize+5; i++ {
+		c.addHistory(t0.Add(time.Duration(i)*time.Second), &Report{PreferredDERP: 1 + i/(historySize+4)})
+	}
+	h := c.History()
+	if len(h) != historySize {
+		t.Fatalf("len(History) = %d; want %d", len(h), historySize)
+	}
+	if got, want := h[0].Time, t0.Add(5*time.Second); !got.Equal(want) {
+		t.Errorf("oldest entry at %v; want %v", got, want)
+	}
+	last := h[len(h)-1]
+	if want := []string{"preferred DERP: 1 => 2"}; !reflect.DeepEqual(last.Changes, want) {
+		t.Errorf("last entry's Changes = %q; want %q", last.Changes, want)
+	}
+	if h[0].Changes != nil {
+		t.Errorf("unchanged entry's Changes = %q; want nil", h[0].Changes)
+	}
 }
 
 func TestWorksWhenUDPBlocked(t *testing.T) {
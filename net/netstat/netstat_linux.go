@@ -0,0 +1,129 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netstat
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// OSMetadata includes any additional OS-specific information that may be
+// obtained during the retrieval of a given Entry.
+type OSMetadata struct {
+	// UID is the user ID owning the socket.
+	UID int
+}
+
+// get returns the TCP connections in /proc/net/tcp and /proc/net/tcp6.
+// The entries' Pid is always zero: finding it requires scanning every
+// process's file descriptors.
+func get() (*Table, error) {
+	t := new(Table)
+	for _, name := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		f, err := os.Open(name)
+		if os.IsNotExist(err) && name == "/proc/net/tcp6" {
+			continue // IPv6 disabled
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.Entries, err = appendProcNetTCP(t.Entries, f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return t, nil
+}
+
+// appendProcNetTCP appends the entries of r, in the format of
+// /proc/net/tcp or /proc/net/tcp6, to es.
+func appendProcNetTCP(es []Entry, r io.Reader) ([]Entry, error) {
+	bs := bufio.NewScanner(r)
+	bs.Scan() // header
+	for bs.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid ...
+		f := strings.Fields(bs.Text())
+		if len(f) < 8 {
+			return nil, fmt.Errorf("short line %q", bs.Text())
+		}
+		local, err := parseProcNetAddr(f[1])
+		if err != nil {
+			return nil, err
+		}
+		remote, err := parseProcNetAddr(f[2])
+		if err != nil {
+			return nil, err
+		}
+		st, err := strconv.ParseUint(f[3], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid state %q", f[3])
+		}
+		uid, err := strconv.Atoi(f[7])
+		if err != nil {
+			return nil, fmt.Errorf("invalid uid %q", f[7])
+		}
+		es = append(es, Entry{
+			Local:      local,
+			Remote:     remote,
+			State:      linuxState(uint8(st)),
+			OSMetadata: OSMetadata{UID: uid},
+		})
+	}
+	return es, bs.Err()
+}
+
+// parseProcNetAddr parses an address of /proc/net/tcp{,6}: the IP as
+// hex 32-bit words in host byte order, a colon, and the port in hex.
+func parseProcNetAddr(s string) (netip.AddrPort, error) {
+	ipHex, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return netip.AddrPort{}, fmt.Errorf("invalid address %q", s)
+	}
+	b, err := hex.DecodeString(ipHex)
+	if err != nil || (len(b) != 4 && len(b) != 16) {
+		return netip.AddrPort{}, fmt.Errorf("invalid address %q", s)
+	}
+	for i := 0; i < len(b); i += 4 {
+		binary.NativeEndian.PutUint32(b[i:], binary.BigEndian.Uint32(b[i:]))
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid address %q", s)
+	}
+	ip, _ := netip.AddrFromSlice(b)
+	return netip.AddrPortFrom(ip.Unmap(), uint16(port)), nil
+}
+
+// linuxStates are the names of the kernel's TCP states, from
+// include/net/tcp_states.h.
+var linuxStates = []string{
+	"",
+	"ESTABLISHED",
+	"SYN-SENT",
+	"SYN-RECEIVED",
+	"FIN-WAIT-1",
+	"FIN-WAIT-2",
+	"TIME-WAIT",
+	"CLOSED",
+	"CLOSE-WAIT",
+	"LAST-ACK",
+	"LISTEN",
+	"CLOSING",
+	"NEW-SYN-RECEIVED",
+}
+
+func linuxState(v uint8) string {
+	if int(v) < len(linuxStates) {
+		return linuxStates[v]
+	}
+	return fmt.Sprintf("unknown-state-%d", v)
+}
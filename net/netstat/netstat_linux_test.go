@@ -0,0 +1,81 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netstat
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAppendProcNetTCP(t *testing.T) {
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("test data is from a little-endian machine")
+	}
+	const tcp = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:1484 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 21389 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:1484 0100007F:D6F2 01 00000000:00000000 00:00000000 00000000     0        0 55124 1 0000000000000000 20 4 30 10 -1
+   2: 0100007F:D6F2 0100007F:1484 01 00000000:00000000 00:00000000 00000000  1000        0 55123 1 0000000000000000 20 4 30 10 -1
+`
+	const tcp6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000001000000:0016 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 20163 1 0000000000000000 100 0 0 10 0
+`
+	es, err := appendProcNetTCP(nil, strings.NewReader(tcp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err = appendProcNetTCP(es, strings.NewReader(tcp6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ap := netip.MustParseAddrPort
+	want := []Entry{
+		{Local: ap("127.0.0.1:5252"), Remote: ap("0.0.0.0:0"), State: "LISTEN"},
+		{Local: ap("127.0.0.1:5252"), Remote: ap("127.0.0.1:55026"), State: "ESTABLISHED"},
+		{Local: ap("127.0.0.1:55026"), Remote: ap("127.0.0.1:5252"), State: "ESTABLISHED", OSMetadata: OSMetadata{UID: 1000}},
+		{Local: ap("[::1]:22"), Remote: ap("[::]:0"), State: "LISTEN"},
+	}
+	if !reflect.DeepEqual(es, want) {
+		t.Errorf("got %+v\nwant %+v", es, want)
+	}
+
+	if _, err := appendProcNetTCP(nil, strings.NewReader("header\n   0: 0100007F 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0\n")); err == nil {
+		t.Error("invalid address parsed without error")
+	}
+}
+
+func TestGetLoopbackConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	tab, err := Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := netip.MustParseAddrPort(c.LocalAddr().String())
+	for _, e := range tab.Entries {
+		if e.Local == local {
+			if e.Remote.String() != ln.Addr().String() {
+				t.Errorf("remote = %v; want %v", e.Remote, ln.Addr())
+			}
+			if e.OSMetadata.UID != os.Getuid() {
+				t.Errorf("uid = %v; want %v", e.OSMetadata.UID, os.Getuid())
+			}
+			return
+		}
+	}
+	t.Errorf("no entry for %v in %+v", local, tab.Entries)
+}
@@ -408,6 +408,16 @@ func (q *Parsed) IsTCPSyn() bool {
 	return (q.TCPFlags & TCPSynAck) == TCPSyn
 }
 
+// TCPSeqAck returns q's TCP sequence and acknowledgment numbers. It
+// reports false if q isn't a TCP packet with a complete header.
+func (q *Parsed) TCPSeqAck() (seq, ack uint32, ok bool) {
+	if q.IPProto != ipproto.TCP || len(q.b) < q.subofs+tcpHeaderLength {
+		return 0, 0, false
+	}
+	tcp := q.b[q.subofs:]
+	return binary.BigEndian.Uint32(tcp[4:]), binary.BigEndian.Uint32(tcp[8:]), true
+}
+
 // IsError reports whether q is an ICMP "Error" packet.
 func (q *Parsed) IsError() bool {
 	switch q.IPProto {
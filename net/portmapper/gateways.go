@@ -0,0 +1,133 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"net/netip"
+	"slices"
+	"time"
+
+	"tailscale.com/net/interfaces"
+	"tailscale.com/util/mak"
+)
+
+// SetGatewaysLookupFunc sets the func that returns all the machine's likely
+// home routers, used by OtherGatewayMappings. It must be called before the
+// client is used. If not called, interfaces.LikelyHomeRouterIPs is used.
+func (c *Client) SetGatewaysLookupFunc(f func() []interfaces.HomeRouter) {
+	c.gateways = f
+}
+
+// OtherGatewayMappings returns the port mappings made on the likely home
+// routers other than the default gateway, which GetCachedMappingOrStartCreatingOne
+// handles. Like GetCachedMappingOrStartCreatingOne, it only returns cached
+// mappings and starts creating (or renewing) any that are missing in the
+// background, calling the onChange hook once they're made.
+//
+// Mappings on gateways that have gone away are released.
+func (c *Client) OtherGatewayMappings() []netip.AddrPort {
+	var want []interfaces.HomeRouter
+	if c.gateways != nil {
+		want = c.gateways()
+	}
+	primary, _, _ := c.gatewayAndSelfIP()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	for gw, o := range c.others {
+		if gw == primary || !slices.ContainsFunc(want, func(hr interfaces.HomeRouter) bool {
+			return hr.Gateway == gw
+		}) {
+			o.Close()
+			delete(c.others, gw)
+		}
+	}
+
+	var ret []netip.AddrPort
+	for _, hr := range want {
+		if hr.Gateway == primary || !hr.Gateway.IsValid() || !hr.MyIP.IsValid() {
+			continue
+		}
+		o, ok := c.others[hr.Gateway]
+		if !ok {
+			o = c.newGatewayClientLocked(hr)
+			mak.Set(&c.others, hr.Gateway, o)
+		}
+		if ext, ok := o.GetCachedMappingOrStartCreatingOne(); ok {
+			ret = append(ret, ext)
+		}
+	}
+	return ret
+}
+
+// newGatewayClientLocked returns a Client that maps c.localPort on the
+// home router hr only.
+//
+// c.mu must be held.
+func (c *Client) newGatewayClientLocked(hr interfaces.HomeRouter) *Client {
+	return &Client{
+		logf:         c.logf,
+		netMon:       c.netMon,
+		controlKnobs: c.controlKnobs,
+		ipAndGateway: func() (gw, ip netip.Addr, ok bool) {
+			return hr.Gateway, hr.MyIP, true
+		},
+		onChange:     c.onChange,
+		debug:        c.debug,
+		testPxPPort:  c.testPxPPort,
+		testUPnPPort: c.testUPnPPort,
+		localPort:    c.localPort,
+	}
+}
+
+// Lease describes a port mapping held on a gateway.
+type Lease struct {
+	Gateway    netip.Addr     // the gateway holding the mapping
+	Self       netip.Addr     // our IP address on the gateway's network
+	Protocol   string         // "pmp", "pcp" or "upnp"
+	External   netip.AddrPort // the mapping's external address
+	GoodUntil  time.Time      // when the mapping expires
+	RenewAfter time.Time      // when the mapping will next be renewed
+}
+
+// Leases returns the port mappings currently held, on the default gateway
+// first and then on any other home routers.
+func (c *Client) Leases() []Lease {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := c.leaseLocked(nil)
+	gws := make([]netip.Addr, 0, len(c.others))
+	for gw := range c.others {
+		gws = append(gws, gw)
+	}
+	slices.SortFunc(gws, netip.Addr.Compare)
+	for _, gw := range gws {
+		o := c.others[gw]
+		o.mu.Lock()
+		ret = o.leaseLocked(ret)
+		o.mu.Unlock()
+	}
+	return ret
+}
+
+// leaseLocked appends c's mapping, if any, to dst and returns it.
+//
+// c.mu must be held.
+func (c *Client) leaseLocked(dst []Lease) []Lease {
+	m := c.mapping
+	if m == nil {
+		return dst
+	}
+	return append(dst, Lease{
+		Gateway:    c.lastGW,
+		Self:       c.lastMyIP,
+		Protocol:   m.MappingType(),
+		External:   m.External(),
+		GoodUntil:  m.GoodUntil(),
+		RenewAfter: m.RenewAfter(),
+	})
+}
@@ -19,6 +19,7 @@
 
 	"go4.org/mem"
 	"tailscale.com/control/controlknobs"
+	"tailscale.com/health"
 	"tailscale.com/net/interfaces"
 	"tailscale.com/net/netaddr"
 	"tailscale.com/net/neterror"
@@ -70,6 +71,7 @@ type Client struct {
 	netMon       *netmon.Monitor // optional; nil means interfaces will be looked up on-demand
 	controlKnobs *controlknobs.Knobs
 	ipAndGateway func() (gw, ip netip.Addr, ok bool)
+	gateways     func() []interfaces.HomeRouter
 	onChange     func() // or nil
 	debug        DebugKnobs
 	testPxPPort  uint16 // if non-zero, pxpPort to use for tests
@@ -101,6 +103,10 @@ type Client struct {
 	localPort uint16
 
 	mapping mapping // non-nil if we have a mapping
+
+	// others are the Clients for the gateways other than the one
+	// ipAndGateway returns, keyed by gateway IP. See OtherGatewayMappings.
+	others map[netip.Addr]*Client
 }
 
 // mapping represents a created port-mapping over some protocol.  It specifies a lease duration,
@@ -119,6 +125,9 @@ type mapping interface {
 	RenewAfter() time.Time
 	// External indicates what port the mapping can be reached from on the outside.
 	External() netip.AddrPort
+	// MappingType returns the protocol the mapping was made with: "pmp",
+	// "pcp" or "upnp".
+	MappingType() string
 }
 
 // HaveMapping reports whether we have a current valid mapping.
@@ -149,6 +158,7 @@ func (m *pmpMapping) externalValid() bool {
 func (p *pmpMapping) GoodUntil() time.Time     { return p.goodUntil }
 func (p *pmpMapping) RenewAfter() time.Time    { return p.renewAfter }
 func (p *pmpMapping) External() netip.AddrPort { return p.external }
+func (p *pmpMapping) MappingType() string      { return "pmp" }
 
 // Release does a best effort fire-and-forget release of the PMP mapping m.
 func (m *pmpMapping) Release(ctx context.Context) {
@@ -180,6 +190,7 @@ func NewClient(logf logger.Logf, netMon *netmon.Monitor, debug *DebugKnobs, cont
 		logf:         logf,
 		netMon:       netMon,
 		ipAndGateway: interfaces.LikelyHomeRouterIP,
+		gateways:     interfaces.LikelyHomeRouterIPs,
 		onChange:     onChange,
 		controlKnobs: controlKnobs,
 	}
@@ -204,6 +215,9 @@ func (c *Client) NoteNetworkDown() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.invalidateMappingsLocked(false)
+	for _, o := range c.others {
+		o.NoteNetworkDown()
+	}
 }
 
 func (c *Client) Close() error {
@@ -214,6 +228,10 @@ func (c *Client) Close() error {
 	}
 	c.closed = true
 	c.invalidateMappingsLocked(true)
+	for gw, o := range c.others {
+		o.Close()
+		delete(c.others, gw)
+	}
 	// TODO: close some future ever-listening UDP socket(s),
 	// waiting for multicast announcements from router.
 	return nil
@@ -229,6 +247,9 @@ func (c *Client) SetLocalPort(localPort uint16) {
 	}
 	c.localPort = localPort
 	c.invalidateMappingsLocked(true)
+	for _, o := range c.others {
+		o.SetLocalPort(localPort)
+	}
 }
 
 func (c *Client) gatewayAndSelfIP() (gw, myIP netip.Addr, ok bool) {
@@ -419,13 +440,40 @@ func (c *Client) createMapping() {
 		c.runningCreate = false
 	}()
 
-	if _, err := c.createOrGetMapping(ctx); err == nil && c.onChange != nil {
+	c.mu.Lock()
+	renewing := c.mapping != nil
+	c.mu.Unlock()
+
+	_, err := c.createOrGetMapping(ctx)
+	switch {
+	case err != nil && renewing:
+		metricMappingRenewFailed.Add(1)
+	case err != nil:
+		metricMappingFailed.Add(1)
+	case renewing:
+		metricMappingRenewed.Add(1)
+	default:
+		metricMappingCreated.Add(1)
+	}
+	if err == nil && c.onChange != nil {
 		go c.onChange()
 	} else if err != nil && !IsNoMappingError(err) {
 		c.logf("createOrGetMapping: %v", err)
 	}
+	if err != nil && !IsNoMappingError(err) {
+		warnMappingFailed.Set(fmt.Errorf("port mapping failed: %w", err))
+	} else {
+		warnMappingFailed.Set(nil)
+	}
 }
 
+// warnMappingFailed is set when a port mapping service was found but
+// creating or renewing a mapping with it failed.
+var warnMappingFailed = health.NewWarnable(health.WithCheck("portmapper", health.SeverityInfo, health.Remediation{
+	Code: "check-port-mapping",
+	Text: "Direct connections may take longer to establish. Check the router's UPnP, NAT-PMP or PCP settings.",
+}))
+
 // wildcardIP is used when the previous external IP is not known for PCP port mapping.
 var wildcardIP = netip.MustParseAddr("0.0.0.0")
 
@@ -956,6 +1004,17 @@ func (c *Client) Probe(ctx context.Context) (res ProbeResult, err error) {
 	// metricPXPResponse counts the number of times we received a PMP/PCP response.
 	metricPXPResponse = clientmetric.NewCounter("portmap_pxp_response")
 
+	// metricMappingCreated counts the port mappings created, over any
+	// protocol, and metricMappingFailed the attempts to create one that
+	// failed, including because no port mapping service was found.
+	metricMappingCreated = clientmetric.NewCounter("portmap_mapping_created")
+	metricMappingFailed  = clientmetric.NewCounter("portmap_mapping_failed")
+
+	// metricMappingRenewed counts the renewals of existing port mappings,
+	// and metricMappingRenewFailed the renewals that failed.
+	metricMappingRenewed     = clientmetric.NewCounter("portmap_mapping_renewed")
+	metricMappingRenewFailed = clientmetric.NewCounter("portmap_mapping_renew_failed")
+
 	// metricPCPSent counts the number of times we sent a PCP request.
 	metricPCPSent = clientmetric.NewCounter("portmap_pcp_sent")
 
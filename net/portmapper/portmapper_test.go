@@ -5,6 +5,7 @@
 
 import (
 	"context"
+	"net/netip"
 	"os"
 	"reflect"
 	"strconv"
@@ -12,6 +13,8 @@
 	"time"
 
 	"tailscale.com/control/controlknobs"
+	"tailscale.com/net/interfaces"
+	"tailscale.com/net/netaddr"
 )
 
 func TestCreateOrGetMapping(t *testing.T) {
@@ -135,3 +138,50 @@ func TestGetUPnPErrorsMetric(t *testing.T) {
 	getUPnPErrorsMetric(0)
 	getUPnPErrorsMetric(-100)
 }
+
+func TestOtherGatewayMappings(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PMP: false, PCP: true, UPnP: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	c.debug.DisablePMP = true // the test IGD doesn't do PMP mappings
+	c.SetLocalPort(1234)
+	// No default gateway, but the test IGD as another home router.
+	c.SetGatewayLookupFunc(func() (gw, ip netip.Addr, ok bool) { return })
+	c.SetGatewaysLookupFunc(func() []interfaces.HomeRouter {
+		gw, ip, _ := testIPAndGateway()
+		return []interfaces.HomeRouter{{Gateway: gw, MyIP: ip}}
+	})
+
+	var got []netip.AddrPort
+	for i := 0; i < 50 && len(got) == 0; i++ {
+		if i > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		got = c.OtherGatewayMappings()
+	}
+	if len(got) != 1 || !got[0].IsValid() {
+		t.Fatalf("OtherGatewayMappings = %v; want one mapping", got)
+	}
+
+	leases := c.Leases()
+	if len(leases) != 1 {
+		t.Fatalf("Leases = %+v; want one lease", leases)
+	}
+	if l := leases[0]; l.Protocol != "pcp" || l.External != got[0] || l.Gateway != netaddr.IPv4(127, 0, 0, 1) {
+		t.Errorf("unexpected lease %+v", l)
+	}
+
+	// Once the gateway goes away, its mapping is dropped.
+	c.SetGatewaysLookupFunc(func() []interfaces.HomeRouter { return nil })
+	if got := c.OtherGatewayMappings(); len(got) != 0 {
+		t.Errorf("OtherGatewayMappings after gateway removal = %v; want none", got)
+	}
+	if leases := c.Leases(); len(leases) != 0 {
+		t.Errorf("Leases after gateway removal = %+v; want none", leases)
+	}
+}
@@ -33,14 +33,17 @@
 // Extension, none), user-selected route acceptance prefs, etc.
 type Dialer struct {
 	Logf logger.Logf
-	// UseNetstackForIP if non-nil is whether NetstackDialTCP (if
-	// it's non-nil) should be used to dial the provided IP.
+	// UseNetstackForIP if non-nil is whether NetstackDialTCP or
+	// NetstackDialUDP (if non-nil) should be used to dial the provided IP.
 	UseNetstackForIP func(netip.Addr) bool
 
 	// NetstackDialTCP dials the provided IPPort using netstack.
 	// If nil, it's not used.
 	NetstackDialTCP func(context.Context, netip.AddrPort) (net.Conn, error)
 
+	// NetstackDialUDP is like NetstackDialTCP, but for UDP.
+	NetstackDialUDP func(context.Context, netip.AddrPort) (net.Conn, error)
+
 	peerClientOnce sync.Once
 	peerClient     *http.Client
 
@@ -237,6 +240,12 @@ func (d *Dialer) SetNetMap(nm *netmap.NetworkMap) {
 	d.dns = m
 }
 
+// UserDialResolve resolves addr as UserDial does, for callers that dial
+// the result themselves.
+func (d *Dialer) UserDialResolve(ctx context.Context, network, addr string) (netip.AddrPort, error) {
+	return d.userDialResolve(ctx, network, addr)
+}
+
 // userDialResolve resolves addr as if a user initiating the dial. (e.g. from a
 // SOCKS or HTTP outbound proxy)
 func (d *Dialer) userDialResolve(ctx context.Context, network, addr string) (netip.AddrPort, error) {
@@ -344,10 +353,14 @@ func (d *Dialer) UserDial(ctx context.Context, network, addr string) (net.Conn,
 		return nil, err
 	}
 	if d.UseNetstackForIP != nil && d.UseNetstackForIP(ipp.Addr()) {
-		if d.NetstackDialTCP == nil {
+		dial := d.NetstackDialTCP
+		if strings.HasPrefix(network, "udp") {
+			dial = d.NetstackDialUDP
+		}
+		if dial == nil {
 			return nil, errors.New("Dialer not initialized correctly")
 		}
-		return d.NetstackDialTCP(ctx, ipp)
+		return dial(ctx, ipp)
 	}
 	// TODO(bradfitz): netns, etc
 	var stdDialer net.Dialer
@@ -0,0 +1,172 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tstun
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/net/tstun/table"
+	"tailscale.com/tstime/rate"
+	"tailscale.com/types/key"
+	"tailscale.com/util/mak"
+	"tailscale.com/wgengine/wgcfg"
+)
+
+// minQoSBurst is the minimum burst size, in bytes, of a peer's rate
+// limiter. It must be at least as large as the largest packet we might
+// send, or such packets would never be allowed.
+const minQoSBurst = 64 << 10
+
+// peerQoS is the quality of service applied to traffic to a peer.
+type peerQoS struct {
+	maxTxBitrate int64         // or 0 for unlimited
+	limiter      *rate.Limiter // or nil if unlimited
+
+	// lastDSCP is the DSCP of the last packet sent to the peer, or
+	// noDSCP if none yet, when qosConfig.noteDSCP is set.
+	lastDSCP atomic.Uint32
+}
+
+// noDSCP is the peerQoS.lastDSCP before any packet is sent to the peer.
+const noDSCP = 0x100
+
+// qosConfig is the per-peer quality of service configuration.
+// It should be treated as immutable.
+//
+// The nil value is a valid configuration that leaves all packets alone.
+type qosConfig struct {
+	peers map[key.NodePublic]*peerQoS
+
+	// dstAddrToPeerKeyMapper is the routing table used to map a given dst
+	// IP to the peer key responsible for that IP. It only contains peers
+	// in peers.
+	dstAddrToPeerKeyMapper *table.RoutingTable
+
+	// noteDSCP, if non-nil, is called with a peer's key and the DSCP of
+	// the packets sent to it whenever that changes. All peers are in
+	// peers when it's set.
+	noteDSCP func(key.NodePublic, uint8)
+}
+
+func (c *qosConfig) String() string {
+	if c == nil {
+		return "<nil>"
+	}
+	keys := make([]key.NodePublic, 0, len(c.peers))
+	for k := range c.peers {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Less(keys[j]) })
+	var b strings.Builder
+	b.WriteString("qosConfig{")
+	var n int
+	for _, k := range keys {
+		q := c.peers[k]
+		if q.maxTxBitrate == 0 {
+			continue // only tracked for noteDSCP
+		}
+		if n > 0 {
+			b.WriteString(", ")
+		}
+		n++
+		fmt.Fprintf(&b, "%v: bitrate=%d", k.ShortString(), q.maxTxBitrate)
+	}
+	if c.noteDSCP != nil {
+		b.WriteString("; tracking DSCP")
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// qosConfigFromWGConfig returns the qosConfig for wcfg, or nil if no peer
+// has a quality of service configured and noteDSCP is nil. Rate limiters of
+// peers whose rate is unchanged from old are reused, so that
+// reconfiguration doesn't refill their buckets.
+func qosConfigFromWGConfig(wcfg *wgcfg.Config, old *qosConfig, noteDSCP func(key.NodePublic, uint8)) *qosConfig {
+	if wcfg == nil {
+		return nil
+	}
+	var (
+		rt    table.RoutingTableBuilder
+		peers map[key.NodePublic]*peerQoS
+	)
+	for i := range wcfg.Peers {
+		p := &wcfg.Peers[i]
+		if p.MaxTxBitrate <= 0 && noteDSCP == nil {
+			continue
+		}
+		q := new(peerQoS)
+		q.lastDSCP.Store(noDSCP)
+		if p.MaxTxBitrate > 0 {
+			q.maxTxBitrate = p.MaxTxBitrate
+			if o, ok := old.peer(p.PublicKey); ok && o.maxTxBitrate == q.maxTxBitrate {
+				q.limiter = o.limiter
+			} else {
+				bytesPerSec := p.MaxTxBitrate / 8
+				q.limiter = rate.NewLimiter(rate.Limit(bytesPerSec), int(max(bytesPerSec/10, minQoSBurst)))
+			}
+		}
+		rt.InsertOrReplace(p.PublicKey, p.AllowedIPs...)
+		mak.Set(&peers, p.PublicKey, q)
+	}
+	if len(peers) == 0 && noteDSCP == nil {
+		return nil
+	}
+	return &qosConfig{
+		peers:                  peers,
+		dstAddrToPeerKeyMapper: rt.Build(),
+		noteDSCP:               noteDSCP,
+	}
+}
+
+func (c *qosConfig) peer(k key.NodePublic) (*peerQoS, bool) {
+	if c == nil {
+		return nil, false
+	}
+	q, ok := c.peers[k]
+	return q, ok
+}
+
+// apply applies the quality of service of p's destination peer to p,
+// reporting whether p is within the peer's rate limit. If the DSCP p is
+// marked with differs from the previous packet to the peer, it's reported to
+// c.noteDSCP. Packets themselves are never re-marked: the peer's DSCP from
+// control is applied to the outer UDP packets by magicsock.
+func (c *qosConfig) apply(p *packet.Parsed) bool {
+	if c == nil {
+		return true
+	}
+	k, ok := c.dstAddrToPeerKeyMapper.Lookup(p.Dst.Addr())
+	if !ok {
+		return true
+	}
+	q := c.peers[k]
+	if q.limiter != nil && !q.limiter.AllowN(len(p.Buffer())) {
+		return false
+	}
+	if c.noteDSCP != nil {
+		if d := packetDSCP(p); q.lastDSCP.Swap(uint32(d)) != uint32(d) {
+			c.noteDSCP(k, d)
+		}
+	}
+	return true
+}
+
+// packetDSCP returns the Differentiated Services code point p is marked
+// with.
+func packetDSCP(p *packet.Parsed) uint8 {
+	b := p.Buffer()
+	switch p.IPVersion {
+	case 4:
+		return b[1] >> 2
+	case 6:
+		// The traffic class straddles the first two bytes.
+		return (b[0]&0x0f)<<2 | b[1]>>6
+	}
+	return 0
+}
@@ -108,6 +108,15 @@ type Wrapper struct {
 	// natConfig stores the current NAT configuration.
 	natConfig atomic.Pointer[natConfig]
 
+	// qosConfig stores the current per-peer quality of service
+	// configuration.
+	qosConfig atomic.Pointer[qosConfig]
+
+	// qosMu guards the inputs to qosConfig.
+	qosMu        sync.Mutex
+	lastWGConfig *wgcfg.Config               // from SetWGConfig
+	noteDSCP     func(key.NodePublic, uint8) // from SetDSCPNotifyFunc
+
 	// vectorBuffer stores the oldest unconsumed packet vector from tdev. It is
 	// allocated in wrap() and the underlying arrays should never grow.
 	vectorBuffer [][]byte
@@ -772,6 +781,35 @@ func (t *Wrapper) SetWGConfig(wcfg *wgcfg.Config) {
 	if !reflect.DeepEqual(old, cfg) {
 		t.logf("nat config: %v", cfg)
 	}
+
+	t.qosMu.Lock()
+	defer t.qosMu.Unlock()
+	t.lastWGConfig = wcfg
+	t.updateQoSConfigLocked()
+}
+
+// SetDSCPNotifyFunc sets the func called with a peer's key and the
+// Differentiated Services code point of the packets sent to it whenever
+// that changes, such as to mark the packets that carry them the same way.
+// A nil fn stops tracking the DSCP of sent packets.
+func (t *Wrapper) SetDSCPNotifyFunc(fn func(key.NodePublic, uint8)) {
+	t.qosMu.Lock()
+	defer t.qosMu.Unlock()
+	if fn == nil && t.noteDSCP == nil {
+		return
+	}
+	t.noteDSCP = fn
+	t.updateQoSConfigLocked()
+}
+
+// t.qosMu must be held.
+func (t *Wrapper) updateQoSConfigLocked() {
+	oldQoS := t.qosConfig.Load()
+	qos := qosConfigFromWGConfig(t.lastWGConfig, oldQoS, t.noteDSCP)
+	t.qosConfig.Store(qos)
+	if qos.String() != oldQoS.String() {
+		t.logf("qos config: %v", qos)
+	}
 }
 
 var (
@@ -883,6 +921,7 @@ func (t *Wrapper) Read(buffs [][]byte, sizes []int, offset int) (int, error) {
 	p := parsedPacketPool.Get().(*packet.Parsed)
 	defer parsedPacketPool.Put(p)
 	captHook := t.captureHook.Load()
+	qos := t.qosConfig.Load()
 	for _, data := range res.data {
 		p.Decode(data[res.dataOffset:])
 
@@ -902,6 +941,11 @@ func (t *Wrapper) Read(buffs [][]byte, sizes []int, offset int) (int, error) {
 				continue
 			}
 		}
+		if !qos.apply(p) {
+			metricPacketOutDrop.Add(1)
+			metricPacketOutDropQoS.Add(1)
+			continue
+		}
 		n := copy(buffs[buffsPos][offset:], p.Buffer())
 		if n != len(data)-res.dataOffset {
 			panic(fmt.Sprintf("short copy: %d != %d", n, len(data)-res.dataOffset))
@@ -944,6 +988,9 @@ func (t *Wrapper) injectedRead(res tunInjectedRead, buf []byte, offset int) (int
 	defer parsedPacketPool.Put(p)
 	p.Decode(buf[offset : offset+n])
 	t.snat(p)
+	if filt := t.filter.Load(); filt != nil {
+		filt.NoteInjectedOut(p)
+	}
 
 	if m := t.destIPActivity.Load(); m != nil {
 		if fn := m[p.Dst.Addr()]; fn != nil {
@@ -1264,6 +1311,7 @@ func (t *Wrapper) SetStatistics(stats *connstats.Statistics) {
 	metricPacketOutDrop          = clientmetric.NewCounter("tstun_out_to_wg_drop")
 	metricPacketOutDropFilter    = clientmetric.NewCounter("tstun_out_to_wg_drop_filter")
 	metricPacketOutDropSelfDisco = clientmetric.NewCounter("tstun_out_to_wg_drop_self_disco")
+	metricPacketOutDropQoS       = clientmetric.NewCounter("tstun_out_to_wg_drop_qos")
 )
 
 func (t *Wrapper) InstallCaptureHook(cb capture.Callback) {
@@ -11,6 +11,7 @@
 	"fmt"
 	"net/netip"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
@@ -822,6 +823,122 @@ func TestNATCfg(t *testing.T) {
 	test(ipproto.Version6)
 }
 
+func TestQoSConfig(t *testing.T) {
+	limited := wgcfg.Peer{
+		PublicKey:    key.NewNode().Public(),
+		AllowedIPs:   []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32")},
+		MaxTxBitrate: 8 * minQoSBurst, // one burst per second
+	}
+	plain := wgcfg.Peer{
+		PublicKey:  key.NewNode().Public(),
+		AllowedIPs: []netip.Prefix{netip.MustParsePrefix("100.64.0.4/32")},
+	}
+
+	if c := qosConfigFromWGConfig(&wgcfg.Config{Peers: []wgcfg.Peer{plain}}, nil, nil); c != nil {
+		t.Fatalf("config without QoS = %v; want nil", c)
+	}
+
+	cfg := &wgcfg.Config{Peers: []wgcfg.Peer{limited, plain}}
+	c := qosConfigFromWGConfig(cfg, nil, nil)
+	if len(c.peers) != 1 {
+		t.Fatalf("got %d peers; want 1", len(c.peers))
+	}
+
+	var p packet.Parsed
+	p.Decode(udp4("100.64.0.1", "100.64.0.4", 1, 2))
+	if !c.apply(&p) || p.Buffer()[1] != 0 {
+		t.Errorf("packet to peer without QoS dropped or modified")
+	}
+
+	// Exhaust the limited peer's burst; later packets are dropped.
+	pkt := udp4("100.64.0.1", "100.64.0.2", 1, 2)
+	allowed := 0
+	for i := 0; i < 2*minQoSBurst/len(pkt); i++ {
+		p.Decode(pkt)
+		if c.apply(&p) {
+			allowed++
+		}
+	}
+	if want := minQoSBurst / len(pkt); allowed < want || allowed > want+1 {
+		t.Errorf("allowed %d packets; want about %d", allowed, want)
+	}
+
+	// Reconfiguring with the same rate keeps the exhausted limiter.
+	c2 := qosConfigFromWGConfig(cfg, c, nil)
+	if c2.peers[limited.PublicKey].limiter != c.peers[limited.PublicKey].limiter {
+		t.Errorf("limiter not reused across reconfiguration")
+	}
+}
+
+func TestQoSConfigNoteDSCP(t *testing.T) {
+	limited := wgcfg.Peer{
+		PublicKey:    key.NewNode().Public(),
+		AllowedIPs:   []netip.Prefix{netip.MustParsePrefix("100.64.0.3/32")},
+		MaxTxBitrate: 8 * minQoSBurst,
+	}
+	plain := wgcfg.Peer{
+		PublicKey:  key.NewNode().Public(),
+		AllowedIPs: []netip.Prefix{netip.MustParsePrefix("100.64.0.4/32"), netip.MustParsePrefix("fd7a:115c:a1e0::4/128")},
+	}
+	type note struct {
+		k    key.NodePublic
+		dscp uint8
+	}
+	var got []note
+	c := qosConfigFromWGConfig(&wgcfg.Config{Peers: []wgcfg.Peer{limited, plain}}, nil, func(k key.NodePublic, dscp uint8) {
+		got = append(got, note{k, dscp})
+	})
+
+	send := func(pkt []byte, tos uint8) {
+		t.Helper()
+		var p packet.Parsed
+		p.Decode(pkt)
+		if tos != 0 {
+			setDSCP(pkt, tos)
+			p.Decode(pkt)
+		}
+		if !c.apply(&p) {
+			t.Fatalf("packet dropped")
+		}
+	}
+	send(udp4("100.64.0.1", "100.64.0.3", 1, 2), 0)
+	send(udp4("100.64.0.1", "100.64.0.3", 1, 2), 0)
+	send(udp4("100.64.0.1", "100.64.0.4", 1, 2), 0)
+	send(udp4("100.64.0.1", "100.64.0.4", 1, 2), 46)
+	udp6 := packet.Generate(&packet.UDP6Header{
+		IP6Header: packet.IP6Header{
+			IPProto: ipproto.UDP,
+			Src:     netip.MustParseAddr("fd7a:115c:a1e0::1"),
+			Dst:     netip.MustParseAddr("fd7a:115c:a1e0::4"),
+		},
+		SrcPort: 1,
+		DstPort: 2,
+	}, []byte("udp_payload"))
+	send(udp6, 46)
+	send(slices.Clone(udp6), 10)
+	want := []note{
+		{limited.PublicKey, 0},
+		{plain.PublicKey, 0},
+		{plain.PublicKey, 46},
+		{plain.PublicKey, 10},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("notes = %v; want %v", got, want)
+	}
+}
+
+// setDSCP sets the Differentiated Services code point of the IP packet pkt.
+// It doesn't update the IPv4 header checksum.
+func setDSCP(pkt []byte, dscp uint8) {
+	switch pkt[0] >> 4 {
+	case 4:
+		pkt[1] = dscp<<2 | pkt[1]&0x03
+	case 6:
+		pkt[0] = pkt[0]&0xf0 | dscp>>2
+		pkt[1] = pkt[1]&0x3f | (dscp&0x03)<<6
+	}
+}
+
 // TestCaptureHook verifies that the Wrapper.captureHook callback is called
 // with the correct parameters when various packet operations are performed.
 func TestCaptureHook(t *testing.T) {
@@ -6,9 +6,12 @@
 package paths
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strings"
 
 	"tailscale.com/syncs"
 	"tailscale.com/version/distro"
@@ -48,6 +51,46 @@ func DefaultTailscaledSocket() string {
 	return "tailscaled.sock"
 }
 
+// instanceNameRx matches valid instance names. They're used in the file
+// names of the instance's socket and state directory, so are kept short
+// and plain.
+var instanceNameRx = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,11}$`)
+
+// CheckInstanceName reports whether name is a valid name for a tailscaled
+// instance, run with tailscaled --instance=<name>.
+func CheckInstanceName(name string) error {
+	if !instanceNameRx.MatchString(name) {
+		return fmt.Errorf("invalid instance name %q; must be 1-12 lowercase letters, digits or dashes, starting with a letter or digit", name)
+	}
+	return nil
+}
+
+// InstanceTailscaledSocket returns the path to the Unix socket of the
+// named tailscaled instance, which is alongside DefaultTailscaledSocket,
+// or the empty string if there's no reasonable default.
+func InstanceTailscaledSocket(name string) string {
+	def := DefaultTailscaledSocket()
+	if def == "" {
+		return ""
+	}
+	ext := filepath.Ext(def)
+	if strings.ContainsAny(ext, `/\`) {
+		ext = ""
+	}
+	return strings.TrimSuffix(def, ext) + "-" + name + ext
+}
+
+// InstanceStateDir returns the state directory of the named tailscaled
+// instance, which is under the directory of DefaultTailscaledStateFile,
+// or the empty string if there's no reasonable default.
+func InstanceStateDir(name string) string {
+	def := DefaultTailscaledStateFile()
+	if def == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(def), "instances", name)
+}
+
 // Overridden in init by OS-specific files.
 var (
 	stateFileFunc func() string
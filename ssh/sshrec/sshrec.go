@@ -0,0 +1,348 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package sshrec stores Tailscale SSH session recordings on local disk,
+// in the asciinema cast format.
+//
+// Recordings are stored locally when debugging with TS_DEBUG_LOG_SSH, or,
+// with TS_SSH_RECORDING_FALLBACK set, when the SSH policy requires
+// sessions to be recorded but none of its recorder nodes is reachable.
+package sshrec
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/envknob"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/logger"
+)
+
+var (
+	// Fallback reports whether sessions whose recorders are all
+	// unreachable are recorded to local disk rather than failing as the
+	// SSH policy says.
+	Fallback = envknob.RegisterBool("TS_SSH_RECORDING_FALLBACK")
+
+	envDir       = envknob.RegisterString("TS_SSH_RECORDING_DIR")
+	envRetention = envknob.RegisterDuration("TS_SSH_RECORDING_RETENTION")
+	envMaxBytes  = envknob.RegisterInt("TS_SSH_RECORDING_MAX_BYTES")
+)
+
+const (
+	filePrefix = "ssh-session-"
+	fileSuffix = ".cast"
+	hashSuffix = ".sha256"
+
+	// pipeWriteTimeout is how long a write to a named pipe may wait for
+	// the reader before it fails, and with it the recording.
+	pipeWriteTimeout = 10 * time.Second
+)
+
+// errQuota is returned by writes to a recording that would take the
+// recordings over Store.MaxBytes.
+var errQuota = errors.New("recording quota exceeded")
+
+// Store is where recordings are kept: a directory, or a named pipe that
+// recordings are written to in turn.
+type Store struct {
+	// Path is the directory of the recordings, or a named pipe.
+	Path string
+
+	// Retention is how long finished recordings are kept. Zero means
+	// forever.
+	Retention time.Duration
+
+	// MaxBytes is the disk quota of the recordings. Once it's exceeded,
+	// the oldest finished recordings are deleted; if that's not enough,
+	// writes to recordings in progress fail. Zero means unlimited.
+	MaxBytes int64
+
+	Logf logger.Logf
+
+	now func() time.Time // or nil for time.Now
+}
+
+// FromEnv returns the Store configured by the environment. The
+// recordings are kept in TS_SSH_RECORDING_DIR, or in varRoot if it's
+// not set, for TS_SSH_RECORDING_RETENTION and up to
+// TS_SSH_RECORDING_MAX_BYTES.
+func FromEnv(varRoot string, logf logger.Logf) (*Store, error) {
+	path := envDir()
+	if path == "" {
+		if varRoot == "" {
+			return nil, errors.New("no var root for recording storage")
+		}
+		path = filepath.Join(varRoot, "ssh-sessions")
+	}
+	return &Store{
+		Path:      path,
+		Retention: envRetention(),
+		MaxBytes:  int64(envMaxBytes()),
+		Logf:      logf,
+	}, nil
+}
+
+func (s *Store) logf(format string, args ...any) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+func (s *Store) timeNow() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// isPipe reports whether s.Path is a named pipe.
+func (s *Store) isPipe() bool {
+	fi, err := os.Stat(s.Path)
+	return err == nil && fi.Mode()&fs.ModeNamedPipe != 0
+}
+
+// Create starts a new recording of a session started at start. The
+// recording is finished by closing the returned WriteCloser, which
+// records its hash. Writes fail once the recordings exceed s.MaxBytes.
+//
+// If s is a named pipe, the recording is written to it as is, without a
+// hash. The writer at the other end must then tell the recordings apart.
+// Create fails if nothing is reading the pipe, and writes fail if the
+// reader stops reading for pipeWriteTimeout.
+func (s *Store) Create(start time.Time) (io.WriteCloser, error) {
+	if s.isPipe() {
+		f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND|pipeOpenFlags, 0)
+		if err != nil {
+			return nil, err
+		}
+		return pipeFile{f}, nil
+	}
+	if err := os.MkdirAll(s.Path, 0700); err != nil {
+		return nil, err
+	}
+	if err := s.Prune(); err != nil {
+		s.logf("sshrec: pruning recordings: %v", err)
+	}
+	f, err := os.CreateTemp(s.Path, fmt.Sprintf("%s%v-*%s", filePrefix, start.UnixNano(), fileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	r := &recordingFile{s: s, f: f, h: sha256.New()}
+	r.budget = r.remaining()
+	return r, nil
+}
+
+// pipeFile is a recording being written to a named pipe.
+type pipeFile struct {
+	*os.File
+}
+
+func (p pipeFile) Write(b []byte) (int, error) {
+	// Pipes opened without blocking support deadlines, which keep a
+	// stalled reader from holding up the session.
+	p.SetWriteDeadline(time.Now().Add(pipeWriteTimeout))
+	return p.File.Write(b)
+}
+
+// recordingFile is a recording being written to a file, which records
+// its hash when closed.
+type recordingFile struct {
+	s *Store
+	f *os.File
+	h hash.Hash
+
+	// budget is how many more bytes can be written before the
+	// recordings might exceed s.MaxBytes, as of when it was last
+	// computed.
+	budget int64
+}
+
+// remaining returns how many more bytes the recordings can grow by
+// before exceeding r.s.MaxBytes, or -1 if there's no limit.
+func (r *recordingFile) remaining() int64 {
+	if r.s.MaxBytes <= 0 {
+		return -1
+	}
+	recs, err := r.s.List(false)
+	if err != nil {
+		r.s.logf("sshrec: listing recordings: %v", err)
+		return 0
+	}
+	left := r.s.MaxBytes
+	for _, rec := range recs {
+		left -= rec.Size
+	}
+	return max(left, 0)
+}
+
+func (r *recordingFile) Write(p []byte) (int, error) {
+	if r.budget >= 0 && int64(len(p)) > r.budget {
+		// Other recordings may have finished or been deleted since the
+		// budget was computed, so prune and recompute it before
+		// failing.
+		if err := r.s.prune(int64(len(p))); err != nil {
+			r.s.logf("sshrec: pruning recordings: %v", err)
+		}
+		if r.budget = r.remaining(); int64(len(p)) > r.budget {
+			return 0, errQuota
+		}
+	}
+	n, err := r.f.Write(p)
+	r.h.Write(p[:n])
+	if r.budget >= 0 {
+		r.budget -= int64(n)
+	}
+	return n, err
+}
+
+func (r *recordingFile) Close() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	return writeHash(r.f.Name(), r.h.Sum(nil))
+}
+
+// writeHash records sum as the hash of the recording at path, in the
+// format of sha256sum.
+func writeHash(path string, sum []byte) error {
+	line := fmt.Sprintf("%x  %s\n", sum, filepath.Base(path))
+	return atomicfile.WriteFile(path+hashSuffix, []byte(line), 0600)
+}
+
+// readHash returns the hex hash recorded for the recording at path, or
+// the empty string if there's none.
+func readHash(path string) string {
+	b, err := os.ReadFile(path + hashSuffix)
+	if err != nil {
+		return ""
+	}
+	sum, _, _ := strings.Cut(string(b), " ")
+	return sum
+}
+
+// List returns the recordings in s, oldest first. If verify, the
+// recordings are checked against their hashes.
+func (s *Store) List(verify bool) ([]ipnstate.SSHRecording, error) {
+	if s.isPipe() {
+		return nil, nil
+	}
+	des, err := os.ReadDir(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var recs []ipnstate.SSHRecording
+	for _, de := range des {
+		name := de.Name()
+		if !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			continue // deleted since ReadDir
+		}
+		path := filepath.Join(s.Path, name)
+		r := ipnstate.SSHRecording{
+			Name:    name,
+			Start:   startFromName(name, fi.ModTime()),
+			ModTime: fi.ModTime(),
+			Size:    fi.Size(),
+			SHA256:  readHash(path),
+		}
+		if verify && r.SHA256 != "" {
+			if err := verifyHash(path, r.SHA256); err != nil {
+				r.VerifyError = err.Error()
+			}
+		}
+		recs = append(recs, r)
+	}
+	slices.SortFunc(recs, func(a, b ipnstate.SSHRecording) int {
+		return a.Start.Compare(b.Start)
+	})
+	return recs, nil
+}
+
+// startFromName returns when the session of the recording named name
+// started, or def if the name doesn't say.
+func startFromName(name string, def time.Time) time.Time {
+	rest := strings.TrimPrefix(name, filePrefix)
+	nanos, _, _ := strings.Cut(rest, "-")
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return def
+	}
+	return time.Unix(0, n)
+}
+
+// verifyHash checks that the recording at path has the hex hash want.
+func verifyHash(path, want string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("hash mismatch: got %s, recorded %s", got, want)
+	}
+	return nil
+}
+
+// Prune deletes the finished recordings that are older than s.Retention,
+// then the oldest finished recordings until the recordings fit in
+// s.MaxBytes. Recordings in progress are kept.
+func (s *Store) Prune() error {
+	return s.prune(0)
+}
+
+// prune is Prune, but makes room for need more bytes within s.MaxBytes.
+func (s *Store) prune(need int64) error {
+	if s.Retention <= 0 && s.MaxBytes <= 0 {
+		return nil
+	}
+	recs, err := s.List(false)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, r := range recs {
+		total += r.Size
+	}
+	now := s.timeNow()
+	for _, r := range recs {
+		if r.SHA256 == "" {
+			continue // in progress
+		}
+		expired := s.Retention > 0 && now.Sub(r.ModTime) > s.Retention
+		overQuota := s.MaxBytes > 0 && total+need > s.MaxBytes
+		if !expired && !overQuota {
+			continue
+		}
+		path := filepath.Join(s.Path, r.Name)
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		os.Remove(path + hashSuffix)
+		total -= r.Size
+		s.logf("sshrec: deleted recording %s (expired=%v, over quota=%v)", r.Name, expired, overQuota)
+	}
+	return nil
+}
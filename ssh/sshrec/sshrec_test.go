@@ -0,0 +1,185 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sshrec
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeRecording(t *testing.T, s *Store, start time.Time, data string) string {
+	t.Helper()
+	w, err := s.Create(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Base(w.(*recordingFile).f.Name())
+}
+
+func TestCreateAndList(t *testing.T) {
+	s := &Store{Path: filepath.Join(t.TempDir(), "ssh-sessions"), Logf: t.Logf}
+	start := time.Unix(1700000000, 0)
+	const data = `{"version": 2}` + "\n"
+	name := writeRecording(t, s, start, data)
+
+	sum := sha256.Sum256([]byte(data))
+	b, err := os.ReadFile(filepath.Join(s.Path, name+hashSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := hex.EncodeToString(sum[:]) + "  " + name + "\n"; string(b) != want {
+		t.Errorf("hash file = %q; want %q", b, want)
+	}
+
+	// A recording in progress has no hash yet.
+	inProgress, err := s.Create(start.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inProgress.Close()
+
+	recs, err := s.List(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("got %d recordings; want 2", len(recs))
+	}
+	r := recs[0]
+	if r.Name != name || !r.Start.Equal(start) || r.Size != int64(len(data)) || r.SHA256 != hex.EncodeToString(sum[:]) || r.VerifyError != "" {
+		t.Errorf("finished recording = %+v", r)
+	}
+	if r := recs[1]; r.SHA256 != "" || !r.Start.Equal(start.Add(time.Minute)) {
+		t.Errorf("recording in progress = %+v", r)
+	}
+
+	// Tampering is caught by verification.
+	if err := os.WriteFile(filepath.Join(s.Path, name), []byte("tampered"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	recs, err = s.List(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(recs[0].VerifyError, "hash mismatch") {
+		t.Errorf("VerifyError = %q; want hash mismatch", recs[0].VerifyError)
+	}
+	recs, err = s.List(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recs[0].VerifyError != "" {
+		t.Errorf("VerifyError = %q without verify", recs[0].VerifyError)
+	}
+}
+
+func TestListMissingDir(t *testing.T) {
+	s := &Store{Path: filepath.Join(t.TempDir(), "missing")}
+	recs, err := s.List(true)
+	if err != nil || len(recs) != 0 {
+		t.Errorf("List = %v, %v; want none", recs, err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	s := &Store{Path: dir, Logf: t.Logf, now: func() time.Time { return now }}
+
+	old := writeRecording(t, s, now.Add(-3*time.Hour), strings.Repeat("a", 100))
+	mid := writeRecording(t, s, now.Add(-2*time.Hour), strings.Repeat("b", 100))
+	recent := writeRecording(t, s, now.Add(-time.Hour), strings.Repeat("c", 100))
+	inProgress, err := s.Create(now.Add(-4 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inProgress.Close()
+	if _, err := io.WriteString(inProgress, strings.Repeat("d", 100)); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(filepath.Join(dir, old), now, now.Add(-3*time.Hour))
+
+	names := func() []string {
+		recs, err := s.List(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ret []string
+		for _, r := range recs {
+			if r.SHA256 != "" {
+				ret = append(ret, r.Name)
+			}
+		}
+		return ret
+	}
+
+	s.Retention = 150 * time.Minute
+	if err := s.Prune(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(names(), ","), mid+","+recent; got != want {
+		t.Errorf("after retention, got %v; want %v", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, old+hashSuffix)); !os.IsNotExist(err) {
+		t.Errorf("hash of pruned recording still exists: %v", err)
+	}
+
+	// The recording in progress counts toward the quota, but is kept.
+	s.Retention = 0
+	s.MaxBytes = 250
+	if err := s.Prune(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(names(), ","), recent; got != want {
+		t.Errorf("after quota, got %v; want %v", got, want)
+	}
+	recs, err := s.List(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 {
+		t.Errorf("got %d recordings; want the recent one and the one in progress", len(recs))
+	}
+}
+
+func TestQuotaDuringRecording(t *testing.T) {
+	s := &Store{Path: filepath.Join(t.TempDir(), "ssh-sessions"), MaxBytes: 100, Logf: t.Logf}
+	start := time.Unix(1700000000, 0)
+	old := writeRecording(t, s, start, strings.Repeat("o", 60))
+
+	w, err := s.Create(start.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err := io.WriteString(w, strings.Repeat("a", 30)); err != nil {
+		t.Fatal(err)
+	}
+	// Pruning the finished recording makes room.
+	if _, err := io.WriteString(w, strings.Repeat("b", 60)); err != nil {
+		t.Fatalf("write after pruning: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.Path, old)); !os.IsNotExist(err) {
+		t.Errorf("finished recording not pruned: %v", err)
+	}
+	// But the recording in progress can't exceed the quota itself.
+	if _, err := io.WriteString(w, strings.Repeat("c", 20)); err != errQuota {
+		t.Errorf("write over quota = %v; want %v", err, errQuota)
+	}
+	if _, err := io.WriteString(w, strings.Repeat("d", 10)); err != nil {
+		t.Errorf("write within quota: %v", err)
+	}
+}
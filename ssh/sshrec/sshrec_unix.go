@@ -0,0 +1,12 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build unix
+
+package sshrec
+
+import "syscall"
+
+// pipeOpenFlags are the extra flags to open a named pipe with, so that
+// opening it fails rather than blocks if nothing is reading it.
+const pipeOpenFlags = syscall.O_NONBLOCK
@@ -0,0 +1,55 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build unix
+
+package sshrec
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCreatePipe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recordings")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+	s := &Store{Path: path, Logf: t.Logf}
+	start := time.Unix(1700000000, 0)
+
+	// With nothing reading the pipe, Create fails rather than blocks.
+	if w, err := s.Create(start); err == nil {
+		w.Close()
+		t.Fatal("Create succeeded with no reader")
+	} else if !errors.Is(err, syscall.ENXIO) {
+		t.Errorf("Create with no reader = %v; want ENXIO", err)
+	}
+
+	r, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	w, err := s.Create(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	const data = `{"version": 2}` + "\n"
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(data))
+	r.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := r.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != data {
+		t.Errorf("read %q; want %q", buf, data)
+	}
+}
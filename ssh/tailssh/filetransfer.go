@@ -0,0 +1,337 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || (darwin && !ios) || freebsd || openbsd
+
+package tailssh
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// File transfer sessions (SFTP, and scp commands) are recorded as the
+// metadata of the transferred files, as asciinema marker events, rather
+// than as their contents.
+
+// fileTransfer reports whether ss is a file transfer, and if so its kind,
+// "sftp" or "scp", and the direction of the stream with the metadata of
+// the transferred files, "i" or "o".
+func (ss *sshSession) fileTransfer() (kind, dir string) {
+	if ss.subsystem() == "sftp" {
+		return "sftp", "i" // the client's requests
+	}
+	if _, sc, ok := parseSCPCommand(ss.rawCommand()); ok {
+		if sc.sink {
+			return "scp", "i"
+		}
+		return "scp", "o"
+	}
+	return "", ""
+}
+
+// transferLogWriter is an io.Writer wrapper that passes writes to the
+// parser of a file transfer, and then writes to w.
+type transferLogWriter struct {
+	pw *io.PipeWriter
+	w  io.Writer
+}
+
+func (t transferLogWriter) Write(p []byte) (int, error) {
+	// The parser reads until the pipe is closed, so errors only mean
+	// the recording is done.
+	t.pw.Write(p)
+	return t.w.Write(p)
+}
+
+// transferWriter returns an io.Writer around w that records the metadata
+// of the files transferred through it.
+func (r *recording) transferWriter(w io.Writer) io.Writer {
+	pr, pw := io.Pipe()
+	r.mu.Lock()
+	if r.out == nil {
+		r.mu.Unlock()
+		return w // closed
+	}
+	r.transferPipes = append(r.transferPipes, pw)
+	r.mu.Unlock()
+
+	mark := func(label string) error {
+		err := r.marker(label)
+		if err != nil && !r.failOpen {
+			r.ss.cancelCtx(err)
+		}
+		return err
+	}
+	r.transferLoggers.Add(1)
+	go func() {
+		defer r.transferLoggers.Done()
+		var err error
+		switch r.transfer {
+		case "sftp":
+			err = logSFTPRequests(pr, mark)
+		case "scp":
+			err = logSCPTransfers(pr, r.transferDir == "i", mark)
+		}
+		if err != nil && err != io.EOF {
+			r.ss.logf("recording: %s metadata: %v", r.transfer, err)
+		}
+		io.Copy(io.Discard, pr)
+	}()
+	return transferLogWriter{pw: pw, w: w}
+}
+
+// SFTP packet types, from draft-ietf-secsh-filexfer-02, the version used
+// by OpenSSH and github.com/pkg/sftp.
+const (
+	sftpOpen     = 3
+	sftpSetstat  = 9
+	sftpRemove   = 13
+	sftpMkdir    = 14
+	sftpRmdir    = 15
+	sftpRename   = 18
+	sftpSymlink  = 20
+	sftpExtended = 200
+)
+
+// SFTP open flags.
+const (
+	sftpFlagRead   = 0x01
+	sftpFlagWrite  = 0x02
+	sftpFlagAppend = 0x04
+	sftpFlagCreate = 0x08
+	sftpFlagTrunc  = 0x10
+	sftpFlagExcl   = 0x20
+)
+
+// maxSFTPLogPacket is the size of the largest request that
+// logSFTPRequests parses. Larger ones, such as writes, carry file
+// contents, and are skipped.
+const maxSFTPLogPacket = 64 << 10
+
+// logSFTPRequests reads the SFTP requests a client sends in r, calling
+// mark with a description of each one that changes or opens a file.
+func logSFTPRequests(r io.Reader, mark func(string) error) error {
+	br := bufio.NewReader(r)
+	var hdr [5]byte // length, type
+	for {
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return err
+		}
+		n := binary.BigEndian.Uint32(hdr[:4])
+		if n == 0 {
+			return errors.New("empty packet")
+		}
+		n-- // the type
+		typ := hdr[4]
+		switch typ {
+		case sftpOpen, sftpSetstat, sftpRemove, sftpMkdir, sftpRmdir, sftpRename, sftpSymlink, sftpExtended:
+		default:
+			if _, err := br.Discard(int(n)); err != nil {
+				return err
+			}
+			continue
+		}
+		if n > maxSFTPLogPacket {
+			return fmt.Errorf("packet of type %d too large: %d bytes", typ, n)
+		}
+		pkt := make([]byte, n)
+		if _, err := io.ReadFull(br, pkt); err != nil {
+			return err
+		}
+		label, err := sftpRequestLabel(typ, pkt)
+		if err != nil {
+			return err
+		}
+		if label == "" {
+			continue
+		}
+		if err := mark(label); err != nil {
+			return err
+		}
+	}
+}
+
+var errShortSFTPPacket = errors.New("short packet")
+
+// sftpPacket is the body of an SFTP packet being parsed.
+type sftpPacket []byte
+
+func (p *sftpPacket) uint32() (uint32, error) {
+	if len(*p) < 4 {
+		return 0, errShortSFTPPacket
+	}
+	v := binary.BigEndian.Uint32(*p)
+	*p = (*p)[4:]
+	return v, nil
+}
+
+func (p *sftpPacket) string() (string, error) {
+	n, err := p.uint32()
+	if err != nil {
+		return "", err
+	}
+	if uint32(len(*p)) < n {
+		return "", errShortSFTPPacket
+	}
+	s := string((*p)[:n])
+	*p = (*p)[n:]
+	return s, nil
+}
+
+// strings reads n strings from p.
+func (p *sftpPacket) strings(n int) ([]string, error) {
+	ss := make([]string, n)
+	for i := range ss {
+		var err error
+		if ss[i], err = p.string(); err != nil {
+			return nil, err
+		}
+	}
+	return ss, nil
+}
+
+// sftpRequestLabel returns the description of the request of type typ
+// in pkt, or the empty string if it's not worth recording.
+func sftpRequestLabel(typ byte, pkt []byte) (string, error) {
+	p := sftpPacket(pkt)
+	if _, err := p.uint32(); err != nil { // request ID
+		return "", err
+	}
+	var (
+		op    string
+		nargs = 1
+	)
+	switch typ {
+	case sftpOpen:
+		name, err := p.string()
+		if err != nil {
+			return "", err
+		}
+		flags, err := p.uint32()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("sftp open %q (%s)", name, sftpOpenFlagsString(flags)), nil
+	case sftpSetstat:
+		op = "setstat"
+	case sftpRemove:
+		op = "remove"
+	case sftpMkdir:
+		op = "mkdir"
+	case sftpRmdir:
+		op = "rmdir"
+	case sftpRename:
+		op, nargs = "rename", 2
+	case sftpSymlink:
+		op, nargs = "symlink", 2
+	case sftpExtended:
+		ext, err := p.string()
+		if err != nil {
+			return "", err
+		}
+		switch ext {
+		case "posix-rename@openssh.com":
+			op, nargs = "rename", 2
+		case "hardlink@openssh.com":
+			op, nargs = "hardlink", 2
+		default:
+			return "", nil
+		}
+	default:
+		return "", nil
+	}
+	args, err := p.strings(nargs)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	sb.WriteString("sftp " + op)
+	for _, a := range args {
+		fmt.Fprintf(&sb, " %q", a)
+	}
+	return sb.String(), nil
+}
+
+func sftpOpenFlagsString(flags uint32) string {
+	var s []string
+	for _, f := range []struct {
+		flag uint32
+		name string
+	}{
+		{sftpFlagRead, "read"},
+		{sftpFlagWrite, "write"},
+		{sftpFlagAppend, "append"},
+		{sftpFlagCreate, "create"},
+		{sftpFlagTrunc, "truncate"},
+		{sftpFlagExcl, "excl"},
+	} {
+		if flags&f.flag != 0 {
+			s = append(s, f.name)
+		}
+	}
+	return strings.Join(s, ",")
+}
+
+// logSCPTransfers reads the scp protocol stream in r, as sent by the side
+// with the files, calling mark with a description of each file and
+// directory. The upload parameter reports whether the client is sending
+// the files.
+func logSCPTransfers(r io.Reader, upload bool, mark func(string) error) error {
+	verb := "download"
+	if upload {
+		verb = "upload"
+	}
+	br := bufio.NewReader(r)
+	var dirs []string
+	for {
+		typ, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if typ == 0 {
+			continue // end of the contents of a file
+		}
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch typ {
+		case 1, 2, 'T':
+			// Errors and times.
+		case 'E':
+			if len(dirs) > 0 {
+				dirs = dirs[:len(dirs)-1]
+			}
+		case 'D':
+			h, err := parseSCPHeader(line)
+			if err != nil {
+				return err
+			}
+			dirs = append(dirs, h.name)
+			if err := mark(fmt.Sprintf("scp %s directory %q (mode %04o)", verb, path.Join(dirs...), h.mode)); err != nil {
+				return err
+			}
+		case 'C':
+			h, err := parseSCPHeader(line)
+			if err != nil {
+				return err
+			}
+			name := path.Join(path.Join(dirs...), h.name)
+			if err := mark(fmt.Sprintf("scp %s %q (%d bytes, mode %04o)", verb, name, h.size, h.mode)); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(io.Discard, br, h.size); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected message %q", typ)
+		}
+	}
+}
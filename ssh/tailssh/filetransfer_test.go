@@ -0,0 +1,95 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || (darwin && !ios) || freebsd || openbsd
+
+package tailssh
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+type pipeRWC struct {
+	io.Reader
+	io.WriteCloser
+}
+
+func TestLogSFTPRequests(t *testing.T) {
+	dir := t.TempDir()
+
+	// The client's requests go to the server and to logSFTPRequests.
+	srvR, cliW := io.Pipe()
+	cliR, srvW := io.Pipe()
+	logR, logW := io.Pipe()
+	server, err := sftp.NewServer(pipeRWC{io.TeeReader(srvR, logW), srvW})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		server.Serve()
+		srvW.Close()
+	}()
+
+	var marks []string
+	logDone := make(chan error, 1)
+	go func() {
+		logDone <- logSFTPRequests(logR, func(s string) error {
+			marks = append(marks, s)
+			return nil
+		})
+		io.Copy(io.Discard, logR)
+	}()
+
+	client, err := sftp.NewClientPipe(cliR, cliW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(dir, "sub")
+	if err := client.Mkdir(sub); err != nil {
+		t.Fatal(err)
+	}
+	f, err := client.Create(filepath.Join(sub, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Large writes carry file contents, and are skipped.
+	if _, err := f.Write([]byte(strings.Repeat("x", 2*maxSFTPLogPacket))); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := client.PosixRename(filepath.Join(sub, "a.txt"), filepath.Join(sub, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Remove(filepath.Join(sub, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	logW.Close()
+	if err := <-logDone; err != io.EOF {
+		t.Errorf("logSFTPRequests = %v; want EOF", err)
+	}
+
+	want := []string{
+		fmt.Sprintf("sftp mkdir %q", sub),
+		fmt.Sprintf("sftp open %q (read,write,create,truncate)", filepath.Join(sub, "a.txt")),
+		fmt.Sprintf("sftp rename %q %q", filepath.Join(sub, "a.txt"), filepath.Join(sub, "b.txt")),
+		fmt.Sprintf("sftp remove %q", filepath.Join(sub, "b.txt")),
+	}
+	if !reflect.DeepEqual(marks, want) {
+		t.Errorf("marks:\n%s\nwant:\n%s", strings.Join(marks, "\n"), strings.Join(want, "\n"))
+	}
+	if _, err := os.Stat(sub); err != nil {
+		t.Error(err)
+	}
+}
@@ -73,25 +73,31 @@ func (ss *sshSession) newIncubatorCommand() (cmd *exec.Cmd) {
 		name    string
 		args    []string
 		isSFTP  bool
+		isSCP   bool
 		isShell bool
 	)
-	switch ss.Subsystem() {
+	switch ss.subsystem() {
 	case "sftp":
 		isSFTP = true
 	case "":
+		if scpArgs, ok := ss.builtinSCPArgs(); ok {
+			isSCP = true
+			args = scpArgs
+			break
+		}
 		name = ss.conn.localUser.LoginShell()
-		if rawCmd := ss.RawCommand(); rawCmd != "" {
+		if rawCmd := ss.rawCommand(); rawCmd != "" {
 			args = append(args, "-c", rawCmd)
 		} else {
 			isShell = true
 			args = append(args, "-l") // login shell
 		}
 	default:
-		panic(fmt.Sprintf("unexpected subsystem: %v", ss.Subsystem()))
+		panic(fmt.Sprintf("unexpected subsystem: %v", ss.subsystem()))
 	}
 
 	if ss.conn.srv.tailscaledPath == "" {
-		// TODO(maisem): this doesn't work with sftp
+		// TODO(maisem): this doesn't work with sftp, or the built-in scp
 		return exec.CommandContext(ss.ctx, name, args...)
 	}
 	lu := ss.conn.localUser
@@ -117,6 +123,9 @@ func (ss *sshSession) newIncubatorCommand() (cmd *exec.Cmd) {
 
 	if isSFTP {
 		incubatorArgs = append(incubatorArgs, "--sftp")
+	} else if isSCP {
+		incubatorArgs = append(incubatorArgs, "--scp", "--")
+		incubatorArgs = append(incubatorArgs, args...)
 	} else {
 		if isShell {
 			incubatorArgs = append(incubatorArgs, "--shell")
@@ -132,6 +141,11 @@ func (ss *sshSession) newIncubatorCommand() (cmd *exec.Cmd) {
 			// See http://github.com/tailscale/tailscale/issues/4908.
 			shouldUseLoginCmd = false
 		}
+		if len(ss.conn.finalAction.SetEnv) > 0 && runtime.GOOS != "darwin" {
+			// The login command discards the environment, including the
+			// variables set by the policy, except on macOS with -p.
+			shouldUseLoginCmd = false
+		}
 		if shouldUseLoginCmd {
 			if lp, err := exec.LookPath("login"); err == nil {
 				incubatorArgs = append(incubatorArgs, "--login-cmd="+lp)
@@ -146,6 +160,20 @@ func (ss *sshSession) newIncubatorCommand() (cmd *exec.Cmd) {
 	return exec.CommandContext(ss.ctx, ss.conn.srv.tailscaledPath, incubatorArgs...)
 }
 
+// builtinSCPArgs reports whether ss runs a legacy scp transfer that the
+// incubator should serve itself, as there's no scp on the system, and
+// if so the arguments of the scp command.
+func (ss *sshSession) builtinSCPArgs() (args []string, ok bool) {
+	args, _, ok = parseSCPCommand(ss.rawCommand())
+	if !ok {
+		return nil, false
+	}
+	if _, err := exec.LookPath("scp"); err == nil {
+		return nil, false
+	}
+	return args, true
+}
+
 const debugIncubator = false
 
 type stdRWC struct{}
@@ -174,6 +202,7 @@ type incubatorArgs struct {
 	hasTTY       bool
 	cmdName      string
 	isSFTP       bool
+	isSCP        bool
 	isShell      bool
 	loginCmdPath string
 	cmdArgs      []string
@@ -192,6 +221,7 @@ func parseIncubatorArgs(args []string) (a incubatorArgs) {
 	flags.StringVar(&a.cmdName, "cmd", "", "the cmd to launch (ignored in sftp mode)")
 	flags.BoolVar(&a.isShell, "shell", false, "is launching a shell (with no cmds)")
 	flags.BoolVar(&a.isSFTP, "sftp", false, "run sftp server (cmd is ignored)")
+	flags.BoolVar(&a.isSCP, "scp", false, "run the built-in scp with the args (cmd is ignored)")
 	flags.StringVar(&a.loginCmdPath, "login-cmd", "", "the path to `login` cmd")
 	flags.Parse(args)
 	a.cmdArgs = flags.Args()
@@ -222,6 +252,9 @@ func beIncubator(args []string) error {
 	if ia.isSFTP && ia.isShell {
 		return fmt.Errorf("--sftp and --shell are mutually exclusive")
 	}
+	if ia.isSCP && (ia.isSFTP || ia.isShell) {
+		return fmt.Errorf("--scp is mutually exclusive with --sftp and --shell")
+	}
 
 	logf := logger.Discard
 	if debugIncubator {
@@ -278,6 +311,22 @@ func beIncubator(args []string) error {
 		return nil
 	}
 
+	if ia.isSCP {
+		logf("handling scp")
+
+		sc, err := parseSCPArgs(ia.cmdArgs)
+		if err != nil {
+			return err
+		}
+		if err := sc.serve(os.Stdin, os.Stdout); err != nil {
+			// The errors have been reported to the client, which
+			// only needs the exit status.
+			logf("scp: %v", err)
+			os.Exit(1)
+		}
+		return nil
+	}
+
 	cmd := exec.Command(ia.cmdName, ia.cmdArgs...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
@@ -460,6 +509,15 @@ func (ss *sshSession) launchProcess() error {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("SSH_AUTH_SOCK=%s", ss.agentListener.Addr()))
 	}
 
+	fa := ss.conn.finalAction
+	if fa.ForceCommand != "" && ss.RawCommand() != "" {
+		cmd.Env = append(cmd.Env, "SSH_ORIGINAL_COMMAND="+ss.RawCommand())
+	}
+	// Later values win, so these override those above.
+	for k, v := range fa.SetEnv {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
 	ptyReq, winCh, isPty := ss.Pty()
 	if !isPty {
 		ss.logf("starting non-pty command: %+v", cmd.Args)
@@ -0,0 +1,524 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || (darwin && !ios) || freebsd || openbsd
+
+package tailssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file implements the server side of the legacy scp protocol (the
+// "scp -t" and "scp -f" commands run by scp clients), for hosts without
+// an scp binary of their own. Modern scp clients use SFTP instead, which
+// is served by the incubator's --sftp mode.
+
+// scpCommand is a parsed remote scp command.
+type scpCommand struct {
+	sink      bool     // -t: receive files from the client; else -f: send them
+	recursive bool     // -r
+	preserve  bool     // -p: preserve modes and times
+	targetDir bool     // -d: the sink target must be a directory
+	paths     []string // the target (-t) or the sources (-f)
+}
+
+// parseSCPCommand parses a command line run by an scp client, such as
+// "scp -r -t -- /tmp", reporting whether it's one. Command lines using
+// shell features that parseSCPCommand doesn't handle, such as variables
+// or globs, aren't reported as scp commands, to be run by the shell.
+func parseSCPCommand(cmdline string) (args []string, sc *scpCommand, ok bool) {
+	words, ok := splitSCPCommandLine(cmdline)
+	if !ok || len(words) == 0 || words[0] != "scp" {
+		return nil, nil, false
+	}
+	sc, err := parseSCPArgs(words[1:])
+	if err != nil {
+		return nil, nil, false
+	}
+	return words[1:], sc, true
+}
+
+// parseSCPArgs parses the arguments of a remote scp command.
+func parseSCPArgs(args []string) (*scpCommand, error) {
+	sc := new(scpCommand)
+	var to, from bool
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		a := args[0]
+		args = args[1:]
+		if a == "--" {
+			break
+		}
+		for _, f := range a[1:] {
+			switch f {
+			case 't':
+				to = true
+			case 'f':
+				from = true
+			case 'r':
+				sc.recursive = true
+			case 'p':
+				sc.preserve = true
+			case 'd':
+				sc.targetDir = true
+			case 'v', 'q':
+			default:
+				return nil, fmt.Errorf("unsupported scp flag -%c", f)
+			}
+		}
+	}
+	switch {
+	case to == from:
+		return nil, errors.New("want exactly one of -t and -f")
+	case to && len(args) != 1:
+		return nil, errors.New("want one target with -t")
+	case from && len(args) == 0:
+		return nil, errors.New("want sources with -f")
+	}
+	sc.sink = to
+	sc.paths = args
+	return sc, nil
+}
+
+// splitSCPCommandLine splits cmdline into words as a POSIX shell would,
+// reporting false if it uses shell features other than quoting. A
+// leading "~" is replaced by ".", as the command is run in the home
+// directory.
+func splitSCPCommandLine(cmdline string) (words []string, ok bool) {
+	var (
+		cur    strings.Builder
+		inWord bool
+	)
+	for i := 0; i < len(cmdline); i++ {
+		c := cmdline[i]
+		switch {
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+			continue
+		case c == '\'':
+			j := strings.IndexByte(cmdline[i+1:], '\'')
+			if j < 0 {
+				return nil, false
+			}
+			cur.WriteString(cmdline[i+1 : i+1+j])
+			i += j + 1
+		case c == '"':
+			for i++; ; i++ {
+				if i == len(cmdline) {
+					return nil, false
+				}
+				c := cmdline[i]
+				if c == '"' {
+					break
+				}
+				if c == '$' || c == '`' {
+					return nil, false
+				}
+				if c == '\\' && i+1 < len(cmdline) && strings.IndexByte("\"\\$`", cmdline[i+1]) >= 0 {
+					i++
+					c = cmdline[i]
+				}
+				cur.WriteByte(c)
+			}
+		case c == '\\':
+			if i+1 == len(cmdline) {
+				return nil, false
+			}
+			i++
+			cur.WriteByte(cmdline[i])
+		case c == '~' && !inWord:
+			if rest := cmdline[i+1:]; rest != "" && rest[0] != '/' && rest[0] != ' ' {
+				return nil, false // ~user
+			}
+			cur.WriteByte('.')
+		case strings.IndexByte("$`*?[]{}()<>|&;#!\n", c) >= 0:
+			return nil, false
+		default:
+			cur.WriteByte(c)
+		}
+		inWord = true
+	}
+	if inWord {
+		words = append(words, cur.String())
+	}
+	return words, true
+}
+
+// errSCPFailed is returned by serve when some of the files weren't
+// transferred. The errors have been reported to the client.
+var errSCPFailed = errors.New("scp: some files weren't transferred")
+
+// serve runs the scp protocol over r and w, as received from and sent to
+// the client.
+func (sc *scpCommand) serve(r io.Reader, w io.Writer) error {
+	s := &scpSession{cmd: sc, br: bufio.NewReader(r), w: w}
+	var err error
+	if sc.sink {
+		err = s.sink()
+	} else {
+		err = s.source()
+	}
+	if err == nil && s.failed {
+		err = errSCPFailed
+	}
+	return err
+}
+
+type scpSession struct {
+	cmd    *scpCommand
+	br     *bufio.Reader
+	w      io.Writer
+	failed bool // whether an error was reported to the client
+}
+
+func (s *scpSession) ack() error {
+	_, err := s.w.Write([]byte{0})
+	return err
+}
+
+// warn reports an error with a file to the client, which carries on
+// with the others.
+func (s *scpSession) warn(format string, args ...any) error {
+	s.failed = true
+	_, err := fmt.Fprintf(s.w, "\x01scp: "+format+"\n", args...)
+	return err
+}
+
+// fatal reports an error to the client that ends the transfer, and
+// returns it.
+func (s *scpSession) fatal(format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Fprintf(s.w, "\x02scp: %s\n", msg)
+	return errors.New(msg)
+}
+
+// errSCPSkip is returned by readAck when the client rejects a file but
+// carries on with the transfer.
+var errSCPSkip = errors.New("scp: file rejected by client")
+
+// readAck reads the client's response to a message.
+func (s *scpSession) readAck() error {
+	b, err := s.br.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch b {
+	case 0:
+		return nil
+	case 1, 2:
+		msg, _ := s.br.ReadString('\n')
+		if b == 1 {
+			s.failed = true
+			return errSCPSkip
+		}
+		return fmt.Errorf("scp: client error: %s", strings.TrimSpace(msg))
+	}
+	return fmt.Errorf("scp: unexpected response %q", b)
+}
+
+// scpHeader is a file or directory header: "C0644 12 name" or
+// "D0755 0 name".
+type scpHeader struct {
+	mode os.FileMode
+	size int64
+	name string
+}
+
+// parseSCPHeader parses the rest of a C or D line, after its type.
+func parseSCPHeader(line string) (h scpHeader, err error) {
+	modeStr, rest, _ := strings.Cut(line, " ")
+	sizeStr, name, _ := strings.Cut(rest, " ")
+	mode, err := strconv.ParseUint(modeStr, 8, 32)
+	if err != nil {
+		return h, fmt.Errorf("bad mode %q", modeStr)
+	}
+	h.mode = os.FileMode(mode) & os.ModePerm
+	h.size, err = strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil || h.size < 0 {
+		return h, fmt.Errorf("bad size %q", sizeStr)
+	}
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return h, fmt.Errorf("unexpected filename %q", name)
+	}
+	h.name = name
+	return h, nil
+}
+
+// parseSCPTimes parses the rest of a T line, "mtime 0 atime 0", after
+// its type.
+func parseSCPTimes(line string) (mtime, atime time.Time, err error) {
+	f := strings.Fields(line)
+	if len(f) != 4 {
+		return mtime, atime, fmt.Errorf("bad times %q", line)
+	}
+	m, err1 := strconv.ParseInt(f[0], 10, 64)
+	a, err2 := strconv.ParseInt(f[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		return mtime, atime, fmt.Errorf("bad times %q", line)
+	}
+	return time.Unix(m, 0), time.Unix(a, 0), nil
+}
+
+// sink receives files from the client into s.cmd.paths[0].
+func (s *scpSession) sink() error {
+	target := s.cmd.paths[0]
+	fi, err := os.Stat(target)
+	targetIsDir := err == nil && fi.IsDir()
+	if s.cmd.targetDir && !targetIsDir {
+		return s.fatal("%s: not a directory", target)
+	}
+	if err := s.ack(); err != nil {
+		return err
+	}
+
+	type dir struct {
+		path         string
+		mtime, atime time.Time
+	}
+	var (
+		dirs         []dir // directories being received
+		mtime, atime time.Time
+	)
+	// dest returns where to write a file or directory named name.
+	dest := func(name string) string {
+		if len(dirs) > 0 {
+			return filepath.Join(dirs[len(dirs)-1].path, name)
+		}
+		if targetIsDir {
+			return filepath.Join(target, name)
+		}
+		return target
+	}
+	for {
+		line, err := s.br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return s.fatal("protocol error: empty message")
+		}
+		switch line[0] {
+		case 1:
+			s.failed = true
+			continue
+		case 2:
+			return fmt.Errorf("scp: client error: %s", line[1:])
+		case 'T':
+			if mtime, atime, err = parseSCPTimes(line[1:]); err != nil {
+				return s.fatal("protocol error: %v", err)
+			}
+		case 'E':
+			if len(dirs) == 0 {
+				return s.fatal("protocol error: unexpected E")
+			}
+			d := dirs[len(dirs)-1]
+			dirs = dirs[:len(dirs)-1]
+			if s.cmd.preserve && !d.mtime.IsZero() {
+				os.Chtimes(d.path, d.atime, d.mtime)
+			}
+		case 'D':
+			if !s.cmd.recursive {
+				return s.fatal("received directory without -r")
+			}
+			h, err := parseSCPHeader(line[1:])
+			if err != nil {
+				return s.fatal("protocol error: %v", err)
+			}
+			p := dest(h.name)
+			if err := os.Mkdir(p, h.mode|0700); err != nil && !os.IsExist(err) {
+				return s.fatal("%s: %v", p, err)
+			}
+			if s.cmd.preserve {
+				os.Chmod(p, h.mode)
+			}
+			dirs = append(dirs, dir{p, mtime, atime})
+		case 'C':
+			h, err := parseSCPHeader(line[1:])
+			if err != nil {
+				return s.fatal("protocol error: %v", err)
+			}
+			if err := s.receiveFile(dest(h.name), h, mtime, atime); err != nil {
+				return err
+			}
+			mtime, atime = time.Time{}, time.Time{}
+			continue // receiveFile acknowledged the file
+		default:
+			return s.fatal("protocol error: unexpected message %q", line)
+		}
+		if line[0] != 'T' {
+			mtime, atime = time.Time{}, time.Time{}
+		}
+		if err := s.ack(); err != nil {
+			return err
+		}
+	}
+}
+
+// receiveFile receives the contents of the file described by h into p.
+// Errors writing p are reported to the client, which carries on.
+func (s *scpSession) receiveFile(p string, h scpHeader, mtime, atime time.Time) error {
+	if err := s.ack(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, h.mode)
+	fw := &scpFileWriter{f: f, err: err}
+	// The contents are read even if they can't be written, to stay in
+	// sync with the client.
+	if _, err := io.CopyN(fw, s.br, h.size); err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return err
+	}
+	if f != nil {
+		if s.cmd.preserve {
+			f.Chmod(h.mode)
+		}
+		if err := f.Close(); err != nil && fw.err == nil {
+			fw.err = err
+		}
+		if s.cmd.preserve && !mtime.IsZero() && fw.err == nil {
+			os.Chtimes(p, atime, mtime)
+		}
+	}
+	if err := skipErr(s.readAck()); err != nil {
+		return err
+	}
+	if fw.err != nil {
+		return s.warn("%s: %v", p, unwrapPathError(fw.err))
+	}
+	return s.ack()
+}
+
+// scpFileWriter writes to f until it fails, after which it discards
+// writes.
+type scpFileWriter struct {
+	f   *os.File // or nil if it couldn't be opened
+	err error    // first error opening or writing f
+}
+
+func (w *scpFileWriter) Write(p []byte) (int, error) {
+	if w.err == nil {
+		_, w.err = w.f.Write(p)
+	}
+	return len(p), nil
+}
+
+// source sends the files in s.cmd.paths to the client.
+func (s *scpSession) source() error {
+	if err := s.readAck(); err != nil {
+		return err
+	}
+	for _, p := range s.cmd.paths {
+		if err := s.send(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send sends the file or directory p to the client. Errors with p are
+// reported to the client, which carries on.
+func (s *scpSession) send(p string) error {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return s.warn("%s: %v", p, unwrapPathError(err))
+	}
+	name := path.Base(filepath.ToSlash(p))
+	if !fi.IsDir() && !fi.Mode().IsRegular() {
+		return s.warn("%s: not a regular file", p)
+	}
+	if fi.IsDir() && !s.cmd.recursive {
+		return s.warn("%s: not a regular file", p)
+	}
+	var f *os.File
+	if !fi.IsDir() {
+		if f, err = os.Open(p); err != nil {
+			return s.warn("%s: %v", p, unwrapPathError(err))
+		}
+		defer f.Close()
+	}
+	if s.cmd.preserve {
+		fmt.Fprintf(s.w, "T%d 0 %d 0\n", fi.ModTime().Unix(), fi.ModTime().Unix())
+		if err := s.readAck(); err != nil {
+			return skipErr(err)
+		}
+	}
+	if fi.IsDir() {
+		ents, err := os.ReadDir(p)
+		if err != nil {
+			return s.warn("%s: %v", p, unwrapPathError(err))
+		}
+		fmt.Fprintf(s.w, "D%04o 0 %s\n", fi.Mode().Perm(), name)
+		if err := s.readAck(); err != nil {
+			return skipErr(err)
+		}
+		for _, e := range ents {
+			if err := s.send(filepath.Join(p, e.Name())); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(s.w, "E\n")
+		return skipErr(s.readAck())
+	}
+	fmt.Fprintf(s.w, "C%04o %d %s\n", fi.Mode().Perm(), fi.Size(), name)
+	if err := s.readAck(); err != nil {
+		return skipErr(err)
+	}
+	n, err := io.CopyN(s.w, f, fi.Size())
+	if err != nil && n < fi.Size() {
+		// The file shrank or can't be read. The client expects as
+		// many bytes as it was told, so pad it out, then report the
+		// error instead of the final ack.
+		if _, err := io.CopyN(s.w, zeroReader{}, fi.Size()-n); err != nil {
+			return err
+		}
+		if err := s.warn("%s: %v", p, unwrapPathError(err)); err != nil {
+			return err
+		}
+	} else if err := s.ack(); err != nil {
+		return err
+	}
+	return skipErr(s.readAck())
+}
+
+// skipErr returns err, unless it's errSCPSkip, in which case the
+// transfer carries on with the next file.
+func skipErr(err error) error {
+	if err == errSCPSkip {
+		return nil
+	}
+	return err
+}
+
+func unwrapPathError(err error) error {
+	var pe *os.PathError
+	if errors.As(err, &pe) {
+		return pe.Err
+	}
+	return err
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,174 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || (darwin && !ios) || freebsd || openbsd
+
+package tailssh
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSCPCommand(t *testing.T) {
+	tests := []struct {
+		cmdline string
+		want    *scpCommand // or nil if not a built-in scp command
+	}{
+		{"scp -t -- /tmp", &scpCommand{sink: true, paths: []string{"/tmp"}}},
+		{"scp -v -r -p -d -t .", &scpCommand{sink: true, recursive: true, preserve: true, targetDir: true, paths: []string{"."}}},
+		{"scp -f 'a b' \"c\\\"d\" e\\ f", &scpCommand{paths: []string{"a b", `c"d`, "e f"}}},
+		{"scp -rf ~/x ~", &scpCommand{recursive: true, paths: []string{"./x", "."}}},
+		{"scp -f a~b", &scpCommand{paths: []string{"a~b"}}},
+		{"scp -f *.txt", nil},
+		{"scp -f $HOME/x", nil},
+		{"scp -f \"$HOME\"", nil},
+		{"scp -f ~root/x", nil},
+		{"scp -f a; rm -rf /", nil},
+		{"scp -t a b", nil},
+		{"scp -t -f a", nil},
+		{"scp -x -t a", nil},
+		{"scp a", nil},
+		{"ls -t a", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		_, got, ok := parseSCPCommand(tt.cmdline)
+		if ok != (tt.want != nil) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSCPCommand(%q) = %+v, %v; want %+v", tt.cmdline, got, ok, tt.want)
+		}
+	}
+}
+
+// runSCP runs src as the source of a transfer into dst, as when copying
+// between two hosts, and returns the stream sent by src.
+func runSCP(t *testing.T, src, dst *scpCommand) (stream []byte, srcErr, dstErr error) {
+	t.Helper()
+	srcR, dstW := io.Pipe()
+	dstR, srcW := io.Pipe()
+	var buf bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		err := src.serve(srcR, io.MultiWriter(srcW, &buf))
+		srcW.Close()
+		done <- err
+	}()
+	dstErr = dst.serve(dstR, dstW)
+	dstW.Close()
+	srcErr = <-done
+	return buf.Bytes(), srcErr, dstErr
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, contents := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSCPRecursive(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"tree/a.txt":     "hello",
+		"tree/sub/b.txt": "world!",
+		"tree/empty":     "",
+	})
+
+	stream, srcErr, dstErr := runSCP(t,
+		&scpCommand{recursive: true, preserve: true, paths: []string{filepath.Join(src, "tree")}},
+		&scpCommand{sink: true, recursive: true, preserve: true, targetDir: true, paths: []string{dst}},
+	)
+	if srcErr != nil || dstErr != nil {
+		t.Fatalf("source: %v, sink: %v", srcErr, dstErr)
+	}
+	for name, want := range map[string]string{
+		"tree/a.txt":     "hello",
+		"tree/sub/b.txt": "world!",
+		"tree/empty":     "",
+	} {
+		p := filepath.Join(dst, name)
+		got, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q; want %q", name, got, want)
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0640 {
+			t.Errorf("%s mode = %v; want 0640", name, fi.Mode())
+		}
+	}
+
+	var marks []string
+	err := logSCPTransfers(bytes.NewReader(stream), false, func(s string) error {
+		marks = append(marks, s)
+		return nil
+	})
+	if err != io.EOF {
+		t.Errorf("logSCPTransfers = %v; want EOF", err)
+	}
+	want := []string{
+		`scp download directory "tree" (mode 0755)`,
+		`scp download "tree/a.txt" (5 bytes, mode 0640)`,
+		`scp download "tree/empty" (0 bytes, mode 0640)`,
+		`scp download directory "tree/sub" (mode 0755)`,
+		`scp download "tree/sub/b.txt" (6 bytes, mode 0640)`,
+	}
+	if !reflect.DeepEqual(marks, want) {
+		t.Errorf("marks:\n%s\nwant:\n%s", strings.Join(marks, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSCPSingleFileAndErrors(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeFiles(t, src, map[string]string{"a.txt": "hello"})
+
+	// Copying a file to a new name, alongside a missing file and a
+	// directory without -r, which are reported but don't stop the
+	// transfer.
+	target := filepath.Join(dst, "renamed.txt")
+	_, srcErr, dstErr := runSCP(t,
+		&scpCommand{paths: []string{filepath.Join(src, "missing"), src, filepath.Join(src, "a.txt")}},
+		&scpCommand{sink: true, paths: []string{target}},
+	)
+	if srcErr != errSCPFailed {
+		t.Errorf("source error = %v; want %v", srcErr, errSCPFailed)
+	}
+	if dstErr != errSCPFailed {
+		t.Errorf("sink error = %v; want %v", dstErr, errSCPFailed)
+	}
+	if got, err := os.ReadFile(target); err != nil || string(got) != "hello" {
+		t.Errorf("target = %q, %v; want hello", got, err)
+	}
+}
+
+func TestSCPSinkRejectsBadNames(t *testing.T) {
+	dst := t.TempDir()
+	sc := &scpCommand{sink: true, paths: []string{dst}}
+	in := strings.NewReader("C0644 5 ../escaped\nhello\x00")
+	var out bytes.Buffer
+	if err := sc.serve(in, &out); err == nil {
+		t.Fatal("unexpected success")
+	}
+	if !strings.Contains(out.String(), "\x02scp: protocol error") {
+		t.Errorf("output = %q; want protocol error", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dst), "escaped")); !os.IsNotExist(err) {
+		t.Errorf("file written outside of target: %v", err)
+	}
+}
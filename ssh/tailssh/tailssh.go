@@ -37,6 +37,7 @@
 	"tailscale.com/logtail/backoff"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
+	"tailscale.com/ssh/sshrec"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tempfork/gliderlabs/ssh"
 	"tailscale.com/types/key"
@@ -471,9 +472,12 @@ func (srv *server) newConn() (*conn, error) {
 
 // mayReversePortPortForwardTo reports whether the ctx should be allowed to port forward
 // to the specified host and port.
-// TODO(bradfitz/maisem): should we have more checks on host/port?
 func (c *conn) mayReversePortForwardTo(ctx ssh.Context, destinationHost string, destinationPort uint32) bool {
 	if c.finalAction != nil && c.finalAction.AllowRemotePortForwarding {
+		if pl := c.finalAction.PermitListen; len(pl) > 0 && !permitsHostPort(pl, destinationHost, destinationPort) {
+			c.logf("remote port forwarding to %v denied by PermitListen", net.JoinHostPort(destinationHost, fmt.Sprint(destinationPort)))
+			return false
+		}
 		metricRemotePortForward.Add(1)
 		return true
 	}
@@ -482,15 +486,41 @@ func (c *conn) mayReversePortForwardTo(ctx ssh.Context, destinationHost string,
 
 // mayForwardLocalPortTo reports whether the ctx should be allowed to port forward
 // to the specified host and port.
-// TODO(bradfitz/maisem): should we have more checks on host/port?
 func (c *conn) mayForwardLocalPortTo(ctx ssh.Context, destinationHost string, destinationPort uint32) bool {
 	if c.finalAction != nil && c.finalAction.AllowLocalPortForwarding {
+		if po := c.finalAction.PermitOpen; len(po) > 0 && !permitsHostPort(po, destinationHost, destinationPort) {
+			c.logf("local port forwarding to %v denied by PermitOpen", net.JoinHostPort(destinationHost, fmt.Sprint(destinationPort)))
+			return false
+		}
 		metricLocalPortForward.Add(1)
 		return true
 	}
 	return false
 }
 
+// permitsHostPort reports whether host and port match one of the
+// "host:port" patterns, as in tailcfg.SSHAction.PermitOpen.
+func permitsHostPort(patterns []string, host string, port uint32) bool {
+	host = strings.TrimSuffix(host, ".")
+	hostIP, hostIPErr := netip.ParseAddr(host)
+	for _, pat := range patterns {
+		ph, pp, err := net.SplitHostPort(pat)
+		if err != nil {
+			continue
+		}
+		if pp != "*" && pp != strconv.FormatUint(uint64(port), 10) {
+			continue
+		}
+		if ph == "*" || strings.EqualFold(strings.TrimSuffix(ph, "."), host) {
+			return true
+		}
+		if ip, err := netip.ParseAddr(ph); err == nil && hostIPErr == nil && ip.Unmap() == hostIP.Unmap() {
+			return true
+		}
+	}
+	return false
+}
+
 // havePubKeyPolicy reports whether any policy rule may provide access by means
 // of a ssh.PublicKey.
 func (c *conn) havePubKeyPolicy() bool {
@@ -1067,32 +1097,32 @@ func (ss *sshSession) run() {
 	ss.DisablePTYEmulation()
 
 	var rec *recording // or nil if disabled
-	if ss.Subsystem() != "sftp" {
+	if ss.subsystem() != "sftp" {
 		if err := ss.handleSSHAgentForwarding(ss, lu); err != nil {
 			ss.logf("agent forwarding failed: %v", err)
 		} else if ss.agentListener != nil {
 			// TODO(maisem/bradfitz): add a way to close all session resources
 			defer ss.agentListener.Close()
 		}
+	}
 
-		if ss.shouldRecord() {
-			var err error
-			rec, err = ss.startNewRecording()
-			if err != nil {
-				var uve userVisibleError
-				if errors.As(err, &uve) {
-					fmt.Fprintf(ss, "%s\r\n", uve.SSHTerminationMessage())
-				} else {
-					fmt.Fprintf(ss, "can't start new recording\r\n")
-				}
-				ss.logf("startNewRecording: %v", err)
-				ss.Exit(1)
-				return
-			}
-			ss.logf("startNewRecording: <nil>")
-			if rec != nil {
-				defer rec.Close()
+	if ss.shouldRecord() {
+		var err error
+		rec, err = ss.startNewRecording()
+		if err != nil {
+			var uve userVisibleError
+			if errors.As(err, &uve) {
+				fmt.Fprintf(ss, "%s\r\n", uve.SSHTerminationMessage())
+			} else {
+				fmt.Fprintf(ss, "can't start new recording\r\n")
 			}
+			ss.logf("startNewRecording: %v", err)
+			ss.Exit(1)
+			return
+		}
+		ss.logf("startNewRecording: <nil>")
+		if rec != nil {
+			defer rec.Close()
 		}
 	}
 
@@ -1206,6 +1236,33 @@ func (ss *sshSession) recorders() ([]netip.AddrPort, *tailcfg.SSHRecorderFailure
 	return ss.conn.action0.Recorders, ss.conn.action0.OnRecordingFailure
 }
 
+// subsystem returns the subsystem that ss runs, such as "sftp", or the
+// empty string if it runs a command or a shell.
+func (ss *sshSession) subsystem() string {
+	if ss.conn.finalAction.ForceCommand != "" {
+		return ""
+	}
+	return ss.Subsystem()
+}
+
+// rawCommand returns the command that ss runs: the ForceCommand of the
+// policy if it has one, or else the one the client requested, if any.
+func (ss *sshSession) rawCommand() string {
+	if fc := ss.conn.finalAction.ForceCommand; fc != "" {
+		return fc
+	}
+	return ss.RawCommand()
+}
+
+// recordedCommand returns the command that ss runs, as recorded in its
+// CastHeader.
+func (ss *sshSession) recordedCommand() string {
+	if fc := ss.conn.finalAction.ForceCommand; fc != "" {
+		return fc
+	}
+	return strings.Join(ss.Command(), " ")
+}
+
 func (ss *sshSession) shouldRecord() bool {
 	recs, _ := ss.recorders()
 	return len(recs) > 0 || recordSSHToLocalDisk()
@@ -1447,6 +1504,11 @@ type CastHeader struct {
 	// It may be shared across multiple sessions over the same connection in
 	// case of SSH multiplexing.
 	ConnectionID string `json:"connectionID"`
+
+	// FileTransfer is "sftp" or "scp" if the session is a file transfer.
+	// Its recording then only has marker ("m") events, describing the
+	// files transferred, rather than the session's output.
+	FileTransfer string `json:"fileTransfer,omitempty"`
 }
 
 // sessionRecordingClient returns an http.Client that uses srv.lb.Dialer() to
@@ -1572,19 +1634,11 @@ func (ss *sshSession) connectToRecorder(ctx context.Context, recs []netip.AddrPo
 }
 
 func (ss *sshSession) openFileForRecording(now time.Time) (_ io.WriteCloser, err error) {
-	varRoot := ss.conn.srv.lb.TailscaleVarRoot()
-	if varRoot == "" {
-		return nil, errors.New("no var root for recording storage")
-	}
-	dir := filepath.Join(varRoot, "ssh-sessions")
-	if err := os.MkdirAll(dir, 0700); err != nil {
-		return nil, err
-	}
-	f, err := os.CreateTemp(dir, fmt.Sprintf("ssh-session-%v-*.cast", now.UnixNano()))
+	st, err := sshrec.FromEnv(ss.conn.srv.lb.TailscaleVarRoot(), ss.logf)
 	if err != nil {
 		return nil, err
 	}
-	return f, nil
+	return st.Create(now)
 }
 
 // startNewRecording starts a new SSH session recording.
@@ -1623,6 +1677,7 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 		start:    now,
 		failOpen: onFailure == nil || onFailure.TerminateSessionWithMessage == "",
 	}
+	rec.transfer, rec.transferDir = ss.fileTransfer()
 
 	// We want to use a background context for uploading and not ss.ctx.
 	// ss.ctx is closed when the session closes, but we don't want to break the upload at that time.
@@ -1637,6 +1692,19 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 		var errChan <-chan error
 		var attempts []*tailcfg.SSHRecordingAttempt
 		rec.out, attempts, errChan, err = ss.connectToRecorder(ctx, recorders)
+		if err != nil && sshrec.Fallback() {
+			// None of the recorders is reachable, so record to local
+			// disk instead, as if the recording had failed open.
+			if out, ferr := ss.openFileForRecording(now); ferr == nil {
+				ss.logf("recording: error starting recording (recording to local disk): %v", err)
+				if onFailure != nil && onFailure.NotifyURL != "" && len(attempts) > 0 {
+					ss.notifyControl(ctx, nodeKey, tailcfg.SSHSessionRecordingFailed, attempts, onFailure.NotifyURL)
+				}
+				rec.out, errChan, err = out, nil, nil
+			} else {
+				ss.logf("recording: error recording to local disk: %v", ferr)
+			}
+		}
 		if err != nil {
 			if onFailure != nil && onFailure.NotifyURL != "" && len(attempts) > 0 {
 				eventType := tailcfg.SSHSessionRecordingFailed
@@ -1656,34 +1724,37 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 			ss.logf("recording: error starting recording (failing open): %v", err)
 			return nil, nil
 		}
-		go func() {
-			err := <-errChan
-			if err == nil {
-				// Success.
-				ss.logf("recording: finished uploading recording")
-				return
-			}
-			if onFailure != nil && onFailure.NotifyURL != "" && len(attempts) > 0 {
-				lastAttempt := attempts[len(attempts)-1]
-				lastAttempt.FailureMessage = err.Error()
-
-				eventType := tailcfg.SSHSessionRecordingFailed
-				if onFailure.TerminateSessionWithMessage != "" {
-					eventType = tailcfg.SSHSessionRecordingTerminated
+		// errChan is nil when recording to local disk.
+		if errChan != nil {
+			go func() {
+				err := <-errChan
+				if err == nil {
+					// Success.
+					ss.logf("recording: finished uploading recording")
+					return
 				}
+				if onFailure != nil && onFailure.NotifyURL != "" && len(attempts) > 0 {
+					lastAttempt := attempts[len(attempts)-1]
+					lastAttempt.FailureMessage = err.Error()
 
-				ss.notifyControl(ctx, nodeKey, eventType, attempts, onFailure.NotifyURL)
-			}
-			if onFailure != nil && onFailure.TerminateSessionWithMessage != "" {
-				ss.logf("recording: error uploading recording (closing session): %v", err)
-				ss.cancelCtx(userVisibleError{
-					error: err,
-					msg:   onFailure.TerminateSessionWithMessage,
-				})
-				return
-			}
-			ss.logf("recording: error uploading recording (failing open): %v", err)
-		}()
+					eventType := tailcfg.SSHSessionRecordingFailed
+					if onFailure.TerminateSessionWithMessage != "" {
+						eventType = tailcfg.SSHSessionRecordingTerminated
+					}
+
+					ss.notifyControl(ctx, nodeKey, eventType, attempts, onFailure.NotifyURL)
+				}
+				if onFailure != nil && onFailure.TerminateSessionWithMessage != "" {
+					ss.logf("recording: error uploading recording (closing session): %v", err)
+					ss.cancelCtx(userVisibleError{
+						error: err,
+						msg:   onFailure.TerminateSessionWithMessage,
+					})
+					return
+				}
+				ss.logf("recording: error uploading recording (failing open): %v", err)
+			}()
+		}
 	}
 
 	ch := CastHeader{
@@ -1691,7 +1762,7 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 		Width:     w.Width,
 		Height:    w.Height,
 		Timestamp: now.Unix(),
-		Command:   strings.Join(ss.Command(), " "),
+		Command:   ss.recordedCommand(),
 		Env: map[string]string{
 			"TERM": term,
 			// TODO(bradfitz): anything else important?
@@ -1708,6 +1779,7 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 		SrcNode:      strings.TrimSuffix(ss.conn.info.node.Name(), "."),
 		SrcNodeID:    ss.conn.info.node.StableID(),
 		ConnectionID: ss.conn.connID,
+		FileTransfer: rec.transfer,
 	}
 	if !ss.conn.info.node.IsTagged() {
 		ch.SrcNodeUser = ss.conn.info.uprof.LoginName
@@ -1780,11 +1852,30 @@ type recording struct {
 	// continue if writing to the recording fails.
 	failOpen bool
 
-	mu  sync.Mutex // guards writes to, close of out
-	out io.WriteCloser
+	// transfer is the kind of file transfer being recorded ("sftp" or
+	// "scp"), or empty if the session isn't one. Only the metadata of
+	// the files transferred is recorded, from the stream in direction
+	// transferDir ("i" or "o").
+	transfer    string
+	transferDir string
+
+	transferLoggers sync.WaitGroup // goroutines of transferWriter
+
+	mu            sync.Mutex // guards writes to, close of out; transferPipes
+	out           io.WriteCloser
+	transferPipes []*io.PipeWriter
 }
 
 func (r *recording) Close() error {
+	r.mu.Lock()
+	pipes := r.transferPipes
+	r.transferPipes = nil
+	r.mu.Unlock()
+	for _, pw := range pipes {
+		pw.Close()
+	}
+	r.transferLoggers.Wait()
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	if r.out == nil {
@@ -1795,6 +1886,20 @@ func (r *recording) Close() error {
 	return err
 }
 
+// marker records an asciinema marker event with the given label.
+func (r *recording) marker(label string) error {
+	j, err := json.Marshal([]any{
+		time.Since(r.start).Seconds(),
+		"m",
+		label,
+	})
+	if err != nil {
+		return err
+	}
+	j = append(j, '\n')
+	return loggingWriter{r: r}.writeCastLine(j)
+}
+
 // writer returns an io.Writer around w that first records the write.
 //
 // The dir should be "i" for input or "o" for output.
@@ -1806,6 +1911,12 @@ func (r *recording) writer(dir string, w io.Writer) io.Writer {
 	if r == nil {
 		return w
 	}
+	if r.transfer != "" {
+		if dir != r.transferDir {
+			return w
+		}
+		return r.transferWriter(w)
+	}
 	if dir == "i" {
 		// TODO: record input? Maybe not, since it might contain
 		// passwords.
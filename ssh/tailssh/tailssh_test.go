@@ -1074,6 +1074,72 @@ func TestExpandPublicKeyURL(t *testing.T) {
 	}
 }
 
+func TestPermitsHostPort(t *testing.T) {
+	patterns := []string{"db.example.com:5432", "*:22", "100.64.0.1:*", "[fd7a:115c:a1e0::1]:443"}
+	tests := []struct {
+		host string
+		port uint32
+		want bool
+	}{
+		{"db.example.com", 5432, true},
+		{"DB.example.com.", 5432, true},
+		{"db.example.com", 5433, false},
+		{"anything", 22, true},
+		{"100.64.0.1", 8080, true},
+		{"::ffff:100.64.0.1", 8080, true},
+		{"100.64.0.2", 8080, false},
+		{"fd7a:115c:a1e0:0::1", 443, true},
+		{"fd7a:115c:a1e0::2", 443, false},
+		{"localhost", 80, false},
+	}
+	for _, tt := range tests {
+		if got := permitsHostPort(patterns, tt.host, tt.port); got != tt.want {
+			t.Errorf("permitsHostPort(%q, %v) = %v; want %v", tt.host, tt.port, got, tt.want)
+		}
+	}
+}
+
+type rawCommandSession struct {
+	ssh.Session
+	subsystem  string
+	rawCommand string
+}
+
+func (s rawCommandSession) Subsystem() string  { return s.subsystem }
+func (s rawCommandSession) RawCommand() string { return s.rawCommand }
+
+func TestForceCommand(t *testing.T) {
+	ss := &sshSession{
+		Session: rawCommandSession{subsystem: "sftp"},
+		conn:    &conn{finalAction: &tailcfg.SSHAction{Accept: true}},
+	}
+	if got := ss.subsystem(); got != "sftp" {
+		t.Errorf("subsystem = %q; want sftp", got)
+	}
+	if kind, _ := ss.fileTransfer(); kind != "sftp" {
+		t.Errorf("fileTransfer = %q; want sftp", kind)
+	}
+
+	// A forced command replaces the subsystem, or the requested command.
+	ss.conn.finalAction.ForceCommand = "scp -t /backups"
+	if got := ss.subsystem(); got != "" {
+		t.Errorf("forced subsystem = %q; want none", got)
+	}
+	if got := ss.rawCommand(); got != "scp -t /backups" {
+		t.Errorf("forced rawCommand = %q", got)
+	}
+	if kind, dir := ss.fileTransfer(); kind != "scp" || dir != "i" {
+		t.Errorf("forced fileTransfer = %q, %q; want scp upload", kind, dir)
+	}
+	ss.Session = rawCommandSession{rawCommand: "rm -rf /"}
+	if got := ss.rawCommand(); got != "scp -t /backups" {
+		t.Errorf("forced rawCommand = %q", got)
+	}
+	if got := ss.recordedCommand(); got != "scp -t /backups" {
+		t.Errorf("forced recordedCommand = %q", got)
+	}
+}
+
 func TestAcceptEnvPair(t *testing.T) {
 	tests := []struct {
 		in   string
@@ -24,6 +24,12 @@ type DERPMap struct {
 	//
 	// This field is only meaningful if the Regions map is non-nil (indicating a change).
 	OmitDefaultRegions bool `json:"omitDefaultRegions,omitempty"`
+
+	// Federation, if non-nil, is where to learn about more DERP regions,
+	// run by other organizations that share their relay capacity.
+	// Regions learned this way are added to Regions, except for those
+	// whose RegionIDs are already in use.
+	Federation *DERPFederation `json:",omitempty"`
 }
 
 // / RegionIDs returns the sorted region IDs.
@@ -36,6 +42,29 @@ func (m *DERPMap) RegionIDs() []int {
 	return ret
 }
 
+// DERPFederation describes where clients can learn about DERP regions run
+// by other organizations, and whose word to trust about them.
+type DERPFederation struct {
+	// URLs are fetched for JSON arrays of signed relay descriptors, as
+	// served by derper at /derp/federation.
+	URLs []string
+
+	// Signers are the organizations trusted to describe their relays.
+	// A descriptor is only used if it's signed by one of them and names
+	// that signer's Operator.
+	Signers []*DERPFederationSigner
+}
+
+// DERPFederationSigner is an organization trusted to sign descriptors of
+// the DERP relays it runs.
+type DERPFederationSigner struct {
+	// Operator is the organization's name, as used in its descriptors.
+	Operator string
+
+	// Key is the organization's ed25519 public key.
+	Key []byte
+}
+
 // DERPHomeParams contains parameters from the server related to selecting a
 // DERP home region (sometimes referred to as the "preferred DERP").
 type DERPHomeParams struct {
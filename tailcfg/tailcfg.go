@@ -3,7 +3,7 @@
 
 package tailcfg
 
-//go:generate go run tailscale.com/cmd/viewer --type=User,Node,Hostinfo,NetInfo,Login,DNSConfig,RegisterResponse,RegisterResponseAuth,RegisterRequest,DERPHomeParams,DERPRegion,DERPMap,DERPNode,SSHRule,SSHAction,SSHPrincipal,ControlDialPlan,Location,UserProfile --clonefunc
+//go:generate go run tailscale.com/cmd/viewer --type=User,Node,Hostinfo,NetInfo,Login,DNSConfig,RegisterResponse,RegisterResponseAuth,RegisterRequest,DERPHomeParams,DERPRegion,DERPMap,DERPFederation,DERPFederationSigner,DERPNode,SSHRule,SSHAction,SSHPrincipal,ControlDialPlan,Location,UserProfile --clonefunc
 
 import (
 	"bytes"
@@ -14,8 +14,10 @@
 	"net/netip"
 	"reflect"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"tailscale.com/types/dnstype"
 	"tailscale.com/types/key"
@@ -120,7 +122,15 @@
 //   - 77: 2023-10-03: Client understands Peers[].SelfNodeV6MasqAddrForThisPeer
 //   - 78: 2023-10-05: can handle c2n Wake-on-LAN sending
 //   - 79: 2023-10-05: Client understands UrgentSecurityUpdate in ClientVersion
-const CurrentCapabilityVersion CapabilityVersion = 79
+//   - 80: 2023-10-17: Client understands Node.EndpointTypes and PeerChange.EndpointTypes
+//   - 81: 2023-10-24: Client understands Peers[].MaxTxBitrateForThisPeer and Peers[].DSCPForThisPeer
+//   - 82: 2023-11-01: can handle c2n /app-connector/status, including service probe results
+//   - 83: 2023-11-08: Client understands DERPMap.Federation
+//   - 84: 2023-11-10: Client serves peers' Hostinfo.DNSRecords if they have NodeAttrPublishDNSRecords
+//   - 85: 2023-11-13: Client understands DNSConfig.Blocklists
+//   - 86: 2023-11-15: Client limits its automatic exit node choice per NodeAttrAutoExitNodeCandidates
+//   - 87: 2023-11-20: Client enforces SSHAction.ForceCommand, SetEnv, PermitOpen and PermitListen
+const CurrentCapabilityVersion CapabilityVersion = 87
 
 type StableID string
 
@@ -276,6 +286,11 @@ type Node struct {
 	AllowedIPs   []netip.Prefix   // range of IP addresses to route to this node
 	Endpoints    []netip.AddrPort `json:",omitempty"` // IP+port (public via STUN, and local LANs)
 
+	// EndpointTypes, if non-empty, are the types of the corresponding
+	// endpoints in Endpoints, describing where each endpoint came from.
+	// It is either empty (types unknown) or the same length as Endpoints.
+	EndpointTypes []EndpointType `json:",omitempty"`
+
 	// DERP is this node's home DERP region ID integer, but shoved into an
 	// IP:port string for legacy reasons. The IP address is always "127.3.3.40"
 	// (a loopback address (127) followed by the digits over the letters DERP on
@@ -403,6 +418,40 @@ type Node struct {
 	// ExitNodeDNSResolvers is the list of DNS servers that should be used when this
 	// node is marked IsWireGuardOnly and being used as an exit node.
 	ExitNodeDNSResolvers []*dnstype.Resolver `json:",omitempty"`
+
+	// MaxTxBitrateForThisPeer, if positive, is the maximum rate in bits
+	// per second at which the current node should send traffic to this
+	// peer. Traffic in excess of the rate is dropped.
+	// This field is only populated in a MapResponse for peers and not
+	// for the current node.
+	MaxTxBitrateForThisPeer int64 `json:",omitempty"`
+
+	// DSCPForThisPeer, if non-zero, is the Differentiated Services code
+	// point (RFC 2474) in the range [1,63] with which the current node
+	// should mark the UDP packets carrying its WireGuard traffic to this
+	// peer, such as 8 (CS1) to de-prioritize bulk traffic.
+	// This field is only populated in a MapResponse for peers and not
+	// for the current node.
+	DSCPForThisPeer uint8 `json:",omitempty"`
+}
+
+// EndpointType returns the type of the node's i'th endpoint, or
+// EndpointUnknownType if the node didn't say.
+func (v NodeView) EndpointType(i int) EndpointType {
+	if i < 0 || i >= len(v.ж.EndpointTypes) {
+		return EndpointUnknownType
+	}
+	return v.ж.EndpointTypes[i]
+}
+
+// TypedEndpoints returns the node's endpoints along with their types.
+// Endpoints whose type is unknown have type EndpointUnknownType.
+func (v NodeView) TypedEndpoints() []Endpoint {
+	ret := make([]Endpoint, len(v.ж.Endpoints))
+	for i, ep := range v.ж.Endpoints {
+		ret[i] = Endpoint{Addr: ep, Type: v.EndpointType(i)}
+	}
+	return ret
 }
 
 // HasCap reports whether the node has the given capability.
@@ -750,10 +799,171 @@ type Hostinfo struct {
 	// explicitly declared by a node.
 	Location *Location `json:",omitempty"`
 
+	// Metadata is optional key/value metadata about the host declared by
+	// its administrator, such as its rack, region or owning team. It's
+	// carried to peers as-is for use by inventory and automation systems.
+	// See CheckHostinfoMetadata for the constraints on its contents.
+	Metadata map[string]string `json:",omitempty"`
+
+	// DNSRecords are DNS records the node publishes under its own MagicDNS
+	// name, for service discovery, such as an SRV record for
+	// _ssh._tcp.<node name>. Each Name is relative to the node's name, with
+	// "@" meaning the name itself. Peers serve them from MagicDNS only if
+	// the node has NodeAttrPublishDNSRecords. See CheckHostinfoDNSRecords
+	// for the constraints on them.
+	DNSRecords []DNSRecord `json:",omitempty"`
+
 	// NOTE: any new fields containing pointers in this type
 	//       require changes to Hostinfo.Equal.
 }
 
+// MaxHostinfoDNSRecords is the maximum number of records in
+// Hostinfo.DNSRecords.
+const MaxHostinfoDNSRecords = 32
+
+// CheckHostinfoDNSRecords returns an error if recs aren't valid as
+// Hostinfo.DNSRecords.
+//
+// There may be at most MaxHostinfoDNSRecords records, of type CNAME, SRV
+// or TXT. Names are "@" or a relative name of letters, digits, '-' and '_'
+// labels. CNAME and SRV targets are absolute if they end in a dot and
+// relative to the node's name otherwise, like in a zone file. A CNAME
+// can't be for "@", which has the node's addresses.
+func CheckHostinfoDNSRecords(recs []DNSRecord) error {
+	if len(recs) > MaxHostinfoDNSRecords {
+		return fmt.Errorf("%d DNS records; max is %d", len(recs), MaxHostinfoDNSRecords)
+	}
+	cnames := map[string]bool{}
+	for _, r := range recs {
+		if err := checkRelativeDNSName(r.Name); err != nil {
+			return fmt.Errorf("DNS record name: %w", err)
+		}
+		switch r.Type {
+		case "CNAME":
+			if r.Name == "@" {
+				return errors.New("CNAME record can't be for the node's own name")
+			}
+			if err := checkDNSTarget(r.Value); err != nil {
+				return fmt.Errorf("CNAME record %q: %w", r.Name, err)
+			}
+			cnames[r.Name] = true
+		case "SRV":
+			if _, _, _, _, err := ParseSRVValue(r.Value); err != nil {
+				return fmt.Errorf("SRV record %q: %w", r.Name, err)
+			}
+		case "TXT":
+			if len(r.Value) > 255 || !utf8.ValidString(r.Value) {
+				return fmt.Errorf("TXT record %q: value must be valid UTF-8 of at most 255 bytes", r.Name)
+			}
+		default:
+			return fmt.Errorf("unsupported DNS record type %q", r.Type)
+		}
+	}
+	for _, r := range recs {
+		if cnames[r.Name] && r.Type != "CNAME" {
+			return fmt.Errorf("%q has a CNAME record and other records", r.Name)
+		}
+	}
+	return nil
+}
+
+// ParseSRVValue parses the Value of an SRV DNSRecord, of the form
+// "priority weight port target".
+func ParseSRVValue(v string) (priority, weight, port uint16, target string, err error) {
+	f := strings.Fields(v)
+	if len(f) != 4 {
+		return 0, 0, 0, "", fmt.Errorf("value %q isn't \"priority weight port target\"", v)
+	}
+	var n [3]uint16
+	for i := range n {
+		u, err := strconv.ParseUint(f[i], 10, 16)
+		if err != nil {
+			return 0, 0, 0, "", fmt.Errorf("value %q: %w", v, err)
+		}
+		n[i] = uint16(u)
+	}
+	if err := checkDNSTarget(f[3]); err != nil {
+		return 0, 0, 0, "", err
+	}
+	return n[0], n[1], n[2], f[3], nil
+}
+
+// checkRelativeDNSName checks that name is "@" or a relative DNS name
+// whose labels can include underscores, as in "_ssh._tcp".
+func checkRelativeDNSName(name string) error {
+	if name == "@" {
+		return nil
+	}
+	if name == "" || strings.HasSuffix(name, ".") {
+		return fmt.Errorf("%q isn't \"@\" or a relative name", name)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("invalid label %q in %q", label, name)
+		}
+		for _, r := range label {
+			if !isDNSRecordLabelRune(r) {
+				return fmt.Errorf("invalid character %q in %q", r, name)
+			}
+		}
+	}
+	return nil
+}
+
+// checkDNSTarget checks the target of a CNAME or SRV record: an absolute
+// name ending in a dot, or else a relative name like checkRelativeDNSName.
+func checkDNSTarget(target string) error {
+	if abs, ok := strings.CutSuffix(target, "."); ok {
+		if _, err := dnsname.ToFQDN(abs); err != nil || abs == "" {
+			return fmt.Errorf("invalid target %q", target)
+		}
+		return nil
+	}
+	return checkRelativeDNSName(target)
+}
+
+func isDNSRecordLabelRune(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' ||
+		r == '-' || r == '_'
+}
+
+// MaxHostinfoMetadataSize is the maximum total size in bytes of all keys and
+// values in Hostinfo.Metadata.
+const MaxHostinfoMetadataSize = 1024
+
+// CheckHostinfoMetadata returns an error if md isn't valid as
+// Hostinfo.Metadata.
+//
+// Keys must be non-empty and only contain ASCII letters, digits, '-', '_' and
+// '.'. Values must be valid UTF-8. The total size of all keys and values must
+// not exceed MaxHostinfoMetadataSize.
+func CheckHostinfoMetadata(md map[string]string) error {
+	size := 0
+	for k, v := range md {
+		if k == "" {
+			return errors.New("empty metadata key")
+		}
+		for _, r := range k {
+			if !isMetadataKeyRune(r) {
+				return fmt.Errorf("invalid character %q in metadata key %q", r, k)
+			}
+		}
+		if !utf8.ValidString(v) {
+			return fmt.Errorf("metadata value for key %q is not valid UTF-8", k)
+		}
+		size += len(k) + len(v)
+	}
+	if size > MaxHostinfoMetadataSize {
+		return fmt.Errorf("metadata is %d bytes; max is %d", size, MaxHostinfoMetadataSize)
+	}
+	return nil
+}
+
+func isMetadataKeyRune(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' ||
+		r == '-' || r == '_' || r == '.'
+}
+
 // TailscaleSSHEnabled reports whether or not this node is acting as a
 // Tailscale SSH server.
 func (hi *Hostinfo) TailscaleSSHEnabled() bool {
@@ -1135,11 +1345,13 @@ type RegisterResponse struct {
 type EndpointType int
 
 const (
-	EndpointUnknownType    = EndpointType(0)
-	EndpointLocal          = EndpointType(1)
-	EndpointSTUN           = EndpointType(2)
-	EndpointPortmapped     = EndpointType(3)
-	EndpointSTUN4LocalPort = EndpointType(4) // hard NAT: STUN'ed IPv4 address + local fixed port
+	EndpointUnknownType     = EndpointType(0)
+	EndpointLocal           = EndpointType(1)
+	EndpointSTUN            = EndpointType(2)
+	EndpointPortmapped      = EndpointType(3)
+	EndpointSTUN4LocalPort  = EndpointType(4) // hard NAT: STUN'ed IPv4 address + local fixed port
+	EndpointExplicitConf    = EndpointType(5) // explicitly configured (static) by the user or admin
+	EndpointControlInferred = EndpointType(6) // not reported by the node; inferred by the control server
 )
 
 func (et EndpointType) String() string {
@@ -1154,14 +1366,28 @@ func (et EndpointType) String() string {
 		return "portmap"
 	case EndpointSTUN4LocalPort:
 		return "stun4localport"
+	case EndpointExplicitConf:
+		return "explicitconf"
+	case EndpointControlInferred:
+		return "controlinferred"
 	}
 	return "other"
 }
 
+// ParseEndpointType parses the String form of an EndpointType.
+func ParseEndpointType(s string) (EndpointType, error) {
+	for et := EndpointUnknownType; et <= EndpointControlInferred; et++ {
+		if et.String() == s {
+			return et, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown endpoint type %q", s)
+}
+
 // Endpoint is an endpoint IPPort and an associated type.
 // It doesn't currently go over the wire as is but is instead
-// broken up into two parallel slices in MapRequest, for compatibility
-// reasons. But this type is used in the codebase.
+// broken up into two parallel slices in MapRequest and Node, for
+// compatibility reasons. But this type is used in the codebase.
 type Endpoint struct {
 	Addr netip.AddrPort
 	Type EndpointType
@@ -1334,6 +1560,10 @@ type CapGrant struct {
 	PeerCapabilityWakeOnLAN PeerCapability = "https://tailscale.com/cap/wake-on-lan"
 	// PeerCapabilityIngress grants the ability for a peer to send ingress traffic.
 	PeerCapabilityIngress PeerCapability = "https://tailscale.com/cap/ingress"
+	// PeerCapabilityWebUI grants the ability for a peer to use the node's
+	// built-in web UI to manage it, when the node serves it on its
+	// Tailscale IPs.
+	PeerCapabilityWebUI PeerCapability = "https://tailscale.com/cap/webui"
 )
 
 // NodeCapMap is a map of capabilities to their optional values. It is valid for
@@ -1544,6 +1774,13 @@ type DNSConfig struct {
 	// Matches are case insensitive.
 	ExitNodeFilteredSet []string `json:",omitempty"`
 
+	// Blocklists are blocklists of names, such as of ad or malware
+	// domains, that the client's MagicDNS resolver answers NXDOMAIN
+	// for, when the client uses Tailscale's DNS settings. Each is an
+	// HTTPS URL of a hosts file or RPZ zone file, which the client
+	// fetches and refreshes periodically.
+	Blocklists []string `json:",omitempty"`
+
 	// TempCorpIssue13969 is a temporary (2023-08-16) field for an internal hack day prototype.
 	// It contains a user inputed URL that should have a list of domains to be blocked.
 	// See https://github.com/tailscale/corp/issues/13969.
@@ -1558,10 +1795,13 @@ type DNSRecord struct {
 
 	// Type is the DNS record type.
 	// Empty means A or AAAA, depending on value.
-	// Other values are currently ignored.
+	// "CNAME", "SRV" and "TXT" are also supported; other values are
+	// currently ignored.
 	Type string `json:",omitempty"`
 
-	// Value is the IP address in string form.
+	// Value is the IP address in string form for A and AAAA records,
+	// the target name for CNAME, "priority weight port target" for SRV
+	// (see ParseSRVValue), or the text for TXT.
 	// TODO(bradfitz): if we ever add support for record types
 	// with non-UTF8 binary data, add ValueBytes []byte that
 	// would take precedence.
@@ -1962,6 +2202,7 @@ func (n *Node) Equal(n2 *Node) bool {
 		slicesx.EqualSameNil(n.AllowedIPs, n2.AllowedIPs) &&
 		slicesx.EqualSameNil(n.PrimaryRoutes, n2.PrimaryRoutes) &&
 		slicesx.EqualSameNil(n.Endpoints, n2.Endpoints) &&
+		slicesx.EqualSameNil(n.EndpointTypes, n2.EndpointTypes) &&
 		n.DERP == n2.DERP &&
 		n.Cap == n2.Cap &&
 		n.Hostinfo.Equal(n2.Hostinfo) &&
@@ -1977,7 +2218,11 @@ func (n *Node) Equal(n2 *Node) bool {
 		n.Expired == n2.Expired &&
 		eqPtr(n.SelfNodeV4MasqAddrForThisPeer, n2.SelfNodeV4MasqAddrForThisPeer) &&
 		eqPtr(n.SelfNodeV6MasqAddrForThisPeer, n2.SelfNodeV6MasqAddrForThisPeer) &&
-		n.IsWireGuardOnly == n2.IsWireGuardOnly
+		n.IsWireGuardOnly == n2.IsWireGuardOnly &&
+		n.DataPlaneAuditLogID == n2.DataPlaneAuditLogID &&
+		slices.EqualFunc(n.ExitNodeDNSResolvers, n2.ExitNodeDNSResolvers, (*dnstype.Resolver).Equal) &&
+		n.MaxTxBitrateForThisPeer == n2.MaxTxBitrateForThisPeer &&
+		n.DSCPForThisPeer == n2.DSCPForThisPeer
 }
 
 func eqPtr[T comparable](a, b *T) bool {
@@ -2138,6 +2383,35 @@ type Oauth2Token struct {
 	// NodeAttrDNSForwarderDisableTCPRetries disables retrying truncated
 	// DNS queries over TCP if the response is truncated.
 	NodeAttrDNSForwarderDisableTCPRetries NodeCapability = "dns-forwarder-disable-tcp-retries"
+
+	// NodeAttrDisableUDPOffload makes the client not use UDP generic
+	// segmentation and receive offload (GSO/GRO) on its magicsock sockets,
+	// for kernels or NICs where they're broken.
+	NodeAttrDisableUDPOffload NodeCapability = "disable-udp-offload"
+
+	// NodeAttrRouteMetrics sets the metrics of the routes the client
+	// installs, overriding the user's preferences. Its value is a JSON
+	// object with any of the uint32 fields "Tailnet", "Subnet" and
+	// "ExitNode"; fields that are absent or zero leave the user's
+	// preference for that class of route in effect.
+	NodeAttrRouteMetrics NodeCapability = "route-metrics"
+
+	// NodeAttrPublishDNSRecords, on a peer, makes the client serve the
+	// records in the peer's Hostinfo.DNSRecords from MagicDNS.
+	NodeAttrPublishDNSRecords NodeCapability = "publish-dns-records"
+
+	// NodeAttrAutoExitNodeCandidates limits the exit nodes the client may
+	// pick when the user lets it choose one automatically. Each value is
+	// a JSON string: either a StableNodeID or a tag, such as "tag:exit",
+	// that the exit node must have. Without the attribute, any exit node
+	// may be picked.
+	NodeAttrAutoExitNodeCandidates NodeCapability = "auto-exit-node-candidates"
+
+	// NodeAttrAppConnectorDomainAttestation makes an app connector on the
+	// node refuse domains that aren't covered by a domain attestation
+	// signed by one of the tailnet key authority's keys. It has no effect
+	// unless tailnet lock is enabled.
+	NodeAttrAppConnectorDomainAttestation NodeCapability = "app-connector-domain-attestation"
 )
 
 // SetDNSRequest is a request to add a DNS record.
@@ -2323,6 +2597,37 @@ type SSHAction struct {
 	// OnRecorderFailure is the action to take if recording fails.
 	// If nil, the default action is to fail open.
 	OnRecordingFailure *SSHRecorderFailureAction `json:"onRecordingFailure,omitempty"`
+
+	// ForceCommand, if non-empty, is the command that the local user's
+	// shell runs for accepted sessions, in place of the command, shell or
+	// subsystem (such as SFTP) that the client requested, like OpenSSH's
+	// ForceCommand. The command requested, if any, is passed to it in the
+	// SSH_ORIGINAL_COMMAND environment variable.
+	//
+	// Along with the port and agent forwarding fields, it makes restricted
+	// grants, such as "backup only" ones running a backup tool, or "jump
+	// only" ones (for ssh -J) allowing local port forwarding to PermitOpen
+	// with a ForceCommand that exits. Control must only send it to clients
+	// with capability version 87 or later, as older ones ignore it.
+	ForceCommand string `json:"forceCommand,omitempty"`
+
+	// SetEnv, if non-empty, are environment variables set for accepted
+	// sessions, overriding those of the local user and those sent by
+	// the client.
+	SetEnv map[string]string `json:"setEnv,omitempty"`
+
+	// PermitOpen, if non-empty, restricts the destinations of local port
+	// forwarding, if allowed by AllowLocalPortForwarding, to those matching
+	// one of its "host:port" patterns, like OpenSSH's PermitOpen. The host
+	// is matched against the host requested by the client, a name or an IP
+	// address, or is "*" to match any host. The port is a port number, or
+	// "*" to match any port.
+	PermitOpen []string `json:"permitOpen,omitempty"`
+
+	// PermitListen, if non-empty, restricts the addresses listened on for
+	// remote port forwarding, if allowed by AllowRemotePortForwarding, to
+	// those matching one of its "host:port" patterns, as in PermitOpen.
+	PermitListen []string `json:"permitListen,omitempty"`
 }
 
 // SSHRecorderFailureAction is the action to take if recording fails.
@@ -2554,6 +2859,11 @@ type PeerChange struct {
 	// have changed to these.
 	Endpoints []netip.AddrPort `json:",omitempty"`
 
+	// EndpointTypes, if non-empty, are the types of the corresponding
+	// endpoints in Endpoints. It is only meaningful if Endpoints is
+	// also set.
+	EndpointTypes []EndpointType `json:",omitempty"`
+
 	// Key, if non-nil, means that the NodeID's wireguard public key changed.
 	Key *key.NodePublic `json:",omitempty"`
 
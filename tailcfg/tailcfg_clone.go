@@ -52,6 +52,7 @@ func (src *Node) Clone() *Node {
 	dst.Addresses = append(src.Addresses[:0:0], src.Addresses...)
 	dst.AllowedIPs = append(src.AllowedIPs[:0:0], src.AllowedIPs...)
 	dst.Endpoints = append(src.Endpoints[:0:0], src.Endpoints...)
+	dst.EndpointTypes = append(src.EndpointTypes[:0:0], src.EndpointTypes...)
 	dst.Hostinfo = src.Hostinfo
 	dst.Tags = append(src.Tags[:0:0], src.Tags...)
 	dst.PrimaryRoutes = append(src.PrimaryRoutes[:0:0], src.PrimaryRoutes...)
@@ -98,6 +99,7 @@ func (src *Node) Clone() *Node {
 	Addresses                     []netip.Prefix
 	AllowedIPs                    []netip.Prefix
 	Endpoints                     []netip.AddrPort
+	EndpointTypes                 []EndpointType
 	DERP                          string
 	Hostinfo                      HostinfoView
 	Created                       time.Time
@@ -119,6 +121,8 @@ func (src *Node) Clone() *Node {
 	SelfNodeV6MasqAddrForThisPeer *netip.Addr
 	IsWireGuardOnly               bool
 	ExitNodeDNSResolvers          []*dnstype.Resolver
+	MaxTxBitrateForThisPeer       int64
+	DSCPForThisPeer               uint8
 }{})
 
 // Clone makes a deep copy of Hostinfo.
@@ -138,6 +142,8 @@ func (src *Hostinfo) Clone() *Hostinfo {
 	if dst.Location != nil {
 		dst.Location = ptr.To(*src.Location)
 	}
+	dst.Metadata = maps.Clone(src.Metadata)
+	dst.DNSRecords = append(src.DNSRecords[:0:0], src.DNSRecords...)
 	return dst
 }
 
@@ -178,6 +184,8 @@ func (src *Hostinfo) Clone() *Hostinfo {
 	Userspace       opt.Bool
 	UserspaceRouter opt.Bool
 	Location        *Location
+	Metadata        map[string]string
+	DNSRecords      []DNSRecord
 }{})
 
 // Clone makes a deep copy of NetInfo.
@@ -262,6 +270,7 @@ func (src *DNSConfig) Clone() *DNSConfig {
 	dst.CertDomains = append(src.CertDomains[:0:0], src.CertDomains...)
 	dst.ExtraRecords = append(src.ExtraRecords[:0:0], src.ExtraRecords...)
 	dst.ExitNodeFilteredSet = append(src.ExitNodeFilteredSet[:0:0], src.ExitNodeFilteredSet...)
+	dst.Blocklists = append(src.Blocklists[:0:0], src.Blocklists...)
 	return dst
 }
 
@@ -276,6 +285,7 @@ func (src *DNSConfig) Clone() *DNSConfig {
 	CertDomains         []string
 	ExtraRecords        []DNSRecord
 	ExitNodeFilteredSet []string
+	Blocklists          []string
 	TempCorpIssue13969  string
 }{})
 
@@ -423,6 +433,7 @@ func (src *DERPMap) Clone() *DERPMap {
 			dst.Regions[k] = v.Clone()
 		}
 	}
+	dst.Federation = src.Federation.Clone()
 	return dst
 }
 
@@ -431,6 +442,49 @@ func (src *DERPMap) Clone() *DERPMap {
 	HomeParams         *DERPHomeParams
 	Regions            map[int]*DERPRegion
 	OmitDefaultRegions bool
+	Federation         *DERPFederation
+}{})
+
+// Clone makes a deep copy of DERPFederation.
+// The result aliases no memory with the original.
+func (src *DERPFederation) Clone() *DERPFederation {
+	if src == nil {
+		return nil
+	}
+	dst := new(DERPFederation)
+	*dst = *src
+	dst.URLs = append(src.URLs[:0:0], src.URLs...)
+	if src.Signers != nil {
+		dst.Signers = make([]*DERPFederationSigner, len(src.Signers))
+		for i := range dst.Signers {
+			dst.Signers[i] = src.Signers[i].Clone()
+		}
+	}
+	return dst
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _DERPFederationCloneNeedsRegeneration = DERPFederation(struct {
+	URLs    []string
+	Signers []*DERPFederationSigner
+}{})
+
+// Clone makes a deep copy of DERPFederationSigner.
+// The result aliases no memory with the original.
+func (src *DERPFederationSigner) Clone() *DERPFederationSigner {
+	if src == nil {
+		return nil
+	}
+	dst := new(DERPFederationSigner)
+	*dst = *src
+	dst.Key = append(src.Key[:0:0], src.Key...)
+	return dst
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _DERPFederationSignerCloneNeedsRegeneration = DERPFederationSigner(struct {
+	Operator string
+	Key      []byte
 }{})
 
 // Clone makes a deep copy of DERPNode.
@@ -502,6 +556,9 @@ func (src *SSHAction) Clone() *SSHAction {
 	if dst.OnRecordingFailure != nil {
 		dst.OnRecordingFailure = ptr.To(*src.OnRecordingFailure)
 	}
+	dst.SetEnv = maps.Clone(src.SetEnv)
+	dst.PermitOpen = append(src.PermitOpen[:0:0], src.PermitOpen...)
+	dst.PermitListen = append(src.PermitListen[:0:0], src.PermitListen...)
 	return dst
 }
 
@@ -517,6 +574,10 @@ func (src *SSHAction) Clone() *SSHAction {
 	AllowRemotePortForwarding bool
 	Recorders                 []netip.AddrPort
 	OnRecordingFailure        *SSHRecorderFailureAction
+	ForceCommand              string
+	SetEnv                    map[string]string
+	PermitOpen                []string
+	PermitListen              []string
 }{})
 
 // Clone makes a deep copy of SSHPrincipal.
@@ -601,7 +662,7 @@ func (src *UserProfile) Clone() *UserProfile {
 
 // Clone duplicates src into dst and reports whether it succeeded.
 // To succeed, <src, dst> must be of types <*T, *T> or <*T, **T>,
-// where T is one of User,Node,Hostinfo,NetInfo,Login,DNSConfig,RegisterResponse,RegisterResponseAuth,RegisterRequest,DERPHomeParams,DERPRegion,DERPMap,DERPNode,SSHRule,SSHAction,SSHPrincipal,ControlDialPlan,Location,UserProfile.
+// where T is one of User,Node,Hostinfo,NetInfo,Login,DNSConfig,RegisterResponse,RegisterResponseAuth,RegisterRequest,DERPHomeParams,DERPRegion,DERPMap,DERPFederation,DERPFederationSigner,DERPNode,SSHRule,SSHAction,SSHPrincipal,ControlDialPlan,Location,UserProfile.
 func Clone(dst, src any) bool {
 	switch src := src.(type) {
 	case *User:
@@ -712,6 +773,24 @@ func Clone(dst, src any) bool {
 			*dst = src.Clone()
 			return true
 		}
+	case *DERPFederation:
+		switch dst := dst.(type) {
+		case *DERPFederation:
+			*dst = *src.Clone()
+			return true
+		case **DERPFederation:
+			*dst = src.Clone()
+			return true
+		}
+	case *DERPFederationSigner:
+		switch dst := dst.(type) {
+		case *DERPFederationSigner:
+			*dst = *src.Clone()
+			return true
+		case **DERPFederationSigner:
+			*dst = src.Clone()
+			return true
+		}
 	case *DERPNode:
 		switch dst := dst.(type) {
 		case *DERPNode:
@@ -16,6 +16,7 @@
 	"time"
 
 	. "tailscale.com/tailcfg"
+	"tailscale.com/types/dnstype"
 	"tailscale.com/types/key"
 	"tailscale.com/types/ptr"
 	"tailscale.com/util/must"
@@ -65,6 +66,8 @@ func TestHostinfoEqual(t *testing.T) {
 		"Userspace",
 		"UserspaceRouter",
 		"Location",
+		"Metadata",
+		"DNSRecords",
 	}
 	if have := fieldsOf(reflect.TypeOf(Hostinfo{})); !reflect.DeepEqual(have, hiHandles) {
 		t.Errorf("Hostinfo.Equal check might be out of sync\nfields: %q\nhandled: %q\n",
@@ -344,7 +347,7 @@ func TestNodeEqual(t *testing.T) {
 	nodeHandles := []string{
 		"ID", "StableID", "Name", "User", "Sharer",
 		"Key", "KeyExpiry", "KeySignature", "Machine", "DiscoKey",
-		"Addresses", "AllowedIPs", "Endpoints", "DERP", "Hostinfo",
+		"Addresses", "AllowedIPs", "Endpoints", "EndpointTypes", "DERP", "Hostinfo",
 		"Created", "Cap", "Tags", "PrimaryRoutes",
 		"LastSeen", "Online", "MachineAuthorized",
 		"Capabilities", "CapMap",
@@ -352,6 +355,7 @@ func TestNodeEqual(t *testing.T) {
 		"ComputedName", "computedHostIfDifferent", "ComputedNameWithHost",
 		"DataPlaneAuditLogID", "Expired", "SelfNodeV4MasqAddrForThisPeer",
 		"SelfNodeV6MasqAddrForThisPeer", "IsWireGuardOnly", "ExitNodeDNSResolvers",
+		"MaxTxBitrateForThisPeer", "DSCPForThisPeer",
 	}
 	if have := fieldsOf(reflect.TypeOf(Node{})); !reflect.DeepEqual(have, nodeHandles) {
 		t.Errorf("Node.Equal check might be out of sync\nfields: %q\nhandled: %q\n",
@@ -476,6 +480,11 @@ func TestNodeEqual(t *testing.T) {
 			&Node{Endpoints: []netip.AddrPort{}},
 			true,
 		},
+		{
+			&Node{EndpointTypes: []EndpointType{EndpointSTUN}},
+			&Node{EndpointTypes: []EndpointType{EndpointPortmapped}},
+			false,
+		},
 		{
 			&Node{Hostinfo: (&Hostinfo{Hostname: "alice"}).View()},
 			&Node{Hostinfo: (&Hostinfo{Hostname: "bob"}).View()},
@@ -595,6 +604,31 @@ func TestNodeEqual(t *testing.T) {
 			},
 			false,
 		},
+		{
+			&Node{DataPlaneAuditLogID: "a"},
+			&Node{DataPlaneAuditLogID: "b"},
+			false,
+		},
+		{
+			&Node{ExitNodeDNSResolvers: []*dnstype.Resolver{{Addr: "1.1.1.1"}}},
+			&Node{ExitNodeDNSResolvers: []*dnstype.Resolver{{Addr: "1.1.1.1"}}},
+			true,
+		},
+		{
+			&Node{ExitNodeDNSResolvers: []*dnstype.Resolver{{Addr: "1.1.1.1"}}},
+			&Node{ExitNodeDNSResolvers: []*dnstype.Resolver{{Addr: "8.8.8.8"}}},
+			false,
+		},
+		{
+			&Node{MaxTxBitrateForThisPeer: 1e6},
+			&Node{MaxTxBitrateForThisPeer: 2e6},
+			false,
+		},
+		{
+			&Node{DSCPForThisPeer: 8},
+			&Node{DSCPForThisPeer: 8},
+			true,
+		},
 	}
 	for i, tt := range tests {
 		got := tt.a.Equal(tt.b)
@@ -855,3 +889,93 @@ type rule struct {
 		})
 	}
 }
+
+func TestCheckHostinfoMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		md      map[string]string
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"ok", map[string]string{"rack": "r1", "owner.team": "infra", "zone_id": "us-east-1a"}, false},
+		{"empty-key", map[string]string{"": "x"}, true},
+		{"bad-key", map[string]string{"rack id": "x"}, true},
+		{"bad-value", map[string]string{"rack": "\xff"}, true},
+		{"max-size", map[string]string{"k": strings.Repeat("x", MaxHostinfoMetadataSize-1)}, false},
+		{"too-big", map[string]string{"k": strings.Repeat("x", MaxHostinfoMetadataSize)}, true},
+	}
+	for _, tt := range tests {
+		err := CheckHostinfoMetadata(tt.md)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v; want error: %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckHostinfoDNSRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		recs    []DNSRecord
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"ok", []DNSRecord{
+			{Name: "_ssh._tcp", Type: "SRV", Value: "0 5 22 @"},
+			{Name: "@", Type: "TXT", Value: "owner=infra"},
+			{Name: "www", Type: "CNAME", Value: "web.example.com."},
+			{Name: "api", Type: "CNAME", Value: "www"},
+		}, false},
+		{"absolute-name", []DNSRecord{{Name: "foo.example.com.", Type: "TXT", Value: "x"}}, true},
+		{"empty-name", []DNSRecord{{Name: "", Type: "TXT", Value: "x"}}, true},
+		{"bad-label", []DNSRecord{{Name: "a..b", Type: "TXT", Value: "x"}}, true},
+		{"bad-type", []DNSRecord{{Name: "@", Type: "A", Value: "1.2.3.4"}}, true},
+		{"cname-self", []DNSRecord{{Name: "@", Type: "CNAME", Value: "other.example.com."}}, true},
+		{"cname-and-txt", []DNSRecord{
+			{Name: "www", Type: "CNAME", Value: "web.example.com."},
+			{Name: "www", Type: "TXT", Value: "x"},
+		}, true},
+		{"bad-srv", []DNSRecord{{Name: "_ssh._tcp", Type: "SRV", Value: "0 5 99999 @"}}, true},
+		{"short-srv", []DNSRecord{{Name: "_ssh._tcp", Type: "SRV", Value: "22 @"}}, true},
+		{"long-txt", []DNSRecord{{Name: "@", Type: "TXT", Value: strings.Repeat("x", 256)}}, true},
+		{"too-many", make([]DNSRecord, MaxHostinfoDNSRecords+1), true},
+	}
+	for _, tt := range tests {
+		err := CheckHostinfoDNSRecords(tt.recs)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v; want error: %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseEndpointType(t *testing.T) {
+	for et := EndpointUnknownType; et <= EndpointControlInferred; et++ {
+		got, err := ParseEndpointType(et.String())
+		if err != nil {
+			t.Errorf("ParseEndpointType(%q): %v", et, err)
+			continue
+		}
+		if got != et {
+			t.Errorf("ParseEndpointType(%q) = %v; want %v", et, got, et)
+		}
+	}
+	if _, err := ParseEndpointType("bogus"); err == nil {
+		t.Error("ParseEndpointType(bogus) succeeded; want error")
+	}
+}
+
+func TestNodeViewTypedEndpoints(t *testing.T) {
+	ep1 := netip.MustParseAddrPort("1.2.3.4:41641")
+	ep2 := netip.MustParseAddrPort("10.0.0.1:41641")
+	n := &Node{
+		Endpoints:     []netip.AddrPort{ep1, ep2},
+		EndpointTypes: []EndpointType{EndpointSTUN},
+	}
+	got := n.View().TypedEndpoints()
+	want := []Endpoint{
+		{Addr: ep1, Type: EndpointSTUN},
+		{Addr: ep2, Type: EndpointUnknownType},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
@@ -19,7 +19,7 @@
 	"tailscale.com/types/views"
 )
 
-//go:generate go run tailscale.com/cmd/cloner  -clonefunc=true -type=User,Node,Hostinfo,NetInfo,Login,DNSConfig,RegisterResponse,RegisterResponseAuth,RegisterRequest,DERPHomeParams,DERPRegion,DERPMap,DERPNode,SSHRule,SSHAction,SSHPrincipal,ControlDialPlan,Location,UserProfile
+//go:generate go run tailscale.com/cmd/cloner  -clonefunc=true -type=User,Node,Hostinfo,NetInfo,Login,DNSConfig,RegisterResponse,RegisterResponseAuth,RegisterRequest,DERPHomeParams,DERPRegion,DERPMap,DERPFederation,DERPFederationSigner,DERPNode,SSHRule,SSHAction,SSHPrincipal,ControlDialPlan,Location,UserProfile
 
 // View returns a readonly view of User.
 func (p *User) View() UserView {
@@ -143,6 +143,7 @@ func (v NodeView) DiscoKey() key.DiscoPublic                { return v.ж.DiscoK
 func (v NodeView) Addresses() views.Slice[netip.Prefix]     { return views.SliceOf(v.ж.Addresses) }
 func (v NodeView) AllowedIPs() views.Slice[netip.Prefix]    { return views.SliceOf(v.ж.AllowedIPs) }
 func (v NodeView) Endpoints() views.Slice[netip.AddrPort]   { return views.SliceOf(v.ж.Endpoints) }
+func (v NodeView) EndpointTypes() views.Slice[EndpointType] { return views.SliceOf(v.ж.EndpointTypes) }
 func (v NodeView) DERP() string                             { return v.ж.DERP }
 func (v NodeView) Hostinfo() HostinfoView                   { return v.ж.Hostinfo }
 func (v NodeView) Created() time.Time                       { return v.ж.Created }
@@ -198,7 +199,9 @@ func (v NodeView) IsWireGuardOnly() bool { return v.ж.IsWireGuardOnly }
 func (v NodeView) ExitNodeDNSResolvers() views.SliceView[*dnstype.Resolver, dnstype.ResolverView] {
 	return views.SliceOfViews[*dnstype.Resolver, dnstype.ResolverView](v.ж.ExitNodeDNSResolvers)
 }
-func (v NodeView) Equal(v2 NodeView) bool { return v.ж.Equal(v2.ж) }
+func (v NodeView) MaxTxBitrateForThisPeer() int64 { return v.ж.MaxTxBitrateForThisPeer }
+func (v NodeView) DSCPForThisPeer() uint8         { return v.ж.DSCPForThisPeer }
+func (v NodeView) Equal(v2 NodeView) bool         { return v.ж.Equal(v2.ж) }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _NodeViewNeedsRegeneration = Node(struct {
@@ -215,6 +218,7 @@ func (v NodeView) Equal(v2 NodeView) bool { return v.ж.Equal(v2.ж) }
 	Addresses                     []netip.Prefix
 	AllowedIPs                    []netip.Prefix
 	Endpoints                     []netip.AddrPort
+	EndpointTypes                 []EndpointType
 	DERP                          string
 	Hostinfo                      HostinfoView
 	Created                       time.Time
@@ -236,6 +240,8 @@ func (v NodeView) Equal(v2 NodeView) bool { return v.ж.Equal(v2.ж) }
 	SelfNodeV6MasqAddrForThisPeer *netip.Addr
 	IsWireGuardOnly               bool
 	ExitNodeDNSResolvers          []*dnstype.Resolver
+	MaxTxBitrateForThisPeer       int64
+	DSCPForThisPeer               uint8
 }{})
 
 // View returns a readonly view of Hostinfo.
@@ -325,7 +331,9 @@ func (v HostinfoView) Location() *Location {
 	return &x
 }
 
-func (v HostinfoView) Equal(v2 HostinfoView) bool { return v.ж.Equal(v2.ж) }
+func (v HostinfoView) Metadata() views.Map[string, string] { return views.MapOf(v.ж.Metadata) }
+func (v HostinfoView) DNSRecords() views.Slice[DNSRecord]  { return views.SliceOf(v.ж.DNSRecords) }
+func (v HostinfoView) Equal(v2 HostinfoView) bool          { return v.ж.Equal(v2.ж) }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HostinfoViewNeedsRegeneration = Hostinfo(struct {
@@ -364,6 +372,8 @@ func (v HostinfoView) Equal(v2 HostinfoView) bool { return v.ж.Equal(v2.ж) }
 	Userspace       opt.Bool
 	UserspaceRouter opt.Bool
 	Location        *Location
+	Metadata        map[string]string
+	DNSRecords      []DNSRecord
 }{})
 
 // View returns a readonly view of NetInfo.
@@ -572,7 +582,8 @@ func (v DNSConfigView) ExtraRecords() views.Slice[DNSRecord] { return views.Slic
 func (v DNSConfigView) ExitNodeFilteredSet() views.Slice[string] {
 	return views.SliceOf(v.ж.ExitNodeFilteredSet)
 }
-func (v DNSConfigView) TempCorpIssue13969() string { return v.ж.TempCorpIssue13969 }
+func (v DNSConfigView) Blocklists() views.Slice[string] { return views.SliceOf(v.ж.Blocklists) }
+func (v DNSConfigView) TempCorpIssue13969() string      { return v.ж.TempCorpIssue13969 }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _DNSConfigViewNeedsRegeneration = DNSConfig(struct {
@@ -585,6 +596,7 @@ func (v DNSConfigView) TempCorpIssue13969() string { return v.ж.TempCorpIssue13
 	CertDomains         []string
 	ExtraRecords        []DNSRecord
 	ExitNodeFilteredSet []string
+	Blocklists          []string
 	TempCorpIssue13969  string
 }{})
 
@@ -982,13 +994,125 @@ func (v DERPMapView) Regions() views.MapFn[int, *DERPRegion, DERPRegionView] {
 		return t.View()
 	})
 }
-func (v DERPMapView) OmitDefaultRegions() bool { return v.ж.OmitDefaultRegions }
+func (v DERPMapView) OmitDefaultRegions() bool       { return v.ж.OmitDefaultRegions }
+func (v DERPMapView) Federation() DERPFederationView { return v.ж.Federation.View() }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _DERPMapViewNeedsRegeneration = DERPMap(struct {
 	HomeParams         *DERPHomeParams
 	Regions            map[int]*DERPRegion
 	OmitDefaultRegions bool
+	Federation         *DERPFederation
+}{})
+
+// View returns a readonly view of DERPFederation.
+func (p *DERPFederation) View() DERPFederationView {
+	return DERPFederationView{ж: p}
+}
+
+// DERPFederationView provides a read-only view over DERPFederation.
+//
+// Its methods should only be called if `Valid()` returns true.
+type DERPFederationView struct {
+	// ж is the underlying mutable value, named with a hard-to-type
+	// character that looks pointy like a pointer.
+	// It is named distinctively to make you think of how dangerous it is to escape
+	// to callers. You must not let callers be able to mutate it.
+	ж *DERPFederation
+}
+
+// Valid reports whether underlying value is non-nil.
+func (v DERPFederationView) Valid() bool { return v.ж != nil }
+
+// AsStruct returns a clone of the underlying value which aliases no memory with
+// the original.
+func (v DERPFederationView) AsStruct() *DERPFederation {
+	if v.ж == nil {
+		return nil
+	}
+	return v.ж.Clone()
+}
+
+func (v DERPFederationView) MarshalJSON() ([]byte, error) { return json.Marshal(v.ж) }
+
+func (v *DERPFederationView) UnmarshalJSON(b []byte) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var x DERPFederation
+	if err := json.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+func (v DERPFederationView) URLs() views.Slice[string] { return views.SliceOf(v.ж.URLs) }
+func (v DERPFederationView) Signers() views.SliceView[*DERPFederationSigner, DERPFederationSignerView] {
+	return views.SliceOfViews[*DERPFederationSigner, DERPFederationSignerView](v.ж.Signers)
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _DERPFederationViewNeedsRegeneration = DERPFederation(struct {
+	URLs    []string
+	Signers []*DERPFederationSigner
+}{})
+
+// View returns a readonly view of DERPFederationSigner.
+func (p *DERPFederationSigner) View() DERPFederationSignerView {
+	return DERPFederationSignerView{ж: p}
+}
+
+// DERPFederationSignerView provides a read-only view over DERPFederationSigner.
+//
+// Its methods should only be called if `Valid()` returns true.
+type DERPFederationSignerView struct {
+	// ж is the underlying mutable value, named with a hard-to-type
+	// character that looks pointy like a pointer.
+	// It is named distinctively to make you think of how dangerous it is to escape
+	// to callers. You must not let callers be able to mutate it.
+	ж *DERPFederationSigner
+}
+
+// Valid reports whether underlying value is non-nil.
+func (v DERPFederationSignerView) Valid() bool { return v.ж != nil }
+
+// AsStruct returns a clone of the underlying value which aliases no memory with
+// the original.
+func (v DERPFederationSignerView) AsStruct() *DERPFederationSigner {
+	if v.ж == nil {
+		return nil
+	}
+	return v.ж.Clone()
+}
+
+func (v DERPFederationSignerView) MarshalJSON() ([]byte, error) { return json.Marshal(v.ж) }
+
+func (v *DERPFederationSignerView) UnmarshalJSON(b []byte) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var x DERPFederationSigner
+	if err := json.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+func (v DERPFederationSignerView) Operator() string             { return v.ж.Operator }
+func (v DERPFederationSignerView) Key() views.ByteSlice[[]byte] { return views.ByteSliceOf(v.ж.Key) }
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _DERPFederationSignerViewNeedsRegeneration = DERPFederationSigner(struct {
+	Operator string
+	Key      []byte
 }{})
 
 // View returns a readonly view of DERPNode.
@@ -1195,6 +1319,11 @@ func (v SSHActionView) OnRecordingFailure() *SSHRecorderFailureAction {
 	return &x
 }
 
+func (v SSHActionView) ForceCommand() string              { return v.ж.ForceCommand }
+func (v SSHActionView) SetEnv() views.Map[string, string] { return views.MapOf(v.ж.SetEnv) }
+func (v SSHActionView) PermitOpen() views.Slice[string]   { return views.SliceOf(v.ж.PermitOpen) }
+func (v SSHActionView) PermitListen() views.Slice[string] { return views.SliceOf(v.ж.PermitListen) }
+
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _SSHActionViewNeedsRegeneration = SSHAction(struct {
 	Message                   string
@@ -1207,6 +1336,10 @@ func (v SSHActionView) OnRecordingFailure() *SSHRecorderFailureAction {
 	AllowRemotePortForwarding bool
 	Recorders                 []netip.AddrPort
 	OnRecordingFailure        *SSHRecorderFailureAction
+	ForceCommand              string
+	SetEnv                    map[string]string
+	PermitOpen                []string
+	PermitListen              []string
 }{})
 
 // View returns a readonly view of SSHPrincipal.
@@ -59,35 +59,36 @@ func (d *fileDeleter) Init(m *Manager, eventHook func(string)) {
 	d.group.Go(func() {
 		d.event("start init")
 		defer d.event("end init")
-		rangeDir(d.dir, func(de fs.DirEntry) bool {
+		walkDir(d.dir, func(name string, de fs.DirEntry) bool {
 			switch {
 			case d.shutdownCtx.Err() != nil:
 				return false // terminate early
 			case !de.Type().IsRegular():
 				return true
-			case strings.HasSuffix(de.Name(), partialSuffix):
+			case strings.HasSuffix(name, partialSuffix):
 				// Only enqueue the file for deletion if there is no active put.
-				nameID := strings.TrimSuffix(de.Name(), partialSuffix)
+				nameID := strings.TrimSuffix(name, partialSuffix)
 				if i := strings.LastIndexByte(nameID, '.'); i > 0 {
 					key := incomingFileKey{ClientID(nameID[i+len("."):]), nameID[:i]}
 					m.incomingFiles.LoadFunc(key, func(_ *incomingFile, loaded bool) {
 						if !loaded {
-							d.Insert(de.Name())
+							d.Insert(name)
 						}
 					})
 				} else {
-					d.Insert(de.Name())
+					d.Insert(name)
 				}
-			case strings.HasSuffix(de.Name(), deletedSuffix):
+			case strings.HasSuffix(name, deletedSuffix):
 				// Best-effort immediate deletion of deleted files.
-				name := strings.TrimSuffix(de.Name(), deletedSuffix)
-				if os.Remove(filepath.Join(d.dir, name)) == nil {
-					if os.Remove(filepath.Join(d.dir, de.Name())) == nil {
+				target := strings.TrimSuffix(name, deletedSuffix)
+				if os.Remove(filepath.Join(d.dir, target)) == nil {
+					if os.Remove(filepath.Join(d.dir, name)) == nil {
+						removeEmptyDirs(d.dir, name)
 						break
 					}
 				}
 				// Otherwise, enqueue the file for later deletion.
-				d.Insert(de.Name())
+				d.Insert(name)
 			}
 			return true
 		})
@@ -148,6 +149,7 @@ func (d *fileDeleter) waitAndDelete(wait time.Duration) {
 				failed = append(failed, elem)
 				continue
 			}
+			removeEmptyDirs(d.dir, file.name)
 			d.queue.Remove(elem)
 			delete(d.byName, file.name)
 			d.event("deleted " + file.name)
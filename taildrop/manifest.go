@@ -0,0 +1,80 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// maxManifestSize is the maximum size of an encoded manifest.
+const maxManifestSize = 16 << 20
+
+// ErrManifestMismatch is returned when putting a manifest that does not match
+// the files that were received.
+var ErrManifestMismatch = errors.New("received files do not match manifest")
+
+// maxReceivedDirFiles is the maximum number of files sent as part of a
+// directory that are remembered until their manifest is received.
+const maxReceivedDirFiles = 64 << 10
+
+// receivedDirFile is a file that was received as part of a directory.
+type receivedDirFile struct {
+	size int64
+	sum  [sha256.Size]byte
+}
+
+// noteReceivedDirFile records that id sent the file name, the slash-separated
+// path of a file within a directory, so that it can be checked against
+// the manifest of the directory.
+func (m *Manager) noteReceivedDirFile(id ClientID, name string, f receivedDirFile) {
+	m.receivedDirFilesMu.Lock()
+	defer m.receivedDirFilesMu.Unlock()
+	if m.receivedDirFiles == nil {
+		m.receivedDirFiles = make(map[incomingFileKey]receivedDirFile)
+	}
+	if len(m.receivedDirFiles) >= maxReceivedDirFiles {
+		// Forget an arbitrary file; its manifest likely never arrives.
+		for k := range m.receivedDirFiles {
+			delete(m.receivedDirFiles, k)
+			break
+		}
+	}
+	m.receivedDirFiles[incomingFileKey{id, name}] = f
+}
+
+// checkManifest reads the [apitype.FileManifest] for dir, the slash-separated
+// path of a directory sent by id, from r, and checks that all the files in it
+// were received intact. The files are checked as they were received, even if
+// they have since been renamed or moved out of [Manager.Dir].
+func (m *Manager) checkManifest(id ClientID, dir string, r io.Reader) error {
+	var manifest apitype.FileManifest
+	if err := json.NewDecoder(io.LimitReader(r, maxManifestSize)).Decode(&manifest); err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+	m.receivedDirFilesMu.Lock()
+	defer m.receivedDirFilesMu.Unlock()
+	var missing []string
+	for _, f := range manifest.Files {
+		var sum Checksum
+		got, ok := m.receivedDirFiles[incomingFileKey{id, path.Join(dir, f.Name)}]
+		if !ok || got.size != f.Size || sum.UnmarshalText([]byte(f.SHA256)) != nil || got.sum != sum.cs {
+			missing = append(missing, f.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrManifestMismatch, strings.Join(missing, ", "))
+	}
+	for _, f := range manifest.Files {
+		delete(m.receivedDirFiles, incomingFileKey{id, path.Join(dir, f.Name)})
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/util/must"
+)
+
+func TestPutFileWithChecksum(t *testing.T) {
+	m := ManagerOptions{Logf: t.Logf, Dir: t.TempDir()}.New()
+	defer m.Shutdown()
+
+	sum := Checksum{sha256.Sum256([]byte("hello"))}
+	opts := PutFileOptions{SHA256: &sum}
+	if _, _, err := m.PutFileWithOptions("id", "bad", strings.NewReader("jello"), 0, 5, opts); !errors.Is(err, ErrFileCorrupt) {
+		t.Fatalf("PutFileWithOptions with corrupt contents = %v; want %v", err, ErrFileCorrupt)
+	}
+	if files := must.Get(m.PartialFiles("id")); len(files) > 0 {
+		t.Errorf("corrupt partial files kept: %q", files)
+	}
+
+	// Resume after part of the file was received.
+	if _, _, err := m.PutFileWithOptions("id", "good", strings.NewReader("hel"), 0, 5, opts); err == nil {
+		t.Fatal("short PutFileWithOptions succeeded")
+	}
+	if _, name, err := m.PutFileWithOptions("id", "good", strings.NewReader("lo"), 3, 2, opts); err != nil || name != "good" {
+		t.Fatalf("PutFileWithOptions = %q, %v; want good", name, err)
+	}
+	if got := must.Get(os.ReadFile(filepath.Join(m.Dir(), "good"))); string(got) != "hello" {
+		t.Errorf("good = %q; want hello", got)
+	}
+}
+
+func TestPutDirectory(t *testing.T) {
+	m := ManagerOptions{Logf: t.Logf, Dir: t.TempDir()}.New()
+	defer m.Shutdown()
+
+	files := map[string]string{
+		"dir/a.txt":     "hello",
+		"dir/sub/b.txt": "world",
+	}
+	var manifest apitype.FileManifest
+	for _, name := range []string{"dir/a.txt", "dir/sub/b.txt"} {
+		contents := files[name]
+		must.Get(m.PutFile("id", name, strings.NewReader(contents), 0, int64(len(contents))))
+		manifest.Files = append(manifest.Files, apitype.FileManifestEntry{
+			Name:   strings.TrimPrefix(name, "dir/"),
+			Size:   int64(len(contents)),
+			SHA256: Checksum{sha256.Sum256([]byte(contents))}.String(),
+		})
+	}
+
+	wfs := must.Get(m.WaitingFiles())
+	want := []apitype.WaitingFile{{Name: "a.txt", Size: 5, Path: "dir/a.txt"}, {Name: "b.txt", Size: 5, Path: "dir/sub/b.txt"}}
+	if !reflect.DeepEqual(wfs, want) {
+		t.Errorf("WaitingFiles = %v; want %v", wfs, want)
+	}
+
+	// Files that are moved out of the inbox still count.
+	must.Do(m.DeleteFile("dir/sub/b.txt"))
+	if _, err := os.Stat(filepath.Join(m.Dir(), "dir", "sub")); !os.IsNotExist(err) {
+		t.Errorf("empty directory not removed: %v", err)
+	}
+
+	putManifest := func(id ClientID, manifest apitype.FileManifest) error {
+		b := must.Get(json.Marshal(manifest))
+		_, err := m.PutFile(id, "dir/"+apitype.FileManifestName, strings.NewReader(string(b)), 0, int64(len(b)))
+		return err
+	}
+	if err := putManifest("other", manifest); !errors.Is(err, ErrManifestMismatch) {
+		t.Errorf("manifest from another client = %v; want %v", err, ErrManifestMismatch)
+	}
+	extra := manifest
+	extra.Files = append(extra.Files, apitype.FileManifestEntry{Name: "missing"})
+	if err := putManifest("id", extra); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("manifest with missing file = %v; want error naming it", err)
+	}
+	must.Do(putManifest("id", manifest))
+	if _, err := os.Stat(filepath.Join(m.Dir(), "dir", apitype.FileManifestName)); !os.IsNotExist(err) {
+		t.Errorf("manifest was stored: %v", err)
+	}
+}
+
+func TestPutDirectorySymlink(t *testing.T) {
+	m := ManagerOptions{Logf: t.Logf, Dir: t.TempDir(), DirectFileMode: true}.New()
+	defer m.Shutdown()
+
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(m.Dir(), "dir")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	if _, err := m.PutFile("id", "dir/sub/a.txt", strings.NewReader("hello"), 0, 5); err == nil {
+		t.Fatal("PutFile through a symlinked directory succeeded")
+	}
+	if des := must.Get(os.ReadDir(outside)); len(des) != 0 {
+		t.Errorf("wrote %d entries outside of the inbox", len(des))
+	}
+}
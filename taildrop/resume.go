@@ -69,8 +69,8 @@ func (m *Manager) PartialFiles(id ClientID) (ret []string, err error) {
 	}
 
 	suffix := id.partialSuffix()
-	if err := rangeDir(m.opts.Dir, func(de fs.DirEntry) bool {
-		if name := de.Name(); strings.HasSuffix(name, suffix) {
+	if err := walkDir(m.opts.Dir, func(name string, de fs.DirEntry) bool {
+		if strings.HasSuffix(name, suffix) {
 			ret = append(ret, name)
 		}
 		return true
@@ -9,9 +9,11 @@
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strings"
 	"time"
 
 	"tailscale.com/client/tailscale/apitype"
@@ -36,12 +38,11 @@ func (m *Manager) HasFilesWaiting() (has bool) {
 	}
 
 	// Check whether there is at least one one waiting file.
-	err := rangeDir(m.opts.Dir, func(de fs.DirEntry) bool {
-		name := de.Name()
+	err := walkDir(m.opts.Dir, func(name string, de fs.DirEntry) bool {
 		if isPartialOrDeleted(name) || !de.Type().IsRegular() {
 			return true
 		}
-		_, err := os.Stat(filepath.Join(m.opts.Dir, name+deletedSuffix))
+		_, err := os.Stat(filepath.Join(m.opts.Dir, filepath.FromSlash(name+deletedSuffix)))
 		if os.IsNotExist(err) {
 			has = true
 			return false
@@ -60,6 +61,8 @@ func (m *Manager) HasFilesWaiting() (has bool) {
 
 // WaitingFiles returns the list of files that have been sent by a
 // peer that are waiting in [Handler.Dir].
+// Files that were sent as part of a directory also report their
+// slash-separated path within [Handler.Dir] in Path.
 // This always returns nil when [Handler.DirectFileMode] is false.
 func (m *Manager) WaitingFiles() (ret []apitype.WaitingFile, err error) {
 	if m == nil || m.opts.Dir == "" {
@@ -68,30 +71,41 @@ func (m *Manager) WaitingFiles() (ret []apitype.WaitingFile, err error) {
 	if m.opts.DirectFileMode {
 		return nil, nil
 	}
-	if err := rangeDir(m.opts.Dir, func(de fs.DirEntry) bool {
-		name := de.Name()
+	if err := walkDir(m.opts.Dir, func(name string, de fs.DirEntry) bool {
 		if isPartialOrDeleted(name) || !de.Type().IsRegular() {
 			return true
 		}
-		_, err := os.Stat(filepath.Join(m.opts.Dir, name+deletedSuffix))
+		_, err := os.Stat(filepath.Join(m.opts.Dir, filepath.FromSlash(name+deletedSuffix)))
 		if os.IsNotExist(err) {
 			fi, err := de.Info()
 			if err != nil {
 				return true
 			}
-			ret = append(ret, apitype.WaitingFile{
-				Name: filepath.Base(name),
+			wf := apitype.WaitingFile{
+				Name: path.Base(name),
 				Size: fi.Size(),
-			})
+			}
+			if strings.Contains(name, "/") {
+				wf.Path = name
+			}
+			ret = append(ret, wf)
 		}
 		return true
 	}); err != nil {
 		return nil, redactError(err)
 	}
-	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	sort.Slice(ret, func(i, j int) bool { return waitingPath(ret[i]) < waitingPath(ret[j]) })
 	return ret, nil
 }
 
+// waitingPath returns the name of wf within [Handler.Dir].
+func waitingPath(wf apitype.WaitingFile) string {
+	if wf.Path != "" {
+		return wf.Path
+	}
+	return wf.Name
+}
+
 // DeleteFile deletes a file of the given baseName from [Handler.Dir].
 // This method is only allowed when [Handler.DirectFileMode] is false.
 func (m *Manager) DeleteFile(baseName string) error {
@@ -137,6 +151,7 @@ func (m *Manager) DeleteFile(baseName string) error {
 			logf("peerapi: failed to DeleteFile: %v", err)
 			return err
 		}
+		removeEmptyDirs(m.opts.Dir, baseName)
 		return nil
 	}
 }
@@ -176,3 +191,53 @@ func (m *Manager) OpenFile(baseName string) (rc io.ReadCloser, size int64, err e
 	}
 	return f, fi.Size(), nil
 }
+
+// MoveFile moves the received file name out of [Handler.Dir] to dst,
+// an absolute path that must not already exist.
+// This method is not allowed when [Handler.AvoidFinalRename] is true.
+func (m *Manager) MoveFile(name, dst string) error {
+	if m == nil || m.opts.Dir == "" {
+		return ErrNoTaildrop
+	}
+	if m.opts.DirectFileMode && m.opts.AvoidFinalRename {
+		return errors.New("moves not allowed with AvoidFinalRename")
+	}
+	if !filepath.IsAbs(dst) {
+		return errors.New("destination is not an absolute path")
+	}
+	src, err := joinDir(m.opts.Dir, name)
+	if err != nil {
+		return err
+	}
+	// Create dst first, so that an existing file is never replaced.
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := os.Rename(src, dst); err != nil {
+		// Likely across filesystems; copy instead.
+		if err := copyFileTo(f, src); err != nil {
+			os.Remove(dst)
+			return redactError(err)
+		}
+		if err := os.Remove(src); err != nil {
+			m.opts.Logf("MoveFile: removing moved file: %v", redactError(err))
+		}
+	}
+	removeEmptyDirs(m.opts.Dir, name)
+	return nil
+}
+
+// copyFileTo copies the contents of the file at src to f, and closes f.
+func copyFileTo(f *os.File, src string) error {
+	sf, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+	if _, err := io.Copy(f, sf); err != nil {
+		return err
+	}
+	return f.Close()
+}
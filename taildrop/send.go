@@ -8,10 +8,13 @@
 	"errors"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/envknob"
 	"tailscale.com/tstime"
 	"tailscale.com/version/distro"
@@ -60,7 +63,8 @@ func (f *incomingFile) Write(p []byte) (n int, err error) {
 }
 
 // PutFile stores a file into [Manager.Dir] from a given client id.
-// The baseName must be a base filename without any slashes.
+// The baseName must be a base filename, or for files sent as part of a
+// directory, the slash-separated path of the file within that directory.
 // The length is the expected length of content to read from r,
 // it may be negative to indicate that it is unknown.
 // It returns the length of the entire file.
@@ -71,18 +75,42 @@ func (f *incomingFile) Write(p []byte) (n int, err error) {
 // specific partial file. This allows the client to determine whether to resume
 // a partial file. While resuming, PutFile may be called again with a non-zero
 // offset to specify where to resume receiving data at.
+//
+// If the base name of baseName is [apitype.FileManifestName], the contents
+// are not stored, but instead checked against the files received so far.
 func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, length int64) (int64, error) {
+	n, _, err := m.PutFileWithOptions(id, baseName, r, offset, length, PutFileOptions{})
+	return n, err
+}
+
+// PutFileOptions are optional parameters for [Manager.PutFileWithOptions].
+type PutFileOptions struct {
+	// SHA256, if non-nil, is the SHA-256 checksum of the entire file,
+	// including any part received before resuming at an offset.
+	// If the received file doesn't match it, the partial file is deleted,
+	// so that a retry starts over, and [ErrFileCorrupt] is returned.
+	SHA256 *Checksum
+}
+
+// PutFileWithOptions is like [Manager.PutFile], but with options.
+// It also returns the name the file was stored as, which differs from
+// baseName if a different file of that name already existed.
+func (m *Manager) PutFileWithOptions(id ClientID, baseName string, r io.Reader, offset, length int64, opts PutFileOptions) (n int64, name string, err error) {
+	return m.putFile(id, baseName, r, offset, length, opts.SHA256)
+}
+
+func (m *Manager) putFile(id ClientID, baseName string, r io.Reader, offset, length int64, wantSum *Checksum) (int64, string, error) {
 	switch {
 	case m == nil || m.opts.Dir == "":
-		return 0, ErrNoTaildrop
+		return 0, "", ErrNoTaildrop
 	case !envknob.CanTaildrop():
-		return 0, ErrNoTaildrop
+		return 0, "", ErrNoTaildrop
 	case distro.Get() == distro.Unraid && !m.opts.DirectFileMode:
-		return 0, ErrNotAccessible
+		return 0, "", ErrNotAccessible
 	}
 	dstPath, err := joinDir(m.opts.Dir, baseName)
 	if err != nil {
-		return 0, err
+		return 0, "", err
 	}
 
 	redactAndLogError := func(action string, err error) error {
@@ -90,6 +118,18 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 		m.opts.Logf("put %v error: %v", action, err)
 		return err
 	}
+	if path.Base(baseName) == apitype.FileManifestName {
+		if err := m.checkManifest(id, path.Dir(baseName), r); err != nil {
+			logErr := err
+			if errors.Is(err, ErrManifestMismatch) {
+				logErr = ErrManifestMismatch // avoid logging the names of files
+			}
+			m.opts.Logf("put Manifest error: %v", logErr)
+			return 0, "", err
+		}
+		return 0, baseName, nil
+	}
+	senderID := id
 
 	avoidPartialRename := m.opts.DirectFileMode && m.opts.AvoidFinalRename
 	if avoidPartialRename {
@@ -114,15 +154,19 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 		return inFile
 	})
 	if loaded {
-		return 0, ErrFileExists
+		return 0, "", ErrFileExists
 	}
 	defer m.incomingFiles.Delete(inFileKey)
-	m.deleter.Remove(filepath.Base(partialPath)) // avoid deleting the partial file while receiving
+	partialName := baseName + id.partialSuffix()
+	m.deleter.Remove(partialName) // avoid deleting the partial file while receiving
 
 	// Create (if not already) the partial file with read-write permissions.
+	if err := mkdirNoFollow(m.opts.Dir, baseName); err != nil {
+		return 0, "", redactAndLogError("Create", err)
+	}
 	f, err := os.OpenFile(partialPath, os.O_CREATE|os.O_RDWR, 0666)
 	if err != nil {
-		return 0, redactAndLogError("Create", err)
+		return 0, "", redactAndLogError("Create", err)
 	}
 	defer func() {
 		f.Close() // best-effort to cleanup dangling file handles
@@ -131,7 +175,7 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 				os.Remove(partialPath) // best-effort
 				return
 			}
-			m.deleter.Insert(filepath.Base(partialPath)) // mark partial file for eventual deletion
+			m.deleter.Insert(partialName) // mark partial file for eventual deletion
 		}
 	}()
 	inFile.w = f
@@ -141,49 +185,73 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 	if offset != 0 {
 		currLength, err := f.Seek(0, io.SeekEnd)
 		if err != nil {
-			return 0, redactAndLogError("Seek", err)
+			return 0, "", redactAndLogError("Seek", err)
 		}
 		if offset < 0 || offset > currLength {
-			return 0, redactAndLogError("Seek", err)
+			return 0, "", redactAndLogError("Seek", err)
 		}
 		if _, err := f.Seek(offset, io.SeekStart); err != nil {
-			return 0, redactAndLogError("Seek", err)
+			return 0, "", redactAndLogError("Seek", err)
 		}
 		if err := f.Truncate(offset); err != nil {
-			return 0, redactAndLogError("Truncate", err)
+			return 0, "", redactAndLogError("Truncate", err)
 		}
 	}
 
 	// Copy the contents of the file.
 	copyLength, err := io.Copy(inFile, r)
 	if err != nil {
-		return 0, redactAndLogError("Copy", err)
+		return 0, "", redactAndLogError("Copy", err)
 	}
 	if length >= 0 && copyLength != length {
-		return 0, redactAndLogError("Copy", errors.New("copied an unexpected number of bytes"))
+		return 0, "", redactAndLogError("Copy", errors.New("copied an unexpected number of bytes"))
 	}
 	if err := f.Close(); err != nil {
-		return 0, redactAndLogError("Close", err)
+		return 0, "", redactAndLogError("Close", err)
 	}
 	fileLength := offset + copyLength
 
+	computePartialSum := sync.OnceValues(func() ([sha256.Size]byte, error) {
+		return sha256File(partialPath)
+	})
+	if wantSum != nil {
+		partialSum, err := computePartialSum()
+		if err != nil {
+			return 0, "", redactAndLogError("Checksum", err)
+		}
+		if partialSum != wantSum.cs {
+			// Resuming is pointless, since we don't know which part is corrupt.
+			os.Remove(partialPath) // best-effort
+			return 0, "", redactAndLogError("Checksum", ErrFileCorrupt)
+		}
+	}
+	// Remember files sent as part of a directory for checking its manifest.
+	var dirFile *receivedDirFile
+	if strings.Contains(baseName, "/") {
+		sum, err := computePartialSum()
+		if err != nil {
+			return 0, "", redactAndLogError("Checksum", err)
+		}
+		dirFile = &receivedDirFile{fileLength, sum}
+	}
+
 	// Return early for avoidPartialRename since users of AvoidFinalRename
 	// are depending on the exact naming of partial files.
 	if avoidPartialRename {
 		inFile.mu.Lock()
 		inFile.done = true
 		inFile.mu.Unlock()
+		if dirFile != nil {
+			m.noteReceivedDirFile(senderID, baseName, *dirFile)
+		}
 		m.totalReceived.Add(1)
 		m.opts.SendFileNotify()
-		return fileLength, nil
+		return fileLength, baseName, nil
 	}
 
 	// File has been successfully received, rename the partial file
 	// to the final destination filename. If a file of that name already exists,
 	// then try multiple times with variations of the filename.
-	computePartialSum := sync.OnceValues(func() ([sha256.Size]byte, error) {
-		return sha256File(partialPath)
-	})
 	maxRetries := 10
 	for ; maxRetries > 0; maxRetries-- {
 		// Atomically rename the partial file as the destination file if it doesn't exist.
@@ -202,7 +270,7 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 			}
 		}()
 		if err != nil {
-			return 0, redactAndLogError("Rename", err)
+			return 0, "", redactAndLogError("Rename", err)
 		}
 		if dstLength < 0 {
 			break // we successfully renamed; so stop
@@ -212,15 +280,15 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 		if dstLength == fileLength {
 			partialSum, err := computePartialSum()
 			if err != nil {
-				return 0, redactAndLogError("Rename", err)
+				return 0, "", redactAndLogError("Rename", err)
 			}
 			dstSum, err := sha256File(dstPath)
 			if err != nil {
-				return 0, redactAndLogError("Rename", err)
+				return 0, "", redactAndLogError("Rename", err)
 			}
 			if dstSum == partialSum {
 				if err := os.Remove(partialPath); err != nil {
-					return 0, redactAndLogError("Remove", err)
+					return 0, "", redactAndLogError("Remove", err)
 				}
 				break // we successfully found a content match; so stop
 			}
@@ -230,11 +298,14 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 		dstPath = NextFilename(dstPath)
 	}
 	if maxRetries <= 0 {
-		return 0, errors.New("too many retries trying to rename partial file")
+		return 0, "", errors.New("too many retries trying to rename partial file")
+	}
+	if dirFile != nil {
+		m.noteReceivedDirFile(senderID, baseName, *dirFile)
 	}
 	m.totalReceived.Add(1)
 	m.opts.SendFileNotify()
-	return fileLength, nil
+	return fileLength, path.Join(path.Dir(baseName), filepath.Base(dstPath)), nil
 }
 
 func sha256File(file string) (out [sha256.Size]byte, err error) {
@@ -12,7 +12,6 @@
 import (
 	"errors"
 	"hash/adler32"
-	"io"
 	"io/fs"
 	"os"
 	"path"
@@ -22,6 +21,7 @@
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"unicode"
 	"unicode/utf8"
 
@@ -37,6 +37,7 @@
 	ErrInvalidFileName = errors.New("invalid filename")
 	ErrFileExists      = errors.New("file already exists")
 	ErrNotAccessible   = errors.New("Taildrop folder not configured or accessible")
+	ErrFileCorrupt     = errors.New("file checksum mismatch")
 )
 
 const (
@@ -117,6 +118,11 @@ type Manager struct {
 	// renameMu is used to protect os.Rename calls so that they are atomic.
 	renameMu sync.Mutex
 
+	// receivedDirFiles are the files received as part of a directory,
+	// whose manifest has not been received yet.
+	receivedDirFilesMu sync.Mutex
+	receivedDirFiles   map[incomingFileKey]receivedDirFile
+
 	// totalReceived counts the cumulative total of received files.
 	totalReceived atomic.Int64
 	// emptySince specifies that there were no waiting files
@@ -175,56 +181,113 @@ func isPartialOrDeleted(s string) bool {
 	return strings.HasSuffix(s, deletedSuffix) || strings.HasSuffix(s, partialSuffix)
 }
 
-func joinDir(dir, baseName string) (fullPath string, err error) {
-	if !utf8.ValidString(baseName) {
+// joinDir joins dir with name, the slash-separated path of a file relative
+// to dir, after validating that name is safe to use on disk.
+// A name is usually a single base filename, but files that were sent as
+// part of a directory have names with several elements (e.g., "photos/1.jpg").
+func joinDir(dir, name string) (fullPath string, err error) {
+	if !utf8.ValidString(name) {
 		return "", ErrInvalidFileName
 	}
-	if strings.TrimSpace(baseName) != baseName {
+	if len(name) > maxNameLength {
 		return "", ErrInvalidFileName
 	}
-	if len(baseName) > 255 {
+	for _, elem := range strings.Split(name, "/") {
+		if !validNameElem(elem) {
+			return "", ErrInvalidFileName
+		}
+	}
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
 		return "", ErrInvalidFileName
 	}
+	return filepath.Join(dir, filepath.FromSlash(name)), nil
+}
+
+// mkdirNoFollow creates the parent directories of the slash-separated
+// name within dir, which must already exist. Unlike [os.MkdirAll],
+// it refuses to traverse an existing element that is not a directory,
+// such as a symlink planted by a local user in [Handler.DirectFileMode],
+// so that files are never written outside of dir.
+func mkdirNoFollow(dir, name string) error {
+	p := dir
+	elems := strings.Split(name, "/")
+	for _, elem := range elems[:len(elems)-1] {
+		p = filepath.Join(p, elem)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			if err := os.Mkdir(p, 0700); err != nil && !os.IsExist(err) {
+				return err
+			}
+			fi, err = os.Lstat(p)
+		}
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: p, Err: syscall.ENOTDIR}
+		}
+	}
+	return nil
+}
+
+// maxNameLength is the maximum length of a name, including the names
+// of its parent directories.
+const maxNameLength = 4096
+
+// validNameElem reports whether elem is a valid element of a name,
+// i.e., the name of a file or of one of its parent directories.
+func validNameElem(elem string) bool {
+	if strings.TrimSpace(elem) != elem {
+		return false
+	}
+	if len(elem) > 255 {
+		return false
+	}
 	// TODO: validate unicode normalization form too? Varies by platform.
-	clean := path.Clean(baseName)
-	if clean != baseName ||
-		clean == "." || clean == ".." ||
-		isPartialOrDeleted(clean) {
-		return "", ErrInvalidFileName
+	if elem == "" || elem == "." || elem == ".." || isPartialOrDeleted(elem) {
+		return false
 	}
-	for _, r := range baseName {
+	for _, r := range elem {
 		if !validFilenameRune(r) {
-			return "", ErrInvalidFileName
+			return false
 		}
 	}
-	if !filepath.IsLocal(baseName) {
-		return "", ErrInvalidFileName
-	}
-	return filepath.Join(dir, baseName), nil
+	return true
 }
 
-// rangeDir iterates over the contents of a directory, calling fn for each entry.
+// walkDir iterates over the files in a directory and its subdirectories,
+// calling fn with the slash-separated name of each file relative to dir.
 // It continues iterating while fn returns true.
-// It reports the number of entries seen.
-func rangeDir(dir string, fn func(fs.DirEntry) bool) error {
-	f, err := os.Open(dir)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	for {
-		des, err := f.ReadDir(10)
-		for _, de := range des {
-			if !fn(de) {
-				return nil
+func walkDir(dir string, fn func(name string, de fs.DirEntry) bool) error {
+	err := filepath.WalkDir(dir, func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			if p != dir && os.IsNotExist(err) {
+				return nil // removed while walking
 			}
+			return err
+		}
+		if de.IsDir() {
+			return nil
 		}
+		name, err := filepath.Rel(dir, p)
 		if err != nil {
-			if err == io.EOF {
-				return nil
-			}
 			return err
 		}
+		if !fn(filepath.ToSlash(name), de) {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return err
+}
+
+// removeEmptyDirs removes the parent directories of the file name
+// relative to dir, from the innermost, while they are empty.
+func removeEmptyDirs(dir, name string) {
+	for d := path.Dir(name); d != "." && d != "/"; d = path.Dir(d) {
+		if os.Remove(filepath.Join(dir, filepath.FromSlash(d))) != nil {
+			return // not empty, or already gone
+		}
 	}
 }
 
@@ -4,9 +4,12 @@
 package taildrop
 
 import (
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"tailscale.com/util/must"
 )
 
 func TestJoinDir(t *testing.T) {
@@ -20,7 +23,13 @@ func TestJoinDir(t *testing.T) {
 		{"foo", "foo", true},
 		{"./foo", "", false},
 		{"../foo", "", false},
-		{"foo/bar", "", false},
+		{"foo/bar", "foo/bar", true},
+		{"foo/../bar", "", false},
+		{"foo//bar", "", false},
+		{"/foo", "", false},
+		{"foo/", "", false},
+		{"foo.partial/bar", "", false},
+		{"foo/ bar", "", false},
 		{"😋", "😋", true},
 		{"\xde\xad\xbe\xef", "", false},
 		{"foo.partial", "", false},
@@ -32,7 +41,7 @@ func TestJoinDir(t *testing.T) {
 		got, gotErr := joinDir(dir, tt.in)
 		got, _ = filepath.Rel(dir, got)
 		gotOk := gotErr == nil
-		if got != tt.want || gotOk != tt.wantOk {
+		if got != filepath.FromSlash(tt.want) || gotOk != tt.wantOk {
 			t.Errorf("joinDir(%q) = (%v, %v), want (%v, %v)", tt.in, got, gotOk, tt.want, tt.wantOk)
 		}
 	}
@@ -66,3 +75,30 @@ func TestNextFilename(t *testing.T) {
 		}
 	}
 }
+
+func TestMoveFile(t *testing.T) {
+	m := ManagerOptions{Logf: t.Logf, Dir: t.TempDir()}.New()
+	defer m.Shutdown()
+	must.Get(m.PutFile("id", "dir/foo", strings.NewReader("hello"), 0, 5))
+
+	dst := filepath.Join(t.TempDir(), "bar")
+	must.Do(os.WriteFile(dst, []byte("keep"), 0644))
+	if err := m.MoveFile("dir/foo", dst); err == nil {
+		t.Fatal("MoveFile replaced an existing file")
+	}
+	if got := must.Get(os.ReadFile(dst)); string(got) != "keep" {
+		t.Fatalf("existing file = %q; want keep", got)
+	}
+	if err := m.MoveFile("dir/foo", "bar"); err == nil {
+		t.Fatal("MoveFile to a relative path succeeded")
+	}
+
+	must.Do(os.Remove(dst))
+	must.Do(m.MoveFile("dir/foo", dst))
+	if got := must.Get(os.ReadFile(dst)); string(got) != "hello" {
+		t.Errorf("moved file = %q; want hello", got)
+	}
+	if _, err := os.Stat(filepath.Join(m.Dir(), "dir")); !os.IsNotExist(err) {
+		t.Errorf("empty directory not removed: %v", err)
+	}
+}
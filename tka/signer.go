@@ -0,0 +1,113 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tka
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"tailscale.com/types/key"
+	"tailscale.com/types/tkatype"
+)
+
+// KeySigner is a tailnet lock key which can sign both AUMs and node-key
+// signatures.
+//
+// key.NLPrivate implements KeySigner with a private key held in memory.
+// Keys whose private part never leaves hardware, such as a PIV smart card
+// that supports ed25519, can implement it with CryptoSigner.
+type KeySigner interface {
+	Signer
+
+	// KeyID returns the ID of the key, as trusted by the authority.
+	KeyID() tkatype.KeyID
+
+	// SignNKS signs the NodeKeySignature identified by the given
+	// NKSSigHash.
+	SignNKS(tkatype.NKSSigHash) ([]byte, error)
+}
+
+var _ KeySigner = key.NLPrivate{}
+
+// CryptoSigner returns a KeySigner which signs with s, such as a signer
+// backed by hardware. The public key of s must be an ed25519 key, as
+// that's the only kind of key supported by tailnet lock, which rules out
+// hardware that only supports P-256 keys, such as TPMs and the macOS
+// Secure Enclave.
+//
+// Each signature made by s is verified before being used, so that a
+// misbehaving signer is caught before its signatures are distributed.
+func CryptoSigner(s crypto.Signer) (KeySigner, error) {
+	pub, ok := s.Public().(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("signer has a %T public key; tailnet lock keys must be ed25519", s.Public())
+	}
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid ed25519 public key length: %d", len(pub))
+	}
+	return cryptoSigner{s: s, pub: pub}, nil
+}
+
+// cryptoSigner is a KeySigner implemented by a crypto.Signer.
+type cryptoSigner struct {
+	s   crypto.Signer
+	pub ed25519.PublicKey
+}
+
+// KeyID implements KeySigner.
+func (s cryptoSigner) KeyID() tkatype.KeyID {
+	// The ID of a 25519 key is its public key.
+	return tkatype.KeyID(s.pub)
+}
+
+// SignAUM implements Signer.
+func (s cryptoSigner) SignAUM(sigHash tkatype.AUMSigHash) ([]tkatype.Signature, error) {
+	sig, err := s.sign(sigHash[:])
+	if err != nil {
+		return nil, err
+	}
+	return []tkatype.Signature{{KeyID: s.KeyID(), Signature: sig}}, nil
+}
+
+// SignNKS implements KeySigner.
+func (s cryptoSigner) SignNKS(sigHash tkatype.NKSSigHash) ([]byte, error) {
+	return s.sign(sigHash[:])
+}
+
+func (s cryptoSigner) sign(msg []byte) ([]byte, error) {
+	// ed25519 signs the message itself rather than a digest of it, which
+	// crypto.Signer expresses as a zero crypto.Hash.
+	sig, err := s.s.Sign(rand.Reader, msg, crypto.Hash(0))
+	if err != nil {
+		return nil, fmt.Errorf("signing: %w", err)
+	}
+	if !ed25519.Verify(s.pub, msg, sig) {
+		return nil, errors.New("signer produced an invalid signature")
+	}
+	return sig, nil
+}
+
+// SignNodeKey returns a direct signature of nodeKey made by signer,
+// authorizing nodeKey. If rotationPublic is non-nil, it's the public key
+// which can sign future rotations of the node key.
+func SignNodeKey(signer KeySigner, nodeKey key.NodePublic, rotationPublic []byte) (NodeKeySignature, error) {
+	p, err := nodeKey.MarshalBinary()
+	if err != nil {
+		return NodeKeySignature{}, err
+	}
+	sig := NodeKeySignature{
+		SigKind:        SigDirect,
+		KeyID:          signer.KeyID(),
+		Pubkey:         p,
+		WrappingPubkey: rotationPublic,
+	}
+	sig.Signature, err = signer.SignNKS(sig.SigHash())
+	if err != nil {
+		return NodeKeySignature{}, fmt.Errorf("signature failed: %w", err)
+	}
+	return sig, nil
+}
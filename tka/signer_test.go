@@ -0,0 +1,116 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tka
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+
+	"tailscale.com/types/key"
+)
+
+// badSigner is a crypto.Signer which produces invalid signatures.
+type badSigner struct {
+	ed25519.PrivateKey
+}
+
+func (s badSigner) Sign(io.Reader, []byte, crypto.SignerOpts) ([]byte, error) {
+	return make([]byte, ed25519.SignatureSize), nil
+}
+
+func TestCryptoSigner(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := CryptoSigner(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s.KeyID(), pub) {
+		t.Errorf("KeyID = %x; want %x", s.KeyID(), pub)
+	}
+	k := Key{Kind: Key25519, Public: pub, Votes: 1}
+
+	node := key.NewNode()
+	sig, err := SignNodeKey(s, node.Public(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sig.verifySignature(node.Public(), k); err != nil {
+		t.Errorf("verifySignature() failed: %v", err)
+	}
+
+	aum := AUM{MessageKind: AUMNoOp}
+	sigs, err := s.SignAUM(aum.SigHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sigs) != 1 {
+		t.Fatalf("got %d AUM signatures; want 1", len(sigs))
+	}
+	if err := signatureVerify(&sigs[0], aum.SigHash(), k); err != nil {
+		t.Errorf("signatureVerify() failed: %v", err)
+	}
+
+	bad, err := CryptoSigner(badSigner{priv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SignNodeKey(bad, node.Public(), nil); err == nil {
+		t.Error("SignNodeKey with a bad signer succeeded")
+	}
+
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CryptoSigner(ec); err == nil {
+		t.Error("CryptoSigner with an ECDSA key succeeded")
+	}
+}
+
+func TestSignNodeKeyMatchesNLPrivate(t *testing.T) {
+	nlPriv := key.NewNLPrivate()
+	node := key.NewNode()
+	viaKey, err := SignNodeKey(nlPriv, node.Public(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	viaCrypto, err := CryptoSigner(nlPrivEd25519(t, nlPriv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	viaHW, err := SignNodeKey(viaCrypto, node.Public(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ed25519 signatures are deterministic, so the same key makes the
+	// same signature either way.
+	if !bytes.Equal(viaKey.Serialize(), viaHW.Serialize()) {
+		t.Error("signatures made with key.NLPrivate and CryptoSigner differ")
+	}
+}
+
+// nlPrivEd25519 returns k as an ed25519.PrivateKey.
+func nlPrivEd25519(t *testing.T, k key.NLPrivate) ed25519.PrivateKey {
+	t.Helper()
+	b, err := k.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := hex.DecodeString(strings.TrimPrefix(string(b), "nlpriv:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ed25519.PrivateKey(priv)
+}
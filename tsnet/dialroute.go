@@ -0,0 +1,177 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/views"
+)
+
+// DialRoute is how DialWithRoute routes a connection. At most one of its
+// fields may be set; the zero value routes as Dial does.
+type DialRoute struct {
+	// ExitNode, if non-empty, is the exit node to route the connection
+	// through, instead of the one in the node's preferences, if any. It's
+	// identified by its stable node ID, Tailscale IP, hostname or MagicDNS
+	// name.
+	ExitNode string
+
+	// Via, if non-empty, is the subnet router to route the connection
+	// through, identified as for ExitNode. It must have a route to the
+	// destination.
+	Via string
+
+	// Direct, if true, dials destinations outside the tailnet from the
+	// host's own network rather than through any exit node.
+	Direct bool
+}
+
+// DialWithRoute is like Dial, but routes the connection as route says, so
+// that, for instance, each connection can egress from a different exit
+// node. Names are resolved as by Dial.
+//
+// Via a subnet router that advertises a 4via6 route to the destination,
+// the connection is dialed to the router's 4via6 address for it, which
+// routes only that connection through the router.
+//
+// Otherwise, since WireGuard routes packets by IP address, the destination
+// IP itself is routed through the peer while the connection is open: all
+// traffic to it, including other connections to it dialed meanwhile, goes
+// through the peer, and dialing it through another exit node or subnet
+// router fails.
+func (s *Server) DialWithRoute(ctx context.Context, network, address string, route DialRoute) (net.Conn, error) {
+	var peerName string
+	switch {
+	case route.ExitNode != "" && route.Via == "" && !route.Direct:
+		peerName = route.ExitNode
+	case route.Via != "" && route.ExitNode == "" && !route.Direct:
+		peerName = route.Via
+	case route.ExitNode != "" || route.Via != "":
+		return nil, errors.New("tsnet: DialRoute may set only one of ExitNode, Via and Direct")
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+	default:
+		return nil, fmt.Errorf("tsnet: unsupported network %q", network)
+	}
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	ipp, err := s.dialer.UserDialResolve(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if peerName == "" {
+		if route.Direct {
+			if p, ok := s.sys.Engine.Get().PeerForIP(ipp.Addr()); !ok || p.Route.Bits() == 0 {
+				// Outside the tailnet, or routed through an exit node.
+				return s.dialer.SystemDial(ctx, network, ipp.String())
+			}
+		}
+		return s.dialer.UserDial(ctx, network, ipp.String())
+	}
+
+	peer, err := s.peerByName(peerName)
+	if err != nil {
+		return nil, err
+	}
+	if tsaddr.IsTailscaleIP(ipp.Addr()) {
+		return nil, fmt.Errorf("tsnet: can't route Tailscale IP %v through a peer", ipp.Addr())
+	}
+	if route.ExitNode != "" {
+		if !tsaddr.ContainsExitRoutes(peer.AllowedIPs()) {
+			return nil, fmt.Errorf("tsnet: %q is not an exit node", peerName)
+		}
+	} else if via, ok := viaAddr(peer, ipp.Addr()); ok {
+		ipp = netip.AddrPortFrom(via, ipp.Port())
+	} else if !views.SliceContainsFunc(peer.PrimaryRoutes(), func(r netip.Prefix) bool {
+		return r.Bits() > 0 && r.Contains(ipp.Addr())
+	}) {
+		return nil, fmt.Errorf("tsnet: %q has no subnet route to %v", peerName, ipp.Addr())
+	}
+
+	release, err := s.lb.AddPeerDialRoute(peer.Key(), ipp.Addr())
+	if err != nil {
+		return nil, fmt.Errorf("tsnet: %w", err)
+	}
+	var c net.Conn
+	if strings.HasPrefix(network, "udp") {
+		c, err = s.dialer.NetstackDialUDP(ctx, ipp)
+	} else {
+		c, err = s.dialer.NetstackDialTCP(ctx, ipp)
+	}
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &routedConn{Conn: c, release: release}, nil
+}
+
+// viaAddr returns the 4via6 address of ip at subnet router peer, if peer
+// advertises a 4via6 route to ip.
+func viaAddr(peer tailcfg.NodeView, ip netip.Addr) (netip.Addr, bool) {
+	if !ip.Is4() {
+		return netip.Addr{}, false
+	}
+	routes := peer.PrimaryRoutes()
+	for i := range routes.LenIter() {
+		r := routes.At(i)
+		if !tsaddr.IsViaPrefix(r) || r.Bits() < 96 {
+			continue
+		}
+		a := r.Addr().As16()
+		siteID := binary.BigEndian.Uint32(a[8:12])
+		if !netip.PrefixFrom(tsaddr.UnmapVia(r.Addr()), r.Bits()-96).Contains(ip) {
+			continue
+		}
+		via, err := tsaddr.MapVia(siteID, netip.PrefixFrom(ip, 32))
+		if err != nil {
+			continue
+		}
+		return via.Addr(), true
+	}
+	return netip.Addr{}, false
+}
+
+// peerByName returns the peer identified by name: its stable node ID, a
+// Tailscale IP, its hostname or its MagicDNS name.
+func (s *Server) peerByName(name string) (tailcfg.NodeView, error) {
+	nm := s.lb.NetMap()
+	if nm == nil {
+		return tailcfg.NodeView{}, errors.New("tsnet: no network map yet; is the server up?")
+	}
+	ip, _ := netip.ParseAddr(name)
+	for _, p := range nm.Peers {
+		if string(p.StableID()) == name ||
+			ip.IsValid() && views.SliceContainsFunc(p.Addresses(), func(a netip.Prefix) bool { return a.IsSingleIP() && a.Addr() == ip }) ||
+			strings.EqualFold(p.Hostinfo().Hostname(), name) ||
+			strings.EqualFold(strings.TrimSuffix(p.Name(), "."), strings.TrimSuffix(name, ".")) {
+			return p, nil
+		}
+	}
+	return tailcfg.NodeView{}, fmt.Errorf("tsnet: no peer %q", name)
+}
+
+// routedConn is a connection dialed by DialWithRoute through a peer,
+// releasing its route when closed.
+type routedConn struct {
+	net.Conn
+	release func() // idempotent
+}
+
+func (c *routedConn) Close() error {
+	err := c.Conn.Close()
+	c.release()
+	return err
+}
@@ -0,0 +1,151 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/views"
+)
+
+// ListenPolicy is an access policy for a listener made with
+// ListenWithPolicy. It's checked for each incoming connection, and
+// connections from peers it doesn't allow are refused, never being
+// returned by the listener's Accept.
+//
+// The zero value allows all peers.
+type ListenPolicy struct {
+	// Caps, if non-empty, are the peer capabilities that the peer must
+	// have all been granted to this node, such as by the tailnet policy
+	// file's grants.
+	Caps []tailcfg.PeerCapability
+
+	// Tags and Users, if either is non-empty, restrict the peers allowed
+	// to those tagged with any of Tags, such as "tag:prod", and the
+	// untagged ones owned by any of Users, identified by login name.
+	Tags  []string
+	Users []string
+}
+
+// check reports whether p allows the peer n, owned by u, with the peer
+// capabilities caps. If not, it returns an error saying why.
+func (p *ListenPolicy) check(n tailcfg.NodeView, u tailcfg.UserProfile, caps tailcfg.PeerCapMap) error {
+	for _, c := range p.Caps {
+		if !caps.HasCapability(c) {
+			return fmt.Errorf("missing peer capability %q", c)
+		}
+	}
+	if len(p.Tags) == 0 && len(p.Users) == 0 {
+		return nil
+	}
+	if tags := n.Tags(); tags.Len() > 0 {
+		if slices.ContainsFunc(p.Tags, func(t string) bool { return views.SliceContains(tags, t) }) {
+			return nil
+		}
+		return fmt.Errorf("tags %v not allowed", tags.AsSlice())
+	}
+	if slices.ContainsFunc(p.Users, func(login string) bool { return strings.EqualFold(login, u.LoginName) }) {
+		return nil
+	}
+	return fmt.Errorf("user %q not allowed", u.LoginName)
+}
+
+// PeerConn is a connection along with the verified identity of the peer
+// at its other end. It's returned by the Accept of listeners made with
+// ListenWithPolicy, and by IdentifyConn.
+type PeerConn struct {
+	net.Conn
+
+	// Node is the peer's node. For a connection from this node itself,
+	// it's this node.
+	Node tailcfg.NodeView
+
+	// UserProfile is the profile of the user owning Node. For tagged
+	// nodes, it's a placeholder for the tagged devices.
+	UserProfile tailcfg.UserProfile
+
+	// CapMap is the peer capabilities that Node has been granted to
+	// this node. See tailcfg.PeerCapMap for details.
+	CapMap tailcfg.PeerCapMap
+}
+
+// ListenWithPolicy is like Listen, but only accepts connections from the
+// peers that policy allows. The connections returned by the listener's
+// Accept are of type *PeerConn, carrying the identity of the peer.
+//
+// Only TCP is supported.
+func (s *Server) ListenWithPolicy(network, addr string, policy ListenPolicy) (net.Listener, error) {
+	switch network {
+	case "", "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("ListenWithPolicy(%q, %q): only tcp is supported", network, addr)
+	}
+	return s.listen(network, addr, listenOnTailnet, &policy)
+}
+
+// IdentifyConn returns c, a connection over the tailnet to one of s's
+// listeners, along with the verified identity of the peer at its other
+// end. It unwraps TLS connections, and returns the PeerConn as-is if c was
+// accepted from a listener made with ListenWithPolicy.
+func (s *Server) IdentifyConn(c net.Conn) (*PeerConn, error) {
+	nc := c
+	if tc, ok := nc.(*tls.Conn); ok {
+		nc = tc.NetConn()
+	}
+	if pc, ok := nc.(*PeerConn); ok {
+		return pc, nil
+	}
+	if s.lb == nil {
+		return nil, errors.New("tsnet: server not started")
+	}
+	src, err := netip.ParseAddrPort(nc.RemoteAddr().String())
+	if err != nil {
+		return nil, fmt.Errorf("tsnet: parsing remote address: %w", err)
+	}
+	pc, err := s.whoIs(src)
+	if err != nil {
+		return nil, err
+	}
+	pc.Conn = c
+	return pc, nil
+}
+
+// whoIs returns the identity of the peer at src, as a PeerConn without
+// its Conn.
+func (s *Server) whoIs(src netip.AddrPort) (*PeerConn, error) {
+	n, u, ok := s.lb.WhoIs(src)
+	if !ok {
+		return nil, fmt.Errorf("tsnet: no peer found for %v", src)
+	}
+	return &PeerConn{
+		Node:        n,
+		UserProfile: u,
+		CapMap:      s.lb.PeerCaps(src.Addr()),
+	}, nil
+}
+
+// policyHandler returns the handler for a connection to ln, which has a
+// policy, from src, or nil if the policy doesn't allow the peer at src.
+func (ln *listener) policyHandler(src netip.AddrPort) func(net.Conn) {
+	id, err := ln.s.whoIs(src)
+	if err == nil {
+		err = ln.policy.check(id.Node, id.UserProfile, id.CapMap)
+	}
+	if err != nil {
+		ln.s.logf("tsnet: refused connection from %v to %s: %v", src, ln.addr, err)
+		return nil
+	}
+	return func(c net.Conn) {
+		pc := *id
+		pc.Conn = c
+		ln.handle(&pc)
+	}
+}
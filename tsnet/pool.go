@@ -0,0 +1,171 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+// PoolMember is a member of the ephemeral node pool of a Server, as seen in
+// its network map. See Server.PoolPrefix.
+type PoolMember struct {
+	Ordinal  int
+	Hostname string
+	NodeID   tailcfg.StableNodeID
+	Online   bool
+	LastSeen time.Time // when last online, or zero if unknown
+	Self     bool      // whether it's the Server itself
+}
+
+// poolJoiningSuffix is the suffix of the hostname that pool members
+// register with, until they have an ordinal.
+const poolJoiningSuffix = "-new"
+
+// poolOrdinal returns the ordinal of hostname in the pool with prefix, or
+// zero if it's not a pool member's hostname.
+func poolOrdinal(prefix, hostname string) int {
+	rest, ok := strings.CutPrefix(strings.ToLower(hostname), strings.ToLower(prefix)+"-")
+	if !ok || rest == "" || rest[0] == '0' || strings.Trim(rest, "0123456789") != "" {
+		return 0
+	}
+	n, err := strconv.Atoi(rest)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// nextPoolOrdinal returns the lowest ordinal in the pool with prefix that
+// none of peers has.
+func nextPoolOrdinal(prefix string, peers []tailcfg.NodeView) int {
+	taken := map[int]bool{}
+	for _, p := range peers {
+		taken[poolOrdinal(prefix, p.Hostinfo().Hostname())] = true
+	}
+	n := 1
+	for taken[n] {
+		n++
+	}
+	return n
+}
+
+// joinPool waits for s's first network map, then renames s from its
+// joining hostname to its pool hostname with the lowest free ordinal. It
+// closes s.poolJoined when done, even if it fails.
+//
+// Nodes joining at once may pick the same ordinal; the control plane then
+// makes their DNS names unique.
+func (s *Server) joinPool() {
+	defer close(s.poolJoined)
+	watcher, err := s.localClient.WatchIPNBus(s.shutdownCtx, ipn.NotifyInitialNetMap|ipn.NotifyNoPrivateKeys)
+	if err != nil {
+		s.logf("tsnet: joining pool: %v", err)
+		return
+	}
+	defer watcher.Close()
+	for {
+		n, err := watcher.Next()
+		if err != nil {
+			s.logf("tsnet: joining pool: %v", err)
+			return
+		}
+		if n.NetMap == nil {
+			continue
+		}
+		ord := nextPoolOrdinal(s.PoolPrefix, n.NetMap.Peers)
+		hostname := fmt.Sprintf("%s-%d", s.PoolPrefix, ord)
+		if _, err := s.lb.EditPrefs(&ipn.MaskedPrefs{
+			Prefs:       ipn.Prefs{Hostname: hostname},
+			HostnameSet: true,
+		}); err != nil {
+			s.logf("tsnet: joining pool: %v", err)
+			return
+		}
+		s.logf("tsnet: joined pool %q as %q", s.PoolPrefix, hostname)
+		return
+	}
+}
+
+// PoolMembers returns the members of s's pool, including s itself once it
+// has its pool hostname, by ordinal.
+func (s *Server) PoolMembers() ([]PoolMember, error) {
+	if s.PoolPrefix == "" {
+		return nil, errors.New("tsnet: not a pool member; PoolPrefix is empty")
+	}
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	nm := s.lb.NetMap()
+	if nm == nil {
+		return nil, errors.New("tsnet: no network map yet; is the server up?")
+	}
+	var members []PoolMember
+	for _, n := range append(slices.Clone(nm.Peers), nm.SelfNode) {
+		if !n.Valid() {
+			continue
+		}
+		ord := poolOrdinal(s.PoolPrefix, n.Hostinfo().Hostname())
+		if ord == 0 {
+			continue
+		}
+		m := PoolMember{
+			Ordinal:  ord,
+			Hostname: n.Hostinfo().Hostname(),
+			NodeID:   n.StableID(),
+			Self:     n.StableID() == nm.SelfNode.StableID(),
+		}
+		if o := n.Online(); o != nil {
+			m.Online = *o
+		}
+		if ls := n.LastSeen(); ls != nil {
+			m.LastSeen = *ls
+		}
+		members = append(members, m)
+	}
+	slices.SortFunc(members, func(a, b PoolMember) int {
+		if a.Ordinal != b.Ordinal {
+			return a.Ordinal - b.Ordinal
+		}
+		return strings.Compare(string(a.NodeID), string(b.NodeID))
+	})
+	return members, nil
+}
+
+// CleanupPool deletes the members of s's pool that have been offline for
+// at least minOffline, such as those left behind by crashed processes
+// before the control plane removed them, and returns them. Members whose
+// last online time is unknown are kept.
+//
+// It uses the Tailscale API as s's node, like APIClient, so it requires
+// tailscale.I_Acknowledge_This_API_Is_Unstable to be set.
+func (s *Server) CleanupPool(ctx context.Context, minOffline time.Duration) ([]PoolMember, error) {
+	members, err := s.PoolMembers()
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.APIClient()
+	if err != nil {
+		return nil, err
+	}
+	var deleted []PoolMember
+	for _, m := range members {
+		if m.Self || m.Online || m.LastSeen.IsZero() || time.Since(m.LastSeen) < minOffline {
+			continue
+		}
+		if err := c.DeleteDevice(ctx, string(m.NodeID)); err != nil {
+			return deleted, fmt.Errorf("tsnet: deleting pool member %q: %w", m.Hostname, err)
+		}
+		deleted = append(deleted, m)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,97 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"tailscale.com/ipn"
+)
+
+// Reauthenticate logs the node in again with a new node key, without
+// restarting s, such as before its key expires.
+//
+// If the control plane requires the node to be authenticated
+// interactively, authURL is called with the URL to visit, and the node
+// may be offline until it's visited. Otherwise, such as with an auth key
+// (see SetAuthKey), authURL isn't called. Reauthenticate returns once
+// the node is running with its new key, or when ctx is done.
+func (s *Server) Reauthenticate(ctx context.Context, authURL func(url string)) error {
+	if authURL == nil {
+		return errors.New("tsnet: Reauthenticate: nil authURL func")
+	}
+	return s.relogin(ctx, authURL)
+}
+
+// RotateNodeKey replaces the node key with a new one on demand, without
+// restarting s. It's like Reauthenticate, but fails instead if the
+// control plane requires interactive authentication, so it's meant for
+// nodes with an auth key, or on tailnets that don't require it.
+func (s *Server) RotateNodeKey(ctx context.Context) error {
+	return s.relogin(ctx, nil)
+}
+
+// SetAuthKey replaces the auth key that s uses when it needs to log in,
+// such as in Reauthenticate, RotateNodeKey, or once its key has expired.
+// It overrides AuthKey and TS_AUTHKEY, which are only read on Start. The
+// connection to the control plane is restarted to log in with the new
+// auth key, but the node keeps its current node key.
+func (s *Server) SetAuthKey(authKey string) error {
+	if authKey == "" {
+		return errors.New("tsnet: SetAuthKey: empty auth key")
+	}
+	if err := s.Start(); err != nil {
+		return err
+	}
+	if err := s.lb.Start(ipn.Options{AuthKey: authKey}); err != nil {
+		return fmt.Errorf("tsnet: restarting with new auth key: %w", err)
+	}
+	return nil
+}
+
+// relogin starts an interactive login, which generates a new node key,
+// and waits for the node to be running with it. If control asks for an
+// auth URL to be visited, it's passed to authURL, or it's an error if
+// authURL is nil.
+func (s *Server) relogin(ctx context.Context, authURL func(string)) error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+	oldKey := s.lb.NodeKey()
+	watcher, err := s.localClient.WatchIPNBus(ctx, ipn.NotifyInitialState|ipn.NotifyNoPrivateKeys)
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	// Consume the initial state, so the login below can't race with
+	// the watcher subscribing.
+	if _, err := watcher.Next(); err != nil {
+		return err
+	}
+	s.lb.StartLoginInteractive()
+	for {
+		n, err := watcher.Next()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if n.ErrMessage != nil {
+			return fmt.Errorf("tsnet: login: %s", *n.ErrMessage)
+		}
+		if n.BrowseToURL != nil && *n.BrowseToURL != "" {
+			if authURL == nil {
+				return fmt.Errorf("tsnet: control requires interactive login at %s; use Reauthenticate", *n.BrowseToURL)
+			}
+			authURL(*n.BrowseToURL)
+		}
+		if nk := s.lb.NodeKey(); nk != oldKey && !nk.IsZero() && s.lb.State() == ipn.Running {
+			s.logf("tsnet: logged in again with node key %v", nk.ShortString())
+			return nil
+		}
+	}
+}
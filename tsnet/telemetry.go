@@ -0,0 +1,76 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"tailscale.com/health"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/clientmetric"
+)
+
+// HealthState is the health of a Server, as returned by Server.Health.
+type HealthState struct {
+	// Warnings are the problems that "tailscale status" shows, such as
+	// not being connected to the control plane.
+	Warnings []string
+
+	// Checks are the results of the registered health checks, as from
+	// the LocalAPI's "health" endpoint.
+	Checks []health.CheckResult
+}
+
+// Health returns the current health of s.
+//
+// Much of it is tracked per process rather than per Server, so multiple
+// Servers in one process share some of their problems.
+func (s *Server) Health(ctx context.Context) (*HealthState, error) {
+	lc, err := s.LocalClient() // calls Start
+	if err != nil {
+		return nil, err
+	}
+	st, err := lc.StatusWithoutPeers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &HealthState{
+		Warnings: st.Health,
+		Checks:   health.RunChecks(),
+	}, nil
+}
+
+// Netcheck runs a netcheck against the DERP servers of s's tailnet, like
+// "tailscale netcheck", and returns the report on the host's network
+// conditions. s must be up, such as after calling Up.
+func (s *Server) Netcheck(ctx context.Context) (*netcheck.Report, error) {
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	nm := s.lb.NetMap()
+	if nm == nil || nm.DERPMap == nil {
+		return nil, errors.New("tsnet: no DERP map for netcheck; is the server up?")
+	}
+	c := &netcheck.Client{
+		Logf:   logger.WithPrefix(s.logf, "netcheck: "),
+		NetMon: s.netMon,
+	}
+	if err := c.Standalone(ctx, ":0"); err != nil {
+		return nil, fmt.Errorf("tsnet: netcheck: %w", err)
+	}
+	return c.GetReport(ctx, nm.DERPMap)
+}
+
+// ClientMetrics returns the client metrics of s, such as the ones that
+// "tailscale debug metrics" prints. To export them to Prometheus, see package
+// tailscale.com/tsnet/tsnetprom.
+//
+// Client metrics are per process rather than per Server, so they're the
+// same for all Servers in one process.
+func (s *Server) ClientMetrics() []*clientmetric.Metric {
+	return clientmetric.Metrics()
+}
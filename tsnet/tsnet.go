@@ -113,11 +113,34 @@ type Server struct {
 	// field at zero unless you know what you are doing.
 	Port uint16
 
+	// PoolPrefix, if non-empty, makes the server a member of a pool of
+	// short-lived nodes sharing the prefix, such as CI jobs or serverless
+	// instances. It implies Ephemeral, and an in-memory Store if Store is
+	// nil, so that each run is a new node. It takes precedence over
+	// Hostname: once the node is up, its hostname becomes the prefix, a
+	// hyphen and the lowest ordinal not used by another member, such as
+	// "ci-runner-3".
+	//
+	// On Close, the node logs out so that it's removed right away. If the
+	// process dies instead, the control plane removes the node once it's
+	// been offline for a while, or CleanupPool can remove it sooner.
+	PoolPrefix string
+
+	// AppConnectorFlowLog, if non-nil, receives the flow log of the app
+	// connector registered with RegisterAppConnector, if it keeps one: a
+	// JSON line for each connection or UDP flow through it that ends.
+	AppConnectorFlowLog io.Writer
+
 	getCertForTesting func(*tls.ClientHelloInfo) (*tls.Certificate, error)
 
+	// controlHTTPClientForTesting, if non-nil, is used to connect to
+	// ControlURL, such as to an in-memory tstest/controlserver.
+	controlHTTPClientForTesting *http.Client
+
 	initOnce         sync.Once
 	initErr          error
 	lb               *ipnlocal.LocalBackend
+	sys              *tsd.System
 	netstack         *netstack.Impl
 	netMon           *netmon.Monitor
 	rootPath         string // the state directory
@@ -133,11 +156,14 @@ type Server struct {
 	logbuffer        *filch.Filch
 	logtail          *logtail.Logger
 	logid            logid.PublicID
+	poolJoined       chan struct{} // closed once joinPool is done, if PoolPrefix is set
 
 	mu                  sync.Mutex
 	listeners           map[listenKey]*listener
 	fallbackTCPHandlers set.HandleSet[FallbackTCPHandler]
+	fallbackUDPHandlers set.HandleSet[FallbackUDPHandler]
 	dialer              *tsdial.Dialer
+	stopProbes          context.CancelFunc // stops probing the app connector's services, or nil
 	closed              bool
 }
 
@@ -154,6 +180,10 @@ type Server struct {
 // over the TCP conn.
 type FallbackTCPHandler func(src, dst netip.AddrPort) (handler func(net.Conn), intercept bool)
 
+// FallbackUDPHandler is like FallbackTCPHandler, but for UDP flows. The
+// handler takes over the packet conn of the flow.
+type FallbackUDPHandler func(src, dst netip.AddrPort) (handler func(nettype.ConnPacketConn), intercept bool)
+
 // Dial connects to the address on the tailnet.
 // It will start the server if it has not been started yet.
 func (s *Server) Dial(ctx context.Context, network, address string) (net.Conn, error) {
@@ -304,8 +334,15 @@ func (s *Server) Up(ctx context.Context) (*ipnstate.Status, error) {
 		if n.ErrMessage != nil {
 			return nil, fmt.Errorf("tsnet.Up: backend: %s", *n.ErrMessage)
 		}
-		if s := n.State; s != nil {
-			if *s == ipn.Running {
+		if st := n.State; st != nil {
+			if *st == ipn.Running {
+				if s.poolJoined != nil {
+					select {
+					case <-s.poolJoined:
+					case <-ctx.Done():
+						return nil, fmt.Errorf("tsnet.Up: %w", ctx.Err())
+					}
+				}
 				status, err := lc.Status(ctx)
 				if err != nil {
 					return nil, fmt.Errorf("tsnet.Up: %w", err)
@@ -364,6 +401,14 @@ func (s *Server) Close() error {
 		}
 	}()
 
+	if s.lb != nil && s.PoolPrefix != "" {
+		// Best effort: remove the pool member now rather than leaving it
+		// for the control plane to remove once it's been offline a while.
+		if err := s.lb.Logout(ctx); err != nil {
+			s.logf("tsnet: logging out of pool: %v", err)
+		}
+	}
+
 	if s.netstack != nil {
 		s.netstack.Close()
 		s.netstack = nil
@@ -471,11 +516,14 @@ func (s *Server) start() (reterr error) {
 	if s.hostname == "" {
 		s.hostname = prog
 	}
+	if s.PoolPrefix != "" {
+		s.hostname = s.PoolPrefix + poolJoiningSuffix
+	}
 
 	s.rootPath = s.Dir
 	if s.Store != nil {
 		_, isMemStore := s.Store.(*mem.Store)
-		if isMemStore && !s.Ephemeral {
+		if isMemStore && !s.ephemeral() {
 			return fmt.Errorf("in-memory store is only supported for Ephemeral nodes")
 		}
 	}
@@ -512,6 +560,7 @@ func (s *Server) start() (reterr error) {
 	closePool.add(s.netMon)
 
 	sys := new(tsd.System)
+	s.sys = sys
 	s.dialer = &tsdial.Dialer{Logf: logf} // mutated below (before used)
 	eng, err := wgengine.NewUserspaceEngine(logf, wgengine.Config{
 		ListenPort:   s.Port,
@@ -543,7 +592,13 @@ func (s *Server) start() (reterr error) {
 	s.dialer.NetstackDialTCP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
 		return ns.DialContextTCP(ctx, dst)
 	}
+	s.dialer.NetstackDialUDP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
+		return ns.DialContextUDP(ctx, dst)
+	}
 
+	if s.Store == nil && s.PoolPrefix != "" {
+		s.Store = new(mem.Store)
+	}
 	if s.Store == nil {
 		stateFile := filepath.Join(s.rootPath, "tailscaled.state")
 		logf("tsnet running state path %s", stateFile)
@@ -555,7 +610,7 @@ func (s *Server) start() (reterr error) {
 	sys.Set(s.Store)
 
 	loginFlags := controlclient.LoginDefault
-	if s.Ephemeral {
+	if s.ephemeral() {
 		loginFlags = controlclient.LoginEphemeral
 	}
 	lb, err := ipnlocal.NewLocalBackend(logf, s.logid, sys, loginFlags|controlclient.LocalBackendStartKeyOSNeutral)
@@ -563,6 +618,9 @@ func (s *Server) start() (reterr error) {
 		return fmt.Errorf("NewLocalBackend: %v", err)
 	}
 	lb.SetTCPHandlerForFunnelFlow(s.getTCPHandlerForFunnelFlow)
+	if s.controlHTTPClientForTesting != nil {
+		lb.SetHTTPTestClient(s.controlHTTPClientForTesting)
+	}
 	lb.SetVarRoot(s.rootPath)
 	logf("tsnet starting with hostname %q, varRoot %q", s.hostname, s.rootPath)
 	s.lb = lb
@@ -608,9 +666,19 @@ func (s *Server) start() (reterr error) {
 		}
 	}()
 	closePool.add(s.localAPIListener)
+
+	if s.PoolPrefix != "" {
+		s.poolJoined = make(chan struct{})
+		go s.joinPool()
+	}
 	return nil
 }
 
+// ephemeral reports whether s registers as an ephemeral node.
+func (s *Server) ephemeral() bool {
+	return s.Ephemeral || s.PoolPrefix != ""
+}
+
 func (s *Server) startLogger(closePool *closeOnErrorPool) error {
 	if testenv.InTest() {
 		return nil
@@ -781,12 +849,23 @@ func (s *Server) getTCPHandlerForFlow(src, dst netip.AddrPort) (handler func(net
 		}
 		return nil, true // don't handle, don't forward to localhost
 	}
+	if ln.policy != nil {
+		return ln.policyHandler(src), true
+	}
 	return ln.handle, true
 }
 
 func (s *Server) getUDPHandlerForFlow(src, dst netip.AddrPort) (handler func(nettype.ConnPacketConn), intercept bool) {
 	ln, ok := s.listenerForDstAddr("udp", dst, false)
 	if !ok {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, handler := range s.fallbackUDPHandlers {
+			connHandler, intercept := handler(src, dst)
+			if intercept {
+				return connHandler, intercept
+			}
+		}
 		return nil, true // don't handle, don't forward to localhost
 	}
 	return func(c nettype.ConnPacketConn) { ln.handle(c) }, true
@@ -854,7 +933,59 @@ func (s *Server) APIClient() (*tailscale.Client, error) {
 // Listen announces only on the Tailscale network.
 // It will start the server if it has not been started yet.
 func (s *Server) Listen(network, addr string) (net.Listener, error) {
-	return s.listen(network, addr, listenOnTailnet)
+	return s.listen(network, addr, listenOnTailnet, nil)
+}
+
+// ListenPacket announces a UDP socket on the Tailscale network, for
+// servers that handle datagrams from many peers on one socket, such as
+// DNS, syslog or QUIC servers. Listen with a "udp" network instead returns
+// a connection per peer address.
+//
+// The network must be "udp", "udp4" or "udp6". The host part of addr must
+// be one of s's Tailscale IPs or empty, meaning its IPv4 address, or its
+// IPv6 address for "udp6". Either way, s must already be up, such as after
+// calling Up.
+func (s *Server) ListenPacket(network, addr string) (net.PacketConn, error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+	default:
+		return nil, fmt.Errorf("ListenPacket(%q, %q): only udp is supported", network, addr)
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("tsnet: %w", err)
+	}
+	port, err := net.LookupPort(network, portStr)
+	if err != nil || port < 0 || port > math.MaxUint16 {
+		return nil, fmt.Errorf("invalid port: %w", err)
+	}
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+
+	ip4, ip6 := s.TailscaleIPs()
+	var ip netip.Addr
+	switch {
+	case host == "" && network == "udp6":
+		ip = ip6
+	case host == "":
+		ip = ip4
+	default:
+		ip, err = netip.ParseAddr(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ListenPacket addr %q; host part must be empty or IP literal", host)
+		}
+		if ip != ip4 && ip != ip6 {
+			return nil, fmt.Errorf("tsnet: %v is not a Tailscale IP of this node", ip)
+		}
+	}
+	if !ip.IsValid() {
+		return nil, errors.New("tsnet: no Tailscale IP for ListenPacket; is the server up?")
+	}
+	if network == "udp4" && !ip.Is4() || network == "udp6" && !ip.Is6() {
+		return nil, fmt.Errorf("invalid addr %v for network %q", ip, network)
+	}
+	return s.netstack.ListenPacket(netip.AddrPortFrom(ip, uint16(port)))
 }
 
 // ListenTLS announces only on the Tailscale network.
@@ -873,7 +1004,7 @@ func (s *Server) ListenTLS(network, addr string) (net.Listener, error) {
 		return nil, errors.New("tsnet: you must enable HTTPS in the admin panel to proceed. See https://tailscale.com/s/https")
 	}
 
-	ln, err := s.listen(network, addr, listenOnTailnet)
+	ln, err := s.listen(network, addr, listenOnTailnet, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -900,6 +1031,106 @@ func (s *Server) RegisterFallbackTCPHandler(cb FallbackTCPHandler) func() {
 	}
 }
 
+// RegisterFallbackUDPHandler is like RegisterFallbackTCPHandler, but
+// registers a callback for UDP flows.
+func (s *Server) RegisterFallbackUDPHandler(cb FallbackUDPHandler) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hnd := s.fallbackUDPHandlers.Add(cb)
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.fallbackUDPHandlers, hnd)
+	}
+}
+
+// appConnectorProbeInterval is how often the services of an app connector
+// registered with RegisterAppConnector are probed.
+const appConnectorProbeInterval = 5 * time.Minute
+
+// appConnectorProber is implemented by app connectors, such as
+// *appc.Server, which can probe their own services.
+type appConnectorProber interface {
+	SetProbeDialer(func(ctx context.Context, network, address string) (net.Conn, error))
+	RunProbes(ctx context.Context, interval time.Duration)
+}
+
+// RegisterAppConnector makes the configuration of ac, typically an
+// *appc.Server, manageable through the LocalAPI of s, starting s if needed.
+// If ac can probe its services, s has it do so periodically until s is
+// closed or another app connector is registered.
+func (s *Server) RegisterAppConnector(ac ipnlocal.AppConnector) error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+	s.lb.SetAppConnector(ac)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopProbes != nil {
+		s.stopProbes()
+		s.stopProbes = nil
+	}
+	if fl, ok := ac.(interface{ SetFlowLog(io.Writer) }); ok && s.AppConnectorFlowLog != nil {
+		fl.SetFlowLog(s.AppConnectorFlowLog)
+	}
+	if p, ok := ac.(appConnectorProber); ok {
+		ctx, cancel := context.WithCancel(s.shutdownCtx)
+		s.stopProbes = cancel
+		p.SetProbeDialer(s.dialLocalFlow)
+		go p.RunProbes(ctx, appConnectorProbeInterval)
+	}
+	return nil
+}
+
+// dialLocalFlow connects to address as a tailnet client of this node would,
+// from its own Tailscale IP, handing the other end of the connection to the
+// listener or fallback handler of the flow. It's used by app connectors to
+// probe their own services, which they can't reach through the tunnel.
+func (s *Server) dialLocalFlow(ctx context.Context, network, address string) (net.Conn, error) {
+	if network != "tcp" {
+		return nil, fmt.Errorf("dialLocalFlow: unsupported network %q", network)
+	}
+	dst, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ip4, ip6 := s.TailscaleIPs()
+	srcIP := ip4
+	if dst.Addr().Is6() {
+		srcIP = ip6
+	}
+	if !srcIP.IsValid() {
+		return nil, fmt.Errorf("dialLocalFlow: no Tailscale IP to dial %v from", dst)
+	}
+	src := netip.AddrPortFrom(srcIP, 0)
+	handler, intercept := s.getTCPHandlerForFlow(src, dst)
+	if !intercept || handler == nil {
+		return nil, fmt.Errorf("dialLocalFlow: connection to %v refused", dst)
+	}
+	c, hc := net.Pipe()
+	go handler(&flowConn{
+		Conn:   hc,
+		local:  net.TCPAddrFromAddrPort(dst),
+		remote: net.TCPAddrFromAddrPort(src),
+	})
+	return &flowConn{
+		Conn:   c,
+		local:  net.TCPAddrFromAddrPort(src),
+		remote: net.TCPAddrFromAddrPort(dst),
+	}, nil
+}
+
+// flowConn is an in-memory net.Conn with the addresses of the TCP flow it
+// stands in for.
+type flowConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c *flowConn) LocalAddr() net.Addr  { return c.local }
+func (c *flowConn) RemoteAddr() net.Addr { return c.remote }
+
 // getCert is the GetCertificate function used by ListenTLS.
 //
 // It calls GetCertificate on the localClient, passing in the ClientHelloInfo.
@@ -999,7 +1230,7 @@ func (s *Server) ListenFunnel(network, addr string, opts ...FunnelOption) (net.L
 			lnOn = listenOnFunnel
 		}
 	}
-	ln, err := s.listen(network, addr, lnOn)
+	ln, err := s.listen(network, addr, lnOn, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1016,7 +1247,9 @@ func (s *Server) ListenFunnel(network, addr string, opts ...FunnelOption) (net.L
 	listenOnBoth    = listenOn("listen-on-both")
 )
 
-func (s *Server) listen(network, addr string, lnOn listenOn) (net.Listener, error) {
+// listen returns a listener on network and addr. If policy is non-nil,
+// connections from peers it doesn't allow are refused.
+func (s *Server) listen(network, addr string, lnOn listenOn, policy *ListenPolicy) (net.Listener, error) {
 	switch network {
 	case "", "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
 	default:
@@ -1063,9 +1296,10 @@ func (s *Server) listen(network, addr string, lnOn listenOn) (net.Listener, erro
 	}
 
 	ln := &listener{
-		s:    s,
-		keys: keys,
-		addr: addr,
+		s:      s,
+		keys:   keys,
+		addr:   addr,
+		policy: policy,
 
 		conn: make(chan net.Conn),
 	}
@@ -1097,6 +1331,7 @@ type listener struct {
 	s      *Server
 	keys   []listenKey
 	addr   string
+	policy *ListenPolicy // or nil to allow all peers
 	conn   chan net.Conn
 	closed bool // guarded by s.mu
 }
@@ -35,8 +35,11 @@
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/net/netns"
+	"tailscale.com/net/tsaddr"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstest"
+	"tailscale.com/tstest/controlserver"
+	"tailscale.com/tstest/deptest"
 	"tailscale.com/tstest/integration"
 	"tailscale.com/tstest/integration/testcontrol"
 	"tailscale.com/types/logger"
@@ -96,6 +99,10 @@ func TestListenerPort(t *testing.T) {
 var verboseNodes = flag.Bool("verbose-nodes", false, "if set, print tsnet.Server logs")
 
 func startControl(t *testing.T) (controlURL string) {
+	return startControlServer(t).HTTPTestServer.URL
+}
+
+func startControlServer(t *testing.T) *testcontrol.Server {
 	// Corp#4520: don't use netns for tests.
 	netns.SetEnabled(false)
 	t.Cleanup(func() {
@@ -117,9 +124,8 @@ func startControl(t *testing.T) (controlURL string) {
 	control.HTTPTestServer = httptest.NewUnstartedServer(control)
 	control.HTTPTestServer.Start()
 	t.Cleanup(control.HTTPTestServer.Close)
-	controlURL = control.HTTPTestServer.URL
-	t.Logf("testcontrol listening on %s", controlURL)
-	return controlURL
+	t.Logf("testcontrol listening on %s", control.HTTPTestServer.URL)
+	return control
 }
 
 type testCertIssuer struct {
@@ -225,6 +231,36 @@ func startServer(t *testing.T, ctx context.Context, controlURL, hostname string)
 	return s, status.TailscaleIPs[0]
 }
 
+func TestInMemoryControl(t *testing.T) {
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	netns.SetEnabled(false)
+	t.Cleanup(func() {
+		netns.SetEnabled(true)
+	})
+	control := controlserver.New(t)
+	control.DERPMap = integration.RunDERPAndSTUN(t, logger.Discard, "127.0.0.1")
+
+	s := &Server{
+		Dir:                         t.TempDir(),
+		ControlURL:                  control.URL(),
+		Hostname:                    "s1",
+		Store:                       new(mem.Store),
+		Ephemeral:                   true,
+		Logf:                        logger.Discard,
+		controlHTTPClientForTesting: control.HTTPClient(),
+	}
+	defer s.Close()
+	if _, err := s.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := control.NumNodes(); n != 1 {
+		t.Errorf("control has %d nodes, want 1", n)
+	}
+}
+
 func TestConn(t *testing.T) {
 	tstest.ResourceCheck(t)
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -673,3 +709,493 @@ func TestFallbackTCPHandler(t *testing.T) {
 		t.Errorf("s1TcpConnCount = %d, want %d", got, 1)
 	}
 }
+
+func TestListenWithPolicy(t *testing.T) {
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL := startControl(t)
+	s1, s1ip := startServer(t, ctx, controlURL, "s1")
+	s2, _ := startServer(t, ctx, controlURL, "s2")
+
+	lc2, err := s2.LocalClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := lc2.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := st.User[st.Self.UserID].LoginName
+
+	// ping to make sure the connection is up.
+	if _, err := lc2.Ping(ctx, s1ip, tailcfg.PingICMP); err != nil {
+		t.Fatal(err)
+	}
+
+	allowed, err := s1.ListenWithPolicy("tcp", ":8081", ListenPolicy{Users: []string{strings.ToUpper(login)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer allowed.Close()
+	denied, err := s1.ListenWithPolicy("tcp", ":8082", ListenPolicy{Tags: []string{"tag:prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer denied.Close()
+	if _, err := s1.ListenWithPolicy("udp", ":8083", ListenPolicy{}); err == nil {
+		t.Error("ListenWithPolicy on udp succeeded")
+	}
+
+	if _, err := s2.Dial(ctx, "tcp", fmt.Sprintf("%s:8082", s1ip)); err == nil {
+		t.Error("dial to listener with denying policy succeeded")
+	}
+
+	w, err := s2.Dial(ctx, "tcp", fmt.Sprintf("%s:8081", s1ip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	c, err := allowed.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	pc, ok := c.(*PeerConn)
+	if !ok {
+		t.Fatalf("accepted %T; want *PeerConn", c)
+	}
+	if got := pc.UserProfile.LoginName; got != login {
+		t.Errorf("peer login = %q; want %q", got, login)
+	}
+	if got, want := pc.Node.StableID(), st.Self.ID; got != want {
+		t.Errorf("peer node = %v; want %v", got, want)
+	}
+	if got, err := s1.IdentifyConn(c); err != nil || got != pc {
+		t.Errorf("IdentifyConn = %v, %v; want the PeerConn", got, err)
+	}
+}
+
+func TestListenPacket(t *testing.T) {
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL := startControl(t)
+	s1, s1ip := startServer(t, ctx, controlURL, "s1")
+	s2, _ := startServer(t, ctx, controlURL, "s2")
+
+	if _, err := s1.ListenPacket("tcp", ":53"); err == nil {
+		t.Error("ListenPacket on tcp succeeded")
+	}
+	if _, err := s1.ListenPacket("udp", "127.0.0.1:53"); err == nil {
+		t.Error("ListenPacket on a non-Tailscale IP succeeded")
+	}
+	pc, err := s1.ListenPacket("udp", ":5353")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	// Two peer sockets, both served by the one PacketConn.
+	for i := 0; i < 2; i++ {
+		w, err := s2.Dial(ctx, "udp", fmt.Sprintf("%s:5353", s1ip))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		want := fmt.Sprintf("hello %d", i)
+		if _, err := io.WriteString(w, want); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 1500)
+		pc.SetReadDeadline(time.Now().Add(10 * time.Second))
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if _, err := pc.WriteTo([]byte("re: "+want), from); err != nil {
+			t.Fatal(err)
+		}
+		w.SetReadDeadline(time.Now().Add(10 * time.Second))
+		n, err = w.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != "re: "+want {
+			t.Errorf("reply = %q, want %q", got, "re: "+want)
+		}
+	}
+}
+
+func TestForwardUDP(t *testing.T) {
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL := startControl(t)
+	s1, s1ip := startServer(t, ctx, controlURL, "s1")
+	s2, _ := startServer(t, ctx, controlURL, "s2")
+
+	// An echo server on localhost to forward to.
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], from)
+		}
+	}()
+
+	fwd, err := s1.ForwardUDP(":5354", echo.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fwd.Close()
+
+	w, err := s2.Dial(ctx, "udp", fmt.Sprintf("%s:5354", s1ip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	want := "hello"
+	if _, err := io.WriteString(w, want); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	w.SetReadDeadline(time.Now().Add(10 * time.Second))
+	n, err := w.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPoolOrdinal(t *testing.T) {
+	tests := []struct {
+		hostname string
+		want     int
+	}{
+		{"ci-1", 1},
+		{"CI-12", 12},
+		{"ci-new", 0},
+		{"ci-0", 0},
+		{"ci-01", 0},
+		{"ci-+1", 0},
+		{"ci-", 0},
+		{"ci", 0},
+		{"cider-1", 0},
+	}
+	for _, tt := range tests {
+		if got := poolOrdinal("ci", tt.hostname); got != tt.want {
+			t.Errorf("poolOrdinal(%q) = %d; want %d", tt.hostname, got, tt.want)
+		}
+	}
+}
+
+func TestPool(t *testing.T) {
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL := startControl(t)
+	startMember := func(wantOrdinal int) *Server {
+		t.Helper()
+		s := &Server{
+			Dir:        t.TempDir(),
+			ControlURL: controlURL,
+			PoolPrefix: "ci",
+		}
+		if !*verboseNodes {
+			s.Logf = logger.Discard
+		}
+		t.Cleanup(func() { s.Close() })
+		if _, err := s.Up(ctx); err != nil {
+			t.Fatal(err)
+		}
+		// Wait for the new hostname to reach control and come back.
+		for {
+			members, err := s.PoolMembers()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(members) == wantOrdinal && members[wantOrdinal-1].Self && members[wantOrdinal-1].Ordinal == wantOrdinal {
+				return s
+			}
+			if ctx.Err() != nil {
+				t.Fatalf("pool members = %+v; want self with ordinal %d", members, wantOrdinal)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	startMember(1)
+	startMember(2)
+}
+
+func TestHealthAndNetcheck(t *testing.T) {
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL := startControl(t)
+	s1, _ := startServer(t, ctx, controlURL, "s1")
+
+	hs, err := s1.Health(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("health: %+v", hs)
+
+	report, err := s1.Netcheck(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.UDP {
+		t.Errorf("netcheck report = %+v; want UDP", report)
+	}
+
+	if len(s1.ClientMetrics()) == 0 {
+		t.Error("no client metrics")
+	}
+}
+
+func TestDialWithRoute(t *testing.T) {
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	control := startControlServer(t)
+	controlURL := control.HTTPTestServer.URL
+	exit, _ := startServer(t, ctx, controlURL, "exit")
+	s2, _ := startServer(t, ctx, controlURL, "s2")
+
+	// Make exit an exit node that handles the flows it forwards itself.
+	exit.netstack.ProcessSubnets = true
+	exitRoutes := []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")}
+	if _, err := exit.lb.EditPrefs(&ipn.MaskedPrefs{
+		Prefs:              ipn.Prefs{AdvertiseRoutes: exitRoutes},
+		AdvertiseRoutesSet: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !control.SetSubnetRoutes(exit.lb.NodeKey(), exitRoutes) {
+		t.Fatal("SetSubnetRoutes failed")
+	}
+	for {
+		if p, err := s2.peerByName("exit"); err == nil && p.AllowedIPs().Len() > 2 {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatal("exit node routes never reached s2")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ln, err := exit.Listen("tcp", ":8081")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	if _, err := s2.DialWithRoute(ctx, "tcp", "203.0.113.1:8081", DialRoute{ExitNode: "exit", Direct: true}); err == nil {
+		t.Error("dial with ExitNode and Direct succeeded")
+	}
+	if _, err := s2.DialWithRoute(ctx, "tcp", "203.0.113.1:8081", DialRoute{Via: "exit"}); err == nil {
+		t.Error("dial via a peer without a subnet route succeeded")
+	}
+	if _, err := s2.DialWithRoute(ctx, "tcp", "203.0.113.1:8081", DialRoute{ExitNode: "nope"}); err == nil {
+		t.Error("dial through an unknown exit node succeeded")
+	}
+
+	w, err := s2.DialWithRoute(ctx, "tcp", "203.0.113.1:8081", DialRoute{ExitNode: "exit"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got, want := r.LocalAddr().String(), "203.0.113.1:8081"; got != want {
+		t.Errorf("exit node accepted connection to %v; want %v", got, want)
+	}
+	want := "hello"
+	if _, err := io.WriteString(w, want); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	w.Close()
+	if _, ok := s2.sys.Engine.Get().PeerForIP(netip.MustParseAddr("203.0.113.1")); ok {
+		t.Error("route to 203.0.113.1 remains after closing the connection")
+	}
+}
+
+func TestViaAddr(t *testing.T) {
+	via, err := tsaddr.MapVia(7, netip.MustParsePrefix("192.0.2.0/24"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := (&tailcfg.Node{
+		PrimaryRoutes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), via},
+	}).View()
+	tests := []struct {
+		ip   string
+		want string // or empty for none
+	}{
+		{"192.0.2.5", "fd7a:115c:a1e0:b1a:0:7:c000:205"},
+		{"192.0.3.5", ""},
+		{"10.1.2.3", ""}, // plain subnet route
+		{"2001:db8::1", ""},
+	}
+	for _, tt := range tests {
+		got, ok := viaAddr(peer, netip.MustParseAddr(tt.ip))
+		if tt.want == "" {
+			if ok {
+				t.Errorf("viaAddr(%s) = %v; want none", tt.ip, got)
+			}
+			continue
+		}
+		if !ok || got != netip.MustParseAddr(tt.want) {
+			t.Errorf("viaAddr(%s) = %v, %v; want %s", tt.ip, got, ok, tt.want)
+		}
+	}
+}
+
+func TestReauthenticate(t *testing.T) {
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	control := startControlServer(t)
+	control.RequireAuth = true
+	s := &Server{
+		Dir:        filepath.Join(t.TempDir(), "s"),
+		ControlURL: control.HTTPTestServer.URL,
+		Hostname:   "s",
+		Store:      new(mem.Store),
+		Ephemeral:  true,
+	}
+	if !*verboseNodes {
+		s.Logf = logger.Discard
+	}
+	defer s.Close()
+
+	var urls []string
+	completeAuth := func(url string) {
+		urls = append(urls, url)
+		if !control.CompleteAuth(url) {
+			t.Errorf("CompleteAuth(%q) failed", url)
+		}
+	}
+	if err := s.Reauthenticate(ctx, completeAuth); err != nil {
+		t.Fatal(err)
+	}
+	key1 := s.lb.NodeKey()
+	if key1.IsZero() {
+		t.Fatal("no node key after login")
+	}
+
+	if err := s.RotateNodeKey(ctx); err == nil {
+		t.Error("RotateNodeKey succeeded despite interactive login being required")
+	}
+
+	if err := s.Reauthenticate(ctx, completeAuth); err != nil {
+		t.Fatal(err)
+	}
+	if key2 := s.lb.NodeKey(); key2 == key1 {
+		t.Error("node key unchanged after Reauthenticate")
+	}
+	if len(urls) < 2 {
+		t.Errorf("got auth URLs %q; want at least 2", urls)
+	}
+}
+
+func TestRotateNodeKeyAndSetAuthKey(t *testing.T) {
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	const authKey = "tskey-test-one"
+	control := startControlServer(t)
+	control.RequireAuthKey = authKey
+	s := &Server{
+		Dir:        filepath.Join(t.TempDir(), "s"),
+		ControlURL: control.HTTPTestServer.URL,
+		Hostname:   "s",
+		Store:      new(mem.Store),
+		Ephemeral:  true,
+		AuthKey:    authKey,
+	}
+	if !*verboseNodes {
+		s.Logf = logger.Discard
+	}
+	defer s.Close()
+	if _, err := s.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	key1 := s.lb.NodeKey()
+	if err := s.RotateNodeKey(ctx); err != nil {
+		t.Fatal(err)
+	}
+	key2 := s.lb.NodeKey()
+	if key2 == key1 {
+		t.Error("node key unchanged after RotateNodeKey")
+	}
+
+	// With an auth key that control rejects, the key can't be rotated.
+	if err := s.SetAuthKey("tskey-test-bad"); err != nil {
+		t.Fatal(err)
+	}
+	shortCtx, shortCancel := context.WithTimeout(ctx, time.Second)
+	defer shortCancel()
+	if err := s.RotateNodeKey(shortCtx); err == nil {
+		t.Error("RotateNodeKey succeeded with a bad auth key")
+	}
+
+	if err := s.SetAuthKey(authKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RotateNodeKey(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if key3 := s.lb.NodeKey(); key3 == key2 || key3 == key1 {
+		t.Error("node key unchanged after RotateNodeKey with a new auth key")
+	}
+}
+
+func TestDeps(t *testing.T) {
+	deptest.DepChecker{
+		// Fixed so the source size budgets don't depend on the host.
+		GOOS:    "linux",
+		GOARCH:  "amd64",
+		MaxDeps: 650,
+		SizeBudgets: map[string]int64{
+			"tailscale.com/...": 3_660_000,
+			"golang.org/...":    3_000_000,
+			"github.com/...":    10_000_000,
+			"gvisor.dev/...":    2_850_000,
+		},
+	}.Check(t)
+}
@@ -0,0 +1,47 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package tsnetprom exports the client metrics of a tsnet.Server to
+// Prometheus. It's separate from tsnet so that programs not using
+// Prometheus don't depend on it.
+package tsnetprom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"tailscale.com/tsnet"
+	"tailscale.com/util/clientmetric"
+)
+
+// Register registers a collector of the client metrics of s into reg,
+// under their own names, such as "magicsock_send_udp". To add a prefix,
+// wrap reg with prometheus.WrapRegistererWithPrefix.
+//
+// Client metrics are per process, so Register should be called for only
+// one Server in a process per registry.
+func Register(reg prometheus.Registerer, s *tsnet.Server) error {
+	return reg.Register(collector{s})
+}
+
+// collector is a prometheus.Collector of the client metrics of a Server.
+//
+// It's unchecked, as in it describes no metrics up front, because the set
+// of client metrics grows as the Server's subsystems start.
+type collector struct {
+	s *tsnet.Server
+}
+
+func (collector) Describe(chan<- *prometheus.Desc) {}
+
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c.s.ClientMetrics() {
+		typ := prometheus.UntypedValue
+		switch m.Type() {
+		case clientmetric.TypeCounter:
+			typ = prometheus.CounterValue
+		case clientmetric.TypeGauge:
+			typ = prometheus.GaugeValue
+		}
+		desc := prometheus.NewDesc(m.Name(), "Tailscale client metric "+m.Name(), nil, nil)
+		ch <- prometheus.MustNewConstMetric(desc, typ, float64(m.Value()))
+	}
+}
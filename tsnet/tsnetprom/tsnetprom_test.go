@@ -0,0 +1,37 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnetprom
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"tailscale.com/tsnet"
+	"tailscale.com/util/clientmetric"
+)
+
+func TestRegister(t *testing.T) {
+	counter := clientmetric.NewCounter("tsnetprom_test_counter")
+	counter.Add(3)
+	gauge := clientmetric.NewGauge("tsnetprom_test_gauge")
+	gauge.Set(-2)
+
+	reg := prometheus.NewRegistry()
+	if err := Register(reg, new(tsnet.Server)); err != nil {
+		t.Fatal(err)
+	}
+	want := `
+# HELP tsnetprom_test_counter Tailscale client metric tsnetprom_test_counter
+# TYPE tsnetprom_test_counter counter
+tsnetprom_test_counter 3
+# HELP tsnetprom_test_gauge Tailscale client metric tsnetprom_test_gauge
+# TYPE tsnetprom_test_gauge gauge
+tsnetprom_test_gauge -2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "tsnetprom_test_counter", "tsnetprom_test_gauge"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"tailscale.com/util/set"
+)
+
+// udpForwardIdleTimeout is how long ForwardUDP keeps forwarding a flow
+// without datagrams in either direction.
+const udpForwardIdleTimeout = 2 * time.Minute
+
+// ForwardUDP forwards the UDP datagrams arriving at addr on the Tailscale
+// network to target, a host:port this process can reach, such as a DNS or
+// syslog server on localhost, and forwards the replies back. Each peer
+// address gets its own socket to target, closed after two minutes without
+// datagrams either way.
+//
+// It will start the server if it has not been started yet. Forwarding
+// stops when the returned io.Closer is closed.
+func (s *Server) ForwardUDP(addr, target string) (io.Closer, error) {
+	ln, err := s.Listen("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	f := &udpForwarder{s: s, ln: ln, target: target}
+	go f.run()
+	return f, nil
+}
+
+type udpForwarder struct {
+	s      *Server
+	ln     net.Listener
+	target string
+
+	mu     sync.Mutex
+	flows  set.HandleSet[net.Conn] // the conns of the active flows, to close
+	closed bool
+}
+
+func (f *udpForwarder) run() {
+	for {
+		c, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.forward(c)
+	}
+}
+
+// forward forwards the flow of c until it's idle or f is closed.
+func (f *udpForwarder) forward(c net.Conn) {
+	defer c.Close()
+	bc, err := net.Dial("udp", f.target)
+	if err != nil {
+		f.s.logf("tsnet: forwarding UDP from %v: %v", c.RemoteAddr(), err)
+		return
+	}
+	defer bc.Close()
+
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return
+	}
+	hc, hbc := f.flows.Add(c), f.flows.Add(bc)
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.flows, hc)
+		delete(f.flows, hbc)
+	}()
+
+	timer := time.AfterFunc(udpForwardIdleTimeout, func() {
+		c.Close()
+		bc.Close()
+	})
+	defer timer.Stop()
+	extend := func() { timer.Reset(udpForwardIdleTimeout) }
+
+	done := make(chan struct{}, 2)
+	go copyDatagrams(bc, c, extend, done)
+	go copyDatagrams(c, bc, extend, done)
+	<-done // the other copy ends once the conns are closed
+}
+
+// copyDatagrams copies datagrams from src to dst, calling extend after
+// each, until either fails. It then sends on done.
+func copyDatagrams(dst, src net.Conn, extend func(), done chan<- struct{}) {
+	defer func() { done <- struct{}{} }()
+	buf := make([]byte, 65535)
+	for {
+		n, err := src.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := dst.Write(buf[:n]); err != nil {
+			return
+		}
+		extend()
+	}
+}
+
+// Close stops f, closing its listener and its active flows.
+func (f *udpForwarder) Close() error {
+	f.mu.Lock()
+	f.closed = true
+	for _, c := range f.flows {
+		c.Close()
+	}
+	f.mu.Unlock()
+	return f.ln.Close()
+}
@@ -0,0 +1,142 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package controlserver provides a scriptable fake control plane for tests
+// that runs in memory, without network access.
+package controlserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/net/memnet"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest/integration/testcontrol"
+	"tailscale.com/types/key"
+)
+
+// addr is the address of the in-memory listener.
+const addr = "control.test:80"
+
+// Server is a testcontrol.Server served over an in-memory listener, with
+// methods to script what it tells its clients.
+//
+// Clients must connect with HTTPClient, such as by passing it to
+// LocalBackend.SetHTTPTestClient or as controlclient.Options.HTTPTestClient,
+// or with Dial.
+type Server struct {
+	*testcontrol.Server
+
+	ln *memnet.Listener
+
+	mu        sync.Mutex
+	throttle  int // number of upcoming register and map requests to reject
+	throttled int // number of requests rejected so far
+}
+
+// New returns a new Server, which is closed when t finishes. Its embedded
+// testcontrol.Server may be configured before clients connect.
+func New(t testing.TB) *Server {
+	s := &Server{
+		Server: &testcontrol.Server{
+			ExplicitBaseURL: "http://" + strings.TrimSuffix(addr, ":80"),
+			Logf:            t.Logf,
+		},
+		ln: memnet.Listen(addr),
+	}
+	hs := &http.Server{Handler: s}
+	go hs.Serve(s.ln)
+	t.Cleanup(func() {
+		hs.Close()
+		s.ln.Close()
+	})
+	return s
+}
+
+// URL returns the URL of the server, to use as the control URL of its
+// clients.
+func (s *Server) URL() string {
+	return s.BaseURL()
+}
+
+// Dial connects to the server in memory, regardless of network and address.
+func (s *Server) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	return s.ln.Dial(ctx, "tcp", addr)
+}
+
+// HTTPClient returns an HTTP client that connects to the server in memory.
+func (s *Server) HTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: s.Dial,
+			// Like controlclient's own transport, leave compression
+			// to the protocol.
+			DisableCompression: true,
+		},
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/machine/") && s.takeThrottle() {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "throttled", http.StatusTooManyRequests)
+		return
+	}
+	s.Server.ServeHTTP(w, r)
+}
+
+func (s *Server) takeThrottle() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.throttle == 0 {
+		return false
+	}
+	s.throttle--
+	s.throttled++
+	return true
+}
+
+// Throttle makes the server reject the next n register and map requests with
+// 429 Too Many Requests, as control does when it's overloaded.
+func (s *Server) Throttle(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.throttle = n
+}
+
+// Throttled returns the number of requests rejected due to Throttle.
+func (s *Server) Throttled() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.throttled
+}
+
+// RegisterNode adds a new node, as if another client had registered,
+// tells the other nodes about it, and returns it.
+func (s *Server) RegisterNode() *tailcfg.Node {
+	return s.AddFakeNode()
+}
+
+// PushMapResponse sends mr to the node with key nk. Once a node has been
+// sent a MapResponse this way, it's only sent MapResponses pushed to it.
+// It reports whether the node is connected.
+func (s *Server) PushMapResponse(nk key.NodePublic, mr *tailcfg.MapResponse) bool {
+	return s.AddRawMapResponse(nk, mr)
+}
+
+// PatchPeers sends the node with key nk a MapResponse with patches to its
+// peers, like PushMapResponse.
+func (s *Server) PatchPeers(nk key.NodePublic, patches ...*tailcfg.PeerChange) bool {
+	return s.AddRawMapResponse(nk, &tailcfg.MapResponse{PeersChangedPatch: patches})
+}
+
+// ExpireNodeKey expires the key of the node with key nk, as if its auth
+// expired, and tells it and its peers. It reports whether the node exists.
+func (s *Server) ExpireNodeKey(nk key.NodePublic) bool {
+	return s.SetNodeKeyExpiry(nk, time.Now().Add(-time.Minute))
+}
@@ -0,0 +1,90 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controlserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"tailscale.com/control/controlclient"
+	"tailscale.com/hostinfo"
+	"tailscale.com/net/tsdial"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/ptr"
+)
+
+type netmapChan chan *netmap.NetworkMap
+
+func (ch netmapChan) UpdateFullNetmap(nm *netmap.NetworkMap) { ch <- nm }
+
+func TestServer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	s := New(t)
+	peer := s.RegisterNode()
+
+	mk := key.NewMachine()
+	hi := hostinfo.New()
+	hi.BackendLogID = "test"
+	c, err := controlclient.NewDirect(controlclient.Options{
+		ServerURL: s.URL(),
+		GetMachinePrivateKey: func() (key.MachinePrivate, error) {
+			return mk, nil
+		},
+		Hostinfo:       hi,
+		Dialer:         new(tsdial.Dialer),
+		HTTPTestClient: s.HTTPClient(),
+		Logf:           t.Logf,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s.Throttle(1)
+	if _, err := c.TryLogin(ctx, nil, controlclient.LoginDefault); err == nil {
+		t.Fatal("TryLogin succeeded while throttled")
+	}
+	if got := s.Throttled(); got != 1 {
+		t.Errorf("Throttled = %d, want 1", got)
+	}
+	if _, err := c.TryLogin(ctx, nil, controlclient.LoginDefault); err != nil {
+		t.Fatalf("TryLogin: %v", err)
+	}
+	nk := c.GetPersist().PublicNodeKey()
+
+	netmaps := make(netmapChan, 10)
+	go c.PollNetMap(ctx, netmaps)
+	next := func() *netmap.NetworkMap {
+		t.Helper()
+		select {
+		case nm := <-netmaps:
+			return nm
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for netmap")
+			return nil
+		}
+	}
+
+	nm := next()
+	if len(nm.Peers) != 1 || nm.Peers[0].ID() != peer.ID {
+		t.Fatalf("peers = %v, want just %v", nm.Peers, peer.ID)
+	}
+
+	if !s.ExpireNodeKey(nk) {
+		t.Fatal("ExpireNodeKey: node not found")
+	}
+	for nm = next(); !nm.Expiry.Before(time.Now()); nm = next() {
+	}
+
+	if !s.PatchPeers(nk, &tailcfg.PeerChange{NodeID: peer.ID, Online: ptr.To(true)}) {
+		t.Fatal("PatchPeers: node not connected")
+	}
+	for nm = next(); nm.Peers[0].Online() == nil || !*nm.Peers[0].Online(); nm = next() {
+	}
+}
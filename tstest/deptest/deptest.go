@@ -3,24 +3,48 @@
 
 // The deptest package contains a shared implementation of negative
 // dependency tests for other packages, making sure we don't start
-// depending on certain packages.
+// depending on certain packages or grow too many or too large
+// dependencies.
 package deptest
 
 import (
+	"bytes"
 	"encoding/json"
+	"flag"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"testing"
 )
 
+var update = flag.Bool("update", false, "update the Golden files of DepCheckers instead of checking them")
+
 type DepChecker struct {
 	GOOS    string            // optional
 	GOARCH  string            // optional
 	BadDeps map[string]string // package => why
+	MaxDeps int               // optional; max number of transitive dependencies
+
+	// SizeBudgets optionally limits the size in bytes of the Go source
+	// files (excluding tests) of the package being checked's dependencies,
+	// as built for GOOS and GOARCH. Keys are package paths, or patterns
+	// ending in "/..." to sum a package and the packages under it. Source
+	// sizes only change with the code and the module versions in go.mod,
+	// not with the Go toolchain.
+	SizeBudgets map[string]int64
+
+	// Golden optionally names a file, relative to the package being
+	// checked, listing its approved dependencies outside the standard
+	// library. Check fails if there are any new ones, and go test -update
+	// rewrites it to the current dependencies. Checkers with a Golden file
+	// should set GOOS and GOARCH so it doesn't depend on the host.
+	Golden string
 }
 
 func (c DepChecker) Check(t *testing.T) {
@@ -54,7 +78,235 @@ func (c DepChecker) Check(t *testing.T) {
 			t.Errorf("package %q is not allowed as a dependency (env: %q); reason: %s", dep, extraEnv, why)
 		}
 	}
+	if c.Golden != "" {
+		c.checkGolden(t, res.Deps, extraEnv)
+	}
 	t.Logf("got %d dependencies", len(res.Deps))
+	if c.MaxDeps > 0 && len(res.Deps) > c.MaxDeps {
+		t.Errorf("got %d dependencies, want at most %d (env: %q); check what new packages pulled in before raising MaxDeps", len(res.Deps), c.MaxDeps, extraEnv)
+	}
+
+	if len(c.SizeBudgets) == 0 {
+		return
+	}
+	sizes := packageSizes(t, extraEnv)
+	for pkg, budget := range c.SizeBudgets {
+		var size int64
+		if prefix, ok := strings.CutSuffix(pkg, "/..."); ok {
+			for p, n := range sizes {
+				if p == prefix || strings.HasPrefix(p, prefix+"/") {
+					size += n
+				}
+			}
+		} else {
+			size = sizes[pkg]
+		}
+		t.Logf("%s: %d bytes (budget %d)", pkg, size, budget)
+		if size > budget {
+			t.Errorf("%s is %d bytes (env: %q), over its budget of %d; check what new code was pulled in before raising the budget", pkg, size, extraEnv, budget)
+		}
+	}
+}
+
+// Target is a platform to check dependencies for.
+type Target struct {
+	GOOS   string
+	GOARCH string
+}
+
+func (t Target) String() string { return t.GOOS + "/" + t.GOARCH }
+
+// CheckAll runs Check for each of targets in parallel subtests, with c's
+// GOOS and GOARCH replaced by the target's, so one test can enforce the
+// same rules on several platforms. If c has a Golden file, each target
+// uses its own, named with "_GOOS_GOARCH" before the extension.
+func (c DepChecker) CheckAll(t *testing.T, targets []Target) {
+	for _, tg := range targets {
+		tc := c
+		tc.GOOS, tc.GOARCH = tg.GOOS, tg.GOARCH
+		if c.Golden != "" {
+			ext := filepath.Ext(c.Golden)
+			tc.Golden = strings.TrimSuffix(c.Golden, ext) + "_" + tg.GOOS + "_" + tg.GOARCH + ext
+		}
+		t.Run(tg.String(), func(t *testing.T) {
+			t.Parallel()
+			tc.Check(t)
+		})
+	}
+}
+
+// checkGolden checks deps against c.Golden, or rewrites it in -update mode.
+func (c DepChecker) checkGolden(t *testing.T, deps, env []string) {
+	t.Helper()
+	// The standard library's dependencies change with each Go release, so
+	// only the others are recorded.
+	var nonStd []string
+	for _, dep := range deps {
+		if !isStd(dep) {
+			nonStd = append(nonStd, dep)
+		}
+	}
+	if *update {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "# Approved non-standard-library dependencies (env: %q).\n", env)
+		fmt.Fprintf(&buf, "# Regenerate with: go test -run %s -update\n", t.Name())
+		for _, dep := range nonStd {
+			fmt.Fprintln(&buf, dep)
+		}
+		if err := os.WriteFile(c.Golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("wrote %d dependencies to %s", len(nonStd), c.Golden)
+		return
+	}
+	golden, err := os.ReadFile(c.Golden)
+	if err != nil {
+		t.Fatalf("%v; run with -update to create it", err)
+	}
+	added, removed := diffDeps(golden, nonStd)
+	for _, dep := range added {
+		t.Errorf("package %q is a new dependency (env: %q) not approved in %s; if it's intended, approve it by running with -update", dep, env, c.Golden)
+	}
+	if len(removed) > 0 {
+		t.Logf("%d approved dependencies are no longer used; run with -update to remove them from %s: %q", len(removed), c.Golden, removed)
+	}
+}
+
+// diffDeps returns the dependencies in deps that aren't in golden, a list of
+// dependencies, one per line, with # comments, and those in golden that
+// aren't in deps.
+func diffDeps(golden []byte, deps []string) (added, removed []string) {
+	approved := make(map[string]bool)
+	for _, line := range strings.Split(string(golden), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		if line = strings.TrimSpace(line); line != "" {
+			approved[line] = true
+		}
+	}
+	for _, dep := range deps {
+		if approved[dep] {
+			delete(approved, dep)
+		} else {
+			added = append(added, dep)
+		}
+	}
+	for dep := range approved {
+		removed = append(removed, dep)
+	}
+	sort.Strings(removed)
+	return added, removed
+}
+
+// isStd reports whether pkg is in the standard library, whose import paths
+// don't start with a domain name.
+func isStd(pkg string) bool {
+	first, _, _ := strings.Cut(pkg, "/")
+	return !strings.Contains(first, ".")
+}
+
+// packageSizes returns the size in bytes of the Go source files, excluding
+// tests, of each package in the transitive closure of the package being
+// checked, built with the environment variables env.
+//
+// Unlike the size of compiled code, which varies with the compiler's
+// inlining and code generation, this only changes when the code does, so
+// budgets don't break when the Go toolchain is updated.
+func packageSizes(t *testing.T, env []string) map[string]int64 {
+	t.Helper()
+	cmd := exec.Command("go", "list", "-deps", "-json=ImportPath,Dir,GoFiles,CgoFiles", ".")
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("go list -deps: %v", err)
+	}
+	sizes := make(map[string]int64)
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		var pkg struct {
+			ImportPath string
+			Dir        string
+			GoFiles    []string
+			CgoFiles   []string
+		}
+		if err := dec.Decode(&pkg); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range append(pkg.GoFiles, pkg.CgoFiles...) {
+			fi, err := os.Stat(filepath.Join(pkg.Dir, f))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sizes[pkg.ImportPath] += fi.Size()
+		}
+	}
+	return sizes
+}
+
+// objSymbol is a symbol in the compiled object of a package.
+type objSymbol struct {
+	pkg  string // import path of the package
+	size int64
+	typ  string // as printed by go tool nm: T for text, U for undefined, etc
+	name string
+}
+
+// depSymbols returns the symbols in the compiled objects of the package in
+// the current directory and its transitive dependencies, built with the
+// environment variables env and the go build flags.
+func depSymbols(t *testing.T, env, flags []string) []objSymbol {
+	t.Helper()
+	args := append([]string{"list", "-deps", "-export", "-f", "{{.ImportPath}} {{.Export}}"}, flags...)
+	cmd := exec.Command("go", append(args, ".")...)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("go list -export: %v", err)
+	}
+	pkgOfFile := make(map[string]string) // export file => import path
+	var files []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		pkg, file, _ := strings.Cut(line, " ")
+		if file == "" {
+			// Packages such as unsafe have no object file.
+			continue
+		}
+		pkgOfFile[file] = pkg
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	out, err = exec.Command("go", append([]string{"tool", "nm", "-size"}, files...)...).Output()
+	if err != nil {
+		t.Fatalf("go tool nm: %v", err)
+	}
+	var syms []objSymbol
+	for _, line := range strings.Split(string(out), "\n") {
+		// With more than one file or object, lines are prefixed with
+		// the file name and, for archives of several objects, the
+		// object in parens.
+		pkg := pkgOfFile[files[0]]
+		if file, rest, ok := strings.Cut(line, ":\t"); ok {
+			file, _, _ = strings.Cut(file, "(")
+			pkg, line = pkgOfFile[file], rest
+		}
+		// Lines are "address size type name", without the address for
+		// undefined symbols. Names may have spaces.
+		f := strings.Fields(line)
+		if len(f) >= 3 && f[1] == "U" {
+			f = append([]string{""}, f...)
+		}
+		if len(f) < 4 {
+			continue
+		}
+		size, err := strconv.ParseInt(f[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		syms = append(syms, objSymbol{pkg: pkg, size: size, typ: f[2], name: strings.Join(f[3:], " ")})
+	}
+	return syms
 }
 
 // ImportAliasCheck checks that all packages are imported according to Tailscale
@@ -3,8 +3,53 @@
 
 package deptest
 
-import "testing"
+import (
+	"os"
+	"reflect"
+	"testing"
+)
 
 func TestImports(t *testing.T) {
 	ImportAliasCheck(t, "../../")
 }
+
+func TestPackageSizes(t *testing.T) {
+	sizes := packageSizes(t, nil)
+	for _, pkg := range []string{"runtime", "strings", "tailscale.com/tstest/deptest"} {
+		if sizes[pkg] <= 0 {
+			t.Errorf("size of %s = %d, want > 0", pkg, sizes[pkg])
+		}
+	}
+	fi, err := os.Stat("deptest.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, min := sizes["tailscale.com/tstest/deptest"], fi.Size(); got < min {
+		t.Errorf("size of tailscale.com/tstest/deptest = %d, want at least the %d bytes of deptest.go", got, min)
+	}
+}
+
+func TestDiffDeps(t *testing.T) {
+	golden := []byte("# approved\ntailscale.com/types/key\n\ngithub.com/foo/bar # for baz\ngolang.org/x/old\n")
+	added, removed := diffDeps(golden, []string{"github.com/foo/bar", "golang.org/x/new", "tailscale.com/types/key"})
+	if !reflect.DeepEqual(added, []string{"golang.org/x/new"}) {
+		t.Errorf("added = %q", added)
+	}
+	if !reflect.DeepEqual(removed, []string{"golang.org/x/old"}) {
+		t.Errorf("removed = %q", removed)
+	}
+}
+
+func TestIsStd(t *testing.T) {
+	for pkg, want := range map[string]bool{
+		"fmt":                                    true,
+		"net/http":                               true,
+		"vendor/golang.org/x/net/dns/dnsmessage": true,
+		"golang.org/x/net/dns/dnsmessage":        false,
+		"tailscale.com/tailcfg":                  false,
+	} {
+		if got := isStd(pkg); got != want {
+			t.Errorf("isStd(%q) = %v, want %v", pkg, got, want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package deptest
+
+import (
+	"fmt"
+	"go/build"
+	"go/build/constraint"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// SymbolChecker checks that the package in the current directory and its
+// dependencies don't reference certain symbols, even of packages they're
+// allowed to import, and that its build doesn't satisfy certain build
+// constraints.
+type SymbolChecker struct {
+	GOOS   string   // optional
+	GOARCH string   // optional
+	Tags   []string // optional build tags
+
+	// BadSymbols maps symbols that must not be referenced to why. Keys are
+	// symbol names as printed by go tool nm, such as
+	// "reflect.Value.MethodByName", or package paths ending in "." to
+	// match all the symbols of a package, such as "encoding/gob.".
+	BadSymbols map[string]string
+
+	// BadTags maps build constraints, such as "ios && !ts_omit_ssh", that
+	// the build must not satisfy to why.
+	BadTags map[string]string
+}
+
+func (c SymbolChecker) Check(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		// Slow and avoid caring about "go.exe" etc.
+		t.Skip("skipping dep tests on windows hosts")
+	}
+	t.Helper()
+	var env, flags []string
+	if c.GOOS != "" {
+		env = append(env, "GOOS="+c.GOOS)
+	}
+	if c.GOARCH != "" {
+		env = append(env, "GOARCH="+c.GOARCH)
+	}
+	if len(c.Tags) > 0 {
+		flags = append(flags, "-tags="+strings.Join(c.Tags, ","))
+	}
+
+	for expr, why := range c.BadTags {
+		match, err := c.matchConstraint(expr)
+		if err != nil {
+			t.Errorf("bad build constraint %q: %v", expr, err)
+			continue
+		}
+		if match {
+			t.Errorf("build (env: %q, flags: %q) satisfies %q, which is not allowed; reason: %s", env, flags, expr, why)
+		}
+	}
+
+	if len(c.BadSymbols) == 0 {
+		return
+	}
+	var errs []string
+	seen := make(map[string]bool)
+	for _, sym := range depSymbols(t, env, flags) {
+		// Undefined symbols are the ones a package references from
+		// others.
+		symPkg := symbolPackage(sym.name)
+		if sym.typ != "U" || symPkg == sym.pkg {
+			continue
+		}
+		bad := sym.name
+		why, ok := c.BadSymbols[bad]
+		if !ok && symPkg != "" {
+			bad = "package " + symPkg
+			why, ok = c.BadSymbols[symPkg+"."]
+		}
+		if !ok {
+			continue
+		}
+		msg := fmt.Sprintf("package %q references %s, which is not allowed (env: %q, flags: %q); reason: %s", sym.pkg, bad, env, flags, why)
+		if !seen[msg] {
+			seen[msg] = true
+			errs = append(errs, msg)
+		}
+	}
+	sort.Strings(errs)
+	for _, msg := range errs {
+		t.Error(msg)
+	}
+}
+
+// matchConstraint reports whether the build satisfies the build constraint
+// expr.
+func (c SymbolChecker) matchConstraint(expr string) (bool, error) {
+	if _, err := constraint.Parse("//go:build " + expr); err != nil {
+		return false, err
+	}
+	ctxt := build.Default
+	if c.GOOS != "" {
+		ctxt.GOOS = c.GOOS
+	}
+	if c.GOARCH != "" {
+		ctxt.GOARCH = c.GOARCH
+	}
+	if (ctxt.GOOS != runtime.GOOS || ctxt.GOARCH != runtime.GOARCH) && os.Getenv("CGO_ENABLED") != "1" {
+		// Like the go command, don't use cgo when cross-compiling
+		// unless asked to.
+		ctxt.CgoEnabled = false
+	}
+	ctxt.BuildTags = c.Tags
+
+	// Let go/build evaluate the constraint, as it knows the tags implied
+	// by GOOS, GOARCH, the Go release, etc.
+	dir, err := os.MkdirTemp("", "deptest")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(dir)
+	src := "//go:build " + expr + "\n\npackage p\n"
+	if err := os.WriteFile(filepath.Join(dir, "p.go"), []byte(src), 0644); err != nil {
+		return false, err
+	}
+	return ctxt.MatchFile(dir, "p.go")
+}
+
+// symbolPackage returns the import path of the package of the symbol named
+// sym, or the empty string if it's not in a package, such as the linker's
+// type and function metadata.
+func symbolPackage(sym string) string {
+	if strings.HasPrefix(sym, "go:") || strings.HasPrefix(sym, "type:") {
+		return ""
+	}
+	// Ignore receivers and type parameters, which may name other
+	// packages.
+	name := sym
+	if i := strings.IndexAny(name, "(["); i >= 0 {
+		name = name[:i]
+	}
+	// The compiler escapes dots in the last element of import paths, so
+	// the first dot after the last slash ends the package.
+	slash := strings.LastIndexByte(name, '/')
+	dot := strings.IndexByte(name[slash+1:], '.')
+	if dot < 0 {
+		return ""
+	}
+	return name[:slash+1+dot]
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package deptest
+
+import "testing"
+
+func TestSymbolPackage(t *testing.T) {
+	tests := []struct {
+		sym  string
+		want string
+	}{
+		{"runtime.pclntab", "runtime"},
+		{"os/exec.(*Cmd).Output", "os/exec"},
+		{"reflect.Value.MethodByName", "reflect"},
+		{"gopkg.in/yaml%2ev3.Unmarshal", "gopkg.in/yaml%2ev3"},
+		{"slices.SortFunc[go.shape.struct { tailscale.com/tailcfg.x int }]", "slices"},
+		{"type:*", ""},
+		{"go:info.*os/exec.Cmd", ""},
+	}
+	for _, tt := range tests {
+		if got := symbolPackage(tt.sym); got != tt.want {
+			t.Errorf("symbolPackage(%q) = %q, want %q", tt.sym, got, tt.want)
+		}
+	}
+}
+
+func TestMatchConstraint(t *testing.T) {
+	c := SymbolChecker{GOOS: "ios", GOARCH: "arm64", Tags: []string{"ts_omit_ssh"}}
+	for expr, want := range map[string]bool{
+		"ios":                     true,
+		"darwin && arm64":         true,
+		"unix && !linux":          true,
+		"ts_omit_ssh":             true,
+		"ios && !ts_omit_ssh":     false,
+		"cgo":                     false,
+		"windows || (ios && 386)": false,
+	} {
+		got, err := c.matchConstraint(expr)
+		if err != nil {
+			t.Errorf("matchConstraint(%q): %v", expr, err)
+		} else if got != want {
+			t.Errorf("matchConstraint(%q) = %v, want %v", expr, got, want)
+		}
+	}
+	if _, err := c.matchConstraint("ios &&"); err == nil {
+		t.Error("matchConstraint of malformed constraint succeeded")
+	}
+}
+
+func TestSymbolChecker(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	// This package uses os/exec, but not net/http.
+	SymbolChecker{
+		BadSymbols: map[string]string{
+			"net/http.":                "test",
+			"os/exec.(*Cmd).StdinPipe": "test",
+		},
+	}.Check(t)
+}
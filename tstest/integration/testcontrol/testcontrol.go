@@ -33,6 +33,7 @@
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/ptr"
+	"tailscale.com/util/mak"
 	"tailscale.com/util/must"
 	"tailscale.com/util/rands"
 	"tailscale.com/util/set"
@@ -82,9 +83,10 @@ type Server struct {
 	logins        map[key.NodePublic]*tailcfg.Login
 	updates       map[tailcfg.NodeID]chan updateType
 	authPath      map[string]*AuthPath
-	nodeKeyAuthed map[key.NodePublic]bool // key => true once authenticated
-	msgToSend     map[key.NodePublic]any  // value is *tailcfg.PingRequest or entire *tailcfg.MapResponse
-	allExpired    bool                    // All nodes will be told their node key is expired.
+	nodeKeyAuthed map[key.NodePublic]bool                   // key => true once authenticated
+	msgToSend     map[key.NodePublic]any                    // value is *tailcfg.PingRequest
+	mapResponses  map[key.NodePublic][]*tailcfg.MapResponse // raw MapResponses to send, in order
+	allExpired    bool                                      // All nodes will be told their node key is expired.
 }
 
 // BaseURL returns the server's base URL, without trailing slash.
@@ -168,6 +170,8 @@ func (s *Server) AddPingRequest(nodeKeyDst key.NodePublic, pr *tailcfg.PingReque
 // MapResponses to that node will be suppressed and only explicit MapResponses
 // injected via AddRawMapResponse will be sent.
 //
+// Multiple MapResponses are sent in the order they were added.
+//
 // It reports whether the message was enqueued. That is, it reports whether
 // nodeKeyDst was connected.
 func (s *Server) AddRawMapResponse(nodeKeyDst key.NodePublic, mr *tailcfg.MapResponse) bool {
@@ -186,14 +190,15 @@ func (s *Server) addDebugMessage(nodeKeyDst key.NodePublic, msg any) bool {
 		return false
 	}
 
-	if _, ok := msg.(*tailcfg.MapResponse); ok {
+	if mr, ok := msg.(*tailcfg.MapResponse); ok {
 		if s.suppressAutoMapResponses == nil {
 			s.suppressAutoMapResponses = set.Set[key.NodePublic]{}
 		}
 		s.suppressAutoMapResponses.Add(nodeKeyDst)
+		mak.Set(&s.mapResponses, nodeKeyDst, append(s.mapResponses[nodeKeyDst], mr))
+	} else {
+		s.msgToSend[nodeKeyDst] = msg
 	}
-
-	s.msgToSend[nodeKeyDst] = msg
 	nodeID := node.ID
 	oldUpdatesCh := s.updates[nodeID]
 	return sendUpdate(oldUpdatesCh, updateDebugInjection)
@@ -211,6 +216,39 @@ func (s *Server) SetExpireAllNodes(expired bool) {
 	}
 }
 
+// SetNodeKeyExpiry sets the key expiry of the node with key nodeKey to t,
+// and sends it and its peers new MapResponses. It reports whether the node
+// exists.
+func (s *Server) SetNodeKeyExpiry(nodeKey key.NodePublic, t time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.nodes[nodeKey]
+	if n == nil {
+		return false
+	}
+	n.KeyExpiry = t
+	sendUpdate(s.updates[n.ID], updateSelfChanged)
+	s.updateLocked("SetNodeKeyExpiry", s.nodeIDsLocked(n.ID))
+	return true
+}
+
+// SetSubnetRoutes adds routes, as if advertised and approved, to the
+// AllowedIPs and PrimaryRoutes of the node with key nodeKey, and sends it
+// and its peers new MapResponses. It reports whether the node exists.
+func (s *Server) SetSubnetRoutes(nodeKey key.NodePublic, routes []netip.Prefix) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.nodes[nodeKey]
+	if n == nil {
+		return false
+	}
+	n.AllowedIPs = append(n.AllowedIPs, routes...)
+	n.PrimaryRoutes = append(n.PrimaryRoutes, routes...)
+	sendUpdate(s.updates[n.ID], updateSelfChanged)
+	s.updateLocked("SetSubnetRoutes", s.nodeIDsLocked(n.ID))
+	return true
+}
+
 type AuthPath struct {
 	nodeKey key.NodePublic
 
@@ -384,8 +422,9 @@ func (s *Server) nodeLocked(nodeKey key.NodePublic) *tailcfg.Node {
 	return s.nodes[nodeKey].Clone()
 }
 
-// AddFakeNode injects a fake node into the server.
-func (s *Server) AddFakeNode() {
+// AddFakeNode injects a fake node into the server, tells the other nodes
+// about it, and returns it.
+func (s *Server) AddFakeNode() *tailcfg.Node {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.nodes == nil {
@@ -409,7 +448,8 @@ func (s *Server) AddFakeNode() {
 		Addresses:         []netip.Prefix{addr},
 		AllowedIPs:        []netip.Prefix{addr},
 	}
-	// TODO: send updates to other (non-fake?) nodes
+	s.updateLocked("AddFakeNode", s.nodeIDsLocked(tailcfg.NodeID(id)))
+	return s.nodes[nk].Clone()
 }
 
 func (s *Server) AllUsers() (users []*tailcfg.User) {
@@ -961,18 +1001,22 @@ func (s *Server) canGenerateAutomaticMapResponseFor(nk key.NodePublic) bool {
 func (s *Server) hasPendingRawMapMessage(nk key.NodePublic) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	_, ok := s.msgToSend[nk].(*tailcfg.MapResponse)
-	return ok
+	return len(s.mapResponses[nk]) > 0
 }
 
 func (s *Server) takeRawMapMessage(nk key.NodePublic) (mapResJSON []byte, ok bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	mr, ok := s.msgToSend[nk].(*tailcfg.MapResponse)
-	if !ok {
+	q := s.mapResponses[nk]
+	if len(q) == 0 {
 		return nil, false
 	}
-	delete(s.msgToSend, nk)
+	mr := q[0]
+	if len(q) == 1 {
+		delete(s.mapResponses, nk)
+	} else {
+		s.mapResponses[nk] = q[1:]
+	}
 	var err error
 	mapResJSON, err = json.Marshal(mr)
 	if err != nil {
@@ -17,5 +17,43 @@ func TestDeps(t *testing.T) {
 			"text/template": "linker bloat (MethodByName)",
 			"html/template": "linker bloat (MethodByName)",
 		},
+		// We're memory constrained on iOS, so also catch large new
+		// dependencies that aren't explicitly banned.
+		MaxDeps: 500,
+		SizeBudgets: map[string]int64{
+			"tailscale.com/...": 3_250_000,
+			"golang.org/...":    2_500_000,
+			"github.com/...":    2_150_000,
+		},
+		Golden: "testdata/deps.txt",
+	}.Check(t)
+}
+
+// TestMobileDeps checks that the mobile apps don't depend on packages only
+// the desktop clients need.
+func TestMobileDeps(t *testing.T) {
+	deptest.DepChecker{
+		BadDeps: map[string]string{
+			"net/http/pprof":                           "desktop-only debug handlers",
+			"golang.org/x/net/http2/h2c":               "desktop-only HTTP/2 cleartext server",
+			"github.com/digitalocean/go-smbios/smbios": "desktop-only hardware info",
+		},
+	}.CheckAll(t, []deptest.Target{
+		{GOOS: "ios", GOARCH: "arm64"},
+		{GOOS: "ios", GOARCH: "amd64"},
+		{GOOS: "android", GOARCH: "arm64"},
+		{GOOS: "android", GOARCH: "arm"},
+	})
+}
+
+func TestSymbols(t *testing.T) {
+	deptest.SymbolChecker{
+		GOOS:   "ios",
+		GOARCH: "arm64",
+		BadSymbols: map[string]string{
+			"encoding/gob.": "linker bloat (reflection)",
+			"net/http/cgi.": "iOS apps can't run subprocesses",
+			"plugin.":       "not supported on iOS",
+		},
 	}.Check(t)
 }
@@ -0,0 +1,222 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netsim
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// PacketConn is a simulated UDP socket. It implements net.PacketConn and
+// nettype.PacketConn.
+type PacketConn struct {
+	n    *Network
+	addr netip.AddrPort
+	in   *inbox
+
+	mu   sync.Mutex
+	rngs map[netip.AddrPort]*rand.Rand // by destination
+}
+
+// LocalAddr implements net.PacketConn.
+func (c *PacketConn) LocalAddr() net.Addr { return net.UDPAddrFromAddrPort(c.addr) }
+
+// ReadFromUDPAddrPort reads the next packet that arrives.
+func (c *PacketConn) ReadFromUDPAddrPort(b []byte) (int, netip.AddrPort, error) {
+	return c.in.read(b, false)
+}
+
+// ReadFrom implements net.PacketConn.
+func (c *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, from, err := c.in.read(b, false)
+	if err != nil {
+		return n, nil, err
+	}
+	return n, net.UDPAddrFromAddrPort(from), nil
+}
+
+// WriteToUDPAddrPort sends b to dst, subject to the link's conditions.
+// Packets that are lost or have no PacketConn at dst are silently dropped.
+func (c *PacketConn) WriteToUDPAddrPort(b []byte, dst netip.AddrPort) (int, error) {
+	c.mu.Lock()
+	rng := c.rngs[dst]
+	if rng == nil {
+		rng = c.n.newRand(c.addr, dst)
+		c.rngs[dst] = rng
+	}
+	d, ok := c.n.schedule(c.addr.Addr(), dst.Addr(), len(b), rng, false)
+	c.mu.Unlock()
+	if ok {
+		data := bytes.Clone(b)
+		src := c.addr
+		c.n.clock.AfterFunc(d, func() { c.n.deliverPacket(src, dst, data) })
+	}
+	return len(b), nil
+}
+
+// WriteTo implements net.PacketConn. addr must be a *net.UDPAddr.
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	ua, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, &net.OpError{Op: "write", Net: "udp", Addr: addr, Err: net.InvalidAddrError("not a UDP address")}
+	}
+	return c.WriteToUDPAddrPort(b, ua.AddrPort())
+}
+
+// Close implements net.PacketConn.
+func (c *PacketConn) Close() error {
+	c.n.mu.Lock()
+	if c.n.packetConns[c.addr] == c {
+		delete(c.n.packetConns, c.addr)
+	}
+	c.n.mu.Unlock()
+	c.in.close()
+	return nil
+}
+
+// SetDeadline implements net.PacketConn. Writes never block, so it only
+// affects reads.
+func (c *PacketConn) SetDeadline(t time.Time) error { return c.SetReadDeadline(t) }
+
+// SetReadDeadline implements net.PacketConn, using the Network's clock.
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	c.in.setDeadline(t)
+	return nil
+}
+
+// SetWriteDeadline implements net.PacketConn. Writes never block, so it
+// does nothing.
+func (c *PacketConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// Listener is a simulated TCP listener. It implements net.Listener.
+type Listener struct {
+	n         *Network
+	addr      netip.AddrPort
+	conns     chan *Conn
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Accept implements net.Listener.
+func (ln *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-ln.conns:
+		return c, nil
+	case <-ln.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements net.Listener.
+func (ln *Listener) Close() error {
+	ln.closeOnce.Do(func() {
+		ln.n.mu.Lock()
+		if ln.n.listeners[ln.addr] == ln {
+			delete(ln.n.listeners, ln.addr)
+		}
+		ln.n.mu.Unlock()
+		close(ln.closed)
+	})
+	return nil
+}
+
+// Addr implements net.Listener.
+func (ln *Listener) Addr() net.Addr { return net.TCPAddrFromAddrPort(ln.addr) }
+
+// Conn is one end of a simulated TCP connection. It implements net.Conn.
+// Data arrives in order and isn't lost, but is subject to the link's
+// latency, jitter and bandwidth.
+type Conn struct {
+	n             *Network
+	local, remote netip.AddrPort
+	in            *inbox
+	peer          *Conn
+
+	mu          sync.Mutex
+	rng         *rand.Rand
+	seq         uint64    // sequence number of the next message sent
+	lastArrival time.Time // of the last message sent
+	closed      bool
+}
+
+func newConnPair(n *Network, client, server netip.AddrPort) (*Conn, *Conn) {
+	c := &Conn{n: n, local: client, remote: server, in: newInbox(n.clock), rng: n.newRand(client, server)}
+	s := &Conn{n: n, local: server, remote: client, in: newInbox(n.clock), rng: n.newRand(server, client)}
+	c.peer, s.peer = s, c
+	return c, s
+}
+
+// send sends m to the peer.
+func (c *Conn) send(m message, size int) {
+	now := c.n.clock.Now()
+	d, _ := c.n.schedule(c.local.Addr(), c.remote.Addr(), size, c.rng, true)
+	// Jitter may delay a message past later ones, but streams arrive in
+	// order, so the peer holds later ones until it arrives.
+	arrival := now.Add(d)
+	if arrival.Before(c.lastArrival) {
+		arrival = c.lastArrival
+	}
+	c.lastArrival = arrival
+	seq := c.seq
+	c.seq++
+	peer := c.peer
+	c.n.clock.AfterFunc(arrival.Sub(now), func() { peer.in.pushSeq(seq, m) })
+}
+
+// Read implements net.Conn.
+func (c *Conn) Read(b []byte) (int, error) {
+	n, _, err := c.in.read(b, true)
+	return n, err
+}
+
+// Write implements net.Conn.
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	if len(b) > 0 {
+		c.send(message{from: c.local, data: bytes.Clone(b)}, len(b))
+	}
+	return len(b), nil
+}
+
+// Close implements net.Conn. The peer reads io.EOF once everything written
+// before it has arrived.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.in.close()
+	c.send(message{from: c.local, eof: true}, 0)
+	return nil
+}
+
+// LocalAddr implements net.Conn.
+func (c *Conn) LocalAddr() net.Addr { return net.TCPAddrFromAddrPort(c.local) }
+
+// RemoteAddr implements net.Conn.
+func (c *Conn) RemoteAddr() net.Addr { return net.TCPAddrFromAddrPort(c.remote) }
+
+// SetDeadline implements net.Conn. Writes never block, so it only affects
+// reads.
+func (c *Conn) SetDeadline(t time.Time) error { return c.SetReadDeadline(t) }
+
+// SetReadDeadline implements net.Conn, using the Network's clock.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.in.setDeadline(t)
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn. Writes never block, so it does
+// nothing.
+func (c *Conn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,166 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netsim
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"tailscale.com/tstime"
+)
+
+// message is a packet, or part of a stream, that has arrived.
+type message struct {
+	from netip.AddrPort
+	data []byte
+	eof  bool // the end of a stream
+}
+
+// inbox holds the messages that have arrived at a PacketConn or Conn until
+// they're read.
+//
+// The clock may run the functions that push messages while holding its own
+// locks, so the clock must not be used with mu held.
+type inbox struct {
+	clock tstime.Clock
+
+	mu            sync.Mutex
+	msgs          []message
+	held          map[uint64]message // stream messages that arrived before earlier ones
+	nextSeq       uint64             // sequence number of the next stream message
+	wake          chan struct{}      // closed and replaced when any of the below change
+	closed        bool
+	deadline      time.Time
+	deadlineTimer tstime.TimerController
+}
+
+func newInbox(clock tstime.Clock) *inbox {
+	return &inbox{
+		clock: clock,
+		wake:  make(chan struct{}),
+	}
+}
+
+func (ib *inbox) wakeLocked() {
+	close(ib.wake)
+	ib.wake = make(chan struct{})
+}
+
+// push adds a message that has arrived.
+func (ib *inbox) push(m message) {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	if ib.closed {
+		return
+	}
+	ib.msgs = append(ib.msgs, m)
+	ib.wakeLocked()
+}
+
+// pushSeq adds the stream message with sequence number seq, after all the
+// ones before it.
+func (ib *inbox) pushSeq(seq uint64, m message) {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	if ib.closed {
+		return
+	}
+	if seq != ib.nextSeq {
+		if ib.held == nil {
+			ib.held = make(map[uint64]message)
+		}
+		ib.held[seq] = m
+		return
+	}
+	for ok := true; ok; m, ok = ib.held[ib.nextSeq] {
+		delete(ib.held, ib.nextSeq)
+		ib.msgs = append(ib.msgs, m)
+		ib.nextSeq++
+	}
+	ib.wakeLocked()
+}
+
+// close makes reads fail with net.ErrClosed and drops future messages.
+func (ib *inbox) close() {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	ib.closed = true
+	ib.msgs = nil
+	ib.held = nil
+	if ib.deadlineTimer != nil {
+		ib.deadlineTimer.Stop()
+	}
+	ib.wakeLocked()
+}
+
+// setDeadline sets the time at which reads stop waiting, as in
+// net.Conn.SetReadDeadline.
+func (ib *inbox) setDeadline(t time.Time) {
+	ib.mu.Lock()
+	if ib.deadlineTimer != nil {
+		ib.deadlineTimer.Stop()
+		ib.deadlineTimer = nil
+	}
+	ib.deadline = t
+	ib.wakeLocked()
+	ib.mu.Unlock()
+
+	if t.IsZero() {
+		return
+	}
+	if d := t.Sub(ib.clock.Now()); d > 0 {
+		timer := ib.clock.AfterFunc(d, func() {
+			ib.mu.Lock()
+			defer ib.mu.Unlock()
+			ib.wakeLocked()
+		})
+		ib.mu.Lock()
+		if ib.deadline.Equal(t) && ib.deadlineTimer == nil {
+			ib.deadlineTimer = timer
+		} else {
+			// Raced with another setDeadline.
+			timer.Stop()
+		}
+		ib.mu.Unlock()
+	}
+}
+
+// read reads the next message into b. For streams, a message too big for b
+// is read in several parts; otherwise, the rest is dropped.
+func (ib *inbox) read(b []byte, stream bool) (int, netip.AddrPort, error) {
+	for {
+		ib.mu.Lock()
+		if ib.closed {
+			ib.mu.Unlock()
+			return 0, netip.AddrPort{}, net.ErrClosed
+		}
+		if len(ib.msgs) > 0 {
+			m := &ib.msgs[0]
+			from := m.from
+			if m.eof {
+				ib.mu.Unlock()
+				return 0, from, io.EOF
+			}
+			n := copy(b, m.data)
+			if stream && n < len(m.data) {
+				m.data = m.data[n:]
+			} else {
+				ib.msgs = ib.msgs[1:]
+			}
+			ib.mu.Unlock()
+			return n, from, nil
+		}
+		deadline, wake := ib.deadline, ib.wake
+		ib.mu.Unlock()
+
+		if !deadline.IsZero() && !ib.clock.Now().Before(deadline) {
+			return 0, netip.AddrPort{}, os.ErrDeadlineExceeded
+		}
+		<-wake
+	}
+}
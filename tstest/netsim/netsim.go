@@ -0,0 +1,246 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package netsim simulates networks with latency, jitter, packet loss and
+// limited bandwidth, for tests.
+//
+// A Network's randomness comes from a seed and its time from a
+// tstime.Clock, so with a tstest.Clock, tests control exactly when each
+// packet arrives and get the same losses on every run.
+package netsim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"tailscale.com/tstime"
+)
+
+// Link describes the conditions of a simulated network link in one
+// direction. The zero value is a perfect link.
+type Link struct {
+	// Latency is how long each packet takes to arrive.
+	Latency time.Duration
+
+	// Jitter is the maximum random delay added to Latency. Packets may
+	// be reordered by it, but data on streams isn't.
+	Jitter time.Duration
+
+	// Loss is the probability, from 0 to 1, of dropping each packet.
+	// Streams are reliable, so it doesn't apply to them.
+	Loss float64
+
+	// Bandwidth is how many bytes per second the link can carry, or zero
+	// for no limit. Packets queue behind each other to be sent.
+	Bandwidth int64
+}
+
+// Network is a simulated network of hosts, identified by IP address,
+// exchanging packets over PacketConns and streams over Conns.
+type Network struct {
+	seed  int64
+	clock tstime.Clock
+
+	mu          sync.Mutex
+	defaultLink Link
+	links       map[hostPair]Link
+	busyUntil   map[hostPair]time.Time // when each link is done sending what it's been given
+	packetConns map[netip.AddrPort]*PacketConn
+	listeners   map[netip.AddrPort]*Listener
+	nextPort    uint16
+}
+
+type hostPair struct {
+	src, dst netip.Addr
+}
+
+// NewNetwork returns a new Network with perfect links, using seed for its
+// randomness and clock for its time. A nil clock means the real time.
+func NewNetwork(seed int64, clock tstime.Clock) *Network {
+	if clock == nil {
+		clock = tstime.StdClock{}
+	}
+	return &Network{
+		seed:        seed,
+		clock:       clock,
+		links:       make(map[hostPair]Link),
+		busyUntil:   make(map[hostPair]time.Time),
+		packetConns: make(map[netip.AddrPort]*PacketConn),
+		listeners:   make(map[netip.AddrPort]*Listener),
+		nextPort:    10000,
+	}
+}
+
+// SetLink sets the conditions of the link from host src to host dst, which
+// apply to the packets sent from then on.
+func (n *Network) SetLink(src, dst netip.Addr, l Link) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.links[hostPair{src, dst}] = l
+}
+
+// SetDefaultLink sets the conditions of the links not set with SetLink.
+func (n *Network) SetDefaultLink(l Link) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.defaultLink = l
+}
+
+// newRand returns a source of randomness for the traffic from src to dst,
+// which is deterministic as long as the sender is.
+func (n *Network) newRand(src, dst netip.AddrPort) *rand.Rand {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%v>%v", src, dst)
+	return rand.New(rand.NewSource(n.seed ^ int64(h.Sum64())))
+}
+
+// schedule returns how long a message of size bytes sent now from src to
+// dst takes to arrive, or false if it's lost. Only unreliable messages are
+// lost. rng must only be used by the sender.
+func (n *Network) schedule(src, dst netip.Addr, size int, rng *rand.Rand, reliable bool) (time.Duration, bool) {
+	// The clock may call back into the Network to deliver packets, so
+	// don't use it with n.mu held.
+	now := n.clock.Now()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	hp := hostPair{src, dst}
+	l, ok := n.links[hp]
+	if !ok {
+		l = n.defaultLink
+	}
+	if !reliable && l.Loss > 0 && rng.Float64() < l.Loss {
+		return 0, false
+	}
+	sent := now
+	if l.Bandwidth > 0 {
+		if b := n.busyUntil[hp]; b.After(sent) {
+			sent = b
+		}
+		sent = sent.Add(time.Duration(int64(size) * int64(time.Second) / l.Bandwidth))
+		n.busyUntil[hp] = sent
+	}
+	d := sent.Sub(now) + l.Latency
+	if l.Jitter > 0 {
+		d += time.Duration(rng.Int63n(int64(l.Jitter) + 1))
+	}
+	return d, true
+}
+
+// allocAddrLocked returns addr, with a free port if its port is zero, or an
+// error if it's in use. n.mu must be held.
+func (n *Network) allocAddrLocked(addr netip.AddrPort, inUse func(netip.AddrPort) bool) (netip.AddrPort, error) {
+	if addr.Port() != 0 {
+		if inUse(addr) {
+			return addr, fmt.Errorf("netsim: address %v in use", addr)
+		}
+		return addr, nil
+	}
+	for i := 0; i < 1<<16; i++ {
+		n.nextPort++
+		if n.nextPort == 0 {
+			n.nextPort = 10000
+		}
+		if a := netip.AddrPortFrom(addr.Addr(), n.nextPort); !inUse(a) {
+			return a, nil
+		}
+	}
+	return addr, fmt.Errorf("netsim: no free ports on %v", addr.Addr())
+}
+
+// ListenPacket returns a PacketConn on addr. If addr's port is zero, a free
+// one is used.
+func (n *Network) ListenPacket(addr netip.AddrPort) (*PacketConn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	addr, err := n.allocAddrLocked(addr, func(a netip.AddrPort) bool { return n.packetConns[a] != nil })
+	if err != nil {
+		return nil, err
+	}
+	c := &PacketConn{
+		n:    n,
+		addr: addr,
+		in:   newInbox(n.clock),
+		rngs: make(map[netip.AddrPort]*rand.Rand),
+	}
+	n.packetConns[addr] = c
+	return c, nil
+}
+
+// deliverPacket delivers a packet sent from src to the PacketConn at dst,
+// if there is one.
+func (n *Network) deliverPacket(src, dst netip.AddrPort, b []byte) {
+	n.mu.Lock()
+	c := n.packetConns[dst]
+	n.mu.Unlock()
+	if c != nil {
+		c.in.push(message{from: src, data: b})
+	}
+}
+
+// Listen returns a Listener for streams on addr. If addr's port is zero, a
+// free one is used.
+func (n *Network) Listen(addr netip.AddrPort) (*Listener, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	addr, err := n.allocAddrLocked(addr, func(a netip.AddrPort) bool { return n.listeners[a] != nil })
+	if err != nil {
+		return nil, err
+	}
+	ln := &Listener{
+		n:      n,
+		addr:   addr,
+		conns:  make(chan *Conn),
+		closed: make(chan struct{}),
+	}
+	n.listeners[addr] = ln
+	return ln, nil
+}
+
+// Dial connects a stream from host src to the Listener at dst. Connecting
+// takes no time.
+func (n *Network) Dial(ctx context.Context, src netip.Addr, dst netip.AddrPort) (*Conn, error) {
+	n.mu.Lock()
+	ln := n.listeners[dst]
+	var local netip.AddrPort
+	var err error
+	if ln != nil {
+		local, err = n.allocAddrLocked(netip.AddrPortFrom(src, 0), func(netip.AddrPort) bool { return false })
+	}
+	n.mu.Unlock()
+	if ln == nil {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Addr: net.TCPAddrFromAddrPort(dst), Err: errors.New("connection refused")}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client, server := newConnPair(n, local, dst)
+	select {
+	case ln.conns <- server:
+		return client, nil
+	case <-ln.closed:
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Addr: net.TCPAddrFromAddrPort(dst), Err: errors.New("connection refused")}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Dialer returns a func like net.Dialer.DialContext that dials streams from
+// host src. Addresses must be IP addresses and ports.
+func (n *Network) Dialer(src netip.Addr) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		dst, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return nil, err
+		}
+		return n.Dial(ctx, src, dst)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netsim
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"tailscale.com/tstest"
+)
+
+var (
+	hostA = netip.MustParseAddr("10.0.0.1")
+	hostB = netip.MustParseAddr("10.0.0.2")
+)
+
+// readNow reads a packet from c if one has arrived, without waiting.
+func readNow(t *testing.T, clock *tstest.Clock, c *PacketConn) (string, bool) {
+	t.Helper()
+	c.SetReadDeadline(clock.Now())
+	buf := make([]byte, 1500)
+	n, _, err := c.ReadFromUDPAddrPort(buf)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return "", false
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n]), true
+}
+
+func newPacketConns(t *testing.T, n *Network) (a, b *PacketConn) {
+	t.Helper()
+	a, err := n.ListenPacket(netip.AddrPortFrom(hostA, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+	b, err = n.ListenPacket(netip.AddrPortFrom(hostB, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return a, b
+}
+
+func TestLatencyAndBandwidth(t *testing.T) {
+	clock := tstest.NewClock(tstest.ClockOpts{})
+	n := NewNetwork(1, clock)
+	n.SetLink(hostA, hostB, Link{Latency: 100 * time.Millisecond, Bandwidth: 1000})
+	a, b := newPacketConns(t, n)
+
+	// Each 500 byte packet takes 500ms to send, then 100ms to arrive.
+	for _, p := range []string{"one", "two"} {
+		a.WriteToUDPAddrPort(append([]byte(p), make([]byte, 497)...), b.addr)
+	}
+	for _, step := range []struct {
+		advance time.Duration
+		want    string
+	}{
+		{599 * time.Millisecond, ""},
+		{time.Millisecond, "one"},
+		{499 * time.Millisecond, ""},
+		{time.Millisecond, "two"},
+	} {
+		clock.Advance(step.advance)
+		got, ok := readNow(t, clock, b)
+		if step.want == "" {
+			if ok {
+				t.Fatalf("at %v, got unexpected packet", clock.Since(clock.GetStart()))
+			}
+			continue
+		}
+		if !ok || got[:3] != step.want {
+			t.Fatalf("at %v, got %q, %v; want %q", clock.Since(clock.GetStart()), got[:min(3, len(got))], ok, step.want)
+		}
+	}
+
+	// The reverse direction is unaffected.
+	b.WriteToUDPAddrPort([]byte("back"), a.addr)
+	if got, ok := readNow(t, clock, a); !ok || got != "back" {
+		t.Errorf("reverse got %q, %v", got, ok)
+	}
+}
+
+func TestLossIsDeterministic(t *testing.T) {
+	received := func() []string {
+		clock := tstest.NewClock(tstest.ClockOpts{})
+		n := NewNetwork(42, clock)
+		n.SetDefaultLink(Link{Loss: 0.5, Latency: time.Millisecond, Jitter: 10 * time.Millisecond})
+		a, b := newPacketConns(t, n)
+		for _, p := range []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"} {
+			a.WriteToUDPAddrPort([]byte(p), b.addr)
+		}
+		clock.Advance(time.Second)
+		var got []string
+		for {
+			p, ok := readNow(t, clock, b)
+			if !ok {
+				return got
+			}
+			got = append(got, p)
+		}
+	}
+	first := received()
+	if len(first) == 0 || len(first) == 10 {
+		t.Fatalf("with 50%% loss, received %q", first)
+	}
+	for i := 0; i < 5; i++ {
+		if got := received(); !slices.Equal(got, first) {
+			t.Fatalf("run %d received %q; first run received %q", i, got, first)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	clock := tstest.NewClock(tstest.ClockOpts{})
+	n := NewNetwork(1, clock)
+	n.SetDefaultLink(Link{Latency: 50 * time.Millisecond, Jitter: 40 * time.Millisecond, Loss: 1})
+
+	ln, err := n.Listen(netip.AddrPortFrom(hostB, 443))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan *Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- c.(*Conn)
+	}()
+	c, err := n.Dialer(hostA)(context.Background(), "tcp", "10.0.0.2:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := <-accepted
+
+	// Despite the jitter, and the loss that streams ignore, the data
+	// arrives in order.
+	for _, p := range []string{"a", "b", "c", "d", "e", "f"} {
+		c.Write([]byte(p))
+	}
+	c.Close()
+	clock.Advance(time.Second)
+	got, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "abcdef" {
+		t.Errorf("read %q, want %q", got, "abcdef")
+	}
+
+	if _, err := c.Write([]byte("x")); err == nil {
+		t.Error("write to closed conn succeeded")
+	}
+	if _, err := n.Dial(context.Background(), hostA, netip.AddrPortFrom(hostB, 80)); err == nil {
+		t.Error("dial of port without listener succeeded")
+	}
+}
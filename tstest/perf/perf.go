@@ -0,0 +1,210 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package perf checks that hot paths don't regress in allocations, bytes
+// allocated, time or mutex contention, against budgets in golden files.
+package perf
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/util/racebuild"
+)
+
+var (
+	update    = flag.Bool("update-perf", false, "update the golden budgets of perf.Checkers instead of checking them")
+	checkTime = flag.Bool("perf-time", false, "also check the time per run against perf.Checker budgets, which depend on the machine")
+)
+
+// Budget is how much a function may use per run, on average.
+type Budget struct {
+	Allocs      uint64  `json:"allocs"`                // heap allocations
+	Bytes       uint64  `json:"bytes"`                 // bytes allocated on the heap
+	Ns          float64 `json:"ns,omitempty"`          // nanoseconds
+	Contentions float64 `json:"contentions,omitempty"` // mutex contention events
+}
+
+// Checker checks functions against the budgets in a golden file.
+//
+// Allocations and bytes are always checked. Time depends on the machine, so
+// it's only checked with the -perf-time flag, and not in race builds. Run
+// the tests with -update-perf to write what the functions use now as their
+// budgets.
+type Checker struct {
+	// Golden is the JSON file of the budgets, keyed by name, relative to
+	// the package being tested. Conventionally testdata/perf.json.
+	Golden string
+
+	// Runs is how many times to run each function. Zero means 1000.
+	Runs int
+
+	// Parallelism is how many goroutines run each function at once, to
+	// measure contention. Zero or one means to run it in one goroutine,
+	// with GOMAXPROCS set to 1.
+	Parallelism int
+
+	// Tolerance is the fraction by which time and contention, which are
+	// noisy, may exceed their budgets. Zero means 0.5.
+	Tolerance float64
+}
+
+// goldenMu serializes updates of golden files, which are shared by tests.
+var goldenMu sync.Mutex
+
+// Check measures f, named name, and fails t if it exceeds its budget.
+func (c Checker) Check(t *testing.T, name string, f func()) {
+	t.Helper()
+	runs := c.Runs
+	if runs <= 0 {
+		runs = 1000
+	}
+	got := Measure(runs, c.Parallelism, f)
+	t.Logf("%s: %d allocs, %d bytes, %.0f ns, %.3f contentions per run", name, got.Allocs, got.Bytes, got.Ns, got.Contentions)
+
+	if *update {
+		if err := c.updateGolden(name, got); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	budgets, err := readGolden(c.Golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, ok := budgets[name]
+	if !ok {
+		t.Errorf("%s has no budget in %s; run with -update-perf to add it", name, c.Golden)
+		return
+	}
+	if got.Allocs > want.Allocs {
+		t.Errorf("%s: %d allocs per run, over its budget of %d", name, got.Allocs, want.Allocs)
+	}
+	if got.Bytes > want.Bytes {
+		t.Errorf("%s: %d bytes allocated per run, over its budget of %d", name, got.Bytes, want.Bytes)
+	}
+	tol := c.Tolerance
+	if tol == 0 {
+		tol = 0.5
+	}
+	if *checkTime && !racebuild.On && want.Ns > 0 && got.Ns > want.Ns*(1+tol) {
+		t.Errorf("%s: %.0f ns per run, over its budget of %.0f ns plus %v%%", name, got.Ns, want.Ns, tol*100)
+	}
+	// Allow one contention per hundred runs, so functions budgeted none
+	// don't fail on a fluke.
+	if got.Contentions > want.Contentions*(1+tol)+0.01 {
+		t.Errorf("%s: %.3f mutex contentions per run, over its budget of %.3f plus %v%%", name, got.Contentions, want.Contentions, tol*100)
+	}
+}
+
+func readGolden(file string) (map[string]Budget, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w; run with -update-perf to create it", err)
+		}
+		return nil, err
+	}
+	var budgets map[string]Budget
+	if err := json.Unmarshal(b, &budgets); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return budgets, nil
+}
+
+func (c Checker) updateGolden(name string, b Budget) error {
+	goldenMu.Lock()
+	defer goldenMu.Unlock()
+	budgets, err := readGolden(c.Golden)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if budgets == nil {
+		budgets = make(map[string]Budget)
+	}
+	b.Ns = math.Round(b.Ns)
+	budgets[name] = b
+	j, err := json.MarshalIndent(budgets, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.Golden, append(j, '\n'), 0644)
+}
+
+// Measure runs f runs times, split between parallelism goroutines, after
+// running it once to warm up, and returns what it used per run. If
+// parallelism is zero or one, it sets GOMAXPROCS to 1 during its
+// measurement, like testing.AllocsPerRun.
+func Measure(runs, parallelism int, f func()) Budget {
+	if parallelism <= 1 {
+		parallelism = 1
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	}
+	perG := max(runs/parallelism, 1)
+	runs = perG * parallelism
+
+	f()
+	defer runtime.SetMutexProfileFraction(runtime.SetMutexProfileFraction(1))
+	contentions := mutexContentions()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	mallocs, bytes := ms.Mallocs, ms.TotalAlloc
+	start := time.Now()
+
+	if parallelism == 1 {
+		for i := 0; i < runs; i++ {
+			f()
+		}
+	} else {
+		var wg sync.WaitGroup
+		wg.Add(parallelism)
+		for g := 0; g < parallelism; g++ {
+			go func() {
+				defer wg.Done()
+				for i := 0; i < perG; i++ {
+					f()
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&ms)
+	contentions = mutexContentions() - contentions
+	n := uint64(runs)
+	return Budget{
+		Allocs:      (ms.Mallocs - mallocs) / n,
+		Bytes:       (ms.TotalAlloc - bytes) / n,
+		Ns:          float64(elapsed.Nanoseconds()) / float64(runs),
+		Contentions: float64(contentions) / float64(runs),
+	}
+}
+
+// mutexContentions returns the number of mutex contention events recorded
+// by the runtime so far.
+func mutexContentions() int64 {
+	var recs []runtime.BlockProfileRecord
+	n, _ := runtime.MutexProfile(nil)
+	for {
+		recs = make([]runtime.BlockProfileRecord, n+50)
+		var ok bool
+		if n, ok = runtime.MutexProfile(recs); ok {
+			break
+		}
+	}
+	var total int64
+	for _, r := range recs[:n] {
+		total += r.Count
+	}
+	return total
+}
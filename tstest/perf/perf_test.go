@@ -0,0 +1,66 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package perf
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+var sink []byte
+
+func TestMeasure(t *testing.T) {
+	got := Measure(100, 0, func() { sink = make([]byte, 1000) })
+	if got.Allocs != 1 {
+		t.Errorf("Allocs = %d; want 1", got.Allocs)
+	}
+	if got.Bytes < 1000 || got.Bytes > 1100 {
+		t.Errorf("Bytes = %d; want about 1000", got.Bytes)
+	}
+	if got := Measure(100, 0, func() {}); got.Allocs != 0 || got.Bytes != 0 {
+		t.Errorf("empty func used %+v", got)
+	}
+}
+
+func TestMeasureContention(t *testing.T) {
+	if testing.Short() {
+		t.Skip("slow in short mode")
+	}
+	var mu sync.Mutex
+	var n int
+	got := Measure(10000, 8, func() {
+		mu.Lock()
+		for i := 0; i < 1000; i++ {
+			n++
+		}
+		mu.Unlock()
+	})
+	if got.Contentions == 0 {
+		t.Skip("no contention observed; machine may have too few CPUs")
+	}
+	t.Logf("%.3f contentions per run", got.Contentions)
+}
+
+func TestReadGolden(t *testing.T) {
+	dir := t.TempDir()
+	c := Checker{Golden: filepath.Join(dir, "perf.json")}
+	if _, err := readGolden(c.Golden); err == nil {
+		t.Fatal("reading missing golden file succeeded")
+	}
+	want := Budget{Allocs: 2, Bytes: 64, Ns: 100}
+	if err := c.updateGolden("a", want); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.updateGolden("b", Budget{Allocs: 1}); err != nil {
+		t.Fatal(err)
+	}
+	budgets, err := readGolden(c.Golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(budgets) != 2 || budgets["a"] != want {
+		t.Errorf("budgets = %+v", budgets)
+	}
+}
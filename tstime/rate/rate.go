@@ -61,7 +61,17 @@ func (lim *Limiter) Allow() bool {
 	return lim.allow(mono.Now())
 }
 
+// AllowN reports whether n events may happen now.
+// It returns false if n exceeds the Limiter's burst size.
+func (lim *Limiter) AllowN(n int) bool {
+	return lim.allowN(mono.Now(), float64(n))
+}
+
 func (lim *Limiter) allow(now mono.Time) bool {
+	return lim.allowN(now, 1)
+}
+
+func (lim *Limiter) allowN(now mono.Time, n float64) bool {
 	lim.mu.Lock()
 	defer lim.mu.Unlock()
 
@@ -77,8 +87,8 @@ func (lim *Limiter) allow(now mono.Time) bool {
 		tokens = lim.burst
 	}
 
-	// Consume a token.
-	tokens--
+	// Consume the tokens.
+	tokens -= n
 
 	// Update state.
 	ok := tokens >= 0
@@ -94,6 +94,27 @@ func TestLimiterBurst1(t *testing.T) {
 	})
 }
 
+func TestLimiterAllowN(t *testing.T) {
+	lim := NewLimiter(10, 5)
+	steps := []struct {
+		t  mono.Time
+		n  float64
+		ok bool
+	}{
+		{t0, 3, true},
+		{t0, 3, false}, // only 2 tokens remain
+		{t0, 2, true},
+		{t2, 2, true},  // refilled 2 tokens
+		{t9, 6, false}, // more than the burst
+		{t9, 5, true},
+	}
+	for i, s := range steps {
+		if ok := lim.allowN(s.t, s.n); ok != s.ok {
+			t.Errorf("step %d: lim.allowN(%v, %v) = %v want %v", i, s.t, s.n, ok, s.ok)
+		}
+	}
+}
+
 func TestLimiterJumpBackwards(t *testing.T) {
 	run(t, NewLimiter(10, 3), []allow{
 		{t1, true}, // start at t1
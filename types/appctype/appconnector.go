@@ -6,9 +6,16 @@
 package appctype
 
 import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"net/netip"
+	"slices"
+	"strings"
+	"time"
 
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/tkatype"
 )
 
 // ConfigID is an opaque identifier for a configuration.
@@ -25,9 +32,141 @@ type AppConnectorConfig struct {
 	// AdvertiseRoutes indicates that the node should advertise routes for each
 	// of the addresses in service configuration address lists. If false, the
 	// routes have already been advertised.
+	//
+	// If true, the app connector also advertises a single-address route for
+	// each address it sees a service's destination domain resolve to, so that
+	// routing by domain works without a list of routes. Such learned routes
+	// are withdrawn once the address hasn't been seen for LearnedRouteTTL.
 	AdvertiseRoutes bool `json:",omitempty"`
+
+	// LearnedRouteTTL is how long a route learned from a DNS answer stays
+	// advertised after its address was last seen. Zero means 24 hours.
+	LearnedRouteTTL time.Duration `json:",omitempty"`
+
+	// MaxLearnedRoutes is the most routes the app connector learns from DNS
+	// answers; addresses beyond it aren't advertised. Zero means 1000.
+	MaxLearnedRoutes int `json:",omitempty"`
+
+	// DrainTimeout is how long the connections of a service that's removed
+	// from the configuration may continue, while new connections to it are
+	// refused, before they're closed. Zero means 30 seconds, and a negative
+	// value closes them immediately.
+	DrainTimeout time.Duration `json:",omitempty"`
+
+	// Firewall is how the app connector restricts which sources can reach
+	// the addresses and ports in service configurations. The zero value
+	// accepts flows from any source.
+	Firewall FirewallMode `json:",omitempty"`
+
+	// DomainAttestation, if non-nil, is a control-signed statement of the
+	// domains this configuration may proxy. It is only consulted by app
+	// connectors which have been configured to require one.
+	DomainAttestation *DomainAttestation `json:",omitempty"`
+
+	// Experiments enables opt-in behaviors that are still being rolled out,
+	// in place of a field of their own. Experiments the app connector doesn't
+	// support are ignored; see CheckExperiments.
+	Experiments map[Experiment]bool `json:",omitempty"`
+}
+
+// Experiment names an opt-in app connector behavior. See
+// AppConnectorConfig.Experiments.
+type Experiment string
+
+const (
+	// ExperimentLearnRoutes learns and advertises routes from the DNS
+	// answers for services' destination domains, as AdvertiseRoutes does,
+	// even if AdvertiseRoutes is false because the routes of the service
+	// addresses have already been advertised.
+	ExperimentLearnRoutes Experiment = "learn-routes"
+)
+
+// SupportedExperiments are the experiments this version of the app
+// connector supports.
+var SupportedExperiments = []Experiment{
+	ExperimentLearnRoutes,
+}
+
+// ErrUnknownExperiment is returned by CheckExperiments when a
+// configuration enables an experiment that isn't supported.
+var ErrUnknownExperiment = errors.New("unknown app connector experiment")
+
+// Experiment reports whether the experiment e is enabled in c.
+func (c *AppConnectorConfig) Experiment(e Experiment) bool {
+	return c != nil && c.Experiments[e]
 }
 
+// CheckExperiments returns an error wrapping ErrUnknownExperiment naming the
+// experiments in c that aren't in SupportedExperiments, if any.
+func (c *AppConnectorConfig) CheckExperiments() error {
+	var unknown []string
+	for e := range c.Experiments {
+		if !slices.Contains(SupportedExperiments, e) {
+			unknown = append(unknown, string(e))
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	slices.Sort(unknown)
+	return fmt.Errorf("%w: %q (supported: %q)", ErrUnknownExperiment, unknown, SupportedExperiments)
+}
+
+// DomainAttestation is a signed list of the domains an app connector is
+// permitted to proxy, used to guard against unauthorized widening of an app
+// connector's scope.
+type DomainAttestation struct {
+	// Domains is the list of domains covered by the attestation, in the
+	// same form as DNATConfig.To and SNIProxyConfig.AllowedDomains.
+	Domains []string
+
+	// Signature is the signature of DomainsHash(Domains) by a tailnet key
+	// authority key. For 25519 keys, KeyID is the public key and the
+	// signature is an ed25519 signature.
+	Signature tkatype.Signature
+}
+
+// DomainsHash returns the hash of a list of domains that is signed in a
+// DomainAttestation. The hash does not depend on the order of domains, on
+// duplicates, or on the case of domains.
+func DomainsHash(domains []string) [sha256.Size]byte {
+	norm := make([]string, len(domains))
+	for i, d := range domains {
+		norm[i] = strings.ToLower(d)
+	}
+	slices.Sort(norm)
+	norm = slices.Compact(norm)
+
+	h := sha256.New()
+	h.Write([]byte("tailscale-appc-domains-v1\n"))
+	for _, d := range norm {
+		h.Write([]byte(d))
+		h.Write([]byte{'\n'})
+	}
+	var ret [sha256.Size]byte
+	h.Sum(ret[:0])
+	return ret
+}
+
+// FirewallMode is how an app connector filters the sources of the flows
+// it receives. Flows are filtered in the app connector's netstack handlers,
+// so the host firewall is left alone on every platform.
+type FirewallMode string
+
+const (
+	// FirewallModeOff accepts flows from any source.
+	FirewallModeOff FirewallMode = ""
+
+	// FirewallModeEnforce refuses flows to the app connector's addresses
+	// and ports unless they come from the Tailscale address of a known
+	// peer.
+	FirewallModeEnforce FirewallMode = "enforce"
+
+	// FirewallModeDryRun logs the flows FirewallModeEnforce would refuse,
+	// without refusing them.
+	FirewallModeDryRun FirewallMode = "dry-run"
+)
+
 // DNATConfig is the configuration structure for a destination NAT service, also
 // known as a "port forward" or "port proxy".
 type DNATConfig struct {
@@ -35,12 +174,77 @@ type DNATConfig struct {
 	Addrs []netip.Addr `json:",omitempty"`
 
 	// To is a list of destination addresses to forward traffic to. It should
-	// only contain one domain, or a list of IP addresses.
+	// only contain one domain, or a list of IP addresses. Destinations may
+	// be reachable only over the tailnet, such as via another node's subnet
+	// routes, if the app connector is configured to dial over the tailnet.
 	To []string `json:",omitempty"`
 
 	// IP is a list of IP specifications to forward. If omitted, all protocols are
 	// forwarded. IP specifications are of the form "tcp/80", "udp/53", etc.
+	// UDP flows are forwarded until they've been idle for a while.
 	IP []tailcfg.ProtoPortRange `json:",omitempty"`
+
+	// Weights, if non-empty, are the relative weights of each element of
+	// To, with which connections are spread across them, instead of evenly.
+	// Destinations of weight zero are standbys, only used when all others
+	// are unhealthy. Weights is ignored unless it's the same length as To.
+	Weights []int `json:",omitempty"`
+
+	// HealthCheck, if non-nil, configures active health checks of the
+	// destinations in To. Unhealthy destinations are taken out of rotation
+	// until they recover.
+	HealthCheck *HealthCheck `json:",omitempty"`
+
+	// AllowedSources, if non-empty, restricts which clients may use the
+	// service, in addition to the tailnet policy. Each is an IP address or
+	// CIDR prefix matching client addresses, or a tag such as "tag:prod"
+	// matching clients with that tag in the netmap. See ParseSources.
+	AllowedSources []string `json:",omitempty"`
+
+	// ProxyProtocol, if non-zero, is the version of the PROXY protocol
+	// header to send to the destination at the start of each TCP
+	// connection, carrying the Tailscale address and identity of the
+	// client. Only version 2 is supported; see the ProxyTLV constants.
+	ProxyProtocol int `json:",omitempty"`
+
+	// Provenance, if non-nil, describes the part of the tailnet policy
+	// that configured this service.
+	Provenance *Provenance `json:",omitempty"`
+}
+
+// HealthCheckKind is a kind of destination health check.
+type HealthCheckKind string
+
+const (
+	// HealthCheckTCP checks that a TCP connection to the destination can be
+	// established.
+	HealthCheckTCP HealthCheckKind = "tcp"
+
+	// HealthCheckHTTP checks that an HTTP GET request to the destination
+	// gets a response with a status below 500.
+	HealthCheckHTTP HealthCheckKind = "http"
+)
+
+// HealthCheck configures the health checks of the destinations of a DNAT
+// service.
+type HealthCheck struct {
+	// Kind is the kind of check. The default is HealthCheckTCP.
+	Kind HealthCheckKind `json:",omitempty"`
+
+	// Port is the destination port to check. If zero, the first TCP port
+	// the service forwards is used.
+	Port uint16 `json:",omitempty"`
+
+	// Path is the path requested by HTTP checks. The default is "/".
+	Path string `json:",omitempty"`
+
+	// Interval is the time between checks of each destination. The
+	// default is 10 seconds.
+	Interval time.Duration `json:",omitempty"`
+
+	// Timeout is how long a check may take before it fails. The default
+	// is 2 seconds.
+	Timeout time.Duration `json:",omitempty"`
 }
 
 // SNIPRoxyConfig is the configuration structure for an SNI proxy service,
@@ -51,9 +255,362 @@ type SNIProxyConfig struct {
 
 	// IP is a list of IP specifications to forward. If omitted, all protocols are
 	// forwarded. IP specifications are of the form "tcp/80", "udp/53", etc.
+	// UDP flows are taken to be QUIC connections, forwarded according to
+	// the SNI of their TLS handshake.
 	IP []tailcfg.ProtoPortRange `json:",omitempty"`
 
 	// AllowedDomains is a list of domains that are allowed to be proxied. If
 	// the domain starts with a `.` that means any subdomain of the suffix.
 	AllowedDomains []string `json:",omitempty"`
+
+	// DomainPatterns is a list of patterns of domains that are allowed, or
+	// with Deny set, refused. A domain is proxied if no deny pattern matches
+	// it and it's either in AllowedDomains or matched by an allow pattern.
+	// If there are neither AllowedDomains nor allow patterns, all domains not
+	// matched by a deny pattern are proxied.
+	DomainPatterns []DomainPattern `json:",omitempty"`
+
+	// AllowedSources is like DNATConfig.AllowedSources.
+	AllowedSources []string `json:",omitempty"`
+
+	// ProxyProtocol is like DNATConfig.ProxyProtocol.
+	ProxyProtocol int `json:",omitempty"`
+
+	// TerminateTLS, if non-nil, makes the service terminate TLS connections
+	// instead of passing them through, and forward the HTTP requests they
+	// carry to TerminateTLS.Backend. Certificates are obtained the same way
+	// as for "tailscale cert", so AllowedDomains must be literal names of
+	// the node itself, from its CertDomains. UDP flows are still passed
+	// through.
+	TerminateTLS *TLSTermination `json:",omitempty"`
+
+	// Provenance, if non-nil, describes the part of the tailnet policy
+	// that configured this service.
+	Provenance *Provenance `json:",omitempty"`
+}
+
+// TLSTermination configures an SNI proxy service that terminates TLS.
+type TLSTermination struct {
+	// Backend is the URL of the HTTP or HTTPS server to forward requests
+	// to, such as "http://10.0.0.5:8080". It's dialed the same way as the
+	// destinations of other services. Requests keep the Host the client
+	// sent.
+	Backend string `json:",omitempty"`
+
+	// SetHeaders are HTTP request headers to set on each request forwarded
+	// to the destination, replacing any the client sent.
+	SetHeaders map[string]string `json:",omitempty"`
+
+	// IdentityHeaders is whether to set the Tailscale-User-Login,
+	// Tailscale-User-Name and Tailscale-Node-Name headers on each request
+	// forwarded to the destination, as identified by the client's address.
+	// The headers are removed from requests from unknown or tagged nodes.
+	IdentityHeaders bool `json:",omitempty"`
+
+	// AccessLog is whether to log each HTTP request.
+	AccessLog bool `json:",omitempty"`
+}
+
+// ProxyTLV is the type of a TLV in the PROXY protocol version 2 headers an
+// app connector sends to destinations, in the range of types the protocol
+// reserves for custom use. Each carries part of the identity of the client.
+// They're omitted if the client's identity is unknown.
+type ProxyTLV byte
+
+const (
+	ProxyTLVNodeName     ProxyTLV = 0xE0 // the client node's FQDN
+	ProxyTLVNodeStableID ProxyTLV = 0xE1 // the client node's stable ID
+	ProxyTLVUserLogin    ProxyTLV = 0xE2 // the login name of the client node's user
+)
+
+// DomainPattern is a pattern of domain names in an SNIProxyConfig. Exactly
+// one of Glob and Regexp must be set. Domains are matched without regard to
+// case.
+type DomainPattern struct {
+	// Glob is a pattern in which "*" matches any sequence of characters
+	// within a single label, "**" matches any sequence of characters
+	// including dots, and "?" matches a single character other than a dot.
+	// For example, "*.internal.example.com" matches "git.internal.example.com"
+	// but not "a.b.internal.example.com".
+	Glob string `json:",omitempty"`
+
+	// Regexp is an RE2 regular expression that must match the whole
+	// domain.
+	Regexp string `json:",omitempty"`
+
+	// Deny is whether domains matching the pattern are refused, even if
+	// they are otherwise allowed.
+	Deny bool `json:",omitempty"`
+}
+
+// String returns p in the form "glob:<Glob>" or "regexp:<Regexp>", prefixed
+// with "!" if p denies the domains it matches. Allow patterns are covered by
+// a DomainAttestation listing them in this form.
+func (p DomainPattern) String() string {
+	var s string
+	if p.Regexp != "" {
+		s = "regexp:" + p.Regexp
+	} else {
+		s = "glob:" + p.Glob
+	}
+	if p.Deny {
+		return "!" + s
+	}
+	return s
+}
+
+// Provenance describes the part of the tailnet policy that granted an app
+// connector service, so that operators can tell who owns it.
+type Provenance struct {
+	// Grant identifies the policy grant that configured the service, such
+	// as its name or position in the policy file.
+	Grant string `json:",omitempty"`
+
+	// Owner is the admin group or user that owns the grant, such as
+	// "group:network-team".
+	Owner string `json:",omitempty"`
+}
+
+func (p *Provenance) String() string {
+	if p == nil {
+		return "unknown"
+	}
+	switch {
+	case p.Grant == "" && p.Owner == "":
+		return "unknown"
+	case p.Owner == "":
+		return "grant " + p.Grant
+	case p.Grant == "":
+		return "owner " + p.Owner
+	}
+	return fmt.Sprintf("grant %s, owner %s", p.Grant, p.Owner)
+}
+
+// ServiceStatus is the status of one service of an app connector.
+type ServiceStatus struct {
+	// Service names the service, in the form "dnat/<ConfigID>" or
+	// "sni/<ConfigID>".
+	Service string
+
+	// Addrs and IP are the addresses, protocols and ports the service
+	// listens on.
+	Addrs []netip.Addr             `json:",omitempty"`
+	IP    []tailcfg.ProtoPortRange `json:",omitempty"`
+
+	// Destinations are the destinations of a DNAT service, or the allowed
+	// domains and domain patterns of an SNI proxy service.
+	Destinations []string `json:",omitempty"`
+
+	// Provenance is the service's Provenance from the configuration, if
+	// any.
+	Provenance *Provenance `json:",omitempty"`
+
+	// Probe is the result of the most recent reachability probe of the
+	// service, or nil if it hasn't been probed since it was configured.
+	Probe *ProbeResult `json:",omitempty"`
+
+	// Unhealthy are the destinations of a DNAT service which are out of
+	// rotation because they failed their health checks.
+	Unhealthy []string `json:",omitempty"`
+
+	// ActiveConns is the number of open connections and UDP flows of the
+	// service. The other counts are since the app connector was last
+	// configured.
+	ActiveConns   int   `json:",omitempty"`
+	BytesIn       int64 `json:",omitempty"` // read from clients
+	BytesOut      int64 `json:",omitempty"` // written to clients
+	ConnectErrors int64 `json:",omitempty"` // failed dials of destinations
+	Dials         int64 `json:",omitempty"` // successful dials of destinations
+}
+
+// DrainStatus is the progress of draining the connections of a service
+// removed from an app connector's configuration.
+type DrainStatus struct {
+	// Service names the service, in the form "dnat/<ConfigID>" or
+	// "sni/<ConfigID>".
+	Service string
+
+	// Started is when the service was removed, and Deadline is when its
+	// remaining connections are closed.
+	Started  time.Time
+	Deadline time.Time
+
+	// ActiveConns is the number of the service's connections still open.
+	ActiveConns int
+}
+
+// ProbeResult is the result of an app connector probing one of its own
+// services end to end: connecting to a service address from the tailnet
+// side, through the app connector, to an upstream destination.
+type ProbeResult struct {
+	// Time is when the probe started.
+	Time time.Time
+
+	// Target is the service address and port probed, followed for SNI
+	// proxy services by the domain requested.
+	Target string
+
+	// Latency is how long the probe took.
+	Latency time.Duration
+
+	// Error describes why the probe failed, or is empty if it succeeded.
+	Error string `json:",omitempty"`
+}
+
+// Healthy reports whether the probe succeeded.
+func (r *ProbeResult) Healthy() bool {
+	return r != nil && r.Error == ""
+}
+
+func (r *ProbeResult) String() string {
+	switch {
+	case r == nil:
+		return "unprobed"
+	case r.Error != "":
+		return "FAIL: " + r.Error
+	}
+	return fmt.Sprintf("ok (%v)", r.Latency.Round(time.Millisecond))
+}
+
+// FlowLogEntry is an entry of an app connector's flow log, describing a
+// connection or UDP flow through one of its services once it has ended.
+type FlowLogEntry struct {
+	Start    time.Time
+	Duration time.Duration
+
+	// Service names the service, in the form "dnat/<ConfigID>" or
+	// "sni/<ConfigID>".
+	Service string
+
+	// Proto is "tcp" or "udp".
+	Proto string
+
+	// Src is the address of the client, and Dst the service address it
+	// connected to.
+	Src, Dst string
+
+	// Backend is the host:port the app connector forwarded the connection
+	// to, or empty if it didn't get as far as dialing one.
+	Backend string `json:",omitempty"`
+
+	// BytesIn and BytesOut are the bytes received from and sent to the
+	// client.
+	BytesIn, BytesOut int64
+
+	// Error describes why dialing Backend failed, if it did.
+	Error string `json:",omitempty"`
+}
+
+// Status returns the status of the services in c, sorted by name.
+func (c *AppConnectorConfig) Status() []ServiceStatus {
+	var ret []ServiceStatus
+	for cID, d := range c.DNAT {
+		ret = append(ret, ServiceStatus{
+			Service:      "dnat/" + string(cID),
+			Addrs:        d.Addrs,
+			IP:           d.IP,
+			Destinations: d.To,
+			Provenance:   d.Provenance,
+		})
+	}
+	for cID, s := range c.SNIProxy {
+		ret = append(ret, ServiceStatus{
+			Service:      "sni/" + string(cID),
+			Addrs:        s.Addrs,
+			IP:           s.IP,
+			Destinations: destinations(s.AllowedDomains, s.DomainPatterns),
+			Provenance:   s.Provenance,
+		})
+	}
+	slices.SortFunc(ret, func(a, b ServiceStatus) int { return strings.Compare(a.Service, b.Service) })
+	return ret
+}
+
+// Conflict is a pair of services in an AppConnectorConfig that listen on
+// the same address with overlapping protocols and ports, such that which of
+// them handles a connection is unspecified.
+type Conflict struct {
+	Addr netip.Addr
+
+	// A and B name the conflicting services, in the form "dnat/<ConfigID>"
+	// or "sni/<ConfigID>", with A sorting before B.
+	A, B string
+
+	// AMatch and BMatch are the overlapping protocols and ports of A and B.
+	AMatch, BMatch tailcfg.ProtoPortRange
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("%v: %s (%v) overlaps %s (%v)", c.Addr, c.A, c.AMatch, c.B, c.BMatch)
+}
+
+// Conflicts returns the pairs of services in c that listen on overlapping
+// addresses, protocols and ports, sorted by address and service name.
+func (c *AppConnectorConfig) Conflicts() []Conflict {
+	type listener struct {
+		service string
+		match   tailcfg.ProtoPortRange
+	}
+	byAddr := make(map[netip.Addr][]listener)
+	add := func(service string, addrs []netip.Addr, ips []tailcfg.ProtoPortRange) {
+		for _, a := range addrs {
+			for _, m := range ips {
+				byAddr[a] = append(byAddr[a], listener{service, m})
+			}
+		}
+	}
+	for cID, d := range c.DNAT {
+		add("dnat/"+string(cID), d.Addrs, d.IP)
+	}
+	for cID, s := range c.SNIProxy {
+		add("sni/"+string(cID), s.Addrs, s.IP)
+	}
+
+	var ret []Conflict
+	for addr, ls := range byAddr {
+		slices.SortFunc(ls, func(a, b listener) int { return strings.Compare(a.service, b.service) })
+		for i := range ls {
+			for j := i + 1; j < len(ls); j++ {
+				if ls[i].service == ls[j].service || !overlaps(ls[i].match, ls[j].match) {
+					continue
+				}
+				ret = append(ret, Conflict{
+					Addr:   addr,
+					A:      ls[i].service,
+					B:      ls[j].service,
+					AMatch: ls[i].match,
+					BMatch: ls[j].match,
+				})
+			}
+		}
+	}
+	slices.SortFunc(ret, func(a, b Conflict) int {
+		if c := a.Addr.Compare(b.Addr); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.A, b.A); c != 0 {
+			return c
+		}
+		return strings.Compare(a.B, b.B)
+	})
+	return ret
+}
+
+// overlaps reports whether a and b match any of the same packets.
+func overlaps(a, b tailcfg.ProtoPortRange) bool {
+	if a.Proto != 0 && b.Proto != 0 && a.Proto != b.Proto {
+		return false
+	}
+	return a.Ports.First <= b.Ports.Last && b.Ports.First <= a.Ports.Last
+}
+
+func destinations(domains []string, patterns []DomainPattern) []string {
+	if len(patterns) == 0 {
+		return domains
+	}
+	ret := slices.Clip(domains)
+	for _, p := range patterns {
+		ret = append(ret, p.String())
+	}
+	return ret
 }
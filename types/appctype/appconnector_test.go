@@ -5,6 +5,7 @@
 
 import (
 	"encoding/json"
+	"errors"
 	"net/netip"
 	"strings"
 	"testing"
@@ -19,7 +20,8 @@
     "opaqueid1": {
       "addrs": ["100.64.0.1", "fd7a:115c:a1e0::1"],
       "to": ["example.org"],
-      "ip": ["*"]
+      "ip": ["*"],
+      "provenance": {"grant": "grants[3]", "owner": "group:web-team"}
     }
   },
   "sniProxy": {
@@ -37,6 +39,10 @@ func TestGolden(t *testing.T) {
 		Addrs: []netip.Addr{netip.MustParseAddr("100.64.0.1"), netip.MustParseAddr("fd7a:115c:a1e0::1")},
 		To:    []string{"example.org"},
 		IP:    []tailcfg.ProtoPortRange{{Proto: 0, Ports: tailcfg.PortRange{First: 0, Last: 65535}}},
+		Provenance: &Provenance{
+			Grant: "grants[3]",
+			Owner: "group:web-team",
+		},
 	}}
 
 	wantSNI := map[ConfigID]SNIProxyConfig{"opaqueid2": {
@@ -76,3 +82,89 @@ func assertEqual(t *testing.T, name string, a, b any) {
 		t.Fatalf("mismatch (-want +got):\n%s", diff)
 	}
 }
+
+func TestStatus(t *testing.T) {
+	var config AppConnectorConfig
+	must.Do(json.NewDecoder(strings.NewReader(golden)).Decode(&config))
+	st := config.Status()
+	if len(st) != 2 || st[0].Service != "dnat/opaqueid1" || st[1].Service != "sni/opaqueid2" {
+		t.Fatalf("Status = %+v", st)
+	}
+	if got, want := st[0].Provenance.String(), "grant grants[3], owner group:web-team"; got != want {
+		t.Errorf("dnat provenance = %q, want %q", got, want)
+	}
+	if got, want := st[1].Provenance.String(), "unknown"; got != want {
+		t.Errorf("sni provenance = %q, want %q", got, want)
+	}
+}
+
+func TestDomainsHash(t *testing.T) {
+	a := DomainsHash([]string{"example.com", ".example.org"})
+	if b := DomainsHash([]string{".example.org", "EXAMPLE.com", "example.com"}); a != b {
+		t.Errorf("hash depends on order, case or duplicates")
+	}
+	if b := DomainsHash([]string{"example.com"}); a == b {
+		t.Errorf("hash of different lists is equal")
+	}
+	if b := DomainsHash([]string{"example.com\n.example.org"}); a == b {
+		t.Errorf("hash of joined list is equal")
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	addr := netip.MustParseAddr("100.64.1.1")
+	other := netip.MustParseAddr("100.64.1.2")
+	ppr := func(s string) tailcfg.ProtoPortRange {
+		var ppr tailcfg.ProtoPortRange
+		must.Do(ppr.UnmarshalText([]byte(s)))
+		return ppr
+	}
+	cfg := AppConnectorConfig{
+		DNAT: map[ConfigID]DNATConfig{
+			"web":  {Addrs: []netip.Addr{addr}, To: []string{"example.com"}, IP: []tailcfg.ProtoPortRange{ppr("tcp:80"), ppr("tcp:443")}},
+			"ssh":  {Addrs: []netip.Addr{addr, other}, To: []string{"example.com"}, IP: []tailcfg.ProtoPortRange{ppr("tcp:22")}},
+			"dns":  {Addrs: []netip.Addr{addr}, To: []string{"8.8.8.8"}, IP: []tailcfg.ProtoPortRange{ppr("udp:443")}},
+			"high": {Addrs: []netip.Addr{other}, To: []string{"example.com"}, IP: []tailcfg.ProtoPortRange{ppr("20-30")}},
+		},
+		SNIProxy: map[ConfigID]SNIProxyConfig{
+			"web": {Addrs: []netip.Addr{addr}, IP: []tailcfg.ProtoPortRange{ppr("tcp:443")}, AllowedDomains: []string{"example.com"}},
+		},
+	}
+	got := cfg.Conflicts()
+	want := []Conflict{
+		{Addr: addr, A: "dnat/web", B: "sni/web", AMatch: ppr("tcp:443"), BMatch: ppr("tcp:443")},
+		{Addr: other, A: "dnat/high", B: "dnat/ssh", AMatch: ppr("20-30"), BMatch: ppr("tcp:22")},
+	}
+	assertEqual(t, "Conflicts", want, got)
+
+	delete(cfg.SNIProxy, "web")
+	delete(cfg.DNAT, "high")
+	if got := cfg.Conflicts(); len(got) != 0 {
+		t.Errorf("Conflicts = %v, want none", got)
+	}
+}
+
+func TestCheckExperiments(t *testing.T) {
+	var config AppConnectorConfig
+	must.Do(json.Unmarshal([]byte(`{"experiments": {"learn-routes": true}}`), &config))
+	if err := config.CheckExperiments(); err != nil {
+		t.Errorf("CheckExperiments = %v", err)
+	}
+	if !config.Experiment(ExperimentLearnRoutes) {
+		t.Errorf("experiment %q not enabled", ExperimentLearnRoutes)
+	}
+	if (*AppConnectorConfig)(nil).Experiment(ExperimentLearnRoutes) {
+		t.Errorf("experiment enabled in nil config")
+	}
+
+	// Unknown experiments are errors, even if disabled.
+	config.Experiments["tproxy"] = false
+	config.Experiments["l7"] = true
+	err := config.CheckExperiments()
+	if !errors.Is(err, ErrUnknownExperiment) {
+		t.Fatalf("CheckExperiments = %v, want ErrUnknownExperiment", err)
+	}
+	if got, want := err.Error(), `unknown app connector experiment: ["l7" "tproxy"] (supported: ["learn-routes"])`; got != want {
+		t.Errorf("error = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appctype
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/dnsname"
+)
+
+// Errors wrapped by the ValidationErrors of Validate, saying what's wrong
+// with a field.
+var (
+	ErrOverlappingAddrs = errors.New("overlaps the listen addresses and ports of another service")
+	ErrInvalidPorts     = errors.New("invalid protocol and port range")
+	ErrEmptyTo          = errors.New("no destinations")
+	ErrMalformedDomain  = errors.New("malformed domain")
+	ErrInvalidSource    = errors.New("invalid source")
+	ErrInvalidBackend   = errors.New("invalid TLS termination backend")
+	ErrNotNodeName      = errors.New("not a name of this node")
+)
+
+// ValidationError is a problem with a field of a service in an
+// AppConnectorConfig.
+type ValidationError struct {
+	Kind  string   // the kind of service: "dnat" or "sni"
+	ID    ConfigID // the service's ID in its map
+	Field string   // the offending field, such as "To" or "IP[1]"
+	Err   error    // what's wrong, wrapping one of the Err variables
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s/%s: %s: %v", e.Kind, e.ID, e.Field, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// ValidationErrors are the problems Validate found in a configuration,
+// sorted by service.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	var sb strings.Builder
+	for i, ve := range e {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		sb.WriteString(ve.Error())
+	}
+	return sb.String()
+}
+
+func (e ValidationErrors) Unwrap() []error {
+	ret := make([]error, len(e))
+	for i, ve := range e {
+		ret[i] = ve
+	}
+	return ret
+}
+
+// ParseTLSBackend parses the Backend of a TLSTermination, which must be an
+// http or https URL with a host.
+func ParseTLSBackend(backend string) (*url.URL, error) {
+	u, err := url.Parse(backend)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackend, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: %q isn't an http or https URL", ErrInvalidBackend, backend)
+	}
+	return u, nil
+}
+
+// Validate checks cfg for services that listen on overlapping addresses,
+// protocols and ports, invalid protocol and port ranges, DNAT services
+// without destinations, malformed domains, invalid allowed sources, and SNI
+// proxy services that terminate TLS without a valid backend or for domain
+// suffixes or patterns. It returns nil if it finds no problems, or else
+// ValidationErrors.
+func Validate(cfg *AppConnectorConfig) error {
+	var errs ValidationErrors
+	add := func(kind string, id ConfigID, field string, err error) {
+		errs = append(errs, &ValidationError{Kind: kind, ID: id, Field: field, Err: err})
+	}
+	checkIP := func(kind string, id ConfigID, ips []tailcfg.ProtoPortRange) {
+		for i, ppr := range ips {
+			if ppr.Proto < 0 || ppr.Proto > 255 || ppr.Ports.First > ppr.Ports.Last {
+				add(kind, id, fmt.Sprintf("IP[%d]", i), fmt.Errorf("%w: %v", ErrInvalidPorts, ppr))
+			}
+		}
+	}
+	checkSources := func(kind string, id ConfigID, sources []string) {
+		for i, src := range sources {
+			if _, _, err := ParseSources([]string{src}); err != nil {
+				add(kind, id, fmt.Sprintf("AllowedSources[%d]", i), err)
+			}
+		}
+	}
+
+	for id, d := range cfg.DNAT {
+		checkIP("dnat", id, d.IP)
+		checkSources("dnat", id, d.AllowedSources)
+		if len(d.To) == 0 {
+			add("dnat", id, "To", ErrEmptyTo)
+		}
+		for i, to := range d.To {
+			if _, err := netip.ParseAddr(to); err == nil {
+				continue
+			}
+			if err := checkDomain(to); err != nil {
+				add("dnat", id, fmt.Sprintf("To[%d]", i), err)
+			}
+		}
+	}
+	for id, s := range cfg.SNIProxy {
+		checkIP("sni", id, s.IP)
+		checkSources("sni", id, s.AllowedSources)
+		for i, d := range s.AllowedDomains {
+			if err := checkDomain(strings.TrimPrefix(d, ".")); err != nil {
+				add("sni", id, fmt.Sprintf("AllowedDomains[%d]", i), err)
+			} else if s.TerminateTLS != nil && strings.HasPrefix(d, ".") {
+				add("sni", id, fmt.Sprintf("AllowedDomains[%d]", i), fmt.Errorf("%w: suffix %q can't terminate TLS", ErrNotNodeName, d))
+			}
+		}
+		if s.TerminateTLS != nil {
+			if len(s.DomainPatterns) > 0 {
+				add("sni", id, "DomainPatterns", fmt.Errorf("%w: patterns can't terminate TLS", ErrNotNodeName))
+			}
+			if _, err := ParseTLSBackend(s.TerminateTLS.Backend); err != nil {
+				add("sni", id, "TerminateTLS.Backend", err)
+			}
+		}
+	}
+	for _, c := range cfg.Conflicts() {
+		kind, id, _ := strings.Cut(c.B, "/")
+		add(kind, ConfigID(id), "Addrs",
+			fmt.Errorf("%w: %v (%v) overlaps %s (%v)", ErrOverlappingAddrs, c.Addr, c.BMatch, c.A, c.AMatch))
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	slices.SortStableFunc(errs, func(a, b *ValidationError) int {
+		if c := strings.Compare(a.Kind, b.Kind); c != 0 {
+			return c
+		}
+		return strings.Compare(string(a.ID), string(b.ID))
+	})
+	return errs
+}
+
+// checkDomain returns an error wrapping ErrMalformedDomain if d isn't a
+// valid domain name.
+func checkDomain(d string) error {
+	if err := dnsname.ValidHostname(d); err != nil {
+		return fmt.Errorf("%w %q: %v", ErrMalformedDomain, d, err)
+	}
+	return nil
+}
+
+// ParseSources parses the AllowedSources of a service into the prefixes and
+// tags of the clients they allow. Addresses are returned as single-address
+// prefixes. It returns an error wrapping ErrInvalidSource if any are
+// invalid.
+func ParseSources(sources []string) (prefixes []netip.Prefix, tags []string, err error) {
+	for _, src := range sources {
+		switch {
+		case strings.HasPrefix(src, "tag:"):
+			if err := tailcfg.CheckTag(src); err != nil {
+				return nil, nil, fmt.Errorf("%w %q: %v", ErrInvalidSource, src, err)
+			}
+			tags = append(tags, src)
+		case strings.Contains(src, "/"):
+			p, err := netip.ParsePrefix(src)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w %q: %v", ErrInvalidSource, src, err)
+			}
+			prefixes = append(prefixes, p.Masked())
+		default:
+			ip, err := netip.ParseAddr(src)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w %q: %v", ErrInvalidSource, src, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(ip, ip.BitLen()))
+		}
+	}
+	return prefixes, tags, nil
+}
@@ -0,0 +1,73 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appctype
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"tailscale.com/tailcfg"
+)
+
+func TestValidate(t *testing.T) {
+	addr := netip.MustParseAddr("100.64.1.1")
+	tcp := func(first, last uint16) tailcfg.ProtoPortRange {
+		return tailcfg.ProtoPortRange{Proto: 6, Ports: tailcfg.PortRange{First: first, Last: last}}
+	}
+	cfg := &AppConnectorConfig{
+		DNAT: map[ConfigID]DNATConfig{
+			"ok":    {Addrs: []netip.Addr{addr}, To: []string{"example.com", "192.0.2.1"}, IP: []tailcfg.ProtoPortRange{tcp(80, 80)}},
+			"empty": {To: nil},
+			"bad":   {To: []string{"bad_domain.example", "example.com"}, IP: []tailcfg.ProtoPortRange{tcp(100, 90), {Proto: 256}}},
+		},
+		SNIProxy: map[ConfigID]SNIProxyConfig{
+			"web": {Addrs: []netip.Addr{addr}, IP: []tailcfg.ProtoPortRange{tcp(1, 100)}, AllowedDomains: []string{".example.com", "-x.example"}, AllowedSources: []string{"tag:ci", "10.0.0.0/33"}},
+			"term": {
+				AllowedDomains: []string{".example.com", "app.example.ts.net"},
+				DomainPatterns: []DomainPattern{{Glob: "*.example.ts.net"}},
+				TerminateTLS:   &TLSTermination{Backend: "ftp://10.0.0.1"},
+			},
+		},
+	}
+	if err := Validate(&AppConnectorConfig{DNAT: map[ConfigID]DNATConfig{"ok": cfg.DNAT["ok"]}}); err != nil {
+		t.Fatalf("Validate of valid config = %v", err)
+	}
+
+	err := Validate(cfg)
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) {
+		t.Fatalf("Validate = %v, want ValidationErrors", err)
+	}
+	type problem struct {
+		kind  string
+		id    ConfigID
+		field string
+		err   error
+	}
+	want := []problem{
+		{"dnat", "bad", "IP[0]", ErrInvalidPorts},
+		{"dnat", "bad", "IP[1]", ErrInvalidPorts},
+		{"dnat", "bad", "To[0]", ErrMalformedDomain},
+		{"dnat", "empty", "To", ErrEmptyTo},
+		{"sni", "term", "AllowedDomains[0]", ErrNotNodeName},
+		{"sni", "term", "DomainPatterns", ErrNotNodeName},
+		{"sni", "term", "TerminateTLS.Backend", ErrInvalidBackend},
+		{"sni", "web", "AllowedSources[1]", ErrInvalidSource},
+		{"sni", "web", "AllowedDomains[1]", ErrMalformedDomain},
+		{"sni", "web", "Addrs", ErrOverlappingAddrs},
+	}
+	if len(verrs) != len(want) {
+		t.Fatalf("Validate = %v, want %d problems", err, len(want))
+	}
+	for i, w := range want {
+		ve := verrs[i]
+		if ve.Kind != w.kind || ve.ID != w.id || ve.Field != w.field || !errors.Is(ve, w.err) {
+			t.Errorf("problem %d = %v, want %s/%s %s: %v", i, ve, w.kind, w.id, w.field, w.err)
+		}
+	}
+	if !errors.Is(err, ErrEmptyTo) {
+		t.Errorf("errors.Is(%v, ErrEmptyTo) = false", err)
+	}
+}
@@ -7,8 +7,16 @@
 //go:generate go run tailscale.com/cmd/viewer --type=Resolver --clonefunc=true
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
 	"net/netip"
+	"net/url"
 	"slices"
+	"strings"
+	"time"
 )
 
 // Resolver is the configuration for one DNS resolver.
@@ -17,11 +25,12 @@ type Resolver struct {
 	//  - A plain IP address for a "classic" UDP+TCP DNS resolver.
 	//    This is the common format as sent by the control plane.
 	//  - An IP:port, for tests.
-	//  - "https://resolver.com/path" for DNS over HTTPS; currently
-	//    as of 2022-09-08 only used for certain well-known resolvers
-	//    (see the publicdns package) for which the IP addresses to dial DoH are
-	//    known ahead of time, so bootstrap DNS resolution is not required.
-	//  - [TODO] "tls://resolver.com" for DNS over TCP+TLS
+	//  - "https://resolver.com/path" for DNS over HTTPS (DoH).
+	//  - "tls://resolver.com" or "tls://resolver.com:port" for DNS over
+	//    TCP+TLS (DoT), on port 853 by default.
+	//  - "http://" URLs, for DoH through an exit node's PeerAPI.
+	//
+	// See Check for the exact syntax accepted.
 	Addr string `json:",omitempty"`
 
 	// BootstrapResolution is an optional suggested resolution for the
@@ -30,9 +39,82 @@ type Resolver struct {
 	// BootstrapResolution may be empty, in which case clients should
 	// look up the DoT/DoH server using their local "classic" DNS
 	// resolver.
-	//
-	// As of 2022-09-08, BootstrapResolution is not yet used.
 	BootstrapResolution []netip.Addr `json:",omitempty"`
+
+	// TLSServerName, if non-empty, is the name to send in the TLS SNI
+	// extension and to verify the DoT/DoH server's certificate against,
+	// instead of the host in Addr. It's for servers dialed by IP whose
+	// certificates are for a name.
+	TLSServerName string `json:",omitempty"`
+
+	// PinnedSPKI, if non-empty, are the base64 (standard encoding) SHA-256
+	// digests of the SubjectPublicKeyInfo of the DoT/DoH server's
+	// certificates to accept, as in RFC 7858's SPKI pin sets. If set,
+	// the server's leaf certificate must match one of them, and it need
+	// not otherwise be trusted, so self-signed certificates work.
+	PinnedSPKI []string `json:",omitempty"`
+}
+
+// IsDoH reports whether r is a DNS-over-HTTPS resolver.
+func (r *Resolver) IsDoH() bool { return strings.HasPrefix(r.Addr, "https://") }
+
+// IsDoT reports whether r is a DNS-over-TLS resolver.
+func (r *Resolver) IsDoT() bool { return strings.HasPrefix(r.Addr, "tls://") }
+
+// Check returns an error if r is a DoH or DoT resolver with an invalid
+// address, or has invalid TLS options.
+func (r *Resolver) Check() error {
+	switch {
+	case r.IsDoH():
+		u, err := url.Parse(r.Addr)
+		if err != nil {
+			return err
+		}
+		if u.Hostname() == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("invalid DoH URL %q", r.Addr)
+		}
+	case r.IsDoT():
+		if _, _, err := r.DoTHostPort(); err != nil {
+			return err
+		}
+	case strings.HasPrefix(r.Addr, "http://"):
+		if _, err := url.Parse(r.Addr); err != nil {
+			return err
+		}
+	default:
+		// Other addresses, normally IPs, are left to the forwarder as
+		// before; they just can't have TLS options.
+		if r.TLSServerName != "" || len(r.PinnedSPKI) > 0 {
+			return fmt.Errorf("resolver %q: TLS options are only for DoH and DoT resolvers", r.Addr)
+		}
+	}
+	for _, pin := range r.PinnedSPKI {
+		if b, err := base64.StdEncoding.DecodeString(pin); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("resolver %q: invalid SPKI pin %q; want base64 SHA-256 digest", r.Addr, pin)
+		}
+	}
+	return nil
+}
+
+// DoTHostPort returns the host and port to dial the DoT resolver r at,
+// from an Addr of "tls://host" or "tls://host:port".
+func (r *Resolver) DoTHostPort() (host, port string, err error) {
+	hostPort, ok := strings.CutPrefix(r.Addr, "tls://")
+	if !ok {
+		return "", "", errors.New("not a DoT resolver")
+	}
+	host, port = hostPort, "853"
+	if strings.LastIndexByte(hostPort, ':') > strings.LastIndexByte(hostPort, ']') {
+		if host, port, err = net.SplitHostPort(hostPort); err != nil {
+			return "", "", fmt.Errorf("invalid DoT address %q: %w", r.Addr, err)
+		}
+	} else {
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+	if host == "" || strings.ContainsAny(host, "/?#@") || port == "" {
+		return "", "", fmt.Errorf("invalid DoT address %q", r.Addr)
+	}
+	return host, port, nil
 }
 
 // IPPort returns r.Addr as an IP address and port if either
@@ -62,5 +144,65 @@ func (r *Resolver) Equal(other *Resolver) bool {
 		return true
 	}
 
-	return r.Addr == other.Addr && slices.Equal(r.BootstrapResolution, other.BootstrapResolution)
+	return r.Addr == other.Addr &&
+		slices.Equal(r.BootstrapResolution, other.BootstrapResolution) &&
+		r.TLSServerName == other.TLSServerName &&
+		slices.Equal(r.PinnedSPKI, other.PinnedSPKI)
+}
+
+// OSConfiguratorStatus describes how tailscaled configures the OS's
+// DNS settings.
+type OSConfiguratorStatus struct {
+	// Mode is the mechanism tailscaled uses, such as
+	// "systemd-resolved" on Linux or "scutil" on macOS.
+	Mode string
+
+	// SupportsSplitDNS is whether the mechanism can send queries for
+	// only some DNS suffixes to tailscaled.
+	SupportsSplitDNS bool
+}
+
+// QueryTrace describes how tailscaled resolved a DNS query, for
+// debugging DNS configurations such as split DNS.
+type QueryTrace struct {
+	Name string // the name queried, as given
+	Type string // the query type, such as "A"
+
+	// Attempts are the fully qualified names tried, in order. A name
+	// without a trailing dot is expanded with the search domains like
+	// the OS resolver would, stopping at the first with answers.
+	Attempts []*QueryAttempt
+}
+
+// QueryAttempt is the resolution of one fully qualified name of a
+// QueryTrace.
+type QueryAttempt struct {
+	FQDN string
+
+	// SearchDomain is the search domain appended to the queried name
+	// to make FQDN, if any.
+	SearchDomain string `json:",omitempty"`
+
+	// Source is what handled the query, one of:
+	//  - "magicdns": tailscaled's own records and local domains
+	//  - "route": the split DNS route for the suffix in Route
+	//  - "default": the default resolvers
+	//  - "cloud": the cloud provider's resolver, as a last resort
+	//  - "none": nothing; the query fails with SERVFAIL
+	Source string
+
+	// Route is the matched split DNS suffix, if Source is "route".
+	Route string `json:",omitempty"`
+
+	// Resolvers are the upstream resolvers that were queried.
+	Resolvers []*Resolver `json:",omitempty"`
+
+	// Upstream is the address of the upstream that answered, if any.
+	Upstream string `json:",omitempty"`
+
+	RCode   string   `json:",omitempty"` // the response code, such as "NXDomain"
+	Answers []string `json:",omitempty"` // the answer records, one per line
+	Err     string   `json:",omitempty"` // why there's no response, if so
+
+	Duration time.Duration
 }
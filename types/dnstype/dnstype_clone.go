@@ -18,6 +18,7 @@ func (src *Resolver) Clone() *Resolver {
 	dst := new(Resolver)
 	*dst = *src
 	dst.BootstrapResolution = append(src.BootstrapResolution[:0:0], src.BootstrapResolution...)
+	dst.PinnedSPKI = append(src.PinnedSPKI[:0:0], src.PinnedSPKI...)
 	return dst
 }
 
@@ -25,6 +26,8 @@ func (src *Resolver) Clone() *Resolver {
 var _ResolverCloneNeedsRegeneration = Resolver(struct {
 	Addr                string
 	BootstrapResolution []netip.Addr
+	TLSServerName       string
+	PinnedSPKI          []string
 }{})
 
 // Clone duplicates src into dst and reports whether it succeeded.
@@ -17,7 +17,7 @@ func TestResolverEqual(t *testing.T) {
 		fieldNames = append(fieldNames, field.Name)
 	}
 	sort.Strings(fieldNames)
-	if !slices.Equal(fieldNames, []string{"Addr", "BootstrapResolution"}) {
+	if !slices.Equal(fieldNames, []string{"Addr", "BootstrapResolution", "PinnedSPKI", "TLSServerName"}) {
 		t.Errorf("Resolver fields changed; update test")
 	}
 
@@ -68,6 +68,18 @@ func TestResolverEqual(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name: "not equal server name",
+			a:    &Resolver{Addr: "tls://1.1.1.1", TLSServerName: "one.one.one.one"},
+			b:    &Resolver{Addr: "tls://1.1.1.1"},
+			want: false,
+		},
+		{
+			name: "not equal pins",
+			a:    &Resolver{Addr: "tls://dns.example.com", PinnedSPKI: []string{testPin}},
+			b:    &Resolver{Addr: "tls://dns.example.com"},
+			want: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -79,3 +91,52 @@ func TestResolverEqual(t *testing.T) {
 		})
 	}
 }
+
+const testPin = "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=" // SHA-256 of ""
+
+func TestResolverCheck(t *testing.T) {
+	tests := []struct {
+		r       Resolver
+		wantErr bool
+	}{
+		{Resolver{Addr: "8.8.8.8"}, false},
+		{Resolver{Addr: "[::1]:5353"}, false},
+		{Resolver{Addr: "https://dns.example.com/dns-query"}, false},
+		{Resolver{Addr: "https://10.0.0.53/dns-query", TLSServerName: "dns.corp.example.com"}, false},
+		{Resolver{Addr: "tls://dns.example.com", PinnedSPKI: []string{testPin}}, false},
+		{Resolver{Addr: "tls://10.0.0.53:8853"}, false},
+		{Resolver{Addr: "tls://[fd00::53]"}, false},
+		{Resolver{Addr: "http://100.100.100.100:1234/dns-query"}, false},
+		{Resolver{Addr: "8.8.8.8", TLSServerName: "dns.google"}, true},
+		{Resolver{Addr: "https:///dns-query"}, true},
+		{Resolver{Addr: "https://dns.example.com/dns-query?x=1"}, true},
+		{Resolver{Addr: "tls://"}, true},
+		{Resolver{Addr: "tls://dns.example.com/path"}, true},
+		{Resolver{Addr: "tls://dns.example.com", PinnedSPKI: []string{"not-a-pin"}}, true},
+	}
+	for _, tt := range tests {
+		err := tt.r.Check()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Check(%+v) = %v; want error: %v", tt.r, err, tt.wantErr)
+		}
+	}
+}
+
+func TestResolverDoTHostPort(t *testing.T) {
+	tests := []struct {
+		addr, host, port string
+	}{
+		{"tls://dns.example.com", "dns.example.com", "853"},
+		{"tls://dns.example.com:8853", "dns.example.com", "8853"},
+		{"tls://1.2.3.4", "1.2.3.4", "853"},
+		{"tls://[fd00::53]", "fd00::53", "853"},
+		{"tls://[fd00::53]:8853", "fd00::53", "8853"},
+	}
+	for _, tt := range tests {
+		r := &Resolver{Addr: tt.addr}
+		host, port, err := r.DoTHostPort()
+		if err != nil || host != tt.host || port != tt.port {
+			t.Errorf("DoTHostPort(%q) = %q, %q, %v; want %q, %q", tt.addr, host, port, err, tt.host, tt.port)
+		}
+	}
+}
@@ -64,10 +64,14 @@ func (v ResolverView) Addr() string { return v.ж.Addr }
 func (v ResolverView) BootstrapResolution() views.Slice[netip.Addr] {
 	return views.SliceOf(v.ж.BootstrapResolution)
 }
-func (v ResolverView) Equal(v2 ResolverView) bool { return v.ж.Equal(v2.ж) }
+func (v ResolverView) TLSServerName() string           { return v.ж.TLSServerName }
+func (v ResolverView) PinnedSPKI() views.Slice[string] { return views.SliceOf(v.ж.PinnedSPKI) }
+func (v ResolverView) Equal(v2 ResolverView) bool      { return v.ж.Equal(v2.ж) }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _ResolverViewNeedsRegeneration = Resolver(struct {
 	Addr                string
 	BootstrapResolution []netip.Addr
+	TLSServerName       string
+	PinnedSPKI          []string
 }{})
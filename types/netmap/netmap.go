@@ -36,7 +36,12 @@ type NetworkMap struct {
 	MachineKey key.MachinePublic
 
 	Peers []tailcfg.NodeView // sorted by Node.ID
-	DNS   tailcfg.DNSConfig
+
+	// PeerIndex, if non-nil, indexes Peers by tag, user, route and
+	// capability. If nil, the PeersWith* methods scan Peers instead.
+	PeerIndex *PeerIndex
+
+	DNS tailcfg.DNSConfig
 
 	PacketFilter      []filter.Match
 	PacketFilterRules views.Slice[tailcfg.FilterRule]
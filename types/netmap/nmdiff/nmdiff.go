@@ -0,0 +1,265 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package nmdiff computes structured, human-readable differences between
+// two NetworkMaps.
+package nmdiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+// Diff is the difference between two NetworkMaps.
+type Diff struct {
+	// Fields are the changed fields of the NetworkMap itself, other than
+	// its peers. Changes within SelfNode have paths like
+	// "SelfNode.Hostinfo.OS".
+	Fields []FieldDiff `json:",omitempty"`
+
+	// PeersAdded are the peers only in the new NetworkMap, sorted by ID.
+	PeersAdded []PeerRef `json:",omitempty"`
+
+	// PeersRemoved are the peers only in the old NetworkMap, sorted by ID.
+	PeersRemoved []PeerRef `json:",omitempty"`
+
+	// PeersChanged are the peers in both NetworkMaps with different
+	// fields, sorted by ID.
+	PeersChanged []PeerDiff `json:",omitempty"`
+}
+
+// PeerRef identifies a peer in a Diff.
+type PeerRef struct {
+	ID       tailcfg.NodeID
+	StableID tailcfg.StableNodeID `json:",omitempty"`
+	Name     string               `json:",omitempty"` // DNS name, if known
+}
+
+func (r PeerRef) String() string {
+	if r.Name != "" {
+		return fmt.Sprintf("%d (%s)", r.ID, r.Name)
+	}
+	return fmt.Sprint(r.ID)
+}
+
+// PeerDiff is the difference between two versions of a peer.
+type PeerDiff struct {
+	PeerRef
+	Fields []FieldDiff
+}
+
+// FieldDiff is a single changed field.
+type FieldDiff struct {
+	// Path is the name of the changed field, with nested struct fields
+	// separated by dots, like "DERP" or "Hostinfo.NetInfo.PreferredDERP".
+	Path string
+
+	// Old and New are the JSON encodings of the field's old and new values.
+	// Values of secret fields are replaced by a placeholder.
+	Old, New json.RawMessage
+}
+
+func (f FieldDiff) String() string {
+	return fmt.Sprintf("%s: %s => %s", f.Path, f.Old, f.New)
+}
+
+// redacted is the FieldDiff value of secret fields.
+var redacted = json.RawMessage(`"[redacted]"`)
+
+// IsEmpty reports whether d has no differences.
+func (d *Diff) IsEmpty() bool {
+	return d == nil || (len(d.Fields) == 0 && len(d.PeersAdded) == 0 &&
+		len(d.PeersRemoved) == 0 && len(d.PeersChanged) == 0)
+}
+
+// String returns a multi-line human-readable form of d.
+func (d *Diff) String() string {
+	if d.IsEmpty() {
+		return "(no changes)"
+	}
+	var sb strings.Builder
+	for _, f := range d.Fields {
+		fmt.Fprintf(&sb, "%v\n", f)
+	}
+	for _, p := range d.PeersAdded {
+		fmt.Fprintf(&sb, "+peer %v\n", p)
+	}
+	for _, p := range d.PeersRemoved {
+		fmt.Fprintf(&sb, "-peer %v\n", p)
+	}
+	for _, p := range d.PeersChanged {
+		fmt.Fprintf(&sb, "peer %v:\n", p.PeerRef)
+		for _, f := range p.Fields {
+			fmt.Fprintf(&sb, "\t%v\n", f)
+		}
+	}
+	return sb.String()
+}
+
+// Compare returns the difference from NetworkMap a to b. A nil NetworkMap
+// is treated as an empty one.
+func Compare(a, b *netmap.NetworkMap) *Diff {
+	if a == nil {
+		a = new(netmap.NetworkMap)
+	}
+	if b == nil {
+		b = new(netmap.NetworkMap)
+	}
+	d := new(Diff)
+
+	av, bv := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	for i := 0; i < av.NumField(); i++ {
+		sf := av.Type().Field(i)
+		switch sf.Name {
+		case "Peers":
+			// Compared peer-by-peer below.
+			continue
+		case "PeerIndex", "PacketFilter":
+			// Derived from Peers and PacketFilterRules, respectively.
+			continue
+		case "PrivateKey":
+			if !a.PrivateKey.Equal(b.PrivateKey) {
+				d.Fields = append(d.Fields, FieldDiff{Path: sf.Name, Old: redacted, New: redacted})
+			}
+			continue
+		}
+		if sf.IsExported() {
+			diffValue(&d.Fields, sf.Name, av.Field(i), bv.Field(i))
+		}
+	}
+
+	aps, bps := a.Peers, b.Peers
+	for len(aps) > 0 || len(bps) > 0 {
+		switch {
+		case len(bps) == 0 || len(aps) > 0 && aps[0].ID() < bps[0].ID():
+			d.PeersRemoved = append(d.PeersRemoved, peerRef(aps[0]))
+			aps = aps[1:]
+		case len(aps) == 0 || bps[0].ID() < aps[0].ID():
+			d.PeersAdded = append(d.PeersAdded, peerRef(bps[0]))
+			bps = bps[1:]
+		default:
+			if fields := CompareNodes(aps[0], bps[0]); len(fields) > 0 {
+				d.PeersChanged = append(d.PeersChanged, PeerDiff{
+					PeerRef: peerRef(bps[0]),
+					Fields:  fields,
+				})
+			}
+			aps, bps = aps[1:], bps[1:]
+		}
+	}
+	return d
+}
+
+// CompareNodes returns the fields that differ between a and b.
+func CompareNodes(a, b tailcfg.NodeView) []FieldDiff {
+	var ret []FieldDiff
+	diffValue(&ret, "", reflect.ValueOf(a), reflect.ValueOf(b))
+	return ret
+}
+
+func peerRef(n tailcfg.NodeView) PeerRef {
+	return PeerRef{ID: n.ID(), StableID: n.StableID(), Name: n.Name()}
+}
+
+// diffValue appends to *out the differences between a and b, which are of
+// the same type, at the given path.
+//
+// It descends into views, pointers to structs and structs with exported
+// fields so that changes are reported for the innermost changed field.
+// Slices and maps are compared as a whole.
+func diffValue(out *[]FieldDiff, path string, a, b reflect.Value) {
+	t := a.Type()
+	if _, ok := t.MethodByName("AsStruct"); ok && t.Kind() == reflect.Struct {
+		av, bv := viewValid(a), viewValid(b)
+		switch {
+		case !av && !bv:
+		case av && bv:
+			diffValue(out, path, callAsStruct(a), callAsStruct(b))
+		default:
+			appendDiff(out, path, a, b)
+		}
+		return
+	}
+	switch {
+	case t.Kind() == reflect.Pointer && t.Elem().Kind() == reflect.Struct:
+		switch {
+		case a.IsNil() && b.IsNil():
+		case !a.IsNil() && !b.IsNil():
+			diffValue(out, path, a.Elem(), b.Elem())
+		default:
+			appendDiff(out, path, a, b)
+		}
+	case t.Kind() == reflect.Struct && hasExportedFields(t) && !hasEqualMethod(t):
+		for i := 0; i < t.NumField(); i++ {
+			if sf := t.Field(i); sf.IsExported() {
+				diffValue(out, joinPath(path, sf.Name), a.Field(i), b.Field(i))
+			}
+		}
+	default:
+		if !valuesEqual(a, b) {
+			appendDiff(out, path, a, b)
+		}
+	}
+}
+
+func appendDiff(out *[]FieldDiff, path string, a, b reflect.Value) {
+	*out = append(*out, FieldDiff{
+		Path: path,
+		Old:  jsonOf(a),
+		New:  jsonOf(b),
+	})
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func viewValid(v reflect.Value) bool {
+	m := v.MethodByName("Valid")
+	return m.IsValid() && m.Call(nil)[0].Bool()
+}
+
+func callAsStruct(v reflect.Value) reflect.Value {
+	return v.MethodByName("AsStruct").Call(nil)[0]
+}
+
+func hasExportedFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).IsExported() {
+			return true
+		}
+	}
+	return false
+}
+
+// hasEqualMethod reports whether t has a method "Equal(t) bool".
+func hasEqualMethod(t reflect.Type) bool {
+	m, ok := t.MethodByName("Equal")
+	return ok && m.Type.NumIn() == 2 && m.Type.In(1) == t &&
+		m.Type.NumOut() == 1 && m.Type.Out(0).Kind() == reflect.Bool
+}
+
+// valuesEqual reports whether a and b are equal, using their Equal method
+// if they have one (such as time.Time) and reflect.DeepEqual otherwise.
+func valuesEqual(a, b reflect.Value) bool {
+	if hasEqualMethod(a.Type()) {
+		return a.MethodByName("Equal").Call([]reflect.Value{b})[0].Bool()
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+func jsonOf(v reflect.Value) json.RawMessage {
+	j, err := json.Marshal(v.Interface())
+	if err != nil {
+		j, _ = json.Marshal(fmt.Sprintf("[json error: %v]", err))
+	}
+	return j
+}
@@ -0,0 +1,104 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package nmdiff
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/ptr"
+)
+
+func TestCompare(t *testing.T) {
+	self := &tailcfg.Node{
+		ID:       1,
+		Name:     "self.example.ts.net.",
+		Hostinfo: (&tailcfg.Hostinfo{OS: "linux"}).View(),
+	}
+	peer := func(id tailcfg.NodeID, derp string) *tailcfg.Node {
+		return &tailcfg.Node{
+			ID:        id,
+			StableID:  tailcfg.StableNodeID(fmt.Sprint("s", id)),
+			Name:      fmt.Sprintf("peer%d.example.ts.net.", id),
+			DERP:      derp,
+			Addresses: []netip.Prefix{netip.MustParsePrefix(fmt.Sprintf("100.64.0.%d/32", id))},
+			KeyExpiry: time.Unix(100, 0),
+		}
+	}
+
+	a := &netmap.NetworkMap{
+		SelfNode:   self.View(),
+		PrivateKey: key.NewNode(),
+		Domain:     "example.com",
+		Peers: []tailcfg.NodeView{
+			peer(2, "127.3.3.40:1").View(),
+			peer(3, "127.3.3.40:1").View(),
+			peer(4, "127.3.3.40:1").View(),
+		},
+	}
+
+	self2 := self.Clone()
+	self2.Hostinfo = (&tailcfg.Hostinfo{OS: "windows"}).View()
+	p3 := peer(3, "127.3.3.40:2")
+	p3.LastSeen = ptr.To(time.Unix(200, 0))
+	p4 := peer(4, "127.3.3.40:1")
+	p4.KeyExpiry = time.Unix(100, 0).In(time.FixedZone("x", 3600)) // same instant
+	b := &netmap.NetworkMap{
+		SelfNode:   self2.View(),
+		PrivateKey: key.NewNode(),
+		Domain:     "example.com",
+		Peers: []tailcfg.NodeView{
+			p3.View(),
+			p4.View(),
+			peer(5, "127.3.3.40:1").View(),
+		},
+	}
+
+	d := Compare(a, b)
+	if d.IsEmpty() {
+		t.Fatal("diff is empty")
+	}
+	paths := func(fs []FieldDiff) (ret []string) {
+		for _, f := range fs {
+			ret = append(ret, f.Path)
+		}
+		return ret
+	}
+	if got, want := paths(d.Fields), []string{"SelfNode.Hostinfo.OS", "PrivateKey"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Fields = %q; want %q", got, want)
+	}
+	for _, f := range d.Fields {
+		if f.Path == "PrivateKey" && (string(f.Old) != string(redacted) || string(f.New) != string(redacted)) {
+			t.Errorf("PrivateKey not redacted: %v", f)
+		}
+	}
+	if len(d.PeersAdded) != 1 || d.PeersAdded[0].ID != 5 {
+		t.Errorf("PeersAdded = %v; want [5]", d.PeersAdded)
+	}
+	if len(d.PeersRemoved) != 1 || d.PeersRemoved[0].ID != 2 {
+		t.Errorf("PeersRemoved = %v; want [2]", d.PeersRemoved)
+	}
+	if len(d.PeersChanged) != 1 || d.PeersChanged[0].ID != 3 {
+		t.Fatalf("PeersChanged = %v; want only peer 3", d.PeersChanged)
+	}
+	if got, want := paths(d.PeersChanged[0].Fields), []string{"DERP", "LastSeen"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("peer 3 fields = %q; want %q", got, want)
+	}
+	if got, want := d.PeersChanged[0].Fields[0].String(), `DERP: "127.3.3.40:1" => "127.3.3.40:2"`; got != want {
+		t.Errorf("DERP diff = %q; want %q", got, want)
+	}
+
+	if d := Compare(a, a); !d.IsEmpty() {
+		t.Errorf("Compare(a, a) = %v; want empty", d)
+	}
+	if d := Compare(nil, a); len(d.PeersAdded) != 3 {
+		t.Errorf("Compare(nil, a) added %v; want 3 peers", d.PeersAdded)
+	}
+}
@@ -42,10 +42,15 @@ func (m NodeMutationDERPHome) Apply(n *tailcfg.Node) {
 type NodeMutationEndpoints struct {
 	mutatingNodeID
 	Endpoints []netip.AddrPort
+
+	// EndpointTypes, if non-nil, are the types of the corresponding
+	// Endpoints.
+	EndpointTypes []tailcfg.EndpointType
 }
 
 func (m NodeMutationEndpoints) Apply(n *tailcfg.Node) {
 	n.Endpoints = slices.Clone(m.Endpoints)
+	n.EndpointTypes = slices.Clone(m.EndpointTypes)
 }
 
 // NodeMutationOnline is a NodeMutation that says a node is now online or
@@ -101,7 +106,14 @@ func NodeMutationsFromPatch(p *tailcfg.PeerChange) (_ []NodeMutation, ok bool) {
 		case "DERPRegion":
 			ret = append(ret, NodeMutationDERPHome{mutatingNodeID(p.NodeID), p.DERPRegion})
 		case "Endpoints":
-			ret = append(ret, NodeMutationEndpoints{mutatingNodeID(p.NodeID), slices.Clone(p.Endpoints)})
+			ret = append(ret, NodeMutationEndpoints{mutatingNodeID(p.NodeID), slices.Clone(p.Endpoints), slices.Clone(p.EndpointTypes)})
+		case "EndpointTypes":
+			if p.Endpoints == nil {
+				// Types without endpoints are meaningless.
+				return nil, false
+			}
+			// Handled above in the "Endpoints" case.
+			continue
 		case "Online":
 			ret = append(ret, NodeMutationOnline{mutatingNodeID(p.NodeID), *p.Online})
 		case "LastSeen":
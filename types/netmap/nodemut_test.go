@@ -97,10 +97,29 @@ func TestMutationsFromMapResponse(t *testing.T) {
 				Endpoints: eps("8.9.10.11:1234"),
 			}),
 			want: muts(
-				NodeMutationEndpoints{1, []netip.AddrPort{netip.MustParseAddrPort("1.2.3.4:567")}},
-				NodeMutationEndpoints{2, []netip.AddrPort{netip.MustParseAddrPort("8.9.10.11:1234")}},
+				NodeMutationEndpoints{1, []netip.AddrPort{netip.MustParseAddrPort("1.2.3.4:567")}, nil},
+				NodeMutationEndpoints{2, []netip.AddrPort{netip.MustParseAddrPort("8.9.10.11:1234")}, nil},
 			),
 		},
+		{
+			name: "patch-ep-types",
+			mr: fromChanges(&tailcfg.PeerChange{
+				NodeID:        1,
+				Endpoints:     eps("1.2.3.4:567"),
+				EndpointTypes: []tailcfg.EndpointType{tailcfg.EndpointSTUN},
+			}),
+			want: muts(
+				NodeMutationEndpoints{1, []netip.AddrPort{netip.MustParseAddrPort("1.2.3.4:567")}, []tailcfg.EndpointType{tailcfg.EndpointSTUN}},
+			),
+		},
+		{
+			name: "patch-ep-types-without-eps",
+			mr: fromChanges(&tailcfg.PeerChange{
+				NodeID:        1,
+				EndpointTypes: []tailcfg.EndpointType{tailcfg.EndpointSTUN},
+			}),
+			want: nil,
+		},
 		{
 			name: "patch-derp",
 			mr: fromChanges(&tailcfg.PeerChange{
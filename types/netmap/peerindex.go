@@ -0,0 +1,254 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netmap
+
+import (
+	"net/netip"
+	"slices"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/views"
+	"tailscale.com/util/cmpx"
+)
+
+// PeerIndex indexes a NetworkMap's peers by tag, user, advertised route and
+// capability, so lookups don't need to scan every peer.
+//
+// A PeerIndex is immutable. It holds node IDs rather than nodes, so it stays
+// valid across peer mutations that don't change any indexed field.
+type PeerIndex struct {
+	byTag   map[string][]tailcfg.NodeID
+	byUser  map[tailcfg.UserID][]tailcfg.NodeID
+	byRoute map[netip.Prefix][]tailcfg.NodeID
+	byCap   map[tailcfg.NodeCapability][]tailcfg.NodeID
+}
+
+// Tag returns the sorted IDs of peers with the given ACL tag.
+func (x *PeerIndex) Tag(tag string) []tailcfg.NodeID { return x.byTag[tag] }
+
+// User returns the sorted IDs of peers owned by uid.
+func (x *PeerIndex) User(uid tailcfg.UserID) []tailcfg.NodeID { return x.byUser[uid] }
+
+// Route returns the sorted IDs of peers whose AllowedIPs include the route
+// pfx, other than as one of the peer's own addresses.
+func (x *PeerIndex) Route(pfx netip.Prefix) []tailcfg.NodeID { return x.byRoute[pfx] }
+
+// Cap returns the sorted IDs of peers with the capability c, either in
+// Capabilities or CapMap.
+func (x *PeerIndex) Cap(c tailcfg.NodeCapability) []tailcfg.NodeID { return x.byCap[c] }
+
+// BuildPeerIndex returns a PeerIndex of peers.
+func BuildPeerIndex(peers []tailcfg.NodeView) *PeerIndex {
+	var b PeerIndexBuilder
+	for _, p := range peers {
+		b.Upsert(tailcfg.NodeView{}, p)
+	}
+	return b.Index()
+}
+
+// PeerIndexBuilder incrementally maintains a PeerIndex as peers are added,
+// changed and removed. The zero value is ready for use.
+type PeerIndexBuilder struct {
+	idx PeerIndex
+
+	// shared is whether idx's maps are referenced by a PeerIndex returned
+	// by Index, and so must be cloned before being modified. The slices
+	// in the maps are never modified in place.
+	shared bool
+}
+
+// Index returns a PeerIndex of the peers added so far. Later changes to b
+// don't affect the returned PeerIndex.
+func (b *PeerIndexBuilder) Index() *PeerIndex {
+	b.shared = true
+	ret := b.idx
+	return &ret
+}
+
+// Upsert updates the index for a peer changing from old to n. Old is the
+// invalid NodeView if n is a new peer.
+func (b *PeerIndexBuilder) Upsert(old, n tailcfg.NodeView) {
+	b.update(old, n, n.ID())
+}
+
+// Remove removes the peer n from the index.
+func (b *PeerIndexBuilder) Remove(n tailcfg.NodeView) {
+	b.update(n, tailcfg.NodeView{}, n.ID())
+}
+
+func (b *PeerIndexBuilder) update(old, n tailcfg.NodeView, id tailcfg.NodeID) {
+	oldKeys, newKeys := peerIndexKeysOf(old), peerIndexKeysOf(n)
+	if oldKeys.equal(newKeys) {
+		return
+	}
+	if b.shared {
+		b.idx = PeerIndex{
+			byTag:   cloneMap(b.idx.byTag),
+			byUser:  cloneMap(b.idx.byUser),
+			byRoute: cloneMap(b.idx.byRoute),
+			byCap:   cloneMap(b.idx.byCap),
+		}
+		b.shared = false
+	}
+	updateIndex(&b.idx.byTag, id, oldKeys.tags, newKeys.tags)
+	updateIndex(&b.idx.byUser, id, oldKeys.users, newKeys.users)
+	updateIndex(&b.idx.byRoute, id, oldKeys.routes, newKeys.routes)
+	updateIndex(&b.idx.byCap, id, oldKeys.caps, newKeys.caps)
+}
+
+// peerIndexKeys are the index keys of a single peer.
+type peerIndexKeys struct {
+	tags   []string
+	users  []tailcfg.UserID
+	routes []netip.Prefix
+	caps   []tailcfg.NodeCapability
+}
+
+func (k peerIndexKeys) equal(o peerIndexKeys) bool {
+	return slices.Equal(k.tags, o.tags) &&
+		slices.Equal(k.users, o.users) &&
+		slices.Equal(k.routes, o.routes) &&
+		slices.Equal(k.caps, o.caps)
+}
+
+// peerIndexKeysOf returns the index keys of n, or the zero value if n is
+// invalid.
+func peerIndexKeysOf(n tailcfg.NodeView) (k peerIndexKeys) {
+	if !n.Valid() {
+		return k
+	}
+	k.tags = n.Tags().AsSlice()
+	k.users = []tailcfg.UserID{n.User()}
+	for i := range n.AllowedIPs().LenIter() {
+		pfx := n.AllowedIPs().At(i)
+		if !views.SliceContains(n.Addresses(), pfx) {
+			k.routes = append(k.routes, pfx)
+		}
+	}
+	k.caps = n.Capabilities().AsSlice()
+	n.CapMap().Range(func(c tailcfg.NodeCapability, _ views.Slice[tailcfg.RawMessage]) bool {
+		if !slices.Contains(k.caps, c) {
+			k.caps = append(k.caps, c)
+		}
+		return true
+	})
+	slices.Sort(k.tags)
+	slices.SortFunc(k.routes, func(a, b netip.Prefix) int {
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c
+		}
+		return cmpx.Compare(a.Bits(), b.Bits())
+	})
+	slices.Sort(k.caps)
+	return k
+}
+
+// updateIndex updates *m for the node id changing from having the keys in
+// old to having the keys in new. The slices in *m are replaced rather than
+// modified, as they may be shared with a previously returned PeerIndex.
+func updateIndex[K comparable](m *map[K][]tailcfg.NodeID, id tailcfg.NodeID, old, new []K) {
+	for _, k := range old {
+		if slices.Contains(new, k) {
+			continue
+		}
+		ids := (*m)[k]
+		if i, ok := slices.BinarySearch(ids, id); ok {
+			if len(ids) == 1 {
+				delete(*m, k)
+				continue
+			}
+			(*m)[k] = slices.Delete(slices.Clone(ids), i, i+1)
+		}
+	}
+	for _, k := range new {
+		if slices.Contains(old, k) {
+			continue
+		}
+		if *m == nil {
+			*m = make(map[K][]tailcfg.NodeID)
+		}
+		ids := (*m)[k]
+		if i, ok := slices.BinarySearch(ids, id); !ok {
+			(*m)[k] = slices.Insert(slices.Clip(ids), i, id)
+		}
+	}
+}
+
+func cloneMap[K comparable, V any](m map[K]V) map[K]V {
+	if m == nil {
+		return nil
+	}
+	ret := make(map[K]V, len(m))
+	for k, v := range m {
+		ret[k] = v
+	}
+	return ret
+}
+
+// peersByID returns the peers in nm with the given IDs.
+func (nm *NetworkMap) peersByID(ids []tailcfg.NodeID) []tailcfg.NodeView {
+	var ret []tailcfg.NodeView
+	for _, id := range ids {
+		if i := nm.PeerIndexByNodeID(id); i >= 0 {
+			ret = append(ret, nm.Peers[i])
+		}
+	}
+	return ret
+}
+
+// peersMatching returns the peers in nm for which f returns true.
+func (nm *NetworkMap) peersMatching(f func(peerIndexKeys) bool) []tailcfg.NodeView {
+	var ret []tailcfg.NodeView
+	for _, p := range nm.Peers {
+		if f(peerIndexKeysOf(p)) {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// PeersWithTag returns the peers with the given ACL tag, sorted by Node.ID.
+func (nm *NetworkMap) PeersWithTag(tag string) []tailcfg.NodeView {
+	if nm == nil {
+		return nil
+	}
+	if nm.PeerIndex != nil {
+		return nm.peersByID(nm.PeerIndex.Tag(tag))
+	}
+	return nm.peersMatching(func(k peerIndexKeys) bool { return slices.Contains(k.tags, tag) })
+}
+
+// PeersOfUser returns the peers owned by uid, sorted by Node.ID.
+func (nm *NetworkMap) PeersOfUser(uid tailcfg.UserID) []tailcfg.NodeView {
+	if nm == nil {
+		return nil
+	}
+	if nm.PeerIndex != nil {
+		return nm.peersByID(nm.PeerIndex.User(uid))
+	}
+	return nm.peersMatching(func(k peerIndexKeys) bool { return slices.Contains(k.users, uid) })
+}
+
+// PeersWithRoute returns the peers routing pfx (exactly, not a containing
+// prefix), sorted by Node.ID.
+func (nm *NetworkMap) PeersWithRoute(pfx netip.Prefix) []tailcfg.NodeView {
+	if nm == nil {
+		return nil
+	}
+	if nm.PeerIndex != nil {
+		return nm.peersByID(nm.PeerIndex.Route(pfx))
+	}
+	return nm.peersMatching(func(k peerIndexKeys) bool { return slices.Contains(k.routes, pfx) })
+}
+
+// PeersWithCap returns the peers with the capability c, sorted by Node.ID.
+func (nm *NetworkMap) PeersWithCap(c tailcfg.NodeCapability) []tailcfg.NodeView {
+	if nm == nil {
+		return nil
+	}
+	if nm.PeerIndex != nil {
+		return nm.peersByID(nm.PeerIndex.Cap(c))
+	}
+	return nm.peersMatching(func(k peerIndexKeys) bool { return slices.Contains(k.caps, c) })
+}
@@ -0,0 +1,107 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netmap
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/tailcfg"
+)
+
+func TestPeerIndex(t *testing.T) {
+	route := netip.MustParsePrefix("10.0.0.0/24")
+	n1 := &tailcfg.Node{
+		ID:         1,
+		User:       10,
+		Tags:       []string{"tag:web"},
+		Addresses:  []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+		AllowedIPs: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32"), route},
+	}
+	n2 := &tailcfg.Node{
+		ID:           2,
+		User:         10,
+		Capabilities: []tailcfg.NodeCapability{tailcfg.CapabilityAdmin},
+	}
+	n3 := &tailcfg.Node{
+		ID:     3,
+		User:   20,
+		Tags:   []string{"tag:db", "tag:web"},
+		CapMap: tailcfg.NodeCapMap{tailcfg.CapabilityAdmin: nil},
+	}
+
+	var b PeerIndexBuilder
+	for _, n := range []*tailcfg.Node{n1, n2, n3} {
+		b.Upsert(tailcfg.NodeView{}, n.View())
+	}
+	idx := b.Index()
+
+	ids := func(ids ...tailcfg.NodeID) []tailcfg.NodeID { return ids }
+	checks := []struct {
+		name string
+		got  []tailcfg.NodeID
+		want []tailcfg.NodeID
+	}{
+		{"tag:web", idx.Tag("tag:web"), ids(1, 3)},
+		{"tag:db", idx.Tag("tag:db"), ids(3)},
+		{"user10", idx.User(10), ids(1, 2)},
+		{"route", idx.Route(route), ids(1)},
+		{"self-addr-not-route", idx.Route(netip.MustParsePrefix("100.64.0.1/32")), nil},
+		{"admin", idx.Cap(tailcfg.CapabilityAdmin), ids(2, 3)},
+	}
+	for _, c := range checks {
+		if !reflect.DeepEqual(c.got, c.want) {
+			t.Errorf("%s: got %v; want %v", c.name, c.got, c.want)
+		}
+	}
+
+	// Changes after Index must not affect the returned PeerIndex.
+	n3b := n3.Clone()
+	n3b.Tags = []string{"tag:db"}
+	b.Upsert(n3.View(), n3b.View())
+	b.Remove(n1.View())
+	if got := idx.Tag("tag:web"); !reflect.DeepEqual(got, ids(1, 3)) {
+		t.Errorf("old index changed: tag:web = %v", got)
+	}
+	idx2 := b.Index()
+	if got := idx2.Tag("tag:web"); got != nil {
+		t.Errorf("new index tag:web = %v; want none", got)
+	}
+	if got := idx2.User(10); !reflect.DeepEqual(got, ids(2)) {
+		t.Errorf("new index user10 = %v; want [2]", got)
+	}
+	if got := idx2.Route(route); got != nil {
+		t.Errorf("new index route = %v; want none", got)
+	}
+}
+
+func TestNetworkMapPeersWith(t *testing.T) {
+	peers := []tailcfg.NodeView{
+		(&tailcfg.Node{ID: 1, User: 10, Tags: []string{"tag:web"}}).View(),
+		(&tailcfg.Node{ID: 2, User: 20, Tags: []string{"tag:web"}}).View(),
+		(&tailcfg.Node{ID: 3, User: 10}).View(),
+	}
+	idOf := func(nv []tailcfg.NodeView) (ret []tailcfg.NodeID) {
+		for _, n := range nv {
+			ret = append(ret, n.ID())
+		}
+		return ret
+	}
+	for _, withIndex := range []bool{false, true} {
+		nm := &NetworkMap{Peers: peers}
+		if withIndex {
+			nm.PeerIndex = BuildPeerIndex(peers)
+		}
+		if got, want := idOf(nm.PeersWithTag("tag:web")), []tailcfg.NodeID{1, 2}; !reflect.DeepEqual(got, want) {
+			t.Errorf("withIndex=%v: PeersWithTag = %v; want %v", withIndex, got, want)
+		}
+		if got, want := idOf(nm.PeersOfUser(10)), []tailcfg.NodeID{1, 3}; !reflect.DeepEqual(got, want) {
+			t.Errorf("withIndex=%v: PeersOfUser = %v; want %v", withIndex, got, want)
+		}
+		if got := nm.PeersWithCap(tailcfg.CapabilityAdmin); len(got) != 0 {
+			t.Errorf("withIndex=%v: PeersWithCap = %v; want none", withIndex, idOf(got))
+		}
+	}
+}
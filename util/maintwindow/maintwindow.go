@@ -0,0 +1,213 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package maintwindow parses and evaluates maintenance windows: recurring
+// periods, described with a cron-like spec, during which disruptive
+// actions such as updates are allowed.
+package maintwindow
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxDuration is the longest a maintenance window may last.
+const MaxDuration = 7 * 24 * time.Hour
+
+// searchLimit is how far ahead Next looks for the start of a window.
+const searchLimit = 366 * 24 * time.Hour
+
+// Window is a parsed maintenance window.
+type Window struct {
+	spec string
+	loc  *time.Location
+	dur  time.Duration
+
+	minute, hour, dom, month, dow uint64 // bitsets of matching values
+
+	// domStar and dowStar are whether the day of month and day of
+	// week fields are "*". As in cron, if neither is, a day matches
+	// if either field does.
+	domStar, dowStar bool
+}
+
+// Parse parses a maintenance window spec. A spec is a standard five
+// field cron schedule (minute, hour, day of month, month and day of
+// week) of the times windows open, followed by how long each window
+// lasts, as a Go duration. An optional leading "CRON_TZ=<zone>" sets
+// the time zone of the schedule, which is otherwise local time.
+//
+// For example, "0 2 * * mon-fri 2h" is from 02:00 to 04:00 on weekdays
+// and "CRON_TZ=UTC 30 22 1 * * 90m" is from 22:30 to 00:00 UTC on the
+// first of each month.
+func Parse(spec string) (*Window, error) {
+	fields := strings.Fields(spec)
+	w := &Window{spec: strings.Join(fields, " "), loc: time.Local}
+	if len(fields) > 0 {
+		if tz, ok := strings.CutPrefix(fields[0], "CRON_TZ="); ok {
+			loc, err := time.LoadLocation(tz)
+			if err != nil {
+				return nil, fmt.Errorf("maintenance window %q: %w", spec, err)
+			}
+			w.loc = loc
+			fields = fields[1:]
+		}
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("maintenance window %q: want 5 schedule fields and a duration, got %d fields", spec, len(fields))
+	}
+	var err error
+	parse := func(dst *uint64, f string, min, max int, names []string) {
+		if err == nil {
+			*dst, err = parseField(f, min, max, names)
+		}
+	}
+	parse(&w.minute, fields[0], 0, 59, nil)
+	parse(&w.hour, fields[1], 0, 23, nil)
+	parse(&w.dom, fields[2], 1, 31, nil)
+	parse(&w.month, fields[3], 1, 12, monthNames)
+	parse(&w.dow, fields[4], 0, 7, dowNames)
+	if err != nil {
+		return nil, fmt.Errorf("maintenance window %q: %w", spec, err)
+	}
+	if w.dow&(1<<7) != 0 {
+		w.dow |= 1 << 0 // 7 is also Sunday
+	}
+	w.domStar = fields[2] == "*"
+	w.dowStar = fields[4] == "*"
+
+	w.dur, err = time.ParseDuration(fields[5])
+	if err != nil {
+		return nil, fmt.Errorf("maintenance window %q: %w", spec, err)
+	}
+	if w.dur < time.Minute || w.dur > MaxDuration {
+		return nil, fmt.Errorf("maintenance window %q: duration must be between 1m and %v", spec, MaxDuration)
+	}
+	return w, nil
+}
+
+var (
+	monthNames = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dowNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// parseField parses a comma-separated list of values, "a-b" ranges and
+// "*", each optionally with a "/step", in [min, max]. names, if
+// non-nil, are alternative names for values, indexed by value.
+func parseField(f string, min, max int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(f, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = parseValue(loStr, min, max, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = parseValue(hiStr, min, max, names); err != nil {
+					return 0, err
+				}
+				if hi < lo {
+					return 0, fmt.Errorf("bad range %q", rng)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(s string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if name != "" && strings.EqualFold(s, name) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad value %q", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", v, min, max)
+	}
+	return v, nil
+}
+
+// String returns the spec w was parsed from.
+func (w *Window) String() string { return w.spec }
+
+// Duration returns how long each window lasts.
+func (w *Window) Duration() time.Duration { return w.dur }
+
+// dayMatches reports whether windows may open on t's day.
+func (w *Window) dayMatches(t time.Time) bool {
+	if w.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	domOK := w.dom&(1<<t.Day()) != 0
+	dowOK := w.dow&(1<<int(t.Weekday())) != 0
+	if !w.domStar && !w.dowStar {
+		return domOK || dowOK
+	}
+	return domOK && dowOK
+}
+
+// opensAt reports whether a window opens at t, which must be in w.loc
+// and a whole minute.
+func (w *Window) opensAt(t time.Time) bool {
+	return w.minute&(1<<t.Minute()) != 0 &&
+		w.hour&(1<<t.Hour()) != 0 &&
+		w.dayMatches(t)
+}
+
+// Contains reports whether t is within a window.
+func (w *Window) Contains(t time.Time) bool {
+	t = t.In(w.loc)
+	start := t.Truncate(time.Minute)
+	for s := start; t.Sub(s) < w.dur; s = s.Add(-time.Minute) {
+		if w.opensAt(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// errNoWindow is returned by Next when no window opens within a year.
+var errNoWindow = errors.New("no maintenance window within a year")
+
+// Next returns the time the next window after t opens. It returns an
+// error if none does within a year, such as for "0 0 30 2 * 1h".
+func (w *Window) Next(t time.Time) (time.Time, error) {
+	t = t.In(w.loc)
+	s := t.Truncate(time.Minute).Add(time.Minute)
+	for end := t.Add(searchLimit); s.Before(end); {
+		if !w.dayMatches(s) {
+			y, m, d := s.Date()
+			s = time.Date(y, m, d+1, 0, 0, 0, 0, w.loc)
+			continue
+		}
+		if w.opensAt(s) {
+			return s, nil
+		}
+		s = s.Add(time.Minute)
+	}
+	return time.Time{}, errNoWindow
+}
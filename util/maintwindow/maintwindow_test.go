@@ -0,0 +1,87 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package maintwindow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for spec, ok := range map[string]bool{
+		"0 2 * * mon-fri 2h":                 true,
+		"CRON_TZ=UTC 30 22 1 * * 90m":        true,
+		"*/15 * * * * 5m":                    true,
+		"0 0 * jan,jul 0,7 24h":              true,
+		"0 2 * * *":                          false,
+		"60 2 * * * 1h":                      false,
+		"0 2 * * 8 1h":                       false,
+		"0 2 * * fri-mon 1h":                 false,
+		"0 2 * * * 0s":                       false,
+		"0 2 * * * 200h":                     false,
+		"CRON_TZ=Nowhere/Bogus 0 2 * * * 1h": false,
+	} {
+		if _, err := Parse(spec); (err == nil) != ok {
+			t.Errorf("Parse(%q) = %v; want ok=%v", spec, err, ok)
+		}
+	}
+}
+
+func TestWindow(t *testing.T) {
+	w, err := Parse("CRON_TZ=UTC 0 22 * * mon-fri 3h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(s string) time.Time {
+		t.Helper()
+		tm, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	// 2023-11-17 is a Friday.
+	for ts, want := range map[string]bool{
+		"2023-11-17T21:59:59Z": false,
+		"2023-11-17T22:00:00Z": true,
+		"2023-11-18T00:59:00Z": true, // Saturday, but in Friday's window
+		"2023-11-18T01:00:00Z": false,
+		"2023-11-18T22:30:00Z": false,
+		"2023-11-20T23:00:00Z": true,
+	} {
+		if got := w.Contains(at(ts)); got != want {
+			t.Errorf("Contains(%s) = %v; want %v", ts, got, want)
+		}
+	}
+
+	next, err := w.Next(at("2023-11-17T22:30:00Z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := at("2023-11-20T22:00:00Z"); !next.Equal(want) {
+		t.Errorf("Next = %v; want %v", next, want)
+	}
+
+	never, err := Parse("0 0 30 feb * 1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := never.Next(at("2023-11-17T22:30:00Z")); err == nil {
+		t.Error("Next of Feb 30 window: got nil error")
+	}
+}
+
+func TestDayOfMonthOrWeek(t *testing.T) {
+	// As in cron, with both fields restricted, either matching is enough.
+	w, err := Parse("CRON_TZ=UTC 0 0 1 * sun 1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ts := range []string{"2023-11-01T00:30:00Z", "2023-11-19T00:30:00Z"} {
+		tm, _ := time.Parse(time.RFC3339, ts)
+		if !w.Contains(tm) {
+			t.Errorf("Contains(%s) = false; want true", ts)
+		}
+	}
+}
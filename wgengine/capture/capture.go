@@ -8,6 +8,7 @@
 	"bytes"
 	"context"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"net/http"
 	"sync"
@@ -37,26 +38,125 @@
 
 const flushPeriod = 100 * time.Millisecond
 
-func writePcapHeader(w io.Writer) {
+// maxSnaplen is the most bytes of each packet recorded, and the snaplen
+// used when OutputOptions doesn't set one.
+const maxSnaplen = 65535
+
+// Format is a packet capture file format.
+type Format uint8
+
+const (
+	// FormatPcap is the classic libpcap format.
+	FormatPcap Format = iota
+	// FormatPcapng is the pcapng format.
+	FormatPcapng
+)
+
+// ParseFormat parses a Format from its name, "pcap" or "pcapng".
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "pcap":
+		return FormatPcap, nil
+	case "pcapng":
+		return FormatPcapng, nil
+	}
+	return 0, fmt.Errorf("unknown capture format %q; want \"pcap\" or \"pcapng\"", s)
+}
+
+func (f Format) String() string {
+	if f == FormatPcapng {
+		return "pcapng"
+	}
+	return "pcap"
+}
+
+// OutputOptions are the options for a capture output.
+type OutputOptions struct {
+	// Filter, if non-nil, selects which packets the output records.
+	Filter *Filter
+
+	// Format is the stream's file format.
+	Format Format
+
+	// Snaplen, if positive, is the most bytes of each packet to record.
+	// Tailscale's debugging data before each packet doesn't count
+	// against it.
+	Snaplen int
+}
+
+func (o OutputOptions) snaplen() int {
+	if o.Snaplen <= 0 || o.Snaplen > maxSnaplen {
+		return maxSnaplen
+	}
+	return o.Snaplen
+}
+
+func writePcapHeader(w io.Writer, snaplen int) {
 	binary.Write(w, binary.LittleEndian, uint32(0xA1B2C3D4)) // pcap magic number
 	binary.Write(w, binary.LittleEndian, uint16(2))          // version major
 	binary.Write(w, binary.LittleEndian, uint16(4))          // version minor
 	binary.Write(w, binary.LittleEndian, uint32(0))          // this zone
 	binary.Write(w, binary.LittleEndian, uint32(0))          // zone significant figures
-	binary.Write(w, binary.LittleEndian, uint32(65535))      // max packet len
+	binary.Write(w, binary.LittleEndian, uint32(snaplen))    // max packet len
 	binary.Write(w, binary.LittleEndian, uint32(147))        // link-layer ID - USER0
 }
 
-func writePktHeader(w *bytes.Buffer, when time.Time, length int) {
+func writePktHeader(w *bytes.Buffer, when time.Time, capLen, length int) {
 	s := when.Unix()
 	us := when.UnixMicro() - (s * 1000000)
 
 	binary.Write(w, binary.LittleEndian, uint32(s))      // timestamp in seconds
 	binary.Write(w, binary.LittleEndian, uint32(us))     // timestamp microseconds
-	binary.Write(w, binary.LittleEndian, uint32(length)) // length present
+	binary.Write(w, binary.LittleEndian, uint32(capLen)) // length present
 	binary.Write(w, binary.LittleEndian, uint32(length)) // total length
 }
 
+// writePcapngHeader writes a pcapng Section Header Block and the
+// Interface Description Block of the only interface.
+func writePcapngHeader(w io.Writer, snaplen int) {
+	binary.Write(w, binary.LittleEndian, uint32(0x0A0D0D0A)) // block type: SHB
+	binary.Write(w, binary.LittleEndian, uint32(28))         // block length
+	binary.Write(w, binary.LittleEndian, uint32(0x1A2B3C4D)) // byte-order magic
+	binary.Write(w, binary.LittleEndian, uint16(1))          // version major
+	binary.Write(w, binary.LittleEndian, uint16(0))          // version minor
+	binary.Write(w, binary.LittleEndian, int64(-1))          // section length: unknown
+	binary.Write(w, binary.LittleEndian, uint32(28))         // block length
+
+	binary.Write(w, binary.LittleEndian, uint32(1))       // block type: IDB
+	binary.Write(w, binary.LittleEndian, uint32(20))      // block length
+	binary.Write(w, binary.LittleEndian, uint16(147))     // link-layer ID - USER0
+	binary.Write(w, binary.LittleEndian, uint16(0))       // reserved
+	binary.Write(w, binary.LittleEndian, uint32(snaplen)) // max packet len
+	binary.Write(w, binary.LittleEndian, uint32(20))      // block length
+}
+
+// writePcapngPktHeader writes the start of a pcapng Enhanced Packet Block
+// for a packet of capLen captured bytes. The caller must write the packet
+// and then call writePcapngPktTrailer.
+func writePcapngPktHeader(w *bytes.Buffer, when time.Time, capLen, length int) {
+	us := uint64(when.UnixMicro()) // default if_tsresol is microseconds
+
+	binary.Write(w, binary.LittleEndian, uint32(6))                    // block type: EPB
+	binary.Write(w, binary.LittleEndian, uint32(pcapngEPBLen(capLen))) // block length
+	binary.Write(w, binary.LittleEndian, uint32(0))                    // interface ID
+	binary.Write(w, binary.LittleEndian, uint32(us>>32))               // timestamp high
+	binary.Write(w, binary.LittleEndian, uint32(us))                   // timestamp low
+	binary.Write(w, binary.LittleEndian, uint32(capLen))               // length present
+	binary.Write(w, binary.LittleEndian, uint32(length))               // total length
+}
+
+// writePcapngPktTrailer pads the packet written after writePcapngPktHeader
+// to 32 bits and ends its block.
+func writePcapngPktTrailer(w *bytes.Buffer, capLen int) {
+	var pad [3]byte
+	w.Write(pad[:(4-capLen%4)%4])
+	binary.Write(w, binary.LittleEndian, uint32(pcapngEPBLen(capLen))) // block length
+}
+
+func pcapngEPBLen(capLen int) int {
+	return 32 + (capLen+3)&^3
+}
+
 // Path describes where in the data path the packet was captured.
 type Path uint8
 
@@ -95,10 +195,15 @@ type Sink struct {
 	ctxCancel context.CancelFunc
 
 	mu         sync.Mutex
-	outputs    set.HandleSet[io.Writer]
+	outputs    set.HandleSet[*output]
 	flushTimer *time.Timer // or nil if none running
 }
 
+type output struct {
+	w    io.Writer
+	opts OutputOptions
+}
+
 // RegisterOutput connects an output to this sink, which
 // will be written to with a pcap stream as packets are logged.
 // A function is returned which unregisters the output when
@@ -108,15 +213,26 @@ type Sink struct {
 // or when the sink is closed. If w implements http.Flusher,
 // it will be flushed periodically.
 func (s *Sink) RegisterOutput(w io.Writer) (unregister func()) {
+	return s.RegisterOutputWithOptions(w, OutputOptions{})
+}
+
+// RegisterOutputWithOptions is like RegisterOutput, but the stream written
+// to w has the given options.
+func (s *Sink) RegisterOutputWithOptions(w io.Writer, opts OutputOptions) (unregister func()) {
 	select {
 	case <-s.ctx.Done():
 		return func() {}
 	default:
 	}
 
-	writePcapHeader(w)
+	switch opts.Format {
+	case FormatPcapng:
+		writePcapngHeader(w, opts.snaplen())
+	default:
+		writePcapHeader(w, opts.snaplen())
+	}
 	s.mu.Lock()
-	hnd := s.outputs.Add(w)
+	hnd := s.outputs.Add(&output{w, opts})
 	s.mu.Unlock()
 
 	return func() {
@@ -146,8 +262,8 @@ func (s *Sink) Close() error {
 	}
 
 	for _, o := range s.outputs {
-		if o, ok := o.(io.Closer); ok {
-			o.Close()
+		if c, ok := o.w.(io.Closer); ok {
+			c.Close()
 		}
 	}
 	s.outputs = nil
@@ -181,54 +297,52 @@ func (s *Sink) LogPacket(path Path, when time.Time, data []byte, meta packet.Cap
 	default:
 	}
 
-	extraLen := customDataLen(meta)
-	b := bufferPool.Get().(*bytes.Buffer)
-	b.Reset()
-	b.Grow(16 + extraLen + len(data)) // 16b pcap header + len(metadata) + len(payload)
-	defer bufferPool.Put(b)
-
-	writePktHeader(b, when, len(data)+extraLen)
-
-	// Custom tailscale debugging data
-	binary.Write(b, binary.LittleEndian, uint16(path))
-	if meta.DidSNAT {
-		binary.Write(b, binary.LittleEndian, uint8(meta.OriginalSrc.Addr().BitLen()/8))
-		b.Write(meta.OriginalSrc.Addr().AsSlice())
-	} else {
-		binary.Write(b, binary.LittleEndian, uint8(0)) // SNAT addr len == 0
-	}
-	if meta.DidDNAT {
-		binary.Write(b, binary.LittleEndian, uint8(meta.OriginalDst.Addr().BitLen()/8))
-		b.Write(meta.OriginalDst.Addr().AsSlice())
-	} else {
-		binary.Write(b, binary.LittleEndian, uint8(0)) // DNAT addr len == 0
-	}
-
-	b.Write(data)
-
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	var hadError []set.Handle
+	var (
+		q       *packet.Parsed // decoded lazily, for filters
+		encoded []encodedPacket
+		hadErr  []set.Handle
+	)
+	defer func() {
+		for _, e := range encoded {
+			bufferPool.Put(e.b)
+		}
+	}()
 	for hnd, o := range s.outputs {
-		if _, err := o.Write(b.Bytes()); err != nil {
-			hadError = append(hadError, hnd)
-			continue
+		if f := o.opts.Filter; f != nil {
+			if q == nil {
+				q = new(packet.Parsed)
+				if path != PathDisco {
+					q.Decode(data)
+				}
+			}
+			if !f.Match(path, q) {
+				continue
+			}
+		}
+		b := encodePacket(&encoded, o.opts, path, when, data, meta)
+		if _, err := o.w.Write(b); err != nil {
+			hadErr = append(hadErr, hnd)
 		}
 	}
-	for _, hnd := range hadError {
-		if o, ok := s.outputs[hnd].(io.Closer); ok {
-			o.Close()
+	for _, hnd := range hadErr {
+		if c, ok := s.outputs[hnd].w.(io.Closer); ok {
+			c.Close()
 		}
 		delete(s.outputs, hnd)
 	}
+	if len(encoded) == 0 {
+		return
+	}
 
 	if s.flushTimer == nil {
 		s.flushTimer = time.AfterFunc(flushPeriod, func() {
 			s.mu.Lock()
 			defer s.mu.Unlock()
 			for _, o := range s.outputs {
-				if f, ok := o.(http.Flusher); ok {
+				if f, ok := o.w.(http.Flusher); ok {
 					f.Flush()
 				}
 			}
@@ -236,3 +350,57 @@ func (s *Sink) LogPacket(path Path, when time.Time, data []byte, meta packet.Cap
 		})
 	}
 }
+
+// encodedPacket is a packet encoded for outputs with the same format and
+// snaplen, so outputs that share them share the encoding.
+type encodedPacket struct {
+	format  Format
+	snaplen int
+	b       *bytes.Buffer
+}
+
+// encodePacket returns the packet encoded for an output with options opts,
+// reusing or appending to the encodings in *cache.
+func encodePacket(cache *[]encodedPacket, opts OutputOptions, path Path, when time.Time, data []byte, meta packet.CaptureMeta) []byte {
+	snaplen := opts.snaplen()
+	for _, e := range *cache {
+		if e.format == opts.Format && e.snaplen == snaplen {
+			return e.b.Bytes()
+		}
+	}
+
+	extraLen := customDataLen(meta)
+	capData := data[:min(len(data), snaplen)]
+	capLen := extraLen + len(capData)
+	b := bufferPool.Get().(*bytes.Buffer)
+	b.Reset()
+	b.Grow(32 + capLen + 4) // 32b pcapng header (16b for pcap) + len(metadata) + len(payload) + pad
+	*cache = append(*cache, encodedPacket{opts.Format, snaplen, b})
+
+	if opts.Format == FormatPcapng {
+		writePcapngPktHeader(b, when, capLen, extraLen+len(data))
+	} else {
+		writePktHeader(b, when, capLen, extraLen+len(data))
+	}
+
+	// Custom tailscale debugging data
+	binary.Write(b, binary.LittleEndian, uint16(path))
+	if meta.DidSNAT {
+		binary.Write(b, binary.LittleEndian, uint8(meta.OriginalSrc.Addr().BitLen()/8))
+		b.Write(meta.OriginalSrc.Addr().AsSlice())
+	} else {
+		binary.Write(b, binary.LittleEndian, uint8(0)) // SNAT addr len == 0
+	}
+	if meta.DidDNAT {
+		binary.Write(b, binary.LittleEndian, uint8(meta.OriginalDst.Addr().BitLen()/8))
+		b.Write(meta.OriginalDst.Addr().AsSlice())
+	} else {
+		binary.Write(b, binary.LittleEndian, uint8(0)) // DNAT addr len == 0
+	}
+
+	b.Write(capData)
+	if opts.Format == FormatPcapng {
+		writePcapngPktTrailer(b, capLen)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,82 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/types/ipproto"
+)
+
+func TestSinkOutputOptions(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	var all, filtered, ng bytes.Buffer
+	s.RegisterOutput(&all)
+	f, err := ParseFilter("udp and port 53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterOutputWithOptions(&filtered, OutputOptions{Filter: f})
+	s.RegisterOutputWithOptions(&ng, OutputOptions{Format: FormatPcapng, Snaplen: 10})
+	const pcapHeaderLen, pcapngHeaderLen = 24, 28 + 20
+	if all.Len() != pcapHeaderLen || filtered.Len() != pcapHeaderLen || ng.Len() != pcapngHeaderLen {
+		t.Fatalf("header lengths = %d, %d, %d", all.Len(), filtered.Len(), ng.Len())
+	}
+
+	udp := packet.Generate(packet.UDP4Header{
+		IP4Header: packet.IP4Header{
+			IPProto: ipproto.UDP,
+			Src:     netip.MustParseAddr("100.64.0.1"),
+			Dst:     netip.MustParseAddr("100.100.100.100"),
+		},
+		SrcPort: 50000,
+		DstPort: 53,
+	}, []byte("query"))
+	icmp := packet.Generate(packet.ICMP4Header{
+		IP4Header: packet.IP4Header{
+			IPProto: ipproto.ICMPv4,
+			Src:     netip.MustParseAddr("100.64.0.1"),
+			Dst:     netip.MustParseAddr("100.64.0.2"),
+		},
+		Type: packet.ICMP4EchoRequest,
+	}, nil)
+
+	when := time.Unix(1700000000, 123456000)
+	s.LogPacket(FromLocal, when, udp, packet.CaptureMeta{})
+	s.LogPacket(FromLocal, when, icmp, packet.CaptureMeta{})
+
+	const metaLen = 4 // path and empty SNAT and DNAT lengths
+	if want := pcapHeaderLen + 2*16 + 2*metaLen + len(udp) + len(icmp); all.Len() != want {
+		t.Errorf("unfiltered len = %d; want %d", all.Len(), want)
+	}
+	if want := pcapHeaderLen + 16 + metaLen + len(udp); filtered.Len() != want {
+		t.Errorf("filtered len = %d; want %d", filtered.Len(), want)
+	}
+
+	// Each pcapng packet is truncated to 10 bytes plus the metadata, and
+	// padded to 32 bits.
+	epb := ng.Bytes()[pcapngHeaderLen:]
+	const epbLen = 32 + 16 // 14 bytes captured, padded to 16
+	if len(epb) != 2*epbLen {
+		t.Fatalf("pcapng packets len = %d; want %d", len(epb), 2*epbLen)
+	}
+	le := binary.LittleEndian
+	if typ, n, n2 := le.Uint32(epb), le.Uint32(epb[4:]), le.Uint32(epb[epbLen-4:]); typ != 6 || n != epbLen || n2 != epbLen {
+		t.Errorf("EPB type, lengths = %d, %d, %d; want 6, %d, %d", typ, n, n2, epbLen, epbLen)
+	}
+	us := uint64(le.Uint32(epb[12:]))<<32 | uint64(le.Uint32(epb[16:]))
+	if want := uint64(when.UnixMicro()); us != want {
+		t.Errorf("timestamp = %d; want %d", us, want)
+	}
+	if capLen, origLen := le.Uint32(epb[20:]), le.Uint32(epb[24:]); capLen != 14 || int(origLen) != metaLen+len(udp) {
+		t.Errorf("captured, original len = %d, %d; want 14, %d", capLen, origLen, metaLen+len(udp))
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package capture
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/types/ipproto"
+)
+
+// Filter selects which packets a capture output records. It's built from
+// an expression in a subset of the tcpdump/BPF filter syntax:
+//
+//	[src|dst] host <ip>
+//	[src|dst] net <cidr>
+//	[src|dst] port <n>
+//	[src|dst] portrange <n>-<m>
+//	ip | ip6 | tcp | udp | icmp | icmp6 | sctp
+//	disco
+//
+// combined with "and" (or "&&"), "or" (or "||"), "not" (or "!") and
+// parentheses. A host, net or port without src or dst matches either
+// direction. Disco frames only match "disco" (and negations of other
+// primitives).
+//
+// A nil Filter matches all packets.
+type Filter struct {
+	expr  string
+	match matchFunc
+}
+
+// matchFunc reports whether a packet captured on path p matches. q is the
+// decoded packet, which is zero for packets that aren't IP (disco frames).
+type matchFunc func(p Path, q *packet.Parsed) bool
+
+// ParseFilter parses a filter expression. An empty (or all whitespace)
+// expression returns a nil Filter, which matches all packets.
+func ParseFilter(expr string) (*Filter, error) {
+	toks := tokenizeFilter(expr)
+	if len(toks) == 0 {
+		return nil, nil
+	}
+	fp := &filterParser{toks: toks}
+	m, err := fp.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid capture filter %q: %w", expr, err)
+	}
+	if !fp.done() {
+		return nil, fmt.Errorf("invalid capture filter %q: unexpected %q", expr, fp.peek())
+	}
+	return &Filter{expr: strings.Join(toks, " "), match: m}, nil
+}
+
+// String returns the filter's expression, normalized.
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.expr
+}
+
+// Match reports whether the packet q, captured on path p, matches f. For
+// disco frames (PathDisco), q is ignored.
+func (f *Filter) Match(p Path, q *packet.Parsed) bool {
+	if f == nil {
+		return true
+	}
+	if p == PathDisco {
+		q = new(packet.Parsed)
+	}
+	return f.match(p, q)
+}
+
+// tokenizeFilter splits expr into words, with parentheses, "!", "&&" and
+// "||" as tokens of their own even when not separated by spaces.
+func tokenizeFilter(expr string) []string {
+	var toks []string
+	var cur strings.Builder
+	flush := func() {
+		if cur.Len() > 0 {
+			toks = append(toks, cur.String())
+			cur.Reset()
+		}
+	}
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			flush()
+		case c == '(' || c == ')' || c == '!':
+			flush()
+			toks = append(toks, string(c))
+		case (c == '&' || c == '|') && i+1 < len(expr) && expr[i+1] == c:
+			flush()
+			toks = append(toks, expr[i:i+2])
+			i++
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	flush()
+	return toks
+}
+
+type filterParser struct {
+	toks []string
+	pos  int
+}
+
+func (fp *filterParser) done() bool { return fp.pos >= len(fp.toks) }
+
+func (fp *filterParser) peek() string {
+	if fp.done() {
+		return ""
+	}
+	return fp.toks[fp.pos]
+}
+
+func (fp *filterParser) next() (string, error) {
+	if fp.done() {
+		return "", fmt.Errorf("unexpected end of expression")
+	}
+	fp.pos++
+	return fp.toks[fp.pos-1], nil
+}
+
+func (fp *filterParser) parseOr() (matchFunc, error) {
+	m, err := fp.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for tok := fp.peek(); tok == "or" || tok == "||"; tok = fp.peek() {
+		fp.pos++
+		r, err := fp.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := m
+		m = func(p Path, q *packet.Parsed) bool { return l(p, q) || r(p, q) }
+	}
+	return m, nil
+}
+
+func (fp *filterParser) parseAnd() (matchFunc, error) {
+	m, err := fp.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for tok := fp.peek(); tok == "and" || tok == "&&"; tok = fp.peek() {
+		fp.pos++
+		r, err := fp.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := m
+		m = func(p Path, q *packet.Parsed) bool { return l(p, q) && r(p, q) }
+	}
+	return m, nil
+}
+
+func (fp *filterParser) parseUnary() (matchFunc, error) {
+	tok, err := fp.next()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case "not", "!":
+		m, err := fp.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(p Path, q *packet.Parsed) bool { return !m(p, q) }, nil
+	case "(":
+		m, err := fp.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok, err := fp.next(); err != nil || tok != ")" {
+			return nil, fmt.Errorf("missing \")\"")
+		}
+		return m, nil
+	}
+	return fp.parsePrimitive(tok)
+}
+
+// direction is which of a packet's addresses or ports a primitive
+// applies to.
+type direction int
+
+const (
+	dirEither direction = iota
+	dirSrc
+	dirDst
+)
+
+func (d direction) matches(q *packet.Parsed, f func(netip.AddrPort) bool) bool {
+	switch d {
+	case dirSrc:
+		return f(q.Src)
+	case dirDst:
+		return f(q.Dst)
+	}
+	return f(q.Src) || f(q.Dst)
+}
+
+func (fp *filterParser) parsePrimitive(tok string) (matchFunc, error) {
+	switch tok {
+	case "ip":
+		return matchIPVersion(4), nil
+	case "ip6":
+		return matchIPVersion(6), nil
+	case "tcp":
+		return matchProto(ipproto.TCP), nil
+	case "udp":
+		return matchProto(ipproto.UDP), nil
+	case "icmp":
+		return matchProto(ipproto.ICMPv4), nil
+	case "icmp6":
+		return matchProto(ipproto.ICMPv6), nil
+	case "sctp":
+		return matchProto(ipproto.SCTP), nil
+	case "disco":
+		return func(p Path, _ *packet.Parsed) bool { return p == PathDisco }, nil
+	}
+
+	dir := dirEither
+	switch tok {
+	case "src":
+		dir = dirSrc
+	case "dst":
+		dir = dirDst
+	}
+	if dir != dirEither {
+		var err error
+		if tok, err = fp.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	switch tok {
+	case "host", "net", "port", "portrange":
+	default:
+		return nil, fmt.Errorf("unknown primitive %q", tok)
+	}
+	arg, err := fp.next()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case "host":
+		ip, err := netip.ParseAddr(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host %q", arg)
+		}
+		return func(_ Path, q *packet.Parsed) bool {
+			return q.IPVersion != 0 && dir.matches(q, func(ap netip.AddrPort) bool { return ap.Addr() == ip })
+		}, nil
+	case "net":
+		pfx, err := netip.ParsePrefix(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid net %q", arg)
+		}
+		pfx = pfx.Masked()
+		return func(_ Path, q *packet.Parsed) bool {
+			return q.IPVersion != 0 && dir.matches(q, func(ap netip.AddrPort) bool { return pfx.Contains(ap.Addr()) })
+		}, nil
+	case "port":
+		port, err := parseFilterPort(arg)
+		if err != nil {
+			return nil, err
+		}
+		return matchPorts(dir, port, port), nil
+	default: // portrange
+		lo, hi, ok := strings.Cut(arg, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid portrange %q; want <n>-<m>", arg)
+		}
+		first, err := parseFilterPort(lo)
+		if err != nil {
+			return nil, err
+		}
+		last, err := parseFilterPort(hi)
+		if err != nil {
+			return nil, err
+		}
+		if first > last {
+			return nil, fmt.Errorf("invalid portrange %q", arg)
+		}
+		return matchPorts(dir, first, last), nil
+	}
+}
+
+func parseFilterPort(s string) (uint16, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return uint16(port), nil
+}
+
+func matchIPVersion(v uint8) matchFunc {
+	return func(_ Path, q *packet.Parsed) bool { return q.IPVersion == v }
+}
+
+func matchProto(proto ipproto.Proto) matchFunc {
+	return func(_ Path, q *packet.Parsed) bool { return q.IPVersion != 0 && q.IPProto == proto }
+}
+
+// matchPorts matches TCP, UDP and SCTP packets whose port in direction
+// dir is in the inclusive range [first, last].
+func matchPorts(dir direction, first, last uint16) matchFunc {
+	return func(_ Path, q *packet.Parsed) bool {
+		if q.IPVersion == 0 {
+			return false
+		}
+		switch q.IPProto {
+		case ipproto.TCP, ipproto.UDP, ipproto.SCTP:
+		default:
+			return false
+		}
+		return dir.matches(q, func(ap netip.AddrPort) bool {
+			return ap.Port() >= first && ap.Port() <= last
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package capture
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/types/ipproto"
+)
+
+func TestFilter(t *testing.T) {
+	pkt := func(proto ipproto.Proto, src, dst string) *packet.Parsed {
+		q := &packet.Parsed{
+			IPProto: proto,
+			Src:     netip.MustParseAddrPort(src),
+			Dst:     netip.MustParseAddrPort(dst),
+		}
+		q.IPVersion = 4
+		if q.Src.Addr().Is6() {
+			q.IPVersion = 6
+		}
+		return q
+	}
+	ssh := pkt(ipproto.TCP, "100.64.0.1:51000", "100.64.0.2:22")
+	dns := pkt(ipproto.UDP, "[fd7a:115c:a1e0::1]:53000", "[fd7a:115c:a1e0::53]:53")
+	ping := pkt(ipproto.ICMPv4, "100.64.0.3:0", "100.64.0.1:0")
+
+	tests := []struct {
+		expr  string
+		q     *packet.Parsed
+		path  Path
+		match bool
+	}{
+		{"", ssh, FromLocal, true},
+		{"tcp", ssh, FromLocal, true},
+		{"tcp", dns, FromLocal, false},
+		{"udp and port 53", dns, FromPeer, true},
+		{"ip6", dns, FromPeer, true},
+		{"ip", dns, FromPeer, false},
+		{"icmp", ping, FromPeer, true},
+		{"port 22", ping, FromPeer, false},
+		{"host 100.64.0.2", ssh, FromLocal, true},
+		{"src host 100.64.0.2", ssh, FromLocal, false},
+		{"dst host 100.64.0.2", ssh, FromLocal, true},
+		{"net 100.64.0.0/24", ping, FromPeer, true},
+		{"src net fd7a:115c:a1e0::/48", dns, FromPeer, true},
+		{"dst port 22", ssh, FromLocal, true},
+		{"src port 22", ssh, FromLocal, false},
+		{"portrange 50000-52000", ssh, FromLocal, true},
+		{"dst portrange 50000-52000", ssh, FromLocal, false},
+		{"not tcp", ssh, FromLocal, false},
+		{"!tcp", dns, FromLocal, true},
+		{"tcp or icmp", ping, FromPeer, true},
+		{"tcp&&port 22||udp", dns, FromPeer, true},
+		{"host 100.64.0.1 and (port 22 or icmp)", ping, FromPeer, true},
+		{"host 100.64.0.1 and (port 22 or icmp)", dns, FromPeer, false},
+		{"not (tcp or udp)", ping, FromPeer, true},
+		{"disco", nil, PathDisco, true},
+		{"disco", ssh, FromLocal, false},
+		{"host 100.64.0.1", nil, PathDisco, false},
+		{"not tcp", nil, PathDisco, true},
+		{"disco or port 22", nil, PathDisco, true},
+	}
+	for _, tt := range tests {
+		f, err := ParseFilter(tt.expr)
+		if err != nil {
+			t.Errorf("ParseFilter(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := f.Match(tt.path, tt.q); got != tt.match {
+			t.Errorf("ParseFilter(%q).Match(%v, %v) = %v; want %v", tt.expr, tt.path, tt.q, got, tt.match)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		"bogus",
+		"host",
+		"host foo",
+		"net 10.0.0.0",
+		"port 65536",
+		"portrange 20",
+		"portrange 30-20",
+		"src tcp",
+		"tcp and",
+		"(tcp",
+		"tcp)",
+		"tcp udp",
+		"not",
+	} {
+		if f, err := ParseFilter(expr); err == nil {
+			t.Errorf("ParseFilter(%q) = %q; want error", expr, f)
+		}
+	}
+}
+
+func TestFilterString(t *testing.T) {
+	f, err := ParseFilter("  tcp&&(port 22||!udp) ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.String(), "tcp && ( port 22 || ! udp )"; got != want {
+		t.Errorf("String = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package filter
+
+import (
+	"container/list"
+	"encoding/binary"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/net/flowtrack"
+	"tailscale.com/net/packet"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/util/clientmetric"
+)
+
+// ConnLimits are the limits on the incoming TCP connections a Filter
+// tracks as half-open: accepted SYNs whose handshake the peer hasn't yet
+// completed. They keep a compromised or misbehaving peer from flooding
+// the machine, or the subnets it routes to, with half-open connections,
+// and bound the memory used to track them.
+//
+// The zero value means no limits, in which case no connections are
+// tracked.
+type ConnLimits struct {
+	// MaxPerPeer is the most half-open connections tracked from any
+	// one peer IP. Zero means no limit.
+	MaxPerPeer int
+
+	// MaxTotal is the most half-open connections tracked from all
+	// peers together. Zero means no limit. SYNs arriving at the same
+	// time from different peers may briefly exceed it.
+	MaxTotal int
+
+	// Timeout is how long a connection is tracked as half-open before
+	// it's forgotten. Zero means DefaultHalfOpenTimeout.
+	Timeout time.Duration
+
+	// Evict is what to do with a new connection once a limit is
+	// reached.
+	Evict EvictPolicy
+}
+
+// enabled reports whether l limits anything.
+func (l ConnLimits) enabled() bool {
+	return l.MaxPerPeer > 0 || l.MaxTotal > 0
+}
+
+// DefaultHalfOpenTimeout is how long connections are tracked as half-open
+// if ConnLimits.Timeout is zero.
+const DefaultHalfOpenTimeout = 30 * time.Second
+
+// EvictPolicy is what a Filter does with a new incoming connection when
+// it's already tracking as many half-open connections as its ConnLimits
+// allow.
+type EvictPolicy int
+
+const (
+	// EvictDropNew drops the new connection's SYN. This protects the
+	// destination, at the cost of the peer not being able to open new
+	// connections until its existing ones are established or time out.
+	EvictDropNew EvictPolicy = iota
+
+	// EvictOldest forgets the oldest half-open connection (from the same
+	// peer, if the per-peer limit was hit, or else from peers sharing
+	// its lock) and accepts the new one. This only bounds the tracking
+	// memory; it doesn't limit what reaches the destination.
+	EvictOldest
+)
+
+// connTrackerShards is how many independently locked shards a
+// connTracker splits its peers into, so that packets from different
+// peers rarely contend.
+const connTrackerShards = 16
+
+// connTracker tracks a Filter's half-open incoming TCP connections and
+// enforces its ConnLimits on them.
+type connTracker struct {
+	// limits are the current limits, or nil if there are none, so the
+	// packet path can skip tracking without locking.
+	limits atomic.Pointer[ConnLimits]
+
+	// n is the number of tracked connections across all shards, so the
+	// packet path can skip locking when there are none.
+	n atomic.Int64
+
+	shards [connTrackerShards]connShard
+}
+
+// connShard is the tracking state for the peers whose IPs hash to it.
+type connShard struct {
+	mu     sync.Mutex
+	conns  map[flowtrack.Tuple]*halfOpenConn
+	all    list.List                 // of *halfOpenConn, oldest first
+	byPeer map[netip.Addr]*list.List // of *halfOpenConn, oldest first
+}
+
+type halfOpenConn struct {
+	tuple   flowtrack.Tuple
+	at      time.Time
+	allElem *list.Element // in connShard.all
+	peerEl  *list.Element // in connShard.byPeer[tuple.Src.Addr()]
+
+	// sawSYNACK is whether our SYN-ACK has gone out, and synAckSeq is
+	// its sequence number, which the peer's ACK completing the
+	// handshake must acknowledge.
+	sawSYNACK bool
+	synAckSeq uint32
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{}
+}
+
+// shard returns the shard that tracks connections from peer.
+func (ct *connTracker) shard(peer netip.Addr) *connShard {
+	a := peer.As16()
+	h := binary.BigEndian.Uint32(a[12:]) ^ binary.BigEndian.Uint32(a[8:])
+	return &ct.shards[h%connTrackerShards]
+}
+
+func (ct *connTracker) setLimits(l ConnLimits) {
+	if !l.enabled() {
+		ct.limits.Store(nil)
+		for i := range ct.shards {
+			s := &ct.shards[i]
+			s.mu.Lock()
+			for e := s.all.Front(); e != nil; e = s.all.Front() {
+				ct.removeLocked(s, e.Value.(*halfOpenConn))
+			}
+			s.mu.Unlock()
+		}
+		return
+	}
+	if l.Timeout <= 0 {
+		l.Timeout = DefaultHalfOpenTimeout
+	}
+	ct.limits.Store(&l)
+}
+
+// admit reports whether to accept q, an incoming TCP SYN that the
+// Filter's rules allow, and if so tracks its connection as half-open.
+func (ct *connTracker) admit(q *packet.Parsed, now time.Time) bool {
+	l := ct.limits.Load()
+	if l == nil {
+		return true
+	}
+	if q.Src.Port() == 0 {
+		// Synthesized by Filter.CheckTCP; not a real connection.
+		return true
+	}
+	t := flowtrack.Tuple{Proto: ipproto.TCP, Src: q.Src, Dst: q.Dst}
+	peer := q.Src.Addr()
+
+	s := ct.shard(peer)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.conns[t]; ok {
+		return true // SYN retransmit
+	}
+	ct.expireLocked(s, l.Timeout, now)
+
+	pl := s.byPeer[peer]
+	if l.MaxPerPeer > 0 && pl != nil && pl.Len() >= l.MaxPerPeer {
+		if l.Evict != EvictOldest {
+			metricHalfOpenDropped.Add(1)
+			return false
+		}
+		ct.removeLocked(s, pl.Front().Value.(*halfOpenConn))
+		metricHalfOpenEvicted.Add(1)
+	}
+	if l.MaxTotal > 0 && ct.n.Load() >= int64(l.MaxTotal) {
+		if l.Evict != EvictOldest || s.all.Len() == 0 {
+			metricHalfOpenDropped.Add(1)
+			return false
+		}
+		ct.removeLocked(s, s.all.Front().Value.(*halfOpenConn))
+		metricHalfOpenEvicted.Add(1)
+	}
+
+	c := &halfOpenConn{tuple: t, at: now}
+	c.allElem = s.all.PushBack(c)
+	if pl = s.byPeer[peer]; pl == nil {
+		if s.byPeer == nil {
+			s.byPeer = make(map[netip.Addr]*list.List)
+		}
+		pl = list.New()
+		s.byPeer[peer] = pl
+	}
+	c.peerEl = pl.PushBack(c)
+	if s.conns == nil {
+		s.conns = make(map[flowtrack.Tuple]*halfOpenConn)
+	}
+	s.conns[t] = c
+	ct.n.Add(1)
+	metricHalfOpenTracked.Add(1)
+	return true
+}
+
+// noteOut is called for each outgoing TCP packet. If it's our SYN-ACK for
+// a tracked half-open connection, it records the sequence number that
+// the peer must acknowledge to complete the handshake.
+func (ct *connTracker) noteOut(q *packet.Parsed) {
+	if ct.n.Load() == 0 || q.TCPFlags&packet.TCPSynAck != packet.TCPSynAck {
+		return
+	}
+	seq, _, ok := q.TCPSeqAck()
+	if !ok {
+		return
+	}
+	t := flowtrack.Tuple{Proto: ipproto.TCP, Src: q.Dst, Dst: q.Src} // src/dst reversed
+	s := ct.shard(q.Dst.Addr())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.conns[t]; ok {
+		c.sawSYNACK = true
+		c.synAckSeq = seq
+	}
+}
+
+// noteNonSYN is called for each incoming TCP packet without SYN set. If
+// it's the ACK that completes the handshake of a tracked half-open
+// connection, the connection is established and no longer tracked. Other
+// packets, including RSTs, leave the connection tracked until it times
+// out, so that they can't be used to get around the limits.
+func (ct *connTracker) noteNonSYN(q *packet.Parsed) {
+	if ct.n.Load() == 0 || q.TCPFlags&(packet.TCPAck|packet.TCPRst) != packet.TCPAck {
+		return
+	}
+	_, ack, ok := q.TCPSeqAck()
+	if !ok {
+		return
+	}
+	t := flowtrack.Tuple{Proto: ipproto.TCP, Src: q.Src, Dst: q.Dst}
+	s := ct.shard(q.Src.Addr())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.conns[t]; ok && c.sawSYNACK && ack == c.synAckSeq+1 {
+		ct.removeLocked(s, c)
+	}
+}
+
+// expireLocked forgets the connections in s that have been half-open
+// for longer than timeout.
+//
+// s.mu must be held.
+func (ct *connTracker) expireLocked(s *connShard, timeout time.Duration, now time.Time) {
+	for e := s.all.Front(); e != nil; e = s.all.Front() {
+		c := e.Value.(*halfOpenConn)
+		if now.Sub(c.at) < timeout {
+			return
+		}
+		ct.removeLocked(s, c)
+		metricHalfOpenExpired.Add(1)
+	}
+}
+
+// s.mu must be held.
+func (ct *connTracker) removeLocked(s *connShard, c *halfOpenConn) {
+	delete(s.conns, c.tuple)
+	s.all.Remove(c.allElem)
+	peer := c.tuple.Src.Addr()
+	if pl := s.byPeer[peer]; pl != nil {
+		pl.Remove(c.peerEl)
+		if pl.Len() == 0 {
+			delete(s.byPeer, peer)
+		}
+	}
+	ct.n.Add(-1)
+}
+
+// HalfOpenConns returns how many incoming TCP connections f is tracking
+// as half-open, in total and from the peer IP peer.
+func (f *Filter) HalfOpenConns(peer netip.Addr) (total, fromPeer int) {
+	ct := f.state.conns
+	s := ct.shard(peer)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pl := s.byPeer[peer]; pl != nil {
+		fromPeer = pl.Len()
+	}
+	return int(ct.n.Load()), fromPeer
+}
+
+// SetConnLimits sets the limits on the half-open incoming TCP connections
+// that f tracks. It applies to all the Filters that f shares state with.
+// New Filters have no limits.
+func (f *Filter) SetConnLimits(l ConnLimits) {
+	f.state.conns.setLimits(l)
+}
+
+// NoteInjectedOut is called with outgoing packets that don't go through
+// RunOut, such as those from netstack, so that f still sees the TCP
+// handshakes it tracks for its ConnLimits.
+func (f *Filter) NoteInjectedOut(q *packet.Parsed) {
+	if q.IPProto == ipproto.TCP {
+		f.state.conns.noteOut(q)
+	}
+}
+
+var (
+	metricHalfOpenTracked = clientmetric.NewCounter("filter_half_open_tracked")
+	metricHalfOpenDropped = clientmetric.NewCounter("filter_half_open_dropped")
+	metricHalfOpenEvicted = clientmetric.NewCounter("filter_half_open_evicted")
+	metricHalfOpenExpired = clientmetric.NewCounter("filter_half_open_expired")
+)
@@ -0,0 +1,148 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package filter
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/types/ipproto"
+)
+
+func TestConnLimits(t *testing.T) {
+	peer := netip.MustParseAddr("8.1.1.1")
+
+	tests := []struct {
+		name   string
+		limits ConnLimits
+		// wantAccepted is how many of 5 SYNs from different source
+		// ports should be accepted.
+		wantAccepted int
+		// wantTracked is how many connections should be tracked after.
+		wantTracked int
+	}{
+		{"per-peer-drop", ConnLimits{MaxPerPeer: 3}, 3, 3},
+		{"total-drop", ConnLimits{MaxTotal: 2}, 2, 2},
+		{"per-peer-evict", ConnLimits{MaxPerPeer: 3, Evict: EvictOldest}, 5, 3},
+		{"total-evict", ConnLimits{MaxTotal: 2, Evict: EvictOldest}, 5, 2},
+		{"no-limits", ConnLimits{}, 5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFilter(t.Logf)
+			f.SetConnLimits(tt.limits)
+			accepted := 0
+			for i := 0; i < 5; i++ {
+				q := parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", uint16(1000+i), 22)
+				if r, _ := f.runIn4(&q); r == Accept {
+					accepted++
+				}
+			}
+			if accepted != tt.wantAccepted {
+				t.Errorf("accepted %d SYNs; want %d", accepted, tt.wantAccepted)
+			}
+			if total, fromPeer := f.HalfOpenConns(peer); total != tt.wantTracked || fromPeer != tt.wantTracked {
+				t.Errorf("HalfOpenConns = %d, %d; want %d", total, fromPeer, tt.wantTracked)
+			}
+		})
+	}
+}
+
+func TestConnLimitsEstablishAndExpire(t *testing.T) {
+	f := newFilter(t.Logf)
+	f.SetConnLimits(ConnLimits{MaxPerPeer: 1, Timeout: time.Minute})
+	peer := netip.MustParseAddr("8.1.1.1")
+
+	syn := parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 1000, 22)
+	if r, _ := f.runIn4(&syn); r != Accept {
+		t.Fatalf("first SYN: %v; want Accept", r)
+	}
+	// A retransmit of the same SYN is still accepted.
+	if r, _ := f.runIn4(&syn); r != Accept {
+		t.Fatalf("SYN retransmit: %v; want Accept", r)
+	}
+	syn2 := parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 1001, 22)
+	if r, _ := f.runIn4(&syn2); r != Drop {
+		t.Fatalf("SYN over limit: %v; want Drop", r)
+	}
+
+	// An ACK that doesn't complete the handshake, such as one sent
+	// before our SYN-ACK, leaves the connection half-open.
+	if r, _ := f.runIn4(tcpPacket(t, "8.1.1.1:1000", "1.2.3.4:22", packet.TCPAck, 1, 1)); r != Accept {
+		t.Fatalf("early ACK: %v; want Accept", r)
+	}
+	if _, n := f.HalfOpenConns(peer); n != 1 {
+		t.Fatalf("after early ACK, %d half-open; want 1", n)
+	}
+
+	// Once the peer acknowledges our SYN-ACK, the connection is
+	// established and a new one can be opened.
+	if r, _ := f.runOut(tcpPacket(t, "1.2.3.4:22", "8.1.1.1:1000", packet.TCPSynAck, 5000, 1)); r != Accept {
+		t.Fatalf("SYN-ACK: %v; want Accept", r)
+	}
+	for _, bogus := range []*packet.Parsed{
+		tcpPacket(t, "8.1.1.1:1000", "1.2.3.4:22", packet.TCPAck, 1, 4999),
+		tcpPacket(t, "8.1.1.1:1000", "1.2.3.4:22", packet.TCPRst|packet.TCPAck, 1, 5001),
+	} {
+		f.runIn4(bogus)
+		if _, n := f.HalfOpenConns(peer); n != 1 {
+			t.Fatalf("after %v, %d half-open; want 1", bogus.TCPFlags, n)
+		}
+	}
+	if r, _ := f.runIn4(tcpPacket(t, "8.1.1.1:1000", "1.2.3.4:22", packet.TCPAck, 1, 5001)); r != Accept {
+		t.Fatalf("ACK: %v; want Accept", r)
+	}
+	if _, n := f.HalfOpenConns(peer); n != 0 {
+		t.Fatalf("after ACK, %d half-open; want 0", n)
+	}
+	if r, _ := f.runIn4(&syn2); r != Accept {
+		t.Fatalf("second SYN after ACK: %v; want Accept", r)
+	}
+
+	// Half-open connections that time out are forgotten.
+	ct := f.state.conns
+	if !ct.admit(&syn, time.Now().Add(2*time.Minute)) {
+		t.Errorf("SYN after timeout not admitted")
+	}
+	if _, n := f.HalfOpenConns(peer); n != 1 {
+		t.Errorf("after timeout, %d half-open; want 1", n)
+	}
+
+	// CheckTCP doesn't count as a connection.
+	for i := 0; i < 3; i++ {
+		if r := f.CheckTCP(peer, netip.MustParseAddr("1.2.3.4"), 22); r != Accept {
+			t.Errorf("CheckTCP = %v; want Accept", r)
+		}
+	}
+}
+
+// tcpPacket returns a parsed IPv4 TCP packet from src to dst with the
+// given flags and sequence and acknowledgment numbers.
+func tcpPacket(t *testing.T, src, dst string, flags packet.TCPFlag, seq, ack uint32) *packet.Parsed {
+	t.Helper()
+	s, d := netip.MustParseAddrPort(src), netip.MustParseAddrPort(dst)
+	b := make([]byte, 40)
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	b[8] = 64
+	b[9] = byte(ipproto.TCP)
+	copy(b[12:16], s.Addr().AsSlice())
+	copy(b[16:20], d.Addr().AsSlice())
+	tcp := b[20:]
+	binary.BigEndian.PutUint16(tcp[0:], s.Port())
+	binary.BigEndian.PutUint16(tcp[2:], d.Port())
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = 5 << 4
+	tcp[13] = byte(flags)
+	q := new(packet.Parsed)
+	q.Decode(b)
+	if q.IPProto != ipproto.TCP {
+		t.Fatalf("bad test packet: %v", q)
+	}
+	return q
+}
@@ -9,6 +9,7 @@
 	"net/netip"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go4.org/netipx"
@@ -55,6 +56,19 @@ type Filter struct {
 	// incoming packets don't get accepted by matches above.
 	state *filterState
 
+	// quarantined, if non-nil, is the set of IPs routed to peers whose
+	// node key has expired. All packets to and from them are dropped, regardless
+	// of matches and state.
+	quarantined *netipx.IPSet
+
+	// flowLog, if non-nil, is where new flows and their verdicts are
+	// recorded.
+	flowLog atomic.Pointer[FlowLog]
+
+	// approve, if non-nil, must also approve new incoming flows that
+	// matches accept.
+	approve ApproveFunc
+
 	shieldsUp bool
 }
 
@@ -62,6 +76,10 @@ type Filter struct {
 type filterState struct {
 	mu  sync.Mutex
 	lru *flowtrack.Cache[struct{}] // from flowtrack.Tuple -> struct{}
+
+	// conns tracks half-open incoming TCP connections. It has its own
+	// lock.
+	conns *connTracker
 }
 
 // lruMax is the size of the LRU cache in filterState.
@@ -178,7 +196,8 @@ func New(matches []Match, localNets *netipx.IPSet, logIPs *netipx.IPSet, shareSt
 		state = shareStateWith.state
 	} else {
 		state = &filterState{
-			lru: &flowtrack.Cache[struct{}]{MaxEntries: lruMax},
+			lru:   &flowtrack.Cache[struct{}]{MaxEntries: lruMax},
+			conns: newConnTracker(),
 		}
 	}
 	f := &Filter{
@@ -194,6 +213,38 @@ func New(matches []Match, localNets *netipx.IPSet, logIPs *netipx.IPSet, shareSt
 	return f
 }
 
+// SetQuarantined sets the IPs routed to quarantined peers, to and from which all
+// packets are dropped. It must be called before f is used.
+func (f *Filter) SetQuarantined(ips *netipx.IPSet) {
+	f.quarantined = ips
+}
+
+// ApproveFunc reports whether a new incoming flow that a Filter's rules
+// accept may proceed. It's called for every packet of the flow that the
+// Filter doesn't already track, so it must be fast and must not block.
+type ApproveFunc func(proto ipproto.Proto, src, dst netip.AddrPort) bool
+
+// SetApprover sets fn as the func that must also approve new incoming
+// flows. It must be called before f is used.
+func (f *Filter) SetApprover(fn ApproveFunc) {
+	f.approve = fn
+}
+
+// approved reports whether f's approver, if any, approves q's new
+// incoming flow.
+func (f *Filter) approved(q *packet.Parsed) bool {
+	return f.approve == nil || f.approve(q.IPProto, q.Src, q.Dst)
+}
+
+// acceptIn returns the verdict on q's new incoming flow, which rule m
+// accepts, subject to f's approver.
+func (f *Filter) acceptIn(q *packet.Parsed, why string, m *Match) (Response, string) {
+	if !f.approved(q) {
+		return f.noteFlow(q, in, Drop, "not approved", m)
+	}
+	return f.noteFlow(q, in, Accept, why, m)
+}
+
 // matchesFamily returns the subset of ms for which keep(srcNet.IP)
 // and keep(dstNet.IP) are both true.
 func matchesFamily(ms matches, keep func(netip.Addr) bool) matches {
@@ -411,7 +462,7 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 	// destinations we didn't explicitly advertise. This check is to
 	// prevent that.
 	if !f.local.Contains(q.Dst.Addr()) {
-		return Drop, "destination not allowed"
+		return f.noteFlow(q, in, Drop, "destination not allowed", nil)
 	}
 
 	switch q.IPProto {
@@ -423,9 +474,9 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 			//  related to an existing ICMP-Echo, TCP, or UDP
 			//  session.
 			return Accept, "icmp response ok"
-		} else if f.matches4.matchIPsOnly(q) {
+		} else if m := f.matches4.matchIPsOnly(q); m != nil {
 			// If any port is open to an IP, allow ICMP to it.
-			return Accept, "icmp ok"
+			return f.acceptIn(q, "icmp ok", m)
 		}
 	case ipproto.TCP:
 		// For TCP, we want to allow *outgoing* connections,
@@ -437,10 +488,17 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 		// It happens to also be much faster.
 		// TODO(apenwarr): Skip the rest of decoding in this path?
 		if !q.IsTCPSyn() {
+			f.state.conns.noteNonSYN(q)
 			return Accept, "tcp non-syn"
 		}
-		if f.matches4.match(q) {
-			return Accept, "tcp ok"
+		if m := f.matches4.match(q); m != nil {
+			if !f.approved(q) {
+				return f.noteFlow(q, in, Drop, "not approved", m)
+			}
+			if !f.state.conns.admit(q, time.Now()) {
+				return f.noteFlow(q, in, Drop, "too many half-open connections", m)
+			}
+			return f.noteFlow(q, in, Accept, "tcp ok", m)
 		}
 	case ipproto.UDP, ipproto.SCTP:
 		t := flowtrack.Tuple{Proto: q.IPProto, Src: q.Src, Dst: q.Dst}
@@ -452,18 +510,18 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 		if ok {
 			return Accept, "cached"
 		}
-		if f.matches4.match(q) {
-			return Accept, "ok"
+		if m := f.matches4.match(q); m != nil {
+			return f.acceptIn(q, "ok", m)
 		}
 	case ipproto.TSMP:
 		return Accept, "tsmp ok"
 	default:
-		if f.matches4.matchProtoAndIPsOnlyIfAllPorts(q) {
-			return Accept, "other-portless ok"
+		if m := f.matches4.matchProtoAndIPsOnlyIfAllPorts(q); m != nil {
+			return f.acceptIn(q, "other-portless ok", m)
 		}
-		return Drop, unknownProtoString(q.IPProto)
+		return f.noteFlow(q, in, Drop, unknownProtoString(q.IPProto), nil)
 	}
-	return Drop, "no rules matched"
+	return f.noteFlow(q, in, Drop, "no rules matched", nil)
 }
 
 func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
@@ -471,7 +529,7 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 	// destinations we didn't explicitly advertise. This check is to
 	// prevent that.
 	if !f.local.Contains(q.Dst.Addr()) {
-		return Drop, "destination not allowed"
+		return f.noteFlow(q, in, Drop, "destination not allowed", nil)
 	}
 
 	switch q.IPProto {
@@ -483,9 +541,9 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 			//  related to an existing ICMP-Echo, TCP, or UDP
 			//  session.
 			return Accept, "icmp response ok"
-		} else if f.matches6.matchIPsOnly(q) {
+		} else if m := f.matches6.matchIPsOnly(q); m != nil {
 			// If any port is open to an IP, allow ICMP to it.
-			return Accept, "icmp ok"
+			return f.acceptIn(q, "icmp ok", m)
 		}
 	case ipproto.TCP:
 		// For TCP, we want to allow *outgoing* connections,
@@ -497,10 +555,17 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 		// It happens to also be much faster.
 		// TODO(apenwarr): Skip the rest of decoding in this path?
 		if q.IPProto == ipproto.TCP && !q.IsTCPSyn() {
+			f.state.conns.noteNonSYN(q)
 			return Accept, "tcp non-syn"
 		}
-		if f.matches6.match(q) {
-			return Accept, "tcp ok"
+		if m := f.matches6.match(q); m != nil {
+			if !f.approved(q) {
+				return f.noteFlow(q, in, Drop, "not approved", m)
+			}
+			if !f.state.conns.admit(q, time.Now()) {
+				return f.noteFlow(q, in, Drop, "too many half-open connections", m)
+			}
+			return f.noteFlow(q, in, Accept, "tcp ok", m)
 		}
 	case ipproto.UDP, ipproto.SCTP:
 		t := flowtrack.Tuple{Proto: q.IPProto, Src: q.Src, Dst: q.Dst}
@@ -512,32 +577,43 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 		if ok {
 			return Accept, "cached"
 		}
-		if f.matches6.match(q) {
-			return Accept, "ok"
+		if m := f.matches6.match(q); m != nil {
+			return f.acceptIn(q, "ok", m)
 		}
 	case ipproto.TSMP:
 		return Accept, "tsmp ok"
 	default:
-		if f.matches6.matchProtoAndIPsOnlyIfAllPorts(q) {
-			return Accept, "other-portless ok"
+		if m := f.matches6.matchProtoAndIPsOnlyIfAllPorts(q); m != nil {
+			return f.acceptIn(q, "other-portless ok", m)
 		}
-		return Drop, unknownProtoString(q.IPProto)
+		return f.noteFlow(q, in, Drop, unknownProtoString(q.IPProto), nil)
 	}
-	return Drop, "no rules matched"
+	return f.noteFlow(q, in, Drop, "no rules matched", nil)
 }
 
-// runIn runs the output-specific part of the filter logic.
+// runOut runs the output-specific part of the filter logic.
 func (f *Filter) runOut(q *packet.Parsed) (r Response, why string) {
+	logFlows := f.flowLog.Load() != nil
+	newFlow := logFlows && q.IPProto == ipproto.TCP && q.IsTCPSyn()
 	switch q.IPProto {
+	case ipproto.TCP:
+		f.state.conns.noteOut(q)
 	case ipproto.UDP, ipproto.SCTP:
 		tuple := flowtrack.Tuple{
 			Proto: q.IPProto,
 			Src:   q.Dst, Dst: q.Src, // src/dst reversed
 		}
 		f.state.mu.Lock()
+		if logFlows {
+			_, seen := f.state.lru.Get(tuple)
+			newFlow = !seen
+		}
 		f.state.lru.Add(tuple, struct{}{})
 		f.state.mu.Unlock()
 	}
+	if newFlow {
+		return f.noteFlow(q, out, Accept, "ok out", nil)
+	}
 	return Accept, "ok out"
 }
 
@@ -584,6 +660,20 @@ func (f *Filter) pre(q *packet.Parsed, rf RunFlags, dir direction) Response {
 		return Drop
 	}
 
+	if f.quarantined != nil {
+		peer := q.Dst.Addr()
+		if dir == in {
+			peer = q.Src.Addr()
+		}
+		if f.quarantined.Contains(peer) {
+			if q.IPProto != ipproto.TCP || q.IsTCPSyn() {
+				f.noteFlow(q, dir, Drop, "quarantined peer", nil)
+			}
+			f.logRateLimit(rf, q, dir, Drop, "quarantined peer")
+			return Drop
+		}
+	}
+
 	if q.IPProto == ipproto.Fragment {
 		// Fragments after the first always need to be passed through.
 		// Very small fragments are considered Junk by Parsed.
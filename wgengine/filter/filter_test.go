@@ -19,6 +19,7 @@
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstest"
+	"tailscale.com/tstest/perf"
 	"tailscale.com/tstime/rate"
 	"tailscale.com/types/ipproto"
 	"tailscale.com/types/logger"
@@ -196,6 +197,70 @@ func TestUDPState(t *testing.T) {
 	}
 }
 
+func TestQuarantine(t *testing.T) {
+	acl := newFilter(t.Logf)
+	var qb netipx.IPSetBuilder
+	qb.Add(netip.MustParseAddr("8.1.1.1"))
+	qb.Add(netip.MustParseAddr("119.119.119.119"))
+	quarantined, _ := qb.IPSet()
+	acl.SetQuarantined(quarantined)
+	flags := LogDrops | LogAccepts
+
+	// Allowed by the matches, but from a quarantined peer.
+	if p := parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22); acl.RunIn(&p, flags) != Drop {
+		t.Errorf("packet from quarantined peer not dropped: %v", p)
+	}
+	// The same rule still allows other peers.
+	if p := parsed(ipproto.TCP, "8.2.2.2", "1.2.3.4", 999, 22); acl.RunIn(&p, flags) != Accept {
+		t.Errorf("packet from other peer not accepted: %v", p)
+	}
+	// Outbound packets to quarantined peers are dropped, so they also
+	// can't open up a return path.
+	b4 := parsed(ipproto.UDP, "102.102.102.102", "119.119.119.119", 4343, 4242)
+	if got := acl.RunOut(&b4, flags); got != Drop {
+		t.Errorf("packet to quarantined peer = %v; want Drop", got)
+	}
+	a4 := parsed(ipproto.UDP, "119.119.119.119", "102.102.102.102", 4242, 4343)
+	if got := acl.RunIn(&a4, flags); got != Drop {
+		t.Errorf("response from quarantined peer = %v; want Drop", got)
+	}
+}
+
+func TestApprover(t *testing.T) {
+	acl := newFilter(t.Logf)
+	var asked []netip.AddrPort
+	acl.SetApprover(func(proto ipproto.Proto, src, dst netip.AddrPort) bool {
+		asked = append(asked, src)
+		return src.Addr() == netip.MustParseAddr("8.1.1.1")
+	})
+	flags := LogDrops | LogAccepts
+
+	// Allowed by the matches and approved.
+	if p := parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22); acl.RunIn(&p, flags) != Accept {
+		t.Errorf("approved packet not accepted: %v", p)
+	}
+	// Allowed by the matches, but not approved.
+	if p := parsed(ipproto.TCP, "8.2.2.2", "1.2.3.4", 999, 22); acl.RunIn(&p, flags) != Drop {
+		t.Errorf("unapproved packet not dropped: %v", p)
+	}
+	// Not allowed by the matches; the approver isn't asked.
+	if p := parsed(ipproto.TCP, "8.2.2.2", "1.2.3.4", 999, 8080); acl.RunIn(&p, flags) != Drop {
+		t.Errorf("packet not matching rules not dropped: %v", p)
+	}
+	// Responses to outbound flows don't need approval.
+	b4 := parsed(ipproto.UDP, "102.102.102.102", "119.119.119.119", 4343, 4242)
+	if got := acl.RunOut(&b4, flags); got != Accept {
+		t.Fatalf("outbound packet didn't egress, got=%v: %v", got, b4)
+	}
+	a4 := parsed(ipproto.UDP, "119.119.119.119", "102.102.102.102", 4242, 4343)
+	if got := acl.RunIn(&a4, flags); got != Accept {
+		t.Errorf("incoming response packet not accepted, got=%v: %v", got, a4)
+	}
+	if want := []netip.AddrPort{netip.MustParseAddrPort("8.1.1.1:999"), netip.MustParseAddrPort("8.2.2.2:999")}; !slices.Equal(asked, want) {
+		t.Errorf("approver asked about %v; want %v", asked, want)
+	}
+}
+
 func TestNoAllocs(t *testing.T) {
 	acl := newFilter(t.Logf)
 
@@ -281,7 +346,13 @@ func TestParseIPSet(t *testing.T) {
 	}
 }
 
-func BenchmarkFilter(b *testing.B) {
+type filterBench struct {
+	name   string
+	dir    direction
+	packet []byte
+}
+
+func filterBenches() []filterBench {
 	tcp4Packet := raw4(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22, 0)
 	udp4Packet := raw4(ipproto.UDP, "8.1.1.1", "1.2.3.4", 999, 22, 0)
 	icmp4Packet := raw4(ipproto.ICMPv4, "8.1.1.1", "1.2.3.4", 0, 0, 0)
@@ -290,11 +361,7 @@ func BenchmarkFilter(b *testing.B) {
 	udp6Packet := raw6(ipproto.UDP, "::1", "2001::1", 999, 22, 0)
 	icmp6Packet := raw6(ipproto.ICMPv6, "::1", "2001::1", 0, 0, 0)
 
-	benches := []struct {
-		name   string
-		dir    direction
-		packet []byte
-	}{
+	return []filterBench{
 		// Non-SYN TCP and ICMP have similar code paths in and out.
 		{"icmp4", in, icmp4Packet},
 		{"tcp4_syn_in", in, tcp4Packet},
@@ -307,26 +374,43 @@ func BenchmarkFilter(b *testing.B) {
 		{"udp6_in", in, udp6Packet},
 		{"udp6_out", out, udp6Packet},
 	}
+}
+
+// run decodes and filters the packet once.
+func (bench filterBench) run(acl *Filter) {
+	q := &packet.Parsed{}
+	q.Decode(bench.packet)
+	// This branch seems to have no measurable impact on performance.
+	if bench.dir == in {
+		acl.RunIn(q, 0)
+	} else {
+		acl.RunOut(q, 0)
+	}
+}
 
-	for _, bench := range benches {
+func BenchmarkFilter(b *testing.B) {
+	for _, bench := range filterBenches() {
 		b.Run(bench.name, func(b *testing.B) {
 			acl := newFilter(b.Logf)
 			b.ReportAllocs()
 			b.ResetTimer()
 			for i := 0; i < b.N; i++ {
-				q := &packet.Parsed{}
-				q.Decode(bench.packet)
-				// This branch seems to have no measurable impact on performance.
-				if bench.dir == in {
-					acl.RunIn(q, 0)
-				} else {
-					acl.RunOut(q, 0)
-				}
+				bench.run(acl)
 			}
 		})
 	}
 }
 
+// TestFilterPerf checks the filter's hot path against the budgets in
+// testdata/perf.json.
+func TestFilterPerf(t *testing.T) {
+	c := perf.Checker{Golden: "testdata/perf.json"}
+	for _, bench := range filterBenches() {
+		acl := newFilter(logger.Discard)
+		c.Check(t, "filter/"+bench.name, func() { bench.run(acl) })
+	}
+}
+
 func TestPreFilter(t *testing.T) {
 	packets := []struct {
 		desc string
@@ -865,7 +949,7 @@ func TestMatchesMatchProtoAndIPsOnlyIfAllPorts(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			matches := matches{tt.m}
-			got := matches.matchProtoAndIPsOnlyIfAllPorts(&tt.p)
+			got := matches.matchProtoAndIPsOnlyIfAllPorts(&tt.p) != nil
 			if got != tt.want {
 				t.Errorf("got = %v; want %v", got, tt.want)
 			}
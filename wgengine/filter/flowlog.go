@@ -0,0 +1,163 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package filter
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"tailscale.com/net/flowtrack"
+	"tailscale.com/net/packet"
+	"tailscale.com/types/ipproto"
+)
+
+// FlowEvent is a new flow seen by a Filter, and the Filter's verdict on it.
+type FlowEvent struct {
+	Seq     uint64 // one more than the previous event's
+	Time    time.Time
+	Dir     string // "in" from peers or "out" to them
+	Proto   ipproto.Proto
+	Src     netip.AddrPort
+	Dst     netip.AddrPort
+	Verdict string // "accept" or "drop"
+	Reason  string // why, as in the Filter's logs
+	Rule    string `json:",omitempty"` // the rule that matched, if any
+}
+
+const (
+	// dropLogInterval is how often a FlowLog records drops of the same
+	// flow. Dropped flows are never established, so without a limit each
+	// of their packets would be recorded.
+	dropLogInterval = 10 * time.Second
+
+	// maxDropFlows is how many dropped flows a FlowLog remembers the last
+	// recorded drop of.
+	maxDropFlows = 512
+
+	// acceptedFlowIdle is how long an accepted flow must go without
+	// packets before its next packet counts as a new flow. The Filter
+	// doesn't track incoming flows other than TCP, so it notes every
+	// accepted packet of theirs.
+	acceptedFlowIdle = 30 * time.Second
+
+	// maxAcceptedFlows is how many accepted flows a FlowLog remembers
+	// the last packet of.
+	maxAcceptedFlows = 1024
+)
+
+// FlowLog is a ring buffer of the FlowEvents of the Filters it's set on
+// with SetFlowLog, for auditing and debugging packet filters.
+//
+// Unlike the Filter's logs, it's kept in memory on the node only, so it
+// includes flows to and from IPs outside the Filter's logIPs. Drops of a
+// flow are recorded at most once every dropLogInterval, and accepted
+// flows only when new.
+type FlowLog struct {
+	mu       sync.Mutex
+	records  []flowRecord                // ring buffer
+	nextSeq  uint64                      // of the next event added; records[nextSeq%len(records)]
+	drops    *flowtrack.Cache[time.Time] // when drops of each flow were last recorded
+	accepted *flowtrack.Cache[time.Time] // when each accepted flow last had a packet
+}
+
+// flowRecord is a FlowEvent as recorded on the hot path, without any
+// allocations.
+type flowRecord struct {
+	at       time.Time
+	dir      direction
+	proto    ipproto.Proto
+	src, dst netip.AddrPort
+	r        Response
+	why      string
+	rule     *Match
+}
+
+// NewFlowLog returns a FlowLog that keeps the most recent size events.
+func NewFlowLog(size int) *FlowLog {
+	return &FlowLog{
+		records:  make([]flowRecord, max(size, 1)),
+		drops:    &flowtrack.Cache[time.Time]{MaxEntries: maxDropFlows},
+		accepted: &flowtrack.Cache[time.Time]{MaxEntries: maxAcceptedFlows},
+	}
+}
+
+func (fl *FlowLog) add(r flowRecord) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	t := flowtrack.Tuple{Proto: r.proto, Src: r.src, Dst: r.dst}
+	if r.r == Accept {
+		last, ok := fl.accepted.Get(t)
+		isNew := !ok || r.at.Sub(*last) >= acceptedFlowIdle
+		fl.accepted.Add(t, r.at)
+		if !isNew {
+			return
+		}
+	} else {
+		if last, ok := fl.drops.Get(t); ok && r.at.Sub(*last) < dropLogInterval {
+			return
+		}
+		fl.drops.Add(t, r.at)
+	}
+	fl.records[fl.nextSeq%uint64(len(fl.records))] = r
+	fl.nextSeq++
+}
+
+// Since returns the events still in fl whose Seq is at least seq, oldest
+// first, and the Seq of the next event to be added, to pass to Since to
+// get only newer events.
+func (fl *FlowLog) Since(seq uint64) (events []FlowEvent, next uint64) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	n := uint64(len(fl.records))
+	if fl.nextSeq > n && seq < fl.nextSeq-n {
+		seq = fl.nextSeq - n
+	}
+	for ; seq < fl.nextSeq; seq++ {
+		r := &fl.records[seq%n]
+		ev := FlowEvent{
+			Seq:     seq,
+			Time:    r.at,
+			Dir:     r.dir.String(),
+			Proto:   r.proto,
+			Src:     r.src,
+			Dst:     r.dst,
+			Verdict: "drop",
+			Reason:  r.why,
+		}
+		if r.r == Accept {
+			ev.Verdict = "accept"
+		}
+		if r.rule != nil {
+			ev.Rule = r.rule.String()
+		}
+		events = append(events, ev)
+	}
+	return events, fl.nextSeq
+}
+
+// SetFlowLog sets the FlowLog that f adds new flows to, or stops it adding
+// them if fl is nil. Unlike the Filter's other settings, it may be changed
+// while f is in use.
+func (f *Filter) SetFlowLog(fl *FlowLog) {
+	f.flowLog.Store(fl)
+}
+
+// noteFlow adds a new flow of q's to f's FlowLog, if it has one, and
+// returns r and why.
+func (f *Filter) noteFlow(q *packet.Parsed, dir direction, r Response, why string, rule *Match) (Response, string) {
+	if fl := f.flowLog.Load(); fl != nil {
+		fl.add(flowRecord{
+			at:    time.Now(),
+			dir:   dir,
+			proto: q.IPProto,
+			src:   q.Src,
+			dst:   q.Dst,
+			r:     r,
+			why:   why,
+			rule:  rule,
+		})
+	}
+	return r, why
+}
@@ -0,0 +1,74 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package filter
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"tailscale.com/types/ipproto"
+)
+
+func TestFlowLog(t *testing.T) {
+	acl := newFilter(t.Logf)
+	fl := NewFlowLog(4)
+	acl.SetFlowLog(fl)
+
+	tcpOK := parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22)
+	tcpDenied := parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 80)
+	tcpNonSyn := tcpOK
+	tcpNonSyn.TCPFlags = 0
+	udpOut := parsed(ipproto.UDP, "1.2.3.4", "8.1.1.1", 53, 999)
+	udpReply := parsed(ipproto.UDP, "8.1.1.1", "1.2.3.4", 999, 53)
+	udpIn := parsed(ipproto.UDP, "8.1.1.1", "1.2.3.4", 1000, 22)
+
+	acl.runIn4(&tcpOK)
+	acl.runIn4(&tcpDenied)
+	acl.runIn4(&tcpDenied) // a retransmit of a dropped flow
+	acl.runIn4(&tcpNonSyn) // not a new flow
+	acl.runOut(&udpOut)
+	acl.runOut(&udpOut)   // not a new flow
+	acl.runIn4(&udpReply) // allowed by state, not a new flow
+	acl.runIn4(&udpIn)
+	acl.runIn4(&udpIn) // not a new flow
+
+	events, next := fl.Since(0)
+	if next != 4 {
+		t.Errorf("next = %d; want 4", next)
+	}
+	var got []string
+	for _, ev := range events {
+		got = append(got, fmt.Sprintf("%d %s %v %v>%v %s %q %s", ev.Seq, ev.Dir, ev.Proto, ev.Src, ev.Dst, ev.Verdict, ev.Reason, ev.Rule))
+	}
+	want := []string{
+		`0 in TCP 8.1.1.1:999>1.2.3.4:22 accept "tcp ok" [TCP UDP ICMPv4 ICMPv6][8.1.1.1/32,8.2.2.2/32]=>[1.2.3.4/32:22,5.6.7.8/32:23-24]`,
+		`1 in TCP 8.1.1.1:999>1.2.3.4:80 drop "no rules matched" `,
+		`2 out UDP 1.2.3.4:53>8.1.1.1:999 accept "ok out" `,
+		`3 in UDP 8.1.1.1:1000>1.2.3.4:22 accept "ok" [TCP UDP ICMPv4 ICMPv6][8.1.1.1/32,8.2.2.2/32]=>[1.2.3.4/32:22,5.6.7.8/32:23-24]`,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("events:\n got %q\nwant %q", got, want)
+	}
+
+	// Once the ring buffer wraps, only the newest events remain.
+	for i := 0; i < 5; i++ {
+		p := tcpDenied
+		p.Src = netip.AddrPortFrom(p.Src.Addr(), uint16(1000+i))
+		acl.runIn4(&p)
+	}
+	events, next = fl.Since(1)
+	if next != 9 || len(events) != 4 || events[0].Seq != 5 {
+		t.Errorf("after wrapping, got %d events from seq %d, next %d", len(events), events[0].Seq, next)
+	}
+	if events, _ := fl.Since(next); len(events) != 0 {
+		t.Errorf("Since(next) = %v; want none", events)
+	}
+
+	acl.SetFlowLog(nil)
+	acl.runIn4(&tcpOK)
+	if _, n := fl.Since(0); n != next {
+		t.Errorf("flow logged after SetFlowLog(nil)")
+	}
+}
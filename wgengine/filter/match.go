@@ -99,8 +99,10 @@ func (m Match) String() string {
 
 type matches []Match
 
-func (ms matches) match(q *packet.Parsed) bool {
-	for _, m := range ms {
+// match returns the first Match in ms that q matches, or nil if none do.
+func (ms matches) match(q *packet.Parsed) *Match {
+	for i := range ms {
+		m := &ms[i]
 		if !slices.Contains(m.IPProto, q.IPProto) {
 			continue
 		}
@@ -114,31 +116,36 @@ func (ms matches) match(q *packet.Parsed) bool {
 			if !dst.Ports.contains(q.Dst.Port()) {
 				continue
 			}
-			return true
+			return m
 		}
 	}
-	return false
+	return nil
 }
 
-func (ms matches) matchIPsOnly(q *packet.Parsed) bool {
-	for _, m := range ms {
+// matchIPsOnly returns the first Match in ms that q's IP addresses match,
+// ignoring its protocol and ports, or nil if none do.
+func (ms matches) matchIPsOnly(q *packet.Parsed) *Match {
+	for i := range ms {
+		m := &ms[i]
 		if !ipInList(q.Src.Addr(), m.Srcs) {
 			continue
 		}
 		for _, dst := range m.Dsts {
 			if dst.Net.Contains(q.Dst.Addr()) {
-				return true
+				return m
 			}
 		}
 	}
-	return false
+	return nil
 }
 
-// matchProtoAndIPsOnlyIfAllPorts reports q matches any Match in ms where the
-// Match if for the right IP Protocol and IP address, but ports are
-// ignored, as long as the match is for the entire uint16 port range.
-func (ms matches) matchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed) bool {
-	for _, m := range ms {
+// matchProtoAndIPsOnlyIfAllPorts returns the first Match in ms where the
+// Match is for the right IP Protocol and IP address, but ports are
+// ignored, as long as the match is for the entire uint16 port range. It
+// returns nil if there's none.
+func (ms matches) matchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed) *Match {
+	for i := range ms {
+		m := &ms[i]
 		if !slices.Contains(m.IPProto, q.IPProto) {
 			continue
 		}
@@ -150,11 +157,11 @@ func (ms matches) matchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed) bool {
 				continue
 			}
 			if dst.Net.Contains(q.Dst.Addr()) {
-				return true
+				return m
 			}
 		}
 	}
-	return false
+	return nil
 }
 
 func ipInList(ip netip.Addr, netlist []netip.Prefix) bool {
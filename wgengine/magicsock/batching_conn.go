@@ -153,7 +153,7 @@ func (c *batchingUDPConn) putSendBatch(batch *sendBatch) {
 	c.sendBatchPool.Put(batch)
 }
 
-func (c *batchingUDPConn) WriteBatchTo(buffs [][]byte, addr netip.AddrPort) error {
+func (c *batchingUDPConn) WriteBatchTo(buffs [][]byte, addr netip.AddrPort, tos uint8) error {
 	batch := c.getSendBatch()
 	defer c.putSendBatch(batch)
 	if addr.Addr().Is6() {
@@ -181,6 +181,11 @@ func (c *batchingUDPConn) WriteBatchTo(buffs [][]byte, addr netip.AddrPort) erro
 		}
 		n = len(buffs)
 	}
+	if tos != 0 {
+		for i := range batch.msgs[:n] {
+			appendTOSToControl(&batch.msgs[i].OOB, addr.Addr().Is6(), tos)
+		}
+	}
 
 	err := c.writeBatch(batch.msgs[:n])
 	if err != nil && c.txOffload.Load() && neterror.ShouldDisableUDPGSO(err) {
@@ -56,8 +56,11 @@ type D struct {
 				continue
 			}
 			home := ""
-			if e.regionID == c.myDerp {
+			switch e.regionID {
+			case c.myDerp:
 				home = "🏠"
+			case c.derpStandby:
+				home = "(standby)"
 			}
 			fmt.Fprintf(w, "<li>%s %d - %v: created %v ago, write %v ago</li>\n",
 				home, e.regionID, html.EscapeString(r.RegionCode),
@@ -68,6 +71,29 @@ type D struct {
 
 	}
 	fmt.Fprintf(w, "</ul>\n")
+	if race := c.lastDERPRace; race != nil {
+		fmt.Fprintf(w, "<p>Last home failover, %v ago, from derp-%d: ", now.Sub(race.When).Round(time.Second), race.Failed)
+		if race.Winner != 0 {
+			fmt.Fprintf(w, "derp-%d won.", race.Winner)
+		} else {
+			fmt.Fprintf(w, "failed: %s.", html.EscapeString(race.Err))
+		}
+		fmt.Fprintf(w, "</p><ul>\n")
+		for i, rid := range race.Regions {
+			r := race.Results[i]
+			switch {
+			case !r.Started:
+				fmt.Fprintf(w, "<li>derp-%d: not tried</li>\n", rid)
+			case r.Err != "":
+				fmt.Fprintf(w, "<li>derp-%d: %s after %v</li>\n", rid, html.EscapeString(r.Err), r.Latency.Round(time.Millisecond))
+			case r.Latency == 0:
+				fmt.Fprintf(w, "<li>derp-%d: still connecting</li>\n", rid)
+			default:
+				fmt.Fprintf(w, "<li>derp-%d: connected in %v</li>\n", rid, r.Latency.Round(time.Millisecond))
+			}
+		}
+		fmt.Fprintf(w, "</ul>\n")
+	}
 
 	fmt.Fprintf(w, "<h2 id=ipport><a href=#ipport>#</a> ip:port to endpoint</h2><ul>")
 	{
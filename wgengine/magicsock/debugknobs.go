@@ -56,6 +56,19 @@
 	debugEnablePMTUD = envknob.RegisterOptBool("TS_DEBUG_ENABLE_PMTUD")
 	// debugPMTUD prints extra debugging about peer MTU path discovery.
 	debugPMTUD = envknob.RegisterBool("TS_DEBUG_PMTUD")
+	// debugMultipath, if set to "duplicate" or "stripe", also sends
+	// packets to peers with trusted direct paths along a second path.
+	// See multipathMode.
+	debugMultipath = envknob.RegisterString("TS_DEBUG_MAGICSOCK_MULTIPATH")
+	// debugDisableUDPOffload disables UDP generic segmentation and receive
+	// offload (GSO/GRO) on the magicsock UDP sockets.
+	debugDisableUDPOffload = envknob.RegisterBool("TS_DEBUG_DISABLE_UDP_OFFLOAD")
+	// debugDisableDERPStandby disables keeping a connection to a second
+	// DERP region to fail over to if the home region fails.
+	debugDisableDERPStandby = envknob.RegisterBool("TS_DEBUG_DISABLE_DERP_STANDBY")
+	// debugBackgroundReSTUN makes idle non-mobile clients still re-STUN
+	// every few minutes, so the netcheck history covers idle periods.
+	debugBackgroundReSTUN = envknob.RegisterBool("TS_DEBUG_BACKGROUND_RESTUN")
 	// Hey you! Adding a new debugknob? Make sure to stub it out in the
 	// debugknobs_stubs.go file too.
 )
@@ -21,10 +21,14 @@ func debugUseDERPHTTP() bool           { return false }
 func debugEnableSilentDisco() bool     { return false }
 func debugSendCallMeUnknownPeer() bool { return false }
 func debugPMTUD() bool                 { return false }
+func debugDisableUDPOffload() bool     { return false }
+func debugDisableDERPStandby() bool    { return false }
 func debugUseDERPAddr() string         { return "" }
+func debugMultipath() string           { return "" }
 func debugUseDerpRouteEnv() string     { return "" }
 func debugUseDerpRoute() opt.Bool      { return "" }
 func debugEnablePMTUD() opt.Bool       { return "" }
 func debugRingBufferMaxSizeBytes() int { return 0 }
 func inTest() bool                     { return false }
 func debugPeerMap() bool               { return false }
+func debugBackgroundReSTUN() bool      { return false }
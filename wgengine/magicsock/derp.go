@@ -15,11 +15,13 @@
 	"runtime"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tailscale/wireguard-go/conn"
 	"tailscale.com/derp"
 	"tailscale.com/derp/derphttp"
+	"tailscale.com/disco"
 	"tailscale.com/health"
 	"tailscale.com/logtail/backoff"
 	"tailscale.com/net/dnscache"
@@ -74,9 +76,9 @@ func (c *Conn) addDerpPeerRoute(peer key.NodePublic, derpID int, dc *derphttp.Cl
 
 // activeDerp contains fields for an active DERP connection.
 type activeDerp struct {
-	c       *derphttp.Client
-	cancel  context.CancelFunc
-	writeCh chan<- derpWriteRequest
+	c      *derphttp.Client
+	cancel context.CancelFunc
+	writeQ *derpWriteQueue
 	// lastWrite is the time of the last request for its write
 	// channel (currently even if there was no write).
 	// It is always non-nil and initialized to a non-zero Time.
@@ -190,7 +192,7 @@ func (c *Conn) goDerpConnect(node int) {
 	if node == 0 {
 		return
 	}
-	go c.derpWriteChanOfAddr(netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, uint16(node)), key.NodePublic{})
+	go c.derpWriteQueueOfAddr(netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, uint16(node)), key.NodePublic{})
 }
 
 var (
@@ -247,13 +249,13 @@ func bufferedDerpWritesBeforeDrop() int {
 	return bufferedDerpWrites
 }
 
-// derpWriteChanOfAddr returns a DERP client for fake UDP addresses that
-// represent DERP servers, creating them as necessary. For real UDP
-// addresses, it returns nil.
+// derpWriteQueueOfAddr returns the write queue of a DERP client for
+// fake UDP addresses that represent DERP servers, creating them as
+// necessary. For real UDP addresses, it returns nil.
 //
 // If peer is non-zero, it can be used to find an active reverse
 // path, without using addr.
-func (c *Conn) derpWriteChanOfAddr(addr netip.AddrPort, peer key.NodePublic) chan<- derpWriteRequest {
+func (c *Conn) derpWriteQueueOfAddr(addr netip.AddrPort, peer key.NodePublic) *derpWriteQueue {
 	if addr.Addr() != tailcfg.DerpMagicIPAddr {
 		return nil
 	}
@@ -284,7 +286,7 @@ func (c *Conn) derpWriteChanOfAddr(addr netip.AddrPort, peer key.NodePublic) cha
 	if ok {
 		*ad.lastWrite = time.Now()
 		c.setPeerLastDerpLocked(peer, regionID, regionID)
-		return ad.writeCh
+		return ad.writeQ
 	}
 
 	// If we don't have an open connection to the peer's home DERP
@@ -298,7 +300,7 @@ func (c *Conn) derpWriteChanOfAddr(addr netip.AddrPort, peer key.NodePublic) cha
 			if ad, ok := c.activeDerp[r.derpID]; ok && ad.c == r.dc {
 				c.setPeerLastDerpLocked(peer, r.derpID, regionID)
 				*ad.lastWrite = time.Now()
-				return ad.writeCh
+				return ad.writeQ
 			}
 		}
 	}
@@ -342,10 +344,13 @@ func (c *Conn) derpWriteChanOfAddr(addr netip.AddrPort, peer key.NodePublic) cha
 	dc.DNSCache = dnscache.Get()
 
 	ctx, cancel := context.WithCancel(c.connCtx)
-	ch := make(chan derpWriteRequest, bufferedDerpWritesBeforeDrop())
+	q := &derpWriteQueue{
+		data:  make(chan derpWriteRequest, bufferedDerpWritesBeforeDrop()),
+		disco: make(chan derpWriteRequest, derpDiscoWritesBeforeDrop),
+	}
 
 	ad.c = dc
-	ad.writeCh = ch
+	ad.writeQ = q
 	ad.cancel = cancel
 	ad.lastWrite = new(time.Time)
 	*ad.lastWrite = time.Now()
@@ -378,10 +383,10 @@ func (c *Conn) derpWriteChanOfAddr(addr netip.AddrPort, peer key.NodePublic) cha
 	}
 
 	go c.runDerpReader(ctx, addr, dc, wg, startGate)
-	go c.runDerpWriter(ctx, dc, ch, wg, startGate)
+	go c.runDerpWriter(ctx, dc, q, wg, startGate)
 	go c.derpActiveFunc()
 
-	return ad.writeCh
+	return ad.writeQ
 }
 
 // setPeerLastDerpLocked notes that peer is now being written to via
@@ -469,6 +474,7 @@ func (c *Conn) runDerpReader(ctx context.Context, derpFakeAddr netip.AddrPort, d
 	bo := backoff.NewBackoff(fmt.Sprintf("derp-%d", regionID), c.logf, 5*time.Second)
 	var lastPacketTime time.Time
 	var lastPacketSrc key.NodePublic
+	var failures int // read errors in a row
 
 	for {
 		msg, connGen, err := dc.RecvDetail()
@@ -494,6 +500,11 @@ func (c *Conn) runDerpReader(ctx context.Context, derpFakeAddr netip.AddrPort, d
 
 			c.logf("magicsock: [%p] derp.Recv(derp-%d): %v", dc, regionID, err)
 
+			// If this is our home DERP and it keeps failing, race
+			// other regions to replace it.
+			failures++
+			c.maybeFailoverDERPHome(regionID, failures)
+
 			// If our DERP connection broke, it might be because our network
 			// conditions changed. Start that check.
 			c.ReSTUN("derp-recv-error")
@@ -508,6 +519,7 @@ func (c *Conn) runDerpReader(ctx context.Context, derpFakeAddr netip.AddrPort, d
 			continue
 		}
 		bo.BackOff(ctx, nil) // reset
+		failures = 0
 
 		now := time.Now()
 		if lastPacketTime.IsZero() || now.Sub(lastPacketTime) > 5*time.Second {
@@ -548,6 +560,10 @@ func (c *Conn) runDerpReader(ctx context.Context, derpFakeAddr netip.AddrPort, d
 			continue
 		case derp.HealthMessage:
 			health.SetDERPRegionHealth(regionID, m.Problem)
+		case derp.RateLimitedMessage:
+			metricDERPRateLimited.Add(1)
+			c.logf("magicsock: derp-%d rate limited us; retry after %v, limit %d bytes/sec", regionID, m.RetryAfter, m.BytesPerSecond)
+			continue
 		case derp.PeerGoneMessage:
 			switch m.Reason {
 			case derp.PeerGoneReasonDisconnected:
@@ -588,9 +604,42 @@ type derpWriteRequest struct {
 	b      []byte // copied; ownership passed to receiver
 }
 
+// derpDiscoWritesBeforeDrop is how many disco messages can be queued for
+// a DERP connection's writer before they're dropped.
+const derpDiscoWritesBeforeDrop = 32
+
+// derpWriteQueue is the queue of packets for a DERP connection's writer.
+// Disco messages have their own queue, which is written first, so that
+// path discovery isn't starved or dropped behind bulk data.
+type derpWriteQueue struct {
+	data  chan derpWriteRequest
+	disco chan derpWriteRequest
+
+	// sendingData is whether the writer is sending a data packet,
+	// which a disco message queued meanwhile has to wait for.
+	sendingData atomic.Bool
+}
+
+// enqueue queues wr for writing, reporting whether there was room.
+func (q *derpWriteQueue) enqueue(wr derpWriteRequest) bool {
+	ch := q.data
+	if disco.LooksLikeDiscoWrapper(wr.b) {
+		ch = q.disco
+		if q.sendingData.Load() {
+			metricSendDERPDiscoInversion.Add(1)
+		}
+	}
+	select {
+	case ch <- wr:
+		return true
+	default:
+		return false
+	}
+}
+
 // runDerpWriter runs in a goroutine for the life of a DERP
-// connection, handling received packets.
-func (c *Conn) runDerpWriter(ctx context.Context, dc *derphttp.Client, ch <-chan derpWriteRequest, wg *syncs.WaitGroupChan, startGate <-chan struct{}) {
+// connection, writing the packets queued in q.
+func (c *Conn) runDerpWriter(ctx context.Context, dc *derphttp.Client, q *derpWriteQueue, wg *syncs.WaitGroupChan, startGate <-chan struct{}) {
 	defer wg.Decr()
 	select {
 	case <-startGate:
@@ -598,18 +647,52 @@ func (c *Conn) runDerpWriter(ctx context.Context, dc *derphttp.Client, ch <-chan
 		return
 	}
 
+	// The queue depths this writer last added to the gauges.
+	var dataDepth, discoDepth int64
+	defer func() {
+		metricSendDERPQueueDepth.Add(-dataDepth)
+		metricSendDERPDiscoQueueDepth.Add(-discoDepth)
+	}()
+	send := func(wr derpWriteRequest) {
+		err := dc.Send(wr.pubKey, wr.b)
+		if err != nil {
+			c.logf("magicsock: derp.Send(%v): %v", wr.addr, err)
+			metricSendDERPError.Add(1)
+		} else {
+			metricSendDERP.Add(1)
+		}
+	}
+
 	for {
+		d := int64(len(q.data))
+		metricSendDERPQueueDepth.Add(d - dataDepth)
+		dataDepth = d
+		d = int64(len(q.disco))
+		metricSendDERPDiscoQueueDepth.Add(d - discoDepth)
+		discoDepth = d
+
+		select {
+		case wr := <-q.disco:
+			send(wr)
+			continue
+		default:
+		}
 		select {
 		case <-ctx.Done():
 			return
-		case wr := <-ch:
-			err := dc.Send(wr.pubKey, wr.b)
-			if err != nil {
-				c.logf("magicsock: derp.Send(%v): %v", wr.addr, err)
-				metricSendDERPError.Add(1)
-			} else {
-				metricSendDERP.Add(1)
+		case wr := <-q.disco:
+			send(wr)
+		case wr := <-q.data:
+			// If a disco message arrived at the same time, it
+			// goes first.
+			select {
+			case dwr := <-q.disco:
+				send(dwr)
+			default:
 			}
+			q.sendingData.Store(true)
+			send(wr)
+			q.sendingData.Store(false)
 		}
 	}
 }
@@ -699,6 +782,16 @@ func (c *Conn) SetDERPMap(dm *tailcfg.DERPMap) {
 		}
 	}
 
+	c.derpMapBase = dm
+	c.maybeFetchFederatedDERPLocked()
+	c.setDERPMapLocked(c.withFederatedDERPLocked(dm))
+}
+
+// setDERPMapLocked sets the DERP map in use to dm, which is the DERP map
+// from SetDERPMap with any federated regions added.
+//
+// c.mu must be held.
+func (c *Conn) setDERPMapLocked(dm *tailcfg.DERPMap) {
 	if reflect.DeepEqual(dm, c.derpMap) {
 		return
 	}
@@ -722,6 +815,9 @@ func (c *Conn) SetDERPMap(dm *tailcfg.DERPMap) {
 			if rid == c.myDerp {
 				c.myDerp = 0
 			}
+			if rid == c.derpStandby {
+				c.derpStandby = 0
+			}
 			c.closeDerpLocked(rid, "derp-region-redefined")
 		}
 		if changes {
@@ -860,7 +956,7 @@ func (c *Conn) cleanStaleDerp() {
 	dirty := false
 	someNonHomeOpen := false
 	for i, ad := range c.activeDerp {
-		if i == c.myDerp {
+		if i == c.myDerp || i == c.derpStandby {
 			continue
 		}
 		if ad.lastWrite.Before(tooOld) {
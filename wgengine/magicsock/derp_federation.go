@@ -0,0 +1,145 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"time"
+
+	"tailscale.com/derp/federation"
+	"tailscale.com/net/netns"
+	"tailscale.com/net/tshttpproxy"
+	"tailscale.com/tailcfg"
+)
+
+const (
+	// federatedDERPRefetchInterval is how often the DERP regions of a
+	// DERP map's Federation are fetched again.
+	federatedDERPRefetchInterval = 30 * time.Minute
+
+	// federatedDERPFetchTimeout bounds fetching them.
+	federatedDERPFetchTimeout = 30 * time.Second
+)
+
+// federatedDERP is what a Conn learned from its DERP map's Federation.
+type federatedDERP struct {
+	cfg      *tailcfg.DERPFederation             // what byURL was fetched for, or nil
+	byURL    map[string][]*federation.Descriptor // valid descriptors from each of cfg.URLs
+	fetching bool                                // whether a fetch is running
+	stale    bool                                // whether byURL is due to be fetched again
+	timer    *time.Timer                         // refetch timer, or nil
+}
+
+// withFederatedDERPLocked returns dm with the regions learned from its
+// Federation added, if they've been fetched. c.mu must be held.
+func (c *Conn) withFederatedDERPLocked(dm *tailcfg.DERPMap) *tailcfg.DERPMap {
+	if dm == nil || dm.Federation == nil || !reflect.DeepEqual(dm.Federation, c.derpFed.cfg) {
+		return dm
+	}
+	now := time.Now()
+	var descs []*federation.Descriptor
+	for _, u := range dm.Federation.URLs {
+		for _, d := range c.derpFed.byURL[u] {
+			if now.Before(d.Expires) {
+				descs = append(descs, d)
+			}
+		}
+	}
+	return federation.AddRegions(dm, descs)
+}
+
+// maybeFetchFederatedDERPLocked starts fetching the DERP regions of the
+// DERP map's Federation, if they haven't been fetched or are due to be
+// fetched again. c.mu must be held.
+func (c *Conn) maybeFetchFederatedDERPLocked() {
+	dm := c.derpMapBase
+	if dm == nil || dm.Federation == nil || len(dm.Federation.URLs) == 0 {
+		if c.derpFed.timer != nil {
+			c.derpFed.timer.Stop()
+		}
+		c.derpFed = federatedDERP{fetching: c.derpFed.fetching}
+		return
+	}
+	if c.derpFed.fetching || c.closed {
+		return
+	}
+	if reflect.DeepEqual(dm.Federation, c.derpFed.cfg) && !c.derpFed.stale {
+		return
+	}
+	c.derpFed.fetching = true
+	go c.fetchFederatedDERP(dm.Federation.Clone())
+}
+
+// refetchFederatedDERP is called by the refetch timer.
+func (c *Conn) refetchFederatedDERP() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.derpFed.stale = true
+	c.maybeFetchFederatedDERPLocked()
+}
+
+// fetchFederatedDERP fetches and verifies the DERP region descriptors at
+// fed's URLs, and adds their regions to the DERP map. Descriptors from
+// URLs that can't be fetched are kept until they expire.
+func (c *Conn) fetchFederatedDERP(fed *tailcfg.DERPFederation) {
+	ctx, cancel := context.WithTimeout(c.connCtx, federatedDERPFetchTimeout)
+	defer cancel()
+	hc := c.federationHTTPClient()
+	defer hc.CloseIdleConnections()
+
+	c.mu.Lock()
+	var prev map[string][]*federation.Descriptor
+	if reflect.DeepEqual(fed, c.derpFed.cfg) {
+		prev = c.derpFed.byURL
+	}
+	c.mu.Unlock()
+
+	byURL := map[string][]*federation.Descriptor{}
+	now := time.Now()
+	for _, u := range fed.URLs {
+		signed, err := federation.Fetch(ctx, hc, u)
+		if err != nil {
+			c.logf("magicsock: federated DERP: %v", err)
+			byURL[u] = prev[u]
+			continue
+		}
+		descs, _, err := federation.VerifyAll(signed, fed.Signers, now)
+		if err != nil {
+			c.logf("magicsock: federated DERP from %s: %v", u, err)
+		}
+		byURL[u] = descs
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.derpFed.fetching = false
+	if c.closed {
+		return
+	}
+	c.derpFed.cfg = fed
+	c.derpFed.byURL = byURL
+	c.derpFed.stale = false
+	if c.derpFed.timer == nil {
+		c.derpFed.timer = time.AfterFunc(federatedDERPRefetchInterval, c.refetchFederatedDERP)
+	} else {
+		c.derpFed.timer.Reset(federatedDERPRefetchInterval)
+	}
+	c.setDERPMapLocked(c.withFederatedDERPLocked(c.derpMapBase))
+
+	// In case the Federation changed while fetching.
+	c.maybeFetchFederatedDERPLocked()
+}
+
+// federationHTTPClient returns an HTTP client for fetching federated DERP
+// region descriptors, which dials outside of the tunnel like DERP
+// connections do.
+func (c *Conn) federationHTTPClient() *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = tshttpproxy.ProxyFromEnvironment
+	tshttpproxy.SetTransportGetProxyConnectHeader(tr)
+	tr.DialContext = netns.NewDialer(c.logf, c.netMon).DialContext
+	return &http.Client{Transport: tr}
+}
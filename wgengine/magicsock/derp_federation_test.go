@@ -0,0 +1,94 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"tailscale.com/derp/federation"
+	"tailscale.com/tailcfg"
+)
+
+func TestFederatedDERP(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := federation.Sign(&federation.Descriptor{
+		Operator: "example",
+		Region: &tailcfg.DERPRegion{
+			RegionID:   950,
+			RegionCode: "ex",
+			Nodes: []*tailcfg.DERPNode{{
+				Name:     "950a",
+				RegionID: 950,
+				HostName: "derp.example.com",
+				IPv4:     "127.0.0.1",
+				IPv6:     "none",
+				STUNPort: -1,
+			}},
+		},
+		Expires: time.Now().Add(time.Hour),
+	}, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]*federation.Signed{signed})
+	}))
+	defer ts.Close()
+
+	conn, err := NewConn(Options{
+		EndpointsFunc: func(eps []tailcfg.Endpoint) {},
+		Logf:          t.Logf,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fed := &tailcfg.DERPFederation{
+		URLs:    []string{ts.URL},
+		Signers: []*tailcfg.DERPFederationSigner{{Operator: "example", Key: pub}},
+	}
+	dm := &tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			1: {RegionID: 1, Nodes: []*tailcfg.DERPNode{{Name: "1a", RegionID: 1, HostName: "derp1.example.com", IPv4: "127.0.0.1", IPv6: "none", STUNPort: -1}}},
+		},
+		Federation: fed,
+	}
+	conn.SetDERPMap(dm)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if got := conn.derpMapAtomic.Load(); got != nil && got.Regions[950] != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("federated region never added to DERP map")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(dm.Regions) != 1 {
+		t.Errorf("SetDERPMap's map was modified")
+	}
+
+	// Setting the same map again, as on every netmap, keeps the
+	// federated region without fetching again.
+	conn.SetDERPMap(dm.Clone())
+	if conn.derpMapAtomic.Load().Regions[950] == nil {
+		t.Errorf("federated region dropped on DERP map update")
+	}
+
+	// Removing the Federation removes the region.
+	conn.SetDERPMap(&tailcfg.DERPMap{Regions: dm.Regions})
+	if conn.derpMapAtomic.Load().Regions[950] != nil {
+		t.Errorf("federated region kept after Federation removed")
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"cmp"
+	"context"
+	"net/netip"
+	"slices"
+	"time"
+
+	"tailscale.com/derp/derphttp"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/mak"
+)
+
+const (
+	// derpRaceRegions is how many DERP regions are raced to replace a
+	// failed home region.
+	derpRaceRegions = 3
+
+	// derpRaceStagger is how long to wait for one region to connect
+	// before also trying the next one.
+	derpRaceStagger = 250 * time.Millisecond
+
+	// derpRaceTimeout bounds a whole race.
+	derpRaceTimeout = 15 * time.Second
+
+	// derpHomeFailures is how many DERP read errors in a row on the
+	// home region, without a successful read between them, start a
+	// race to replace it. The first error is often just the server
+	// closing the connection; the second means reconnecting failed.
+	derpHomeFailures = 2
+
+	// derpHomeDownHoldoff is how long a home region that failed is
+	// avoided as home, even if netcheck still prefers it.
+	derpHomeDownHoldoff = time.Minute
+)
+
+// DERPRaceStatus is the state of DERP home failover, for debugging.
+type DERPRaceStatus struct {
+	// Home is the home DERP region ID, or 0 if none.
+	Home int
+
+	// Standby is the DERP region kept connected to fail over to if
+	// the home region fails, or 0 if none.
+	Standby int `json:",omitempty"`
+
+	// DownUntil are the regions that recently failed as home, and
+	// until when they're avoided as home.
+	DownUntil map[int]time.Time `json:",omitempty"`
+
+	// LastRace is the most recent race to replace a failed home
+	// region, if any.
+	LastRace *DERPRace `json:",omitempty"`
+}
+
+// DERPRace is a race to replace a failed home DERP region.
+type DERPRace struct {
+	When   time.Time
+	Failed int // region ID of the home region that failed
+
+	// Regions are the region IDs raced, in order of preference.
+	// Results are the outcomes of connecting to each, indexed the
+	// same.
+	Regions []int
+	Results []derphttp.RaceResult
+
+	Winner int    `json:",omitempty"` // region ID that won, or 0 if none did
+	Err    string `json:",omitempty"`
+}
+
+// DERPRaceStatus returns the state of DERP home failover.
+func (c *Conn) DERPRaceStatus() DERPRaceStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := DERPRaceStatus{
+		Home:     c.myDerp,
+		Standby:  c.derpStandby,
+		LastRace: c.lastDERPRace,
+	}
+	now := time.Now()
+	for rid, until := range c.derpDownUntil {
+		if now.Before(until) {
+			mak.Set(&st.DownUntil, rid, until)
+		}
+	}
+	return st
+}
+
+// derpDownLocked reports whether regionID failed as home recently
+// enough that it should be avoided. c.mu must be held.
+func (c *Conn) derpDownLocked(regionID int, now time.Time) bool {
+	until, ok := c.derpDownUntil[regionID]
+	if ok && !now.Before(until) {
+		delete(c.derpDownUntil, regionID)
+		return false
+	}
+	return ok
+}
+
+// derpRegionsByLatencyLocked returns up to n DERP region IDs other than
+// the home region, fastest first according to report. Regions without a
+// latency in report follow, by ID. Regions that are down or that the DERP
+// map says to avoid are left out. c.mu must be held.
+func (c *Conn) derpRegionsByLatencyLocked(report *netcheck.Report, n int) []int {
+	if c.derpMap == nil {
+		return nil
+	}
+	now := time.Now()
+	var ids []int
+	for rid, r := range c.derpMap.Regions {
+		if rid == c.myDerp || r == nil || r.Avoid || c.derpDownLocked(rid, now) {
+			continue
+		}
+		ids = append(ids, rid)
+	}
+	latency := func(rid int) (time.Duration, bool) {
+		if report == nil {
+			return 0, false
+		}
+		d, ok := report.RegionLatency[rid]
+		return d, ok
+	}
+	slices.SortFunc(ids, func(a, b int) int {
+		da, oka := latency(a)
+		db, okb := latency(b)
+		switch {
+		case oka && !okb:
+			return -1
+		case okb && !oka:
+			return 1
+		case da != db:
+			return cmp.Compare(da, db)
+		}
+		return cmp.Compare(a, b)
+	})
+	if len(ids) > n {
+		ids = ids[:n]
+	}
+	return ids
+}
+
+// avoidDownDERP returns the region to use as home when netcheck prefers
+// the region preferred: the current home instead, if preferred failed as
+// home recently.
+//
+// c.mu must NOT be held.
+func (c *Conn) avoidDownDERP(preferred int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.myDerp == 0 || !c.derpDownLocked(preferred, time.Now()) {
+		return preferred
+	}
+	return c.myDerp
+}
+
+// updateDERPStandby picks the fastest DERP region other than home
+// according to report as the warm standby, and makes sure it's
+// connected, so that failing over to it if the home region fails takes
+// no handshake.
+//
+// c.mu must NOT be held.
+func (c *Conn) updateDERPStandby(report *netcheck.Report) {
+	if debugDisableDERPStandby() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.myDerp == 0 || c.privateKey.IsZero() || c.derpFailingOver {
+		return
+	}
+	var standby int
+	if rids := c.derpRegionsByLatencyLocked(report, 1); len(rids) > 0 {
+		standby = rids[0]
+	}
+	if standby != c.derpStandby {
+		c.logf("magicsock: DERP warm standby is now derp-%d", standby)
+		c.derpStandby = standby
+	}
+	c.goDerpConnect(standby)
+}
+
+// maybeFailoverDERPHome is called by the DERP reader of regionID after
+// failures read errors in a row. If regionID is home and failed enough,
+// it's avoided as home for a while, and a race starts among the standby
+// and the next fastest regions to replace it.
+//
+// c.mu must NOT be held.
+func (c *Conn) maybeFailoverDERPHome(regionID, failures int) {
+	if failures < derpHomeFailures {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || regionID != c.myDerp || c.derpFailingOver {
+		return
+	}
+	mak.Set(&c.derpDownUntil, regionID, time.Now().Add(derpHomeDownHoldoff))
+	var regions []int
+	if c.derpStandby != 0 && c.derpStandby != regionID {
+		regions = append(regions, c.derpStandby)
+	}
+	for _, rid := range c.derpRegionsByLatencyLocked(c.lastNetCheckReport.Load(), derpRaceRegions) {
+		if len(regions) < derpRaceRegions && !slices.Contains(regions, rid) {
+			regions = append(regions, rid)
+		}
+	}
+	if len(regions) == 0 {
+		c.logf("magicsock: home derp-%d failing; no other DERP regions to fail over to", regionID)
+		return
+	}
+	c.logf("magicsock: home derp-%d failing; racing derp regions %v", regionID, regions)
+	c.derpFailingOver = true
+	go c.raceDERPHome(regionID, regions)
+}
+
+// raceDERPHome races connecting to regions, in order of preference, to
+// replace the failed home region, and makes the winner home. The first
+// loser still connected becomes the new warm standby.
+func (c *Conn) raceDERPHome(failed int, regions []int) {
+	ctx, cancel := context.WithTimeout(c.connCtx, derpRaceTimeout)
+	defer cancel()
+	race := &DERPRace{
+		When:    time.Now(),
+		Failed:  failed,
+		Regions: regions,
+	}
+	winner, results, err := derphttp.RaceConnect(ctx, len(regions), derpRaceStagger, func(i int) *derphttp.Client {
+		return c.derpClientForRegion(regions[i])
+	})
+	race.Results = results
+	if err != nil {
+		race.Err = err.Error()
+	} else {
+		race.Winner = regions[winner]
+	}
+
+	c.mu.Lock()
+	c.derpFailingOver = false
+	c.lastDERPRace = race
+	homeChanged := c.myDerp != failed
+	c.mu.Unlock()
+
+	if err != nil {
+		c.logf("magicsock: racing derp regions %v to replace derp-%d: %v", regions, failed, err)
+		return
+	}
+	if homeChanged {
+		c.logf("magicsock: derp-%d won race to replace derp-%d, but home already changed", race.Winner, failed)
+		return
+	}
+	metricDERPHomeFailover.Add(1)
+	c.logf("magicsock: derp-%d won race to replace home derp-%d in %v", race.Winner, failed, results[winner].Latency.Round(time.Millisecond))
+	c.setNearestDERP(race.Winner)
+
+	c.mu.Lock()
+	c.derpStandby = 0
+	for i, r := range results {
+		if i != winner && r.Started && r.Err == "" {
+			c.derpStandby = regions[i]
+			break
+		}
+	}
+	c.mu.Unlock()
+
+	// Tell control about the new home.
+	c.ReSTUN("derp-failover")
+}
+
+// derpClientForRegion returns the client for the DERP connection to
+// regionID, adding the connection if needed, or nil if DERP is
+// unavailable.
+//
+// c.mu must NOT be held.
+func (c *Conn) derpClientForRegion(regionID int) *derphttp.Client {
+	if c.derpWriteQueueOfAddr(netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, uint16(regionID)), key.NodePublic{}) == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ad, ok := c.activeDerp[regionID]; ok {
+		return ad.c
+	}
+	return nil
+}
+
+var metricDERPHomeFailover = clientmetric.NewCounter("magicsock_derp_home_failover")
@@ -0,0 +1,60 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/net/netcheck"
+	"tailscale.com/tailcfg"
+)
+
+func TestDERPRegionsByLatency(t *testing.T) {
+	c := newConn()
+	c.derpMap = &tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{
+		1: {RegionID: 1},
+		2: {RegionID: 2},
+		3: {RegionID: 3},
+		4: {RegionID: 4, Avoid: true},
+		5: {RegionID: 5},
+		6: {RegionID: 6},
+	}}
+	c.myDerp = 1
+	report := &netcheck.Report{RegionLatency: map[int]time.Duration{
+		1: 5 * time.Millisecond,
+		2: 30 * time.Millisecond,
+		3: 10 * time.Millisecond,
+		4: time.Millisecond,
+	}}
+
+	if got, want := c.derpRegionsByLatencyLocked(report, 10), []int{3, 2, 5, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("regions = %v; want %v", got, want)
+	}
+	if got, want := c.derpRegionsByLatencyLocked(report, 1), []int{3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("regions = %v; want %v", got, want)
+	}
+	if got, want := c.derpRegionsByLatencyLocked(nil, 2), []int{2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("regions without report = %v; want %v", got, want)
+	}
+
+	c.derpDownUntil = map[int]time.Time{
+		3: time.Now().Add(time.Minute),
+		5: time.Now().Add(-time.Second),
+	}
+	if got, want := c.derpRegionsByLatencyLocked(report, 10), []int{2, 5, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("regions with 3 down = %v; want %v", got, want)
+	}
+	if _, ok := c.derpDownUntil[5]; ok {
+		t.Errorf("expired down region not forgotten")
+	}
+
+	if got := c.avoidDownDERP(3); got != 1 {
+		t.Errorf("avoidDownDERP(3) = %d; want current home 1", got)
+	}
+	if got := c.avoidDownDERP(2); got != 2 {
+		t.Errorf("avoidDownDERP(2) = %d; want 2", got)
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"testing"
+
+	"tailscale.com/disco"
+)
+
+func TestDERPWriteQueue(t *testing.T) {
+	q := &derpWriteQueue{
+		data:  make(chan derpWriteRequest, 2),
+		disco: make(chan derpWriteRequest, 1),
+	}
+	data := derpWriteRequest{b: []byte("data")}
+	discoMsg := derpWriteRequest{b: make([]byte, 128)}
+	copy(discoMsg.b, disco.Magic)
+
+	for i := 0; i < 2; i++ {
+		if !q.enqueue(data) {
+			t.Fatalf("data %d dropped", i)
+		}
+	}
+	if q.enqueue(data) {
+		t.Errorf("data queued past the queue's capacity")
+	}
+
+	// A full data queue doesn't hold up disco.
+	inversions := metricSendDERPDiscoInversion.Value()
+	q.sendingData.Store(true)
+	if !q.enqueue(discoMsg) {
+		t.Fatalf("disco dropped behind full data queue")
+	}
+	if got := metricSendDERPDiscoInversion.Value() - inversions; got != 1 {
+		t.Errorf("counted %d priority inversions; want 1", got)
+	}
+	if q.enqueue(discoMsg) {
+		t.Errorf("disco queued past the queue's capacity")
+	}
+	if len(q.disco) != 1 || len(q.data) != 2 {
+		t.Errorf("queue depths disco=%d data=%d; want 1, 2", len(q.disco), len(q.data))
+	}
+}
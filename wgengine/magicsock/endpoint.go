@@ -31,6 +31,7 @@
 	"tailscale.com/tstime/mono"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
+	"tailscale.com/types/views"
 	"tailscale.com/util/mak"
 	"tailscale.com/util/ringbuffer"
 )
@@ -69,6 +70,11 @@ type endpoint struct {
 
 	disco atomic.Pointer[endpointDisco] // if the peer supports disco, the key and short string
 
+	txPackets atomic.Uint64 // WireGuard packets sent to the peer
+	rxPackets atomic.Uint64 // WireGuard packets received from the peer
+	innerDSCP atomic.Uint32 // DSCP of the packets being sent to the peer; see Conn.NoteInnerDSCP
+	peerDSCP  atomic.Uint32 // DSCP control asked to mark packets to the peer with; see tailcfg.Node.DSCPForThisPeer
+
 	// mu protects all following fields.
 	mu sync.Mutex // Lock ordering: Conn.mu, then endpoint.mu
 
@@ -82,6 +88,7 @@ type endpoint struct {
 	trustBestAddrUntil mono.Time   // time when bestAddr expires
 	sentPing           map[stun.TxID]sentPing
 	endpointState      map[netip.AddrPort]*endpointState
+	pathStats          [numPathKinds]pathStats // recent pings by kind of path
 	isCallMeMaybeEP    map[netip.AddrPort]bool
 
 	// The following fields are related to the new "silent disco"
@@ -91,6 +98,8 @@ type endpoint struct {
 
 	expired         bool // whether the node has expired
 	isWireguardOnly bool // whether the endpoint is WireGuard only
+
+	pin EndpointPin // fixed path for the peer's traffic, if any; see Conn.SetEndpointPins
 }
 
 // endpointDisco is the current disco key and short string for an endpoint. This
@@ -138,6 +147,10 @@ type endpointState struct {
 	recentPong  uint16      // index into recentPongs of most recent; older before, wrapped
 
 	index int16 // index in nodecfg.Node.Endpoints; meaningless if lastGotPing non-zero
+
+	// typ is the type of the endpoint as advertised by the peer, or
+	// tailcfg.EndpointUnknownType if unknown or learned at runtime.
+	typ tailcfg.EndpointType
 }
 
 // clear removes all derived / probed state from an endpointState.
@@ -145,6 +158,7 @@ func (s *endpointState) clear() {
 	*s = endpointState{
 		index:       s.index,
 		lastGotPing: s.lastGotPing,
+		typ:         s.typ,
 	}
 }
 
@@ -235,8 +249,10 @@ func (de *endpoint) initFakeUDPAddr() {
 }
 
 // noteRecvActivity records receive activity on de, and invokes
-// Conn.noteRecvActivity no more than once every 10s.
+// Conn.noteRecvActivity no more than once every 10s. It's called once
+// per WireGuard packet received.
 func (de *endpoint) noteRecvActivity(ipp netip.AddrPort) {
+	de.rxPackets.Add(1)
 	now := mono.Now()
 
 	// TODO(raggi): this probably applies relatively equally well to disco
@@ -292,6 +308,11 @@ func (de *endpoint) DstToBytes() []byte  { return packIPPort(de.fakeWGAddr) }
 //
 // TODO(val): Rewrite the addrFor*Locked() variations to share code.
 func (de *endpoint) addrForSendLocked(now mono.Time) (udpAddr, derpAddr netip.AddrPort, sendWGPing bool) {
+	if de.pin != (EndpointPin{}) {
+		udpAddr, derpAddr = de.pinnedAddrsForSendLocked()
+		return udpAddr, derpAddr, false
+	}
+
 	udpAddr = de.bestAddr.AddrPort
 
 	if udpAddr.IsValid() && !now.After(de.trustBestAddrUntil) {
@@ -433,7 +454,7 @@ func (de *endpoint) heartbeat() {
 		de.startDiscoPingLocked(udpAddr, now, pingHeartbeat, 0, nil)
 	}
 
-	if de.wantFullPingLocked(now) {
+	if de.wantFullPingLocked(now) && !de.pin.skipsDiscovery() {
 		de.sendDiscoPingsLocked(now, true)
 	}
 
@@ -536,6 +557,7 @@ func (de *endpoint) send(buffs [][]byte) error {
 		de.mu.Unlock()
 		return errExpired
 	}
+	de.txPackets.Add(uint64(len(buffs)))
 
 	now := mono.Now()
 	udpAddr, derpAddr, startWGPing := de.addrForSendLocked(now)
@@ -544,18 +566,40 @@ func (de *endpoint) send(buffs [][]byte) error {
 		if startWGPing {
 			de.sendWireGuardOnlyPingsLocked(now)
 		}
-	} else if !udpAddr.IsValid() || now.After(de.trustBestAddrUntil) {
+	} else if (!udpAddr.IsValid() || now.After(de.trustBestAddrUntil)) && !de.pin.skipsDiscovery() {
 		de.sendDiscoPingsLocked(now, true)
 	}
+	// Until the direct path is trusted, packets already go along both it
+	// and DERP.
+	var altAddr netip.AddrPort
+	var altBuffs [][]byte
+	if mode := multipathMode(de.c.multipath.Load()); mode != multipathOff && udpAddr.IsValid() && !derpAddr.IsValid() && de.pin == (EndpointPin{}) {
+		if altAddr = de.multipathAltAddrLocked(now); altAddr.IsValid() {
+			if mode == multipathStripe {
+				buffs, altBuffs = stripeBuffs(buffs)
+			} else {
+				altBuffs = buffs
+			}
+		}
+	}
 	de.noteActiveLocked()
 	de.mu.Unlock()
 
 	if !udpAddr.IsValid() && !derpAddr.IsValid() {
 		return errNoUDPOrDERP
 	}
+	for _, buff := range altBuffs {
+		// Only the primary path's errors are reported; the second is
+		// best effort.
+		de.c.sendAddr(altAddr, de.publicKey, buff)
+		metricSendMultipath.Add(1)
+		if stats := de.c.stats.Load(); stats != nil {
+			stats.UpdateTxPhysical(de.nodeAddr, altAddr, len(buff))
+		}
+	}
 	var err error
 	if udpAddr.IsValid() {
-		_, err = de.c.sendUDPBatch(udpAddr, buffs)
+		_, err = de.c.sendUDPBatch(udpAddr, buffs, de.outerTOS())
 
 		// If the error is known to indicate that the endpoint is no longer
 		// usable, clear the endpoint statistics so that the next send will
@@ -601,7 +645,12 @@ func (de *endpoint) discoPingTimeout(txid stun.TxID) {
 	if debugDisco() || !de.bestAddr.IsValid() || mono.Now().After(de.trustBestAddrUntil) {
 		de.c.dlogf("[v1] magicsock: disco: timeout waiting for pong %x from %v (%v, %v)", txid[:6], sp.to, de.publicKey.ShortString(), de.discoShort())
 	}
+	onBestPath := sp.to == de.bestAddr.AddrPort
+	de.recordPingLocked(sp, 0, true)
 	de.removeSentDiscoPingLocked(txid, sp)
+	if onBestPath && sp.to.Addr() != tailcfg.DerpMagicIPAddr {
+		de.c.noteUDPPathResult(false)
+	}
 }
 
 // forgetDiscoPing is called by a timer when a ping either fails to send or
@@ -858,6 +907,7 @@ func (de *endpoint) updateFromNode(n tailcfg.NodeView, heartbeatDisabled bool) {
 
 	de.heartbeatDisabled = heartbeatDisabled
 	de.expired = n.Expired()
+	de.peerDSCP.Store(uint32(n.DSCPForThisPeer() & 0x3f))
 
 	epDisco := de.disco.Load()
 	var discoKey key.DiscoPublic
@@ -899,17 +949,22 @@ func (de *endpoint) updateFromNode(n tailcfg.NodeView, heartbeatDisabled bool) {
 		de.derpAddr = newDerp
 	}
 
-	de.setEndpointsLocked(n.Endpoints())
+	de.setEndpointsLocked(n.Endpoints(), n.EndpointTypes())
 }
 
+// setEndpointsLocked sets de's netmap endpoints to eps. If types is non-empty,
+// it holds the types of the corresponding endpoints in eps.
+//
+// Endpoints whose type is ignored by the local EndpointPolicy are skipped.
 func (de *endpoint) setEndpointsLocked(eps interface {
 	LenIter() []struct{}
 	At(i int) netip.AddrPort
-}) {
+}, types views.Slice[tailcfg.EndpointType]) {
 	for _, st := range de.endpointState {
 		st.index = indexSentinelDeleted // assume deleted until updated in next loop
 	}
 
+	pol := de.endpointTypePolicy()
 	var newIpps []netip.AddrPort
 	for i := range eps.LenIter() {
 		if i > math.MaxInt16 {
@@ -921,10 +976,18 @@ func (de *endpoint) setEndpointsLocked(eps interface {
 			de.c.logf("magicsock: bogus netmap endpoint from %v", eps)
 			continue
 		}
+		typ := tailcfg.EndpointUnknownType
+		if i < types.Len() {
+			typ = types.At(i)
+		}
+		if pol.ignores(typ) {
+			continue
+		}
 		if st, ok := de.endpointState[ipp]; ok {
 			st.index = int16(i)
+			st.typ = typ
 		} else {
-			de.endpointState[ipp] = &endpointState{index: int16(i)}
+			de.endpointState[ipp] = &endpointState{index: int16(i), typ: typ}
 			newIpps = append(newIpps, ipp)
 		}
 	}
@@ -1091,8 +1154,10 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src netip
 
 	now := mono.Now()
 	latency := now.Sub(sp.at)
+	de.recordPingLocked(sp, latency, false)
 
 	if !isDerp {
+		de.c.noteUDPPathResult(true)
 		st, ok := de.endpointState[sp.to]
 		if !ok {
 			// This is no longer an endpoint we care about.
@@ -1129,7 +1194,8 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src netip
 	// TODO(bradfitz): decide how latency vs. preference order affects decision
 	if !isDerp {
 		thisPong := addrQuality{sp.to, latency, tstun.WireMTU(pingSizeToPktLen(sp.size, sp.to.Addr().Is6()))}
-		if betterAddr(thisPong, de.bestAddr) {
+		thisRank, bestRank := de.addrRankLocked(thisPong.AddrPort), de.addrRankLocked(de.bestAddr.AddrPort)
+		if thisRank > bestRank || (thisRank == bestRank && betterAddr(thisPong, de.bestAddr) && !de.lossierPathLocked(thisPong.AddrPort, de.bestAddr.AddrPort)) {
 			de.c.logf("magicsock: disco: node %v %v now using %v mtu=%v tx=%x", de.publicKey.ShortString(), de.discoShort(), sp.to, thisPong.wireMTU, m.TxID[:6])
 			de.debugUpdates.Add(EndpointChange{
 				When: time.Now(),
@@ -1311,6 +1377,10 @@ func (de *endpoint) populatePeerStatus(ps *ipnstate.PeerStatus) {
 	defer de.mu.Unlock()
 
 	ps.Relay = de.c.derpRegionCodeOfIDLocked(int(de.derpAddr.Port()))
+	ps.PathStats = de.pathStatusLocked()
+	ps.TxPackets = int64(de.txPackets.Load())
+	ps.RxPackets = int64(de.rxPackets.Load())
+	ps.EndpointPin = de.pin.String()
 
 	if de.lastSend.IsZero() {
 		return
@@ -1356,6 +1426,7 @@ func (de *endpoint) resetLocked() {
 	de.lastSend = 0
 	de.lastFullPing = 0
 	de.clearBestAddrLocked()
+	de.pathStats = [numPathKinds]pathStats{}
 	for _, es := range de.endpointState {
 		es.lastPing = 0
 	}
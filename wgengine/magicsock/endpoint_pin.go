@@ -0,0 +1,110 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"fmt"
+	"maps"
+	"net/netip"
+
+	"tailscale.com/types/key"
+)
+
+// EndpointPin fixes the path a peer's traffic takes, bypassing automatic
+// path discovery, for networks where traffic must take a known path. At
+// most one of its fields is set; the zero value doesn't pin anything.
+type EndpointPin struct {
+	// Addr, if valid, is the only address to send the peer's traffic to.
+	Addr netip.AddrPort
+
+	// DERPOnly is whether to send the peer's traffic only via DERP.
+	DERPOnly bool
+
+	// DirectOnly is whether to never send the peer's traffic via DERP,
+	// only over direct paths found by discovery. Until one is found,
+	// traffic to the peer is dropped.
+	DirectOnly bool
+}
+
+// ParseEndpointPin parses an EndpointPin from s, which is "derp-only",
+// "direct-only" or an "ip:port" address.
+func ParseEndpointPin(s string) (EndpointPin, error) {
+	switch s {
+	case "derp-only":
+		return EndpointPin{DERPOnly: true}, nil
+	case "direct-only":
+		return EndpointPin{DirectOnly: true}, nil
+	}
+	ap, err := netip.ParseAddrPort(s)
+	if err != nil || ap.Port() == 0 {
+		return EndpointPin{}, fmt.Errorf("invalid endpoint pin %q; want \"derp-only\", \"direct-only\" or an ip:port", s)
+	}
+	return EndpointPin{Addr: ap}, nil
+}
+
+// String returns p in the form ParseEndpointPin accepts, or the empty
+// string if p doesn't pin anything.
+func (p EndpointPin) String() string {
+	switch {
+	case p.DERPOnly:
+		return "derp-only"
+	case p.DirectOnly:
+		return "direct-only"
+	case p.Addr.IsValid():
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// skipsDiscovery reports whether p leaves no choice of path, so there's
+// no point discovering paths to the peer.
+func (p EndpointPin) skipsDiscovery() bool {
+	return p.DERPOnly || p.Addr.IsValid()
+}
+
+// SetEndpointPins sets the peers whose traffic is pinned to a fixed path,
+// replacing any previous pins. Peers not in pins use automatic path
+// discovery.
+func (c *Conn) SetEndpointPins(pins map[key.NodePublic]EndpointPin) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if maps.Equal(c.endpointPins, pins) {
+		return
+	}
+	c.endpointPins = maps.Clone(pins)
+	c.peerMap.forEachEndpoint(func(ep *endpoint) {
+		ep.setPin(pins[ep.publicKey])
+	})
+}
+
+func (de *endpoint) setPin(p EndpointPin) {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	if de.pin == p {
+		return
+	}
+	if p != (EndpointPin{}) {
+		de.c.logf("magicsock: %v traffic pinned to %v", de.publicKey.ShortString(), p)
+	} else if de.pin != (EndpointPin{}) {
+		de.c.logf("magicsock: %v traffic unpinned", de.publicKey.ShortString())
+	}
+	de.pin = p
+}
+
+// pinnedAddrsForSendLocked is like addrForSendLocked, for when de.pin is
+// set.
+//
+// de.mu must be held.
+func (de *endpoint) pinnedAddrsForSendLocked() (udpAddr, derpAddr netip.AddrPort) {
+	switch {
+	case de.pin.DERPOnly:
+		return netip.AddrPort{}, de.derpAddr
+	case de.pin.Addr.IsValid():
+		return de.pin.Addr, netip.AddrPort{}
+	default: // DirectOnly
+		// Use the best direct path even if it's no longer trusted; if
+		// it's gone, discovery will find another.
+		return de.bestAddr.AddrPort, netip.AddrPort{}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime/mono"
+)
+
+func TestParseEndpointPin(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    EndpointPin
+		wantErr bool
+	}{
+		{in: "derp-only", want: EndpointPin{DERPOnly: true}},
+		{in: "direct-only", want: EndpointPin{DirectOnly: true}},
+		{in: "192.168.1.5:41641", want: EndpointPin{Addr: netip.MustParseAddrPort("192.168.1.5:41641")}},
+		{in: "[2001:db8::1]:41641", want: EndpointPin{Addr: netip.MustParseAddrPort("[2001:db8::1]:41641")}},
+		{in: "", wantErr: true},
+		{in: "192.168.1.5", wantErr: true},
+		{in: "192.168.1.5:0", wantErr: true},
+		{in: "derp", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseEndpointPin(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseEndpointPin(%q) err = %v; want error: %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseEndpointPin(%q) = %+v; want %+v", tt.in, got, tt.want)
+		}
+		if err == nil && got.String() != tt.in {
+			t.Errorf("ParseEndpointPin(%q).String() = %q", tt.in, got.String())
+		}
+	}
+}
+
+func TestEndpointPinAddrForSend(t *testing.T) {
+	now := mono.Now()
+	best := netip.MustParseAddrPort("1.2.3.4:1")
+	pinned := netip.MustParseAddrPort("10.0.0.1:41641")
+	derp := netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, 1)
+
+	tests := []struct {
+		name     string
+		pin      EndpointPin
+		trusted  bool
+		wantUDP  netip.AddrPort
+		wantDERP netip.AddrPort
+	}{
+		{name: "unpinned-trusted", trusted: true, wantUDP: best},
+		{name: "unpinned-untrusted", wantUDP: best, wantDERP: derp},
+		{name: "derp-only", pin: EndpointPin{DERPOnly: true}, trusted: true, wantDERP: derp},
+		{name: "direct-only", pin: EndpointPin{DirectOnly: true}, wantUDP: best},
+		{name: "addr", pin: EndpointPin{Addr: pinned}, trusted: true, wantUDP: pinned},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			de := &endpoint{
+				bestAddr: addrQuality{AddrPort: best},
+				derpAddr: derp,
+				pin:      tt.pin,
+			}
+			if tt.trusted {
+				de.trustBestAddrUntil = now.Add(time.Minute)
+			}
+			udp, derpAddr, _ := de.addrForSendLocked(now)
+			if udp != tt.wantUDP || derpAddr != tt.wantDERP {
+				t.Errorf("addrForSendLocked = %v, %v; want %v, %v", udp, derpAddr, tt.wantUDP, tt.wantDERP)
+			}
+		})
+	}
+}
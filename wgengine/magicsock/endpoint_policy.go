@@ -0,0 +1,188 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+// EndpointTypePolicy is local policy about which classes of a peer's
+// advertised endpoints (as described by tailcfg.Node.EndpointTypes) to use.
+//
+// Endpoints whose type the peer didn't report have type
+// tailcfg.EndpointUnknownType and can be matched like any other type.
+type EndpointTypePolicy struct {
+	// Ignore lists endpoint types that are never used. Ignored
+	// endpoints are dropped as if the peer never advertised them,
+	// although the peer can still be reached on them if it contacts us
+	// from that address first.
+	Ignore []tailcfg.EndpointType
+
+	// Prefer lists endpoint types, most preferred first. When
+	// choosing a peer's best UDP address, an address of a preferred
+	// type always wins over one of a less preferred or unlisted type,
+	// regardless of latency. Addresses of equally ranked types are
+	// compared as usual.
+	Prefer []tailcfg.EndpointType
+}
+
+// ParseEndpointTypePolicy parses an EndpointTypePolicy from s, a
+// comma-separated list of tailcfg.EndpointType names such as
+// "local,stun,-portmap". Types prefixed with "-" are ignored; the others
+// are preferred, most preferred first. The empty string is the zero
+// policy.
+func ParseEndpointTypePolicy(s string) (EndpointTypePolicy, error) {
+	var p EndpointTypePolicy
+	if s == "" {
+		return p, nil
+	}
+	for _, f := range strings.Split(s, ",") {
+		name, ignore := strings.CutPrefix(f, "-")
+		t, err := tailcfg.ParseEndpointType(name)
+		if err != nil {
+			return EndpointTypePolicy{}, fmt.Errorf("invalid endpoint type policy %q: %w", s, err)
+		}
+		if slices.Contains(p.Ignore, t) || slices.Contains(p.Prefer, t) {
+			return EndpointTypePolicy{}, fmt.Errorf("invalid endpoint type policy %q: %v listed more than once", s, t)
+		}
+		if ignore {
+			p.Ignore = append(p.Ignore, t)
+		} else {
+			p.Prefer = append(p.Prefer, t)
+		}
+	}
+	return p, nil
+}
+
+func (p EndpointTypePolicy) equal(p2 EndpointTypePolicy) bool {
+	return slices.Equal(p.Ignore, p2.Ignore) && slices.Equal(p.Prefer, p2.Prefer)
+}
+
+// ignores reports whether p ignores endpoints of type t.
+func (p *EndpointTypePolicy) ignores(t tailcfg.EndpointType) bool {
+	return p != nil && slices.Contains(p.Ignore, t)
+}
+
+// rank returns the preference rank of endpoint type t. Higher is more
+// preferred; types not listed in p.Prefer have rank 0.
+func (p *EndpointTypePolicy) rank(t tailcfg.EndpointType) int {
+	if p == nil {
+		return 0
+	}
+	if i := slices.Index(p.Prefer, t); i >= 0 {
+		return len(p.Prefer) - i
+	}
+	return 0
+}
+
+// EndpointPolicy is the local endpoint type policy for all peers.
+type EndpointPolicy struct {
+	// Default is the policy for peers not in Peers.
+	Default EndpointTypePolicy
+
+	// Peers, if non-nil, overrides Default for specific peers.
+	Peers map[key.NodePublic]EndpointTypePolicy
+}
+
+func (p *EndpointPolicy) equal(p2 *EndpointPolicy) bool {
+	if p == nil || p2 == nil {
+		return p == p2
+	}
+	return p.Default.equal(p2.Default) && maps.EqualFunc(p.Peers, p2.Peers, EndpointTypePolicy.equal)
+}
+
+// forPeer returns the policy to use for the peer with public key k, or nil
+// if there's no policy.
+func (p *EndpointPolicy) forPeer(k key.NodePublic) *EndpointTypePolicy {
+	if p == nil {
+		return nil
+	}
+	if pp, ok := p.Peers[k]; ok {
+		return &pp
+	}
+	return &p.Default
+}
+
+// SetEndpointPolicy sets the local policy controlling which of each peer's
+// advertised endpoints are used, based on their types. A nil policy means
+// to use all endpoints with no type preference.
+//
+// The policy is applied to all current peers immediately.
+func (c *Conn) SetEndpointPolicy(p *EndpointPolicy) {
+	if p.equal(c.endpointPolicy.Load()) {
+		return
+	}
+	if p != nil {
+		p = &EndpointPolicy{
+			Default: EndpointTypePolicy{
+				Ignore: slices.Clone(p.Default.Ignore),
+				Prefer: slices.Clone(p.Default.Prefer),
+			},
+			Peers: cloneEndpointPolicyPeers(p.Peers),
+		}
+	}
+	c.endpointPolicy.Store(p)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	flags := c.debugFlagsLocked()
+	for i := range c.peers.LenIter() {
+		n := c.peers.At(i)
+		if ep, ok := c.peerMap.endpointForNodeID(n.ID()); ok && ep.publicKey == n.Key() {
+			ep.updateFromNode(n, flags.heartbeatDisabled)
+		}
+	}
+}
+
+// EndpointPolicy returns the policy set by SetEndpointPolicy, or nil if
+// there is none. It must not be modified.
+func (c *Conn) EndpointPolicy() *EndpointPolicy {
+	return c.endpointPolicy.Load()
+}
+
+func cloneEndpointPolicyPeers(m map[key.NodePublic]EndpointTypePolicy) map[key.NodePublic]EndpointTypePolicy {
+	if m == nil {
+		return nil
+	}
+	ret := make(map[key.NodePublic]EndpointTypePolicy, len(m))
+	for k, v := range m {
+		ret[k] = EndpointTypePolicy{
+			Ignore: slices.Clone(v.Ignore),
+			Prefer: slices.Clone(v.Prefer),
+		}
+	}
+	return ret
+}
+
+// endpointTypePolicy returns the endpoint type policy for de, or nil if
+// there is none.
+func (de *endpoint) endpointTypePolicy() *EndpointTypePolicy {
+	return de.c.endpointPolicy.Load().forPeer(de.publicKey)
+}
+
+// addrRankLocked returns the policy preference rank of ap, one of de's
+// endpoints. Higher is more preferred.
+//
+// de.mu must be held.
+func (de *endpoint) addrRankLocked(ap netip.AddrPort) int {
+	pol := de.endpointTypePolicy()
+	if pol == nil || len(pol.Prefer) == 0 {
+		return 0
+	}
+	st, ok := de.endpointState[ap]
+	if !ok {
+		return 0
+	}
+	return pol.rank(st.typ)
+}
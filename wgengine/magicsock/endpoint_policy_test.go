@@ -0,0 +1,73 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"reflect"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/util/must"
+)
+
+func TestParseEndpointTypePolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    EndpointTypePolicy
+		wantErr bool
+	}{
+		{in: "", want: EndpointTypePolicy{}},
+		{in: "local", want: EndpointTypePolicy{Prefer: []tailcfg.EndpointType{tailcfg.EndpointLocal}}},
+		{in: "stun,local,-portmap", want: EndpointTypePolicy{
+			Ignore: []tailcfg.EndpointType{tailcfg.EndpointPortmapped},
+			Prefer: []tailcfg.EndpointType{tailcfg.EndpointSTUN, tailcfg.EndpointLocal},
+		}},
+		{in: "-?", want: EndpointTypePolicy{Ignore: []tailcfg.EndpointType{tailcfg.EndpointUnknownType}}},
+		{in: "lan", wantErr: true},
+		{in: "local,", wantErr: true},
+		{in: "local,-local", wantErr: true},
+		{in: "-stun,-stun", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseEndpointTypePolicy(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseEndpointTypePolicy(%q) err = %v; want error: %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseEndpointTypePolicy(%q) = %+v; want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEndpointPolicyEqual(t *testing.T) {
+	k := key.NewNode().Public()
+	pol := func(def string, peers map[key.NodePublic]string) *EndpointPolicy {
+		p := &EndpointPolicy{Default: must.Get(ParseEndpointTypePolicy(def))}
+		for k, s := range peers {
+			if p.Peers == nil {
+				p.Peers = map[key.NodePublic]EndpointTypePolicy{}
+			}
+			p.Peers[k] = must.Get(ParseEndpointTypePolicy(s))
+		}
+		return p
+	}
+	tests := []struct {
+		a, b *EndpointPolicy
+		want bool
+	}{
+		{nil, nil, true},
+		{nil, pol("", nil), false},
+		{pol("local,-stun", nil), pol("local,-stun", nil), true},
+		{pol("local,stun", nil), pol("stun,local", nil), false},
+		{pol("", map[key.NodePublic]string{k: "-portmap"}), pol("", map[key.NodePublic]string{k: "-portmap"}), true},
+		{pol("", map[key.NodePublic]string{k: "-portmap"}), pol("", map[key.NodePublic]string{k: "portmap"}), false},
+	}
+	for i, tt := range tests {
+		if got := tt.a.equal(tt.b); got != tt.want {
+			t.Errorf("%d: equal = %v; want %v", i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tstime/mono"
+	"tailscale.com/util/clientmetric"
+)
+
+// failoverBudget is how long moving traffic to a new interface after a
+// link change should take: rebinding the sockets and sending disco pings
+// to the peers with active sessions over the new interface. Going over
+// it is logged.
+const failoverBudget = time.Second
+
+// reprobeActivePeers starts discovery to all the peers with active
+// sessions right away, over the current sockets, rather than waiting for
+// their next send or heartbeat to find that their paths are gone. It's
+// called after the sockets are rebound and the endpoint states reset. It
+// returns how many peers it probed.
+func (c *Conn) reprobeActivePeers() int {
+	now := mono.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int
+	c.peerMap.forEachEndpoint(func(ep *endpoint) {
+		if ep.reprobeIfActive(now) {
+			n++
+		}
+	})
+	return n
+}
+
+// reprobeIfActive sends disco pings to all of de's candidate paths, and a
+// CallMeMaybe via DERP, if de's session is active. It reports whether it
+// did.
+func (de *endpoint) reprobeIfActive(now mono.Time) bool {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	if de.lastSend.IsZero() || now.Sub(de.lastSend) > sessionActiveTimeout {
+		return false
+	}
+	if de.isWireguardOnly || de.pin.skipsDiscovery() {
+		return false
+	}
+	de.sendDiscoPingsLocked(now, true)
+	return true
+}
+
+// SetFailoverCallback sets the func to be called when a rebind moves
+// traffic to a new interface, that is, when the interface with the
+// default route changed.
+//
+// At most one func can be registered; the most recent one replaces any
+// previous registration.
+//
+// This is called by LocalBackend.
+func (c *Conn) SetFailoverCallback(fn func(ipnstate.FailoverEvent)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failoverFunc = fn
+}
+
+// noteRebound is called at the end of a Rebind that started at start,
+// after which defIf has the default route and probed peers were
+// re-probed. If the default route moved to another interface, it logs
+// and reports the failover.
+//
+// c.mu must NOT be held.
+func (c *Conn) noteRebound(start time.Time, defIf string, probed int) {
+	d := time.Since(start)
+	c.mu.Lock()
+	oldIf := c.defaultRouteIf
+	c.defaultRouteIf = defIf
+	fn := c.failoverFunc
+	c.mu.Unlock()
+	if oldIf == defIf {
+		return
+	}
+
+	metricFailovers.Add(1)
+	c.logf("magicsock: failover %q => %q in %v; re-probed %d active peers", oldIf, defIf, d.Round(time.Millisecond), probed)
+	if d > failoverBudget {
+		metricFailoverOverBudget.Add(1)
+		c.logf("magicsock: failover took %v, over its %v budget", d.Round(time.Millisecond), failoverBudget)
+	}
+	if fn != nil {
+		go fn(ipnstate.FailoverEvent{
+			When:         start,
+			OldInterface: oldIf,
+			NewInterface: defIf,
+			PeersProbed:  probed,
+			Duration:     d,
+		})
+	}
+}
+
+var (
+	metricFailovers          = clientmetric.NewCounter("magicsock_failovers")
+	metricFailoverOverBudget = clientmetric.NewCounter("magicsock_failover_over_budget")
+)
@@ -0,0 +1,67 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tstime/mono"
+)
+
+func TestReprobeIfActive(t *testing.T) {
+	now := mono.Now()
+	tests := []struct {
+		name     string
+		lastSend mono.Time
+		pin      EndpointPin
+		want     bool
+	}{
+		{name: "never-sent"},
+		{name: "idle", lastSend: now.Add(-sessionActiveTimeout - time.Second)},
+		{name: "active", lastSend: now.Add(-time.Second), want: true},
+		{name: "active-derp-only", lastSend: now.Add(-time.Second), pin: EndpointPin{DERPOnly: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			de := &endpoint{lastSend: tt.lastSend, pin: tt.pin}
+			if got := de.reprobeIfActive(now); got != tt.want {
+				t.Errorf("reprobeIfActive = %v; want %v", got, tt.want)
+			}
+			if got := de.lastFullPing == now; got != tt.want {
+				t.Errorf("full ping started = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoteReboundFailover(t *testing.T) {
+	c := newConn()
+	c.logf = t.Logf
+	c.defaultRouteIf = "eth0"
+	events := make(chan ipnstate.FailoverEvent, 1)
+	c.SetFailoverCallback(func(ev ipnstate.FailoverEvent) { events <- ev })
+
+	c.noteRebound(time.Now(), "eth0", 2)
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected failover event for unchanged interface: %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	start := time.Now()
+	c.noteRebound(start, "wlan0", 3)
+	select {
+	case ev := <-events:
+		if ev.OldInterface != "eth0" || ev.NewInterface != "wlan0" || ev.PeersProbed != 3 || !ev.When.Equal(start) {
+			t.Errorf("got event %+v; want eth0 => wlan0 with 3 peers probed at %v", ev, start)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no failover event")
+	}
+	if c.defaultRouteIf != "wlan0" {
+		t.Errorf("defaultRouteIf = %q; want wlan0", c.defaultRouteIf)
+	}
+}
@@ -45,7 +45,6 @@
 	"tailscale.com/net/tstun"
 	"tailscale.com/syncs"
 	"tailscale.com/tailcfg"
-	"tailscale.com/tstime"
 	"tailscale.com/tstime/mono"
 	"tailscale.com/types/key"
 	"tailscale.com/types/lazy"
@@ -169,12 +168,37 @@ type Conn struct {
 	// peerMTUEnabled is whether path MTU discovery to peers is enabled.
 	peerMTUEnabled atomic.Bool
 
+	// multipath is the multipathMode of sends to peers.
+	multipath atomic.Int32
+
+	// udpOffload is whether the UDP sockets were last bound allowing UDP
+	// generic segmentation and receive offload. See udpOffloadAllowed.
+	udpOffload atomic.Bool
+
 	// stats maintains per-connection counters.
 	stats atomic.Pointer[connstats.Statistics]
 
 	// captureHook, if non-nil, is the pcap logging callback when capturing.
 	captureHook syncs.AtomicValue[capture.Callback]
 
+	// endpointPolicy, if non-nil, is the local policy for which peer
+	// endpoint types to use. See SetEndpointPolicy.
+	endpointPolicy atomic.Pointer[EndpointPolicy]
+
+	// portPolicy is the local policy for which UDP port to listen on and
+	// when to move to a new one. See SetUDPPortPolicy.
+	portPolicy syncs.AtomicValue[UDPPortPolicy]
+
+	// udpPathFailures is how many UDP disco pings in a row have gone
+	// unanswered, and lastPathFailureRotate the mono.Time of the last
+	// port rotation they caused. See noteUDPPathResult.
+	udpPathFailures       atomic.Int32
+	lastPathFailureRotate atomic.Int64
+
+	// outerQoS is the marking of the UDP packets sent to peers. See
+	// SetOuterQoS.
+	outerQoS syncs.AtomicValue[OuterQoS]
+
 	// discoPrivate is the private naclbox key used for active
 	// discovery traffic. It is always present, and immutable.
 	discoPrivate key.DiscoPrivate
@@ -207,6 +231,14 @@ type Conn struct {
 	// that will call Conn.doPeriodicSTUN.
 	periodicReSTUNTimer *time.Timer
 
+	// endpointPins are the peers whose traffic is pinned to a fixed
+	// path. See SetEndpointPins.
+	endpointPins map[key.NodePublic]EndpointPin
+
+	// portRotateTimer, when non-nil, is an AfterFunc timer for the
+	// port policy's scheduled port rotation. See SetUDPPortPolicy.
+	portRotateTimer *time.Timer
+
 	// endpointsUpdateActive indicates that updateEndpoints is
 	// currently running. It's used to deduplicate concurrent endpoint
 	// update requests.
@@ -261,7 +293,26 @@ type Conn struct {
 	// magicsock could do with any complexity reduction it can get.
 	netInfoLast *tailcfg.NetInfo
 
+	// netcheckChangeFunc, if non-nil, is called with how the key facts
+	// about the network changed between netcheck reports.
+	netcheckChangeFunc func(changes []string)
+	// netcheckChanges are the changes not yet passed to
+	// netcheckChangeFunc, oldest first, and netcheckChangesRunning is
+	// whether a goroutine is passing them on.
+	netcheckChanges        [][]string
+	netcheckChangesRunning bool
+
+	// failoverFunc, if non-nil, is called when traffic moves to a new
+	// network interface. See SetFailoverCallback.
+	failoverFunc func(ipnstate.FailoverEvent)
+
+	// defaultRouteIf is the name of the interface that had the default
+	// route at the last Rebind, or at startup.
+	defaultRouteIf string
+
 	derpMap          *tailcfg.DERPMap              // nil (or zero regions/nodes) means DERP is disabled
+	derpMapBase      *tailcfg.DERPMap              // from last SetDERPMap, before adding federated regions
+	derpFed          federatedDERP                 // regions learned from derpMapBase.Federation
 	peers            views.Slice[tailcfg.NodeView] // from last SetNetworkMap update
 	lastFlags        debugFlags                    // at time of last SetNetworkMap
 	firstAddrForTest netip.Addr                    // from last SetNetworkMap update; for tests only
@@ -272,6 +323,19 @@ type Conn struct {
 	activeDerp       map[int]activeDerp            // DERP regionID -> connection to a node in that region
 	prevDerp         map[int]*syncs.WaitGroupChan
 
+	// derpStandby is the DERP region, other than home, kept connected
+	// to fail over to quickly if home fails; 0 means none.
+	derpStandby int
+	// derpDownUntil are the DERP regions that failed as home, and
+	// until when they're avoided as home.
+	derpDownUntil map[int]time.Time
+	// derpFailingOver is whether a race to replace a failed home
+	// DERP region is running.
+	derpFailingOver bool
+	// lastDERPRace is the most recent race to replace a failed home
+	// DERP region, or nil.
+	lastDERPRace *DERPRace
+
 	// derpRoute contains optional alternate routes to use as an
 	// optimization instead of contacting a peer via their home
 	// DERP connection.  If they sent us a message on a different
@@ -414,6 +478,7 @@ func NewConn(opts Options) (*Conn, error) {
 	c.port.Store(uint32(opts.Port))
 	c.controlKnobs = opts.ControlKnobs
 	c.logf = opts.logf()
+	c.initMultipath()
 	c.epFunc = opts.endpointsFunc()
 	c.derpActiveFunc = opts.derpActiveFunc()
 	c.idleFunc = opts.IdleFunc
@@ -424,6 +489,9 @@ func NewConn(opts Options) (*Conn, error) {
 		c.portMapper.SetGatewayLookupFunc(opts.NetMon.GatewayAndSelfIP)
 	}
 	c.netMon = opts.NetMon
+	if c.netMon != nil {
+		c.defaultRouteIf = c.netMon.InterfaceState().DefaultRouteInterface
+	}
 
 	if err := c.rebind(keepCurrentPort); err != nil {
 		return nil, err
@@ -495,12 +563,7 @@ func (c *Conn) updateEndpoints(why string) {
 				go c.updateEndpoints(why)
 				return
 			}
-			if c.shouldDoPeriodicReSTUNLocked() {
-				// Pick a random duration between 20
-				// and 26 seconds (just under 30s, a
-				// common UDP NAT timeout on Linux,
-				// etc)
-				d := tstime.RandomDurationBetween(20*time.Second, 26*time.Second)
+			if d, ok := c.nextPeriodicReSTUNLocked(); ok {
 				if t := c.periodicReSTUNTimer; t != nil {
 					if debugReSTUNStopOnIdle() {
 						c.logf("resetting existing periodicSTUN to run in %v", d)
@@ -621,7 +684,7 @@ func (c *Conn) updateNetInfo(ctx context.Context) (*netcheck.Report, error) {
 		return nil, err
 	}
 
-	c.lastNetCheckReport.Store(report)
+	c.noteNetcheckReport(c.lastNetCheckReport.Swap(report), report)
 	c.noV4.Store(!report.IPv4)
 	c.noV6.Store(!report.IPv6)
 	c.noV4Send.Store(!report.IPv4CanSend)
@@ -652,9 +715,11 @@ func (c *Conn) updateNetInfo(ctx context.Context) (*netcheck.Report, error) {
 		// one.
 		ni.PreferredDERP = c.pickDERPFallback()
 	}
+	ni.PreferredDERP = c.avoidDownDERP(ni.PreferredDERP)
 	if !c.setNearestDERP(ni.PreferredDERP) {
 		ni.PreferredDERP = 0
 	}
+	c.updateDERPStandby(report)
 	ni.FirewallMode = hostinfo.FirewallMode()
 
 	c.callNetInfoCallback(ni)
@@ -850,6 +915,11 @@ func (c *Conn) determineEndpoints(ctx context.Context) ([]tailcfg.Endpoint, erro
 		addAddr(portmapExt, tailcfg.EndpointPortmapped)
 		c.setNetInfoHavePortMap()
 	}
+	// And any mappings on other home routers, for machines with more
+	// than one uplink.
+	for _, ext := range c.portMapper.OtherGatewayMappings() {
+		addAddr(ext, tailcfg.EndpointPortmapped)
+	}
 
 	if nr.GlobalV4 != "" {
 		addAddr(ipp(nr.GlobalV4), tailcfg.EndpointSTUN)
@@ -885,7 +955,9 @@ func (c *Conn) determineEndpoints(ctx context.Context) ([]tailcfg.Endpoint, erro
 	// re-run.
 	eps = c.endpointTracker.update(time.Now(), eps)
 
-	if localAddr := c.pconn4.LocalAddr(); localAddr.IP.IsUnspecified() {
+	// The IPv4 socket is unbound (port 0) on hosts without IPv4 at all,
+	// in which case the IPv6 socket's local addresses are still usable.
+	if localAddr := c.pconn4.LocalAddr(); localAddr.IP.IsUnspecified() || localAddr.Port == 0 {
 		ips, loopback, err := interfaces.LocalAddresses()
 		if err != nil {
 			return nil, err
@@ -898,8 +970,8 @@ func (c *Conn) determineEndpoints(ctx context.Context) ([]tailcfg.Endpoint, erro
 			// offline, for example.
 			ips = loopback
 		}
-		for _, ip := range ips {
-			addAddr(netip.AddrPortFrom(ip, uint16(localAddr.Port)), tailcfg.EndpointLocal)
+		for _, ep := range localEndpoints(ips, uint16(localAddr.Port), uint16(c.pconn6.LocalAddr().Port)) {
+			addAddr(ep, tailcfg.EndpointLocal)
 		}
 	} else {
 		// Our local endpoint is bound to a particular address.
@@ -923,6 +995,25 @@ func (c *Conn) determineEndpoints(ctx context.Context) ([]tailcfg.Endpoint, erro
 	return eps, nil
 }
 
+// localEndpoints returns the endpoints on the local interface addresses
+// ips of magicsock's IPv4 and IPv6 sockets, which are bound to the
+// unspecified address on port4 and port6 respectively. A port of 0 means
+// that the socket of that address family isn't bound, so that no
+// endpoints of that family are returned.
+func localEndpoints(ips []netip.Addr, port4, port6 uint16) []netip.AddrPort {
+	var eps []netip.AddrPort
+	for _, ip := range ips {
+		port := port4
+		if ip.Is6() {
+			port = port6
+		}
+		if port != 0 {
+			eps = append(eps, netip.AddrPortFrom(ip, port))
+		}
+	}
+	return eps
+}
+
 // endpointSetsEqual reports whether x and y represent the same set of
 // endpoints. The order doesn't matter.
 //
@@ -995,7 +1086,9 @@ func (c *Conn) Send(buffs [][]byte, ep conn.Endpoint) error {
 	_ ipv6.Message = ipv4.Message{}
 )
 
-func (c *Conn) sendUDPBatch(addr netip.AddrPort, buffs [][]byte) (sent bool, err error) {
+// sendUDPBatch sends buffs to addr, marked with the IPv4 type of service
+// or IPv6 traffic class tos if non-zero and supported.
+func (c *Conn) sendUDPBatch(addr netip.AddrPort, buffs [][]byte, tos uint8) (sent bool, err error) {
 	isIPv6 := false
 	switch {
 	case addr.Addr().Is4():
@@ -1005,9 +1098,9 @@ func (c *Conn) sendUDPBatch(addr netip.AddrPort, buffs [][]byte) (sent bool, err
 		panic("bogus sendUDPBatch addr type")
 	}
 	if isIPv6 {
-		err = c.pconn6.WriteBatchTo(buffs, addr)
+		err = c.pconn6.WriteBatchTo(buffs, addr, tos)
 	} else {
-		err = c.pconn4.WriteBatchTo(buffs, addr)
+		err = c.pconn4.WriteBatchTo(buffs, addr, tos)
 	}
 	if err != nil {
 		var errGSO neterror.ErrUDPGSODisabled
@@ -1071,8 +1164,8 @@ func (c *Conn) sendAddr(addr netip.AddrPort, pubKey key.NodePublic, b []byte) (s
 		return c.sendUDP(addr, b)
 	}
 
-	ch := c.derpWriteChanOfAddr(addr, pubKey)
-	if ch == nil {
+	q := c.derpWriteQueueOfAddr(addr, pubKey)
+	if q == nil {
 		metricSendDERPErrorChan.Add(1)
 		return false, nil
 	}
@@ -1089,14 +1182,15 @@ func (c *Conn) sendAddr(addr netip.AddrPort, pubKey key.NodePublic, b []byte) (s
 	case <-c.donec:
 		metricSendDERPErrorClosed.Add(1)
 		return false, errConnClosed
-	case ch <- derpWriteRequest{addr, pubKey, pkt}:
-		metricSendDERPQueued.Add(1)
-		return true, nil
 	default:
+	}
+	if !q.enqueue(derpWriteRequest{addr, pubKey, pkt}) {
 		metricSendDERPErrorQueue.Add(1)
 		// Too many writes queued. Drop packet.
 		return false, errDropDerpPacket
 	}
+	metricSendDERPQueued.Add(1)
+	return true, nil
 }
 
 type receiveBatch struct {
@@ -1917,6 +2011,7 @@ func (c *Conn) SetNetworkMap(nm *netmap.NetworkMap) {
 			endpointState:     map[netip.AddrPort]*endpointState{},
 			heartbeatDisabled: flags.heartbeatDisabled,
 			isWireguardOnly:   n.IsWireGuardOnly(),
+			pin:               c.endpointPins[n.Key()],
 		}
 		if n.Addresses().Len() > 0 {
 			ep.nodeAddr = n.Addresses().At(0).Addr()
@@ -2121,6 +2216,12 @@ func (c *Conn) Close() error {
 		c.derpCleanupTimer.Stop()
 	}
 	c.stopPeriodicReSTUNTimerLocked()
+	if c.portRotateTimer != nil {
+		c.portRotateTimer.Stop()
+	}
+	if c.derpFed.timer != nil {
+		c.derpFed.timer.Stop()
+	}
 	c.portMapper.Close()
 
 	c.peerMap.forEachEndpoint(func(ep *endpoint) {
@@ -2154,12 +2255,12 @@ func (c *Conn) goroutinesRunningLocked() bool {
 	if c.endpointsUpdateActive {
 		return true
 	}
-	// The goroutine running dc.Connect in derpWriteChanOfAddr may linger
+	// The goroutine running dc.Connect in derpWriteQueueOfAddr may linger
 	// and appear to leak, as observed in https://github.com/tailscale/tailscale/issues/554.
 	// This is despite the underlying context being cancelled by connCtxCancel above.
 	// To avoid this condition, we must wait on derpStarted here
 	// to ensure that this goroutine has exited by the time Close returns.
-	// We only do this if derpWriteChanOfAddr has executed at least once:
+	// We only do this if derpWriteQueueOfAddr has executed at least once:
 	// on the first run, it sets firstDerp := true and spawns the aforementioned goroutine.
 	// To detect this, we check activeDerp, which is initialized to non-nil on the first run.
 	if c.activeDerp != nil {
@@ -2199,6 +2300,12 @@ func (c *Conn) shouldDoPeriodicReSTUNLocked() bool {
 
 func (c *Conn) onPortMapChanged() { c.ReSTUN("portmap-changed") }
 
+// PortMapLeases returns the port mappings c currently holds on its home
+// routers.
+func (c *Conn) PortMapLeases() []portmapper.Lease {
+	return c.portMapper.Leases()
+}
+
 // ReSTUN triggers an address discovery.
 // The provided why string is for debug logging only.
 func (c *Conn) ReSTUN(why string) {
@@ -2269,29 +2376,57 @@ func (c *Conn) bindSocket(ruc *RebindingUDPConn, network string, curPortFate cur
 	defer ruc.mu.Unlock()
 
 	if runtime.GOOS == "js" {
-		ruc.setConnLocked(newBlockForeverConn(), "", c.bind.BatchSize())
+		ruc.setConnLocked(newBlockForeverConn(), "", c.bind.BatchSize(), false)
 		return nil
 	}
 
 	if debugAlwaysDERP() {
 		c.logf("disabled %v per TS_DEBUG_ALWAYS_USE_DERP", network)
-		ruc.setConnLocked(newBlockForeverConn(), "", c.bind.BatchSize())
+		ruc.setConnLocked(newBlockForeverConn(), "", c.bind.BatchSize(), false)
 		return nil
 	}
 
 	// Build a list of preferred ports.
-	// Best is the port that the user requested.
-	// Second best is the port that is currently in use.
-	// If those fail, fall back to 0.
+	// Best is the port that the user requested, unless rotating away
+	// from the current port, which moves away from it for good.
+	// Second best is the port that is currently in use, unless the port
+	// policy rotates ports, in which case it's best, so that rebinding
+	// doesn't undo a rotation.
+	// Then random ports from the port policy's range, if any, and when
+	// rotating, the current port again, rather than none at all.
+	// Without a range, fall back to 0. With one, that would leave the
+	// range, so fail instead.
+	pol := c.portPolicy.Load()
+	var curPort uint16
+	if ruc.pconn != nil {
+		curPort = uint16(ruc.localAddrLocked().Port)
+	}
 	var ports []uint16
-	if port := uint16(c.port.Load()); port != 0 {
+	addPort := func(port uint16) {
+		if port == 0 || !pol.allows(port) {
+			return
+		}
+		if curPortFate == rotateCurrentPort && port == curPort {
+			return
+		}
 		ports = append(ports, port)
 	}
-	if ruc.pconn != nil && curPortFate == keepCurrentPort {
-		curPort := uint16(ruc.localAddrLocked().Port)
+	if curPortFate == keepCurrentPort && pol.rotates() {
+		addPort(curPort)
+	}
+	if curPortFate != rotateCurrentPort {
+		addPort(uint16(c.port.Load()))
+	}
+	if curPortFate == keepCurrentPort {
+		addPort(curPort)
+	}
+	ports = append(ports, pol.randomPorts(maxRangeBindAttempts, curPort)...)
+	if curPortFate == rotateCurrentPort && curPort != 0 && pol.allows(curPort) {
 		ports = append(ports, curPort)
 	}
-	ports = append(ports, 0)
+	if !pol.hasRange() {
+		ports = append(ports, 0)
+	}
 	// Remove duplicates. (All duplicates are consecutive.)
 	uniq.ModifySlice(&ports)
 
@@ -2313,23 +2448,26 @@ func (c *Conn) bindSocket(ruc *RebindingUDPConn, network string, curPortFate cur
 			continue
 		}
 		trySetSocketBuffer(pconn, c.logf)
+		if network == "udp6" && c.outerQoS.Load().FlowLabels {
+			trySetFlowLabels(pconn, c.logf)
+		}
 
 		// Success.
 		if debugBindSocket() {
 			c.logf("magicsock: bindSocket: successfully listened %v port %d", network, port)
 		}
-		ruc.setConnLocked(pconn, network, c.bind.BatchSize())
+		ruc.setConnLocked(pconn, network, c.bind.BatchSize(), c.udpOffload.Load())
 		if network == "udp4" {
 			health.SetUDP4Unbound(false)
 		}
 		return nil
 	}
 
-	// Failed to bind, including on port 0 (!).
+	// Failed to bind, including on port 0 (!) if there's no range.
 	// Set pconn to a dummy conn whose reads block until closed.
 	// This keeps the receive funcs alive for a future in which
 	// we get a link change and we can try binding again.
-	ruc.setConnLocked(newBlockForeverConn(), "", c.bind.BatchSize())
+	ruc.setConnLocked(newBlockForeverConn(), "", c.bind.BatchSize(), false)
 	if network == "udp4" {
 		health.SetUDP4Unbound(true)
 	}
@@ -2339,18 +2477,25 @@ func (c *Conn) bindSocket(ruc *RebindingUDPConn, network string, curPortFate cur
 type currentPortFate uint8
 
 const (
-	keepCurrentPort = currentPortFate(0)
-	dropCurrentPort = currentPortFate(1)
+	keepCurrentPort   = currentPortFate(0)
+	dropCurrentPort   = currentPortFate(1)
+	rotateCurrentPort = currentPortFate(2) // like dropCurrentPort, but also avoid reusing it
 )
 
 // rebind closes and re-binds the UDP sockets.
-// We consider it successful if we manage to bind the IPv4 socket.
+// We consider it successful if we manage to bind the IPv4 socket, or,
+// failing that, the IPv6 socket, as on hosts without IPv4 at all.
 func (c *Conn) rebind(curPortFate currentPortFate) error {
-	if err := c.bindSocket(&c.pconn6, "udp6", curPortFate); err != nil {
-		c.logf("magicsock: Rebind ignoring IPv6 bind failure: %v", err)
+	c.udpOffload.Store(c.udpOffloadAllowed())
+	err6 := c.bindSocket(&c.pconn6, "udp6", curPortFate)
+	if err6 != nil {
+		c.logf("magicsock: Rebind ignoring IPv6 bind failure: %v", err6)
 	}
 	if err := c.bindSocket(&c.pconn4, "udp4", curPortFate); err != nil {
-		return fmt.Errorf("magicsock: Rebind IPv4 failed: %w", err)
+		if err6 != nil {
+			return fmt.Errorf("magicsock: Rebind IPv4 failed: %w", err)
+		}
+		c.logf("magicsock: Rebind ignoring IPv4 bind failure, using IPv6 only: %v", err)
 	}
 	c.portMapper.SetLocalPort(c.LocalPort())
 	c.UpdatePMTUD()
@@ -2361,21 +2506,34 @@ func (c *Conn) rebind(curPortFate currentPortFate) error {
 // It should be followed by a call to ReSTUN.
 func (c *Conn) Rebind() {
 	metricRebindCalls.Add(1)
+	start := time.Now()
 	if err := c.rebind(keepCurrentPort); err != nil {
 		c.logf("%v", err)
 		return
 	}
 
 	var ifIPs []netip.Prefix
+	var defIf string
 	if c.netMon != nil {
 		st := c.netMon.InterfaceState()
-		defIf := st.DefaultRouteInterface
+		defIf = st.DefaultRouteInterface
 		ifIPs = st.InterfaceIPs[defIf]
 		c.logf("Rebind; defIf=%q, ips=%v", defIf, ifIPs)
 	}
 
 	c.maybeCloseDERPsOnRebind(ifIPs)
 	c.resetEndpointStates()
+	probed := c.reprobeActivePeers()
+	c.noteRebound(start, defIf, probed)
+}
+
+// NoteClockJump is called when the wall clock jumps forward relative to the
+// monotonic clock, such as after a suspend and resume. The trust in peer
+// paths was last refreshed before the jump, so it resets the preferred
+// address for all peers and triggers an address discovery.
+func (c *Conn) NoteClockJump() {
+	c.resetEndpointStates()
+	c.ReSTUN("clock-jump")
 }
 
 // resetEndpointStates resets the preferred address for all peers.
@@ -2519,8 +2677,9 @@ func (c *batchingUDPConn) Close() error {
 }
 
 // tryUpgradeToBatchingUDPConn probes the capabilities of the OS and pconn, and
-// upgrades pconn to a *batchingUDPConn if appropriate.
-func tryUpgradeToBatchingUDPConn(pconn nettype.PacketConn, network string, batchSize int) nettype.PacketConn {
+// upgrades pconn to a *batchingUDPConn if appropriate. If offload is false,
+// UDP generic segmentation and receive offload aren't used even if supported.
+func tryUpgradeToBatchingUDPConn(pconn nettype.PacketConn, network string, batchSize int, offload bool) nettype.PacketConn {
 	if network != "udp4" && network != "udp6" {
 		return pconn
 	}
@@ -2570,9 +2729,11 @@ func tryUpgradeToBatchingUDPConn(pconn nettype.PacketConn, network string, batch
 	default:
 		panic("bogus network")
 	}
-	var txOffload bool
-	txOffload, b.rxOffload = tryEnableUDPOffload(uc)
-	b.txOffload.Store(txOffload)
+	if offload {
+		var txOffload bool
+		txOffload, b.rxOffload = tryEnableUDPOffload(uc)
+		b.txOffload.Store(txOffload)
+	}
 	return b
 }
 
@@ -2609,7 +2770,7 @@ func (c *Conn) UpdateNetmapDelta(muts []netmap.NodeMutation) (handled bool) {
 			ep.setDERPHome(uint16(m.DERPRegion))
 		case netmap.NodeMutationEndpoints:
 			ep.mu.Lock()
-			ep.setEndpointsLocked(views.SliceOf(m.Endpoints))
+			ep.setEndpointsLocked(views.SliceOf(m.Endpoints), views.SliceOf(m.EndpointTypes))
 			ep.mu.Unlock()
 		}
 	}
@@ -2805,6 +2966,7 @@ type discoInfo struct {
 	metricNumDERPConns = clientmetric.NewGauge("magicsock_num_derp_conns")
 
 	metricRebindCalls     = clientmetric.NewCounter("magicsock_rebind_calls")
+	metricPortRotations   = clientmetric.NewCounter("magicsock_port_rotations")
 	metricReSTUNCalls     = clientmetric.NewCounter("magicsock_restun_calls")
 	metricUpdateEndpoints = clientmetric.NewCounter("magicsock_update_endpoints")
 
@@ -2817,6 +2979,13 @@ type discoInfo struct {
 	metricSendUDPError        = clientmetric.NewCounter("magicsock_send_udp_error")
 	metricSendDERP            = clientmetric.NewCounter("magicsock_send_derp")
 	metricSendDERPError       = clientmetric.NewCounter("magicsock_send_derp_error")
+	metricSendMultipath       = clientmetric.NewCounter("magicsock_send_multipath")
+
+	// Per-class DERP write queue depths, summed over DERP connections,
+	// and disco messages that had to wait for a data packet being sent.
+	metricSendDERPQueueDepth      = clientmetric.NewGauge("magicsock_send_derp_queue_depth")
+	metricSendDERPDiscoQueueDepth = clientmetric.NewGauge("magicsock_send_derp_disco_queue_depth")
+	metricSendDERPDiscoInversion  = clientmetric.NewCounter("magicsock_send_derp_disco_priority_inversion")
 
 	// Data packets (non-disco)
 	metricSendData            = clientmetric.NewCounter("magicsock_send_data")
@@ -2852,6 +3021,10 @@ type discoInfo struct {
 	// changed from non-zero to a different non-zero.
 	metricDERPHomeChange = clientmetric.NewCounter("derp_home_change")
 
+	// metricDERPRateLimited is how many times a DERP server told us it
+	// dropped our packets for being over its rate limits.
+	metricDERPRateLimited = clientmetric.NewCounter("magicsock_derp_rate_limited")
+
 	// Disco packets received bpf read path
 	metricRecvDiscoPacketIPv4 = clientmetric.NewCounter("magicsock_disco_recv_bpf_ipv4")
 	metricRecvDiscoPacketIPv6 = clientmetric.NewCounter("magicsock_disco_recv_bpf_ipv6")
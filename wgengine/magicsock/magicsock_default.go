@@ -31,6 +31,10 @@ func getGSOSizeFromControl(control []byte) (int, error) {
 
 func setGSOSizeInControl(control *[]byte, gso uint16) {}
 
+func appendTOSToControl(control *[]byte, is6 bool, tos uint8) {}
+
+func trySetFlowLabels(pconn nettype.PacketConn, logf logger.Logf) {}
+
 const (
 	controlMessageSize = 0
 )
@@ -11,6 +11,7 @@
 	"io"
 	"net"
 	"net/netip"
+	"slices"
 	"syscall"
 	"time"
 	"unsafe"
@@ -382,6 +383,42 @@ func setGSOSizeInControl(control *[]byte, gsoSize uint16) {
 	*control = (*control)[:unix.CmsgSpace(2)]
 }
 
+// appendTOSToControl appends to control a control message that sets the
+// IPv4 type of service, or if is6 the IPv6 traffic class, of the sent
+// packets to tos.
+func appendTOSToControl(control *[]byte, is6 bool, tos uint8) {
+	level, typ := unix.IPPROTO_IP, unix.IP_TOS
+	if is6 {
+		level, typ = unix.IPPROTO_IPV6, unix.IPV6_TCLASS
+	}
+	start := len(*control)
+	space := unix.CmsgSpace(4)
+	*control = slices.Grow(*control, space)[:start+space]
+	hdr := (*unix.Cmsghdr)(unsafe.Pointer(&(*control)[start]))
+	hdr.Level = int32(level)
+	hdr.Type = int32(typ)
+	hdr.SetLen(unix.CmsgLen(4))
+	binary.NativeEndian.PutUint32((*control)[start+unix.SizeofCmsghdr:], uint32(tos))
+}
+
+// trySetFlowLabels has the kernel set a flow label, derived from the flow's
+// addresses and ports, on the IPv6 packets sent on pconn.
+func trySetFlowLabels(pconn nettype.PacketConn, logf logger.Logf) {
+	c, ok := pconn.(*net.UDPConn)
+	if !ok {
+		return
+	}
+	rc, err := c.SyscallConn()
+	if err == nil {
+		rc.Control(func(fd uintptr) {
+			err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_AUTOFLOWLABEL, 1)
+		})
+	}
+	if err != nil {
+		logf("magicsock: [warning] failed to enable IPv6 flow labels: %v", err)
+	}
+}
+
 var controlMessageSize = -1 // bomb if used for allocation before init
 
 func init() {
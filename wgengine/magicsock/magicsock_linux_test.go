@@ -0,0 +1,41 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestAppendTOSToControl(t *testing.T) {
+	for _, is6 := range []bool{false, true} {
+		control := make([]byte, 0, controlMessageSize)
+		setGSOSizeInControl(&control, 1280)
+		appendTOSToControl(&control, is6, 46<<2)
+
+		msgs, err := unix.ParseSocketControlMessage(control)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != 2 {
+			t.Fatalf("is6=%v: got %d control messages; want 2", is6, len(msgs))
+		}
+		if h := msgs[0].Header; h.Level != unix.SOL_UDP || h.Type != unix.UDP_SEGMENT {
+			t.Errorf("is6=%v: first message is %d/%d; want UDP_SEGMENT", is6, h.Level, h.Type)
+		}
+		wantLevel, wantType := int32(unix.IPPROTO_IP), int32(unix.IP_TOS)
+		if is6 {
+			wantLevel, wantType = unix.IPPROTO_IPV6, unix.IPV6_TCLASS
+		}
+		m := msgs[1]
+		if m.Header.Level != wantLevel || m.Header.Type != wantType {
+			t.Errorf("is6=%v: second message is %d/%d; want %d/%d", is6, m.Header.Level, m.Header.Type, wantLevel, wantType)
+		}
+		if got := binary.NativeEndian.Uint32(m.Data); got != 46<<2 {
+			t.Errorf("is6=%v: TOS = %#x; want %#x", is6, got, 46<<2)
+		}
+	}
+}
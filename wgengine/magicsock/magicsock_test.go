@@ -19,6 +19,7 @@
 	"net/netip"
 	"os"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -58,8 +59,10 @@
 	"tailscale.com/types/netmap"
 	"tailscale.com/types/nettype"
 	"tailscale.com/types/ptr"
+	"tailscale.com/types/views"
 	"tailscale.com/util/cibuild"
 	"tailscale.com/util/racebuild"
+	"tailscale.com/util/ringbuffer"
 	"tailscale.com/util/set"
 	"tailscale.com/wgengine/filter"
 	"tailscale.com/wgengine/wgcfg"
@@ -1588,6 +1591,59 @@ func TestRebindStress(t *testing.T) {
 	}
 }
 
+func TestLocalEndpoints(t *testing.T) {
+	ips := []netip.Addr{
+		netip.MustParseAddr("192.168.0.2"),
+		netip.MustParseAddr("2001:db8::2"),
+	}
+	tests := []struct {
+		name         string
+		port4, port6 uint16
+		want         []netip.AddrPort
+	}{
+		{
+			name:  "dual-stack",
+			port4: 41641,
+			port6: 41641,
+			want: []netip.AddrPort{
+				netip.MustParseAddrPort("192.168.0.2:41641"),
+				netip.MustParseAddrPort("[2001:db8::2]:41641"),
+			},
+		},
+		{
+			name:  "different-ports",
+			port4: 41641,
+			port6: 41642,
+			want: []netip.AddrPort{
+				netip.MustParseAddrPort("192.168.0.2:41641"),
+				netip.MustParseAddrPort("[2001:db8::2]:41642"),
+			},
+		},
+		{
+			name:  "v6-only",
+			port6: 41641,
+			want: []netip.AddrPort{
+				netip.MustParseAddrPort("[2001:db8::2]:41641"),
+			},
+		},
+		{
+			name:  "v6-unbound",
+			port4: 41641,
+			want: []netip.AddrPort{
+				netip.MustParseAddrPort("192.168.0.2:41641"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := localEndpoints(ips, tt.port4, tt.port6)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestEndpointSetsEqual(t *testing.T) {
 	s := func(ports ...uint16) (ret []tailcfg.Endpoint) {
 		for _, port := range ports {
@@ -1953,8 +2009,30 @@ func TestRebindingUDPConn(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer realConn.Close()
-	c.setConnLocked(realConn.(nettype.PacketConn), "udp4", 1)
-	c.setConnLocked(newBlockForeverConn(), "", 1)
+	c.setConnLocked(realConn.(nettype.PacketConn), "udp4", 1, true)
+	c.setConnLocked(newBlockForeverConn(), "", 1, false)
+}
+
+func TestUDPOffloadAllowed(t *testing.T) {
+	knobs := &controlknobs.Knobs{}
+	c := &Conn{controlKnobs: knobs}
+	if !c.udpOffloadAllowed() {
+		t.Fatal("UDP offload not allowed by default")
+	}
+	knobs.DisableUDPOffload.Store(true)
+	if c.udpOffloadAllowed() {
+		t.Fatal("UDP offload allowed despite control knob")
+	}
+
+	realConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer realConn.Close()
+	upc := tryUpgradeToBatchingUDPConn(realConn.(nettype.PacketConn), "udp4", 1, false)
+	if b, ok := upc.(*batchingUDPConn); ok && (b.txOffload.Load() || b.rxOffload) {
+		t.Errorf("UDP offload enabled with offload=false: tx=%v rx=%v", b.txOffload.Load(), b.rxOffload)
+	}
 }
 
 // https://github.com/tailscale/tailscale/issues/6680: don't ignore
@@ -3004,3 +3082,62 @@ func TestAddrForPingSizeLocked(t *testing.T) {
 		})
 	}
 }
+
+func TestEndpointPolicy(t *testing.T) {
+	stun := netip.MustParseAddrPort("1.2.3.4:41641")
+	local := netip.MustParseAddrPort("192.168.0.2:41641")
+	static := netip.MustParseAddrPort("10.0.0.2:41641")
+	eps := views.SliceOf([]netip.AddrPort{stun, local, static})
+	types := views.SliceOf([]tailcfg.EndpointType{tailcfg.EndpointSTUN, tailcfg.EndpointLocal, tailcfg.EndpointExplicitConf})
+
+	peerKey := key.NewNode().Public()
+	otherKey := key.NewNode().Public()
+	newEndpoint := func(pub key.NodePublic, pol *EndpointPolicy) *endpoint {
+		c := &Conn{logf: t.Logf}
+		c.endpointPolicy.Store(pol)
+		return &endpoint{
+			c:             c,
+			publicKey:     pub,
+			debugUpdates:  ringbuffer.New[EndpointChange](10),
+			endpointState: map[netip.AddrPort]*endpointState{},
+		}
+	}
+	pol := &EndpointPolicy{
+		Default: EndpointTypePolicy{
+			Prefer: []tailcfg.EndpointType{tailcfg.EndpointExplicitConf, tailcfg.EndpointSTUN},
+		},
+		Peers: map[key.NodePublic]EndpointTypePolicy{
+			peerKey: {Ignore: []tailcfg.EndpointType{tailcfg.EndpointLocal}},
+		},
+	}
+
+	de := newEndpoint(peerKey, pol)
+	de.setEndpointsLocked(eps, types)
+	if _, ok := de.endpointState[local]; ok {
+		t.Errorf("ignored local endpoint was added")
+	}
+	if st, ok := de.endpointState[stun]; !ok || st.typ != tailcfg.EndpointSTUN {
+		t.Errorf("stun endpoint state = %+v, %v", st, ok)
+	}
+
+	de = newEndpoint(otherKey, pol)
+	de.setEndpointsLocked(eps, types)
+	if len(de.endpointState) != 3 {
+		t.Fatalf("got %d endpoints; want 3", len(de.endpointState))
+	}
+	if a, b := de.addrRankLocked(static), de.addrRankLocked(stun); a <= b {
+		t.Errorf("static rank %d <= stun rank %d", a, b)
+	}
+	if a, b := de.addrRankLocked(stun), de.addrRankLocked(local); a <= b {
+		t.Errorf("stun rank %d <= local rank %d", a, b)
+	}
+
+	de = newEndpoint(otherKey, nil)
+	de.setEndpointsLocked(eps, views.Slice[tailcfg.EndpointType]{})
+	if len(de.endpointState) != 3 {
+		t.Fatalf("got %d endpoints with nil policy; want 3", len(de.endpointState))
+	}
+	if r := de.addrRankLocked(static); r != 0 {
+		t.Errorf("rank with nil policy = %d; want 0", r)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+
+	"tailscale.com/tstime/mono"
+)
+
+// multipathMode is how packets to a peer with a trusted direct path are
+// also sent along a second path, for reliability over lossy links.
+//
+// WireGuard's replay protection drops the copies of a packet after the
+// first to arrive, so receivers need no support for it.
+type multipathMode int32
+
+const (
+	// multipathOff sends packets along the best path only.
+	multipathOff multipathMode = iota
+
+	// multipathDuplicate sends every packet along both paths, so a
+	// packet is only lost if it's lost on both.
+	multipathDuplicate
+
+	// multipathStripe alternates packets between the paths, spreading
+	// the load when the primary path drops more as it's pushed harder.
+	multipathStripe
+)
+
+// parseMultipathMode parses the value of TS_DEBUG_MAGICSOCK_MULTIPATH.
+func parseMultipathMode(s string) (multipathMode, bool) {
+	switch s {
+	case "", "off":
+		return multipathOff, true
+	case "duplicate":
+		return multipathDuplicate, true
+	case "stripe":
+		return multipathStripe, true
+	}
+	return multipathOff, false
+}
+
+// initMultipath sets c's multipath mode from the environment.
+func (c *Conn) initMultipath() {
+	v := debugMultipath()
+	m, ok := parseMultipathMode(v)
+	if !ok {
+		c.logf("magicsock: ignoring unknown TS_DEBUG_MAGICSOCK_MULTIPATH mode %q", v)
+	}
+	if m != multipathOff {
+		c.logf("magicsock: multipath mode %q", v)
+	}
+	c.multipath.Store(int32(m))
+}
+
+// multipathAltAddrLocked returns the second path to send packets along
+// when sending them along the trusted bestAddr: another direct address of
+// the peer that has recently replied to a ping, preferring the other IP
+// family and then lower latency, or else DERP. It returns the zero value
+// if there's none. de.mu must be held.
+func (de *endpoint) multipathAltAddrLocked(now mono.Time) netip.AddrPort {
+	best := de.bestAddr.AddrPort
+	var alt netip.AddrPort
+	var altQuality addrQuality
+	for ep, st := range de.endpointState {
+		if ep == best || len(st.recentPongs) == 0 {
+			continue
+		}
+		pong := st.recentPongs[st.recentPong]
+		if now.Sub(pong.pongAt) > trustUDPAddrDuration {
+			continue
+		}
+		q := addrQuality{AddrPort: ep, latency: pong.latency}
+		otherFamily := ep.Addr().Is4() != best.Addr().Is4()
+		altOtherFamily := alt.IsValid() && alt.Addr().Is4() != best.Addr().Is4()
+		switch {
+		case !alt.IsValid(),
+			otherFamily && !altOtherFamily,
+			otherFamily == altOtherFamily && q.latency < altQuality.latency:
+			alt, altQuality = ep, q
+		}
+	}
+	if alt.IsValid() {
+		return alt
+	}
+	return de.derpAddr
+}
+
+// stripeBuffs splits buffs between two paths, alternating between them.
+func stripeBuffs(buffs [][]byte) (a, b [][]byte) {
+	for i, buf := range buffs {
+		if i%2 == 0 {
+			a = append(a, buf)
+		} else {
+			b = append(b, buf)
+		}
+	}
+	return a, b
+}
@@ -0,0 +1,96 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime/mono"
+)
+
+func TestParseMultipathMode(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   multipathMode
+		wantOK bool
+	}{
+		{"", multipathOff, true},
+		{"off", multipathOff, true},
+		{"duplicate", multipathDuplicate, true},
+		{"stripe", multipathStripe, true},
+		{"bond", multipathOff, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseMultipathMode(tt.in)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseMultipathMode(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestStripeBuffs(t *testing.T) {
+	buffs := [][]byte{{0}, {1}, {2}, {3}, {4}}
+	a, b := stripeBuffs(buffs)
+	if want := [][]byte{{0}, {2}, {4}}; !reflect.DeepEqual(a, want) {
+		t.Errorf("a = %v; want %v", a, want)
+	}
+	if want := [][]byte{{1}, {3}}; !reflect.DeepEqual(b, want) {
+		t.Errorf("b = %v; want %v", b, want)
+	}
+	if a, b := stripeBuffs(buffs[:1]); len(a) != 1 || b != nil {
+		t.Errorf("single buff striped to %v, %v", a, b)
+	}
+}
+
+func TestMultipathAltAddr(t *testing.T) {
+	now := mono.Now()
+	best := netip.MustParseAddrPort("1.2.3.4:1")
+	otherV4Fast := netip.MustParseAddrPort("5.6.7.8:1")
+	otherV4Slow := netip.MustParseAddrPort("9.9.9.9:1")
+	v6 := netip.MustParseAddrPort("[2001:db8::1]:1")
+	stale := netip.MustParseAddrPort("[2001:db8::2]:1")
+	derp := netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, 1)
+
+	withPong := func(latency time.Duration, at mono.Time) *endpointState {
+		st := &endpointState{}
+		st.addPongReplyLocked(pongReply{latency: latency, pongAt: at})
+		return st
+	}
+	de := &endpoint{
+		bestAddr: addrQuality{AddrPort: best, latency: time.Millisecond},
+		derpAddr: derp,
+		endpointState: map[netip.AddrPort]*endpointState{
+			best:        withPong(time.Millisecond, now),
+			otherV4Slow: withPong(30*time.Millisecond, now),
+			stale:       withPong(time.Millisecond, now.Add(-2*trustUDPAddrDuration)),
+		},
+	}
+	if got := de.multipathAltAddrLocked(now); got != otherV4Slow {
+		t.Errorf("alt = %v; want %v", got, otherV4Slow)
+	}
+	de.endpointState[otherV4Fast] = withPong(10*time.Millisecond, now)
+	if got := de.multipathAltAddrLocked(now); got != otherV4Fast {
+		t.Errorf("alt = %v; want lower latency %v", got, otherV4Fast)
+	}
+	de.endpointState[v6] = withPong(50*time.Millisecond, now)
+	if got := de.multipathAltAddrLocked(now); got != v6 {
+		t.Errorf("alt = %v; want other family %v", got, v6)
+	}
+
+	// Without other recently confirmed direct paths, DERP is used.
+	delete(de.endpointState, otherV4Fast)
+	delete(de.endpointState, otherV4Slow)
+	delete(de.endpointState, v6)
+	if got := de.multipathAltAddrLocked(now); got != derp {
+		t.Errorf("alt = %v; want DERP %v", got, derp)
+	}
+	de.derpAddr = netip.AddrPort{}
+	if got := de.multipathAltAddrLocked(now); got.IsValid() {
+		t.Errorf("alt = %v; want none", got)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"runtime"
+	"strings"
+	"time"
+
+	"tailscale.com/net/netcheck"
+	"tailscale.com/tstime"
+)
+
+// backgroundReSTUNMin and backgroundReSTUNMax bound how long an idle
+// Conn waits between the background netchecks that keep its netcheck
+// history current. See shouldDoBackgroundReSTUNLocked.
+const (
+	backgroundReSTUNMin = 4*time.Minute + 30*time.Second
+	backgroundReSTUNMax = 5*time.Minute + 30*time.Second
+)
+
+// shouldDoBackgroundReSTUNLocked reports whether, while periodic re-STUNs
+// are idle, c should still do one every few minutes, so the netcheck
+// history shows how the network changed while idle too. They're only done
+// if enabled with TS_DEBUG_BACKGROUND_RESTUN, and never on mobile
+// platforms, to save battery. c.mu must be held.
+func (c *Conn) shouldDoBackgroundReSTUNLocked() bool {
+	if !debugBackgroundReSTUN() {
+		return false
+	}
+	if runtime.GOOS == "ios" || runtime.GOOS == "android" {
+		return false
+	}
+	if c.networkDown() {
+		return false
+	}
+	return len(c.peerSet) > 0 && !c.privateKey.IsZero()
+}
+
+// nextPeriodicReSTUNLocked returns how long to wait until the next
+// periodic re-STUN, and whether to do one at all. c.mu must be held.
+func (c *Conn) nextPeriodicReSTUNLocked() (d time.Duration, ok bool) {
+	if c.shouldDoPeriodicReSTUNLocked() {
+		// Pick a random duration between 20 and 26 seconds (just
+		// under 30s, a common UDP NAT timeout on Linux, etc)
+		return tstime.RandomDurationBetween(20*time.Second, 26*time.Second), true
+	}
+	if c.shouldDoBackgroundReSTUNLocked() {
+		return tstime.RandomDurationBetween(backgroundReSTUNMin, backgroundReSTUNMax), true
+	}
+	return 0, false
+}
+
+// NetcheckHistory returns c's recent netcheck reports, oldest first.
+func (c *Conn) NetcheckHistory() []netcheck.HistoryEntry {
+	return c.netChecker.History()
+}
+
+// SetNetcheckChangeCallback sets the func to be called with descriptions
+// of what changed whenever a netcheck report's key facts, such as the NAT
+// type or preferred DERP region, differ from the previous report's. See
+// netcheck.ReportChanges.
+//
+// At most one func can be registered; the most recent one replaces any
+// previous registration.
+//
+// This is called by LocalBackend.
+func (c *Conn) SetNetcheckChangeCallback(fn func(changes []string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.netcheckChangeFunc = fn
+}
+
+// noteNetcheckReport logs how the new netcheck report differs from the
+// previous one, prev, and queues a call of the netcheck change callback
+// if so. Callbacks are made one at a time, in the order of the reports.
+//
+// c.mu must NOT be held.
+func (c *Conn) noteNetcheckReport(prev, report *netcheck.Report) {
+	changes := netcheck.ReportChanges(prev, report)
+	if len(changes) == 0 {
+		return
+	}
+	c.logf("magicsock: netcheck changes: %s", strings.Join(changes, "; "))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.netcheckChangeFunc == nil {
+		return
+	}
+	c.netcheckChanges = append(c.netcheckChanges, changes)
+	if !c.netcheckChangesRunning {
+		c.netcheckChangesRunning = true
+		go c.deliverNetcheckChanges()
+	}
+}
+
+// deliverNetcheckChanges passes the queued netcheck changes to the
+// netcheck change callback until the queue is empty.
+func (c *Conn) deliverNetcheckChanges() {
+	for {
+		c.mu.Lock()
+		if len(c.netcheckChanges) == 0 {
+			c.netcheckChangesRunning = false
+			c.mu.Unlock()
+			return
+		}
+		changes := c.netcheckChanges[0]
+		c.netcheckChanges[0] = nil
+		c.netcheckChanges = c.netcheckChanges[1:]
+		fn := c.netcheckChangeFunc
+		c.mu.Unlock()
+		if fn != nil {
+			fn(changes)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"fmt"
+	"testing"
+
+	"tailscale.com/net/netcheck"
+)
+
+func TestNetcheckChangesInOrder(t *testing.T) {
+	c := &Conn{logf: t.Logf}
+	const n = 20
+	got := make(chan string, n)
+	c.SetNetcheckChangeCallback(func(changes []string) {
+		got <- changes[0]
+	})
+	for i := 0; i < n; i++ {
+		c.noteNetcheckReport(&netcheck.Report{PreferredDERP: i}, &netcheck.Report{PreferredDERP: i + 1})
+	}
+	for i := 0; i < n; i++ {
+		want := fmt.Sprintf("preferred DERP: %d => %d", i, i+1)
+		if g := <-got; g != want {
+			t.Fatalf("change %d = %q; want %q", i, g, want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"tailscale.com/types/key"
+)
+
+// OuterQoS is the quality of service marking of the UDP packets that
+// carry WireGuard traffic to peers, so network QoS and ECMP hashing can
+// treat them like the traffic they carry. It's only supported on Linux.
+//
+// The zero value leaves packets unmarked.
+type OuterQoS struct {
+	// CopyDSCP is whether to mark the packets to each peer with the DSCP
+	// of the packets most recently sent to it over WireGuard, as reported
+	// by NoteInnerDSCP.
+	CopyDSCP bool
+
+	// DSCP, if CopyDSCP is false, is the DSCP to mark all packets to
+	// peers with.
+	DSCP uint8
+
+	// FlowLabels is whether to have the kernel set a flow label on
+	// IPv6 packets, derived from their addresses and ports, so the
+	// packets to a peer keep a stable label regardless of the system's
+	// default.
+	FlowLabels bool
+}
+
+// SetOuterQoS sets the quality of service marking of the UDP packets sent
+// to peers.
+func (c *Conn) SetOuterQoS(q OuterQoS) {
+	q.DSCP &= 0x3f
+	c.mu.Lock()
+	if c.closed || c.outerQoS.Load() == q {
+		c.mu.Unlock()
+		return
+	}
+	old := c.outerQoS.Swap(q)
+	if !q.CopyDSCP {
+		c.peerMap.forEachEndpoint(func(ep *endpoint) {
+			ep.innerDSCP.Store(0)
+		})
+	}
+	c.mu.Unlock()
+
+	c.logf("magicsock: outer QoS: %+v", q)
+	if q.FlowLabels != old.FlowLabels {
+		// Only newly bound sockets can go back to the system default,
+		// so rebind rather than toggling the option.
+		if err := c.rebind(keepCurrentPort); err != nil {
+			c.logf("magicsock: rebind for IPv6 flow labels: %v", err)
+		}
+	}
+}
+
+// NoteInnerDSCP records that the packets being sent to the peer with key k
+// are marked with the Differentiated Services code point dscp, for the
+// OuterQoS CopyDSCP policy. It's meant to be called when that changes, not
+// for every packet.
+func (c *Conn) NoteInnerDSCP(k key.NodePublic, dscp uint8) {
+	if !c.outerQoS.Load().CopyDSCP {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ep, ok := c.peerMap.endpointForNodeKey(k); ok {
+		ep.innerDSCP.Store(uint32(dscp & 0x3f))
+	}
+}
+
+// outerTOS returns the IPv4 type of service or IPv6 traffic class byte to
+// send de's UDP packets with, or zero to leave them unmarked. A DSCP control
+// set for the peer takes precedence over the OuterQoS policy.
+func (de *endpoint) outerTOS() uint8 {
+	if dscp := de.peerDSCP.Load(); dscp != 0 {
+		return uint8(dscp) << 2
+	}
+	q := de.c.outerQoS.Load()
+	dscp := q.DSCP
+	if q.CopyDSCP {
+		dscp = uint8(de.innerDSCP.Load())
+	}
+	return dscp << 2 // the ECN bits are left zero
+}
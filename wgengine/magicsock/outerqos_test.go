@@ -0,0 +1,39 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"testing"
+)
+
+func TestEndpointOuterTOS(t *testing.T) {
+	c := &Conn{}
+	de := &endpoint{c: c}
+	de.innerDSCP.Store(10)
+
+	tests := []struct {
+		q    OuterQoS
+		want uint8
+	}{
+		{OuterQoS{}, 0},
+		{OuterQoS{DSCP: 46}, 46 << 2},
+		{OuterQoS{CopyDSCP: true}, 10 << 2},
+		{OuterQoS{CopyDSCP: true, DSCP: 46}, 10 << 2},
+	}
+	for _, tt := range tests {
+		c.outerQoS.Store(tt.q)
+		if got := de.outerTOS(); got != tt.want {
+			t.Errorf("%+v: outerTOS = %#x; want %#x", tt.q, got, tt.want)
+		}
+	}
+
+	// A DSCP set for the peer by control wins over any policy.
+	de.peerDSCP.Store(8)
+	for _, tt := range tests {
+		c.outerQoS.Store(tt.q)
+		if got := de.outerTOS(); got != 8<<2 {
+			t.Errorf("%+v with peer DSCP: outerTOS = %#x; want %#x", tt.q, got, 8<<2)
+		}
+	}
+}
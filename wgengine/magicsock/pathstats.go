@@ -0,0 +1,163 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+// pathKind is a kind of path that packets to a peer can take.
+type pathKind uint8
+
+const (
+	pathDirectV4 pathKind = iota
+	pathDirectV6
+	pathDERP
+	numPathKinds
+)
+
+func (k pathKind) String() string {
+	switch k {
+	case pathDirectV4:
+		return "direct4"
+	case pathDirectV6:
+		return "direct6"
+	case pathDERP:
+		return "derp"
+	}
+	return "unknown"
+}
+
+// pathKindOf returns the kind of path that packets sent to ap take.
+func pathKindOf(ap netip.AddrPort) pathKind {
+	switch {
+	case ap.Addr() == tailcfg.DerpMagicIPAddr:
+		return pathDERP
+	case ap.Addr().Is4() || ap.Addr().Is4In6():
+		return pathDirectV4
+	}
+	return pathDirectV6
+}
+
+// pathStatsHistory is how many pings each pathStats remembers.
+const pathStatsHistory = 32
+
+// minPingsForLoss is how many pings a pathStats needs before its loss is
+// trusted for choosing between paths.
+const minPingsForLoss = 8
+
+// lossierPathMargin is how much higher the loss of a path must be than
+// the current one for it not to be switched to, however low its latency.
+const lossierPathMargin = 0.25
+
+// pathStats is the outcomes of the recent disco pings along one kind of
+// path to a peer. The zero value is ready for use.
+type pathStats struct {
+	pings [pathStatsHistory]pingOutcome // ring buffer
+	n     int                           // number of valid entries in pings
+	next  int                           // index in pings of the next outcome
+}
+
+type pingOutcome struct {
+	rtt  time.Duration
+	lost bool
+}
+
+// add records the outcome of a ping: its round-trip time, or that it was
+// lost.
+func (ps *pathStats) add(rtt time.Duration, lost bool) {
+	ps.pings[ps.next] = pingOutcome{rtt: rtt, lost: lost}
+	ps.next = (ps.next + 1) % pathStatsHistory
+	ps.n = min(ps.n+1, pathStatsHistory)
+}
+
+// loss returns the fraction of the recent pings that were lost, or false
+// if there have been too few to tell.
+func (ps *pathStats) loss() (float64, bool) {
+	if ps.n < minPingsForLoss {
+		return 0, false
+	}
+	var lost int
+	for _, p := range ps.pings[:ps.n] {
+		if p.lost {
+			lost++
+		}
+	}
+	return float64(lost) / float64(ps.n), true
+}
+
+// status returns ps as an ipnstate.PathStats for a path of kind k, or false
+// if there have been no pings.
+func (ps *pathStats) status(k pathKind) (ipnstate.PathStats, bool) {
+	if ps.n == 0 {
+		return ipnstate.PathStats{}, false
+	}
+	st := ipnstate.PathStats{Path: k.String(), Pings: ps.n}
+	var lost int
+	var sum, minRTT time.Duration
+	for _, p := range ps.pings[:ps.n] {
+		if p.lost {
+			lost++
+			continue
+		}
+		sum += p.rtt
+		if minRTT == 0 || p.rtt < minRTT {
+			minRTT = p.rtt
+		}
+	}
+	if got := ps.n - lost; got > 0 {
+		st.RTTSeconds = (sum / time.Duration(got)).Seconds()
+		st.MinRTTSeconds = minRTT.Seconds()
+	}
+	st.Loss = float64(lost) / float64(ps.n)
+	return st, true
+}
+
+// recordPingLocked records the outcome of the ping sp. Only pings of the
+// minimum size count, as bigger ones probing the path MTU are expected to
+// be lost. de.mu must be held.
+func (de *endpoint) recordPingLocked(sp sentPing, rtt time.Duration, lost bool) {
+	if sp.size != 0 {
+		return
+	}
+	de.pathStats[pathKindOf(sp.to)].add(rtt, lost)
+}
+
+// lossierPathLocked reports whether the path to a has lost enough more of
+// the recent pings than the path to b that it shouldn't replace it,
+// whatever their latencies. de.mu must be held.
+func (de *endpoint) lossierPathLocked(a, b netip.AddrPort) bool {
+	if !a.IsValid() || !b.IsValid() {
+		return false
+	}
+	ka, kb := pathKindOf(a), pathKindOf(b)
+	if ka == kb {
+		return false
+	}
+	la, ok := de.pathStats[ka].loss()
+	if !ok {
+		return false
+	}
+	lb, ok := de.pathStats[kb].loss()
+	if !ok {
+		return false
+	}
+	return la >= lb+lossierPathMargin
+}
+
+// pathStatusLocked returns the stats of the paths to the peer that have
+// been pinged. de.mu must be held.
+func (de *endpoint) pathStatusLocked() []ipnstate.PathStats {
+	var ret []ipnstate.PathStats
+	for k := pathKind(0); k < numPathKinds; k++ {
+		if st, ok := de.pathStats[k].status(k); ok {
+			ret = append(ret, st)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,96 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+func TestPathKindOf(t *testing.T) {
+	tests := []struct {
+		ap   netip.AddrPort
+		want pathKind
+	}{
+		{netip.MustParseAddrPort("1.2.3.4:41641"), pathDirectV4},
+		{netip.MustParseAddrPort("[::ffff:1.2.3.4]:41641"), pathDirectV4},
+		{netip.MustParseAddrPort("[2001:db8::1]:41641"), pathDirectV6},
+		{netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, 1), pathDERP},
+	}
+	for _, tt := range tests {
+		if got := pathKindOf(tt.ap); got != tt.want {
+			t.Errorf("pathKindOf(%v) = %v; want %v", tt.ap, got, tt.want)
+		}
+	}
+}
+
+func TestPathStats(t *testing.T) {
+	var ps pathStats
+	if _, ok := ps.status(pathDirectV4); ok {
+		t.Fatal("status of unpinged path is ok")
+	}
+	ps.add(10*time.Millisecond, false)
+	ps.add(30*time.Millisecond, false)
+	ps.add(0, true)
+	ps.add(20*time.Millisecond, false)
+	if _, ok := ps.loss(); ok {
+		t.Errorf("loss ok after %d pings", ps.n)
+	}
+	got, _ := ps.status(pathDirectV4)
+	want := ipnstate.PathStats{
+		Path:          "direct4",
+		Pings:         4,
+		RTTSeconds:    0.02,
+		MinRTTSeconds: 0.01,
+		Loss:          0.25,
+	}
+	if got != want {
+		t.Errorf("status = %+v; want %+v", got, want)
+	}
+
+	// Only the most recent pings count.
+	for i := 0; i < pathStatsHistory; i++ {
+		ps.add(0, true)
+	}
+	if loss, ok := ps.loss(); !ok || loss != 1 {
+		t.Errorf("loss = %v, %v; want 1, true", loss, ok)
+	}
+	got, _ = ps.status(pathDirectV4)
+	if got.Pings != pathStatsHistory || got.RTTSeconds != 0 || got.MinRTTSeconds != 0 {
+		t.Errorf("status after all lost = %+v", got)
+	}
+}
+
+func TestLossierPath(t *testing.T) {
+	v4 := netip.MustParseAddrPort("1.2.3.4:41641")
+	v4b := netip.MustParseAddrPort("5.6.7.8:41641")
+	v6 := netip.MustParseAddrPort("[2001:db8::1]:41641")
+
+	de := &endpoint{}
+	for i := 0; i < minPingsForLoss; i++ {
+		de.recordPingLocked(sentPing{to: v4}, time.Millisecond, false)
+		de.recordPingLocked(sentPing{to: v6}, 0, i%2 == 0)
+		// MTU probes don't count.
+		de.recordPingLocked(sentPing{to: v4, size: 1000}, 0, true)
+	}
+	if !de.lossierPathLocked(v6, v4) {
+		t.Error("path with 50% loss not lossier than one with none")
+	}
+	if de.lossierPathLocked(v4, v6) {
+		t.Error("path with no loss lossier than one with 50%")
+	}
+	if de.lossierPathLocked(v4b, v4) {
+		t.Error("paths of the same kind compared")
+	}
+	if de.lossierPathLocked(v6, netip.AddrPort{}) {
+		t.Error("path lossier than no path")
+	}
+	if got := de.pathStatusLocked(); len(got) != 2 || got[0].Path != "direct4" || got[1].Path != "direct6" {
+		t.Errorf("pathStatusLocked = %+v", got)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"math/rand"
+	"slices"
+	"time"
+
+	"tailscale.com/tstime/mono"
+)
+
+// UDPPortPolicy is local policy about which UDP port magicsock listens on
+// and when it moves to a new one, for networks whose firewalls only allow
+// some ports or time out or block long-lived UDP flows.
+//
+// The zero value means to use the preferred port (see SetPreferredPort),
+// or else any port, for as long as possible.
+type UDPPortPolicy struct {
+	// RangeFirst and RangeLast, if non-zero, are the first and last ports
+	// of the inclusive range of ports to listen on. The preferred port is
+	// only used if it's in the range.
+	RangeFirst, RangeLast uint16
+
+	// RotateEvery, if positive, is how often to move to a new port.
+	// Once moved from, the preferred port isn't used again until the
+	// sockets are rebound for some other reason, such as a link change.
+	RotateEvery time.Duration
+
+	// RotateOnPathFailure is whether to move to a new port when pings
+	// to peers over UDP keep failing.
+	RotateOnPathFailure bool
+}
+
+const (
+	// portRotatePathFailures is how many UDP disco pings in a row must go
+	// unanswered, across all peers, before RotateOnPathFailure moves to a
+	// new port.
+	portRotatePathFailures = 20
+
+	// minPathFailurePortRotateInterval is the minimum time between port
+	// rotations due to path failures, so a network where UDP doesn't work
+	// at all, or where all peers are offline, doesn't churn ports.
+	minPathFailurePortRotateInterval = 5 * time.Minute
+
+	// maxRangeBindAttempts is how many random ports in a UDPPortPolicy's
+	// range bindSocket tries before giving up.
+	maxRangeBindAttempts = 8
+)
+
+// hasRange reports whether p restricts the ports to listen on.
+func (p UDPPortPolicy) hasRange() bool {
+	return p.RangeFirst != 0 && p.RangeLast != 0 && p.RangeFirst <= p.RangeLast
+}
+
+// allows reports whether p allows listening on port.
+func (p UDPPortPolicy) allows(port uint16) bool {
+	return !p.hasRange() || (port >= p.RangeFirst && port <= p.RangeLast)
+}
+
+// rotates reports whether p ever moves to a new port.
+func (p UDPPortPolicy) rotates() bool {
+	return p.RotateEvery > 0 || p.RotateOnPathFailure
+}
+
+// randomPorts returns up to n distinct random ports from p's range,
+// excluding avoid. It returns nil if p has no range.
+func (p UDPPortPolicy) randomPorts(n int, avoid uint16) []uint16 {
+	if !p.hasRange() {
+		return nil
+	}
+	size := int(p.RangeLast-p.RangeFirst) + 1
+	var ret []uint16
+	for tries := 0; len(ret) < n && tries < 4*n; tries++ {
+		port := p.RangeFirst + uint16(rand.Intn(size))
+		if port != avoid && !slices.Contains(ret, port) {
+			ret = append(ret, port)
+		}
+	}
+	return ret
+}
+
+// SetUDPPortPolicy sets the local policy about which UDP port to listen on
+// and when to move to a new one. If the new policy doesn't allow the
+// current port, the sockets are rebound immediately.
+func (c *Conn) SetUDPPortPolicy(p UDPPortPolicy) {
+	c.mu.Lock()
+	if c.closed || c.portPolicy.Load() == p {
+		c.mu.Unlock()
+		return
+	}
+	c.portPolicy.Store(p)
+	c.resetPortRotateTimerLocked()
+	c.mu.Unlock()
+
+	c.logf("magicsock: UDP port policy: %+v", p)
+	if !p.allows(c.LocalPort()) {
+		c.rotatePort("port not allowed by new policy")
+	}
+}
+
+// resetPortRotateTimerLocked (re)starts the timer for the policy's
+// scheduled port rotation, or stops it if there is none.
+//
+// c.mu must be held.
+func (c *Conn) resetPortRotateTimerLocked() {
+	if c.portRotateTimer != nil {
+		c.portRotateTimer.Stop()
+		c.portRotateTimer = nil
+	}
+	if d := c.portPolicy.Load().RotateEvery; d > 0 && !c.closed {
+		c.portRotateTimer = time.AfterFunc(d, c.onPortRotateTimer)
+	}
+}
+
+func (c *Conn) onPortRotateTimer() {
+	c.rotatePort("scheduled")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resetPortRotateTimerLocked()
+}
+
+// rotatePort rebinds the UDP sockets to new ports and has peers and
+// control learn the new endpoints. The why string is for logging only.
+//
+// c.mu must NOT be held.
+func (c *Conn) rotatePort(why string) {
+	if c.closing.Load() {
+		return
+	}
+	metricPortRotations.Add(1)
+	c.udpPathFailures.Store(0)
+	old := c.LocalPort()
+	if err := c.rebind(rotateCurrentPort); err != nil {
+		c.logf("magicsock: port rotation (%s): %v", why, err)
+		return
+	}
+	c.logf("magicsock: rotated UDP port %d => %d (%s)", old, c.LocalPort(), why)
+	c.resetEndpointStates()
+	c.ReSTUN("port-rotated")
+}
+
+// noteUDPPathResult is called with whether a disco ping sent to a peer
+// over UDP was answered, for the RotateOnPathFailure policy. Failures
+// only count for pings to a peer's current best path, not candidate
+// endpoints that may be stale.
+//
+// It may be called with endpoint and Conn locks held.
+func (c *Conn) noteUDPPathResult(ok bool) {
+	if ok {
+		c.udpPathFailures.Store(0)
+		return
+	}
+	if !c.portPolicy.Load().RotateOnPathFailure {
+		return
+	}
+	if c.udpPathFailures.Add(1) < portRotatePathFailures {
+		return
+	}
+	now := mono.Now()
+	last := c.lastPathFailureRotate.Load()
+	if last != 0 && now.Sub(mono.Time(last)) < minPathFailurePortRotateInterval {
+		return
+	}
+	if !c.lastPathFailureRotate.CompareAndSwap(last, int64(now)) {
+		return // raced with another failure
+	}
+	go c.rotatePort("repeated UDP path failures")
+}
@@ -0,0 +1,93 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestUDPPortPolicyRandomPorts(t *testing.T) {
+	if got := (UDPPortPolicy{}).randomPorts(8, 0); got != nil {
+		t.Errorf("no range: got %v; want nil", got)
+	}
+
+	p := UDPPortPolicy{RangeFirst: 50000, RangeLast: 50009}
+	got := p.randomPorts(8, 50003)
+	if len(got) != 8 {
+		t.Fatalf("got %d ports (%v); want 8", len(got), got)
+	}
+	for i, port := range got {
+		if !p.allows(port) {
+			t.Errorf("port %d not in range", port)
+		}
+		if port == 50003 {
+			t.Errorf("got avoided port %d", port)
+		}
+		if slices.Contains(got[:i], port) {
+			t.Errorf("duplicate port %d", port)
+		}
+	}
+
+	p = UDPPortPolicy{RangeFirst: 41641, RangeLast: 41641}
+	if got := p.randomPorts(8, 41641); len(got) != 0 {
+		t.Errorf("single port range avoiding it: got %v; want none", got)
+	}
+	if p.allows(41642) || !p.allows(41641) {
+		t.Errorf("allows wrong for %+v", p)
+	}
+}
+
+func TestSetUDPPortPolicy(t *testing.T) {
+	conn := newTestConn(t)
+	defer conn.Close()
+
+	const first, last = 47000, 47999
+	inRange := func(port uint16) bool { return port >= first && port <= last }
+
+	conn.SetUDPPortPolicy(UDPPortPolicy{RangeFirst: first, RangeLast: last, RotateEvery: time.Hour})
+	port := conn.LocalPort()
+	if !inRange(port) {
+		t.Fatalf("after SetUDPPortPolicy, port %d not in range", port)
+	}
+	if conn.portRotateTimer == nil {
+		t.Errorf("rotation timer not started")
+	}
+
+	// Rebinding keeps the rotated-to port.
+	conn.Rebind()
+	if got := conn.LocalPort(); got != port {
+		t.Errorf("after Rebind, port = %d; want %d", got, port)
+	}
+
+	conn.rotatePort("test")
+	if got := conn.LocalPort(); got == port || !inRange(got) {
+		t.Errorf("after rotatePort, port = %d; want a new port in range (was %d)", got, port)
+	}
+
+	conn.SetUDPPortPolicy(UDPPortPolicy{})
+	if conn.portRotateTimer != nil {
+		t.Errorf("rotation timer not stopped")
+	}
+}
+
+func TestBindSocketRangeExhausted(t *testing.T) {
+	conn := newTestConn(t)
+	defer conn.Close()
+
+	// Occupy the only port in the range.
+	busy, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	port := uint16(busy.LocalAddr().(*net.UDPAddr).Port)
+	conn.portPolicy.Store(UDPPortPolicy{RangeFirst: port, RangeLast: port})
+
+	if err := conn.bindSocket(&conn.pconn4, "udp4", dropCurrentPort); err == nil {
+		t.Errorf("bindSocket succeeded on port %d; want error, not a port outside the range", conn.pconn4.Port())
+	}
+}
@@ -38,9 +38,10 @@ type RebindingUDPConn struct {
 // nettype.PacketConn to a *batchingUDPConn when appropriate. This upgrade
 // is intentionally pushed closest to where read/write ops occur in order to
 // avoid disrupting surrounding code that assumes nettype.PacketConn is a
-// *net.UDPConn.
-func (c *RebindingUDPConn) setConnLocked(p nettype.PacketConn, network string, batchSize int) {
-	upc := tryUpgradeToBatchingUDPConn(p, network, batchSize)
+// *net.UDPConn. UDP offload is only enabled on the upgraded conn if offload
+// is true.
+func (c *RebindingUDPConn) setConnLocked(p nettype.PacketConn, network string, batchSize int, offload bool) {
+	upc := tryUpgradeToBatchingUDPConn(p, network, batchSize, offload)
 	c.pconn = upc
 	c.pconnAtomic.Store(&upc)
 	c.port = uint16(c.localAddrLocked().Port)
@@ -70,8 +71,9 @@ func (c *RebindingUDPConn) ReadFromUDPAddrPort(b []byte) (int, netip.AddrPort, e
 	return c.readFromWithInitPconn(*c.pconnAtomic.Load(), b)
 }
 
-// WriteBatchTo writes buffs to addr.
-func (c *RebindingUDPConn) WriteBatchTo(buffs [][]byte, addr netip.AddrPort) error {
+// WriteBatchTo writes buffs to addr, marked with the IPv4 type of service or
+// IPv6 traffic class tos if non-zero and supported.
+func (c *RebindingUDPConn) WriteBatchTo(buffs [][]byte, addr netip.AddrPort, tos uint8) error {
 	for {
 		pconn := *c.pconnAtomic.Load()
 		b, ok := pconn.(*batchingUDPConn)
@@ -84,7 +86,7 @@ func (c *RebindingUDPConn) WriteBatchTo(buffs [][]byte, addr netip.AddrPort) err
 			}
 			return nil
 		}
-		err := b.WriteBatchTo(buffs, addr)
+		err := b.WriteBatchTo(buffs, addr, tos)
 		if err != nil {
 			if pconn != c.currentConn() {
 				continue
@@ -0,0 +1,30 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+// udpOffloadAllowed reports whether the UDP sockets may use UDP generic
+// segmentation and receive offload (GSO/GRO) where the OS supports them. It
+// can be turned off with TS_DEBUG_DISABLE_UDP_OFFLOAD or by control, for
+// kernels and NICs with broken offload.
+func (c *Conn) udpOffloadAllowed() bool {
+	if debugDisableUDPOffload() {
+		return false
+	}
+	if c.controlKnobs != nil && c.controlKnobs.DisableUDPOffload.Load() {
+		return false
+	}
+	return true
+}
+
+// UpdateUDPOffload rebinds the UDP sockets if whether they may use UDP
+// offload has changed since they were bound, such as when control
+// toggles it.
+func (c *Conn) UpdateUDPOffload() {
+	allowed := c.udpOffloadAllowed()
+	if c.udpOffload.Load() == allowed {
+		return
+	}
+	c.logf("magicsock: UDP offload allowed changed to %v; rebinding", allowed)
+	c.Rebind()
+}
@@ -488,6 +488,24 @@ func (ns *Impl) DialContextUDP(ctx context.Context, ipp netip.AddrPort) (*gonet.
 	return gonet.DialUDP(ns.ipstack, nil, remoteAddress, ipType)
 }
 
+// ListenPacket returns an unconnected UDP socket bound to ipp, which must
+// be one of the node's Tailscale IPs.
+func (ns *Impl) ListenPacket(ipp netip.AddrPort) (*gonet.UDPConn, error) {
+	localAddress := &tcpip.FullAddress{
+		NIC:  nicID,
+		Addr: tcpip.AddrFromSlice(ipp.Addr().AsSlice()),
+		Port: ipp.Port(),
+	}
+	var ipType tcpip.NetworkProtocolNumber
+	if ipp.Addr().Is4() {
+		ipType = ipv4.ProtocolNumber
+	} else {
+		ipType = ipv6.ProtocolNumber
+	}
+
+	return gonet.DialUDP(ns.ipstack, localAddress, nil, ipType)
+}
+
 // The inject goroutine reads in packets that netstack generated, and delivers
 // them to the correct path.
 func (ns *Impl) inject() {
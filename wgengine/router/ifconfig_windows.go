@@ -367,7 +367,7 @@ func configureInterface(cfg *Config, tun *tun.NativeTun) (retErr error) {
 		r := &winipcfg.RouteData{
 			Destination: route,
 			NextHop:     gateway,
-			Metric:      0,
+			Metric:      cfg.RouteMetrics[route], // added to the interface metric, which is 0
 		}
 		if r.Destination.Addr().Unmap() == gateway {
 			// no need to add a route for the interface's
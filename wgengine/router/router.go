@@ -67,6 +67,15 @@ type Config struct {
 	// routing rules apply.
 	LocalRoutes []netip.Prefix
 
+	// RouteMetrics are the metrics (priorities) to install Routes
+	// with, so they can deliberately win or lose against routes from
+	// other software on the machine. Lower metrics are preferred.
+	// Routes not in the map use the platform's default metric.
+	// Currently only used on Linux and Windows. On Linux, the routes
+	// still go in Tailscale's routing table, so the metrics only set
+	// their priority against other routes in that table.
+	RouteMetrics map[netip.Prefix]uint32
+
 	// NewMTU is currently only used by the MacOS network extension
 	// app to set the MTU of the tun in the router configuration
 	// callback. If zero, the MTU is unchanged.
@@ -6,6 +6,7 @@
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"net"
 	"net/netip"
 	"os"
@@ -44,6 +45,7 @@ type linuxRouter struct {
 	unregNetMon      func()
 	addrs            map[netip.Prefix]bool
 	routes           map[netip.Prefix]bool
+	routeMetrics     map[netip.Prefix]uint32 // of the routes in routes; missing means 0
 	localRoutes      map[netip.Prefix]bool
 	snatSubnetRoutes bool
 	netfilterMode    preftype.NetfilterMode
@@ -349,6 +351,19 @@ func (r *linuxRouter) Set(cfg *Config) error {
 	}
 	r.localRoutes = newLocalRoutes
 
+	// A route's metric is part of its identity, so replacing a route
+	// with a different metric would add a second one. Remove the routes
+	// whose metric changed first; cidrDiff adds them back.
+	for cidr := range r.routes {
+		if cfg.RouteMetrics[cidr] != r.routeMetrics[cidr] {
+			if err := r.delRoute(cidr); err != nil {
+				errs = append(errs, err)
+			}
+			delete(r.routes, cidr)
+		}
+	}
+	r.routeMetrics = maps.Clone(cfg.RouteMetrics)
+
 	newRoutes, err := cidrDiff("route", r.routes, cfg.Routes, r.addRoute, r.delRoute, r.logf)
 	if err != nil {
 		errs = append(errs, err)
@@ -594,7 +609,7 @@ func (r *linuxRouter) addRoute(cidr netip.Prefix) error {
 		return nil
 	}
 	if r.useIPCommand() {
-		return r.addRouteDef([]string{normalizeCIDR(cidr), "dev", r.tunname}, cidr)
+		return r.addRouteDef(r.tunRouteDef(cidr), cidr)
 	}
 	linkIndex, err := r.linkIndex()
 	if err != nil {
@@ -604,9 +619,20 @@ func (r *linuxRouter) addRoute(cidr netip.Prefix) error {
 		LinkIndex: linkIndex,
 		Dst:       netipx.PrefixIPNet(cidr.Masked()),
 		Table:     r.routeTable(),
+		Priority:  int(r.routeMetrics[cidr]),
 	})
 }
 
+// tunRouteDef returns the "ip route" arguments for the route for cidr
+// pointing to the tunnel interface, with its metric if it has one.
+func (r *linuxRouter) tunRouteDef(cidr netip.Prefix) []string {
+	def := []string{normalizeCIDR(cidr), "dev", r.tunname}
+	if m := r.routeMetrics[cidr]; m != 0 {
+		def = append(def, "metric", strconv.FormatUint(uint64(m), 10))
+	}
+	return def
+}
+
 // addThrowRoute adds a throw route for the provided cidr.
 // This has the effect that lookup in the routing table is terminated
 // pretending that no route was found. Fails if the route already exists,
@@ -671,7 +697,7 @@ func (r *linuxRouter) delRoute(cidr netip.Prefix) error {
 		return nil
 	}
 	if r.useIPCommand() {
-		return r.delRouteDef([]string{normalizeCIDR(cidr), "dev", r.tunname}, cidr)
+		return r.delRouteDef(r.tunRouteDef(cidr), cidr)
 	}
 	linkIndex, err := r.linkIndex()
 	if err != nil {
@@ -681,6 +707,7 @@ func (r *linuxRouter) delRoute(cidr netip.Prefix) error {
 		LinkIndex: linkIndex,
 		Dst:       netipx.PrefixIPNet(cidr.Masked()),
 		Table:     r.routeTable(),
+		Priority:  int(r.routeMetrics[cidr]),
 	})
 	if errors.Is(err, errESRCH) {
 		// Didn't exist to begin with.
@@ -76,6 +76,61 @@ func TestRouterStates(t *testing.T) {
 ip route add 192.168.16.0/24 dev tailscale0 table 52` + basic,
 		},
 
+		{
+			name: "addr and routes with metrics",
+			in: &Config{
+				LocalAddrs: mustCIDRs("100.101.102.103/10"),
+				Routes:     mustCIDRs("100.100.100.100/32", "192.168.16.0/24"),
+				RouteMetrics: map[netip.Prefix]uint32{
+					netip.MustParsePrefix("192.168.16.0/24"): 500,
+				},
+				NetfilterMode: netfilterOff,
+			},
+			want: `
+up
+ip addr add 100.101.102.103/10 dev tailscale0
+ip route add 100.100.100.100/32 dev tailscale0 table 52
+ip route add 192.168.16.0/24 dev tailscale0 metric 500 table 52` + basic,
+		},
+
+		{
+			name: "addr and routes with changed metrics",
+			in: &Config{
+				LocalAddrs: mustCIDRs("100.101.102.103/10"),
+				Routes:     mustCIDRs("100.100.100.100/32", "192.168.16.0/24"),
+				RouteMetrics: map[netip.Prefix]uint32{
+					netip.MustParsePrefix("100.100.100.100/32"): 10,
+					netip.MustParsePrefix("192.168.16.0/24"):    20,
+				},
+				NetfilterMode: netfilterOff,
+			},
+			want: `
+up
+ip addr add 100.101.102.103/10 dev tailscale0
+ip route add 100.100.100.100/32 dev tailscale0 metric 10 table 52
+ip route add 192.168.16.0/24 dev tailscale0 metric 20 table 52` + basic,
+		},
+
+		{
+			// Even with a metric, an exit node's default route stays in
+			// Tailscale's table, which tailscaled's own marked traffic
+			// skips.
+			name: "addr and default route with metric",
+			in: &Config{
+				LocalAddrs: mustCIDRs("100.101.102.103/10"),
+				Routes:     mustCIDRs("100.100.100.100/32", "0.0.0.0/0"),
+				RouteMetrics: map[netip.Prefix]uint32{
+					netip.MustParsePrefix("0.0.0.0/0"): 1000,
+				},
+				NetfilterMode: netfilterOff,
+			},
+			want: `
+up
+ip addr add 100.101.102.103/10 dev tailscale0
+ip route add 0.0.0.0/0 dev tailscale0 metric 1000 table 52
+ip route add 100.100.100.100/32 dev tailscale0 table 52` + basic,
+		},
+
 		{
 			name: "addr and routes and subnet routes",
 			in: &Config{
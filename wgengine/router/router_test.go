@@ -21,7 +21,7 @@ func mustCIDRs(ss ...string) []netip.Prefix {
 
 func TestConfigEqual(t *testing.T) {
 	testedFields := []string{
-		"LocalAddrs", "Routes", "LocalRoutes", "NewMTU",
+		"LocalAddrs", "Routes", "LocalRoutes", "RouteMetrics", "NewMTU",
 		"SubnetRoutes", "SNATSubnetRoutes", "NetfilterMode",
 	}
 	configType := reflect.TypeOf(Config{})
@@ -102,6 +102,17 @@ func TestConfigEqual(t *testing.T) {
 			true,
 		},
 
+		{
+			&Config{RouteMetrics: map[netip.Prefix]uint32{netip.MustParsePrefix("100.1.27.0/24"): 100}},
+			&Config{RouteMetrics: map[netip.Prefix]uint32{netip.MustParsePrefix("100.1.27.0/24"): 200}},
+			false,
+		},
+		{
+			&Config{RouteMetrics: map[netip.Prefix]uint32{netip.MustParsePrefix("100.1.27.0/24"): 100}},
+			&Config{RouteMetrics: map[netip.Prefix]uint32{netip.MustParsePrefix("100.1.27.0/24"): 100}},
+			true,
+		},
+
 		{
 			&Config{SubnetRoutes: nets("100.1.27.0/24")},
 			&Config{SubnetRoutes: nets("100.2.19.0/24")},
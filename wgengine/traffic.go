@@ -0,0 +1,105 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package wgengine
+
+import (
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+)
+
+// trafficSnapshotInterval is how often the engine snapshots the bytes
+// sent to and received from each peer, to compute their recent rates.
+const trafficSnapshotInterval = 10 * time.Second
+
+// trafficSnapshot is the bytes sent to and received from each peer at
+// one time.
+type trafficSnapshot struct {
+	at    time.Time
+	peers map[key.NodePublic]ipnstate.PeerStatusLite
+}
+
+// trafficRate is the bytes per second sent to and received from a peer
+// between two trafficSnapshots.
+type trafficRate struct {
+	tx, rx float64
+}
+
+// newTrafficSnapshot returns a trafficSnapshot of peers at time at.
+func newTrafficSnapshot(at time.Time, peers []ipnstate.PeerStatusLite) *trafficSnapshot {
+	s := &trafficSnapshot{
+		at:    at,
+		peers: make(map[key.NodePublic]ipnstate.PeerStatusLite, len(peers)),
+	}
+	for _, p := range peers {
+		s.peers[p.NodeKey] = p
+	}
+	return s
+}
+
+// ratesSince returns the traffic rate of each peer in both s and prev.
+// Peers whose counters went backwards, because WireGuard forgot and
+// re-added them, are omitted.
+func (s *trafficSnapshot) ratesSince(prev *trafficSnapshot) map[key.NodePublic]trafficRate {
+	secs := s.at.Sub(prev.at).Seconds()
+	if secs <= 0 {
+		return nil
+	}
+	rates := make(map[key.NodePublic]trafficRate, len(s.peers))
+	for k, p := range s.peers {
+		pp, ok := prev.peers[k]
+		if !ok || p.TxBytes < pp.TxBytes || p.RxBytes < pp.RxBytes {
+			continue
+		}
+		rates[k] = trafficRate{
+			tx: float64(p.TxBytes-pp.TxBytes) / secs,
+			rx: float64(p.RxBytes-pp.RxBytes) / secs,
+		}
+	}
+	return rates
+}
+
+// snapshotTrafficLoop snapshots per-peer traffic every
+// trafficSnapshotInterval until e is closed, updating e.trafficRates.
+func (e *userspaceEngine) snapshotTrafficLoop() {
+	t := time.NewTicker(trafficSnapshotInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-e.waitCh:
+			return
+		case now := <-t.C:
+			e.snapshotTraffic(now)
+		}
+	}
+}
+
+func (e *userspaceEngine) snapshotTraffic(now time.Time) {
+	e.mu.Lock()
+	peerKeys := append([]key.NodePublic(nil), e.peerSequence...)
+	e.mu.Unlock()
+
+	peers := make([]ipnstate.PeerStatusLite, 0, len(peerKeys))
+	for _, k := range peerKeys {
+		if st, ok := e.getPeerStatusLite(k); ok {
+			peers = append(peers, st)
+		}
+	}
+	snap := newTrafficSnapshot(now, peers)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.trafficSnap != nil {
+		e.trafficRates = snap.ratesSince(e.trafficSnap)
+	}
+	e.trafficSnap = snap
+}
+
+// trafficRate returns the most recent traffic rate of the peer nk.
+func (e *userspaceEngine) trafficRate(nk key.NodePublic) trafficRate {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.trafficRates[nk]
+}
@@ -133,6 +133,8 @@ type userspaceEngine struct {
 	peerSequence   []key.NodePublic
 	endpoints      []tailcfg.Endpoint
 	pendOpen       map[flowtrack.Tuple]*pendingOpenFlow // see pendopen.go
+	trafficSnap    *trafficSnapshot                     // most recent; see traffic.go
+	trafficRates   map[key.NodePublic]trafficRate       // per peer, between the last two trafficSnaps
 
 	// pongCallback is the map of response handlers waiting for disco or TSMP
 	// pong callbacks. The map key is a random slice of bytes.
@@ -441,6 +443,8 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		conf.SetSubsystem(e.netMon)
 	}
 
+	go e.snapshotTrafficLoop()
+
 	e.logf("Engine created.")
 	return e, nil
 }
@@ -879,6 +883,7 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 	e.magicConn.UpdatePeers(peerSet)
 	e.magicConn.SetPreferredPort(listenPort)
 	e.magicConn.UpdatePMTUD()
+	e.magicConn.UpdateUDPOffload()
 
 	if err := e.maybeReconfigWireguardLocked(discoChanged); err != nil {
 		return err
@@ -1117,7 +1122,16 @@ func (e *userspaceEngine) linkChange(delta *netmon.ChangeDelta) {
 
 	health.SetAnyInterfaceUp(up)
 	e.magicConn.SetNetworkUp(up)
+	if changed {
+		// Rebind first, before the slower DNS work below, so traffic
+		// moves to the new interface as soon as possible.
+		metricNumMajorChanges.Add(1)
+		e.magicConn.Rebind()
+	}
 	if !up || changed {
+		// Upstream resolvers may answer differently on the new
+		// network, so forget their cached responses too.
+		e.dns.FlushCache()
 		if err := e.dns.FlushCaches(); err != nil {
 			e.logf("wgengine: dns flush failed after major link change: %v", err)
 		}
@@ -1146,8 +1160,6 @@ func (e *userspaceEngine) linkChange(delta *netmon.ChangeDelta) {
 	why := "link-change-minor"
 	if changed {
 		why = "link-change-major"
-		metricNumMajorChanges.Add(1)
-		e.magicConn.Rebind()
 	} else {
 		metricNumMinorChanges.Add(1)
 	}
@@ -1169,9 +1181,12 @@ func (e *userspaceEngine) UpdateStatus(sb *ipnstate.StatusBuilder) {
 	}
 	if sb.WantPeers {
 		for _, ps := range st.Peers {
+			rate := e.trafficRate(ps.NodeKey)
 			sb.AddPeer(ps.NodeKey, &ipnstate.PeerStatus{
 				RxBytes:       int64(ps.RxBytes),
 				TxBytes:       int64(ps.TxBytes),
+				RxBytesPerSec: rate.rx,
+				TxBytesPerSec: rate.tx,
 				LastHandshake: ps.LastHandshake,
 				InEngine:      true,
 			})
@@ -10,11 +10,13 @@
 	"reflect"
 	"runtime"
 	"testing"
+	"time"
 
 	"go4.org/mem"
 	"tailscale.com/cmd/testwrapper/flakytest"
 	"tailscale.com/control/controlknobs"
 	"tailscale.com/envknob"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/dns"
 	"tailscale.com/net/netaddr"
 	"tailscale.com/net/tstun"
@@ -97,6 +99,32 @@ func nodeViews(v []*tailcfg.Node) []tailcfg.NodeView {
 	return nv
 }
 
+func TestTrafficSnapshotRates(t *testing.T) {
+	k1 := key.NewNode().Public()
+	k2 := key.NewNode().Public()
+	k3 := key.NewNode().Public()
+	t0 := time.Unix(1000, 0)
+	prev := newTrafficSnapshot(t0, []ipnstate.PeerStatusLite{
+		{NodeKey: k1, TxBytes: 100, RxBytes: 1000},
+		{NodeKey: k2, TxBytes: 500, RxBytes: 500},
+	})
+	cur := newTrafficSnapshot(t0.Add(10*time.Second), []ipnstate.PeerStatusLite{
+		{NodeKey: k1, TxBytes: 1100, RxBytes: 3000},
+		{NodeKey: k2, TxBytes: 10, RxBytes: 10}, // re-added to WireGuard
+		{NodeKey: k3, TxBytes: 10, RxBytes: 10}, // new
+	})
+	got := cur.ratesSince(prev)
+	want := map[key.NodePublic]trafficRate{
+		k1: {tx: 100, rx: 200},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ratesSince = %v; want %v", got, want)
+	}
+	if got := cur.ratesSince(cur); got != nil {
+		t.Errorf("ratesSince(self) = %v; want nil", got)
+	}
+}
+
 func TestUserspaceEngineReconfig(t *testing.T) {
 	e, err := NewFakeUserspaceEngine(t.Logf, 0)
 	if err != nil {
@@ -73,6 +73,7 @@ func (src *Peer) Clone() *Peer {
 	AllowedIPs          []netip.Prefix
 	V4MasqAddr          *netip.Addr
 	V6MasqAddr          *netip.Addr
+	MaxTxBitrate        int64
 	PersistentKeepalive uint16
 	WGEndpoint          key.NodePublic
 }{})